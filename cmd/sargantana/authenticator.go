@@ -0,0 +1,59 @@
+package main
+
+import (
+	"io"
+
+	"github.com/animalet/sargantana-go/pkg/controller"
+	"github.com/animalet/sargantana-go/pkg/server"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/pkg/errors"
+)
+
+// registerAuthenticators registers the authenticator types selectable in the authenticator
+// section of the server configuration. The basic authenticator reads its table through the pool of
+// the postgres configuration section. Returns a closer function stopping the background work of the
+// authenticators, which should be deferred like the session store closer.
+func registerAuthenticators(postgres *sharedPostgres) func() error {
+	var closers []io.Closer
+
+	server.RegisterAuthenticator("goth", func(*controller.GothAuthenticatorConfig) (server.Authenticator, error) {
+		return controller.NewGothAuthenticator(), nil
+	})
+	server.RegisterAuthenticator("jwt", func(c *server.JWTAuthenticatorConfig) (server.Authenticator, error) {
+		authenticator, err := server.NewJWTAuthenticator(*c)
+		if err != nil {
+			return nil, err
+		}
+		closers = append(closers, authenticator.(io.Closer))
+		return authenticator, nil
+	})
+	server.RegisterAuthenticator("basic", func(c *server.BasicAuthenticatorConfig) (server.Authenticator, error) {
+		var pool *pgxpool.Pool
+		if c.Table != nil {
+			var err error
+			if pool, err = postgres.get(); err != nil {
+				return nil, err
+			}
+			if pool == nil {
+				return nil, errors.New("basic authentication with a table requires the postgres configuration section")
+			}
+		}
+		return server.NewBasicAuthenticator(*c, pool)
+	})
+	server.RegisterAuthenticator("header", func(c *server.TrustedHeaderAuthenticatorConfig) (server.Authenticator, error) {
+		return server.NewTrustedHeaderAuthenticator(*c)
+	})
+	server.RegisterAuthenticator("client_cert", func(c *server.ClientCertAuthenticatorConfig) (server.Authenticator, error) {
+		return server.NewClientCertAuthenticator(*c), nil
+	})
+	server.RegisterAuthenticator("none", func(*server.AnonymousAuthenticatorConfig) (server.Authenticator, error) {
+		return server.NewAnonymousAuthenticator(), nil
+	})
+
+	return func() error {
+		for _, closer := range closers {
+			_ = closer.Close()
+		}
+		return nil
+	}
+}
@@ -0,0 +1,18 @@
+//go:build unit
+
+package main
+
+import (
+	"github.com/animalet/sargantana-go/pkg/server"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Authenticator registration", func() {
+	It("should register the built-in authenticator types", func() {
+		closeAuthenticators := registerAuthenticators(newSharedPostgres(nil))
+		DeferCleanup(closeAuthenticators)
+		Expect(server.AuthenticatorTypes()).To(ContainElements("basic", "client_cert", "goth", "header", "jwt", "none"))
+	})
+})
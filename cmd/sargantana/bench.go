@@ -0,0 +1,334 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"maps"
+	"math"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"text/tabwriter"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const benchCommand = "bench"
+
+// benchOptions configures the load generated by the bench command
+type benchOptions struct {
+	base        *url.URL
+	paths       []string // Requested round-robin
+	method      string
+	concurrency int
+	duration    time.Duration
+	requests    int64  // Stops the run after this number of requests, 0 for no limit
+	cookie      string // Cookie header sent with every request, e.g. an authenticated session
+	timeout     time.Duration
+}
+
+// benchSample is the outcome of one request of the run
+type benchSample struct {
+	path    int
+	latency time.Duration
+	status  int // 0 if the request failed
+}
+
+// latencySummary holds the latency percentiles of a set of requests, in milliseconds
+type latencySummary struct {
+	P50 float64 `json:"p50_ms"`
+	P90 float64 `json:"p90_ms"`
+	P95 float64 `json:"p95_ms"`
+	P99 float64 `json:"p99_ms"`
+	Max float64 `json:"max_ms"`
+}
+
+// benchStats summarizes a set of requests. Errors are the failed requests and the 5xx responses.
+type benchStats struct {
+	Requests  int            `json:"requests"`
+	Errors    int            `json:"errors"`
+	ErrorRate float64        `json:"error_rate"`
+	Latency   latencySummary `json:"latency"`
+	// Statuses counts the responses by status code, and the failed requests as "error"
+	Statuses map[string]int `json:"statuses"`
+}
+
+// benchPathStats summarizes the requests of one path
+type benchPathStats struct {
+	Path string `json:"path"`
+	benchStats
+}
+
+// benchReport is the result of a run
+type benchReport struct {
+	URL         string  `json:"url"`
+	Concurrency int     `json:"concurrency"`
+	Seconds     float64 `json:"seconds"`
+	Throughput  float64 `json:"requests_per_second"`
+	benchStats
+	Paths []benchPathStats `json:"paths"`
+}
+
+// runBenchCommand handles the "bench" command, generating load against a running gateway and
+// printing the latency percentiles and error rates, for basic capacity planning and soak tests
+func runBenchCommand(args []string) int {
+	fs := flag.NewFlagSet(programName+" "+benchCommand, flag.ContinueOnError)
+	fs.SetOutput(os.Stderr)
+	targetURL := fs.String("url", "", "URL of the target, e.g. https://gateway.example.org (required)")
+	paths := fs.String("paths", "/", "Comma-separated paths requested round-robin, with their query")
+	method := fs.String("method", http.MethodGet, "Method of the requests")
+	concurrency := fs.Int("concurrency", 10, "Number of concurrent requests")
+	duration := fs.Duration("duration", 30*time.Second, "Duration of the run")
+	requests := fs.Int64("requests", 0, "Stop after this number of requests, 0 for no limit")
+	cookie := fs.String("cookie", "", "Cookie header sent with every request, e.g. \"session=...\" of an authenticated session")
+	timeout := fs.Duration("timeout", 10*time.Second, "Timeout of each request")
+	maxErrorRate := fs.Float64("max-error-rate", 0.01, "Exit with 1 when the ratio of failed requests and 5xx responses exceeds this value")
+	format := fs.String("format", "text", "Report format: text or json")
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return exitSuccess
+		}
+		return exitError
+	}
+
+	opts := benchOptions{
+		paths:       splitList(*paths),
+		method:      *method,
+		concurrency: *concurrency,
+		duration:    *duration,
+		requests:    *requests,
+		cookie:      *cookie,
+		timeout:     *timeout,
+	}
+	base, err := url.Parse(*targetURL)
+	switch {
+	case *targetURL == "":
+		err = errors.New("--url flag is required")
+	case err != nil || (base.Scheme != "http" && base.Scheme != "https") || base.Host == "":
+		err = errors.Errorf("invalid --url %q", *targetURL)
+	case len(opts.paths) == 0:
+		err = errors.New("--paths must not be empty")
+	case opts.concurrency < 1:
+		err = errors.New("--concurrency must be at least 1")
+	case opts.duration <= 0:
+		err = errors.New("--duration must be positive")
+	case opts.requests < 0:
+		err = errors.New("--requests must not be negative")
+	case *format != "text" && *format != "json":
+		err = errors.Errorf("invalid --format %q, must be text or json", *format)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return exitError
+	}
+	opts.base = base
+
+	// Ctrl+C ends the run early, with the report of the requests completed so far
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	report := runBench(ctx, opts)
+	if err := writeBenchReport(os.Stdout, *format, report); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return exitError
+	}
+	if report.Requests == 0 || report.ErrorRate > *maxErrorRate {
+		return exitError
+	}
+	return exitSuccess
+}
+
+// runBench sends requests with the concurrency of the options until the duration elapses, the
+// number of requests is reached or ctx is done, and summarizes them
+func runBench(ctx context.Context, opts benchOptions) *benchReport {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.MaxIdleConnsPerHost = opts.concurrency
+	client := &http.Client{
+		Timeout:   opts.timeout,
+		Transport: transport,
+		// Redirects are measured as responses, e.g. the login redirects of protected routes
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	defer client.CloseIdleConnections()
+
+	ctx, cancel := context.WithTimeout(ctx, opts.duration)
+	defer cancel()
+	targets := make([]string, len(opts.paths))
+	for i, path := range opts.paths {
+		targets[i] = benchTarget(opts.base, path)
+	}
+
+	var (
+		next    atomic.Int64
+		mu      sync.Mutex
+		samples []benchSample
+		wg      sync.WaitGroup
+	)
+	start := time.Now()
+	for range opts.concurrency {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var own []benchSample
+			for ctx.Err() == nil {
+				n := next.Add(1) - 1
+				if opts.requests > 0 && n >= opts.requests {
+					break
+				}
+				path := int(n % int64(len(targets)))
+				sample, ok := benchRequest(ctx, client, opts, targets[path])
+				if !ok {
+					break
+				}
+				sample.path = path
+				own = append(own, sample)
+			}
+			mu.Lock()
+			samples = append(samples, own...)
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+	elapsed := time.Since(start)
+
+	report := &benchReport{
+		URL:         opts.base.String(),
+		Concurrency: opts.concurrency,
+		Seconds:     elapsed.Seconds(),
+		benchStats:  summarizeBench(samples),
+	}
+	if elapsed > 0 {
+		report.Throughput = float64(report.Requests) / elapsed.Seconds()
+	}
+	for i, path := range opts.paths {
+		var pathSamples []benchSample
+		for _, sample := range samples {
+			if sample.path == i {
+				pathSamples = append(pathSamples, sample)
+			}
+		}
+		report.Paths = append(report.Paths, benchPathStats{Path: path, benchStats: summarizeBench(pathSamples)})
+	}
+	return report
+}
+
+// benchTarget returns the URL of the path, with its query, under the base URL
+func benchTarget(base *url.URL, path string) string {
+	u, err := url.Parse(path)
+	if err != nil {
+		return base.JoinPath(path).String()
+	}
+	target := base.JoinPath(u.Path)
+	target.RawQuery = u.RawQuery
+	return target.String()
+}
+
+// benchRequest sends one request and reads its response. It returns false for the requests
+// interrupted by the end of the run, which are not counted.
+func benchRequest(ctx context.Context, client *http.Client, opts benchOptions, target string) (benchSample, bool) {
+	start := time.Now()
+	request, err := http.NewRequestWithContext(ctx, opts.method, target, nil)
+	if err != nil {
+		return benchSample{latency: time.Since(start)}, true
+	}
+	if opts.cookie != "" {
+		request.Header.Set("Cookie", opts.cookie)
+	}
+	response, err := client.Do(request)
+	if err == nil {
+		_, err = io.Copy(io.Discard, response.Body)
+		_ = response.Body.Close()
+	}
+	if ctx.Err() != nil {
+		return benchSample{}, false
+	}
+	sample := benchSample{latency: time.Since(start)}
+	if err == nil {
+		sample.status = response.StatusCode
+	}
+	return sample, true
+}
+
+// summarizeBench computes the error rate, status codes and latency percentiles of the samples
+func summarizeBench(samples []benchSample) benchStats {
+	stats := benchStats{Requests: len(samples), Statuses: make(map[string]int)}
+	if len(samples) == 0 {
+		return stats
+	}
+	latencies := make([]time.Duration, 0, len(samples))
+	for _, sample := range samples {
+		latencies = append(latencies, sample.latency)
+		status := "error"
+		if sample.status != 0 {
+			status = strconv.Itoa(sample.status)
+		}
+		stats.Statuses[status]++
+		if sample.status == 0 || sample.status >= 500 {
+			stats.Errors++
+		}
+	}
+	stats.ErrorRate = float64(stats.Errors) / float64(len(samples))
+
+	slices.Sort(latencies)
+	percentile := func(p float64) float64 {
+		// Nearest rank
+		rank := int(math.Ceil(p/100*float64(len(latencies)))) - 1
+		return milliseconds(latencies[max(rank, 0)])
+	}
+	stats.Latency = latencySummary{
+		P50: percentile(50),
+		P90: percentile(90),
+		P95: percentile(95),
+		P99: percentile(99),
+		Max: milliseconds(latencies[len(latencies)-1]),
+	}
+	return stats
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
+
+// writeBenchReport writes the report as a table of the run and of each path, or as JSON
+func writeBenchReport(w io.Writer, format string, report *benchReport) error {
+	if format == "json" {
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(report)
+	}
+
+	_, err := fmt.Fprintf(w, "%s: %d requests in %.1fs with %d concurrent requests, %.1f requests/s\n\n",
+		report.URL, report.Requests, report.Seconds, report.Concurrency, report.Throughput)
+	if err != nil {
+		return err
+	}
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	_, _ = fmt.Fprintln(tw, "path\trequests\terrors\tp50 ms\tp90 ms\tp95 ms\tp99 ms\tmax ms\tstatuses\t")
+	row := func(path string, stats benchStats) {
+		var statuses []string
+		for _, status := range slices.Sorted(maps.Keys(stats.Statuses)) {
+			statuses = append(statuses, fmt.Sprintf("%s:%d", status, stats.Statuses[status]))
+		}
+		_, _ = fmt.Fprintf(tw, "%s\t%d\t%.2f%%\t%.1f\t%.1f\t%.1f\t%.1f\t%.1f\t%s\t\n", path, stats.Requests,
+			stats.ErrorRate*100, stats.Latency.P50, stats.Latency.P90, stats.Latency.P95, stats.Latency.P99,
+			stats.Latency.Max, strings.Join(statuses, " "))
+	}
+	for _, path := range report.Paths {
+		row(path.Path, path.benchStats)
+	}
+	if len(report.Paths) > 1 {
+		row("total", report.benchStats)
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,130 @@
+//go:build unit
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Bench command", func() {
+	var (
+		target  *httptest.Server
+		cookies atomic.Int64
+	)
+
+	BeforeEach(func() {
+		cookies.Store(0)
+		mux := http.NewServeMux()
+		mux.HandleFunc("/ok", func(w http.ResponseWriter, r *http.Request) {
+			if cookie, err := r.Cookie("session"); err == nil && cookie.Value == "abc" {
+				cookies.Add(1)
+			}
+			_, _ = w.Write([]byte("ok"))
+		})
+		mux.HandleFunc("/failing", func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Query().Get("code") == "503" {
+				w.WriteHeader(http.StatusServiceUnavailable)
+			}
+		})
+		target = httptest.NewServer(mux)
+		DeferCleanup(target.Close)
+	})
+
+	options := func(paths ...string) benchOptions {
+		base, err := url.Parse(target.URL)
+		Expect(err).NotTo(HaveOccurred())
+		return benchOptions{
+			base:        base,
+			paths:       paths,
+			method:      http.MethodGet,
+			concurrency: 4,
+			duration:    10 * time.Second,
+			requests:    40,
+			cookie:      "session=abc",
+			timeout:     time.Second,
+		}
+	}
+
+	It("should send the requests round-robin with the cookie and report each path", func() {
+		report := runBench(context.Background(), options("/ok", "/failing?code=503"))
+		Expect(report.Requests).To(Equal(40))
+		Expect(report.Errors).To(Equal(20))
+		Expect(report.ErrorRate).To(Equal(0.5))
+		Expect(report.Statuses).To(Equal(map[string]int{"200": 20, "503": 20}))
+		Expect(report.Throughput).To(BeNumerically(">", 0))
+		Expect(cookies.Load()).To(BeEquivalentTo(20))
+
+		Expect(report.Paths).To(HaveLen(2))
+		Expect(report.Paths[0].Path).To(Equal("/ok"))
+		Expect(report.Paths[0].Requests).To(Equal(20))
+		Expect(report.Paths[0].ErrorRate).To(BeZero())
+		Expect(report.Paths[1].Statuses).To(Equal(map[string]int{"503": 20}))
+		Expect(report.Latency.P50).To(BeNumerically("<=", report.Latency.P99))
+		Expect(report.Latency.P99).To(BeNumerically("<=", report.Latency.Max))
+	})
+
+	It("should run for the duration without a request limit", func() {
+		opts := options("/ok")
+		opts.requests = 0
+		opts.duration = 200 * time.Millisecond
+		start := time.Now()
+		report := runBench(context.Background(), opts)
+		Expect(time.Since(start)).To(BeNumerically("<", 2*time.Second))
+		Expect(report.Requests).To(BeNumerically(">", 0))
+		Expect(report.Errors).To(BeZero())
+	})
+
+	It("should count unreachable targets as errors", func() {
+		opts := options("/ok")
+		target.Close()
+		opts.requests = 5
+		report := runBench(context.Background(), opts)
+		Expect(report.Requests).To(Equal(5))
+		Expect(report.Statuses).To(Equal(map[string]int{"error": 5}))
+		Expect(report.ErrorRate).To(Equal(1.0))
+	})
+
+	It("should compute nearest-rank percentiles", func() {
+		var samples []benchSample
+		for i := 1; i <= 100; i++ {
+			samples = append(samples, benchSample{latency: time.Duration(i) * time.Millisecond, status: http.StatusOK})
+		}
+		stats := summarizeBench(samples)
+		Expect(stats.Latency).To(Equal(latencySummary{P50: 50, P90: 90, P95: 95, P99: 99, Max: 100}))
+		Expect(summarizeBench(nil).Requests).To(BeZero())
+	})
+
+	It("should write text and JSON reports", func() {
+		report := runBench(context.Background(), options("/ok", "/failing"))
+		var text bytes.Buffer
+		Expect(writeBenchReport(&text, "text", report)).To(Succeed())
+		Expect(text.String()).To(ContainSubstring("40 requests in"))
+		Expect(text.String()).To(MatchRegexp(`/failing\s+20\s+0.00%`))
+		Expect(text.String()).To(ContainSubstring("total"))
+
+		var data bytes.Buffer
+		Expect(writeBenchReport(&data, "json", report)).To(Succeed())
+		var decoded map[string]any
+		Expect(json.Unmarshal(data.Bytes(), &decoded)).To(Succeed())
+		Expect(decoded).To(HaveKeyWithValue("requests", BeEquivalentTo(40)))
+		Expect(decoded).To(HaveKey("requests_per_second"))
+		Expect(decoded["latency"]).To(HaveKey("p99_ms"))
+	})
+
+	It("should reject invalid arguments", func() {
+		Expect(runWithArgs([]string{"bench"})).To(Equal(exitError))
+		Expect(runWithArgs([]string{"bench", "--url", target.URL, "--concurrency", "0"})).To(Equal(exitError))
+		Expect(runWithArgs([]string{"bench", "--url", target.URL, "--paths", ","})).To(Equal(exitError))
+		Expect(runWithArgs([]string{"bench", "--url", target.URL, "--duration", "0s"})).To(Equal(exitError))
+	})
+})
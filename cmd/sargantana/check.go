@@ -0,0 +1,363 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"maps"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/animalet/sargantana-go/pkg/config"
+	"github.com/animalet/sargantana-go/pkg/controller"
+	"github.com/animalet/sargantana-go/pkg/routes"
+	"github.com/animalet/sargantana-go/pkg/server"
+	"github.com/pkg/errors"
+)
+
+const checkCommand = "check"
+
+// checkPlan lists what the check command exercises on a running gateway
+type checkPlan struct {
+	// healthPaths must answer with a 2xx status
+	healthPaths []string
+	// headers must be present in the response of the root path
+	headers []string
+	// logins are the login paths by provider, which must redirect to the provider
+	logins map[string]string
+}
+
+// checkResult is the outcome of one check of the report
+type checkResult struct {
+	Check  string `json:"check"`
+	Target string `json:"target"`
+	Passed bool   `json:"passed"`
+	Detail string `json:"detail"`
+}
+
+// checker runs the checks of a plan against the gateway at base
+type checker struct {
+	base        *url.URL
+	timeout     time.Duration
+	minValidity time.Duration
+	// rootCAs verify the certificate chain of the gateway. Nil for the system roots.
+	rootCAs *x509.CertPool
+}
+
+// runCheckCommand handles the "check" command, exercising a deployed gateway and printing a
+// pass/fail report, for smoke tests in deployment pipelines
+func runCheckCommand(args []string) int {
+	fs := flag.NewFlagSet(programName+" "+checkCommand, flag.ContinueOnError)
+	fs.SetOutput(os.Stderr)
+	gatewayURL := fs.String("url", "", "URL of the running gateway, e.g. https://gateway.example.org (required)")
+	configPath := fs.String("config", "", "Configuration file of the gateway, to check its probe paths, security headers and providers")
+	health := fs.String("health", "", "Comma-separated health paths (default: the liveness and readiness paths of --config, or "+routes.DefaultReadinessPath+")")
+	headers := fs.String("headers", "", "Comma-separated security headers expected (default: the security section of --config, or common headers)")
+	providers := fs.String("providers", "", "Comma-separated providers whose login at "+routes.DefaultLoginPath+" must redirect (default: the providers of --config)")
+	minValidity := fs.Duration("min-validity", 14*24*time.Hour, "Minimum remaining validity of the TLS certificate")
+	timeout := fs.Duration("timeout", 10*time.Second, "Timeout of each check")
+	format := fs.String("format", "text", "Report format: text or json")
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return exitSuccess
+		}
+		return exitError
+	}
+	if *gatewayURL == "" {
+		fmt.Fprintf(os.Stderr, "Error: --url flag is required\n")
+		return exitError
+	}
+	base, err := url.Parse(*gatewayURL)
+	if err != nil || (base.Scheme != "http" && base.Scheme != "https") || base.Host == "" {
+		fmt.Fprintf(os.Stderr, "Error: invalid --url %q\n", *gatewayURL)
+		return exitError
+	}
+	if *format != "text" && *format != "json" {
+		fmt.Fprintf(os.Stderr, "Error: invalid --format %q, must be text or json\n", *format)
+		return exitError
+	}
+
+	plan := checkPlan{
+		healthPaths: []string{routes.DefaultReadinessPath},
+		headers:     []string{"X-Content-Type-Options", "X-Frame-Options"},
+	}
+	if base.Scheme == "https" {
+		plan.headers = append(plan.headers, "Strict-Transport-Security")
+	}
+	if *configPath != "" {
+		if plan, err = planFromConfig(*configPath, base.Scheme == "https"); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return exitCode(err)
+		}
+	}
+	if *health != "" {
+		plan.healthPaths = splitList(*health)
+	}
+	if *headers != "" {
+		plan.headers = splitList(*headers)
+	}
+	if *providers != "" {
+		plan.logins = make(map[string]string)
+		for _, provider := range splitList(*providers) {
+			plan.logins[provider] = routes.Defaults().LoginURL(provider)
+		}
+	}
+
+	c := &checker{base: base, timeout: *timeout, minValidity: *minValidity}
+	results := c.run(plan)
+	if err := writeCheckReport(os.Stdout, *format, base.String(), results); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return exitError
+	}
+	for _, result := range results {
+		if !result.Passed {
+			return exitError
+		}
+	}
+	return exitSuccess
+}
+
+// planFromConfig returns the checks of the gateway configured in the configuration file: its
+// liveness and readiness paths, the headers of its security section and the logins of its auth
+// controllers
+func planFromConfig(configPath string, https bool) (checkPlan, error) {
+	var plan checkPlan
+	cfg, err := loadConfig(configPath)
+	if err != nil {
+		return plan, configError{err}
+	}
+	serverCfg, err := config.Get[server.SargantanaConfig](cfg, "sargantana")
+	if err != nil {
+		return plan, configError{errors.Wrap(err, "failed to load server configuration")}
+	}
+	if serverCfg == nil {
+		return plan, configError{errors.New("server configuration is required")}
+	}
+	bindings, err := serverCfg.ControllerBindings.WithEnvOverrides(os.Environ())
+	if err != nil {
+		return plan, configError{errors.Wrap(err, "failed to override controller configuration")}
+	}
+
+	switch liveness, readiness := serverCfg.WebServerConfig.ProbePaths(); {
+	case liveness != "":
+		plan.healthPaths = []string{liveness, readiness}
+	case readiness != "":
+		plan.healthPaths = []string{readiness}
+	}
+	if security := serverCfg.WebServerConfig.Security; security != nil {
+		plan.headers = securityHeaders(*security, https)
+	}
+	plan.logins = make(map[string]string)
+	for _, binding := range bindings {
+		if binding.TypeName != "auth" {
+			continue
+		}
+		authCfg, err := config.Unmarshal[controller.AuthControllerConfig](binding.Config)
+		if err != nil {
+			return plan, configError{errors.Wrapf(err, "invalid configuration of controller %s", binding.TypeName)}
+		}
+		r := authCfg.Routes()
+		// Relative to the checked URL, which may not be the callback host
+		r.Origin, r.BasePath = "", binding.BasePath
+		for provider := range authCfg.Providers {
+			plan.logins[provider] = r.LoginURL(provider)
+		}
+	}
+	return plan, nil
+}
+
+// securityHeaders returns the headers the security section of the configuration adds to the
+// responses. Strict-Transport-Security is only sent over HTTPS.
+func securityHeaders(c server.SecurityConfig, https bool) []string {
+	var headers []string
+	add := func(set bool, header string) {
+		if set {
+			headers = append(headers, header)
+		}
+	}
+	add(c.STSSeconds > 0 && https, "Strict-Transport-Security")
+	add(c.FrameDeny || c.CustomFrameOptionsValue != "", "X-Frame-Options")
+	add(c.ContentTypeNosniff, "X-Content-Type-Options")
+	add(c.BrowserXssFilter, "X-XSS-Protection")
+	add(c.ContentSecurityPolicy != "", "Content-Security-Policy")
+	add(c.ReferrerPolicy != "", "Referrer-Policy")
+	add(c.PermissionsPolicy != "", "Permissions-Policy")
+	return headers
+}
+
+// run runs the checks of the plan, the TLS one first for HTTPS gateways
+func (c *checker) run(plan checkPlan) []checkResult {
+	var results []checkResult
+	if c.base.Scheme == "https" {
+		results = append(results, c.checkTLS())
+	}
+	client := &http.Client{
+		Timeout:   c.timeout,
+		Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: c.rootCAs, MinVersion: tls.VersionTLS12}},
+		// Login redirects are checked, not followed
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	for _, path := range plan.healthPaths {
+		results = append(results, c.checkHealth(client, path))
+	}
+	if len(plan.headers) > 0 {
+		results = append(results, c.checkHeaders(client, plan.headers)...)
+	}
+	for _, provider := range slices.Sorted(maps.Keys(plan.logins)) {
+		results = append(results, c.checkLogin(client, provider, plan.logins[provider]))
+	}
+	return results
+}
+
+// checkTLS verifies the certificate chain of the gateway and its remaining validity
+func (c *checker) checkTLS() checkResult {
+	host := c.base.Hostname()
+	address := c.base.Host
+	if c.base.Port() == "" {
+		address = net.JoinHostPort(host, "443")
+	}
+	result := checkResult{Check: "tls", Target: address}
+	dialer := &tls.Dialer{
+		NetDialer: &net.Dialer{Timeout: c.timeout},
+		Config:    &tls.Config{ServerName: host, RootCAs: c.rootCAs, MinVersion: tls.VersionTLS12},
+	}
+	conn, err := dialer.Dial("tcp", address)
+	if err != nil {
+		result.Detail = err.Error()
+		return result
+	}
+	defer func() { _ = conn.Close() }()
+
+	leaf := conn.(*tls.Conn).ConnectionState().PeerCertificates[0]
+	remaining := time.Until(leaf.NotAfter)
+	result.Detail = fmt.Sprintf("valid until %s (%d days), issued by %s",
+		leaf.NotAfter.UTC().Format(time.RFC3339), int(remaining.Hours()/24), leaf.Issuer.CommonName)
+	if remaining < c.minValidity {
+		result.Detail = "expires too soon: " + result.Detail
+		return result
+	}
+	result.Passed = true
+	return result
+}
+
+// checkHealth requires a 2xx status from the health path
+func (c *checker) checkHealth(client *http.Client, path string) checkResult {
+	result := checkResult{Check: "health", Target: path}
+	response, err := c.get(client, path)
+	if err != nil {
+		result.Detail = err.Error()
+		return result
+	}
+	result.Detail = response.Status
+	result.Passed = response.StatusCode >= 200 && response.StatusCode < 300
+	return result
+}
+
+// checkHeaders requires the headers in the response of the root path, whatever its status
+func (c *checker) checkHeaders(client *http.Client, headers []string) []checkResult {
+	results := make([]checkResult, 0, len(headers))
+	response, err := c.get(client, "/")
+	for _, header := range headers {
+		result := checkResult{Check: "header", Target: header}
+		switch {
+		case err != nil:
+			result.Detail = err.Error()
+		case response.Header.Get(header) == "":
+			result.Detail = "missing"
+		default:
+			result.Detail = response.Header.Get(header)
+			result.Passed = true
+		}
+		results = append(results, result)
+	}
+	return results
+}
+
+// checkLogin requires the login path of the provider to redirect, to the authorization page of
+// the provider
+func (c *checker) checkLogin(client *http.Client, provider, path string) checkResult {
+	result := checkResult{Check: "login", Target: provider}
+	response, err := c.get(client, path)
+	if err != nil {
+		result.Detail = err.Error()
+		return result
+	}
+	location := response.Header.Get("Location")
+	if response.StatusCode < 300 || response.StatusCode >= 400 || location == "" {
+		result.Detail = fmt.Sprintf("%s at %s, no redirection", response.Status, path)
+		return result
+	}
+	// The query holds the state and the client ID, the host is enough to recognize the provider
+	if target, err := url.Parse(location); err == nil && target.Host != "" {
+		location = target.Scheme + "://" + target.Host + target.Path
+	}
+	result.Detail = "redirects to " + location
+	result.Passed = true
+	return result
+}
+
+// get requests the path on the gateway, discarding the body of the response
+func (c *checker) get(client *http.Client, path string) (*http.Response, error) {
+	response, err := client.Get(c.base.JoinPath(path).String())
+	if err != nil {
+		return nil, err
+	}
+	_, _ = io.Copy(io.Discard, io.LimitReader(response.Body, 1<<20))
+	_ = response.Body.Close()
+	return response, nil
+}
+
+// writeCheckReport writes the results as an aligned table with a summary, or as JSON
+func writeCheckReport(w io.Writer, format, gatewayURL string, results []checkResult) error {
+	failed := 0
+	for _, result := range results {
+		if !result.Passed {
+			failed++
+		}
+	}
+	if format == "json" {
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(struct {
+			URL    string        `json:"url"`
+			Passed bool          `json:"passed"`
+			Checks []checkResult `json:"checks"`
+		}{gatewayURL, failed == 0, results})
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	for _, result := range results {
+		status := "PASS"
+		if !result.Passed {
+			status = "FAIL"
+		}
+		_, _ = fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", status, result.Check, result.Target, result.Detail)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	_, err := fmt.Fprintf(w, "\n%s: %d passed, %d failed\n", gatewayURL, len(results)-failed, failed)
+	return err
+}
+
+// splitList splits a comma-separated flag value, ignoring empty items
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
@@ -0,0 +1,178 @@
+//go:build unit
+
+package main
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Check command", func() {
+	var (
+		gateway *httptest.Server
+		ready   bool
+	)
+
+	BeforeEach(func() {
+		ready = true
+		mux := http.NewServeMux()
+		mux.HandleFunc("/readyz", func(w http.ResponseWriter, _ *http.Request) {
+			if !ready {
+				w.WriteHeader(http.StatusServiceUnavailable)
+			}
+		})
+		mux.HandleFunc("/auth/github", func(w http.ResponseWriter, r *http.Request) {
+			http.Redirect(w, r, "https://github.com/login/oauth/authorize?client_id=key&state=123", http.StatusTemporaryRedirect)
+		})
+		mux.HandleFunc("/", func(w http.ResponseWriter, _ *http.Request) {
+			w.Header().Set("X-Content-Type-Options", "nosniff")
+			w.Header().Set("Strict-Transport-Security", "max-age=31536000")
+			w.WriteHeader(http.StatusNotFound)
+		})
+		gateway = httptest.NewTLSServer(mux)
+		DeferCleanup(gateway.Close)
+	})
+
+	newChecker := func(minValidity time.Duration) *checker {
+		base, err := url.Parse(gateway.URL)
+		Expect(err).NotTo(HaveOccurred())
+		roots := x509.NewCertPool()
+		roots.AddCert(gateway.Certificate())
+		return &checker{base: base, timeout: 5 * time.Second, minValidity: minValidity, rootCAs: roots}
+	}
+
+	plan := checkPlan{
+		healthPaths: []string{"/readyz"},
+		headers:     []string{"X-Content-Type-Options", "Strict-Transport-Security"},
+		logins:      map[string]string{"github": "/auth/github"},
+	}
+
+	It("should pass the checks of a healthy gateway", func() {
+		results := newChecker(24 * time.Hour).run(plan)
+		Expect(results).To(HaveLen(5))
+		for _, result := range results {
+			Expect(result.Passed).To(BeTrue(), "%s %s: %s", result.Check, result.Target, result.Detail)
+		}
+		Expect(results[0].Check).To(Equal("tls"))
+		Expect(results[0].Detail).To(ContainSubstring("valid until"))
+		Expect(results[4]).To(Equal(checkResult{
+			Check:  "login",
+			Target: "github",
+			Passed: true,
+			Detail: "redirects to https://github.com/login/oauth/authorize",
+		}))
+	})
+
+	It("should report the failed checks", func() {
+		ready = false
+		results := newChecker(100 * 365 * 24 * time.Hour).run(checkPlan{
+			healthPaths: []string{"/readyz"},
+			headers:     []string{"X-Frame-Options"},
+			logins:      map[string]string{"google": "/auth/google"},
+		})
+		Expect(results).To(HaveLen(4))
+		for _, result := range results {
+			Expect(result.Passed).To(BeFalse(), "%s %s: %s", result.Check, result.Target, result.Detail)
+		}
+		Expect(results[0].Detail).To(ContainSubstring("expires too soon"))
+		Expect(results[1].Detail).To(Equal("503 Service Unavailable"))
+		Expect(results[2].Detail).To(Equal("missing"))
+		Expect(results[3].Detail).To(ContainSubstring("no redirection"))
+	})
+
+	It("should fail the TLS check with an untrusted certificate chain", func() {
+		c := newChecker(0)
+		c.rootCAs = x509.NewCertPool()
+		results := c.run(checkPlan{})
+		Expect(results).To(HaveLen(1))
+		Expect(results[0].Passed).To(BeFalse())
+		Expect(results[0].Detail).To(ContainSubstring("certificate"))
+	})
+
+	It("should write text and JSON reports", func() {
+		results := []checkResult{
+			{Check: "health", Target: "/readyz", Passed: true, Detail: "200 OK"},
+			{Check: "header", Target: "X-Frame-Options", Detail: "missing"},
+		}
+		var text bytes.Buffer
+		Expect(writeCheckReport(&text, "text", "https://gateway.example.org", results)).To(Succeed())
+		Expect(text.String()).To(ContainSubstring("PASS  health  /readyz"))
+		Expect(text.String()).To(ContainSubstring("FAIL  header  X-Frame-Options"))
+		Expect(text.String()).To(ContainSubstring("https://gateway.example.org: 1 passed, 1 failed"))
+
+		var report struct {
+			URL    string        `json:"url"`
+			Passed bool          `json:"passed"`
+			Checks []checkResult `json:"checks"`
+		}
+		var data bytes.Buffer
+		Expect(writeCheckReport(&data, "json", "https://gateway.example.org", results)).To(Succeed())
+		Expect(json.Unmarshal(data.Bytes(), &report)).To(Succeed())
+		Expect(report.Passed).To(BeFalse())
+		Expect(report.Checks).To(Equal(results))
+	})
+
+	It("should plan the checks of the configured gateway", func() {
+		configPath := filepath.Join(GinkgoT().TempDir(), "config.yaml")
+		Expect(os.WriteFile(configPath, []byte(`sargantana:
+  server:
+    address: :9999
+    session_name: test_session
+    session_secret: a_very_long_secret_key_for_testing_purposes
+    preflight:
+      readiness_path: /health/ready
+    health:
+      liveness_path: /health/live
+    security:
+      sts_seconds: 31536000
+      frame_deny: true
+      content_type_nosniff: true
+      content_security_policy: "default-src 'self'"
+  controllers:
+    - type: auth
+      base_path: /app
+      config:
+        callback_host: https://login.example.org
+        callback_path: /auth/{provider}/callback
+        login_path: /login/{provider}
+        logout_path: /auth/logout
+        user_info_path: /auth/user
+        redirect_on_login: /
+        redirect_on_logout: /
+        providers:
+          github: {key: k, secret: s}
+          google: {key: k, secret: s}
+`), 0644)).To(Succeed())
+
+		plan, err := planFromConfig(configPath, true)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(plan.healthPaths).To(Equal([]string{"/health/live", "/health/ready"}))
+		Expect(plan.headers).To(Equal([]string{
+			"Strict-Transport-Security", "X-Frame-Options", "X-Content-Type-Options", "Content-Security-Policy",
+		}))
+		Expect(plan.logins).To(Equal(map[string]string{
+			"github": "/app/login/github",
+			"google": "/app/login/google",
+		}))
+
+		plan, err = planFromConfig(configPath, false)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(plan.headers).NotTo(ContainElement("Strict-Transport-Security"))
+	})
+
+	It("should reject invalid arguments", func() {
+		Expect(runWithArgs([]string{"check"})).To(Equal(exitError))
+		Expect(runWithArgs([]string{"check", "--url", "gateway.example.org"})).To(Equal(exitError))
+		Expect(runWithArgs([]string{"check", "--url", "https://gateway.example.org", "--format", "xml"})).To(Equal(exitError))
+	})
+})
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"github.com/animalet/sargantana-go/pkg/controller/cms"
+	"github.com/animalet/sargantana-go/pkg/controller/privacy"
+	"github.com/animalet/sargantana-go/pkg/server"
+	"github.com/pkg/errors"
+)
+
+// registerCMS registers the cms controller type, keeping the posts in the database of the postgres
+// configuration section. The store is created with the first cms controller and shared by the
+// others.
+func registerCMS(postgres *sharedPostgres) {
+	var store cms.Store
+
+	server.RegisterController("cms", func(c *cms.Config, ctx server.ControllerContext) (server.IController, error) {
+		if store == nil {
+			pool, err := postgres.get()
+			if err != nil {
+				return nil, err
+			}
+			if pool == nil {
+				return nil, errors.New("the cms controller requires the postgres configuration section")
+			}
+			store = cms.NewPostgresStore(pool)
+			privacy.RegisterSource("cms", cmsSource{store: store})
+		}
+		return cms.NewController(store)(c, ctx)
+	})
+}
@@ -1,9 +1,15 @@
 package main
 
 import (
+	"io"
+	"os"
+
 	"github.com/animalet/sargantana-go/pkg/config"
 	"github.com/animalet/sargantana-go/pkg/config/secrets"
+	"github.com/animalet/sargantana-go/pkg/logging"
+	"github.com/animalet/sargantana-go/pkg/server"
 	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
 )
 
 // loadConfig reads the configuration file and registers all secret providers
@@ -21,8 +27,14 @@ func loadConfig(configPath string) (*config.Config, error) {
 	return cfg, nil
 }
 
-// registerSecretProviders registers all configured secret providers
+// registerSecretProviders registers all configured secret providers and sets the master key of
+// the encrypted values
 func registerSecretProviders(cfg *config.Config) error {
+	// Set the master key from the environment first, so that every section can be encrypted
+	if err := loadMasterKey(); err != nil {
+		return err
+	}
+
 	// Register Vault provider if configured
 	vaultClient, vaultCfg, err := config.GetClientAndConfig[secrets.VaultConfig](cfg, "vault")
 	if err != nil {
@@ -39,6 +51,14 @@ func registerSecretProviders(cfg *config.Config) error {
 	}
 	if fileResolver != nil {
 		secrets.Register("file", *fileResolver)
+	} else if info, err := os.Stat(secrets.DefaultSecretsDir); err == nil && info.IsDir() {
+		// Use the conventional Docker and Kubernetes secrets mount when it is present
+		defaultResolver, err := secrets.NewFileSecretLoader(secrets.DefaultSecretsDir)
+		if err != nil {
+			return errors.Wrap(err, "failed to create file secret provider")
+		}
+		log.Info().Str("secrets_dir", secrets.DefaultSecretsDir).Msg("Using mounted secrets for the file secret provider")
+		secrets.Register("file", defaultResolver)
 	}
 
 	// Register AWS Secrets Manager provider if configured
@@ -50,5 +70,56 @@ func registerSecretProviders(cfg *config.Config) error {
 		secrets.Register("aws", secrets.NewAWSSecretLoader(*awsClient, awsCfg.SecretName))
 	}
 
+	// Register AWS Systems Manager Parameter Store provider if configured
+	ssmClient, ssmCfg, err := config.GetClientAndConfig[secrets.SSMConfig](cfg, "ssm")
+	if err != nil {
+		return errors.Wrap(err, "failed to load or create AWS Parameter Store client")
+	}
+	if ssmClient != nil {
+		secrets.Register("ssm", secrets.NewSSMParameterLoaderFromConfig(*ssmClient, *ssmCfg))
+	}
+
+	// Apply the fallback chain and resolution mode if configured
+	resolutionCfg, err := config.Get[secrets.ResolutionConfig](cfg, "secrets")
+	if err != nil {
+		return errors.Wrap(err, "failed to load secrets configuration")
+	}
+	if resolutionCfg != nil {
+		secrets.SetFallbackChain(resolutionCfg.Fallback)
+		secrets.SetLenient(resolutionCfg.Mode == secrets.ModeLenient)
+	}
+
+	// Set the master key from the encryption section if configured, e.g. from a KMS encrypted
+	// parameter, for the sections loaded afterwards
+	encryptionCfg, err := config.Get[secrets.EncryptionConfig](cfg, "encryption")
+	if err != nil {
+		return errors.Wrap(err, "failed to load encryption configuration")
+	}
+	if encryptionCfg != nil {
+		key, err := secrets.ParseMasterKey(encryptionCfg.MasterKey)
+		if err != nil {
+			return errors.Wrap(err, "invalid encryption configuration")
+		}
+		if err := secrets.SetMasterKey(key); err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
+
+// printEffectiveConfig loads the configuration as the server does and writes it with the secrets
+// redacted and the source of every value, without starting anything
+func printEffectiveConfig(configPath string, w io.Writer) error {
+	cfg, err := loadConfig(configPath)
+	if err != nil {
+		return configError{err}
+	}
+	if _, err := config.Get[logging.Config](cfg, "logging"); err != nil {
+		return configError{errors.Wrap(err, "failed to load logging configuration")}
+	}
+	if _, err := config.Get[server.SargantanaConfig](cfg, "sargantana"); err != nil {
+		return configError{errors.Wrap(err, "failed to load server configuration")}
+	}
+	return cfg.WriteEffective(w)
+}
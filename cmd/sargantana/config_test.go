@@ -3,8 +3,15 @@
 package main
 
 import (
+	"bytes"
+	"encoding/base64"
 	"os"
 	"path/filepath"
+	"strings"
+
+	"github.com/animalet/sargantana-go/pkg/config"
+	"github.com/animalet/sargantana-go/pkg/config/secrets"
+	"github.com/animalet/sargantana-go/pkg/server"
 
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
@@ -123,4 +130,104 @@ aws: "this should be an object"
 			Expect(err.Error()).To(ContainSubstring("failed to load or create AWS Secrets Manager client"))
 		})
 	})
+
+	Describe("printEffectiveConfig", func() {
+		It("should print the configuration with secrets redacted and sources", func() {
+			tmpDir := GinkgoT().TempDir()
+			configPath := filepath.Join(tmpDir, "config.yaml")
+			GinkgoT().Setenv("PRINT_CONFIG_ADDRESS", "localhost:9999")
+
+			validConfig := `sargantana:
+  server:
+    address: ${PRINT_CONFIG_ADDRESS}
+    session_name: test_session
+    session_secret: a_very_long_secret_key_for_testing_purposes
+  controllers:
+    - type: static
+      config:
+        status: 200
+        body: "OK"
+`
+			Expect(os.WriteFile(configPath, []byte(validConfig), 0644)).To(Succeed())
+
+			var out bytes.Buffer
+			Expect(printEffectiveConfig(configPath, &out)).To(Succeed())
+			Expect(out.String()).To(ContainSubstring(`address: localhost:9999 # env`))
+			Expect(out.String()).To(ContainSubstring(`session_secret: <redacted> # config file`))
+			Expect(out.String()).NotTo(ContainSubstring("a_very_long_secret_key_for_testing_purposes"))
+		})
+
+		It("should fail with a configuration error for an invalid server section", func() {
+			tmpDir := GinkgoT().TempDir()
+			configPath := filepath.Join(tmpDir, "config.yaml")
+			Expect(os.WriteFile(configPath, []byte("sargantana: \"invalid\"\n"), 0644)).To(Succeed())
+
+			err := printEffectiveConfig(configPath, &bytes.Buffer{})
+			Expect(err).To(HaveOccurred())
+			Expect(exitCode(err)).To(Equal(exitConfigError))
+		})
+	})
+})
+
+var _ = Describe("Configuration Encryption", func() {
+	masterKey := base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{3}, 32))
+
+	BeforeEach(func() {
+		DeferCleanup(func() { _ = secrets.SetMasterKey(nil) })
+	})
+
+	It("should encrypt the values read from the standard input", func() {
+		encrypted, err := encryptValue(masterKey, strings.NewReader("s3cr3t\n"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(encrypted).To(HavePrefix("ENC[AES256_GCM,"))
+		Expect(secrets.Decrypt(encrypted)).To(Equal("s3cr3t"))
+
+		_, err = encryptValue("", strings.NewReader("s3cr3t"))
+		Expect(err).To(MatchError(ContainSubstring(secrets.MasterKeyEnv)))
+		_, err = encryptValue(masterKey, strings.NewReader("\n"))
+		Expect(err).To(MatchError(ContainSubstring("empty")))
+	})
+
+	It("should decrypt the encrypted values of the configuration with the master key of the environment", func() {
+		encrypted, err := encryptValue(masterKey, strings.NewReader("a_very_long_secret_key_for_testing_purposes"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(secrets.SetMasterKey(nil)).To(Succeed())
+		GinkgoT().Setenv(secrets.MasterKeyEnv, masterKey)
+
+		configPath := filepath.Join(GinkgoT().TempDir(), "config.yaml")
+		Expect(os.WriteFile(configPath, []byte(`sargantana:
+  server:
+    address: :9999
+    session_name: test_session
+    session_secret: `+encrypted+`
+  controllers:
+    - type: static
+      config:
+        status: 200
+        body: "OK"
+`), 0644)).To(Succeed())
+
+		cfg, err := loadConfig(configPath)
+		Expect(err).NotTo(HaveOccurred())
+		serverCfg, err := config.Get[server.SargantanaConfig](cfg, "sargantana")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(serverCfg.WebServerConfig.SessionSecret).To(Equal("a_very_long_secret_key_for_testing_purposes"))
+
+		var out bytes.Buffer
+		Expect(cfg.WriteEffective(&out)).To(Succeed())
+		Expect(out.String()).To(ContainSubstring("session_secret: <redacted> # encrypted"))
+	})
+
+	It("should load the master key from the encryption section", func() {
+		configPath := filepath.Join(GinkgoT().TempDir(), "config.yaml")
+		GinkgoT().Setenv("TEST_MASTER_KEY", masterKey)
+		Expect(os.WriteFile(configPath, []byte("encryption:\n  master_key: ${env:TEST_MASTER_KEY}\n"), 0644)).To(Succeed())
+		_, err := loadConfig(configPath)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(secrets.Encrypt("value")).To(HavePrefix("ENC["))
+
+		Expect(os.WriteFile(configPath, []byte("encryption:\n  master_key: short\n"), 0644)).To(Succeed())
+		_, err = loadConfig(configPath)
+		Expect(err).To(MatchError(ContainSubstring("failed to load encryption configuration")))
+	})
 })
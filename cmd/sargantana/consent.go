@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+
+	"github.com/animalet/sargantana-go/pkg/controller"
+	"github.com/animalet/sargantana-go/pkg/controller/consent"
+	"github.com/animalet/sargantana-go/pkg/controller/privacy"
+	"github.com/animalet/sargantana-go/pkg/server"
+	"github.com/pkg/errors"
+)
+
+// registerConsent registers the consents controller type, keeping the consents in the database of
+// the postgres configuration section. The auth controller records the consents of the logins once
+// a consents controller is created.
+func registerConsent(postgres *sharedPostgres) {
+	var store consent.Store
+
+	server.RegisterController("consents", func(c *consent.Config, ctx server.ControllerContext) (server.IController, error) {
+		if store == nil {
+			pool, err := postgres.get()
+			if err != nil {
+				return nil, err
+			}
+			if pool == nil {
+				return nil, errors.New("the consents controller requires the postgres configuration section")
+			}
+			store = consent.NewPostgresStore(pool)
+			controller.ConsentStore = store
+			privacy.RegisterSource("consents", consentSource{store: store})
+		}
+		return consent.NewController(store)(c, ctx)
+	})
+}
+
+// consentSource exports and erases the consents of the users
+type consentSource struct {
+	store consent.Store
+}
+
+func (s consentSource) Export(ctx context.Context, user string) (any, error) {
+	consents, err := s.store.List(ctx, user)
+	if err != nil || len(consents) == 0 {
+		return nil, err
+	}
+	return consents, nil
+}
+
+func (s consentSource) Delete(ctx context.Context, user string) error {
+	consents, err := s.store.List(ctx, user)
+	if err != nil {
+		return err
+	}
+	for _, c := range consents {
+		if err := s.store.Revoke(ctx, user, c.Provider); err != nil && !errors.Is(err, consent.ErrNotFound) {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+)
+
+// Service manager notifications, see sd_notify(3)
+const (
+	notifyReady     = "READY=1"
+	notifyReloading = "RELOADING=1"
+	notifyStopping  = "STOPPING=1"
+)
+
+// notifySocketEnv is the environment variable systemd sets for Type=notify services
+const notifySocketEnv = "NOTIFY_SOCKET"
+
+// sdNotify sends a state notification to the service manager. It does nothing when the process
+// is not started by systemd with a notification socket.
+func sdNotify(state string) error {
+	socket := os.Getenv(notifySocketEnv)
+	if socket == "" {
+		return nil
+	}
+	// Abstract sockets are given with a leading "@", which net handles on Linux
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		return errors.Wrap(err, "failed to connect to the service manager notification socket")
+	}
+	defer func() {
+		_ = conn.Close()
+	}()
+	if _, err := conn.Write([]byte(state)); err != nil {
+		return errors.Wrap(err, "failed to notify the service manager")
+	}
+	return nil
+}
+
+// notify sends a state notification to the service manager, logging failures as they must not
+// stop the server.
+func notify(state ...string) {
+	if err := sdNotify(strings.Join(state, "\n")); err != nil {
+		log.Warn().Err(err).Msg("Failed to notify the service manager")
+	}
+}
+
+// writePIDFile writes the process id to path, atomically so that readers never see a partial file.
+func writePIDFile(path string) error {
+	content := []byte(strconv.Itoa(os.Getpid()) + "\n")
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return errors.Wrap(err, "failed to create PID file")
+	}
+	defer func() {
+		_ = os.Remove(tmp.Name())
+	}()
+	if _, err := tmp.Write(content); err != nil {
+		_ = tmp.Close()
+		return errors.Wrap(err, "failed to write PID file")
+	}
+	if err := tmp.Close(); err != nil {
+		return errors.Wrap(err, "failed to write PID file")
+	}
+	// #nosec G302 -- PID files are meant to be readable by service managers and scripts
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return errors.Wrap(err, "failed to write PID file")
+	}
+	return errors.Wrap(os.Rename(tmp.Name(), path), "failed to write PID file")
+}
+
+// removePIDFile removes the PID file unless it was overwritten by another process.
+func removePIDFile(path string) error {
+	// #nosec G304 -- PID file path is provided by operator at startup, this is intentional
+	content, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return errors.Wrap(err, "failed to read PID file")
+	}
+	if strings.TrimSpace(string(content)) != strconv.Itoa(os.Getpid()) {
+		log.Warn().Str("pidfile", path).Msg("PID file belongs to another process, leaving it in place")
+		return nil
+	}
+	return errors.Wrap(os.Remove(path), "failed to remove PID file")
+}
@@ -0,0 +1,74 @@
+//go:build unit
+
+package main
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/animalet/sargantana-go/pkg/server"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/pkg/errors"
+)
+
+var _ = Describe("Process management", func() {
+	Describe("exitCode", func() {
+		It("should tell configuration, bind and runtime errors apart", func() {
+			Expect(exitCode(configError{errors.New("invalid address")})).To(Equal(exitConfigError))
+			Expect(exitCode(errors.Wrap(server.ErrListen, "server error"))).To(Equal(exitBindError))
+			Expect(exitCode(errors.Wrap(server.ErrPreflight, "server error"))).To(Equal(exitBindError))
+			Expect(exitCode(errors.Wrap(server.ErrFatal, "server error"))).To(Equal(exitFatalError))
+			Expect(exitCode(errors.Wrap(server.ErrUncleanShutdown, "forced shutdown"))).To(Equal(exitUncleanShutdown))
+			Expect(exitCode(errors.New("runtime error"))).To(Equal(exitError))
+		})
+	})
+
+	Describe("PID file", func() {
+		It("should write and remove the PID file", func() {
+			path := filepath.Join(GinkgoT().TempDir(), "sargantana.pid")
+			Expect(writePIDFile(path)).To(Succeed())
+
+			content, err := os.ReadFile(path)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(content)).To(Equal(strconv.Itoa(os.Getpid()) + "\n"))
+
+			Expect(removePIDFile(path)).To(Succeed())
+			Expect(path).NotTo(BeAnExistingFile())
+		})
+
+		It("should leave the PID file of another process", func() {
+			path := filepath.Join(GinkgoT().TempDir(), "sargantana.pid")
+			Expect(os.WriteFile(path, []byte("1\n"), 0644)).To(Succeed())
+
+			Expect(removePIDFile(path)).To(Succeed())
+			Expect(path).To(BeAnExistingFile())
+		})
+	})
+
+	Describe("sdNotify", func() {
+		It("should do nothing without notification socket", func() {
+			GinkgoT().Setenv(notifySocketEnv, "")
+			Expect(sdNotify(notifyReady)).To(Succeed())
+		})
+
+		It("should send the state to the notification socket", func() {
+			socket := filepath.Join(GinkgoT().TempDir(), "notify.sock")
+			conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: socket, Net: "unixgram"})
+			Expect(err).NotTo(HaveOccurred())
+			defer func() {
+				_ = conn.Close()
+			}()
+			GinkgoT().Setenv(notifySocketEnv, socket)
+
+			Expect(sdNotify(notifyStopping)).To(Succeed())
+
+			buf := make([]byte, 64)
+			n, err := conn.Read(buf)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(buf[:n])).To(Equal("STOPPING=1"))
+		})
+	})
+})
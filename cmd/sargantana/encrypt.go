@@ -0,0 +1,67 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/animalet/sargantana-go/pkg/config/secrets"
+	"github.com/pkg/errors"
+)
+
+const encryptCommand = "encrypt"
+
+// runEncryptCommand handles the "encrypt" command, printing the value read from the standard
+// input encrypted with the master key of the SARGANTANA_MASTER_KEY variable
+func runEncryptCommand(args []string) int {
+	if len(args) > 0 {
+		fmt.Fprintf(os.Stderr, "Error: encrypt reads the value from the standard input and takes no arguments\n")
+		return exitError
+	}
+	encrypted, err := encryptValue(os.Getenv(secrets.MasterKeyEnv), os.Stdin)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return exitError
+	}
+	fmt.Println(encrypted)
+	return exitSuccess
+}
+
+// encryptValue encrypts the first line of r with the master key encoded in base64
+func encryptValue(encodedKey string, r io.Reader) (string, error) {
+	if encodedKey == "" {
+		return "", errors.Errorf("%s must be set to the master key", secrets.MasterKeyEnv)
+	}
+	key, err := secrets.ParseMasterKey(encodedKey)
+	if err != nil {
+		return "", err
+	}
+	value, err := bufio.NewReader(r).ReadString('\n')
+	if err != nil && !errors.Is(err, io.EOF) {
+		return "", errors.Wrap(err, "failed to read the value")
+	}
+	value = strings.TrimRight(value, "\r\n")
+	if value == "" {
+		return "", errors.New("the value to encrypt is empty")
+	}
+	if err := secrets.SetMasterKey(key); err != nil {
+		return "", err
+	}
+	return secrets.Encrypt(value)
+}
+
+// loadMasterKey sets the master key decrypting the encrypted values of the configuration from the
+// SARGANTANA_MASTER_KEY variable, if set
+func loadMasterKey() error {
+	encoded := os.Getenv(secrets.MasterKeyEnv)
+	if encoded == "" {
+		return nil
+	}
+	key, err := secrets.ParseMasterKey(encoded)
+	if err != nil {
+		return errors.Wrapf(err, "invalid %s", secrets.MasterKeyEnv)
+	}
+	return secrets.SetMasterKey(key)
+}
@@ -4,12 +4,14 @@ import (
 	"flag"
 	"fmt"
 	"os"
+	"strconv"
 
 	"github.com/animalet/sargantana-go/pkg/config"
+	"github.com/animalet/sargantana-go/pkg/config/secrets"
 	"github.com/animalet/sargantana-go/pkg/controller"
+	"github.com/animalet/sargantana-go/pkg/logging"
 	"github.com/animalet/sargantana-go/pkg/server"
 	"github.com/pkg/errors"
-	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
 )
 
@@ -17,8 +19,45 @@ const (
 	programName = "sargantana"
 	exitSuccess = 0
 	exitError   = 1
+	// exitBindError is returned when the server cannot listen on its address, or a required
+	// preflight check fails in strict mode (EX_UNAVAILABLE)
+	exitBindError = 69
+	// exitFatalError is returned when the server shut down on a fatal error while serving (EX_SOFTWARE)
+	exitFatalError = 70
+	// exitUncleanShutdown is returned when connections were not drained in time or shutdown hooks
+	// failed (EX_TEMPFAIL)
+	exitUncleanShutdown = 75
+	// exitConfigError is returned when the configuration is invalid (EX_CONFIG)
+	exitConfigError = 78
 )
 
+// configError marks errors caused by the configuration, see exitCode
+type configError struct {
+	error
+}
+
+func (e configError) Unwrap() error {
+	return e.error
+}
+
+// exitCode returns the exit code for the failure class of err: configuration, bind, fatal, unclean
+// shutdown or runtime error
+func exitCode(err error) int {
+	var cfgErr configError
+	switch {
+	case errors.Is(err, server.ErrListen), errors.Is(err, server.ErrPreflight):
+		return exitBindError
+	case errors.Is(err, server.ErrFatal):
+		return exitFatalError
+	case errors.Is(err, server.ErrUncleanShutdown):
+		return exitUncleanShutdown
+	case errors.As(err, &cfgErr):
+		return exitConfigError
+	default:
+		return exitError
+	}
+}
+
 func main() {
 	os.Exit(run())
 }
@@ -29,6 +68,31 @@ func run() int {
 
 // runWithArgs allows tests to pass custom arguments
 func runWithArgs(args []string) int {
+	// Handle the Windows service management commands
+	if len(args) > 0 && args[0] == serviceCommand {
+		return runServiceCommand(args[1:])
+	}
+
+	// Handle the encryption of configuration values
+	if len(args) > 0 && args[0] == encryptCommand {
+		return runEncryptCommand(args[1:])
+	}
+
+	// Handle the smoke tests of a running gateway
+	if len(args) > 0 && args[0] == checkCommand {
+		return runCheckCommand(args[1:])
+	}
+
+	// Handle the load tests of a running gateway
+	if len(args) > 0 && args[0] == benchCommand {
+		return runBenchCommand(args[1:])
+	}
+
+	// Handle the generation of the OpenAPI description and TypeScript client
+	if len(args) > 0 && args[0] == openAPICommand {
+		return runOpenAPICommand(args[1:])
+	}
+
 	// Parse command-line flags
 	opts, err := parseFlags(args)
 	if err != nil {
@@ -49,7 +113,10 @@ func runWithArgs(args []string) int {
 	}
 
 	// Setup logging
-	setupLogging(opts.debug)
+	if err := setupLogging(opts); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return exitError
+	}
 
 	// Validate required flags
 	if opts.configPath == "" {
@@ -58,10 +125,19 @@ func runWithArgs(args []string) int {
 		return exitError
 	}
 
+	// Print the effective configuration instead of running the server
+	if opts.printConfig {
+		if err := printEffectiveConfig(opts.configPath, os.Stdout); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return exitCode(err)
+		}
+		return exitSuccess
+	}
+
 	// Run the server
 	if err := runServer(opts); err != nil {
 		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
-		return exitError
+		return exitCode(err)
 	}
 
 	return exitSuccess
@@ -71,22 +147,20 @@ func runWithArgs(args []string) int {
 type options struct {
 	configPath  string
 	debug       bool
+	quiet       bool
+	logFormat   string
+	pidFile     string
+	printConfig bool
 	showVersion bool
 	showHelp    bool
+	// started is called once the server accepts connections, used by the Windows service
+	started func(srv *server.Server)
 }
 
 // parseFlags parses command-line flags and returns options or an error
 func parseFlags(args []string) (*options, error) {
 	opts := &options{}
-
-	fs := flag.NewFlagSet(programName, flag.ContinueOnError)
-	fs.SetOutput(os.Stderr)
-
-	// Define flags
-	fs.StringVar(&opts.configPath, "config", "", "Path to configuration file (required)")
-	fs.BoolVar(&opts.debug, "debug", false, "Enable debug mode")
-	fs.BoolVar(&opts.showVersion, "version", false, "Show version information and exit")
-	fs.BoolVar(&opts.showHelp, "help", false, "Show this help message and exit")
+	fs := newFlagSet(programName, opts)
 
 	// Custom usage function
 	fs.Usage = func() {
@@ -107,6 +181,23 @@ func parseFlags(args []string) (*options, error) {
 	return opts, nil
 }
 
+// newFlagSet creates a flag set defining the server options, stored in opts
+func newFlagSet(name string, opts *options) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(os.Stderr)
+
+	// Define flags
+	fs.StringVar(&opts.configPath, "config", "", "Path to configuration file (required)")
+	fs.BoolVar(&opts.debug, "debug", false, "Enable debug mode")
+	fs.BoolVar(&opts.quiet, "quiet", false, "Suppress colored and informational console output")
+	fs.StringVar(&opts.logFormat, "log-format", "", "Log format, console or json (overrides the configuration)")
+	fs.StringVar(&opts.pidFile, "pidfile", "", "Write the process id to this file while running")
+	fs.BoolVar(&opts.printConfig, "print-config", false, "Print the effective configuration with secrets redacted and exit")
+	fs.BoolVar(&opts.showVersion, "version", false, "Show version information and exit")
+	fs.BoolVar(&opts.showHelp, "help", false, "Show this help message and exit")
+	return fs
+}
+
 // printUsage prints the usage message to the specified writer
 func printUsage(w *os.File) {
 	usage := `Usage: %s [OPTIONS]
@@ -116,36 +207,85 @@ Sargantana is a flexible web authentication gateway and reverse proxy.
 OPTIONS:
   --config PATH    Path to configuration file (required)
   --debug          Enable debug mode with verbose logging
+  --quiet          Only log warnings and errors, without colors or request logs
+  --log-format FMT Log format: console or json, overriding the logging section
+  --pidfile PATH   Write the process id to PATH while the server is running
+  --print-config   Print the effective configuration, with secrets redacted and the
+                   source of every value, and exit
   --version        Display version information and exit
   --help           Display this help message and exit
 
+COMMANDS:
+  encrypt                     Print the value read from the standard input encrypted with
+                              the master key of SARGANTANA_MASTER_KEY, as ENC[...]
+  openapi --config PATH       Print the OpenAPI description of the auth, admin, health and
+          [--output FILE]     key set endpoints of the configuration, and write a TypeScript
+          [--typescript FILE] client with --typescript
+  check --url URL             Check a running gateway: health endpoints, TLS certificate,
+        [--config PATH]       security headers and login redirects, and print a pass/fail
+        [--format text|json]  report. Exits with 1 if a check failed
+  bench --url URL             Send requests to a running gateway for --duration with
+        [--paths P1,P2]       --concurrency concurrent requests, optionally with --cookie,
+        [--concurrency N]     and print the latency percentiles and error rates. Exits
+        [--duration D]        with 1 above --max-error-rate (default 0.01)
+
+COMMANDS (Windows only):
+  service install [OPTIONS]   Register a Windows service running with OPTIONS
+  service uninstall           Remove the Windows service
+  service run [OPTIONS]       Run as a Windows service (used by the service manager)
+
 EXAMPLES:
   %s --config /etc/sargantana/config.yaml
   %s --config ./config.yaml --debug
+  %s --config ./config.yaml --quiet --log-format json
+  %s --config ./config.yaml --print-config
+
+EXIT CODES:
+  0    Clean shutdown
+  1    Runtime error
+  69   The server cannot listen on its address, or a required preflight check failed
+  70   The server shut down on a fatal error while serving
+  75   Unclean shutdown: connections were not drained in time, or shutdown hooks failed
+  78   Invalid configuration
 
 For more information, visit: https://github.com/animalet/sargantana-go
 `
-	_, err := fmt.Fprintf(w, usage, programName, programName, programName)
+	_, err := fmt.Fprintf(w, usage, programName, programName, programName, programName, programName)
 	if err != nil {
 		panic(err)
 	}
 }
 
-// setupLogging configures the global logger
-func setupLogging(debug bool) {
-	// Set log level
-	if debug {
-		zerolog.SetGlobalLevel(zerolog.DebugLevel)
-	} else {
-		zerolog.SetGlobalLevel(zerolog.InfoLevel)
+// setupLogging configures the global logger with pretty console output, or the format selected
+// with --log-format, until the logging configuration section is loaded. Logs go to the standard
+// error with --print-config, keeping the standard output for the configuration.
+func setupLogging(opts *options) error {
+	logCfg := applyLogFlags(logging.Config{}, opts)
+	out := os.Stdout
+	if opts.printConfig {
+		out = os.Stderr
 	}
+	if err := logging.Configure(logCfg, out); err != nil {
+		return err
+	}
+	server.SetQuiet(opts.quiet)
+	logging.SetDebug(opts.debug)
+	return nil
+}
 
-	// Configure pretty console output
-	log.Logger = log.Output(zerolog.ConsoleWriter{
-		Out:        os.Stdout,
-		NoColor:    false,
-		TimeFormat: "2006-01-02 15:04:05",
-	})
+// applyLogFlags overrides the logging configuration with the --quiet and --log-format flags.
+// Quiet mode disables colors and raises the default level to warn, unless a level is configured.
+func applyLogFlags(logCfg logging.Config, opts *options) logging.Config {
+	if opts.logFormat != "" {
+		logCfg.Format = opts.logFormat
+	}
+	if opts.quiet {
+		logCfg.NoColor = true
+		if logCfg.Level == "" {
+			logCfg.Level = "warn"
+		}
+	}
+	return logCfg
 }
 
 // initServer initializes and returns the Sargantana server (for tests)
@@ -156,6 +296,17 @@ func initServer(opts *options) (*server.Server, func() error, error) {
 		return nil, nil, err
 	}
 
+	// Apply the logging configuration, if present
+	logCfg, err := config.Get[logging.Config](cfg, "logging")
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "failed to load logging configuration")
+	}
+	if logCfg != nil {
+		if err := logging.Configure(applyLogFlags(*logCfg, opts), nil); err != nil {
+			return nil, nil, errors.Wrap(err, "failed to configure logging")
+		}
+	}
+
 	// Get server configuration
 	serverCfg, err := config.Get[server.SargantanaConfig](cfg, "sargantana")
 	if err != nil {
@@ -165,24 +316,85 @@ func initServer(opts *options) (*server.Server, func() error, error) {
 		return nil, nil, errors.New("server configuration is required")
 	}
 
+	// Apply the controller configuration overrides set in the environment
+	serverCfg.ControllerBindings, err = serverCfg.ControllerBindings.WithEnvOverrides(os.Environ())
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "failed to override controller configuration")
+	}
+
+	// Load the signing keys, if present, before the controllers using them
+	closeSigningKeys, err := loadSigningKeys(cfg)
+	if err != nil {
+		return nil, nil, err
+	}
+
 	// Set debug mode
 	server.SetDebug(opts.debug)
+	server.SetQuiet(opts.quiet)
 
 	// Register all controllers
 	server.RegisterController("auth", controller.NewAuthController)
+	server.RegisterController("debug_echo", controller.NewDebugEchoController)
+	server.RegisterController("grpc_gateway", controller.NewGRPCGatewayController)
+	server.RegisterController("jwks", controller.NewJWKSController)
 	server.RegisterController("load_balancer", controller.NewLoadBalancerController)
+	server.RegisterController("log_levels", controller.NewLogLevelsController)
+	server.RegisterController("quota_admin", controller.NewQuotaAdminController)
+	server.RegisterController("revocation", controller.NewRevocationController)
 	server.RegisterController("static", controller.NewStaticController)
 	server.RegisterController("template", controller.NewTemplateController)
+	server.RegisterController("webdav", controller.NewWebDAVController)
+
+	// Register all binding middlewares
+	registerMiddlewares()
+
+	// The pool of the postgres configuration section, shared by the stores kept there
+	postgres := newSharedPostgres(cfg)
+
+	// Register all authenticators
+	closeAuthenticators := registerAuthenticators(postgres)
+
+	// Register the CMS, with its store injected from the postgres configuration section
+	registerCMS(postgres)
+
+	// Register the user profiles, with their store injected from the postgres configuration section
+	registerProfile(postgres)
+
+	// Register the personal data exports and deletion requests
+	registerPrivacy(postgres)
+
+	// Register the OAuth consents, recorded by the auth controller once configured
+	registerConsent(postgres)
+
+	// Register the controller types of the plugins, which may override the built-in ones
+	if err := loadPlugins(cfg); err != nil {
+		return nil, nil, err
+	}
 
 	// Create server
 	srv := server.NewServer(*serverCfg)
 
-	// Configure authentication
-	srv.SetAuthenticator(controller.NewGothAuthenticator())
+	// Configure authentication, with goth sessions unless selected in the configuration
+	if serverCfg.WebServerConfig.Authenticator == nil {
+		srv.SetAuthenticator(controller.NewGothAuthenticator())
+	}
+
+	// Provide the Vault client for server certificates issued by Vault PKI
+	if tlsCfg := serverCfg.WebServerConfig.TLS; tlsCfg != nil && tlsCfg.VaultPKI != nil {
+		vaultClient, err := config.GetClient[secrets.VaultConfig](cfg, "vault")
+		if err != nil {
+			return nil, nil, errors.Wrap(err, "failed to load or create Vault client")
+		}
+		if vaultClient == nil {
+			return nil, nil, errors.New("tls.vault_pki requires the vault configuration section")
+		}
+		srv.SetVaultClient(*vaultClient)
+	}
 
 	// Configure session store and get cleanup function
 	closeSessionStore, err := configureSessionStore(
 		cfg,
+		postgres,
 		srv,
 		[]byte(serverCfg.WebServerConfig.SessionSecret),
 		opts.debug,
@@ -191,18 +403,32 @@ func initServer(opts *options) (*server.Server, func() error, error) {
 		return nil, nil, err
 	}
 
-	return srv, closeSessionStore, nil
+	return srv, func() error {
+		if err := closeAuthenticators(); err != nil {
+			return err
+		}
+		if err := closeSigningKeys(); err != nil {
+			return err
+		}
+		if err := closeSessionStore(); err != nil {
+			return err
+		}
+		return postgres.Close()
+	}, nil
 }
 
 // runServer initializes and runs the Sargantana server
 func runServer(opts *options) error {
-	srv, closeSessionStore, err := initServer(opts)
+	srv, closeResources, err := initServer(opts)
 	if err != nil {
-		return err
+		return configError{err}
 	}
 	defer func() {
-		if err := closeSessionStore(); err != nil {
-			log.Error().Err(err).Msg("Failed to close session store")
+		if err := closeResources(); err != nil {
+			log.Error().Err(err).Msg("Failed to close session store, authenticators or databases")
+		}
+		if err := logging.Close(); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: failed to close log output: %v\n", err)
 		}
 	}()
 
@@ -212,9 +438,81 @@ func runServer(opts *options) error {
 		Bool("debug", opts.debug).
 		Msg("Starting Sargantana server")
 
-	if err := srv.StartAndWaitForSignal(); err != nil {
+	if err := srv.Start(); err != nil {
+		if errors.Is(err, server.ErrListen) || errors.Is(err, server.ErrPreflight) {
+			return errors.Wrap(err, "server error")
+		}
+		return configError{errors.Wrap(err, "server error")}
+	}
+
+	if opts.pidFile != "" {
+		if err := writePIDFile(opts.pidFile); err != nil {
+			_ = srv.Shutdown()
+			return err
+		}
+		defer func() {
+			if err := removePIDFile(opts.pidFile); err != nil {
+				log.Warn().Err(err).Msg("Failed to remove PID file")
+			}
+		}()
+	}
+
+	srv.OnReload(func() error {
+		notify(notifyReloading)
+		defer notify(notifyReady)
+		return reloadLogging(opts)
+	})
+	srv.OnStopping(func() {
+		notify(notifyStopping)
+	})
+	logReady(srv, opts.quiet)
+	notify(notifyReady, "MAINPID="+strconv.Itoa(os.Getpid()))
+	if opts.started != nil {
+		opts.started(srv)
+	}
+
+	if err := srv.WaitForSignal(); err != nil {
 		return errors.Wrap(err, "server error")
 	}
+	if report := srv.ShutdownReport(); report != nil && !report.Clean() {
+		return errors.Wrapf(server.ErrUncleanShutdown, "%d shutdown hooks failed", report.HooksFailed)
+	}
 
 	return nil
 }
+
+// reloadLogging reads the logging section of the configuration file again and applies it, which
+// also reopens log files moved away by external log rotation.
+func reloadLogging(opts *options) error {
+	cfg, err := config.NewConfig(opts.configPath)
+	if err != nil {
+		return errors.Wrap(err, "failed to reload configuration file")
+	}
+	logCfg, err := config.Get[logging.Config](cfg, "logging")
+	if err != nil {
+		return errors.Wrap(err, "failed to reload logging configuration")
+	}
+	if logCfg == nil {
+		return nil
+	}
+	if err := logging.Configure(applyLogFlags(*logCfg, opts), nil); err != nil {
+		return errors.Wrap(err, "failed to reconfigure logging")
+	}
+	logging.SetDebug(opts.debug)
+	return nil
+}
+
+// logReady emits the single "ready" event that orchestration tooling can wait for once the server
+// accepts connections. In quiet mode it is logged without level, so it is never filtered out.
+func logReady(srv *server.Server, quiet bool) {
+	event := log.Info()
+	if quiet {
+		event = log.Log()
+	}
+	event.
+		Str("event", "ready").
+		Strs("listeners", srv.Addresses()).
+		Int("pid", os.Getpid()).
+		Str("version", version).
+		Msg("Sargantana server is ready")
+}
@@ -8,6 +8,7 @@ import (
 	"os"
 	"path/filepath"
 
+	"github.com/animalet/sargantana-go/pkg/logging"
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
 	"github.com/rs/zerolog"
@@ -31,6 +32,13 @@ var _ = Describe("Command-Line Argument Parsing", func() {
 			Expect(opts.debug).To(BeTrue())
 		})
 
+		It("should parse quiet and log format flags", func() {
+			opts, err := parseFlags([]string{"--config", "/path/to/config.yaml", "--quiet", "--log-format", "json"})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(opts.quiet).To(BeTrue())
+			Expect(opts.logFormat).To(Equal("json"))
+		})
+
 		It("should parse version flag", func() {
 			opts, err := parseFlags([]string{"--version"})
 			Expect(err).NotTo(HaveOccurred())
@@ -107,14 +115,36 @@ var _ = Describe("Usage Message", func() {
 
 var _ = Describe("Logging Setup", func() {
 	It("should set debug level when debug mode is enabled", func() {
-		setupLogging(true)
+		Expect(setupLogging(&options{debug: true})).To(Succeed())
 		Expect(zerolog.GlobalLevel()).To(Equal(zerolog.DebugLevel))
 	})
 
 	It("should set info level when debug mode is disabled", func() {
-		setupLogging(false)
+		Expect(setupLogging(&options{})).To(Succeed())
 		Expect(zerolog.GlobalLevel()).To(Equal(zerolog.InfoLevel))
 	})
+
+	It("should set warn level in quiet mode", func() {
+		defer func() {
+			Expect(setupLogging(&options{})).To(Succeed())
+		}()
+		Expect(setupLogging(&options{quiet: true})).To(Succeed())
+		Expect(zerolog.GlobalLevel()).To(Equal(zerolog.WarnLevel))
+	})
+
+	It("should reject an unsupported log format", func() {
+		Expect(setupLogging(&options{logFormat: "xml"})).To(HaveOccurred())
+	})
+
+	It("should override the configured format and level with the flags", func() {
+		logCfg := applyLogFlags(logging.Config{Format: logging.FormatConsole}, &options{quiet: true, logFormat: logging.FormatJSON})
+		Expect(logCfg.Format).To(Equal(logging.FormatJSON))
+		Expect(logCfg.Level).To(Equal("warn"))
+		Expect(logCfg.NoColor).To(BeTrue())
+
+		logCfg = applyLogFlags(logging.Config{Level: "error"}, &options{quiet: true})
+		Expect(logCfg.Level).To(Equal("error"))
+	})
 })
 
 var _ = Describe("runWithArgs", func() {
@@ -171,7 +201,7 @@ var _ = Describe("runWithArgs", func() {
 
 	It("should fail with invalid config path", func() {
 		exitCode := runWithArgs([]string{"--config", "/nonexistent/config.yaml"})
-		Expect(exitCode).To(Equal(exitError))
+		Expect(exitCode).To(Equal(exitConfigError))
 	})
 
 	It("should fail with invalid flag", func() {
@@ -244,6 +274,72 @@ var _ = Describe("initServer", func() {
 
 		Expect(closeFunc()).To(Succeed())
 	})
+
+	It("should fail when tls.vault_pki is configured without vault section", func() {
+		tmpDir := GinkgoT().TempDir()
+		configPath := filepath.Join(tmpDir, "vault-pki.yaml")
+
+		vaultPKIConfig := `sargantana:
+  server:
+    address: :9999
+    session_name: test_session
+    session_secret: a_very_long_secret_key_for_testing_purposes_that_meets_minimum_requirements
+    tls:
+      vault_pki:
+        role: gateway
+        common_name: gateway.internal
+  controllers: []
+`
+		Expect(os.WriteFile(configPath, []byte(vaultPKIConfig), 0644)).To(Succeed())
+
+		opts := &options{configPath: configPath}
+		_, _, err := initServer(opts)
+		Expect(err).To(HaveOccurred())
+		Expect(err.Error()).To(ContainSubstring("tls.vault_pki requires the vault configuration section"))
+	})
+
+	It("should fail with an authenticator section without type", func() {
+		tmpDir := GinkgoT().TempDir()
+		configPath := filepath.Join(tmpDir, "authenticator.yaml")
+
+		authenticatorConfig := `sargantana:
+  server:
+    address: :9999
+    session_name: test_session
+    session_secret: a_very_long_secret_key_for_testing_purposes_that_meets_minimum_requirements
+    authenticator:
+      config:
+        secret: jwt-secret
+  controllers: []
+`
+		Expect(os.WriteFile(configPath, []byte(authenticatorConfig), 0644)).To(Succeed())
+
+		opts := &options{configPath: configPath}
+		_, _, err := initServer(opts)
+		Expect(err).To(HaveOccurred())
+		Expect(err.Error()).To(ContainSubstring("exactly one of authenticator type or any_of must be set"))
+	})
+
+	It("should fail with an invalid logging section", func() {
+		tmpDir := GinkgoT().TempDir()
+		configPath := filepath.Join(tmpDir, "logging.yaml")
+
+		loggingConfig := `logging:
+  format: xml
+sargantana:
+  server:
+    address: :9999
+    session_name: test_session
+    session_secret: a_very_long_secret_key_for_testing_purposes_that_meets_minimum_requirements
+  controllers: []
+`
+		Expect(os.WriteFile(configPath, []byte(loggingConfig), 0644)).To(Succeed())
+
+		opts := &options{configPath: configPath}
+		_, _, err := initServer(opts)
+		Expect(err).To(HaveOccurred())
+		Expect(err.Error()).To(ContainSubstring("failed to load logging configuration"))
+	})
 })
 
 var _ = Describe("runServer", func() {
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"github.com/animalet/sargantana-go/pkg/scripting"
+	"github.com/animalet/sargantana-go/pkg/server"
+	"github.com/animalet/sargantana-go/pkg/wasm"
+	"github.com/gin-gonic/gin"
+)
+
+// registerMiddlewares registers the middleware types selectable in the middlewares of controller
+// bindings. They apply the login middleware variants of the binding to all its routes, authorize
+// them with policies, make their unsafe requests idempotent, enforce usage quotas, inject
+// snippets into their HTML pages, compress their responses, or run them through WebAssembly or
+// Lua hooks.
+func registerMiddlewares() {
+	server.RegisterMiddleware("login", func(_ *server.LoginMiddlewareConfig, ctx server.ControllerContext) (gin.HandlerFunc, error) {
+		return ctx.LoginMiddleware(), nil
+	})
+	server.RegisterMiddleware("optional_auth", func(_ *server.LoginMiddlewareConfig, ctx server.ControllerContext) (gin.HandlerFunc, error) {
+		return ctx.OptionalAuth(), nil
+	})
+	server.RegisterMiddleware("require_role", func(c *server.RoleMiddlewareConfig, ctx server.ControllerContext) (gin.HandlerFunc, error) {
+		return ctx.RequireRole(c.Role), nil
+	})
+	server.RegisterMiddleware("require_scope", func(c *server.ScopeMiddlewareConfig, ctx server.ControllerContext) (gin.HandlerFunc, error) {
+		return ctx.RequireScope(c.Scope), nil
+	})
+	server.RegisterMiddleware("policy", func(c *server.PolicyConfig, _ server.ControllerContext) (gin.HandlerFunc, error) {
+		return server.NewPolicyMiddleware(*c)
+	})
+	server.RegisterMiddleware("idempotency", func(c *server.IdempotencyConfig, _ server.ControllerContext) (gin.HandlerFunc, error) {
+		return server.NewIdempotencyMiddleware(*c)
+	})
+	server.RegisterMiddleware("quota", func(c *server.QuotaConfig, _ server.ControllerContext) (gin.HandlerFunc, error) {
+		return server.NewQuotaMiddleware(*c)
+	})
+	server.RegisterMiddleware("html_injection", func(c *server.HTMLInjectionConfig, _ server.ControllerContext) (gin.HandlerFunc, error) {
+		return server.NewHTMLInjectionMiddleware(*c)
+	})
+	server.RegisterMiddleware("compression", func(c *server.CompressionConfig, _ server.ControllerContext) (gin.HandlerFunc, error) {
+		return server.NewCompressionMiddleware(*c)
+	})
+	server.RegisterMiddleware("wasm", func(c *wasm.Config, _ server.ControllerContext) (gin.HandlerFunc, error) {
+		return wasm.NewMiddleware(*c)
+	})
+	server.RegisterMiddleware("lua", func(c *scripting.Config, _ server.ControllerContext) (gin.HandlerFunc, error) {
+		return scripting.NewMiddleware(*c)
+	})
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/animalet/sargantana-go/pkg/config"
+	"github.com/animalet/sargantana-go/pkg/controller"
+	"github.com/animalet/sargantana-go/pkg/logging"
+	"github.com/animalet/sargantana-go/pkg/openapi"
+	"github.com/animalet/sargantana-go/pkg/server"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+)
+
+const openAPICommand = "openapi"
+
+// describedControllers are the controller types whose routes are described by the openapi command
+var describedControllers = map[string]func(config.ModuleRawConfig) (openapi.Describer, error){
+	"auth":        describer[controller.AuthControllerConfig],
+	"jwks":        describer[controller.JWKSControllerConfig],
+	"log_levels":  describer[controller.LogLevelsControllerConfig],
+	"quota_admin": describer[controller.QuotaAdminControllerConfig],
+	"revocation":  describer[controller.RevocationControllerConfig],
+}
+
+// describer decodes the configuration of a controller describing its routes
+func describer[T interface {
+	config.Validatable
+	openapi.Describer
+}](raw config.ModuleRawConfig) (openapi.Describer, error) {
+	cfg, err := config.Unmarshal[T](raw)
+	if err != nil {
+		return nil, err
+	}
+	if err := (*cfg).Validate(); err != nil {
+		return nil, err
+	}
+	return *cfg, nil
+}
+
+// runOpenAPICommand handles the "openapi" command, printing the OpenAPI description of the
+// endpoints of the gateway configured in the configuration file, and optionally writing a
+// TypeScript client
+func runOpenAPICommand(args []string) int {
+	fs := flag.NewFlagSet(programName+" "+openAPICommand, flag.ContinueOnError)
+	fs.SetOutput(os.Stderr)
+	configPath := fs.String("config", "", "Path to configuration file (required)")
+	output := fs.String("output", "", "Write the OpenAPI document to this file instead of the standard output")
+	typescript := fs.String("typescript", "", "Write a TypeScript client to this file")
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return exitSuccess
+		}
+		return exitError
+	}
+	if *configPath == "" {
+		fmt.Fprintf(os.Stderr, "Error: --config flag is required\n")
+		return exitError
+	}
+
+	// Keep the standard output for the document
+	if err := logging.Configure(logging.Config{Level: "warn", NoColor: true}, os.Stderr); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return exitError
+	}
+	doc, err := describeGateway(*configPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return exitCode(err)
+	}
+
+	data, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return exitError
+	}
+	data = append(data, '\n')
+	if *output == "" {
+		_, err = os.Stdout.Write(data)
+	} else {
+		err = os.WriteFile(*output, data, 0o644)
+	}
+	if err == nil && *typescript != "" {
+		err = os.WriteFile(*typescript, []byte(doc.TypeScript()), 0o644)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return exitError
+	}
+	return exitSuccess
+}
+
+// describeGateway describes the liveness and readiness endpoints and the routes of the controllers of the
+// configuration file, with the environment overrides applied as the server does
+func describeGateway(configPath string) (*openapi.Document, error) {
+	cfg, err := loadConfig(configPath)
+	if err != nil {
+		return nil, configError{err}
+	}
+	serverCfg, err := config.Get[server.SargantanaConfig](cfg, "sargantana")
+	if err != nil {
+		return nil, configError{errors.Wrap(err, "failed to load server configuration")}
+	}
+	if serverCfg == nil {
+		return nil, configError{errors.New("server configuration is required")}
+	}
+	bindings, err := serverCfg.ControllerBindings.WithEnvOverrides(os.Environ())
+	if err != nil {
+		return nil, configError{errors.Wrap(err, "failed to override controller configuration")}
+	}
+
+	doc := openapi.New("Sargantana gateway", version)
+	serverCfg.WebServerConfig.Describe(doc, "/")
+	for _, binding := range bindings {
+		describe, ok := describedControllers[binding.TypeName]
+		if !ok {
+			log.Debug().Str("type", binding.TypeName).Msg("Controller type not described")
+			continue
+		}
+		d, err := describe(binding.Config)
+		if err != nil {
+			return nil, configError{errors.Wrapf(err, "invalid configuration of controller type %s", binding.TypeName)}
+		}
+		d.Describe(doc, binding.BasePath)
+	}
+	return doc, nil
+}
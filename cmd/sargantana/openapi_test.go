@@ -0,0 +1,68 @@
+//go:build unit
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("OpenAPI generation", func() {
+	writeConfig := func(controllers string) string {
+		configPath := filepath.Join(GinkgoT().TempDir(), "config.yaml")
+		Expect(os.WriteFile(configPath, []byte(`sargantana:
+  server:
+    address: :9999
+    session_name: test_session
+    session_secret: a_very_long_secret_key_for_testing_purposes
+    preflight:
+      readiness_path: /readyz
+  controllers:
+`+controllers), 0644)).To(Succeed())
+		return configPath
+	}
+
+	It("should describe the endpoints of the configured controllers", func() {
+		doc, err := describeGateway(writeConfig(`    - type: auth
+      base_path: /app
+      config:
+        callback_path: /auth/{provider}/callback
+        login_path: /auth/{provider}
+        logout_path: /auth/logout
+        user_info_path: /auth/user
+        redirect_on_login: /
+        redirect_on_logout: /
+        providers:
+          github: {key: k, secret: s}
+    - type: log_levels
+      config:
+        path: /admin/log-levels
+        auth: true
+    - type: static
+      config:
+        status: 200
+        body: "OK"
+`))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(doc.Paths).To(HaveKey("/readyz"))
+		Expect(doc.Paths).To(HaveKey("/app/auth/{provider}"))
+		Expect(doc.Paths).To(HaveKey("/app/auth/user"))
+		Expect(doc.Paths).To(HaveKey("/admin/log-levels"))
+		Expect(doc.Paths).To(HaveLen(6))
+		Expect(*doc.Paths["/app/auth/{provider}/callback"]).To(HaveKey("post"))
+		Expect(doc.Components.Schemas).To(HaveKey("UserObject"))
+		Expect(doc.TypeScript()).To(ContainSubstring("getUser(): Promise<UserObject>"))
+	})
+
+	It("should fail with a configuration error for an invalid controller", func() {
+		_, err := describeGateway(writeConfig(`    - type: jwks
+      config:
+        max_age: 5m
+`))
+		Expect(err).To(MatchError(ContainSubstring("path must be set")))
+		Expect(exitCode(err)).To(Equal(exitConfigError))
+	})
+})
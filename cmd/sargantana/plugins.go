@@ -0,0 +1,21 @@
+package main
+
+import (
+	"github.com/animalet/sargantana-go/pkg/config"
+	"github.com/animalet/sargantana-go/pkg/plugins"
+	"github.com/pkg/errors"
+)
+
+// loadPlugins registers the controller types of the plugins configuration section, if present,
+// see plugins.Load. It must run after the built-in controller types are registered, so that
+// plugins may override them.
+func loadPlugins(cfg *config.Config) error {
+	pluginsCfg, err := config.Get[plugins.Config](cfg, "plugins")
+	if err != nil {
+		return errors.Wrap(err, "failed to load plugins configuration")
+	}
+	if pluginsCfg == nil {
+		return nil
+	}
+	return plugins.Load(*pluginsCfg)
+}
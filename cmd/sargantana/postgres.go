@@ -0,0 +1,46 @@
+package main
+
+import (
+	"github.com/animalet/sargantana-go/pkg/config"
+	"github.com/animalet/sargantana-go/pkg/database"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/pkg/errors"
+)
+
+// sharedPostgres opens the pool of the postgres configuration section with its first user and
+// shares it with the others, so that the features keeping their data there do not multiply the
+// connections to the database.
+type sharedPostgres struct {
+	cfg    *config.Config
+	pool   *pgxpool.Pool
+	opened bool
+}
+
+func newSharedPostgres(cfg *config.Config) *sharedPostgres {
+	return &sharedPostgres{cfg: cfg}
+}
+
+// get returns the pool, or nil if the postgres configuration section is not present
+func (p *sharedPostgres) get() (*pgxpool.Pool, error) {
+	if !p.opened {
+		pgPool, err := config.GetClient[database.PostgresConfig](p.cfg, "postgres")
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to load or create PostgreSQL client")
+		}
+		if pgPool != nil {
+			p.pool = *pgPool
+		}
+		p.opened = true
+	}
+	return p.pool, nil
+}
+
+// Close closes the pool, if opened
+func (p *sharedPostgres) Close() error {
+	if p.pool != nil {
+		database.ClosePostgres(p.pool)
+		p.pool = nil
+	}
+	p.opened = false
+	return nil
+}
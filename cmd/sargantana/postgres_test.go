@@ -0,0 +1,35 @@
+//go:build unit
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Shared PostgreSQL pool", func() {
+	load := func(content string) *sharedPostgres {
+		configPath := filepath.Join(GinkgoT().TempDir(), "config.yaml")
+		Expect(os.WriteFile(configPath, []byte(content), 0644)).To(Succeed())
+		cfg, err := loadConfig(configPath)
+		Expect(err).NotTo(HaveOccurred())
+		return newSharedPostgres(cfg)
+	}
+
+	It("should return no pool without the postgres configuration section", func() {
+		postgres := load("sargantana:\n  server:\n    address: :9999\n")
+		pool, err := postgres.get()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(pool).To(BeNil())
+		Expect(postgres.Close()).To(Succeed())
+	})
+
+	It("should fail with an invalid postgres configuration section", func() {
+		postgres := load("sargantana:\n  server:\n    address: :9999\npostgres:\n  host: localhost\n")
+		_, err := postgres.get()
+		Expect(err).To(MatchError(ContainSubstring("failed to load or create PostgreSQL client")))
+	})
+})
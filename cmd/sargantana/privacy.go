@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+
+	"github.com/animalet/sargantana-go/pkg/controller/cms"
+	"github.com/animalet/sargantana-go/pkg/controller/privacy"
+	"github.com/animalet/sargantana-go/pkg/controller/profile"
+	"github.com/animalet/sargantana-go/pkg/query"
+	"github.com/animalet/sargantana-go/pkg/server"
+	"github.com/pkg/errors"
+)
+
+// cmsPageSize is the number of posts read at once by the exports and the erasures
+const cmsPageSize = 100
+
+// registerPrivacy registers the privacy controller type, keeping the deletion requests in the
+// database of the postgres configuration section. The personal data sources are registered with
+// their stores, see registerCMS and registerProfile.
+func registerPrivacy(postgres *sharedPostgres) {
+	server.RegisterController("privacy", func(c *privacy.Config, ctx server.ControllerContext) (server.IController, error) {
+		pool, err := postgres.get()
+		if err != nil {
+			return nil, err
+		}
+		if pool == nil {
+			return nil, errors.New("the privacy controller requires the postgres configuration section")
+		}
+		return privacy.NewController(privacy.NewPostgresStore(pool))(c, ctx)
+	})
+}
+
+// profileSource exports and erases the profiles
+type profileSource struct {
+	store profile.Store
+}
+
+func (s profileSource) Export(ctx context.Context, user string) (any, error) {
+	p, err := s.store.Get(ctx, user)
+	if errors.Is(err, profile.ErrNotFound) {
+		return nil, nil
+	}
+	return p, err
+}
+
+func (s profileSource) Delete(ctx context.Context, user string) error {
+	return s.store.Delete(ctx, user)
+}
+
+// cmsSource exports and erases the posts of the users
+type cmsSource struct {
+	store cms.Store
+}
+
+// page lists a page of the posts of a user, oldest first
+func (s cmsSource) page(ctx context.Context, user string, page int) ([]cms.Post, bool, error) {
+	return s.store.List(ctx, user, query.Options{
+		Page:    page,
+		PerPage: cmsPageSize,
+		Sort:    []query.Sort{{Column: "id"}},
+	})
+}
+
+func (s cmsSource) Export(ctx context.Context, user string) (any, error) {
+	var posts []cms.Post
+	for page, more := 1, true; more; page++ {
+		var batch []cms.Post
+		var err error
+		if batch, more, err = s.page(ctx, user, page); err != nil {
+			return nil, err
+		}
+		posts = append(posts, batch...)
+	}
+	if len(posts) == 0 {
+		return nil, nil
+	}
+	return posts, nil
+}
+
+// Delete deletes the posts of the user, listing the first page again after each deletion round
+func (s cmsSource) Delete(ctx context.Context, user string) error {
+	for {
+		posts, more, err := s.page(ctx, user, 1)
+		if err != nil {
+			return err
+		}
+		for _, p := range posts {
+			if err := s.store.Delete(ctx, p.Id); err != nil && !errors.Is(err, cms.ErrNotFound) {
+				return err
+			}
+		}
+		if !more {
+			return nil
+		}
+	}
+}
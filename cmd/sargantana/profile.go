@@ -0,0 +1,45 @@
+package main
+
+import (
+	"github.com/animalet/sargantana-go/pkg/controller/privacy"
+	"github.com/animalet/sargantana-go/pkg/controller/profile"
+	"github.com/animalet/sargantana-go/pkg/server"
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+)
+
+// registerProfile registers the profile controller and middleware types, keeping the profiles in the
+// database of the postgres configuration section. The store is created with the first controller or
+// middleware and shared by the others.
+func registerProfile(postgres *sharedPostgres) {
+	var store profile.Store
+	loadStore := func() (profile.Store, error) {
+		if store == nil {
+			pool, err := postgres.get()
+			if err != nil {
+				return nil, err
+			}
+			if pool == nil {
+				return nil, errors.New("profiles require the postgres configuration section")
+			}
+			store = profile.NewPostgresStore(pool)
+			privacy.RegisterSource("profile", profileSource{store: store})
+		}
+		return store, nil
+	}
+
+	server.RegisterController("profile", func(c *profile.Config, ctx server.ControllerContext) (server.IController, error) {
+		s, err := loadStore()
+		if err != nil {
+			return nil, err
+		}
+		return profile.NewController(s)(c, ctx)
+	})
+	server.RegisterMiddleware("profile", func(c *profile.MiddlewareConfig, ctx server.ControllerContext) (gin.HandlerFunc, error) {
+		s, err := loadStore()
+		if err != nil {
+			return nil, err
+		}
+		return profile.NewMiddleware(s)(c, ctx)
+	})
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	serviceCommand     = "service"
+	defaultServiceName = programName
+)
+
+// runServiceCommand handles the "service install", "service uninstall" and "service run" commands
+func runServiceCommand(args []string) int {
+	if len(args) == 0 {
+		fmt.Fprintf(os.Stderr, "Error: service command requires install, uninstall or run\n\n")
+		printUsage(os.Stderr)
+		return exitError
+	}
+
+	action := args[0]
+	opts := &options{}
+	fs := newFlagSet(programName+" "+serviceCommand+" "+action, opts)
+	name := fs.String("name", defaultServiceName, "Windows service name")
+	if err := fs.Parse(args[1:]); err != nil {
+		return exitError
+	}
+
+	var err error
+	switch action {
+	case "install":
+		if opts.configPath == "" {
+			fmt.Fprintf(os.Stderr, "Error: --config flag is required\n")
+			return exitError
+		}
+		var serviceArgs []string
+		if serviceArgs, err = runArgs(*name, opts); err == nil {
+			err = installService(*name, serviceArgs)
+		}
+	case "uninstall":
+		err = uninstallService(*name)
+	case "run":
+		err = runService(*name, opts)
+	default:
+		err = errors.Errorf("unknown service command %q", action)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return exitCode(err)
+	}
+	return exitSuccess
+}
+
+// runArgs returns the arguments the service control manager starts the service with. Paths are
+// made absolute, as services do not start in the directory of the installation.
+func runArgs(name string, opts *options) ([]string, error) {
+	configPath, err := filepath.Abs(opts.configPath)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to resolve the configuration file path")
+	}
+	args := []string{serviceCommand, "run", "--name", name, "--config", configPath}
+	if opts.pidFile != "" {
+		pidFile, err := filepath.Abs(opts.pidFile)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to resolve the PID file path")
+		}
+		args = append(args, "--pidfile", pidFile)
+	}
+	if opts.debug {
+		args = append(args, "--debug")
+	}
+	if opts.quiet {
+		args = append(args, "--quiet")
+	}
+	if opts.logFormat != "" {
+		args = append(args, "--log-format", opts.logFormat)
+	}
+	return args, nil
+}
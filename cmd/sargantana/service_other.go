@@ -0,0 +1,19 @@
+//go:build !windows
+
+package main
+
+import "github.com/pkg/errors"
+
+var errServiceUnsupported = errors.New("service commands are only supported on Windows, use the service manager of the system instead")
+
+func installService(string, []string) error {
+	return errServiceUnsupported
+}
+
+func uninstallService(string) error {
+	return errServiceUnsupported
+}
+
+func runService(string, *options) error {
+	return errServiceUnsupported
+}
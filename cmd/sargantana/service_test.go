@@ -0,0 +1,41 @@
+//go:build unit
+
+package main
+
+import (
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Service commands", func() {
+	It("should start the service with absolute paths and the server options", func() {
+		args, err := runArgs("gateway", &options{
+			configPath: "config.yaml",
+			pidFile:    "gateway.pid",
+			quiet:      true,
+			logFormat:  "json",
+		})
+		Expect(err).NotTo(HaveOccurred())
+
+		configPath, _ := filepath.Abs("config.yaml")
+		pidFile, _ := filepath.Abs("gateway.pid")
+		Expect(args).To(Equal([]string{
+			"service", "run", "--name", "gateway", "--config", configPath,
+			"--pidfile", pidFile, "--quiet", "--log-format", "json",
+		}))
+	})
+
+	It("should fail without action", func() {
+		Expect(runWithArgs([]string{"service"})).To(Equal(exitError))
+	})
+
+	It("should fail with an unknown action", func() {
+		Expect(runWithArgs([]string{"service", "restart"})).To(Equal(exitError))
+	})
+
+	It("should require the configuration to install the service", func() {
+		Expect(runWithArgs([]string{"service", "install"})).To(Equal(exitError))
+	})
+})
@@ -0,0 +1,140 @@
+//go:build windows
+
+package main
+
+import (
+	"os"
+
+	"github.com/animalet/sargantana-go/pkg/server"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	"golang.org/x/sys/windows/svc"
+	"golang.org/x/sys/windows/svc/mgr"
+)
+
+// installService registers the gateway as an automatically started Windows service
+func installService(name string, args []string) error {
+	exePath, err := os.Executable()
+	if err != nil {
+		return errors.Wrap(err, "failed to locate the executable")
+	}
+	manager, err := mgr.Connect()
+	if err != nil {
+		return errors.Wrap(err, "failed to connect to the service control manager")
+	}
+	defer func() {
+		_ = manager.Disconnect()
+	}()
+
+	if existing, err := manager.OpenService(name); err == nil {
+		_ = existing.Close()
+		return errors.Errorf("service %q already exists", name)
+	}
+	service, err := manager.CreateService(name, exePath, mgr.Config{
+		DisplayName: "Sargantana gateway (" + name + ")",
+		Description: "Sargantana web authentication gateway and reverse proxy",
+		StartType:   mgr.StartAutomatic,
+	}, args...)
+	if err != nil {
+		return errors.Wrapf(err, "failed to create service %q", name)
+	}
+	_ = service.Close()
+	log.Info().Str("service", name).Msg("Windows service installed")
+	return nil
+}
+
+// uninstallService removes the Windows service, which stops once it is no longer running
+func uninstallService(name string) error {
+	manager, err := mgr.Connect()
+	if err != nil {
+		return errors.Wrap(err, "failed to connect to the service control manager")
+	}
+	defer func() {
+		_ = manager.Disconnect()
+	}()
+
+	service, err := manager.OpenService(name)
+	if err != nil {
+		return errors.Wrapf(err, "service %q is not installed", name)
+	}
+	defer func() {
+		_ = service.Close()
+	}()
+	if err := service.Delete(); err != nil {
+		return errors.Wrapf(err, "failed to delete service %q", name)
+	}
+	log.Info().Str("service", name).Msg("Windows service uninstalled")
+	return nil
+}
+
+// runService runs the server under the service control manager
+func runService(name string, opts *options) error {
+	isService, err := svc.IsWindowsService()
+	if err != nil {
+		return errors.Wrap(err, "failed to detect the service environment")
+	}
+	if !isService {
+		return errors.New("service run must be started by the service control manager")
+	}
+	if err := setupLogging(opts); err != nil {
+		return err
+	}
+	return svc.Run(name, &windowsService{opts: opts})
+}
+
+// windowsService translates service control requests into server shutdown and reload requests
+type windowsService struct {
+	opts *options
+}
+
+func (s *windowsService) Execute(_ []string, requests <-chan svc.ChangeRequest, status chan<- svc.Status) (bool, uint32) {
+	const accepted = svc.AcceptStop | svc.AcceptShutdown | svc.AcceptParamChange
+	status <- svc.Status{State: svc.StartPending}
+
+	started := make(chan *server.Server, 1)
+	s.opts.started = func(srv *server.Server) {
+		started <- srv
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- runServer(s.opts)
+	}()
+
+	var (
+		srv      *server.Server
+		stopping bool
+	)
+	for {
+		select {
+		case srv = <-started:
+			if stopping {
+				srv.RequestShutdown()
+				continue
+			}
+			status <- svc.Status{State: svc.Running, Accepts: accepted}
+		case err := <-done:
+			if err != nil {
+				log.Error().Err(err).Msg("Service stopped with an error")
+				return true, uint32(exitCode(err))
+			}
+			return false, 0
+		case request := <-requests:
+			switch request.Cmd {
+			case svc.Interrogate:
+				status <- request.CurrentStatus
+			case svc.Stop, svc.Shutdown:
+				status <- svc.Status{State: svc.StopPending}
+				stopping = true
+				if srv != nil {
+					srv.RequestShutdown()
+				}
+			case svc.ParamChange:
+				if srv != nil {
+					srv.RequestReload()
+				}
+			default:
+				log.Warn().Msgf("Unexpected service control request %d", request.Cmd)
+			}
+		}
+	}
+}
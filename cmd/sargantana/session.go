@@ -11,13 +11,16 @@ import (
 	"github.com/rs/zerolog/log"
 )
 
+// sessionStoreCheck names the preflight check of the session store database
+const sessionStoreCheck = "session_store"
+
 // sessionStoreCloser is a function that cleans up session store resources
 type sessionStoreCloser func() error
 
 // configureSessionStore sets up the session store based on available database configuration.
 // Priority: Redis > Memcached > PostgreSQL > MongoDB > Cookie (default)
 // Returns a closer function that should be deferred to clean up resources
-func configureSessionStore(cfg *config.Config, srv *server.Server, sessionSecret []byte, debugMode bool) (sessionStoreCloser, error) {
+func configureSessionStore(cfg *config.Config, postgres *sharedPostgres, srv *server.Server, sessionSecret []byte, debugMode bool) (sessionStoreCloser, error) {
 	// Try Redis first
 	if closer, err := configureRedisStore(cfg, srv, sessionSecret, debugMode); closer != nil || err != nil {
 		return closer, err
@@ -29,7 +32,7 @@ func configureSessionStore(cfg *config.Config, srv *server.Server, sessionSecret
 	}
 
 	// Try PostgreSQL third
-	if closer, err := configurePostgresStore(cfg, srv, sessionSecret, debugMode); closer != nil || err != nil {
+	if closer, err := configurePostgresStore(postgres, srv, sessionSecret, debugMode); closer != nil || err != nil {
 		return closer, err
 	}
 
@@ -42,6 +45,13 @@ func configureSessionStore(cfg *config.Config, srv *server.Server, sessionSecret
 	return func() error { return nil }, nil
 }
 
+// addSessionStoreChecks checks the session store database before the listener opens and while the
+// server runs, for its readiness
+func addSessionStoreChecks(srv *server.Server, check database.HealthCheck) {
+	srv.AddPreflightCheck(sessionStoreCheck, check)
+	srv.AddHealthReporter(sessionStoreCheck, check)
+}
+
 func configureRedisStore(cfg *config.Config, srv *server.Server, sessionSecret []byte, debugMode bool) (sessionStoreCloser, error) {
 	redisPool, err := config.GetClient[database.RedisConfig](cfg, "redis")
 	if err != nil {
@@ -53,15 +63,16 @@ func configureRedisStore(cfg *config.Config, srv *server.Server, sessionSecret [
 
 	store, err := session.NewRedisSessionStore(debugMode, sessionSecret, *redisPool)
 	if err != nil {
-		_ = (*redisPool).Close()
+		_ = database.CloseRedis(*redisPool)
 		return nil, errors.Wrap(err, "failed to create Redis session store")
 	}
 
 	srv.SetSessionStore(store)
+	addSessionStoreChecks(srv, database.RedisHealthCheck(*redisPool))
 	log.Info().Msg("Using Redis session store")
 
 	return func() error {
-		if err := (*redisPool).Close(); err != nil {
+		if err := database.CloseRedis(*redisPool); err != nil {
 			log.Error().Err(err).Msg("Failed to close Redis pool")
 			return err
 		}
@@ -80,15 +91,16 @@ func configureMongoDBStore(cfg *config.Config, srv *server.Server, sessionSecret
 
 	store, err := session.NewMongoDBSessionStore(!debugMode, sessionSecret, *mongoClient, mongoCfg.Database, "sessions")
 	if err != nil {
-		_ = (*mongoClient).Disconnect(context.Background())
+		_ = database.DisconnectMongoDB(context.Background(), *mongoClient)
 		return nil, errors.Wrap(err, "failed to create MongoDB session store")
 	}
 
 	srv.SetSessionStore(store)
+	addSessionStoreChecks(srv, database.MongoDBHealthCheck(*mongoClient))
 	log.Info().Msg("Using MongoDB session store")
 
 	return func() error {
-		if err := (*mongoClient).Disconnect(context.Background()); err != nil {
+		if err := database.DisconnectMongoDB(context.Background(), *mongoClient); err != nil {
 			log.Error().Err(err).Msg("Failed to disconnect MongoDB client")
 			return err
 		}
@@ -96,28 +108,27 @@ func configureMongoDBStore(cfg *config.Config, srv *server.Server, sessionSecret
 	}, nil
 }
 
-func configurePostgresStore(cfg *config.Config, srv *server.Server, sessionSecret []byte, debugMode bool) (sessionStoreCloser, error) {
-	pgPool, err := config.GetClient[database.PostgresConfig](cfg, "postgres")
+// configurePostgresStore keeps the sessions in the pool of the postgres configuration section,
+// closed with the other users of the pool
+func configurePostgresStore(postgres *sharedPostgres, srv *server.Server, sessionSecret []byte, debugMode bool) (sessionStoreCloser, error) {
+	pool, err := postgres.get()
 	if err != nil {
-		return nil, errors.Wrap(err, "failed to load or create PostgreSQL client")
+		return nil, err
 	}
-	if pgPool == nil {
+	if pool == nil {
 		return nil, nil
 	}
 
-	store, err := session.NewPostgresSessionStore(!debugMode, sessionSecret, *pgPool, "sessions")
+	store, err := session.NewPostgresSessionStore(!debugMode, sessionSecret, pool, "sessions")
 	if err != nil {
-		(*pgPool).Close()
 		return nil, errors.Wrap(err, "failed to create PostgreSQL session store")
 	}
 
 	srv.SetSessionStore(store)
+	addSessionStoreChecks(srv, database.PostgresHealthCheck(pool))
 	log.Info().Msg("Using PostgreSQL session store")
 
-	return func() error {
-		(*pgPool).Close()
-		return nil
-	}, nil
+	return func() error { return nil }, nil
 }
 
 func configureMemcachedStore(cfg *config.Config, srv *server.Server, sessionSecret []byte, debugMode bool) (sessionStoreCloser, error) {
@@ -135,6 +146,7 @@ func configureMemcachedStore(cfg *config.Config, srv *server.Server, sessionSecr
 	}
 
 	srv.SetSessionStore(store)
+	addSessionStoreChecks(srv, database.MemcachedHealthCheck(*memcachedClient))
 	log.Info().Msg("Using Memcached session store")
 
 	// Memcached client doesn't need explicit cleanup
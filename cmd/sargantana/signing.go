@@ -0,0 +1,25 @@
+package main
+
+import (
+	"github.com/animalet/sargantana-go/pkg/config"
+	"github.com/animalet/sargantana-go/pkg/signing"
+	"github.com/pkg/errors"
+)
+
+// loadSigningKeys loads the rings of the signing_keys configuration section, if present, making
+// them available to the controllers through signing.Lookup. Returns a closer function stopping the
+// refresh of the keys.
+func loadSigningKeys(cfg *config.Config) (func() error, error) {
+	signingCfg, err := config.Get[signing.Config](cfg, "signing_keys")
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to load signing keys configuration")
+	}
+	if signingCfg == nil {
+		return func() error { return nil }, nil
+	}
+	manager, err := signing.NewManager(*signingCfg)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to load signing keys")
+	}
+	return manager.Close, nil
+}
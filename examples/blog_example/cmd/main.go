@@ -3,10 +3,10 @@ package main
 import (
 	"os"
 
-	"github.com/animalet/sargantana-go/examples/blog_example/blog"
 	"github.com/animalet/sargantana-go/pkg/config"
 	"github.com/animalet/sargantana-go/pkg/config/secrets"
 	"github.com/animalet/sargantana-go/pkg/controller"
+	"github.com/animalet/sargantana-go/pkg/controller/cms"
 	"github.com/animalet/sargantana-go/pkg/database"
 	"github.com/animalet/sargantana-go/pkg/server"
 	"github.com/animalet/sargantana-go/pkg/server/session"
@@ -30,7 +30,7 @@ func main() {
 	cfg := readConfig()
 	pool := newPgPool(cfg)
 	defer pool.Close()
-	server.RegisterController("blog", blog.NewBlogController(pool))
+	server.RegisterController("cms", cms.NewController(cms.NewPostgresStore(pool)))
 
 	sargantana, redisPool := newServer(cfg)
 	defer func() {
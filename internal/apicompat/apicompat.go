@@ -0,0 +1,208 @@
+// Package apicompat lists the exported API of the packages of the module, one declaration per
+// line, so that the compatibility tests detect the changes breaking embedders, such as removed
+// functions, changed signatures or renamed configuration fields.
+package apicompat
+
+import (
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Surface returns the exported declarations of the package in dir, sorted: functions and methods
+// with their signatures, types, struct fields and interface methods with their types and tags,
+// and constants and variables with their types and constant values. Test files are ignored.
+func Surface(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read package %s", dir)
+	}
+	fset := token.NewFileSet()
+	var lines []string
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(name, ".go") || strings.HasSuffix(name, "_test.go") {
+			continue
+		}
+		file, err := parser.ParseFile(fset, filepath.Join(dir, name), nil, parser.SkipObjectResolution)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to parse %s", name)
+		}
+		for _, decl := range file.Decls {
+			lines = append(lines, declaration(decl)...)
+		}
+	}
+	slices.Sort(lines)
+	return slices.Compact(lines), nil
+}
+
+// Packages returns the directories of the packages below root, relative to it and sorted,
+// skipping testdata directories.
+func Packages(root string) ([]string, error) {
+	var packages []string
+	err := filepath.WalkDir(root, func(path string, entry os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() && entry.Name() == "testdata" {
+			return filepath.SkipDir
+		}
+		if entry.IsDir() || !strings.HasSuffix(path, ".go") || strings.HasSuffix(path, "_test.go") {
+			return nil
+		}
+		rel, err := filepath.Rel(root, filepath.Dir(path))
+		if err != nil {
+			return err
+		}
+		if !slices.Contains(packages, rel) {
+			packages = append(packages, rel)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to list packages of %s", root)
+	}
+	slices.Sort(packages)
+	return packages, nil
+}
+
+func declaration(decl ast.Decl) []string {
+	switch d := decl.(type) {
+	case *ast.FuncDecl:
+		if !d.Name.IsExported() {
+			return nil
+		}
+		if d.Recv == nil {
+			return []string{"func " + d.Name.Name + typeParams(d.Type.TypeParams) + signature(d.Type)}
+		}
+		receiver := d.Recv.List[0].Type
+		if !ast.IsExported(baseTypeName(receiver)) {
+			return nil
+		}
+		return []string{fmt.Sprintf("method (%s) %s%s", types.ExprString(receiver), d.Name.Name, signature(d.Type))}
+	case *ast.GenDecl:
+		var lines []string
+		var previousType ast.Expr
+		for _, spec := range d.Specs {
+			switch s := spec.(type) {
+			case *ast.TypeSpec:
+				lines = append(lines, typeDeclaration(s)...)
+			case *ast.ValueSpec:
+				// Constants without type nor value repeat the previous ones, as with iota
+				if s.Type != nil || len(s.Values) > 0 {
+					previousType = s.Type
+				}
+				for i, name := range s.Names {
+					if !name.IsExported() {
+						continue
+					}
+					line := strings.ToLower(d.Tok.String()) + " " + name.Name
+					if previousType != nil {
+						line += " " + types.ExprString(previousType)
+					}
+					if d.Tok == token.CONST && i < len(s.Values) {
+						line += " = " + types.ExprString(s.Values[i])
+					}
+					lines = append(lines, line)
+				}
+			}
+		}
+		return lines
+	}
+	return nil
+}
+
+func typeDeclaration(s *ast.TypeSpec) []string {
+	if !s.Name.IsExported() {
+		return nil
+	}
+	name := s.Name.Name
+	declared := "type " + name + typeParams(s.TypeParams)
+	if s.Assign.IsValid() {
+		return []string{declared + " = " + types.ExprString(s.Type)}
+	}
+	switch t := s.Type.(type) {
+	case *ast.StructType:
+		lines := []string{declared + " struct"}
+		for _, field := range t.Fields.List {
+			tag := ""
+			if field.Tag != nil {
+				tag = " " + field.Tag.Value
+			}
+			if len(field.Names) == 0 {
+				if ast.IsExported(baseTypeName(field.Type)) {
+					lines = append(lines, fmt.Sprintf("embedded %s.%s%s", name, types.ExprString(field.Type), tag))
+				}
+				continue
+			}
+			for _, fieldName := range field.Names {
+				if fieldName.IsExported() {
+					lines = append(lines, fmt.Sprintf("field %s.%s %s%s", name, fieldName.Name, types.ExprString(field.Type), tag))
+				}
+			}
+		}
+		return lines
+	case *ast.InterfaceType:
+		lines := []string{declared + " interface"}
+		for _, method := range t.Methods.List {
+			if len(method.Names) == 0 {
+				lines = append(lines, fmt.Sprintf("embedded %s.%s", name, types.ExprString(method.Type)))
+				continue
+			}
+			for _, methodName := range method.Names {
+				if funcType, ok := method.Type.(*ast.FuncType); ok && methodName.IsExported() {
+					lines = append(lines, fmt.Sprintf("method %s.%s%s", name, methodName.Name, signature(funcType)))
+				}
+			}
+		}
+		return lines
+	default:
+		return []string{declared + " " + types.ExprString(s.Type)}
+	}
+}
+
+// signature returns the parameters and results of the function type, without its name
+func signature(t *ast.FuncType) string {
+	return strings.TrimPrefix(types.ExprString(t), "func")
+}
+
+func typeParams(params *ast.FieldList) string {
+	if params == nil || len(params.List) == 0 {
+		return ""
+	}
+	var list []string
+	for _, param := range params.List {
+		names := make([]string, len(param.Names))
+		for i, name := range param.Names {
+			names[i] = name.Name
+		}
+		list = append(list, strings.Join(names, ", ")+" "+types.ExprString(param.Type))
+	}
+	return "[" + strings.Join(list, ", ") + "]"
+}
+
+// baseTypeName returns the name of the type of a receiver or embedded field, e.g. Server for
+// *Server or Store[T], and the selected name for qualified types
+func baseTypeName(expr ast.Expr) string {
+	switch t := expr.(type) {
+	case *ast.StarExpr:
+		return baseTypeName(t.X)
+	case *ast.IndexExpr:
+		return baseTypeName(t.X)
+	case *ast.IndexListExpr:
+		return baseTypeName(t.X)
+	case *ast.SelectorExpr:
+		return t.Sel.Name
+	case *ast.Ident:
+		return t.Name
+	}
+	return ""
+}
@@ -0,0 +1,96 @@
+//go:build unit
+
+package apicompat
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/animalet/sargantana-go/pkg/golden"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+// publicRoot is the root of the public packages, whose exported API embedders depend on
+const publicRoot = "../../pkg"
+
+var _ = Describe("API compatibility", func() {
+	It("should list the exported declarations of a package", func() {
+		dir := GinkgoT().TempDir()
+		Expect(os.WriteFile(filepath.Join(dir, "example.go"), []byte(`package example
+
+type Store[T any] struct {
+	Name    string `+"`yaml:\"name\"`"+`
+	Backend
+	secret  string
+}
+
+type Backend interface {
+	Get(key string) (string, error)
+	close()
+}
+
+type Level int
+
+const (
+	Low Level = iota
+	High
+	internal
+)
+
+const DefaultPath = "/readyz"
+
+var ErrMissing = New("missing")
+
+func New(message string) error { return nil }
+
+func (s *Store[T]) Load(key string) (T, bool) { var zero T; return zero, false }
+
+func (s *Store[T]) reset() {}
+
+type options struct{}
+
+func (o options) Apply() {}
+
+func Map[K comparable, V any](values map[K]V) []V { return nil }
+`), 0o600)).To(Succeed())
+		Expect(os.WriteFile(filepath.Join(dir, "example_test.go"), []byte("package example\n\nfunc Helper() {}\n"), 0o600)).To(Succeed())
+
+		surface, err := Surface(dir)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(surface).To(Equal([]string{
+			`const DefaultPath = "/readyz"`,
+			"const High Level",
+			"const Low Level = iota",
+			"embedded Store.Backend",
+			`field Store.Name string ` + "`yaml:\"name\"`",
+			"func Map[K comparable, V any](values map[K]V) []V",
+			"func New(message string) error",
+			"method (*Store[T]) Load(key string) (T, bool)",
+			"method Backend.Get(key string) (string, error)",
+			"type Backend interface",
+			"type Level int",
+			"type Store[T any] struct",
+			"var ErrMissing",
+		}))
+	})
+
+	It("should keep the public packages", func() {
+		packages, err := Packages(publicRoot)
+		Expect(err).NotTo(HaveOccurred())
+		golden.Assert(GinkgoT(), "packages", []byte(strings.Join(packages, "\n")+"\n"))
+	})
+
+	packages, err := Packages(publicRoot)
+	if err != nil {
+		panic(err)
+	}
+	for _, pkg := range packages {
+		It("should keep the exported API of pkg/"+pkg, func() {
+			surface, err := Surface(filepath.Join(publicRoot, pkg))
+			Expect(err).NotTo(HaveOccurred())
+			golden.Assert(GinkgoT(), filepath.Join("pkg", pkg), []byte(strings.Join(surface, "\n")+"\n"))
+		})
+	}
+})
@@ -0,0 +1,13 @@
+package apicompat
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+func TestAPICompat(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "API Compatibility Suite")
+}
@@ -0,0 +1,161 @@
+// Package cron parses the standard five field cron expressions scheduling the background jobs of
+// the server, evaluated in UTC.
+package cron
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Schedule is a parsed cron expression. Fields are bit sets of the allowed values.
+type Schedule struct {
+	minute, hour, dom, month, dow uint64
+	// Whether the day of month and the day of week fields are restricted. When both are, a day
+	// matches if either does, as in standard cron.
+	domRestricted, dowRestricted bool
+}
+
+type field struct {
+	min, max int
+	names    []string // Names of the values from min, if any
+}
+
+var (
+	minuteField = field{min: 0, max: 59}
+	hourField   = field{min: 0, max: 23}
+	domField    = field{min: 1, max: 31}
+	monthField  = field{min: 1, max: 12, names: []string{"jan", "feb", "mar", "apr", "may", "jun", "jul", "aug", "sep", "oct", "nov", "dec"}}
+	dowField    = field{min: 0, max: 7, names: []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}}
+)
+
+var shortcuts = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+// Parse parses a cron expression with the minute, hour, day of month, month and day of week fields,
+// e.g. "30 2 * * mon-fri", or one of the @yearly, @monthly, @weekly, @daily and @hourly shortcuts.
+// Fields accept *, values, names of months and days, ranges, lists and steps such as */15.
+func Parse(spec string) (*Schedule, error) {
+	if expanded, ok := shortcuts[strings.ToLower(strings.TrimSpace(spec))]; ok {
+		spec = expanded
+	}
+	fields := strings.Fields(spec)
+	if len(fields) != 5 {
+		return nil, errors.Errorf("cron expression %q must have 5 fields", spec)
+	}
+	var s Schedule
+	var err error
+	for i, target := range []struct {
+		bits  *uint64
+		field field
+		name  string
+	}{
+		{&s.minute, minuteField, "minute"},
+		{&s.hour, hourField, "hour"},
+		{&s.dom, domField, "day of month"},
+		{&s.month, monthField, "month"},
+		{&s.dow, dowField, "day of week"},
+	} {
+		if *target.bits, err = target.field.parse(fields[i]); err != nil {
+			return nil, errors.Wrapf(err, "invalid %s field", target.name)
+		}
+	}
+	// 7 is also Sunday
+	if s.dow&(1<<7) != 0 {
+		s.dow |= 1
+	}
+	s.domRestricted = !strings.HasPrefix(fields[2], "*")
+	s.dowRestricted = !strings.HasPrefix(fields[4], "*")
+	return &s, nil
+}
+
+func (f field) parse(spec string) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(spec, ",") {
+		rangeSpec, step := part, 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			var err error
+			if step, err = strconv.Atoi(part[i+1:]); err != nil || step <= 0 {
+				return 0, errors.Errorf("invalid step in %q", part)
+			}
+			rangeSpec = part[:i]
+		}
+		low, high := f.min, f.max
+		if rangeSpec != "*" {
+			bounds := strings.SplitN(rangeSpec, "-", 2)
+			var err error
+			if low, err = f.value(bounds[0]); err != nil {
+				return 0, err
+			}
+			high = low
+			if len(bounds) == 2 {
+				if high, err = f.value(bounds[1]); err != nil {
+					return 0, err
+				}
+			} else if step > 1 {
+				// A value with a step, e.g. 5/15, runs to the end of the range
+				high = f.max
+			}
+			if high < low {
+				return 0, errors.Errorf("invalid range %q", rangeSpec)
+			}
+		}
+		for v := low; v <= high; v += step {
+			bits |= 1 << v
+		}
+	}
+	return bits, nil
+}
+
+func (f field) value(spec string) (int, error) {
+	for i, name := range f.names {
+		if strings.EqualFold(spec, name) {
+			return f.min + i, nil
+		}
+	}
+	v, err := strconv.Atoi(spec)
+	if err != nil || v < f.min || v > f.max {
+		return 0, errors.Errorf("value %q out of range %d-%d", spec, f.min, f.max)
+	}
+	return v, nil
+}
+
+// Next returns the first time matching the schedule after t, in UTC, or the zero time if none
+// does within five years, e.g. for February 30th.
+func (s *Schedule) Next(t time.Time) time.Time {
+	t = t.UTC().Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(5, 0, 0)
+	for t.Before(limit) {
+		switch {
+		case s.month&(1<<int(t.Month())) == 0:
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, time.UTC)
+		case !s.dayMatches(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, time.UTC)
+		case s.hour&(1<<t.Hour()) == 0:
+			t = t.Truncate(time.Hour).Add(time.Hour)
+		case s.minute&(1<<t.Minute()) == 0:
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+func (s *Schedule) dayMatches(t time.Time) bool {
+	dom := s.dom&(1<<t.Day()) != 0
+	dow := s.dow&(1<<int(t.Weekday())) != 0
+	if s.domRestricted && s.dowRestricted {
+		return dom || dow
+	}
+	return dom && dow
+}
@@ -0,0 +1,57 @@
+//go:build unit
+
+package cron_test
+
+import (
+	"time"
+
+	"github.com/animalet/sargantana-go/internal/cron"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Cron", func() {
+	// A Friday
+	start := time.Date(2024, 5, 17, 10, 7, 30, 0, time.UTC)
+
+	next := func(spec string, from time.Time) time.Time {
+		schedule, err := cron.Parse(spec)
+		Expect(err).NotTo(HaveOccurred())
+		return schedule.Next(from)
+	}
+
+	DescribeTable("Next",
+		func(spec string, expected time.Time) {
+			Expect(next(spec, start)).To(Equal(expected))
+		},
+		Entry("every minute", "* * * * *", time.Date(2024, 5, 17, 10, 8, 0, 0, time.UTC)),
+		Entry("steps", "*/15 * * * *", time.Date(2024, 5, 17, 10, 15, 0, 0, time.UTC)),
+		Entry("value with step", "5/20 * * * *", time.Date(2024, 5, 17, 10, 25, 0, 0, time.UTC)),
+		Entry("lists and ranges", "0 8-9,22 * * *", time.Date(2024, 5, 17, 22, 0, 0, 0, time.UTC)),
+		Entry("daily", "@daily", time.Date(2024, 5, 18, 0, 0, 0, 0, time.UTC)),
+		Entry("monthly", "@monthly", time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)),
+		Entry("yearly", "@yearly", time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)),
+		Entry("week days by name", "30 2 * * mon-thu", time.Date(2024, 5, 20, 2, 30, 0, 0, time.UTC)),
+		Entry("sunday as 7", "0 0 * * 7", time.Date(2024, 5, 19, 0, 0, 0, 0, time.UTC)),
+		Entry("months by name", "0 0 1 jan,jul *", time.Date(2024, 7, 1, 0, 0, 0, 0, time.UTC)),
+		Entry("day of month or week", "0 0 1 * sat", time.Date(2024, 5, 18, 0, 0, 0, 0, time.UTC)),
+		Entry("leap day", "0 0 29 2 *", time.Date(2028, 2, 29, 0, 0, 0, 0, time.UTC)),
+	)
+
+	It("should evaluate schedules in UTC", func() {
+		from := time.Date(2024, 5, 17, 23, 30, 0, 0, time.FixedZone("CEST", 2*3600))
+		Expect(next("0 * * * *", from)).To(Equal(time.Date(2024, 5, 17, 22, 0, 0, 0, time.UTC)))
+	})
+
+	It("should return the zero time for impossible dates", func() {
+		Expect(next("0 0 30 2 *", start)).To(BeZero())
+	})
+
+	It("should reject invalid expressions", func() {
+		for _, spec := range []string{"", "* * * *", "60 * * * *", "* 24 * * *", "* * 0 * *", "* * * 13 *",
+			"* * * * 8", "*/0 * * * *", "5-1 * * * *", "* * * foo *", "@often"} {
+			_, err := cron.Parse(spec)
+			Expect(err).To(HaveOccurred(), spec)
+		}
+	})
+})
@@ -3,35 +3,105 @@
 package expansion
 
 import (
+	"fmt"
 	"os"
 	"reflect"
+	"slices"
 	"strings"
 
 	"github.com/animalet/sargantana-go/pkg/config/secrets"
-	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
 )
 
-// expand checks for specific prefixes in the string and expands them accordingly.
-// Supported prefixes are:
+// UnresolvedPlaceholder describes a placeholder that could not be resolved.
+type UnresolvedPlaceholder struct {
+	// Path locates the value in the configuration, using YAML field names, e.g. "tls.vault_pki.role".
+	Path string
+	// Placeholder is the placeholder without "${" and "}", e.g. "vault:SESSION_SECRET", or the
+	// encrypted value that could not be decrypted, e.g. "ENC[AES256_GCM,...]".
+	Placeholder string
+	// Err is the resolution error, naming the provider and the key.
+	Err error
+}
+
+// Error reports all the placeholders of a configuration that could not be resolved.
+type Error struct {
+	Unresolved []UnresolvedPlaceholder
+}
+
+func (e *Error) Error() string {
+	var b strings.Builder
+	_, _ = fmt.Fprintf(&b, "%d unresolvable placeholder(s):", len(e.Unresolved))
+	for _, u := range e.Unresolved {
+		if strings.HasPrefix(u.Placeholder, secrets.EncryptedPrefix) {
+			_, _ = fmt.Fprintf(&b, "\n - %s: error decrypting value: %v", displayPath(u.Path), u.Err)
+			continue
+		}
+		_, _ = fmt.Fprintf(&b, "\n - %s: error resolving property ${%s}: %v", displayPath(u.Path), u.Placeholder, u.Err)
+	}
+	return b.String()
+}
+
+// WithPrefix returns a copy of the error with the paths prefixed, e.g. with the configuration section name.
+func (e *Error) WithPrefix(prefix string) *Error {
+	prefixed := &Error{Unresolved: make([]UnresolvedPlaceholder, len(e.Unresolved))}
+	for i, u := range e.Unresolved {
+		u.Path = joinPath(prefix, u.Path)
+		prefixed.Unresolved[i] = u
+	}
+	return prefixed
+}
+
+// SourceEncrypted is the source of the values decrypted with the master key, see secrets.Decrypt.
+const SourceEncrypted = "encrypted"
+
+// Sources maps the paths of the expanded values to the prefixes of the secret providers that
+// resolved their placeholders, e.g. "session_secret" to ["vault"], or SourceEncrypted for the
+// decrypted values. Values without placeholders nor encryption are not listed.
+type Sources map[string][]string
+
+// WithPrefix returns a copy of the sources with the paths prefixed, e.g. with the configuration section name.
+func (s Sources) WithPrefix(prefix string) Sources {
+	prefixed := make(Sources, len(s))
+	for path, sources := range s {
+		prefixed[joinPath(prefix, path)] = sources
+	}
+	return prefixed
+}
+
+// expansion holds the state of a traversal
+type expansion struct {
+	unresolved []UnresolvedPlaceholder
+	sources    Sources
+}
+
+// expand resolves a placeholder through the registered secret providers.
+// Supported prefixes are those registered in the secrets package, such as:
 //   - "env:": Expands to the value of the specified environment variable
-//   - "vault:": Placeholder for retrieving secrets from Vault
+//   - "vault:": Retrieves secrets from Vault
 //   - "file:": Reads the content of the specified file in secrets dir (if configured) and returns it as a string
 //
-// If no known prefix is found, the original string is returned unchanged.
-func expand(s string) (string, error) {
-	value, err := secrets.Resolve(s)
-	if err != nil {
-		return "", errors.Wrap(err, "error resolving property")
-	}
-	return value, nil
+// Placeholders without prefix are resolved from the environment or the fallback chain.
+func expand(s string) (string, string, error) {
+	return secrets.ResolveSource(s)
 }
 
 // ExpandVariables recursively traverses the fields of a struct and expands environment variables in string fields.
 // It handles nested structs, pointers to structs, slices, and maps.
 // The toExpand parameter must be a pointer to the value to expand.
+//
+// Every placeholder is resolved before reporting errors: in strict mode (the default), the
+// unresolvable placeholders are returned as a single *Error. In lenient mode (see
+// secrets.SetLenient), they are logged and replaced with empty strings.
 func ExpandVariables(toExpand any) error {
+	_, err := Expand(toExpand)
+	return err
+}
+
+// Expand works like ExpandVariables, also returning where the expanded values came from.
+func Expand(toExpand any) (Sources, error) {
 	if toExpand == nil {
-		return nil
+		return nil, nil
 	}
 
 	v := reflect.ValueOf(toExpand)
@@ -39,56 +109,62 @@ func ExpandVariables(toExpand any) error {
 	// Handle pointer: dereference to get the actual value
 	if v.Kind() == reflect.Ptr {
 		if v.IsNil() {
-			return nil
+			return nil, nil
 		}
-		return expandValue(v.Elem())
+		v = v.Elem()
 	}
 
-	return expandValue(v)
+	e := &expansion{sources: make(Sources)}
+	e.expandValue(v, "")
+	if len(e.unresolved) == 0 {
+		return e.sources, nil
+	}
+	if secrets.Lenient() {
+		for _, u := range e.unresolved {
+			log.Warn().
+				Err(u.Err).
+				Str("path", displayPath(u.Path)).
+				Str("placeholder", u.Placeholder).
+				Msg("Unresolvable placeholder replaced with an empty string")
+		}
+		return e.sources, nil
+	}
+	return nil, &Error{Unresolved: e.unresolved}
 }
 
-// expandValue is the internal recursive function that operates on reflect.Value
-func expandValue(val reflect.Value) error {
+// expandValue is the internal recursive function that operates on reflect.Value, collecting the
+// placeholders that cannot be resolved and the sources of the resolved ones
+func (e *expansion) expandValue(val reflect.Value, path string) {
 	switch val.Kind() {
 	case reflect.String:
 		if val.CanSet() {
-			var expandErr error
 			expanded := os.Expand(strings.TrimSpace(val.String()), func(s string) string {
-				if expandErr != nil {
-					return ""
-				}
-				res, err := expand(s)
+				res, source, err := expand(s)
 				if err != nil {
-					expandErr = err
+					e.unresolved = append(e.unresolved, UnresolvedPlaceholder{Path: path, Placeholder: s, Err: err})
 					return ""
 				}
+				if !slices.Contains(e.sources[path], source) {
+					e.sources[path] = append(e.sources[path], source)
+				}
 				return res
 			})
-			if expandErr != nil {
-				return expandErr
-			}
-			val.SetString(expanded)
+			val.SetString(e.decrypt(expanded, path))
 		}
 
 	case reflect.Struct:
 		for i := 0; i < val.NumField(); i++ {
-			if err := expandValue(val.Field(i)); err != nil {
-				return err
-			}
+			e.expandValue(val.Field(i), fieldPath(path, val.Type().Field(i)))
 		}
 
 	case reflect.Ptr:
 		if !val.IsNil() {
-			if err := expandValue(val.Elem()); err != nil {
-				return err
-			}
+			e.expandValue(val.Elem(), path)
 		}
 
 	case reflect.Slice:
 		for j := 0; j < val.Len(); j++ {
-			if err := expandValue(val.Index(j)); err != nil {
-				return err
-			}
+			e.expandValue(val.Index(j), fmt.Sprintf("%s[%d]", path, j))
 		}
 
 	case reflect.Map:
@@ -97,14 +173,62 @@ func expandValue(val reflect.Value) error {
 			// Create a new addressable value of the same type
 			newVal := reflect.New(mapVal.Type()).Elem()
 			newVal.Set(mapVal)
-			if err := expandValue(newVal); err != nil {
-				return err
-			}
+			e.expandValue(newVal, joinPath(path, fmt.Sprint(key.Interface())))
 			val.SetMapIndex(key, newVal)
 		}
 	default:
 		// No action needed for other kinds
 	}
+}
 
-	return nil
+// decrypt replaces the encrypted values found in s, after the expansion of its placeholders, with
+// their plaintext
+func (e *expansion) decrypt(s, path string) string {
+	if !strings.Contains(s, secrets.EncryptedPrefix) {
+		return s
+	}
+	return secrets.EncryptedValue.ReplaceAllStringFunc(s, func(encrypted string) string {
+		plaintext, err := secrets.Decrypt(encrypted)
+		if err != nil {
+			e.unresolved = append(e.unresolved, UnresolvedPlaceholder{Path: path, Placeholder: encrypted, Err: err})
+			return ""
+		}
+		if !slices.Contains(e.sources[path], SourceEncrypted) {
+			e.sources[path] = append(e.sources[path], SourceEncrypted)
+		}
+		return plaintext
+	})
+}
+
+// fieldPath returns the path of a struct field, named after its YAML name. Embedded fields
+// without name do not add a path segment.
+func fieldPath(path string, field reflect.StructField) string {
+	name, _, _ := strings.Cut(field.Tag.Get("yaml"), ",")
+	if name == "" || name == "-" {
+		if field.Anonymous {
+			return path
+		}
+		name = field.Name
+	}
+	return joinPath(path, name)
+}
+
+func joinPath(prefix, path string) string {
+	switch {
+	case prefix == "":
+		return path
+	case path == "":
+		return prefix
+	case strings.HasPrefix(path, "["):
+		return prefix + path
+	default:
+		return prefix + "." + path
+	}
+}
+
+func displayPath(path string) string {
+	if path == "" {
+		return "(root)"
+	}
+	return path
 }
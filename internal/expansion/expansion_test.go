@@ -3,6 +3,9 @@
 package expansion_test
 
 import (
+	"bytes"
+	"errors"
+
 	"github.com/animalet/sargantana-go/internal/expansion"
 	"github.com/animalet/sargantana-go/pkg/config/secrets"
 	. "github.com/onsi/ginkgo/v2"
@@ -103,5 +106,91 @@ var _ = Describe("Expansion", func() {
 			Expect(err).To(HaveOccurred())
 			Expect(err.Error()).To(ContainSubstring("error resolving property"))
 		})
+
+		It("should report every unresolvable placeholder with its path", func() {
+			type TLS struct {
+				Cert string `yaml:"cert_file"`
+			}
+			type TestStruct struct {
+				Secret  string            `yaml:"session_secret"`
+				TLS     *TLS              `yaml:"tls,omitempty"`
+				Headers map[string]string `yaml:"headers"`
+				Hosts   []string
+			}
+			s := TestStruct{
+				Secret:  "${unregistered:secret}",
+				TLS:     &TLS{Cert: "${unregistered:cert}"},
+				Headers: map[string]string{"X-Key": "${mock:test-secret}"},
+				Hosts:   []string{"ok", "${unregistered:host}"},
+			}
+			err := expansion.ExpandVariables(&s)
+
+			var expansionErr *expansion.Error
+			Expect(errors.As(err, &expansionErr)).To(BeTrue())
+			Expect(expansionErr.Unresolved).To(HaveLen(3))
+			Expect(expansionErr.Unresolved[0].Path).To(Equal("session_secret"))
+			Expect(expansionErr.Unresolved[0].Placeholder).To(Equal("unregistered:secret"))
+			Expect(expansionErr.Unresolved[1].Path).To(Equal("tls.cert_file"))
+			Expect(expansionErr.Unresolved[2].Path).To(Equal("Hosts[1]"))
+			Expect(expansionErr.WithPrefix("server").Unresolved[1].Path).To(Equal("server.tls.cert_file"))
+			Expect(err.Error()).To(ContainSubstring("3 unresolvable placeholder(s)"))
+			Expect(s.Headers["X-Key"]).To(Equal("resolved-secret"))
+		})
+
+		It("should replace unresolvable placeholders with empty strings in lenient mode", func() {
+			secrets.SetLenient(true)
+			defer secrets.SetLenient(false)
+
+			type TestStruct struct {
+				Value string
+			}
+			s := TestStruct{Value: "prefix-${unregistered:some-key}"}
+			Expect(expansion.ExpandVariables(&s)).To(Succeed())
+			Expect(s.Value).To(Equal("prefix-"))
+		})
+	})
+
+	Context("encrypted values", func() {
+		BeforeEach(func() {
+			Expect(secrets.SetMasterKey(bytes.Repeat([]byte{1}, 32))).To(Succeed())
+			DeferCleanup(func() { _ = secrets.SetMasterKey(nil) })
+		})
+
+		It("should decrypt the encrypted values and their placeholders", func() {
+			encrypted, err := secrets.Encrypt("s3cr3t")
+			Expect(err).NotTo(HaveOccurred())
+			secrets.Register("passthrough", &secrets.LiteralLoader{})
+
+			type TestStruct struct {
+				Secret   string `yaml:"secret"`
+				DSN      string `yaml:"dsn"`
+				Resolved string `yaml:"resolved"`
+			}
+			s := TestStruct{Secret: encrypted, DSN: "postgres://app:" + encrypted + "@db/app", Resolved: "${passthrough:" + encrypted + "}"}
+			sources, err := expansion.Expand(&s)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(s).To(Equal(TestStruct{Secret: "s3cr3t", DSN: "postgres://app:s3cr3t@db/app", Resolved: "s3cr3t"}))
+			Expect(sources).To(HaveKeyWithValue("secret", []string{expansion.SourceEncrypted}))
+			Expect(sources).To(HaveKeyWithValue("resolved", []string{"passthrough", expansion.SourceEncrypted}))
+		})
+
+		It("should report the values that cannot be decrypted with their path", func() {
+			encrypted, err := secrets.Encrypt("s3cr3t")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(secrets.SetMasterKey(nil)).To(Succeed())
+
+			type TestStruct struct {
+				Secret string `yaml:"secret"`
+			}
+			s := TestStruct{Secret: encrypted}
+			err = expansion.ExpandVariables(&s)
+
+			var expansionErr *expansion.Error
+			Expect(errors.As(err, &expansionErr)).To(BeTrue())
+			Expect(expansionErr.Unresolved).To(HaveLen(1))
+			Expect(expansionErr.Unresolved[0].Path).To(Equal("secret"))
+			Expect(err.Error()).To(ContainSubstring("secret: error decrypting value: no master key is set"))
+			Expect(err.Error()).NotTo(ContainSubstring(encrypted))
+		})
 	})
 })
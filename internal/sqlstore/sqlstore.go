@@ -0,0 +1,96 @@
+// Package sqlstore holds the SQL machinery shared by the stores of the controllers: the
+// differences between the supported databases and the versioned migrations of their tables.
+package sqlstore
+
+import (
+	"context"
+	"database/sql"
+	"strconv"
+	"strings"
+
+	"github.com/animalet/sargantana-go/pkg/query"
+	"github.com/pkg/errors"
+)
+
+// Dialect holds the SQL differences between the supported databases
+type Dialect struct {
+	Name string
+	// Numbered tells whether placeholders are numbered ($1, $2...) instead of ?
+	Numbered bool
+	// Clauses builds the clauses of the list queries
+	Clauses query.Dialect
+	// Lock is run at the start of the migration transaction to serialize concurrent migrations
+	Lock string
+	// Migrations are the schema versions, applied in order. A version may hold several
+	// statements separated by semicolons.
+	Migrations []string
+}
+
+// Store is the base of the stores on a database/sql database, embedded by the stores of the
+// controllers.
+type Store struct {
+	DB      *sql.DB
+	Dialect Dialect
+	// MigrationsTable records the applied schema versions
+	MigrationsTable string
+}
+
+// Rebind adapts the ? placeholders of a query to the dialect
+func (s *Store) Rebind(query string) string {
+	if !s.Dialect.Numbered {
+		return query
+	}
+	var b strings.Builder
+	n := 0
+	for _, r := range query {
+		if r == '?' {
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// Migrate applies the schema versions not recorded in the migrations table, in one transaction
+func (s *Store) Migrate(ctx context.Context) error {
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return errors.Wrap(err, "failed to start the migration transaction")
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	if s.Dialect.Lock != "" {
+		if _, err := tx.ExecContext(ctx, s.Dialect.Lock); err != nil {
+			return errors.Wrap(err, "failed to lock the migrations")
+		}
+	}
+	if _, err := tx.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS `+s.MigrationsTable+` (
+		version INTEGER PRIMARY KEY,
+		applied_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	)`); err != nil {
+		return errors.Wrap(err, "failed to create the migrations table")
+	}
+	var version int
+	if err := tx.QueryRowContext(ctx, "SELECT COALESCE(MAX(version), 0) FROM "+s.MigrationsTable).Scan(&version); err != nil {
+		return errors.Wrap(err, "failed to read the schema version")
+	}
+	if version > len(s.Dialect.Migrations) {
+		return errors.Errorf("schema version %d is newer than the supported version %d", version, len(s.Dialect.Migrations))
+	}
+	for i := version; i < len(s.Dialect.Migrations); i++ {
+		for _, statement := range strings.Split(s.Dialect.Migrations[i], ";") {
+			if strings.TrimSpace(statement) == "" {
+				continue
+			}
+			if _, err := tx.ExecContext(ctx, statement); err != nil {
+				return errors.Wrapf(err, "failed to apply migration %d", i+1)
+			}
+		}
+		if _, err := tx.ExecContext(ctx, s.Rebind("INSERT INTO "+s.MigrationsTable+" (version) VALUES (?)"), i+1); err != nil {
+			return errors.Wrapf(err, "failed to record migration %d", i+1)
+		}
+	}
+	return errors.Wrap(tx.Commit(), "failed to commit the migrations")
+}
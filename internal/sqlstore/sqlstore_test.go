@@ -0,0 +1,18 @@
+//go:build unit
+
+package sqlstore_test
+
+import (
+	"github.com/animalet/sargantana-go/internal/sqlstore"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("SQL store", func() {
+	It("should number the placeholders of the numbered dialects", func() {
+		numbered := sqlstore.Store{Dialect: sqlstore.Dialect{Numbered: true}}
+		Expect(numbered.Rebind("SELECT a FROM t WHERE b = ? AND c = ?")).To(Equal("SELECT a FROM t WHERE b = $1 AND c = $2"))
+		plain := sqlstore.Store{}
+		Expect(plain.Rebind("SELECT a FROM t WHERE b = ?")).To(Equal("SELECT a FROM t WHERE b = ?"))
+	})
+})
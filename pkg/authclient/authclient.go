@@ -0,0 +1,265 @@
+// Package authclient lets downstream Go services trust the gateway: it validates the identity
+// tokens signed by the gateway against the keys published by its JWKS controller, following the
+// key rotations, and fetches the user of a session from the user info endpoint:
+//
+//	client, err := authclient.New(authclient.Config{
+//	    GatewayURL: "https://gateway.example.org",
+//	    Issuer:     "https://gateway.example.org",
+//	})
+//	if err != nil {
+//	    return err
+//	}
+//	claims, err := client.VerifyRequest(r) // Authorization: Bearer <token>
+//	user, err := client.UserInfo(r.Context(), r) // With the session cookies of r
+package authclient
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/animalet/sargantana-go/pkg/routes"
+	"github.com/animalet/sargantana-go/pkg/sessionsx"
+	"github.com/go-jose/go-jose/v4"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/pkg/errors"
+)
+
+const (
+	// DefaultJWKSPath is the path of the key set used throughout the documentation.
+	DefaultJWKSPath = "/.well-known/jwks.json"
+
+	defaultKeysMaxAge         = 5 * time.Minute
+	defaultMinRefreshInterval = time.Minute
+	defaultTimeout            = 10 * time.Second
+)
+
+// algorithms are the algorithms of the asymmetric keys of the signing rings
+var algorithms = []string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512", "EdDSA"}
+
+// ErrUnauthenticated is returned by UserInfo when the request has no authenticated session.
+var ErrUnauthenticated = errors.New("unauthenticated")
+
+// Config configures a Client.
+type Config struct {
+	// GatewayURL is the origin of the gateway, e.g. https://gateway.example.org.
+	GatewayURL string
+	// Routes are the paths of the routes of the gateway. Defaults to routes.Defaults().
+	Routes *routes.Routes
+	// JWKSPath is the path of the key set served by the JWKS controller. Defaults to
+	// /.well-known/jwks.json.
+	JWKSPath string
+	// Issuer and Audience are the required iss and aud claims of the tokens, if set.
+	Issuer   string
+	Audience string
+	// Leeway is the clock skew tolerated on the time claims.
+	Leeway time.Duration
+	// KeysMaxAge is the time the key set is used before it is downloaded again, so that removed
+	// keys are no longer trusted. Defaults to 5m, the max age of the JWKS controller.
+	KeysMaxAge time.Duration
+	// MinRefreshInterval limits how often tokens signed by unknown keys download the key set.
+	// Defaults to 1m.
+	MinRefreshInterval time.Duration
+	// HTTPClient calls the gateway. Defaults to a client with a timeout of 10s.
+	HTTPClient *http.Client
+}
+
+func (c Config) Validate() error {
+	u, err := url.Parse(c.GatewayURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return errors.New("gateway URL must be an http or https URL")
+	}
+	if c.JWKSPath != "" && !strings.HasPrefix(c.JWKSPath, "/") {
+		return errors.New("JWKS path must start with /")
+	}
+	if c.Leeway < 0 || c.KeysMaxAge < 0 || c.MinRefreshInterval < 0 {
+		return errors.New("leeway, keys max age and min refresh interval must be non-negative")
+	}
+	return nil
+}
+
+// Client validates the tokens and sessions of a gateway. It is safe for concurrent use.
+type Client struct {
+	routes  routes.Routes
+	client  *http.Client
+	options []jwt.ParserOption
+	keys    *keySet
+}
+
+// New creates a client of the gateway. The key set is downloaded on the first verification.
+func New(c Config) (*Client, error) {
+	if err := c.Validate(); err != nil {
+		return nil, errors.Wrap(err, "invalid auth client configuration")
+	}
+	r := routes.Defaults()
+	if c.Routes != nil {
+		r = *c.Routes
+	}
+	r.Origin = strings.TrimSuffix(c.GatewayURL, "/")
+	client := c.HTTPClient
+	if client == nil {
+		client = &http.Client{Timeout: defaultTimeout}
+	}
+	jwksPath := c.JWKSPath
+	if jwksPath == "" {
+		jwksPath = DefaultJWKSPath
+	}
+	keys := &keySet{
+		url:                r.Origin + jwksPath,
+		client:             client,
+		maxAge:             c.KeysMaxAge,
+		minRefreshInterval: c.MinRefreshInterval,
+	}
+	if keys.maxAge == 0 {
+		keys.maxAge = defaultKeysMaxAge
+	}
+	if keys.minRefreshInterval == 0 {
+		keys.minRefreshInterval = defaultMinRefreshInterval
+	}
+
+	options := []jwt.ParserOption{jwt.WithValidMethods(algorithms), jwt.WithLeeway(c.Leeway), jwt.WithExpirationRequired()}
+	if c.Issuer != "" {
+		options = append(options, jwt.WithIssuer(c.Issuer))
+	}
+	if c.Audience != "" {
+		options = append(options, jwt.WithAudience(c.Audience))
+	}
+	return &Client{routes: r, client: client, options: options, keys: keys}, nil
+}
+
+// Verify validates a token signed by the gateway and returns its claims.
+func (c *Client) Verify(ctx context.Context, token string) (jwt.MapClaims, error) {
+	claims := jwt.MapClaims{}
+	_, err := jwt.ParseWithClaims(token, claims, func(token *jwt.Token) (any, error) {
+		kid, _ := token.Header["kid"].(string)
+		return c.keys.key(ctx, kid, token.Method.Alg())
+	}, c.options...)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid token")
+	}
+	return claims, nil
+}
+
+// VerifyRequest validates the bearer token of the Authorization header of the request.
+func (c *Client) VerifyRequest(r *http.Request) (jwt.MapClaims, error) {
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") || token == "" {
+		return nil, errors.New("missing bearer token")
+	}
+	return c.Verify(r.Context(), token)
+}
+
+// UserInfo returns the user of the session of the request, sending its cookies to the user info
+// endpoint of the gateway. It returns ErrUnauthenticated when the session has no valid user.
+func (c *Client) UserInfo(ctx context.Context, r *http.Request) (*sessionsx.UserObject, error) {
+	if c.routes.UserInfo == "" {
+		return nil, errors.New("the user info route is not configured")
+	}
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, c.routes.UserInfoURL(), nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create user info request")
+	}
+	for _, cookie := range r.Cookies() {
+		request.AddCookie(cookie)
+	}
+	request.Header.Set("Accept", "application/json")
+
+	response, err := c.client.Do(request)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get user info")
+	}
+	defer func() { _ = response.Body.Close() }()
+	switch response.StatusCode {
+	case http.StatusOK:
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return nil, ErrUnauthenticated
+	default:
+		return nil, fmt.Errorf("failed to get user info: unexpected status %d", response.StatusCode)
+	}
+
+	var user sessionsx.UserObject
+	if err := json.NewDecoder(response.Body).Decode(&user); err != nil {
+		return nil, errors.Wrap(err, "invalid user info")
+	}
+	return &user, nil
+}
+
+// keySet resolves the verification keys of the tokens from the key set of the gateway, downloading
+// it again when it is stale or a token refers to an unknown key, e.g. after a rotation.
+type keySet struct {
+	url                string
+	client             *http.Client
+	maxAge             time.Duration
+	minRefreshInterval time.Duration
+
+	mu      sync.Mutex
+	keys    jose.JSONWebKeySet
+	fetched time.Time
+}
+
+func (s *keySet) key(ctx context.Context, kid, algorithm string) (any, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if time.Since(s.fetched) >= s.maxAge {
+		// Stale keys are kept when the gateway is unreachable, until it answers again
+		if err := s.fetch(ctx); err != nil && len(s.keys.Keys) == 0 {
+			return nil, err
+		}
+	}
+	key, ok := s.lookup(kid)
+	if !ok && time.Since(s.fetched) >= s.minRefreshInterval {
+		if err := s.fetch(ctx); err != nil {
+			return nil, err
+		}
+		key, ok = s.lookup(kid)
+	}
+	if !ok {
+		return nil, errors.Errorf("unknown key %q", kid)
+	}
+	if key.Algorithm != "" && key.Algorithm != algorithm {
+		return nil, errors.Errorf("unexpected algorithm %q for key %q", algorithm, kid)
+	}
+	return key.Key, nil
+}
+
+// lookup finds the signing key with the given ID. Tokens without an ID are accepted if the set
+// has a single signing key.
+func (s *keySet) lookup(kid string) (jose.JSONWebKey, bool) {
+	candidates := slices.DeleteFunc(slices.Clone(s.keys.Keys), func(key jose.JSONWebKey) bool {
+		return key.Use == "enc" || (kid != "" && key.KeyID != kid)
+	})
+	if len(candidates) != 1 {
+		return jose.JSONWebKey{}, false
+	}
+	return candidates[0], true
+}
+
+func (s *keySet) fetch(ctx context.Context) error {
+	s.fetched = time.Now()
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, s.url, nil)
+	if err != nil {
+		return errors.Wrap(err, "failed to create JWKS request")
+	}
+	response, err := s.client.Do(request)
+	if err != nil {
+		return errors.Wrap(err, "failed to download JWKS")
+	}
+	defer func() { _ = response.Body.Close() }()
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to download JWKS: unexpected status %d", response.StatusCode)
+	}
+
+	var keys jose.JSONWebKeySet
+	if err := json.NewDecoder(response.Body).Decode(&keys); err != nil {
+		return errors.Wrap(err, "invalid JWKS")
+	}
+	s.keys = keys
+	return nil
+}
@@ -0,0 +1,178 @@
+//go:build unit
+
+package authclient
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-jose/go-jose/v4"
+	"github.com/golang-jwt/jwt/v5"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+// signingKey is a key of the gateway, published in its key set
+type signingKey struct {
+	id  string
+	key *ecdsa.PrivateKey
+}
+
+func newSigningKey(id string) signingKey {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	Expect(err).NotTo(HaveOccurred())
+	return signingKey{id: id, key: key}
+}
+
+func (k signingKey) sign(claims jwt.MapClaims) string {
+	token := jwt.NewWithClaims(jwt.SigningMethodES256, claims)
+	token.Header["kid"] = k.id
+	signed, err := token.SignedString(k.key)
+	Expect(err).NotTo(HaveOccurred())
+	return signed
+}
+
+func (k signingKey) jwk() jose.JSONWebKey {
+	return jose.JSONWebKey{Key: &k.key.PublicKey, KeyID: k.id, Algorithm: "ES256", Use: "sig"}
+}
+
+var _ = Describe("Client", func() {
+	var (
+		gateway   *httptest.Server
+		published atomic.Pointer[[]signingKey]
+		downloads atomic.Int32
+		current   signingKey
+	)
+
+	claims := func() jwt.MapClaims {
+		return jwt.MapClaims{"sub": "alice", "iss": "https://gateway.example.org", "exp": time.Now().Add(time.Hour).Unix()}
+	}
+
+	newClient := func(c Config) *Client {
+		c.GatewayURL = gateway.URL
+		client, err := New(c)
+		Expect(err).NotTo(HaveOccurred())
+		return client
+	}
+
+	BeforeEach(func() {
+		current = newSigningKey("1")
+		published.Store(&[]signingKey{current})
+		downloads.Store(0)
+		gateway = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch r.URL.Path {
+			case DefaultJWKSPath:
+				downloads.Add(1)
+				set := jose.JSONWebKeySet{}
+				for _, key := range *published.Load() {
+					set.Keys = append(set.Keys, key.jwk())
+				}
+				_ = json.NewEncoder(w).Encode(set)
+			case "/auth/user":
+				if session, err := r.Cookie("session"); err != nil || session.Value != "valid" {
+					w.WriteHeader(http.StatusUnauthorized)
+					return
+				}
+				_, _ = w.Write([]byte(`{"id":"alice@example.org","user":{"provider":"github","email":"alice@example.org"}}`))
+			default:
+				w.WriteHeader(http.StatusInternalServerError)
+			}
+		}))
+		DeferCleanup(gateway.Close)
+	})
+
+	It("should validate the configuration", func() {
+		Expect(Config{GatewayURL: "https://gateway.example.org"}.Validate()).To(Succeed())
+		Expect(Config{GatewayURL: "gateway.example.org"}.Validate()).To(MatchError(ContainSubstring("gateway URL")))
+		Expect(Config{GatewayURL: "https://gateway.example.org", JWKSPath: "jwks.json"}.Validate()).To(MatchError(ContainSubstring("JWKS path")))
+		Expect(Config{GatewayURL: "https://gateway.example.org", Leeway: -time.Second}.Validate()).To(HaveOccurred())
+	})
+
+	It("should verify the tokens signed by the gateway", func() {
+		client := newClient(Config{Issuer: "https://gateway.example.org"})
+		verified, err := client.Verify(context.Background(), current.sign(claims()))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(verified["sub"]).To(Equal("alice"))
+
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("Authorization", "Bearer "+current.sign(claims()))
+		_, err = client.VerifyRequest(req)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(downloads.Load()).To(BeEquivalentTo(1))
+	})
+
+	It("should reject invalid tokens", func() {
+		client := newClient(Config{Issuer: "https://gateway.example.org", Audience: "orders"})
+		withAudience := claims()
+		withAudience["aud"] = "orders"
+		_, err := client.Verify(context.Background(), current.sign(withAudience))
+		Expect(err).NotTo(HaveOccurred())
+
+		_, err = client.Verify(context.Background(), current.sign(claims()))
+		Expect(err).To(MatchError(ContainSubstring("invalid token")))
+
+		expired := claims()
+		expired["aud"], expired["exp"] = "orders", time.Now().Add(-time.Minute).Unix()
+		_, err = client.Verify(context.Background(), current.sign(expired))
+		Expect(err).To(HaveOccurred())
+
+		_, err = client.Verify(context.Background(), newSigningKey("1").sign(withAudience))
+		Expect(err).To(HaveOccurred())
+
+		_, err = client.VerifyRequest(httptest.NewRequest(http.MethodGet, "/", nil))
+		Expect(err).To(MatchError(ContainSubstring("missing bearer token")))
+	})
+
+	It("should follow the key rotations", func() {
+		client := newClient(Config{MinRefreshInterval: time.Nanosecond})
+		_, err := client.Verify(context.Background(), current.sign(claims()))
+		Expect(err).NotTo(HaveOccurred())
+
+		next := newSigningKey("2")
+		published.Store(&[]signingKey{current, next})
+		_, err = client.Verify(context.Background(), next.sign(claims()))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(downloads.Load()).To(BeEquivalentTo(2))
+	})
+
+	It("should limit the downloads of the unknown keys", func() {
+		client := newClient(Config{})
+		unknown := newSigningKey("unknown")
+		for range 3 {
+			_, err := client.Verify(context.Background(), unknown.sign(claims()))
+			Expect(err).To(MatchError(ContainSubstring(`unknown key "unknown"`)))
+		}
+		Expect(downloads.Load()).To(BeEquivalentTo(1))
+	})
+
+	It("should stop trusting the removed keys", func() {
+		client := newClient(Config{KeysMaxAge: time.Nanosecond})
+		token := current.sign(claims())
+		_, err := client.Verify(context.Background(), token)
+		Expect(err).NotTo(HaveOccurred())
+
+		published.Store(&[]signingKey{newSigningKey("2")})
+		_, err = client.Verify(context.Background(), token)
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("should get the user of the session", func() {
+		client := newClient(Config{})
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.AddCookie(&http.Cookie{Name: "session", Value: "valid"})
+		user, err := client.UserInfo(context.Background(), req)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(user.Id).To(Equal("alice@example.org"))
+		Expect(user.User.Provider).To(Equal("github"))
+
+		_, err = client.UserInfo(context.Background(), httptest.NewRequest(http.MethodGet, "/", nil))
+		Expect(err).To(MatchError(ErrUnauthenticated))
+	})
+})
@@ -0,0 +1,13 @@
+package authclient
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+func TestAuthClient(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Auth Client Suite")
+}
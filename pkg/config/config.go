@@ -4,8 +4,10 @@
 package config
 
 import (
+	"bytes"
 	"encoding/json"
 	"encoding/xml"
+	"io"
 	"os"
 
 	"github.com/animalet/sargantana-go/internal/expansion"
@@ -17,17 +19,32 @@ import (
 
 type Config struct {
 	modules map[string]ModuleRawConfig
+	// loaded holds the sections loaded with Get, see WriteEffective
+	loaded map[string]loadedSection
+	// nodes holds the sections of YAML files, to report unknown fields with their lines
+	nodes map[string]*yaml.Node
 }
 
 type ModuleRawConfig []byte
 
 func Unmarshal[T Validatable](r ModuleRawConfig) (config *T, err error) {
 	log.Debug().Msgf("Unmarshalling configuration of type %T", config)
+	if format == YamlFormat {
+		var node yaml.Node
+		if err := yaml.Unmarshal(r, &node); err != nil {
+			return nil, err
+		}
+		// Module configurations are nested in the file, so only their paths are known
+		if err := checkKnownFields[T](&node, "", false); err != nil {
+			return nil, err
+		}
+	}
 	err = unmarshal(r, &config)
 	if err != nil {
 		return nil, err
 	}
-	return doExpand(config)
+	config, _, err = doExpand("", config)
+	return config, err
 }
 
 type Validatable interface {
@@ -56,11 +73,24 @@ func NewConfig(path string) (cfg *Config, err error) {
 		return nil, errors.Wrapf(err, "failed to read configuration file: %s", path)
 	}
 	var modules map[string]ModuleRawConfig
-	err = unmarshal(data, &modules)
+	nodes := make(map[string]*yaml.Node)
+	if format == YamlFormat {
+		var doc yaml.Node
+		if err := yaml.Unmarshal(data, &doc); err != nil {
+			return nil, errors.Wrapf(err, "error unmarshalling to %s", format)
+		}
+		if doc.Kind != 0 {
+			migrate(&doc)
+			nodes = sectionNodes(&doc)
+			err = doc.Decode(&modules)
+		}
+	} else {
+		err = unmarshal(data, &modules)
+	}
 	if err != nil {
 		return nil, errors.Wrapf(err, "error unmarshalling to %s", format)
 	}
-	return &Config{modules: modules}, nil
+	return &Config{modules: modules, loaded: make(map[string]loadedSection), nodes: nodes}, nil
 }
 
 // Get loads a configuration by name and returns a pointer to the configuration object.
@@ -81,17 +111,25 @@ func Get[T Validatable](c *Config, name string) (*T, error) {
 		return nil, nil
 	}
 
+	// Report all the unknown fields of YAML files with their lines before decoding
+	if node, ok := c.nodes[name]; ok {
+		if err := checkKnownFields[T](node, name, true); err != nil {
+			return nil, err
+		}
+	}
+
 	var partial *T
 	err := unmarshal(raw, &partial)
 	if err != nil {
 		return nil, err
 	}
 
-	expanded, err := doExpand(partial)
+	expanded, sources, err := doExpand(name, partial)
 	if err != nil {
 		return nil, err
 	}
 
+	c.loaded[name] = loadedSection{value: expanded, sources: sources}
 	return expanded, nil
 }
 
@@ -144,16 +182,22 @@ func GetClientAndConfig[T ClientFactory[F], F any](c *Config, name string) (*F,
 	return &client, cfg, nil
 }
 
-func doExpand[T Validatable](toExpand *T) (*T, error) {
+func doExpand[T Validatable](name string, toExpand *T) (*T, expansion.Sources, error) {
 	log.Debug().Msgf("Expanding variables for config type %T", toExpand)
-	if err := expansion.ExpandVariables(toExpand); err != nil {
-		return nil, err
+	sources, err := expansion.Expand(toExpand)
+	if err != nil {
+		var expansionErr *expansion.Error
+		if errors.As(err, &expansionErr) {
+			// Locate the placeholders from the root of the configuration file
+			return nil, nil, expansionErr.WithPrefix(name)
+		}
+		return nil, nil, err
 	}
 	log.Debug().Msgf("Validating config type %T", toExpand)
 	if err := (*toExpand).Validate(); err != nil {
-		return nil, errors.Wrap(err, "configuration is invalid")
+		return nil, nil, errors.Wrap(err, "configuration is invalid")
 	}
-	return toExpand, nil
+	return toExpand, sources, nil
 }
 
 var format = YamlFormat
@@ -174,7 +218,13 @@ const (
 func unmarshal(in []byte, out any) error {
 	switch format {
 	case YamlFormat:
-		return yaml.Unmarshal(in, out)
+		// Unknown fields are errors, not silently ignored typos
+		decoder := yaml.NewDecoder(bytes.NewReader(in))
+		decoder.KnownFields(true)
+		if err := decoder.Decode(out); err != nil && !errors.Is(err, io.EOF) {
+			return err
+		}
+		return nil
 	case JsonFormat:
 		return json.Unmarshal(in, out)
 	case TomlFormat:
@@ -196,6 +246,16 @@ func (m *ModuleRawConfig) UnmarshalYAML(value *yaml.Node) error {
 	return nil
 }
 
+// MarshalYAML renders the raw configuration as the tree it holds, so that configurations embedding
+// module configurations can be written, e.g. by Config.WriteEffective.
+func (m ModuleRawConfig) MarshalYAML() (interface{}, error) {
+	var tree interface{}
+	if err := unmarshal(m, &tree); err != nil {
+		return string(m), nil
+	}
+	return tree, nil
+}
+
 func (m *ModuleRawConfig) UnmarshalJSON(data []byte) error {
 	*m = data
 	return nil
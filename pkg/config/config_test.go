@@ -7,6 +7,7 @@ import (
 	"encoding/xml"
 	"os"
 	"path/filepath"
+	"reflect"
 
 	"github.com/animalet/sargantana-go/pkg/config/secrets"
 	. "github.com/onsi/ginkgo/v2"
@@ -71,6 +72,18 @@ func (t TestClientConfig) CreateClient() (TestClient, error) {
 	return TestClient{Name: t.Name}, nil
 }
 
+// OverrideTestConfig is the type the overridden configurations decode to
+type OverrideTestConfig struct {
+	Hosts []string `yaml:"hosts"`
+	TLS   *struct {
+		CAFile string `yaml:"ca_file"`
+	} `yaml:"tls"`
+	Providers map[string]struct {
+		Key string `yaml:"key"`
+	} `yaml:"providers"`
+	ClientSecret string `yaml:"client_secret"`
+}
+
 type MockSecretLoader struct {
 	Secrets map[string]string
 }
@@ -242,6 +255,82 @@ test:
 
 	})
 
+	Context("ModuleRawConfig Override", func() {
+		It("should override existing keys matching consecutive words", func() {
+			m := ModuleRawConfig("client_secret: old\nport: 80\n")
+			overridden, err := m.Override([]string{"CLIENT", "SECRET"}, "new", nil)
+			Expect(err).NotTo(HaveOccurred())
+			overridden, err = overridden.Override([]string{"PORT"}, "8080", nil)
+			Expect(err).NotTo(HaveOccurred())
+
+			var values map[string]any
+			Expect(yaml.Unmarshal(overridden, &values)).To(Succeed())
+			Expect(values).To(Equal(map[string]any{"client_secret": "new", "port": 8080}))
+		})
+
+		It("should add missing keys as one flat key when nothing resolves them", func() {
+			for _, schema := range []reflect.Type{nil, reflect.TypeFor[OverrideTestConfig]()} {
+				overridden, err := ModuleRawConfig("port: 80\n").Override([]string{"CLIENT", "SECRET"}, "s3cret", schema)
+				Expect(err).NotTo(HaveOccurred())
+
+				var values map[string]any
+				Expect(yaml.Unmarshal(overridden, &values)).To(Succeed())
+				Expect(values).To(Equal(map[string]any{"client_secret": "s3cret", "port": 80}))
+			}
+
+			overridden, err := ModuleRawConfig("").Override([]string{"CLIENT", "SECRET"}, "s3cret", reflect.TypeFor[OverrideTestConfig]())
+			Expect(err).NotTo(HaveOccurred())
+			var cfg OverrideTestConfig
+			Expect(yaml.Unmarshal(overridden, &cfg)).To(Succeed())
+			Expect(cfg.ClientSecret).To(Equal("s3cret"))
+		})
+
+		It("should add missing keys and override list items", func() {
+			m := ModuleRawConfig("hosts: [a, b]\n")
+			overridden, err := m.Override([]string{"hosts", "1"}, "c", reflect.TypeFor[OverrideTestConfig]())
+			Expect(err).NotTo(HaveOccurred())
+			overridden, err = overridden.Override([]string{"tls", "ca", "file"}, "/ca.pem", reflect.TypeFor[OverrideTestConfig]())
+			Expect(err).NotTo(HaveOccurred())
+
+			var values map[string]any
+			Expect(yaml.Unmarshal(overridden, &values)).To(Succeed())
+			Expect(values["hosts"]).To(Equal([]any{"a", "c"}))
+			Expect(values["tls"]).To(Equal(map[string]any{"ca_file": "/ca.pem"}))
+		})
+
+		It("should nest the words of unset sections under the existing keys", func() {
+			m := ModuleRawConfig("providers:\n  github:\n    key: a\n")
+			overridden, err := m.Override([]string{"PROVIDERS", "google", "KEY"}, "b", reflect.TypeFor[OverrideTestConfig]())
+			Expect(err).NotTo(HaveOccurred())
+
+			var values map[string]any
+			Expect(yaml.Unmarshal(overridden, &values)).To(Succeed())
+			Expect(values["providers"]).To(Equal(map[string]any{
+				"github": map[string]any{"key": "a"},
+				"google": map[string]any{"key": "b"},
+			}))
+		})
+
+		It("should fail for out of range indexes and scalars", func() {
+			m := ModuleRawConfig("hosts: [a]\nport: 80\n")
+			_, err := m.Override([]string{"hosts", "3"}, "c", nil)
+			Expect(err).To(MatchError(ContainSubstring("invalid index")))
+			_, err = m.Override([]string{"port", "number"}, "c", nil)
+			Expect(err).To(MatchError(ContainSubstring("scalar value")))
+		})
+
+		It("should keep the configured format", func() {
+			UseFormat(JsonFormat)
+			defer UseFormat(YamlFormat)
+
+			overridden, err := ModuleRawConfig(`{"field": "value"}`).Override([]string{"FIELD"}, "other", nil)
+			Expect(err).NotTo(HaveOccurred())
+			cfg, err := Unmarshal[TestConfigStruct](overridden)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(cfg.Field).To(Equal("other"))
+		})
+	})
+
 	Context("ModuleRawConfig Unmarshal Errors", func() {
 		It("should return error when TOML marshal fails", func() {
 			// Create a structure that cannot be marshaled to TOML (e.g., channel)
@@ -0,0 +1,193 @@
+package config
+
+import (
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+
+	"github.com/animalet/sargantana-go/internal/expansion"
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+// Provenance of the configuration values that do not come from a secret provider. Values
+// resolved from placeholders have the prefixes of their providers as source, e.g. "env" or "vault".
+const (
+	// SourceConfigFile is the source of the values written in the configuration file.
+	SourceConfigFile = "config file"
+	// SourceDefault is the source of the values absent from the configuration file.
+	SourceDefault = "default"
+)
+
+// Redacted replaces secret values in the effective configuration.
+const Redacted = "<redacted>"
+
+// plainSources are the secret providers whose values are printed in the effective configuration,
+// unless their key looks sensitive.
+var plainSources = []string{SourceConfigFile, SourceDefault, "env", "literal"}
+
+// sensitiveWords are the words of the keys whose values are always redacted, e.g. "client_secret".
+var sensitiveWords = []string{"secret", "password", "passwd", "token", "credential", "credentials", "apikey", "dsn", "dsns"}
+
+// sensitiveKeys are the key fragments whose values are always redacted, e.g. "jwt_private_key".
+var sensitiveKeys = []string{"private_key", "api_key", "secret_key"}
+
+// loadedSection is a section loaded with Get, kept to print the effective configuration
+type loadedSection struct {
+	value   any
+	sources expansion.Sources
+}
+
+// Source returns where the value at path came from: SourceConfigFile, SourceDefault or the
+// prefixes of the secret providers that resolved it, joined with "+". The path starts with the
+// section name and uses YAML field names, e.g. "sargantana.session_secret" or "redis.tls.ca_file".
+// Sections not loaded with Get yet are not expanded, so their values come from the file.
+func (c *Config) Source(path string) string {
+	name, rest, _ := strings.Cut(path, ".")
+	if section, ok := c.loaded[name]; ok {
+		if sources, ok := section.sources[rest]; ok {
+			return strings.Join(sources, "+")
+		}
+	}
+	if present, known := c.rawPaths(name); known && !present[rest] {
+		return SourceDefault
+	}
+	return SourceConfigFile
+}
+
+// WriteEffective writes the effective configuration as YAML, with the secrets redacted and the
+// source of every value as a line comment. Sections loaded with Get are written with their
+// placeholders expanded and their defaults, the other ones as found in the file.
+func (c *Config) WriteEffective(w io.Writer) error {
+	names := make([]string, 0, len(c.modules))
+	for name := range c.modules {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	root := &yaml.Node{Kind: yaml.MappingNode}
+	for _, name := range names {
+		node := &yaml.Node{}
+		if section, ok := c.loaded[name]; ok {
+			if err := node.Encode(section.value); err != nil {
+				return errors.Wrapf(err, "failed to encode configuration %q", name)
+			}
+		} else {
+			var tree any
+			if err := unmarshal(c.modules[name], &tree); err != nil {
+				return errors.Wrapf(err, "failed to decode configuration %q", name)
+			}
+			if err := node.Encode(tree); err != nil {
+				return errors.Wrapf(err, "failed to encode configuration %q", name)
+			}
+			node.HeadComment = "not loaded, placeholders are not expanded"
+		}
+		c.annotate(node, name, false)
+		root.Content = append(root.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: name, HeadComment: node.HeadComment}, node)
+		node.HeadComment = ""
+	}
+
+	encoder := yaml.NewEncoder(w)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(root); err != nil {
+		return errors.Wrap(err, "failed to write effective configuration")
+	}
+	return errors.Wrap(encoder.Close(), "failed to write effective configuration")
+}
+
+// annotate redacts the secrets of node and adds the source of every scalar as line comment
+func (c *Config) annotate(node *yaml.Node, path string, sensitive bool) {
+	switch node.Kind {
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key := node.Content[i].Value
+			c.annotate(node.Content[i+1], joinPath(path, key), sensitive || isSensitiveKey(key))
+		}
+	case yaml.SequenceNode:
+		for i, item := range node.Content {
+			c.annotate(item, fmt.Sprintf("%s[%d]", path, i), sensitive)
+		}
+	case yaml.ScalarNode:
+		source := c.Source(path)
+		if node.Value != "" && (sensitive || !isPlainSource(source)) {
+			node.Value = Redacted
+			node.Tag = "!!str"
+			node.Style = 0
+		}
+		node.LineComment = source
+	default:
+		// Documents and aliases are not produced by encoding values
+	}
+}
+
+// rawPaths returns the paths present in the file for the section, and false if the section
+// cannot be decoded generically, e.g. in XML.
+func (c *Config) rawPaths(name string) (map[string]bool, bool) {
+	raw, ok := c.modules[name]
+	if !ok {
+		return nil, false
+	}
+	var tree any
+	if err := unmarshal(raw, &tree); err != nil {
+		return nil, false
+	}
+	present := make(map[string]bool)
+	collectPaths(tree, "", present)
+	return present, true
+}
+
+func collectPaths(tree any, path string, present map[string]bool) {
+	present[path] = true
+	switch value := tree.(type) {
+	case map[string]any:
+		for key, child := range value {
+			collectPaths(child, joinPath(path, key), present)
+		}
+	case map[any]any:
+		for key, child := range value {
+			collectPaths(child, joinPath(path, fmt.Sprint(key)), present)
+		}
+	case []any:
+		for i, child := range value {
+			collectPaths(child, fmt.Sprintf("%s[%d]", path, i), present)
+		}
+	}
+}
+
+func isPlainSource(source string) bool {
+	for _, s := range strings.Split(source, "+") {
+		if !slices.Contains(plainSources, s) {
+			return false
+		}
+	}
+	return true
+}
+
+func isSensitiveKey(key string) bool {
+	key = strings.ToLower(key)
+	for _, word := range strings.FieldsFunc(key, func(r rune) bool { return r == '_' || r == '-' }) {
+		if slices.Contains(sensitiveWords, word) {
+			return true
+		}
+	}
+	for _, fragment := range sensitiveKeys {
+		if strings.Contains(key, fragment) {
+			return true
+		}
+	}
+	return false
+}
+
+func joinPath(prefix, path string) string {
+	switch {
+	case prefix == "":
+		return path
+	case path == "":
+		return prefix
+	case strings.HasPrefix(path, "["):
+		return prefix + path
+	default:
+		return prefix + "." + path
+	}
+}
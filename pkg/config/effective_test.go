@@ -0,0 +1,84 @@
+//go:build unit
+
+package config
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+
+	"github.com/animalet/sargantana-go/pkg/config/secrets"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+type EffectiveTestStruct struct {
+	Host     string          `yaml:"host"`
+	Port     int             `yaml:"port"`
+	Password string          `yaml:"password"`
+	Token    string          `yaml:"api_token"`
+	Nested   ModuleRawConfig `yaml:"nested,omitempty"`
+}
+
+func (e EffectiveTestStruct) Validate() error {
+	return nil
+}
+
+var _ = Describe("Effective configuration", func() {
+	var cfg *Config
+
+	BeforeEach(func() {
+		secrets.Register("mock", &MockSecretLoader{Secrets: map[string]string{
+			"HOST":  "vault.example.com",
+			"TOKEN": "s3cr3t",
+		}})
+		GinkgoT().Setenv("EFFECTIVE_PASSWORD", "hunter2")
+
+		path := filepath.Join(GinkgoT().TempDir(), "config.yaml")
+		Expect(os.WriteFile(path, []byte(`
+service:
+  host: ${mock:HOST}
+  password: ${EFFECTIVE_PASSWORD}
+  api_token: ${mock:TOKEN}
+  nested:
+    client_secret: plain
+    url: https://example.com
+other:
+  secrets_dir: /run/secrets
+  key: ${mock:TOKEN}
+`), 0644)).To(Succeed())
+
+		var err error
+		cfg, err = NewConfig(path)
+		Expect(err).NotTo(HaveOccurred())
+		_, err = Get[EffectiveTestStruct](cfg, "service")
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	It("should report the source of each value", func() {
+		Expect(cfg.Source("service.host")).To(Equal("mock"))
+		Expect(cfg.Source("service.password")).To(Equal("env"))
+		Expect(cfg.Source("service.port")).To(Equal(SourceDefault))
+		Expect(cfg.Source("service.nested.url")).To(Equal(SourceConfigFile))
+		Expect(cfg.Source("other.key")).To(Equal(SourceConfigFile))
+	})
+
+	It("should write the configuration with secrets redacted and sources", func() {
+		var out bytes.Buffer
+		Expect(cfg.WriteEffective(&out)).To(Succeed())
+		dump := out.String()
+
+		Expect(dump).To(ContainSubstring("host: <redacted> # mock"))
+		Expect(dump).To(ContainSubstring("port: 0 # default"))
+		Expect(dump).To(ContainSubstring("password: <redacted> # env"))
+		Expect(dump).To(ContainSubstring("api_token: <redacted> # mock"))
+		Expect(dump).To(ContainSubstring("client_secret: <redacted> # config file"))
+		Expect(dump).To(ContainSubstring("url: https://example.com # config file"))
+		Expect(dump).To(ContainSubstring("# not loaded, placeholders are not expanded"))
+		Expect(dump).To(ContainSubstring("key: ${mock:TOKEN} # config file"))
+		Expect(dump).To(ContainSubstring("secrets_dir: /run/secrets # config file"))
+		Expect(dump).NotTo(ContainSubstring("hunter2"))
+		Expect(dump).NotTo(ContainSubstring("s3cr3t"))
+		Expect(dump).NotTo(ContainSubstring("vault.example.com"))
+	})
+})
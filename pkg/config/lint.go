@@ -0,0 +1,286 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"slices"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+	"gopkg.in/yaml.v3"
+)
+
+// UnknownField is a key of a YAML configuration that matches no field of the configuration type.
+type UnknownField struct {
+	// Path locates the key from the root of the file, e.g. "sargantana.server.sesion_secret", or
+	// from the root of the module configuration for Unmarshal.
+	Path string
+	// Line is the line of the key in the configuration file, 0 when unknown.
+	Line int
+	// Suggestion is the known field with the closest name, if any is close enough.
+	Suggestion string
+}
+
+// UnknownFieldsError reports all the unknown keys of a configuration, so that typos are not ignored.
+type UnknownFieldsError struct {
+	Fields []UnknownField
+}
+
+func (e *UnknownFieldsError) Error() string {
+	var b strings.Builder
+	_, _ = fmt.Fprintf(&b, "%d unknown configuration field(s):", len(e.Fields))
+	for _, f := range e.Fields {
+		_, _ = fmt.Fprintf(&b, "\n - %s", f.Path)
+		if f.Line > 0 {
+			_, _ = fmt.Fprintf(&b, " (line %d)", f.Line)
+		}
+		if f.Suggestion != "" {
+			_, _ = fmt.Fprintf(&b, ", did you mean %q?", f.Suggestion)
+		}
+	}
+	return b.String()
+}
+
+// migration renames a deprecated key, see RegisterMigration
+type migration struct {
+	path    []string
+	newName string
+}
+
+// migrations holds the registered key migrations, applied in order when loading YAML files.
+var migrations []migration
+
+// RegisterMigration renames a deprecated key of YAML configuration files to its new name when
+// they are loaded, logging a warning with the line of the key. The deprecated path is given from
+// the root of the file, with "*" matching any key or list item, and the new name replaces its
+// last segment. When both keys are set, the deprecated one is ignored.
+//
+// Example:
+//
+//	config.RegisterMigration("sargantana.controllers.*.config.timeout_seconds", "timeout")
+func RegisterMigration(deprecatedPath, newName string) {
+	migrations = append(migrations, migration{path: strings.Split(deprecatedPath, "."), newName: newName})
+}
+
+// migrate applies the registered migrations to the document node of a YAML configuration file
+func migrate(doc *yaml.Node) {
+	if doc.Kind != yaml.DocumentNode || len(doc.Content) == 0 {
+		return
+	}
+	for _, m := range migrations {
+		m.apply(doc.Content[0], m.path, "")
+	}
+}
+
+func (m migration) apply(node *yaml.Node, segments []string, path string) {
+	if node.Kind == yaml.AliasNode {
+		node = node.Alias
+	}
+	switch node.Kind {
+	case yaml.SequenceNode:
+		if segments[0] != "*" {
+			return
+		}
+		for i, item := range node.Content {
+			if len(segments) > 1 {
+				m.apply(item, segments[1:], fmt.Sprintf("%s[%d]", path, i))
+			}
+		}
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key := node.Content[i]
+			if segments[0] != "*" && key.Value != segments[0] {
+				continue
+			}
+			if len(segments) > 1 {
+				m.apply(node.Content[i+1], segments[1:], joinPath(path, key.Value))
+				continue
+			}
+			m.rename(node, i, path)
+			return
+		}
+	default:
+		// Scalars have no keys to migrate
+	}
+}
+
+// rename renames the key at index i of the mapping, or removes it if the new key is already set
+func (m migration) rename(mapping *yaml.Node, i int, path string) {
+	key := mapping.Content[i]
+	deprecated := joinPath(path, key.Value)
+	replacement := joinPath(path, m.newName)
+	for j := 0; j+1 < len(mapping.Content); j += 2 {
+		if mapping.Content[j].Value == m.newName {
+			log.Warn().
+				Str("key", deprecated).
+				Str("replacement", replacement).
+				Int("line", key.Line).
+				Msg("Deprecated configuration key ignored, its replacement is also set")
+			mapping.Content = slices.Delete(mapping.Content, i, i+2)
+			return
+		}
+	}
+	log.Warn().
+		Str("key", deprecated).
+		Str("replacement", replacement).
+		Int("line", key.Line).
+		Msg("Deprecated configuration key, rename it")
+	key.Value = m.newName
+}
+
+// sectionNodes returns the value nodes of the top-level keys of a YAML configuration file
+func sectionNodes(doc *yaml.Node) map[string]*yaml.Node {
+	nodes := make(map[string]*yaml.Node)
+	if doc.Kind != yaml.DocumentNode || len(doc.Content) == 0 || doc.Content[0].Kind != yaml.MappingNode {
+		return nodes
+	}
+	root := doc.Content[0]
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		nodes[root.Content[i].Value] = root.Content[i+1]
+	}
+	return nodes
+}
+
+// checkKnownFields reports the keys of node that match no field of T, located from path. Lines
+// are reported when the node comes from the configuration file.
+func checkKnownFields[T any](node *yaml.Node, path string, withLines bool) error {
+	var unknown []UnknownField
+	unknownFields(node, reflect.TypeFor[T](), path, &unknown)
+	if len(unknown) == 0 {
+		return nil
+	}
+	if !withLines {
+		for i := range unknown {
+			unknown[i].Line = 0
+		}
+	}
+	return &UnknownFieldsError{Fields: unknown}
+}
+
+var unmarshalerType = reflect.TypeFor[yaml.Unmarshaler]()
+
+func unknownFields(node *yaml.Node, t reflect.Type, path string, unknown *[]UnknownField) {
+	switch node.Kind {
+	case yaml.DocumentNode:
+		if len(node.Content) > 0 {
+			unknownFields(node.Content[0], t, path, unknown)
+		}
+		return
+	case yaml.AliasNode:
+		unknownFields(node.Alias, t, path, unknown)
+		return
+	default:
+	}
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	// Types decoding themselves, such as ModuleRawConfig, are checked when they are decoded
+	if t.Implements(unmarshalerType) || reflect.PointerTo(t).Implements(unmarshalerType) {
+		return
+	}
+
+	switch {
+	case t.Kind() == reflect.Struct && node.Kind == yaml.MappingNode:
+		fields, anyKey := yamlFields(t)
+		if anyKey {
+			return
+		}
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key := node.Content[i].Value
+			if key == "<<" {
+				continue
+			}
+			fieldType, ok := fields[key]
+			if !ok {
+				*unknown = append(*unknown, UnknownField{
+					Path:       joinPath(path, key),
+					Line:       node.Content[i].Line,
+					Suggestion: closestName(key, fields),
+				})
+				continue
+			}
+			unknownFields(node.Content[i+1], fieldType, joinPath(path, key), unknown)
+		}
+	case t.Kind() == reflect.Map && node.Kind == yaml.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			unknownFields(node.Content[i+1], t.Elem(), joinPath(path, node.Content[i].Value), unknown)
+		}
+	case (t.Kind() == reflect.Slice || t.Kind() == reflect.Array) && node.Kind == yaml.SequenceNode:
+		for i, item := range node.Content {
+			unknownFields(item, t.Elem(), fmt.Sprintf("%s[%d]", path, i), unknown)
+		}
+	default:
+		// Scalars, interfaces and mismatched kinds are left to the decoder
+	}
+}
+
+// yamlFields returns the types of the fields of a struct by YAML key, following the yaml.v3
+// naming rules, and whether an inline map accepts any key.
+func yamlFields(t reflect.Type) (map[string]reflect.Type, bool) {
+	fields := make(map[string]reflect.Type)
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.PkgPath != "" && !field.Anonymous {
+			continue
+		}
+		tag := field.Tag.Get("yaml")
+		if tag == "-" {
+			continue
+		}
+		name, options, _ := strings.Cut(tag, ",")
+		if slices.Contains(strings.Split(options, ","), "inline") {
+			fieldType := field.Type
+			for fieldType.Kind() == reflect.Pointer {
+				fieldType = fieldType.Elem()
+			}
+			if fieldType.Kind() == reflect.Map {
+				return fields, true
+			}
+			inlined, anyKey := yamlFields(fieldType)
+			if anyKey {
+				return fields, true
+			}
+			for key, inlinedType := range inlined {
+				fields[key] = inlinedType
+			}
+			continue
+		}
+		if name == "" {
+			name = strings.ToLower(field.Name)
+		}
+		fields[name] = field.Type
+	}
+	return fields, false
+}
+
+// closestName returns the field name within two edits of key, if any
+func closestName(key string, fields map[string]reflect.Type) string {
+	best, bestDistance := "", 3
+	for name := range fields {
+		if d := editDistance(key, name); d < bestDistance || (d == bestDistance && name < best) {
+			best, bestDistance = name, d
+		}
+	}
+	return best
+}
+
+// editDistance returns the Levenshtein distance between a and b
+func editDistance(a, b string) int {
+	previous := make([]int, len(b)+1)
+	current := make([]int, len(b)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		current[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			current[j] = min(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+		}
+		previous, current = current, previous
+	}
+	return previous[len(b)]
+}
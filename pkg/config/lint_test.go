@@ -0,0 +1,93 @@
+//go:build unit
+
+package config
+
+import (
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+type LintTestStruct struct {
+	SessionSecret string            `yaml:"session_secret"`
+	Timeout       int               `yaml:"timeout"`
+	Nested        *LintNestedStruct `yaml:"nested"`
+	Items         []LintNestedStruct
+	Raw           ModuleRawConfig `yaml:"raw"`
+}
+
+type LintNestedStruct struct {
+	Name string `yaml:"name"`
+}
+
+func (l LintTestStruct) Validate() error {
+	return nil
+}
+
+var _ = Describe("Configuration linting", func() {
+	var path string
+
+	BeforeEach(func() {
+		path = filepath.Join(GinkgoT().TempDir(), "config.yaml")
+	})
+
+	AfterEach(func() {
+		migrations = nil
+	})
+
+	It("should report every unknown field with its line and a suggestion", func() {
+		Expect(os.WriteFile(path, []byte(`other: {}
+lint:
+  sesion_secret: typo
+  nested:
+    name: ok
+    nmae: typo
+  items:
+    - name: ok
+    - unrelated: x
+  raw:
+    anything: goes
+`), 0644)).To(Succeed())
+
+		cfg, err := NewConfig(path)
+		Expect(err).NotTo(HaveOccurred())
+		_, err = Get[LintTestStruct](cfg, "lint")
+
+		var unknownErr *UnknownFieldsError
+		Expect(err).To(BeAssignableToTypeOf(unknownErr))
+		unknownErr = err.(*UnknownFieldsError)
+		Expect(unknownErr.Fields).To(Equal([]UnknownField{
+			{Path: "lint.sesion_secret", Line: 3, Suggestion: "session_secret"},
+			{Path: "lint.nested.nmae", Line: 6, Suggestion: "name"},
+			{Path: "lint.items[1].unrelated", Line: 9},
+		}))
+		Expect(err.Error()).To(ContainSubstring(`lint.sesion_secret (line 3), did you mean "session_secret"?`))
+	})
+
+	It("should report unknown fields of module configurations without lines", func() {
+		_, err := Unmarshal[LintTestStruct](ModuleRawConfig("timeout: 3\ntimeuot: 4\n"))
+		Expect(err).To(MatchError(ContainSubstring(`timeuot, did you mean "timeout"?`)))
+		Expect(err.Error()).NotTo(ContainSubstring("line"))
+	})
+
+	It("should rename deprecated keys", func() {
+		RegisterMigration("lint.secret", "session_secret")
+		RegisterMigration("lint.items.*.title", "name")
+		Expect(os.WriteFile(path, []byte(`lint:
+  secret: migrated
+  items:
+    - title: first
+    - name: second
+      title: ignored
+`), 0644)).To(Succeed())
+
+		cfg, err := NewConfig(path)
+		Expect(err).NotTo(HaveOccurred())
+		lint, err := Get[LintTestStruct](cfg, "lint")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(lint.SessionSecret).To(Equal("migrated"))
+		Expect(lint.Items).To(Equal([]LintNestedStruct{{Name: "first"}, {Name: "second"}}))
+	})
+})
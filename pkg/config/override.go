@@ -0,0 +1,205 @@
+package config
+
+import (
+	"encoding/json"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/pelletier/go-toml/v2"
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+// Override returns a copy of the raw configuration with the value at path replaced, e.g. to apply
+// environment variable overrides. The path is given as words, e.g. ["providers", "google", "key"]:
+// consecutive words joined with "_" match existing keys case-insensitively, so that ["client",
+// "secret"] overrides "client_secret", and numeric words index sequences. Words matching no key are
+// resolved against the yaml tags of schema, the type the configuration decodes to, so that
+// ["tls", "ca", "file"] sets tls.ca_file when tls is a struct with a ca_file field. Without schema,
+// or when it has no such field, they are joined into one lower-case key. The value is parsed as a
+// YAML scalar, so that "8080" overrides integers and "true" booleans.
+func (m ModuleRawConfig) Override(path []string, value string, schema reflect.Type) (ModuleRawConfig, error) {
+	if len(path) == 0 {
+		return nil, errors.New("override path must not be empty")
+	}
+	var tree any
+	if len(m) > 0 {
+		if err := unmarshal(m, &tree); err != nil {
+			return nil, errors.Wrap(err, "failed to decode configuration to override")
+		}
+	}
+
+	var typed any
+	if err := yaml.Unmarshal([]byte(value), &typed); err != nil || typed == nil {
+		typed = value
+	}
+	tree, err := override(tree, path, typed, schema)
+	if err != nil {
+		return nil, err
+	}
+	return marshal(tree)
+}
+
+// override sets the value at path in tree, whose type is schema, or nil if unknown
+func override(tree any, path []string, value any, schema reflect.Type) (any, error) {
+	if len(path) == 0 {
+		return value, nil
+	}
+	switch node := tree.(type) {
+	case nil:
+		return override(map[string]any{}, path, value, schema)
+	case map[string]any:
+		// Prefer the longest key, "client_secret" over "client"
+		for n := len(path); n > 0; n-- {
+			wanted := strings.Join(path[:n], "_")
+			for key, child := range node {
+				if strings.EqualFold(key, wanted) {
+					overridden, err := override(child, path[n:], value, childSchema(schema, key))
+					if err != nil {
+						return nil, err
+					}
+					node[key] = overridden
+					return node, nil
+				}
+			}
+		}
+		keys := resolve(schema, path)
+		if keys == nil {
+			keys = []string{strings.ToLower(strings.Join(path, "_"))}
+		}
+		var nested any = value
+		for i := len(keys) - 1; i > 0; i-- {
+			nested = map[string]any{keys[i]: nested}
+		}
+		node[keys[0]] = nested
+		return node, nil
+	case []any:
+		index, err := strconv.Atoi(path[0])
+		if err != nil || index < 0 || index >= len(node) {
+			return nil, errors.Errorf("invalid index %q for a list of %d items", path[0], len(node))
+		}
+		overridden, err := override(node[index], path[1:], value, childSchema(schema, path[0]))
+		if err != nil {
+			return nil, err
+		}
+		node[index] = overridden
+		return node, nil
+	default:
+		return nil, errors.Errorf("cannot override %q in a scalar value", strings.Join(path, "_"))
+	}
+}
+
+// resolve returns the keys of the words of path in schema, one per nested section, or nil if
+// schema has no such value. Map keys take as few words as possible.
+func resolve(schema reflect.Type, path []string) []string {
+	if schema == nil {
+		return nil
+	}
+	for schema.Kind() == reflect.Pointer {
+		schema = schema.Elem()
+	}
+	switch schema.Kind() {
+	case reflect.Struct:
+		for n := len(path); n > 0; n-- {
+			field, ok := yamlField(schema, strings.Join(path[:n], "_"))
+			if !ok {
+				continue
+			}
+			if n == len(path) {
+				return []string{field.name}
+			}
+			if keys := resolve(field.schema, path[n:]); keys != nil {
+				return append([]string{field.name}, keys...)
+			}
+		}
+	case reflect.Map:
+		if schema.Key().Kind() != reflect.String {
+			return nil
+		}
+		for n := 1; n <= len(path); n++ {
+			key := strings.ToLower(strings.Join(path[:n], "_"))
+			if n == len(path) {
+				return []string{key}
+			}
+			if keys := resolve(schema.Elem(), path[n:]); keys != nil {
+				return append([]string{key}, keys...)
+			}
+		}
+	case reflect.Interface:
+		return []string{strings.ToLower(strings.Join(path, "_"))}
+	}
+	return nil
+}
+
+// childSchema returns the type of the value at key in schema, or nil if unknown
+func childSchema(schema reflect.Type, key string) reflect.Type {
+	if schema == nil {
+		return nil
+	}
+	for schema.Kind() == reflect.Pointer {
+		schema = schema.Elem()
+	}
+	switch schema.Kind() {
+	case reflect.Struct:
+		if field, ok := yamlField(schema, key); ok {
+			return field.schema
+		}
+	case reflect.Map, reflect.Slice, reflect.Array:
+		return schema.Elem()
+	}
+	return nil
+}
+
+type schemaField struct {
+	name   string
+	schema reflect.Type
+}
+
+// yamlField finds the field of a struct named name in YAML, case-insensitively, including the
+// fields of inlined structs
+func yamlField(schema reflect.Type, name string) (schemaField, bool) {
+	for i := 0; i < schema.NumField(); i++ {
+		field := schema.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		tagName, options, _ := strings.Cut(field.Tag.Get("yaml"), ",")
+		if tagName == "-" {
+			continue
+		}
+		if slices.Contains(strings.Split(options, ","), "inline") {
+			inlined := field.Type
+			for inlined.Kind() == reflect.Pointer {
+				inlined = inlined.Elem()
+			}
+			if inlined.Kind() == reflect.Struct {
+				if found, ok := yamlField(inlined, name); ok {
+					return found, true
+				}
+			}
+			continue
+		}
+		if tagName == "" {
+			tagName = strings.ToLower(field.Name)
+		}
+		if strings.EqualFold(tagName, name) {
+			return schemaField{name: tagName, schema: field.Type}, true
+		}
+	}
+	return schemaField{}, false
+}
+
+func marshal(in any) ([]byte, error) {
+	switch format {
+	case YamlFormat:
+		return yaml.Marshal(in)
+	case JsonFormat:
+		return json.Marshal(in)
+	case TomlFormat:
+		return toml.Marshal(in)
+	default:
+		return nil, errors.Errorf("overriding %s configuration is not supported", format)
+	}
+}
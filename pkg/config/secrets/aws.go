@@ -38,40 +38,43 @@ func (a AWSConfig) Validate() error {
 // Returns *secretsmanager.Client on success, or an error if client creation fails.
 
 func (a AWSConfig) CreateClient() (*secretsmanager.Client, error) {
-	ctx := context.Background()
+	cfg, err := loadAWSConfig(a.Region, a.Endpoint, a.AccessKeyID, a.SecretAccessKey)
+	if err != nil {
+		return nil, err
+	}
 
-	var cfg aws.Config
-	var err error
+	client := secretsmanager.NewFromConfig(cfg)
+	return client, nil
+}
 
+// loadAWSConfig builds the AWS SDK configuration shared by the AWS secret providers
+func loadAWSConfig(region, endpoint, accessKeyID, secretAccessKey string) (aws.Config, error) {
 	// Build config options
 	configOpts := []func(*config.LoadOptions) error{
-		config.WithRegion(a.Region),
+		config.WithRegion(region),
 	}
 
 	// Add custom endpoint if provided (for LocalStack or custom endpoints)
-	if a.Endpoint != "" {
-		configOpts = append(configOpts, config.WithBaseEndpoint(a.Endpoint))
+	if endpoint != "" {
+		configOpts = append(configOpts, config.WithBaseEndpoint(endpoint))
 	}
 
 	// Add credentials if provided; otherwise use default credential chain (IAM role, env vars, etc.)
-	if a.AccessKeyID != "" && a.SecretAccessKey != "" {
+	if accessKeyID != "" && secretAccessKey != "" {
 		configOpts = append(configOpts, config.WithCredentialsProvider(
 			credentials.NewStaticCredentialsProvider(
-				a.AccessKeyID,
-				a.SecretAccessKey,
+				accessKeyID,
+				secretAccessKey,
 				"",
 			),
 		))
 	}
 
-	cfg, err = config.LoadDefaultConfig(ctx, configOpts...)
-
+	cfg, err := config.LoadDefaultConfig(context.Background(), configOpts...)
 	if err != nil {
-		return nil, errors.Wrap(err, "failed to load AWS configuration")
+		return aws.Config{}, errors.Wrap(err, "failed to load AWS configuration")
 	}
-
-	client := secretsmanager.NewFromConfig(cfg)
-	return client, nil
+	return cfg, nil
 }
 
 // AWSSecretLoader retrieves secrets from AWS Secrets Manager.
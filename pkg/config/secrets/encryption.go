@@ -0,0 +1,134 @@
+package secrets
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// MasterKeyEnv is the environment variable holding the master key decrypting the encrypted
+// values of the configuration, encoded in base64.
+const MasterKeyEnv = "SARGANTANA_MASTER_KEY"
+
+// EncryptedPrefix starts the encrypted values of the configuration, e.g.
+// "ENC[AES256_GCM,bm9uY2U...]".
+const EncryptedPrefix = "ENC["
+
+// encryptionAlgorithm tags the encrypted values, to allow other algorithms later
+const encryptionAlgorithm = "AES256_GCM"
+
+// masterKeyLength is the length of the master key in bytes, for AES-256
+const masterKeyLength = 32
+
+// EncryptedValue matches the encrypted values of the configuration.
+var EncryptedValue = regexp.MustCompile(`ENC\[([A-Z0-9_]+),([A-Za-z0-9+/=]+)\]`)
+
+// ErrNoMasterKey is returned when decrypting without master key.
+var ErrNoMasterKey = errors.New("no master key is set, see " + MasterKeyEnv)
+
+var (
+	masterKeyMu sync.RWMutex
+	masterKey   []byte
+)
+
+// EncryptionConfig is the encryption configuration section, loading the master key from a secret
+// provider instead of the SARGANTANA_MASTER_KEY variable, e.g. from a KMS encrypted parameter with
+// master_key: ${ssm:/sargantana/master-key}.
+type EncryptionConfig struct {
+	// MasterKey is the master key encoded in base64.
+	MasterKey string `yaml:"master_key"`
+}
+
+func (c EncryptionConfig) Validate() error {
+	if c.MasterKey == "" {
+		return errors.New("master_key must be set and non-empty")
+	}
+	_, err := ParseMasterKey(c.MasterKey)
+	return err
+}
+
+// ParseMasterKey decodes a master key encoded in base64, e.g. generated with
+// "openssl rand -base64 32".
+func ParseMasterKey(encoded string) ([]byte, error) {
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil {
+		return nil, errors.Wrap(err, "master key is not valid base64")
+	}
+	if len(key) != masterKeyLength {
+		return nil, errors.Errorf("master key must have %d bytes, got %d", masterKeyLength, len(key))
+	}
+	return key, nil
+}
+
+// SetMasterKey sets the master key used by Encrypt and Decrypt, or unsets it with nil.
+func SetMasterKey(key []byte) error {
+	if key != nil && len(key) != masterKeyLength {
+		return errors.Errorf("master key must have %d bytes, got %d", masterKeyLength, len(key))
+	}
+	masterKeyMu.Lock()
+	defer masterKeyMu.Unlock()
+	masterKey = key
+	return nil
+}
+
+func currentCipher() (cipher.AEAD, error) {
+	masterKeyMu.RLock()
+	key := masterKey
+	masterKeyMu.RUnlock()
+	if key == nil {
+		return nil, ErrNoMasterKey
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid master key")
+	}
+	return cipher.NewGCM(block)
+}
+
+// Encrypt encrypts a configuration value with the master key, returning it as
+// ENC[AES256_GCM,{base64 of the nonce and the ciphertext}].
+func Encrypt(plaintext string) (string, error) {
+	aead, err := currentCipher()
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", errors.Wrap(err, "failed to generate nonce")
+	}
+	sealed := aead.Seal(nonce, nonce, []byte(plaintext), nil)
+	return EncryptedPrefix + encryptionAlgorithm + "," + base64.StdEncoding.EncodeToString(sealed) + "]", nil
+}
+
+// Decrypt decrypts a value encrypted by Encrypt with the master key.
+func Decrypt(value string) (string, error) {
+	match := EncryptedValue.FindStringSubmatch(value)
+	if match == nil || match[0] != value {
+		return "", errors.New("malformed encrypted value")
+	}
+	if match[1] != encryptionAlgorithm {
+		return "", errors.Errorf("unsupported encryption algorithm %q", match[1])
+	}
+	sealed, err := base64.StdEncoding.DecodeString(match[2])
+	if err != nil {
+		return "", errors.Wrap(err, "malformed encrypted value")
+	}
+	aead, err := currentCipher()
+	if err != nil {
+		return "", err
+	}
+	if len(sealed) < aead.NonceSize() {
+		return "", errors.New("malformed encrypted value")
+	}
+	plaintext, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], nil)
+	if err != nil {
+		return "", errors.New("failed to decrypt value, the master key may be wrong")
+	}
+	return string(plaintext), nil
+}
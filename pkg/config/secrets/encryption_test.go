@@ -0,0 +1,75 @@
+//go:build unit
+
+package secrets
+
+import (
+	"bytes"
+	"encoding/base64"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Encryption", func() {
+	key := bytes.Repeat([]byte{7}, masterKeyLength)
+
+	BeforeEach(func() {
+		Expect(SetMasterKey(key)).To(Succeed())
+		DeferCleanup(func() { _ = SetMasterKey(nil) })
+	})
+
+	It("should parse base64 master keys of 32 bytes", func() {
+		Expect(ParseMasterKey(base64.StdEncoding.EncodeToString(key) + "\n")).To(Equal(key))
+		_, err := ParseMasterKey(base64.StdEncoding.EncodeToString(key[:16]))
+		Expect(err).To(MatchError(ContainSubstring("32 bytes")))
+		_, err = ParseMasterKey("not base64!")
+		Expect(err).To(MatchError(ContainSubstring("base64")))
+		Expect(SetMasterKey(key[:16])).To(MatchError(ContainSubstring("32 bytes")))
+
+		Expect(EncryptionConfig{}.Validate()).To(MatchError(ContainSubstring("master_key")))
+		Expect(EncryptionConfig{MasterKey: base64.StdEncoding.EncodeToString(key)}.Validate()).To(Succeed())
+	})
+
+	It("should decrypt the encrypted values", func() {
+		encrypted, err := Encrypt("s3cr3t")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(encrypted).To(HavePrefix("ENC[AES256_GCM,"))
+		Expect(EncryptedValue.FindString(encrypted)).To(Equal(encrypted))
+		Expect(Decrypt(encrypted)).To(Equal("s3cr3t"))
+
+		// Every encryption uses a new nonce
+		Expect(Encrypt("s3cr3t")).NotTo(Equal(encrypted))
+	})
+
+	It("should reject values encrypted with another key, tampered or malformed", func() {
+		encrypted, err := Encrypt("s3cr3t")
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(SetMasterKey(bytes.Repeat([]byte{8}, masterKeyLength))).To(Succeed())
+		_, err = Decrypt(encrypted)
+		Expect(err).To(MatchError(ContainSubstring("master key may be wrong")))
+
+		Expect(SetMasterKey(key)).To(Succeed())
+		sealed, _ := base64.StdEncoding.DecodeString(EncryptedValue.FindStringSubmatch(encrypted)[2])
+		sealed[len(sealed)-1] ^= 1
+		_, err = Decrypt("ENC[AES256_GCM," + base64.StdEncoding.EncodeToString(sealed) + "]")
+		Expect(err).To(HaveOccurred())
+
+		_, err = Decrypt("ENC[AES256_GCM,AAAA]")
+		Expect(err).To(MatchError(ContainSubstring("malformed")))
+		_, err = Decrypt("ENC[ROT13,AAAA]")
+		Expect(err).To(MatchError(ContainSubstring("unsupported encryption algorithm")))
+		_, err = Decrypt("plain")
+		Expect(err).To(MatchError(ContainSubstring("malformed")))
+	})
+
+	It("should fail without master key", func() {
+		encrypted, err := Encrypt("s3cr3t")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(SetMasterKey(nil)).To(Succeed())
+		_, err = Decrypt(encrypted)
+		Expect(err).To(MatchError(ErrNoMasterKey))
+		_, err = Encrypt("s3cr3t")
+		Expect(err).To(MatchError(ErrNoMasterKey))
+	})
+})
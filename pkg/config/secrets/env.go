@@ -2,10 +2,16 @@ package secrets
 
 import (
 	"os"
+	"strings"
 
+	"github.com/pkg/errors"
 	"github.com/rs/zerolog/log"
 )
 
+// FileEnvSuffix is the suffix of environment variables holding the path of a file with the value
+// of the variable without suffix, following the common container image convention.
+const FileEnvSuffix = "_FILE"
+
 // EnvLoader resolves properties from environment variables.
 // This is the default resolver when no prefix is specified.
 //
@@ -13,6 +19,9 @@ import (
 //
 //	address: ${PORT}           # Resolves from env (implicit)
 //	address: ${env:PORT}       # Resolves from env (explicit)
+//
+// When a variable is not set but the same variable with the "_FILE" suffix is, the value is read
+// from the file it points at, such as DB_PASSWORD_FILE=/run/secrets/db_password.
 type EnvLoader struct{}
 
 // NewEnvLoader creates a new environment variable resolver
@@ -22,7 +31,12 @@ func NewEnvLoader() *EnvLoader {
 
 // Resolve retrieves an environment variable value
 func (e *EnvLoader) Resolve(key string) (string, error) {
-	value := os.Getenv(key)
+	value, ok := os.LookupEnv(key)
+	if !ok {
+		if path := os.Getenv(key + FileEnvSuffix); path != "" {
+			return readEnvFile(key, path)
+		}
+	}
 
 	// Warn about missing environment variables to prevent silent failures
 	// Note: We don't treat empty/missing as an error to maintain Go's os.Expand behavior
@@ -43,3 +57,17 @@ func (e *EnvLoader) Resolve(key string) (string, error) {
 func (e *EnvLoader) Name() string {
 	return "Environment"
 }
+
+// readEnvFile reads the value of an environment variable from the file pointed at by its "_FILE"
+// counterpart. The file contents are trimmed of whitespace.
+func readEnvFile(key, path string) (string, error) {
+	// #nosec G304 -- The path is provided by the operator through the environment, this is intentional
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return "", errors.Wrapf(err, "failed to read %s%s file", key, FileEnvSuffix)
+	}
+	log.Debug().
+		Str("env_var", key+FileEnvSuffix).
+		Msg("Retrieved value from file pointed by environment variable")
+	return strings.TrimSpace(string(content)), nil
+}
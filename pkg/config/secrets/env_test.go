@@ -4,6 +4,7 @@ package secrets
 
 import (
 	"os"
+	"path/filepath"
 
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
@@ -25,6 +26,32 @@ var _ = Describe("EnvLoader", func() {
 		Expect(val).To(Equal("test-value"))
 	})
 
+	It("should read the file pointed by the _FILE variable when the variable is not set", func() {
+		path := filepath.Join(GinkgoT().TempDir(), "db_password")
+		Expect(os.WriteFile(path, []byte("s3cr3t\n"), 0600)).To(Succeed())
+		GinkgoT().Setenv("TEST_DB_PASSWORD_FILE", path)
+
+		val, err := loader.Resolve("TEST_DB_PASSWORD")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(val).To(Equal("s3cr3t"))
+	})
+
+	It("should prefer the variable over the _FILE variable", func() {
+		GinkgoT().Setenv("TEST_API_KEY", "from-env")
+		GinkgoT().Setenv("TEST_API_KEY_FILE", "/nonexistent/api_key")
+
+		val, err := loader.Resolve("TEST_API_KEY")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(val).To(Equal("from-env"))
+	})
+
+	It("should fail when the _FILE variable points at a missing file", func() {
+		GinkgoT().Setenv("TEST_TOKEN_FILE", "/nonexistent/token")
+
+		_, err := loader.Resolve("TEST_TOKEN")
+		Expect(err).To(MatchError(ContainSubstring("TEST_TOKEN_FILE")))
+	})
+
 	It("should return empty string if env var not set", func() {
 		val, err := loader.Resolve("NON_EXISTENT_VAR")
 		Expect(err).NotTo(HaveOccurred())
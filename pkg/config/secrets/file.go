@@ -8,37 +8,50 @@ import (
 	"github.com/pkg/errors"
 )
 
+// DefaultSecretsDir is the conventional mount path of Docker and Kubernetes secrets, used when
+// secrets_dir is not set.
+const DefaultSecretsDir = "/run/secrets"
+
 // FileSecretConfig holds configuration for the file-based resolver
 type FileSecretConfig struct {
+	// SecretsDir is the directory containing secret files, defaults to DefaultSecretsDir.
 	SecretsDir string `yaml:"secrets_dir"`
 }
 
-// Validate checks if the FileSecretConfig has all required fields set
+// Validate checks if the secrets directory exists
 func (f FileSecretConfig) Validate() error {
-	if f.SecretsDir == "" {
-		return errors.New("secrets_dir is required for file resolver")
-	}
+	secretsDir := f.secretsDir()
 
 	// Test if the directory exists and is a directory
-	info, err := os.Stat(f.SecretsDir)
+	info, err := os.Stat(secretsDir)
 	if os.IsNotExist(err) {
-		return errors.Errorf("secrets_dir %q does not exist", f.SecretsDir)
+		return errors.Errorf("secrets_dir %q does not exist", secretsDir)
 	}
 	if err != nil {
-		return errors.Wrapf(err, "error accessing secrets_dir %q", f.SecretsDir)
+		return errors.Wrapf(err, "error accessing secrets_dir %q", secretsDir)
 	}
 	if !info.IsDir() {
-		return errors.Errorf("secrets_dir %q is not a directory", f.SecretsDir)
+		return errors.Errorf("secrets_dir %q is not a directory", secretsDir)
 	}
 	return nil
 }
 
+func (f FileSecretConfig) secretsDir() string {
+	if f.SecretsDir == "" {
+		return DefaultSecretsDir
+	}
+	return f.SecretsDir
+}
+
 // CreateClient creates a FileSecretLoader from this config.
 // Implements the config.ClientFactory[*FileSecretLoader] interface.
 // Returns *FileSecretLoader on success, or an error if creation fails.
 
 func (f FileSecretConfig) CreateClient() (*FileSecretLoader, error) {
-	return NewFileSecretLoader(f.SecretsDir)
+	if err := f.Validate(); err != nil {
+		return nil, err
+	}
+	return NewFileSecretLoader(f.secretsDir())
 }
 
 // FileSecretLoader reads secrets from files in a configured directory.
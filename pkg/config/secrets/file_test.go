@@ -24,11 +24,14 @@ var _ = Describe("FileSecretLoader", func() {
 	})
 
 	Context("Validate", func() {
-		It("should return error if secrets_dir is empty", func() {
+		It("should default to the conventional secrets mount if secrets_dir is empty", func() {
+			if _, err := os.Stat(DefaultSecretsDir); err == nil {
+				Skip("the default secrets directory exists on this host")
+			}
 			cfg := FileSecretConfig{}
 			err := cfg.Validate()
 			Expect(err).To(HaveOccurred())
-			Expect(err.Error()).To(ContainSubstring("secrets_dir is required"))
+			Expect(err.Error()).To(ContainSubstring(DefaultSecretsDir))
 		})
 
 		It("should return error if secrets_dir does not exist", func() {
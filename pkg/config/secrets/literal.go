@@ -0,0 +1,14 @@
+package secrets
+
+// LiteralLoader resolves properties to their key, providing default values as the last
+// alternative of a property.
+//
+// Example usage in config:
+//
+//	address: ${env:ADDRESS|literal::8080}   # Uses :8080 when ADDRESS is not set
+type LiteralLoader struct{}
+
+// Resolve returns the key itself
+func (LiteralLoader) Resolve(key string) (string, error) {
+	return key, nil
+}
@@ -1,10 +1,16 @@
 package secrets
 
 import (
+	"strings"
+
 	"github.com/pkg/errors"
 	"github.com/rs/zerolog/log"
 )
 
+// AlternativeSeparator separates the alternatives of a property, tried in order until one
+// resolves to a non-empty value, e.g. "vault:KEY|env:KEY|literal:default".
+const AlternativeSeparator = "|"
+
 // SecretLoader defines the interface that all secret providers must implement.
 // A provider is responsible for retrieving a secret value based on a key.
 //
@@ -30,8 +36,17 @@ type SecretLoader interface {
 // It provides a thread-safe registry for associating prefixes with their providers.
 var providers = make(map[string]SecretLoader)
 
+// lenient tells whether unresolvable placeholders are replaced with empty strings instead of
+// failing the configuration loading.
+var lenient = false
+
+// fallbackChain holds the prefixes tried in order for properties without prefix. Prefixes without
+// registered provider are skipped. When empty, such properties are resolved from the environment.
+var fallbackChain []string
+
 func init() {
 	Register("env", NewEnvLoader())
+	Register("literal", LiteralLoader{})
 }
 
 // Register registers a secret provider for a specific prefix.
@@ -53,22 +68,139 @@ func Register(prefix string, provider SecretLoader) {
 	providers[prefix] = provider
 }
 
+// Resolution modes accepted in ResolutionConfig.Mode.
+const (
+	// ModeStrict fails the configuration loading when a placeholder cannot be resolved. It is the default.
+	ModeStrict = "strict"
+	// ModeLenient logs unresolvable placeholders and replaces them with empty strings.
+	ModeLenient = "lenient"
+)
+
+// ResolutionConfig holds the global resolution settings, read from the "secrets" section.
+type ResolutionConfig struct {
+	// Fallback lists the prefixes tried in order for properties without prefix, see
+	// SetFallbackChain.
+	Fallback []string `yaml:"fallback"`
+	// Mode is either "strict" (default) or "lenient", see SetLenient.
+	Mode string `yaml:"mode"`
+}
+
+// Validate checks the mode and that the prefixes of the fallback chain are non-empty and unique
+func (c ResolutionConfig) Validate() error {
+	switch c.Mode {
+	case "", ModeStrict, ModeLenient:
+	default:
+		return errors.Errorf("unsupported mode %q, must be %q or %q", c.Mode, ModeStrict, ModeLenient)
+	}
+	seen := make(map[string]bool, len(c.Fallback))
+	for _, prefix := range c.Fallback {
+		if prefix == "" {
+			return errors.New("fallback prefixes must be non-empty")
+		}
+		if seen[prefix] {
+			return errors.Errorf("fallback prefix %q is listed more than once", prefix)
+		}
+		seen[prefix] = true
+	}
+	return nil
+}
+
+// SetLenient switches between the strict mode (the default), where configuration loading reports
+// all the unresolvable placeholders as one error, and the lenient mode, where they are logged and
+// replaced with empty strings.
+func SetLenient(enabled bool) {
+	lenient = enabled
+}
+
+// Lenient tells whether the lenient mode is enabled, see SetLenient.
+func Lenient() bool {
+	return lenient
+}
+
+// SetFallbackChain sets the prefixes tried in order for properties without prefix, so that the
+// same configuration resolves ${KEY} from Vault in production and from the environment in
+// development. Prefixes without registered provider are skipped. An empty chain restores the
+// default, which resolves such properties from the environment.
+//
+// Example:
+//
+//	secrets.SetFallbackChain([]string{"vault", "env"})
+func SetFallbackChain(prefixes []string) {
+	fallbackChain = append([]string(nil), prefixes...)
+}
+
 // Resolve attempts to resolve a secret using the appropriate provider.
-// The input should be in the format "prefix:key" or just "key" (defaults to env).
+// The input should be in the format "prefix:key" or just "key" (defaults to env, or to the
+// fallback chain if set). Several alternatives can be given, separated by "|": they are tried in
+// order and the first non-empty value wins, the last alternative being returned even if empty.
 //
 // Examples:
 //   - "vault:DATABASE_PASSWORD" -> Uses Vault provider
 //   - "file:api_key" -> Uses File provider
 //   - "env:PORT" -> Uses Environment provider (explicit)
 //   - "PORT" -> Uses Environment provider (implicit, no prefix)
+//   - "vault:PORT|env:PORT|literal:8080" -> Uses Vault, then Environment, then the literal value
 //
 // Returns:
 //   - string: The resolved secret value
 //   - error: An error if no provider is found or resolution fails
 func Resolve(property string) (string, error) {
-	// Parse prefix and key
-	prefix, key := parseProperty(property)
+	value, _, err := ResolveSource(property)
+	return value, err
+}
+
+// ResolveSource resolves a property like Resolve, also returning the prefix of the provider the
+// value came from, e.g. "vault" for "vault:KEY|env:KEY" when Vault has the key.
+func ResolveSource(property string) (value string, source string, err error) {
+	alternatives := strings.Split(property, AlternativeSeparator)
+	if len(alternatives) == 1 {
+		return resolveProperty(property)
+	}
+
+	var lastErr error
+	for i, alternative := range alternatives {
+		value, source, err := resolveProperty(alternative)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		if value != "" || i == len(alternatives)-1 {
+			return value, source, nil
+		}
+		log.Debug().Str("alternative", alternative).Msg("Secret alternative resolved to an empty value, trying the next one")
+	}
+	return "", "", errors.Wrapf(lastErr, "no alternative of %q could be resolved", property)
+}
+
+// resolveProperty resolves a single alternative, using the fallback chain if it has no prefix.
+func resolveProperty(property string) (string, string, error) {
+	if len(fallbackChain) == 0 || strings.Contains(property, ":") {
+		prefix, key := parseProperty(property)
+		value, err := resolveWith(prefix, key, property)
+		return value, prefix, err
+	}
+
+	var lastErr error
+	for i, prefix := range fallbackChain {
+		if _, exists := providers[prefix]; !exists {
+			continue
+		}
+		value, err := resolveWith(prefix, property, property)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		if value != "" || i == len(fallbackChain)-1 {
+			return value, prefix, nil
+		}
+	}
+	if lastErr == nil {
+		return "", "", errors.Errorf("no provider of the fallback chain %v is registered or resolves %q", fallbackChain, property)
+	}
+	return "", "", errors.Wrapf(lastErr, "no provider of the fallback chain %v resolves %q", fallbackChain, property)
+}
 
+func resolveWith(prefix, key, property string) (string, error) {
 	// Look up the provider
 	provider, exists := providers[prefix]
 	if !exists {
@@ -65,4 +65,70 @@ var _ = Describe("Resolver", func() {
 		Expect(err).NotTo(HaveOccurred())
 		Expect(val).To(Equal("value1"))
 	})
+
+	Context("Alternatives", func() {
+		It("should return the first alternative that resolves", func() {
+			val, err := Resolve("unknown:key1|test:key2|test:key1|literal:default")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(val).To(Equal("value1"))
+		})
+
+		It("should fall back to the literal value", func() {
+			val, err := Resolve("test:key2|literal:default:with:colons")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(val).To(Equal("default:with:colons"))
+		})
+
+		It("should skip alternatives resolving to empty values", func() {
+			mockLoader.Secrets["empty"] = ""
+			val, err := Resolve("test:empty|literal:default")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(val).To(Equal("default"))
+		})
+
+		It("should fail when no alternative resolves", func() {
+			_, err := Resolve("test:key2|unknown:key1")
+			Expect(err).To(MatchError(ContainSubstring("no alternative of")))
+		})
+	})
+
+	Context("Fallback chain", func() {
+		AfterEach(func() {
+			SetFallbackChain(nil)
+		})
+
+		It("should try the chain in order for properties without prefix", func() {
+			Register("other", &MockLoader{Secrets: map[string]string{"key1": "other", "key3": "value3"}})
+			SetFallbackChain([]string{"unregistered", "test", "other"})
+
+			val, err := Resolve("key1")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(val).To(Equal("value1"))
+
+			val, err = Resolve("key3")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(val).To(Equal("value3"))
+		})
+
+		It("should not apply to properties with prefix", func() {
+			SetFallbackChain([]string{"test"})
+			val, err := Resolve("literal:key1")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(val).To(Equal("key1"))
+		})
+
+		It("should fail when no provider of the chain resolves the property", func() {
+			SetFallbackChain([]string{"unregistered", "test"})
+			_, err := Resolve("key2")
+			Expect(err).To(MatchError(ContainSubstring("fallback chain")))
+		})
+	})
+
+	It("should validate the chain configuration", func() {
+		Expect(ResolutionConfig{Fallback: []string{"vault", "env"}}.Validate()).To(Succeed())
+		Expect(ResolutionConfig{Fallback: []string{"vault", ""}}.Validate()).To(HaveOccurred())
+		Expect(ResolutionConfig{Fallback: []string{"env", "env"}}.Validate()).To(HaveOccurred())
+		Expect(ResolutionConfig{Mode: ModeLenient}.Validate()).To(Succeed())
+		Expect(ResolutionConfig{Mode: "relaxed"}.Validate()).To(HaveOccurred())
+	})
 })
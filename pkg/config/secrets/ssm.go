@@ -0,0 +1,106 @@
+package secrets
+
+import (
+	"context"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+)
+
+// SSMConfig holds configuration for AWS Systems Manager Parameter Store
+type SSMConfig struct {
+	Region          string `yaml:"region"`
+	AccessKeyID     string `yaml:"access_key_id"`
+	SecretAccessKey string `yaml:"secret_access_key"`
+	Endpoint        string `yaml:"endpoint"` // Optional: for LocalStack or custom endpoints
+	// Path is prepended to the keys, e.g. "/myapp/prod" resolves ${ssm:db/host} from the
+	// "/myapp/prod/db/host" parameter. Without path, keys are full parameter names.
+	Path string `yaml:"path"`
+	// Decrypt decrypts SecureString parameters, defaults to true.
+	Decrypt *bool `yaml:"decrypt"`
+}
+
+// Validate checks if the SSMConfig has all required fields set
+func (s SSMConfig) Validate() error {
+	if s.Region == "" {
+		return errors.New("AWS region is required")
+	}
+	// AccessKeyID and SecretAccessKey are optional - if not provided, will use IAM role or default credentials
+	return nil
+}
+
+// CreateClient creates and configures an AWS Systems Manager client from this config.
+// Implements the config.ClientFactory[*ssm.Client] interface.
+// Returns *ssm.Client on success, or an error if client creation fails.
+func (s SSMConfig) CreateClient() (*ssm.Client, error) {
+	cfg, err := loadAWSConfig(s.Region, s.Endpoint, s.AccessKeyID, s.SecretAccessKey)
+	if err != nil {
+		return nil, err
+	}
+	return ssm.NewFromConfig(cfg), nil
+}
+
+// SSMParameterLoader retrieves parameters from AWS Systems Manager Parameter Store.
+// String, StringList and SecureString parameters are supported, StringList values are
+// returned as stored (comma-separated).
+//
+// Example usage in config:
+//
+//	log_level: ${ssm:log_level}          # Reads <path>/log_level
+//	password: ${ssm:/shared/db/password} # Reads an absolute name when no path is configured
+type SSMParameterLoader struct {
+	client  *ssm.Client
+	path    string
+	decrypt bool
+}
+
+// NewSSMParameterLoader creates a new Parameter Store-based resolver
+//
+// Parameters:
+//   - client: Configured AWS Systems Manager client
+//   - path: The path prefix of the parameters, may be empty
+//   - decrypt: Whether SecureString parameters are decrypted
+func NewSSMParameterLoader(client *ssm.Client, path string, decrypt bool) *SSMParameterLoader {
+	return &SSMParameterLoader{
+		client:  client,
+		path:    strings.TrimSuffix(path, "/"),
+		decrypt: decrypt,
+	}
+}
+
+// NewSSMParameterLoaderFromConfig creates a Parameter Store-based resolver with the path and
+// decryption settings of cfg.
+func NewSSMParameterLoaderFromConfig(client *ssm.Client, cfg SSMConfig) *SSMParameterLoader {
+	decrypt := cfg.Decrypt == nil || *cfg.Decrypt
+	return NewSSMParameterLoader(client, cfg.Path, decrypt)
+}
+
+// Resolve retrieves a parameter from AWS Systems Manager Parameter Store
+func (s *SSMParameterLoader) Resolve(key string) (string, error) {
+	if key == "" {
+		return "", errors.New("no parameter specified for ssm secret")
+	}
+	name := key
+	if s.path != "" {
+		name = s.path + "/" + strings.TrimPrefix(key, "/")
+	}
+
+	result, err := s.client.GetParameter(context.Background(), &ssm.GetParameterInput{
+		Name:           aws.String(name),
+		WithDecryption: aws.Bool(s.decrypt),
+	})
+	if err != nil {
+		return "", errors.Wrapf(err, "failed to read parameter from AWS Parameter Store: %q", name)
+	}
+	if result.Parameter == nil || result.Parameter.Value == nil {
+		return "", errors.Errorf("parameter %q has no value", name)
+	}
+
+	log.Debug().
+		Str("parameter", name).
+		Msg("Retrieved parameter from AWS Parameter Store")
+	return *result.Parameter.Value, nil
+}
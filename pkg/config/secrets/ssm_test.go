@@ -0,0 +1,101 @@
+//go:build unit
+
+package secrets
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("AWS Parameter Store", func() {
+	Context("SSMConfig Validate", func() {
+		It("should return error if region is empty", func() {
+			err := SSMConfig{Path: "/myapp"}.Validate()
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("AWS region is required"))
+		})
+
+		It("should pass with only a region", func() {
+			Expect(SSMConfig{Region: "us-east-1"}.Validate()).To(Succeed())
+		})
+	})
+
+	Context("Resolve", func() {
+		var (
+			parameters map[string]string
+			requests   []map[string]any
+			endpoint   *httptest.Server
+		)
+
+		BeforeEach(func() {
+			parameters = map[string]string{
+				"/myapp/prod/db/host": "db.internal",
+				"/shared/region":      "eu-west-1",
+			}
+			requests = nil
+			endpoint = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				defer GinkgoRecover()
+				Expect(r.Header.Get("X-Amz-Target")).To(Equal("AmazonSSM.GetParameter"))
+				var input map[string]any
+				Expect(json.NewDecoder(r.Body).Decode(&input)).To(Succeed())
+				requests = append(requests, input)
+
+				w.Header().Set("Content-Type", "application/x-amz-json-1.1")
+				value, ok := parameters[input["Name"].(string)]
+				if !ok {
+					w.WriteHeader(http.StatusBadRequest)
+					_, _ = w.Write([]byte(`{"__type":"ParameterNotFound","message":"not found"}`))
+					return
+				}
+				_ = json.NewEncoder(w).Encode(map[string]any{
+					"Parameter": map[string]any{"Name": input["Name"], "Type": "String", "Value": value},
+				})
+			}))
+		})
+
+		AfterEach(func() {
+			endpoint.Close()
+		})
+
+		newLoader := func(cfg SSMConfig) *SSMParameterLoader {
+			cfg.Region = "us-east-1"
+			cfg.AccessKeyID = "test"
+			cfg.SecretAccessKey = "test"
+			cfg.Endpoint = endpoint.URL
+			client, err := cfg.CreateClient()
+			Expect(err).NotTo(HaveOccurred())
+			return NewSSMParameterLoaderFromConfig(client, cfg)
+		}
+
+		It("should prepend the path and decrypt by default", func() {
+			loader := newLoader(SSMConfig{Path: "/myapp/prod/"})
+
+			value, err := loader.Resolve("db/host")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(value).To(Equal("db.internal"))
+			Expect(requests).To(HaveLen(1))
+			Expect(requests[0]).To(HaveKeyWithValue("WithDecryption", true))
+		})
+
+		It("should resolve full names without path", func() {
+			decrypt := false
+			loader := newLoader(SSMConfig{Decrypt: &decrypt})
+
+			value, err := loader.Resolve("/shared/region")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(value).To(Equal("eu-west-1"))
+			Expect(requests[0]).To(HaveKeyWithValue("WithDecryption", false))
+		})
+
+		It("should fail for missing parameters", func() {
+			loader := newLoader(SSMConfig{Path: "/myapp/prod"})
+
+			_, err := loader.Resolve("missing")
+			Expect(err).To(MatchError(ContainSubstring("/myapp/prod/missing")))
+		})
+	})
+})
@@ -1,15 +1,22 @@
 package controller
 
 import (
-	"encoding/gob"
+	"context"
 	"fmt"
+	"maps"
 	"net/http"
 	"net/url"
+	"path"
+	"slices"
 	"strings"
 	"time"
 
 	"github.com/animalet/sargantana-go/internal/snapshot"
+	"github.com/animalet/sargantana-go/pkg/controller/consent"
+	"github.com/animalet/sargantana-go/pkg/openapi"
+	"github.com/animalet/sargantana-go/pkg/routes"
 	"github.com/animalet/sargantana-go/pkg/server"
+	"github.com/animalet/sargantana-go/pkg/sessionsx"
 	"github.com/gin-contrib/sessions"
 	"github.com/gin-gonic/gin"
 	"github.com/markbates/goth"
@@ -96,6 +103,21 @@ type AuthControllerConfig struct {
 	RedirectOnLogin  string                    `yaml:"redirect_on_login"`
 	RedirectOnLogout string                    `yaml:"redirect_on_logout"`
 	Providers        map[string]ProviderConfig `yaml:"providers"`
+	// VerifyProviders checks at startup that the credentials of the providers are well-formed
+	// and their endpoints reachable, reporting the results in the startup diagnostics.
+	VerifyProviders bool `yaml:"verify_providers,omitempty"`
+	// ConsentTemplate renders a consent screen on the login path before redirecting to the
+	// provider, with the provider, its scopes and the continue_url resuming the login. Optional.
+	ConsentTemplate string `yaml:"consent_template,omitempty"`
+	// State configures the session keeping the OAuth state between the login and the callback,
+	// see AuthStateConfig. Optional.
+	State *AuthStateConfig `yaml:"state,omitempty"`
+	// Reauth serves the silent re-authentication of the users with the provider of their last
+	// login, see AuthReauthConfig. Optional.
+	Reauth *AuthReauthConfig `yaml:"reauth,omitempty"`
+	// UserCache caches the profiles of the users fetched from the providers, see
+	// AuthUserCacheConfig. Optional.
+	UserCache *AuthUserCacheConfig `yaml:"user_cache,omitempty"`
 }
 
 func (a AuthControllerConfig) Validate() error {
@@ -120,6 +142,21 @@ func (a AuthControllerConfig) Validate() error {
 	if a.RedirectOnLogout == "" {
 		return errors.New("redirect_on_logout must be set and non-empty")
 	}
+	if a.State != nil {
+		if err := a.State.Validate(); err != nil {
+			return errors.Wrap(err, "invalid state configuration")
+		}
+	}
+	if a.Reauth != nil {
+		if err := a.Reauth.Validate(); err != nil {
+			return errors.Wrap(err, "invalid reauth configuration")
+		}
+	}
+	if a.UserCache != nil {
+		if err := a.UserCache.Validate(); err != nil {
+			return errors.Wrap(err, "invalid user_cache configuration")
+		}
+	}
 	for name, provider := range a.Providers {
 		if name == "wecom" {
 			if provider.CorpID == "" {
@@ -147,6 +184,88 @@ func (a AuthControllerConfig) Validate() error {
 	return nil
 }
 
+// Routes returns the routes of the controller, to build its URLs, see routes.Routes. The base path
+// of the controller binding, if any, must be set.
+func (a AuthControllerConfig) Routes() routes.Routes {
+	r := routes.Routes{
+		Origin:   a.CallbackHost,
+		Login:    a.LoginPath,
+		Callback: a.CallbackPath,
+		Logout:   a.LogoutPath,
+		UserInfo: a.UserInfoPath,
+	}
+	if a.Reauth != nil {
+		r.Reauth = a.Reauth.Path
+	}
+	return r
+}
+
+// Describe adds the routes of the controller to the OpenAPI document, see openapi.Describer.
+func (a AuthControllerConfig) Describe(doc *openapi.Document, basePath string) {
+	tags := []string{"auth"}
+	provider := openapi.Parameter{
+		Name:     "provider",
+		In:       "path",
+		Required: true,
+		Schema:   &openapi.Schema{Type: "string", Enum: slices.Sorted(maps.Keys(a.Providers))},
+	}
+	callback := openapi.Operation{
+		OperationID: "callback",
+		Summary:     "Completes the login with the provider",
+		Tags:        tags,
+		Parameters:  []openapi.Parameter{provider},
+		Responses:   openapi.With(openapi.Redirect("Redirection to redirect_on_login"), openapi.Errors(http.StatusUnauthorized)),
+	}
+	formPostCallback := callback
+	formPostCallback.OperationID = "formPostCallback"
+	formPostCallback.Summary = "Completes the login with the providers posting their callbacks"
+
+	doc.Add(http.MethodGet, basePath, a.LoginPath, openapi.Operation{
+		OperationID: "login",
+		Summary:     "Starts the login with the provider",
+		Tags:        tags,
+		Parameters:  []openapi.Parameter{provider},
+		Responses:   openapi.Redirect("Redirection to the provider"),
+	})
+	doc.Add(http.MethodGet, basePath, a.CallbackPath, callback)
+	doc.Add(http.MethodPost, basePath, a.CallbackPath, formPostCallback)
+	doc.Add(http.MethodGet, basePath, a.LogoutPath, openapi.Operation{
+		OperationID: "logout",
+		Summary:     "Signs the user out",
+		Tags:        tags,
+		Responses:   openapi.Redirect("Redirection to redirect_on_logout"),
+	})
+	doc.Add(http.MethodGet, basePath, a.UserInfoPath, openapi.Operation{
+		OperationID: "getUser",
+		Summary:     "Returns the signed-in user",
+		Tags:        tags,
+		Responses: openapi.With(map[string]openapi.Response{
+			"200": {Description: "The user of the session", Content: openapi.JSON(doc.Schema("UserObject", UserObject{}))},
+		}, openapi.Errors(http.StatusUnauthorized)),
+	})
+	if a.Reauth != nil {
+		provider := provider
+		provider.In, provider.Required = "query", false
+		doc.Add(http.MethodGet, basePath, a.Reauth.Path, openapi.Operation{
+			OperationID: "reauth",
+			Summary:     "Renews the session silently, with the provider of the last login by default",
+			Tags:        tags,
+			Parameters:  []openapi.Parameter{provider},
+			Responses:   openapi.Redirect("Redirection to the provider, or to redirect_on_failure"),
+		})
+	}
+	if a.UserCache != nil && a.UserCache.RefreshPath != "" {
+		doc.Add(http.MethodPost, basePath, a.UserCache.RefreshPath, openapi.Operation{
+			OperationID: "refreshUser",
+			Summary:     "Fetches the profile of the signed-in user from the provider again",
+			Tags:        tags,
+			Responses: openapi.With(map[string]openapi.Response{
+				"200": {Description: "The refreshed user of the session", Content: openapi.JSON(doc.Schema("UserObject", UserObject{}))},
+			}, openapi.Errors(http.StatusUnauthorized, http.StatusConflict, http.StatusBadGateway)),
+		})
+	}
+}
+
 func NewAuthController(c *AuthControllerConfig, ctx server.ControllerContext) (server.IController, error) {
 	var callbackEndpoint string
 	if c.CallbackHost != "" {
@@ -169,23 +288,46 @@ func NewAuthController(c *AuthControllerConfig, ctx server.ControllerContext) (s
 	}
 
 	callbackPath := c.CallbackPath
-	callbackURLTemplate := callbackEndpoint + "/" + strings.TrimPrefix(callbackPath, "/")
+	// The callback is served below the base path of the binding, if any
+	callbackURLTemplate := callbackEndpoint + path.Join("/", ctx.BasePath, callbackPath)
 
-	gob.Register(UserObject{})
+	providerConfigs := *snapshot.MustCopy(&c.Providers)
 	providerFactory := ProviderFactory
 	if providerFactory == nil {
-		providerFactory = &configProviderFactory{config: *snapshot.MustCopy(&c.Providers)}
+		providerFactory = &configProviderFactory{config: providerConfigs}
 	}
 	providers := providerFactory.CreateProviders(callbackURLTemplate)
 	if len(providers) > 0 {
 		goth.UseProviders(providers...)
 	}
 
-	// Set the gothic store if available in controller context
+	// Keep the OAuth state in the session store of the server, if available in controller context
 	if ctx.SessionStore != nil {
-		gothic.Store = ctx.SessionStore
+		var state AuthStateConfig
+		if c.State != nil {
+			state = *c.State
+		}
+		useStateStore(ctx.SessionStore, state)
 		log.Debug().Msg("Auth controller: gothic.Store configured from controller context")
 	}
+	if names := formPostProviders(providerConfigs); len(names) > 0 && (c.State == nil || !strings.EqualFold(c.State.SameSite, "none")) {
+		// Lax and strict cookies are not sent with cross-site POST requests
+		log.Warn().Strs("providers", names).Msg("Auth controller: providers post their callbacks, set state.same_site to none or their logins fail")
+	}
+	var reauth *AuthReauthConfig
+	if c.Reauth != nil {
+		reauth = snapshot.MustCopy(c.Reauth)
+		if reauth.RedirectOnSuccess == "" {
+			reauth.RedirectOnSuccess = c.RedirectOnLogin
+		}
+	}
+	var cache *userCache
+	if c.UserCache != nil {
+		var err error
+		if cache, err = newUserCache(*c.UserCache); err != nil {
+			return nil, errors.Wrap(err, "failed to create the user cache")
+		}
+	}
 	return &auth{
 		loginPath:        providerToGin(c.LoginPath),
 		logoutPath:       providerToGin(c.LogoutPath),
@@ -193,6 +335,12 @@ func NewAuthController(c *AuthControllerConfig, ctx server.ControllerContext) (s
 		redirectOnLogin:  providerToGin(c.RedirectOnLogin),
 		redirectOnLogout: providerToGin(c.RedirectOnLogout),
 		callbackPath:     providerToGin(callbackPath),
+		hideTokens:       ctx.ServerConfig.ServerSideSessions,
+		checks:           newProviderChecks(c.VerifyProviders, providerConfigs, providers),
+		consentTemplate:  c.ConsentTemplate,
+		providerConfigs:  providerConfigs,
+		reauthConfig:     reauth,
+		userCache:        cache,
 	}, nil
 }
 
@@ -213,28 +361,44 @@ type auth struct {
 	redirectOnLogin  string
 	redirectOnLogout string
 	callbackPath     string
+	hideTokens       bool // provider tokens and raw data never leave the server
+	checks           *providerChecks
+	consentTemplate  string
+	providerConfigs  map[string]ProviderConfig // The client IDs and scopes of the consents
+	reauthConfig     *AuthReauthConfig         // Nil without silent re-authentication
+	userCache        *userCache                // Nil without user cache
 }
 
-type UserObject struct {
-	Id   string    `json:"id"`   // Unique identifier for the user session
-	User goth.User `json:"user"` // Complete user information from OAuth2 provider
-}
+// UserObject is the authenticated user stored in the session, see sessionsx.User.
+type UserObject = sessionsx.UserObject
 
 // LoginFunc is a middleware function that protects routes requiring authentication.
 func LoginFunc(c *gin.Context) {
-	userSession := sessions.Default(c)
-	userObject := userSession.Get("user")
-	if userObject == nil {
+	requireUser(c)
+}
+
+// requireUser aborts with 401 Unauthorized unless the session holds a user whose token has not
+// expired nor been revoked. Sessions holding a revoked or malformed user are cleared, and expired
+// users are removed, keeping the provider of their last login for the re-authentication.
+func requireUser(c *gin.Context) {
+	if sessions.Default(c).Get(sessionsx.CurrentUserKey(c)) == nil {
 		c.AbortWithStatus(http.StatusUnauthorized)
 		return
 	}
 
-	u, ok := userObject.(UserObject)
-
-	if !ok || time.Now().After(u.User.ExpiresAt) {
-		userSession.Clear()
-		err := userSession.Save()
-		if err != nil {
+	u, ok := sessionsx.User(c)
+	if !ok || server.CredentialsRevoked(c, u.Id, func(r server.Revocation) bool {
+		return u.Generation >= r.Generation
+	}) {
+		if err := sessionsx.Clear(c); err != nil {
+			_ = c.AbortWithError(http.StatusInternalServerError, err)
+			return
+		}
+		c.AbortWithStatus(http.StatusUnauthorized)
+		return
+	}
+	if time.Now().After(u.User.ExpiresAt) {
+		if err := expireUser(c); err != nil {
 			_ = c.AbortWithError(http.StatusInternalServerError, err)
 			return
 		}
@@ -246,6 +410,10 @@ func LoginFunc(c *gin.Context) {
 }
 
 func (a *auth) Bind(engine *gin.Engine, loginMiddleware gin.HandlerFunc) error {
+	return a.BindGroup(&engine.RouterGroup, loginMiddleware)
+}
+
+func (a *auth) BindGroup(group *gin.RouterGroup, loginMiddleware gin.HandlerFunc) error {
 	hack := func(c *gin.Context) {
 		// Hack to make gothic work with gin
 		q := c.Request.URL.Query()
@@ -259,44 +427,114 @@ func (a *auth) Bind(engine *gin.Engine, loginMiddleware gin.HandlerFunc) error {
 		c.Next()
 	}
 
-	engine.GET(a.loginPath, hack, a.login).GET(a.callbackPath, hack, a.callback)
-	engine.GET(a.logoutPath, a.logout)
-	engine.GET(a.userInfoPath, loginMiddleware, a.userInfo)
+	group.GET(a.loginPath, hack, a.login).GET(a.callbackPath, hack, a.callback)
+	// Providers using the form_post response mode post their callbacks
+	group.POST(a.callbackPath, formCallback, hack, a.callback)
+	group.GET(a.logoutPath, a.logout)
+	group.GET(a.userInfoPath, loginMiddleware, a.userInfo)
+	if a.reauthConfig != nil {
+		group.GET(a.reauthConfig.Path, a.reauth)
+	}
+	if a.userCache != nil && a.userCache.path != "" {
+		group.POST(a.userCache.path, loginMiddleware, a.refreshUser)
+	}
 	return nil
 }
 
 func (a *auth) Close() error {
-	return nil
+	return a.userCache.Close()
 }
 
-func (a *auth) success(c *gin.Context, user goth.User) {
-	session := sessions.Default(c)
-	session.Set("user", a.userFactory(user))
-	err := session.Save()
+func (a *auth) success(c *gin.Context, user goth.User, redirect string) {
+	object := a.userFactory(user)
+	revocation, err := server.CurrentRevocation(c.Request.Context(), object.Id)
 	if err != nil {
+		// Users revoked before have to sign in again once the store recovers
+		logger := server.RequestLogger(c)
+		logger.Error().Err(err).Msg("Failed to read the credential generation")
+	}
+	object.Generation = revocation.Generation
+	sessions.Default(c).Set(lastProviderKey, user.Provider) // Saved with the user
+	if err := sessionsx.SetUser(c, object); err != nil {
 		_ = c.AbortWithError(http.StatusInternalServerError, err)
 		return
 	}
-	c.Redirect(http.StatusFound, a.redirectOnLogin)
+	server.Audit(c, "login", map[string]any{"provider": user.Provider})
+	a.recordConsent(c, user)
+	c.Redirect(http.StatusFound, redirect)
+}
+
+// recordConsent records the scopes granted to the provider, if there is a consent store. Failures
+// are logged and do not fail the login.
+func (a *auth) recordConsent(c *gin.Context, user goth.User) {
+	if ConsentStore == nil {
+		return
+	}
+	providerConfig := a.providerConfigs[user.Provider]
+	record := consent.Consent{
+		User:     a.userFactory(user).Id,
+		Provider: user.Provider,
+		Client:   providerConfig.Key,
+		Scopes:   providerConfig.Scopes,
+	}
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+	defer cancel()
+	if err := ConsentStore.Grant(ctx, record); err != nil {
+		logger := server.RequestLogger(c)
+		logger.Error().Err(err).Str("provider", user.Provider).Msg("Failed to record consent")
+		return
+	}
+	server.Audit(c, "consent_granted", map[string]any{"provider": user.Provider, "scopes": providerConfig.Scopes})
 }
 
 func (a *auth) login(c *gin.Context) {
 	if user, err := gothic.CompleteUserAuth(c.Writer, c.Request); err != nil {
+		if a.consentTemplate != "" && c.Query("consent") != "granted" {
+			provider := c.Param("provider")
+			query := c.Request.URL.Query()
+			query.Del("provider") // Added back from the path
+			query.Set("consent", "granted")
+			c.HTML(http.StatusOK, a.consentTemplate, gin.H{
+				"provider":     provider,
+				"scopes":       a.providerConfigs[provider].Scopes,
+				"continue_url": c.Request.URL.Path + "?" + query.Encode(),
+			})
+			return
+		}
 		gothic.BeginAuthHandler(c.Writer, c.Request)
 	} else {
-		a.success(c, user)
+		a.success(c, user, a.redirectOnLogin)
 	}
 }
 
 func (a *auth) callback(c *gin.Context) {
+	silent := a.reauthConfig != nil && reauthenticating(c)
 	if user, err := gothic.CompleteUserAuth(c.Writer, c.Request); err != nil {
+		if silent {
+			// e.g. login_required when the user is signed out of the provider
+			reason := c.Query("error")
+			if reason == "" {
+				reason = "login_required"
+			}
+			a.reauthFailed(c, c.Param("provider"), reason)
+			return
+		}
+		server.Audit(c, "login_failed", map[string]any{"provider": c.Param("provider")})
 		_ = c.AbortWithError(http.StatusUnauthorized, err)
 	} else {
-		a.success(c, user)
+		completeAppleUser(c, &user)
+		a.userCache.complete(c, &user)
+		redirect := a.redirectOnLogin
+		if silent {
+			redirect = a.reauthConfig.RedirectOnSuccess
+		}
+		a.success(c, user, redirect)
 	}
 }
 
 func (a *auth) logout(c *gin.Context) {
+	// Audit before the session is cleared, so that the record includes the user
+	server.Audit(c, "logout", nil)
 	err := gothic.Logout(c.Writer, c.Request)
 	if err != nil {
 		log.Error().Err(err).Msg("Failed to log out")
@@ -312,7 +550,25 @@ func (a *auth) logout(c *gin.Context) {
 }
 
 func (a *auth) userInfo(c *gin.Context) {
-	c.JSON(http.StatusOK, sessions.Default(c).Get("user").(UserObject))
+	user, ok := sessionsx.User(c)
+	if !ok {
+		c.AbortWithStatus(http.StatusUnauthorized)
+		return
+	}
+	c.JSON(http.StatusOK, a.publicUser(user))
+}
+
+// publicUser returns the user answered to the clients, without tokens if they must not leave the
+// server
+func (a *auth) publicUser(user UserObject) UserObject {
+	if a.hideTokens {
+		user.User.AccessToken = ""
+		user.User.AccessTokenSecret = ""
+		user.User.RefreshToken = ""
+		user.User.IDToken = ""
+		user.User.RawData = nil
+	}
+	return user
 }
 
 func (a *auth) userFactory(user goth.User) *UserObject {
@@ -330,6 +586,10 @@ func (a *auth) userFactory(user goth.User) *UserObject {
 
 var ProviderFactory ProvidersFactory
 
+// ConsentStore records the scopes granted to the providers on each login when set, see the consent
+// package.
+var ConsentStore consent.Store
+
 type configProviderFactory struct {
 	config map[string]ProviderConfig
 }
@@ -1,11 +1,7 @@
 package controller
 
 import (
-	"net/http"
-	"time"
-
 	"github.com/animalet/sargantana-go/pkg/server"
-	"github.com/gin-contrib/sessions"
 	"github.com/gin-gonic/gin"
 )
 
@@ -14,6 +10,14 @@ import (
 // before accessing protected routes.
 type GothAuthenticator struct{}
 
+// GothAuthenticatorConfig is the empty configuration of GothAuthenticator, used to select it in
+// the authenticator section of the server configuration.
+type GothAuthenticatorConfig struct{}
+
+func (c GothAuthenticatorConfig) Validate() error {
+	return nil
+}
+
 // NewGothAuthenticator creates a new authenticator that uses goth sessions for authentication.
 // This authenticator should be used in conjunction with the auth controller which handles
 // the OAuth2 login flow and session management.
@@ -30,27 +34,5 @@ func NewGothAuthenticator() server.Authenticator {
 // It checks for a valid user session and ensures the OAuth2 token has not expired.
 // If authentication fails, it returns 401 Unauthorized.
 func (g *GothAuthenticator) Middleware() gin.HandlerFunc {
-	return func(c *gin.Context) {
-		userSession := sessions.Default(c)
-		userObject := userSession.Get("user")
-		if userObject == nil {
-			c.AbortWithStatus(http.StatusUnauthorized)
-			return
-		}
-
-		u, ok := userObject.(UserObject)
-
-		if !ok || time.Now().After(u.User.ExpiresAt) {
-			userSession.Clear()
-			err := userSession.Save()
-			if err != nil {
-				_ = c.AbortWithError(http.StatusInternalServerError, err)
-				return
-			}
-			c.AbortWithStatus(http.StatusUnauthorized)
-			return
-		}
-
-		c.Next()
-	}
+	return requireUser
 }
@@ -0,0 +1,189 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/animalet/sargantana-go/pkg/server"
+	"github.com/markbates/goth"
+	"github.com/pkg/errors"
+)
+
+// providerAliases maps the configuration names of the providers to their goth names
+var providerAliases = map[string]string{"twitter": "twitterv2"}
+
+// providerChecks verifies the configured OAuth providers at startup, see
+// AuthControllerConfig.VerifyProviders
+type providerChecks struct {
+	configs   map[string]ProviderConfig
+	providers map[string]goth.Provider
+	client    *http.Client
+}
+
+func newProviderChecks(enabled bool, configs map[string]ProviderConfig, providers []goth.Provider) *providerChecks {
+	if !enabled {
+		return nil
+	}
+	byName := make(map[string]goth.Provider, len(providers))
+	for _, provider := range providers {
+		byName[provider.Name()] = provider
+	}
+	return &providerChecks{
+		configs:   configs,
+		providers: byName,
+		client: &http.Client{
+			// The endpoints are only probed, redirects to login pages tell nothing more
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+	}
+}
+
+// StartupCheck verifies each configured provider when verify_providers is enabled, see
+// server.StartupChecker.
+func (a *auth) StartupCheck(ctx context.Context) []server.CheckResult {
+	if a.checks == nil {
+		return nil
+	}
+	return a.checks.run(ctx)
+}
+
+func (p *providerChecks) run(ctx context.Context) []server.CheckResult {
+	names := make([]string, 0, len(p.configs))
+	for name := range p.configs {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	results := make([]server.CheckResult, 0, len(names))
+	for _, name := range names {
+		start := time.Now()
+		err := p.check(ctx, name, p.configs[name])
+		results = append(results, server.CheckResult{Name: "provider " + name, Err: err, Duration: time.Since(start)})
+	}
+	return results
+}
+
+// check verifies the credentials of a provider, then fetches the discovery document of OpenID
+// Connect providers and probes their token endpoint, or probes the authorization endpoint of the
+// other providers.
+func (p *providerChecks) check(ctx context.Context, name string, cfg ProviderConfig) error {
+	if err := checkCredentials(name, cfg); err != nil {
+		return err
+	}
+
+	gothName := name
+	if alias, ok := providerAliases[name]; ok {
+		gothName = alias
+	}
+	provider, ok := p.providers[gothName]
+	if !ok {
+		return errors.New("provider could not be created, see the startup log")
+	}
+
+	if name == "openid-connect" {
+		tokenEndpoint, err := p.discover(ctx, cfg.URL)
+		if err != nil {
+			return err
+		}
+		return p.probe(ctx, tokenEndpoint)
+	}
+
+	session, err := provider.BeginAuth("startup-check")
+	if err != nil {
+		return errors.Wrap(err, "failed to build the authorization request")
+	}
+	authURL, err := session.GetAuthURL()
+	if err != nil {
+		return errors.Wrap(err, "failed to build the authorization request")
+	}
+	endpoint, err := url.Parse(authURL)
+	if err != nil {
+		return errors.Wrap(err, "invalid authorization endpoint")
+	}
+	endpoint.RawQuery = ""
+	return p.probe(ctx, endpoint.String())
+}
+
+// discover fetches the OpenID Connect discovery document and returns the token endpoint
+func (p *providerChecks) discover(ctx context.Context, discoveryURL string) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, discoveryURL, nil)
+	if err != nil {
+		return "", errors.Wrap(err, "invalid discovery URL")
+	}
+	res, err := p.client.Do(req)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to fetch the discovery document")
+	}
+	defer func() {
+		_ = res.Body.Close()
+	}()
+	if res.StatusCode != http.StatusOK {
+		return "", errors.Errorf("discovery document returned status %d", res.StatusCode)
+	}
+	var discovery struct {
+		Issuer                string `json:"issuer"`
+		AuthorizationEndpoint string `json:"authorization_endpoint"`
+		TokenEndpoint         string `json:"token_endpoint"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&discovery); err != nil {
+		return "", errors.Wrap(err, "invalid discovery document")
+	}
+	if discovery.Issuer == "" || discovery.AuthorizationEndpoint == "" || discovery.TokenEndpoint == "" {
+		return "", errors.New("discovery document lacks the issuer, authorization_endpoint or token_endpoint")
+	}
+	return discovery.TokenEndpoint, nil
+}
+
+// probe sends a HEAD request to an endpoint, which is reachable when it answers without server error
+func (p *providerChecks) probe(ctx context.Context, endpoint string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, endpoint, nil)
+	if err != nil {
+		return errors.Wrapf(err, "invalid endpoint %s", endpoint)
+	}
+	res, err := p.client.Do(req)
+	if err != nil {
+		return errors.Wrapf(err, "endpoint %s is unreachable", endpoint)
+	}
+	_ = res.Body.Close()
+	if res.StatusCode >= http.StatusInternalServerError {
+		return errors.Errorf("endpoint %s returned status %d", endpoint, res.StatusCode)
+	}
+	return nil
+}
+
+// checkCredentials rejects the credentials that cannot be valid, such as unexpanded placeholders
+// or values with surrounding whitespace pasted from a secret file
+func checkCredentials(name string, cfg ProviderConfig) error {
+	credentials := map[string]string{"key": cfg.Key, "secret": cfg.Secret}
+	switch name {
+	case "wecom":
+		credentials = map[string]string{"corp_id": cfg.CorpID, "secret": cfg.Secret}
+	case "steam":
+		credentials = map[string]string{"key": cfg.Key}
+	}
+	for _, field := range []string{"key", "corp_id", "secret"} {
+		value, ok := credentials[field]
+		if !ok {
+			continue
+		}
+		switch {
+		case value == "":
+			return errors.Errorf("%s is empty", field)
+		case strings.Contains(value, "${"):
+			return errors.Errorf("%s contains an unexpanded placeholder", field)
+		case strings.TrimSpace(value) != value:
+			return errors.Errorf("%s has leading or trailing whitespace", field)
+		case strings.ContainsFunc(value, unicode.IsControl):
+			return errors.Errorf("%s contains control characters", field)
+		}
+	}
+	return nil
+}
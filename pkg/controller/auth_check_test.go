@@ -0,0 +1,135 @@
+//go:build unit
+
+package controller
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/animalet/sargantana-go/pkg/server"
+	"github.com/markbates/goth"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+// endpointProvider is a provider whose authorization endpoint is authURL
+type endpointProvider struct {
+	MockProvider
+	authURL string
+}
+
+func (e *endpointProvider) BeginAuth(string) (goth.Session, error) {
+	return &endpointSession{authURL: e.authURL}, nil
+}
+
+type endpointSession struct {
+	MockSession
+	authURL string
+}
+
+func (e *endpointSession) GetAuthURL() (string, error) { return e.authURL, nil }
+
+var _ = Describe("Auth provider startup checks", func() {
+	Context("checkCredentials", func() {
+		It("should accept well-formed credentials", func() {
+			Expect(checkCredentials("google", ProviderConfig{Key: "id", Secret: "secret"})).To(Succeed())
+			Expect(checkCredentials("steam", ProviderConfig{Key: "key"})).To(Succeed())
+			Expect(checkCredentials("wecom", ProviderConfig{CorpID: "corp", Secret: "secret"})).To(Succeed())
+		})
+
+		It("should reject malformed credentials", func() {
+			Expect(checkCredentials("google", ProviderConfig{Key: "id", Secret: "${vault:SECRET}"})).
+				To(MatchError(ContainSubstring("secret contains an unexpanded placeholder")))
+			Expect(checkCredentials("google", ProviderConfig{Key: "id\n", Secret: "secret"})).
+				To(MatchError(ContainSubstring("key has leading or trailing whitespace")))
+			Expect(checkCredentials("google", ProviderConfig{Key: "i\x00d", Secret: "secret"})).
+				To(MatchError(ContainSubstring("key contains control characters")))
+			Expect(checkCredentials("wecom", ProviderConfig{Secret: "secret"})).
+				To(MatchError(ContainSubstring("corp_id is empty")))
+		})
+	})
+
+	Context("providerChecks", func() {
+		var upstream *httptest.Server
+
+		BeforeEach(func() {
+			upstream = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				switch r.URL.Path {
+				case "/authorize":
+					Expect(r.Method).To(Equal(http.MethodHead))
+					w.WriteHeader(http.StatusBadRequest)
+				default:
+					w.WriteHeader(http.StatusBadGateway)
+				}
+			}))
+			DeferCleanup(upstream.Close)
+		})
+
+		It("should be disabled unless verify_providers is set", func() {
+			Expect(newProviderChecks(false, nil, nil)).To(BeNil())
+			Expect((&auth{}).StartupCheck(context.Background())).To(BeNil())
+		})
+
+		It("should report the reachability of the authorization endpoints", func() {
+			checks := newProviderChecks(true, map[string]ProviderConfig{
+				"google":  {Key: "id", Secret: "secret"},
+				"github":  {Key: "id", Secret: "secret"},
+				"twitter": {Key: "id", Secret: "secret"},
+				"gitlab":  {Key: "id", Secret: "secret"},
+			}, []goth.Provider{
+				&endpointProvider{MockProvider: MockProvider{name: "google"}, authURL: upstream.URL + "/authorize?client_id=id"},
+				&endpointProvider{MockProvider: MockProvider{name: "github"}, authURL: upstream.URL + "/broken"},
+				&endpointProvider{MockProvider: MockProvider{name: "twitterv2"}, authURL: upstream.URL + "/authorize"},
+			})
+
+			results := checks.run(context.Background())
+			Expect(results).To(HaveLen(4))
+			Expect(results[0].Name).To(Equal("provider github"))
+			Expect(results[0].Err).To(MatchError(ContainSubstring("returned status 502")))
+			Expect(results[1].Name).To(Equal("provider gitlab"))
+			Expect(results[1].Err).To(MatchError(ContainSubstring("could not be created")))
+			Expect(results[2].Name).To(Equal("provider google"))
+			Expect(results[2].Err).NotTo(HaveOccurred())
+			Expect(results[3].Name).To(Equal("provider twitter"))
+			Expect(results[3].Err).NotTo(HaveOccurred())
+		})
+
+		It("should fetch the discovery document of OpenID Connect providers", func() {
+			var discovery *httptest.Server
+			discovery = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				switch r.URL.Path {
+				case "/.well-known/openid-configuration":
+					w.Header().Set("Content-Type", "application/json")
+					_, _ = w.Write([]byte(`{"issuer": "` + discovery.URL + `", "authorization_endpoint": "` + discovery.URL +
+						`/authorize", "token_endpoint": "` + discovery.URL + `/token", "userinfo_endpoint": "` + discovery.URL + `/userinfo"}`))
+				case "/token":
+					Expect(r.Method).To(Equal(http.MethodHead))
+					w.WriteHeader(http.StatusMethodNotAllowed)
+				default:
+					w.WriteHeader(http.StatusNotFound)
+				}
+			}))
+			DeferCleanup(discovery.Close)
+
+			ctrl, err := NewAuthController(&AuthControllerConfig{
+				CallbackPath:     "/auth/{provider}/callback",
+				LoginPath:        "/auth/{provider}",
+				LogoutPath:       "/logout",
+				UserInfoPath:     "/user",
+				RedirectOnLogin:  "/",
+				RedirectOnLogout: "/",
+				VerifyProviders:  true,
+				Providers: map[string]ProviderConfig{
+					"openid-connect": {Key: "id", Secret: "secret", URL: discovery.URL + "/.well-known/openid-configuration"},
+				},
+			}, server.ControllerContext{ServerConfig: server.WebServerConfig{Address: "localhost:8080"}})
+			Expect(err).NotTo(HaveOccurred())
+
+			results := ctrl.(server.StartupChecker).StartupCheck(context.Background())
+			Expect(results).To(HaveLen(1))
+			Expect(results[0].Name).To(Equal("provider openid-connect"))
+			Expect(results[0].Err).NotTo(HaveOccurred())
+		})
+	})
+})
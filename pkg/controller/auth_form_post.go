@@ -0,0 +1,89 @@
+package controller
+
+import (
+	"encoding/json"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/animalet/sargantana-go/pkg/server"
+	"github.com/gin-gonic/gin"
+	"github.com/markbates/goth"
+	"github.com/pkg/errors"
+)
+
+// maxCallbackFormSize bounds the callbacks posted by the providers, which carry a code, a state
+// and at most an ID token and a user
+const maxCallbackFormSize = 64 << 10
+
+// formCallback moves the parameters of the callbacks posted with the form_post response mode, e.g.
+// by Apple, to the query, where gothic reads them along with the provider.
+func formCallback(c *gin.Context) {
+	if c.ContentType() != "application/x-www-form-urlencoded" {
+		c.AbortWithStatus(http.StatusUnsupportedMediaType)
+		return
+	}
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxCallbackFormSize)
+	if err := c.Request.ParseForm(); err != nil {
+		status := http.StatusBadRequest
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			status = http.StatusRequestEntityTooLarge
+		}
+		server.Audit(c, "login_failed", map[string]any{"provider": c.Param("provider")})
+		_ = c.AbortWithError(status, err)
+		return
+	}
+	query := c.Request.URL.Query()
+	for key, values := range c.Request.PostForm {
+		if key != "provider" { // Taken from the path
+			query[key] = values
+		}
+	}
+	c.Request.URL.RawQuery = query.Encode()
+	c.Next()
+}
+
+// appleUser is the user posted by Apple on the first sign in, when the name or email scopes are
+// requested. It is the only source of the name of the user.
+type appleUser struct {
+	Name struct {
+		FirstName string `json:"firstName"`
+		LastName  string `json:"lastName"`
+	} `json:"name"`
+	Email string `json:"email"`
+}
+
+// completeAppleUser fills the name and email of the user missing from the Apple ID token with the
+// user posted to the callback, if any
+func completeAppleUser(c *gin.Context, user *goth.User) {
+	posted := c.Query("user")
+	if user.Provider != "apple" || posted == "" {
+		return
+	}
+	var apple appleUser
+	if err := json.Unmarshal([]byte(posted), &apple); err != nil {
+		logger := server.RequestLogger(c)
+		logger.Warn().Err(err).Msg("Ignoring malformed Apple user")
+		return
+	}
+	if user.FirstName == "" && user.LastName == "" {
+		user.FirstName, user.LastName = apple.Name.FirstName, apple.Name.LastName
+		user.Name = strings.TrimSpace(apple.Name.FirstName + " " + apple.Name.LastName)
+	}
+	if user.Email == "" {
+		user.Email = apple.Email
+	}
+}
+
+// formPostProviders returns the configured providers answering with the form_post response mode,
+// whose callbacks are cross-site POST requests
+func formPostProviders(configs map[string]ProviderConfig) []string {
+	var names []string
+	if apple, ok := configs["apple"]; ok && slices.ContainsFunc(apple.Scopes, func(scope string) bool {
+		return scope == "name" || scope == "email"
+	}) {
+		names = append(names, "apple")
+	}
+	return names
+}
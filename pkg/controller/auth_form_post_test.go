@@ -0,0 +1,155 @@
+//go:build unit
+
+package controller
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+
+	"github.com/animalet/sargantana-go/pkg/server"
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-contrib/sessions/cookie"
+	"github.com/gin-gonic/gin"
+	"github.com/markbates/goth"
+	"github.com/markbates/goth/gothic"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/pkg/errors"
+	"golang.org/x/oauth2"
+)
+
+// formPostProvider is a provider posting its callbacks, authorized once the code is received
+type formPostProvider struct{}
+
+func (formPostProvider) Name() string                { return "apple" }
+func (formPostProvider) SetName(string)              {}
+func (formPostProvider) Debug(bool)                  {}
+func (formPostProvider) RefreshTokenAvailable() bool { return false }
+func (formPostProvider) RefreshToken(string) (*oauth2.Token, error) {
+	return nil, nil
+}
+func (formPostProvider) BeginAuth(state string) (goth.Session, error) {
+	return &formPostSession{AuthURL: "https://provider.example.org/auth?response_mode=form_post&state=" + state}, nil
+}
+func (formPostProvider) UnmarshalSession(data string) (goth.Session, error) {
+	session := &formPostSession{}
+	return session, json.Unmarshal([]byte(data), session)
+}
+func (formPostProvider) FetchUser(session goth.Session) (goth.User, error) {
+	if session.(*formPostSession).Code == "" {
+		return goth.User{}, errors.New("not authorized")
+	}
+	return goth.User{Provider: "apple", UserID: "001"}, nil
+}
+
+type formPostSession struct {
+	AuthURL string
+	Code    string
+}
+
+func (s *formPostSession) GetAuthURL() (string, error) { return s.AuthURL, nil }
+func (s *formPostSession) String() string              { return s.Marshal() }
+func (s *formPostSession) Marshal() string {
+	data, _ := json.Marshal(s)
+	return string(data)
+}
+func (s *formPostSession) Authorize(_ goth.Provider, params goth.Params) (string, error) {
+	s.Code = params.Get("code")
+	return "token", nil
+}
+
+type formPostProviderFactory struct{}
+
+func (formPostProviderFactory) CreateProviders(string) []goth.Provider {
+	return []goth.Provider{formPostProvider{}}
+}
+
+var _ = Describe("Auth controller form_post callbacks", func() {
+	var (
+		engine *gin.Engine
+		state  string
+		login  []*http.Cookie
+	)
+
+	BeforeEach(func() {
+		origFactory, origStore := ProviderFactory, gothic.Store
+		ProviderFactory = formPostProviderFactory{}
+		DeferCleanup(func() { ProviderFactory, gothic.Store = origFactory, origStore })
+
+		store := cookie.NewStore([]byte("secret"))
+		engine = gin.New()
+		engine.Use(sessions.Sessions("session", store))
+		secure := true
+		ctrl, err := NewAuthController(&AuthControllerConfig{
+			CallbackPath:     "/auth/{provider}/callback",
+			LoginPath:        "/auth/{provider}",
+			LogoutPath:       "/auth/logout",
+			UserInfoPath:     "/auth/user",
+			RedirectOnLogin:  "/",
+			RedirectOnLogout: "/",
+			Providers:        map[string]ProviderConfig{"apple": {Key: "k", Secret: "s", Scopes: []string{"name", "email"}}},
+			State:            &AuthStateConfig{SameSite: "none", Secure: &secure},
+		}, server.ControllerContext{SessionStore: store})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(ctrl.Bind(engine, func(c *gin.Context) { c.Next() })).To(Succeed())
+
+		w := httptest.NewRecorder()
+		engine.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/auth/apple", nil))
+		Expect(w.Code).To(Equal(http.StatusTemporaryRedirect))
+		location, err := url.Parse(w.Header().Get("Location"))
+		Expect(err).NotTo(HaveOccurred())
+		state, login = location.Query().Get("state"), w.Result().Cookies()
+	})
+
+	// callback posts the form to the callback with the cookies of the login
+	callback := func(contentType string, form url.Values) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/auth/apple/callback", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", contentType)
+		for _, c := range login {
+			req.AddCookie(c)
+		}
+		w := httptest.NewRecorder()
+		engine.ServeHTTP(w, req)
+		return w
+	}
+
+	It("should complete the logins posted by the providers", func() {
+		w := callback("application/x-www-form-urlencoded", url.Values{
+			"code":  {"code"},
+			"state": {state},
+			"user":  {`{"name":{"firstName":"Ana","lastName":"García"},"email":"ana@example.org"}`},
+		})
+		Expect(w.Code).To(Equal(http.StatusFound))
+		Expect(w.Header().Get("Location")).To(Equal("/"))
+
+		req := httptest.NewRequest(http.MethodGet, "/auth/user", nil)
+		for _, c := range w.Result().Cookies() {
+			req.AddCookie(c)
+		}
+		w = httptest.NewRecorder()
+		engine.ServeHTTP(w, req)
+		Expect(w.Code).To(Equal(http.StatusOK))
+		var user UserObject
+		Expect(json.Unmarshal(w.Body.Bytes(), &user)).To(Succeed())
+		Expect(user.Id).To(Equal("ana@example.org"))
+		Expect(user.User.Name).To(Equal("Ana García"))
+	})
+
+	It("should reject the callbacks with another state", func() {
+		w := callback("application/x-www-form-urlencoded", url.Values{"code": {"code"}, "state": {"forged"}})
+		Expect(w.Code).To(Equal(http.StatusUnauthorized))
+	})
+
+	It("should reject the callbacks that are not forms", func() {
+		w := callback("application/json", url.Values{"code": {"code"}, "state": {state}})
+		Expect(w.Code).To(Equal(http.StatusUnsupportedMediaType))
+	})
+
+	It("should reject oversized callbacks", func() {
+		w := callback("application/x-www-form-urlencoded", url.Values{"id_token": {strings.Repeat("a", maxCallbackFormSize)}})
+		Expect(w.Code).To(Equal(http.StatusRequestEntityTooLarge))
+	})
+})
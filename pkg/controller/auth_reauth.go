@@ -0,0 +1,131 @@
+package controller
+
+import (
+	"net/http"
+	"net/url"
+
+	"github.com/animalet/sargantana-go/pkg/server"
+	"github.com/animalet/sargantana-go/pkg/sessionsx"
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+	"github.com/markbates/goth"
+	"github.com/markbates/goth/gothic"
+	"github.com/pkg/errors"
+)
+
+const (
+	// lastProviderKey is the session key of the provider of the last login, kept when the user
+	// expires so that the session can be renewed with it
+	lastProviderKey = "last_provider"
+	// reauthKey is the session key marking a silent re-authentication in progress
+	reauthKey = "reauth"
+)
+
+// silentProviders are the providers accepting prompt=none, which fail instead of showing a login
+// or consent page. The other providers are sent the regular login, which usually redirects back
+// at once when the user is signed in and the scopes already granted.
+var silentProviders = map[string]bool{
+	"auth0":           true,
+	"azuread":         true,
+	"google":          true,
+	"microsoftonline": true,
+	"okta":            true,
+	"openid-connect":  true,
+}
+
+// AuthReauthConfig serves the silent re-authentication of the users with the provider of their
+// last login, e.g. for single-page applications renewing expired sessions in a hidden frame.
+type AuthReauthConfig struct {
+	// Path is the path starting the re-authentication, e.g. /auth/reauth. The provider can be
+	// chosen with the provider query parameter.
+	Path string `yaml:"path"`
+	// RedirectOnSuccess is the location the users are sent to once authenticated. Defaults to
+	// redirect_on_login.
+	RedirectOnSuccess string `yaml:"redirect_on_success,omitempty"`
+	// RedirectOnFailure is the location the users are sent to when they must sign in again, with
+	// the error query parameter, e.g. login_required.
+	RedirectOnFailure string `yaml:"redirect_on_failure"`
+}
+
+func (r AuthReauthConfig) Validate() error {
+	if r.Path == "" {
+		return errors.New("path must be set and non-empty")
+	}
+	if r.RedirectOnFailure == "" {
+		return errors.New("redirect_on_failure must be set and non-empty")
+	}
+	return nil
+}
+
+// reauth starts a silent re-authentication with the provider of the last login
+func (a *auth) reauth(c *gin.Context) {
+	provider := c.Query("provider")
+	if provider == "" {
+		provider, _ = sessionsx.Get[string](c, lastProviderKey)
+	}
+	if _, err := goth.GetProvider(provider); provider == "" || err != nil {
+		a.reauthFailed(c, provider, "login_required")
+		return
+	}
+
+	query := url.Values{"provider": {provider}}
+	c.Request.URL.RawQuery = query.Encode()
+	authURL, err := gothic.GetAuthURL(c.Writer, c.Request)
+	if err != nil {
+		logger := server.RequestLogger(c)
+		logger.Error().Err(err).Str("provider", provider).Msg("Failed to start the re-authentication")
+		a.reauthFailed(c, provider, "server_error")
+		return
+	}
+	if silentProviders[provider] {
+		if u, err := url.Parse(authURL); err == nil {
+			query := u.Query()
+			query.Set("prompt", "none")
+			u.RawQuery = query.Encode()
+			authURL = u.String()
+		}
+	}
+	if err := sessionsx.Set(c, reauthKey, true); err != nil {
+		_ = c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	c.Redirect(http.StatusFound, authURL)
+}
+
+// reauthenticating tells whether the callback completes a silent re-authentication, forgetting it
+func reauthenticating(c *gin.Context) bool {
+	silent, _ := sessionsx.Get[bool](c, reauthKey)
+	if silent {
+		sessions.Default(c).Delete(reauthKey) // Saved with the user or the failure
+	}
+	return silent
+}
+
+// reauthFailed sends the user to the failure location, with the error of the provider if any
+func (a *auth) reauthFailed(c *gin.Context, provider, reason string) {
+	if err := sessions.Default(c).Save(); err != nil {
+		_ = c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	server.Audit(c, "reauth_failed", map[string]any{"provider": provider, "error": reason})
+	location, err := url.Parse(a.reauthConfig.RedirectOnFailure)
+	if err != nil {
+		_ = c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	query := location.Query()
+	query.Set("error", reason)
+	location.RawQuery = query.Encode()
+	c.Redirect(http.StatusFound, location.String())
+}
+
+// expireUser removes the expired user from the session, keeping the provider of the last login
+func expireUser(c *gin.Context) error {
+	session := sessions.Default(c)
+	provider := session.Get(lastProviderKey)
+	session.Clear()
+	if provider != nil {
+		session.Set(lastProviderKey, provider)
+	}
+	return errors.Wrap(session.Save(), "failed to clear session")
+}
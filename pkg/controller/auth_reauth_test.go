@@ -0,0 +1,179 @@
+//go:build unit
+
+package controller
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"time"
+
+	"github.com/animalet/sargantana-go/pkg/server"
+	"github.com/animalet/sargantana-go/pkg/sessionsx"
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-contrib/sessions/cookie"
+	"github.com/gin-gonic/gin"
+	"github.com/markbates/goth"
+	"github.com/markbates/goth/gothic"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/pkg/errors"
+	"golang.org/x/oauth2"
+)
+
+// redirectProvider is a provider redirecting to its callback, authorized once the code is received
+type redirectProvider struct{ name string }
+
+func (p redirectProvider) Name() string              { return p.name }
+func (redirectProvider) SetName(string)              {}
+func (redirectProvider) Debug(bool)                  {}
+func (redirectProvider) RefreshTokenAvailable() bool { return false }
+func (redirectProvider) RefreshToken(string) (*oauth2.Token, error) {
+	return nil, nil
+}
+func (redirectProvider) BeginAuth(state string) (goth.Session, error) {
+	return &formPostSession{AuthURL: "https://provider.example.org/auth?state=" + state}, nil
+}
+func (redirectProvider) UnmarshalSession(data string) (goth.Session, error) {
+	session := &formPostSession{}
+	return session, json.Unmarshal([]byte(data), session)
+}
+func (p redirectProvider) FetchUser(session goth.Session) (goth.User, error) {
+	if session.(*formPostSession).Code == "" {
+		return goth.User{}, errors.New("not authorized")
+	}
+	return goth.User{Provider: p.name, Email: "user@example.org", ExpiresAt: time.Now().Add(time.Hour)}, nil
+}
+
+type redirectProviderFactory struct{}
+
+func (redirectProviderFactory) CreateProviders(string) []goth.Provider {
+	return []goth.Provider{redirectProvider{name: "google"}, redirectProvider{name: "github"}}
+}
+
+var _ = Describe("Auth controller silent re-authentication", func() {
+	var (
+		engine *gin.Engine
+		jar    map[string]*http.Cookie
+	)
+
+	BeforeEach(func() {
+		origFactory, origStore := ProviderFactory, gothic.Store
+		ProviderFactory = redirectProviderFactory{}
+		DeferCleanup(func() { ProviderFactory, gothic.Store = origFactory, origStore })
+
+		store := cookie.NewStore([]byte("secret"))
+		engine = gin.New()
+		engine.Use(sessions.Sessions("session", store))
+		ctrl, err := NewAuthController(&AuthControllerConfig{
+			CallbackPath:     "/auth/{provider}/callback",
+			LoginPath:        "/auth/{provider}",
+			LogoutPath:       "/auth/logout",
+			UserInfoPath:     "/auth/user",
+			RedirectOnLogin:  "/",
+			RedirectOnLogout: "/",
+			Providers:        map[string]ProviderConfig{"google": {Key: "k", Secret: "s"}, "github": {Key: "k", Secret: "s"}},
+			Reauth:           &AuthReauthConfig{Path: "/auth/reauth", RedirectOnSuccess: "/app", RedirectOnFailure: "/signin?from=reauth"},
+		}, server.ControllerContext{SessionStore: store})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(ctrl.Bind(engine, LoginFunc)).To(Succeed())
+		jar = map[string]*http.Cookie{}
+	})
+
+	// get sends a request with the cookies received so far and keeps the cookies of the response
+	get := func(path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		for _, c := range jar {
+			req.AddCookie(c)
+		}
+		w := httptest.NewRecorder()
+		engine.ServeHTTP(w, req)
+		for _, c := range w.Result().Cookies() {
+			if c.MaxAge < 0 {
+				delete(jar, c.Name)
+			} else {
+				jar[c.Name] = c
+			}
+		}
+		return w
+	}
+
+	// authorize follows a redirection to the provider, returning the query of the authorization URL
+	authorize := func(w *httptest.ResponseRecorder) url.Values {
+		Expect(w.Code).To(BeElementOf(http.StatusFound, http.StatusTemporaryRedirect))
+		location, err := url.Parse(w.Header().Get("Location"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(location.Host).To(Equal("provider.example.org"))
+		return location.Query()
+	}
+
+	login := func(provider string) {
+		query := authorize(get("/auth/" + provider))
+		w := get("/auth/" + provider + "/callback?code=code&state=" + query.Get("state"))
+		Expect(w.Header().Get("Location")).To(Equal("/"))
+	}
+
+	It("should validate the configuration", func() {
+		Expect(AuthReauthConfig{Path: "/auth/reauth", RedirectOnFailure: "/signin"}.Validate()).To(Succeed())
+		Expect(AuthReauthConfig{RedirectOnFailure: "/signin"}.Validate()).To(MatchError(ContainSubstring("path")))
+		Expect(AuthReauthConfig{Path: "/auth/reauth"}.Validate()).To(MatchError(ContainSubstring("redirect_on_failure")))
+	})
+
+	It("should expose its routes", func() {
+		r := AuthControllerConfig{
+			CallbackHost: "https://example.org", LoginPath: "/auth/{provider}", LogoutPath: "/auth/logout",
+			Reauth: &AuthReauthConfig{Path: "/auth/reauth"},
+		}.Routes()
+		Expect(r.LoginURL("google")).To(Equal("https://example.org/auth/google"))
+		Expect(r.ReauthURL("github")).To(Equal("https://example.org/auth/reauth?provider=github"))
+		Expect(r.UserInfoURL()).To(BeEmpty())
+	})
+
+	It("should ask the users without provider to sign in", func() {
+		w := get("/auth/reauth")
+		Expect(w.Code).To(Equal(http.StatusFound))
+		Expect(w.Header().Get("Location")).To(Equal("/signin?error=login_required&from=reauth"))
+	})
+
+	It("should renew the session silently with the provider of the last login", func() {
+		login("google")
+		query := authorize(get("/auth/reauth"))
+		Expect(query.Get("prompt")).To(Equal("none"))
+
+		w := get("/auth/google/callback?code=code&state=" + query.Get("state"))
+		Expect(w.Header().Get("Location")).To(Equal("/app"))
+		Expect(get("/auth/user").Code).To(Equal(http.StatusOK))
+	})
+
+	It("should report the errors of the providers", func() {
+		login("google")
+		query := authorize(get("/auth/reauth"))
+		w := get("/auth/google/callback?error=interaction_required&state=" + query.Get("state"))
+		Expect(w.Header().Get("Location")).To(Equal("/signin?error=interaction_required&from=reauth"))
+	})
+
+	It("should send the regular login to the providers without prompt=none", func() {
+		login("github")
+		query := authorize(get("/auth/reauth"))
+		Expect(query).NotTo(HaveKey("prompt"))
+		query = authorize(get("/auth/reauth?provider=google"))
+		Expect(query.Get("prompt")).To(Equal("none"))
+	})
+
+	It("should keep the provider when the user expires", func() {
+		engine.GET("/expire", func(c *gin.Context) {
+			user := sessionsx.MustUser(c)
+			user.User.ExpiresAt = time.Now().Add(-time.Minute)
+			Expect(sessionsx.SetUser(c, &user)).To(Succeed())
+		})
+		login("github")
+		get("/expire")
+		Expect(get("/auth/user").Code).To(Equal(http.StatusUnauthorized))
+		Expect(authorize(get("/auth/reauth"))).NotTo(HaveKey("prompt"))
+
+		// Logging out forgets it
+		get("/auth/logout")
+		Expect(get("/auth/reauth").Header().Get("Location")).To(HavePrefix("/signin"))
+	})
+})
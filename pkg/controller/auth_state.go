@@ -0,0 +1,125 @@
+package controller
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	gsessions "github.com/gorilla/sessions"
+	"github.com/markbates/goth/gothic"
+	"github.com/pkg/errors"
+)
+
+// defaultStateMaxAge is the lifetime of the OAuth state session, long enough to sign in at the
+// provider
+const defaultStateMaxAge = 10 * time.Minute
+
+// AuthStateConfig configures the session keeping the OAuth state between the login and the
+// callback. It is stored in the session store of the server, under its own cookie, and inherits the
+// attributes of the session cookie unless overridden here.
+type AuthStateConfig struct {
+	// MaxAge is the lifetime of the state session. Defaults to 10 minutes.
+	MaxAge time.Duration `yaml:"max_age,omitempty"`
+	// SameSite is the SameSite attribute of the state cookie: lax, strict or none. Defaults to lax,
+	// as strict cookies are not sent on the redirection from the provider. none requires secure.
+	SameSite string `yaml:"same_site,omitempty"`
+	// Secure sets the Secure attribute of the state cookie. Defaults to the attribute of the session
+	// cookie, set in release mode only.
+	Secure *bool `yaml:"secure,omitempty"`
+	// Domain is the domain of the state cookie, when the callback host differs from the login host.
+	Domain string `yaml:"domain,omitempty"`
+}
+
+func (s AuthStateConfig) Validate() error {
+	if s.MaxAge < 0 {
+		return errors.New("max_age must not be negative")
+	}
+	if s.MaxAge > 0 && s.MaxAge < time.Second {
+		return errors.New("max_age must be at least one second")
+	}
+	sameSite, err := parseSameSite(s.SameSite)
+	if err != nil {
+		return err
+	}
+	if sameSite == http.SameSiteNoneMode && (s.Secure == nil || !*s.Secure) {
+		return errors.New("same_site none requires secure")
+	}
+	return nil
+}
+
+func parseSameSite(value string) (http.SameSite, error) {
+	switch strings.ToLower(value) {
+	case "", "lax":
+		return http.SameSiteLaxMode, nil
+	case "strict":
+		return http.SameSiteStrictMode, nil
+	case "none":
+		return http.SameSiteNoneMode, nil
+	default:
+		return 0, errors.Errorf("invalid same_site %q, must be lax, strict or none", value)
+	}
+}
+
+// stateStore is the store of the gothic state session: the session store of the server, with the
+// cookie attributes of the AuthStateConfig.
+type stateStore struct {
+	store    gsessions.Store
+	maxAge   int
+	sameSite http.SameSite
+	secure   *bool
+	domain   string
+}
+
+func newStateStore(store gsessions.Store, config AuthStateConfig) *stateStore {
+	maxAge := config.MaxAge
+	if maxAge == 0 {
+		maxAge = defaultStateMaxAge
+	}
+	sameSite, _ := parseSameSite(config.SameSite) // Validated
+	return &stateStore{
+		store:    store,
+		maxAge:   int(maxAge / time.Second),
+		sameSite: sameSite,
+		secure:   config.Secure,
+		domain:   config.Domain,
+	}
+}
+
+// Get returns a cached session for the request, see gorilla/sessions.Store.
+func (s *stateStore) Get(r *http.Request, name string) (*gsessions.Session, error) {
+	return gsessions.GetRegistry(r).Get(s, name)
+}
+
+// New returns the session of the server store, with the attributes of the state cookie.
+func (s *stateStore) New(r *http.Request, name string) (*gsessions.Session, error) {
+	session, err := s.store.New(r, name)
+	if session == nil {
+		return session, err
+	}
+	options := gsessions.Options{Path: "/"}
+	if session.Options != nil {
+		options = *session.Options
+	}
+	options.MaxAge = s.maxAge
+	options.HttpOnly = true
+	options.SameSite = s.sameSite
+	if s.secure != nil {
+		options.Secure = *s.secure
+	}
+	if s.domain != "" {
+		options.Domain = s.domain
+	}
+	session.Options = &options
+	return session, err
+}
+
+// Save saves the session with the server store.
+func (s *stateStore) Save(r *http.Request, w http.ResponseWriter, session *gsessions.Session) error {
+	return s.store.Save(r, w, session)
+}
+
+// useStateStore binds the gothic state session to the session store of the server. gothic keeps
+// its own cookie store otherwise, keyed with the SESSION_SECRET environment variable.
+func useStateStore(store gsessions.Store, config AuthStateConfig) {
+	gothic.Store = newStateStore(store, config)
+}
@@ -0,0 +1,83 @@
+//go:build unit
+
+package controller
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	"github.com/animalet/sargantana-go/pkg/server"
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-contrib/sessions/cookie"
+	"github.com/markbates/goth/gothic"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Auth state session", func() {
+	var store sessions.Store
+
+	BeforeEach(func() {
+		store = cookie.NewStore([]byte("secret"))
+		store.Options(sessions.Options{Path: "/", MaxAge: 86400, Secure: false, SameSite: http.SameSiteStrictMode})
+		original := gothic.Store
+		DeferCleanup(func() { gothic.Store = original })
+	})
+
+	// stateCookie stores a value in the gothic session and returns its cookie
+	stateCookie := func() *http.Cookie {
+		w := httptest.NewRecorder()
+		Expect(gothic.StoreInSession("state", "value", httptest.NewRequest(http.MethodGet, "/auth/google", nil), w)).To(Succeed())
+		cookies := w.Result().Cookies()
+		Expect(cookies).To(HaveLen(1))
+		Expect(cookies[0].Name).To(Equal(gothic.SessionName))
+		return cookies[0]
+	}
+
+	It("should validate the configuration", func() {
+		secure := true
+		Expect(AuthStateConfig{}.Validate()).To(Succeed())
+		Expect(AuthStateConfig{MaxAge: time.Minute, SameSite: "Strict"}.Validate()).To(Succeed())
+		Expect(AuthStateConfig{SameSite: "none", Secure: &secure}.Validate()).To(Succeed())
+		Expect(AuthStateConfig{SameSite: "none"}.Validate()).To(MatchError(ContainSubstring("requires secure")))
+		Expect(AuthStateConfig{SameSite: "always"}.Validate()).To(MatchError(ContainSubstring("invalid same_site")))
+		Expect(AuthStateConfig{MaxAge: -time.Minute}.Validate()).To(MatchError(ContainSubstring("must not be negative")))
+		Expect(AuthControllerConfig{
+			CallbackPath: "/cb", LoginPath: "/login", LogoutPath: "/logout", UserInfoPath: "/user",
+			RedirectOnLogin: "/", RedirectOnLogout: "/",
+			Providers: map[string]ProviderConfig{"google": {Key: "key", Secret: "secret"}},
+			State:     &AuthStateConfig{SameSite: "always"},
+		}.Validate()).To(MatchError(ContainSubstring("invalid state configuration")))
+	})
+
+	It("should keep the state in the session store of the server with short-lived lax cookies", func() {
+		_, err := NewAuthController(&AuthControllerConfig{
+			CallbackPath: "/auth/{provider}/callback",
+			Providers:    map[string]ProviderConfig{"google": {Key: "key", Secret: "secret"}},
+		}, server.ControllerContext{SessionStore: store})
+		Expect(err).NotTo(HaveOccurred())
+
+		state := stateCookie()
+		Expect(state.MaxAge).To(Equal(600))
+		Expect(state.SameSite).To(Equal(http.SameSiteLaxMode))
+		Expect(state.HttpOnly).To(BeTrue())
+		Expect(state.Secure).To(BeFalse())
+
+		// The state cookie is decoded by the server store
+		req := httptest.NewRequest(http.MethodGet, "/auth/google/callback", nil)
+		req.AddCookie(state)
+		Expect(gothic.GetFromSession("state", req)).To(Equal("value"))
+	})
+
+	It("should apply the configured cookie attributes", func() {
+		secure := true
+		useStateStore(store, AuthStateConfig{MaxAge: time.Hour, SameSite: "none", Secure: &secure, Domain: "example.org"})
+
+		state := stateCookie()
+		Expect(state.MaxAge).To(Equal(3600))
+		Expect(state.SameSite).To(Equal(http.SameSiteNoneMode))
+		Expect(state.Secure).To(BeTrue())
+		Expect(state.Domain).To(Equal("example.org"))
+	})
+})
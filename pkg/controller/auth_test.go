@@ -3,11 +3,15 @@
 package controller
 
 import (
+	"context"
+	"encoding/json"
+	htmltemplate "html/template"
 	"net/http"
 	"net/http/httptest"
 	"time"
 
 	"github.com/animalet/sargantana-go/pkg/config"
+	"github.com/animalet/sargantana-go/pkg/controller/consent"
 	"github.com/animalet/sargantana-go/pkg/server"
 	"github.com/gin-contrib/sessions"
 	"github.com/gin-contrib/sessions/cookie"
@@ -15,6 +19,7 @@ import (
 	"github.com/markbates/goth"
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
+	"github.com/pkg/errors"
 	"golang.org/x/oauth2"
 	"gopkg.in/yaml.v3"
 )
@@ -123,9 +128,44 @@ providers:
 			Expect(err).NotTo(HaveOccurred())
 			Expect(ctrl).NotTo(BeNil())
 		})
+
+		It("should serve the routes and the callback below the base path of the binding", func() {
+			factory := &recordingProviderFactory{}
+			origFactory := ProviderFactory
+			ProviderFactory = factory
+			DeferCleanup(func() { ProviderFactory = origFactory })
+
+			ctrl, err := NewAuthController(&AuthControllerConfig{
+				CallbackHost: "https://example.org",
+				CallbackPath: "/auth/{provider}/callback",
+				LoginPath:    "/auth/{provider}",
+				LogoutPath:   "/logout",
+				UserInfoPath: "/user",
+			}, server.ControllerContext{BasePath: "/app"})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(factory.callbackURLTemplate).To(Equal("https://example.org/app/auth/{provider}/callback"))
+
+			engine := gin.New()
+			Expect(ctrl.(server.GroupController).BindGroup(engine.Group("/app"), func(c *gin.Context) {})).To(Succeed())
+			var paths []string
+			for _, route := range engine.Routes() {
+				paths = append(paths, route.Path)
+			}
+			Expect(paths).To(ConsistOf("/app/auth/:provider", "/app/auth/:provider/callback", "/app/auth/:provider/callback", "/app/logout", "/app/user"))
+		})
 	})
 })
 
+// recordingProviderFactory records the callback URL template the providers are created with.
+type recordingProviderFactory struct {
+	callbackURLTemplate string
+}
+
+func (r *recordingProviderFactory) CreateProviders(callbackURLTemplate string) []goth.Provider {
+	r.callbackURLTemplate = callbackURLTemplate
+	return nil
+}
+
 var _ = Describe("Auth Middleware", func() {
 	var (
 		engine *gin.Engine
@@ -523,4 +563,179 @@ var _ = Describe("Auth Controller (Detailed)", func() {
 			Expect(w.Header().Get("Location")).To(Equal("/home"))
 		})
 	})
+
+	Context("User info", func() {
+		userInfo := func(serverSideSessions bool) map[string]interface{} {
+			engine := gin.New()
+			engine.Use(sessions.Sessions("session", cookie.NewStore([]byte("secret"))))
+			ctrl, err := NewAuthController(&AuthControllerConfig{
+				CallbackPath:     "/auth/callback/{provider}",
+				LoginPath:        "/auth/login/{provider}",
+				LogoutPath:       "/auth/logout",
+				UserInfoPath:     "/auth/user",
+				RedirectOnLogin:  "/",
+				RedirectOnLogout: "/",
+				Providers:        map[string]ProviderConfig{"test-provider": {Key: "k", Secret: "s"}},
+			}, server.ControllerContext{
+				ServerConfig: server.WebServerConfig{Address: "localhost:8080", ServerSideSessions: serverSideSessions},
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			loggedIn := func(c *gin.Context) {
+				sessions.Default(c).Set("user", UserObject{Id: "user@example.com", User: goth.User{
+					Email:        "user@example.com",
+					AccessToken:  "access",
+					RefreshToken: "refresh",
+					IDToken:      "id",
+					RawData:      map[string]interface{}{"sub": "123"},
+				}})
+			}
+			Expect(ctrl.Bind(engine, loggedIn)).To(Succeed())
+
+			w := httptest.NewRecorder()
+			req, _ := http.NewRequest("GET", "/auth/user", nil)
+			engine.ServeHTTP(w, req)
+			Expect(w.Code).To(Equal(http.StatusOK))
+
+			var body map[string]interface{}
+			Expect(json.Unmarshal(w.Body.Bytes(), &body)).To(Succeed())
+			return body["user"].(map[string]interface{})
+		}
+
+		It("should include provider tokens by default", func() {
+			user := userInfo(false)
+			Expect(user["AccessToken"]).To(Equal("access"))
+			Expect(user["RawData"]).NotTo(BeNil())
+		})
+
+		It("should hide provider tokens and raw data with server side sessions", func() {
+			user := userInfo(true)
+			Expect(user["Email"]).To(Equal("user@example.com"))
+			Expect(user["AccessToken"]).To(BeEmpty())
+			Expect(user["RefreshToken"]).To(BeEmpty())
+			Expect(user["IDToken"]).To(BeEmpty())
+			Expect(user["RawData"]).To(BeNil())
+		})
+
+		It("should reject malformed user sessions instead of panicking", func() {
+			engine := gin.New()
+			engine.Use(sessions.Sessions("session", cookie.NewStore([]byte("secret"))))
+			ctrl, err := NewAuthController(&AuthControllerConfig{
+				CallbackPath:     "/auth/callback/{provider}",
+				LoginPath:        "/auth/login/{provider}",
+				LogoutPath:       "/auth/logout",
+				UserInfoPath:     "/auth/user",
+				RedirectOnLogin:  "/",
+				RedirectOnLogout: "/",
+				Providers:        map[string]ProviderConfig{"test-provider": {Key: "k", Secret: "s"}},
+			}, server.ControllerContext{
+				ServerConfig: server.WebServerConfig{Address: "localhost:8080"},
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(ctrl.Bind(engine, func(c *gin.Context) {
+				sessions.Default(c).Set("user", "not-a-user")
+			})).To(Succeed())
+
+			w := httptest.NewRecorder()
+			req, _ := http.NewRequest("GET", "/auth/user", nil)
+			engine.ServeHTTP(w, req)
+			Expect(w.Code).To(Equal(http.StatusUnauthorized))
+		})
+	})
+})
+
+// grantingStore is a consent.Store recording the granted consents
+type grantingStore struct {
+	consent.Store
+	granted []consent.Consent
+	err     error
+}
+
+func (g *grantingStore) Grant(_ context.Context, c consent.Consent) error {
+	if g.err != nil {
+		return g.err
+	}
+	g.granted = append(g.granted, c)
+	return nil
+}
+
+var _ = Describe("Auth Controller consents", func() {
+	var (
+		store       *grantingStore
+		origFactory ProvidersFactory
+	)
+
+	BeforeEach(func() {
+		gin.SetMode(gin.TestMode)
+		origFactory = ProviderFactory
+		ProviderFactory = &MockProviderFactory{}
+		store = &grantingStore{}
+		ConsentStore = store
+		DeferCleanup(func() {
+			ProviderFactory = origFactory
+			ConsentStore = nil
+		})
+	})
+
+	newAuth := func(consentTemplate string) *auth {
+		ctrl, err := NewAuthController(&AuthControllerConfig{
+			CallbackPath:     "/auth/callback/{provider}",
+			LoginPath:        "/auth/login/{provider}",
+			LogoutPath:       "/auth/logout",
+			UserInfoPath:     "/auth/user",
+			RedirectOnLogin:  "/",
+			RedirectOnLogout: "/",
+			ConsentTemplate:  consentTemplate,
+			Providers: map[string]ProviderConfig{
+				"test-provider": {Key: "client", Secret: "s", Scopes: []string{"email", "profile"}},
+			},
+		}, server.ControllerContext{
+			ServerConfig: server.WebServerConfig{Address: "localhost:8080"},
+			SessionStore: cookie.NewStore([]byte("secret")),
+		})
+		Expect(err).NotTo(HaveOccurred())
+		return ctrl.(*auth)
+	}
+
+	It("should record the scopes granted on login", func() {
+		a := newAuth("")
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request = httptest.NewRequest(http.MethodGet, "/auth/callback/test-provider", nil)
+		a.recordConsent(c, goth.User{UserID: "42", Provider: "test-provider", Email: "user@example.com"})
+
+		Expect(store.granted).To(ConsistOf(consent.Consent{
+			User:     "user@example.com",
+			Provider: "test-provider",
+			Client:   "client",
+			Scopes:   []string{"email", "profile"},
+		}))
+	})
+
+	It("should not fail the login when the consent cannot be recorded", func() {
+		store.err = errors.New("boom")
+		a := newAuth("")
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = httptest.NewRequest(http.MethodGet, "/auth/callback/test-provider", nil)
+		a.recordConsent(c, goth.User{UserID: "42", Provider: "test-provider"})
+		Expect(c.IsAborted()).To(BeFalse())
+		Expect(store.granted).To(BeEmpty())
+	})
+
+	It("should show the consent screen before redirecting to the provider", func() {
+		engine := gin.New()
+		engine.Use(sessions.Sessions("session", cookie.NewStore([]byte("secret"))))
+		engine.SetHTMLTemplate(htmltemplate.Must(htmltemplate.New("consent.html").Parse(
+			`{{.provider}}|{{range .scopes}}{{.}} {{end}}|{{.continue_url}}`)))
+		Expect(newAuth("consent.html").Bind(engine, nil)).To(Succeed())
+
+		w := httptest.NewRecorder()
+		engine.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/auth/login/test-provider?lang=es", nil))
+		Expect(w.Code).To(Equal(http.StatusOK))
+		Expect(w.Body.String()).To(Equal("test-provider|email profile |/auth/login/test-provider?consent=granted&amp;lang=es"))
+
+		w = httptest.NewRecorder()
+		engine.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/auth/login/test-provider?consent=granted&lang=es", nil))
+		Expect(w.Code).To(Equal(http.StatusTemporaryRedirect))
+	})
 })
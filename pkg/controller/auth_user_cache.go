@@ -0,0 +1,271 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/animalet/sargantana-go/pkg/database"
+	"github.com/animalet/sargantana-go/pkg/server"
+	"github.com/animalet/sargantana-go/pkg/sessionsx"
+	"github.com/gin-gonic/gin"
+	"github.com/gomodule/redigo/redis"
+	"github.com/markbates/goth"
+	"github.com/pkg/errors"
+)
+
+const (
+	defaultUserCacheTTL  = 24 * time.Hour
+	redisUserCachePrefix = "sargantana:user:"
+	userCacheTimeout     = 2 * time.Second
+)
+
+// AuthUserCacheConfig caches the profiles fetched from the providers by provider and user ID. The
+// cached profile completes the profiles that providers only return partially on later logins, e.g.
+// the name and email Apple sends on the first sign in, and can be refreshed without a new login.
+type AuthUserCacheConfig struct {
+	// TTL is how long a profile is kept after the last login or refresh. Defaults to 24h.
+	TTL time.Duration `yaml:"ttl,omitempty"`
+	// RefreshPath fetches the profile of the signed-in user from the provider again on POST, with
+	// the access token of the session, and answers it like the user info path. Optional.
+	RefreshPath string `yaml:"refresh_path,omitempty"`
+	// Redis stores the profiles in Redis, to be shared by the replicas of the server. Defaults to
+	// memory.
+	Redis *database.RedisConfig `yaml:"redis,omitempty"`
+}
+
+func (c AuthUserCacheConfig) Validate() error {
+	if c.TTL < 0 {
+		return errors.New("ttl must not be negative")
+	}
+	if c.Redis != nil {
+		if err := c.Redis.Validate(); err != nil {
+			return errors.Wrap(err, "invalid redis configuration")
+		}
+	}
+	return nil
+}
+
+// userCacheStore keeps the profiles of the users, without their tokens
+type userCacheStore interface {
+	Get(ctx context.Context, provider, userID string) (goth.User, bool, error)
+	Set(ctx context.Context, user goth.User, ttl time.Duration) error
+}
+
+// userCache is the user cache of an auth controller
+type userCache struct {
+	store  userCacheStore
+	ttl    time.Duration
+	path   string
+	closer func() error
+}
+
+func newUserCache(c AuthUserCacheConfig) (*userCache, error) {
+	cache := &userCache{ttl: c.TTL, path: providerToGin(c.RefreshPath)}
+	if cache.ttl == 0 {
+		cache.ttl = defaultUserCacheTTL
+	}
+	if c.Redis == nil {
+		cache.store = &memoryUserCache{users: make(map[string]memoryUser)}
+		return cache, nil
+	}
+	pool, err := c.Redis.CreateClient()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create redis client")
+	}
+	cache.store, cache.closer = &redisUserCache{pool: pool}, func() error { return database.CloseRedis(pool) }
+	return cache, nil
+}
+
+func (u *userCache) Close() error {
+	if u == nil || u.closer == nil {
+		return nil
+	}
+	return u.closer()
+}
+
+// complete fills the fields missing from the profile fetched from the provider with the cached
+// profile, and caches the result. Failures of the store are logged and leave the profile as is.
+func (u *userCache) complete(c *gin.Context, user *goth.User) {
+	if u == nil || user.UserID == "" {
+		return
+	}
+	ctx, cancel := context.WithTimeout(c.Request.Context(), userCacheTimeout)
+	defer cancel()
+	logger := server.RequestLogger(c)
+	cached, ok, err := u.store.Get(ctx, user.Provider, user.UserID)
+	if err != nil {
+		logger.Warn().Err(err).Str("provider", user.Provider).Msg("Failed to read the cached user profile")
+	} else if ok {
+		mergeProfile(user, cached)
+	}
+	if err := u.store.Set(ctx, *user, u.ttl); err != nil {
+		logger.Warn().Err(err).Str("provider", user.Provider).Msg("Failed to cache the user profile")
+	}
+}
+
+// mergeProfile fills the empty profile fields of user with those of cached
+func mergeProfile(user *goth.User, cached goth.User) {
+	for _, field := range []struct{ value, cached *string }{
+		{&user.Email, &cached.Email},
+		{&user.Name, &cached.Name},
+		{&user.FirstName, &cached.FirstName},
+		{&user.LastName, &cached.LastName},
+		{&user.NickName, &cached.NickName},
+		{&user.Description, &cached.Description},
+		{&user.AvatarURL, &cached.AvatarURL},
+		{&user.Location, &cached.Location},
+	} {
+		if *field.value == "" {
+			*field.value = *field.cached
+		}
+	}
+	if len(user.RawData) == 0 {
+		user.RawData = cached.RawData
+	}
+}
+
+// profile returns the user without its tokens
+func profile(user goth.User) goth.User {
+	user.AccessToken, user.AccessTokenSecret, user.RefreshToken, user.IDToken = "", "", "", ""
+	user.ExpiresAt = time.Time{}
+	return user
+}
+
+// tokenSession is the provider session of the tokens of a user, in the JSON encoding of the
+// sessions of the goth providers
+type tokenSession struct {
+	AccessToken  string    `json:"AccessToken,omitempty"`
+	RefreshToken string    `json:"RefreshToken,omitempty"`
+	ExpiresAt    time.Time `json:"ExpiresAt,omitzero"`
+	IDToken      string    `json:"IDToken,omitempty"`
+}
+
+// refreshUser fetches the profile of the signed-in user from the provider again
+func (a *auth) refreshUser(c *gin.Context) {
+	object, ok := sessionsx.User(c)
+	if !ok {
+		c.AbortWithStatus(http.StatusUnauthorized)
+		return
+	}
+	old := object.User
+	provider, err := goth.GetProvider(old.Provider)
+	if err != nil {
+		server.RespondError(c, http.StatusConflict) // The provider is no longer configured
+		return
+	}
+	data, err := json.Marshal(tokenSession{AccessToken: old.AccessToken, RefreshToken: old.RefreshToken, ExpiresAt: old.ExpiresAt, IDToken: old.IDToken})
+	if err != nil {
+		_ = c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	session, err := provider.UnmarshalSession(string(data))
+	if err != nil {
+		_ = c.AbortWithError(http.StatusInternalServerError, errors.Wrap(err, "failed to restore the provider session"))
+		return
+	}
+	fresh, err := provider.FetchUser(session)
+	if err != nil {
+		logger := server.RequestLogger(c)
+		logger.Warn().Err(err).Str("provider", old.Provider).Msg("Failed to refresh the user profile")
+		server.RespondError(c, http.StatusBadGateway)
+		return
+	}
+
+	// The tokens and expiry of the session are kept, unless the provider renewed them
+	fresh.Provider = old.Provider
+	if fresh.AccessToken == "" {
+		fresh.AccessToken, fresh.AccessTokenSecret, fresh.RefreshToken = old.AccessToken, old.AccessTokenSecret, old.RefreshToken
+	}
+	if fresh.IDToken == "" {
+		fresh.IDToken = old.IDToken
+	}
+	if fresh.ExpiresAt.IsZero() {
+		fresh.ExpiresAt = old.ExpiresAt
+	}
+	a.userCache.complete(c, &fresh)
+	object.User = fresh
+	if err := sessionsx.SetUser(c, &object); err != nil {
+		_ = c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	server.Audit(c, "user_refreshed", map[string]any{"provider": fresh.Provider})
+	c.JSON(http.StatusOK, a.publicUser(object))
+}
+
+type memoryUser struct {
+	user    goth.User
+	expires time.Time
+}
+
+// memoryUserCache is an in-process user cache, for servers without replicas
+type memoryUserCache struct {
+	mu    sync.Mutex
+	users map[string]memoryUser
+}
+
+func (m *memoryUserCache) Get(_ context.Context, provider, userID string) (goth.User, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	key := provider + ":" + userID
+	cached, ok := m.users[key]
+	if !ok || time.Now().After(cached.expires) {
+		delete(m.users, key)
+		return goth.User{}, false, nil
+	}
+	return cached.user, true, nil
+}
+
+func (m *memoryUserCache) Set(_ context.Context, user goth.User, ttl time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now()
+	for key, cached := range m.users {
+		if now.After(cached.expires) {
+			delete(m.users, key)
+		}
+	}
+	m.users[user.Provider+":"+user.UserID] = memoryUser{user: profile(user), expires: now.Add(ttl)}
+	return nil
+}
+
+// redisUserCache stores the profiles as JSON strings expiring with the TTL
+type redisUserCache struct {
+	pool *redis.Pool
+}
+
+func (r *redisUserCache) Get(ctx context.Context, provider, userID string) (goth.User, bool, error) {
+	conn, err := r.pool.GetContext(ctx)
+	if err != nil {
+		return goth.User{}, false, err
+	}
+	defer func() { _ = conn.Close() }()
+	data, err := redis.Bytes(conn.Do("GET", redisUserCachePrefix+provider+":"+userID))
+	if errors.Is(err, redis.ErrNil) {
+		return goth.User{}, false, nil
+	}
+	if err != nil {
+		return goth.User{}, false, errors.Wrap(err, "failed to get the cached user")
+	}
+	var user goth.User
+	if err := json.Unmarshal(data, &user); err != nil {
+		return goth.User{}, false, errors.Wrap(err, "invalid cached user")
+	}
+	return user, true, nil
+}
+
+func (r *redisUserCache) Set(ctx context.Context, user goth.User, ttl time.Duration) error {
+	data, err := json.Marshal(profile(user))
+	if err != nil {
+		return err
+	}
+	conn, err := r.pool.GetContext(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = conn.Close() }()
+	_, err = conn.Do("SET", redisUserCachePrefix+user.Provider+":"+user.UserID, data, "PX", ttl.Milliseconds())
+	return errors.Wrap(err, "failed to cache the user")
+}
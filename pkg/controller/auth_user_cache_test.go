@@ -0,0 +1,199 @@
+//go:build unit
+
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"time"
+
+	"github.com/animalet/sargantana-go/pkg/server"
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-contrib/sessions/cookie"
+	"github.com/gin-gonic/gin"
+	"github.com/markbates/goth"
+	"github.com/markbates/goth/gothic"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/pkg/errors"
+	"golang.org/x/oauth2"
+)
+
+// profileProvider is a provider answering the next profile of profiles to the holders of a token
+type profileProvider struct {
+	profiles *[]goth.User
+}
+
+func (profileProvider) Name() string                { return "gitlab" }
+func (profileProvider) SetName(string)              {}
+func (profileProvider) Debug(bool)                  {}
+func (profileProvider) RefreshTokenAvailable() bool { return false }
+func (profileProvider) RefreshToken(string) (*oauth2.Token, error) {
+	return nil, nil
+}
+func (profileProvider) BeginAuth(state string) (goth.Session, error) {
+	return &profileSession{AuthURL: "https://provider.example.org/auth?state=" + state}, nil
+}
+func (profileProvider) UnmarshalSession(data string) (goth.Session, error) {
+	session := &profileSession{}
+	return session, json.Unmarshal([]byte(data), session)
+}
+func (p profileProvider) FetchUser(session goth.Session) (goth.User, error) {
+	token := session.(*profileSession).AccessToken
+	if token == "" || len(*p.profiles) == 0 {
+		return goth.User{}, errors.New("not authorized")
+	}
+	user := (*p.profiles)[0]
+	*p.profiles = (*p.profiles)[1:]
+	user.Provider, user.UserID, user.AccessToken = "gitlab", "42", token
+	user.ExpiresAt = time.Now().Add(time.Hour)
+	return user, nil
+}
+
+type profileSession struct {
+	AuthURL     string
+	AccessToken string
+}
+
+func (s *profileSession) GetAuthURL() (string, error) { return s.AuthURL, nil }
+func (s *profileSession) String() string              { return s.Marshal() }
+func (s *profileSession) Marshal() string {
+	data, _ := json.Marshal(s)
+	return string(data)
+}
+func (s *profileSession) Authorize(_ goth.Provider, params goth.Params) (string, error) {
+	s.AccessToken = "token-" + params.Get("code")
+	return s.AccessToken, nil
+}
+
+type profileProviderFactory struct{ profiles *[]goth.User }
+
+func (f profileProviderFactory) CreateProviders(string) []goth.Provider {
+	return []goth.Provider{profileProvider{profiles: f.profiles}}
+}
+
+var _ = Describe("Auth controller user cache", func() {
+	var (
+		engine   *gin.Engine
+		ctrl     server.IController
+		jar      map[string]*http.Cookie
+		profiles []goth.User
+	)
+
+	BeforeEach(func() {
+		origFactory, origStore := ProviderFactory, gothic.Store
+		ProviderFactory = profileProviderFactory{profiles: &profiles}
+		DeferCleanup(func() { ProviderFactory, gothic.Store = origFactory, origStore })
+
+		store := cookie.NewStore([]byte("secret"))
+		engine = gin.New()
+		engine.Use(sessions.Sessions("session", store))
+		var err error
+		ctrl, err = NewAuthController(&AuthControllerConfig{
+			CallbackPath:     "/auth/{provider}/callback",
+			LoginPath:        "/auth/{provider}",
+			LogoutPath:       "/auth/logout",
+			UserInfoPath:     "/auth/user",
+			RedirectOnLogin:  "/",
+			RedirectOnLogout: "/",
+			Providers:        map[string]ProviderConfig{"gitlab": {Key: "k", Secret: "s"}},
+			UserCache:        &AuthUserCacheConfig{RefreshPath: "/auth/user/refresh"},
+		}, server.ControllerContext{SessionStore: store})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(ctrl.Bind(engine, LoginFunc)).To(Succeed())
+		DeferCleanup(ctrl.Close)
+		jar = map[string]*http.Cookie{}
+	})
+
+	// send sends a request with the cookies received so far and keeps the cookies of the response
+	send := func(method, path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		for _, c := range jar {
+			req.AddCookie(c)
+		}
+		w := httptest.NewRecorder()
+		engine.ServeHTTP(w, req)
+		for _, c := range w.Result().Cookies() {
+			if c.MaxAge < 0 {
+				delete(jar, c.Name)
+			} else {
+				jar[c.Name] = c
+			}
+		}
+		return w
+	}
+
+	login := func(code string) {
+		location, err := url.Parse(send(http.MethodGet, "/auth/gitlab").Header().Get("Location"))
+		Expect(err).NotTo(HaveOccurred())
+		w := send(http.MethodGet, "/auth/gitlab/callback?code="+code+"&state="+location.Query().Get("state"))
+		Expect(w.Header().Get("Location")).To(Equal("/"))
+	}
+
+	user := func(w *httptest.ResponseRecorder) UserObject {
+		Expect(w.Code).To(Equal(http.StatusOK))
+		var object UserObject
+		Expect(json.Unmarshal(w.Body.Bytes(), &object)).To(Succeed())
+		return object
+	}
+
+	It("should validate the configuration", func() {
+		Expect(AuthUserCacheConfig{}.Validate()).To(Succeed())
+		Expect(AuthUserCacheConfig{TTL: -time.Second}.Validate()).To(MatchError(ContainSubstring("ttl")))
+	})
+
+	It("should complete the partial profiles with the cached profile", func() {
+		profiles = []goth.User{
+			{Email: "ana@example.org", Name: "Ana", AvatarURL: "https://example.org/ana.png"},
+			{Name: "Ana García"},
+		}
+		login("first")
+		login("second")
+
+		object := user(send(http.MethodGet, "/auth/user"))
+		Expect(object.Id).To(Equal("ana@example.org"))
+		Expect(object.User.Name).To(Equal("Ana García"))
+		Expect(object.User.AvatarURL).To(Equal("https://example.org/ana.png"))
+		Expect(object.User.AccessToken).To(Equal("token-second"))
+	})
+
+	It("should refresh the profile of the signed-in user", func() {
+		profiles = []goth.User{
+			{Email: "ana@example.org", Name: "Ana"},
+			{Name: "Ana García", Location: "Madrid"},
+		}
+		login("code")
+		object := user(send(http.MethodPost, "/auth/user/refresh"))
+		Expect(object.Id).To(Equal("ana@example.org"))
+		Expect(object.User.Name).To(Equal("Ana García"))
+		Expect(object.User.Email).To(Equal("ana@example.org"))
+		Expect(object.User.AccessToken).To(Equal("token-code"))
+		Expect(user(send(http.MethodGet, "/auth/user")).User.Location).To(Equal("Madrid"))
+
+		// The provider refuses further refreshes
+		Expect(send(http.MethodPost, "/auth/user/refresh").Code).To(Equal(http.StatusBadGateway))
+	})
+
+	It("should require a signed-in user to refresh", func() {
+		Expect(send(http.MethodPost, "/auth/user/refresh").Code).To(Equal(http.StatusUnauthorized))
+	})
+
+	It("should keep the profiles without tokens until they expire", func() {
+		cache := &memoryUserCache{users: make(map[string]memoryUser)}
+		ctx := context.Background()
+		Expect(cache.Set(ctx, goth.User{Provider: "gitlab", UserID: "42", Name: "Ana", AccessToken: "secret"}, 50*time.Millisecond)).To(Succeed())
+		cached, ok, err := cache.Get(ctx, "gitlab", "42")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(ok).To(BeTrue())
+		Expect(cached.Name).To(Equal("Ana"))
+		Expect(cached.AccessToken).To(BeEmpty())
+
+		Eventually(func() bool {
+			_, ok, _ := cache.Get(ctx, "gitlab", "42")
+			return ok
+		}).Should(BeFalse())
+	})
+})
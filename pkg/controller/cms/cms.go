@@ -0,0 +1,368 @@
+// Package cms provides a content management controller: Markdown posts written by the signed-in
+// users, listed in HTML pages and an Atom feed, with an admin area listing the posts of each user.
+// The posts are kept in a Store, PostgreSQL or SQLite, injected when the controller type is
+// registered:
+//
+//	server.RegisterController("cms", cms.NewController(cms.NewPostgresStore(pool)))
+//
+// Pages are rendered with the templates loaded by the template controller.
+package cms
+
+import (
+	"context"
+	"html/template"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/animalet/sargantana-go/internal/snapshot"
+	"github.com/animalet/sargantana-go/pkg/controller/profile"
+	"github.com/animalet/sargantana-go/pkg/query"
+	"github.com/animalet/sargantana-go/pkg/server"
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	defaultTitle         = "Blog"
+	defaultPageSize      = 10
+	maxPageSize          = 100
+	defaultListTemplate  = "articles.html"
+	defaultAdminTemplate = "admin.html"
+	migrationTimeout     = time.Minute
+)
+
+// Config holds the routes and the presentation of a CMS controller
+type Config struct {
+	// FeedPath lists the latest posts
+	FeedPath string `yaml:"feed_path"`
+	// PostPath shows the posts on PostPath/:id, creates and updates them on POST, and deletes them
+	// on DELETE PostPath/:id. Changes require authentication and are allowed to the owner only.
+	PostPath string `yaml:"post_path"`
+	// AdminAreaPath lists the posts of the signed-in user, and requires authentication
+	AdminAreaPath string `yaml:"admin_area_path"`
+	// AtomPath serves the Atom feed of the latest posts. Disabled if empty.
+	AtomPath string `yaml:"atom_path,omitempty"`
+	// Title is the title of the pages and the feed. Defaults to "Blog".
+	Title string `yaml:"title,omitempty"`
+	// PageSize is the number of posts of the pages without a per_page parameter, and of the feed.
+	// Defaults to 10.
+	PageSize int `yaml:"page_size,omitempty"`
+	// ListTemplate renders the post lists and the single posts. Defaults to articles.html.
+	ListTemplate string `yaml:"list_template,omitempty"`
+	// AdminTemplate renders the admin area. Defaults to admin.html.
+	AdminTemplate string `yaml:"admin_template,omitempty"`
+}
+
+func (c Config) Validate() error {
+	if c.FeedPath == "" {
+		return errors.New("feed_path must be set and non-empty")
+	}
+	if c.PostPath == "" {
+		return errors.New("post_path must be set and non-empty")
+	}
+	if c.AdminAreaPath == "" {
+		return errors.New("admin_area_path must be set and non-empty")
+	}
+	if c.PageSize < 0 {
+		return errors.New("page_size must not be negative")
+	}
+	return nil
+}
+
+// NewController returns the factory of CMS controllers keeping their posts in the store. The
+// store is migrated when the first controller is created.
+func NewController(store Store) func(cfg *Config, ctx server.ControllerContext) (server.IController, error) {
+	return func(cfg *Config, ctx server.ControllerContext) (server.IController, error) {
+		if store == nil {
+			return nil, errors.New("cms store must not be nil")
+		}
+		// Deep copy the config to enforce immutability
+		configCopy := snapshot.MustCopy(cfg)
+
+		migrationCtx, cancel := context.WithTimeout(context.Background(), migrationTimeout)
+		defer cancel()
+		if err := store.Migrate(migrationCtx); err != nil {
+			return nil, errors.Wrap(err, "failed to migrate the cms store")
+		}
+
+		b := &cms{
+			store:         store,
+			feedPath:      ctx.BasePath + configCopy.FeedPath,
+			postPath:      ctx.BasePath + configCopy.PostPath,
+			adminPath:     ctx.BasePath + configCopy.AdminAreaPath,
+			config:        configCopy,
+			title:         configCopy.Title,
+			listTemplate:  configCopy.ListTemplate,
+			adminTemplate: configCopy.AdminTemplate,
+			optionalAuth:  ctx.OptionalAuth(),
+			login:         ctx.LoginMiddleware(),
+		}
+		if configCopy.AtomPath != "" {
+			b.atomPath = ctx.BasePath + configCopy.AtomPath
+		}
+		if b.title == "" {
+			b.title = defaultTitle
+		}
+		pageSize := configCopy.PageSize
+		if pageSize == 0 {
+			pageSize = defaultPageSize
+		}
+		b.schema = query.Schema{
+			Fields:         Fields,
+			DefaultSort:    "-id",
+			DefaultPerPage: pageSize,
+			MaxPerPage:     max(maxPageSize, pageSize),
+		}
+		if b.listTemplate == "" {
+			b.listTemplate = defaultListTemplate
+		}
+		if b.adminTemplate == "" {
+			b.adminTemplate = defaultAdminTemplate
+		}
+
+		log.Info().
+			Str("feed_path", b.feedPath).
+			Str("post_path", b.postPath).
+			Str("admin_area_path", b.adminPath).
+			Str("atom_path", b.atomPath).
+			Msg("CMS configured")
+		return b, nil
+	}
+}
+
+// cms is the CMS controller. The paths are absolute, including the base path of the binding,
+// for the links and redirects.
+type cms struct {
+	store         Store
+	config        *Config
+	feedPath      string
+	postPath      string
+	adminPath     string
+	atomPath      string // Empty if the feed is disabled
+	title         string
+	schema        query.Schema // Pagination, sort and filters of the lists
+	listTemplate  string
+	adminTemplate string
+	optionalAuth  gin.HandlerFunc // Identifies the signed-in users on the public pages
+	login         gin.HandlerFunc // Login middleware of the binding, used without one from Bind
+}
+
+// postView is a post with its rendered content
+type postView struct {
+	Post
+	HTML template.HTML `json:"html"`
+}
+
+// postForm is the form, or JSON body, creating a post, or updating it when Id is set
+type postForm struct {
+	Id      int64  `form:"id" json:"id"`
+	Title   string `form:"title" json:"title" binding:"required"`
+	Content string `form:"content" json:"content" binding:"required"`
+}
+
+func (b *cms) Bind(engine *gin.Engine, loginMiddleware gin.HandlerFunc) error {
+	return b.BindGroup(&engine.RouterGroup, loginMiddleware)
+}
+
+func (b *cms) BindGroup(group *gin.RouterGroup, loginMiddleware gin.HandlerFunc) error {
+	if loginMiddleware == nil {
+		loginMiddleware = b.login
+	}
+	group.GET(b.config.FeedPath, b.optionalAuth, b.getFeed)
+	group.GET(b.config.PostPath+"/:id", b.optionalAuth, b.getPost)
+	group.POST(b.config.PostPath, loginMiddleware, b.savePost)
+	group.DELETE(b.config.PostPath+"/:id", loginMiddleware, b.deletePost)
+	group.GET(b.config.AdminAreaPath, loginMiddleware, b.adminArea)
+	if b.config.AtomPath != "" {
+		group.GET(b.config.AtomPath, b.getAtomFeed)
+	}
+	return nil
+}
+
+// Close does nothing, the store is owned by the application
+func (b *cms) Close() error { return nil }
+
+func (b *cms) getFeed(c *gin.Context) {
+	b.renderList(c, b.listTemplate, "")
+}
+
+func (b *cms) adminArea(c *gin.Context) {
+	b.renderList(c, b.adminTemplate, server.UserId(c))
+}
+
+// renderList renders a page of the posts of an owner, or of everyone if empty. The page, the
+// sort and the filters are taken from the query parameters, see query.Schema.Parse.
+func (b *cms) renderList(c *gin.Context, name, owner string) {
+	options, err := b.schema.ParseRequest(c)
+	if err != nil {
+		server.RespondError(c, http.StatusBadRequest)
+		return
+	}
+	posts, more, err := b.store.List(c.Request.Context(), owner, options)
+	if err != nil {
+		b.fail(c, err)
+		return
+	}
+	nextPage := 0
+	if more {
+		nextPage = options.Page + 1
+	}
+	views, err := render(posts)
+	if err != nil {
+		b.fail(c, err)
+		return
+	}
+	c.HTML(http.StatusOK, name, b.templateData(c, gin.H{
+		"feed":      views,
+		"page":      options.Page,
+		"prev_page": options.Page - 1,
+		"next_page": nextPage,
+	}))
+}
+
+func (b *cms) getPost(c *gin.Context) {
+	p, ok := b.loadPost(c)
+	if !ok {
+		return
+	}
+	views, err := render([]Post{p})
+	if err != nil {
+		b.fail(c, err)
+		return
+	}
+	if c.NegotiateFormat(gin.MIMEHTML, gin.MIMEJSON) == gin.MIMEJSON {
+		c.JSON(http.StatusOK, views[0])
+		return
+	}
+	c.HTML(http.StatusOK, b.listTemplate, b.templateData(c, gin.H{"feed": views, "page": 1, "prev_page": 0, "next_page": 0}))
+}
+
+// savePost creates a post, or updates it if the form has an ID and the user owns it. Forms are
+// redirected to the post, JSON requests are answered with the post.
+func (b *cms) savePost(c *gin.Context) {
+	user := server.UserId(c)
+	if user == "" {
+		server.RespondError(c, http.StatusUnauthorized)
+		return
+	}
+	var form postForm
+	if err := c.ShouldBind(&form); err != nil {
+		server.RespondError(c, http.StatusBadRequest)
+		return
+	}
+
+	ctx := c.Request.Context()
+	status, id := http.StatusOK, form.Id
+	if id != 0 {
+		p, err := b.store.Get(ctx, id)
+		if err != nil {
+			b.fail(c, err)
+			return
+		}
+		if p.Owner != user {
+			server.RespondError(c, http.StatusForbidden)
+			return
+		}
+		if err := b.store.Update(ctx, Post{Id: id, Title: form.Title, Content: form.Content}); err != nil {
+			b.fail(c, err)
+			return
+		}
+	} else {
+		var err error
+		if id, err = b.store.Create(ctx, Post{Title: form.Title, Content: form.Content, Owner: user}); err != nil {
+			b.fail(c, err)
+			return
+		}
+		status = http.StatusCreated
+	}
+
+	location := b.postPath + "/" + strconv.FormatInt(id, 10)
+	if c.ContentType() != gin.MIMEJSON {
+		c.Redirect(http.StatusSeeOther, location)
+		return
+	}
+	p, err := b.store.Get(ctx, id)
+	if err != nil {
+		b.fail(c, err)
+		return
+	}
+	views, err := render([]Post{p})
+	if err != nil {
+		b.fail(c, err)
+		return
+	}
+	c.Header("Location", location)
+	c.JSON(status, views[0])
+}
+
+func (b *cms) deletePost(c *gin.Context) {
+	p, ok := b.loadPost(c)
+	if !ok {
+		return
+	}
+	if user := server.UserId(c); user == "" || p.Owner != user {
+		server.RespondError(c, http.StatusForbidden)
+		return
+	}
+	if err := b.store.Delete(c.Request.Context(), p.Id); err != nil {
+		b.fail(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// loadPost reads the post of the id path parameter, answering 404 if there is none
+func (b *cms) loadPost(c *gin.Context) (Post, bool) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		server.RespondError(c, http.StatusNotFound)
+		return Post{}, false
+	}
+	p, err := b.store.Get(c.Request.Context(), id)
+	if err != nil {
+		b.fail(c, err)
+		return Post{}, false
+	}
+	return p, true
+}
+
+// templateData adds the signed-in user, their profile if loaded by the profile middleware, the title
+// and the paths of the CMS to the data of a page
+func (b *cms) templateData(c *gin.Context, data gin.H) gin.H {
+	data["user"] = server.UserId(c)
+	if p, ok := profile.FromContext(c); ok {
+		data["profile"] = p
+	}
+	data["title"] = b.title
+	data["feed_path"] = b.feedPath
+	data["post_path"] = b.postPath
+	data["admin_path"] = b.adminPath
+	data["atom_path"] = b.atomPath
+	return data
+}
+
+// fail answers 404 for missing posts, or logs the error and answers 500
+func (b *cms) fail(c *gin.Context, err error) {
+	if errors.Is(err, ErrNotFound) {
+		server.RespondError(c, http.StatusNotFound)
+		return
+	}
+	logger := server.RequestLogger(c)
+	logger.Error().Err(err).Msg("CMS request failed")
+	server.RespondError(c, http.StatusInternalServerError)
+}
+
+// render renders the content of the posts
+func render(posts []Post) ([]postView, error) {
+	views := make([]postView, 0, len(posts))
+	for _, p := range posts {
+		html, err := renderMarkdown(p.Content)
+		if err != nil {
+			return nil, err
+		}
+		views = append(views, postView{Post: p, HTML: html})
+	}
+	return views, nil
+}
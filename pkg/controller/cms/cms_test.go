@@ -0,0 +1,268 @@
+//go:build unit
+
+package cms
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"html/template"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/animalet/sargantana-go/pkg/query"
+	"github.com/animalet/sargantana-go/pkg/server"
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-contrib/sessions/cookie"
+	"github.com/gin-gonic/gin"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+// memoryStore is a Store keeping the posts in memory
+type memoryStore struct {
+	mu       sync.Mutex
+	posts    []Post
+	migrated int
+}
+
+func (m *memoryStore) Migrate(context.Context) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.migrated++
+	return nil
+}
+
+func (m *memoryStore) List(_ context.Context, owner string, options query.Options) ([]Post, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	posts := make([]Post, 0)
+	for i := len(m.posts) - 1; i >= 0; i-- {
+		if owner == "" || m.posts[i].Owner == owner {
+			posts = append(posts, m.posts[i])
+		}
+	}
+	posts = posts[min(options.Offset(), len(posts)):]
+	return posts[:min(options.PerPage, len(posts))], len(posts) > options.PerPage, nil
+}
+
+func (m *memoryStore) Get(_ context.Context, id int64) (Post, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, p := range m.posts {
+		if p.Id == id {
+			return p, nil
+		}
+	}
+	return Post{}, ErrNotFound
+}
+
+func (m *memoryStore) Create(_ context.Context, post Post) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	post.Id = int64(len(m.posts) + 1)
+	post.PublicationDate = time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC).Add(time.Duration(post.Id) * time.Hour)
+	post.UpdateDate = post.PublicationDate
+	m.posts = append(m.posts, post)
+	return post.Id, nil
+}
+
+func (m *memoryStore) Update(_ context.Context, post Post) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i, p := range m.posts {
+		if p.Id == post.Id {
+			m.posts[i].Title, m.posts[i].Content = post.Title, post.Content
+			return nil
+		}
+	}
+	return ErrNotFound
+}
+
+func (m *memoryStore) Delete(_ context.Context, id int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	i := slices.IndexFunc(m.posts, func(p Post) bool { return p.Id == id })
+	if i < 0 {
+		return ErrNotFound
+	}
+	m.posts = slices.Delete(m.posts, i, i+1)
+	return nil
+}
+
+var _ = Describe("CMS controller", func() {
+	var (
+		store  *memoryStore
+		engine *gin.Engine
+	)
+
+	cfg := Config{
+		FeedPath:      "/",
+		PostPath:      "/post",
+		AdminAreaPath: "/admin",
+		AtomPath:      "/feed.xml",
+		Title:         "Notes",
+		PageSize:      2,
+	}
+
+	BeforeEach(func() {
+		gin.SetMode(gin.TestMode)
+		store = &memoryStore{}
+		ctrl, err := NewController(store)(&cfg, server.ControllerContext{BasePath: "/blog"})
+		Expect(err).NotTo(HaveOccurred())
+
+		engine = gin.New()
+		engine.SetHTMLTemplate(template.Must(template.New("articles.html").Parse(
+			`{{.title}}|{{.user}}|{{range .feed}}[{{.Id}} {{.Title}} {{.HTML}}]{{end}}|{{.next_page}}`,
+		)))
+		engine.Use(sessions.Sessions("test", cookie.NewStore([]byte("secret"))))
+		engine.Use(func(c *gin.Context) {
+			if user := c.GetHeader("X-User"); user != "" {
+				c.Set(server.RemoteUserKey, user)
+			}
+		})
+		login := func(c *gin.Context) {
+			if _, ok := server.GetRemoteUser(c); !ok {
+				c.AbortWithStatus(http.StatusUnauthorized)
+			}
+		}
+		Expect(ctrl.(server.GroupController).BindGroup(engine.Group("/blog"), login)).To(Succeed())
+	})
+
+	request := func(method, path, user string, form url.Values) *httptest.ResponseRecorder {
+		var r *http.Request
+		if form != nil {
+			r = httptest.NewRequest(method, path, strings.NewReader(form.Encode()))
+			r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		} else {
+			r = httptest.NewRequest(method, path, nil)
+		}
+		if user != "" {
+			r.Header.Set("X-User", user)
+		}
+		w := httptest.NewRecorder()
+		engine.ServeHTTP(w, r)
+		return w
+	}
+
+	publish := func(user, title, content string) {
+		w := request(http.MethodPost, "/blog/post", user, url.Values{"title": {title}, "content": {content}})
+		Expect(w.Code).To(Equal(http.StatusSeeOther))
+	}
+
+	It("should validate the configuration", func() {
+		Expect(Config{}.Validate()).To(MatchError(ContainSubstring("feed_path")))
+		Expect(Config{FeedPath: "/"}.Validate()).To(MatchError(ContainSubstring("post_path")))
+		Expect(Config{FeedPath: "/", PostPath: "/post"}.Validate()).To(MatchError(ContainSubstring("admin_area_path")))
+		Expect(Config{FeedPath: "/", PostPath: "/post", AdminAreaPath: "/admin", PageSize: -1}.Validate()).To(MatchError(ContainSubstring("page_size")))
+		Expect(cfg.Validate()).To(Succeed())
+	})
+
+	It("should migrate the store and require one", func() {
+		Expect(store.migrated).To(Equal(1))
+		_, err := NewController(nil)(&cfg, server.ControllerContext{})
+		Expect(err).To(MatchError(ContainSubstring("store")))
+	})
+
+	It("should create posts and render them as markdown", func() {
+		w := request(http.MethodPost, "/blog/post", "alice", url.Values{"title": {"Hello"}, "content": {"**bold** <script>x</script>"}})
+		Expect(w.Code).To(Equal(http.StatusSeeOther))
+		Expect(w.Header().Get("Location")).To(Equal("/blog/post/1"))
+
+		w = request(http.MethodGet, "/blog/post/1", "", nil)
+		Expect(w.Code).To(Equal(http.StatusOK))
+		Expect(w.Body.String()).To(ContainSubstring("[1 Hello <p><strong>bold</strong>"))
+		Expect(w.Body.String()).NotTo(ContainSubstring("<script>"))
+	})
+
+	It("should answer JSON requests with the post", func() {
+		r := httptest.NewRequest(http.MethodPost, "/blog/post", strings.NewReader(`{"title":"Hi","content":"# Title"}`))
+		r.Header.Set("Content-Type", "application/json")
+		r.Header.Set("X-User", "alice")
+		w := httptest.NewRecorder()
+		engine.ServeHTTP(w, r)
+		Expect(w.Code).To(Equal(http.StatusCreated))
+		Expect(w.Header().Get("Location")).To(Equal("/blog/post/1"))
+
+		var view map[string]any
+		Expect(json.Unmarshal(w.Body.Bytes(), &view)).To(Succeed())
+		Expect(view).To(HaveKeyWithValue("owner", "alice"))
+		Expect(view).To(HaveKeyWithValue("html", "<h1>Title</h1>\n"))
+	})
+
+	It("should require authentication and valid forms to change posts", func() {
+		Expect(request(http.MethodPost, "/blog/post", "", url.Values{"title": {"a"}, "content": {"b"}}).Code).To(Equal(http.StatusUnauthorized))
+		Expect(request(http.MethodPost, "/blog/post", "alice", url.Values{"title": {"a"}}).Code).To(Equal(http.StatusBadRequest))
+		Expect(request(http.MethodGet, "/blog/admin", "", nil).Code).To(Equal(http.StatusUnauthorized))
+	})
+
+	It("should only let the owners update and delete their posts", func() {
+		publish("alice", "Mine", "text")
+
+		update := url.Values{"id": {"1"}, "title": {"Stolen"}, "content": {"text"}}
+		Expect(request(http.MethodPost, "/blog/post", "bob", update).Code).To(Equal(http.StatusForbidden))
+		Expect(request(http.MethodDelete, "/blog/post/1", "bob", nil).Code).To(Equal(http.StatusForbidden))
+
+		update.Set("title", "Edited")
+		Expect(request(http.MethodPost, "/blog/post", "alice", update).Code).To(Equal(http.StatusSeeOther))
+		Expect(store.Get(context.Background(), 1)).To(HaveField("Title", "Edited"))
+
+		Expect(request(http.MethodDelete, "/blog/post/1", "alice", nil).Code).To(Equal(http.StatusNoContent))
+		Expect(request(http.MethodGet, "/blog/post/1", "", nil).Code).To(Equal(http.StatusNotFound))
+		Expect(request(http.MethodDelete, "/blog/post/1", "alice", nil).Code).To(Equal(http.StatusNotFound))
+	})
+
+	It("should page the feed, newest first", func() {
+		publish("alice", "one", "1")
+		publish("bob", "two", "2")
+		publish("alice", "three", "3")
+
+		w := request(http.MethodGet, "/blog/", "alice", nil)
+		Expect(w.Code).To(Equal(http.StatusOK))
+		Expect(w.Body.String()).To(MatchRegexp(`(?s)^Notes\|alice\|\[3 three .*\]\[2 two .*\]\|2$`))
+
+		w = request(http.MethodGet, "/blog/?page=2", "", nil)
+		Expect(w.Body.String()).To(MatchRegexp(`(?s)^Notes\|\|\[1 one .*\]\|0$`))
+
+		w = request(http.MethodGet, "/blog/?per_page=3", "", nil)
+		Expect(w.Body.String()).To(MatchRegexp(`(?s)\[3 three .*\]\[2 two .*\]\[1 one .*\]\|0$`))
+	})
+
+	It("should reject invalid list parameters", func() {
+		Expect(request(http.MethodGet, "/blog/?sort=content", "", nil).Code).To(Equal(http.StatusBadRequest))
+		Expect(request(http.MethodGet, "/blog/?per_page=1000", "", nil).Code).To(Equal(http.StatusBadRequest))
+		Expect(request(http.MethodGet, "/blog/?filter=owner:like:a", "", nil).Code).To(Equal(http.StatusBadRequest))
+	})
+
+	It("should list the posts of the user in the admin area", func() {
+		engine.SetHTMLTemplate(template.Must(template.New("admin.html").Parse(`{{range .feed}}{{.Title}},{{end}}`)))
+		publish("alice", "one", "1")
+		publish("bob", "two", "2")
+
+		w := request(http.MethodGet, "/blog/admin", "bob", nil)
+		Expect(w.Code).To(Equal(http.StatusOK))
+		Expect(w.Body.String()).To(Equal("two,"))
+	})
+
+	It("should serve the atom feed", func() {
+		publish("alice", "one", "*1*")
+
+		w := request(http.MethodGet, "/blog/feed.xml", "", nil)
+		Expect(w.Code).To(Equal(http.StatusOK))
+		Expect(w.Header().Get("Content-Type")).To(Equal("application/atom+xml; charset=utf-8"))
+
+		var feed atomFeed
+		Expect(xml.Unmarshal(w.Body.Bytes(), &feed)).To(Succeed())
+		Expect(feed.Title).To(Equal("Notes"))
+		Expect(feed.Id).To(Equal("http://example.com/blog/"))
+		Expect(feed.Entries).To(HaveLen(1))
+		Expect(feed.Entries[0].Id).To(Equal("http://example.com/blog/post/1"))
+		Expect(feed.Entries[0].Author.Name).To(Equal("alice"))
+		Expect(feed.Entries[0].Content.Body).To(Equal("<p><em>1</em></p>\n"))
+	})
+})
@@ -0,0 +1,110 @@
+package cms
+
+import (
+	"encoding/xml"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const atomContentType = "application/atom+xml; charset=utf-8"
+
+// atomFeed is an Atom 1.0 feed (RFC 4287) of the latest posts
+type atomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	Title   string      `xml:"title"`
+	Id      string      `xml:"id"`
+	Updated string      `xml:"updated"`
+	Links   []atomLink  `xml:"link"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type atomLink struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr,omitempty"`
+}
+
+type atomEntry struct {
+	Title     string      `xml:"title"`
+	Id        string      `xml:"id"`
+	Link      atomLink    `xml:"link"`
+	Published string      `xml:"published"`
+	Updated   string      `xml:"updated"`
+	Author    atomAuthor  `xml:"author"`
+	Content   atomContent `xml:"content"`
+}
+
+type atomAuthor struct {
+	Name string `xml:"name"`
+}
+
+type atomContent struct {
+	Type string `xml:"type,attr"`
+	Body string `xml:",chardata"`
+}
+
+func (b *cms) getAtomFeed(c *gin.Context) {
+	// The feed has the latest posts, whatever the query parameters
+	options, err := b.schema.Parse(nil)
+	if err != nil {
+		b.fail(c, err)
+		return
+	}
+	posts, _, err := b.store.List(c.Request.Context(), "", options)
+	if err != nil {
+		b.fail(c, err)
+		return
+	}
+
+	origin := requestOrigin(c)
+	feed := atomFeed{
+		Title: b.title,
+		Id:    origin + b.feedPath,
+		Links: []atomLink{
+			{Href: origin + b.atomPath, Rel: "self"},
+			{Href: origin + b.feedPath},
+		},
+		Entries: make([]atomEntry, 0, len(posts)),
+	}
+	updated := time.Time{}
+	for _, p := range posts {
+		html, err := renderMarkdown(p.Content)
+		if err != nil {
+			b.fail(c, err)
+			return
+		}
+		link := origin + b.postPath + "/" + strconv.FormatInt(p.Id, 10)
+		feed.Entries = append(feed.Entries, atomEntry{
+			Title:     p.Title,
+			Id:        link,
+			Link:      atomLink{Href: link},
+			Published: p.PublicationDate.UTC().Format(time.RFC3339),
+			Updated:   p.UpdateDate.UTC().Format(time.RFC3339),
+			Author:    atomAuthor{Name: p.Owner},
+			Content:   atomContent{Type: "html", Body: string(html)},
+		})
+		if p.UpdateDate.After(updated) {
+			updated = p.UpdateDate
+		}
+	}
+	feed.Updated = updated.UTC().Format(time.RFC3339)
+
+	out, err := xml.Marshal(feed)
+	if err != nil {
+		b.fail(c, err)
+		return
+	}
+	c.Data(http.StatusOK, atomContentType, append([]byte(xml.Header), out...))
+}
+
+// requestOrigin returns the scheme and the host the request was sent to, for the absolute links
+// of the feed
+func requestOrigin(c *gin.Context) string {
+	scheme := "http"
+	if c.Request.TLS != nil {
+		scheme = "https"
+	}
+	return scheme + "://" + c.Request.Host
+}
@@ -0,0 +1,25 @@
+package cms
+
+import (
+	"bytes"
+	"html/template"
+
+	"github.com/pkg/errors"
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/extension"
+)
+
+// markdown converts the posts to HTML, with the GitHub flavoured extensions (tables, strikethrough,
+// task lists and autolinks). Raw HTML and dangerous links in the posts are left out of the output,
+// so that authors cannot inject scripts in the pages.
+var markdown = goldmark.New(goldmark.WithExtensions(extension.GFM))
+
+// renderMarkdown renders the Markdown content of a post as HTML, safe to be inserted in templates
+func renderMarkdown(content string) (template.HTML, error) {
+	var out bytes.Buffer
+	if err := markdown.Convert([]byte(content), &out); err != nil {
+		return "", errors.Wrap(err, "failed to render markdown")
+	}
+	// #nosec G203 -- goldmark escapes the content and omits raw HTML
+	return template.HTML(out.String()), nil
+}
@@ -0,0 +1,187 @@
+package cms
+
+import (
+	"context"
+	"database/sql"
+	"slices"
+	"time"
+
+	"github.com/animalet/sargantana-go/internal/sqlstore"
+	"github.com/animalet/sargantana-go/pkg/query"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/jackc/pgx/v5/stdlib"
+	"github.com/pkg/errors"
+)
+
+// ErrNotFound is returned by stores for posts that do not exist
+var ErrNotFound = errors.New("post not found")
+
+// Post is an article of the CMS. Content is Markdown, rendered to HTML when the post is shown.
+type Post struct {
+	Id              int64     `json:"id"`
+	Title           string    `json:"title"`
+	Content         string    `json:"content"`
+	PublicationDate time.Time `json:"publication_date"`
+	UpdateDate      time.Time `json:"update_date"`
+	Owner           string    `json:"owner"`
+}
+
+// Store persists the posts of the CMS. Stores are injected in the controller factory, see
+// NewController, so that their connections are owned and closed by the application.
+type Store interface {
+	// Migrate creates or upgrades the tables of the store. Called once by NewController.
+	Migrate(ctx context.Context) error
+	// List returns a page of the posts of an owner, or of everyone if owner is empty, telling
+	// whether there are more. The columns of the options are those of Fields.
+	List(ctx context.Context, owner string, options query.Options) (posts []Post, more bool, err error)
+	// Get returns a post, or ErrNotFound.
+	Get(ctx context.Context, id int64) (Post, error)
+	// Create stores a new post, returning its ID.
+	Create(ctx context.Context, post Post) (int64, error)
+	// Update changes the title and the content of a post, or returns ErrNotFound.
+	Update(ctx context.Context, post Post) error
+	// Delete removes a post, or returns ErrNotFound.
+	Delete(ctx context.Context, id int64) error
+}
+
+var (
+	postgresDialect = sqlstore.Dialect{
+		Name:     "postgres",
+		Numbered: true,
+		Clauses:  query.Postgres,
+		Lock:     "SELECT pg_advisory_xact_lock(4242001)",
+		Migrations: []string{
+			// The posts table of the blog example
+			`CREATE TABLE IF NOT EXISTS posts (
+				id SERIAL PRIMARY KEY,
+				title TEXT NOT NULL,
+				content TEXT NOT NULL,
+				publication_date TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+				owner TEXT NOT NULL
+			)`,
+			`ALTER TABLE posts ADD COLUMN IF NOT EXISTS update_date TIMESTAMP;
+			CREATE INDEX IF NOT EXISTS posts_owner_idx ON posts (owner, id)`,
+		},
+	}
+	sqliteDialect = sqlstore.Dialect{
+		Name:    "sqlite",
+		Clauses: query.SQLite,
+		Migrations: []string{
+			`CREATE TABLE IF NOT EXISTS posts (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				title TEXT NOT NULL,
+				content TEXT NOT NULL,
+				publication_date TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+				owner TEXT NOT NULL
+			)`,
+			`ALTER TABLE posts ADD COLUMN update_date TIMESTAMP;
+			CREATE INDEX IF NOT EXISTS posts_owner_idx ON posts (owner, id)`,
+		},
+	}
+)
+
+// sqlStore is a Store on a database/sql database
+type sqlStore struct {
+	sqlstore.Store
+}
+
+// NewPostgresStore returns a Store keeping the posts in PostgreSQL. The pool is not closed by the
+// store.
+func NewPostgresStore(pool *pgxpool.Pool) Store {
+	return &sqlStore{sqlstore.Store{DB: stdlib.OpenDBFromPool(pool), Dialect: postgresDialect, MigrationsTable: "cms_migrations"}}
+}
+
+// NewSQLiteStore returns a Store keeping the posts in SQLite. The database must be opened with a
+// SQLite driver registered by the application, e.g. modernc.org/sqlite, and is not closed by the
+// store.
+func NewSQLiteStore(db *sql.DB) Store {
+	return &sqlStore{sqlstore.Store{DB: db, Dialect: sqliteDialect, MigrationsTable: "cms_migrations"}}
+}
+
+const postColumns = "id, title, content, publication_date, COALESCE(update_date, publication_date), owner"
+
+// Fields are the fields the posts can be sorted and filtered by, see query.Schema
+var Fields = map[string]query.Field{
+	"id":        {Column: "id", Type: query.Int},
+	"title":     {Column: "title", Type: query.String},
+	"owner":     {Column: "owner", Type: query.String},
+	"published": {Column: "publication_date", Type: query.Time},
+	"updated":   {Column: "COALESCE(update_date, publication_date)", Type: query.Time},
+}
+
+func (s *sqlStore) List(ctx context.Context, owner string, options query.Options) ([]Post, bool, error) {
+	if owner != "" {
+		options.Filters = append(slices.Clip(options.Filters), query.Filter{Column: "owner", Op: query.Eq, Value: owner})
+	}
+	clauses := options.SQL(s.Dialect.Clauses, 0)
+	// An extra post tells whether there are more
+	clauses.Limit++
+	rows, err := s.DB.QueryContext(ctx, "SELECT "+postColumns+" FROM posts"+clauses.String(), clauses.Args...)
+	if err != nil {
+		return nil, false, errors.Wrap(err, "failed to list posts")
+	}
+	defer func() { _ = rows.Close() }()
+
+	posts := make([]Post, 0, clauses.Limit)
+	for rows.Next() {
+		var p Post
+		if err := rows.Scan(&p.Id, &p.Title, &p.Content, &p.PublicationDate, &p.UpdateDate, &p.Owner); err != nil {
+			return nil, false, errors.Wrap(err, "failed to read post")
+		}
+		posts = append(posts, p)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, false, errors.Wrap(err, "failed to list posts")
+	}
+	if len(posts) > options.PerPage {
+		return posts[:options.PerPage], true, nil
+	}
+	return posts, false, nil
+}
+
+func (s *sqlStore) Get(ctx context.Context, id int64) (Post, error) {
+	var p Post
+	err := s.DB.QueryRowContext(ctx, s.Rebind("SELECT "+postColumns+" FROM posts WHERE id = ?"), id).
+		Scan(&p.Id, &p.Title, &p.Content, &p.PublicationDate, &p.UpdateDate, &p.Owner)
+	if errors.Is(err, sql.ErrNoRows) {
+		return p, ErrNotFound
+	}
+	return p, errors.Wrap(err, "failed to read post")
+}
+
+func (s *sqlStore) Create(ctx context.Context, post Post) (int64, error) {
+	var id int64
+	err := s.DB.QueryRowContext(ctx,
+		s.Rebind("INSERT INTO posts (title, content, publication_date, owner) VALUES (?, ?, ?, ?) RETURNING id"),
+		post.Title, post.Content, time.Now().UTC(), post.Owner,
+	).Scan(&id)
+	return id, errors.Wrap(err, "failed to create post")
+}
+
+func (s *sqlStore) Update(ctx context.Context, post Post) error {
+	result, err := s.DB.ExecContext(ctx,
+		s.Rebind("UPDATE posts SET title = ?, content = ?, update_date = ? WHERE id = ?"),
+		post.Title, post.Content, time.Now().UTC(), post.Id,
+	)
+	return affected(result, err, "failed to update post")
+}
+
+func (s *sqlStore) Delete(ctx context.Context, id int64) error {
+	result, err := s.DB.ExecContext(ctx, s.Rebind("DELETE FROM posts WHERE id = ?"), id)
+	return affected(result, err, "failed to delete post")
+}
+
+// affected returns ErrNotFound when a statement changed no rows
+func affected(result sql.Result, err error, message string) error {
+	if err != nil {
+		return errors.Wrap(err, message)
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return errors.Wrap(err, message)
+	}
+	if rows == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
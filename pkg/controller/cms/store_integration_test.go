@@ -0,0 +1,62 @@
+//go:build integration
+
+package cms
+
+import (
+	"context"
+	"net/url"
+
+	"github.com/animalet/sargantana-go/pkg/database"
+	"github.com/animalet/sargantana-go/pkg/query"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("PostgreSQL store", func() {
+	var store Store
+
+	BeforeEach(func() {
+		pool, err := database.PostgresConfig{
+			Host:     "localhost",
+			Port:     5432,
+			User:     "user",
+			Password: "password",
+			Database: "my_blog_db",
+			SSLMode:  "disable",
+		}.CreateClient()
+		Expect(err).NotTo(HaveOccurred())
+		DeferCleanup(pool.Close)
+		store = NewPostgresStore(pool)
+	})
+
+	It("should migrate idempotently", func() {
+		Expect(store.Migrate(context.Background())).To(Succeed())
+		Expect(store.Migrate(context.Background())).To(Succeed())
+	})
+
+	It("should create, list, update and delete posts", func() {
+		ctx := context.Background()
+		Expect(store.Migrate(ctx)).To(Succeed())
+
+		id, err := store.Create(ctx, Post{Title: "Title", Content: "*content*", Owner: "cms-integration"})
+		Expect(err).NotTo(HaveOccurred())
+		DeferCleanup(func() { _ = store.Delete(context.Background(), id) })
+
+		options, err := query.Schema{Fields: Fields}.Parse(url.Values{"filter": {"title:contains:TIT"}, "sort": {"-published"}})
+		Expect(err).NotTo(HaveOccurred())
+		posts, _, err := store.List(ctx, "cms-integration", options)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(posts).To(ContainElement(HaveField("Id", id)))
+
+		Expect(store.Update(ctx, Post{Id: id, Title: "Edited", Content: "text"})).To(Succeed())
+		post, err := store.Get(ctx, id)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(post.Title).To(Equal("Edited"))
+		Expect(post.UpdateDate).NotTo(BeTemporally("<", post.PublicationDate))
+
+		Expect(store.Delete(ctx, id)).To(Succeed())
+		_, err = store.Get(ctx, id)
+		Expect(err).To(MatchError(ErrNotFound))
+		Expect(store.Delete(ctx, id)).To(MatchError(ErrNotFound))
+	})
+})
@@ -0,0 +1,13 @@
+package cms
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+func TestCMS(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "CMS Suite")
+}
@@ -0,0 +1,131 @@
+// Package consent keeps track of the OAuth scopes the users granted to the providers of the auth
+// controller, recorded on each login, and lets them review and revoke their consents. The consents
+// are kept in a Store, PostgreSQL or SQLite, injected when the controller type is registered and
+// handed to the auth controller:
+//
+//	store := consent.NewPostgresStore(pool)
+//	controller.ConsentStore = store
+//	server.RegisterController("consents", consent.NewController(store))
+package consent
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/animalet/sargantana-go/internal/snapshot"
+	"github.com/animalet/sargantana-go/pkg/server"
+	"github.com/animalet/sargantana-go/pkg/sessionsx"
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+)
+
+const migrationTimeout = time.Minute
+
+// Config holds the route of a consents controller
+type Config struct {
+	// Path lists the consents of the signed-in user on GET, and revokes their consents to a provider
+	// on DELETE Path/:provider. Both require authentication.
+	Path string `yaml:"path"`
+}
+
+func (c Config) Validate() error {
+	if c.Path == "" {
+		return errors.New("path must be set and non-empty")
+	}
+	return nil
+}
+
+// NewController returns the factory of consents controllers reading the consents from the store.
+// The store is migrated when a controller is created.
+func NewController(store Store) func(cfg *Config, ctx server.ControllerContext) (server.IController, error) {
+	return func(cfg *Config, ctx server.ControllerContext) (server.IController, error) {
+		if store == nil {
+			return nil, errors.New("consent store must not be nil")
+		}
+		// Deep copy the config to enforce immutability
+		configCopy := snapshot.MustCopy(cfg)
+
+		migrationCtx, cancel := context.WithTimeout(context.Background(), migrationTimeout)
+		defer cancel()
+		if err := store.Migrate(migrationCtx); err != nil {
+			return nil, errors.Wrap(err, "failed to migrate the consent store")
+		}
+		log.Info().Str("path", ctx.BasePath+configCopy.Path).Msg("Consents controller configured")
+		return &controller{store: store, config: configCopy, login: ctx.LoginMiddleware()}, nil
+	}
+}
+
+type controller struct {
+	store  Store
+	config *Config
+	login  gin.HandlerFunc // Login middleware of the binding, used without one from Bind
+}
+
+func (p *controller) Bind(engine *gin.Engine, loginMiddleware gin.HandlerFunc) error {
+	return p.BindGroup(&engine.RouterGroup, loginMiddleware)
+}
+
+func (p *controller) BindGroup(group *gin.RouterGroup, loginMiddleware gin.HandlerFunc) error {
+	if loginMiddleware == nil {
+		loginMiddleware = p.login
+	}
+	group.GET(p.config.Path, loginMiddleware, p.list)
+	group.DELETE(p.config.Path+"/:provider", loginMiddleware, p.revoke)
+	return nil
+}
+
+// Close does nothing, the store is owned by the application
+func (p *controller) Close() error { return nil }
+
+func (p *controller) list(c *gin.Context) {
+	user := server.UserId(c)
+	if user == "" {
+		server.RespondError(c, http.StatusUnauthorized)
+		return
+	}
+	consents, err := p.store.List(c.Request.Context(), user)
+	if err != nil {
+		p.fail(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, consents)
+}
+
+// revoke removes the consents of the user to a provider. Sessions signed in with the provider are
+// signed out, so that the provider tokens are no longer used.
+func (p *controller) revoke(c *gin.Context) {
+	user := server.UserId(c)
+	if user == "" {
+		server.RespondError(c, http.StatusUnauthorized)
+		return
+	}
+	provider := c.Param("provider")
+	if err := p.store.Revoke(c.Request.Context(), user, provider); err != nil {
+		p.fail(c, err)
+		return
+	}
+	server.Audit(c, "consent_revoked", map[string]any{"provider": provider})
+	if _, ok := c.Get(sessions.DefaultKey); ok {
+		if session, ok := sessionsx.User(c); ok && session.User.Provider == provider {
+			if err := sessionsx.Clear(c); err != nil {
+				p.fail(c, err)
+				return
+			}
+		}
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// fail answers 404 for missing consents, or logs the error and answers 500
+func (p *controller) fail(c *gin.Context, err error) {
+	if errors.Is(err, ErrNotFound) {
+		server.RespondError(c, http.StatusNotFound)
+		return
+	}
+	logger := server.RequestLogger(c)
+	logger.Error().Err(err).Msg("Consent request failed")
+	server.RespondError(c, http.StatusInternalServerError)
+}
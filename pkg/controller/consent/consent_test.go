@@ -0,0 +1,200 @@
+//go:build unit
+
+package consent
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/animalet/sargantana-go/pkg/server"
+	"github.com/animalet/sargantana-go/pkg/sessionsx"
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-contrib/sessions/cookie"
+	"github.com/gin-gonic/gin"
+	"github.com/markbates/goth"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/pkg/errors"
+)
+
+// memoryStore is a Store keeping the consents in memory
+type memoryStore struct {
+	mu       sync.Mutex
+	consents []Consent
+	migrated int
+	err      error
+}
+
+func (m *memoryStore) Migrate(context.Context) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.migrated++
+	return m.err
+}
+
+func (m *memoryStore) Grant(_ context.Context, consent Consent) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.err != nil {
+		return m.err
+	}
+	consent.Scopes = normalize(consent.Scopes)
+	for i, c := range m.consents {
+		if c.User == consent.User && c.Provider == consent.Provider && c.Client == consent.Client {
+			m.consents[i].Scopes = consent.Scopes
+			return nil
+		}
+	}
+	m.consents = append(m.consents, consent)
+	return nil
+}
+
+func (m *memoryStore) List(_ context.Context, user string) ([]Consent, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.err != nil {
+		return nil, m.err
+	}
+	consents := make([]Consent, 0)
+	for _, c := range m.consents {
+		if c.User == user {
+			consents = append(consents, c)
+		}
+	}
+	slices.SortFunc(consents, func(a, b Consent) int {
+		return strings.Compare(a.Provider+" "+a.Client, b.Provider+" "+b.Client)
+	})
+	return consents, nil
+}
+
+func (m *memoryStore) Revoke(_ context.Context, user, provider string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.err != nil {
+		return m.err
+	}
+	n := len(m.consents)
+	m.consents = slices.DeleteFunc(m.consents, func(c Consent) bool { return c.User == user && c.Provider == provider })
+	if len(m.consents) == n {
+		return ErrNotFound
+	}
+	return nil
+}
+
+var _ = Describe("Consents controller", func() {
+	var (
+		store  *memoryStore
+		engine *gin.Engine
+	)
+
+	cfg := Config{Path: "/consents"}
+
+	BeforeEach(func() {
+		gin.SetMode(gin.TestMode)
+		store = &memoryStore{}
+		ctrl, err := NewController(store)(&cfg, server.ControllerContext{})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(store.migrated).To(Equal(1))
+
+		engine = gin.New()
+		engine.Use(sessions.Sessions("session", cookie.NewStore([]byte("secret"))))
+		engine.GET("/signin/:provider", func(c *gin.Context) {
+			Expect(sessionsx.Set(c, sessionsx.UserKey, sessionsx.UserObject{
+				Id:   "alice",
+				User: goth.User{UserID: "alice", Provider: c.Param("provider")},
+			})).To(Succeed())
+		})
+		engine.GET("/whoami", func(c *gin.Context) {
+			user, _ := sessionsx.User(c)
+			c.String(http.StatusOK, user.Id)
+		})
+		login := func(c *gin.Context) {
+			if _, ok := sessionsx.User(c); !ok {
+				c.AbortWithStatus(http.StatusUnauthorized)
+			}
+		}
+		Expect(ctrl.(server.GroupController).BindGroup(engine.Group("/account"), login)).To(Succeed())
+	})
+
+	// request runs a request carrying the cookies of the previous response, if any
+	request := func(method, path string, previous *httptest.ResponseRecorder) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, path, nil)
+		if previous != nil {
+			for _, c := range previous.Result().Cookies() {
+				r.AddCookie(c)
+			}
+		}
+		w := httptest.NewRecorder()
+		engine.ServeHTTP(w, r)
+		return w
+	}
+
+	It("should validate the config", func() {
+		Expect(Config{}.Validate()).To(MatchError(ContainSubstring("path must be set")))
+		Expect(cfg.Validate()).To(Succeed())
+	})
+
+	It("should refuse a nil store", func() {
+		_, err := NewController(nil)(&cfg, server.ControllerContext{})
+		Expect(err).To(MatchError(ContainSubstring("must not be nil")))
+	})
+
+	It("should fail when the store cannot be migrated", func() {
+		_, err := NewController(&memoryStore{err: errors.New("boom")})(&cfg, server.ControllerContext{})
+		Expect(err).To(MatchError(ContainSubstring("failed to migrate the consent store")))
+	})
+
+	It("should require authentication", func() {
+		Expect(request(http.MethodGet, "/account/consents", nil).Code).To(Equal(http.StatusUnauthorized))
+		Expect(request(http.MethodDelete, "/account/consents/github", nil).Code).To(Equal(http.StatusUnauthorized))
+	})
+
+	It("should list the consents of the signed-in user only", func() {
+		ctx := context.Background()
+		Expect(store.Grant(ctx, Consent{User: "alice", Provider: "google", Client: "app", Scopes: []string{"email", "profile", "email"}})).To(Succeed())
+		Expect(store.Grant(ctx, Consent{User: "alice", Provider: "github", Client: "app", Scopes: []string{"read:user"}})).To(Succeed())
+		Expect(store.Grant(ctx, Consent{User: "bob", Provider: "github", Client: "app", Scopes: []string{"repo"}})).To(Succeed())
+
+		w := request(http.MethodGet, "/account/consents", request(http.MethodGet, "/signin/github", nil))
+		Expect(w.Code).To(Equal(http.StatusOK))
+		var consents []Consent
+		Expect(json.Unmarshal(w.Body.Bytes(), &consents)).To(Succeed())
+		Expect(consents).To(HaveLen(2))
+		Expect(consents[0].Provider).To(Equal("github"))
+		Expect(consents[1].Provider).To(Equal("google"))
+		Expect(consents[1].Scopes).To(Equal([]string{"email", "profile"}))
+	})
+
+	It("should revoke the consents to a provider and sign out its sessions", func() {
+		Expect(store.Grant(context.Background(), Consent{User: "alice", Provider: "github", Client: "app", Scopes: []string{"read:user"}})).To(Succeed())
+
+		signedIn := request(http.MethodGet, "/signin/github", nil)
+		w := request(http.MethodDelete, "/account/consents/github", signedIn)
+		Expect(w.Code).To(Equal(http.StatusNoContent))
+		Expect(store.consents).To(BeEmpty())
+		Expect(request(http.MethodGet, "/whoami", w).Body.String()).To(BeEmpty())
+
+		Expect(request(http.MethodDelete, "/account/consents/github", signedIn).Code).To(Equal(http.StatusNotFound))
+	})
+
+	It("should keep the session signed in with another provider", func() {
+		Expect(store.Grant(context.Background(), Consent{User: "alice", Provider: "github", Client: "app", Scopes: []string{"read:user"}})).To(Succeed())
+
+		signedIn := request(http.MethodGet, "/signin/google", nil)
+		w := request(http.MethodDelete, "/account/consents/github", signedIn)
+		Expect(w.Code).To(Equal(http.StatusNoContent))
+		Expect(request(http.MethodGet, "/whoami", signedIn).Body.String()).To(Equal("alice"))
+	})
+
+	It("should answer 500 when the store fails", func() {
+		signedIn := request(http.MethodGet, "/signin/github", nil)
+		store.err = errors.New("boom")
+		Expect(request(http.MethodGet, "/account/consents", signedIn).Code).To(Equal(http.StatusInternalServerError))
+		Expect(request(http.MethodDelete, "/account/consents/github", signedIn).Code).To(Equal(http.StatusInternalServerError))
+	})
+})
@@ -0,0 +1,130 @@
+package consent
+
+import (
+	"context"
+	"database/sql"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/animalet/sargantana-go/internal/sqlstore"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/jackc/pgx/v5/stdlib"
+	"github.com/pkg/errors"
+)
+
+// ErrNotFound is returned by stores for consents that do not exist
+var ErrNotFound = errors.New("consent not found")
+
+// Consent records the OAuth scopes a user granted to a client of a provider, the key of the
+// provider in the auth controller configuration.
+type Consent struct {
+	User      string    `json:"user"`
+	Provider  string    `json:"provider"`
+	Client    string    `json:"client"`
+	Scopes    []string  `json:"scopes"`
+	GrantedAt time.Time `json:"granted_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Store persists the consents. Stores are injected in the controller factory, see NewController,
+// so that their connections are owned and closed by the application.
+type Store interface {
+	// Migrate creates or upgrades the tables of the store. Called by NewController.
+	Migrate(ctx context.Context) error
+	// Grant records the consent of a user to a client, replacing its scopes if already granted.
+	Grant(ctx context.Context, consent Consent) error
+	// List returns the consents of a user, sorted by provider and client.
+	List(ctx context.Context, user string) ([]Consent, error)
+	// Revoke removes the consents of a user to the clients of a provider, or returns ErrNotFound.
+	Revoke(ctx context.Context, user, provider string) error
+}
+
+var (
+	postgresDialect = sqlstore.Dialect{
+		Numbered: true,
+		Lock:     "SELECT pg_advisory_xact_lock(4242004)",
+		Migrations: []string{
+			`CREATE TABLE IF NOT EXISTS oauth_consents (
+				user_id TEXT NOT NULL,
+				provider TEXT NOT NULL,
+				client_id TEXT NOT NULL,
+				scopes TEXT NOT NULL,
+				granted_at TIMESTAMP NOT NULL,
+				updated_at TIMESTAMP NOT NULL,
+				PRIMARY KEY (user_id, provider, client_id)
+			)`,
+		},
+	}
+	sqliteDialect = sqlstore.Dialect{
+		Migrations: postgresDialect.Migrations,
+	}
+)
+
+// sqlStore is a Store on a database/sql database. Scopes are kept space separated, as in OAuth.
+type sqlStore struct {
+	sqlstore.Store
+}
+
+// NewPostgresStore returns a Store keeping the consents in PostgreSQL. The pool is not closed by
+// the store.
+func NewPostgresStore(pool *pgxpool.Pool) Store {
+	return &sqlStore{sqlstore.Store{DB: stdlib.OpenDBFromPool(pool), Dialect: postgresDialect, MigrationsTable: "consent_migrations"}}
+}
+
+// NewSQLiteStore returns a Store keeping the consents in SQLite. The database must be opened with a
+// SQLite driver registered by the application, e.g. modernc.org/sqlite, and is not closed by the
+// store.
+func NewSQLiteStore(db *sql.DB) Store {
+	return &sqlStore{sqlstore.Store{DB: db, Dialect: sqliteDialect, MigrationsTable: "consent_migrations"}}
+}
+
+func (s *sqlStore) Grant(ctx context.Context, consent Consent) error {
+	now := time.Now().UTC()
+	_, err := s.DB.ExecContext(ctx, s.Rebind(
+		"INSERT INTO oauth_consents (user_id, provider, client_id, scopes, granted_at, updated_at) VALUES (?, ?, ?, ?, ?, ?) "+
+			"ON CONFLICT (user_id, provider, client_id) DO UPDATE SET scopes = EXCLUDED.scopes, updated_at = EXCLUDED.updated_at"),
+		consent.User, consent.Provider, consent.Client, strings.Join(normalize(consent.Scopes), " "), now, now,
+	)
+	return errors.Wrap(err, "failed to record consent")
+}
+
+func (s *sqlStore) List(ctx context.Context, user string) ([]Consent, error) {
+	rows, err := s.DB.QueryContext(ctx, s.Rebind(
+		"SELECT provider, client_id, scopes, granted_at, updated_at FROM oauth_consents WHERE user_id = ? ORDER BY provider, client_id"), user)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list consents")
+	}
+	defer func() { _ = rows.Close() }()
+	consents := make([]Consent, 0)
+	for rows.Next() {
+		c := Consent{User: user}
+		var scopes string
+		if err := rows.Scan(&c.Provider, &c.Client, &scopes, &c.GrantedAt, &c.UpdatedAt); err != nil {
+			return nil, errors.Wrap(err, "failed to read consent")
+		}
+		c.Scopes = strings.Fields(scopes)
+		consents = append(consents, c)
+	}
+	return consents, errors.Wrap(rows.Err(), "failed to list consents")
+}
+
+func (s *sqlStore) Revoke(ctx context.Context, user, provider string) error {
+	result, err := s.DB.ExecContext(ctx, s.Rebind("DELETE FROM oauth_consents WHERE user_id = ? AND provider = ?"), user, provider)
+	if err != nil {
+		return errors.Wrap(err, "failed to revoke consent")
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return errors.Wrap(err, "failed to revoke consent")
+	}
+	if rows == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// normalize sorts the scopes and removes the duplicates
+func normalize(scopes []string) []string {
+	return slices.Compact(slices.Sorted(slices.Values(scopes)))
+}
@@ -0,0 +1,60 @@
+//go:build integration
+
+package consent
+
+import (
+	"context"
+
+	"github.com/animalet/sargantana-go/pkg/database"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("PostgreSQL store", func() {
+	var store Store
+
+	BeforeEach(func() {
+		pool, err := database.PostgresConfig{
+			Host:     "localhost",
+			Port:     5432,
+			User:     "user",
+			Password: "password",
+			Database: "my_blog_db",
+			SSLMode:  "disable",
+		}.CreateClient()
+		Expect(err).NotTo(HaveOccurred())
+		DeferCleanup(pool.Close)
+		store = NewPostgresStore(pool)
+	})
+
+	It("should migrate idempotently", func() {
+		Expect(store.Migrate(context.Background())).To(Succeed())
+		Expect(store.Migrate(context.Background())).To(Succeed())
+	})
+
+	It("should grant, list and revoke consents", func() {
+		ctx := context.Background()
+		Expect(store.Migrate(ctx)).To(Succeed())
+		user := "consent-integration"
+		_ = store.Revoke(ctx, user, "github")
+		_ = store.Revoke(ctx, user, "google")
+
+		Expect(store.Grant(ctx, Consent{User: user, Provider: "google", Client: "app", Scopes: []string{"profile", "email"}})).To(Succeed())
+		Expect(store.Grant(ctx, Consent{User: user, Provider: "github", Client: "app", Scopes: []string{"read:user"}})).To(Succeed())
+		Expect(store.Grant(ctx, Consent{User: user, Provider: "google", Client: "app", Scopes: []string{"openid", "email", "email"}})).To(Succeed())
+
+		consents, err := store.List(ctx, user)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(consents).To(HaveLen(2))
+		Expect(consents[0].Provider).To(Equal("github"))
+		Expect(consents[1].Scopes).To(Equal([]string{"email", "openid"}))
+		Expect(consents[1].UpdatedAt).NotTo(BeTemporally("<", consents[1].GrantedAt))
+
+		Expect(store.Revoke(ctx, user, "google")).To(Succeed())
+		Expect(store.Revoke(ctx, user, "google")).To(MatchError(ErrNotFound))
+		Expect(store.Revoke(ctx, user, "github")).To(Succeed())
+		consents, err = store.List(ctx, user)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(consents).To(BeEmpty())
+	})
+})
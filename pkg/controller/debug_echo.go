@@ -0,0 +1,236 @@
+package controller
+
+import (
+	"net"
+	"net/http"
+	"net/netip"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/animalet/sargantana-go/internal/snapshot"
+	"github.com/animalet/sargantana-go/pkg/server"
+	"github.com/animalet/sargantana-go/pkg/sessionsx"
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+)
+
+// defaultDebugEchoAllow are the loopback and private networks allowed by default
+var defaultDebugEchoAllow = []string{"127.0.0.0/8", "::1/128", "10.0.0.0/8", "172.16.0.0/12", "192.168.0.0/16", "fc00::/7"}
+
+// redactedHeaders are the request headers whose values the echo never reflects
+var redactedHeaders = []string{"Authorization", "Proxy-Authorization", "Cookie"}
+
+// DebugEchoControllerConfig configures an endpoint reflecting the requests it receives as seen by
+// the server, to debug the headers set by the proxies in front of it and by the binding
+// middlewares.
+type DebugEchoControllerConfig struct {
+	// Path serves the echo for every method, including its subpaths, e.g. /debug/echo.
+	Path string `yaml:"path"`
+	// Role is the role required to the authenticated users.
+	Role string `yaml:"role"`
+	// Allow are the IPs or CIDRs of the peers allowed to reach the endpoint, compared with the
+	// address of the connection rather than the forwarded headers. Defaults to the loopback and
+	// private networks.
+	Allow []string `yaml:"allow,omitempty"`
+}
+
+func (c DebugEchoControllerConfig) Validate() error {
+	if c.Path == "" {
+		return errors.New("path must be set and non-empty")
+	}
+	if c.Role == "" {
+		return errors.New("role must be set and non-empty")
+	}
+	if _, err := parsePrefixes(c.Allow); err != nil {
+		return errors.Wrap(err, "invalid allow entry")
+	}
+	return nil
+}
+
+// parsePrefixes parses IPs and CIDRs, turning IPs into single address prefixes
+func parsePrefixes(entries []string) ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(entries))
+	for _, entry := range entries {
+		if !strings.Contains(entry, "/") {
+			addr, err := netip.ParseAddr(entry)
+			if err != nil {
+				return nil, errors.Wrapf(err, "invalid IP address %q", entry)
+			}
+			prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+			continue
+		}
+		prefix, err := netip.ParsePrefix(entry)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid CIDR %q", entry)
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+	return prefixes, nil
+}
+
+// NewDebugEchoController creates a controller answering the requests to its path with a JSON
+// description of the request: its headers, the peer address and the resolved client IP, the
+// matched route, the session of the user and the changes made by each middleware of the binding.
+func NewDebugEchoController(c *DebugEchoControllerConfig, ctx server.ControllerContext) (server.IController, error) {
+	// Deep copy the config to enforce immutability
+	configCopy := snapshot.MustCopy(c)
+
+	entries := configCopy.Allow
+	if len(entries) == 0 {
+		entries = defaultDebugEchoAllow
+	}
+	allow, err := parsePrefixes(entries)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Info().Str("path", configCopy.Path).Str("role", configCopy.Role).Strs("allow", entries).Msg("Debug echo endpoint configured")
+	return &debugEcho{path: configCopy.Path, role: configCopy.Role, allow: allow, ctx: ctx}, nil
+}
+
+type debugEcho struct {
+	path  string
+	role  string
+	allow []netip.Prefix
+	ctx   server.ControllerContext
+}
+
+// TraceMiddlewares reports the changes of the binding middlewares in the echo
+func (d *debugEcho) TraceMiddlewares() bool {
+	return true
+}
+
+func (d *debugEcho) Bind(engine *gin.Engine, loginMiddleware gin.HandlerFunc) error {
+	return d.BindGroup(&engine.RouterGroup, loginMiddleware)
+}
+
+func (d *debugEcho) BindGroup(group *gin.RouterGroup, _ gin.HandlerFunc) error {
+	handlers := []gin.HandlerFunc{d.allowPeer, d.ctx.RequireRole(d.role), d.echo}
+	base := strings.TrimSuffix(d.path, "/")
+	group.Any(base, handlers...)
+	group.Any(base+"/*path", handlers...)
+	return nil
+}
+
+func (d *debugEcho) Close() error {
+	return nil
+}
+
+// allowPeer answers 404 Not Found to the peers outside the allowed networks, hiding the endpoint
+func (d *debugEcho) allowPeer(c *gin.Context) {
+	host, _, err := net.SplitHostPort(c.Request.RemoteAddr)
+	if err != nil {
+		host = c.Request.RemoteAddr
+	}
+	addr, err := netip.ParseAddr(host)
+	if err == nil {
+		addr = addr.Unmap()
+		for _, prefix := range d.allow {
+			if prefix.Contains(addr) {
+				c.Next()
+				return
+			}
+		}
+	}
+	server.RespondError(c, http.StatusNotFound)
+}
+
+type debugEchoResponse struct {
+	Method      string                       `json:"method"`
+	URL         string                       `json:"url"`
+	Host        string                       `json:"host"`
+	Proto       string                       `json:"proto"`
+	RemoteAddr  string                       `json:"remote_addr"`
+	ClientIP    string                       `json:"client_ip"`
+	TLS         bool                         `json:"tls"`
+	Headers     http.Header                  `json:"headers"`
+	Route       debugEchoRoute               `json:"route"`
+	Session     *debugEchoSession            `json:"session,omitempty"`
+	RemoteUser  string                       `json:"remote_user,omitempty"`
+	Roles       []string                     `json:"roles,omitempty"`
+	Scopes      []string                     `json:"scopes,omitempty"`
+	Middlewares []server.RequestModification `json:"middlewares"`
+}
+
+type debugEchoRoute struct {
+	Controller     string            `json:"controller"`
+	ControllerType string            `json:"controller_type"`
+	Path           string            `json:"path"`
+	Params         map[string]string `json:"params,omitempty"`
+}
+
+type debugEchoSession struct {
+	User       string    `json:"user"`
+	Provider   string    `json:"provider,omitempty"`
+	ExpiresAt  time.Time `json:"expires_at,omitzero"`
+	Generation int64     `json:"generation,omitempty"`
+}
+
+func (d *debugEcho) echo(c *gin.Context) {
+	response := debugEchoResponse{
+		Method:     c.Request.Method,
+		URL:        c.Request.URL.String(),
+		Host:       c.Request.Host,
+		Proto:      c.Request.Proto,
+		RemoteAddr: c.Request.RemoteAddr,
+		ClientIP:   c.ClientIP(),
+		TLS:        c.Request.TLS != nil,
+		Headers:    reflectedHeaders(c.Request),
+		Route: debugEchoRoute{
+			Controller:     server.ControllerName(c),
+			ControllerType: server.ControllerType(c),
+			Path:           c.FullPath(),
+		},
+		Roles:       server.GetRoles(c),
+		Scopes:      server.GetScopes(c),
+		Middlewares: server.RequestModifications(c),
+	}
+	if len(c.Params) > 0 {
+		response.Route.Params = make(map[string]string, len(c.Params))
+		for _, param := range c.Params {
+			response.Route.Params[param.Key] = param.Value
+		}
+	}
+	if response.Middlewares == nil {
+		response.Middlewares = []server.RequestModification{}
+	}
+	response.RemoteUser, _ = server.GetRemoteUser(c)
+	if _, ok := c.Get(sessions.DefaultKey); ok {
+		if user, ok := sessionsx.User(c); ok {
+			response.Session = &debugEchoSession{
+				User:       user.Id,
+				Provider:   user.User.Provider,
+				ExpiresAt:  user.User.ExpiresAt,
+				Generation: user.Generation,
+			}
+		}
+	}
+	c.Header("Cache-Control", "no-store")
+	c.JSON(http.StatusOK, response)
+}
+
+// reflectedHeaders returns the request headers with the credentials redacted. The cookies are
+// listed by name only.
+func reflectedHeaders(r *http.Request) http.Header {
+	headers := r.Header.Clone()
+	for _, name := range redactedHeaders {
+		if _, ok := headers[name]; ok {
+			headers[name] = []string{"<redacted>"}
+		}
+	}
+	if cookies := r.Cookies(); len(cookies) > 0 {
+		names := make([]string, 0, len(cookies))
+		for _, cookie := range cookies {
+			names = append(names, cookie.Name+"=<redacted>")
+		}
+		slices.Sort(names)
+		headers["Cookie"] = []string{strings.Join(names, "; ")}
+	}
+	if r.Host != "" {
+		headers.Set("Host", r.Host)
+	}
+	return headers
+}
@@ -0,0 +1,109 @@
+//go:build unit
+
+package controller
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	"github.com/animalet/sargantana-go/pkg/server"
+	"github.com/animalet/sargantana-go/pkg/sessionsx"
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-contrib/sessions/cookie"
+	"github.com/gin-gonic/gin"
+	"github.com/markbates/goth"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("DebugEchoController", func() {
+	var (
+		engine *gin.Engine
+		ctrl   *debugEcho
+	)
+
+	BeforeEach(func() {
+		gin.SetMode(gin.TestMode)
+		c, err := NewDebugEchoController(&DebugEchoControllerConfig{Path: "/debug/echo", Role: "ops"}, server.ControllerContext{})
+		Expect(err).NotTo(HaveOccurred())
+		ctrl = c.(*debugEcho)
+		Expect(ctrl.TraceMiddlewares()).To(BeTrue())
+
+		engine = gin.New()
+		engine.Use(sessions.Sessions("session", cookie.NewStore([]byte("secret"))))
+		engine.GET("/signin", func(c *gin.Context) {
+			Expect(sessionsx.Set(c, sessionsx.UserKey, UserObject{
+				Id:   "alice@example.org",
+				User: goth.User{UserID: "alice", Provider: "github", ExpiresAt: time.Now().Add(time.Hour)},
+			})).To(Succeed())
+		})
+		// The echo, without the role check of the binding
+		engine.Any("/unchecked/*path", ctrl.allowPeer, ctrl.echo)
+		Expect(ctrl.Bind(engine, nil)).To(Succeed())
+	})
+
+	request := func(method, path, remoteAddr string, header http.Header) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(method, path, nil)
+		req.RemoteAddr = remoteAddr
+		for name, values := range header {
+			req.Header[name] = values
+		}
+		engine.ServeHTTP(w, req)
+		return w
+	}
+
+	It("should validate the configuration", func() {
+		Expect(DebugEchoControllerConfig{}.Validate()).To(MatchError(ContainSubstring("path")))
+		Expect(DebugEchoControllerConfig{Path: "/debug/echo"}.Validate()).To(MatchError(ContainSubstring("role")))
+		Expect(DebugEchoControllerConfig{Path: "/debug/echo", Role: "ops"}.Validate()).To(Succeed())
+		Expect(DebugEchoControllerConfig{Path: "/debug/echo", Role: "ops", Allow: []string{"10.1.2.3", "fd00::/8"}}.Validate()).To(Succeed())
+		Expect(DebugEchoControllerConfig{Path: "/debug/echo", Role: "ops", Allow: []string{"internal"}}.Validate()).
+			To(MatchError(ContainSubstring("invalid allow entry")))
+	})
+
+	It("should require the role", func() {
+		Expect(request(http.MethodGet, "/debug/echo", "127.0.0.1:4000", nil).Code).To(Equal(http.StatusUnauthorized))
+		Expect(request(http.MethodPost, "/debug/echo/api/orders", "127.0.0.1:4000", nil).Code).To(Equal(http.StatusUnauthorized))
+	})
+
+	It("should hide the endpoint from the peers outside the allowed networks", func() {
+		forwarded := http.Header{"X-Forwarded-For": {"127.0.0.1"}}
+		Expect(request(http.MethodGet, "/debug/echo", "203.0.113.7:4000", forwarded).Code).To(Equal(http.StatusNotFound))
+		Expect(request(http.MethodGet, "/unchecked/", "203.0.113.7:4000", forwarded).Code).To(Equal(http.StatusNotFound))
+		Expect(request(http.MethodGet, "/unchecked/", "[::ffff:10.0.0.8]:4000", nil).Code).To(Equal(http.StatusOK))
+	})
+
+	It("should reflect the request with its credentials redacted", func() {
+		signin := request(http.MethodGet, "/signin", "10.0.0.8:4000", nil)
+		header := http.Header{
+			"Authorization":   {"Bearer token"},
+			"X-Forwarded-For": {"198.51.100.4, 10.0.0.1"},
+		}
+		for _, c := range signin.Result().Cookies() {
+			header.Add("Cookie", c.Name+"="+c.Value)
+		}
+		w := request(http.MethodPut, "/unchecked/api/orders?id=1", "10.0.0.8:4000", header)
+		Expect(w.Code).To(Equal(http.StatusOK))
+		Expect(w.Header().Get("Cache-Control")).To(Equal("no-store"))
+
+		var echo debugEchoResponse
+		Expect(json.Unmarshal(w.Body.Bytes(), &echo)).To(Succeed())
+		Expect(echo.Method).To(Equal(http.MethodPut))
+		Expect(echo.URL).To(Equal("/unchecked/api/orders?id=1"))
+		Expect(echo.RemoteAddr).To(Equal("10.0.0.8:4000"))
+		Expect(echo.ClientIP).NotTo(BeEmpty())
+		Expect(echo.Headers.Get("Authorization")).To(Equal("<redacted>"))
+		Expect(echo.Headers.Get("Cookie")).To(Equal("session=<redacted>"))
+		Expect(echo.Headers.Get("X-Forwarded-For")).To(Equal("198.51.100.4, 10.0.0.1"))
+		Expect(echo.Route.Path).To(Equal("/unchecked/*path"))
+		Expect(echo.Route.Params).To(HaveKeyWithValue("path", "/api/orders"))
+		Expect(echo.Session).NotTo(BeNil())
+		Expect(echo.Session.User).To(Equal("alice@example.org"))
+		Expect(echo.Session.Provider).To(Equal("github"))
+		Expect(echo.Middlewares).To(BeEmpty())
+		Expect(w.Body.String()).NotTo(ContainSubstring("Bearer token"))
+	})
+})
@@ -0,0 +1,303 @@
+package controller
+
+import (
+	"bytes"
+	"context"
+	"html"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	defaultESIMaxEntries  = 1000
+	defaultESIMaxBodySize = 1024 * 1024
+	defaultESITimeout     = 5 * time.Second
+	defaultESIMaxIncludes = 32
+	defaultESIMaxDepth    = 3
+
+	// esiDepthHeader counts the nested includes of fragment requests, to stop include loops
+	esiDepthHeader = "X-Sargantana-ESI-Depth"
+)
+
+var (
+	esiInclude = regexp.MustCompile(`(?s)<esi:include\s([^>]*?)/?>(?:\s*</esi:include>)?`)
+	esiRemove  = regexp.MustCompile(`(?s)<esi:remove>.*?</esi:remove>`)
+	esiComment = regexp.MustCompile(`(?s)<!--esi(.*?)-->`)
+	esiAttr    = regexp.MustCompile(`([a-zA-Z]+)\s*=\s*(?:"([^"]*)"|'([^']*)')`)
+)
+
+// ESIConfig enables Edge Side Includes processing of proxied HTML responses. The <esi:include>
+// tags are replaced with the responses of other routes of the gateway, requested with the headers
+// of the client, <esi:remove> elements are dropped and <!--esi ...--> comments are unwrapped.
+// Fragments are rendered by the handler of the HTTP server of the request, and cached unless their
+// response opts out, with the same rules as ResponseCacheConfig.
+type ESIConfig struct {
+	// TTL is the time fragments are cached for. Defaults to 0, disabling the fragment cache.
+	TTL time.Duration `yaml:"ttl,omitempty"`
+	// MaxEntries bounds the number of cached fragments. Defaults to 1000.
+	MaxEntries int `yaml:"max_entries,omitempty"`
+	// MaxBodySize is the size in bytes of the largest processed page and included fragment. Larger
+	// pages are sent unprocessed. Defaults to 1 MiB.
+	MaxBodySize int64 `yaml:"max_body_size,omitempty"`
+	// Timeout bounds the time to render each fragment. Defaults to 5s.
+	Timeout time.Duration `yaml:"timeout,omitempty"`
+	// MaxIncludes bounds the number of includes of a page. Defaults to 32.
+	MaxIncludes int `yaml:"max_includes,omitempty"`
+	// MaxDepth bounds the nesting of fragments including other fragments. Defaults to 3.
+	MaxDepth int `yaml:"max_depth,omitempty"`
+}
+
+func (c ESIConfig) Validate() error {
+	if c.TTL < 0 || c.Timeout < 0 {
+		return errors.New("ttl and timeout must not be negative")
+	}
+	if c.MaxEntries < 0 || c.MaxBodySize < 0 || c.MaxIncludes < 0 || c.MaxDepth < 0 {
+		return errors.New("max_entries, max_body_size, max_includes and max_depth must not be negative")
+	}
+	return nil
+}
+
+// esiProcessor resolves the includes of HTML pages against the routes of the gateway
+type esiProcessor struct {
+	config ESIConfig
+	cache  *responseCache // Nil if fragments are not cached
+}
+
+func newESIProcessor(c ESIConfig) (*esiProcessor, error) {
+	if c.MaxEntries == 0 {
+		c.MaxEntries = defaultESIMaxEntries
+	}
+	if c.MaxBodySize == 0 {
+		c.MaxBodySize = defaultESIMaxBodySize
+	}
+	if c.Timeout == 0 {
+		c.Timeout = defaultESITimeout
+	}
+	if c.MaxIncludes == 0 {
+		c.MaxIncludes = defaultESIMaxIncludes
+	}
+	if c.MaxDepth == 0 {
+		c.MaxDepth = defaultESIMaxDepth
+	}
+	processor := &esiProcessor{config: c}
+	if c.TTL > 0 {
+		cache, err := newResponseCache(ResponseCacheConfig{TTL: c.TTL, MaxEntries: c.MaxEntries, MaxBodySize: c.MaxBodySize})
+		if err != nil {
+			return nil, err
+		}
+		processor.cache = cache
+	}
+	return processor, nil
+}
+
+// applies tells whether the response is an HTML page to process
+func (e *esiProcessor) applies(header http.Header) bool {
+	mediaType, _, err := mime.ParseMediaType(header.Get("Content-Type"))
+	return err == nil && (mediaType == "text/html" || mediaType == "application/xhtml+xml")
+}
+
+// esiTag is an include of a page
+type esiTag struct {
+	start, end int
+	src, alt   string
+	continues  bool // onerror="continue": the include is dropped if it fails
+}
+
+// process resolves the ESI tags of a page requested by the client
+func (e *esiProcessor) process(c *gin.Context, page []byte) ([]byte, error) {
+	page = esiRemove.ReplaceAll(page, nil)
+	page = esiComment.ReplaceAll(page, []byte("$1"))
+
+	matches := esiInclude.FindAllSubmatchIndex(page, -1)
+	if len(matches) == 0 {
+		return page, nil
+	}
+	if len(matches) > e.config.MaxIncludes {
+		return nil, errors.Errorf("page has %d includes, more than the maximum of %d", len(matches), e.config.MaxIncludes)
+	}
+	tags := make([]esiTag, len(matches))
+	for i, match := range matches {
+		tags[i] = esiTag{start: match[0], end: match[1]}
+		for _, attr := range esiAttr.FindAllSubmatch(page[match[2]:match[3]], -1) {
+			value := html.UnescapeString(string(attr[2]) + string(attr[3]))
+			switch strings.ToLower(string(attr[1])) {
+			case "src":
+				tags[i].src = value
+			case "alt":
+				tags[i].alt = value
+			case "onerror":
+				tags[i].continues = value == "continue"
+			}
+		}
+	}
+
+	fragments := make([][]byte, len(tags))
+	errs := make([]error, len(tags))
+	var wg sync.WaitGroup
+	for i, tag := range tags {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			fragments[i], errs[i] = e.include(c, tag)
+		}()
+	}
+	wg.Wait()
+
+	var processed bytes.Buffer
+	last := 0
+	for i, tag := range tags {
+		if errs[i] != nil {
+			return nil, errs[i]
+		}
+		processed.Write(page[last:tag.start])
+		processed.Write(fragments[i])
+		last = tag.end
+	}
+	processed.Write(page[last:])
+	return processed.Bytes(), nil
+}
+
+// include renders the fragment of a tag, falling back to its alt source
+func (e *esiProcessor) include(c *gin.Context, tag esiTag) ([]byte, error) {
+	fragment, err := e.fragment(c, tag.src)
+	if err != nil && tag.alt != "" {
+		fragment, err = e.fragment(c, tag.alt)
+	}
+	if err != nil {
+		if tag.continues {
+			log.Debug().Err(err).Str("src", tag.src).Msg("ESI include failed, continuing without it")
+			return nil, nil
+		}
+		return nil, errors.Wrapf(err, "failed to include %s", tag.src)
+	}
+	return fragment, nil
+}
+
+// processStream processes a page read from the upstream. Pages larger than the maximum body size
+// are returned unprocessed.
+func (e *esiProcessor) processStream(c *gin.Context, body io.Reader) (io.Reader, error) {
+	page, err := io.ReadAll(io.LimitReader(body, e.config.MaxBodySize+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(page)) > e.config.MaxBodySize {
+		log.Warn().Str("path", c.Request.URL.Path).Int64("max_body_size", e.config.MaxBodySize).
+			Msg("Page too large for ESI processing, sent unprocessed")
+		return io.MultiReader(bytes.NewReader(page), body), nil
+	}
+	processed, err := e.process(c, page)
+	if err != nil {
+		return nil, err
+	}
+	return bytes.NewReader(processed), nil
+}
+
+// fragment renders a route of the gateway, or returns its cached fragment
+func (e *esiProcessor) fragment(c *gin.Context, src string) ([]byte, error) {
+	target, err := url.Parse(src)
+	if err != nil || target.Scheme != "" || target.Host != "" || !strings.HasPrefix(target.Path, "/") {
+		return nil, errors.Errorf("src %q must be an absolute path of the gateway", src)
+	}
+	if e.cache != nil {
+		if entry, ok := e.cache.get(target.RequestURI()); ok {
+			return entry.body, nil
+		}
+	}
+
+	depth, _ := strconv.Atoi(c.GetHeader(esiDepthHeader))
+	if depth >= e.config.MaxDepth {
+		return nil, errors.Errorf("includes are nested deeper than the maximum of %d", e.config.MaxDepth)
+	}
+	srv, ok := c.Request.Context().Value(http.ServerContextKey).(*http.Server)
+	if !ok || srv.Handler == nil {
+		return nil, errors.New("the gateway handler is not available to render fragments")
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), e.config.Timeout)
+	defer cancel()
+	// The fragment is requested as the client requested the page, from the same address and host
+	request := c.Request.Clone(ctx)
+	request.Method = http.MethodGet
+	request.URL = target
+	request.RequestURI = target.RequestURI()
+	request.Body = http.NoBody
+	request.ContentLength = 0
+	for _, name := range []string{"Content-Type", "Content-Length", "Accept-Encoding", "Range", "If-Match",
+		"If-None-Match", "If-Modified-Since", "If-Unmodified-Since", "If-Range", CacheStatusHeader} {
+		request.Header.Del(name)
+	}
+	request.Header.Set(esiDepthHeader, strconv.Itoa(depth+1))
+
+	writer := &fragmentWriter{header: make(http.Header), max: e.config.MaxBodySize}
+	srv.Handler.ServeHTTP(writer, request)
+	if writer.status() != http.StatusOK {
+		return nil, errors.Errorf("fragment responded with status %d", writer.status())
+	}
+	if writer.overflow {
+		return nil, errors.Errorf("fragment is larger than the maximum of %d bytes", e.config.MaxBodySize)
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, errors.Wrap(err, "fragment timed out")
+	}
+	if e.cache != nil {
+		e.cache.store(target.RequestURI(), http.StatusOK, writer.header, writer.body.Bytes())
+	}
+	return writer.body.Bytes(), nil
+}
+
+func (e *esiProcessor) Close() error {
+	if e.cache != nil {
+		return e.cache.Close()
+	}
+	return nil
+}
+
+// fragmentWriter collects the response of a fragment, up to the maximum size
+type fragmentWriter struct {
+	header   http.Header
+	code     int
+	body     bytes.Buffer
+	max      int64
+	overflow bool
+}
+
+func (w *fragmentWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *fragmentWriter) WriteHeader(status int) {
+	if w.code == 0 {
+		w.code = status
+	}
+}
+
+func (w *fragmentWriter) Write(b []byte) (int, error) {
+	w.WriteHeader(http.StatusOK)
+	if !w.overflow {
+		if int64(w.body.Len()+len(b)) > w.max {
+			w.overflow = true
+			w.body.Reset()
+		} else {
+			w.body.Write(b)
+		}
+	}
+	return len(b), nil
+}
+
+func (w *fragmentWriter) status() int {
+	if w.code == 0 {
+		return http.StatusOK
+	}
+	return w.code
+}
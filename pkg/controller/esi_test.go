@@ -0,0 +1,198 @@
+//go:build unit
+
+package controller
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"time"
+
+	"github.com/animalet/sargantana-go/pkg/server"
+	"github.com/gin-gonic/gin"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("ESI processing", func() {
+	var (
+		gateway        *httptest.Server
+		pages          map[string]string
+		fragmentCalls  atomic.Int32
+		fragmentCookie atomic.Value
+	)
+
+	setup := func(cfg ESIConfig, cache *ResponseCacheConfig) {
+		fragmentCalls.Store(0)
+		fragmentCookie.Store("")
+		backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			page, ok := pages[r.URL.Path]
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			_, _ = io.WriteString(w, page)
+		}))
+		DeferCleanup(backend.Close)
+
+		ctrl, err := NewLoadBalancerController(&LoadBalancerControllerConfig{
+			Path:      "/pages",
+			Endpoints: []string{backend.URL},
+			ESI:       &cfg,
+			Cache:     cache,
+		}, server.ControllerContext{})
+		Expect(err).NotTo(HaveOccurred())
+		DeferCleanup(ctrl.Close)
+
+		engine := gin.New()
+		Expect(ctrl.Bind(engine, nil)).To(Succeed())
+		engine.GET("/fragments/header", func(c *gin.Context) {
+			fragmentCalls.Add(1)
+			if cookie, err := c.Cookie("session"); err == nil {
+				fragmentCookie.Store(cookie)
+			}
+			c.Data(http.StatusOK, "text/html", []byte("<h1>Header</h1>"))
+		})
+		engine.GET("/fragments/private", func(c *gin.Context) {
+			fragmentCalls.Add(1)
+			c.Header("Cache-Control", "private")
+			c.Data(http.StatusOK, "text/html", []byte("<p>Hello</p>"))
+		})
+		engine.GET("/fragments/broken", func(c *gin.Context) {
+			c.Status(http.StatusInternalServerError)
+		})
+		engine.GET("/fragments/slow", func(c *gin.Context) {
+			select {
+			case <-time.After(time.Second):
+			case <-c.Request.Context().Done():
+			}
+			c.Data(http.StatusOK, "text/html", []byte("late"))
+		})
+		gateway = httptest.NewServer(engine)
+		DeferCleanup(gateway.Close)
+	}
+
+	get := func(path string, cookies ...*http.Cookie) (int, string, http.Header) {
+		request, err := http.NewRequest(http.MethodGet, gateway.URL+path, nil)
+		Expect(err).NotTo(HaveOccurred())
+		for _, cookie := range cookies {
+			request.AddCookie(cookie)
+		}
+		response, err := http.DefaultClient.Do(request)
+		Expect(err).NotTo(HaveOccurred())
+		defer func() { _ = response.Body.Close() }()
+		body, err := io.ReadAll(response.Body)
+		Expect(err).NotTo(HaveOccurred())
+		return response.StatusCode, string(body), response.Header
+	}
+
+	BeforeEach(func() {
+		pages = map[string]string{
+			"/pages/home":     `<html><esi:include src="/fragments/header"/><main>Home</main></html>`,
+			"/pages/markup":   `<esi:remove><a href="/fallback">Fallback</a></esi:remove><!--esi <esi:include src="/fragments/header" /> -->`,
+			"/pages/alt":      `<esi:include src="/fragments/broken" alt="/fragments/header"></esi:include>`,
+			"/pages/continue": `A<esi:include src="/fragments/broken" onerror="continue"/>B`,
+			"/pages/broken":   `<esi:include src="/fragments/broken"/>`,
+			"/pages/external": `<esi:include src="https://example.com/header" onerror="continue"/>ok`,
+			"/pages/private":  `<esi:include src="/fragments/private"/>`,
+			"/pages/loop":     `x<esi:include src="/pages/loop" onerror="continue"/>`,
+			"/pages/slow":     `<esi:include src="/fragments/slow"/>`,
+			"/pages/plain":    `no includes`,
+		}
+	})
+
+	It("should validate the configuration", func() {
+		Expect(ESIConfig{}.Validate()).To(Succeed())
+		Expect(ESIConfig{TTL: -time.Second}.Validate()).To(MatchError(ContainSubstring("ttl")))
+		Expect(ESIConfig{MaxDepth: -1}.Validate()).To(MatchError(ContainSubstring("max_depth")))
+		Expect(LoadBalancerControllerConfig{
+			Path:      "/pages",
+			Endpoints: []string{"http://localhost:8080"},
+			ESI:       &ESIConfig{MaxIncludes: -1},
+		}.Validate()).To(MatchError(ContainSubstring("invalid esi configuration")))
+	})
+
+	It("should replace includes with the fragments of other routes", func() {
+		setup(ESIConfig{}, nil)
+		status, body, _ := get("/pages/home", &http.Cookie{Name: "session", Value: "abc"})
+		Expect(status).To(Equal(http.StatusOK))
+		Expect(body).To(Equal("<html><h1>Header</h1><main>Home</main></html>"))
+		Expect(fragmentCookie.Load()).To(Equal("abc"))
+	})
+
+	It("should drop esi:remove elements and unwrap esi comments", func() {
+		setup(ESIConfig{}, nil)
+		_, body, _ := get("/pages/markup")
+		Expect(body).To(Equal(" <h1>Header</h1> "))
+	})
+
+	It("should fall back to alt and continue on errors when asked to", func() {
+		setup(ESIConfig{}, nil)
+		_, body, _ := get("/pages/alt")
+		Expect(body).To(Equal("<h1>Header</h1>"))
+		_, body, _ = get("/pages/continue")
+		Expect(body).To(Equal("AB"))
+		_, body, _ = get("/pages/external")
+		Expect(body).To(Equal("ok"))
+	})
+
+	It("should answer 502 when a required fragment fails", func() {
+		setup(ESIConfig{Timeout: 50 * time.Millisecond}, nil)
+		status, _, _ := get("/pages/broken")
+		Expect(status).To(Equal(http.StatusBadGateway))
+		status, _, _ = get("/pages/slow")
+		Expect(status).To(Equal(http.StatusBadGateway))
+	})
+
+	It("should stop include loops at the maximum depth", func() {
+		setup(ESIConfig{MaxDepth: 2}, nil)
+		_, body, _ := get("/pages/loop")
+		Expect(body).To(Equal("xxx"))
+	})
+
+	It("should cache fragments unless they opt out", func() {
+		setup(ESIConfig{TTL: time.Minute}, nil)
+		get("/pages/home")
+		get("/pages/home")
+		Expect(fragmentCalls.Load()).To(Equal(int32(1)))
+
+		get("/pages/private")
+		get("/pages/private")
+		Expect(fragmentCalls.Load()).To(Equal(int32(3)))
+	})
+
+	It("should not cache fragments without ttl", func() {
+		setup(ESIConfig{}, nil)
+		get("/pages/home")
+		get("/pages/home")
+		Expect(fragmentCalls.Load()).To(Equal(int32(2)))
+	})
+
+	It("should cache pages with their includes and resolve them on every hit", func() {
+		setup(ESIConfig{}, &ResponseCacheConfig{TTL: time.Minute})
+		_, body, header := get("/pages/home")
+		Expect(header.Get(CacheStatusHeader)).To(Equal("MISS"))
+		Expect(body).To(ContainSubstring("<h1>Header</h1>"))
+		_, body, header = get("/pages/home")
+		Expect(header.Get(CacheStatusHeader)).To(Equal("HIT"))
+		Expect(body).To(Equal("<html><h1>Header</h1><main>Home</main></html>"))
+		Expect(fragmentCalls.Load()).To(Equal(int32(2)))
+	})
+
+	It("should reject pages with too many includes", func() {
+		setup(ESIConfig{MaxIncludes: 1}, nil)
+		pages["/pages/many"] = `<esi:include src="/fragments/header"/><esi:include src="/fragments/header"/>`
+		status, _, _ := get("/pages/many")
+		Expect(status).To(Equal(http.StatusBadGateway))
+	})
+
+	It("should send large pages unprocessed", func() {
+		setup(ESIConfig{MaxBodySize: 10}, nil)
+		_, body, _ := get("/pages/home")
+		Expect(body).To(Equal(pages["/pages/home"]))
+		_, body, _ = get("/pages/plain")
+		Expect(body).To(Equal("no includes"))
+	})
+})
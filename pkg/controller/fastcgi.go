@@ -0,0 +1,361 @@
+package controller
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	defaultFastCGIIndex     = "index.php"
+	defaultFastCGIExtension = ".php"
+	defaultFastCGITimeout   = 60 * time.Second
+)
+
+// FastCGIConfig makes the load balancer talk FastCGI to its endpoints, e.g. PHP-FPM pools, instead
+// of HTTP. Endpoints are then tcp://host:port or unix:///path/to/socket addresses.
+//
+// Requests for paths containing a script, e.g. /blog/post.php/extra, run that script with the rest of
+// the path as PATH_INFO. Any other path runs the Index script, as the front controllers of most PHP
+// frameworks expect.
+type FastCGIConfig struct {
+	// ScriptRoot is the directory of the scripts on the FastCGI server, sent as DOCUMENT_ROOT.
+	ScriptRoot string `yaml:"script_root"`
+	// Index is the script run for paths without a script. Defaults to index.php.
+	Index string `yaml:"index,omitempty"`
+	// Extension identifies script paths. Defaults to .php.
+	Extension string `yaml:"extension,omitempty"`
+	// Params adds or overrides FastCGI parameters. Values may reference other parameters as {NAME},
+	// e.g. "{DOCUMENT_ROOT}/public{SCRIPT_NAME}". Empty values remove the parameter.
+	Params map[string]string `yaml:"params,omitempty"`
+	// Timeout bounds the whole exchange with the FastCGI server. Defaults to 60s.
+	Timeout time.Duration `yaml:"timeout,omitempty"`
+}
+
+func (c FastCGIConfig) Validate() error {
+	if !path.IsAbs(c.ScriptRoot) {
+		return errors.New("script_root must be an absolute path")
+	}
+	if strings.Contains(c.Index, "/") {
+		return errors.New("index must be a file name")
+	}
+	if c.Extension != "" && !strings.HasPrefix(c.Extension, ".") {
+		return errors.New("extension must start with a dot")
+	}
+	if c.Timeout < 0 {
+		return errors.New("timeout must not be negative")
+	}
+	for name := range c.Params {
+		if name == "" {
+			return errors.New("param names must not be empty")
+		}
+	}
+	return nil
+}
+
+// FastCGI record types and roles, see https://fastcgi-archives.github.io/FastCGI_Specification.html
+const (
+	fcgiVersion      = 1
+	fcgiBeginRequest = 1
+	fcgiEndRequest   = 3
+	fcgiParams       = 4
+	fcgiStdin        = 5
+	fcgiStdout       = 6
+	fcgiStderr       = 7
+	fcgiResponder    = 1
+	fcgiRequestID    = 1
+	fcgiMaxContent   = 65535
+)
+
+var fastCGIParamReference = regexp.MustCompile(`\{([A-Za-z0-9_]+)\}`)
+
+// fastCGIClient sends requests to FastCGI servers, one connection per request.
+type fastCGIClient struct {
+	config FastCGIConfig
+	dialer net.Dialer
+}
+
+func newFastCGIClient(c FastCGIConfig) *fastCGIClient {
+	if c.Index == "" {
+		c.Index = defaultFastCGIIndex
+	}
+	if c.Extension == "" {
+		c.Extension = defaultFastCGIExtension
+	}
+	if c.Timeout == 0 {
+		c.Timeout = defaultFastCGITimeout
+	}
+	return &fastCGIClient{config: c}
+}
+
+// params builds the CGI/1.1 parameters of a request, see RFC 3875.
+func (f *fastCGIClient) params(c *gin.Context, request *http.Request) map[string]string {
+	scriptName, pathInfo := f.splitPath(request.URL.Path)
+	host, port, err := net.SplitHostPort(c.Request.Host)
+	if err != nil {
+		host, port = c.Request.Host, "80"
+		if c.Request.TLS != nil {
+			port = "443"
+		}
+	}
+	params := map[string]string{
+		"GATEWAY_INTERFACE": "CGI/1.1",
+		"SERVER_SOFTWARE":   "sargantana",
+		"SERVER_PROTOCOL":   c.Request.Proto,
+		"SERVER_NAME":       host,
+		"SERVER_PORT":       port,
+		"REQUEST_METHOD":    request.Method,
+		"REQUEST_URI":       request.URL.RequestURI(),
+		"QUERY_STRING":      request.URL.RawQuery,
+		"DOCUMENT_ROOT":     f.config.ScriptRoot,
+		"DOCUMENT_URI":      scriptName,
+		"SCRIPT_NAME":       scriptName,
+		"SCRIPT_FILENAME":   path.Join(f.config.ScriptRoot, scriptName),
+		"PATH_INFO":         pathInfo,
+		"REMOTE_ADDR":       c.ClientIP(),
+		"CONTENT_TYPE":      request.Header.Get("Content-Type"),
+	}
+	if request.ContentLength > 0 {
+		params["CONTENT_LENGTH"] = strconv.FormatInt(request.ContentLength, 10)
+	}
+	if c.Request.TLS != nil {
+		params["HTTPS"] = "on"
+	}
+	for name, values := range request.Header {
+		// Proxy is skipped to protect scripts from HTTP_PROXY injection (httpoxy)
+		if name == "Content-Type" || name == "Content-Length" || name == "Proxy" {
+			continue
+		}
+		params["HTTP_"+strings.ToUpper(strings.ReplaceAll(name, "-", "_"))] = strings.Join(values, ", ")
+	}
+
+	standard := make(map[string]string, len(params))
+	for name, value := range params {
+		standard[name] = value
+	}
+	for name, value := range f.config.Params {
+		value = fastCGIParamReference.ReplaceAllStringFunc(value, func(reference string) string {
+			return standard[reference[1:len(reference)-1]]
+		})
+		if value == "" {
+			delete(params, name)
+		} else {
+			params[name] = value
+		}
+	}
+	return params
+}
+
+// splitPath splits a request path into the script name and the path info.
+func (f *fastCGIClient) splitPath(requestPath string) (string, string) {
+	cleaned := path.Clean("/" + requestPath)
+	if index := strings.Index(cleaned+"/", f.config.Extension+"/"); index >= 0 {
+		end := index + len(f.config.Extension)
+		return cleaned[:end], cleaned[end:]
+	}
+	return "/" + f.config.Index, cleaned
+}
+
+// roundTrip sends the request to the FastCGI endpoint and returns its response. The response body
+// streams the output of the script and must be closed.
+func (f *fastCGIClient) roundTrip(c *gin.Context, request *http.Request, endpoint url.URL) (*http.Response, error) {
+	if request.ContentLength < 0 {
+		// CGI scripts need CONTENT_LENGTH to read the body, buffer chunked bodies to compute it
+		body, err := io.ReadAll(request.Body)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to read the request body")
+		}
+		request.Body = io.NopCloser(bytes.NewReader(body))
+		request.ContentLength = int64(len(body))
+	}
+
+	network, address := "tcp", endpoint.Host
+	if endpoint.Scheme == "unix" {
+		network, address = "unix", endpoint.Path
+	}
+	conn, err := f.dialer.DialContext(request.Context(), network, address)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to connect to FastCGI server %s", address)
+	}
+	_ = conn.SetDeadline(time.Now().Add(f.config.Timeout))
+
+	response, err := f.exchange(conn, f.params(c, request), request)
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	response.Request = request
+	return response, nil
+}
+
+func (f *fastCGIClient) exchange(conn net.Conn, params map[string]string, request *http.Request) (*http.Response, error) {
+	writer := bufio.NewWriter(conn)
+	// Role and flags, the connection is closed by the server after the request
+	begin := []byte{0, fcgiResponder, 0, 0, 0, 0, 0, 0}
+	if err := writeFastCGIRecord(writer, fcgiBeginRequest, begin); err != nil {
+		return nil, err
+	}
+	var encoded bytes.Buffer
+	for name, value := range params {
+		writeFastCGILength(&encoded, len(name))
+		writeFastCGILength(&encoded, len(value))
+		encoded.WriteString(name)
+		encoded.WriteString(value)
+	}
+	if err := writeFastCGIStream(writer, fcgiParams, &encoded); err != nil {
+		return nil, err
+	}
+	body := io.Reader(http.NoBody)
+	if request.Body != nil {
+		body = request.Body
+	}
+	if err := writeFastCGIStream(writer, fcgiStdin, body); err != nil {
+		return nil, err
+	}
+	if err := writer.Flush(); err != nil {
+		return nil, errors.Wrap(err, "failed to send FastCGI request")
+	}
+
+	stdout := &fastCGIReader{conn: conn, reader: bufio.NewReader(conn), url: request.URL.Redacted()}
+	reader := bufio.NewReader(stdout)
+	header, err := textproto.NewReader(reader).ReadMIMEHeader()
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid FastCGI response headers")
+	}
+	response := &http.Response{
+		Status:     "200 OK",
+		StatusCode: http.StatusOK,
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header:     http.Header(header),
+		Body: struct {
+			io.Reader
+			io.Closer
+		}{reader, stdout},
+		ContentLength: -1,
+	}
+	if status := header.Get("Status"); status != "" {
+		code, err := strconv.Atoi(strings.Fields(status + " ")[0])
+		if err != nil || code < 100 || code > 999 {
+			return nil, errors.Errorf("invalid FastCGI response status %q", status)
+		}
+		response.Status, response.StatusCode = status, code
+		response.Header.Del("Status")
+	} else if header.Get("Location") != "" {
+		response.Status, response.StatusCode = "302 Found", http.StatusFound
+	}
+	return response, nil
+}
+
+func writeFastCGIRecord(w io.Writer, recordType byte, content []byte) error {
+	padding := -len(content) & 7
+	header := []byte{fcgiVersion, recordType, 0, fcgiRequestID, 0, 0, byte(padding), 0}
+	binary.BigEndian.PutUint16(header[4:], uint16(len(content)))
+	if _, err := w.Write(header); err != nil {
+		return errors.Wrap(err, "failed to send FastCGI request")
+	}
+	if _, err := w.Write(content); err != nil {
+		return errors.Wrap(err, "failed to send FastCGI request")
+	}
+	_, err := w.Write(make([]byte, padding))
+	return errors.Wrap(err, "failed to send FastCGI request")
+}
+
+// writeFastCGIStream sends a stream in records of at most 64 KiB, terminated by an empty record.
+func writeFastCGIStream(w io.Writer, recordType byte, r io.Reader) error {
+	chunk := make([]byte, fcgiMaxContent)
+	for {
+		n, err := io.ReadFull(r, chunk)
+		if n > 0 {
+			if writeErr := writeFastCGIRecord(w, recordType, chunk[:n]); writeErr != nil {
+				return writeErr
+			}
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return writeFastCGIRecord(w, recordType, nil)
+		}
+		if err != nil {
+			return errors.Wrap(err, "failed to read the request body")
+		}
+	}
+}
+
+func writeFastCGILength(buffer *bytes.Buffer, length int) {
+	if length < 128 {
+		buffer.WriteByte(byte(length))
+		return
+	}
+	_ = binary.Write(buffer, binary.BigEndian, uint32(length)|1<<31)
+}
+
+// fastCGIReader reads the standard output of a FastCGI request, logging its standard error, until
+// the end of the request.
+type fastCGIReader struct {
+	conn   net.Conn
+	reader *bufio.Reader
+	url    string
+	left   int // Bytes left of the current stdout record
+	pad    int // Padding after the current stdout record
+	done   bool
+}
+
+func (r *fastCGIReader) Read(p []byte) (int, error) {
+	for r.left == 0 {
+		if r.done {
+			return 0, io.EOF
+		}
+		if _, err := r.reader.Discard(r.pad); err != nil {
+			return 0, errors.Wrap(err, "truncated FastCGI response")
+		}
+		r.pad = 0
+		var header [8]byte
+		if _, err := io.ReadFull(r.reader, header[:]); err != nil {
+			return 0, errors.Wrap(err, "truncated FastCGI response")
+		}
+		length, padding := int(binary.BigEndian.Uint16(header[4:])), int(header[6])
+		switch header[1] {
+		case fcgiStdout:
+			r.left, r.pad = length, padding
+		case fcgiStderr:
+			content := make([]byte, length+padding)
+			if _, err := io.ReadFull(r.reader, content); err != nil {
+				return 0, errors.Wrap(err, "truncated FastCGI response")
+			}
+			log.Warn().Str("url", r.url).Str("stderr", strings.TrimSpace(string(content[:length]))).Msg("FastCGI script error output")
+		case fcgiEndRequest:
+			r.done = true
+		default:
+			if _, err := r.reader.Discard(length + padding); err != nil {
+				return 0, errors.Wrap(err, "truncated FastCGI response")
+			}
+		}
+	}
+	if len(p) > r.left {
+		p = p[:r.left]
+	}
+	n, err := r.reader.Read(p)
+	r.left -= n
+	if err == io.EOF {
+		err = errors.New("truncated FastCGI response")
+	}
+	return n, err
+}
+
+func (r *fastCGIReader) Close() error {
+	return r.conn.Close()
+}
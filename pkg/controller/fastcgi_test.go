@@ -0,0 +1,152 @@
+//go:build unit
+
+package controller
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/fcgi"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+
+	"github.com/animalet/sargantana-go/pkg/server"
+	"github.com/gin-gonic/gin"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"gopkg.in/yaml.v3"
+)
+
+var _ = Describe("FastCGI", func() {
+	Context("FastCGIConfig Validate", func() {
+		It("should validate the configuration", func() {
+			Expect(FastCGIConfig{ScriptRoot: "/var/www"}.Validate()).To(Succeed())
+			Expect(FastCGIConfig{ScriptRoot: "var/www"}.Validate()).To(MatchError(ContainSubstring("absolute path")))
+			Expect(FastCGIConfig{ScriptRoot: "/var/www", Index: "public/index.php"}.Validate()).To(HaveOccurred())
+			Expect(FastCGIConfig{ScriptRoot: "/var/www", Extension: "php"}.Validate()).To(HaveOccurred())
+			Expect(FastCGIConfig{ScriptRoot: "/var/www", Timeout: -1}.Validate()).To(HaveOccurred())
+			Expect(FastCGIConfig{ScriptRoot: "/var/www", Params: map[string]string{"": "x"}}.Validate()).To(HaveOccurred())
+
+			lbCfg := LoadBalancerControllerConfig{Path: "/", Endpoints: []string{"http://php:9000"}, FastCGI: &FastCGIConfig{ScriptRoot: "/var/www"}}
+			Expect(lbCfg.Validate()).To(MatchError(ContainSubstring("must be a tcp:// or unix:// address")))
+			lbCfg.Endpoints = []string{"tcp://php:9000", "unix:///run/php-fpm.sock"}
+			Expect(lbCfg.Validate()).To(Succeed())
+			lbCfg.SPIFFE = true
+			Expect(lbCfg.Validate()).To(MatchError(ContainSubstring("spiffe is not supported")))
+			lbCfg.SPIFFE, lbCfg.FastCGI.ScriptRoot = false, ""
+			Expect(lbCfg.Validate()).To(MatchError(ContainSubstring("invalid fastcgi configuration")))
+		})
+	})
+
+	Context("Script resolution", func() {
+		It("should split script paths and fall back to the index", func() {
+			client := newFastCGIClient(FastCGIConfig{ScriptRoot: "/var/www"})
+			split := func(requestPath string) []string {
+				script, info := client.splitPath(requestPath)
+				return []string{script, info}
+			}
+			Expect(split("/info.php")).To(Equal([]string{"/info.php", ""}))
+			Expect(split("/blog/post.php/2024/hello")).To(Equal([]string{"/blog/post.php", "/2024/hello"}))
+			Expect(split("/users/42")).To(Equal([]string{"/index.php", "/users/42"}))
+			Expect(split("/a.phpx/b")).To(Equal([]string{"/index.php", "/a.phpx/b"}))
+			Expect(split("/../../etc/passwd.php")).To(Equal([]string{"/etc/passwd.php", ""}))
+		})
+	})
+
+	Context("Load balancer", func() {
+		var engine *gin.Engine
+
+		// serveFastCGI starts a FastCGI server echoing the CGI environment and body of the requests
+		serveFastCGI := func(network, address string) string {
+			listener, err := net.Listen(network, address)
+			Expect(err).NotTo(HaveOccurred())
+			DeferCleanup(listener.Close)
+			go func() {
+				_ = fcgi.Serve(listener, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					env := fcgi.ProcessEnv(r)
+					switch r.URL.Path {
+					case "/missing":
+						w.WriteHeader(http.StatusNotFound)
+					case "/redirect":
+						http.Redirect(w, r, "/login", http.StatusSeeOther)
+					case "/large":
+						_, _ = w.Write([]byte(strings.Repeat("x", 200000)))
+					default:
+						body, _ := io.ReadAll(r.Body)
+						w.Header().Set("Content-Type", "text/plain")
+						w.Header().Set("Set-Cookie", "PHPSESSID=1")
+						_, _ = fmt.Fprintf(w, "%s %s script=%s query=%s root=%s app=%s auth=%q body=%s",
+							r.Method, r.URL.RequestURI(), env["SCRIPT_FILENAME"], r.URL.RawQuery,
+							env["DOCUMENT_ROOT"], env["APP_ENV"], r.Header.Get("Authorization"), body)
+					}
+				}))
+			}()
+			return listener.Addr().String()
+		}
+
+		setup := func(endpoint string) {
+			cfgBytes := []byte("path: /\nendpoints:\n  - " + endpoint + "\nfastcgi:\n  script_root: /var/www\n" +
+				"  params:\n    APP_ENV: production\n    SCRIPT_FILENAME: \"{DOCUMENT_ROOT}/public{SCRIPT_NAME}\"\n")
+			var lbCfg LoadBalancerControllerConfig
+			Expect(yaml.Unmarshal(cfgBytes, &lbCfg)).To(Succeed())
+			Expect(lbCfg.Validate()).To(Succeed())
+			ctrl, err := NewLoadBalancerController(&lbCfg, server.ControllerContext{})
+			Expect(err).NotTo(HaveOccurred())
+			DeferCleanup(ctrl.Close)
+
+			gin.SetMode(gin.TestMode)
+			engine = gin.New()
+			Expect(ctrl.Bind(engine, nil)).To(Succeed())
+		}
+
+		serve := func(method, path, body string) *httptest.ResponseRecorder {
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest(method, path, strings.NewReader(body))
+			req.Header.Set("Authorization", "Bearer secret")
+			engine.ServeHTTP(w, req)
+			return w
+		}
+
+		It("should serve scripts over TCP with mapped parameters", func() {
+			setup("tcp://" + serveFastCGI("tcp", "127.0.0.1:0"))
+
+			w := serve(http.MethodPost, "/users/42?sort=name", "name=x")
+			Expect(w.Code).To(Equal(http.StatusOK))
+			Expect(w.Header().Get("Content-Type")).To(Equal("text/plain"))
+			Expect(w.Header().Get("Set-Cookie")).To(BeEmpty())
+			Expect(w.Body.String()).To(Equal(`POST /users/42?sort=name script=/var/www/public/index.php query=sort=name root=/var/www app=production auth="" body=name=x`))
+
+			Expect(serve(http.MethodGet, "/blog/post.php/hello", "").Body.String()).To(ContainSubstring("script=/var/www/public/blog/post.php "))
+		})
+
+		It("should map the status and stream large responses", func() {
+			setup("tcp://" + serveFastCGI("tcp", "127.0.0.1:0"))
+
+			Expect(serve(http.MethodGet, "/missing", "").Code).To(Equal(http.StatusNotFound))
+			w := serve(http.MethodGet, "/redirect", "")
+			Expect(w.Code).To(Equal(http.StatusSeeOther))
+			Expect(w.Header().Get("Location")).To(Equal("/login"))
+			Expect(serve(http.MethodGet, "/large", "").Body.Len()).To(Equal(200000))
+		})
+
+		It("should serve scripts over unix sockets", func() {
+			socket := filepath.Join(GinkgoT().TempDir(), "php-fpm.sock")
+			serveFastCGI("unix", socket)
+			setup("unix://" + socket)
+
+			Expect(serve(http.MethodGet, "/info.php", "").Body.String()).To(ContainSubstring("script=/var/www/public/info.php"))
+		})
+
+		It("should fail with bad gateway when the server is unreachable", func() {
+			listener, err := net.Listen("tcp", "127.0.0.1:0")
+			Expect(err).NotTo(HaveOccurred())
+			address := listener.Addr().String()
+			Expect(listener.Close()).To(Succeed())
+			setup("tcp://" + address)
+
+			Expect(serve(http.MethodGet, "/", "").Code).To(Equal(http.StatusBadGateway))
+		})
+	})
+})
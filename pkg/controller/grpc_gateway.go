@@ -0,0 +1,517 @@
+package controller
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/animalet/sargantana-go/internal/snapshot"
+	"github.com/animalet/sargantana-go/pkg/server"
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	reflectionpb "google.golang.org/grpc/reflection/grpc_reflection_v1"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+const (
+	defaultGRPCTimeout = 30 * time.Second
+	// maxGRPCRequestBody bounds the size of the JSON request bodies transcoded to protobuf.
+	maxGRPCRequestBody = 4 * 1024 * 1024
+)
+
+// GRPCGatewayControllerConfig exposes unary methods of an upstream gRPC service as REST routes.
+// Request messages are built from the JSON body, path parameters and query parameters, and
+// response messages are returned as JSON.
+type GRPCGatewayControllerConfig struct {
+	Target string `yaml:"target"` // Upstream address, e.g. orders:50051
+	Auth   bool   `yaml:"auth"`
+	// Descriptors is a FileDescriptorSet file, as generated by
+	// protoc --include_imports --descriptor_set_out. Either Descriptors or Reflection must be set.
+	Descriptors string `yaml:"descriptors,omitempty"`
+	// Reflection resolves the methods through the server reflection service of the upstream.
+	Reflection bool `yaml:"reflection,omitempty"`
+	// TLS connects to the upstream with TLS, verified against the system roots. Plaintext is used
+	// if neither TLS nor SPIFFE is enabled.
+	TLS bool `yaml:"tls,omitempty"`
+	// SPIFFE enables mTLS to the upstream using the server's SPIFFE workload identity.
+	// SPIFFEIDs optionally restricts which upstream SPIFFE IDs are accepted.
+	SPIFFE    bool              `yaml:"spiffe,omitempty"`
+	SPIFFEIDs []string          `yaml:"spiffe_ids,omitempty"`
+	Timeout   time.Duration     `yaml:"timeout,omitempty"` // Per call, defaults to 30s
+	Routes    []GRPCRouteConfig `yaml:"routes"`
+}
+
+// GRPCRouteConfig maps an HTTP route to a gRPC method.
+type GRPCRouteConfig struct {
+	Method string `yaml:"method"` // HTTP method
+	// Path is the route path. Path parameters, e.g. /orders/:id, set the request fields with the
+	// same name. Nested fields are set with dotted names, e.g. /orders/:order.id.
+	Path string `yaml:"path"`
+	GRPC string `yaml:"grpc"` // Fully qualified method, e.g. orders.v1.OrderService/GetOrder
+	// Body is the request field the JSON body is decoded into, or "*" for the whole request
+	// message. The body is ignored if empty.
+	Body string `yaml:"body,omitempty"`
+}
+
+func (g GRPCGatewayControllerConfig) Validate() error {
+	if g.Target == "" {
+		return errors.New("target must be set and non-empty")
+	}
+	if (g.Descriptors == "") == !g.Reflection {
+		return errors.New("exactly one of descriptors or reflection must be set")
+	}
+	if g.TLS && g.SPIFFE {
+		return errors.New("tls and spiffe cannot be enabled at the same time")
+	}
+	if len(g.SPIFFEIDs) > 0 && !g.SPIFFE {
+		return errors.New("spiffe_ids requires spiffe to be enabled")
+	}
+	if g.Timeout < 0 {
+		return errors.New("timeout must not be negative")
+	}
+	if len(g.Routes) == 0 {
+		return errors.New("at least one route must be provided")
+	}
+	for i, route := range g.Routes {
+		if err := route.Validate(); err != nil {
+			return errors.Wrapf(err, "invalid route at index %d", i)
+		}
+	}
+	return nil
+}
+
+func (r GRPCRouteConfig) Validate() error {
+	switch r.Method {
+	case http.MethodGet, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete:
+	default:
+		return errors.Errorf("unsupported HTTP method %q", r.Method)
+	}
+	if !strings.HasPrefix(r.Path, "/") {
+		return errors.New("path must start with /")
+	}
+	service, method, ok := strings.Cut(r.GRPC, "/")
+	if !ok || service == "" || method == "" || strings.Contains(method, "/") {
+		return errors.Errorf("grpc method %q must have the form package.Service/Method", r.GRPC)
+	}
+	return nil
+}
+
+func NewGRPCGatewayController(c *GRPCGatewayControllerConfig, ctx server.ControllerContext) (server.IController, error) {
+	// Deep copy the config to enforce immutability
+	configCopy := snapshot.MustCopy(c)
+
+	creds := insecure.NewCredentials()
+	if configCopy.TLS {
+		creds = credentials.NewTLS(&tls.Config{MinVersion: tls.VersionTLS12})
+	} else if configCopy.SPIFFE {
+		if ctx.Identity == nil {
+			return nil, errors.New("spiffe is enabled but the server has no SPIFFE workload identity configured")
+		}
+		tlsConfig, err := ctx.Identity.ClientTLSConfig(configCopy.SPIFFEIDs...)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to configure upstream mTLS")
+		}
+		creds = credentials.NewTLS(tlsConfig)
+	}
+	conn, err := grpc.NewClient(configCopy.Target, grpc.WithTransportCredentials(creds))
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to create gRPC client for %s", configCopy.Target)
+	}
+
+	gateway := &grpcGateway{
+		config:  *configCopy,
+		conn:    conn,
+		timeout: configCopy.Timeout,
+		methods: make(map[string]protoreflect.MethodDescriptor),
+	}
+	if gateway.timeout == 0 {
+		gateway.timeout = defaultGRPCTimeout
+	}
+
+	if configCopy.Descriptors != "" {
+		files, err := loadDescriptorSet(configCopy.Descriptors)
+		if err != nil {
+			_ = conn.Close()
+			return nil, err
+		}
+		// Resolve every route now, so configuration errors prevent the controller from loading
+		for _, route := range configCopy.Routes {
+			if _, err := gateway.register(files, route); err != nil {
+				_ = conn.Close()
+				return nil, err
+			}
+		}
+	}
+
+	log.Info().Str("target", configCopy.Target).Int("routes", len(configCopy.Routes)).Bool("reflection", configCopy.Reflection).
+		Msg("gRPC gateway configured")
+	return gateway, nil
+}
+
+// grpcGateway transcodes REST requests to unary gRPC calls using dynamic protobuf messages.
+type grpcGateway struct {
+	server.IController
+	config  GRPCGatewayControllerConfig
+	conn    *grpc.ClientConn
+	timeout time.Duration
+
+	mu      sync.Mutex
+	methods map[string]protoreflect.MethodDescriptor // By GRPCRouteConfig.GRPC
+}
+
+func (g *grpcGateway) Bind(engine *gin.Engine, loginMiddleware gin.HandlerFunc) error {
+	return g.BindGroup(&engine.RouterGroup, loginMiddleware)
+}
+
+func (g *grpcGateway) BindGroup(group *gin.RouterGroup, loginMiddleware gin.HandlerFunc) error {
+	for _, route := range g.config.Routes {
+		if g.config.Auth {
+			group.Handle(route.Method, route.Path, loginMiddleware, g.handler(route))
+		} else {
+			group.Handle(route.Method, route.Path, g.handler(route))
+		}
+	}
+	return nil
+}
+
+func (g *grpcGateway) Close() error {
+	return g.conn.Close()
+}
+
+func (g *grpcGateway) handler(route GRPCRouteConfig) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(c.Request.Context(), g.timeout)
+		defer cancel()
+
+		method, err := g.resolve(ctx, route)
+		if err != nil {
+			log.Error().Err(err).Str("grpc", route.GRPC).Msg("Failed to resolve gRPC method")
+			c.JSON(http.StatusBadGateway, gin.H{"code": codes.Unavailable.String(), "message": "upstream method could not be resolved"})
+			return
+		}
+
+		request := dynamicpb.NewMessage(method.Input())
+		if err := decodeGRPCRequest(c, route, request); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"code": codes.InvalidArgument.String(), "message": err.Error()})
+			return
+		}
+
+		if id := server.RequestID(c); id != "" {
+			ctx = metadata.AppendToOutgoingContext(ctx, strings.ToLower(server.RequestIDHeader), id)
+		}
+		response := dynamicpb.NewMessage(method.Output())
+		if err := g.conn.Invoke(ctx, "/"+route.GRPC, request, response); err != nil {
+			st := status.Convert(err)
+			c.JSON(grpcHTTPStatus(st.Code()), gin.H{"code": st.Code().String(), "message": st.Message()})
+			return
+		}
+
+		body, err := protojson.Marshal(response)
+		if err != nil {
+			_ = c.AbortWithError(http.StatusInternalServerError, err)
+			return
+		}
+		c.Data(http.StatusOK, "application/json", body)
+	}
+}
+
+// resolve returns the descriptor of the route method, fetching it through server reflection the
+// first time if needed.
+func (g *grpcGateway) resolve(ctx context.Context, route GRPCRouteConfig) (protoreflect.MethodDescriptor, error) {
+	g.mu.Lock()
+	method, ok := g.methods[route.GRPC]
+	g.mu.Unlock()
+	if ok || !g.config.Reflection {
+		return method, nil
+	}
+
+	service, _, _ := strings.Cut(route.GRPC, "/")
+	files, err := fetchReflectionFiles(ctx, g.conn, service)
+	if err != nil {
+		return nil, err
+	}
+	return g.register(files, route)
+}
+
+// register looks up the route method and checks that the route can be transcoded to it.
+func (g *grpcGateway) register(files *protoregistry.Files, route GRPCRouteConfig) (protoreflect.MethodDescriptor, error) {
+	service, name, _ := strings.Cut(route.GRPC, "/")
+	descriptor, err := files.FindDescriptorByName(protoreflect.FullName(service))
+	if err != nil {
+		return nil, errors.Wrapf(err, "service %s not found", service)
+	}
+	serviceDescriptor, ok := descriptor.(protoreflect.ServiceDescriptor)
+	if !ok {
+		return nil, errors.Errorf("%s is not a service", service)
+	}
+	method := serviceDescriptor.Methods().ByName(protoreflect.Name(name))
+	if method == nil {
+		return nil, errors.Errorf("method %s not found", route.GRPC)
+	}
+	if method.IsStreamingClient() || method.IsStreamingServer() {
+		return nil, errors.Errorf("method %s is a streaming method, only unary methods are supported", route.GRPC)
+	}
+	if route.Body != "" && route.Body != "*" {
+		field := findField(method.Input(), route.Body)
+		if field == nil || field.Kind() != protoreflect.MessageKind || field.IsList() || field.IsMap() {
+			return nil, errors.Errorf("body of route %s must be a message field of %s", route.Path, method.Input().FullName())
+		}
+	}
+
+	g.mu.Lock()
+	g.methods[route.GRPC] = method
+	g.mu.Unlock()
+	return method, nil
+}
+
+func loadDescriptorSet(path string) (*protoregistry.Files, error) {
+	// #nosec G304 -- Descriptor set path is provided by operator configuration
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read descriptor set")
+	}
+	var set descriptorpb.FileDescriptorSet
+	if err := proto.Unmarshal(data, &set); err != nil {
+		return nil, errors.Wrap(err, "failed to parse descriptor set")
+	}
+	files, err := protodesc.NewFiles(&set)
+	return files, errors.Wrap(err, "invalid descriptor set")
+}
+
+// fetchReflectionFiles downloads the file declaring the service and all its dependencies through
+// the upstream reflection service.
+func fetchReflectionFiles(ctx context.Context, conn *grpc.ClientConn, service string) (*protoregistry.Files, error) {
+	stream, err := reflectionpb.NewServerReflectionClient(conn).ServerReflectionInfo(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to open reflection stream")
+	}
+	defer func() { _ = stream.CloseSend() }()
+
+	protos := make(map[string]*descriptorpb.FileDescriptorProto)
+	requested := make(map[string]bool)
+	pending := []*reflectionpb.ServerReflectionRequest{{
+		MessageRequest: &reflectionpb.ServerReflectionRequest_FileContainingSymbol{FileContainingSymbol: service},
+	}}
+	for len(pending) > 0 {
+		if err := stream.Send(pending[0]); err != nil {
+			return nil, errors.Wrap(err, "failed to send reflection request")
+		}
+		pending = pending[1:]
+		response, err := stream.Recv()
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to receive reflection response")
+		}
+		if failure := response.GetErrorResponse(); failure != nil {
+			return nil, errors.Errorf("reflection request failed: %s", failure.GetErrorMessage())
+		}
+		for _, raw := range response.GetFileDescriptorResponse().GetFileDescriptorProto() {
+			file := &descriptorpb.FileDescriptorProto{}
+			if err := proto.Unmarshal(raw, file); err != nil {
+				return nil, errors.Wrap(err, "invalid file descriptor in reflection response")
+			}
+			protos[file.GetName()] = file
+		}
+
+		for _, file := range protos {
+			for _, dependency := range file.GetDependency() {
+				if _, ok := protos[dependency]; ok || requested[dependency] {
+					continue
+				}
+				// Well-known types may be omitted by the upstream, they are compiled in
+				if known, err := protoregistry.GlobalFiles.FindFileByPath(dependency); err == nil {
+					protos[dependency] = protodesc.ToFileDescriptorProto(known)
+					continue
+				}
+				requested[dependency] = true
+				pending = append(pending, &reflectionpb.ServerReflectionRequest{
+					MessageRequest: &reflectionpb.ServerReflectionRequest_FileByFilename{FileByFilename: dependency},
+				})
+			}
+		}
+	}
+
+	set := &descriptorpb.FileDescriptorSet{}
+	for _, file := range protos {
+		set.File = append(set.File, file)
+	}
+	files, err := protodesc.NewFiles(set)
+	return files, errors.Wrap(err, "invalid descriptors returned by reflection")
+}
+
+// decodeGRPCRequest fills the request message from the JSON body, then the query parameters and
+// finally the path parameters.
+func decodeGRPCRequest(c *gin.Context, route GRPCRouteConfig, request *dynamicpb.Message) error {
+	if route.Body != "" {
+		body, err := io.ReadAll(io.LimitReader(c.Request.Body, maxGRPCRequestBody+1))
+		if err != nil {
+			return errors.Wrap(err, "failed to read request body")
+		}
+		if len(body) > maxGRPCRequestBody {
+			return errors.New("request body too large")
+		}
+		if len(strings.TrimSpace(string(body))) > 0 {
+			target := request.ProtoReflect()
+			if route.Body != "*" {
+				field := findField(request.Descriptor(), route.Body)
+				target = request.Mutable(field).Message()
+			}
+			if err := protojson.Unmarshal(body, target.Interface()); err != nil {
+				return errors.Wrap(err, "invalid request body")
+			}
+		}
+	}
+
+	for key, values := range c.Request.URL.Query() {
+		if err := setGRPCField(request, key, values); err != nil {
+			return errors.Wrapf(err, "invalid query parameter %s", key)
+		}
+	}
+	for _, param := range c.Params {
+		if err := setGRPCField(request, param.Key, []string{param.Value}); err != nil {
+			return errors.Wrapf(err, "invalid path parameter %s", param.Key)
+		}
+	}
+	return nil
+}
+
+// findField finds a field by its proto or JSON name.
+func findField(message protoreflect.MessageDescriptor, name string) protoreflect.FieldDescriptor {
+	if field := message.Fields().ByName(protoreflect.Name(name)); field != nil {
+		return field
+	}
+	return message.Fields().ByJSONName(name)
+}
+
+// setGRPCField sets a possibly nested (dotted) scalar field. Repeated fields take every value.
+func setGRPCField(message protoreflect.Message, path string, values []string) error {
+	parts := strings.Split(path, ".")
+	for i, part := range parts {
+		field := findField(message.Descriptor(), part)
+		if field == nil {
+			return errors.Errorf("unknown field %s", part)
+		}
+		if i < len(parts)-1 {
+			if field.Kind() != protoreflect.MessageKind || field.IsList() || field.IsMap() {
+				return errors.Errorf("field %s is not a message", part)
+			}
+			message = message.Mutable(field).Message()
+			continue
+		}
+
+		if field.IsMap() {
+			return errors.Errorf("map field %s cannot be set from a parameter", part)
+		}
+		if field.IsList() {
+			list := message.Mutable(field).List()
+			for _, value := range values {
+				parsed, err := parseGRPCScalar(field, value)
+				if err != nil {
+					return err
+				}
+				list.Append(parsed)
+			}
+			return nil
+		}
+		parsed, err := parseGRPCScalar(field, values[len(values)-1])
+		if err != nil {
+			return err
+		}
+		message.Set(field, parsed)
+	}
+	return nil
+}
+
+func parseGRPCScalar(field protoreflect.FieldDescriptor, value string) (protoreflect.Value, error) {
+	switch field.Kind() {
+	case protoreflect.StringKind:
+		return protoreflect.ValueOfString(value), nil
+	case protoreflect.BoolKind:
+		b, err := strconv.ParseBool(value)
+		return protoreflect.ValueOfBool(b), err
+	case protoreflect.Int32Kind, protoreflect.Sint32Kind, protoreflect.Sfixed32Kind:
+		n, err := strconv.ParseInt(value, 10, 32)
+		return protoreflect.ValueOfInt32(int32(n)), err
+	case protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind:
+		n, err := strconv.ParseInt(value, 10, 64)
+		return protoreflect.ValueOfInt64(n), err
+	case protoreflect.Uint32Kind, protoreflect.Fixed32Kind:
+		n, err := strconv.ParseUint(value, 10, 32)
+		return protoreflect.ValueOfUint32(uint32(n)), err
+	case protoreflect.Uint64Kind, protoreflect.Fixed64Kind:
+		n, err := strconv.ParseUint(value, 10, 64)
+		return protoreflect.ValueOfUint64(n), err
+	case protoreflect.FloatKind:
+		f, err := strconv.ParseFloat(value, 32)
+		return protoreflect.ValueOfFloat32(float32(f)), err
+	case protoreflect.DoubleKind:
+		f, err := strconv.ParseFloat(value, 64)
+		return protoreflect.ValueOfFloat64(f), err
+	case protoreflect.BytesKind:
+		b, err := base64.StdEncoding.DecodeString(value)
+		if err != nil {
+			b, err = base64.URLEncoding.DecodeString(value)
+		}
+		return protoreflect.ValueOfBytes(b), err
+	case protoreflect.EnumKind:
+		if enumValue := field.Enum().Values().ByName(protoreflect.Name(value)); enumValue != nil {
+			return protoreflect.ValueOfEnum(enumValue.Number()), nil
+		}
+		n, err := strconv.ParseInt(value, 10, 32)
+		if err != nil {
+			return protoreflect.Value{}, errors.Errorf("unknown %s value %q", field.Enum().FullName(), value)
+		}
+		return protoreflect.ValueOfEnum(protoreflect.EnumNumber(n)), nil
+	default:
+		return protoreflect.Value{}, errors.Errorf("field %s of kind %s cannot be set from a parameter", field.Name(), field.Kind())
+	}
+}
+
+// grpcHTTPStatus maps gRPC status codes to HTTP statuses, as the gRPC-HTTP transcoding spec does.
+func grpcHTTPStatus(code codes.Code) int {
+	switch code {
+	case codes.OK:
+		return http.StatusOK
+	case codes.Canceled:
+		return server.StatusClientClosedRequest
+	case codes.InvalidArgument, codes.FailedPrecondition, codes.OutOfRange:
+		return http.StatusBadRequest
+	case codes.DeadlineExceeded:
+		return http.StatusGatewayTimeout
+	case codes.NotFound:
+		return http.StatusNotFound
+	case codes.AlreadyExists, codes.Aborted:
+		return http.StatusConflict
+	case codes.PermissionDenied:
+		return http.StatusForbidden
+	case codes.Unauthenticated:
+		return http.StatusUnauthorized
+	case codes.ResourceExhausted:
+		return http.StatusTooManyRequests
+	case codes.Unimplemented:
+		return http.StatusNotImplemented
+	case codes.Unavailable:
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusInternalServerError
+	}
+}
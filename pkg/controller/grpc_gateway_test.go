@@ -0,0 +1,311 @@
+//go:build unit
+
+package controller
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/animalet/sargantana-go/pkg/server"
+	"github.com/gin-gonic/gin"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/reflection"
+	reflectionpb "google.golang.org/grpc/reflection/grpc_reflection_v1"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// itemsProto declares the test.v1.ItemService used by the gRPC gateway tests.
+var itemsProto = &descriptorpb.FileDescriptorProto{
+	Name:       proto.String("test/v1/items.proto"),
+	Package:    proto.String("test.v1"),
+	Syntax:     proto.String("proto3"),
+	Dependency: []string{"google/protobuf/timestamp.proto"},
+	MessageType: []*descriptorpb.DescriptorProto{
+		{
+			Name: proto.String("Filter"),
+			Field: []*descriptorpb.FieldDescriptorProto{
+				grpcTestField("active", 1, descriptorpb.FieldDescriptorProto_TYPE_BOOL, ""),
+			},
+		},
+		{
+			Name: proto.String("GetItemRequest"),
+			Field: []*descriptorpb.FieldDescriptorProto{
+				grpcTestField("item_id", 1, descriptorpb.FieldDescriptorProto_TYPE_STRING, ""),
+				grpcTestField("quantity", 2, descriptorpb.FieldDescriptorProto_TYPE_INT32, ""),
+				grpcRepeated(grpcTestField("tags", 3, descriptorpb.FieldDescriptorProto_TYPE_STRING, "")),
+				grpcTestField("filter", 4, descriptorpb.FieldDescriptorProto_TYPE_MESSAGE, ".test.v1.Filter"),
+				grpcTestField("status", 5, descriptorpb.FieldDescriptorProto_TYPE_ENUM, ".test.v1.Status"),
+			},
+		},
+		{
+			Name: proto.String("Item"),
+			Field: []*descriptorpb.FieldDescriptorProto{
+				grpcTestField("item_id", 1, descriptorpb.FieldDescriptorProto_TYPE_STRING, ""),
+				grpcTestField("quantity", 2, descriptorpb.FieldDescriptorProto_TYPE_INT32, ""),
+				grpcRepeated(grpcTestField("tags", 3, descriptorpb.FieldDescriptorProto_TYPE_STRING, "")),
+				grpcTestField("filter", 4, descriptorpb.FieldDescriptorProto_TYPE_MESSAGE, ".test.v1.Filter"),
+				grpcTestField("status", 5, descriptorpb.FieldDescriptorProto_TYPE_ENUM, ".test.v1.Status"),
+				grpcTestField("request_id", 6, descriptorpb.FieldDescriptorProto_TYPE_STRING, ""),
+				grpcTestField("created", 7, descriptorpb.FieldDescriptorProto_TYPE_MESSAGE, ".google.protobuf.Timestamp"),
+			},
+		},
+	},
+	EnumType: []*descriptorpb.EnumDescriptorProto{{
+		Name: proto.String("Status"),
+		Value: []*descriptorpb.EnumValueDescriptorProto{
+			{Name: proto.String("STATUS_UNSPECIFIED"), Number: proto.Int32(0)},
+			{Name: proto.String("STATUS_ACTIVE"), Number: proto.Int32(1)},
+		},
+	}},
+	Service: []*descriptorpb.ServiceDescriptorProto{{
+		Name: proto.String("ItemService"),
+		Method: []*descriptorpb.MethodDescriptorProto{
+			{Name: proto.String("GetItem"), InputType: proto.String(".test.v1.GetItemRequest"), OutputType: proto.String(".test.v1.Item")},
+			{Name: proto.String("WatchItem"), InputType: proto.String(".test.v1.GetItemRequest"), OutputType: proto.String(".test.v1.Item"), ServerStreaming: proto.Bool(true)},
+		},
+	}},
+}
+
+func grpcTestField(name string, number int32, kind descriptorpb.FieldDescriptorProto_Type, typeName string) *descriptorpb.FieldDescriptorProto {
+	field := &descriptorpb.FieldDescriptorProto{
+		Name:   proto.String(name),
+		Number: proto.Int32(number),
+		Type:   kind.Enum(),
+		Label:  descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+	}
+	if typeName != "" {
+		field.TypeName = proto.String(typeName)
+	}
+	return field
+}
+
+func grpcRepeated(field *descriptorpb.FieldDescriptorProto) *descriptorpb.FieldDescriptorProto {
+	field.Label = descriptorpb.FieldDescriptorProto_LABEL_REPEATED.Enum()
+	return field
+}
+
+var _ = Describe("gRPC Gateway Controller", func() {
+	var (
+		files    *protoregistry.Files
+		target   string
+		setPath  string
+		engine   *gin.Engine
+		grpcSrv  *grpc.Server
+		itemDesc protoreflect.MessageDescriptor
+	)
+
+	BeforeEach(func() {
+		set := &descriptorpb.FileDescriptorSet{File: []*descriptorpb.FileDescriptorProto{
+			protodesc.ToFileDescriptorProto(timestamppb.File_google_protobuf_timestamp_proto),
+			itemsProto,
+		}}
+		var err error
+		files, err = protodesc.NewFiles(set)
+		Expect(err).NotTo(HaveOccurred())
+		data, err := proto.Marshal(set)
+		Expect(err).NotTo(HaveOccurred())
+		setPath = filepath.Join(GinkgoT().TempDir(), "items.pb")
+		Expect(os.WriteFile(setPath, data, 0o600)).To(Succeed())
+
+		descriptor, err := files.FindDescriptorByName("test.v1.Item")
+		Expect(err).NotTo(HaveOccurred())
+		itemDesc = descriptor.(protoreflect.MessageDescriptor)
+		requestDescriptor, err := files.FindDescriptorByName("test.v1.GetItemRequest")
+		Expect(err).NotTo(HaveOccurred())
+		requestDesc := requestDescriptor.(protoreflect.MessageDescriptor)
+
+		grpcSrv = grpc.NewServer()
+		grpcSrv.RegisterService(&grpc.ServiceDesc{
+			ServiceName: "test.v1.ItemService",
+			HandlerType: (*interface{})(nil),
+			Methods: []grpc.MethodDesc{{
+				MethodName: "GetItem",
+				Handler: func(_ interface{}, ctx context.Context, dec func(interface{}) error, _ grpc.UnaryServerInterceptor) (interface{}, error) {
+					request := dynamicpb.NewMessage(requestDesc)
+					if err := dec(request); err != nil {
+						return nil, err
+					}
+					id := request.Get(requestDesc.Fields().ByName("item_id")).String()
+					if id == "missing" {
+						return nil, status.Error(codes.NotFound, "item not found")
+					}
+					// Item shares the field numbers of GetItemRequest
+					item := dynamicpb.NewMessage(itemDesc)
+					data, err := proto.Marshal(request)
+					if err != nil {
+						return nil, err
+					}
+					if err := proto.Unmarshal(data, item); err != nil {
+						return nil, err
+					}
+					if md, ok := metadata.FromIncomingContext(ctx); ok && len(md.Get("x-request-id")) > 0 {
+						item.Set(itemDesc.Fields().ByName("request_id"), protoreflect.ValueOfString(md.Get("x-request-id")[0]))
+					}
+					return item, nil
+				},
+			}},
+		}, struct{}{})
+		reflectionpb.RegisterServerReflectionServer(grpcSrv, reflection.NewServerV1(reflection.ServerOptions{
+			Services:           grpcSrv,
+			DescriptorResolver: files,
+		}))
+
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		Expect(err).NotTo(HaveOccurred())
+		target = listener.Addr().String()
+		go func() { _ = grpcSrv.Serve(listener) }()
+		DeferCleanup(grpcSrv.Stop)
+
+		gin.SetMode(gin.TestMode)
+		engine = gin.New()
+	})
+
+	routes := []GRPCRouteConfig{
+		{Method: http.MethodGet, Path: "/items/:item_id", GRPC: "test.v1.ItemService/GetItem"},
+		{Method: http.MethodPost, Path: "/items", GRPC: "test.v1.ItemService/GetItem", Body: "*"},
+		{Method: http.MethodPut, Path: "/items/:item_id/filter", GRPC: "test.v1.ItemService/GetItem", Body: "filter"},
+	}
+
+	bind := func(cfg GRPCGatewayControllerConfig) {
+		Expect(cfg.Validate()).To(Succeed())
+		ctrl, err := NewGRPCGatewayController(&cfg, server.ControllerContext{})
+		Expect(err).NotTo(HaveOccurred())
+		DeferCleanup(ctrl.Close)
+		Expect(ctrl.Bind(engine, func(c *gin.Context) {
+			if c.GetHeader("Authorization") == "" {
+				c.AbortWithStatus(http.StatusUnauthorized)
+			}
+		})).To(Succeed())
+	}
+
+	call := func(method, path, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer token")
+		engine.ServeHTTP(w, req)
+		return w
+	}
+
+	Context("GRPCGatewayControllerConfig Validate", func() {
+		It("should validate the configuration", func() {
+			valid := GRPCGatewayControllerConfig{Target: "localhost:50051", Reflection: true, Routes: routes}
+			Expect(valid.Validate()).To(Succeed())
+
+			invalid := valid
+			invalid.Target = ""
+			Expect(invalid.Validate()).To(MatchError(ContainSubstring("target")))
+			invalid = valid
+			invalid.Descriptors = "items.pb"
+			Expect(invalid.Validate()).To(MatchError(ContainSubstring("exactly one of descriptors or reflection")))
+			invalid = valid
+			invalid.TLS, invalid.SPIFFE = true, true
+			Expect(invalid.Validate()).To(HaveOccurred())
+			invalid = valid
+			invalid.Routes = nil
+			Expect(invalid.Validate()).To(HaveOccurred())
+
+			Expect(GRPCRouteConfig{Method: "TRACE", Path: "/a", GRPC: "a.B/C"}.Validate()).To(HaveOccurred())
+			Expect(GRPCRouteConfig{Method: http.MethodGet, Path: "a", GRPC: "a.B/C"}.Validate()).To(HaveOccurred())
+			Expect(GRPCRouteConfig{Method: http.MethodGet, Path: "/a", GRPC: "a.B.C"}.Validate()).To(HaveOccurred())
+		})
+
+		It("should reject routes that cannot be transcoded", func() {
+			for _, route := range []GRPCRouteConfig{
+				{Method: http.MethodGet, Path: "/a", GRPC: "test.v1.Unknown/GetItem"},
+				{Method: http.MethodGet, Path: "/a", GRPC: "test.v1.ItemService/Unknown"},
+				{Method: http.MethodGet, Path: "/a", GRPC: "test.v1.ItemService/WatchItem"},
+				{Method: http.MethodPost, Path: "/a", GRPC: "test.v1.ItemService/GetItem", Body: "quantity"},
+			} {
+				cfg := GRPCGatewayControllerConfig{Target: target, Descriptors: setPath, Routes: []GRPCRouteConfig{route}}
+				_, err := NewGRPCGatewayController(&cfg, server.ControllerContext{})
+				Expect(err).To(HaveOccurred())
+			}
+		})
+	})
+
+	for _, mode := range []string{"descriptors", "reflection"} {
+		Context("with "+mode, func() {
+			BeforeEach(func() {
+				cfg := GRPCGatewayControllerConfig{Target: target, Auth: true, Routes: routes}
+				if mode == "reflection" {
+					cfg.Reflection = true
+				} else {
+					cfg.Descriptors = setPath
+				}
+				bind(cfg)
+			})
+
+			It("should map path and query parameters to the request", func() {
+				w := call(http.MethodGet, "/items/42?quantity=3&tags=a&tags=b&filter.active=true&status=STATUS_ACTIVE", "")
+				Expect(w.Code).To(Equal(http.StatusOK))
+				Expect(w.Header().Get("Content-Type")).To(Equal("application/json"))
+				Expect(w.Body.String()).To(MatchJSON(`{"itemId": "42", "quantity": 3, "tags": ["a", "b"], "filter": {"active": true}, "status": "STATUS_ACTIVE"}`))
+			})
+
+			It("should decode the JSON body", func() {
+				w := call(http.MethodPost, "/items", `{"itemId": "7", "quantity": 2, "status": 1}`)
+				Expect(w.Code).To(Equal(http.StatusOK))
+				Expect(w.Body.String()).To(MatchJSON(`{"itemId": "7", "quantity": 2, "status": "STATUS_ACTIVE"}`))
+
+				w = call(http.MethodPut, "/items/8/filter", `{"active": true}`)
+				Expect(w.Body.String()).To(MatchJSON(`{"itemId": "8", "filter": {"active": true}}`))
+			})
+
+			It("should reject invalid requests", func() {
+				Expect(call(http.MethodGet, "/items/1?quantity=many", "").Code).To(Equal(http.StatusBadRequest))
+				Expect(call(http.MethodGet, "/items/1?unknown=1", "").Code).To(Equal(http.StatusBadRequest))
+				Expect(call(http.MethodGet, "/items/1?status=STATUS_NOPE", "").Code).To(Equal(http.StatusBadRequest))
+				Expect(call(http.MethodGet, "/items/1?filter=x", "").Code).To(Equal(http.StatusBadRequest))
+				w := call(http.MethodPost, "/items", `{"unknown": 1}`)
+				Expect(w.Code).To(Equal(http.StatusBadRequest))
+				Expect(w.Body.String()).To(ContainSubstring(codes.InvalidArgument.String()))
+			})
+
+			It("should map gRPC errors to HTTP statuses", func() {
+				w := call(http.MethodGet, "/items/missing", "")
+				Expect(w.Code).To(Equal(http.StatusNotFound))
+				Expect(w.Body.String()).To(MatchJSON(`{"code": "NotFound", "message": "item not found"}`))
+			})
+
+			It("should require authentication", func() {
+				w := httptest.NewRecorder()
+				engine.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/items/1", nil))
+				Expect(w.Code).To(Equal(http.StatusUnauthorized))
+			})
+		})
+	}
+
+	It("should propagate the request ID as metadata", func() {
+		engine.Use(func(c *gin.Context) { c.Set("sargantana.request_id", "req-42") })
+		bind(GRPCGatewayControllerConfig{Target: target, Descriptors: setPath, Routes: routes})
+		Expect(call(http.MethodGet, "/items/1", "").Body.String()).To(ContainSubstring(`"requestId":"req-42"`))
+	})
+
+	It("should report unavailable upstreams", func() {
+		grpcSrv.Stop()
+		bind(GRPCGatewayControllerConfig{Target: target, Reflection: true, Routes: routes})
+		Expect(call(http.MethodGet, "/items/1", "").Code).To(Equal(http.StatusBadGateway))
+	})
+
+	It("should map status codes", func() {
+		Expect(grpcHTTPStatus(codes.PermissionDenied)).To(Equal(http.StatusForbidden))
+		Expect(grpcHTTPStatus(codes.Unavailable)).To(Equal(http.StatusServiceUnavailable))
+		Expect(grpcHTTPStatus(codes.DataLoss)).To(Equal(http.StatusInternalServerError))
+	})
+})
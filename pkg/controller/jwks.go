@@ -0,0 +1,127 @@
+package controller
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/animalet/sargantana-go/internal/snapshot"
+	"github.com/animalet/sargantana-go/pkg/openapi"
+	"github.com/animalet/sargantana-go/pkg/server"
+	"github.com/animalet/sargantana-go/pkg/signing"
+	"github.com/gin-gonic/gin"
+	"github.com/go-jose/go-jose/v4"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+)
+
+const defaultJWKSMaxAge = 5 * time.Minute
+
+// JWKSControllerConfig configures the publication of the public signing keys as a JSON Web Key
+// Set, for the verifiers of the tokens signed by the server.
+type JWKSControllerConfig struct {
+	// Path serves the key set, e.g. /.well-known/jwks.json.
+	Path string `yaml:"path"`
+	// Rings are the names of the published rings of the signing_keys section. Defaults to all.
+	Rings []string `yaml:"rings,omitempty"`
+	// MaxAge is the max-age of the Cache-Control header. Defaults to 5m.
+	MaxAge time.Duration `yaml:"max_age,omitempty"`
+}
+
+func (c JWKSControllerConfig) Validate() error {
+	if c.Path == "" {
+		return errors.New("path must be set and non-empty")
+	}
+	if c.MaxAge < 0 {
+		return errors.New("max_age must be non-negative")
+	}
+	return nil
+}
+
+// jsonWebKeySet documents the key set served by the controller
+type jsonWebKeySet struct {
+	Keys []jsonWebKey `json:"keys"`
+}
+
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use,omitempty"`
+	Alg string `json:"alg,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+}
+
+// Describe adds the routes of the controller to the OpenAPI document, see openapi.Describer.
+func (c JWKSControllerConfig) Describe(doc *openapi.Document, basePath string) {
+	doc.Add(http.MethodGet, basePath, c.Path, openapi.Operation{
+		OperationID: "getJWKS",
+		Summary:     "Returns the public keys verifying the tokens signed by the gateway",
+		Tags:        []string{"keys"},
+		Responses: map[string]openapi.Response{
+			"200": {Description: "The key set", Content: openapi.JSON(doc.Schema("JSONWebKeySet", jsonWebKeySet{}))},
+		},
+	})
+}
+
+// NewJWKSController creates a controller serving the public keys of the asymmetric keys of the
+// signing rings. The rings must be loaded from the signing_keys section before.
+func NewJWKSController(c *JWKSControllerConfig, _ server.ControllerContext) (server.IController, error) {
+	// Deep copy the config to enforce immutability
+	configCopy := snapshot.MustCopy(c)
+
+	names := configCopy.Rings
+	if len(names) == 0 {
+		names = signing.Names()
+	}
+	if len(names) == 0 {
+		return nil, errors.New("no signing ring is configured, see the signing_keys section")
+	}
+	rings := make([]*signing.KeyRing, 0, len(names))
+	for _, name := range names {
+		ring, ok := signing.Lookup(name)
+		if !ok {
+			return nil, errors.Errorf("unknown signing ring %q", name)
+		}
+		rings = append(rings, ring)
+	}
+	maxAge := configCopy.MaxAge
+	if maxAge == 0 {
+		maxAge = defaultJWKSMaxAge
+	}
+
+	log.Info().Str("path", configCopy.Path).Strs("rings", names).Msg("JWKS endpoint configured")
+	return &jwks{path: configCopy.Path, rings: rings, maxAge: maxAge}, nil
+}
+
+type jwks struct {
+	path   string
+	rings  []*signing.KeyRing
+	maxAge time.Duration
+}
+
+func (j *jwks) Bind(engine *gin.Engine, loginMiddleware gin.HandlerFunc) error {
+	return j.BindGroup(&engine.RouterGroup, loginMiddleware)
+}
+
+func (j *jwks) BindGroup(group *gin.RouterGroup, _ gin.HandlerFunc) error {
+	group.GET(j.path, j.get)
+	return nil
+}
+
+func (j *jwks) Close() error {
+	return nil
+}
+
+// get serves the keys of the rings, which are reloaded on every request to follow the rotations
+func (j *jwks) get(c *gin.Context) {
+	set := jose.JSONWebKeySet{Keys: []jose.JSONWebKey{}}
+	for _, ring := range j.rings {
+		set.Keys = append(set.Keys, ring.JWKS().Keys...)
+	}
+	c.Header("Cache-Control", "public, max-age="+strconv.Itoa(int(j.maxAge.Seconds())))
+	c.JSON(http.StatusOK, set)
+}
@@ -0,0 +1,87 @@
+//go:build unit
+
+package controller
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	"github.com/animalet/sargantana-go/pkg/config/secrets"
+	"github.com/animalet/sargantana-go/pkg/server"
+	"github.com/animalet/sargantana-go/pkg/signing"
+	"github.com/gin-gonic/gin"
+	"github.com/go-jose/go-jose/v4"
+	"github.com/golang-jwt/jwt/v5"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+// jwksSecrets resolves the keys of the JWKS tests
+type jwksSecrets map[string]string
+
+func (s jwksSecrets) Resolve(key string) (string, error) {
+	return s[key], nil
+}
+
+var _ = Describe("JWKSController", func() {
+	BeforeEach(func() {
+		gin.SetMode(gin.TestMode)
+		ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		Expect(err).NotTo(HaveOccurred())
+		der, err := x509.MarshalPKCS8PrivateKey(ecKey)
+		Expect(err).NotTo(HaveOccurred())
+		secrets.Register("jwkstest", jwksSecrets{
+			"ec":   string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})),
+			"hmac": strings.Repeat("s", 32),
+		})
+
+		for name, key := range map[string]signing.KeyConfig{
+			"jwks-tokens": {ID: "tokens-1", Algorithm: "ES256", Secret: "jwkstest:ec"},
+			"jwks-csrf":   {ID: "csrf-1", Algorithm: "HS256", Secret: "jwkstest:hmac"},
+		} {
+			ring, err := signing.NewKeyRing([]signing.KeyConfig{key})
+			Expect(err).NotTo(HaveOccurred())
+			signing.Register(name, ring)
+		}
+	})
+
+	It("should validate the configuration", func() {
+		Expect(JWKSControllerConfig{}.Validate()).To(MatchError(ContainSubstring("path")))
+		Expect(JWKSControllerConfig{Path: "/jwks.json", MaxAge: -1}.Validate()).To(MatchError(ContainSubstring("max_age")))
+		Expect(JWKSControllerConfig{Path: "/jwks.json"}.Validate()).To(Succeed())
+		_, err := NewJWKSController(&JWKSControllerConfig{Path: "/jwks.json", Rings: []string{"unknown"}}, server.ControllerContext{})
+		Expect(err).To(MatchError(ContainSubstring(`unknown signing ring "unknown"`)))
+	})
+
+	It("should serve the public keys verifying the tokens of the rings", func() {
+		c, err := NewJWKSController(&JWKSControllerConfig{Path: "/.well-known/jwks.json", Rings: []string{"jwks-tokens", "jwks-csrf"}}, server.ControllerContext{})
+		Expect(err).NotTo(HaveOccurred())
+		engine := gin.New()
+		Expect(c.Bind(engine, nil)).To(Succeed())
+
+		w := httptest.NewRecorder()
+		engine.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/.well-known/jwks.json", nil))
+		Expect(w.Code).To(Equal(http.StatusOK))
+		Expect(w.Header().Get("Cache-Control")).To(Equal("public, max-age=300"))
+		var set jose.JSONWebKeySet
+		Expect(json.Unmarshal(w.Body.Bytes(), &set)).To(Succeed())
+		Expect(set.Keys).To(HaveLen(1))
+		Expect(set.Keys[0].KeyID).To(Equal("tokens-1"))
+
+		ring, _ := signing.Lookup("jwks-tokens")
+		token, err := ring.SignJWT(jwt.MapClaims{"sub": "alice"})
+		Expect(err).NotTo(HaveOccurred())
+		parsed, err := jwt.Parse(token, func(token *jwt.Token) (any, error) {
+			return set.Key(token.Header["kid"].(string))[0].Key, nil
+		})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(parsed.Valid).To(BeTrue())
+	})
+})
@@ -1,12 +1,18 @@
 package controller
 
 import (
+	"bytes"
+	"context"
 	"fmt"
 	"io"
+	"mime"
+	"net"
 	"net/http"
 	"net/url"
+	"slices"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/animalet/sargantana-go/internal/snapshot"
 	"github.com/animalet/sargantana-go/pkg/server"
@@ -19,6 +25,68 @@ type LoadBalancerControllerConfig struct {
 	Auth      bool     `yaml:"auth"`
 	Path      string   `yaml:"path"`
 	Endpoints []string `yaml:"endpoints"`
+	// Routes send the requests matching their methods, path or headers to other endpoints. The
+	// first matching route applies, the other requests go to Endpoints.
+	Routes []EndpointRouteConfig `yaml:"routes,omitempty"`
+	// SPIFFE enables mTLS to the endpoints using the server's SPIFFE workload identity.
+	// SPIFFEIDs optionally restricts which upstream SPIFFE IDs are accepted.
+	SPIFFE    bool     `yaml:"spiffe,omitempty"`
+	SPIFFEIDs []string `yaml:"spiffe_ids,omitempty"`
+	// TLS trusts a private CA bundle, pins public keys or overrides the server name for HTTPS
+	// endpoints, instead of the system trust.
+	TLS *UpstreamTLSConfig `yaml:"tls,omitempty"`
+	// Cache enables caching of upstream GET responses with tag-based invalidation.
+	Cache *ResponseCacheConfig `yaml:"cache,omitempty"`
+	// Transform rewrites upstream JSON responses before they are cached and sent to clients.
+	Transform *ResponseTransformConfig `yaml:"transform,omitempty"`
+	// XML enables XML and SOAP upstream support: JSON conversion, charset handling and XML hooks.
+	XML *XMLConfig `yaml:"xml,omitempty"`
+	// FastCGI talks FastCGI to the endpoints instead of HTTP, e.g. to serve PHP-FPM applications.
+	FastCGI *FastCGIConfig `yaml:"fastcgi,omitempty"`
+	// Concurrency caps the requests in flight to each endpoint, queueing or rejecting the others.
+	Concurrency *ConcurrencyConfig `yaml:"concurrency,omitempty"`
+	// ESI processes the Edge Side Includes of HTML responses, composing pages from the fragments
+	// served by other routes of the gateway.
+	ESI *ESIConfig `yaml:"esi,omitempty"`
+	// Coalesce shares the responses of GET requests in flight with the identical requests arriving
+	// meanwhile, instead of forwarding them. Disabled by default.
+	Coalesce *CoalesceConfig `yaml:"coalesce,omitempty"`
+	// StreamingTypes are the content types of the responses flushed to the clients while they are
+	// copied, e.g. server-sent events. Defaults to text/event-stream, application/x-ndjson and
+	// application/stream+json.
+	StreamingTypes []string `yaml:"streaming_types,omitempty"`
+	// Upgrades are the protocols of the Upgrade requests passed through to the endpoints, whose
+	// connections are then copied in both directions until either side closes. Defaults to
+	// websocket; the other Upgrade requests are forwarded without their Upgrade header.
+	Upgrades []string `yaml:"upgrades,omitempty"`
+	// FlushInterval is the maximum delay before the copied bytes of streaming responses are
+	// flushed. Defaults to 0, flushing after every write.
+	FlushInterval time.Duration `yaml:"flush_interval,omitempty"`
+	// LogCurl logs each proxied request as a sanitized curl command at debug level, to reproduce
+	// the upstream failures. Disabled by default.
+	LogCurl *CurlLogConfig `yaml:"log_curl,omitempty"`
+	// Timeout bounds the requests to the endpoints, until their responses are copied, unless their
+	// route sets its own. Defaults to 0, without timeout; the requests are still cancelled when
+	// their client disconnects.
+	Timeout time.Duration `yaml:"timeout,omitempty"`
+	// DeadlineHeader sends the deadline of the requests to the endpoints, e.g. X-Request-Deadline or
+	// grpc-timeout, so they can give up on work the gateway will not wait for.
+	DeadlineHeader string `yaml:"deadline_header,omitempty"`
+	// DeadlineFormat is the format of the deadline header: unix_ms, rfc3339 or grpc. Defaults to
+	// grpc for the grpc-timeout header and unix_ms for the others.
+	DeadlineFormat string `yaml:"deadline_format,omitempty"`
+	// Diff also sends the requests to candidate endpoints and compares their responses with the
+	// ones served, e.g. to migrate to a new backend. Disabled by default.
+	Diff *DiffConfig `yaml:"diff,omitempty"`
+	// HealthCheck probes the endpoints in the background, taking the failing ones out of rotation
+	// until they recover. Disabled by default.
+	HealthCheck *LoadBalancerHealthCheckConfig `yaml:"health_check,omitempty"`
+	// Retry retries the requests failing on an endpoint against the next endpoints, e.g. on
+	// connection errors or 503 responses. Disabled by default.
+	Retry *RetryConfig `yaml:"retry,omitempty"`
+	// CircuitBreaker ejects the endpoints failing repeatedly for a cool-down period. Disabled by
+	// default.
+	CircuitBreaker *CircuitBreakerConfig `yaml:"circuit_breaker,omitempty"`
 }
 
 func (l LoadBalancerControllerConfig) Validate() error {
@@ -35,10 +103,146 @@ func (l LoadBalancerControllerConfig) Validate() error {
 			return errors.Wrap(err, fmt.Sprintf("invalid endpoint URL: %s", endpoint))
 		}
 	}
+
+	for i, route := range l.Routes {
+		if err := route.Validate(); err != nil {
+			return errors.Wrapf(err, "invalid route %d", i)
+		}
+	}
+
+	if l.FastCGI != nil {
+		if err := l.FastCGI.Validate(); err != nil {
+			return errors.Wrap(err, "invalid fastcgi configuration")
+		}
+		if l.SPIFFE {
+			return errors.New("spiffe is not supported with fastcgi endpoints")
+		}
+		endpoints := slices.Clone(l.Endpoints)
+		for _, route := range l.Routes {
+			endpoints = append(endpoints, route.Endpoints...)
+		}
+		for _, endpoint := range endpoints {
+			if u, _ := url.Parse(endpoint); u.Scheme != "tcp" && u.Scheme != "unix" {
+				return errors.Errorf("fastcgi endpoint %s must be a tcp:// or unix:// address", endpoint)
+			}
+		}
+	}
+
+	if len(l.SPIFFEIDs) > 0 && !l.SPIFFE {
+		return errors.New("spiffe_ids requires spiffe to be enabled")
+	}
+
+	if l.TLS != nil {
+		if l.SPIFFE {
+			return errors.New("tls and spiffe cannot be configured at the same time")
+		}
+		if l.FastCGI != nil {
+			return errors.New("tls is not supported with fastcgi endpoints")
+		}
+		if err := l.TLS.Validate(); err != nil {
+			return errors.Wrap(err, "invalid tls configuration")
+		}
+	}
+
+	if l.Cache != nil {
+		if err := l.Cache.Validate(); err != nil {
+			return errors.Wrap(err, "invalid cache configuration")
+		}
+	}
+
+	if l.Transform != nil {
+		if err := l.Transform.Validate(); err != nil {
+			return errors.Wrap(err, "invalid transform configuration")
+		}
+	}
+
+	if l.XML != nil {
+		if err := l.XML.Validate(); err != nil {
+			return errors.Wrap(err, "invalid xml configuration")
+		}
+	}
+
+	if l.Concurrency != nil {
+		if err := l.Concurrency.Validate(); err != nil {
+			return errors.Wrap(err, "invalid concurrency configuration")
+		}
+	}
+
+	if l.ESI != nil {
+		if err := l.ESI.Validate(); err != nil {
+			return errors.Wrap(err, "invalid esi configuration")
+		}
+	}
+
+	if l.Coalesce != nil {
+		if err := l.Coalesce.Validate(); err != nil {
+			return errors.Wrap(err, "invalid coalesce configuration")
+		}
+	}
+
+	if l.LogCurl != nil {
+		if l.FastCGI != nil {
+			return errors.New("log_curl is not supported with fastcgi endpoints")
+		}
+		if err := l.LogCurl.Validate(); err != nil {
+			return errors.Wrap(err, "invalid log_curl configuration")
+		}
+	}
+
+	if l.Diff != nil {
+		if l.FastCGI != nil {
+			return errors.New("diff is not supported with fastcgi endpoints")
+		}
+		if err := l.Diff.Validate(); err != nil {
+			return errors.Wrap(err, "invalid diff configuration")
+		}
+	}
+
+	if l.HealthCheck != nil {
+		if l.FastCGI != nil {
+			return errors.New("health_check is not supported with fastcgi endpoints")
+		}
+		if err := l.HealthCheck.Validate(); err != nil {
+			return errors.Wrap(err, "invalid health_check configuration")
+		}
+	}
+
+	if l.Retry != nil {
+		if err := l.Retry.Validate(); err != nil {
+			return errors.Wrap(err, "invalid retry configuration")
+		}
+	}
+
+	if l.CircuitBreaker != nil {
+		if err := l.CircuitBreaker.Validate(); err != nil {
+			return errors.Wrap(err, "invalid circuit_breaker configuration")
+		}
+	}
+
+	if l.Timeout < 0 {
+		return errors.New("timeout must not be negative")
+	}
+	if err := validateDeadline(l.DeadlineHeader, l.DeadlineFormat); err != nil {
+		return err
+	}
+
+	if l.FlushInterval < 0 {
+		return errors.New("flush_interval must not be negative")
+	}
+	for _, streamingType := range l.StreamingTypes {
+		if _, _, err := mime.ParseMediaType(streamingType); err != nil {
+			return errors.Wrapf(err, "invalid streaming type %q", streamingType)
+		}
+	}
+	for _, protocol := range l.Upgrades {
+		if protocol == "" || strings.ContainsAny(protocol, " \t,") {
+			return errors.Errorf("invalid upgrade protocol %q", protocol)
+		}
+	}
 	return nil
 }
 
-func NewLoadBalancerController(c *LoadBalancerControllerConfig, _ server.ControllerContext) (server.IController, error) {
+func NewLoadBalancerController(c *LoadBalancerControllerConfig, ctx server.ControllerContext) (server.IController, error) {
 	// Deep copy the config to enforce immutability
 	configCopy := snapshot.MustCopy(c)
 
@@ -54,19 +258,212 @@ func NewLoadBalancerController(c *LoadBalancerControllerConfig, _ server.Control
 		}
 		endpoints = append(endpoints, *u)
 	}
+	defaultCount := len(endpoints)
+
+	routes := make([]*endpointRoute, 0, len(configCopy.Routes))
+	for _, routeConfig := range configCopy.Routes {
+		var indices []int
+		var err error
+		if endpoints, indices, err = endpointIndices(endpoints, routeConfig.Endpoints); err != nil {
+			return nil, err
+		}
+		route, err := newEndpointRoute(routeConfig, indices)
+		if err != nil {
+			return nil, err
+		}
+		routes = append(routes, route)
+		log.Info().
+			Strs("methods", routeConfig.Methods).
+			Str("path", routeConfig.Path).
+			Int("headers", len(routeConfig.Headers)).
+			Strs("endpoints", routeConfig.Endpoints).
+			Dur("timeout", routeConfig.Timeout).
+			Msg("Load balancing route configured")
+	}
+
+	transport := &http.Transport{
+		MaxIdleConns:        100,
+		MaxIdleConnsPerHost: 10,
+	}
+	if configCopy.SPIFFE {
+		if ctx.Identity == nil {
+			return nil, errors.New("spiffe is enabled but the server has no SPIFFE workload identity configured")
+		}
+		tlsConfig, err := ctx.Identity.ClientTLSConfig(configCopy.SPIFFEIDs...)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to configure upstream mTLS")
+		}
+		transport.TLSClientConfig = tlsConfig
+		log.Info().Strs("spiffe_ids", configCopy.SPIFFEIDs).Msg("Load balancing upstream mTLS configured with SPIFFE identity")
+	}
+	if configCopy.TLS != nil {
+		tlsConfig, err := configCopy.TLS.buildTLSConfig()
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to configure upstream TLS")
+		}
+		transport.TLSClientConfig = tlsConfig
+		log.Info().
+			Str("ca_file", configCopy.TLS.CAFile).
+			Int("pins", len(configCopy.TLS.Pins)).
+			Str("server_name", configCopy.TLS.ServerName).
+			Msg("Load balancing upstream TLS configured")
+	}
+	httpClient := &http.Client{Transport: transport}
+
+	var cache *responseCache
+	if configCopy.Cache != nil {
+		var err error
+		if cache, err = newResponseCache(*configCopy.Cache); err != nil {
+			return nil, err
+		}
+		log.Info().Dur("ttl", configCopy.Cache.TTL).Str("purge_path", configCopy.Cache.PurgePath).Msg("Load balancing response cache configured")
+	}
 
-	httpClient := &http.Client{
-		Transport: &http.Transport{
-			MaxIdleConns:        100,
-			MaxIdleConnsPerHost: 10,
-		},
+	var transformer *responseTransformer
+	if configCopy.Transform != nil {
+		var err error
+		if transformer, err = newResponseTransformer(*configCopy.Transform); err != nil {
+			return nil, err
+		}
+		log.Info().Msg("Load balancing response transformation configured")
+	}
+
+	var xmlSupport *xmlProxy
+	if configCopy.XML != nil {
+		var err error
+		if xmlSupport, err = newXMLProxy(*configCopy.XML); err != nil {
+			return nil, err
+		}
+		log.Info().Strs("hooks", configCopy.XML.Hooks).Msg("Load balancing XML support configured")
+	}
+
+	var fastCGI *fastCGIClient
+	if configCopy.FastCGI != nil {
+		fastCGI = newFastCGIClient(*configCopy.FastCGI)
+		log.Info().Str("script_root", configCopy.FastCGI.ScriptRoot).Msg("Load balancing FastCGI endpoints configured")
+	}
+
+	var limiters []*backendLimiter
+	var retryAfter time.Duration
+	if configCopy.Concurrency != nil {
+		limiters = newBackendLimiters(*configCopy.Concurrency, len(endpoints))
+		retryAfter = configCopy.Concurrency.RetryAfter
+		log.Info().
+			Int("max_in_flight", configCopy.Concurrency.MaxInFlight).
+			Int("max_queue", configCopy.Concurrency.MaxQueue).
+			Dur("queue_timeout", limiters[0].timeout).
+			Msg("Load balancing concurrency limits configured")
+	}
+
+	var esi *esiProcessor
+	if configCopy.ESI != nil {
+		var err error
+		if esi, err = newESIProcessor(*configCopy.ESI); err != nil {
+			return nil, err
+		}
+		log.Info().Dur("ttl", configCopy.ESI.TTL).Msg("Load balancing ESI processing configured")
+	}
+
+	var requestCoalescer *coalescer
+	if configCopy.Coalesce != nil {
+		requestCoalescer = newCoalescer(*configCopy.Coalesce)
+		log.Info().Strs("key_headers", requestCoalescer.config.KeyHeaders).Msg("Load balancing request coalescing configured")
+	}
+
+	var curl *curlLogger
+	if configCopy.LogCurl != nil {
+		curl = newCurlLogger(*configCopy.LogCurl)
+		log.Info().Int64("max_body_size", curl.maxBodySize).Msg("Load balancing curl commands logged at debug level")
+	}
+
+	var differ *responseDiffer
+	if configCopy.Diff != nil {
+		var err error
+		if differ, err = newResponseDiffer(*configCopy.Diff, httpClient); err != nil {
+			return nil, err
+		}
+		log.Info().Strs("candidates", configCopy.Diff.Endpoints).Strs("methods", differ.methods).Msg("Load balancing response diffing configured")
+	}
+
+	deadlineFormat := configCopy.DeadlineFormat
+	if configCopy.DeadlineHeader != "" {
+		if deadlineFormat == "" {
+			deadlineFormat = defaultDeadlineFormat(configCopy.DeadlineHeader)
+		}
+		log.Info().Str("header", configCopy.DeadlineHeader).Str("format", deadlineFormat).Msg("Load balancing deadline propagation configured")
+	}
+
+	var prober *healthProber
+	if configCopy.HealthCheck != nil {
+		prober = newHealthProber(*configCopy.HealthCheck, transport, ctx.Name, endpoints)
+		log.Info().
+			Str("path", prober.config.Path).
+			Dur("interval", prober.config.Interval).
+			Int("unhealthy_threshold", prober.config.UnhealthyThreshold).
+			Int("healthy_threshold", prober.config.HealthyThreshold).
+			Msg("Load balancing active health checks configured")
+	}
+
+	var retry *retryPolicy
+	if configCopy.Retry != nil {
+		retry = newRetryPolicy(*configCopy.Retry)
+		log.Info().
+			Int("max_retries", retry.config.MaxRetries).
+			Ints("statuses", retry.config.Statuses).
+			Strs("methods", retry.config.Methods).
+			Dur("backoff", retry.config.Backoff).
+			Msg("Load balancing retries configured")
+	}
+
+	var breakers []*circuitBreaker
+	if configCopy.CircuitBreaker != nil {
+		breakers = newCircuitBreakers(*configCopy.CircuitBreaker, ctx.Name, endpoints)
+		log.Info().
+			Float64("failure_rate", breakers[0].config.FailureRate).
+			Int("min_requests", breakers[0].config.MinRequests).
+			Dur("window", breakers[0].config.Window).
+			Dur("cool_down", breakers[0].config.CoolDown).
+			Msg("Load balancing circuit breakers configured")
+	}
+
+	streamingTypes := configCopy.StreamingTypes
+	if len(streamingTypes) == 0 {
+		streamingTypes = defaultStreamingTypes
+	}
+	upgradeProtocols := defaultUpgrades
+	if len(configCopy.Upgrades) > 0 {
+		upgradeProtocols = make([]string, len(configCopy.Upgrades))
+		for i, protocol := range configCopy.Upgrades {
+			upgradeProtocols[i] = strings.ToLower(protocol)
+		}
 	}
 
 	return &loadBalancer{
-		endpoints:  endpoints,
-		httpClient: httpClient,
-		path:       strings.TrimSuffix(configCopy.Path, "/") + "/*proxyPath",
-		auth:       configCopy.Auth,
+		endpoints:        endpoints,
+		defaultCount:     defaultCount,
+		routes:           routes,
+		httpClient:       httpClient,
+		path:             strings.TrimSuffix(configCopy.Path, "/") + "/*proxyPath",
+		auth:             configCopy.Auth,
+		cache:            cache,
+		transformer:      transformer,
+		xml:              xmlSupport,
+		fastCGI:          fastCGI,
+		limiters:         limiters,
+		retryAfter:       retryAfter,
+		esi:              esi,
+		coalescer:        requestCoalescer,
+		streamingTypes:   streamingTypes,
+		upgradeProtocols: upgradeProtocols,
+		flushInterval:    configCopy.FlushInterval,
+		curl:             curl,
+		differ:           differ,
+		timeout:          configCopy.Timeout,
+		deadlineHeader:   configCopy.DeadlineHeader,
+		deadlineFormat:   deadlineFormat,
+		prober:           prober,
+		retry:            retry,
+		breakers:         breakers,
 	}, nil
 }
 
@@ -75,22 +472,48 @@ func NewLoadBalancerController(c *LoadBalancerControllerConfig, _ server.Control
 // optional authentication requirements for protected load-balanced routes.
 type loadBalancer struct {
 	server.IController
-	endpoints     []url.URL
-	endpointIndex int
-	mu            sync.Mutex
-	httpClient    *http.Client
-	path          string
-	auth          bool
+	endpoints      []url.URL // The default endpoints, followed by the other endpoints of the routes
+	defaultCount   int       // Number of default endpoints, receiving the requests no route matches
+	routes         []*endpointRoute
+	endpointIndex  int
+	mu             sync.Mutex
+	httpClient     *http.Client
+	path           string
+	auth           bool
+	cache          *responseCache       // Nil if caching is disabled
+	transformer    *responseTransformer // Nil if responses are not transformed
+	xml            *xmlProxy            // Nil if XML support is disabled
+	fastCGI        *fastCGIClient       // Nil if the endpoints talk HTTP
+	limiters       []*backendLimiter    // One per endpoint, nil if concurrency is not limited
+	retryAfter     time.Duration
+	esi            *esiProcessor // Nil if ESI tags are not processed
+	coalescer      *coalescer    // Nil if requests are not coalesced
+	streamingTypes []string
+	// upgradeProtocols are the lower case protocols of the Upgrade requests passed through
+	upgradeProtocols []string
+	flushInterval    time.Duration
+	curl             *curlLogger     // Nil if the curl commands are not logged
+	differ           *responseDiffer // Nil if the responses are not compared with candidates
+	timeout          time.Duration
+	deadlineHeader   string // Empty if the deadlines are not sent to the endpoints
+	deadlineFormat   string
+	prober           *healthProber     // Nil if the endpoints are not probed
+	retry            *retryPolicy      // Nil if the failed requests are not retried
+	breakers         []*circuitBreaker // One per endpoint, nil if the endpoints are not ejected
 }
 
 func (l *loadBalancer) Bind(engine *gin.Engine, loginMiddleware gin.HandlerFunc) error {
+	return l.BindGroup(&engine.RouterGroup, loginMiddleware)
+}
+
+func (l *loadBalancer) BindGroup(group *gin.RouterGroup, loginMiddleware gin.HandlerFunc) error {
 	if len(l.endpoints) == 0 {
 		log.Warn().Msg("Load balancer not loaded: no endpoints configured")
 		return nil
 	}
 
 	if l.auth {
-		engine.GET(l.path, loginMiddleware, l.forward).
+		group.GET(l.path, loginMiddleware, l.forward).
 			POST(l.path, loginMiddleware, l.forward).
 			PUT(l.path, loginMiddleware, l.forward).
 			DELETE(l.path, loginMiddleware, l.forward).
@@ -98,7 +521,7 @@ func (l *loadBalancer) Bind(engine *gin.Engine, loginMiddleware gin.HandlerFunc)
 			HEAD(l.path, loginMiddleware, l.forward).
 			OPTIONS(l.path, loginMiddleware, l.forward)
 	} else {
-		engine.GET(l.path, l.forward).
+		group.GET(l.path, l.forward).
 			POST(l.path, l.forward).
 			PUT(l.path, l.forward).
 			DELETE(l.path, l.forward).
@@ -106,55 +529,238 @@ func (l *loadBalancer) Bind(engine *gin.Engine, loginMiddleware gin.HandlerFunc)
 			HEAD(l.path, l.forward).
 			OPTIONS(l.path, l.forward)
 	}
+
+	if l.cache != nil && l.cache.config.PurgePath != "" {
+		group.POST(l.cache.config.PurgePath, loginMiddleware, l.cache.purgeHandler)
+	}
 	return nil
 }
 
 func (l *loadBalancer) Close() error {
-	return nil
+	if l.prober != nil {
+		l.prober.Close()
+	}
+	var err error
+	if l.cache != nil {
+		err = l.cache.Close()
+	}
+	if l.esi != nil {
+		if esiErr := l.esi.Close(); err == nil {
+			err = esiErr
+		}
+	}
+	return err
 }
 
-func (l *loadBalancer) nextEndpoint() url.URL {
+// StartupCheck connects to each endpoint, reporting the unreachable ones in the startup
+// diagnostics, see server.StartupChecker.
+func (l *loadBalancer) StartupCheck(ctx context.Context) []server.CheckResult {
+	results := make([]server.CheckResult, 0, len(l.endpoints))
+	var dialer net.Dialer
+	for _, endpoint := range l.endpoints {
+		network, address := "tcp", endpoint.Host
+		switch {
+		case endpoint.Scheme == "unix":
+			network, address = "unix", endpoint.Path
+		case endpoint.Port() == "" && endpoint.Scheme == "https":
+			address = net.JoinHostPort(endpoint.Hostname(), "443")
+		case endpoint.Port() == "":
+			address = net.JoinHostPort(endpoint.Hostname(), "80")
+		}
+
+		start := time.Now()
+		conn, err := dialer.DialContext(ctx, network, address)
+		if err == nil {
+			_ = conn.Close()
+		}
+		results = append(results, server.CheckResult{
+			Name:     "endpoint " + endpoint.String(),
+			Err:      errors.Wrapf(err, "endpoint %s is unreachable", address),
+			Duration: time.Since(start),
+		})
+	}
+	return results
+}
+
+// HealthCheck fails when none of the endpoints is reachable, or healthy if they are probed, see
+// server.HealthReporter. A single unreachable endpoint does not fail it, so that an upstream outage
+// does not make every replica of the gateway unready.
+func (l *loadBalancer) HealthCheck(ctx context.Context) error {
+	if l.prober != nil {
+		if unhealthy := l.prober.unhealthy(); len(unhealthy) == len(l.endpoints) {
+			return errors.Errorf("no endpoint is healthy: %s", strings.Join(unhealthy, ", "))
+		}
+		return nil
+	}
+	var unreachable []string
+	for _, result := range l.StartupCheck(ctx) {
+		if result.Err == nil {
+			return nil
+		}
+		unreachable = append(unreachable, result.Err.Error())
+	}
+	if len(unreachable) == 0 {
+		return nil
+	}
+	return errors.Errorf("no endpoint is reachable: %s", strings.Join(unreachable, "; "))
+}
+
+func (l *loadBalancer) nextEndpoint() int {
 	l.mu.Lock()
 	defer func() {
-		l.endpointIndex = (l.endpointIndex + 1) % len(l.endpoints)
+		l.endpointIndex = (l.endpointIndex + 1) % l.defaultCount
 		l.mu.Unlock()
 	}()
-	return l.endpoints[l.endpointIndex]
+	return l.endpointIndex
 }
 
 func (l *loadBalancer) forward(c *gin.Context) {
-	endpoint := l.nextEndpoint()
-	// Build the target URL using only path and raw query
-	targetUrl := url.URL{
-		Scheme:   endpoint.Scheme,
-		Host:     endpoint.Host,
-		Path:     c.Request.URL.Path,
-		RawQuery: c.Request.URL.RawQuery,
+	if protocol := upgradeProtocol(c.Request.Header); l.upgrades(protocol) {
+		route, _ := l.route(c)
+		l.forwardUpgrade(c, route, protocol)
+		return
 	}
 
-	// Create the new request
-	request, err := http.NewRequest(c.Request.Method, targetUrl.String(), c.Request.Body)
-	if err != nil {
-		_ = c.AbortWithError(http.StatusInternalServerError, err)
+	cacheable := l.cache != nil && l.cache.cacheable(c)
+	cacheKey := c.Request.URL.RequestURI()
+	wantsJSON := l.xml != nil && l.xml.wantsJSON(c)
+	if wantsJSON {
+		cacheKey += "\x00json"
+	}
+	route, routeIndex := l.route(c)
+	cacheKey += routeKey(routeIndex)
+	if !l.decompresses() {
+		// The encoding of the response depends on the Accept-Encoding forwarded upstream
+		cacheKey += "\x00" + c.GetHeader("Accept-Encoding")
+	}
+	if cacheable {
+		if entry, ok := l.cache.get(cacheKey); ok {
+			l.writeStored(c, entry.status, entry.header, entry.body, CacheStatusHeader, "HIT")
+			return
+		}
+	}
+
+	// The first of identical GET requests leads their flight, the others wait for its response
+	var leader *flight
+	var coalesceKey string
+	if l.coalescer != nil && l.coalescer.coalescable(c) {
+		coalesceKey = l.coalescer.key(c, cacheKey)
+		f, leads := l.coalescer.join(coalesceKey)
+		if !leads {
+			if shared := f.wait(c.Request.Context(), l.coalescer.config.Timeout); shared != nil {
+				l.writeStored(c, shared.status, shared.header, shared.body, CoalescedHeader, "true")
+				return
+			}
+		} else {
+			leader = f
+			// Releases the waiting requests if the response is not shared
+			defer l.coalescer.finish(coalesceKey, leader, nil)
+		}
+	}
+
+	index := l.pickRouteEndpoint(route)
+	if index < 0 {
+		l.rejectOpenCircuits(c)
 		return
 	}
 
-	// Filter headers to avoid leaking sensitive data
-	for k, v := range c.Request.Header {
-		// Skip Host, X-Forwarded-For, Authorization, Cookie, etc.
-		if strings.EqualFold(k, "Host") || strings.HasPrefix(strings.ToLower(k), "x-forwarded-") || strings.EqualFold(k, "Authorization") || strings.EqualFold(k, "Cookie") {
-			continue
+	// Create the requests, cancelled when the client disconnects or the timeout expires
+	ctx, cancel := l.upstreamContext(c, route)
+	defer cancel()
+	var replay []byte // The body sent again on retries
+	retryable := false
+	if l.retry != nil {
+		replay, retryable = l.retry.bufferBody(c.Request)
+	}
+
+	var tried []int
+	var diff *diffRun
+	var response *http.Response
+	var err error
+	release := func() {}
+	defer func() { release() }()
+	for {
+		endpoint := l.endpoints[index]
+		if l.limiters != nil {
+			limiter := l.limiters[index]
+			if err = limiter.acquire(c.Request.Context(), server.RequestPriority(c)); err != nil {
+				l.recordOutcome(index, outcomeIgnored)
+				rejectRequest(c, err, l.retryAfter)
+				return
+			}
+			release = limiter.release
 		}
-		for _, vv := range v {
-			request.Header.Add(k, vv)
+		if replay != nil {
+			c.Request.Body = io.NopCloser(bytes.NewReader(replay))
+		}
+		var request *http.Request
+		if request, err = l.newUpstreamRequest(ctx, c, endpoint); err != nil {
+			l.recordOutcome(index, outcomeIgnored)
+			_ = c.AbortWithError(http.StatusInternalServerError, err)
+			return
+		}
+		if l.xml != nil {
+			if err = l.xml.prepareRequest(c, request); err != nil {
+				l.recordOutcome(index, outcomeIgnored)
+				c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
 		}
-	}
 
-	request.Header.Set("X-Forwarded-For", c.ClientIP())
+		if l.curl != nil {
+			l.curl.log(c, request)
+		}
+		if l.differ != nil && len(tried) == 0 {
+			diff = l.differ.start(c, request)
+		}
+
+		observed := observeBackend(c, endpoint)
+		if l.fastCGI != nil {
+			response, err = l.fastCGI.roundTrip(c, request, endpoint)
+		} else {
+			response, err = l.httpClient.Do(request)
+		}
+		observed(response, err)
+		if l.breakers != nil {
+			breaker := l.breakers[index]
+			breaker.record(breaker.outcome(response, err, server.ClientClosed(c)))
+		}
+		tried = append(tried, index)
+		if !retryable || len(tried) > l.retry.config.MaxRetries {
+			break
+		}
+		reason := l.retry.reason(ctx, response, err)
+		next := -1
+		if reason != "" {
+			next = l.retryEndpoint(route, tried)
+		}
+		if next < 0 {
+			break
+		}
 
-	response, err := l.httpClient.Do(request)
+		// The failed attempt is discarded before trying the next endpoint
+		if response != nil {
+			_ = response.Body.Close()
+		}
+		release()
+		release = func() {}
+		backendRetries.WithLabelValues(server.ControllerName(c), endpoint.Redacted(), reason).Inc()
+		logger := server.RequestLogger(c)
+		logger.Debug().
+			Err(err).
+			Str("endpoint", endpoint.Redacted()).
+			Str("reason", reason).
+			Str("next_endpoint", l.endpoints[next].Redacted()).
+			Msg("Load balancing request retried")
+		if !l.retry.wait(ctx, len(tried)) {
+			l.recordOutcome(next, outcomeIgnored)
+			abortUpstreamError(c, ctx.Err())
+			return
+		}
+		index = next
+	}
 	if err != nil {
-		_ = c.AbortWithError(http.StatusBadGateway, err)
+		abortUpstreamError(c, err)
 		return
 	}
 	defer func() {
@@ -163,19 +769,172 @@ func (l *loadBalancer) forward(c *gin.Context) {
 			log.Error().Err(err).Msg("Error closing response body")
 		}
 	}()
+	if diff != nil {
+		diff.capture(response)
+	}
 
-	c.Status(response.StatusCode)
+	body := io.Reader(response.Body)
+	if l.xml != nil {
+		processed, xmlErr := l.xml.processResponse(c, response, wantsJSON)
+		if xmlErr != nil {
+			_ = c.AbortWithError(http.StatusBadGateway, xmlErr)
+			return
+		}
+		if processed != nil {
+			body = bytes.NewReader(processed)
+		}
+		if l.xml.config.JSONResponses {
+			response.Header.Add("Vary", "Accept")
+		}
+	}
+	if l.transformer != nil && l.transformer.applies(response.Header) {
+		raw, readErr := io.ReadAll(body)
+		if readErr != nil {
+			_ = c.AbortWithError(http.StatusBadGateway, readErr)
+			return
+		}
+		body = bytes.NewReader(l.transformer.transformResponse(response, raw))
+	}
+
+	var sharedHeader http.Header
+	if leader != nil {
+		sharedHeader = make(http.Header, len(response.Header))
+	}
 	for k, v := range response.Header {
 		if strings.EqualFold(k, "Set-Cookie") {
 			continue // avoid leaking backend cookies
 		}
+		if l.cache != nil && strings.EqualFold(k, l.cache.config.TagHeader) {
+			continue
+		}
 		for _, vv := range v {
 			c.Writer.Header().Add(k, vv)
 		}
+		if sharedHeader != nil {
+			sharedHeader[k] = v
+		}
+	}
+
+	if cacheable {
+		c.Header(CacheStatusHeader, "MISS")
+		// Buffer up to the maximum cached body size, the rest is streamed
+		buffered, readErr := io.ReadAll(io.LimitReader(body, l.cache.config.MaxBodySize+1))
+		// The key tells apart the Accept-Encoding, and the Accept preferring JSON of XML upstreams
+		keyed := []string{"Accept-Encoding"}
+		if l.xml != nil && l.xml.config.JSONResponses {
+			keyed = append(keyed, "Accept")
+		}
+		if readErr == nil && int64(len(buffered)) <= l.cache.config.MaxBodySize && !varies(response.Header, keyed...) {
+			l.cache.store(cacheKey, response.StatusCode, response.Header, buffered)
+		}
+		body = io.MultiReader(bytes.NewReader(buffered), body)
+	}
+
+	var sharedBody *coalesceBuffer
+	if leader != nil {
+		if l.streams(response.Header.Get("Content-Type")) {
+			// Streams may not end, the waiting requests are forwarded at once
+			l.coalescer.finish(coalesceKey, leader, nil)
+		} else {
+			sharedBody = &coalesceBuffer{max: l.coalescer.config.MaxBodySize}
+			body = io.TeeReader(body, sharedBody)
+		}
+	}
+
+	// Cached and shared pages keep their ESI tags, so that fragments are resolved for each client
+	if l.esi != nil && l.esi.applies(response.Header) {
+		c.Writer.Header().Del("Content-Length")
+		processed, esiErr := l.esi.processStream(c, body)
+		if esiErr != nil {
+			_ = c.AbortWithError(http.StatusBadGateway, esiErr)
+			return
+		}
+		body = processed
 	}
 
-	_, err = io.Copy(c.Writer, response.Body)
+	c.Status(response.StatusCode)
+	err = l.copyResponse(c, body, response.Header.Get("Content-Type"))
 	if err != nil {
-		log.Error().Err(err).Msg("Error copying response body")
+		if server.ClientClosed(c) {
+			log.Debug().Err(err).Msg("Client disconnected while copying response body")
+		} else {
+			log.Error().Err(err).Msg("Error copying response body")
+		}
+		return
+	}
+	if diff != nil {
+		go diff.compare()
+	}
+	if sharedBody != nil && !sharedBody.overflow {
+		l.coalescer.finish(coalesceKey, leader, &coalescedResponse{
+			status: response.StatusCode,
+			header: sharedHeader,
+			body:   sharedBody.Bytes(),
+		})
+	}
+}
+
+// newUpstreamRequest creates the request forwarded to the endpoint, with the path, query and
+// headers of the client request, except the sensitive ones
+func (l *loadBalancer) newUpstreamRequest(ctx context.Context, c *gin.Context, endpoint url.URL) (*http.Request, error) {
+	// Build the target URL using only path and raw query
+	targetUrl := url.URL{
+		Scheme:   endpoint.Scheme,
+		Host:     endpoint.Host,
+		Path:     c.Request.URL.Path,
+		RawQuery: c.Request.URL.RawQuery,
+	}
+	request, err := http.NewRequestWithContext(ctx, c.Request.Method, targetUrl.String(), c.Request.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	request.ContentLength = c.Request.ContentLength
+
+	// Filter headers to avoid leaking sensitive data
+	for k, v := range c.Request.Header {
+		// Skip Host, X-Forwarded-For, Authorization, Cookie, etc.
+		if strings.EqualFold(k, "Host") || strings.HasPrefix(strings.ToLower(k), "x-forwarded-") || strings.EqualFold(k, "Authorization") || strings.EqualFold(k, "Cookie") {
+			continue
+		}
+		for _, vv := range v {
+			request.Header.Add(k, vv)
+		}
+	}
+
+	if protocol := upgradeProtocol(request.Header); protocol != "" && !l.upgrades(protocol) {
+		removeUpgrade(request.Header)
+	}
+	request.Header.Set("X-Forwarded-For", c.ClientIP())
+	l.setDeadline(request)
+	if l.decompresses() {
+		// Let the transport negotiate compression and decompress the responses to transform or compare
+		request.Header.Del("Accept-Encoding")
+	}
+	return request, nil
+}
+
+// decompresses tells whether the responses are read decompressed, instead of forwarding the
+// Accept-Encoding of the clients
+func (l *loadBalancer) decompresses() bool {
+	return l.transformer != nil || l.xml != nil || l.esi != nil || l.differ != nil
+}
+
+// writeStored writes a cached or shared response, with a header telling where it comes from
+func (l *loadBalancer) writeStored(c *gin.Context, status int, header http.Header, body []byte, sourceHeader, source string) {
+	for k, v := range header {
+		c.Writer.Header()[k] = slices.Clone(v)
+	}
+	c.Header(sourceHeader, source)
+	if l.esi != nil && l.esi.applies(header) {
+		c.Writer.Header().Del("Content-Length")
+		processed, err := l.esi.process(c, body)
+		if err != nil {
+			_ = c.AbortWithError(http.StatusBadGateway, err)
+			return
+		}
+		body = processed
 	}
+	c.Status(status)
+	_, _ = c.Writer.Write(body)
 }
@@ -0,0 +1,258 @@
+package controller
+
+import (
+	"math"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	defaultBreakerFailureRate    = 0.5
+	defaultBreakerMinRequests    = 10
+	defaultBreakerWindow         = 10 * time.Second
+	defaultBreakerCoolDown       = 30 * time.Second
+	defaultBreakerHalfOpenProbes = 3
+	// breakerBuckets is the number of buckets of the sliding window of the breakers
+	breakerBuckets = 10
+)
+
+// CircuitBreakerConfig ejects the endpoints of a load balancer failing repeatedly for a cool-down
+// period. A circuit breaker per endpoint opens when the rate of failed requests in the window
+// reaches the threshold, then lets a few probe requests through after the cool-down: it closes
+// if they all succeed, and opens again otherwise. A request fails when the endpoint cannot be
+// reached, does not answer within the timeout, or answers with one of the failure statuses.
+type CircuitBreakerConfig struct {
+	// FailureRate is the rate of failed requests opening the breaker, between 0 and 1. Defaults to
+	// 0.5.
+	FailureRate float64 `yaml:"failure_rate,omitempty"`
+	// MinRequests is the number of requests in the window below which the breaker does not open.
+	// Defaults to 10.
+	MinRequests int `yaml:"min_requests,omitempty"`
+	// Window is the duration of the sliding window in which the failures are counted. Defaults to
+	// 10s.
+	Window time.Duration `yaml:"window,omitempty"`
+	// CoolDown is the time an open breaker rejects the requests before letting probes through.
+	// Defaults to 30s.
+	CoolDown time.Duration `yaml:"cool_down,omitempty"`
+	// HalfOpenProbes is the number of probe requests let through after the cool-down, all of which
+	// must succeed to close the breaker. Defaults to 3.
+	HalfOpenProbes int `yaml:"half_open_probes,omitempty"`
+	// Statuses are the response statuses counted as failures. Defaults to 502, 503 and 504.
+	Statuses []int `yaml:"statuses,omitempty"`
+}
+
+func (c CircuitBreakerConfig) Validate() error {
+	if c.FailureRate < 0 || c.FailureRate > 1 {
+		return errors.New("failure_rate must be between 0 and 1")
+	}
+	if c.MinRequests < 0 {
+		return errors.New("min_requests must not be negative")
+	}
+	if c.Window < 0 {
+		return errors.New("window must not be negative")
+	}
+	if c.CoolDown < 0 {
+		return errors.New("cool_down must not be negative")
+	}
+	if c.HalfOpenProbes < 0 {
+		return errors.New("half_open_probes must not be negative")
+	}
+	for _, status := range c.Statuses {
+		if status < 400 || status > 599 {
+			return errors.Errorf("invalid failure status %d, must be between 400 and 599", status)
+		}
+	}
+	return nil
+}
+
+func (c CircuitBreakerConfig) withDefaults() CircuitBreakerConfig {
+	if c.FailureRate == 0 {
+		c.FailureRate = defaultBreakerFailureRate
+	}
+	if c.MinRequests == 0 {
+		c.MinRequests = defaultBreakerMinRequests
+	}
+	if c.Window == 0 {
+		c.Window = defaultBreakerWindow
+	}
+	if c.CoolDown == 0 {
+		c.CoolDown = defaultBreakerCoolDown
+	}
+	if c.HalfOpenProbes == 0 {
+		c.HalfOpenProbes = defaultBreakerHalfOpenProbes
+	}
+	if len(c.Statuses) == 0 {
+		c.Statuses = defaultRetryStatuses
+	}
+	return c
+}
+
+// States of the circuit breakers, also the values of the sargantana_backend_circuit_state gauge
+const (
+	circuitClosed = iota
+	circuitHalfOpen
+	circuitOpen
+)
+
+var circuitStateNames = []string{"closed", "half_open", "open"}
+
+// Outcomes of the requests let through by a circuit breaker
+const (
+	outcomeSuccess = iota
+	outcomeFailure
+	// outcomeIgnored is the outcome of the requests cancelled by their client or never sent
+	outcomeIgnored
+)
+
+// breakerBucket counts the requests of a slice of the sliding window
+type breakerBucket struct {
+	slot     int64 // Index of the slice since the epoch
+	requests int
+	failures int
+}
+
+// circuitBreaker is the circuit breaker of an endpoint
+type circuitBreaker struct {
+	config     CircuitBreakerConfig
+	controller string
+	endpoint   string
+	mu         sync.Mutex
+	state      int
+	openedAt   time.Time
+	buckets    [breakerBuckets]breakerBucket
+	probes     int // Probes let through while half-open
+	successes  int // Successful probes while half-open
+}
+
+// newCircuitBreakers creates the circuit breakers of the endpoints, closed
+func newCircuitBreakers(cfg CircuitBreakerConfig, controller string, endpoints []url.URL) []*circuitBreaker {
+	cfg = cfg.withDefaults()
+	breakers := make([]*circuitBreaker, len(endpoints))
+	for i, endpoint := range endpoints {
+		breakers[i] = &circuitBreaker{config: cfg, controller: controller, endpoint: endpoint.Redacted()}
+		backendCircuitState.WithLabelValues(controller, breakers[i].endpoint).Set(circuitClosed)
+	}
+	return breakers
+}
+
+// allow tells whether a request may be sent to the endpoint, counting it as a probe while
+// half-open. The outcome of the allowed requests must be recorded.
+func (b *circuitBreaker) allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state == circuitOpen {
+		if time.Since(b.openedAt) < b.config.CoolDown {
+			return false
+		}
+		b.transition(circuitHalfOpen, nil)
+	}
+	if b.state == circuitHalfOpen {
+		if b.probes >= b.config.HalfOpenProbes {
+			return false
+		}
+		b.probes++
+	}
+	return true
+}
+
+// record counts the outcome of a request allowed by the breaker
+func (b *circuitBreaker) record(outcome int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case circuitHalfOpen:
+		switch outcome {
+		case outcomeFailure:
+			b.transition(circuitOpen, errors.New("probe request failed"))
+		case outcomeSuccess:
+			if b.successes++; b.successes >= b.config.HalfOpenProbes {
+				b.transition(circuitClosed, nil)
+			}
+		default:
+			b.probes--
+		}
+	case circuitClosed:
+		if outcome == outcomeIgnored {
+			return
+		}
+		width := b.config.Window.Nanoseconds() / breakerBuckets
+		slot := time.Now().UnixNano() / max(width, 1)
+		bucket := &b.buckets[slot%breakerBuckets]
+		if bucket.slot != slot {
+			*bucket = breakerBucket{slot: slot}
+		}
+		bucket.requests++
+		if outcome == outcomeFailure {
+			bucket.failures++
+		}
+		var requests, failures int
+		for _, bucket := range b.buckets {
+			if bucket.slot > slot-breakerBuckets {
+				requests += bucket.requests
+				failures += bucket.failures
+			}
+		}
+		if requests >= b.config.MinRequests && float64(failures) >= b.config.FailureRate*float64(requests) {
+			b.transition(circuitOpen, errors.Errorf("%d of the last %d requests failed", failures, requests))
+		}
+	}
+	// The outcomes of the requests allowed before the breaker opened are not counted
+}
+
+// transition changes the state of the breaker, resetting its counters
+func (b *circuitBreaker) transition(state int, reason error) {
+	b.state = state
+	b.probes, b.successes = 0, 0
+	switch state {
+	case circuitOpen:
+		b.openedAt = time.Now()
+		log.Warn().Err(reason).Str("controller", b.controller).Str("endpoint", b.endpoint).
+			Dur("cool_down", b.config.CoolDown).Msg("Load balancer endpoint circuit breaker opened")
+	case circuitClosed:
+		b.buckets = [breakerBuckets]breakerBucket{}
+		log.Info().Str("controller", b.controller).Str("endpoint", b.endpoint).Msg("Load balancer endpoint circuit breaker closed")
+	}
+	backendCircuitState.WithLabelValues(b.controller, b.endpoint).Set(float64(state))
+	backendCircuitTransitions.WithLabelValues(b.controller, b.endpoint, circuitStateNames[state]).Inc()
+}
+
+// outcome classifies the result of a request sent to the endpoint
+func (b *circuitBreaker) outcome(response *http.Response, err error, clientClosed bool) int {
+	switch {
+	case clientClosed:
+		return outcomeIgnored
+	case err != nil:
+		return outcomeFailure
+	case slices.Contains(b.config.Statuses, response.StatusCode):
+		return outcomeFailure
+	default:
+		return outcomeSuccess
+	}
+}
+
+// available tells whether a request may be sent to the endpoint, see circuitBreaker.allow
+func (l *loadBalancer) available(index int) bool {
+	return l.breakers == nil || l.breakers[index].allow()
+}
+
+// recordOutcome counts the outcome of a request in the circuit breaker of the endpoint
+func (l *loadBalancer) recordOutcome(index int, outcome int) {
+	if l.breakers != nil {
+		l.breakers[index].record(outcome)
+	}
+}
+
+// rejectOpenCircuits answers the requests whose endpoints all have their circuit breakers open
+func (l *loadBalancer) rejectOpenCircuits(c *gin.Context) {
+	log.Warn().Str("path", c.Request.URL.Path).Msg("Load balancing request rejected, all the circuit breakers are open")
+	c.Header("Retry-After", strconv.Itoa(int(math.Ceil(l.breakers[0].config.CoolDown.Seconds()))))
+	c.AbortWithStatusJSON(http.StatusServiceUnavailable, gin.H{"error": "no endpoint available"})
+}
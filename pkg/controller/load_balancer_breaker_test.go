@@ -0,0 +1,194 @@
+//go:build unit
+
+package controller
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"time"
+
+	"github.com/animalet/sargantana-go/pkg/server"
+	"github.com/gin-gonic/gin"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+var _ = Describe("Load balancer circuit breakers", func() {
+	Context("Configuration", func() {
+		It("should validate the circuit breaker configuration", func() {
+			Expect(CircuitBreakerConfig{}.Validate()).To(Succeed())
+			Expect(CircuitBreakerConfig{FailureRate: 1.5}.Validate()).To(MatchError(ContainSubstring("failure_rate")))
+			Expect(CircuitBreakerConfig{MinRequests: -1}.Validate()).To(MatchError(ContainSubstring("min_requests")))
+			Expect(CircuitBreakerConfig{Window: -1}.Validate()).To(MatchError(ContainSubstring("window")))
+			Expect(CircuitBreakerConfig{CoolDown: -1}.Validate()).To(MatchError(ContainSubstring("cool_down")))
+			Expect(CircuitBreakerConfig{HalfOpenProbes: -1}.Validate()).To(MatchError(ContainSubstring("half_open_probes")))
+			Expect(CircuitBreakerConfig{Statuses: []int{200}}.Validate()).To(MatchError(ContainSubstring("invalid failure status 200")))
+			Expect(LoadBalancerControllerConfig{Path: "/api", Endpoints: []string{"http://localhost"}, CircuitBreaker: &CircuitBreakerConfig{MinRequests: -1}}.Validate()).
+				To(MatchError(ContainSubstring("invalid circuit_breaker configuration")))
+		})
+	})
+
+	It("should open on the failure rate, probe after the cool-down and close on successful probes", func() {
+		endpoint, err := url.Parse("http://backend:8080")
+		Expect(err).NotTo(HaveOccurred())
+		breaker := newCircuitBreakers(CircuitBreakerConfig{
+			FailureRate:    0.5,
+			MinRequests:    4,
+			CoolDown:       50 * time.Millisecond,
+			HalfOpenProbes: 2,
+		}, "breaker-unit", []url.URL{*endpoint})[0]
+		state := backendCircuitState.WithLabelValues("breaker-unit", "http://backend:8080")
+
+		By("staying closed below the minimum requests and the failure rate")
+		for _, outcome := range []int{outcomeFailure, outcomeSuccess, outcomeIgnored, outcomeSuccess, outcomeSuccess} {
+			Expect(breaker.allow()).To(BeTrue())
+			breaker.record(outcome)
+		}
+		Expect(testutil.ToFloat64(state)).To(BeEquivalentTo(circuitClosed))
+
+		By("opening once half of the requests failed")
+		for range 2 {
+			Expect(breaker.allow()).To(BeTrue())
+			breaker.record(outcomeFailure)
+		}
+		Expect(testutil.ToFloat64(state)).To(BeEquivalentTo(circuitOpen))
+		Expect(breaker.allow()).To(BeFalse())
+
+		By("letting the probes through after the cool-down")
+		Eventually(breaker.allow).Should(BeTrue())
+		Expect(testutil.ToFloat64(state)).To(BeEquivalentTo(circuitHalfOpen))
+		Expect(breaker.allow()).To(BeTrue())
+		Expect(breaker.allow()).To(BeFalse())
+
+		By("opening again when a probe fails")
+		breaker.record(outcomeSuccess)
+		breaker.record(outcomeFailure)
+		Expect(testutil.ToFloat64(state)).To(BeEquivalentTo(circuitOpen))
+
+		By("closing when all the probes succeed")
+		Eventually(breaker.allow).Should(BeTrue())
+		breaker.record(outcomeIgnored)
+		Expect(breaker.allow()).To(BeTrue())
+		Expect(breaker.allow()).To(BeTrue())
+		breaker.record(outcomeSuccess)
+		breaker.record(outcomeSuccess)
+		Expect(testutil.ToFloat64(state)).To(BeEquivalentTo(circuitClosed))
+		Expect(testutil.ToFloat64(backendCircuitTransitions.WithLabelValues("breaker-unit", "http://backend:8080", "open"))).To(Equal(2.0))
+	})
+
+	It("should eject the failing endpoints until their breaker closes", func() {
+		var failing atomic.Bool
+		failing.Store(true)
+		var flakyHits atomic.Int32
+		flaky := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			flakyHits.Add(1)
+			if failing.Load() {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			_, _ = w.Write([]byte("flaky"))
+		}))
+		DeferCleanup(flaky.Close)
+		stable := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			_, _ = w.Write([]byte("stable"))
+		}))
+		DeferCleanup(stable.Close)
+
+		lbConfig := LoadBalancerControllerConfig{
+			Path:      "/api",
+			Endpoints: []string{flaky.URL, stable.URL},
+			CircuitBreaker: &CircuitBreakerConfig{
+				MinRequests:    2,
+				CoolDown:       100 * time.Millisecond,
+				HalfOpenProbes: 1,
+			},
+		}
+		Expect(lbConfig.Validate()).To(Succeed())
+		ctrl, err := NewLoadBalancerController(&lbConfig, server.ControllerContext{Name: "breaker-api"})
+		Expect(err).NotTo(HaveOccurred())
+		DeferCleanup(ctrl.Close)
+		engine := gin.New()
+		Expect(ctrl.Bind(engine, nil)).To(Succeed())
+		get := func() string {
+			recorder := httptest.NewRecorder()
+			engine.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/api/orders", nil))
+			return recorder.Body.String()
+		}
+
+		for range 4 {
+			get()
+		}
+		Expect(flakyHits.Load()).To(Equal(int32(2)))
+		Expect(testutil.ToFloat64(backendCircuitState.WithLabelValues("breaker-api", flaky.URL))).To(BeEquivalentTo(circuitOpen))
+
+		By("sending every request to the other endpoints while open")
+		for range 6 {
+			Expect(get()).To(Equal("stable"))
+		}
+		Expect(flakyHits.Load()).To(Equal(int32(2)))
+
+		By("closing after a successful probe")
+		failing.Store(false)
+		time.Sleep(150 * time.Millisecond)
+		Eventually(get).Should(Equal("flaky"))
+		Expect(testutil.ToFloat64(backendCircuitState.WithLabelValues("breaker-api", flaky.URL))).To(BeEquivalentTo(circuitClosed))
+	})
+
+	It("should answer 503 when the breakers of all the endpoints are open", func() {
+		unavailable := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			w.WriteHeader(http.StatusBadGateway)
+		}))
+		DeferCleanup(unavailable.Close)
+		lbConfig := LoadBalancerControllerConfig{
+			Path:           "/api",
+			Endpoints:      []string{unavailable.URL},
+			CircuitBreaker: &CircuitBreakerConfig{MinRequests: 1, CoolDown: time.Minute},
+		}
+		ctrl, err := NewLoadBalancerController(&lbConfig, server.ControllerContext{})
+		Expect(err).NotTo(HaveOccurred())
+		DeferCleanup(ctrl.Close)
+		engine := gin.New()
+		Expect(ctrl.Bind(engine, nil)).To(Succeed())
+
+		recorder := httptest.NewRecorder()
+		engine.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/api/orders", nil))
+		Expect(recorder.Code).To(Equal(http.StatusBadGateway))
+		recorder = httptest.NewRecorder()
+		engine.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/api/orders", nil))
+		Expect(recorder.Code).To(Equal(http.StatusServiceUnavailable))
+		Expect(recorder.Header().Get("Retry-After")).To(Equal("60"))
+	})
+
+	It("should retry the requests on the endpoints whose breaker is closed", func() {
+		var hits atomic.Int32
+		unavailable := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			hits.Add(1)
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}))
+		DeferCleanup(unavailable.Close)
+		stable := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			_, _ = w.Write([]byte("stable"))
+		}))
+		DeferCleanup(stable.Close)
+		lbConfig := LoadBalancerControllerConfig{
+			Path:           "/api",
+			Endpoints:      []string{unavailable.URL, stable.URL},
+			Retry:          &RetryConfig{Backoff: time.Millisecond},
+			CircuitBreaker: &CircuitBreakerConfig{MinRequests: 1, CoolDown: time.Minute},
+		}
+		ctrl, err := NewLoadBalancerController(&lbConfig, server.ControllerContext{})
+		Expect(err).NotTo(HaveOccurred())
+		DeferCleanup(ctrl.Close)
+		engine := gin.New()
+		Expect(ctrl.Bind(engine, nil)).To(Succeed())
+		for range 4 {
+			recorder := httptest.NewRecorder()
+			engine.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/api/orders", nil))
+			Expect(recorder.Body.String()).To(Equal("stable"))
+		}
+		Expect(hits.Load()).To(Equal(int32(1)))
+	})
+})
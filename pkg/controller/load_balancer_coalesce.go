@@ -0,0 +1,162 @@
+package controller
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+)
+
+const (
+	defaultCoalesceMaxBodySize = 1024 * 1024
+	defaultCoalesceTimeout     = 10 * time.Second
+
+	// CoalescedHeader is set to "true" on the responses shared with the requests that waited for an
+	// identical request in flight.
+	CoalescedHeader = "X-Coalesced"
+)
+
+// defaultCoalesceKeyHeaders are the request headers upstream responses usually vary on
+var defaultCoalesceKeyHeaders = []string{"Accept", "Accept-Encoding"}
+
+// CoalesceConfig enables coalescing of identical concurrent GET requests: while a request is in
+// flight to an endpoint, the identical requests wait for its response instead of being forwarded.
+// Requests are identical when they have the same path, query and key headers.
+type CoalesceConfig struct {
+	// KeyHeaders are the request headers whose values tell requests apart, e.g. Accept-Language for
+	// upstreams varying on it. Defaults to Accept and Accept-Encoding.
+	KeyHeaders []string `yaml:"key_headers,omitempty"`
+	// MaxBodySize is the size in bytes of the largest shared response body; the requests waiting
+	// for larger responses are forwarded. Defaults to 1 MiB.
+	MaxBodySize int64 `yaml:"max_body_size,omitempty"`
+	// Timeout bounds the wait for the response of the request in flight, after which the waiting
+	// requests are forwarded. Defaults to 10s.
+	Timeout time.Duration `yaml:"timeout,omitempty"`
+}
+
+func (c CoalesceConfig) Validate() error {
+	if c.MaxBodySize < 0 || c.Timeout < 0 {
+		return errors.New("max_body_size and timeout must not be negative")
+	}
+	for _, header := range c.KeyHeaders {
+		if header == "" {
+			return errors.New("key_headers must not contain empty names")
+		}
+	}
+	return nil
+}
+
+// coalescer tracks the GET requests in flight by key
+type coalescer struct {
+	config  CoalesceConfig
+	mu      sync.Mutex
+	flights map[string]*flight
+}
+
+// flight is a request in flight, whose response is shared with the identical requests
+type flight struct {
+	done     chan struct{}
+	once     sync.Once
+	response *coalescedResponse // Nil if the response cannot be shared
+}
+
+type coalescedResponse struct {
+	status int
+	header http.Header
+	body   []byte
+}
+
+func newCoalescer(c CoalesceConfig) *coalescer {
+	if len(c.KeyHeaders) == 0 {
+		c.KeyHeaders = defaultCoalesceKeyHeaders
+	}
+	if c.MaxBodySize == 0 {
+		c.MaxBodySize = defaultCoalesceMaxBodySize
+	}
+	if c.Timeout == 0 {
+		c.Timeout = defaultCoalesceTimeout
+	}
+	return &coalescer{config: c, flights: make(map[string]*flight)}
+}
+
+// coalescable tells whether the request may wait for an identical request in flight. Requests
+// carrying a user are not, as their responses may be personalised, see personal.
+func (co *coalescer) coalescable(c *gin.Context) bool {
+	return c.Request.Method == http.MethodGet && !personal(c)
+}
+
+// key identifies the requests identical to the one with the given cache key
+func (co *coalescer) key(c *gin.Context, cacheKey string) string {
+	var key strings.Builder
+	key.WriteString(cacheKey)
+	for _, header := range co.config.KeyHeaders {
+		key.WriteString("\x00")
+		key.WriteString(strings.Join(c.Request.Header.Values(header), ","))
+	}
+	return key.String()
+}
+
+// join returns the flight of the key, and whether the caller leads it, forwarding the request
+// and finishing the flight
+func (co *coalescer) join(key string) (*flight, bool) {
+	co.mu.Lock()
+	defer co.mu.Unlock()
+	if f, ok := co.flights[key]; ok {
+		return f, false
+	}
+	f := &flight{done: make(chan struct{})}
+	co.flights[key] = f
+	return f, true
+}
+
+// finish releases the requests waiting for the flight with the response, nil if it cannot be
+// shared. Only the first call has an effect.
+func (co *coalescer) finish(key string, f *flight, response *coalescedResponse) {
+	f.once.Do(func() {
+		co.mu.Lock()
+		if co.flights[key] == f {
+			delete(co.flights, key)
+		}
+		co.mu.Unlock()
+		f.response = response
+		close(f.done)
+	})
+}
+
+// wait waits for the response of the flight, returning nil if it is not shared in time
+func (f *flight) wait(ctx context.Context, timeout time.Duration) *coalescedResponse {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-f.done:
+		return f.response
+	case <-timer.C:
+	case <-ctx.Done():
+	}
+	return nil
+}
+
+// coalesceBuffer keeps a copy of the response body read by the leader of a flight, up to the
+// maximum size
+type coalesceBuffer struct {
+	bytes.Buffer
+	max      int64
+	overflow bool
+}
+
+func (b *coalesceBuffer) Write(p []byte) (int, error) {
+	if b.overflow {
+		return len(p), nil
+	}
+	if int64(b.Len()+len(p)) > b.max {
+		b.overflow = true
+		b.Reset()
+		return len(p), nil
+	}
+	return b.Buffer.Write(p)
+}
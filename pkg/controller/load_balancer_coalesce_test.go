@@ -0,0 +1,205 @@
+//go:build unit
+
+package controller
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"time"
+
+	"github.com/animalet/sargantana-go/pkg/server"
+	"github.com/gin-gonic/gin"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Load balancer request coalescing", func() {
+	var (
+		engine  *gin.Engine
+		release chan struct{}
+		started chan struct{}
+		calls   atomic.Int32
+		body    string
+	)
+
+	setup := func(cfg *CoalesceConfig) {
+		calls.Store(0)
+		body = "response"
+		release = make(chan struct{})
+		started = make(chan struct{}, 10)
+		backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			call := calls.Add(1)
+			started <- struct{}{}
+			<-release
+			w.Header().Set("X-Upstream-Call", fmt.Sprint(call))
+			w.Header().Set("Content-Type", "text/plain")
+			_, _ = w.Write([]byte(body))
+		}))
+		DeferCleanup(backend.Close)
+		DeferCleanup(func() {
+			select {
+			case <-release:
+			default:
+				close(release)
+			}
+		})
+
+		ctrl, err := NewLoadBalancerController(&LoadBalancerControllerConfig{
+			Path:      "/api",
+			Endpoints: []string{backend.URL},
+			Coalesce:  cfg,
+		}, server.ControllerContext{})
+		Expect(err).NotTo(HaveOccurred())
+		engine = gin.New()
+		Expect(ctrl.Bind(engine, nil)).To(Succeed())
+	}
+
+	serve := func(method, path string, headers ...string) chan *httptest.ResponseRecorder {
+		done := make(chan *httptest.ResponseRecorder, 1)
+		go func() {
+			defer GinkgoRecover()
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest(method, path, nil)
+			for i := 0; i < len(headers); i += 2 {
+				req.Header.Set(headers[i], headers[i+1])
+			}
+			engine.ServeHTTP(w, req)
+			done <- w
+		}()
+		return done
+	}
+
+	// settle lets the requests sent in the background join the flight in progress
+	settle := func() {
+		Consistently(started, 200*time.Millisecond).ShouldNot(Receive())
+	}
+
+	It("should validate the configuration", func() {
+		Expect(CoalesceConfig{}.Validate()).To(Succeed())
+		Expect(CoalesceConfig{Timeout: -time.Second}.Validate()).To(MatchError(ContainSubstring("timeout")))
+		Expect(CoalesceConfig{KeyHeaders: []string{""}}.Validate()).To(MatchError(ContainSubstring("key_headers")))
+		Expect(LoadBalancerControllerConfig{
+			Path:      "/api",
+			Endpoints: []string{"http://localhost:8080"},
+			Coalesce:  &CoalesceConfig{MaxBodySize: -1},
+		}.Validate()).To(MatchError(ContainSubstring("invalid coalesce configuration")))
+	})
+
+	It("should share the response of the request in flight with identical requests", func(ctx SpecContext) {
+		setup(&CoalesceConfig{})
+		leader := serve(http.MethodGet, "/api/items?page=1")
+		Eventually(started).Should(Receive())
+		followers := []chan *httptest.ResponseRecorder{
+			serve(http.MethodGet, "/api/items?page=1"),
+			serve(http.MethodGet, "/api/items?page=1"),
+		}
+		settle()
+		close(release)
+
+		var w *httptest.ResponseRecorder
+		Eventually(leader).Should(Receive(&w))
+		Expect(w.Body.String()).To(Equal("response"))
+		Expect(w.Header().Get(CoalescedHeader)).To(BeEmpty())
+		for _, follower := range followers {
+			Eventually(follower).Should(Receive(&w))
+			Expect(w.Code).To(Equal(http.StatusOK))
+			Expect(w.Body.String()).To(Equal("response"))
+			Expect(w.Header().Get("X-Upstream-Call")).To(Equal("1"))
+			Expect(w.Header().Get(CoalescedHeader)).To(Equal("true"))
+		}
+		Expect(calls.Load()).To(Equal(int32(1)))
+
+		// The flight is over, the next request is forwarded
+		Eventually(serve(http.MethodGet, "/api/items?page=1")).Should(Receive(&w))
+		Expect(w.Header().Get("X-Upstream-Call")).To(Equal("2"))
+	}, SpecTimeout(5*time.Second))
+
+	It("should forward requests with different queries or key headers", func(ctx SpecContext) {
+		setup(&CoalesceConfig{KeyHeaders: []string{"Accept-Language"}})
+		first := serve(http.MethodGet, "/api/items", "Accept-Language", "en")
+		Eventually(started).Should(Receive())
+		second := serve(http.MethodGet, "/api/items", "Accept-Language", "es")
+		Eventually(started).Should(Receive())
+		third := serve(http.MethodGet, "/api/items?page=2", "Accept-Language", "en")
+		Eventually(started).Should(Receive())
+		close(release)
+
+		for _, done := range []chan *httptest.ResponseRecorder{first, second, third} {
+			var w *httptest.ResponseRecorder
+			Eventually(done).Should(Receive(&w))
+			Expect(w.Header().Get(CoalescedHeader)).To(BeEmpty())
+		}
+		Expect(calls.Load()).To(Equal(int32(3)))
+	}, SpecTimeout(5*time.Second))
+
+	It("should not share the response of one user with another", func(ctx SpecContext) {
+		setup(&CoalesceConfig{})
+		first := serve(http.MethodGet, "/api/me", "Authorization", "Bearer alice")
+		Eventually(started).Should(Receive())
+		second := serve(http.MethodGet, "/api/me", "Authorization", "Bearer bob")
+		Eventually(started).Should(Receive())
+		close(release)
+
+		for _, done := range []chan *httptest.ResponseRecorder{first, second} {
+			var w *httptest.ResponseRecorder
+			Eventually(done).Should(Receive(&w))
+			Expect(w.Header().Get(CoalescedHeader)).To(BeEmpty())
+		}
+		Expect(calls.Load()).To(Equal(int32(2)))
+	}, SpecTimeout(5*time.Second))
+
+	It("should not coalesce other methods", func(ctx SpecContext) {
+		setup(&CoalesceConfig{})
+		first := serve(http.MethodPost, "/api/items")
+		Eventually(started).Should(Receive())
+		second := serve(http.MethodPost, "/api/items")
+		Eventually(started).Should(Receive())
+		close(release)
+		Eventually(first).Should(Receive())
+		Eventually(second).Should(Receive())
+	}, SpecTimeout(5*time.Second))
+
+	It("should forward the waiting requests when the response is too large", func(ctx SpecContext) {
+		setup(&CoalesceConfig{MaxBodySize: 4})
+		leader := serve(http.MethodGet, "/api/items")
+		Eventually(started).Should(Receive())
+		follower := serve(http.MethodGet, "/api/items")
+		settle()
+		close(release)
+
+		Eventually(leader).Should(Receive())
+		Eventually(started).Should(Receive())
+		var w *httptest.ResponseRecorder
+		Eventually(follower).Should(Receive(&w))
+		Expect(w.Header().Get("X-Upstream-Call")).To(Equal("2"))
+		Expect(w.Header().Get(CoalescedHeader)).To(BeEmpty())
+	}, SpecTimeout(5*time.Second))
+
+	It("should forward the waiting requests after the timeout", func(ctx SpecContext) {
+		setup(&CoalesceConfig{Timeout: 50 * time.Millisecond})
+		leader := serve(http.MethodGet, "/api/items")
+		Eventually(started).Should(Receive())
+		follower := serve(http.MethodGet, "/api/items")
+		Eventually(started).Should(Receive())
+		close(release)
+
+		Eventually(leader).Should(Receive())
+		var w *httptest.ResponseRecorder
+		Eventually(follower).Should(Receive(&w))
+		Expect(w.Header().Get(CoalescedHeader)).To(BeEmpty())
+		Expect(calls.Load()).To(Equal(int32(2)))
+	}, SpecTimeout(5*time.Second))
+
+	It("should not coalesce when disabled", func(ctx SpecContext) {
+		setup(nil)
+		first := serve(http.MethodGet, "/api/items")
+		Eventually(started).Should(Receive())
+		second := serve(http.MethodGet, "/api/items")
+		Eventually(started).Should(Receive())
+		close(release)
+		Eventually(first).Should(Receive())
+		Eventually(second).Should(Receive())
+	}, SpecTimeout(5*time.Second))
+})
@@ -0,0 +1,184 @@
+package controller
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/animalet/sargantana-go/pkg/server"
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	defaultQueueTimeout = 5 * time.Second
+	defaultRetryAfter   = time.Second
+)
+
+var (
+	errQueueFull    = errors.New("backend request queue is full")
+	errQueueTimeout = errors.New("timed out waiting for a backend slot")
+)
+
+// ConcurrencyConfig caps the requests in flight to each endpoint of a load balancer. Requests over
+// the cap wait in a bounded queue for a slot, by priority (see server.RequestPriority), so that a
+// slow upstream is answered with errors instead of piling up requests.
+type ConcurrencyConfig struct {
+	// MaxInFlight is the maximum number of requests sent to each endpoint at the same time.
+	MaxInFlight int `yaml:"max_in_flight"`
+	// MaxQueue is the maximum number of requests waiting for a slot of each endpoint. Requests
+	// arriving when the queue is full are answered with 429, unless they take the place of a
+	// waiting request of lower priority. Defaults to 0, no queueing.
+	MaxQueue int `yaml:"max_queue,omitempty"`
+	// QueueTimeout is how long a request waits for a slot before being answered with 503.
+	// Defaults to 5s.
+	QueueTimeout time.Duration `yaml:"queue_timeout,omitempty"`
+	// RetryAfter is sent in the Retry-After header of the rejected requests, rounded up to
+	// seconds. Defaults to 1s.
+	RetryAfter time.Duration `yaml:"retry_after,omitempty"`
+}
+
+func (c ConcurrencyConfig) Validate() error {
+	if c.MaxInFlight <= 0 {
+		return errors.New("max_in_flight must be positive")
+	}
+	if c.MaxQueue < 0 {
+		return errors.New("max_queue must not be negative")
+	}
+	if c.QueueTimeout < 0 {
+		return errors.New("queue_timeout must not be negative")
+	}
+	if c.RetryAfter < 0 {
+		return errors.New("retry_after must not be negative")
+	}
+	return nil
+}
+
+// backendLimiter holds the in-flight slots and the wait queue of an endpoint. Waiting requests
+// get the released slots by priority, then in arrival order.
+type backendLimiter struct {
+	mu          sync.Mutex
+	inFlight    int
+	maxInFlight int
+	waiters     []*waiter // By descending priority rank, then arrival
+	maxQueue    int
+	timeout     time.Duration
+}
+
+// waiter is a request waiting for a slot. ready receives nil when it is handed a slot, or
+// errQueueFull when a request of higher priority takes its place in a full queue.
+type waiter struct {
+	rank  int
+	ready chan error
+}
+
+func newBackendLimiters(cfg ConcurrencyConfig, endpoints int) []*backendLimiter {
+	timeout := cfg.QueueTimeout
+	if timeout == 0 {
+		timeout = defaultQueueTimeout
+	}
+	limiters := make([]*backendLimiter, endpoints)
+	for i := range limiters {
+		limiters[i] = &backendLimiter{
+			maxInFlight: cfg.MaxInFlight,
+			maxQueue:    cfg.MaxQueue,
+			timeout:     timeout,
+		}
+	}
+	return limiters
+}
+
+// acquire takes a slot, waiting in the queue when all the slots are taken. When the queue is
+// full, the last waiter of lower priority, if any, is rejected to make room. The slot must be
+// released once the response is sent.
+func (b *backendLimiter) acquire(ctx context.Context, priority server.Priority) error {
+	b.mu.Lock()
+	if b.inFlight < b.maxInFlight {
+		b.inFlight++
+		b.mu.Unlock()
+		return nil
+	}
+	rank := priority.Rank()
+	if len(b.waiters) >= b.maxQueue {
+		last := len(b.waiters) - 1
+		if last < 0 || b.waiters[last].rank >= rank {
+			b.mu.Unlock()
+			return errQueueFull
+		}
+		b.waiters[last].ready <- errQueueFull
+		b.waiters = b.waiters[:last]
+	}
+	w := &waiter{rank: rank, ready: make(chan error, 1)}
+	position := sort.Search(len(b.waiters), func(i int) bool { return b.waiters[i].rank < rank })
+	b.waiters = slices.Insert(b.waiters, position, w)
+	b.mu.Unlock()
+
+	timer := time.NewTimer(b.timeout)
+	defer timer.Stop()
+	select {
+	case err := <-w.ready:
+		return err
+	case <-timer.C:
+		if b.dequeue(w) {
+			return errQueueTimeout
+		}
+		// Handed a slot or rejected meanwhile
+		return <-w.ready
+	case <-ctx.Done():
+		if !b.dequeue(w) && <-w.ready == nil {
+			b.release()
+		}
+		return ctx.Err()
+	}
+}
+
+// dequeue removes a waiter from the queue, telling whether it was still waiting
+func (b *backendLimiter) dequeue(w *waiter) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	i := slices.Index(b.waiters, w)
+	if i < 0 {
+		return false
+	}
+	b.waiters = slices.Delete(b.waiters, i, i+1)
+	return true
+}
+
+// release hands the slot to the first waiter, or frees it
+func (b *backendLimiter) release() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if len(b.waiters) == 0 {
+		b.inFlight--
+		return
+	}
+	next := b.waiters[0]
+	b.waiters = b.waiters[1:]
+	next.ready <- nil
+}
+
+// rejectRequest answers a request that got no slot: 429 when the queue is full, 503 when it
+// waited too long. Requests of gone clients are only aborted.
+func rejectRequest(c *gin.Context, err error, retryAfter time.Duration) {
+	status := http.StatusServiceUnavailable
+	switch {
+	case errors.Is(err, errQueueFull):
+		status = http.StatusTooManyRequests
+	case errors.Is(err, errQueueTimeout):
+	default:
+		c.Abort()
+		return
+	}
+	if retryAfter == 0 {
+		retryAfter = defaultRetryAfter
+	}
+	log.Warn().Err(err).Str("path", c.Request.URL.Path).Int("status", status).Msg("Load balancing request rejected")
+	c.Header("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+	c.AbortWithStatusJSON(status, gin.H{"error": err.Error()})
+}
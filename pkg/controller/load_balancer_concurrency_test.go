@@ -0,0 +1,155 @@
+//go:build unit
+
+package controller
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	"github.com/animalet/sargantana-go/pkg/server"
+	"github.com/gin-gonic/gin"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Load balancer concurrency limits", func() {
+	var (
+		engine  *gin.Engine
+		release chan struct{}
+		started chan struct{}
+	)
+
+	setup := func(cfg ConcurrencyConfig) {
+		release = make(chan struct{})
+		started = make(chan struct{}, 10)
+		backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			started <- struct{}{}
+			<-release
+			w.WriteHeader(http.StatusOK)
+		}))
+		DeferCleanup(backend.Close)
+		DeferCleanup(func() {
+			select {
+			case <-release:
+			default:
+				close(release)
+			}
+		})
+
+		ctrl, err := NewLoadBalancerController(&LoadBalancerControllerConfig{
+			Path:        "/api",
+			Endpoints:   []string{backend.URL},
+			Concurrency: &cfg,
+		}, server.ControllerContext{})
+		Expect(err).NotTo(HaveOccurred())
+		engine = gin.New()
+		Expect(ctrl.Bind(engine, nil)).To(Succeed())
+	}
+
+	serve := func() *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		engine.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/test", nil))
+		return w
+	}
+
+	// occupy sends a request in the background and waits until the backend holds it
+	occupy := func() chan *httptest.ResponseRecorder {
+		done := make(chan *httptest.ResponseRecorder, 1)
+		go func() { done <- serve() }()
+		Eventually(started).Should(Receive())
+		return done
+	}
+
+	It("should validate the configuration", func() {
+		Expect(ConcurrencyConfig{}.Validate()).To(MatchError(ContainSubstring("max_in_flight")))
+		Expect(ConcurrencyConfig{MaxInFlight: 1, MaxQueue: -1}.Validate()).To(MatchError(ContainSubstring("max_queue")))
+		Expect(ConcurrencyConfig{MaxInFlight: 1, QueueTimeout: -time.Second}.Validate()).To(MatchError(ContainSubstring("queue_timeout")))
+		Expect(ConcurrencyConfig{MaxInFlight: 1}.Validate()).To(Succeed())
+	})
+
+	It("should answer 429 when the queue is full", func() {
+		setup(ConcurrencyConfig{MaxInFlight: 1, RetryAfter: 1500 * time.Millisecond})
+		done := occupy()
+
+		w := serve()
+		Expect(w.Code).To(Equal(http.StatusTooManyRequests))
+		Expect(w.Header().Get("Retry-After")).To(Equal("2"))
+
+		close(release)
+		Expect((<-done).Code).To(Equal(http.StatusOK))
+	})
+
+	It("should answer 503 when a queued request times out", func() {
+		setup(ConcurrencyConfig{MaxInFlight: 1, MaxQueue: 1, QueueTimeout: 50 * time.Millisecond})
+		done := occupy()
+
+		w := serve()
+		Expect(w.Code).To(Equal(http.StatusServiceUnavailable))
+		Expect(w.Header().Get("Retry-After")).To(Equal("1"))
+
+		close(release)
+		Expect((<-done).Code).To(Equal(http.StatusOK))
+	})
+
+	It("should forward queued requests once a slot is released", func() {
+		setup(ConcurrencyConfig{MaxInFlight: 1, MaxQueue: 1, QueueTimeout: 5 * time.Second})
+		first := occupy()
+
+		queued := make(chan *httptest.ResponseRecorder, 1)
+		go func() { queued <- serve() }()
+		Consistently(started, 100*time.Millisecond).ShouldNot(Receive())
+
+		close(release)
+		Expect((<-first).Code).To(Equal(http.StatusOK))
+		Eventually(queued).Should(Receive(HaveField("Code", http.StatusOK)))
+	})
+
+	Context("priorities", func() {
+		// wait queues a request of the given priority in the background
+		wait := func(limiter *backendLimiter, priority server.Priority) chan error {
+			result := make(chan error, 1)
+			go func() { result <- limiter.acquire(context.Background(), priority) }()
+			return result
+		}
+
+		queued := func(limiter *backendLimiter) func() int {
+			return func() int {
+				limiter.mu.Lock()
+				defer limiter.mu.Unlock()
+				return len(limiter.waiters)
+			}
+		}
+
+		It("should hand the released slots to the waiters of highest priority first", func() {
+			limiter := newBackendLimiters(ConcurrencyConfig{MaxInFlight: 1, MaxQueue: 2}, 1)[0]
+			Expect(limiter.acquire(context.Background(), server.PriorityNormal)).To(Succeed())
+
+			low := wait(limiter, server.PriorityLow)
+			Eventually(queued(limiter)).Should(Equal(1))
+			high := wait(limiter, server.PriorityHigh)
+			Eventually(queued(limiter)).Should(Equal(2))
+
+			limiter.release()
+			Eventually(high).Should(Receive(BeNil()))
+			Consistently(low, 50*time.Millisecond).ShouldNot(Receive())
+			limiter.release()
+			Eventually(low).Should(Receive(BeNil()))
+		})
+
+		It("should reject the last waiter of lower priority when the queue is full", func() {
+			limiter := newBackendLimiters(ConcurrencyConfig{MaxInFlight: 1, MaxQueue: 1}, 1)[0]
+			Expect(limiter.acquire(context.Background(), server.PriorityNormal)).To(Succeed())
+
+			low := wait(limiter, server.PriorityLow)
+			Eventually(queued(limiter)).Should(Equal(1))
+			Expect(limiter.acquire(context.Background(), server.PriorityLow)).To(MatchError(errQueueFull))
+
+			critical := wait(limiter, server.PriorityCritical)
+			Eventually(low).Should(Receive(MatchError(errQueueFull)))
+			limiter.release()
+			Eventually(critical).Should(Receive(BeNil()))
+		})
+	})
+})
@@ -0,0 +1,169 @@
+package controller
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/animalet/sargantana-go/pkg/logging"
+	"github.com/animalet/sargantana-go/pkg/server"
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+)
+
+const (
+	// CurlLogModule is the logging module of the curl commands of the proxied requests, whose
+	// level can be lowered to debug at runtime to log them without the debug mode.
+	CurlLogModule = "curl"
+
+	defaultCurlMaxBodySize = 4096
+	redactedValue          = "<redacted>"
+)
+
+// curlRedactedHeaders are the forwarded headers whose values are always redacted
+var curlRedactedHeaders = []string{"Proxy-Authorization"}
+
+// CurlLogConfig configures the debug records of the proxied requests as curl commands reproducing
+// them, with the method, the upstream URL, the forwarded headers and the body.
+type CurlLogConfig struct {
+	// MaxBodySize is the maximum number of body bytes included in the commands, longer bodies are
+	// truncated. Defaults to 4096, -1 leaves the bodies out.
+	MaxBodySize int64 `yaml:"max_body_size,omitempty"`
+	// RedactHeaders are the forwarded headers whose values are replaced with <redacted>, in
+	// addition to Proxy-Authorization. The Authorization and Cookie headers are never forwarded.
+	RedactHeaders []string `yaml:"redact_headers,omitempty"`
+	// RedactQuery are the query parameters whose values are replaced with <redacted>, e.g. api_key.
+	RedactQuery []string `yaml:"redact_query,omitempty"`
+}
+
+func (c CurlLogConfig) Validate() error {
+	if c.MaxBodySize < -1 {
+		return errors.New("max_body_size must be -1, 0 or positive")
+	}
+	for _, header := range c.RedactHeaders {
+		if header == "" {
+			return errors.New("redact_headers entries must be non-empty")
+		}
+	}
+	for _, param := range c.RedactQuery {
+		if param == "" {
+			return errors.New("redact_query entries must be non-empty")
+		}
+	}
+	return nil
+}
+
+// curlLogger logs the proxied requests as curl commands
+type curlLogger struct {
+	maxBodySize   int64
+	redactHeaders []string
+	redactQuery   []string
+}
+
+func newCurlLogger(cfg CurlLogConfig) *curlLogger {
+	maxBodySize := cfg.MaxBodySize
+	switch maxBodySize {
+	case 0:
+		maxBodySize = defaultCurlMaxBodySize
+	case -1:
+		maxBodySize = 0
+	}
+	redactHeaders := slices.Clone(curlRedactedHeaders)
+	for _, header := range cfg.RedactHeaders {
+		redactHeaders = append(redactHeaders, http.CanonicalHeaderKey(header))
+	}
+	return &curlLogger{maxBodySize: maxBodySize, redactHeaders: redactHeaders, redactQuery: cfg.RedactQuery}
+}
+
+// log logs the request about to be sent upstream at debug level. The logged part of the body is
+// read ahead and put back in front of the rest, so the request is forwarded unchanged.
+func (l *curlLogger) log(c *gin.Context, request *http.Request) {
+	logger := logging.ForModule(server.RequestLogger(c).With().Str(logging.ModuleField, CurlLogModule).Logger(), CurlLogModule)
+	event := logger.Debug()
+	if !event.Enabled() {
+		return
+	}
+
+	var body []byte
+	truncated := false
+	if l.maxBodySize > 0 && request.Body != nil && request.Body != http.NoBody {
+		read, err := io.ReadAll(io.LimitReader(request.Body, l.maxBodySize+1))
+		request.Body = readCloser{Reader: io.MultiReader(bytes.NewReader(read), request.Body), Closer: request.Body}
+		if err != nil {
+			event.Discard()
+			return
+		}
+		if int64(len(read)) > l.maxBodySize {
+			body, truncated = read[:l.maxBodySize], true
+		} else {
+			body = read
+		}
+	}
+
+	if !utf8.Valid(body) {
+		// Binary bodies cannot be pasted in a shell
+		event.Int("body_omitted", len(body))
+		body = nil
+	}
+	event.
+		Str("curl", l.command(request, body)).
+		Bool("body_truncated", truncated).
+		Msg("Proxied request")
+}
+
+// command builds the curl command of the request, redacting the configured headers and query
+// parameters
+func (l *curlLogger) command(request *http.Request, body []byte) string {
+	target := *request.URL
+	if len(l.redactQuery) > 0 && target.RawQuery != "" {
+		query := target.Query()
+		for _, param := range l.redactQuery {
+			if query.Has(param) {
+				query[param] = []string{redactedValue}
+			}
+		}
+		target.RawQuery = query.Encode()
+	}
+
+	var b strings.Builder
+	b.WriteString("curl")
+	switch request.Method {
+	case http.MethodGet:
+	case http.MethodHead:
+		b.WriteString(" --head")
+	default:
+		b.WriteString(" -X " + request.Method)
+	}
+	b.WriteString(" " + shellQuote(target.String()))
+
+	names := make([]string, 0, len(request.Header))
+	for name := range request.Header {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	for _, name := range names {
+		for _, value := range request.Header[name] {
+			if slices.Contains(l.redactHeaders, name) {
+				value = redactedValue
+			}
+			b.WriteString(" -H " + shellQuote(name+": "+value))
+		}
+	}
+	if len(body) > 0 {
+		b.WriteString(" --data-raw " + shellQuote(string(body)))
+	}
+	return b.String()
+}
+
+// shellQuote quotes s for POSIX shells
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
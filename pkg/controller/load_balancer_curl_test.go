@@ -0,0 +1,133 @@
+//go:build unit
+
+package controller
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	"github.com/animalet/sargantana-go/pkg/logging"
+	"github.com/animalet/sargantana-go/pkg/server"
+	"github.com/gin-gonic/gin"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+var _ = Describe("Load balancer curl commands", func() {
+	var (
+		backend  *httptest.Server
+		received chan string
+		logs     *bytes.Buffer
+	)
+
+	BeforeEach(func() {
+		received = make(chan string, 1)
+		backend = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body, _ := io.ReadAll(r.Body)
+			received <- string(body)
+			w.WriteHeader(http.StatusBadGateway)
+		}))
+		DeferCleanup(backend.Close)
+
+		logs = &bytes.Buffer{}
+		originalLogger := log.Logger
+		log.Logger = zerolog.New(logs)
+		logging.SetDebug(true)
+		DeferCleanup(func() {
+			logging.SetDebug(false)
+			log.Logger = originalLogger
+		})
+	})
+
+	// serve sends a request through a load balancer logging the curl commands
+	serve := func(cfg CurlLogConfig, req *http.Request) {
+		lbConfig := LoadBalancerControllerConfig{Path: "/api", Endpoints: []string{backend.URL}, LogCurl: &cfg}
+		Expect(lbConfig.Validate()).To(Succeed())
+		ctrl, err := NewLoadBalancerController(&lbConfig, server.ControllerContext{})
+		Expect(err).NotTo(HaveOccurred())
+		engine := gin.New()
+		Expect(ctrl.Bind(engine, nil)).To(Succeed())
+		w := httptest.NewRecorder()
+		engine.ServeHTTP(w, req)
+		Expect(w.Code).To(Equal(http.StatusBadGateway))
+	}
+
+	// record returns the logged curl record
+	record := func() map[string]any {
+		for _, line := range strings.Split(strings.TrimSpace(logs.String()), "\n") {
+			var entry map[string]any
+			if json.Unmarshal([]byte(line), &entry) == nil && entry["curl"] != nil {
+				return entry
+			}
+		}
+		return nil
+	}
+
+	It("should validate the configuration", func() {
+		Expect(CurlLogConfig{MaxBodySize: -2}.Validate()).To(MatchError(ContainSubstring("max_body_size")))
+		Expect(CurlLogConfig{RedactHeaders: []string{""}}.Validate()).To(MatchError(ContainSubstring("redact_headers")))
+		Expect(CurlLogConfig{RedactQuery: []string{""}}.Validate()).To(MatchError(ContainSubstring("redact_query")))
+		Expect(CurlLogConfig{MaxBodySize: -1, RedactHeaders: []string{"X-Api-Key"}}.Validate()).To(Succeed())
+
+		cfg := LoadBalancerControllerConfig{
+			Path:      "/php",
+			Endpoints: []string{"tcp://localhost:9000"},
+			FastCGI:   &FastCGIConfig{ScriptRoot: "/srv"},
+			LogCurl:   &CurlLogConfig{},
+		}
+		Expect(cfg.Validate()).To(MatchError(ContainSubstring("log_curl is not supported")))
+	})
+
+	It("should log a sanitized curl command and forward the request unchanged", func() {
+		req := httptest.NewRequest(http.MethodPost, "/api/orders?id=1&api_key=secret", strings.NewReader(`{"item":"it's"}`))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer token")
+		req.Header.Set("X-Api-Key", "secret")
+		serve(CurlLogConfig{RedactHeaders: []string{"x-api-key"}, RedactQuery: []string{"api_key"}}, req)
+		Expect(received).To(Receive(Equal(`{"item":"it's"}`)))
+
+		entry := record()
+		Expect(entry).NotTo(BeNil())
+		Expect(entry).To(HaveKeyWithValue(logging.ModuleField, CurlLogModule))
+		Expect(entry).To(HaveKeyWithValue("body_truncated", false))
+		command := entry["curl"].(string)
+		Expect(command).To(HavePrefix("curl -X POST '" + backend.URL + "/api/orders?api_key=%3Credacted%3E&id=1'"))
+		Expect(command).To(ContainSubstring(` -H 'Content-Type: application/json'`))
+		Expect(command).To(ContainSubstring(` -H 'X-Api-Key: <redacted>'`))
+		Expect(command).To(ContainSubstring(` -H 'X-Forwarded-For: 192.0.2.1'`))
+		Expect(command).To(HaveSuffix(` --data-raw '{"item":"it'\''s"}'`))
+		Expect(command).NotTo(ContainSubstring("secret"))
+		Expect(command).NotTo(ContainSubstring("Bearer"))
+	})
+
+	It("should truncate the logged bodies", func() {
+		req := httptest.NewRequest(http.MethodPut, "/api/files", strings.NewReader("0123456789"))
+		serve(CurlLogConfig{MaxBodySize: 4}, req)
+		Expect(received).To(Receive(Equal("0123456789")))
+		entry := record()
+		Expect(entry).To(HaveKeyWithValue("body_truncated", true))
+		Expect(entry["curl"]).To(HaveSuffix(" --data-raw '0123'"))
+	})
+
+	It("should leave out the binary bodies", func() {
+		req := httptest.NewRequest(http.MethodPost, "/api/upload", bytes.NewReader([]byte{0xff, 0xfe, 0x00}))
+		serve(CurlLogConfig{}, req)
+		Expect(received).To(Receive(Equal(string([]byte{0xff, 0xfe, 0x00}))))
+		entry := record()
+		Expect(entry).To(HaveKeyWithValue("body_omitted", BeNumerically("==", 3)))
+		Expect(entry["curl"]).NotTo(ContainSubstring("--data-raw"))
+	})
+
+	It("should not log outside the debug level", func() {
+		logging.SetDebug(false)
+		serve(CurlLogConfig{}, httptest.NewRequest(http.MethodGet, "/api/orders", nil))
+		Expect(received).To(Receive())
+		Expect(record()).To(BeNil())
+	})
+})
@@ -0,0 +1,116 @@
+package controller
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/animalet/sargantana-go/pkg/server"
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+)
+
+// Formats of the deadline header sent to the endpoints
+const (
+	// DeadlineUnixMillis sends the deadline in milliseconds since the Unix epoch, e.g. 1717171717171.
+	DeadlineUnixMillis = "unix_ms"
+	// DeadlineRFC3339 sends the deadline as an RFC 3339 time with milliseconds, e.g. 2024-05-31T16:08:37.171Z.
+	DeadlineRFC3339 = "rfc3339"
+	// DeadlineGRPC sends the time left before the deadline in the grpc-timeout format, e.g. 2500m.
+	DeadlineGRPC = "grpc"
+
+	// grpcTimeoutHeader is the header of the gRPC deadlines, sent in the gRPC format by default
+	grpcTimeoutHeader = "Grpc-Timeout"
+
+	// maxGRPCTimeoutValue is the largest value of the grpc-timeout header, which has up to 8 digits
+	maxGRPCTimeoutValue = 99999999
+)
+
+func validateDeadline(header, format string) error {
+	switch format {
+	case "", DeadlineUnixMillis, DeadlineRFC3339, DeadlineGRPC:
+	default:
+		return errors.Errorf("invalid deadline_format %q, must be %s, %s or %s", format, DeadlineUnixMillis, DeadlineRFC3339, DeadlineGRPC)
+	}
+	if format != "" && header == "" {
+		return errors.New("deadline_format requires deadline_header")
+	}
+	return nil
+}
+
+// defaultDeadlineFormat returns the format of the deadline header when none is configured
+func defaultDeadlineFormat(header string) string {
+	if http.CanonicalHeaderKey(header) == grpcTimeoutHeader {
+		return DeadlineGRPC
+	}
+	return DeadlineUnixMillis
+}
+
+// upstreamContext returns the context of the request to the endpoint: it is cancelled when the
+// client disconnects and expires after the timeout of the route, or of the load balancer.
+func (l *loadBalancer) upstreamContext(c *gin.Context, route *endpointRoute) (context.Context, context.CancelFunc) {
+	if timeout := l.routeTimeout(route); timeout > 0 {
+		return context.WithTimeout(c.Request.Context(), timeout)
+	}
+	return context.WithCancel(c.Request.Context())
+}
+
+// routeTimeout returns the timeout of the route, or of the load balancer if the route sets none
+func (l *loadBalancer) routeTimeout(route *endpointRoute) time.Duration {
+	if route != nil && route.timeout > 0 {
+		return route.timeout
+	}
+	return l.timeout
+}
+
+// setDeadline sends the deadline of the request to the endpoint in the deadline header, replacing
+// the value sent by the client
+func (l *loadBalancer) setDeadline(request *http.Request) {
+	if l.deadlineHeader == "" {
+		return
+	}
+	deadline, ok := request.Context().Deadline()
+	if !ok {
+		request.Header.Del(l.deadlineHeader)
+		return
+	}
+	request.Header.Set(l.deadlineHeader, formatDeadline(deadline, l.deadlineFormat))
+}
+
+func formatDeadline(deadline time.Time, format string) string {
+	switch format {
+	case DeadlineRFC3339:
+		return deadline.UTC().Format("2006-01-02T15:04:05.000Z07:00")
+	case DeadlineGRPC:
+		return grpcTimeout(time.Until(deadline))
+	default:
+		return strconv.FormatInt(deadline.UnixMilli(), 10)
+	}
+}
+
+// grpcTimeout formats a timeout as the grpc-timeout header, rounded up to the millisecond
+func grpcTimeout(timeout time.Duration) string {
+	millis := (timeout + time.Millisecond - 1) / time.Millisecond
+	if millis < 1 {
+		millis = 1
+	}
+	if millis <= maxGRPCTimeoutValue {
+		return strconv.FormatInt(int64(millis), 10) + "m"
+	}
+	seconds := (timeout + time.Second - 1) / time.Second
+	return strconv.FormatInt(min(int64(seconds), maxGRPCTimeoutValue), 10) + "S"
+}
+
+// abortUpstreamError answers the failure to reach an endpoint: 504 Gateway Timeout once the
+// deadline expired, 502 Bad Gateway otherwise. Requests of gone clients are only aborted.
+func abortUpstreamError(c *gin.Context, err error) {
+	switch {
+	case server.ClientClosed(c):
+		c.Abort()
+	case errors.Is(err, context.DeadlineExceeded):
+		_ = c.AbortWithError(http.StatusGatewayTimeout, err)
+	default:
+		_ = c.AbortWithError(http.StatusBadGateway, err)
+	}
+}
@@ -0,0 +1,133 @@
+//go:build unit
+
+package controller
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"time"
+
+	"github.com/animalet/sargantana-go/pkg/server"
+	"github.com/gin-gonic/gin"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Load balancer deadlines", func() {
+	var (
+		backend  *httptest.Server
+		headers  chan http.Header
+		finished chan error
+	)
+
+	BeforeEach(func() {
+		headers = make(chan http.Header, 10)
+		finished = make(chan error, 10)
+		backend = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			headers <- r.Header.Clone()
+			delay, _ := time.ParseDuration(r.URL.Query().Get("delay"))
+			select {
+			case <-time.After(delay):
+				_, _ = w.Write([]byte("done"))
+				finished <- nil
+			case <-r.Context().Done():
+				finished <- r.Context().Err()
+			}
+		}))
+		DeferCleanup(backend.Close)
+	})
+
+	// proxy creates a load balancer sending the requests to the backend
+	proxy := func(cfg LoadBalancerControllerConfig) *gin.Engine {
+		cfg.Path = "/api"
+		cfg.Endpoints = []string{backend.URL}
+		Expect(cfg.Validate()).To(Succeed())
+		ctrl, err := NewLoadBalancerController(&cfg, server.ControllerContext{})
+		Expect(err).NotTo(HaveOccurred())
+		engine := gin.New()
+		Expect(ctrl.Bind(engine, nil)).To(Succeed())
+		return engine
+	}
+
+	send := func(engine *gin.Engine, req *http.Request) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		engine.ServeHTTP(w, req)
+		return w
+	}
+
+	It("should validate the timeouts and the deadline header", func() {
+		cfg := LoadBalancerControllerConfig{Path: "/api", Endpoints: []string{"http://localhost"}, Timeout: -time.Second}
+		Expect(cfg.Validate()).To(MatchError(ContainSubstring("timeout must not be negative")))
+		cfg = LoadBalancerControllerConfig{Path: "/api", Endpoints: []string{"http://localhost"}, DeadlineFormat: DeadlineGRPC}
+		Expect(cfg.Validate()).To(MatchError(ContainSubstring("deadline_format requires deadline_header")))
+		cfg.DeadlineHeader, cfg.DeadlineFormat = "X-Request-Deadline", "iso"
+		Expect(cfg.Validate()).To(MatchError(ContainSubstring("invalid deadline_format")))
+		Expect(EndpointRouteConfig{Path: "^/slow/", Timeout: -time.Second}.Validate()).To(MatchError(ContainSubstring("timeout must not be negative")))
+		Expect(EndpointRouteConfig{Path: "^/slow/", Timeout: time.Minute}.Validate()).To(Succeed())
+	})
+
+	It("should answer 504 and cancel the upstream request once the timeout expires", func() {
+		engine := proxy(LoadBalancerControllerConfig{Timeout: 50 * time.Millisecond})
+		w := send(engine, httptest.NewRequest(http.MethodGet, "/api/orders?delay=5s", nil))
+		Expect(w.Code).To(Equal(http.StatusGatewayTimeout))
+		Eventually(finished).Should(Receive(MatchError(context.Canceled)))
+	})
+
+	It("should apply the timeouts of the routes", func() {
+		engine := proxy(LoadBalancerControllerConfig{
+			Timeout: 50 * time.Millisecond,
+			Routes:  []EndpointRouteConfig{{Path: "^/api/reports/", Timeout: 5 * time.Second}},
+		})
+		Expect(send(engine, httptest.NewRequest(http.MethodGet, "/api/reports/daily?delay=200ms", nil)).Code).To(Equal(http.StatusOK))
+		Expect(send(engine, httptest.NewRequest(http.MethodGet, "/api/orders?delay=200ms", nil)).Code).To(Equal(http.StatusGatewayTimeout))
+	})
+
+	It("should cancel the upstream request when the client disconnects", func() {
+		engine := proxy(LoadBalancerControllerConfig{})
+		ctx, cancel := context.WithCancel(context.Background())
+		req := httptest.NewRequest(http.MethodGet, "/api/orders?delay=5s", nil).WithContext(ctx)
+		go func() {
+			<-headers
+			cancel()
+		}()
+		send(engine, req)
+		Eventually(finished).Should(Receive(MatchError(context.Canceled)))
+	})
+
+	It("should send the deadline to the endpoints", func() {
+		engine := proxy(LoadBalancerControllerConfig{Timeout: 2 * time.Second, DeadlineHeader: "X-Request-Deadline"})
+		req := httptest.NewRequest(http.MethodGet, "/api/orders", nil)
+		req.Header.Set("X-Request-Deadline", "0")
+		before := time.Now()
+		Expect(send(engine, req).Code).To(Equal(http.StatusOK))
+		var header http.Header
+		Expect(headers).To(Receive(&header))
+		deadline, err := strconv.ParseInt(header.Get("X-Request-Deadline"), 10, 64)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(time.UnixMilli(deadline)).To(BeTemporally("~", before.Add(2*time.Second), time.Second))
+	})
+
+	It("should remove the deadline header of the clients without timeout", func() {
+		engine := proxy(LoadBalancerControllerConfig{DeadlineHeader: "grpc-timeout"})
+		req := httptest.NewRequest(http.MethodGet, "/api/orders", nil)
+		req.Header.Set("Grpc-Timeout", "1m")
+		Expect(send(engine, req).Code).To(Equal(http.StatusOK))
+		var header http.Header
+		Expect(headers).To(Receive(&header))
+		Expect(header).NotTo(HaveKey("Grpc-Timeout"))
+	})
+
+	It("should format the deadlines", func() {
+		deadline := time.Date(2024, 5, 31, 16, 8, 37, 171_000_000, time.UTC)
+		Expect(formatDeadline(deadline, DeadlineUnixMillis)).To(Equal("1717171717171"))
+		Expect(formatDeadline(deadline, DeadlineRFC3339)).To(Equal("2024-05-31T16:08:37.171Z"))
+		Expect(defaultDeadlineFormat("grpc-timeout")).To(Equal(DeadlineGRPC))
+		Expect(defaultDeadlineFormat("X-Request-Deadline")).To(Equal(DeadlineUnixMillis))
+		Expect(grpcTimeout(2500 * time.Millisecond)).To(Equal("2500m"))
+		Expect(grpcTimeout(1500 * time.Microsecond)).To(Equal("2m"))
+		Expect(grpcTimeout(-time.Second)).To(Equal("1m"))
+		Expect(grpcTimeout(48 * time.Hour)).To(Equal("172800S"))
+	})
+})
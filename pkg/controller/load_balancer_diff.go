@@ -0,0 +1,381 @@
+package controller
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"reflect"
+	"slices"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/animalet/sargantana-go/pkg/server"
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/rs/zerolog"
+)
+
+const (
+	defaultDiffMaxBodySize = 1024 * 1024
+	defaultDiffTimeout     = 10 * time.Second
+
+	// maxDiffDifferences is the number of differences logged per response
+	maxDiffDifferences = 10
+	// maxDiffValueLength is the length of the longest JSON value logged in a difference
+	maxDiffValueLength = 64
+)
+
+var (
+	defaultDiffMethods = []string{http.MethodGet, http.MethodHead}
+	defaultDiffHeaders = []string{"Content-Type"}
+
+	responseDiffs = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "sargantana_response_diffs_total",
+		Help: "Number of responses compared with the candidate endpoints, by controller and result (match, mismatch, error or skipped).",
+	}, []string{"controller", "result"})
+)
+
+// DiffConfig sends the requests to a group of candidate endpoints as well, e.g. the new version of
+// a migrated backend, and compares their responses with the responses of the endpoints, which are
+// the ones served. The differences are logged and counted in the sargantana_response_diffs_total
+// metric.
+type DiffConfig struct {
+	// Endpoints are the candidate endpoints, balanced round-robin.
+	Endpoints []string `yaml:"endpoints"`
+	// Methods are the methods of the requests sent to both groups. Defaults to GET and HEAD, as the
+	// other requests would be applied twice.
+	Methods []string `yaml:"methods,omitempty"`
+	// Headers are the response headers compared. Defaults to Content-Type.
+	Headers []string `yaml:"headers,omitempty"`
+	// IgnoreFields are the fields removed from JSON bodies before comparing them, e.g. $.timestamp or
+	// $.items[*].etag, with the paths of the response transformation.
+	IgnoreFields []string `yaml:"ignore_fields,omitempty"`
+	// MaxBodySize is the size in bytes of the largest request and response bodies compared; the
+	// larger ones are skipped. Defaults to 1 MiB.
+	MaxBodySize int64 `yaml:"max_body_size,omitempty"`
+	// Timeout bounds the requests to the candidate endpoints. Defaults to 10s.
+	Timeout time.Duration `yaml:"timeout,omitempty"`
+}
+
+func (c DiffConfig) Validate() error {
+	if len(c.Endpoints) == 0 {
+		return errors.New("at least one candidate endpoint must be provided")
+	}
+	for _, endpoint := range c.Endpoints {
+		if _, err := url.ParseRequestURI(endpoint); err != nil {
+			return errors.Wrapf(err, "invalid candidate endpoint URL: %s", endpoint)
+		}
+	}
+	for _, method := range c.Methods {
+		if method == "" || method != strings.ToUpper(method) {
+			return errors.Errorf("invalid method %q, methods must be uppercase", method)
+		}
+	}
+	for _, header := range c.Headers {
+		if header == "" {
+			return errors.New("headers must not contain empty names")
+		}
+	}
+	for _, path := range c.IgnoreFields {
+		if _, err := parseFieldPath(path); err != nil {
+			return errors.Wrapf(err, "invalid ignore_fields path %q", path)
+		}
+	}
+	if c.MaxBodySize < 0 || c.Timeout < 0 {
+		return errors.New("max_body_size and timeout must not be negative")
+	}
+	return nil
+}
+
+// responseDiffer compares the responses of the endpoints with those of the candidate endpoints
+type responseDiffer struct {
+	endpoints   []url.URL
+	next        atomic.Uint64
+	methods     []string
+	headers     []string
+	ignore      []fieldPath
+	maxBodySize int64
+	timeout     time.Duration
+	client      *http.Client
+}
+
+func newResponseDiffer(c DiffConfig, client *http.Client) (*responseDiffer, error) {
+	d := &responseDiffer{
+		methods:     c.Methods,
+		headers:     c.Headers,
+		maxBodySize: c.MaxBodySize,
+		timeout:     c.Timeout,
+		client:      client,
+	}
+	for _, endpoint := range c.Endpoints {
+		u, err := url.ParseRequestURI(endpoint)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid candidate endpoint URL: %s", endpoint)
+		}
+		d.endpoints = append(d.endpoints, *u)
+	}
+	for _, path := range c.IgnoreFields {
+		compiled, err := parseFieldPath(path)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid ignore_fields path %q", path)
+		}
+		d.ignore = append(d.ignore, compiled)
+	}
+	if len(d.methods) == 0 {
+		d.methods = defaultDiffMethods
+	}
+	if len(d.headers) == 0 {
+		d.headers = defaultDiffHeaders
+	}
+	if d.maxBodySize == 0 {
+		d.maxBodySize = defaultDiffMaxBodySize
+	}
+	if d.timeout == 0 {
+		d.timeout = defaultDiffTimeout
+	}
+	return d, nil
+}
+
+// diffedResponse is the part of a response compared
+type diffedResponse struct {
+	status int
+	header http.Header
+	body   []byte
+}
+
+// diffRun is a request sent to a candidate endpoint, waiting for the response of the endpoint
+type diffRun struct {
+	differ     *responseDiffer
+	logger     zerolog.Logger
+	controller string
+	method     string
+	path       string
+	candidate  string
+	done       chan struct{}
+	response   *diffedResponse // Nil if the candidate failed or its body was too large
+	err        error
+	status     int
+	header     http.Header
+	primary    *coalesceBuffer // The body of the response served
+}
+
+// start sends a copy of the request to the next candidate endpoint, unless its method is not
+// compared or its body is too large. The request body is buffered to be sent to both groups.
+func (d *responseDiffer) start(c *gin.Context, request *http.Request) *diffRun {
+	if !slices.Contains(d.methods, request.Method) {
+		return nil
+	}
+	controller := server.ControllerName(c)
+	var body []byte
+	if request.Body != nil && request.Body != http.NoBody {
+		read, err := io.ReadAll(io.LimitReader(request.Body, d.maxBodySize+1))
+		request.Body = readCloser{Reader: io.MultiReader(bytes.NewReader(read), request.Body), Closer: request.Body}
+		if err != nil || int64(len(read)) > d.maxBodySize {
+			responseDiffs.WithLabelValues(controller, "skipped").Inc()
+			return nil
+		}
+		body = read
+	}
+
+	endpoint := d.endpoints[(d.next.Add(1)-1)%uint64(len(d.endpoints))]
+	target := *request.URL
+	target.Scheme, target.Host = endpoint.Scheme, endpoint.Host
+	// The candidate is not bound to the client connection, its response is awaited after serving
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(c.Request.Context()), d.timeout)
+	candidate, err := http.NewRequestWithContext(ctx, request.Method, target.String(), bytes.NewReader(body))
+	if err != nil {
+		cancel()
+		responseDiffs.WithLabelValues(controller, "error").Inc()
+		return nil
+	}
+	candidate.Header = request.Header.Clone()
+
+	run := &diffRun{
+		differ:     d,
+		logger:     server.RequestLogger(c),
+		controller: controller,
+		method:     request.Method,
+		path:       request.URL.Path,
+		candidate:  endpoint.String(),
+		done:       make(chan struct{}),
+		primary:    &coalesceBuffer{max: d.maxBodySize},
+	}
+	go func() {
+		defer close(run.done)
+		defer cancel()
+		run.response, run.err = d.roundTrip(candidate)
+	}()
+	return run
+}
+
+// roundTrip sends the request to the candidate, returning a nil response if its body is too large
+func (d *responseDiffer) roundTrip(request *http.Request) (*diffedResponse, error) {
+	response, err := d.client.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = response.Body.Close() }()
+	body, err := io.ReadAll(io.LimitReader(response.Body, d.maxBodySize+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(body)) > d.maxBodySize {
+		return nil, nil
+	}
+	return &diffedResponse{status: response.StatusCode, header: response.Header, body: body}, nil
+}
+
+// capture copies the response of the endpoint, its body while it is read
+func (r *diffRun) capture(response *http.Response) {
+	r.status, r.header = response.StatusCode, response.Header.Clone()
+	response.Body = readCloser{Reader: io.TeeReader(response.Body, r.primary), Closer: response.Body}
+}
+
+// compare waits for the candidate and compares its response with the response served, logging the
+// differences. It must be called once the served response is fully read.
+func (r *diffRun) compare() {
+	<-r.done
+	switch {
+	case r.err != nil:
+		responseDiffs.WithLabelValues(r.controller, "error").Inc()
+		r.logger.Warn().Err(r.err).Str("candidate", r.candidate).Str("path", r.path).Msg("Candidate endpoint failed")
+		return
+	case r.response == nil || r.primary.overflow:
+		responseDiffs.WithLabelValues(r.controller, "skipped").Inc()
+		return
+	}
+
+	differences := r.differ.differences(&diffedResponse{status: r.status, header: r.header, body: r.primary.Bytes()}, r.response)
+	if len(differences) == 0 {
+		responseDiffs.WithLabelValues(r.controller, "match").Inc()
+		return
+	}
+	responseDiffs.WithLabelValues(r.controller, "mismatch").Inc()
+	if len(differences) > maxDiffDifferences {
+		differences = append(differences[:maxDiffDifferences], fmt.Sprintf("and %d more", len(differences)-maxDiffDifferences))
+	}
+	r.logger.Warn().
+		Str("controller", r.controller).
+		Str("method", r.method).
+		Str("path", r.path).
+		Str("candidate", r.candidate).
+		Strs("differences", differences).
+		Msg("Candidate response differs")
+}
+
+// differences lists the differences of the status, the compared headers and the normalized bodies
+func (d *responseDiffer) differences(served, candidate *diffedResponse) []string {
+	var differences []string
+	if served.status != candidate.status {
+		differences = append(differences, fmt.Sprintf("status: %d != %d", served.status, candidate.status))
+	}
+	for _, name := range d.headers {
+		servedValue := strings.Join(served.header.Values(name), ", ")
+		candidateValue := strings.Join(candidate.header.Values(name), ", ")
+		if servedValue != candidateValue {
+			differences = append(differences, fmt.Sprintf("header %s: %q != %q", http.CanonicalHeaderKey(name), servedValue, candidateValue))
+		}
+	}
+
+	servedDocument, servedJSON := d.normalizedJSON(served)
+	candidateDocument, candidateJSON := d.normalizedJSON(candidate)
+	if servedJSON && candidateJSON {
+		return jsonDifferences(differences, "$", servedDocument, candidateDocument)
+	}
+	if !bytes.Equal(bytes.TrimSpace(served.body), bytes.TrimSpace(candidate.body)) {
+		differences = append(differences, fmt.Sprintf("body: %d bytes != %d bytes", len(served.body), len(candidate.body)))
+	}
+	return differences
+}
+
+// normalizedJSON parses a JSON body and removes the ignored fields
+func (d *responseDiffer) normalizedJSON(response *diffedResponse) (any, bool) {
+	mediaType, _, err := mime.ParseMediaType(response.header.Get("Content-Type"))
+	if err != nil || !isJSONMediaType(mediaType) {
+		return nil, false
+	}
+	decoder := json.NewDecoder(bytes.NewReader(response.body))
+	decoder.UseNumber()
+	var document any
+	if err := decoder.Decode(&document); err != nil {
+		return nil, false
+	}
+	for _, path := range d.ignore {
+		visitObjects(document, path.parents, func(object map[string]any) {
+			delete(object, path.field)
+		})
+	}
+	return document, true
+}
+
+// jsonDifferences appends the paths where the documents differ
+func jsonDifferences(differences []string, path string, served, candidate any) []string {
+	switch servedValue := served.(type) {
+	case map[string]any:
+		candidateValue, ok := candidate.(map[string]any)
+		if !ok {
+			break
+		}
+		keys := make([]string, 0, len(servedValue)+len(candidateValue))
+		for key := range servedValue {
+			keys = append(keys, key)
+		}
+		for key := range candidateValue {
+			if _, found := servedValue[key]; !found {
+				keys = append(keys, key)
+			}
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			servedField, inServed := servedValue[key]
+			candidateField, inCandidate := candidateValue[key]
+			switch {
+			case !inCandidate:
+				differences = append(differences, "body "+path+"."+key+": missing in candidate")
+			case !inServed:
+				differences = append(differences, "body "+path+"."+key+": only in candidate")
+			default:
+				differences = jsonDifferences(differences, path+"."+key, servedField, candidateField)
+			}
+		}
+		return differences
+	case []any:
+		candidateValue, ok := candidate.([]any)
+		if !ok || len(servedValue) != len(candidateValue) {
+			break
+		}
+		for i := range servedValue {
+			differences = jsonDifferences(differences, fmt.Sprintf("%s[%d]", path, i), servedValue[i], candidateValue[i])
+		}
+		return differences
+	}
+	if !reflect.DeepEqual(served, candidate) {
+		differences = append(differences, fmt.Sprintf("body %s: %s != %s", path, jsonSummary(served), jsonSummary(candidate)))
+	}
+	return differences
+}
+
+// jsonSummary describes a JSON value in a difference, without the content of objects and arrays
+// and truncating long values
+func jsonSummary(value any) string {
+	switch v := value.(type) {
+	case map[string]any:
+		return fmt.Sprintf("object of %d fields", len(v))
+	case []any:
+		return fmt.Sprintf("array of %d elements", len(v))
+	}
+	encoded, _ := json.Marshal(value)
+	if len(encoded) > maxDiffValueLength {
+		return string(encoded[:maxDiffValueLength]) + "..."
+	}
+	return string(encoded)
+}
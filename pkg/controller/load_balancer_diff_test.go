@@ -0,0 +1,161 @@
+//go:build unit
+
+package controller
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+
+	"github.com/animalet/sargantana-go/pkg/server"
+	"github.com/gin-gonic/gin"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+// lockedBuffer collects the records logged by the comparisons running after the responses
+type lockedBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *lockedBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+var _ = Describe("Load balancer response diffing", func() {
+	var (
+		served        *httptest.Server
+		candidate     *httptest.Server
+		candidateBody string
+		requests      chan string
+		logs          *lockedBuffer
+	)
+
+	BeforeEach(func() {
+		served = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			_, _ = w.Write([]byte(`{"id": 1, "name": "old", "generated_at": "2024-01-01", "items": [1, 2]}`))
+		}))
+		DeferCleanup(served.Close)
+		requests = make(chan string, 1)
+		candidateBody = `{"id": 1, "name": "old", "generated_at": "2024-06-01", "items": [1, 2]}`
+		candidate = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body, _ := io.ReadAll(r.Body)
+			requests <- r.Method + " " + r.URL.RequestURI() + " " + string(body)
+			w.Header().Set("Content-Type", "application/json")
+			_, _ = w.Write([]byte(candidateBody))
+		}))
+		DeferCleanup(candidate.Close)
+
+		logs = &lockedBuffer{}
+		originalLogger := log.Logger
+		log.Logger = zerolog.New(logs)
+		DeferCleanup(func() { log.Logger = originalLogger })
+	})
+
+	// serve sends a request through a load balancer comparing the responses and returns its body
+	serve := func(cfg DiffConfig, req *http.Request) string {
+		cfg.Endpoints = []string{candidate.URL}
+		lbConfig := LoadBalancerControllerConfig{Path: "/api", Endpoints: []string{served.URL}, Diff: &cfg}
+		Expect(lbConfig.Validate()).To(Succeed())
+		ctrl, err := NewLoadBalancerController(&lbConfig, server.ControllerContext{})
+		Expect(err).NotTo(HaveOccurred())
+		engine := gin.New()
+		Expect(ctrl.Bind(engine, nil)).To(Succeed())
+		w := httptest.NewRecorder()
+		engine.ServeHTTP(w, req)
+		Expect(w.Code).To(Equal(http.StatusOK))
+		return w.Body.String()
+	}
+
+	results := func(result string) func() float64 {
+		return func() float64 { return testutil.ToFloat64(responseDiffs.WithLabelValues("", result)) }
+	}
+
+	It("should validate the configuration", func() {
+		Expect(DiffConfig{}.Validate()).To(MatchError(ContainSubstring("candidate endpoint")))
+		Expect(DiffConfig{Endpoints: []string{"not a url"}}.Validate()).To(MatchError(ContainSubstring("invalid candidate endpoint")))
+		Expect(DiffConfig{Endpoints: []string{"http://new"}, Methods: []string{"get"}}.Validate()).To(MatchError(ContainSubstring("uppercase")))
+		Expect(DiffConfig{Endpoints: []string{"http://new"}, IgnoreFields: []string{"$.items[*]"}}.Validate()).
+			To(MatchError(ContainSubstring("invalid ignore_fields path")))
+		Expect(DiffConfig{Endpoints: []string{"http://new"}, Timeout: -1}.Validate()).To(MatchError(ContainSubstring("must not be negative")))
+		Expect(DiffConfig{Endpoints: []string{"http://new"}, Methods: []string{"GET", "POST"}, IgnoreFields: []string{"$.generated_at"}}.Validate()).To(Succeed())
+
+		cfg := LoadBalancerControllerConfig{
+			Path:      "/php",
+			Endpoints: []string{"tcp://localhost:9000"},
+			FastCGI:   &FastCGIConfig{ScriptRoot: "/srv"},
+			Diff:      &DiffConfig{Endpoints: []string{"http://new"}},
+		}
+		Expect(cfg.Validate()).To(MatchError(ContainSubstring("diff is not supported")))
+	})
+
+	It("should serve the response of the endpoints and log the differences of the candidates", func() {
+		mismatches := testutil.ToFloat64(responseDiffs.WithLabelValues("", "mismatch"))
+		candidateBody = `{"id": "1", "name": "new", "items": [1, 2, 3], "extra": true}`
+		body := serve(DiffConfig{IgnoreFields: []string{"$.generated_at"}}, httptest.NewRequest(http.MethodGet, "/api/orders?id=1", nil))
+		Expect(body).To(ContainSubstring(`"name": "old"`))
+		Eventually(requests).Should(Receive(Equal("GET /api/orders?id=1 ")))
+
+		Eventually(results("mismatch")).Should(Equal(mismatches + 1))
+		Eventually(logs.String).Should(ContainSubstring("Candidate response differs"))
+		Expect(logs.String()).To(ContainSubstring(`body $.extra: only in candidate`))
+		Expect(logs.String()).To(ContainSubstring(`body $.id: 1 != \"1\"`))
+		Expect(logs.String()).To(ContainSubstring(`body $.items: array of 2 elements != array of 3 elements`))
+		Expect(logs.String()).To(ContainSubstring(`body $.name: \"old\" != \"new\"`))
+		Expect(logs.String()).NotTo(ContainSubstring("generated_at"))
+	})
+
+	It("should count the matching responses after normalizing them", func() {
+		matches := testutil.ToFloat64(responseDiffs.WithLabelValues("", "match"))
+		serve(DiffConfig{IgnoreFields: []string{"$.generated_at"}}, httptest.NewRequest(http.MethodGet, "/api/orders", nil))
+		Eventually(results("match")).Should(Equal(matches + 1))
+		Expect(logs.String()).NotTo(ContainSubstring("Candidate response differs"))
+	})
+
+	It("should compare the status and the configured headers", func() {
+		differ, err := newResponseDiffer(DiffConfig{Endpoints: []string{candidate.URL}, Headers: []string{"content-type", "Cache-Control"}}, http.DefaultClient)
+		Expect(err).NotTo(HaveOccurred())
+		differences := differ.differences(
+			&diffedResponse{status: http.StatusOK, header: http.Header{"Content-Type": {"text/plain"}, "Cache-Control": {"max-age=60"}}, body: []byte("hello\n")},
+			&diffedResponse{status: http.StatusNotFound, header: http.Header{"Content-Type": {"text/plain"}}, body: []byte("hello")},
+		)
+		Expect(differences).To(Equal([]string{`status: 200 != 404`, `header Cache-Control: "max-age=60" != ""`}))
+
+		differences = differ.differences(
+			&diffedResponse{status: http.StatusOK, header: http.Header{}, body: []byte("hello")},
+			&diffedResponse{status: http.StatusOK, header: http.Header{}, body: []byte("bye")},
+		)
+		Expect(differences).To(Equal([]string{"body: 5 bytes != 3 bytes"}))
+	})
+
+	It("should only send the configured methods to the candidates", func() {
+		serve(DiffConfig{}, httptest.NewRequest(http.MethodPost, "/api/orders", strings.NewReader(`{"id": 2}`)))
+		Consistently(requests, "100ms").ShouldNot(Receive())
+
+		serve(DiffConfig{Methods: []string{http.MethodPost}}, httptest.NewRequest(http.MethodPost, "/api/orders", strings.NewReader(`{"id": 2}`)))
+		Eventually(requests).Should(Receive(Equal(`POST /api/orders {"id": 2}`)))
+	})
+
+	It("should skip the requests with bodies over the limit", func() {
+		skipped := testutil.ToFloat64(responseDiffs.WithLabelValues("", "skipped"))
+		serve(DiffConfig{Methods: []string{http.MethodPut}, MaxBodySize: 4}, httptest.NewRequest(http.MethodPut, "/api/orders", strings.NewReader("0123456789")))
+		Expect(requests).NotTo(Receive())
+		Expect(testutil.ToFloat64(responseDiffs.WithLabelValues("", "skipped"))).To(Equal(skipped + 1))
+	})
+})
@@ -0,0 +1,256 @@
+package controller
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	defaultHealthCheckPath               = "/"
+	defaultHealthCheckInterval           = 10 * time.Second
+	defaultHealthCheckTimeout            = 2 * time.Second
+	defaultHealthCheckUnhealthyThreshold = 3
+	defaultHealthCheckHealthyThreshold   = 2
+)
+
+// LoadBalancerHealthCheckConfig probes the endpoints of a load balancer in the background, taking
+// the failing ones out of rotation until they recover. An endpoint fails a probe when it does not
+// answer within the timeout, or answers with a status of 400 or more.
+type LoadBalancerHealthCheckConfig struct {
+	// Path is requested with GET on each endpoint. Defaults to /.
+	Path string `yaml:"path,omitempty"`
+	// Interval is the time between the probes of each endpoint. Defaults to 10s.
+	Interval time.Duration `yaml:"interval,omitempty"`
+	// Timeout bounds each probe. Defaults to 2s, and must not exceed the interval.
+	Timeout time.Duration `yaml:"timeout,omitempty"`
+	// UnhealthyThreshold is the number of consecutive failed probes taking an endpoint out of
+	// rotation. Defaults to 3.
+	UnhealthyThreshold int `yaml:"unhealthy_threshold,omitempty"`
+	// HealthyThreshold is the number of consecutive successful probes putting an unhealthy
+	// endpoint back in rotation. Defaults to 2.
+	HealthyThreshold int `yaml:"healthy_threshold,omitempty"`
+}
+
+func (c LoadBalancerHealthCheckConfig) Validate() error {
+	if c.Path != "" && !strings.HasPrefix(c.Path, "/") {
+		return errors.New("path must start with /")
+	}
+	if c.Interval < 0 {
+		return errors.New("interval must not be negative")
+	}
+	if c.Timeout < 0 {
+		return errors.New("timeout must not be negative")
+	}
+	if c.UnhealthyThreshold < 0 {
+		return errors.New("unhealthy_threshold must not be negative")
+	}
+	if c.HealthyThreshold < 0 {
+		return errors.New("healthy_threshold must not be negative")
+	}
+	if resolved := c.withDefaults(); resolved.Timeout > resolved.Interval {
+		return errors.New("timeout must not exceed the interval")
+	}
+	return nil
+}
+
+func (c LoadBalancerHealthCheckConfig) withDefaults() LoadBalancerHealthCheckConfig {
+	if c.Path == "" {
+		c.Path = defaultHealthCheckPath
+	}
+	if c.Interval == 0 {
+		c.Interval = defaultHealthCheckInterval
+	}
+	if c.Timeout == 0 {
+		c.Timeout = min(defaultHealthCheckTimeout, c.Interval)
+	}
+	if c.UnhealthyThreshold == 0 {
+		c.UnhealthyThreshold = defaultHealthCheckUnhealthyThreshold
+	}
+	if c.HealthyThreshold == 0 {
+		c.HealthyThreshold = defaultHealthCheckHealthyThreshold
+	}
+	return c
+}
+
+// endpointHealth is the health of an endpoint. The counters are only updated by the prober.
+type endpointHealth struct {
+	healthy   atomic.Bool
+	failures  int // Consecutive failed probes
+	successes int // Consecutive successful probes while unhealthy
+}
+
+// healthProber probes the endpoints of a load balancer until stopped. The endpoints start healthy,
+// so that requests are forwarded before the first probes complete.
+type healthProber struct {
+	config     LoadBalancerHealthCheckConfig
+	client     *http.Client
+	controller string
+	endpoints  []url.URL
+	health     []*endpointHealth // One per endpoint
+	stop       chan struct{}
+	done       chan struct{}
+}
+
+func newHealthProber(cfg LoadBalancerHealthCheckConfig, transport http.RoundTripper, controller string, endpoints []url.URL) *healthProber {
+	p := &healthProber{
+		config: cfg.withDefaults(),
+		client: &http.Client{
+			Transport: transport,
+			// Redirects are answers of the endpoint, not followed
+			CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+		},
+		controller: controller,
+		endpoints:  endpoints,
+		health:     make([]*endpointHealth, len(endpoints)),
+		stop:       make(chan struct{}),
+		done:       make(chan struct{}),
+	}
+	for i, endpoint := range endpoints {
+		p.health[i] = &endpointHealth{}
+		p.health[i].healthy.Store(true)
+		backendHealthy.WithLabelValues(controller, endpoint.Redacted()).Set(1)
+	}
+	go p.run()
+	return p
+}
+
+func (p *healthProber) run() {
+	defer close(p.done)
+	ticker := time.NewTicker(p.config.Interval)
+	defer ticker.Stop()
+	for {
+		p.probeAll()
+		select {
+		case <-ticker.C:
+		case <-p.stop:
+			return
+		}
+	}
+}
+
+// probeAll probes the endpoints concurrently and updates their health
+func (p *healthProber) probeAll() {
+	var wg sync.WaitGroup
+	for i := range p.endpoints {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			p.update(i, p.probe(p.endpoints[i]))
+		}()
+	}
+	wg.Wait()
+}
+
+func (p *healthProber) probe(endpoint url.URL) error {
+	ctx, cancel := context.WithTimeout(context.Background(), p.config.Timeout)
+	defer cancel()
+	target := url.URL{Scheme: endpoint.Scheme, Host: endpoint.Host, Path: p.config.Path}
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, target.String(), nil)
+	if err != nil {
+		return err
+	}
+	response, err := p.client.Do(request)
+	if err != nil {
+		return err
+	}
+	_ = response.Body.Close()
+	if response.StatusCode >= http.StatusBadRequest {
+		return errors.Errorf("health check answered with status %d", response.StatusCode)
+	}
+	return nil
+}
+
+// update counts the result of a probe, changing the health of the endpoint when a threshold is
+// reached
+func (p *healthProber) update(index int, err error) {
+	health := p.health[index]
+	target := p.endpoints[index].Redacted()
+	if err != nil {
+		health.successes = 0
+		health.failures++
+		if health.healthy.Load() && health.failures >= p.config.UnhealthyThreshold {
+			health.healthy.Store(false)
+			backendHealthy.WithLabelValues(p.controller, target).Set(0)
+			backendHealthTransitions.WithLabelValues(p.controller, target, "unhealthy").Inc()
+			log.Warn().Err(err).Str("controller", p.controller).Str("endpoint", target).
+				Int("failures", health.failures).Msg("Load balancer endpoint taken out of rotation")
+		}
+		return
+	}
+	health.failures = 0
+	if health.healthy.Load() {
+		return
+	}
+	health.successes++
+	if health.successes >= p.config.HealthyThreshold {
+		health.successes = 0
+		health.healthy.Store(true)
+		backendHealthy.WithLabelValues(p.controller, target).Set(1)
+		backendHealthTransitions.WithLabelValues(p.controller, target, "healthy").Inc()
+		log.Info().Str("controller", p.controller).Str("endpoint", target).Msg("Load balancer endpoint back in rotation")
+	}
+}
+
+func (p *healthProber) healthy(index int) bool {
+	return p.health[index].healthy.Load()
+}
+
+// unhealthy returns the endpoints out of rotation
+func (p *healthProber) unhealthy() []string {
+	var endpoints []string
+	for i, endpoint := range p.endpoints {
+		if !p.healthy(i) {
+			endpoints = append(endpoints, endpoint.Redacted())
+		}
+	}
+	return endpoints
+}
+
+func (p *healthProber) Close() {
+	close(p.stop)
+	<-p.done
+}
+
+// pickRouteEndpoint returns the next endpoint of the route, or of the default endpoints without
+// route, see pickEndpoint
+func (l *loadBalancer) pickRouteEndpoint(route *endpointRoute) int {
+	if route != nil && len(route.indices) > 0 {
+		return l.pickEndpoint(route.nextEndpoint, len(route.indices))
+	}
+	return l.pickEndpoint(l.nextEndpoint, l.defaultCount)
+}
+
+// pickEndpoint returns the next endpoint in rotation, skipping the unhealthy ones and those whose
+// circuit breaker is open, or -1 if all the circuit breakers are open. When all of them are
+// unhealthy, the next endpoint is returned anyway, since an endpoint failing its health checks may
+// still answer some requests.
+func (l *loadBalancer) pickEndpoint(next func() int, count int) int {
+	if l.prober == nil && l.breakers == nil {
+		return next()
+	}
+	var unhealthy []int
+	for range count {
+		index := next()
+		if l.prober != nil && !l.prober.healthy(index) {
+			unhealthy = append(unhealthy, index)
+			continue
+		}
+		if l.available(index) {
+			return index
+		}
+	}
+	for _, index := range unhealthy {
+		if l.available(index) {
+			return index
+		}
+	}
+	return -1
+}
@@ -0,0 +1,141 @@
+//go:build unit
+
+package controller
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"time"
+
+	"github.com/animalet/sargantana-go/pkg/server"
+	"github.com/gin-gonic/gin"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+var _ = Describe("Load balancer health checks", func() {
+	Context("Configuration", func() {
+		It("should validate the health check configuration", func() {
+			Expect(LoadBalancerHealthCheckConfig{}.Validate()).To(Succeed())
+			Expect(LoadBalancerHealthCheckConfig{Path: "health"}.Validate()).To(MatchError(ContainSubstring("path")))
+			Expect(LoadBalancerHealthCheckConfig{Interval: -1}.Validate()).To(MatchError(ContainSubstring("interval")))
+			Expect(LoadBalancerHealthCheckConfig{Timeout: -1}.Validate()).To(MatchError(ContainSubstring("timeout")))
+			Expect(LoadBalancerHealthCheckConfig{UnhealthyThreshold: -1}.Validate()).To(MatchError(ContainSubstring("unhealthy_threshold")))
+			Expect(LoadBalancerHealthCheckConfig{HealthyThreshold: -1}.Validate()).To(MatchError(ContainSubstring("healthy_threshold")))
+			Expect(LoadBalancerHealthCheckConfig{Interval: time.Second, Timeout: 2 * time.Second}.Validate()).
+				To(MatchError(ContainSubstring("must not exceed the interval")))
+			Expect(LoadBalancerHealthCheckConfig{Interval: time.Second}.Validate()).To(Succeed())
+		})
+
+		It("should reject health checks of fastcgi endpoints", func() {
+			cfg := LoadBalancerControllerConfig{
+				Path:        "/php",
+				Endpoints:   []string{"tcp://localhost:9000"},
+				FastCGI:     &FastCGIConfig{ScriptRoot: "/var/www"},
+				HealthCheck: &LoadBalancerHealthCheckConfig{},
+			}
+			Expect(cfg.Validate()).To(MatchError(ContainSubstring("health_check is not supported")))
+		})
+	})
+
+	It("should take the failing endpoints out of rotation until they recover", func() {
+		var failing atomic.Bool
+		var probes atomic.Int32
+		flaky := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/health" {
+				probes.Add(1)
+				if failing.Load() {
+					w.WriteHeader(http.StatusServiceUnavailable)
+					return
+				}
+			}
+			_, _ = w.Write([]byte("flaky"))
+		}))
+		DeferCleanup(flaky.Close)
+		stable := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			_, _ = w.Write([]byte("stable"))
+		}))
+		DeferCleanup(stable.Close)
+
+		lbConfig := LoadBalancerControllerConfig{
+			Path:      "/api",
+			Endpoints: []string{flaky.URL, stable.URL},
+			HealthCheck: &LoadBalancerHealthCheckConfig{
+				Path:               "/health",
+				Interval:           20 * time.Millisecond,
+				UnhealthyThreshold: 2,
+				HealthyThreshold:   2,
+			},
+		}
+		Expect(lbConfig.Validate()).To(Succeed())
+		ctrl, err := NewLoadBalancerController(&lbConfig, server.ControllerContext{Name: "health-api"})
+		Expect(err).NotTo(HaveOccurred())
+		DeferCleanup(ctrl.Close)
+		engine := gin.New()
+		Expect(ctrl.Bind(engine, nil)).To(Succeed())
+		responses := func() []string {
+			var bodies []string
+			for range 4 {
+				w := httptest.NewRecorder()
+				engine.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/items", nil))
+				bodies = append(bodies, w.Body.String())
+			}
+			return bodies
+		}
+
+		healthy := backendHealthy.WithLabelValues("health-api", flaky.URL)
+		wentDown := backendHealthTransitions.WithLabelValues("health-api", flaky.URL, "unhealthy")
+		cameBack := backendHealthTransitions.WithLabelValues("health-api", flaky.URL, "healthy")
+		Eventually(probes.Load).Should(BeNumerically(">", 0))
+		Expect(testutil.ToFloat64(healthy)).To(Equal(1.0))
+		Expect(responses()).To(ContainElements("flaky", "stable"))
+
+		By("skipping the endpoint failing its health checks")
+		failing.Store(true)
+		Eventually(func() float64 { return testutil.ToFloat64(healthy) }).Should(Equal(0.0))
+		Expect(testutil.ToFloat64(wentDown)).To(Equal(1.0))
+		Expect(responses()).To(HaveEach("stable"))
+		Expect(ctrl.(server.HealthReporter).HealthCheck(context.Background())).To(Succeed())
+
+		By("putting it back in rotation once it recovers")
+		failing.Store(false)
+		Eventually(func() float64 { return testutil.ToFloat64(healthy) }).Should(Equal(1.0))
+		Expect(testutil.ToFloat64(cameBack)).To(Equal(1.0))
+		Expect(responses()).To(ContainElements("flaky", "stable"))
+	})
+
+	It("should keep forwarding and fail the health check when no endpoint is healthy", func() {
+		backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/" {
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			_, _ = w.Write([]byte("ok"))
+		}))
+		DeferCleanup(backend.Close)
+
+		lbConfig := LoadBalancerControllerConfig{
+			Path:      "/api",
+			Endpoints: []string{backend.URL},
+			HealthCheck: &LoadBalancerHealthCheckConfig{
+				Interval:           20 * time.Millisecond,
+				UnhealthyThreshold: 1,
+			},
+		}
+		ctrl, err := NewLoadBalancerController(&lbConfig, server.ControllerContext{})
+		Expect(err).NotTo(HaveOccurred())
+		DeferCleanup(ctrl.Close)
+		reporter := ctrl.(server.HealthReporter)
+		Eventually(func() error { return reporter.HealthCheck(context.Background()) }).
+			Should(MatchError(ContainSubstring("no endpoint is healthy")))
+
+		engine := gin.New()
+		Expect(ctrl.Bind(engine, nil)).To(Succeed())
+		w := httptest.NewRecorder()
+		engine.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/items", nil))
+		Expect(w.Body.String()).To(Equal("ok"))
+	})
+})
@@ -0,0 +1,71 @@
+package controller
+
+import (
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/animalet/sargantana-go/pkg/server"
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	backendRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "sargantana_backend_requests_total",
+		Help: "Number of requests forwarded to the load balancer endpoints, by controller, endpoint and status code (error if no response was received).",
+	}, []string{"controller", "endpoint", "code"})
+	backendRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "sargantana_backend_request_duration_seconds",
+		Help:    "Time until the response headers of the load balancer endpoints, by controller and endpoint.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"controller", "endpoint"})
+	backendInFlight = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "sargantana_backend_requests_in_flight",
+		Help: "Number of requests awaiting the response headers of the load balancer endpoints, by controller and endpoint.",
+	}, []string{"controller", "endpoint"})
+	backendHealthy = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "sargantana_backend_healthy",
+		Help: "Whether the load balancer endpoints probed by active health checks are in rotation (1) or not (0), by controller and endpoint.",
+	}, []string{"controller", "endpoint"})
+	backendHealthTransitions = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "sargantana_backend_health_transitions_total",
+		Help: "Number of times the load balancer endpoints probed by active health checks became healthy or unhealthy, by controller, endpoint and state.",
+	}, []string{"controller", "endpoint", "state"})
+	backendRetries = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "sargantana_backend_retries_total",
+		Help: "Number of requests retried on another load balancer endpoint, by controller, failed endpoint and reason (status code, or error if no response was received).",
+	}, []string{"controller", "endpoint", "reason"})
+	backendUpgradedConnections = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "sargantana_backend_upgraded_connections",
+		Help: "Number of open connections upgraded to another protocol, e.g. WebSocket, with the load balancer endpoints, by controller and endpoint.",
+	}, []string{"controller", "endpoint"})
+	backendCircuitState = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "sargantana_backend_circuit_state",
+		Help: "State of the circuit breakers of the load balancer endpoints, closed (0), half-open (1) or open (2), by controller and endpoint.",
+	}, []string{"controller", "endpoint"})
+	backendCircuitTransitions = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "sargantana_backend_circuit_transitions_total",
+		Help: "Number of times the circuit breakers of the load balancer endpoints changed state, by controller, endpoint and new state.",
+	}, []string{"controller", "endpoint", "state"})
+)
+
+// observeBackend starts observing a request forwarded to the endpoint, and returns the function
+// ending the observation with the response of the endpoint.
+func observeBackend(c *gin.Context, endpoint url.URL) func(*http.Response, error) {
+	controller, target := server.ControllerName(c), endpoint.Redacted()
+	inFlight := backendInFlight.WithLabelValues(controller, target)
+	inFlight.Inc()
+	start := time.Now()
+	return func(response *http.Response, err error) {
+		inFlight.Dec()
+		code := "error"
+		if err == nil {
+			code = strconv.Itoa(response.StatusCode)
+		}
+		backendRequests.WithLabelValues(controller, target, code).Inc()
+		backendRequestDuration.WithLabelValues(controller, target).Observe(time.Since(start).Seconds())
+	}
+}
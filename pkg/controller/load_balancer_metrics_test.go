@@ -0,0 +1,44 @@
+//go:build unit
+
+package controller
+
+import (
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/animalet/sargantana-go/pkg/server"
+	"github.com/gin-gonic/gin"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+var _ = Describe("Load balancer backend metrics", func() {
+	It("should count the requests of each endpoint by status code, and the unreachable endpoints", func() {
+		backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			w.WriteHeader(http.StatusAccepted)
+		}))
+		DeferCleanup(backend.Close)
+		unreachable := httptest.NewServer(http.NotFoundHandler())
+		unreachable.Close()
+
+		lbConfig := LoadBalancerControllerConfig{Path: "/api", Endpoints: []string{backend.URL, unreachable.URL}}
+		Expect(lbConfig.Validate()).To(Succeed())
+		ctrl, err := NewLoadBalancerController(&lbConfig, server.ControllerContext{})
+		Expect(err).NotTo(HaveOccurred())
+		engine := gin.New()
+		Expect(ctrl.Bind(engine, nil)).To(Succeed())
+
+		accepted := backendRequests.WithLabelValues("", backend.URL, "202")
+		failed := backendRequests.WithLabelValues("", unreachable.URL, "error")
+		acceptedBefore, failedBefore := testutil.ToFloat64(accepted), testutil.ToFloat64(failed)
+		for range 4 {
+			engine.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/api/items", nil))
+		}
+
+		Expect(testutil.ToFloat64(accepted) - acceptedBefore).To(Equal(2.0))
+		Expect(testutil.ToFloat64(failed) - failedBefore).To(Equal(2.0))
+		Expect(testutil.ToFloat64(backendInFlight.WithLabelValues("", backend.URL))).To(BeZero())
+		Expect(testutil.CollectAndCount(backendRequestDuration, "sargantana_backend_request_duration_seconds")).To(BeNumerically(">=", 2))
+	})
+})
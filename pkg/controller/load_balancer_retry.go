@@ -0,0 +1,195 @@
+package controller
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	defaultRetryMaxRetries  = 2
+	defaultRetryBackoff     = 50 * time.Millisecond
+	defaultRetryMaxBackoff  = time.Second
+	defaultRetryMaxBodySize = 1 << 20
+)
+
+var (
+	defaultRetryStatuses = []int{http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout}
+	// The idempotent methods, see RFC 9110 section 9.2.2
+	defaultRetryMethods = []string{http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodPut, http.MethodDelete}
+)
+
+// RetryConfig retries the requests failing on an endpoint against the next endpoints of their
+// route, each endpoint being tried at most once per request. A request fails when the endpoint
+// cannot be reached or answers with one of the retried statuses; the response of the last
+// attempt is sent when all the attempts fail.
+type RetryConfig struct {
+	// MaxRetries is the number of retries after the first attempt. Defaults to 2.
+	MaxRetries int `yaml:"max_retries,omitempty"`
+	// Statuses are the response statuses retried. Defaults to 502, 503 and 504.
+	Statuses []int `yaml:"statuses,omitempty"`
+	// Methods are the request methods retried. Defaults to the idempotent ones: GET, HEAD,
+	// OPTIONS, PUT and DELETE.
+	Methods []string `yaml:"methods,omitempty"`
+	// Backoff is the wait before the first retry, doubled on each retry. Defaults to 50ms.
+	Backoff time.Duration `yaml:"backoff,omitempty"`
+	// MaxBackoff caps the wait before each retry. Defaults to 1s.
+	MaxBackoff time.Duration `yaml:"max_backoff,omitempty"`
+	// MaxBodySize is the size of the largest request body buffered to be sent again; requests with
+	// larger bodies are not retried. Defaults to 1 MiB.
+	MaxBodySize int64 `yaml:"max_body_size,omitempty"`
+}
+
+func (r RetryConfig) Validate() error {
+	if r.MaxRetries < 0 {
+		return errors.New("max_retries must not be negative")
+	}
+	for _, status := range r.Statuses {
+		if status < 400 || status > 599 {
+			return errors.Errorf("invalid retried status %d, must be between 400 and 599", status)
+		}
+	}
+	for _, method := range r.Methods {
+		if method == "" || method != strings.ToUpper(method) {
+			return errors.Errorf("invalid retried method %q, must be non-empty and upper case", method)
+		}
+	}
+	if r.Backoff < 0 {
+		return errors.New("backoff must not be negative")
+	}
+	if r.MaxBackoff < 0 {
+		return errors.New("max_backoff must not be negative")
+	}
+	if r.MaxBodySize < 0 {
+		return errors.New("max_body_size must not be negative")
+	}
+	if resolved := r.withDefaults(); resolved.Backoff > resolved.MaxBackoff {
+		return errors.New("backoff must not exceed max_backoff")
+	}
+	return nil
+}
+
+func (r RetryConfig) withDefaults() RetryConfig {
+	if r.MaxRetries == 0 {
+		r.MaxRetries = defaultRetryMaxRetries
+	}
+	if len(r.Statuses) == 0 {
+		r.Statuses = defaultRetryStatuses
+	}
+	if len(r.Methods) == 0 {
+		r.Methods = defaultRetryMethods
+	}
+	if r.Backoff == 0 {
+		r.Backoff = defaultRetryBackoff
+	}
+	if r.MaxBackoff == 0 {
+		r.MaxBackoff = defaultRetryMaxBackoff
+	}
+	if r.MaxBodySize == 0 {
+		r.MaxBodySize = defaultRetryMaxBodySize
+	}
+	return r
+}
+
+// retryPolicy decides which requests are retried, and when
+type retryPolicy struct {
+	config RetryConfig
+}
+
+func newRetryPolicy(c RetryConfig) *retryPolicy {
+	return &retryPolicy{config: c.withDefaults()}
+}
+
+// bufferBody reads the body of the request to send it again on retries, returning false if the
+// request is not retried because of its method or the size of its body
+func (r *retryPolicy) bufferBody(request *http.Request) ([]byte, bool) {
+	if !slices.Contains(r.config.Methods, request.Method) {
+		return nil, false
+	}
+	if request.Body == nil || request.Body == http.NoBody {
+		return nil, true
+	}
+	read, err := io.ReadAll(io.LimitReader(request.Body, r.config.MaxBodySize+1))
+	if err != nil || int64(len(read)) > r.config.MaxBodySize {
+		// The read part is sent first, and the forward fails if the body cannot be read
+		request.Body = readCloser{Reader: io.MultiReader(bytes.NewReader(read), request.Body), Closer: request.Body}
+		return nil, false
+	}
+	return read, true
+}
+
+// reason returns why the attempt failed, the status of its response or "error" if the endpoint
+// could not be reached, or an empty string if it is not retried. Attempts cancelled by the client
+// or the timeout are not retried.
+func (r *retryPolicy) reason(ctx context.Context, response *http.Response, err error) string {
+	if err != nil {
+		if ctx.Err() != nil {
+			return ""
+		}
+		return "error"
+	}
+	if slices.Contains(r.config.Statuses, response.StatusCode) {
+		return strconv.Itoa(response.StatusCode)
+	}
+	return ""
+}
+
+// wait waits before the retry following the failed attempts, returning false if the context is
+// done meanwhile
+func (r *retryPolicy) wait(ctx context.Context, attempts int) bool {
+	backoff := r.config.Backoff
+	for i := 1; i < attempts && backoff < r.config.MaxBackoff; i++ {
+		backoff *= 2
+	}
+	timer := time.NewTimer(min(backoff, r.config.MaxBackoff))
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// retryEndpoint returns the endpoint of the route following the last one tried, skipping the
+// tried ones and those whose circuit breaker is open, and preferring the healthy ones, or -1 if no
+// endpoint of the route is left
+func (l *loadBalancer) retryEndpoint(route *endpointRoute, tried []int) int {
+	var candidates []int
+	if route != nil && len(route.indices) > 0 {
+		candidates = route.indices
+	} else {
+		candidates = make([]int, l.defaultCount)
+		for i := range candidates {
+			candidates[i] = i
+		}
+	}
+	start := slices.Index(candidates, tried[len(tried)-1])
+	var unhealthy []int
+	for i := 1; i < len(candidates); i++ {
+		index := candidates[(start+i)%len(candidates)]
+		if slices.Contains(tried, index) {
+			continue
+		}
+		if l.prober != nil && !l.prober.healthy(index) {
+			unhealthy = append(unhealthy, index)
+			continue
+		}
+		if l.available(index) {
+			return index
+		}
+	}
+	for _, index := range unhealthy {
+		if l.available(index) {
+			return index
+		}
+	}
+	return -1
+}
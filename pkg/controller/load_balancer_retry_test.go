@@ -0,0 +1,129 @@
+//go:build unit
+
+package controller
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/animalet/sargantana-go/pkg/server"
+	"github.com/gin-gonic/gin"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+var _ = Describe("Load balancer retries", func() {
+	// backend answers with the status, counting its requests and echoing their body
+	backend := func(status int, hits *atomic.Int32) *httptest.Server {
+		s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			hits.Add(1)
+			body, _ := io.ReadAll(r.Body)
+			w.WriteHeader(status)
+			_, _ = w.Write([]byte(strings.TrimSpace(http.StatusText(status) + " " + string(body))))
+		}))
+		DeferCleanup(s.Close)
+		return s
+	}
+
+	unreachable := func() string {
+		s := httptest.NewServer(http.NotFoundHandler())
+		s.Close()
+		return s.URL
+	}
+
+	serve := func(retry *RetryConfig, endpoints []string, method, body string) *httptest.ResponseRecorder {
+		lbConfig := LoadBalancerControllerConfig{Path: "/api", Endpoints: endpoints, Retry: retry}
+		Expect(lbConfig.Validate()).To(Succeed())
+		ctrl, err := NewLoadBalancerController(&lbConfig, server.ControllerContext{})
+		Expect(err).NotTo(HaveOccurred())
+		DeferCleanup(ctrl.Close)
+		engine := gin.New()
+		Expect(ctrl.Bind(engine, nil)).To(Succeed())
+		recorder := httptest.NewRecorder()
+		engine.ServeHTTP(recorder, httptest.NewRequest(method, "/api/orders", strings.NewReader(body)))
+		return recorder
+	}
+
+	Context("Configuration", func() {
+		It("should validate the retry configuration", func() {
+			Expect(RetryConfig{}.Validate()).To(Succeed())
+			Expect(RetryConfig{MaxRetries: -1}.Validate()).To(MatchError(ContainSubstring("max_retries")))
+			Expect(RetryConfig{Statuses: []int{200}}.Validate()).To(MatchError(ContainSubstring("invalid retried status 200")))
+			Expect(RetryConfig{Methods: []string{"get"}}.Validate()).To(MatchError(ContainSubstring(`invalid retried method "get"`)))
+			Expect(RetryConfig{Backoff: -1}.Validate()).To(MatchError(ContainSubstring("backoff must not be negative")))
+			Expect(RetryConfig{MaxBackoff: -1}.Validate()).To(MatchError(ContainSubstring("max_backoff must not be negative")))
+			Expect(RetryConfig{MaxBodySize: -1}.Validate()).To(MatchError(ContainSubstring("max_body_size")))
+			Expect(RetryConfig{Backoff: 2 * time.Second}.Validate()).To(MatchError(ContainSubstring("must not exceed max_backoff")))
+			Expect(LoadBalancerControllerConfig{Path: "/api", Endpoints: []string{"http://localhost"}, Retry: &RetryConfig{MaxRetries: -1}}.Validate()).
+				To(MatchError(ContainSubstring("invalid retry configuration")))
+		})
+
+		It("should double the backoff up to the maximum", func() {
+			policy := newRetryPolicy(RetryConfig{Backoff: 10 * time.Millisecond, MaxBackoff: 25 * time.Millisecond})
+			start := time.Now()
+			Expect(policy.wait(GinkgoT().Context(), 3)).To(BeTrue())
+			Expect(time.Since(start)).To(BeNumerically(">=", 25*time.Millisecond))
+		})
+	})
+
+	It("should retry the requests failing on connection errors and retried statuses", func() {
+		var unavailableHits, okHits atomic.Int32
+		unavailable := backend(http.StatusServiceUnavailable, &unavailableHits)
+		ok := backend(http.StatusOK, &okHits)
+		retried := backendRetries.WithLabelValues("", unavailable.URL, "503")
+		before := testutil.ToFloat64(retried)
+
+		recorder := serve(&RetryConfig{Backoff: time.Millisecond}, []string{unreachable(), unavailable.URL, ok.URL}, http.MethodPut, "order")
+		Expect(recorder.Code).To(Equal(http.StatusOK))
+		Expect(recorder.Body.String()).To(Equal("OK order"))
+		Expect(unavailableHits.Load()).To(Equal(int32(1)))
+		Expect(okHits.Load()).To(Equal(int32(1)))
+		Expect(testutil.ToFloat64(retried) - before).To(Equal(1.0))
+	})
+
+	It("should send the last response once the endpoints or the retries are exhausted", func() {
+		var firstHits, secondHits, thirdHits atomic.Int32
+		first := backend(http.StatusServiceUnavailable, &firstHits)
+		second := backend(http.StatusBadGateway, &secondHits)
+		recorder := serve(&RetryConfig{Backoff: time.Millisecond}, []string{first.URL, second.URL}, http.MethodGet, "")
+		Expect(recorder.Code).To(Equal(http.StatusBadGateway))
+		Expect(firstHits.Load() + secondHits.Load()).To(Equal(int32(2)))
+
+		By("limiting the retries")
+		firstHits.Store(0)
+		secondHits.Store(0)
+		third := backend(http.StatusOK, &thirdHits)
+		recorder = serve(&RetryConfig{MaxRetries: 1, Backoff: time.Millisecond}, []string{first.URL, second.URL, third.URL}, http.MethodGet, "")
+		Expect(recorder.Code).To(Equal(http.StatusBadGateway))
+		Expect(thirdHits.Load()).To(BeZero())
+
+		By("answering 502 when no endpoint is reachable")
+		recorder = serve(&RetryConfig{Backoff: time.Millisecond}, []string{unreachable(), unreachable()}, http.MethodGet, "")
+		Expect(recorder.Code).To(Equal(http.StatusBadGateway))
+	})
+
+	It("should not retry the other statuses, methods and large bodies", func() {
+		var hits, okHits atomic.Int32
+		failing := backend(http.StatusInternalServerError, &hits)
+		ok := backend(http.StatusOK, &okHits)
+		Expect(serve(&RetryConfig{}, []string{failing.URL, ok.URL}, http.MethodGet, "").Code).To(Equal(http.StatusInternalServerError))
+
+		unavailable := backend(http.StatusServiceUnavailable, &hits)
+		Expect(serve(&RetryConfig{}, []string{unavailable.URL, ok.URL}, http.MethodPost, "order").Code).To(Equal(http.StatusServiceUnavailable))
+
+		recorder := serve(&RetryConfig{MaxBodySize: 4}, []string{unavailable.URL, ok.URL}, http.MethodPut, "large order")
+		Expect(recorder.Code).To(Equal(http.StatusServiceUnavailable))
+		Expect(recorder.Body.String()).To(Equal("Service Unavailable large order"))
+		Expect(okHits.Load()).To(BeZero())
+
+		By("retrying the configured methods")
+		recorder = serve(&RetryConfig{Methods: []string{http.MethodPost}, Backoff: time.Millisecond}, []string{unavailable.URL, ok.URL}, http.MethodPost, "order")
+		Expect(recorder.Code).To(Equal(http.StatusOK))
+		Expect(recorder.Body.String()).To(Equal("OK order"))
+	})
+})
@@ -0,0 +1,154 @@
+package controller
+
+import (
+	"net/http"
+	"net/url"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+)
+
+// EndpointRouteConfig sends the requests matching all its conditions to a subset of endpoints,
+// e.g. /api/v2 to a new cluster, or bounds them with another timeout. At least one condition must
+// be set.
+type EndpointRouteConfig struct {
+	// Methods are the methods of the matching requests, e.g. [POST, PUT].
+	Methods []string `yaml:"methods,omitempty"`
+	// Path is a regular expression matched against the request path, e.g. ^/api/v2/.
+	Path string `yaml:"path,omitempty"`
+	// Headers are regular expressions matched against the values of the request headers, e.g.
+	// X-Beta: "^(1|true)$". Requests without the header do not match.
+	Headers map[string]string `yaml:"headers,omitempty"`
+	// Endpoints are the endpoints of the matching requests, balanced round-robin. Defaults to the
+	// endpoints of the load balancer.
+	Endpoints []string `yaml:"endpoints,omitempty"`
+	// Timeout bounds the matching requests instead of the timeout of the load balancer.
+	Timeout time.Duration `yaml:"timeout,omitempty"`
+}
+
+func (r EndpointRouteConfig) Validate() error {
+	if len(r.Methods) == 0 && r.Path == "" && len(r.Headers) == 0 {
+		return errors.New("at least one of methods, path or headers must be set")
+	}
+	if len(r.Endpoints) == 0 && r.Timeout == 0 {
+		return errors.New("at least one endpoint or a timeout must be provided")
+	}
+	if r.Timeout < 0 {
+		return errors.New("timeout must not be negative")
+	}
+	for _, endpoint := range r.Endpoints {
+		if _, err := url.ParseRequestURI(endpoint); err != nil {
+			return errors.Wrapf(err, "invalid endpoint URL: %s", endpoint)
+		}
+	}
+	for _, method := range r.Methods {
+		if method == "" || method != strings.ToUpper(method) {
+			return errors.Errorf("invalid method %q, methods must be uppercase", method)
+		}
+	}
+	_, err := newEndpointRoute(r, nil)
+	return err
+}
+
+// endpointRoute is a compiled EndpointRouteConfig
+type endpointRoute struct {
+	methods []string
+	path    *regexp.Regexp // Nil if any path matches
+	headers map[string]*regexp.Regexp
+	// indices are the positions of the endpoints of the route in the endpoints of the load
+	// balancer, empty if the route uses the default endpoints
+	indices []int
+	next    atomic.Uint64
+	timeout time.Duration
+}
+
+func newEndpointRoute(r EndpointRouteConfig, indices []int) (*endpointRoute, error) {
+	route := &endpointRoute{methods: r.Methods, indices: indices, timeout: r.Timeout}
+	if r.Path != "" {
+		compiled, err := regexp.Compile(r.Path)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid path expression %q", r.Path)
+		}
+		route.path = compiled
+	}
+	for name, expression := range r.Headers {
+		if name == "" {
+			return nil, errors.New("headers must not contain empty names")
+		}
+		compiled, err := regexp.Compile(expression)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid expression of header %s", name)
+		}
+		if route.headers == nil {
+			route.headers = make(map[string]*regexp.Regexp, len(r.Headers))
+		}
+		route.headers[http.CanonicalHeaderKey(name)] = compiled
+	}
+	return route, nil
+}
+
+// matches tells whether the request meets every condition of the route
+func (r *endpointRoute) matches(request *http.Request) bool {
+	if len(r.methods) > 0 && !slices.Contains(r.methods, request.Method) {
+		return false
+	}
+	if r.path != nil && !r.path.MatchString(request.URL.Path) {
+		return false
+	}
+	for name, expression := range r.headers {
+		if !slices.ContainsFunc(request.Header.Values(name), expression.MatchString) {
+			return false
+		}
+	}
+	return true
+}
+
+// nextEndpoint returns the index of the next endpoint of the route in the load balancer endpoints
+func (r *endpointRoute) nextEndpoint() int {
+	return r.indices[(r.next.Add(1)-1)%uint64(len(r.indices))]
+}
+
+// endpointIndices returns the positions of the endpoints in the list, appending the missing ones,
+// so that the routes sharing endpoints share their concurrency limits
+func endpointIndices(endpoints []url.URL, routeEndpoints []string) ([]url.URL, []int, error) {
+	indices := make([]int, 0, len(routeEndpoints))
+	for _, endpoint := range routeEndpoints {
+		u, err := url.ParseRequestURI(endpoint)
+		if err != nil {
+			return nil, nil, errors.Wrapf(err, "invalid endpoint URL: %s", endpoint)
+		}
+		index := slices.IndexFunc(endpoints, func(existing url.URL) bool { return existing.String() == u.String() })
+		if index < 0 {
+			endpoints = append(endpoints, *u)
+			index = len(endpoints) - 1
+		}
+		indices = append(indices, index)
+	}
+	return endpoints, indices, nil
+}
+
+// route returns the first route matching the request and its position, or nil and -1 for the
+// default endpoints
+func (l *loadBalancer) route(c *gin.Context) (*endpointRoute, int) {
+	for i, route := range l.routes {
+		if route.matches(c.Request) {
+			return route, i
+		}
+	}
+	return nil, -1
+}
+
+// routeKey suffixes the cache keys of the requests sent to the endpoints of a route, as their
+// responses may differ from the responses of the default endpoints for the same URL
+func routeKey(index int) string {
+	if index < 0 {
+		return ""
+	}
+	return "\x00route" + strconv.Itoa(index)
+}
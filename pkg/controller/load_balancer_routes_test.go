@@ -0,0 +1,119 @@
+//go:build unit
+
+package controller
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	"github.com/animalet/sargantana-go/pkg/server"
+	"github.com/gin-gonic/gin"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Load balancer routes", func() {
+	var backends map[string]*httptest.Server
+
+	BeforeEach(func() {
+		backends = make(map[string]*httptest.Server)
+		for _, name := range []string{"old", "new-1", "new-2", "writer"} {
+			backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Cache-Control", "max-age=60")
+				_, _ = w.Write([]byte(name))
+			}))
+			DeferCleanup(backend.Close)
+			backends[name] = backend
+		}
+	})
+
+	// proxy creates a load balancer sending the requests to the old backend by default
+	proxy := func(cfg LoadBalancerControllerConfig) *gin.Engine {
+		cfg.Path = "/api"
+		cfg.Endpoints = []string{backends["old"].URL}
+		Expect(cfg.Validate()).To(Succeed())
+		ctrl, err := NewLoadBalancerController(&cfg, server.ControllerContext{})
+		Expect(err).NotTo(HaveOccurred())
+		engine := gin.New()
+		Expect(ctrl.Bind(engine, nil)).To(Succeed())
+		return engine
+	}
+
+	// send returns the name of the backend that answered the request
+	send := func(engine *gin.Engine, method, path string, header http.Header) string {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(method, path, nil)
+		for name, values := range header {
+			req.Header[name] = values
+		}
+		engine.ServeHTTP(w, req)
+		Expect(w.Code).To(Equal(http.StatusOK))
+		return w.Body.String()
+	}
+
+	It("should validate the routes", func() {
+		Expect(EndpointRouteConfig{Endpoints: []string{"http://new"}}.Validate()).To(MatchError(ContainSubstring("at least one of methods, path or headers")))
+		Expect(EndpointRouteConfig{Path: "^/v2/"}.Validate()).To(MatchError(ContainSubstring("at least one endpoint")))
+		Expect(EndpointRouteConfig{Path: "^/v2/", Endpoints: []string{"new"}}.Validate()).To(MatchError(ContainSubstring("invalid endpoint URL")))
+		Expect(EndpointRouteConfig{Methods: []string{"post"}, Endpoints: []string{"http://new"}}.Validate()).To(MatchError(ContainSubstring("uppercase")))
+		Expect(EndpointRouteConfig{Path: "(", Endpoints: []string{"http://new"}}.Validate()).To(MatchError(ContainSubstring("invalid path expression")))
+		Expect(EndpointRouteConfig{Headers: map[string]string{"X-Beta": "("}, Endpoints: []string{"http://new"}}.Validate()).
+			To(MatchError(ContainSubstring("invalid expression of header X-Beta")))
+		Expect(EndpointRouteConfig{Path: "^/v2/", Headers: map[string]string{"X-Beta": "1"}, Endpoints: []string{"http://new"}}.Validate()).To(Succeed())
+
+		cfg := LoadBalancerControllerConfig{
+			Path:      "/php",
+			Endpoints: []string{"tcp://localhost:9000"},
+			FastCGI:   &FastCGIConfig{ScriptRoot: "/srv"},
+			Routes:    []EndpointRouteConfig{{Path: "^/php/admin/", Endpoints: []string{"http://localhost:8080"}}},
+		}
+		Expect(cfg.Validate()).To(MatchError(ContainSubstring("must be a tcp:// or unix:// address")))
+		cfg.Routes = []EndpointRouteConfig{{Path: "("}}
+		Expect(cfg.Validate()).To(MatchError(ContainSubstring("invalid route 0")))
+	})
+
+	It("should send the requests to the endpoints of the first matching route", func() {
+		engine := proxy(LoadBalancerControllerConfig{Routes: []EndpointRouteConfig{
+			{Methods: []string{http.MethodPost, http.MethodPut}, Endpoints: []string{backends["writer"].URL}},
+			{Path: "^/api/v2/", Endpoints: []string{backends["new-1"].URL, backends["new-2"].URL}},
+			{Headers: map[string]string{"X-Beta": "^(1|true)$"}, Endpoints: []string{backends["new-1"].URL}},
+		}})
+
+		Expect(send(engine, http.MethodGet, "/api/v1/orders", nil)).To(Equal("old"))
+		Expect(send(engine, http.MethodPost, "/api/v2/orders", nil)).To(Equal("writer"))
+		Expect(send(engine, http.MethodGet, "/api/v2/orders", nil)).To(Equal("new-1"))
+		Expect(send(engine, http.MethodGet, "/api/v2/orders", nil)).To(Equal("new-2"))
+		Expect(send(engine, http.MethodGet, "/api/v2/orders", nil)).To(Equal("new-1"))
+		Expect(send(engine, http.MethodGet, "/api/v1/orders", http.Header{"X-Beta": {"true"}})).To(Equal("new-1"))
+		Expect(send(engine, http.MethodGet, "/api/v1/orders", http.Header{"X-Beta": {"no"}})).To(Equal("old"))
+	})
+
+	It("should share the endpoints listed by several routes", func() {
+		cfg := LoadBalancerControllerConfig{
+			Path:      "/api",
+			Endpoints: []string{backends["old"].URL},
+			Routes: []EndpointRouteConfig{
+				{Path: "^/api/v2/", Endpoints: []string{backends["new-1"].URL, backends["old"].URL}},
+				{Methods: []string{http.MethodDelete}, Endpoints: []string{backends["new-1"].URL}},
+			},
+		}
+		ctrl, err := NewLoadBalancerController(&cfg, server.ControllerContext{})
+		Expect(err).NotTo(HaveOccurred())
+		lb := ctrl.(*loadBalancer)
+		Expect(lb.endpoints).To(HaveLen(2))
+		Expect(lb.defaultCount).To(Equal(1))
+		Expect(lb.routes[0].indices).To(Equal([]int{1, 0}))
+		Expect(lb.routes[1].indices).To(Equal([]int{1}))
+	})
+
+	It("should cache the responses of the routes apart", func() {
+		engine := proxy(LoadBalancerControllerConfig{
+			Cache:  &ResponseCacheConfig{TTL: time.Minute},
+			Routes: []EndpointRouteConfig{{Headers: map[string]string{"X-Beta": "1"}, Endpoints: []string{backends["new-1"].URL}}},
+		})
+		Expect(send(engine, http.MethodGet, "/api/orders", nil)).To(Equal("old"))
+		Expect(send(engine, http.MethodGet, "/api/orders", http.Header{"X-Beta": {"1"}})).To(Equal("new-1"))
+		Expect(send(engine, http.MethodGet, "/api/orders", nil)).To(Equal("old"))
+	})
+})
@@ -0,0 +1,97 @@
+package controller
+
+import (
+	"io"
+	"mime"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const copyBufferSize = 32 * 1024
+
+// defaultStreamingTypes are the content types of the responses flushed while they are copied
+var defaultStreamingTypes = []string{"text/event-stream", "application/x-ndjson", "application/stream+json"}
+
+// copyBuffers are the buffers responses are copied with, shared by all load balancers
+var copyBuffers = sync.Pool{
+	New: func() any {
+		buffer := make([]byte, copyBufferSize)
+		return &buffer
+	},
+}
+
+// copyResponse copies a response body to the client with a pooled buffer, flushing the
+// responses of streaming content types so that clients receive the events as they come.
+func (l *loadBalancer) copyResponse(c *gin.Context, body io.Reader, contentType string) error {
+	buffer := copyBuffers.Get().(*[]byte)
+	defer copyBuffers.Put(buffer)
+
+	var dst io.Writer = c.Writer
+	if l.streams(contentType) {
+		flusher := &flushWriter{writer: c.Writer, interval: l.flushInterval}
+		defer flusher.stop()
+		dst = flusher
+	}
+	_, err := io.CopyBuffer(dst, body, *buffer)
+	return err
+}
+
+// streams tells whether responses of the content type are flushed while they are copied
+func (l *loadBalancer) streams(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	for _, streamingType := range l.streamingTypes {
+		if strings.EqualFold(mediaType, streamingType) {
+			return true
+		}
+	}
+	return false
+}
+
+// flushWriter flushes the response after every write, or at most every interval if positive
+type flushWriter struct {
+	mu       sync.Mutex
+	writer   gin.ResponseWriter
+	interval time.Duration
+	timer    *time.Timer // Pending flush, nil if none
+}
+
+func (f *flushWriter) Write(b []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	n, err := f.writer.Write(b)
+	if err != nil {
+		return n, err
+	}
+	if f.interval <= 0 {
+		f.writer.Flush()
+	} else if f.timer == nil {
+		f.timer = time.AfterFunc(f.interval, f.flush)
+	}
+	return n, nil
+}
+
+func (f *flushWriter) flush() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.timer != nil {
+		f.timer = nil
+		f.writer.Flush()
+	}
+}
+
+// stop flushes the pending writes, once the copy is over
+func (f *flushWriter) stop() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.timer != nil {
+		f.timer.Stop()
+		f.timer = nil
+		f.writer.Flush()
+	}
+}
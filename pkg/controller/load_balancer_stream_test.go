@@ -0,0 +1,99 @@
+//go:build unit
+
+package controller
+
+import (
+	"bufio"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	"github.com/animalet/sargantana-go/pkg/server"
+	"github.com/gin-gonic/gin"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Load balancer streaming", func() {
+	var (
+		backend *httptest.Server
+		release chan struct{}
+	)
+
+	BeforeEach(func() {
+		release = make(chan struct{})
+		backend = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", r.URL.Query().Get("type"))
+			_, _ = w.Write([]byte("data: first\n\n"))
+			w.(http.Flusher).Flush()
+			<-release
+			_, _ = w.Write([]byte("data: last\n\n"))
+		}))
+		DeferCleanup(backend.Close)
+		// Cleanups run in reverse order: unblock the backend before closing it, also when a spec fails
+		DeferCleanup(func() {
+			select {
+			case <-release:
+			default:
+				close(release)
+			}
+		})
+	})
+
+	// proxy serves the load balancer and returns its URL
+	proxy := func(cfg LoadBalancerControllerConfig) string {
+		cfg.Path = "/events"
+		cfg.Endpoints = []string{backend.URL}
+		Expect(cfg.Validate()).To(Succeed())
+		ctrl, err := NewLoadBalancerController(&cfg, server.ControllerContext{})
+		Expect(err).NotTo(HaveOccurred())
+		engine := gin.New()
+		Expect(ctrl.Bind(engine, nil)).To(Succeed())
+		frontend := httptest.NewServer(engine)
+		DeferCleanup(frontend.Close)
+		return frontend.URL
+	}
+
+	// firstLine returns a channel receiving the first line of the response
+	firstLine := func(url string) chan string {
+		lines := make(chan string, 1)
+		go func() {
+			defer GinkgoRecover()
+			res, err := http.Get(url)
+			Expect(err).NotTo(HaveOccurred())
+			defer func() { _ = res.Body.Close() }()
+			line, _ := bufio.NewReader(res.Body).ReadString('\n')
+			lines <- line
+		}()
+		return lines
+	}
+
+	It("should validate the streaming configuration", func() {
+		cfg := LoadBalancerControllerConfig{Path: "/events", Endpoints: []string{"http://localhost"}, FlushInterval: -time.Second}
+		Expect(cfg.Validate()).To(MatchError(ContainSubstring("flush_interval")))
+		cfg = LoadBalancerControllerConfig{Path: "/events", Endpoints: []string{"http://localhost"}, StreamingTypes: []string{"text/"}}
+		Expect(cfg.Validate()).To(MatchError(ContainSubstring("invalid streaming type")))
+	})
+
+	It("should flush server-sent events as they come", func() {
+		url := proxy(LoadBalancerControllerConfig{})
+		lines := firstLine(url + "/events/feed?type=text/event-stream")
+		Eventually(lines).Should(Receive(Equal("data: first\n")))
+		close(release)
+	})
+
+	It("should flush configured streaming types on the flush interval", func() {
+		url := proxy(LoadBalancerControllerConfig{StreamingTypes: []string{"application/x-progress"}, FlushInterval: 20 * time.Millisecond})
+		lines := firstLine(url + "/events/feed?type=application/x-progress%3B+charset=utf-8")
+		Eventually(lines).Should(Receive(Equal("data: first\n")))
+		close(release)
+	})
+
+	It("should not flush other content types before the end of the response", func() {
+		url := proxy(LoadBalancerControllerConfig{})
+		lines := firstLine(url + "/events/feed?type=text/plain")
+		Consistently(lines, 200*time.Millisecond).ShouldNot(Receive())
+		close(release)
+		Eventually(lines).Should(Receive(Equal("data: first\n")))
+	})
+})
@@ -261,6 +261,45 @@ endpoints:
 		})
 	})
 
+	Context("StartupCheck", func() {
+		It("should report the reachability of each endpoint", func() {
+			backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+			DeferCleanup(backend.Close)
+			closed := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+			closed.Close()
+
+			ctrl, err := NewLoadBalancerController(&LoadBalancerControllerConfig{
+				Path:      "/api",
+				Endpoints: []string{backend.URL, closed.URL},
+			}, server.ControllerContext{})
+			Expect(err).NotTo(HaveOccurred())
+
+			results := ctrl.(server.StartupChecker).StartupCheck(context.Background())
+			Expect(results).To(HaveLen(2))
+			Expect(results[0].Name).To(Equal("endpoint " + backend.URL))
+			Expect(results[0].Err).NotTo(HaveOccurred())
+			Expect(results[1].Name).To(Equal("endpoint " + closed.URL))
+			Expect(results[1].Err).To(MatchError(ContainSubstring("is unreachable")))
+		})
+
+		It("should be healthy while an endpoint is reachable", func() {
+			backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+			closed := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+			closed.Close()
+
+			ctrl, err := NewLoadBalancerController(&LoadBalancerControllerConfig{
+				Path:      "/api",
+				Endpoints: []string{closed.URL, backend.URL},
+			}, server.ControllerContext{})
+			Expect(err).NotTo(HaveOccurred())
+			reporter := ctrl.(server.HealthReporter)
+			Expect(reporter.HealthCheck(context.Background())).To(Succeed())
+
+			backend.Close()
+			Expect(reporter.HealthCheck(context.Background())).To(MatchError(ContainSubstring("no endpoint is reachable")))
+		})
+	})
+
 	Context("LoadBalancerController Integration", func() {
 		var (
 			backend1 *httptest.Server
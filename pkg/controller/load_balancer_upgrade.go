@@ -0,0 +1,174 @@
+package controller
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/animalet/sargantana-go/pkg/server"
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+)
+
+// defaultUpgrades are the protocols of the Upgrade requests passed through to the endpoints
+var defaultUpgrades = []string{"websocket"}
+
+// upgradeProtocol returns the protocol a request asks to switch to with the Connection: Upgrade
+// and Upgrade headers, or an empty string if it does not
+func upgradeProtocol(header http.Header) string {
+	for _, value := range header.Values("Connection") {
+		for _, token := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(token), "upgrade") {
+				return strings.ToLower(strings.TrimSpace(header.Get("Upgrade")))
+			}
+		}
+	}
+	return ""
+}
+
+// removeUpgrade removes the Upgrade header and the upgrade token of the Connection header, so that
+// the endpoint answers the request without switching protocols
+func removeUpgrade(header http.Header) {
+	header.Del("Upgrade")
+	var tokens []string
+	for _, value := range header.Values("Connection") {
+		for _, token := range strings.Split(value, ",") {
+			if token = strings.TrimSpace(token); token != "" && !strings.EqualFold(token, "upgrade") {
+				tokens = append(tokens, token)
+			}
+		}
+	}
+	header.Del("Connection")
+	if len(tokens) > 0 {
+		header.Set("Connection", strings.Join(tokens, ", "))
+	}
+}
+
+// upgrades tells whether the requests switching to the protocol are passed through. FastCGI
+// endpoints cannot switch protocols.
+func (l *loadBalancer) upgrades(protocol string) bool {
+	return protocol != "" && l.fastCGI == nil && slices.Contains(l.upgradeProtocols, protocol)
+}
+
+// forwardUpgrade passes an Upgrade request through to an endpoint and, once the endpoint switched
+// protocols, copies the data of the connections in both directions until either side closes its
+// connection. The timeout only bounds the handshake; upgraded connections are not retried, nor
+// counted by the concurrency limits.
+func (l *loadBalancer) forwardUpgrade(c *gin.Context, route *endpointRoute, protocol string) {
+	index := l.pickRouteEndpoint(route)
+	if index < 0 {
+		l.rejectOpenCircuits(c)
+		return
+	}
+	endpoint := l.endpoints[index]
+	ctx, cancel := l.upstreamContext(c, route)
+	defer cancel()
+	request, err := l.newUpstreamRequest(ctx, c, endpoint)
+	if err != nil {
+		l.recordOutcome(index, outcomeIgnored)
+		_ = c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	// The deadline of the handshake does not apply to the upgraded connection
+	if l.deadlineHeader != "" {
+		request.Header.Del(l.deadlineHeader)
+	}
+	if l.curl != nil {
+		l.curl.log(c, request)
+	}
+
+	observed := observeBackend(c, endpoint)
+	response, err := l.httpClient.Do(request)
+	observed(response, err)
+	if l.breakers != nil {
+		breaker := l.breakers[index]
+		breaker.record(breaker.outcome(response, err, server.ClientClosed(c)))
+	}
+	if err != nil {
+		abortUpstreamError(c, err)
+		return
+	}
+	for k, v := range response.Header {
+		if strings.EqualFold(k, "Set-Cookie") {
+			continue // avoid leaking backend cookies
+		}
+		for _, vv := range v {
+			c.Writer.Header().Add(k, vv)
+		}
+	}
+	if response.StatusCode != http.StatusSwitchingProtocols {
+		// The endpoint declined the upgrade, its response is sent as is
+		defer func() { _ = response.Body.Close() }()
+		c.Status(response.StatusCode)
+		if err := l.copyResponse(c, response.Body, response.Header.Get("Content-Type")); err != nil {
+			log.Debug().Err(err).Msg("Error copying response body")
+		}
+		return
+	}
+	backend, ok := response.Body.(io.ReadWriteCloser)
+	if !ok {
+		_ = response.Body.Close()
+		_ = c.AbortWithError(http.StatusBadGateway, errors.New("the endpoint connection cannot be upgraded"))
+		return
+	}
+	defer func() { _ = backend.Close() }()
+	if switched := upgradeProtocol(response.Header); switched != protocol {
+		_ = c.AbortWithError(http.StatusBadGateway, errors.Errorf("the endpoint switched to protocol %q instead of %q", switched, protocol))
+		return
+	}
+
+	c.Writer.WriteHeader(http.StatusSwitchingProtocols)
+	client, buffered, err := c.Writer.Hijack()
+	if err != nil {
+		_ = c.AbortWithError(http.StatusInternalServerError, errors.Wrap(err, "the client connection cannot be upgraded"))
+		return
+	}
+	defer func() { _ = client.Close() }()
+	// Clears the read and write timeouts of the server, which would end the upgraded connection
+	_ = client.SetDeadline(time.Time{})
+	// The response switching protocols is written on the hijacked connection
+	response.Header, response.Body = c.Writer.Header(), nil
+	if err := response.Write(buffered); err != nil {
+		log.Debug().Err(err).Msg("Failed to write the response switching protocols")
+		return
+	}
+	if err := buffered.Flush(); err != nil {
+		log.Debug().Err(err).Msg("Failed to write the response switching protocols")
+		return
+	}
+
+	logger := server.RequestLogger(c)
+	logger.Debug().Str("endpoint", endpoint.Redacted()).Str("protocol", protocol).Msg("Load balancing connection upgraded")
+	upgraded := backendUpgradedConnections.WithLabelValues(server.ControllerName(c), endpoint.Redacted())
+	upgraded.Inc()
+	defer upgraded.Dec()
+	err = pipeConnections(client, buffered.Reader, backend)
+	logger.Debug().Err(err).Str("endpoint", endpoint.Redacted()).Msg("Load balancing upgraded connection closed")
+}
+
+// pipeConnections copies the data of the client to the backend and back until either side closes
+// its connection or fails, then closes both connections. The client data is read from reader,
+// which may hold data buffered before the upgrade.
+func pipeConnections(client net.Conn, reader io.Reader, backend io.ReadWriteCloser) error {
+	errs := make(chan error, 2)
+	pipe := func(dst io.Writer, src io.Reader) {
+		buffer := copyBuffers.Get().(*[]byte)
+		defer copyBuffers.Put(buffer)
+		_, err := io.CopyBuffer(dst, src, *buffer)
+		errs <- err
+	}
+	go pipe(backend, reader)
+	go pipe(client, backend)
+	err := <-errs
+	_ = client.Close()
+	_ = backend.Close()
+	<-errs
+	if errors.Is(err, net.ErrClosed) {
+		return nil
+	}
+	return err
+}
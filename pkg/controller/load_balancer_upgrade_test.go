@@ -0,0 +1,154 @@
+//go:build unit
+
+package controller
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/animalet/sargantana-go/pkg/server"
+	"github.com/gin-gonic/gin"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+var _ = Describe("Load balancer upgrades", func() {
+	var (
+		backend  *httptest.Server
+		mu       sync.Mutex
+		received http.Header // Headers of the last request of the backend
+	)
+
+	BeforeEach(func() {
+		backend = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			mu.Lock()
+			received = r.Header.Clone()
+			mu.Unlock()
+			if upgradeProtocol(r.Header) != "websocket" {
+				w.WriteHeader(http.StatusUpgradeRequired)
+				_, _ = w.Write([]byte("websocket required"))
+				return
+			}
+			conn, rw, err := w.(http.Hijacker).Hijack()
+			if err != nil {
+				return
+			}
+			defer func() { _ = conn.Close() }()
+			_, _ = rw.WriteString("HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade\r\nUpgrade: websocket\r\n" +
+				"Sec-WebSocket-Accept: accepted-" + r.Header.Get("Sec-WebSocket-Key") + "\r\nSet-Cookie: backend=secret\r\n\r\n")
+			_ = rw.Flush()
+			// Echoes the lines until the client sends bye
+			for {
+				line, err := rw.ReadString('\n')
+				if err != nil || line == "bye\n" {
+					return
+				}
+				_, _ = rw.WriteString("echo: " + line)
+				_ = rw.Flush()
+			}
+		}))
+		DeferCleanup(backend.Close)
+	})
+
+	// proxy serves the load balancer and returns its address
+	proxy := func(cfg LoadBalancerControllerConfig) string {
+		cfg.Path = "/ws"
+		cfg.Endpoints = []string{backend.URL}
+		Expect(cfg.Validate()).To(Succeed())
+		ctrl, err := NewLoadBalancerController(&cfg, server.ControllerContext{})
+		Expect(err).NotTo(HaveOccurred())
+		engine := gin.New()
+		Expect(ctrl.Bind(engine, nil)).To(Succeed())
+		frontend := httptest.NewServer(engine)
+		DeferCleanup(frontend.Close)
+		return frontend.Listener.Addr().String()
+	}
+
+	// upgrade sends an Upgrade request to the address, and returns the connection and the response
+	upgrade := func(address, protocol string) (net.Conn, *bufio.Reader, *http.Response) {
+		conn, err := net.Dial("tcp", address)
+		Expect(err).NotTo(HaveOccurred())
+		DeferCleanup(func() { _ = conn.Close() })
+		_, err = conn.Write([]byte("GET /ws/chat HTTP/1.1\r\nHost: gateway\r\nConnection: keep-alive, Upgrade\r\nUpgrade: " + protocol + "\r\n" +
+			"Sec-WebSocket-Key: dGhlIHNhbXBsZQ==\r\nSec-WebSocket-Version: 13\r\nSec-WebSocket-Protocol: chat\r\n\r\n"))
+		Expect(err).NotTo(HaveOccurred())
+		reader := bufio.NewReader(conn)
+		response, err := http.ReadResponse(reader, nil)
+		Expect(err).NotTo(HaveOccurred())
+		return conn, reader, response
+	}
+
+	It("should validate the upgrade protocols", func() {
+		cfg := LoadBalancerControllerConfig{Path: "/ws", Endpoints: []string{"http://localhost"}, Upgrades: []string{"websocket", "h2c"}}
+		Expect(cfg.Validate()).To(Succeed())
+		cfg.Upgrades = []string{"websocket, h2c"}
+		Expect(cfg.Validate()).To(MatchError(ContainSubstring("invalid upgrade protocol")))
+		cfg.Upgrades = []string{""}
+		Expect(cfg.Validate()).To(MatchError(ContainSubstring("invalid upgrade protocol")))
+	})
+
+	It("should pass WebSocket connections through until either side closes", func() {
+		// The timeout only bounds the handshake
+		conn, reader, response := upgrade(proxy(LoadBalancerControllerConfig{Timeout: 50 * time.Millisecond}), "WebSocket")
+		Expect(response.StatusCode).To(Equal(http.StatusSwitchingProtocols))
+		Expect(response.Header.Get("Upgrade")).To(Equal("websocket"))
+		Expect(response.Header.Get("Sec-WebSocket-Accept")).To(Equal("accepted-dGhlIHNhbXBsZQ=="))
+		Expect(response.Header.Values("Set-Cookie")).To(BeEmpty())
+		mu.Lock()
+		Expect(received.Get("Sec-WebSocket-Key")).To(Equal("dGhlIHNhbXBsZQ=="))
+		Expect(received.Get("Sec-WebSocket-Version")).To(Equal("13"))
+		Expect(received.Get("Sec-WebSocket-Protocol")).To(Equal("chat"))
+		mu.Unlock()
+		upgraded := backendUpgradedConnections.WithLabelValues("", backend.URL)
+		Expect(testutil.ToFloat64(upgraded)).To(Equal(1.0))
+
+		time.Sleep(100 * time.Millisecond)
+		for _, message := range []string{"hello", "world"} {
+			_, err := conn.Write([]byte(message + "\n"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(reader.ReadString('\n')).To(Equal("echo: " + message + "\n"))
+		}
+
+		// The backend closes its connection after bye
+		_, err := conn.Write([]byte("bye\n"))
+		Expect(err).NotTo(HaveOccurred())
+		_, err = reader.ReadString('\n')
+		Expect(err).To(HaveOccurred())
+		Eventually(func() float64 { return testutil.ToFloat64(upgraded) }).Should(BeZero())
+	})
+
+	It("should close the backend connection when the client closes", func() {
+		conn, _, response := upgrade(proxy(LoadBalancerControllerConfig{}), "websocket")
+		Expect(response.StatusCode).To(Equal(http.StatusSwitchingProtocols))
+		upgraded := backendUpgradedConnections.WithLabelValues("", backend.URL)
+		Expect(testutil.ToFloat64(upgraded)).To(Equal(1.0))
+		Expect(conn.Close()).To(Succeed())
+		Eventually(func() float64 { return testutil.ToFloat64(upgraded) }).Should(BeZero())
+	})
+
+	It("should forward the other protocols without their Upgrade header", func() {
+		_, _, response := upgrade(proxy(LoadBalancerControllerConfig{}), "h2c")
+		Expect(response.StatusCode).To(Equal(http.StatusUpgradeRequired))
+		mu.Lock()
+		Expect(received.Get("Upgrade")).To(BeEmpty())
+		Expect(strings.ToLower(received.Get("Connection"))).NotTo(ContainSubstring("upgrade"))
+		mu.Unlock()
+	})
+
+	It("should send the response of the endpoints declining the upgrade", func() {
+		address := proxy(LoadBalancerControllerConfig{Upgrades: []string{"websocket", "custom"}})
+		_, _, response := upgrade(address, "custom")
+		Expect(response.StatusCode).To(Equal(http.StatusUpgradeRequired))
+		Expect(io.ReadAll(response.Body)).To(BeEquivalentTo("websocket required"))
+		mu.Lock()
+		Expect(received.Get("Upgrade")).To(Equal("custom"))
+		mu.Unlock()
+	})
+})
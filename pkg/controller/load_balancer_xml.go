@@ -0,0 +1,316 @@
+package controller
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"io"
+	"mime"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	"golang.org/x/text/encoding/htmlindex"
+)
+
+const (
+	soap11EnvelopeNamespace = "http://schemas.xmlsoap.org/soap/envelope/"
+	soap12EnvelopeNamespace = "http://www.w3.org/2003/05/soap-envelope"
+)
+
+// XMLConfig lets the load balancer front XML and SOAP upstreams. JSON requests can be converted to
+// XML, optionally wrapped in a SOAP envelope, and XML responses can be converted to JSON for clients
+// preferring it in their Accept header. XML and text responses are always delivered in UTF-8.
+//
+// JSON and XML are mapped as follows: the single member of the JSON object is the root element,
+// "@name" members are attributes, "#text" is the text of elements with attributes or children and
+// arrays are repeated elements. XML values are always converted to JSON strings.
+type XMLConfig struct {
+	// Charset is the encoding of the XML requests sent to the upstreams. Defaults to UTF-8.
+	Charset string `yaml:"charset,omitempty"`
+	// JSONRequests converts JSON request bodies to XML.
+	JSONRequests bool `yaml:"json_requests,omitempty"`
+	// JSONResponses converts XML responses to JSON when the client prefers application/json.
+	JSONResponses bool `yaml:"json_responses,omitempty"`
+	// SOAP wraps converted requests in a SOAP envelope and unwraps the SOAP body of converted
+	// responses.
+	SOAP *SOAPConfig `yaml:"soap,omitempty"`
+	// Hooks lists the XML hooks, registered with RegisterXMLHook, applied in order to XML requests
+	// and responses.
+	Hooks []string `yaml:"hooks,omitempty"`
+}
+
+// SOAPConfig configures the SOAP envelope of converted requests.
+type SOAPConfig struct {
+	// Version is either 1.1 (default) or 1.2.
+	Version string `yaml:"version,omitempty"`
+	// Action is sent in the SOAPAction header (1.1) or the action parameter of the Content-Type (1.2).
+	Action string `yaml:"action,omitempty"`
+	// Namespace is set as the default namespace of the operation element.
+	Namespace string `yaml:"namespace,omitempty"`
+}
+
+func (c XMLConfig) Validate() error {
+	if c.Charset != "" {
+		if _, err := lookupCharset(c.Charset); err != nil {
+			return err
+		}
+	}
+	if c.SOAP != nil {
+		if c.SOAP.Version != "" && c.SOAP.Version != "1.1" && c.SOAP.Version != "1.2" {
+			return errors.Errorf("unsupported soap version %q, must be 1.1 or 1.2", c.SOAP.Version)
+		}
+		if !c.JSONRequests && !c.JSONResponses {
+			return errors.New("soap requires json_requests or json_responses to be enabled")
+		}
+	}
+	for _, hook := range c.Hooks {
+		if hook == "" {
+			return errors.New("hook names must not be empty")
+		}
+	}
+	return nil
+}
+
+// xmlProxy applies an XMLConfig to proxied requests and responses.
+type xmlProxy struct {
+	config XMLConfig
+	hooks  []XMLHook
+}
+
+func newXMLProxy(c XMLConfig) (*xmlProxy, error) {
+	proxy := &xmlProxy{config: c}
+	for _, name := range c.Hooks {
+		hook, ok := lookupXMLHook(name)
+		if !ok {
+			return nil, errors.Errorf("unknown xml hook %q", name)
+		}
+		proxy.hooks = append(proxy.hooks, hook)
+	}
+	return proxy, nil
+}
+
+// wantsJSON tells whether the XML response should be converted to JSON for the client.
+func (p *xmlProxy) wantsJSON(c *gin.Context) bool {
+	return p.config.JSONResponses && prefersJSON(c.GetHeader("Accept"))
+}
+
+// prepareRequest converts JSON request bodies to XML and applies the request hooks to XML bodies.
+// Other requests are forwarded unchanged.
+func (p *xmlProxy) prepareRequest(c *gin.Context, request *http.Request) error {
+	if c.Request.Body == nil || c.Request.Body == http.NoBody {
+		return nil
+	}
+	mediaType, params, err := mime.ParseMediaType(c.GetHeader("Content-Type"))
+	if err != nil {
+		return nil
+	}
+	converted := p.config.JSONRequests && isJSONMediaType(mediaType)
+	if !converted && (!isXMLMediaType(mediaType) || len(p.hooks) == 0) {
+		return nil
+	}
+
+	raw, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		return errors.Wrap(err, "failed to read the request body")
+	}
+	var document *XMLNode
+	if converted {
+		if document, err = jsonToXML(raw); err != nil {
+			return err
+		}
+		if p.config.SOAP != nil {
+			document = p.envelope(document)
+		}
+	} else if document, err = ParseXML(bytes.NewReader(raw), params["charset"]); err != nil {
+		return err
+	}
+	for _, hook := range p.hooks {
+		if hook.Request != nil {
+			if err := hook.Request(c, document); err != nil {
+				return err
+			}
+		}
+	}
+
+	encoded, err := EncodeXML(document, p.config.Charset)
+	if err != nil {
+		return err
+	}
+	request.Body = io.NopCloser(bytes.NewReader(encoded))
+	request.ContentLength = int64(len(encoded))
+	request.GetBody = func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(encoded)), nil
+	}
+
+	charset := p.config.Charset
+	if charset == "" {
+		charset = "utf-8"
+	}
+	if converted {
+		mediaType = "application/xml"
+	}
+	contentType := map[string]string{"charset": charset}
+	if converted && p.config.SOAP != nil {
+		if p.config.SOAP.Version == "1.2" {
+			mediaType = "application/soap+xml"
+			if p.config.SOAP.Action != "" {
+				contentType["action"] = p.config.SOAP.Action
+			}
+		} else {
+			mediaType = "text/xml"
+			request.Header.Set("SOAPAction", strconv.Quote(p.config.SOAP.Action))
+		}
+	}
+	request.Header.Set("Content-Type", mime.FormatMediaType(mediaType, contentType))
+	return nil
+}
+
+// envelope wraps the operation element in a SOAP envelope.
+func (p *xmlProxy) envelope(operation *XMLNode) *XMLNode {
+	namespace := soap11EnvelopeNamespace
+	if p.config.SOAP.Version == "1.2" {
+		namespace = soap12EnvelopeNamespace
+	}
+	if p.config.SOAP.Namespace != "" {
+		operation.Attrs = append(operation.Attrs, xml.Attr{Name: xml.Name{Local: "xmlns"}, Value: p.config.SOAP.Namespace})
+	}
+	return &XMLNode{
+		Name:     xml.Name{Space: "soap", Local: "Envelope"},
+		Attrs:    []xml.Attr{{Name: xml.Name{Space: "xmlns", Local: "soap"}, Value: namespace}},
+		Children: []*XMLNode{{Name: xml.Name{Space: "soap", Local: "Body"}, Children: []*XMLNode{operation}}},
+	}
+}
+
+var xmlDeclarationEncoding = regexp.MustCompile(`^\s*<\?xml[^>]*encoding\s*=\s*["']([^"']+)["']`)
+
+// processResponse applies the response hooks to XML responses, converts them to JSON if wanted and
+// transcodes XML and text responses to UTF-8. It returns the new body, or nil if the response is
+// forwarded unchanged. Responses that cannot be parsed are forwarded unchanged.
+func (p *xmlProxy) processResponse(c *gin.Context, response *http.Response, wantsJSON bool) ([]byte, error) {
+	mediaType, params, err := mime.ParseMediaType(response.Header.Get("Content-Type"))
+	if err != nil {
+		return nil, nil
+	}
+	charset := params["charset"]
+	isXML := isXMLMediaType(mediaType)
+	if !isXML && (isUTF8(charset) || (!strings.HasPrefix(mediaType, "text/") && !isJSONMediaType(mediaType))) {
+		return nil, nil
+	}
+
+	raw, err := io.ReadAll(response.Body)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read the upstream response")
+	}
+	if !isXML {
+		enc, err := lookupCharset(charset)
+		if err != nil {
+			log.Warn().Err(err).Str("url", response.Request.URL.Redacted()).Msg("Upstream response could not be transcoded")
+			return raw, nil
+		}
+		decoded, err := enc.NewDecoder().Bytes(raw)
+		if err != nil {
+			log.Warn().Err(err).Str("url", response.Request.URL.Redacted()).Msg("Upstream response could not be transcoded")
+			return raw, nil
+		}
+		params["charset"] = "utf-8"
+		response.Header.Set("Content-Type", mime.FormatMediaType(mediaType, params))
+		response.Header.Del("Content-Length")
+		return decoded, nil
+	}
+
+	declared := ""
+	if match := xmlDeclarationEncoding.FindSubmatch(raw); match != nil {
+		declared = string(match[1])
+	}
+	if len(bytes.TrimSpace(raw)) == 0 || (!wantsJSON && len(p.hooks) == 0 && isUTF8(charset) && isUTF8(declared)) {
+		return raw, nil
+	}
+
+	document, err := ParseXML(bytes.NewReader(raw), charset)
+	if err != nil {
+		log.Warn().Err(err).Str("url", response.Request.URL.Redacted()).Msg("Upstream XML response could not be parsed")
+		return raw, nil
+	}
+	for _, hook := range p.hooks {
+		if hook.Response != nil {
+			if err := hook.Response(c, document); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	response.Header.Del("Content-Length")
+	if !wantsJSON {
+		encoded, err := EncodeXML(document, "")
+		if err != nil {
+			return nil, err
+		}
+		params["charset"] = "utf-8"
+		response.Header.Set("Content-Type", mime.FormatMediaType(mediaType, params))
+		return encoded, nil
+	}
+
+	if p.config.SOAP != nil && document.Name.Local == "Envelope" {
+		if body := document.Child("Body"); body != nil && len(body.Children) == 1 {
+			document = body.Children[0]
+		}
+	}
+	var output bytes.Buffer
+	encoder := json.NewEncoder(&output)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(map[string]any{document.Name.Local: xmlToJSON(document)}); err != nil {
+		return nil, err
+	}
+	response.Header.Set("Content-Type", "application/json; charset=utf-8")
+	return bytes.TrimSuffix(output.Bytes(), []byte("\n")), nil
+}
+
+// prefersJSON tells whether an Accept header ranks JSON above XML. Wildcards are ignored so that
+// clients not expressing a preference get the upstream XML.
+func prefersJSON(accept string) bool {
+	jsonQuality, xmlQuality := 0.0, 0.0
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		quality := 1.0
+		if q, ok := params["q"]; ok {
+			if quality, err = strconv.ParseFloat(q, 64); err != nil {
+				continue
+			}
+		}
+		switch {
+		case isJSONMediaType(mediaType):
+			jsonQuality = max(jsonQuality, quality)
+		case isXMLMediaType(mediaType):
+			xmlQuality = max(xmlQuality, quality)
+		}
+	}
+	return jsonQuality > 0 && jsonQuality > xmlQuality
+}
+
+func isJSONMediaType(mediaType string) bool {
+	return mediaType == "application/json" || strings.HasSuffix(mediaType, "+json")
+}
+
+func isXMLMediaType(mediaType string) bool {
+	return mediaType == "application/xml" || mediaType == "text/xml" || strings.HasSuffix(mediaType, "+xml")
+}
+
+func isUTF8(charset string) bool {
+	if charset == "" {
+		return true
+	}
+	enc, err := htmlindex.Get(charset)
+	if err != nil {
+		return false
+	}
+	name, _ := htmlindex.Name(enc)
+	return name == "utf-8"
+}
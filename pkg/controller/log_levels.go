@@ -0,0 +1,129 @@
+package controller
+
+import (
+	"net/http"
+
+	"github.com/animalet/sargantana-go/internal/snapshot"
+	"github.com/animalet/sargantana-go/pkg/logging"
+	"github.com/animalet/sargantana-go/pkg/openapi"
+	"github.com/animalet/sargantana-go/pkg/server"
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+)
+
+// LogLevelsControllerConfig configures the admin endpoint for runtime log level changes.
+type LogLevelsControllerConfig struct {
+	Path string `yaml:"path"`
+	Auth bool   `yaml:"auth"`
+}
+
+func (c LogLevelsControllerConfig) Validate() error {
+	if c.Path == "" {
+		return errors.New("path must be set and non-empty")
+	}
+	return nil
+}
+
+// Describe adds the routes of the controller to the OpenAPI document, see openapi.Describer.
+func (c LogLevelsControllerConfig) Describe(doc *openapi.Document, basePath string) {
+	tags := []string{"admin"}
+	levels := map[string]openapi.Response{
+		"200": {Description: "The log levels", Content: openapi.JSON(doc.Schema("LogLevels", logLevelsResponse{}))},
+	}
+	var errs map[string]openapi.Response
+	if c.Auth {
+		errs = openapi.Errors(http.StatusUnauthorized)
+	}
+	doc.Add(http.MethodGet, basePath, c.Path, openapi.Operation{
+		OperationID: "getLogLevels",
+		Summary:     "Returns the log levels",
+		Tags:        tags,
+		Responses:   openapi.With(levels, errs),
+	})
+	doc.Add(http.MethodPut, basePath, c.Path, openapi.Operation{
+		OperationID: "setLogLevel",
+		Summary:     "Changes the level of a module, or the default level without module",
+		Tags:        tags,
+		RequestBody: &openapi.RequestBody{Required: true, Content: openapi.JSON(doc.Schema("LogLevelRequest", logLevelRequest{}))},
+		Responses:   openapi.With(levels, openapi.With(errs, openapi.Errors(http.StatusBadRequest))),
+	})
+}
+
+// NewLogLevelsController creates a controller that reports the log levels on GET and changes
+// them on PUT with a JSON body like {"module": "api", "level": "debug"}. An empty module changes
+// the default level and an empty level removes a module override.
+func NewLogLevelsController(c *LogLevelsControllerConfig, _ server.ControllerContext) (server.IController, error) {
+	// Deep copy the config to enforce immutability
+	configCopy := snapshot.MustCopy(c)
+
+	log.Info().Str("path", configCopy.Path).Bool("auth", configCopy.Auth).Msg("Log levels endpoint configured")
+	if !configCopy.Auth {
+		log.Warn().Str("path", configCopy.Path).Msg("Log levels endpoint is not protected by authentication")
+	}
+
+	return &logLevels{
+		path: configCopy.Path,
+		auth: configCopy.Auth,
+	}, nil
+}
+
+type logLevels struct {
+	server.IController
+	path string
+	auth bool
+}
+
+type logLevelsResponse struct {
+	Default string            `json:"default"`
+	Modules map[string]string `json:"modules"`
+}
+
+type logLevelRequest struct {
+	Module string `json:"module"`
+	Level  string `json:"level"`
+}
+
+func (l *logLevels) Bind(engine *gin.Engine, loginMiddleware gin.HandlerFunc) error {
+	return l.BindGroup(&engine.RouterGroup, loginMiddleware)
+}
+
+func (l *logLevels) BindGroup(group *gin.RouterGroup, loginMiddleware gin.HandlerFunc) error {
+	if l.auth {
+		group.GET(l.path, loginMiddleware, l.get).PUT(l.path, loginMiddleware, l.set)
+	} else {
+		group.GET(l.path, l.get).PUT(l.path, l.set)
+	}
+	return nil
+}
+
+func (l *logLevels) Close() error {
+	return nil
+}
+
+func (l *logLevels) get(c *gin.Context) {
+	response := logLevelsResponse{Modules: make(map[string]string)}
+	for module, level := range logging.Levels() {
+		if module == "" {
+			response.Default = level
+		} else {
+			response.Modules[module] = level
+		}
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+func (l *logLevels) set(c *gin.Context) {
+	var request logLevelRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := logging.SetLevel(request.Module, request.Level); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	log.Info().Str("module", request.Module).Str("level", request.Level).Msg("Log level changed")
+	server.Audit(c, "log_level_changed", map[string]any{"module": request.Module, "level": request.Level})
+	l.get(c)
+}
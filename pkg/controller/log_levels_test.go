@@ -0,0 +1,77 @@
+//go:build unit
+
+package controller
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	"github.com/animalet/sargantana-go/pkg/logging"
+	"github.com/animalet/sargantana-go/pkg/server"
+	"github.com/gin-gonic/gin"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("LogLevelsController", func() {
+	var engine *gin.Engine
+
+	BeforeEach(func() {
+		gin.SetMode(gin.TestMode)
+		engine = gin.New()
+		DeferCleanup(func() {
+			Expect(logging.SetLevel("", "info")).To(Succeed())
+			Expect(logging.SetLevel("api", "")).To(Succeed())
+		})
+	})
+
+	request := func(method, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(method, "/admin/log-levels", strings.NewReader(body))
+		engine.ServeHTTP(w, req)
+		return w
+	}
+
+	It("should require a path", func() {
+		Expect(LogLevelsControllerConfig{}.Validate()).To(HaveOccurred())
+		Expect(LogLevelsControllerConfig{Path: "/admin/log-levels"}.Validate()).To(Succeed())
+	})
+
+	It("should report and change levels", func() {
+		ctrl, err := NewLogLevelsController(&LogLevelsControllerConfig{Path: "/admin/log-levels"}, server.ControllerContext{})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(ctrl.Bind(engine, nil)).To(Succeed())
+
+		w := request(http.MethodPut, `{"module": "api", "level": "debug"}`)
+		Expect(w.Code).To(Equal(http.StatusOK))
+
+		w = request(http.MethodGet, "")
+		Expect(w.Code).To(Equal(http.StatusOK))
+		var body logLevelsResponse
+		Expect(json.Unmarshal(w.Body.Bytes(), &body)).To(Succeed())
+		Expect(body.Default).To(Equal("info"))
+		Expect(body.Modules).To(HaveKeyWithValue("api", "debug"))
+	})
+
+	It("should reject invalid requests", func() {
+		ctrl, err := NewLogLevelsController(&LogLevelsControllerConfig{Path: "/admin/log-levels"}, server.ControllerContext{})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(ctrl.Bind(engine, nil)).To(Succeed())
+
+		Expect(request(http.MethodPut, `{"level": "loud"}`).Code).To(Equal(http.StatusBadRequest))
+		Expect(request(http.MethodPut, `not json`).Code).To(Equal(http.StatusBadRequest))
+	})
+
+	It("should protect the endpoint when auth is enabled", func() {
+		ctrl, err := NewLogLevelsController(&LogLevelsControllerConfig{Path: "/admin/log-levels", Auth: true}, server.ControllerContext{})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(ctrl.Bind(engine, func(c *gin.Context) {
+			c.AbortWithStatus(http.StatusUnauthorized)
+		})).To(Succeed())
+
+		Expect(request(http.MethodGet, "").Code).To(Equal(http.StatusUnauthorized))
+		Expect(request(http.MethodPut, `{"level": "debug"}`).Code).To(Equal(http.StatusUnauthorized))
+	})
+})
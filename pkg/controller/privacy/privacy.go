@@ -0,0 +1,440 @@
+// Package privacy lets the signed-in users export their personal data and request its deletion,
+// as required by data protection regulations such as the GDPR. The data is kept by Sources, e.g. the
+// profiles or the CMS posts, registered by the application with RegisterSource. Deletion requests
+// are erased source by source once their retention ends, and kept in a Store, PostgreSQL or SQLite,
+// as the audit trail of their fulfillment:
+//
+//	privacy.RegisterSource("profile", profileSource)
+//	server.RegisterController("privacy", privacy.NewController(privacy.NewPostgresStore(pool)))
+package privacy
+
+import (
+	"context"
+	"maps"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/animalet/sargantana-go/internal/snapshot"
+	"github.com/animalet/sargantana-go/pkg/logging"
+	"github.com/animalet/sargantana-go/pkg/server"
+	"github.com/animalet/sargantana-go/pkg/sessionsx"
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	defaultInterval  = 10 * time.Minute
+	dueBatchSize     = 100
+	migrationTimeout = time.Minute
+	erasureTimeout   = time.Minute
+)
+
+// Source keeps personal data of the users.
+type Source interface {
+	// Export returns the data of a user, encoded as JSON in the exports. Nil if there is none.
+	Export(ctx context.Context, user string) (any, error)
+	// Delete erases the data of a user. Deleting missing data must succeed, as erasures are retried.
+	Delete(ctx context.Context, user string) error
+}
+
+var (
+	sourcesMu sync.RWMutex
+	sources   = make(map[string]Source)
+)
+
+// RegisterSource makes the data of a source part of the exports and the deletion requests.
+func RegisterSource(name string, source Source) {
+	log.Info().Msgf("Registering personal data source %q", name)
+	sourcesMu.Lock()
+	defer sourcesMu.Unlock()
+	if _, exists := sources[name]; exists {
+		log.Warn().Msgf("Personal data source %q is already registered, overriding", name)
+	}
+	sources[name] = source
+}
+
+// SourceNames returns the names of the registered sources, sorted.
+func SourceNames() []string {
+	sourcesMu.RLock()
+	defer sourcesMu.RUnlock()
+	return slices.Sorted(maps.Keys(sources))
+}
+
+func lookupSource(name string) (Source, bool) {
+	sourcesMu.RLock()
+	defer sourcesMu.RUnlock()
+	source, ok := sources[name]
+	return source, ok
+}
+
+// Export returns the data of a user kept by the registered sources, by source name. Sources without
+// data for the user are left out.
+func Export(ctx context.Context, user string) (map[string]any, error) {
+	data := make(map[string]any)
+	for _, name := range SourceNames() {
+		source, _ := lookupSource(name)
+		exported, err := source.Export(ctx, user)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to export personal data from %s", name)
+		}
+		if exported != nil {
+			data[name] = exported
+		}
+	}
+	return data, nil
+}
+
+// Erase deletes the data of a user from all the registered sources at once, bypassing the deletion
+// requests and their retention.
+func Erase(ctx context.Context, user string) error {
+	for _, name := range SourceNames() {
+		source, _ := lookupSource(name)
+		if err := source.Delete(ctx, user); err != nil {
+			return errors.Wrapf(err, "failed to erase personal data from %s", name)
+		}
+	}
+	return nil
+}
+
+// Config holds the route and the retention policies of a privacy controller
+type Config struct {
+	// Path serves the export of the data of the signed-in user on GET Path/export, and their
+	// deletion request on Path/deletion: POST requests the deletion, GET reports its progress and
+	// DELETE cancels it. All require authentication.
+	Path string `yaml:"path"`
+	// GracePeriod delays all the erasures, so that the users can cancel their requests. Defaults to
+	// immediate erasures.
+	GracePeriod time.Duration `yaml:"grace_period,omitempty"`
+	// Retention keeps the data of sources for longer than the grace period after the requests, e.g.
+	// billing records kept for legal obligations. By source name.
+	Retention map[string]time.Duration `yaml:"retention,omitempty"`
+	// Interval is the period of the fulfillment of the due erasures. Defaults to 10m.
+	Interval time.Duration `yaml:"interval,omitempty"`
+}
+
+func (c Config) Validate() error {
+	if c.Path == "" {
+		return errors.New("path must be set and non-empty")
+	}
+	if c.GracePeriod < 0 {
+		return errors.New("grace_period must not be negative")
+	}
+	for name, retention := range c.Retention {
+		if retention < 0 {
+			return errors.Errorf("retention of %s must not be negative", name)
+		}
+	}
+	if c.Interval < 0 {
+		return errors.New("interval must not be negative")
+	}
+	return nil
+}
+
+// NewController returns the factory of privacy controllers keeping the deletion requests in the
+// store. The store is migrated when a controller is created, which fulfills the due erasures once
+// bound, until closed.
+func NewController(store Store) func(cfg *Config, ctx server.ControllerContext) (server.IController, error) {
+	return func(cfg *Config, ctx server.ControllerContext) (server.IController, error) {
+		if store == nil {
+			return nil, errors.New("privacy store must not be nil")
+		}
+		// Deep copy the config to enforce immutability
+		configCopy := snapshot.MustCopy(cfg)
+		if configCopy.Interval == 0 {
+			configCopy.Interval = defaultInterval
+		}
+
+		migrationCtx, cancel := context.WithTimeout(context.Background(), migrationTimeout)
+		defer cancel()
+		if err := store.Migrate(migrationCtx); err != nil {
+			return nil, errors.Wrap(err, "failed to migrate the privacy store")
+		}
+
+		p := &privacy{
+			store:  store,
+			config: configCopy,
+			login:  ctx.LoginMiddleware(),
+			now:    time.Now,
+			done:   make(chan struct{}),
+		}
+		log.Info().
+			Str("path", ctx.BasePath+configCopy.Path).
+			Dur("grace_period", configCopy.GracePeriod).
+			Msg("Privacy controller configured")
+		return p, nil
+	}
+}
+
+type privacy struct {
+	store  Store
+	config *Config
+	login  gin.HandlerFunc // Login middleware of the binding, used without one from Bind
+	now    func() time.Time
+
+	done       chan struct{}
+	startOnce  sync.Once
+	closeOnce  sync.Once
+	wg         sync.WaitGroup
+	fulfilling sync.Mutex // Serializes the runs, so that erasures are not fulfilled twice
+}
+
+// sessionUser is the exported part of the session user, without the tokens of the provider
+type sessionUser struct {
+	Id        string `json:"id"`
+	Provider  string `json:"provider"`
+	UserID    string `json:"user_id"`
+	Email     string `json:"email"`
+	Name      string `json:"name"`
+	NickName  string `json:"nickname"`
+	AvatarURL string `json:"avatar_url"`
+	Location  string `json:"location"`
+}
+
+// exportDocument is the document of a data export
+type exportDocument struct {
+	User       string         `json:"user"`
+	ExportedAt time.Time      `json:"exported_at"`
+	Session    *sessionUser   `json:"session,omitempty"`
+	Data       map[string]any `json:"data"`
+	Deletion   *Request       `json:"deletion_request,omitempty"`
+}
+
+func (p *privacy) Bind(engine *gin.Engine, loginMiddleware gin.HandlerFunc) error {
+	return p.BindGroup(&engine.RouterGroup, loginMiddleware)
+}
+
+func (p *privacy) BindGroup(group *gin.RouterGroup, loginMiddleware gin.HandlerFunc) error {
+	if loginMiddleware == nil {
+		loginMiddleware = p.login
+	}
+	routes := group.Group(p.config.Path, loginMiddleware)
+	routes.GET("/export", p.export)
+	routes.POST("/deletion", p.requestDeletion)
+	routes.GET("/deletion", p.getDeletion)
+	routes.DELETE("/deletion", p.cancelDeletion)
+	p.startOnce.Do(func() {
+		p.wg.Add(1)
+		go p.run()
+	})
+	return nil
+}
+
+// Close stops the fulfillment of the erasures. The store is owned by the application.
+func (p *privacy) Close() error {
+	p.closeOnce.Do(func() { close(p.done) })
+	p.wg.Wait()
+	return nil
+}
+
+func (p *privacy) export(c *gin.Context) {
+	user, ok := requireUser(c)
+	if !ok {
+		return
+	}
+	ctx := c.Request.Context()
+	data, err := Export(ctx, user)
+	if err != nil {
+		p.fail(c, err)
+		return
+	}
+	document := exportDocument{User: user, ExportedAt: p.now().UTC(), Data: data}
+	if _, ok := c.Get(sessions.DefaultKey); ok {
+		if u, ok := sessionsx.User(c); ok {
+			document.Session = &sessionUser{
+				Id:        u.Id,
+				Provider:  u.User.Provider,
+				UserID:    u.User.UserID,
+				Email:     u.User.Email,
+				Name:      u.User.Name,
+				NickName:  u.User.NickName,
+				AvatarURL: u.User.AvatarURL,
+				Location:  u.User.Location,
+			}
+		}
+	}
+	if request, err := p.store.Latest(ctx, user); err == nil {
+		document.Deletion = &request
+	} else if !errors.Is(err, ErrNotFound) {
+		p.fail(c, err)
+		return
+	}
+
+	server.Audit(c, "personal_data_exported", map[string]any{"sources": slices.Sorted(maps.Keys(data))})
+	c.Header("Content-Disposition", `attachment; filename="personal-data.json"`)
+	c.JSON(http.StatusOK, document)
+}
+
+// requestDeletion schedules the erasure of the data of the user from each source, after the grace
+// period or the retention of the source. Users with a pending request get it instead.
+func (p *privacy) requestDeletion(c *gin.Context) {
+	user, ok := requireUser(c)
+	if !ok {
+		return
+	}
+	ctx := c.Request.Context()
+	latest, err := p.store.Latest(ctx, user)
+	switch {
+	case err == nil && latest.Status == StatusPending:
+		c.JSON(http.StatusOK, latest)
+		return
+	case err != nil && !errors.Is(err, ErrNotFound):
+		p.fail(c, err)
+		return
+	}
+
+	now := p.now().UTC()
+	request := Request{User: user, Status: StatusPending, RequestedAt: now, Erasures: make([]Erasure, 0)}
+	for _, name := range SourceNames() {
+		delay := max(p.config.GracePeriod, p.config.Retention[name])
+		request.Erasures = append(request.Erasures, Erasure{Source: name, DueAt: now.Add(delay)})
+	}
+	if _, err := p.store.Create(ctx, request); err != nil {
+		p.fail(c, err)
+		return
+	}
+	created, err := p.store.Latest(ctx, user)
+	if err != nil {
+		p.fail(c, err)
+		return
+	}
+	server.Audit(c, "personal_data_deletion_requested", map[string]any{"request_id": created.Id})
+	log.Info().Int64("request_id", created.Id).Msg("Personal data deletion requested")
+	p.wake()
+	c.JSON(http.StatusAccepted, created)
+}
+
+func (p *privacy) getDeletion(c *gin.Context) {
+	user, ok := requireUser(c)
+	if !ok {
+		return
+	}
+	request, err := p.store.Latest(c.Request.Context(), user)
+	if err != nil {
+		p.fail(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, request)
+}
+
+// cancelDeletion cancels the pending request of the user, as long as no data was erased
+func (p *privacy) cancelDeletion(c *gin.Context) {
+	user, ok := requireUser(c)
+	if !ok {
+		return
+	}
+	ctx := c.Request.Context()
+	request, err := p.store.Latest(ctx, user)
+	if err != nil {
+		p.fail(c, err)
+		return
+	}
+	if request.Status != StatusPending {
+		server.RespondError(c, http.StatusConflict)
+		return
+	}
+	if err := p.store.Cancel(ctx, request.Id); err != nil {
+		if errors.Is(err, ErrNotFound) {
+			// Some data was already erased
+			server.RespondError(c, http.StatusConflict)
+			return
+		}
+		p.fail(c, err)
+		return
+	}
+	server.Audit(c, "personal_data_deletion_cancelled", map[string]any{"request_id": request.Id})
+	c.Status(http.StatusNoContent)
+}
+
+// run fulfills the due erasures every interval, until closed
+func (p *privacy) run() {
+	defer p.wg.Done()
+	ticker := time.NewTicker(p.config.Interval)
+	defer ticker.Stop()
+	for {
+		p.fulfill()
+		select {
+		case <-p.done:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// wake fulfills the erasures already due, those without grace period nor retention, right away
+func (p *privacy) wake() {
+	select {
+	case <-p.done:
+		return
+	default:
+	}
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+		p.fulfill()
+	}()
+}
+
+// fulfill erases the data of the due erasures, recording their outcome. Failed erasures are retried
+// on the next run.
+func (p *privacy) fulfill() {
+	p.fulfilling.Lock()
+	defer p.fulfilling.Unlock()
+	ctx, cancel := context.WithTimeout(context.Background(), erasureTimeout)
+	defer cancel()
+	due, err := p.store.Due(ctx, p.now(), dueBatchSize)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to list due personal data erasures")
+		return
+	}
+	for _, erasure := range due {
+		source, ok := lookupSource(erasure.Source)
+		if !ok {
+			log.Warn().Str("source", erasure.Source).Int64("request_id", erasure.RequestId).
+				Msg("Personal data source of an erasure is not registered")
+			continue
+		}
+		failure := source.Delete(ctx, erasure.User)
+		if failure != nil {
+			log.Error().Err(failure).Str("source", erasure.Source).Int64("request_id", erasure.RequestId).
+				Msg("Failed to erase personal data")
+		}
+		if err := p.store.Fulfill(ctx, erasure, p.now(), failure); err != nil {
+			log.Error().Err(err).Int64("request_id", erasure.RequestId).Msg("Failed to record personal data erasure")
+			continue
+		}
+		if failure == nil {
+			log.Info().Str("source", erasure.Source).Int64("request_id", erasure.RequestId).Msg("Personal data erased")
+			logging.Ship(logging.StreamAudit, map[string]any{
+				"action":     "personal_data_erased",
+				"user_id":    erasure.User,
+				"source":     erasure.Source,
+				"request_id": erasure.RequestId,
+			})
+		}
+	}
+}
+
+// fail answers 404 for missing requests, or logs the error and answers 500
+func (p *privacy) fail(c *gin.Context, err error) {
+	if errors.Is(err, ErrNotFound) {
+		server.RespondError(c, http.StatusNotFound)
+		return
+	}
+	logger := server.RequestLogger(c)
+	logger.Error().Err(err).Msg("Privacy request failed")
+	server.RespondError(c, http.StatusInternalServerError)
+}
+
+// requireUser returns the ID of the signed-in user, the session user of the auth controller or the
+// user of the basic, header or JWT authenticators, answering 401 for anonymous requests.
+func requireUser(c *gin.Context) (string, bool) {
+	if user := server.UserId(c); user != "" {
+		return user, true
+	}
+	server.RespondError(c, http.StatusUnauthorized)
+	return "", false
+}
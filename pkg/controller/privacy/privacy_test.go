@@ -0,0 +1,382 @@
+//go:build unit
+
+package privacy
+
+import (
+	"context"
+	"encoding/json"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/animalet/sargantana-go/pkg/server"
+	"github.com/gin-gonic/gin"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+// memoryStore is a Store keeping the deletion requests in memory
+type memoryStore struct {
+	mu       sync.Mutex
+	requests []Request
+	migrated int
+}
+
+func (m *memoryStore) Migrate(context.Context) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.migrated++
+	return nil
+}
+
+func (m *memoryStore) Create(_ context.Context, request Request) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	request.Id = int64(len(m.requests) + 1)
+	request.Status = StatusPending
+	if len(request.Erasures) == 0 {
+		request.Status, request.CompletedAt = StatusCompleted, &request.RequestedAt
+	}
+	request.Erasures = slices.Clone(request.Erasures)
+	m.requests = append(m.requests, request)
+	return request.Id, nil
+}
+
+func (m *memoryStore) Latest(_ context.Context, user string) (Request, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i := len(m.requests) - 1; i >= 0; i-- {
+		if m.requests[i].User == user {
+			r := m.requests[i]
+			r.Erasures = slices.Clone(r.Erasures)
+			return r, nil
+		}
+	}
+	return Request{}, ErrNotFound
+}
+
+func (m *memoryStore) Cancel(_ context.Context, id int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	r := &m.requests[id-1]
+	if r.Status != StatusPending || slices.ContainsFunc(r.Erasures, func(e Erasure) bool { return e.ErasedAt != nil }) {
+		return ErrNotFound
+	}
+	r.Status = StatusCancelled
+	return nil
+}
+
+func (m *memoryStore) Due(_ context.Context, now time.Time, limit int) ([]DueErasure, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	due := make([]DueErasure, 0)
+	for _, r := range m.requests {
+		for _, e := range r.Erasures {
+			if r.Status == StatusPending && e.ErasedAt == nil && !e.DueAt.After(now) && len(due) < limit {
+				due = append(due, DueErasure{RequestId: r.Id, User: r.User, Source: e.Source})
+			}
+		}
+	}
+	return due, nil
+}
+
+func (m *memoryStore) Fulfill(_ context.Context, erasure DueErasure, now time.Time, failure error) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	r := &m.requests[erasure.RequestId-1]
+	remaining := 0
+	for i := range r.Erasures {
+		e := &r.Erasures[i]
+		if e.Source == erasure.Source {
+			if failure != nil {
+				e.LastError = failure.Error()
+			} else {
+				e.ErasedAt, e.LastError = &now, ""
+			}
+		}
+		if e.ErasedAt == nil {
+			remaining++
+		}
+	}
+	if remaining == 0 {
+		r.Status, r.CompletedAt = StatusCompleted, &now
+	}
+	return nil
+}
+
+// memorySource is a Source keeping a value by user
+type memorySource struct {
+	mu   sync.Mutex
+	data map[string]any
+	err  error
+}
+
+func (s *memorySource) Export(_ context.Context, user string) (any, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.data[user], s.err
+}
+
+func (s *memorySource) Delete(_ context.Context, user string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return s.err
+	}
+	delete(s.data, user)
+	return nil
+}
+
+func (s *memorySource) has(user string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.data[user]
+	return ok
+}
+
+// clock is a settable time source, read by the fulfillment goroutines
+type clock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *clock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *clock) Add(d time.Duration) time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+	return c.now
+}
+
+var _ = Describe("Privacy controller", func() {
+	var (
+		store    *memoryStore
+		profiles *memorySource
+		billing  *memorySource
+		ctrl     *privacy
+		engine   *gin.Engine
+		login    gin.HandlerFunc
+		now      *clock
+	)
+
+	cfg := Config{
+		Path:        "/privacy",
+		GracePeriod: time.Hour,
+		Retention:   map[string]time.Duration{"billing": 24 * time.Hour},
+		Interval:    time.Hour,
+	}
+
+	BeforeEach(func() {
+		gin.SetMode(gin.TestMode)
+		sourcesMu.Lock()
+		sources = make(map[string]Source)
+		sourcesMu.Unlock()
+		profiles = &memorySource{data: map[string]any{"alice": map[string]any{"name": "Alice"}, "bob": "Bob"}}
+		billing = &memorySource{data: map[string]any{"alice": []int{1, 2}}}
+		RegisterSource("profile", profiles)
+		RegisterSource("billing", billing)
+
+		store = &memoryStore{}
+		created, err := NewController(store)(&cfg, server.ControllerContext{})
+		Expect(err).NotTo(HaveOccurred())
+		ctrl = created.(*privacy)
+		DeferCleanup(ctrl.Close)
+		now = &clock{now: time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)}
+		ctrl.now = now.Now
+
+		engine = gin.New()
+		engine.Use(func(c *gin.Context) {
+			if user := c.GetHeader("X-User"); user != "" {
+				c.Set(server.RemoteUserKey, user)
+			}
+		})
+		login = func(c *gin.Context) {
+			if _, ok := server.GetRemoteUser(c); !ok {
+				c.AbortWithStatus(http.StatusUnauthorized)
+			}
+		}
+		Expect(ctrl.BindGroup(engine.Group("/account"), login)).To(Succeed())
+	})
+
+	request := func(method, path, user string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, path, nil)
+		if user != "" {
+			r.Header.Set("X-User", user)
+		}
+		w := httptest.NewRecorder()
+		engine.ServeHTTP(w, r)
+		return w
+	}
+
+	decode := func(w *httptest.ResponseRecorder) Request {
+		var r Request
+		Expect(json.Unmarshal(w.Body.Bytes(), &r)).To(Succeed())
+		return r
+	}
+
+	It("should validate the configuration", func() {
+		Expect(Config{}.Validate()).To(MatchError(ContainSubstring("path")))
+		Expect(Config{Path: "/p", GracePeriod: -1}.Validate()).To(MatchError(ContainSubstring("grace_period")))
+		Expect(Config{Path: "/p", Retention: map[string]time.Duration{"cms": -1}}.Validate()).To(MatchError(ContainSubstring("retention of cms")))
+		Expect(Config{Path: "/p", Interval: -1}.Validate()).To(MatchError(ContainSubstring("interval")))
+		Expect(cfg.Validate()).To(Succeed())
+	})
+
+	It("should migrate the store and require one", func() {
+		Expect(store.migrated).To(Equal(1))
+		_, err := NewController(nil)(&cfg, server.ControllerContext{})
+		Expect(err).To(MatchError(ContainSubstring("store")))
+	})
+
+	It("should require authentication", func() {
+		Expect(request(http.MethodGet, "/account/privacy/export", "").Code).To(Equal(http.StatusUnauthorized))
+		Expect(request(http.MethodPost, "/account/privacy/deletion", "").Code).To(Equal(http.StatusUnauthorized))
+	})
+
+	It("should export the data of the user", func() {
+		w := request(http.MethodGet, "/account/privacy/export", "alice")
+		Expect(w.Code).To(Equal(http.StatusOK))
+		Expect(w.Header().Get("Content-Disposition")).To(ContainSubstring("attachment"))
+		var document map[string]any
+		Expect(json.Unmarshal(w.Body.Bytes(), &document)).To(Succeed())
+		Expect(document).To(HaveKeyWithValue("user", "alice"))
+		Expect(document).To(HaveKeyWithValue("exported_at", "2025-03-01T12:00:00Z"))
+		Expect(document["data"]).To(Equal(map[string]any{
+			"profile": map[string]any{"name": "Alice"},
+			"billing": []any{float64(1), float64(2)},
+		}))
+		Expect(document).NotTo(HaveKey("deletion_request"))
+
+		w = request(http.MethodGet, "/account/privacy/export", "bob")
+		Expect(json.Unmarshal(w.Body.Bytes(), &document)).To(Succeed())
+		Expect(document["data"]).To(Equal(map[string]any{"profile": "Bob"}))
+	})
+
+	It("should fail exports when a source fails", func() {
+		billing.err = context.DeadlineExceeded
+		Expect(request(http.MethodGet, "/account/privacy/export", "alice").Code).To(Equal(http.StatusInternalServerError))
+	})
+
+	It("should erase the data of each source once its retention ends", func() {
+		Expect(request(http.MethodGet, "/account/privacy/deletion", "alice").Code).To(Equal(http.StatusNotFound))
+
+		w := request(http.MethodPost, "/account/privacy/deletion", "alice")
+		Expect(w.Code).To(Equal(http.StatusAccepted))
+		r := decode(w)
+		Expect(r.Status).To(Equal(StatusPending))
+		start := now.Now()
+		Expect(r.Erasures).To(ConsistOf(
+			Erasure{Source: "billing", DueAt: start.Add(24 * time.Hour)},
+			Erasure{Source: "profile", DueAt: start.Add(time.Hour)},
+		))
+		// Requesting again answers the pending request
+		w = request(http.MethodPost, "/account/privacy/deletion", "alice")
+		Expect(w.Code).To(Equal(http.StatusOK))
+		Expect(decode(w).Id).To(Equal(r.Id))
+
+		ctrl.fulfill()
+		Expect(profiles.has("alice")).To(BeTrue())
+
+		now.Add(time.Hour)
+		ctrl.fulfill()
+		Expect(profiles.has("alice")).To(BeFalse())
+		Expect(profiles.has("bob")).To(BeTrue())
+		Expect(billing.has("alice")).To(BeTrue())
+		Expect(request(http.MethodDelete, "/account/privacy/deletion", "alice").Code).To(Equal(http.StatusConflict))
+
+		end := now.Add(23 * time.Hour)
+		ctrl.fulfill()
+		Expect(billing.has("alice")).To(BeFalse())
+		r = decode(request(http.MethodGet, "/account/privacy/deletion", "alice"))
+		Expect(r.Status).To(Equal(StatusCompleted))
+		Expect(r.CompletedAt).To(HaveValue(Equal(end)))
+		Expect(r.Erasures).To(HaveEach(HaveField("ErasedAt", Not(BeNil()))))
+	})
+
+	It("should retry failed erasures", func() {
+		Expect(request(http.MethodPost, "/account/privacy/deletion", "alice").Code).To(Equal(http.StatusAccepted))
+		now.Add(24 * time.Hour)
+		billing.mu.Lock()
+		billing.err = context.DeadlineExceeded
+		billing.mu.Unlock()
+		ctrl.fulfill()
+		r := decode(request(http.MethodGet, "/account/privacy/deletion", "alice"))
+		Expect(r.Status).To(Equal(StatusPending))
+		Expect(r.Erasures).To(ContainElement(And(HaveField("Source", "billing"), HaveField("LastError", ContainSubstring("deadline")))))
+
+		billing.mu.Lock()
+		billing.err = nil
+		billing.mu.Unlock()
+		ctrl.fulfill()
+		Expect(decode(request(http.MethodGet, "/account/privacy/deletion", "alice")).Status).To(Equal(StatusCompleted))
+	})
+
+	It("should cancel pending requests before any erasure", func() {
+		Expect(request(http.MethodDelete, "/account/privacy/deletion", "alice").Code).To(Equal(http.StatusNotFound))
+		Expect(request(http.MethodPost, "/account/privacy/deletion", "alice").Code).To(Equal(http.StatusAccepted))
+		Expect(request(http.MethodDelete, "/account/privacy/deletion", "alice").Code).To(Equal(http.StatusNoContent))
+		Expect(decode(request(http.MethodGet, "/account/privacy/deletion", "alice")).Status).To(Equal(StatusCancelled))
+		Expect(request(http.MethodDelete, "/account/privacy/deletion", "alice").Code).To(Equal(http.StatusConflict))
+
+		now.Add(48 * time.Hour)
+		ctrl.fulfill()
+		Expect(profiles.has("alice")).To(BeTrue())
+	})
+
+	It("should erase right away without grace period", func() {
+		immediate, err := NewController(store)(&Config{Path: "/now", Interval: time.Hour}, server.ControllerContext{})
+		Expect(err).NotTo(HaveOccurred())
+		DeferCleanup(immediate.Close)
+		Expect(immediate.(server.GroupController).BindGroup(engine.Group("/account"), login)).To(Succeed())
+
+		r := httptest.NewRequest(http.MethodPost, "/account/now/deletion", nil)
+		r.Header.Set("X-User", "bob")
+		w := httptest.NewRecorder()
+		engine.ServeHTTP(w, r)
+		Expect(w.Code).To(Equal(http.StatusAccepted))
+		Eventually(func() bool { return profiles.has("bob") }).Should(BeFalse())
+	})
+
+	It("should complete requests without sources at once", func() {
+		sourcesMu.Lock()
+		sources = make(map[string]Source)
+		sourcesMu.Unlock()
+		w := request(http.MethodPost, "/account/privacy/deletion", "alice")
+		Expect(w.Code).To(Equal(http.StatusAccepted))
+		Expect(decode(w).Status).To(Equal(StatusCompleted))
+	})
+})
+
+var _ = Describe("Personal data sources", func() {
+	BeforeEach(func() {
+		sourcesMu.Lock()
+		sources = make(map[string]Source)
+		sourcesMu.Unlock()
+	})
+
+	It("should export and erase the data of all the sources", func() {
+		first := &memorySource{data: map[string]any{"alice": 1}}
+		second := &memorySource{data: map[string]any{"alice": 2, "bob": 3}}
+		RegisterSource("first", first)
+		RegisterSource("second", second)
+		Expect(SourceNames()).To(Equal([]string{"first", "second"}))
+
+		data, err := Export(context.Background(), "alice")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(data).To(Equal(map[string]any{"first": 1, "second": 2}))
+
+		Expect(Erase(context.Background(), "alice")).To(Succeed())
+		data, err = Export(context.Background(), "alice")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(data).To(BeEmpty())
+		Expect(slices.Collect(maps.Keys(second.data))).To(Equal([]string{"bob"}))
+	})
+})
@@ -0,0 +1,261 @@
+package privacy
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/animalet/sargantana-go/internal/sqlstore"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/jackc/pgx/v5/stdlib"
+	"github.com/pkg/errors"
+)
+
+// ErrNotFound is returned by stores for deletion requests that do not exist, or cannot be changed
+var ErrNotFound = errors.New("deletion request not found")
+
+// Statuses of the deletion requests
+const (
+	StatusPending   = "pending"
+	StatusCancelled = "cancelled"
+	StatusCompleted = "completed"
+)
+
+// Request is a request of a user to delete their data. Each source of personal data is erased
+// once its erasure is due, see Config.
+type Request struct {
+	Id          int64      `json:"id"`
+	User        string     `json:"user"`
+	Status      string     `json:"status"`
+	RequestedAt time.Time  `json:"requested_at"`
+	CompletedAt *time.Time `json:"completed_at,omitempty"`
+	Erasures    []Erasure  `json:"erasures"`
+}
+
+// Erasure is the deletion of the data of a user from a source. Failed erasures keep the last error
+// and are retried.
+type Erasure struct {
+	Source    string     `json:"source"`
+	DueAt     time.Time  `json:"due_at"`
+	ErasedAt  *time.Time `json:"erased_at,omitempty"`
+	LastError string     `json:"last_error,omitempty"`
+}
+
+// DueErasure is an erasure to fulfill
+type DueErasure struct {
+	RequestId int64
+	User      string
+	Source    string
+}
+
+// Store persists the deletion requests, which are kept once fulfilled as the audit trail of the
+// erasures. Stores are injected in the controller factory, see NewController.
+type Store interface {
+	// Migrate creates or upgrades the tables of the store. Called once by NewController.
+	Migrate(ctx context.Context) error
+	// Create stores a new request with its erasures, pending unless it has none, returning its ID.
+	Create(ctx context.Context, request Request) (int64, error)
+	// Latest returns the last request of a user, or ErrNotFound.
+	Latest(ctx context.Context, user string) (Request, error)
+	// Cancel cancels a pending request none of whose erasures is fulfilled, or returns ErrNotFound.
+	Cancel(ctx context.Context, id int64) error
+	// Due returns up to limit erasures of pending requests due at now.
+	Due(ctx context.Context, now time.Time, limit int) ([]DueErasure, error)
+	// Fulfill records the outcome of an erasure at now, completing the request once all its
+	// erasures are fulfilled. A nil failure marks the erasure fulfilled.
+	Fulfill(ctx context.Context, erasure DueErasure, now time.Time, failure error) error
+}
+
+var (
+	postgresDialect = sqlstore.Dialect{
+		Numbered: true,
+		Lock:     "SELECT pg_advisory_xact_lock(4242003)",
+		Migrations: []string{
+			`CREATE TABLE IF NOT EXISTS privacy_requests (
+				id SERIAL PRIMARY KEY,
+				user_id TEXT NOT NULL,
+				status TEXT NOT NULL,
+				requested_at TIMESTAMP NOT NULL,
+				completed_at TIMESTAMP
+			);
+			CREATE INDEX IF NOT EXISTS privacy_requests_user_idx ON privacy_requests (user_id, id);
+			CREATE TABLE IF NOT EXISTS privacy_erasures (
+				request_id INTEGER NOT NULL REFERENCES privacy_requests (id),
+				source TEXT NOT NULL,
+				due_at TIMESTAMP NOT NULL,
+				erased_at TIMESTAMP,
+				last_error TEXT NOT NULL DEFAULT '',
+				PRIMARY KEY (request_id, source)
+			)`,
+		},
+	}
+	sqliteDialect = sqlstore.Dialect{
+		Migrations: []string{
+			`CREATE TABLE IF NOT EXISTS privacy_requests (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				user_id TEXT NOT NULL,
+				status TEXT NOT NULL,
+				requested_at TIMESTAMP NOT NULL,
+				completed_at TIMESTAMP
+			);
+			CREATE INDEX IF NOT EXISTS privacy_requests_user_idx ON privacy_requests (user_id, id);
+			CREATE TABLE IF NOT EXISTS privacy_erasures (
+				request_id INTEGER NOT NULL REFERENCES privacy_requests (id),
+				source TEXT NOT NULL,
+				due_at TIMESTAMP NOT NULL,
+				erased_at TIMESTAMP,
+				last_error TEXT NOT NULL DEFAULT '',
+				PRIMARY KEY (request_id, source)
+			)`,
+		},
+	}
+)
+
+// sqlStore is a Store on a database/sql database
+type sqlStore struct {
+	sqlstore.Store
+}
+
+// NewPostgresStore returns a Store keeping the deletion requests in PostgreSQL. The pool is not
+// closed by the store.
+func NewPostgresStore(pool *pgxpool.Pool) Store {
+	return &sqlStore{sqlstore.Store{DB: stdlib.OpenDBFromPool(pool), Dialect: postgresDialect, MigrationsTable: "privacy_migrations"}}
+}
+
+// NewSQLiteStore returns a Store keeping the deletion requests in SQLite. The database must be
+// opened with a SQLite driver registered by the application, e.g. modernc.org/sqlite, and is not
+// closed by the store.
+func NewSQLiteStore(db *sql.DB) Store {
+	return &sqlStore{sqlstore.Store{DB: db, Dialect: sqliteDialect, MigrationsTable: "privacy_migrations"}}
+}
+
+func (s *sqlStore) Create(ctx context.Context, request Request) (int64, error) {
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to start the request transaction")
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	// Without erasures, there is nothing to wait for
+	status, completed := StatusPending, sql.NullTime{}
+	if len(request.Erasures) == 0 {
+		status, completed = StatusCompleted, sql.NullTime{Time: request.RequestedAt.UTC(), Valid: true}
+	}
+	var id int64
+	if err := tx.QueryRowContext(ctx,
+		s.Rebind("INSERT INTO privacy_requests (user_id, status, requested_at, completed_at) VALUES (?, ?, ?, ?) RETURNING id"),
+		request.User, status, request.RequestedAt.UTC(), completed,
+	).Scan(&id); err != nil {
+		return 0, errors.Wrap(err, "failed to create deletion request")
+	}
+	for _, erasure := range request.Erasures {
+		if _, err := tx.ExecContext(ctx,
+			s.Rebind("INSERT INTO privacy_erasures (request_id, source, due_at) VALUES (?, ?, ?)"),
+			id, erasure.Source, erasure.DueAt.UTC(),
+		); err != nil {
+			return 0, errors.Wrap(err, "failed to create erasure")
+		}
+	}
+	return id, errors.Wrap(tx.Commit(), "failed to commit deletion request")
+}
+
+func (s *sqlStore) Latest(ctx context.Context, user string) (Request, error) {
+	r := Request{User: user, Erasures: make([]Erasure, 0)}
+	var completed sql.NullTime
+	err := s.DB.QueryRowContext(ctx, s.Rebind(
+		"SELECT id, status, requested_at, completed_at FROM privacy_requests WHERE user_id = ? ORDER BY id DESC LIMIT 1"), user).
+		Scan(&r.Id, &r.Status, &r.RequestedAt, &completed)
+	if errors.Is(err, sql.ErrNoRows) {
+		return r, ErrNotFound
+	}
+	if err != nil {
+		return r, errors.Wrap(err, "failed to read deletion request")
+	}
+	if completed.Valid {
+		r.CompletedAt = &completed.Time
+	}
+
+	rows, err := s.DB.QueryContext(ctx, s.Rebind(
+		"SELECT source, due_at, erased_at, last_error FROM privacy_erasures WHERE request_id = ? ORDER BY source"), r.Id)
+	if err != nil {
+		return r, errors.Wrap(err, "failed to read erasures")
+	}
+	defer func() { _ = rows.Close() }()
+	for rows.Next() {
+		var e Erasure
+		var erased sql.NullTime
+		if err := rows.Scan(&e.Source, &e.DueAt, &erased, &e.LastError); err != nil {
+			return r, errors.Wrap(err, "failed to read erasure")
+		}
+		if erased.Valid {
+			e.ErasedAt = &erased.Time
+		}
+		r.Erasures = append(r.Erasures, e)
+	}
+	return r, errors.Wrap(rows.Err(), "failed to read erasures")
+}
+
+func (s *sqlStore) Cancel(ctx context.Context, id int64) error {
+	result, err := s.DB.ExecContext(ctx, s.Rebind(
+		"UPDATE privacy_requests SET status = ? WHERE id = ? AND status = ? AND NOT EXISTS "+
+			"(SELECT 1 FROM privacy_erasures WHERE request_id = ? AND erased_at IS NOT NULL)"),
+		StatusCancelled, id, StatusPending, id)
+	if err != nil {
+		return errors.Wrap(err, "failed to cancel deletion request")
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return errors.Wrap(err, "failed to cancel deletion request")
+	}
+	if rows == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *sqlStore) Due(ctx context.Context, now time.Time, limit int) ([]DueErasure, error) {
+	rows, err := s.DB.QueryContext(ctx, s.Rebind(
+		"SELECT e.request_id, r.user_id, e.source FROM privacy_erasures e JOIN privacy_requests r ON r.id = e.request_id "+
+			"WHERE r.status = ? AND e.erased_at IS NULL AND e.due_at <= ? ORDER BY e.due_at LIMIT ?"),
+		StatusPending, now.UTC(), limit)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list due erasures")
+	}
+	defer func() { _ = rows.Close() }()
+	due := make([]DueErasure, 0)
+	for rows.Next() {
+		var e DueErasure
+		if err := rows.Scan(&e.RequestId, &e.User, &e.Source); err != nil {
+			return nil, errors.Wrap(err, "failed to read due erasure")
+		}
+		due = append(due, e)
+	}
+	return due, errors.Wrap(rows.Err(), "failed to list due erasures")
+}
+
+func (s *sqlStore) Fulfill(ctx context.Context, erasure DueErasure, now time.Time, failure error) error {
+	if failure != nil {
+		_, err := s.DB.ExecContext(ctx, s.Rebind(
+			"UPDATE privacy_erasures SET last_error = ? WHERE request_id = ? AND source = ?"),
+			failure.Error(), erasure.RequestId, erasure.Source)
+		return errors.Wrap(err, "failed to record erasure failure")
+	}
+
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return errors.Wrap(err, "failed to start the erasure transaction")
+	}
+	defer func() { _ = tx.Rollback() }()
+	if _, err := tx.ExecContext(ctx, s.Rebind(
+		"UPDATE privacy_erasures SET erased_at = ?, last_error = '' WHERE request_id = ? AND source = ?"),
+		now.UTC(), erasure.RequestId, erasure.Source); err != nil {
+		return errors.Wrap(err, "failed to record erasure")
+	}
+	if _, err := tx.ExecContext(ctx, s.Rebind(
+		"UPDATE privacy_requests SET status = ?, completed_at = ? WHERE id = ? AND status = ? AND NOT EXISTS "+
+			"(SELECT 1 FROM privacy_erasures WHERE request_id = ? AND erased_at IS NULL)"),
+		StatusCompleted, now.UTC(), erasure.RequestId, StatusPending, erasure.RequestId); err != nil {
+		return errors.Wrap(err, "failed to complete deletion request")
+	}
+	return errors.Wrap(tx.Commit(), "failed to commit erasure")
+}
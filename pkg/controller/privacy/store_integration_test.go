@@ -0,0 +1,84 @@
+//go:build integration
+
+package privacy
+
+import (
+	"context"
+	"time"
+
+	"github.com/animalet/sargantana-go/pkg/database"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/pkg/errors"
+)
+
+var _ = Describe("PostgreSQL store", func() {
+	var store Store
+
+	BeforeEach(func() {
+		pool, err := database.PostgresConfig{
+			Host:     "localhost",
+			Port:     5432,
+			User:     "user",
+			Password: "password",
+			Database: "my_blog_db",
+			SSLMode:  "disable",
+		}.CreateClient()
+		Expect(err).NotTo(HaveOccurred())
+		DeferCleanup(pool.Close)
+		store = NewPostgresStore(pool)
+	})
+
+	It("should migrate idempotently", func() {
+		Expect(store.Migrate(context.Background())).To(Succeed())
+		Expect(store.Migrate(context.Background())).To(Succeed())
+	})
+
+	It("should record the fulfillment of deletion requests", func() {
+		ctx := context.Background()
+		Expect(store.Migrate(ctx)).To(Succeed())
+		user := "privacy-integration-" + time.Now().Format(time.RFC3339Nano)
+		now := time.Now().UTC().Truncate(time.Second)
+
+		id, err := store.Create(ctx, Request{User: user, RequestedAt: now, Erasures: []Erasure{
+			{Source: "cms", DueAt: now},
+			{Source: "profile", DueAt: now.Add(time.Hour)},
+		}})
+		Expect(err).NotTo(HaveOccurred())
+
+		due, err := store.Due(ctx, now, 1000)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(due).To(ContainElement(DueErasure{RequestId: id, User: user, Source: "cms"}))
+		Expect(due).NotTo(ContainElement(And(HaveField("Source", "profile"), HaveField("RequestId", id))))
+
+		Expect(store.Fulfill(ctx, DueErasure{RequestId: id, User: user, Source: "cms"}, now, errors.New("down"))).To(Succeed())
+		Expect(store.Fulfill(ctx, DueErasure{RequestId: id, User: user, Source: "cms"}, now, nil)).To(Succeed())
+		Expect(store.Cancel(ctx, id)).To(MatchError(ErrNotFound))
+
+		request, err := store.Latest(ctx, user)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(request.Status).To(Equal(StatusPending))
+		Expect(request.Erasures).To(HaveLen(2))
+		Expect(request.Erasures[0].ErasedAt).NotTo(BeNil())
+
+		Expect(store.Fulfill(ctx, DueErasure{RequestId: id, User: user, Source: "profile"}, now, nil)).To(Succeed())
+		request, err = store.Latest(ctx, user)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(request.Status).To(Equal(StatusCompleted))
+		Expect(request.CompletedAt).NotTo(BeNil())
+	})
+
+	It("should cancel pending requests", func() {
+		ctx := context.Background()
+		Expect(store.Migrate(ctx)).To(Succeed())
+		user := "privacy-integration-cancel-" + time.Now().Format(time.RFC3339Nano)
+
+		id, err := store.Create(ctx, Request{User: user, RequestedAt: time.Now(), Erasures: []Erasure{{Source: "cms", DueAt: time.Now().Add(time.Hour)}}})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(store.Cancel(ctx, id)).To(Succeed())
+		Expect(store.Cancel(ctx, id)).To(MatchError(ErrNotFound))
+		request, err := store.Latest(ctx, user)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(request.Status).To(Equal(StatusCancelled))
+	})
+})
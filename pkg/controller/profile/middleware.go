@@ -0,0 +1,142 @@
+package profile
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+
+	"github.com/animalet/sargantana-go/internal/snapshot"
+	"github.com/animalet/sargantana-go/pkg/server"
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+	"golang.org/x/net/http/httpguts"
+)
+
+// ContextKey is the gin context key of the profile loaded by the middleware, see FromContext
+const ContextKey = "sargantana_profile"
+
+const preferencesPrefix = "preferences."
+
+// MiddlewareConfig configures the profile middleware type, which loads the profile of the
+// signed-in user for the templates, see FromContext, and forwards it to the backends in request
+// headers. The configured headers are removed from all the requests, so that clients cannot forge
+// them, and the middleware should follow the login or optional_auth middleware of the binding.
+type MiddlewareConfig struct {
+	// Headers maps request headers to the profile fields they carry: display_name, email, locale,
+	// timezone or preferences.<name>, e.g. X-User-Locale: locale.
+	Headers map[string]string `yaml:"headers,omitempty"`
+	// ClaimsHeader carries the whole profile as base64url encoded JSON, e.g. X-User-Profile.
+	ClaimsHeader string `yaml:"claims_header,omitempty"`
+}
+
+func (c MiddlewareConfig) Validate() error {
+	for header, field := range c.Headers {
+		if !httpguts.ValidHeaderFieldName(header) {
+			return errors.Errorf("invalid header name %q", header)
+		}
+		switch field {
+		case "display_name", "email", "locale", "timezone":
+		default:
+			if !strings.HasPrefix(field, preferencesPrefix) || len(field) == len(preferencesPrefix) {
+				return errors.Errorf("header %s has unknown profile field %q", header, field)
+			}
+		}
+	}
+	if c.ClaimsHeader != "" && !httpguts.ValidHeaderFieldName(c.ClaimsHeader) {
+		return errors.Errorf("invalid claims_header name %q", c.ClaimsHeader)
+	}
+	return nil
+}
+
+// NewMiddleware returns the factory of profile middlewares reading the profiles from the store.
+// The store is migrated when a middleware is created.
+func NewMiddleware(store Store) func(cfg *MiddlewareConfig, ctx server.ControllerContext) (gin.HandlerFunc, error) {
+	return func(cfg *MiddlewareConfig, _ server.ControllerContext) (gin.HandlerFunc, error) {
+		if err := migrate(store); err != nil {
+			return nil, err
+		}
+		configCopy := snapshot.MustCopy(cfg)
+		return (&middleware{store: store, config: configCopy}).handle, nil
+	}
+}
+
+// FromContext returns the profile loaded by the profile middleware. Users without a stored profile
+// have an empty one.
+func FromContext(c *gin.Context) (Profile, bool) {
+	value, ok := c.Get(ContextKey)
+	if !ok {
+		return Profile{}, false
+	}
+	profile, ok := value.(Profile)
+	return profile, ok
+}
+
+type middleware struct {
+	store  Store
+	config *MiddlewareConfig
+}
+
+func (m *middleware) handle(c *gin.Context) {
+	header := c.Request.Header
+	for name := range m.config.Headers {
+		header.Del(name)
+	}
+	if m.config.ClaimsHeader != "" {
+		header.Del(m.config.ClaimsHeader)
+	}
+
+	user := server.UserId(c)
+	if user == "" {
+		c.Next()
+		return
+	}
+	profile, err := m.store.Get(c.Request.Context(), user)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		// The profile is not essential to serve the request
+		logger := server.RequestLogger(c)
+		logger.Warn().Err(err).Msg("Failed to load user profile")
+		c.Next()
+		return
+	}
+	profile.User = user
+	c.Set(ContextKey, profile)
+
+	for name, field := range m.config.Headers {
+		if value := fieldValue(profile, field); value != "" && httpguts.ValidHeaderFieldValue(value) {
+			header.Set(name, value)
+		}
+	}
+	if m.config.ClaimsHeader != "" {
+		if claims, err := json.Marshal(profile); err == nil {
+			header.Set(m.config.ClaimsHeader, base64.RawURLEncoding.EncodeToString(claims))
+		}
+	}
+	c.Next()
+}
+
+// fieldValue formats a field of the profile for a header. Preferences that are not strings are
+// JSON encoded.
+func fieldValue(profile Profile, field string) string {
+	switch field {
+	case "display_name":
+		return profile.DisplayName
+	case "email":
+		return profile.Email
+	case "locale":
+		return profile.Locale
+	case "timezone":
+		return profile.Timezone
+	}
+	value, ok := profile.Preferences[strings.TrimPrefix(field, preferencesPrefix)]
+	if !ok {
+		return ""
+	}
+	if s, ok := value.(string); ok {
+		return s
+	}
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return ""
+	}
+	return string(encoded)
+}
@@ -0,0 +1,123 @@
+//go:build unit
+
+package profile
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/animalet/sargantana-go/pkg/server"
+	"github.com/gin-gonic/gin"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Profile middleware", func() {
+	var (
+		store    *memoryStore
+		engine   *gin.Engine
+		received http.Header
+		loaded   *Profile
+	)
+
+	cfg := MiddlewareConfig{
+		Headers: map[string]string{
+			"X-User-Name":   "display_name",
+			"X-User-Locale": "locale",
+			"X-User-Theme":  "preferences.theme",
+			"X-User-Size":   "preferences.page_size",
+		},
+		ClaimsHeader: "X-User-Profile",
+	}
+
+	BeforeEach(func() {
+		gin.SetMode(gin.TestMode)
+		store = newMemoryStore()
+		Expect(store.Put(context.Background(), Profile{
+			User:        "alice",
+			DisplayName: "Alice",
+			Locale:      "es-ES",
+			Preferences: map[string]any{"theme": "dark", "page_size": 20},
+		})).To(Succeed())
+		handler, err := NewMiddleware(store)(&cfg, server.ControllerContext{})
+		Expect(err).NotTo(HaveOccurred())
+
+		received, loaded = nil, nil
+		engine = gin.New()
+		engine.Use(withUser, handler)
+		engine.GET("/", func(c *gin.Context) {
+			received = c.Request.Header.Clone()
+			if p, ok := FromContext(c); ok {
+				loaded = &p
+			}
+		})
+	})
+
+	request := func(user string, headers map[string]string) {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		if user != "" {
+			r.Header.Set("X-User", user)
+		}
+		for name, value := range headers {
+			r.Header.Set(name, value)
+		}
+		engine.ServeHTTP(httptest.NewRecorder(), r)
+	}
+
+	It("should validate the configuration", func() {
+		Expect(cfg.Validate()).To(Succeed())
+		Expect(MiddlewareConfig{}.Validate()).To(Succeed())
+		Expect(MiddlewareConfig{Headers: map[string]string{"X-A": "password"}}.Validate()).To(MatchError(ContainSubstring("password")))
+		Expect(MiddlewareConfig{Headers: map[string]string{"X-A": "preferences."}}.Validate()).To(HaveOccurred())
+		Expect(MiddlewareConfig{Headers: map[string]string{"Bad Header": "email"}}.Validate()).To(MatchError(ContainSubstring("header name")))
+		Expect(MiddlewareConfig{ClaimsHeader: "Bad:Header"}.Validate()).To(MatchError(ContainSubstring("claims_header")))
+	})
+
+	It("should forward the profile to the backends", func() {
+		request("alice", nil)
+		Expect(received.Get("X-User-Name")).To(Equal("Alice"))
+		Expect(received.Get("X-User-Locale")).To(Equal("es-ES"))
+		Expect(received.Get("X-User-Theme")).To(Equal("dark"))
+		Expect(received.Get("X-User-Size")).To(Equal("20"))
+
+		claims, err := base64.RawURLEncoding.DecodeString(received.Get("X-User-Profile"))
+		Expect(err).NotTo(HaveOccurred())
+		var p Profile
+		Expect(json.Unmarshal(claims, &p)).To(Succeed())
+		Expect(p.User).To(Equal("alice"))
+		Expect(p.DisplayName).To(Equal("Alice"))
+	})
+
+	It("should expose the profile to the handlers", func() {
+		request("alice", nil)
+		Expect(loaded).NotTo(BeNil())
+		Expect(loaded.DisplayName).To(Equal("Alice"))
+
+		request("bob", nil)
+		Expect(loaded).To(Equal(&Profile{User: "bob"}))
+		Expect(received.Get("X-User-Name")).To(BeEmpty())
+		Expect(received.Get("X-User-Profile")).NotTo(BeEmpty())
+	})
+
+	It("should remove the headers sent by the clients", func() {
+		forged := map[string]string{"X-User-Name": "Mallory", "X-User-Profile": "e30"}
+		request("", forged)
+		Expect(received).NotTo(HaveKey("X-User-Name"))
+		Expect(received).NotTo(HaveKey("X-User-Profile"))
+		Expect(loaded).To(BeNil())
+
+		request("bob", forged)
+		Expect(received).NotTo(HaveKey("X-User-Name"))
+	})
+
+	It("should let the requests through when the store fails", func() {
+		store.err = context.DeadlineExceeded
+		request("alice", map[string]string{"X-User-Name": "Mallory"})
+		Expect(received).NotTo(BeNil())
+		Expect(received).NotTo(HaveKey("X-User-Name"))
+		Expect(loaded).To(BeNil())
+	})
+})
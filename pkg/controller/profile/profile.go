@@ -0,0 +1,256 @@
+// Package profile provides the profiles of the signed-in users: a display name, an email address,
+// a locale, a time zone and free-form preferences, read and updated by each user through a
+// controller. A middleware loads the profile of the requests, exposing it to the templates and
+// forwarding it to the backends in headers. The profiles are kept in a Store, PostgreSQL or SQLite,
+// injected when the types are registered:
+//
+//	store := profile.NewPostgresStore(pool)
+//	server.RegisterController("profile", profile.NewController(store))
+//	server.RegisterMiddleware("profile", profile.NewMiddleware(store))
+package profile
+
+import (
+	"context"
+	"encoding/json"
+	"maps"
+	"net/http"
+	"net/mail"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/animalet/sargantana-go/internal/snapshot"
+	"github.com/animalet/sargantana-go/pkg/server"
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	"golang.org/x/text/language"
+)
+
+const (
+	defaultMaxPreferencesSize = 16 << 10
+	maxFieldLength            = 256
+	migrationTimeout          = time.Minute
+)
+
+// Config holds the route of a profile controller
+type Config struct {
+	// Path serves the profile of the signed-in user on GET, replaces it on PUT and merges changes
+	// into the preferences on PATCH Path/preferences. All require authentication.
+	Path string `yaml:"path"`
+	// Template renders the profile for the browsers, with the user and profile fields. Optional,
+	// the profile is served as JSON otherwise.
+	Template string `yaml:"template,omitempty"`
+	// MaxPreferencesSize bounds the JSON encoded preferences, in bytes. Defaults to 16KiB.
+	MaxPreferencesSize int `yaml:"max_preferences_size,omitempty"`
+}
+
+func (c Config) Validate() error {
+	if c.Path == "" {
+		return errors.New("path must be set and non-empty")
+	}
+	if c.MaxPreferencesSize < 0 {
+		return errors.New("max_preferences_size must not be negative")
+	}
+	return nil
+}
+
+// NewController returns the factory of profile controllers keeping the profiles in the store. The
+// store is migrated when a controller is created.
+func NewController(store Store) func(cfg *Config, ctx server.ControllerContext) (server.IController, error) {
+	return func(cfg *Config, ctx server.ControllerContext) (server.IController, error) {
+		if err := migrate(store); err != nil {
+			return nil, err
+		}
+		// Deep copy the config to enforce immutability
+		configCopy := snapshot.MustCopy(cfg)
+		if configCopy.MaxPreferencesSize == 0 {
+			configCopy.MaxPreferencesSize = defaultMaxPreferencesSize
+		}
+		log.Info().Str("path", ctx.BasePath+configCopy.Path).Msg("Profile controller configured")
+		return &controller{store: store, config: configCopy, login: ctx.LoginMiddleware()}, nil
+	}
+}
+
+// migrate migrates the store, which must not be nil
+func migrate(store Store) error {
+	if store == nil {
+		return errors.New("profile store must not be nil")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), migrationTimeout)
+	defer cancel()
+	return errors.Wrap(store.Migrate(ctx), "failed to migrate the profile store")
+}
+
+type controller struct {
+	store  Store
+	config *Config
+	login  gin.HandlerFunc // Login middleware of the binding, used without one from Bind
+}
+
+// profileForm is the body replacing a profile
+type profileForm struct {
+	DisplayName string         `json:"display_name"`
+	Email       string         `json:"email"`
+	Locale      string         `json:"locale"`
+	Timezone    string         `json:"timezone"`
+	Preferences map[string]any `json:"preferences"`
+}
+
+func (p *controller) Bind(engine *gin.Engine, loginMiddleware gin.HandlerFunc) error {
+	return p.BindGroup(&engine.RouterGroup, loginMiddleware)
+}
+
+func (p *controller) BindGroup(group *gin.RouterGroup, loginMiddleware gin.HandlerFunc) error {
+	if loginMiddleware == nil {
+		loginMiddleware = p.login
+	}
+	group.GET(p.config.Path, loginMiddleware, p.get)
+	group.PUT(p.config.Path, loginMiddleware, p.put)
+	group.PATCH(p.config.Path+"/preferences", loginMiddleware, p.patchPreferences)
+	return nil
+}
+
+// Close does nothing, the store is owned by the application
+func (p *controller) Close() error { return nil }
+
+func (p *controller) get(c *gin.Context) {
+	profile, ok := p.load(c)
+	if !ok {
+		return
+	}
+	if p.config.Template != "" && c.NegotiateFormat(gin.MIMEJSON, gin.MIMEHTML) == gin.MIMEHTML {
+		c.HTML(http.StatusOK, p.config.Template, gin.H{"user": profile.User, "profile": profile})
+		return
+	}
+	c.JSON(http.StatusOK, profile)
+}
+
+func (p *controller) put(c *gin.Context) {
+	user := server.UserId(c)
+	if user == "" {
+		server.RespondError(c, http.StatusUnauthorized)
+		return
+	}
+	var form profileForm
+	if err := c.ShouldBindJSON(&form); err != nil {
+		server.RespondError(c, http.StatusBadRequest)
+		return
+	}
+	p.save(c, Profile{
+		User:        user,
+		DisplayName: form.DisplayName,
+		Email:       form.Email,
+		Locale:      form.Locale,
+		Timezone:    form.Timezone,
+		Preferences: form.Preferences,
+	})
+}
+
+// patchPreferences merges a JSON object into the preferences, removing those set to null, as in
+// a JSON merge patch of the top-level keys.
+func (p *controller) patchPreferences(c *gin.Context) {
+	var patch map[string]any
+	if err := c.ShouldBindJSON(&patch); err != nil {
+		server.RespondError(c, http.StatusBadRequest)
+		return
+	}
+	profile, ok := p.load(c)
+	if !ok {
+		return
+	}
+	preferences := maps.Clone(profile.Preferences)
+	if preferences == nil {
+		preferences = make(map[string]any, len(patch))
+	}
+	for key, value := range patch {
+		if value == nil {
+			delete(preferences, key)
+		} else {
+			preferences[key] = value
+		}
+	}
+	profile.Preferences = preferences
+	p.save(c, profile)
+}
+
+// load reads the profile of the signed-in user, empty if there is none yet
+func (p *controller) load(c *gin.Context) (Profile, bool) {
+	user := server.UserId(c)
+	if user == "" {
+		server.RespondError(c, http.StatusUnauthorized)
+		return Profile{}, false
+	}
+	profile, err := p.store.Get(c.Request.Context(), user)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		p.fail(c, err)
+		return Profile{}, false
+	}
+	profile.User = user
+	return profile, true
+}
+
+// save validates and stores a profile, answering with the stored profile
+func (p *controller) save(c *gin.Context, profile Profile) {
+	if err := p.validate(profile); err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	ctx := c.Request.Context()
+	if err := p.store.Put(ctx, profile); err != nil {
+		p.fail(c, err)
+		return
+	}
+	saved, err := p.store.Get(ctx, profile.User)
+	if err != nil {
+		p.fail(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, saved)
+}
+
+func (p *controller) validate(profile Profile) error {
+	for name, value := range map[string]string{
+		"display_name": profile.DisplayName,
+		"email":        profile.Email,
+		"locale":       profile.Locale,
+		"timezone":     profile.Timezone,
+	} {
+		if len(value) > maxFieldLength {
+			return errors.Errorf("%s must not be longer than %d bytes", name, maxFieldLength)
+		}
+		if strings.IndexFunc(value, unicode.IsControl) >= 0 {
+			return errors.Errorf("%s must not contain control characters", name)
+		}
+	}
+	if profile.Email != "" {
+		if address, err := mail.ParseAddress(profile.Email); err != nil || address.Address != profile.Email {
+			return errors.New("email must be an email address")
+		}
+	}
+	if profile.Locale != "" {
+		if _, err := language.Parse(profile.Locale); err != nil {
+			return errors.New("locale must be a BCP 47 language tag")
+		}
+	}
+	if profile.Timezone != "" {
+		if _, err := time.LoadLocation(profile.Timezone); err != nil {
+			return errors.New("timezone must be an IANA time zone")
+		}
+	}
+	encoded, err := json.Marshal(profile.Preferences)
+	if err != nil {
+		return errors.New("preferences must be a JSON object")
+	}
+	if len(encoded) > p.config.MaxPreferencesSize {
+		return errors.Errorf("preferences must not be larger than %d bytes", p.config.MaxPreferencesSize)
+	}
+	return nil
+}
+
+// fail logs the error and answers 500
+func (p *controller) fail(c *gin.Context, err error) {
+	logger := server.RequestLogger(c)
+	logger.Error().Err(err).Msg("Profile request failed")
+	server.RespondError(c, http.StatusInternalServerError)
+}
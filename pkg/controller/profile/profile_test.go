@@ -0,0 +1,208 @@
+//go:build unit
+
+package profile
+
+import (
+	"context"
+	"encoding/json"
+	"html/template"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+
+	"github.com/animalet/sargantana-go/pkg/server"
+	"github.com/gin-gonic/gin"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+// memoryStore is a Store keeping the profiles in memory
+type memoryStore struct {
+	mu       sync.Mutex
+	profiles map[string]Profile
+	migrated int
+	err      error
+}
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{profiles: make(map[string]Profile)}
+}
+
+func (m *memoryStore) Migrate(context.Context) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.migrated++
+	return nil
+}
+
+func (m *memoryStore) Get(_ context.Context, user string) (Profile, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.err != nil {
+		return Profile{}, m.err
+	}
+	p, ok := m.profiles[user]
+	if !ok {
+		return Profile{User: user}, ErrNotFound
+	}
+	p.Preferences = maps.Clone(p.Preferences)
+	return p, nil
+}
+
+func (m *memoryStore) Put(_ context.Context, profile Profile) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.err != nil {
+		return m.err
+	}
+	if profile.Preferences == nil {
+		profile.Preferences = map[string]any{}
+	}
+	m.profiles[profile.User] = profile
+	return nil
+}
+
+func (m *memoryStore) Delete(_ context.Context, user string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.err != nil {
+		return m.err
+	}
+	delete(m.profiles, user)
+	return nil
+}
+
+// withUser authenticates the requests with an X-User header
+func withUser(c *gin.Context) {
+	if user := c.GetHeader("X-User"); user != "" {
+		c.Set(server.RemoteUserKey, user)
+	}
+}
+
+var _ = Describe("Profile controller", func() {
+	var (
+		store  *memoryStore
+		engine *gin.Engine
+	)
+
+	cfg := Config{Path: "/profile", Template: "profile.html", MaxPreferencesSize: 64}
+
+	BeforeEach(func() {
+		gin.SetMode(gin.TestMode)
+		store = newMemoryStore()
+		ctrl, err := NewController(store)(&cfg, server.ControllerContext{})
+		Expect(err).NotTo(HaveOccurred())
+
+		engine = gin.New()
+		engine.SetHTMLTemplate(template.Must(template.New("profile.html").Parse(`{{.user}}|{{.profile.DisplayName}}`)))
+		engine.Use(withUser)
+		login := func(c *gin.Context) {
+			if _, ok := server.GetRemoteUser(c); !ok {
+				c.AbortWithStatus(http.StatusUnauthorized)
+			}
+		}
+		Expect(ctrl.(server.GroupController).BindGroup(engine.Group("/account"), login)).To(Succeed())
+	})
+
+	request := func(method, path, user, body string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, path, strings.NewReader(body))
+		if body != "" {
+			r.Header.Set("Content-Type", "application/json")
+		}
+		if user != "" {
+			r.Header.Set("X-User", user)
+		}
+		w := httptest.NewRecorder()
+		engine.ServeHTTP(w, r)
+		return w
+	}
+
+	decode := func(w *httptest.ResponseRecorder) Profile {
+		var p Profile
+		Expect(json.Unmarshal(w.Body.Bytes(), &p)).To(Succeed())
+		return p
+	}
+
+	It("should validate the configuration", func() {
+		Expect(Config{}.Validate()).To(MatchError(ContainSubstring("path")))
+		Expect(Config{Path: "/p", MaxPreferencesSize: -1}.Validate()).To(MatchError(ContainSubstring("max_preferences_size")))
+		Expect(cfg.Validate()).To(Succeed())
+	})
+
+	It("should migrate the store and require one", func() {
+		Expect(store.migrated).To(Equal(1))
+		_, err := NewController(nil)(&cfg, server.ControllerContext{})
+		Expect(err).To(MatchError(ContainSubstring("store")))
+	})
+
+	It("should require authentication", func() {
+		Expect(request(http.MethodGet, "/account/profile", "", "").Code).To(Equal(http.StatusUnauthorized))
+		Expect(request(http.MethodPut, "/account/profile", "", `{}`).Code).To(Equal(http.StatusUnauthorized))
+	})
+
+	It("should serve an empty profile to new users", func() {
+		w := request(http.MethodGet, "/account/profile", "alice", "")
+		Expect(w.Code).To(Equal(http.StatusOK))
+		Expect(decode(w)).To(Equal(Profile{User: "alice"}))
+	})
+
+	It("should replace the profile of the signed-in user", func() {
+		w := request(http.MethodPut, "/account/profile", "alice",
+			`{"display_name":"Alice","email":"alice@example.com","locale":"es-ES","timezone":"UTC","preferences":{"theme":"dark"}}`)
+		Expect(w.Code).To(Equal(http.StatusOK))
+		p := decode(w)
+		Expect(p.User).To(Equal("alice"))
+		Expect(p.DisplayName).To(Equal("Alice"))
+		Expect(p.Preferences).To(Equal(map[string]any{"theme": "dark"}))
+
+		Expect(store.profiles).To(HaveKey("alice"))
+		Expect(store.profiles).NotTo(HaveKey("bob"))
+		Expect(decode(request(http.MethodGet, "/account/profile", "bob", "")).DisplayName).To(BeEmpty())
+	})
+
+	It("should merge changes into the preferences", func() {
+		Expect(request(http.MethodPut, "/account/profile", "alice", `{"display_name":"Alice","preferences":{"theme":"dark","lang":"es"}}`).Code).To(Equal(http.StatusOK))
+
+		w := request(http.MethodPatch, "/account/profile/preferences", "alice", `{"theme":"light","lang":null,"page_size":20}`)
+		Expect(w.Code).To(Equal(http.StatusOK))
+		p := decode(w)
+		Expect(p.DisplayName).To(Equal("Alice"))
+		Expect(p.Preferences).To(Equal(map[string]any{"theme": "light", "page_size": float64(20)}))
+
+		Expect(request(http.MethodPatch, "/account/profile/preferences", "alice", `["theme"]`).Code).To(Equal(http.StatusBadRequest))
+	})
+
+	It("should reject invalid profiles", func() {
+		for _, body := range []string{
+			`{"email":"not an address"}`,
+			`{"locale":"not a locale!"}`,
+			`{"timezone":"Mars/Olympus"}`,
+			`{"display_name":"line\nbreak"}`,
+			`{"display_name":"` + strings.Repeat("a", maxFieldLength+1) + `"}`,
+			`{"preferences":{"notes":"` + strings.Repeat("a", 64) + `"}}`,
+			`not json`,
+		} {
+			Expect(request(http.MethodPut, "/account/profile", "alice", body).Code).To(Equal(http.StatusBadRequest), body)
+		}
+		Expect(store.profiles).To(BeEmpty())
+	})
+
+	It("should render the profile for browsers", func() {
+		Expect(request(http.MethodPut, "/account/profile", "alice", `{"display_name":"Alice"}`).Code).To(Equal(http.StatusOK))
+
+		r := httptest.NewRequest(http.MethodGet, "/account/profile", nil)
+		r.Header.Set("X-User", "alice")
+		r.Header.Set("Accept", "text/html")
+		w := httptest.NewRecorder()
+		engine.ServeHTTP(w, r)
+		Expect(w.Code).To(Equal(http.StatusOK))
+		Expect(w.Body.String()).To(Equal("alice|Alice"))
+	})
+
+	It("should answer 500 when the store fails", func() {
+		store.err = context.DeadlineExceeded
+		Expect(request(http.MethodGet, "/account/profile", "alice", "").Code).To(Equal(http.StatusInternalServerError))
+	})
+})
@@ -0,0 +1,118 @@
+package profile
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"time"
+
+	"github.com/animalet/sargantana-go/internal/sqlstore"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/jackc/pgx/v5/stdlib"
+	"github.com/pkg/errors"
+)
+
+// ErrNotFound is returned by stores for users without a profile
+var ErrNotFound = errors.New("profile not found")
+
+// Profile holds the profile and the preferences of a user. Preferences are free-form settings of
+// the applications, e.g. {"theme": "dark"}.
+type Profile struct {
+	User        string         `json:"user"`
+	DisplayName string         `json:"display_name"`
+	Email       string         `json:"email"`
+	Locale      string         `json:"locale"`
+	Timezone    string         `json:"timezone"`
+	Preferences map[string]any `json:"preferences"`
+	UpdateDate  time.Time      `json:"update_date"`
+}
+
+// Store persists the profiles. Stores are injected in the controller and middleware factories, see
+// NewController, so that their connections are owned and closed by the application.
+type Store interface {
+	// Migrate creates or upgrades the tables of the store. Called by the factories.
+	Migrate(ctx context.Context) error
+	// Get returns the profile of a user, or ErrNotFound.
+	Get(ctx context.Context, user string) (Profile, error)
+	// Put creates or replaces the profile of a user.
+	Put(ctx context.Context, profile Profile) error
+	// Delete removes the profile of a user, if any.
+	Delete(ctx context.Context, user string) error
+}
+
+var (
+	postgresDialect = sqlstore.Dialect{
+		Numbered: true,
+		Lock:     "SELECT pg_advisory_xact_lock(4242002)",
+		Migrations: []string{
+			`CREATE TABLE IF NOT EXISTS user_profiles (
+				user_id TEXT PRIMARY KEY,
+				display_name TEXT NOT NULL DEFAULT '',
+				email TEXT NOT NULL DEFAULT '',
+				locale TEXT NOT NULL DEFAULT '',
+				timezone TEXT NOT NULL DEFAULT '',
+				preferences TEXT NOT NULL DEFAULT '{}',
+				update_date TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+			)`,
+		},
+	}
+	sqliteDialect = sqlstore.Dialect{
+		Migrations: postgresDialect.Migrations,
+	}
+)
+
+// sqlStore is a Store on a database/sql database
+type sqlStore struct {
+	sqlstore.Store
+}
+
+// NewPostgresStore returns a Store keeping the profiles in PostgreSQL. The pool is not closed by
+// the store.
+func NewPostgresStore(pool *pgxpool.Pool) Store {
+	return &sqlStore{sqlstore.Store{DB: stdlib.OpenDBFromPool(pool), Dialect: postgresDialect, MigrationsTable: "profile_migrations"}}
+}
+
+// NewSQLiteStore returns a Store keeping the profiles in SQLite. The database must be opened with a
+// SQLite driver registered by the application, e.g. modernc.org/sqlite, and is not closed by the
+// store.
+func NewSQLiteStore(db *sql.DB) Store {
+	return &sqlStore{sqlstore.Store{DB: db, Dialect: sqliteDialect, MigrationsTable: "profile_migrations"}}
+}
+
+func (s *sqlStore) Get(ctx context.Context, user string) (Profile, error) {
+	p := Profile{User: user}
+	var preferences string
+	err := s.DB.QueryRowContext(ctx, s.Rebind(
+		"SELECT display_name, email, locale, timezone, preferences, update_date FROM user_profiles WHERE user_id = ?"), user).
+		Scan(&p.DisplayName, &p.Email, &p.Locale, &p.Timezone, &preferences, &p.UpdateDate)
+	if errors.Is(err, sql.ErrNoRows) {
+		return p, ErrNotFound
+	}
+	if err != nil {
+		return p, errors.Wrap(err, "failed to read profile")
+	}
+	return p, errors.Wrap(json.Unmarshal([]byte(preferences), &p.Preferences), "failed to decode preferences")
+}
+
+func (s *sqlStore) Put(ctx context.Context, profile Profile) error {
+	preferences, err := json.Marshal(profile.Preferences)
+	if err != nil {
+		return errors.Wrap(err, "failed to encode preferences")
+	}
+	if profile.Preferences == nil {
+		preferences = []byte("{}")
+	}
+	_, err = s.DB.ExecContext(ctx, s.Rebind(
+		"INSERT INTO user_profiles (user_id, display_name, email, locale, timezone, preferences, update_date) "+
+			"VALUES (?, ?, ?, ?, ?, ?, ?) ON CONFLICT (user_id) DO UPDATE SET "+
+			"display_name = EXCLUDED.display_name, email = EXCLUDED.email, locale = EXCLUDED.locale, "+
+			"timezone = EXCLUDED.timezone, preferences = EXCLUDED.preferences, update_date = EXCLUDED.update_date"),
+		profile.User, profile.DisplayName, profile.Email, profile.Locale, profile.Timezone, string(preferences), time.Now().UTC(),
+	)
+	return errors.Wrap(err, "failed to save profile")
+}
+
+func (s *sqlStore) Delete(ctx context.Context, user string) error {
+	_, err := s.DB.ExecContext(ctx, s.Rebind("DELETE FROM user_profiles WHERE user_id = ?"), user)
+	return errors.Wrap(err, "failed to delete profile")
+}
@@ -0,0 +1,57 @@
+//go:build integration
+
+package profile
+
+import (
+	"context"
+
+	"github.com/animalet/sargantana-go/pkg/database"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("PostgreSQL store", func() {
+	var store Store
+
+	BeforeEach(func() {
+		pool, err := database.PostgresConfig{
+			Host:     "localhost",
+			Port:     5432,
+			User:     "user",
+			Password: "password",
+			Database: "my_blog_db",
+			SSLMode:  "disable",
+		}.CreateClient()
+		Expect(err).NotTo(HaveOccurred())
+		DeferCleanup(pool.Close)
+		store = NewPostgresStore(pool)
+	})
+
+	It("should migrate idempotently", func() {
+		Expect(store.Migrate(context.Background())).To(Succeed())
+		Expect(store.Migrate(context.Background())).To(Succeed())
+	})
+
+	It("should create and replace profiles", func() {
+		ctx := context.Background()
+		Expect(store.Migrate(ctx)).To(Succeed())
+
+		_, err := store.Get(ctx, "profile-integration-missing")
+		Expect(err).To(MatchError(ErrNotFound))
+
+		Expect(store.Put(ctx, Profile{User: "profile-integration", DisplayName: "Alice", Preferences: map[string]any{"theme": "dark"}})).To(Succeed())
+		Expect(store.Put(ctx, Profile{User: "profile-integration", DisplayName: "Alice B.", Locale: "es"})).To(Succeed())
+
+		p, err := store.Get(ctx, "profile-integration")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(p.DisplayName).To(Equal("Alice B."))
+		Expect(p.Locale).To(Equal("es"))
+		Expect(p.Preferences).To(BeEmpty())
+		Expect(p.UpdateDate).NotTo(BeZero())
+
+		Expect(store.Delete(ctx, "profile-integration")).To(Succeed())
+		Expect(store.Delete(ctx, "profile-integration")).To(Succeed())
+		_, err = store.Get(ctx, "profile-integration")
+		Expect(err).To(MatchError(ErrNotFound))
+	})
+})
@@ -0,0 +1,172 @@
+package controller
+
+import (
+	"net/http"
+
+	"github.com/animalet/sargantana-go/internal/snapshot"
+	"github.com/animalet/sargantana-go/pkg/openapi"
+	"github.com/animalet/sargantana-go/pkg/server"
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+)
+
+// QuotaAdminControllerConfig configures the admin endpoint viewing and resetting the usage of the
+// quotas of the quota middlewares.
+type QuotaAdminControllerConfig struct {
+	Path string `yaml:"path"`
+	Auth bool   `yaml:"auth"`
+}
+
+func (c QuotaAdminControllerConfig) Validate() error {
+	if c.Path == "" {
+		return errors.New("path must be set and non-empty")
+	}
+	return nil
+}
+
+// Describe adds the routes of the controller to the OpenAPI document, see openapi.Describer.
+func (c QuotaAdminControllerConfig) Describe(doc *openapi.Document, basePath string) {
+	tags := []string{"admin"}
+	var errs map[string]openapi.Response
+	if c.Auth {
+		errs = openapi.Errors(http.StatusUnauthorized)
+	}
+	doc.Add(http.MethodGet, basePath, c.Path, openapi.Operation{
+		OperationID: "listQuotas",
+		Summary:     "Lists the quotas",
+		Tags:        tags,
+		Responses: openapi.With(map[string]openapi.Response{
+			"200": {Description: "The quotas", Content: openapi.JSON(&openapi.Schema{Type: "array", Items: doc.Schema("Quota", quotaResponse{})})},
+		}, errs),
+	})
+	doc.Add(http.MethodGet, basePath, openapi.Join(c.Path, ":quota"), openapi.Operation{
+		OperationID: "getQuotaUsage",
+		Summary:     "Returns the usage of the subjects of the quota, in the current period by default",
+		Tags:        tags,
+		Parameters:  []openapi.Parameter{{Name: "period", In: "query", Schema: openapi.String()}},
+		Responses: openapi.With(map[string]openapi.Response{
+			"200": {Description: "The usage of the quota", Content: openapi.JSON(doc.Schema("QuotaUsage", quotaUsageResponse{}))},
+		}, openapi.With(errs, openapi.Errors(http.StatusNotFound, http.StatusServiceUnavailable))),
+	})
+	doc.Add(http.MethodDelete, basePath, openapi.Join(c.Path, ":quota/:subject"), openapi.Operation{
+		OperationID: "resetQuotaUsage",
+		Summary:     "Resets the usage of the subject of the quota, in the current period by default",
+		Tags:        tags,
+		Parameters:  []openapi.Parameter{{Name: "period", In: "query", Schema: openapi.String()}},
+		Responses: openapi.With(map[string]openapi.Response{
+			"204": {Description: "The usage is reset"},
+		}, openapi.With(errs, openapi.Errors(http.StatusNotFound, http.StatusServiceUnavailable))),
+	})
+}
+
+// NewQuotaAdminController creates a controller that lists the quotas on GET {path}, reports the
+// usage of the subjects of a quota on GET {path}/{quota} and resets the usage of a subject on
+// DELETE {path}/{quota}/{subject}. The period defaults to the current one and can be selected
+// with the period query parameter, e.g. ?period=2024-05.
+func NewQuotaAdminController(c *QuotaAdminControllerConfig, _ server.ControllerContext) (server.IController, error) {
+	// Deep copy the config to enforce immutability
+	configCopy := snapshot.MustCopy(c)
+
+	log.Info().Str("path", configCopy.Path).Bool("auth", configCopy.Auth).Msg("Quota admin endpoint configured")
+	if !configCopy.Auth {
+		log.Warn().Str("path", configCopy.Path).Msg("Quota admin endpoint is not protected by authentication")
+	}
+
+	return &quotaAdmin{
+		path: configCopy.Path,
+		auth: configCopy.Auth,
+	}, nil
+}
+
+type quotaAdmin struct {
+	server.IController
+	path string
+	auth bool
+}
+
+type quotaResponse struct {
+	Name  string `json:"name"`
+	Limit int64  `json:"limit"`
+	Unit  string `json:"unit"`
+}
+
+type quotaUsageResponse struct {
+	quotaResponse
+	Period string           `json:"period"`
+	Usage  map[string]int64 `json:"usage"`
+}
+
+func (q *quotaAdmin) Bind(engine *gin.Engine, loginMiddleware gin.HandlerFunc) error {
+	return q.BindGroup(&engine.RouterGroup, loginMiddleware)
+}
+
+func (q *quotaAdmin) BindGroup(group *gin.RouterGroup, loginMiddleware gin.HandlerFunc) error {
+	admin := group.Group(q.path)
+	if q.auth {
+		admin.Use(loginMiddleware)
+	}
+	admin.GET("", q.list)
+	admin.GET("/:quota", q.usage)
+	admin.DELETE("/:quota/:subject", q.reset)
+	return nil
+}
+
+func (q *quotaAdmin) Close() error {
+	return nil
+}
+
+func (q *quotaAdmin) list(c *gin.Context) {
+	response := make([]quotaResponse, 0)
+	for _, name := range server.QuotaNames() {
+		if quota, ok := server.LookupQuota(name); ok {
+			response = append(response, describeQuota(name, quota))
+		}
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+func (q *quotaAdmin) usage(c *gin.Context) {
+	quota, ok := server.LookupQuota(c.Param("quota"))
+	if !ok {
+		server.RespondError(c, http.StatusNotFound)
+		return
+	}
+	period := c.DefaultQuery("period", quota.CurrentPeriod())
+	usage, err := quota.Usage(c.Request.Context(), period)
+	if err != nil {
+		logger := server.RequestLogger(c)
+		logger.Error().Err(err).Str("quota", c.Param("quota")).Msg("Failed to read quota usage")
+		server.RespondError(c, http.StatusServiceUnavailable)
+		return
+	}
+	c.JSON(http.StatusOK, quotaUsageResponse{
+		quotaResponse: describeQuota(c.Param("quota"), quota),
+		Period:        period,
+		Usage:         usage,
+	})
+}
+
+func (q *quotaAdmin) reset(c *gin.Context) {
+	name, subject := c.Param("quota"), c.Param("subject")
+	quota, ok := server.LookupQuota(name)
+	if !ok {
+		server.RespondError(c, http.StatusNotFound)
+		return
+	}
+	period := c.DefaultQuery("period", quota.CurrentPeriod())
+	if err := quota.Reset(c.Request.Context(), subject, period); err != nil {
+		logger := server.RequestLogger(c)
+		logger.Error().Err(err).Str("quota", name).Msg("Failed to reset quota usage")
+		server.RespondError(c, http.StatusServiceUnavailable)
+		return
+	}
+	log.Info().Str("quota", name).Str("subject", subject).Str("period", period).Msg("Quota usage reset")
+	server.Audit(c, "quota_reset", map[string]any{"quota": name, "subject": subject, "period": period})
+	c.Status(http.StatusNoContent)
+}
+
+func describeQuota(name string, quota *server.Quota) quotaResponse {
+	limit, unit := quota.Limit()
+	return quotaResponse{Name: name, Limit: limit, Unit: unit}
+}
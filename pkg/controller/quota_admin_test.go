@@ -0,0 +1,78 @@
+//go:build unit
+
+package controller
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/animalet/sargantana-go/pkg/server"
+	"github.com/gin-gonic/gin"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("QuotaAdminController", func() {
+	var engine *gin.Engine
+
+	BeforeEach(func() {
+		gin.SetMode(gin.TestMode)
+		engine = gin.New()
+		ctrl, err := NewQuotaAdminController(&QuotaAdminControllerConfig{Path: "/admin/quotas"}, server.ControllerContext{})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(ctrl.Bind(engine, nil)).To(Succeed())
+	})
+
+	request := func(method, path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(method, path, nil)
+		req.Header.Set("X-Tenant-ID", "acme")
+		engine.ServeHTTP(w, req)
+		return w
+	}
+
+	It("should require a path", func() {
+		Expect(QuotaAdminControllerConfig{}.Validate()).To(HaveOccurred())
+		Expect(QuotaAdminControllerConfig{Path: "/admin/quotas"}.Validate()).To(Succeed())
+	})
+
+	It("should list the quotas and view and reset their usage", func() {
+		middleware, err := server.NewQuotaMiddleware(server.QuotaConfig{Name: "admin-test", Limit: 100, Subject: "header", Header: "X-Tenant-ID"})
+		Expect(err).NotTo(HaveOccurred())
+		engine.GET("/api", middleware, func(c *gin.Context) { c.Status(http.StatusOK) })
+		Expect(request(http.MethodGet, "/api").Code).To(Equal(http.StatusOK))
+		Expect(request(http.MethodGet, "/api").Code).To(Equal(http.StatusOK))
+
+		w := request(http.MethodGet, "/admin/quotas")
+		Expect(w.Code).To(Equal(http.StatusOK))
+		var quotas []quotaResponse
+		Expect(json.Unmarshal(w.Body.Bytes(), &quotas)).To(Succeed())
+		Expect(quotas).To(ContainElement(quotaResponse{Name: "admin-test", Limit: 100, Unit: "requests"}))
+
+		w = request(http.MethodGet, "/admin/quotas/admin-test")
+		Expect(w.Code).To(Equal(http.StatusOK))
+		var usage quotaUsageResponse
+		Expect(json.Unmarshal(w.Body.Bytes(), &usage)).To(Succeed())
+		quota, _ := server.LookupQuota("admin-test")
+		Expect(usage.Period).To(Equal(quota.CurrentPeriod()))
+		Expect(usage.Usage).To(Equal(map[string]int64{"acme": 2}))
+
+		Expect(request(http.MethodDelete, "/admin/quotas/admin-test/acme").Code).To(Equal(http.StatusNoContent))
+		w = request(http.MethodGet, "/admin/quotas/admin-test")
+		usage = quotaUsageResponse{}
+		Expect(json.Unmarshal(w.Body.Bytes(), &usage)).To(Succeed())
+		Expect(usage.Usage).To(BeEmpty())
+
+		w = request(http.MethodGet, "/admin/quotas/admin-test?period=2000-01-01")
+		usage = quotaUsageResponse{}
+		Expect(json.Unmarshal(w.Body.Bytes(), &usage)).To(Succeed())
+		Expect(usage.Period).To(Equal("2000-01-01"))
+		Expect(usage.Usage).To(BeEmpty())
+	})
+
+	It("should answer 404 for unknown quotas", func() {
+		Expect(request(http.MethodGet, "/admin/quotas/missing").Code).To(Equal(http.StatusNotFound))
+		Expect(request(http.MethodDelete, "/admin/quotas/missing/acme").Code).To(Equal(http.StatusNotFound))
+	})
+})
@@ -0,0 +1,352 @@
+package controller
+
+import (
+	"container/list"
+	"encoding/json"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/animalet/sargantana-go/pkg/database"
+	"github.com/animalet/sargantana-go/pkg/server"
+	"github.com/gin-gonic/gin"
+	"github.com/gomodule/redigo/redis"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	defaultCacheMaxEntries   = 10000
+	defaultCacheMaxBodySize  = 1024 * 1024
+	defaultCacheTagHeader    = "X-Cache-Tags"
+	defaultCachePurgeChannel = "sargantana:cache:purge"
+
+	// CacheStatusHeader tells whether a response was served from the cache (HIT) or not (MISS).
+	CacheStatusHeader = "X-Cache"
+)
+
+// ResponseCacheConfig enables caching of upstream GET responses. Upstreams attach tags to their
+// responses with TagHeader, e.g. "X-Cache-Tags: product-42, catalog", and entries carrying a tag
+// are invalidated through the purge endpoint or a pub/sub message.
+type ResponseCacheConfig struct {
+	TTL time.Duration `yaml:"ttl"`
+	// MaxEntries bounds the number of cached responses, evicting the least recently used ones.
+	// Defaults to 10000.
+	MaxEntries int `yaml:"max_entries,omitempty"`
+	// MaxBodySize is the size in bytes of the largest cached response body. Defaults to 1 MiB.
+	MaxBodySize int64 `yaml:"max_body_size,omitempty"`
+	// TagHeader is the upstream response header listing the cache tags, separated by commas or
+	// spaces. It is not sent to clients. Defaults to X-Cache-Tags.
+	TagHeader string `yaml:"tag_header,omitempty"`
+	// PurgePath enables the purge endpoint, which always requires authentication.
+	PurgePath string `yaml:"purge_path,omitempty"`
+	// PubSub propagates purges to every replica sharing the Redis channel.
+	PubSub *ResponseCachePubSubConfig `yaml:"pubsub,omitempty"`
+}
+
+func (c ResponseCacheConfig) Validate() error {
+	if c.TTL <= 0 {
+		return errors.New("ttl must be greater than zero")
+	}
+	if c.MaxEntries < 0 || c.MaxBodySize < 0 {
+		return errors.New("max_entries and max_body_size must not be negative")
+	}
+	if c.PurgePath != "" && !strings.HasPrefix(c.PurgePath, "/") {
+		return errors.New("purge_path must start with /")
+	}
+	if c.PubSub != nil {
+		if err := c.PubSub.Redis.Validate(); err != nil {
+			return errors.Wrap(err, "invalid pubsub configuration")
+		}
+	}
+	return nil
+}
+
+// ResponseCachePubSubConfig configures the Redis channel purges are published to.
+type ResponseCachePubSubConfig struct {
+	Redis database.RedisConfig `yaml:"redis"`
+	// Channel defaults to sargantana:cache:purge.
+	Channel string `yaml:"channel,omitempty"`
+}
+
+type cachedResponse struct {
+	key     string
+	status  int
+	header  http.Header
+	body    []byte
+	tags    []string
+	expires time.Time
+}
+
+// responseCache is an in-memory LRU cache of upstream responses indexed by tag.
+type responseCache struct {
+	config ResponseCacheConfig
+	now    func() time.Time
+
+	mu      sync.Mutex
+	entries map[string]*list.Element // of *cachedResponse
+	lru     *list.List
+	tags    map[string]map[string]struct{}
+
+	pool    *redis.Pool
+	channel string
+	stop    chan struct{}
+	wg      sync.WaitGroup
+	subMu   sync.Mutex
+	subConn redis.Conn
+}
+
+func newResponseCache(c ResponseCacheConfig) (*responseCache, error) {
+	if c.MaxEntries == 0 {
+		c.MaxEntries = defaultCacheMaxEntries
+	}
+	if c.MaxBodySize == 0 {
+		c.MaxBodySize = defaultCacheMaxBodySize
+	}
+	if c.TagHeader == "" {
+		c.TagHeader = defaultCacheTagHeader
+	}
+	cache := &responseCache{
+		config:  c,
+		now:     time.Now,
+		entries: make(map[string]*list.Element),
+		lru:     list.New(),
+		tags:    make(map[string]map[string]struct{}),
+		stop:    make(chan struct{}),
+	}
+
+	if c.PubSub != nil {
+		pool, err := c.PubSub.Redis.CreateClient()
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to create pubsub redis client")
+		}
+		cache.pool = pool
+		cache.channel = c.PubSub.Channel
+		if cache.channel == "" {
+			cache.channel = defaultCachePurgeChannel
+		}
+		cache.wg.Add(1)
+		go cache.subscribe()
+	}
+	return cache, nil
+}
+
+// cacheable tells whether the request may be answered from the cache. Requests carrying a user
+// are not, as the identity headers forwarded upstream may personalise their responses.
+func (r *responseCache) cacheable(c *gin.Context) bool {
+	return c.Request.Method == http.MethodGet && !hasCacheDirective(c.Request.Header, "no-cache", "no-store") &&
+		!personal(c)
+}
+
+// personal tells whether the request carries a user: a signed-in one, or credentials or cookies
+// that middlewares and hooks may turn into identity headers
+func personal(c *gin.Context) bool {
+	return server.UserId(c) != "" || c.GetHeader("Authorization") != "" || c.GetHeader("Cookie") != ""
+}
+
+func (r *responseCache) get(key string) (*cachedResponse, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	element, ok := r.entries[key]
+	if !ok {
+		return nil, false
+	}
+	entry := element.Value.(*cachedResponse)
+	if !r.now().Before(entry.expires) {
+		r.remove(element)
+		return nil, false
+	}
+	r.lru.MoveToFront(element)
+	return entry, true
+}
+
+// store caches a successful upstream response unless it opts out of caching.
+func (r *responseCache) store(key string, status int, header http.Header, body []byte) {
+	if status != http.StatusOK || header.Get("Set-Cookie") != "" ||
+		hasCacheDirective(header, "no-store", "no-cache", "private") {
+		return
+	}
+	entry := &cachedResponse{
+		key:     key,
+		status:  status,
+		header:  header.Clone(),
+		body:    body,
+		tags:    r.parseTags(header),
+		expires: r.now().Add(r.config.TTL),
+	}
+	entry.header.Del(r.config.TagHeader)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if element, ok := r.entries[key]; ok {
+		r.remove(element)
+	}
+	for r.lru.Len() >= r.config.MaxEntries {
+		r.remove(r.lru.Back())
+	}
+	r.entries[key] = r.lru.PushFront(entry)
+	for _, tag := range entry.tags {
+		if r.tags[tag] == nil {
+			r.tags[tag] = make(map[string]struct{})
+		}
+		r.tags[tag][key] = struct{}{}
+	}
+}
+
+// varies tells whether the response varies on request headers other than the keyed ones, which
+// the cache key does not tell apart
+func varies(header http.Header, keyed ...string) bool {
+	for _, value := range header.Values("Vary") {
+		for _, name := range strings.Split(value, ",") {
+			name = strings.TrimSpace(name)
+			if name == "" {
+				continue
+			}
+			if !slices.ContainsFunc(keyed, func(k string) bool { return strings.EqualFold(k, name) }) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// remove drops an entry and its tag references. Must be called with the lock held.
+func (r *responseCache) remove(element *list.Element) {
+	entry := r.lru.Remove(element).(*cachedResponse)
+	delete(r.entries, entry.key)
+	for _, tag := range entry.tags {
+		delete(r.tags[tag], entry.key)
+		if len(r.tags[tag]) == 0 {
+			delete(r.tags, tag)
+		}
+	}
+}
+
+// invalidate removes the local entries carrying any of the tags and returns their number.
+func (r *responseCache) invalidate(tags []string) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	purged := 0
+	for _, tag := range tags {
+		for key := range r.tags[tag] {
+			if element, ok := r.entries[key]; ok {
+				r.remove(element)
+				purged++
+			}
+		}
+	}
+	return purged
+}
+
+// purge invalidates the tags locally and publishes them to the other replicas.
+func (r *responseCache) purge(tags []string) (int, error) {
+	purged := r.invalidate(tags)
+	if r.pool == nil {
+		return purged, nil
+	}
+	message, err := json.Marshal(tags)
+	if err != nil {
+		return purged, err
+	}
+	conn := r.pool.Get()
+	defer func() { _ = conn.Close() }()
+	_, err = conn.Do("PUBLISH", r.channel, message)
+	return purged, errors.Wrap(err, "failed to publish cache purge")
+}
+
+func (r *responseCache) parseTags(header http.Header) []string {
+	return strings.FieldsFunc(header.Get(r.config.TagHeader), func(c rune) bool {
+		return c == ',' || c == ' '
+	})
+}
+
+// purgeHandler invalidates the entries carrying the tags of a {"tags": [...]} request body.
+func (r *responseCache) purgeHandler(c *gin.Context) {
+	var request struct {
+		Tags []string `json:"tags"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil || len(request.Tags) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "tags must be a non-empty list"})
+		return
+	}
+	purged, err := r.purge(request.Tags)
+	if err != nil {
+		log.Error().Err(err).Strs("tags", request.Tags).Msg("Failed to propagate cache purge")
+		c.JSON(http.StatusBadGateway, gin.H{"error": "purge could not be propagated to other replicas", "purged": purged})
+		return
+	}
+	log.Info().Strs("tags", request.Tags).Int("purged", purged).Msg("Cache entries purged")
+	c.JSON(http.StatusOK, gin.H{"purged": purged})
+}
+
+// subscribe applies the purges published by any replica, reconnecting until the cache is closed.
+func (r *responseCache) subscribe() {
+	defer r.wg.Done()
+	for {
+		conn := r.pool.Get()
+		r.subMu.Lock()
+		select {
+		case <-r.stop:
+			r.subMu.Unlock()
+			_ = conn.Close()
+			return
+		default:
+			r.subConn = conn
+		}
+		r.subMu.Unlock()
+
+		psc := redis.PubSubConn{Conn: conn}
+		err := psc.Subscribe(r.channel)
+		for err == nil {
+			switch message := psc.Receive().(type) {
+			case redis.Message:
+				var tags []string
+				if jsonErr := json.Unmarshal(message.Data, &tags); jsonErr != nil {
+					log.Warn().Err(jsonErr).Msg("Ignoring malformed cache purge message")
+					continue
+				}
+				r.invalidate(tags)
+			case error:
+				err = message
+			}
+		}
+		_ = conn.Close()
+
+		select {
+		case <-r.stop:
+			return
+		case <-time.After(time.Second):
+			log.Warn().Err(err).Str("channel", r.channel).Msg("Cache purge subscription lost, reconnecting")
+		}
+	}
+}
+
+func (r *responseCache) Close() error {
+	if r.pool == nil {
+		return nil
+	}
+	close(r.stop)
+	r.subMu.Lock()
+	if r.subConn != nil {
+		_ = r.subConn.Close()
+	}
+	r.subMu.Unlock()
+	r.wg.Wait()
+	return database.CloseRedis(r.pool)
+}
+
+func hasCacheDirective(header http.Header, directives ...string) bool {
+	for _, value := range header.Values("Cache-Control") {
+		for _, part := range strings.Split(value, ",") {
+			part = strings.ToLower(strings.TrimSpace(part))
+			for _, directive := range directives {
+				if part == directive || strings.HasPrefix(part, directive+"=") {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
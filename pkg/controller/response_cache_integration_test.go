@@ -0,0 +1,54 @@
+//go:build integration
+
+package controller
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/animalet/sargantana-go/pkg/database"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Response Cache PubSub Integration", func() {
+	It("should propagate purges to every replica", func() {
+		cfg := ResponseCacheConfig{
+			TTL: time.Minute,
+			PubSub: &ResponseCachePubSubConfig{
+				Redis: database.RedisConfig{
+					Address:     "localhost:6379",
+					Username:    "redisuser",
+					Password:    "redispass",
+					MaxIdle:     3,
+					IdleTimeout: 240 * time.Second,
+				},
+				Channel: "sargantana:test:cache:purge",
+			},
+		}
+		replicas := make([]*responseCache, 2)
+		for i := range replicas {
+			cache, err := newResponseCache(cfg)
+			Expect(err).NotTo(HaveOccurred())
+			DeferCleanup(cache.Close)
+			cache.store("/products", http.StatusOK, http.Header{defaultCacheTagHeader: []string{"catalog"}}, []byte("products"))
+			replicas[i] = cache
+		}
+
+		cached := func(cache *responseCache) func() bool {
+			return func() bool {
+				_, ok := cache.get("/products")
+				return ok
+			}
+		}
+
+		// Give both replicas time to subscribe
+		time.Sleep(200 * time.Millisecond)
+
+		purged, err := replicas[0].purge([]string{"catalog"})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(purged).To(Equal(1))
+		Expect(cached(replicas[0])()).To(BeFalse())
+		Eventually(cached(replicas[1])).Should(BeFalse())
+	})
+})
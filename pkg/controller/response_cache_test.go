@@ -0,0 +1,270 @@
+//go:build unit
+
+package controller
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/animalet/sargantana-go/pkg/database"
+	"github.com/animalet/sargantana-go/pkg/server"
+	"github.com/gin-gonic/gin"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"gopkg.in/yaml.v3"
+)
+
+var _ = Describe("Response Cache", func() {
+	Context("ResponseCacheConfig Validate", func() {
+		It("should validate the configuration", func() {
+			Expect(ResponseCacheConfig{TTL: time.Minute}.Validate()).To(Succeed())
+			Expect(ResponseCacheConfig{}.Validate()).To(MatchError(ContainSubstring("ttl")))
+			Expect(ResponseCacheConfig{TTL: time.Minute, MaxEntries: -1}.Validate()).To(HaveOccurred())
+			Expect(ResponseCacheConfig{TTL: time.Minute, PurgePath: "purge"}.Validate()).To(HaveOccurred())
+			Expect(ResponseCacheConfig{TTL: time.Minute, PubSub: &ResponseCachePubSubConfig{Redis: database.RedisConfig{}}}.Validate()).
+				To(MatchError(ContainSubstring("pubsub")))
+			Expect(LoadBalancerControllerConfig{Path: "/api", Endpoints: []string{"http://localhost:8080"}, Cache: &ResponseCacheConfig{}}.Validate()).
+				To(MatchError(ContainSubstring("invalid cache configuration")))
+		})
+	})
+
+	Context("Cache", func() {
+		var cache *responseCache
+
+		BeforeEach(func() {
+			var err error
+			cache, err = newResponseCache(ResponseCacheConfig{TTL: time.Minute, MaxEntries: 2})
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		tagged := func(tags string) http.Header {
+			return http.Header{defaultCacheTagHeader: []string{tags}}
+		}
+
+		It("should expire entries after the TTL", func() {
+			now := time.Now()
+			cache.now = func() time.Time { return now }
+			cache.store("/a", http.StatusOK, http.Header{}, []byte("a"))
+			_, ok := cache.get("/a")
+			Expect(ok).To(BeTrue())
+
+			now = now.Add(time.Minute)
+			_, ok = cache.get("/a")
+			Expect(ok).To(BeFalse())
+		})
+
+		It("should evict the least recently used entry", func() {
+			cache.store("/a", http.StatusOK, tagged("a"), []byte("a"))
+			cache.store("/b", http.StatusOK, http.Header{}, []byte("b"))
+			_, _ = cache.get("/a")
+			cache.store("/c", http.StatusOK, http.Header{}, []byte("c"))
+
+			_, ok := cache.get("/b")
+			Expect(ok).To(BeFalse())
+			_, ok = cache.get("/a")
+			Expect(ok).To(BeTrue())
+			Expect(cache.invalidate([]string{"a"})).To(Equal(1))
+			Expect(cache.tags).To(BeEmpty())
+		})
+
+		It("should not store responses that opt out of caching", func() {
+			cache.store("/error", http.StatusInternalServerError, http.Header{}, nil)
+			cache.store("/private", http.StatusOK, http.Header{"Cache-Control": []string{"max-age=60, private"}}, nil)
+			cache.store("/no-store", http.StatusOK, http.Header{"Cache-Control": []string{"no-store"}}, nil)
+			cache.store("/cookie", http.StatusOK, http.Header{"Set-Cookie": []string{"a=b"}}, nil)
+			Expect(cache.entries).To(BeEmpty())
+		})
+
+		It("should invalidate every entry carrying a tag", func() {
+			cache.config.MaxEntries = 10
+			cache.store("/products/1", http.StatusOK, tagged("product-1, catalog"), nil)
+			cache.store("/products/2", http.StatusOK, tagged("product-2 catalog"), nil)
+			cache.store("/about", http.StatusOK, http.Header{}, nil)
+
+			Expect(cache.invalidate([]string{"product-1"})).To(Equal(1))
+			_, ok := cache.get("/products/2")
+			Expect(ok).To(BeTrue())
+			Expect(cache.invalidate([]string{"catalog", "unknown"})).To(Equal(1))
+			_, ok = cache.get("/about")
+			Expect(ok).To(BeTrue())
+			Expect(cache.entries).To(HaveLen(1))
+		})
+	})
+
+	Context("Load balancer", func() {
+		var (
+			engine *gin.Engine
+			calls  atomic.Int32
+			ctrl   server.IController
+		)
+
+		BeforeEach(func() {
+			calls.Store(0)
+			backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				calls.Add(1)
+				if strings.HasPrefix(r.URL.Path, "/api/products") {
+					w.Header().Set(defaultCacheTagHeader, "catalog")
+				}
+				switch r.URL.Path {
+				case "/api/encoded":
+					w.Header().Set("Vary", "Accept-Encoding")
+					if strings.Contains(r.Header.Get("Accept-Encoding"), "gzip") {
+						w.Header().Set("Content-Encoding", "gzip")
+						_, _ = w.Write([]byte("gzipped"))
+						return
+					}
+				case "/api/localized":
+					w.Header().Set("Vary", "Accept-Language")
+				}
+				_, _ = w.Write([]byte("response " + r.URL.RequestURI()))
+			}))
+			DeferCleanup(backend.Close)
+
+			cfgBytes := []byte("path: /api\nendpoints:\n  - " + backend.URL + "\ncache:\n  ttl: 1m\n  purge_path: /admin/cache/purge")
+			var lbCfg LoadBalancerControllerConfig
+			Expect(yaml.Unmarshal(cfgBytes, &lbCfg)).To(Succeed())
+			Expect(lbCfg.Validate()).To(Succeed())
+
+			var err error
+			ctrl, err = NewLoadBalancerController(&lbCfg, server.ControllerContext{})
+			Expect(err).NotTo(HaveOccurred())
+			DeferCleanup(ctrl.Close)
+
+			gin.SetMode(gin.TestMode)
+			engine = gin.New()
+			loginMiddleware := func(c *gin.Context) {
+				if c.GetHeader("Authorization") == "" {
+					c.AbortWithStatus(http.StatusUnauthorized)
+				}
+			}
+			Expect(ctrl.Bind(engine, loginMiddleware)).To(Succeed())
+		})
+
+		get := func(path string) *httptest.ResponseRecorder {
+			w := httptest.NewRecorder()
+			engine.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+			return w
+		}
+
+		purge := func(body string, authenticated bool) *httptest.ResponseRecorder {
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodPost, "/admin/cache/purge", strings.NewReader(body))
+			req.Header.Set("Content-Type", "application/json")
+			if authenticated {
+				req.Header.Set("Authorization", "Bearer token")
+			}
+			engine.ServeHTTP(w, req)
+			return w
+		}
+
+		It("should serve repeated GET requests from the cache", func() {
+			w := get("/api/products?page=1")
+			Expect(w.Code).To(Equal(http.StatusOK))
+			Expect(w.Header().Get(CacheStatusHeader)).To(Equal("MISS"))
+			Expect(w.Header().Get(defaultCacheTagHeader)).To(BeEmpty())
+
+			w = get("/api/products?page=1")
+			Expect(w.Header().Get(CacheStatusHeader)).To(Equal("HIT"))
+			Expect(w.Header().Get(defaultCacheTagHeader)).To(BeEmpty())
+			Expect(w.Body.String()).To(Equal("response /api/products?page=1"))
+			Expect(calls.Load()).To(BeEquivalentTo(1))
+
+			get("/api/products?page=2")
+			Expect(calls.Load()).To(BeEquivalentTo(2))
+		})
+
+		It("should purge tagged entries through the authenticated endpoint", func() {
+			get("/api/products")
+			get("/api/about")
+			Expect(calls.Load()).To(BeEquivalentTo(2))
+
+			Expect(purge(`{"tags": ["catalog"]}`, false).Code).To(Equal(http.StatusUnauthorized))
+			Expect(purge(`{"tags": []}`, true).Code).To(Equal(http.StatusBadRequest))
+			w := purge(`{"tags": ["catalog"]}`, true)
+			Expect(w.Code).To(Equal(http.StatusOK))
+			Expect(w.Body.String()).To(MatchJSON(`{"purged": 1}`))
+
+			Expect(get("/api/products").Header().Get(CacheStatusHeader)).To(Equal("MISS"))
+			Expect(get("/api/about").Header().Get(CacheStatusHeader)).To(Equal("HIT"))
+			Expect(calls.Load()).To(BeEquivalentTo(3))
+		})
+
+		It("should keep the responses of each encoding apart", func() {
+			encoded := func(acceptEncoding string) *httptest.ResponseRecorder {
+				w := httptest.NewRecorder()
+				req := httptest.NewRequest(http.MethodGet, "/api/encoded", nil)
+				req.Header.Set("Accept-Encoding", acceptEncoding)
+				engine.ServeHTTP(w, req)
+				return w
+			}
+			Expect(encoded("gzip").Header().Get("Content-Encoding")).To(Equal("gzip"))
+			w := encoded("")
+			Expect(w.Header().Get(CacheStatusHeader)).To(Equal("MISS"))
+			Expect(w.Header().Get("Content-Encoding")).To(BeEmpty())
+			Expect(w.Body.String()).To(Equal("response /api/encoded"))
+			w = encoded("gzip")
+			Expect(w.Header().Get(CacheStatusHeader)).To(Equal("HIT"))
+			Expect(w.Body.String()).To(Equal("gzipped"))
+			Expect(calls.Load()).To(BeEquivalentTo(2))
+		})
+
+		It("should not store the responses varying on other request headers", func() {
+			get("/api/localized")
+			Expect(get("/api/localized").Header().Get(CacheStatusHeader)).To(Equal("MISS"))
+			Expect(calls.Load()).To(BeEquivalentTo(2))
+		})
+
+		It("should bypass the cache when the client asks for a fresh response", func() {
+			get("/api/products")
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, "/api/products", nil)
+			req.Header.Set("Cache-Control", "no-cache")
+			engine.ServeHTTP(w, req)
+			Expect(w.Header().Get(CacheStatusHeader)).To(BeEmpty())
+			Expect(calls.Load()).To(BeEquivalentTo(2))
+		})
+	})
+
+	Context("Authenticated load balancer", func() {
+		It("should not share the responses of one user with another", func() {
+			var calls atomic.Int32
+			backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				calls.Add(1)
+				_, _ = w.Write([]byte("hello " + r.Header.Get("X-User")))
+			}))
+			DeferCleanup(backend.Close)
+
+			ctrl, err := NewLoadBalancerController(&LoadBalancerControllerConfig{
+				Path:      "/api",
+				Endpoints: []string{backend.URL},
+				Auth:      true,
+				Cache:     &ResponseCacheConfig{TTL: time.Minute},
+			}, server.ControllerContext{})
+			Expect(err).NotTo(HaveOccurred())
+			DeferCleanup(ctrl.Close)
+
+			gin.SetMode(gin.TestMode)
+			engine := gin.New()
+			// The user is forwarded upstream in a header, like the profile middleware does
+			Expect(ctrl.Bind(engine, func(c *gin.Context) {
+				c.Set(server.RemoteUserKey, c.GetHeader("X-User"))
+			})).To(Succeed())
+
+			get := func(user string) *httptest.ResponseRecorder {
+				w := httptest.NewRecorder()
+				req := httptest.NewRequest(http.MethodGet, "/api/me", nil)
+				req.Header.Set("X-User", user)
+				engine.ServeHTTP(w, req)
+				return w
+			}
+			Expect(get("alice").Body.String()).To(Equal("hello alice"))
+			w := get("bob")
+			Expect(w.Body.String()).To(Equal("hello bob"))
+			Expect(w.Header().Get(CacheStatusHeader)).To(BeEmpty())
+			Expect(calls.Load()).To(BeEquivalentTo(2))
+		})
+	})
+})
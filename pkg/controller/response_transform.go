@@ -0,0 +1,241 @@
+package controller
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+)
+
+// ResponseTransformConfig rewrites proxied JSON responses, e.g. to hide internal fields from public
+// API consumers. Fields are selected with a JSONPath subset: $ for the root, .name or ['name'] for
+// object fields, [n] for array elements and .* or [*] for every field or element. Rules are applied
+// in order: removals, renames and finally the envelope.
+type ResponseTransformConfig struct {
+	// Remove lists the fields to remove, e.g. $.internal_id or $.items[*].cost.
+	Remove []string `yaml:"remove,omitempty"`
+	// Rename renames fields, keeping them in the same object.
+	Rename []FieldRenameConfig `yaml:"rename,omitempty"`
+	// Envelope wraps the response in an object under the given key, e.g. {"data": <response>}.
+	Envelope string `yaml:"envelope,omitempty"`
+}
+
+// FieldRenameConfig renames the fields matched by From to To.
+type FieldRenameConfig struct {
+	From string `yaml:"from"`
+	To   string `yaml:"to"`
+}
+
+func (c ResponseTransformConfig) Validate() error {
+	if len(c.Remove) == 0 && len(c.Rename) == 0 && c.Envelope == "" {
+		return errors.New("at least one of remove, rename or envelope must be set")
+	}
+	for _, rename := range c.Rename {
+		if rename.To == "" {
+			return errors.Errorf("rename of %q must set a non-empty target name", rename.From)
+		}
+	}
+	_, err := newResponseTransformer(c)
+	return err
+}
+
+// pathSegment is a step of a compiled path: an object field, an array index or a wildcard.
+type pathSegment struct {
+	field    string
+	index    int
+	isIndex  bool
+	wildcard bool
+}
+
+// fieldPath is a compiled path whose last segment is the selected field name.
+type fieldPath struct {
+	parents []pathSegment
+	field   string
+}
+
+// parseFieldPath compiles a path selecting object fields. Its last segment must be a field name.
+func parseFieldPath(path string) (fieldPath, error) {
+	segments, err := parsePath(path)
+	if err != nil {
+		return fieldPath{}, err
+	}
+	if len(segments) == 0 {
+		return fieldPath{}, errors.New("path must select a field")
+	}
+	last := segments[len(segments)-1]
+	if last.isIndex || last.wildcard {
+		return fieldPath{}, errors.New("path must end with a field name")
+	}
+	return fieldPath{parents: segments[:len(segments)-1], field: last.field}, nil
+}
+
+func parsePath(path string) ([]pathSegment, error) {
+	rest, ok := strings.CutPrefix(path, "$")
+	if !ok {
+		return nil, errors.New("path must start with $")
+	}
+	var segments []pathSegment
+	for rest != "" {
+		switch rest[0] {
+		case '.':
+			rest = rest[1:]
+			end := strings.IndexAny(rest, ".[")
+			if end < 0 {
+				end = len(rest)
+			}
+			name := rest[:end]
+			rest = rest[end:]
+			switch name {
+			case "":
+				return nil, errors.New("empty field name")
+			case "*":
+				segments = append(segments, pathSegment{wildcard: true})
+			default:
+				segments = append(segments, pathSegment{field: name})
+			}
+		case '[':
+			end := strings.IndexByte(rest, ']')
+			if end < 0 {
+				return nil, errors.New("unterminated [")
+			}
+			selector := rest[1:end]
+			rest = rest[end+1:]
+			switch {
+			case selector == "*":
+				segments = append(segments, pathSegment{wildcard: true})
+			case len(selector) >= 2 && (selector[0] == '\'' || selector[0] == '"') && selector[len(selector)-1] == selector[0]:
+				segments = append(segments, pathSegment{field: selector[1 : len(selector)-1]})
+			default:
+				index, err := strconv.Atoi(selector)
+				if err != nil || index < 0 {
+					return nil, errors.Errorf("invalid selector [%s]", selector)
+				}
+				segments = append(segments, pathSegment{index: index, isIndex: true})
+			}
+		default:
+			return nil, errors.Errorf("unexpected %q", rest[0])
+		}
+	}
+	return segments, nil
+}
+
+// visitObjects calls visit for every object reached by following the segments from node.
+func visitObjects(node any, segments []pathSegment, visit func(map[string]any)) {
+	if len(segments) == 0 {
+		if object, ok := node.(map[string]any); ok {
+			visit(object)
+		}
+		return
+	}
+	segment, rest := segments[0], segments[1:]
+	switch value := node.(type) {
+	case map[string]any:
+		if segment.wildcard {
+			for _, child := range value {
+				visitObjects(child, rest, visit)
+			}
+		} else if child, ok := value[segment.field]; ok && !segment.isIndex {
+			visitObjects(child, rest, visit)
+		}
+	case []any:
+		if segment.wildcard {
+			for _, child := range value {
+				visitObjects(child, rest, visit)
+			}
+		} else if segment.isIndex && segment.index < len(value) {
+			visitObjects(value[segment.index], rest, visit)
+		}
+	}
+}
+
+type renameRule struct {
+	from fieldPath
+	to   string
+}
+
+// responseTransformer applies the compiled rules of a ResponseTransformConfig.
+type responseTransformer struct {
+	remove   []fieldPath
+	rename   []renameRule
+	envelope string
+}
+
+func newResponseTransformer(c ResponseTransformConfig) (*responseTransformer, error) {
+	t := &responseTransformer{envelope: c.Envelope}
+	for _, path := range c.Remove {
+		compiled, err := parseFieldPath(path)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid remove path %q", path)
+		}
+		t.remove = append(t.remove, compiled)
+	}
+	for _, rename := range c.Rename {
+		compiled, err := parseFieldPath(rename.From)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid rename path %q", rename.From)
+		}
+		t.rename = append(t.rename, renameRule{from: compiled, to: rename.To})
+	}
+	return t, nil
+}
+
+// applies tells whether the response has a JSON body.
+func (t *responseTransformer) applies(header http.Header) bool {
+	mediaType, _, err := mime.ParseMediaType(header.Get("Content-Type"))
+	return err == nil && (mediaType == "application/json" || strings.HasSuffix(mediaType, "+json"))
+}
+
+// transform applies the rules to a JSON document. Numbers are kept as written by the upstream.
+func (t *responseTransformer) transform(body []byte) ([]byte, error) {
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+	var document any
+	if err := decoder.Decode(&document); err != nil {
+		return nil, err
+	}
+
+	for _, path := range t.remove {
+		visitObjects(document, path.parents, func(object map[string]any) {
+			delete(object, path.field)
+		})
+	}
+	for _, rule := range t.rename {
+		visitObjects(document, rule.from.parents, func(object map[string]any) {
+			if value, ok := object[rule.from.field]; ok {
+				delete(object, rule.from.field)
+				object[rule.to] = value
+			}
+		})
+	}
+	if t.envelope != "" {
+		document = map[string]any{t.envelope: document}
+	}
+
+	var output bytes.Buffer
+	encoder := json.NewEncoder(&output)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(document); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(output.Bytes(), []byte("\n")), nil
+}
+
+// transformResponse replaces the body of a JSON response with its transformed version. Empty
+// bodies and responses that cannot be parsed are passed through unchanged.
+func (t *responseTransformer) transformResponse(response *http.Response, body []byte) []byte {
+	if len(bytes.TrimSpace(body)) == 0 {
+		return body
+	}
+	transformed, err := t.transform(body)
+	if err != nil {
+		log.Warn().Err(err).Str("url", response.Request.URL.Redacted()).Msg("Upstream JSON response could not be transformed")
+		return body
+	}
+	response.Header.Del("Content-Length")
+	return transformed
+}
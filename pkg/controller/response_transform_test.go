@@ -0,0 +1,124 @@
+//go:build unit
+
+package controller
+
+import (
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/animalet/sargantana-go/pkg/server"
+	"github.com/gin-gonic/gin"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"gopkg.in/yaml.v3"
+)
+
+var _ = Describe("Response Transformation", func() {
+	Context("ResponseTransformConfig Validate", func() {
+		It("should validate the rules", func() {
+			Expect(ResponseTransformConfig{Envelope: "data"}.Validate()).To(Succeed())
+			Expect(ResponseTransformConfig{Remove: []string{"$.a.b", "$.items[*].cost", "$['odd key']", "$.list[0].x", "$.*.secret"}}.Validate()).To(Succeed())
+			Expect(ResponseTransformConfig{}.Validate()).To(HaveOccurred())
+			Expect(ResponseTransformConfig{Remove: []string{"a.b"}}.Validate()).To(MatchError(ContainSubstring("must start with $")))
+			Expect(ResponseTransformConfig{Remove: []string{"$"}}.Validate()).To(HaveOccurred())
+			Expect(ResponseTransformConfig{Remove: []string{"$.items[*]"}}.Validate()).To(MatchError(ContainSubstring("must end with a field name")))
+			Expect(ResponseTransformConfig{Remove: []string{"$.items[-1].a"}}.Validate()).To(HaveOccurred())
+			Expect(ResponseTransformConfig{Remove: []string{"$.items[0"}}.Validate()).To(HaveOccurred())
+			Expect(ResponseTransformConfig{Remove: []string{"$..a"}}.Validate()).To(HaveOccurred())
+			Expect(ResponseTransformConfig{Rename: []FieldRenameConfig{{From: "$.a"}}}.Validate()).To(HaveOccurred())
+			Expect(LoadBalancerControllerConfig{Path: "/api", Endpoints: []string{"http://localhost:8080"}, Transform: &ResponseTransformConfig{}}.Validate()).
+				To(MatchError(ContainSubstring("invalid transform configuration")))
+		})
+	})
+
+	Context("Transform", func() {
+		transform := func(cfg ResponseTransformConfig, body string) string {
+			transformer, err := newResponseTransformer(cfg)
+			Expect(err).NotTo(HaveOccurred())
+			result, err := transformer.transform([]byte(body))
+			Expect(err).NotTo(HaveOccurred())
+			return string(result)
+		}
+
+		It("should remove fields", func() {
+			Expect(transform(ResponseTransformConfig{Remove: []string{"$.internal_id", "$.items[*].cost", "$.owner['db id']", "$.missing.field"}},
+				`{"id": 1, "internal_id": 99, "items": [{"name": "a", "cost": 1}, {"name": "b", "cost": 2}, "scalar"], "owner": {"db id": 5, "name": "x"}}`)).
+				To(MatchJSON(`{"id": 1, "items": [{"name": "a"}, {"name": "b"}, "scalar"], "owner": {"name": "x"}}`))
+		})
+
+		It("should remove fields of selected array elements and object values", func() {
+			Expect(transform(ResponseTransformConfig{Remove: []string{"$[0].secret", "$[1].nested.*.secret"}},
+				`[{"secret": 1, "a": 1}, {"nested": {"x": {"secret": 2}, "y": {"secret": 3, "b": 2}}}]`)).
+				To(MatchJSON(`[{"a": 1}, {"nested": {"x": {}, "y": {"b": 2}}}]`))
+		})
+
+		It("should rename fields and wrap the response", func() {
+			Expect(transform(ResponseTransformConfig{
+				Rename:   []FieldRenameConfig{{From: "$.user.uid", To: "id"}, {From: "$.items[*].n", To: "name"}},
+				Envelope: "data",
+			}, `{"user": {"uid": 7}, "items": [{"n": "a"}]}`)).
+				To(MatchJSON(`{"data": {"user": {"id": 7}, "items": [{"name": "a"}]}}`))
+		})
+
+		It("should keep numbers and markup as written", func() {
+			Expect(transform(ResponseTransformConfig{Envelope: "data"}, `{"big": 12345678901234567890, "html": "<b>&</b>"}`)).
+				To(Equal(`{"data":{"big":12345678901234567890,"html":"<b>&</b>"}}`))
+		})
+	})
+
+	Context("Load balancer", func() {
+		var engine *gin.Engine
+
+		BeforeEach(func() {
+			backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				switch r.URL.Path {
+				case "/api/user":
+					w.Header().Set("Content-Type", "application/json; charset=utf-8")
+					_, _ = w.Write([]byte(`{"id": 1, "password_hash": "secret"}`))
+				case "/api/invalid":
+					w.Header().Set("Content-Type", "application/problem+json")
+					_, _ = w.Write([]byte(`not json`))
+				default:
+					w.Header().Set("Content-Type", "text/plain")
+					_, _ = w.Write([]byte(`{"password_hash": "kept"}`))
+				}
+			}))
+			DeferCleanup(backend.Close)
+
+			cfgBytes := []byte("path: /api\nendpoints:\n  - " + backend.URL + "\ntransform:\n  remove: [$.password_hash]\n  envelope: data\ncache:\n  ttl: 1m")
+			var lbCfg LoadBalancerControllerConfig
+			Expect(yaml.Unmarshal(cfgBytes, &lbCfg)).To(Succeed())
+			Expect(lbCfg.Validate()).To(Succeed())
+			ctrl, err := NewLoadBalancerController(&lbCfg, server.ControllerContext{})
+			Expect(err).NotTo(HaveOccurred())
+			DeferCleanup(ctrl.Close)
+
+			gin.SetMode(gin.TestMode)
+			engine = gin.New()
+			Expect(ctrl.Bind(engine, nil)).To(Succeed())
+		})
+
+		get := func(path string) *httptest.ResponseRecorder {
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, path, nil)
+			req.Header.Set("Accept-Encoding", "gzip")
+			engine.ServeHTTP(w, req)
+			return w
+		}
+
+		It("should transform JSON responses before caching them", func() {
+			for _, status := range []string{"MISS", "HIT"} {
+				w := get("/api/user")
+				Expect(w.Code).To(Equal(http.StatusOK))
+				Expect(w.Header().Get(CacheStatusHeader)).To(Equal(status))
+				Expect(w.Header().Get("Content-Encoding")).To(BeEmpty())
+				Expect(w.Body.String()).To(MatchJSON(`{"data": {"id": 1}}`))
+			}
+		})
+
+		It("should pass other responses through unchanged", func() {
+			Expect(get("/api/text").Body.String()).To(Equal(`{"password_hash": "kept"}`))
+			Expect(get("/api/invalid").Body.String()).To(Equal(`not json`))
+		})
+	})
+})
@@ -0,0 +1,325 @@
+package controller
+
+import (
+	"context"
+	"net/http"
+	"regexp"
+	"time"
+
+	"github.com/animalet/sargantana-go/internal/snapshot"
+	"github.com/animalet/sargantana-go/pkg/database"
+	"github.com/animalet/sargantana-go/pkg/openapi"
+	"github.com/animalet/sargantana-go/pkg/server"
+	"github.com/animalet/sargantana-go/pkg/sessionsx"
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+	"github.com/gomodule/redigo/redis"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	defaultRevocationTable        = "credential_revocations"
+	defaultRevocationCacheTTL     = 30 * time.Second
+	defaultRevocationCacheChannel = "sargantana:revocations"
+)
+
+// revocationTableName accepts table names optionally qualified by their schema
+var revocationTableName = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*(\.[a-zA-Z_][a-zA-Z0-9_]*)?$`)
+
+// RevocationControllerConfig configures the revocation of all the credentials of a user after a
+// compromise: the sessions of the auth controller and the bearer tokens accepted by the jwt
+// authenticator. The generations of the users are stored in Redis or PostgreSQL, to be shared by
+// the replicas of the server, or in memory without either.
+type RevocationControllerConfig struct {
+	// Path signs the signed-in user out everywhere on POST. Optional.
+	Path string `yaml:"path,omitempty"`
+	// AdminPath reports the generation of a user on GET {admin_path}/{user} and revokes their
+	// credentials on POST {admin_path}/{user}, for the users with AdminRole. Optional.
+	AdminPath string `yaml:"admin_path,omitempty"`
+	AdminRole string `yaml:"admin_role,omitempty"`
+	// FailClosed rejects the credentials while the store fails, instead of accepting them.
+	FailClosed bool `yaml:"fail_closed,omitempty"`
+	// Redis stores the generations in Redis.
+	Redis *database.RedisConfig `yaml:"redis,omitempty"`
+	// Postgres stores the generations in a PostgreSQL table, created if it does not exist.
+	Postgres *database.PostgresConfig `yaml:"postgres,omitempty"`
+	// Table is the PostgreSQL table of the generations. Defaults to credential_revocations.
+	Table string `yaml:"table,omitempty"`
+	// Cache keeps the generations read from the store in each replica. Optional.
+	Cache *RevocationCacheConfig `yaml:"cache,omitempty"`
+}
+
+// RevocationCacheConfig caches the generations of the users in each replica, instead of reading
+// them from the store on every request. The revocations are broadcast to the other replicas
+// through Redis pub/sub, so that every replica rejects the revoked credentials at once. Without
+// Redis, the other replicas reject them once their cached generation expires.
+type RevocationCacheConfig struct {
+	// TTL is the time a generation is cached. Defaults to 30s.
+	TTL time.Duration `yaml:"ttl,omitempty"`
+	// Redis broadcasts the revocations. Defaults to the Redis store, if any.
+	Redis *database.RedisConfig `yaml:"redis,omitempty"`
+	// Channel is the Redis channel of the broadcast. Defaults to sargantana:revocations.
+	Channel string `yaml:"channel,omitempty"`
+}
+
+func (c RevocationCacheConfig) Validate() error {
+	if c.TTL < 0 {
+		return errors.New("ttl must not be negative")
+	}
+	if c.Redis != nil {
+		if err := c.Redis.Validate(); err != nil {
+			return errors.Wrap(err, "invalid redis configuration")
+		}
+	}
+	return nil
+}
+
+func (c RevocationControllerConfig) Validate() error {
+	if c.Path == "" && c.AdminPath == "" {
+		return errors.New("at least one of path and admin_path must be set")
+	}
+	if c.AdminPath != "" && c.AdminRole == "" {
+		return errors.New("admin_role must be set with admin_path")
+	}
+	if c.Redis != nil && c.Postgres != nil {
+		return errors.New("only one of redis and postgres can be set")
+	}
+	if c.Redis != nil {
+		if err := c.Redis.Validate(); err != nil {
+			return errors.Wrap(err, "invalid redis configuration")
+		}
+	}
+	if c.Postgres != nil {
+		if err := c.Postgres.Validate(); err != nil {
+			return errors.Wrap(err, "invalid postgres configuration")
+		}
+	}
+	if c.Table != "" && !revocationTableName.MatchString(c.Table) {
+		return errors.Errorf("invalid table name %q", c.Table)
+	}
+	if c.Cache != nil {
+		if c.Redis == nil && c.Postgres == nil {
+			return errors.New("cache requires a redis or postgres store")
+		}
+		if err := c.Cache.Validate(); err != nil {
+			return errors.Wrap(err, "invalid cache configuration")
+		}
+	}
+	return nil
+}
+
+// Describe adds the routes of the controller to the OpenAPI document, see openapi.Describer.
+func (c RevocationControllerConfig) Describe(doc *openapi.Document, basePath string) {
+	if c.Path != "" {
+		doc.Add(http.MethodPost, basePath, c.Path, openapi.Operation{
+			OperationID: "revokeSessions",
+			Summary:     "Signs the user out everywhere, revoking all their credentials",
+			Tags:        []string{"auth"},
+			Responses: openapi.With(map[string]openapi.Response{
+				"204": {Description: "The credentials are revoked"},
+			}, openapi.Errors(http.StatusUnauthorized, http.StatusServiceUnavailable)),
+		})
+	}
+	if c.AdminPath == "" {
+		return
+	}
+	revocation := map[string]openapi.Response{
+		"200": {Description: "The credential generation of the user", Content: openapi.JSON(doc.Schema("Revocation", server.Revocation{}))},
+	}
+	errs := openapi.Errors(http.StatusUnauthorized, http.StatusForbidden, http.StatusServiceUnavailable)
+	doc.Add(http.MethodGet, basePath, openapi.Join(c.AdminPath, ":user"), openapi.Operation{
+		OperationID: "getRevocation",
+		Summary:     "Returns the credential generation of the user",
+		Tags:        []string{"admin"},
+		Responses:   openapi.With(revocation, errs),
+	})
+	doc.Add(http.MethodPost, basePath, openapi.Join(c.AdminPath, ":user"), openapi.Operation{
+		OperationID: "revokeUser",
+		Summary:     "Revokes all the credentials of the user",
+		Tags:        []string{"admin"},
+		Responses:   openapi.With(revocation, errs),
+	})
+}
+
+// NewRevocationController creates the controller revoking the credentials of the users and
+// installs its store with server.SetRevocationStore, so that revoked sessions and tokens are
+// rejected by the login middlewares of every binding.
+func NewRevocationController(c *RevocationControllerConfig, ctx server.ControllerContext) (server.IController, error) {
+	// Deep copy the config to enforce immutability
+	configCopy := snapshot.MustCopy(c)
+
+	r := &revocation{config: configCopy, ctx: ctx}
+	var redisPool *redis.Pool
+	switch {
+	case configCopy.Redis != nil:
+		pool, err := configCopy.Redis.CreateClient()
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to create redis client")
+		}
+		r.store, r.closers, redisPool = server.NewRedisRevocationStore(pool), []func() error{closeRedis(pool)}, pool
+	case configCopy.Postgres != nil:
+		pool, err := configCopy.Postgres.CreateClient()
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to create postgres client")
+		}
+		table := configCopy.Table
+		if table == "" {
+			table = defaultRevocationTable
+		}
+		migrationCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if r.store, err = server.NewPostgresRevocationStore(migrationCtx, pool, table); err != nil {
+			database.ClosePostgres(pool)
+			return nil, err
+		}
+		r.closers = []func() error{closePool(pool)}
+	default:
+		r.store = server.NewMemoryRevocationStore()
+	}
+	if cache := configCopy.Cache; cache != nil {
+		if err := r.cache(*cache, redisPool); err != nil {
+			_ = r.Close()
+			return nil, err
+		}
+	}
+	server.SetRevocationStore(r.store, configCopy.FailClosed)
+
+	log.Info().Str("path", configCopy.Path).Str("admin_path", configCopy.AdminPath).Bool("fail_closed", configCopy.FailClosed).
+		Msg("Credential revocation configured")
+	return r, nil
+}
+
+// closePool adapts the Close of a PostgreSQL pool to a closer
+func closePool(pool *pgxpool.Pool) func() error {
+	return func() error {
+		database.ClosePostgres(pool)
+		return nil
+	}
+}
+
+// closeRedis adapts the Close of a Redis pool to a closer
+func closeRedis(pool *redis.Pool) func() error {
+	return func() error {
+		return database.CloseRedis(pool)
+	}
+}
+
+type revocation struct {
+	config  *RevocationControllerConfig
+	ctx     server.ControllerContext
+	store   server.RevocationStore
+	closers []func() error // Run in reverse order on Close
+}
+
+// cache wraps the store with the cache, broadcasting the revocations through the Redis of the
+// cache or else of the store, if any
+func (r *revocation) cache(c RevocationCacheConfig, storePool *redis.Pool) error {
+	ttl := c.TTL
+	if ttl == 0 {
+		ttl = defaultRevocationCacheTTL
+	}
+	pool := storePool
+	if c.Redis != nil {
+		var err error
+		if pool, err = c.Redis.CreateClient(); err != nil {
+			return errors.Wrap(err, "failed to create redis client")
+		}
+		r.closers = append(r.closers, closeRedis(pool))
+	}
+	if pool == nil {
+		r.store = server.NewCachedRevocationStore(r.store, ttl)
+		log.Warn().Dur("ttl", ttl).Msg("Credential revocations are not broadcast, the other replicas apply them once their cache expires")
+		return nil
+	}
+	channel := c.Channel
+	if channel == "" {
+		channel = defaultRevocationCacheChannel
+	}
+	broadcast := server.NewRevocationBroadcast(r.store, ttl, pool, channel)
+	r.store, r.closers = broadcast, append(r.closers, broadcast.Close)
+	log.Info().Dur("ttl", ttl).Str("channel", channel).Msg("Credential revocations broadcast to the replicas")
+	return nil
+}
+
+func (r *revocation) Bind(engine *gin.Engine, loginMiddleware gin.HandlerFunc) error {
+	return r.BindGroup(&engine.RouterGroup, loginMiddleware)
+}
+
+func (r *revocation) BindGroup(group *gin.RouterGroup, loginMiddleware gin.HandlerFunc) error {
+	if loginMiddleware == nil {
+		loginMiddleware = r.ctx.LoginMiddleware()
+	}
+	if r.config.Path != "" {
+		group.POST(r.config.Path, loginMiddleware, r.revokeSelf)
+	}
+	if r.config.AdminPath != "" {
+		admin := group.Group(r.config.AdminPath, r.ctx.RequireRole(r.config.AdminRole))
+		admin.GET("/:user", r.get)
+		admin.POST("/:user", r.revokeUser)
+	}
+	return nil
+}
+
+// Close stops the revocation checks and releases the store
+func (r *revocation) Close() error {
+	server.SetRevocationStore(nil, false)
+	var err error
+	for i := len(r.closers) - 1; i >= 0; i-- {
+		if closeErr := r.closers[i](); err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
+
+// revokeSelf revokes the credentials of the signed-in user and clears the current session
+func (r *revocation) revokeSelf(c *gin.Context) {
+	user := server.UserId(c)
+	if user == "" {
+		server.RespondError(c, http.StatusUnauthorized)
+		return
+	}
+	if _, ok := r.revoke(c, user); !ok {
+		return
+	}
+	if _, ok := c.Get(sessions.DefaultKey); ok {
+		if err := sessionsx.Clear(c); err != nil {
+			_ = c.AbortWithError(http.StatusInternalServerError, err)
+			return
+		}
+	}
+	c.Status(http.StatusNoContent)
+}
+
+func (r *revocation) revokeUser(c *gin.Context) {
+	if revocation, ok := r.revoke(c, c.Param("user")); ok {
+		c.JSON(http.StatusOK, revocation)
+	}
+}
+
+func (r *revocation) get(c *gin.Context) {
+	revocation, err := r.store.Get(c.Request.Context(), c.Param("user"))
+	if err != nil {
+		logger := server.RequestLogger(c)
+		logger.Error().Err(err).Msg("Failed to read the credential generation")
+		server.RespondError(c, http.StatusServiceUnavailable)
+		return
+	}
+	c.JSON(http.StatusOK, revocation)
+}
+
+// revoke increments the generation of the user, answering 503 on failure
+func (r *revocation) revoke(c *gin.Context, user string) (server.Revocation, bool) {
+	revocation, err := r.store.Revoke(c.Request.Context(), user, time.Now())
+	if err != nil {
+		logger := server.RequestLogger(c)
+		logger.Error().Err(err).Msg("Failed to revoke credentials")
+		server.RespondError(c, http.StatusServiceUnavailable)
+		return revocation, false
+	}
+	log.Warn().Str("user", user).Int64("generation", revocation.Generation).Msg("Credentials revoked")
+	server.Audit(c, "credentials_revoked", map[string]any{"user": user, "generation": revocation.Generation})
+	return revocation, true
+}
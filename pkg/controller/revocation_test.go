@@ -0,0 +1,130 @@
+//go:build unit
+
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	"github.com/animalet/sargantana-go/pkg/database"
+	"github.com/animalet/sargantana-go/pkg/server"
+	"github.com/animalet/sargantana-go/pkg/sessionsx"
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-contrib/sessions/cookie"
+	"github.com/gin-gonic/gin"
+	"github.com/markbates/goth"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("RevocationController", func() {
+	var (
+		engine *gin.Engine
+		ctrl   *revocation
+	)
+
+	BeforeEach(func() {
+		gin.SetMode(gin.TestMode)
+		c, err := NewRevocationController(&RevocationControllerConfig{
+			Path:      "/account/sign-out-everywhere",
+			AdminPath: "/admin/revocations",
+			AdminRole: "security",
+		}, server.ControllerContext{})
+		Expect(err).NotTo(HaveOccurred())
+		ctrl = c.(*revocation)
+		DeferCleanup(ctrl.Close)
+
+		engine = gin.New()
+		engine.Use(sessions.Sessions("session", cookie.NewStore([]byte("secret"))))
+		engine.GET("/signin", func(c *gin.Context) {
+			revocation, err := server.CurrentRevocation(c.Request.Context(), "alice")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(sessionsx.Set(c, sessionsx.UserKey, UserObject{
+				Id:         "alice",
+				User:       goth.User{UserID: "alice", ExpiresAt: time.Now().Add(time.Hour)},
+				Generation: revocation.Generation,
+			})).To(Succeed())
+		})
+		engine.GET("/whoami", func(c *gin.Context) {
+			user, _ := sessionsx.User(c)
+			c.String(http.StatusOK, user.Id)
+		})
+		// The admin handlers, without the role check of the binding
+		engine.GET("/unchecked/:user", ctrl.get)
+		engine.POST("/unchecked/:user", ctrl.revokeUser)
+		Expect(ctrl.Bind(engine, LoginFunc)).To(Succeed())
+	})
+
+	// request runs a request carrying the cookies of the previous response, if any
+	request := func(method, path string, previous *httptest.ResponseRecorder) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(method, path, nil)
+		if previous != nil {
+			for _, c := range previous.Result().Cookies() {
+				req.AddCookie(c)
+			}
+		}
+		engine.ServeHTTP(w, req)
+		return w
+	}
+
+	It("should validate the configuration", func() {
+		Expect(RevocationControllerConfig{}.Validate()).To(MatchError(ContainSubstring("at least one of path and admin_path")))
+		Expect(RevocationControllerConfig{Path: "/logout-all"}.Validate()).To(Succeed())
+		Expect(RevocationControllerConfig{AdminPath: "/admin"}.Validate()).To(MatchError(ContainSubstring("admin_role")))
+		Expect(RevocationControllerConfig{AdminPath: "/admin", AdminRole: "security"}.Validate()).To(Succeed())
+		Expect(RevocationControllerConfig{Path: "/logout-all", Redis: &database.RedisConfig{Address: "localhost:6379"},
+			Postgres: &database.PostgresConfig{}}.Validate()).To(MatchError(ContainSubstring("only one of")))
+		Expect(RevocationControllerConfig{Path: "/logout-all", Table: "auth.revocations"}.Validate()).To(Succeed())
+		Expect(RevocationControllerConfig{Path: "/logout-all", Table: "revocations; DROP TABLE users"}.Validate()).To(HaveOccurred())
+		Expect(RevocationControllerConfig{Path: "/logout-all", Cache: &RevocationCacheConfig{}}.Validate()).
+			To(MatchError(ContainSubstring("cache requires a redis or postgres store")))
+		redisStore := &database.RedisConfig{Address: "localhost:6379"}
+		Expect(RevocationControllerConfig{Path: "/logout-all", Redis: redisStore, Cache: &RevocationCacheConfig{TTL: time.Minute}}.Validate()).To(Succeed())
+		Expect(RevocationControllerConfig{Path: "/logout-all", Redis: redisStore, Cache: &RevocationCacheConfig{TTL: -1}}.Validate()).
+			To(MatchError(ContainSubstring("invalid cache configuration: ttl")))
+		Expect(RevocationControllerConfig{Path: "/logout-all", Redis: redisStore, Cache: &RevocationCacheConfig{Redis: &database.RedisConfig{}}}.Validate()).
+			To(MatchError(ContainSubstring("invalid cache configuration: invalid redis configuration")))
+	})
+
+	It("should sign the user out of every session", func() {
+		first := request(http.MethodGet, "/signin", nil)
+		second := request(http.MethodGet, "/signin", nil)
+		Expect(request(http.MethodPost, "/account/sign-out-everywhere", nil).Code).To(Equal(http.StatusUnauthorized))
+
+		w := request(http.MethodPost, "/account/sign-out-everywhere", first)
+		Expect(w.Code).To(Equal(http.StatusNoContent))
+		Expect(request(http.MethodGet, "/whoami", w).Body.String()).To(BeEmpty())
+		Expect(request(http.MethodPost, "/account/sign-out-everywhere", second).Code).To(Equal(http.StatusUnauthorized))
+
+		// Sessions opened afterwards belong to the new generation
+		third := request(http.MethodGet, "/signin", nil)
+		Expect(request(http.MethodGet, "/whoami", third).Body.String()).To(Equal("alice"))
+		Expect(request(http.MethodPost, "/account/sign-out-everywhere", third).Code).To(Equal(http.StatusNoContent))
+		Expect(ctrl.store.Get(context.Background(), "alice")).To(HaveField("Generation", BeEquivalentTo(2)))
+	})
+
+	It("should protect the admin API with the role", func() {
+		Expect(request(http.MethodPost, "/admin/revocations/alice", nil).Code).To(Equal(http.StatusUnauthorized))
+		Expect(request(http.MethodGet, "/admin/revocations/alice", nil).Code).To(Equal(http.StatusUnauthorized))
+	})
+
+	It("should revoke and report the generation of a user", func() {
+		var revocation server.Revocation
+		w := request(http.MethodGet, "/unchecked/bob", nil)
+		Expect(w.Code).To(Equal(http.StatusOK))
+		Expect(json.Unmarshal(w.Body.Bytes(), &revocation)).To(Succeed())
+		Expect(revocation.Generation).To(BeZero())
+
+		w = request(http.MethodPost, "/unchecked/bob", nil)
+		Expect(w.Code).To(Equal(http.StatusOK))
+		Expect(json.Unmarshal(w.Body.Bytes(), &revocation)).To(Succeed())
+		Expect(revocation.Generation).To(BeEquivalentTo(1))
+		Expect(revocation.RevokedAt).NotTo(BeZero())
+
+		Expect(server.CurrentRevocation(context.Background(), "bob")).To(HaveField("Generation", BeEquivalentTo(1)))
+	})
+})
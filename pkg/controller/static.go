@@ -1,7 +1,13 @@
 package controller
 
 import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
 	"os"
+	"path"
+	"strings"
 
 	"github.com/animalet/sargantana-go/internal/snapshot"
 	"github.com/animalet/sargantana-go/pkg/server"
@@ -17,8 +23,17 @@ type StaticControllerConfig struct {
 	Dir  string `yaml:"dir,omitempty"`
 	File string `yaml:"file,omitempty"`
 	Auth bool   `yaml:"auth,omitempty"` // If true, requires authentication to access static content
+	// MaxLargeTransfers limits the number of large files sent concurrently, answering
+	// 503 Service Unavailable beyond it. Zero means unlimited.
+	MaxLargeTransfers int `yaml:"max_large_transfers,omitempty"`
+	// LargeFileSize is the size in bytes from which a file counts as large. Defaults to 64 MiB.
+	LargeFileSize int64 `yaml:"large_file_size,omitempty"`
+	// AutoIndex lists the directories without an index.html. Only valid with dir.
+	AutoIndex *AutoIndexConfig `yaml:"autoindex,omitempty"`
 }
 
+const defaultLargeFileSize = 64 * 1024 * 1024
+
 func (s StaticControllerConfig) Validate() error {
 	if s.Path == "" {
 		return errors.New("path must be set and non-empty")
@@ -32,6 +47,19 @@ func (s StaticControllerConfig) Validate() error {
 		return errors.New("cannot set both dir and file, choose one")
 	}
 
+	if s.MaxLargeTransfers < 0 || s.LargeFileSize < 0 {
+		return errors.New("max_large_transfers and large_file_size must not be negative")
+	}
+
+	if s.AutoIndex != nil {
+		if s.File != "" {
+			return errors.New("autoindex requires dir")
+		}
+		if err := s.AutoIndex.Validate(); err != nil {
+			return errors.Wrap(err, "invalid autoindex configuration")
+		}
+	}
+
 	if s.File != "" {
 		if stat, err := os.Stat(s.File); err != nil || stat.IsDir() {
 			return errors.Wrap(err, "static file not present or is a directory")
@@ -55,13 +83,35 @@ func NewStaticController(c *StaticControllerConfig, _ server.ControllerContext)
 		Str("dir", configCopy.Dir).
 		Str("file", configCopy.File).
 		Bool("auth", configCopy.Auth).
+		Int("max_large_transfers", configCopy.MaxLargeTransfers).
+		Bool("autoindex", configCopy.AutoIndex != nil).
 		Msg("Static content configured")
 
+	var index *autoIndex
+	if configCopy.AutoIndex != nil {
+		var err error
+		if index, err = newAutoIndex(*configCopy.AutoIndex); err != nil {
+			return nil, err
+		}
+	}
+
+	largeFileSize := configCopy.LargeFileSize
+	if largeFileSize == 0 {
+		largeFileSize = defaultLargeFileSize
+	}
+	var transfers chan struct{}
+	if configCopy.MaxLargeTransfers > 0 {
+		transfers = make(chan struct{}, configCopy.MaxLargeTransfers)
+	}
+
 	return &static{
-		path: configCopy.Path,
-		dir:  configCopy.Dir,
-		file: configCopy.File,
-		auth: configCopy.Auth,
+		path:          configCopy.Path,
+		dir:           configCopy.Dir,
+		file:          configCopy.File,
+		auth:          configCopy.Auth,
+		largeFileSize: largeFileSize,
+		transfers:     transfers,
+		autoIndex:     index,
 	}, nil
 }
 
@@ -70,16 +120,23 @@ func NewStaticController(c *StaticControllerConfig, _ server.ControllerContext)
 // Fields are extracted from configuration at initialization time for immutability.
 type static struct {
 	server.IController
-	path string
-	dir  string
-	file string
-	auth bool
+	path          string
+	dir           string
+	file          string
+	auth          bool
+	largeFileSize int64
+	transfers     chan struct{} // Slots of the concurrent large transfers, nil if unlimited
+	autoIndex     *autoIndex    // Nil if directory listings are disabled
 }
 
 // Bind registers the static controller with the provided Gin engine.
 // It sets up routes for serving static files or directories from the configured path.
 // If authentication is enabled, the loginMiddleware is applied to protect the static content.
 func (s *static) Bind(engine *gin.Engine, loginMiddleware gin.HandlerFunc) error {
+	return s.BindGroup(&engine.RouterGroup, loginMiddleware)
+}
+
+func (s *static) BindGroup(group *gin.RouterGroup, loginMiddleware gin.HandlerFunc) error {
 	isFile := s.file != ""
 
 	log.Info().
@@ -89,22 +146,18 @@ func (s *static) Bind(engine *gin.Engine, loginMiddleware gin.HandlerFunc) error
 		Bool("auth", s.auth).
 		Msgf("Binding static %s", map[bool]string{true: "file", false: "directory"}[isFile])
 
-	if isFile {
-		if s.auth {
-			engine.GET(s.path, loginMiddleware, func(c *gin.Context) {
-				c.File(s.file)
-			})
-		} else {
-			engine.StaticFile(s.path, s.file)
-		}
-	} else {
-		if s.auth {
-			group := engine.Group(s.path, loginMiddleware)
-			group.Static("/", s.dir)
-		} else {
-			engine.Static(s.path, s.dir)
-		}
+	route := s.path
+	handler := s.serveFile
+	if !isFile {
+		route = path.Join(s.path, "/*filepath")
+		handler = s.serveDir
 	}
+	handlers := []gin.HandlerFunc{handler}
+	if s.auth {
+		handlers = []gin.HandlerFunc{loginMiddleware, handler}
+	}
+	group.GET(route, handlers...)
+	group.HEAD(route, handlers...)
 	return nil
 }
 
@@ -116,3 +169,106 @@ func (s *static) Bind(engine *gin.Engine, loginMiddleware gin.HandlerFunc) error
 func (s *static) Close() error {
 	return nil
 }
+
+func (s *static) serveFile(c *gin.Context) {
+	file, err := os.Open(s.file)
+	if err != nil {
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+	defer func() { _ = file.Close() }()
+	s.serveContent(c, file)
+}
+
+// serveDir serves the files of the directory, and the index.html or listing of its subdirectories.
+// Names are resolved by http.Dir, which prevents escaping the directory.
+func (s *static) serveDir(c *gin.Context) {
+	name := c.Param("filepath")
+	root := http.Dir(s.dir)
+	file, err := root.Open(name)
+	if err != nil {
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+	defer func() { _ = file.Close() }()
+
+	if stat, err := file.Stat(); err == nil && stat.IsDir() {
+		if !strings.HasSuffix(c.Request.URL.Path, "/") {
+			// Relative links of directory pages need the trailing slash
+			target := url.URL{Path: c.Request.URL.Path + "/", RawQuery: c.Request.URL.RawQuery}
+			c.Redirect(http.StatusMovedPermanently, target.String())
+			return
+		}
+		index, err := root.Open(path.Join(name, "index.html"))
+		if err != nil {
+			if s.autoIndex != nil {
+				s.autoIndex.serve(c, file, name)
+			} else {
+				c.AbortWithStatus(http.StatusNotFound)
+			}
+			return
+		}
+		defer func() { _ = index.Close() }()
+		file = index
+	}
+	s.serveContent(c, file)
+}
+
+// serveContent sends a file with support for range requests and conditional requests, so that
+// interrupted downloads can be resumed. Large files take a transfer slot while they are sent.
+func (s *static) serveContent(c *gin.Context, file http.File) {
+	stat, err := file.Stat()
+	if err != nil || stat.IsDir() {
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+
+	if s.transfers != nil && stat.Size() >= s.largeFileSize {
+		select {
+		case s.transfers <- struct{}{}:
+			defer func() { <-s.transfers }()
+		default:
+			log.Warn().Str("path", c.Request.URL.Path).Int("max_large_transfers", cap(s.transfers)).Msg("Too many concurrent large file transfers")
+			c.Header("Retry-After", "1")
+			c.AbortWithStatus(http.StatusServiceUnavailable)
+			return
+		}
+	}
+
+	// A strong validator lets clients resume downloads with If-Range
+	c.Header("ETag", fmt.Sprintf(`"%x-%x"`, stat.ModTime().UnixNano(), stat.Size()))
+	writer := newSendfileWriter(c.Writer)
+	http.ServeContent(writer, c.Request, stat.Name(), stat.ModTime(), file)
+	if writer.sent > 0 {
+		c.Set(server.ResponseBytesKey, writer.sent)
+	}
+}
+
+// sendfileWriter hands the body copies of http.ServeContent to the ReaderFrom of the connection,
+// which uses sendfile(2) for files, instead of copying them through gin's ResponseWriter.
+type sendfileWriter struct {
+	gin.ResponseWriter
+	target io.ReaderFrom // Nil if the connection does not support it
+	sent   int64
+}
+
+// newSendfileWriter only bypasses gin's own ResponseWriter: writers installed by middlewares, e.g.
+// to log or compress the response, must see the body.
+func newSendfileWriter(w gin.ResponseWriter) *sendfileWriter {
+	writer := &sendfileWriter{ResponseWriter: w}
+	if unwrapper, ok := w.(interface{ Unwrap() http.ResponseWriter }); ok {
+		writer.target, _ = unwrapper.Unwrap().(io.ReaderFrom)
+	}
+	return writer
+}
+
+func (w *sendfileWriter) ReadFrom(r io.Reader) (int64, error) {
+	if w.target == nil {
+		// Hide this method from io.Copy to avoid recursion
+		return io.Copy(struct{ io.Writer }{w.ResponseWriter}, r)
+	}
+	w.ResponseWriter.WriteHeaderNow()
+	n, err := w.target.ReadFrom(r)
+	w.sent += n
+	return n, err
+}
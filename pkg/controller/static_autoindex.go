@@ -0,0 +1,189 @@
+package controller
+
+import (
+	"bytes"
+	"fmt"
+	htmltemplate "html/template"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+)
+
+const defaultAutoIndexDisableFile = ".noindex"
+
+// AutoIndexConfig enables listings of the directories without an index.html. Listings are sorted
+// with the sort (name, size or modified) and order (asc or desc) query parameters.
+type AutoIndexConfig struct {
+	// Template is an html/template file rendering the listing, see AutoIndexPage for its data.
+	// Defaults to a plain table.
+	Template string `yaml:"template,omitempty"`
+	// ShowHidden lists the files whose name starts with a dot.
+	ShowHidden bool `yaml:"show_hidden,omitempty"`
+	// DisableFile is the name of the file disabling the listing of the directory containing it.
+	// Defaults to .noindex.
+	DisableFile string `yaml:"disable_file,omitempty"`
+}
+
+func (c AutoIndexConfig) Validate() error {
+	if c.Template != "" {
+		if _, err := htmltemplate.ParseFiles(c.Template); err != nil {
+			return errors.Wrap(err, "invalid autoindex template")
+		}
+	}
+	if strings.ContainsRune(c.DisableFile, '/') {
+		return errors.New("disable_file must be a file name")
+	}
+	return nil
+}
+
+// AutoIndexPage is the data of the directory listing template.
+type AutoIndexPage struct {
+	// Path is the URL path of the directory, ending with a slash.
+	Path string
+	// Root tells whether the directory is the root of the static controller, without a parent.
+	Root    bool
+	Entries []AutoIndexEntry
+	// Sort and Order are the applied sorting, used to build the column links.
+	Sort  string
+	Order string
+}
+
+// AutoIndexEntry is a file or subdirectory of a listing.
+type AutoIndexEntry struct {
+	Name string
+	// URL is the escaped link to the entry, relative to the listing.
+	URL      string
+	IsDir    bool
+	Size     int64
+	Modified time.Time
+}
+
+// HumanSize formats the size with binary units, e.g. 1.5 MiB.
+func (e AutoIndexEntry) HumanSize() string {
+	if e.IsDir {
+		return "-"
+	}
+	const unit = 1024
+	if e.Size < unit {
+		return fmt.Sprintf("%d B", e.Size)
+	}
+	div, exp := int64(unit), 0
+	for n := e.Size / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(e.Size)/float64(div), "KMGTPE"[exp])
+}
+
+const defaultAutoIndexTemplate = `<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>Index of {{.Path}}</title></head>
+<body>
+<h1>Index of {{.Path}}</h1>
+<table>
+<tr>
+<th><a href="?sort=name&amp;order={{if and (eq .Sort "name") (eq .Order "asc")}}desc{{else}}asc{{end}}">Name</a></th>
+<th><a href="?sort=size&amp;order={{if and (eq .Sort "size") (eq .Order "asc")}}desc{{else}}asc{{end}}">Size</a></th>
+<th><a href="?sort=modified&amp;order={{if and (eq .Sort "modified") (eq .Order "asc")}}desc{{else}}asc{{end}}">Modified</a></th>
+</tr>
+{{if not .Root}}<tr><td><a href="../">../</a></td><td></td><td></td></tr>
+{{end}}{{range .Entries}}<tr><td><a href="{{.URL}}">{{.Name}}{{if .IsDir}}/{{end}}</a></td><td>{{.HumanSize}}</td><td>{{.Modified.UTC.Format "2006-01-02 15:04:05"}}</td></tr>
+{{end}}</table>
+</body>
+</html>
+`
+
+// autoIndex renders directory listings.
+type autoIndex struct {
+	template    *htmltemplate.Template
+	showHidden  bool
+	disableFile string
+}
+
+func newAutoIndex(c AutoIndexConfig) (*autoIndex, error) {
+	index := &autoIndex{showHidden: c.ShowHidden, disableFile: c.DisableFile}
+	if index.disableFile == "" {
+		index.disableFile = defaultAutoIndexDisableFile
+	}
+	var err error
+	if c.Template != "" {
+		index.template, err = htmltemplate.ParseFiles(c.Template)
+	} else {
+		index.template, err = htmltemplate.New("autoindex").Parse(defaultAutoIndexTemplate)
+	}
+	return index, errors.Wrap(err, "invalid autoindex template")
+}
+
+// serve lists the directory, or answers 404 Not Found if its listing is disabled.
+func (a *autoIndex) serve(c *gin.Context, dir http.File, name string) {
+	infos, err := dir.Readdir(-1)
+	if err != nil {
+		log.Error().Err(err).Str("path", c.Request.URL.Path).Msg("Failed to read directory")
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	page := AutoIndexPage{
+		Path:  c.Request.URL.Path,
+		Root:  path.Clean("/"+name) == "/",
+		Sort:  c.DefaultQuery("sort", "name"),
+		Order: c.DefaultQuery("order", "asc"),
+	}
+	for _, info := range infos {
+		if info.Name() == a.disableFile {
+			c.AbortWithStatus(http.StatusNotFound)
+			return
+		}
+		if !a.showHidden && strings.HasPrefix(info.Name(), ".") {
+			continue
+		}
+		link := (&url.URL{Path: info.Name()}).EscapedPath()
+		if info.IsDir() {
+			link += "/"
+		}
+		page.Entries = append(page.Entries, AutoIndexEntry{
+			Name:     info.Name(),
+			URL:      "./" + link,
+			IsDir:    info.IsDir(),
+			Size:     info.Size(),
+			Modified: info.ModTime(),
+		})
+	}
+	sortAutoIndex(page.Entries, page.Sort, page.Order == "desc")
+
+	var body bytes.Buffer
+	if err := a.template.Execute(&body, page); err != nil {
+		log.Error().Err(err).Str("path", c.Request.URL.Path).Msg("Failed to render directory listing")
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+	c.Data(http.StatusOK, "text/html; charset=utf-8", body.Bytes())
+}
+
+// sortAutoIndex sorts the entries by the given key, listing directories first. Unknown keys sort
+// by name, and ties are broken by name.
+func sortAutoIndex(entries []AutoIndexEntry, key string, descending bool) {
+	sort.SliceStable(entries, func(i, j int) bool {
+		a, b := entries[i], entries[j]
+		if a.IsDir != b.IsDir {
+			return a.IsDir
+		}
+		if descending {
+			a, b = b, a
+		}
+		switch {
+		case key == "size" && a.Size != b.Size:
+			return a.Size < b.Size
+		case key == "modified" && !a.Modified.Equal(b.Modified):
+			return a.Modified.Before(b.Modified)
+		}
+		return a.Name < b.Name
+	})
+}
@@ -3,7 +3,13 @@
 package controller
 
 import (
+	"bytes"
+	"io"
+	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
 
 	"github.com/animalet/sargantana-go/pkg/server"
 	"github.com/gin-gonic/gin"
@@ -66,6 +72,11 @@ var _ = Describe("Static Controller", func() {
 			Expect(err).NotTo(HaveOccurred())
 		})
 
+		It("should return error if transfer limits are negative", func() {
+			cfg := StaticControllerConfig{Path: "/static", Dir: "./testdata", MaxLargeTransfers: -1}
+			Expect(cfg.Validate()).To(MatchError(ContainSubstring("must not be negative")))
+		})
+
 		It("should pass validation with valid directory", func() {
 			cfg := StaticControllerConfig{Path: "/static", Dir: "./testdata"}
 			err := cfg.Validate()
@@ -104,4 +115,181 @@ file: ./testdata/test.txt`)
 			Expect(ctrl.Close()).To(Succeed())
 		})
 	})
+
+	Context("File transfers", func() {
+		var (
+			dir     string
+			content []byte
+			ctrl    server.IController
+		)
+
+		BeforeEach(func() {
+			dir = GinkgoT().TempDir()
+			content = bytes.Repeat([]byte("0123456789"), 100000)
+			Expect(os.WriteFile(filepath.Join(dir, "artifact.bin"), content, 0o644)).To(Succeed())
+			Expect(os.WriteFile(filepath.Join(dir, "small.txt"), []byte("small"), 0o644)).To(Succeed())
+			Expect(os.Mkdir(filepath.Join(dir, "site"), 0o755)).To(Succeed())
+			Expect(os.WriteFile(filepath.Join(dir, "site", "index.html"), []byte("<h1>home</h1>"), 0o644)).To(Succeed())
+
+			var err error
+			ctrl, err = NewStaticController(&StaticControllerConfig{Path: "/files", Dir: dir, MaxLargeTransfers: 1, LargeFileSize: 1000}, server.ControllerContext{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(ctrl.Bind(engine, nil)).To(Succeed())
+		})
+
+		serve := func(method, path string, header http.Header) *httptest.ResponseRecorder {
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest(method, path, nil)
+			for k, v := range header {
+				req.Header[k] = v
+			}
+			engine.ServeHTTP(w, req)
+			return w
+		}
+
+		It("should serve byte ranges", func() {
+			w := serve(http.MethodGet, "/files/artifact.bin", http.Header{"Range": {"bytes=10-19"}})
+			Expect(w.Code).To(Equal(http.StatusPartialContent))
+			Expect(w.Header().Get("Content-Range")).To(Equal("bytes 10-19/1000000"))
+			Expect(w.Header().Get("Accept-Ranges")).To(Equal("bytes"))
+			Expect(w.Body.String()).To(Equal("0123456789"))
+
+			w = serve(http.MethodGet, "/files/artifact.bin", http.Header{"Range": {"bytes=2000000-"}})
+			Expect(w.Code).To(Equal(http.StatusRequestedRangeNotSatisfiable))
+		})
+
+		It("should resume downloads only while the file is unchanged", func() {
+			etag := serve(http.MethodHead, "/files/artifact.bin", nil).Header().Get("ETag")
+			Expect(etag).To(MatchRegexp(`^"[0-9a-f]+-f4240"$`))
+
+			w := serve(http.MethodGet, "/files/artifact.bin", http.Header{"Range": {"bytes=999990-"}, "If-Range": {etag}})
+			Expect(w.Code).To(Equal(http.StatusPartialContent))
+			Expect(w.Body.String()).To(Equal("0123456789"))
+
+			w = serve(http.MethodGet, "/files/artifact.bin", http.Header{"Range": {"bytes=999990-"}, "If-Range": {`"stale"`}})
+			Expect(w.Code).To(Equal(http.StatusOK))
+			Expect(w.Body.Len()).To(Equal(len(content)))
+		})
+
+		It("should serve directory indexes and reject missing files and traversal", func() {
+			Expect(serve(http.MethodGet, "/files/site/", nil).Body.String()).To(Equal("<h1>home</h1>"))
+			Expect(serve(http.MethodGet, "/files/", nil).Code).To(Equal(http.StatusNotFound))
+			Expect(serve(http.MethodGet, "/files/missing.txt", nil).Code).To(Equal(http.StatusNotFound))
+			Expect(serve(http.MethodGet, "/files/../static_test.go", nil).Code).To(Equal(http.StatusNotFound))
+		})
+
+		It("should limit the concurrent large transfers", func() {
+			ctrl.(*static).transfers <- struct{}{}
+			w := serve(http.MethodGet, "/files/artifact.bin", nil)
+			Expect(w.Code).To(Equal(http.StatusServiceUnavailable))
+			Expect(w.Header().Get("Retry-After")).To(Equal("1"))
+			Expect(serve(http.MethodGet, "/files/small.txt", nil).Body.String()).To(Equal("small"))
+
+			<-ctrl.(*static).transfers
+			Expect(serve(http.MethodGet, "/files/artifact.bin", nil).Code).To(Equal(http.StatusOK))
+		})
+
+		It("should stream files through the connection and record the bytes sent", func() {
+			recorded := make(chan any, 1)
+			recorder := gin.New()
+			recorder.Use(func(c *gin.Context) {
+				c.Next()
+				value, _ := c.Get(server.ResponseBytesKey)
+				recorded <- value
+			})
+			Expect(ctrl.Bind(recorder, nil)).To(Succeed())
+			httpServer := httptest.NewServer(recorder)
+			defer httpServer.Close()
+
+			response, err := http.Get(httpServer.URL + "/files/artifact.bin")
+			Expect(err).NotTo(HaveOccurred())
+			defer func() { _ = response.Body.Close() }()
+			body, err := io.ReadAll(response.Body)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(body).To(Equal(content))
+			Eventually(recorded).Should(Receive(Equal(int64(len(content)))))
+		})
+	})
+
+	Context("Directory listings", func() {
+		var dir string
+
+		BeforeEach(func() {
+			dir = GinkgoT().TempDir()
+			Expect(os.WriteFile(filepath.Join(dir, "b.txt"), []byte("b"), 0o644)).To(Succeed())
+			Expect(os.WriteFile(filepath.Join(dir, "a large file.bin"), bytes.Repeat([]byte("a"), 1536), 0o644)).To(Succeed())
+			Expect(os.WriteFile(filepath.Join(dir, ".secret"), []byte("s"), 0o644)).To(Succeed())
+			Expect(os.Mkdir(filepath.Join(dir, "z-dir"), 0o755)).To(Succeed())
+			Expect(os.Mkdir(filepath.Join(dir, "private"), 0o755)).To(Succeed())
+			Expect(os.WriteFile(filepath.Join(dir, "private", ".noindex"), nil, 0o644)).To(Succeed())
+			Expect(os.WriteFile(filepath.Join(dir, "private", "file.txt"), []byte("f"), 0o644)).To(Succeed())
+		})
+
+		bind := func(autoIndex *AutoIndexConfig) {
+			cfg := &StaticControllerConfig{Path: "/share", Dir: dir, AutoIndex: autoIndex}
+			Expect(cfg.Validate()).To(Succeed())
+			ctrl, err := NewStaticController(cfg, server.ControllerContext{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(ctrl.Bind(engine, nil)).To(Succeed())
+		}
+
+		get := func(path string) *httptest.ResponseRecorder {
+			w := httptest.NewRecorder()
+			engine.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+			return w
+		}
+
+		It("should validate the configuration", func() {
+			Expect(StaticControllerConfig{Path: "/f", File: "./testdata/test.txt", AutoIndex: &AutoIndexConfig{}}.Validate()).
+				To(MatchError(ContainSubstring("autoindex requires dir")))
+			Expect(AutoIndexConfig{DisableFile: "a/b"}.Validate()).To(HaveOccurred())
+			Expect(AutoIndexConfig{Template: "./testdata/missing.html"}.Validate()).To(MatchError(ContainSubstring("invalid autoindex template")))
+		})
+
+		It("should list directories without hidden files", func() {
+			bind(&AutoIndexConfig{})
+			w := get("/share/")
+			Expect(w.Code).To(Equal(http.StatusOK))
+			Expect(w.Header().Get("Content-Type")).To(Equal("text/html; charset=utf-8"))
+			body := w.Body.String()
+			Expect(body).To(ContainSubstring(`<a href="./a%20large%20file.bin">a large file.bin</a></td><td>1.5 KiB</td>`))
+			Expect(body).NotTo(ContainSubstring(".secret"))
+			Expect(body).NotTo(ContainSubstring(`href="../"`))
+			Expect(strings.Index(body, "z-dir/")).To(BeNumerically("<", strings.Index(body, "a large file.bin")))
+			Expect(strings.Index(body, "a large file.bin")).To(BeNumerically("<", strings.Index(body, "b.txt")))
+
+			body = get("/share/?sort=size&order=desc").Body.String()
+			Expect(strings.Index(body, "a large file.bin")).To(BeNumerically("<", strings.Index(body, "b.txt")))
+			body = get("/share/?sort=size").Body.String()
+			Expect(strings.Index(body, "b.txt")).To(BeNumerically("<", strings.Index(body, "a large file.bin")))
+
+			Expect(get("/share/z-dir/").Body.String()).To(ContainSubstring(`href="../"`))
+		})
+
+		It("should redirect directories to their trailing slash", func() {
+			bind(&AutoIndexConfig{})
+			w := get("/share/z-dir?sort=size")
+			Expect(w.Code).To(Equal(http.StatusMovedPermanently))
+			Expect(w.Header().Get("Location")).To(Equal("/share/z-dir/?sort=size"))
+		})
+
+		It("should honour the per-directory disable file and the hidden files option", func() {
+			bind(&AutoIndexConfig{ShowHidden: true})
+			Expect(get("/share/private/").Code).To(Equal(http.StatusNotFound))
+			Expect(get("/share/private/file.txt").Body.String()).To(Equal("f"))
+			Expect(get("/share/").Body.String()).To(ContainSubstring(".secret"))
+		})
+
+		It("should render custom templates", func() {
+			template := filepath.Join(GinkgoT().TempDir(), "listing.html")
+			Expect(os.WriteFile(template, []byte(`{{.Path}}:{{range .Entries}} {{.Name}}{{end}}`), 0o644)).To(Succeed())
+			bind(&AutoIndexConfig{Template: template})
+			Expect(get("/share/").Body.String()).To(Equal("/share/: private z-dir a large file.bin b.txt"))
+		})
+
+		It("should not list directories when disabled", func() {
+			bind(nil)
+			Expect(get("/share/").Code).To(Equal(http.StatusNotFound))
+		})
+	})
 })
@@ -1,19 +1,117 @@
 package controller
 
 import (
+	"bytes"
+	"encoding/json"
+	htmltemplate "html/template"
 	"io/fs"
+	"maps"
+	"net/http"
 	"os"
 	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/animalet/sargantana-go/internal/snapshot"
 	"github.com/animalet/sargantana-go/pkg/server"
 	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/render"
 	"github.com/pkg/errors"
 	"github.com/rs/zerolog/log"
 )
 
 type TemplateControllerConfig struct {
 	Path string `yaml:"path"`
+	// Pages are routes rendering a template with static data, e.g. landing or legal pages.
+	Pages []TemplatePageConfig `yaml:"pages,omitempty"`
+}
+
+const defaultPageMaxBodySize = 1024 * 1024
+
+// Body parsers of the POST pages, see TemplatePageConfig.Parsers
+const (
+	pageParserJSON = "json"
+	pageParserForm = "form"
+)
+
+// TemplatePageConfig serves a template rendered with static data. Rendered pages are cached for
+// CacheTTL, so that templates are not executed on every request.
+type TemplatePageConfig struct {
+	Path     string         `yaml:"path"`
+	Template string         `yaml:"template"`
+	Data     map[string]any `yaml:"data,omitempty"`
+	// CacheTTL is how long the rendered page is served before being rendered again. Defaults to 0,
+	// rendering the page on every request.
+	CacheTTL time.Duration `yaml:"cache_ttl,omitempty"`
+	// Providers are the names of the data providers of the page, see RegisterPageDataProvider.
+	// The data of each provider is added to the data of the page under its name.
+	Providers []string `yaml:"providers,omitempty"`
+	// JSON answers the data of the page instead of the rendered page to the requests preferring
+	// application/json, so that single-page applications share the data of the page.
+	JSON bool `yaml:"json,omitempty"`
+	// Methods are the methods served, GET and POST. Defaults to GET.
+	Methods []string `yaml:"methods,omitempty"`
+	// Parsers are the body formats accepted by POST, json and form, added to the data of the page
+	// under body. Defaults to both.
+	Parsers []string `yaml:"parsers,omitempty"`
+	// MaxBodySize is the size in bytes of the largest POST body. Defaults to 1 MiB.
+	MaxBodySize int64 `yaml:"max_body_size,omitempty"`
+}
+
+func (c TemplatePageConfig) Validate() error {
+	if !strings.HasPrefix(c.Path, "/") {
+		return errors.New("path must be set and start with /")
+	}
+	if c.Template == "" {
+		return errors.New("template must be set and non-empty")
+	}
+	if c.CacheTTL < 0 {
+		return errors.New("cache_ttl must not be negative")
+	}
+	if c.CacheTTL > 0 && len(c.Providers) > 0 {
+		return errors.New("cache_ttl cannot be set with providers, whose data changes per request")
+	}
+	for _, method := range c.Methods {
+		if method != http.MethodGet && method != http.MethodPost {
+			return errors.Errorf("unsupported method %q, must be GET or POST", method)
+		}
+	}
+	for _, parser := range c.Parsers {
+		if parser != pageParserJSON && parser != pageParserForm {
+			return errors.Errorf("unsupported parser %q, must be json or form", parser)
+		}
+	}
+	if c.MaxBodySize < 0 {
+		return errors.New("max_body_size must not be negative")
+	}
+	return nil
+}
+
+// PageDataProvider returns the data of a template page for the request, e.g. the articles of a
+// listing. Providers are registered by name with RegisterPageDataProvider and referenced from the
+// pages. Their errors fail the request with 500 Internal Server Error.
+type PageDataProvider func(c *gin.Context) (any, error)
+
+var (
+	pageDataProvidersMu sync.RWMutex
+	pageDataProviders   = make(map[string]PageDataProvider)
+)
+
+// RegisterPageDataProvider registers a page data provider under the given name. It must be called
+// before the controllers are created, e.g. next to server.RegisterController.
+func RegisterPageDataProvider(name string, provider PageDataProvider) {
+	pageDataProvidersMu.Lock()
+	defer pageDataProvidersMu.Unlock()
+	pageDataProviders[name] = provider
+}
+
+func lookupPageDataProvider(name string) (PageDataProvider, bool) {
+	pageDataProvidersMu.RLock()
+	defer pageDataProvidersMu.RUnlock()
+	provider, ok := pageDataProviders[name]
+	return provider, ok
 }
 
 func NewTemplateController(c *TemplateControllerConfig, _ server.ControllerContext) (server.IController, error) {
@@ -24,8 +122,24 @@ func NewTemplateController(c *TemplateControllerConfig, _ server.ControllerConte
 		Str("path", configCopy.Path).
 		Msg("Templates directory configured")
 
+	pages := make([]*templatePage, 0, len(configCopy.Pages))
+	for _, page := range configCopy.Pages {
+		log.Info().
+			Str("path", page.Path).
+			Str("template", page.Template).
+			Dur("cache_ttl", page.CacheTTL).
+			Bool("json", page.JSON).
+			Msg("Template page configured")
+		p, err := newTemplatePage(page)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid page %s", page.Path)
+		}
+		pages = append(pages, p)
+	}
+
 	return &template{
-		path: configCopy.Path,
+		path:  configCopy.Path,
+		pages: pages,
 	}, nil
 }
 
@@ -37,6 +151,11 @@ func (c TemplateControllerConfig) Validate() error {
 	if !stat.IsDir() {
 		return errors.New("templates path is not a directory")
 	}
+	for i, page := range c.Pages {
+		if err := page.Validate(); err != nil {
+			return errors.Wrapf(err, "invalid page at index %d", i)
+		}
+	}
 	return nil
 }
 
@@ -45,11 +164,13 @@ func (c TemplateControllerConfig) Validate() error {
 // images, and HTML files, as well as Go template rendering capabilities.
 type template struct {
 	server.IController
-	path string
+	path  string
+	pages []*templatePage
 }
 
 // Bind registers the template controller with the provided Gin engine.
-// It sets up the HTML template rendering by loading templates from the configured directory.
+// It sets up the HTML template rendering by loading templates from the configured directory,
+// parsing them so that syntax errors fail the startup, and registers the routes of the pages.
 func (t *template) Bind(engine *gin.Engine, _ gin.HandlerFunc) error {
 	if stat, err := os.Stat(t.path); err == nil && stat.IsDir() {
 		var found bool
@@ -68,14 +189,249 @@ func (t *template) Bind(engine *gin.Engine, _ gin.HandlerFunc) error {
 		}
 
 		if found {
-			engine.LoadHTMLGlob(t.path + "/**")
+			if err := loadTemplates(engine, t.path+"/**"); err != nil {
+				return err
+			}
 		} else {
 			log.Warn().Msg("Templates directory present but no files found, skipping templates.")
 		}
 	}
+
+	if len(t.pages) == 0 {
+		return nil
+	}
+	templates, err := parsedTemplates(engine.HTMLRender)
+	if err != nil {
+		return err
+	}
+	for _, page := range t.pages {
+		if templates.Lookup(page.config.Template) == nil {
+			return errors.Errorf("template %q of page %s not found", page.config.Template, page.config.Path)
+		}
+		for _, method := range page.methods {
+			engine.Handle(method, page.config.Path, page.serve(engine))
+		}
+	}
+	return nil
+}
+
+// loadTemplates adds the templates matching the pattern to the ones already loaded in the engine,
+// so that several template controllers can be bound. Templates with the same name as a loaded one
+// replace it.
+func loadTemplates(engine *gin.Engine, pattern string) error {
+	switch loaded := engine.HTMLRender.(type) {
+	case render.HTMLProduction:
+		templates, err := loaded.Template.ParseGlob(pattern)
+		if err != nil {
+			return errors.Wrap(err, "error parsing templates")
+		}
+		engine.SetHTMLTemplate(templates)
+		return nil
+	case render.HTMLDebug:
+		// Debug renderers parse the files on every request, keep loading all of them
+		files := loaded.Files
+		for _, glob := range []string{loaded.Glob, pattern} {
+			if glob == "" {
+				continue
+			}
+			matches, err := filepath.Glob(glob)
+			if err != nil {
+				return errors.Wrap(err, "error listing templates")
+			}
+			files = append(files, matches...)
+		}
+		engine.LoadHTMLFiles(files...)
+	default:
+		if err := loadHTMLGlob(engine, pattern); err != nil {
+			return err
+		}
+	}
+	// Debug renderers are only parsed when rendering, parse them now to report syntax errors
+	_, err := parsedTemplates(engine.HTMLRender)
+	return err
+}
+
+// loadHTMLGlob loads the first templates in the engine, returning the parsing errors Gin panics with
+func loadHTMLGlob(engine *gin.Engine, pattern string) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = errors.Errorf("error parsing templates: %v", r)
+		}
+	}()
+	engine.LoadHTMLGlob(pattern)
 	return nil
 }
 
+// parsedTemplates returns the templates loaded in the engine, parsing them in debug mode
+func parsedTemplates(renderer render.HTMLRender) (*htmltemplate.Template, error) {
+	switch loaded := renderer.(type) {
+	case render.HTMLProduction:
+		return loaded.Template, nil
+	case render.HTMLDebug:
+		templates := htmltemplate.New("").Delims(loaded.Delims.Left, loaded.Delims.Right).Funcs(loaded.FuncMap)
+		var err error
+		if len(loaded.Files) > 0 {
+			templates, err = templates.ParseFiles(loaded.Files...)
+		} else {
+			templates, err = templates.ParseGlob(loaded.Glob)
+		}
+		return templates, errors.Wrap(err, "error parsing templates")
+	default:
+		return nil, errors.New("no templates loaded")
+	}
+}
+
+// templatePage serves a template rendered with the data of the page, see TemplatePageConfig
+type templatePage struct {
+	config    TemplatePageConfig
+	methods   []string
+	parsers   []string
+	providers map[string]PageDataProvider
+	mu        sync.Mutex
+	body      []byte
+	expires   time.Time
+}
+
+func newTemplatePage(c TemplatePageConfig) (*templatePage, error) {
+	page := &templatePage{config: c, methods: c.Methods, parsers: c.Parsers, providers: make(map[string]PageDataProvider)}
+	if len(page.methods) == 0 {
+		page.methods = []string{http.MethodGet}
+	}
+	if len(page.parsers) == 0 {
+		page.parsers = []string{pageParserJSON, pageParserForm}
+	}
+	if page.config.MaxBodySize == 0 {
+		page.config.MaxBodySize = defaultPageMaxBodySize
+	}
+	for _, name := range c.Providers {
+		provider, ok := lookupPageDataProvider(name)
+		if !ok {
+			return nil, errors.Errorf("unknown page data provider %q", name)
+		}
+		page.providers[name] = provider
+	}
+	return page, nil
+}
+
+func (p *templatePage) serve(engine *gin.Engine) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		wantsJSON := false
+		if p.config.JSON {
+			c.Header("Vary", "Accept")
+			wantsJSON = c.NegotiateFormat(gin.MIMEHTML, gin.MIMEJSON) == gin.MIMEJSON
+		}
+		cached := p.config.CacheTTL > 0 && c.Request.Method == http.MethodGet
+		if cached && !wantsJSON {
+			if body := p.cached(); body != nil {
+				c.Data(http.StatusOK, "text/html; charset=utf-8", body)
+				return
+			}
+		}
+
+		data, ok := p.data(c)
+		if !ok {
+			return
+		}
+		if wantsJSON {
+			c.JSON(http.StatusOK, data)
+			return
+		}
+		body, err := p.render(engine, data)
+		if err != nil {
+			_ = c.AbortWithError(http.StatusInternalServerError, err)
+			return
+		}
+		if cached {
+			p.mu.Lock()
+			p.body, p.expires = body, time.Now().Add(p.config.CacheTTL)
+			p.mu.Unlock()
+		}
+		c.Data(http.StatusOK, "text/html; charset=utf-8", body)
+	}
+}
+
+// cached returns the rendered page until it expires
+func (p *templatePage) cached() []byte {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if time.Now().Before(p.expires) {
+		return p.body
+	}
+	return nil
+}
+
+// data returns the data of the page for the request: the static data, the data of the providers
+// and the body of the POST requests. It aborts the request on failure.
+func (p *templatePage) data(c *gin.Context) (map[string]any, bool) {
+	data := maps.Clone(p.config.Data)
+	if data == nil {
+		data = make(map[string]any)
+	}
+	for name, provider := range p.providers {
+		value, err := provider(c)
+		if err != nil {
+			_ = c.AbortWithError(http.StatusInternalServerError, errors.Wrapf(err, "page data provider %q failed", name))
+			return nil, false
+		}
+		data[name] = value
+	}
+	if c.Request.Method == http.MethodPost {
+		body, status, err := p.parseBody(c)
+		if err != nil {
+			_ = c.AbortWithError(status, err)
+			return nil, false
+		}
+		data["body"] = body
+	}
+	return data, true
+}
+
+// parseBody parses the body of the request with the parser of its content type
+func (p *templatePage) parseBody(c *gin.Context) (any, int, error) {
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, p.config.MaxBodySize)
+	var body any
+	var err error
+	switch contentType := c.ContentType(); {
+	case contentType == gin.MIMEJSON && slices.Contains(p.parsers, pageParserJSON):
+		err = json.NewDecoder(c.Request.Body).Decode(&body)
+	case contentType == gin.MIMEPOSTForm && slices.Contains(p.parsers, pageParserForm):
+		if err = c.Request.ParseForm(); err == nil {
+			form := make(map[string]any, len(c.Request.PostForm))
+			for key, values := range c.Request.PostForm {
+				if len(values) == 1 {
+					form[key] = values[0]
+				} else {
+					form[key] = values
+				}
+			}
+			body = form
+		}
+	default:
+		return nil, http.StatusUnsupportedMediaType, errors.Errorf("unsupported content type %q", contentType)
+	}
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			return nil, http.StatusRequestEntityTooLarge, err
+		}
+		return nil, http.StatusBadRequest, errors.Wrap(err, "invalid body")
+	}
+	return body, http.StatusOK, nil
+}
+
+// render executes the template of the page with its data
+func (p *templatePage) render(engine *gin.Engine, data map[string]any) ([]byte, error) {
+	templates, err := parsedTemplates(engine.HTMLRender)
+	if err != nil {
+		return nil, err
+	}
+	var rendered bytes.Buffer
+	if err = templates.ExecuteTemplate(&rendered, p.config.Template, data); err != nil {
+		return nil, errors.Wrapf(err, "error rendering template %q", p.config.Template)
+	}
+	return rendered.Bytes(), nil
+}
+
 // Close performs cleanup for the static controller.
 //
 // Returns nil as no cleanup is required.
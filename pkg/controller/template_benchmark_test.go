@@ -0,0 +1,79 @@
+//go:build unit
+
+package controller_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/animalet/sargantana-go/pkg/controller"
+	"github.com/animalet/sargantana-go/pkg/server"
+	"github.com/gin-gonic/gin"
+)
+
+const benchmarkTemplate = `<html><body><h1>{{ .title }}</h1><ul>{{ range .items }}<li>{{ . }}</li>{{ end }}</ul></body></html>`
+
+// benchmarkPageEngine binds a template controller serving a page with a list of 100 items
+func benchmarkPageEngine(b *testing.B, mode string, cacheTTL time.Duration) *gin.Engine {
+	gin.SetMode(mode)
+	b.Cleanup(func() { gin.SetMode(gin.TestMode) })
+
+	dir := b.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "page.html"), []byte(benchmarkTemplate), 0644); err != nil {
+		b.Fatal(err)
+	}
+	items := make([]any, 100)
+	for i := range items {
+		items[i] = strings.Repeat("item ", 10)
+	}
+	ctrl, err := controller.NewTemplateController(&controller.TemplateControllerConfig{
+		Path: dir,
+		Pages: []controller.TemplatePageConfig{{
+			Path:     "/page",
+			Template: "page.html",
+			Data:     map[string]any{"title": "Benchmark", "items": items},
+			CacheTTL: cacheTTL,
+		}},
+	}, server.ControllerContext{})
+	if err != nil {
+		b.Fatal(err)
+	}
+	engine := gin.New()
+	if err = ctrl.Bind(engine, nil); err != nil {
+		b.Fatal(err)
+	}
+	return engine
+}
+
+func benchmarkPage(b *testing.B, engine *gin.Engine) {
+	request := httptest.NewRequest(http.MethodGet, "/page", nil)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		w := httptest.NewRecorder()
+		engine.ServeHTTP(w, request)
+		if w.Code != http.StatusOK {
+			b.Fatalf("unexpected status %d", w.Code)
+		}
+	}
+}
+
+func BenchmarkTemplatePage_Uncached(b *testing.B) {
+	benchmarkPage(b, benchmarkPageEngine(b, gin.ReleaseMode, 0))
+}
+
+func BenchmarkTemplatePage_Cached(b *testing.B) {
+	benchmarkPage(b, benchmarkPageEngine(b, gin.ReleaseMode, time.Minute))
+}
+
+func BenchmarkTemplatePage_DebugUncached(b *testing.B) {
+	benchmarkPage(b, benchmarkPageEngine(b, gin.DebugMode, 0))
+}
+
+func BenchmarkTemplatePage_DebugCached(b *testing.B) {
+	benchmarkPage(b, benchmarkPageEngine(b, gin.DebugMode, time.Minute))
+}
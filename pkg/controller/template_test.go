@@ -3,8 +3,13 @@
 package controller
 
 import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
 	"os"
 	"path/filepath"
+	"strings"
+	"time"
 
 	"github.com/animalet/sargantana-go/pkg/config"
 	"github.com/animalet/sargantana-go/pkg/server"
@@ -80,6 +85,154 @@ var _ = Describe("TemplateController", func() {
 		})
 	})
 
+	Context("Pages", func() {
+		BeforeEach(func() {
+			Expect(os.WriteFile(filepath.Join(tempDir, "about.html"), []byte("<h1>{{ .title }}</h1>"), 0644)).To(Succeed())
+		})
+
+		bind := func(pages ...TemplatePageConfig) *gin.Engine {
+			cfg := TemplateControllerConfig{Path: tempDir, Pages: pages}
+			Expect(cfg.Validate()).To(Succeed())
+			ctrl, err := NewTemplateController(&cfg, server.ControllerContext{})
+			Expect(err).NotTo(HaveOccurred())
+			engine := gin.New()
+			Expect(ctrl.Bind(engine, nil)).To(Succeed())
+			return engine
+		}
+
+		get := func(engine *gin.Engine, path string) *httptest.ResponseRecorder {
+			w := httptest.NewRecorder()
+			engine.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+			return w
+		}
+
+		It("should validate the pages", func() {
+			Expect(TemplatePageConfig{Path: "about", Template: "about.html"}.Validate()).To(MatchError(ContainSubstring("path")))
+			Expect(TemplatePageConfig{Path: "/about"}.Validate()).To(MatchError(ContainSubstring("template")))
+			Expect(TemplatePageConfig{Path: "/about", Template: "about.html", CacheTTL: -time.Second}.Validate()).To(MatchError(ContainSubstring("cache_ttl")))
+			Expect(TemplateControllerConfig{Path: tempDir, Pages: []TemplatePageConfig{{Path: "/about"}}}.Validate()).To(MatchError(ContainSubstring("invalid page at index 0")))
+		})
+
+		It("should render the pages with their data", func() {
+			engine := bind(TemplatePageConfig{Path: "/about", Template: "about.html", Data: map[string]any{"title": "About us"}})
+			w := get(engine, "/about")
+			Expect(w.Code).To(Equal(http.StatusOK))
+			Expect(w.Header().Get("Content-Type")).To(Equal("text/html; charset=utf-8"))
+			Expect(w.Body.String()).To(Equal("<h1>About us</h1>"))
+		})
+
+		It("should fail to bind pages of unknown templates", func() {
+			ctrl, err := NewTemplateController(&TemplateControllerConfig{
+				Path:  tempDir,
+				Pages: []TemplatePageConfig{{Path: "/missing", Template: "missing.html"}},
+			}, server.ControllerContext{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(ctrl.Bind(gin.New(), nil)).To(MatchError(ContainSubstring(`template "missing.html" of page /missing not found`)))
+		})
+
+		It("should serve the cached page until it expires", func() {
+			gin.SetMode(gin.DebugMode)
+			DeferCleanup(gin.SetMode, gin.TestMode)
+			engine := bind(
+				TemplatePageConfig{Path: "/cached", Template: "about.html", Data: map[string]any{"title": "Cached"}, CacheTTL: 100 * time.Millisecond},
+				TemplatePageConfig{Path: "/live", Template: "about.html", Data: map[string]any{"title": "Live"}},
+			)
+			Expect(get(engine, "/cached").Body.String()).To(Equal("<h1>Cached</h1>"))
+
+			// Debug renderers reload the edited templates
+			Expect(os.WriteFile(filepath.Join(tempDir, "about.html"), []byte("<h2>{{ .title }}</h2>"), 0644)).To(Succeed())
+			Expect(get(engine, "/live").Body.String()).To(Equal("<h2>Live</h2>"))
+			Expect(get(engine, "/cached").Body.String()).To(Equal("<h1>Cached</h1>"))
+			Eventually(func() string { return get(engine, "/cached").Body.String() }).Should(Equal("<h2>Cached</h2>"))
+		})
+
+		Context("with data providers and JSON variants", func() {
+			BeforeEach(func() {
+				RegisterPageDataProvider("articles", func(c *gin.Context) (any, error) {
+					return []string{"first", c.Query("second")}, nil
+				})
+				DeferCleanup(func() { delete(pageDataProviders, "articles") })
+				Expect(os.WriteFile(filepath.Join(tempDir, "list.html"), []byte(
+					"<h1>{{ .title }}</h1>{{ range .articles }}<p>{{ . }}</p>{{ end }}{{ with .body }}<em>{{ .name }}</em>{{ end }}"), 0644)).To(Succeed())
+			})
+
+			request := func(engine *gin.Engine, method, path, accept, contentType, body string) *httptest.ResponseRecorder {
+				req := httptest.NewRequest(method, path, strings.NewReader(body))
+				req.Header.Set("Accept", accept)
+				req.Header.Set("Content-Type", contentType)
+				w := httptest.NewRecorder()
+				engine.ServeHTTP(w, req)
+				return w
+			}
+
+			It("should validate the pages", func() {
+				page := TemplatePageConfig{Path: "/list", Template: "list.html"}
+				withCache := page
+				withCache.Providers, withCache.CacheTTL = []string{"articles"}, time.Minute
+				Expect(withCache.Validate()).To(MatchError(ContainSubstring("cache_ttl")))
+				withMethod := page
+				withMethod.Methods = []string{http.MethodPut}
+				Expect(withMethod.Validate()).To(MatchError(ContainSubstring(`unsupported method "PUT"`)))
+				withParser := page
+				withParser.Parsers = []string{"xml"}
+				Expect(withParser.Validate()).To(MatchError(ContainSubstring(`unsupported parser "xml"`)))
+
+				_, err := NewTemplateController(&TemplateControllerConfig{
+					Path:  tempDir,
+					Pages: []TemplatePageConfig{{Path: "/list", Template: "list.html", Providers: []string{"missing"}}},
+				}, server.ControllerContext{})
+				Expect(err).To(MatchError(ContainSubstring(`unknown page data provider "missing"`)))
+			})
+
+			It("should render the data of the providers", func() {
+				engine := bind(TemplatePageConfig{Path: "/list", Template: "list.html", Data: map[string]any{"title": "News"}, Providers: []string{"articles"}})
+				w := get(engine, "/list?second=other")
+				Expect(w.Body.String()).To(Equal("<h1>News</h1><p>first</p><p>other</p>"))
+				Expect(w.Header().Get("Vary")).To(BeEmpty())
+			})
+
+			It("should answer the data to the requests preferring JSON", func() {
+				engine := bind(TemplatePageConfig{Path: "/list", Template: "list.html", Data: map[string]any{"title": "News"}, Providers: []string{"articles"}, JSON: true})
+				w := request(engine, http.MethodGet, "/list?second=other", "application/json", "", "")
+				Expect(w.Code).To(Equal(http.StatusOK))
+				Expect(w.Header().Get("Vary")).To(Equal("Accept"))
+				Expect(w.Body.String()).To(MatchJSON(`{"title":"News","articles":["first","other"]}`))
+
+				w = request(engine, http.MethodGet, "/list", "text/html,application/xhtml+xml,*/*;q=0.8", "", "")
+				Expect(w.Header().Get("Content-Type")).To(Equal("text/html; charset=utf-8"))
+				Expect(w.Header().Get("Vary")).To(Equal("Accept"))
+			})
+
+			It("should not answer the cached page to the requests preferring JSON", func() {
+				engine := bind(TemplatePageConfig{Path: "/about", Template: "about.html", Data: map[string]any{"title": "About"}, JSON: true, CacheTTL: time.Minute})
+				Expect(get(engine, "/about").Body.String()).To(Equal("<h1>About</h1>"))
+				Expect(request(engine, http.MethodGet, "/about", "application/json", "", "").Body.String()).To(MatchJSON(`{"title":"About"}`))
+			})
+
+			It("should parse the bodies of the POST requests", func() {
+				engine := bind(TemplatePageConfig{
+					Path: "/list", Template: "list.html", Data: map[string]any{"title": "Search"},
+					Methods: []string{http.MethodGet, http.MethodPost}, Parsers: []string{"form"}, JSON: true, MaxBodySize: 64,
+				})
+				w := request(engine, http.MethodPost, "/list", "text/html", "application/x-www-form-urlencoded", url.Values{"name": {"Ana"}}.Encode())
+				Expect(w.Body.String()).To(Equal("<h1>Search</h1><em>Ana</em>"))
+				w = request(engine, http.MethodPost, "/list", "application/json", "application/x-www-form-urlencoded", url.Values{"tag": {"a", "b"}}.Encode())
+				Expect(w.Body.String()).To(MatchJSON(`{"title":"Search","body":{"tag":["a","b"]}}`))
+
+				Expect(request(engine, http.MethodPost, "/list", "text/html", "application/json", `{"name":"Ana"}`).Code).To(Equal(http.StatusUnsupportedMediaType))
+				Expect(request(engine, http.MethodPost, "/list", "text/html", "application/x-www-form-urlencoded", "name="+strings.Repeat("a", 64)).Code).To(Equal(http.StatusRequestEntityTooLarge))
+				Expect(get(engine, "/list").Body.String()).To(Equal("<h1>Search</h1>"))
+			})
+
+			It("should parse the JSON bodies by default", func() {
+				engine := bind(TemplatePageConfig{Path: "/list", Template: "list.html", Methods: []string{http.MethodPost}})
+				w := request(engine, http.MethodPost, "/list", "text/html", "application/json", `{"name":"Ana"}`)
+				Expect(w.Body.String()).To(Equal("<h1></h1><em>Ana</em>"))
+				Expect(request(engine, http.MethodPost, "/list", "text/html", "application/json", `{"name"`).Code).To(Equal(http.StatusBadRequest))
+			})
+		})
+	})
+
 	Context("Binding", func() {
 		It("should load templates", func() {
 			// Create a dummy template file
@@ -107,6 +260,31 @@ var _ = Describe("TemplateController", func() {
 			// We can at least ensure Bind didn't panic
 		})
 
+		It("should load the templates of several controllers", func() {
+			for _, mode := range []string{gin.TestMode, gin.DebugMode} {
+				gin.SetMode(mode)
+				engine := gin.New()
+				for _, name := range []string{"docs", "app"} {
+					dir := filepath.Join(tempDir, mode, name)
+					Expect(os.MkdirAll(dir, 0755)).To(Succeed())
+					Expect(os.WriteFile(filepath.Join(dir, name+".html"), []byte("<h1>"+name+"</h1>"), 0644)).To(Succeed())
+					ctrl, err := NewTemplateController(&TemplateControllerConfig{Path: dir}, server.ControllerContext{})
+					Expect(err).NotTo(HaveOccurred())
+					Expect(ctrl.Bind(engine, nil)).To(Succeed())
+				}
+				engine.GET("/:name", func(c *gin.Context) {
+					c.HTML(http.StatusOK, c.Param("name")+".html", nil)
+				})
+
+				for _, name := range []string{"docs", "app"} {
+					w := httptest.NewRecorder()
+					engine.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/"+name, nil))
+					Expect(w.Body.String()).To(Equal("<h1>"+name+"</h1>"), mode)
+				}
+			}
+			gin.SetMode(gin.TestMode)
+		})
+
 		It("should handle empty templates directory", func() {
 			// Create an empty directory with no template files
 			emptyDir := filepath.Join(tempDir, "empty")
@@ -138,6 +316,17 @@ var _ = Describe("TemplateController", func() {
 			Expect(err).NotTo(HaveOccurred())
 		})
 
+		It("should fail to bind templates with syntax errors", func() {
+			Expect(os.WriteFile(filepath.Join(tempDir, "broken.html"), []byte("<h1>{{ .Title </h1>"), 0644)).To(Succeed())
+			for _, mode := range []string{gin.TestMode, gin.DebugMode} {
+				gin.SetMode(mode)
+				ctrl, err := NewTemplateController(&TemplateControllerConfig{Path: tempDir}, server.ControllerContext{})
+				Expect(err).NotTo(HaveOccurred())
+				Expect(ctrl.Bind(gin.New(), nil)).To(MatchError(ContainSubstring("broken.html")), mode)
+			}
+			gin.SetMode(gin.TestMode)
+		})
+
 		It("should handle non-existent path in Bind gracefully", func() {
 			tmplCfg := TemplateControllerConfig{
 				Path: "/non/existent/path",
@@ -0,0 +1,92 @@
+package controller
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"os"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// pinPrefix is the optional prefix of SPKI pins, as in the HTTP Public Key Pinning syntax
+const pinPrefix = "sha256/"
+
+// UpstreamTLSConfig configures the TLS connections of a load balancer to its endpoints, so that
+// services using a private PKI can be proxied without changing the trust of the whole process.
+type UpstreamTLSConfig struct {
+	// CAFile is a PEM bundle of the CAs trusted for the endpoints, replacing the system roots.
+	CAFile string `yaml:"ca_file,omitempty"`
+	// Pins are base64 SHA-256 hashes of certificate public keys (SubjectPublicKeyInfo), optionally
+	// prefixed with "sha256/". When set, the verified chain of the endpoint must contain one of them.
+	Pins []string `yaml:"pins,omitempty"`
+	// ServerName overrides the host name verified in the endpoint certificates and sent with SNI,
+	// e.g. when the endpoints are addressed by IP.
+	ServerName string `yaml:"server_name,omitempty"`
+}
+
+func (c UpstreamTLSConfig) Validate() error {
+	for _, pin := range c.Pins {
+		if _, err := decodePin(pin); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// buildTLSConfig loads the CA bundle and returns the client TLS configuration verifying the pins.
+func (c UpstreamTLSConfig) buildTLSConfig() (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		ServerName: c.ServerName,
+	}
+
+	if c.CAFile != "" {
+		// #nosec G304 -- CA file path is provided by the operator in the configuration
+		bundle, err := os.ReadFile(c.CAFile)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to read upstream CA bundle")
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(bundle) {
+			return nil, errors.Errorf("failed to parse upstream CA bundle %q", c.CAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if len(c.Pins) > 0 {
+		pins := make(map[[sha256.Size]byte]bool, len(c.Pins))
+		for _, pin := range c.Pins {
+			hash, err := decodePin(pin)
+			if err != nil {
+				return nil, err
+			}
+			pins[hash] = true
+		}
+		// Runs after the chain is verified against the trusted CAs
+		tlsConfig.VerifyConnection = func(state tls.ConnectionState) error {
+			for _, chain := range state.VerifiedChains {
+				for _, cert := range chain {
+					if pins[sha256.Sum256(cert.RawSubjectPublicKeyInfo)] {
+						return nil
+					}
+				}
+			}
+			return errors.Errorf("certificate of %s matches no pinned public key", state.ServerName)
+		}
+	}
+	return tlsConfig, nil
+}
+
+// decodePin decodes a base64 SHA-256 SPKI pin, with or without the "sha256/" prefix
+func decodePin(pin string) ([sha256.Size]byte, error) {
+	var hash [sha256.Size]byte
+	decoded, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(pin, pinPrefix))
+	if err != nil || len(decoded) != sha256.Size {
+		return hash, errors.Errorf("invalid pin %q, expected a base64 SHA-256 hash", pin)
+	}
+	copy(hash[:], decoded)
+	return hash, nil
+}
@@ -0,0 +1,92 @@
+//go:build unit
+
+package controller
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+
+	"github.com/animalet/sargantana-go/pkg/server"
+	"github.com/gin-gonic/gin"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Upstream TLS", func() {
+	var (
+		backend *httptest.Server
+		caFile  string
+		pin     string
+	)
+
+	BeforeEach(func() {
+		backend = httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write([]byte("private"))
+		}))
+		DeferCleanup(backend.Close)
+
+		caFile = filepath.Join(GinkgoT().TempDir(), "ca.pem")
+		Expect(os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: backend.Certificate().Raw}), 0600)).To(Succeed())
+		hash := sha256.Sum256(backend.Certificate().RawSubjectPublicKeyInfo)
+		pin = "sha256/" + base64.StdEncoding.EncodeToString(hash[:])
+	})
+
+	proxy := func(tlsConfig *UpstreamTLSConfig) int {
+		ctrl, err := NewLoadBalancerController(&LoadBalancerControllerConfig{
+			Path:      "/api",
+			Endpoints: []string{backend.URL},
+			TLS:       tlsConfig,
+		}, server.ControllerContext{})
+		Expect(err).NotTo(HaveOccurred())
+		engine := gin.New()
+		Expect(ctrl.Bind(engine, nil)).To(Succeed())
+
+		w := httptest.NewRecorder()
+		engine.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/test", nil))
+		return w.Code
+	}
+
+	It("should reject endpoints signed by a private CA by default", func() {
+		Expect(proxy(nil)).To(Equal(http.StatusBadGateway))
+	})
+
+	It("should trust the CA bundle of the binding", func() {
+		Expect(proxy(&UpstreamTLSConfig{CAFile: caFile})).To(Equal(http.StatusOK))
+	})
+
+	It("should verify the pinned public keys", func() {
+		Expect(proxy(&UpstreamTLSConfig{CAFile: caFile, Pins: []string{pin}})).To(Equal(http.StatusOK))
+		otherPin := base64.StdEncoding.EncodeToString(make([]byte, sha256.Size))
+		Expect(proxy(&UpstreamTLSConfig{CAFile: caFile, Pins: []string{otherPin}})).To(Equal(http.StatusBadGateway))
+	})
+
+	It("should verify the overridden server name", func() {
+		Expect(proxy(&UpstreamTLSConfig{CAFile: caFile, ServerName: "example.com"})).To(Equal(http.StatusOK))
+		Expect(proxy(&UpstreamTLSConfig{CAFile: caFile, ServerName: "internal.test"})).To(Equal(http.StatusBadGateway))
+	})
+
+	It("should validate the configuration", func() {
+		Expect(UpstreamTLSConfig{Pins: []string{pin}}.Validate()).To(Succeed())
+		Expect(UpstreamTLSConfig{Pins: []string{"sha256/not-base64"}}.Validate()).To(MatchError(ContainSubstring("invalid pin")))
+		Expect(LoadBalancerControllerConfig{
+			Path:      "/api",
+			Endpoints: []string{"https://backend"},
+			SPIFFE:    true,
+			TLS:       &UpstreamTLSConfig{},
+		}.Validate()).To(MatchError(ContainSubstring("tls and spiffe")))
+	})
+
+	It("should fail on unreadable CA bundles", func() {
+		_, err := NewLoadBalancerController(&LoadBalancerControllerConfig{
+			Path:      "/api",
+			Endpoints: []string{backend.URL},
+			TLS:       &UpstreamTLSConfig{CAFile: filepath.Join(GinkgoT().TempDir(), "missing.pem")},
+		}, server.ControllerContext{})
+		Expect(err).To(MatchError(ContainSubstring("failed to read upstream CA bundle")))
+	})
+})
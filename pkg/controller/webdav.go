@@ -0,0 +1,134 @@
+package controller
+
+import (
+	"net/http"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/animalet/sargantana-go/internal/snapshot"
+	"github.com/animalet/sargantana-go/pkg/server"
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	"golang.org/x/net/webdav"
+)
+
+// webDAVMethods are the HTTP methods of WebDAV (RFC 4918) served by the controller.
+var webDAVMethods = []string{
+	http.MethodOptions, http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodDelete,
+	"PROPFIND", "PROPPATCH", "MKCOL", "COPY", "MOVE", "LOCK", "UNLOCK",
+}
+
+// webDAVReadMethods are the methods allowed in read-only mode.
+var webDAVReadMethods = map[string]bool{
+	http.MethodOptions: true, http.MethodGet: true, http.MethodHead: true, "PROPFIND": true,
+}
+
+// WebDAVControllerConfig exposes a directory or an S3 bucket as a WebDAV share. Shares always
+// require authentication: clients that cannot follow login redirects, e.g. mounted drives, need an
+// authenticator accepting credentials on every request.
+type WebDAVControllerConfig struct {
+	Path string `yaml:"path"`
+	// ReadOnly rejects every method modifying the share with 405 Method Not Allowed.
+	ReadOnly bool `yaml:"read_only,omitempty"`
+	// Dir is the directory of the share. Exactly one of dir and s3 must be set.
+	Dir string `yaml:"dir,omitempty"`
+	// S3 stores the share in a bucket of S3 or any compatible object store.
+	S3 *WebDAVS3Config `yaml:"s3,omitempty"`
+}
+
+func (w WebDAVControllerConfig) Validate() error {
+	if !strings.HasPrefix(w.Path, "/") {
+		return errors.New("path must be set and start with /")
+	}
+	if (w.Dir == "") == (w.S3 == nil) {
+		return errors.New("exactly one of dir or s3 must be set")
+	}
+	if w.S3 != nil {
+		return errors.Wrap(w.S3.Validate(), "invalid s3 configuration")
+	}
+	if stat, err := os.Stat(w.Dir); err != nil || !stat.IsDir() {
+		return errors.Wrap(err, "webdav directory not present or is not a directory")
+	}
+	return nil
+}
+
+func NewWebDAVController(c *WebDAVControllerConfig, _ server.ControllerContext) (server.IController, error) {
+	// Deep copy the config to enforce immutability
+	configCopy := snapshot.MustCopy(c)
+
+	var fileSystem webdav.FileSystem
+	if configCopy.S3 != nil {
+		s3, err := newS3FileSystem(*configCopy.S3)
+		if err != nil {
+			return nil, err
+		}
+		fileSystem = s3
+	} else {
+		fileSystem = webdav.Dir(configCopy.Dir)
+	}
+
+	log.Info().
+		Str("path", configCopy.Path).
+		Str("dir", configCopy.Dir).
+		Bool("s3", configCopy.S3 != nil).
+		Bool("read_only", configCopy.ReadOnly).
+		Msg("WebDAV share configured")
+
+	prefix := strings.TrimSuffix(configCopy.Path, "/")
+	return &webDAV{
+		path:     prefix,
+		readOnly: configCopy.ReadOnly,
+		handler: &webdav.Handler{
+			Prefix:     prefix,
+			FileSystem: fileSystem,
+			LockSystem: webdav.NewMemLS(),
+			Logger: func(r *http.Request, err error) {
+				if err != nil {
+					log.Debug().Err(err).Str("method", r.Method).Str("path", r.URL.Path).Msg("WebDAV request failed")
+				}
+			},
+		},
+	}, nil
+}
+
+// webDAV is a controller serving a WebDAV share behind the login middleware.
+type webDAV struct {
+	server.IController
+	path     string
+	readOnly bool
+	handler  *webdav.Handler
+}
+
+func (w *webDAV) Bind(engine *gin.Engine, loginMiddleware gin.HandlerFunc) error {
+	return w.BindGroup(&engine.RouterGroup, loginMiddleware)
+}
+
+func (w *webDAV) BindGroup(group *gin.RouterGroup, loginMiddleware gin.HandlerFunc) error {
+	// The handler resolves file names relative to the full path, including the group base path
+	w.handler.Prefix = strings.TrimSuffix(path.Join(group.BasePath(), w.path), "/")
+	routes := []string{path.Join(w.path, "/*filepath")}
+	if w.path != "" {
+		routes = append(routes, w.path)
+	}
+	for _, route := range routes {
+		for _, method := range webDAVMethods {
+			group.Handle(method, route, loginMiddleware, w.serve)
+		}
+	}
+	return nil
+}
+
+func (w *webDAV) serve(c *gin.Context) {
+	if w.readOnly && !webDAVReadMethods[c.Request.Method] {
+		c.Header("Allow", "OPTIONS, GET, HEAD, PROPFIND")
+		c.AbortWithStatus(http.StatusMethodNotAllowed)
+		return
+	}
+	w.handler.ServeHTTP(c.Writer, c.Request)
+}
+
+func (w *webDAV) Close() error {
+	return nil
+}
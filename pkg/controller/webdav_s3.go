@@ -0,0 +1,539 @@
+package controller
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/pkg/errors"
+	"golang.org/x/net/webdav"
+)
+
+// WebDAVS3Config configures the bucket of an S3 backed WebDAV share. Directories are stored as
+// empty objects whose key ends with a slash, and also exist implicitly when objects are stored
+// under them.
+type WebDAVS3Config struct {
+	Bucket string `yaml:"bucket"`
+	// Prefix is prepended to the keys of the share, e.g. shares/team-a.
+	Prefix string `yaml:"prefix,omitempty"`
+	Region string `yaml:"region"`
+	// Endpoint overrides the AWS endpoint, e.g. for MinIO. Defaults to https://s3.<region>.amazonaws.com.
+	Endpoint string `yaml:"endpoint,omitempty"`
+	// PathStyle addresses the bucket in the path instead of the host name, as most compatible
+	// object stores require.
+	PathStyle bool `yaml:"path_style,omitempty"`
+	// AccessKeyID and SecretAccessKey are optional, the default AWS credential chain is used otherwise.
+	AccessKeyID     string `yaml:"access_key_id,omitempty"`
+	SecretAccessKey string `yaml:"secret_access_key,omitempty"`
+}
+
+func (c WebDAVS3Config) Validate() error {
+	if c.Bucket == "" || c.Region == "" {
+		return errors.New("bucket and region are required")
+	}
+	if c.Endpoint != "" {
+		if _, err := url.ParseRequestURI(c.Endpoint); err != nil {
+			return errors.Wrap(err, "invalid endpoint")
+		}
+	}
+	if (c.AccessKeyID == "") != (c.SecretAccessKey == "") {
+		return errors.New("access_key_id and secret_access_key must be set together")
+	}
+	return nil
+}
+
+// s3FileSystem implements webdav.FileSystem on top of the S3 REST API.
+type s3FileSystem struct {
+	bucket      string
+	prefix      string
+	endpoint    *url.URL
+	pathStyle   bool
+	region      string
+	credentials aws.CredentialsProvider
+	signer      *v4.Signer
+	client      *http.Client
+}
+
+func newS3FileSystem(c WebDAVS3Config) (*s3FileSystem, error) {
+	endpoint := c.Endpoint
+	if endpoint == "" {
+		endpoint = "https://s3." + c.Region + ".amazonaws.com"
+	}
+	parsed, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid s3 endpoint")
+	}
+
+	var provider aws.CredentialsProvider
+	if c.AccessKeyID != "" {
+		provider = credentials.NewStaticCredentialsProvider(c.AccessKeyID, c.SecretAccessKey, "")
+	} else {
+		cfg, err := awsconfig.LoadDefaultConfig(context.Background(), awsconfig.WithRegion(c.Region))
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to load AWS configuration")
+		}
+		provider = cfg.Credentials
+	}
+
+	prefix := strings.Trim(c.Prefix, "/")
+	if prefix != "" {
+		prefix += "/"
+	}
+	return &s3FileSystem{
+		bucket:      c.Bucket,
+		prefix:      prefix,
+		endpoint:    parsed,
+		pathStyle:   c.PathStyle,
+		region:      c.Region,
+		credentials: provider,
+		signer: v4.NewSigner(func(options *v4.SignerOptions) {
+			// S3 expects object keys to be escaped only once
+			options.DisableURIPathEscaping = true
+		}),
+		client: &http.Client{Timeout: 5 * time.Minute},
+	}, nil
+}
+
+// key maps a WebDAV name to its object key, without a trailing slash.
+func (s *s3FileSystem) key(name string) string {
+	return s.prefix + strings.TrimPrefix(path.Clean("/"+name), "/")
+}
+
+// children returns the key prefix of the objects under a directory.
+func (s *s3FileSystem) children(name string) string {
+	if isS3Root(name) {
+		return s.prefix
+	}
+	return s.key(name) + "/"
+}
+
+func isS3Root(name string) bool {
+	return path.Clean("/"+name) == "/"
+}
+
+// request sends a signed request for the key, or for the bucket if the key is empty.
+func (s *s3FileSystem) request(ctx context.Context, method, key string, query url.Values, header http.Header, body io.Reader, length int64) (*http.Response, error) {
+	target := *s.endpoint
+	escapedKey := s3Escape(key)
+	if s.pathStyle {
+		target.Path = path.Join("/", target.Path, s.bucket) + "/" + key
+		target.RawPath = strings.TrimSuffix(s3Escape(path.Join("/", s.endpoint.Path, s.bucket)), "/") + "/" + escapedKey
+	} else {
+		target.Host = s.bucket + "." + target.Host
+		target.Path = "/" + key
+		target.RawPath = "/" + escapedKey
+	}
+	target.RawQuery = strings.ReplaceAll(query.Encode(), "+", "%20")
+
+	request, err := http.NewRequestWithContext(ctx, method, target.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range header {
+		request.Header[k] = v
+	}
+	if body != nil {
+		request.ContentLength = length
+	}
+	request.Header.Set("X-Amz-Content-Sha256", "UNSIGNED-PAYLOAD")
+
+	creds, err := s.credentials.Retrieve(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to retrieve AWS credentials")
+	}
+	if err := s.signer.SignHTTP(ctx, creds, request, "UNSIGNED-PAYLOAD", "s3", s.region, time.Now()); err != nil {
+		return nil, errors.Wrap(err, "failed to sign s3 request")
+	}
+	response, err := s.client.Do(request)
+	if err != nil {
+		return nil, errors.Wrap(err, "s3 request failed")
+	}
+	return response, nil
+}
+
+// do sends a request whose response body is not needed, failing on unexpected statuses.
+func (s *s3FileSystem) do(ctx context.Context, method, key string, header http.Header, body io.Reader, length int64) error {
+	response, err := s.request(ctx, method, key, nil, header, body, length)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = response.Body.Close() }()
+	return s3Error(response, method, key)
+}
+
+func s3Error(response *http.Response, method, key string) error {
+	switch {
+	case response.StatusCode < 300:
+		return nil
+	case response.StatusCode == http.StatusNotFound:
+		return os.ErrNotExist
+	case response.StatusCode == http.StatusForbidden:
+		return os.ErrPermission
+	default:
+		message, _ := io.ReadAll(io.LimitReader(response.Body, 1024))
+		return errors.Errorf("s3 %s %s failed with status %d: %s", method, key, response.StatusCode, strings.TrimSpace(string(message)))
+	}
+}
+
+type s3ListResult struct {
+	Contents []struct {
+		Key          string    `xml:"Key"`
+		LastModified time.Time `xml:"LastModified"`
+		Size         int64     `xml:"Size"`
+	} `xml:"Contents"`
+	CommonPrefixes []struct {
+		Prefix string `xml:"Prefix"`
+	} `xml:"CommonPrefixes"`
+	IsTruncated           bool   `xml:"IsTruncated"`
+	NextContinuationToken string `xml:"NextContinuationToken"`
+}
+
+// list calls visit for every page of objects under the prefix, only the direct children if
+// delimited. Listing stops when visit returns false.
+func (s *s3FileSystem) list(ctx context.Context, prefix string, delimited bool, maxKeys int, visit func(*s3ListResult) bool) error {
+	query := url.Values{"list-type": {"2"}, "prefix": {prefix}}
+	if delimited {
+		query.Set("delimiter", "/")
+	}
+	if maxKeys > 0 {
+		query.Set("max-keys", strconv.Itoa(maxKeys))
+	}
+	for {
+		response, err := s.request(ctx, http.MethodGet, "", query, nil, nil, 0)
+		if err != nil {
+			return err
+		}
+		var result s3ListResult
+		if err = s3Error(response, "LIST", prefix); err == nil {
+			err = errors.Wrap(xml.NewDecoder(response.Body).Decode(&result), "invalid s3 list response")
+		}
+		_ = response.Body.Close()
+		if err != nil {
+			return err
+		}
+		if !visit(&result) || !result.IsTruncated {
+			return nil
+		}
+		query.Set("continuation-token", result.NextContinuationToken)
+	}
+}
+
+func (s *s3FileSystem) Stat(ctx context.Context, name string) (os.FileInfo, error) {
+	if isS3Root(name) {
+		return &s3FileInfo{name: "/", dir: true}, nil
+	}
+	key := s.key(name)
+	base := path.Base(path.Clean("/" + name))
+
+	response, err := s.request(ctx, http.MethodHead, key, nil, nil, nil, 0)
+	if err != nil {
+		return nil, err
+	}
+	_ = response.Body.Close()
+	if err := s3Error(response, "HEAD", key); err == nil {
+		modified, _ := http.ParseTime(response.Header.Get("Last-Modified"))
+		return &s3FileInfo{name: base, size: response.ContentLength, modified: modified}, nil
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	found := false
+	err = s.list(ctx, s.children(name), false, 1, func(result *s3ListResult) bool {
+		found = len(result.Contents) > 0
+		return false
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, os.ErrNotExist
+	}
+	return &s3FileInfo{name: base, dir: true}, nil
+}
+
+func (s *s3FileSystem) Mkdir(ctx context.Context, name string, _ os.FileMode) error {
+	if _, err := s.Stat(ctx, name); err == nil {
+		return os.ErrExist
+	}
+	if _, err := s.Stat(ctx, path.Dir(path.Clean("/"+name))); err != nil {
+		return err
+	}
+	return s.do(ctx, http.MethodPut, s.children(name), nil, strings.NewReader(""), 0)
+}
+
+func (s *s3FileSystem) OpenFile(ctx context.Context, name string, flag int, _ os.FileMode) (webdav.File, error) {
+	if flag&(os.O_WRONLY|os.O_RDWR|os.O_CREATE|os.O_TRUNC) != 0 {
+		info, err := s.Stat(ctx, name)
+		switch {
+		case err == nil && info.IsDir():
+			return nil, os.ErrPermission
+		case err == nil && flag&os.O_EXCL != 0:
+			return nil, os.ErrExist
+		case errors.Is(err, os.ErrNotExist) && flag&os.O_CREATE == 0:
+			return nil, err
+		case err != nil && !errors.Is(err, os.ErrNotExist):
+			return nil, err
+		}
+		if _, err := s.Stat(ctx, path.Dir(path.Clean("/"+name))); err != nil {
+			return nil, err
+		}
+		buffer, err := os.CreateTemp("", "sargantana-webdav-*")
+		if err != nil {
+			return nil, err
+		}
+		return &s3WriteFile{File: buffer, fs: s, ctx: ctx, key: s.key(name), name: path.Base(name)}, nil
+	}
+
+	info, err := s.Stat(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	return &s3ReadFile{fs: s, ctx: ctx, name: name, key: s.key(name), info: info.(*s3FileInfo)}, nil
+}
+
+func (s *s3FileSystem) RemoveAll(ctx context.Context, name string) error {
+	if isS3Root(name) {
+		return os.ErrPermission
+	}
+	key := s.key(name)
+	var keys []string
+	err := s.list(ctx, s.children(name), false, 0, func(result *s3ListResult) bool {
+		for _, object := range result.Contents {
+			keys = append(keys, object.Key)
+		}
+		return true
+	})
+	if err != nil {
+		return err
+	}
+	if err := s.do(ctx, http.MethodDelete, key, nil, nil, 0); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	for _, object := range keys {
+		if err := s.do(ctx, http.MethodDelete, object, nil, nil, 0); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	return nil
+}
+
+// Rename copies the objects to their new keys and deletes the old ones. S3 has no atomic rename.
+func (s *s3FileSystem) Rename(ctx context.Context, oldName, newName string) error {
+	info, err := s.Stat(ctx, oldName)
+	if err != nil {
+		return err
+	}
+	oldKey, newKey := s.key(oldName), s.key(newName)
+	if !info.IsDir() {
+		if err := s.copyObject(ctx, oldKey, newKey); err != nil {
+			return err
+		}
+		return s.do(ctx, http.MethodDelete, oldKey, nil, nil, 0)
+	}
+
+	var keys []string
+	err = s.list(ctx, s.children(oldName), false, 0, func(result *s3ListResult) bool {
+		for _, object := range result.Contents {
+			keys = append(keys, object.Key)
+		}
+		return true
+	})
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		if err := s.copyObject(ctx, key, newKey+strings.TrimPrefix(key, oldKey)); err != nil {
+			return err
+		}
+	}
+	for _, key := range keys {
+		if err := s.do(ctx, http.MethodDelete, key, nil, nil, 0); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *s3FileSystem) copyObject(ctx context.Context, source, target string) error {
+	header := http.Header{"X-Amz-Copy-Source": {s3Escape(s.bucket + "/" + source)}}
+	return s.do(ctx, http.MethodPut, target, header, nil, 0)
+}
+
+// s3Escape escapes an object key for the request path, keeping the slashes.
+func s3Escape(key string) string {
+	var escaped strings.Builder
+	for _, b := range []byte(key) {
+		if 'A' <= b && b <= 'Z' || 'a' <= b && b <= 'z' || '0' <= b && b <= '9' || strings.IndexByte("-_.~/", b) >= 0 {
+			escaped.WriteByte(b)
+		} else {
+			fmt.Fprintf(&escaped, "%%%02X", b)
+		}
+	}
+	return escaped.String()
+}
+
+type s3FileInfo struct {
+	name     string
+	size     int64
+	modified time.Time
+	dir      bool
+}
+
+func (i *s3FileInfo) Name() string       { return i.name }
+func (i *s3FileInfo) Size() int64        { return i.size }
+func (i *s3FileInfo) ModTime() time.Time { return i.modified }
+func (i *s3FileInfo) IsDir() bool        { return i.dir }
+func (i *s3FileInfo) Sys() any           { return nil }
+
+func (i *s3FileInfo) Mode() os.FileMode {
+	if i.dir {
+		return fs.ModeDir | 0o755
+	}
+	return 0o644
+}
+
+// s3ReadFile reads an object with range requests starting at the current offset, or lists a
+// directory.
+type s3ReadFile struct {
+	fs     *s3FileSystem
+	ctx    context.Context
+	name   string
+	key    string
+	info   *s3FileInfo
+	offset int64
+	body   io.ReadCloser
+}
+
+func (f *s3ReadFile) Read(p []byte) (int, error) {
+	if f.info.dir {
+		return 0, errors.New("is a directory")
+	}
+	if f.offset >= f.info.size {
+		return 0, io.EOF
+	}
+	if f.body == nil {
+		header := http.Header{"Range": {fmt.Sprintf("bytes=%d-", f.offset)}}
+		response, err := f.fs.request(f.ctx, http.MethodGet, f.key, nil, header, nil, 0)
+		if err != nil {
+			return 0, err
+		}
+		if err := s3Error(response, "GET", f.key); err != nil {
+			_ = response.Body.Close()
+			return 0, err
+		}
+		f.body = response.Body
+	}
+	n, err := f.body.Read(p)
+	f.offset += int64(n)
+	return n, err
+}
+
+func (f *s3ReadFile) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += f.offset
+	case io.SeekEnd:
+		offset += f.info.size
+	}
+	if offset < 0 {
+		return 0, errors.New("negative offset")
+	}
+	if offset != f.offset && f.body != nil {
+		_ = f.body.Close()
+		f.body = nil
+	}
+	f.offset = offset
+	return offset, nil
+}
+
+func (f *s3ReadFile) Readdir(count int) ([]fs.FileInfo, error) {
+	if !f.info.dir {
+		return nil, errors.New("not a directory")
+	}
+	prefix := f.fs.children(f.name)
+	var infos []fs.FileInfo
+	err := f.fs.list(f.ctx, prefix, true, 0, func(result *s3ListResult) bool {
+		for _, common := range result.CommonPrefixes {
+			infos = append(infos, &s3FileInfo{name: path.Base(common.Prefix), dir: true})
+		}
+		for _, object := range result.Contents {
+			if object.Key == prefix {
+				continue // Directory marker
+			}
+			infos = append(infos, &s3FileInfo{name: path.Base(object.Key), size: object.Size, modified: object.LastModified})
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	if count > 0 && len(infos) > count {
+		infos = infos[:count]
+	}
+	return infos, nil
+}
+
+func (f *s3ReadFile) Stat() (fs.FileInfo, error) {
+	return f.info, nil
+}
+
+func (f *s3ReadFile) Write([]byte) (int, error) {
+	return 0, os.ErrPermission
+}
+
+func (f *s3ReadFile) Close() error {
+	if f.body != nil {
+		return f.body.Close()
+	}
+	return nil
+}
+
+// s3WriteFile buffers the written content in a temporary file and uploads it on Close.
+type s3WriteFile struct {
+	*os.File
+	fs   *s3FileSystem
+	ctx  context.Context
+	key  string
+	name string
+}
+
+func (f *s3WriteFile) Readdir(int) ([]fs.FileInfo, error) {
+	return nil, errors.New("not a directory")
+}
+
+func (f *s3WriteFile) Stat() (fs.FileInfo, error) {
+	stat, err := f.File.Stat()
+	if err != nil {
+		return nil, err
+	}
+	return &s3FileInfo{name: f.name, size: stat.Size(), modified: stat.ModTime()}, nil
+}
+
+func (f *s3WriteFile) Close() error {
+	defer func() {
+		_ = f.File.Close()
+		_ = os.Remove(f.File.Name())
+	}()
+	size, err := f.File.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+	if _, err := f.File.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	// Hide the file methods from the transport, which would otherwise close it
+	return f.fs.do(f.ctx, http.MethodPut, f.key, nil, io.NopCloser(f.File), size)
+}
@@ -0,0 +1,266 @@
+//go:build unit
+
+package controller
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/animalet/sargantana-go/pkg/server"
+	"github.com/gin-gonic/gin"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"gopkg.in/yaml.v3"
+)
+
+// fakeS3 is an in-memory S3 server supporting the subset of the API used by the WebDAV share.
+type fakeS3 struct {
+	mu      sync.Mutex
+	objects map[string][]byte
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=AKID/") {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+	key, ok := strings.CutPrefix(r.URL.Path, "/bucket/")
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	switch {
+	case r.Method == http.MethodGet && r.URL.Query().Get("list-type") == "2":
+		f.list(w, r.URL.Query())
+	case r.Method == http.MethodGet || r.Method == http.MethodHead:
+		data, ok := f.objects[key]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Last-Modified", time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC).Format(http.TimeFormat))
+		if start, ok := strings.CutPrefix(r.Header.Get("Range"), "bytes="); ok {
+			offset, _ := strconv.Atoi(strings.TrimSuffix(start, "-"))
+			data = data[offset:]
+		}
+		w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+		_, _ = w.Write(data)
+	case r.Method == http.MethodPut:
+		if source := r.Header.Get("X-Amz-Copy-Source"); source != "" {
+			unescaped, _ := url.PathUnescape(source)
+			f.objects[key] = f.objects[strings.TrimPrefix(unescaped, "bucket/")]
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		f.objects[key] = body
+	case r.Method == http.MethodDelete:
+		delete(f.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func (f *fakeS3) list(w http.ResponseWriter, query url.Values) {
+	prefix, delimiter := query.Get("prefix"), query.Get("delimiter")
+	maxKeys, _ := strconv.Atoi(query.Get("max-keys"))
+	if maxKeys == 0 {
+		maxKeys = 2 // Small pages to exercise pagination
+	}
+	start, _ := strconv.Atoi(query.Get("continuation-token"))
+
+	var keys []string
+	seen := map[string]bool{}
+	for key := range f.objects {
+		if !strings.HasPrefix(key, prefix) {
+			continue
+		}
+		if rest := key[len(prefix):]; delimiter != "" && strings.Contains(rest, delimiter) {
+			key = prefix + rest[:strings.Index(rest, delimiter)+1]
+		}
+		if !seen[key] {
+			seen[key] = true
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	var result s3ListResult
+	end := min(start+maxKeys, len(keys))
+	for _, key := range keys[start:end] {
+		if _, ok := f.objects[key]; ok {
+			result.Contents = append(result.Contents, struct {
+				Key          string    `xml:"Key"`
+				LastModified time.Time `xml:"LastModified"`
+				Size         int64     `xml:"Size"`
+			}{key, time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC), int64(len(f.objects[key]))})
+		} else {
+			result.CommonPrefixes = append(result.CommonPrefixes, struct {
+				Prefix string `xml:"Prefix"`
+			}{key})
+		}
+	}
+	if end < len(keys) {
+		result.IsTruncated, result.NextContinuationToken = true, strconv.Itoa(end)
+	}
+	_ = xml.NewEncoder(w).Encode(struct {
+		XMLName xml.Name `xml:"ListBucketResult"`
+		s3ListResult
+	}{s3ListResult: result})
+}
+
+var _ = Describe("WebDAV Controller", func() {
+	Context("WebDAVControllerConfig Validate", func() {
+		It("should validate the configuration", func() {
+			dir := GinkgoT().TempDir()
+			Expect(WebDAVControllerConfig{Path: "/dav", Dir: dir}.Validate()).To(Succeed())
+			Expect(WebDAVControllerConfig{Path: "dav", Dir: dir}.Validate()).To(MatchError(ContainSubstring("start with /")))
+			Expect(WebDAVControllerConfig{Path: "/dav"}.Validate()).To(MatchError(ContainSubstring("exactly one of dir or s3")))
+			Expect(WebDAVControllerConfig{Path: "/dav", Dir: dir, S3: &WebDAVS3Config{}}.Validate()).To(HaveOccurred())
+			Expect(WebDAVControllerConfig{Path: "/dav", Dir: filepath.Join(dir, "missing")}.Validate()).To(MatchError(ContainSubstring("not present")))
+			Expect(WebDAVControllerConfig{Path: "/dav", S3: &WebDAVS3Config{Bucket: "b"}}.Validate()).To(MatchError(ContainSubstring("bucket and region are required")))
+			Expect(WebDAVS3Config{Bucket: "b", Region: "r", AccessKeyID: "a"}.Validate()).To(MatchError(ContainSubstring("must be set together")))
+			Expect(WebDAVS3Config{Bucket: "b", Region: "r", Endpoint: "not a url"}.Validate()).To(HaveOccurred())
+		})
+
+		It("should escape object keys once", func() {
+			Expect(s3Escape("docs/a b+c/ñ.txt")).To(Equal("docs/a%20b%2Bc/%C3%B1.txt"))
+		})
+	})
+
+	var engine *gin.Engine
+
+	bind := func(yamlConfig string) {
+		var cfg WebDAVControllerConfig
+		Expect(yaml.Unmarshal([]byte(yamlConfig), &cfg)).To(Succeed())
+		Expect(cfg.Validate()).To(Succeed())
+		ctrl, err := NewWebDAVController(&cfg, server.ControllerContext{})
+		Expect(err).NotTo(HaveOccurred())
+		DeferCleanup(ctrl.Close)
+
+		gin.SetMode(gin.TestMode)
+		engine = gin.New()
+		login := func(c *gin.Context) {
+			if c.GetHeader("X-Test-User") == "" {
+				c.AbortWithStatus(http.StatusUnauthorized)
+			}
+		}
+		Expect(ctrl.Bind(engine, login)).To(Succeed())
+	}
+
+	serve := func(method, path string, header http.Header, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("X-Test-User", "alice")
+		for k, v := range header {
+			req.Header[k] = v
+		}
+		engine.ServeHTTP(w, req)
+		return w
+	}
+
+	// exercise runs a session of a WebDAV client against the share
+	exercise := func() {
+		Expect(serve("MKCOL", "/dav/docs", nil, "").Code).To(Equal(http.StatusCreated))
+		Expect(serve("MKCOL", "/dav/missing/docs", nil, "").Code).To(Equal(http.StatusConflict))
+		Expect(serve(http.MethodPut, "/dav/docs/a%20b.txt", nil, "hello world").Code).To(Equal(http.StatusCreated))
+		Expect(serve(http.MethodPut, "/dav/docs/second.txt", nil, "2").Code).To(Equal(http.StatusCreated))
+		Expect(serve(http.MethodPut, "/dav/docs/third.txt", nil, "3").Code).To(Equal(http.StatusCreated))
+
+		w := serve("PROPFIND", "/dav/docs/", http.Header{"Depth": {"1"}}, "")
+		Expect(w.Code).To(Equal(http.StatusMultiStatus))
+		Expect(w.Body.String()).To(ContainSubstring("/dav/docs/a%20b.txt"))
+		Expect(w.Body.String()).To(ContainSubstring("/dav/docs/third.txt"))
+		Expect(w.Body.String()).To(ContainSubstring("<D:getcontentlength>11</D:getcontentlength>"))
+
+		w = serve(http.MethodGet, "/dav/docs/a%20b.txt", http.Header{"Range": {"bytes=6-"}}, "")
+		Expect(w.Code).To(Equal(http.StatusPartialContent))
+		Expect(w.Body.String()).To(Equal("world"))
+
+		Expect(serve("MOVE", "/dav/docs/a%20b.txt", http.Header{"Destination": {"/dav/docs/moved.txt"}}, "").Code).To(Equal(http.StatusCreated))
+		Expect(serve(http.MethodGet, "/dav/docs/a%20b.txt", nil, "").Code).To(Equal(http.StatusNotFound))
+		Expect(serve("COPY", "/dav/docs", http.Header{"Destination": {"/dav/backup"}}, "").Code).To(Equal(http.StatusCreated))
+		Expect(serve(http.MethodGet, "/dav/backup/moved.txt", nil, "").Body.String()).To(Equal("hello world"))
+		Expect(serve("MOVE", "/dav/backup", http.Header{"Destination": {"/dav/archive"}}, "").Code).To(Equal(http.StatusCreated))
+		Expect(serve(http.MethodGet, "/dav/archive/second.txt", nil, "").Body.String()).To(Equal("2"))
+
+		Expect(serve(http.MethodDelete, "/dav/docs", nil, "").Code).To(Equal(http.StatusNoContent))
+		Expect(serve("PROPFIND", "/dav/docs/", http.Header{"Depth": {"0"}}, "").Code).To(Equal(http.StatusNotFound))
+		Expect(serve(http.MethodGet, "/dav/archive/moved.txt", nil, "").Body.String()).To(Equal("hello world"))
+	}
+
+	It("should serve a directory share", func() {
+		dir := GinkgoT().TempDir()
+		bind("path: /dav\ndir: " + dir)
+		exercise()
+		content, err := os.ReadFile(filepath.Join(dir, "archive", "moved.txt"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(content)).To(Equal("hello world"))
+	})
+
+	It("should serve an S3 share", func() {
+		store := &fakeS3{objects: map[string][]byte{}}
+		s3Server := httptest.NewServer(store)
+		DeferCleanup(s3Server.Close)
+		bind(fmt.Sprintf("path: /dav\ns3:\n  bucket: bucket\n  prefix: /team/\n  region: eu-west-1\n  endpoint: %s\n  path_style: true\n"+
+			"  access_key_id: AKID\n  secret_access_key: secret\n", s3Server.URL))
+		exercise()
+
+		store.mu.Lock()
+		defer store.mu.Unlock()
+		Expect(store.objects).To(HaveKeyWithValue("team/archive/moved.txt", []byte("hello world")))
+		Expect(store.objects).NotTo(HaveKey("team/docs/"))
+	})
+
+	It("should reject modifications of read-only shares", func() {
+		dir := GinkgoT().TempDir()
+		Expect(os.WriteFile(filepath.Join(dir, "file.txt"), []byte("content"), 0o644)).To(Succeed())
+		bind("path: /dav\nread_only: true\ndir: " + dir)
+
+		Expect(serve(http.MethodGet, "/dav/file.txt", nil, "").Body.String()).To(Equal("content"))
+		Expect(serve("PROPFIND", "/dav", http.Header{"Depth": {"1"}}, "").Code).To(Equal(http.StatusMultiStatus))
+		for _, method := range []string{http.MethodPut, http.MethodDelete, "MKCOL", "MOVE", "COPY", "PROPPATCH", "LOCK"} {
+			w := serve(method, "/dav/file.txt", nil, "")
+			Expect(w.Code).To(Equal(http.StatusMethodNotAllowed), method)
+			Expect(w.Header().Get("Allow")).To(Equal("OPTIONS, GET, HEAD, PROPFIND"))
+		}
+		Expect(serve(http.MethodGet, "/dav/file.txt", nil, "").Body.String()).To(Equal("content"))
+	})
+
+	It("should require authentication", func() {
+		bind("path: /dav\ndir: " + GinkgoT().TempDir())
+		w := httptest.NewRecorder()
+		engine.ServeHTTP(w, httptest.NewRequest("PROPFIND", "/dav/", nil))
+		Expect(w.Code).To(Equal(http.StatusUnauthorized))
+	})
+
+	It("should resolve file names below the base path of a router group", func() {
+		dir := GinkgoT().TempDir()
+		Expect(os.WriteFile(filepath.Join(dir, "file.txt"), []byte("content"), 0o644)).To(Succeed())
+		var cfg WebDAVControllerConfig
+		Expect(yaml.Unmarshal([]byte("path: /dav\ndir: "+dir), &cfg)).To(Succeed())
+		ctrl, err := NewWebDAVController(&cfg, server.ControllerContext{})
+		Expect(err).NotTo(HaveOccurred())
+
+		engine = gin.New()
+		Expect(ctrl.(server.GroupController).BindGroup(engine.Group("/team"), func(c *gin.Context) {})).To(Succeed())
+
+		Expect(serve(http.MethodGet, "/team/dav/file.txt", nil, "").Body.String()).To(Equal("content"))
+		w := serve("PROPFIND", "/team/dav/", http.Header{"Depth": {"1"}}, "")
+		Expect(w.Code).To(Equal(http.StatusMultiStatus))
+		Expect(w.Body.String()).To(ContainSubstring("/team/dav/file.txt"))
+		Expect(serve(http.MethodGet, "/dav/file.txt", nil, "").Code).To(Equal(http.StatusNotFound))
+	})
+})
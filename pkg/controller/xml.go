@@ -0,0 +1,402 @@
+package controller
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/htmlindex"
+	"golang.org/x/text/encoding/unicode"
+)
+
+// XMLNode is an element of an XML document. Names keep the namespace prefixes as written in the
+// document, e.g. {Space: "soap", Local: "Body"}, so documents are re-encoded as received. Character
+// data of mixed content is concatenated in Text.
+type XMLNode struct {
+	Name     xml.Name
+	Attrs    []xml.Attr
+	Children []*XMLNode
+	Text     string
+}
+
+// Child returns the first child element with the given local name, or nil.
+func (n *XMLNode) Child(local string) *XMLNode {
+	for _, child := range n.Children {
+		if child.Name.Local == local {
+			return child
+		}
+	}
+	return nil
+}
+
+// Attr returns the value of the attribute with the given local name, or an empty string.
+func (n *XMLNode) Attr(local string) string {
+	for _, attr := range n.Attrs {
+		if attr.Name.Local == local {
+			return attr.Value
+		}
+	}
+	return ""
+}
+
+// ParseXML parses an XML document into its root element. The charset is taken from the charset
+// argument, usually the charset parameter of the Content-Type header, or else from the XML
+// declaration. The returned document is always UTF-8.
+func ParseXML(r io.Reader, charset string) (*XMLNode, error) {
+	if charset != "" {
+		enc, err := lookupCharset(charset)
+		if err != nil {
+			return nil, err
+		}
+		r = enc.NewDecoder().Reader(r)
+	}
+	decoder := xml.NewDecoder(r)
+	decoder.CharsetReader = func(label string, input io.Reader) (io.Reader, error) {
+		if charset != "" {
+			// Already decoded according to the Content-Type
+			return input, nil
+		}
+		enc, err := lookupCharset(label)
+		if err != nil {
+			return nil, err
+		}
+		return enc.NewDecoder().Reader(input), nil
+	}
+
+	var root *XMLNode
+	var stack []*XMLNode
+	for {
+		token, err := decoder.RawToken()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, errors.Wrap(err, "invalid XML document")
+		}
+		switch t := token.(type) {
+		case xml.StartElement:
+			node := &XMLNode{Name: t.Name, Attrs: append([]xml.Attr(nil), t.Attr...)}
+			if len(stack) > 0 {
+				parent := stack[len(stack)-1]
+				parent.Children = append(parent.Children, node)
+			} else if root != nil {
+				return nil, errors.New("invalid XML document: multiple root elements")
+			} else {
+				root = node
+			}
+			stack = append(stack, node)
+		case xml.EndElement:
+			if len(stack) == 0 || stack[len(stack)-1].Name != t.Name {
+				return nil, errors.Errorf("invalid XML document: unexpected closing element %s", qualifiedName(t.Name))
+			}
+			stack = stack[:len(stack)-1]
+		case xml.CharData:
+			if len(stack) > 0 {
+				stack[len(stack)-1].Text += string(t)
+			}
+		}
+	}
+	if root == nil || len(stack) > 0 {
+		return nil, errors.New("invalid XML document: missing or unterminated root element")
+	}
+	return root, nil
+}
+
+// EncodeXML encodes the document with an XML declaration in the given charset, UTF-8 if empty.
+// Characters that cannot be represented in an ASCII-compatible charset are written as character
+// references.
+func EncodeXML(root *XMLNode, charset string) ([]byte, error) {
+	name := "UTF-8"
+	ascii := false
+	if charset != "" {
+		enc, err := lookupCharset(charset)
+		if err != nil {
+			return nil, err
+		}
+		if canonical, _ := htmlindex.Name(enc); canonical != "utf-8" {
+			name, ascii = charset, true
+		}
+	}
+
+	var buffer bytes.Buffer
+	buffer.WriteString(`<?xml version="1.0" encoding="` + name + `"?>`)
+	writeXMLNode(&buffer, root, ascii)
+	return buffer.Bytes(), nil
+}
+
+func writeXMLNode(buffer *bytes.Buffer, node *XMLNode, ascii bool) {
+	buffer.WriteByte('<')
+	buffer.WriteString(qualifiedName(node.Name))
+	for _, attr := range node.Attrs {
+		buffer.WriteByte(' ')
+		buffer.WriteString(qualifiedName(attr.Name))
+		buffer.WriteString(`="`)
+		writeXMLText(buffer, attr.Value, ascii)
+		buffer.WriteByte('"')
+	}
+	if node.Text == "" && len(node.Children) == 0 {
+		buffer.WriteString("/>")
+		return
+	}
+	buffer.WriteByte('>')
+	writeXMLText(buffer, node.Text, ascii)
+	for _, child := range node.Children {
+		writeXMLNode(buffer, child, ascii)
+	}
+	buffer.WriteString("</")
+	buffer.WriteString(qualifiedName(node.Name))
+	buffer.WriteByte('>')
+}
+
+func writeXMLText(buffer *bytes.Buffer, text string, ascii bool) {
+	var escaped bytes.Buffer
+	_ = xml.EscapeText(&escaped, []byte(text))
+	if !ascii {
+		buffer.Write(escaped.Bytes())
+		return
+	}
+	for _, r := range escaped.String() {
+		if r < utf8.RuneSelf {
+			buffer.WriteRune(r)
+		} else {
+			fmt.Fprintf(buffer, "&#x%X;", r)
+		}
+	}
+}
+
+func qualifiedName(name xml.Name) string {
+	if name.Space == "" {
+		return name.Local
+	}
+	return name.Space + ":" + name.Local
+}
+
+// lookupCharset finds an encoding by its IANA or WHATWG label. Only ASCII-compatible encodings
+// are supported.
+func lookupCharset(label string) (encoding.Encoding, error) {
+	enc, err := htmlindex.Get(label)
+	if err != nil {
+		return nil, errors.Errorf("unsupported charset %q", label)
+	}
+	if enc == unicode.UTF16(unicode.BigEndian, unicode.IgnoreBOM) || enc == unicode.UTF16(unicode.LittleEndian, unicode.IgnoreBOM) {
+		return nil, errors.Errorf("unsupported charset %q", label)
+	}
+	return enc, nil
+}
+
+// xmlToJSON converts an element to a JSON value: attributes become "@name" members, child elements
+// become members named after their local name (arrays if repeated) and the text of elements with
+// attributes or children becomes a "#text" member. Namespace declarations are dropped.
+func xmlToJSON(node *XMLNode) any {
+	text := strings.TrimSpace(node.Text)
+	object := make(map[string]any)
+	for _, attr := range node.Attrs {
+		if attr.Name.Space == "xmlns" || (attr.Name.Space == "" && attr.Name.Local == "xmlns") {
+			continue
+		}
+		object["@"+attr.Name.Local] = attr.Value
+	}
+	if len(object) == 0 && len(node.Children) == 0 {
+		return text
+	}
+	for _, child := range node.Children {
+		value := xmlToJSON(child)
+		switch existing := object[child.Name.Local].(type) {
+		case nil:
+			object[child.Name.Local] = value
+		case []any:
+			object[child.Name.Local] = append(existing, value)
+		default:
+			object[child.Name.Local] = []any{existing, value}
+		}
+	}
+	if text != "" {
+		object["#text"] = text
+	}
+	return object
+}
+
+// jsonMember is a member of a JSON object, kept in document order as XML schemas usually require
+// elements in a fixed sequence.
+type jsonMember struct {
+	key   string
+	value any
+}
+
+// decodeOrderedJSON decodes a JSON value keeping the order of object members, returned as
+// []jsonMember. Numbers are returned as json.Number.
+func decodeOrderedJSON(data []byte) (any, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	value, err := decodeOrderedValue(decoder)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := decoder.Token(); err != io.EOF {
+		return nil, errors.New("unexpected data after the JSON value")
+	}
+	return value, nil
+}
+
+func decodeOrderedValue(decoder *json.Decoder) (any, error) {
+	token, err := decoder.Token()
+	if err != nil {
+		return nil, err
+	}
+	switch token {
+	case json.Delim('{'):
+		var members []jsonMember
+		for decoder.More() {
+			key, err := decoder.Token()
+			if err != nil {
+				return nil, err
+			}
+			value, err := decodeOrderedValue(decoder)
+			if err != nil {
+				return nil, err
+			}
+			members = append(members, jsonMember{key: key.(string), value: value})
+		}
+		_, err = decoder.Token()
+		return members, err
+	case json.Delim('['):
+		var values []any
+		for decoder.More() {
+			value, err := decodeOrderedValue(decoder)
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, value)
+		}
+		_, err = decoder.Token()
+		return values, err
+	default:
+		return token, nil
+	}
+}
+
+// jsonToXML converts a JSON document with a single member to an XML element named after it,
+// following the conventions of xmlToJSON. Member names may include a namespace prefix.
+func jsonToXML(data []byte) (*XMLNode, error) {
+	document, err := decodeOrderedJSON(data)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid JSON document")
+	}
+	members, ok := document.([]jsonMember)
+	if !ok || len(members) != 1 {
+		return nil, errors.New("the JSON document must be an object with a single member naming the root element")
+	}
+	root := newXMLElement(members[0].key)
+	if err := fillXMLElement(root, members[0].value); err != nil {
+		return nil, err
+	}
+	return root, nil
+}
+
+func newXMLElement(name string) *XMLNode {
+	prefix, local, ok := strings.Cut(name, ":")
+	if !ok {
+		return &XMLNode{Name: xml.Name{Local: name}}
+	}
+	return &XMLNode{Name: xml.Name{Space: prefix, Local: local}}
+}
+
+func fillXMLElement(node *XMLNode, value any) error {
+	switch v := value.(type) {
+	case []jsonMember:
+		for _, member := range v {
+			switch {
+			case strings.HasPrefix(member.key, "@"):
+				attr := newXMLElement(member.key[1:]).Name
+				text, err := jsonScalar(member.value)
+				if err != nil {
+					return errors.Wrapf(err, "attribute %s", member.key)
+				}
+				node.Attrs = append(node.Attrs, xml.Attr{Name: attr, Value: text})
+			case member.key == "#text":
+				text, err := jsonScalar(member.value)
+				if err != nil {
+					return errors.Wrap(err, "#text")
+				}
+				node.Text = text
+			default:
+				items, repeated := member.value.([]any)
+				if !repeated {
+					items = []any{member.value}
+				}
+				for _, item := range items {
+					child := newXMLElement(member.key)
+					if err := fillXMLElement(child, item); err != nil {
+						return err
+					}
+					node.Children = append(node.Children, child)
+				}
+			}
+		}
+		return nil
+	case []any:
+		return errors.Errorf("nested arrays cannot be converted to element %s", qualifiedName(node.Name))
+	default:
+		text, err := jsonScalar(v)
+		node.Text = text
+		return err
+	}
+}
+
+func jsonScalar(value any) (string, error) {
+	switch v := value.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return v, nil
+	case json.Number:
+		return v.String(), nil
+	case bool:
+		if v {
+			return "true", nil
+		}
+		return "false", nil
+	default:
+		return "", errors.New("expected a scalar value")
+	}
+}
+
+// XMLHook transforms the XML documents exchanged with upstreams by controllers with XML support
+// enabled. Hooks are registered by name with RegisterXMLHook and referenced from the controller
+// configuration. Either function may be nil. Errors of request hooks reject the request with 400
+// Bad Request, while errors of response hooks fail it with 502 Bad Gateway.
+type XMLHook struct {
+	// Request transforms the XML request sent to the upstream, after any JSON conversion and SOAP
+	// wrapping.
+	Request func(c *gin.Context, document *XMLNode) error
+	// Response transforms the XML response of the upstream, before any JSON conversion.
+	Response func(c *gin.Context, document *XMLNode) error
+}
+
+var (
+	xmlHooksMu sync.RWMutex
+	xmlHooks   = make(map[string]XMLHook)
+)
+
+// RegisterXMLHook registers an XML hook under the given name. It must be called before the
+// controllers are created, e.g. next to server.RegisterController.
+func RegisterXMLHook(name string, hook XMLHook) {
+	xmlHooksMu.Lock()
+	defer xmlHooksMu.Unlock()
+	xmlHooks[name] = hook
+}
+
+func lookupXMLHook(name string) (XMLHook, bool) {
+	xmlHooksMu.RLock()
+	defer xmlHooksMu.RUnlock()
+	hook, ok := xmlHooks[name]
+	return hook, ok
+}
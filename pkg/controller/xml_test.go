@@ -0,0 +1,242 @@
+//go:build unit
+
+package controller
+
+import (
+	"encoding/xml"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	"github.com/animalet/sargantana-go/pkg/server"
+	"github.com/gin-gonic/gin"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+var _ = Describe("XML Support", func() {
+	Context("XMLConfig Validate", func() {
+		It("should validate the configuration", func() {
+			Expect(XMLConfig{}.Validate()).To(Succeed())
+			Expect(XMLConfig{Charset: "ISO-8859-1", JSONRequests: true, SOAP: &SOAPConfig{Version: "1.2"}}.Validate()).To(Succeed())
+			Expect(XMLConfig{Charset: "klingon"}.Validate()).To(MatchError(ContainSubstring("unsupported charset")))
+			Expect(XMLConfig{Charset: "utf-16le"}.Validate()).To(MatchError(ContainSubstring("unsupported charset")))
+			Expect(XMLConfig{JSONRequests: true, SOAP: &SOAPConfig{Version: "2.0"}}.Validate()).To(MatchError(ContainSubstring("unsupported soap version")))
+			Expect(XMLConfig{SOAP: &SOAPConfig{}}.Validate()).To(MatchError(ContainSubstring("requires json_requests or json_responses")))
+			Expect(XMLConfig{Hooks: []string{""}}.Validate()).To(HaveOccurred())
+			Expect(LoadBalancerControllerConfig{Path: "/api", Endpoints: []string{"http://localhost:8080"}, XML: &XMLConfig{Charset: "klingon"}}.Validate()).
+				To(MatchError(ContainSubstring("invalid xml configuration")))
+		})
+
+		It("should reject unknown hooks", func() {
+			_, err := NewLoadBalancerController(&LoadBalancerControllerConfig{
+				Path: "/api", Endpoints: []string{"http://localhost:8080"}, XML: &XMLConfig{Hooks: []string{"missing"}},
+			}, server.ControllerContext{})
+			Expect(err).To(MatchError(ContainSubstring(`unknown xml hook "missing"`)))
+		})
+	})
+
+	Context("Parsing and encoding", func() {
+		It("should round-trip documents keeping namespace prefixes", func() {
+			document, err := ParseXML(strings.NewReader(`<?xml version="1.0"?><s:Envelope xmlns:s="urn:s"><s:Body a="1 &amp; 2">text<m:Op xmlns:m="urn:m"/></s:Body></s:Envelope>`), "")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(document.Child("Body").Attr("a")).To(Equal("1 & 2"))
+			encoded, err := EncodeXML(document, "")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(encoded)).To(Equal(`<?xml version="1.0" encoding="UTF-8"?><s:Envelope xmlns:s="urn:s"><s:Body a="1 &amp; 2">text<m:Op xmlns:m="urn:m"/></s:Body></s:Envelope>`))
+		})
+
+		It("should decode the charset of the Content-Type or the XML declaration", func() {
+			latin1 := "<?xml version=\"1.0\" encoding=\"ISO-8859-1\"?><name>Jos\xe9</name>"
+			document, err := ParseXML(strings.NewReader(latin1), "")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(document.Text).To(Equal("José"))
+
+			document, err = ParseXML(strings.NewReader(latin1), "windows-1252")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(document.Text).To(Equal("José"))
+		})
+
+		It("should encode non-ASCII characters as references in other charsets", func() {
+			encoded, err := EncodeXML(&XMLNode{Name: xml.Name{Local: "name"}, Text: "José €"}, "ISO-8859-1")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(encoded)).To(Equal(`<?xml version="1.0" encoding="ISO-8859-1"?><name>Jos&#xE9; &#x20AC;</name>`))
+		})
+
+		It("should reject malformed documents", func() {
+			for _, document := range []string{"", "<a>", "<a></b>", "<a/><b/>", "not xml"} {
+				_, err := ParseXML(strings.NewReader(document), "")
+				Expect(err).To(HaveOccurred(), document)
+			}
+		})
+	})
+
+	Context("JSON conversion", func() {
+		It("should convert JSON to XML keeping the member order", func() {
+			document, err := jsonToXML([]byte(`{"m:GetPrice": {"@currency": "EUR", "z": 1, "a": [true, null], "item": {"#text": "x", "@id": 2}}}`))
+			Expect(err).NotTo(HaveOccurred())
+			encoded, err := EncodeXML(document, "")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(encoded)).To(HaveSuffix(`<m:GetPrice currency="EUR"><z>1</z><a>true</a><a/><item id="2">x</item></m:GetPrice>`))
+		})
+
+		It("should reject JSON without a single root member", func() {
+			for _, document := range []string{`[]`, `{}`, `{"a": 1, "b": 2}`, `{"a": [[1]]}`, `{"a": {"@b": {}}}`, `{"a": 1} x`} {
+				_, err := jsonToXML([]byte(document))
+				Expect(err).To(HaveOccurred(), document)
+			}
+		})
+
+		It("should convert XML to JSON", func() {
+			document, err := ParseXML(strings.NewReader(`<r xmlns="urn:x" id="1"><item>a</item><item>b</item><empty/><mixed k="v"> t </mixed></r>`), "")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(xmlToJSON(document)).To(Equal(map[string]any{
+				"@id":   "1",
+				"item":  []any{"a", "b"},
+				"empty": "",
+				"mixed": map[string]any{"@k": "v", "#text": "t"},
+			}))
+		})
+	})
+
+	Context("Content negotiation", func() {
+		It("should prefer JSON only when ranked above XML", func() {
+			Expect(prefersJSON("application/json")).To(BeTrue())
+			Expect(prefersJSON("application/xml;q=0.5, application/json")).To(BeTrue())
+			Expect(prefersJSON("application/json;q=0.5, text/xml")).To(BeFalse())
+			Expect(prefersJSON("*/*")).To(BeFalse())
+			Expect(prefersJSON("")).To(BeFalse())
+			Expect(prefersJSON("application/json;q=0")).To(BeFalse())
+		})
+	})
+
+	Context("Load balancer", func() {
+		var engine *gin.Engine
+		var received *http.Request
+		var receivedBody string
+
+		setup := func(xmlConfig string) {
+			backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				body, _ := io.ReadAll(r.Body)
+				received, receivedBody = r, string(body)
+				switch r.URL.Path {
+				case "/soap/price":
+					w.Header().Set("Content-Type", "text/xml; charset=ISO-8859-1")
+					_, _ = w.Write([]byte(`<soap:Envelope xmlns:soap="http://schemas.xmlsoap.org/soap/envelope/"><soap:Body>` +
+						"<GetPriceResponse xmlns=\"urn:shop\"><Name>Caf\xe9</Name><Price>1.5</Price></GetPriceResponse></soap:Body></soap:Envelope>"))
+				case "/soap/text":
+					w.Header().Set("Content-Type", "text/plain; charset=ISO-8859-1")
+					_, _ = w.Write([]byte("Caf\xe9"))
+				default:
+					w.Header().Set("Content-Type", "application/xml")
+					_, _ = w.Write([]byte(`<!-- kept --><ok/>`))
+				}
+			}))
+			DeferCleanup(backend.Close)
+
+			cfgBytes := []byte("path: /soap\nendpoints:\n  - " + backend.URL + "\ncache:\n  ttl: 1m\nxml:\n" + xmlConfig)
+			var lbCfg LoadBalancerControllerConfig
+			Expect(yaml.Unmarshal(cfgBytes, &lbCfg)).To(Succeed())
+			Expect(lbCfg.Validate()).To(Succeed())
+			ctrl, err := NewLoadBalancerController(&lbCfg, server.ControllerContext{})
+			Expect(err).NotTo(HaveOccurred())
+			DeferCleanup(ctrl.Close)
+
+			gin.SetMode(gin.TestMode)
+			engine = gin.New()
+			Expect(ctrl.Bind(engine, nil)).To(Succeed())
+		}
+
+		serve := func(method, path, contentType, accept, body string) *httptest.ResponseRecorder {
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest(method, path, strings.NewReader(body))
+			if contentType != "" {
+				req.Header.Set("Content-Type", contentType)
+			}
+			req.Header.Set("Accept", accept)
+			engine.ServeHTTP(w, req)
+			return w
+		}
+
+		It("should call SOAP 1.1 services with JSON", func() {
+			setup("  charset: ISO-8859-1\n  json_requests: true\n  json_responses: true\n  soap:\n    action: urn:shop#GetPrice\n    namespace: urn:shop\n")
+			w := serve(http.MethodPost, "/soap/price", "application/json", "application/json", `{"GetPrice": {"Name": "Café"}}`)
+			Expect(received.Header.Get("Content-Type")).To(Equal("text/xml; charset=ISO-8859-1"))
+			Expect(received.Header.Get("SOAPAction")).To(Equal(`"urn:shop#GetPrice"`))
+			Expect(receivedBody).To(Equal(`<?xml version="1.0" encoding="ISO-8859-1"?><soap:Envelope xmlns:soap="http://schemas.xmlsoap.org/soap/envelope/">` +
+				`<soap:Body><GetPrice xmlns="urn:shop"><Name>Caf&#xE9;</Name></GetPrice></soap:Body></soap:Envelope>`))
+
+			Expect(w.Code).To(Equal(http.StatusOK))
+			Expect(w.Header().Get("Content-Type")).To(Equal("application/json; charset=utf-8"))
+			Expect(w.Header().Get("Vary")).To(Equal("Accept"))
+			Expect(w.Body.String()).To(MatchJSON(`{"GetPriceResponse": {"Name": "Café", "Price": "1.5"}}`))
+		})
+
+		It("should use the SOAP 1.2 content type", func() {
+			setup("  json_requests: true\n  soap:\n    version: \"1.2\"\n    action: urn:shop#GetPrice\n")
+			serve(http.MethodPost, "/soap/price", "application/json", "", `{"GetPrice": {}}`)
+			Expect(received.Header.Get("Content-Type")).To(Equal(`application/soap+xml; action="urn:shop#GetPrice"; charset=utf-8`))
+			Expect(received.Header.Get("SOAPAction")).To(BeEmpty())
+			Expect(receivedBody).To(ContainSubstring(`xmlns:soap="http://www.w3.org/2003/05/soap-envelope"`))
+		})
+
+		It("should reject JSON requests that cannot be converted", func() {
+			setup("  json_requests: true\n")
+			w := serve(http.MethodPost, "/soap/price", "application/json", "", `{"a": 1, "b": 2}`)
+			Expect(w.Code).To(Equal(http.StatusBadRequest))
+		})
+
+		It("should negotiate the response format and cache both variants", func() {
+			setup("  json_responses: true\n  soap: {}\n")
+			for _, status := range []string{"MISS", "HIT"} {
+				w := serve(http.MethodGet, "/soap/price", "", "application/json", "")
+				Expect(w.Header().Get(CacheStatusHeader)).To(Equal(status))
+				Expect(w.Body.String()).To(MatchJSON(`{"GetPriceResponse": {"Name": "Café", "Price": "1.5"}}`))
+
+				w = serve(http.MethodGet, "/soap/price", "", "text/xml", "")
+				Expect(w.Header().Get(CacheStatusHeader)).To(Equal(status))
+				Expect(w.Header().Get("Content-Type")).To(Equal("text/xml; charset=utf-8"))
+				Expect(w.Body.String()).To(ContainSubstring(`<Name>Café</Name>`))
+			}
+		})
+
+		It("should normalize text responses to UTF-8 and pass UTF-8 XML through", func() {
+			setup("  json_responses: true\n")
+			w := serve(http.MethodGet, "/soap/text", "", "", "")
+			Expect(w.Header().Get("Content-Type")).To(Equal("text/plain; charset=utf-8"))
+			Expect(w.Body.String()).To(Equal("Café"))
+
+			Expect(serve(http.MethodGet, "/soap/other", "", "", "").Body.String()).To(Equal(`<!-- kept --><ok/>`))
+			Expect(serve(http.MethodGet, "/soap/other", "", "application/json", "").Body.String()).To(MatchJSON(`{"ok": ""}`))
+		})
+
+		It("should apply the registered hooks", func() {
+			RegisterXMLHook("test-credentials", XMLHook{
+				Request: func(c *gin.Context, document *XMLNode) error {
+					if document.Child("Fail") != nil {
+						return errors.New("rejected by hook")
+					}
+					document.Children = append(document.Children, &XMLNode{Name: xml.Name{Local: "Token"}, Text: "secret"})
+					return nil
+				},
+				Response: func(c *gin.Context, document *XMLNode) error {
+					document.Attrs = append(document.Attrs, xml.Attr{Name: xml.Name{Local: "checked"}, Value: "true"})
+					return nil
+				},
+			})
+			setup("  hooks: [test-credentials]\n")
+
+			w := serve(http.MethodPost, "/soap/other", "application/xml", "", `<Login><User>me</User></Login>`)
+			Expect(receivedBody).To(HaveSuffix(`<Login><User>me</User><Token>secret</Token></Login>`))
+			Expect(received.Header.Get("Content-Type")).To(Equal("application/xml; charset=utf-8"))
+			Expect(w.Body.String()).To(HaveSuffix(`<ok checked="true"/>`))
+
+			w = serve(http.MethodPost, "/soap/other", "application/xml", "", `<Login><Fail/></Login>`)
+			Expect(w.Code).To(Equal(http.StatusBadRequest))
+			Expect(w.Body.String()).To(ContainSubstring("rejected by hook"))
+		})
+	})
+})
@@ -0,0 +1,92 @@
+package database
+
+import (
+	"context"
+	"sync/atomic"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type readOnlyKey struct{}
+
+// ReadOnly returns a context hinting that its queries only read, so that a PostgresCluster routes them
+// to a replica and WithTx runs read-only transactions
+func ReadOnly(ctx context.Context) context.Context {
+	return context.WithValue(ctx, readOnlyKey{}, true)
+}
+
+// IsReadOnly tells whether the context carries the ReadOnly hint
+func IsReadOnly(ctx context.Context) bool {
+	readOnly, _ := ctx.Value(readOnlyKey{}).(bool)
+	return readOnly
+}
+
+// PostgresCluster holds the pools of a PostgreSQL primary and its read replicas. Queries run on the
+// primary unless their context carries the ReadOnly hint, and read-only transactions run on a
+// replica. Replicas are picked in turn; without replicas everything runs on the primary. Replicas
+// lag behind the primary, so reads that must see the writes of the request should not be hinted.
+type PostgresCluster struct {
+	primary  *pgxpool.Pool
+	replicas []*pgxpool.Pool
+	next     atomic.Uint64
+}
+
+// NewPostgresCluster creates a cluster from existing pools, see PostgresConfig.CreateCluster
+func NewPostgresCluster(primary *pgxpool.Pool, replicas ...*pgxpool.Pool) *PostgresCluster {
+	return &PostgresCluster{primary: primary, replicas: replicas}
+}
+
+// Primary returns the pool of the primary
+func (c *PostgresCluster) Primary() *pgxpool.Pool {
+	return c.primary
+}
+
+// Replica returns the pool of the next replica, or of the primary without replicas
+func (c *PostgresCluster) Replica() *pgxpool.Pool {
+	if len(c.replicas) == 0 {
+		return c.primary
+	}
+	return c.replicas[(c.next.Add(1)-1)%uint64(len(c.replicas))]
+}
+
+// Pool returns the pool queries with the context run on
+func (c *PostgresCluster) Pool(ctx context.Context) *pgxpool.Pool {
+	if IsReadOnly(ctx) {
+		return c.Replica()
+	}
+	return c.primary
+}
+
+// Exec runs a statement on the primary, or on a replica with the ReadOnly hint
+func (c *PostgresCluster) Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error) {
+	return c.Pool(ctx).Exec(ctx, sql, args...)
+}
+
+// Query runs a query on the primary, or on a replica with the ReadOnly hint
+func (c *PostgresCluster) Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error) {
+	return c.Pool(ctx).Query(ctx, sql, args...)
+}
+
+// QueryRow runs a query on the primary, or on a replica with the ReadOnly hint
+func (c *PostgresCluster) QueryRow(ctx context.Context, sql string, args ...any) pgx.Row {
+	return c.Pool(ctx).QueryRow(ctx, sql, args...)
+}
+
+// BeginTx starts read-only transactions on a replica and the others on the primary, so that the
+// cluster can be given to WithTx
+func (c *PostgresCluster) BeginTx(ctx context.Context, txOptions pgx.TxOptions) (pgx.Tx, error) {
+	if txOptions.AccessMode == pgx.ReadOnly {
+		return c.Replica().BeginTx(ctx, txOptions)
+	}
+	return c.primary.BeginTx(ctx, txOptions)
+}
+
+// Close closes the pools of the primary and the replicas
+func (c *PostgresCluster) Close() {
+	ClosePostgres(c.primary)
+	for _, replica := range c.replicas {
+		ClosePostgres(replica)
+	}
+}
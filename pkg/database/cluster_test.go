@@ -0,0 +1,52 @@
+//go:build unit
+
+package database
+
+import (
+	"context"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("PostgresCluster", func() {
+	// lazyPool returns a pool that never connects, as the specs only compare pools
+	lazyPool := func(host string) *pgxpool.Pool {
+		pool, err := pgxpool.New(context.Background(), "postgres://user:password@"+host+":5432/db")
+		Expect(err).NotTo(HaveOccurred())
+		DeferCleanup(pool.Close)
+		return pool
+	}
+
+	It("should route hinted queries to the replicas in turn", func() {
+		primary, first, second := lazyPool("primary"), lazyPool("replica-1"), lazyPool("replica-2")
+		cluster := NewPostgresCluster(primary, first, second)
+
+		Expect(cluster.Primary()).To(BeIdenticalTo(primary))
+		Expect(cluster.Pool(context.Background())).To(BeIdenticalTo(primary))
+
+		readOnly := ReadOnly(context.Background())
+		Expect(IsReadOnly(readOnly)).To(BeTrue())
+		Expect(cluster.Pool(readOnly)).To(BeIdenticalTo(first))
+		Expect(cluster.Pool(readOnly)).To(BeIdenticalTo(second))
+		Expect(cluster.Replica()).To(BeIdenticalTo(first))
+	})
+
+	It("should use the primary without replicas", func() {
+		primary := lazyPool("primary")
+		cluster := NewPostgresCluster(primary)
+		Expect(cluster.Replica()).To(BeIdenticalTo(primary))
+		Expect(cluster.Pool(ReadOnly(context.Background()))).To(BeIdenticalTo(primary))
+	})
+
+	It("should run read-only transactions with the hint", func() {
+		db := &fakeBeginner{}
+		Expect(WithTx(ReadOnly(context.Background()), db, func(tx pgx.Tx) error { return nil })).To(Succeed())
+		Expect(db.options.AccessMode).To(Equal(pgx.ReadOnly))
+
+		Expect(WithTx(context.Background(), db, func(tx pgx.Tx) error { return nil })).To(Succeed())
+		Expect(db.options.AccessMode).To(BeEmpty())
+	})
+})
@@ -0,0 +1,63 @@
+package database
+
+import (
+	"context"
+
+	"github.com/bradfitz/gomemcache/memcache"
+	"github.com/gomodule/redigo/redis"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// HealthCheck pings a database. It implements server.HealthReporter, to make the readiness of the
+// server depend on the database, and is a preflight check, see server.Server.AddPreflightCheck.
+type HealthCheck func(ctx context.Context) error
+
+// HealthCheck pings the database.
+func (h HealthCheck) HealthCheck(ctx context.Context) error {
+	return h(ctx)
+}
+
+// PostgresHealthCheck pings the PostgreSQL pool.
+func PostgresHealthCheck(pool *pgxpool.Pool) HealthCheck {
+	return func(ctx context.Context) error {
+		return errors.Wrap(pool.Ping(ctx), "failed to ping PostgreSQL")
+	}
+}
+
+// HealthCheck pings the primary of the cluster, see PostgresHealthCheck. Replicas are not
+// checked.
+func (c *PostgresCluster) HealthCheck(ctx context.Context) error {
+	return PostgresHealthCheck(c.Primary())(ctx)
+}
+
+// RedisHealthCheck pings Redis with a connection of the pool.
+func RedisHealthCheck(pool *redis.Pool) HealthCheck {
+	return func(ctx context.Context) error {
+		conn, err := pool.GetContext(ctx)
+		if err != nil {
+			return errors.Wrap(err, "failed to connect to Redis")
+		}
+		defer func() {
+			_ = conn.Close()
+		}()
+		_, err = redis.DoContext(conn, ctx, "PING")
+		return errors.Wrap(err, "failed to ping Redis")
+	}
+}
+
+// MongoDBHealthCheck pings the primary of the MongoDB deployment.
+func MongoDBHealthCheck(client *mongo.Client) HealthCheck {
+	return func(ctx context.Context) error {
+		return errors.Wrap(client.Ping(ctx, nil), "failed to ping MongoDB")
+	}
+}
+
+// MemcachedHealthCheck pings the Memcached servers, bounded by the timeout of the client rather
+// than by the context.
+func MemcachedHealthCheck(client *memcache.Client) HealthCheck {
+	return func(context.Context) error {
+		return errors.Wrap(client.Ping(), "failed to ping Memcached")
+	}
+}
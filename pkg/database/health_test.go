@@ -0,0 +1,41 @@
+//go:build unit
+
+package database
+
+import (
+	"context"
+	"net"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/jackc/pgx/v5/pgxpool"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Health checks", func() {
+	// closedAddress returns an address nothing listens on
+	closedAddress := func() string {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		Expect(err).NotTo(HaveOccurred())
+		address := listener.Addr().String()
+		Expect(listener.Close()).To(Succeed())
+		return address
+	}
+
+	It("should report the unreachable databases", func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		DeferCleanup(cancel)
+		address := closedAddress()
+
+		pool, err := pgxpool.New(ctx, "postgres://user:password@"+address+"/db")
+		Expect(err).NotTo(HaveOccurred())
+		DeferCleanup(pool.Close)
+		Expect(PostgresHealthCheck(pool).HealthCheck(ctx)).To(MatchError(ContainSubstring("failed to ping PostgreSQL")))
+		Expect(NewPostgresCluster(pool).HealthCheck(ctx)).To(MatchError(ContainSubstring("failed to ping PostgreSQL")))
+
+		redisPool := &redis.Pool{Dial: func() (redis.Conn, error) { return redis.Dial("tcp", address) }}
+		DeferCleanup(redisPool.Close)
+		Expect(RedisHealthCheck(redisPool).HealthCheck(ctx)).To(MatchError(ContainSubstring("Redis")))
+	})
+})
@@ -5,9 +5,11 @@ import (
 	"crypto/tls"
 	"crypto/x509"
 	"os"
+	"sync/atomic"
 	"time"
 
 	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/event"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 	"go.mongodb.org/mongo-driver/mongo/readpref"
@@ -39,13 +41,20 @@ type MongoDBConfig struct {
 	// Default: 10s if not specified
 	ConnectTimeout time.Duration `yaml:"connect_timeout"`
 
-	// MaxPoolSize is the maximum number of connections in the pool
-	// Default: 100 if not specified
-	MaxPoolSize uint64 `yaml:"max_pool_size"`
+	// PoolConfig holds the pool settings. max_conns defaults to 100, health_check_period is the heartbeat
+	// interval of the driver and max_conn_lifetime is not supported.
+	PoolConfig `yaml:",inline"`
 
-	// MinPoolSize is the minimum number of connections in the pool
-	// Default: 0 if not specified
-	MinPoolSize uint64 `yaml:"min_pool_size"`
+	// MaxPoolSize is the maximum number of connections in the pool.
+	// Deprecated: use max_conns, which takes precedence.
+	MaxPoolSize uint64 `yaml:"max_pool_size,omitempty"`
+
+	// MinPoolSize is the minimum number of connections in the pool.
+	// Deprecated: use min_conns, which takes precedence.
+	MinPoolSize uint64 `yaml:"min_pool_size,omitempty"`
+
+	// Transactions holds the settings of WithMongoTx, only the timeout applies
+	Transactions TxConfig `yaml:"transactions,omitempty"`
 }
 
 // MongoDBTLSConfig holds TLS configuration for MongoDB.
@@ -78,8 +87,18 @@ func (m MongoDBConfig) Validate() error {
 		return errors.New("connect_timeout cannot be negative")
 	}
 
-	if m.MinPoolSize > m.MaxPoolSize && m.MaxPoolSize > 0 {
-		return errors.New("min_pool_size cannot be greater than max_pool_size")
+	if err := m.PoolConfig.Validate(); err != nil {
+		return err
+	}
+	if m.MaxConnLifetime > 0 {
+		return errors.New("max_conn_lifetime is not supported by MongoDB")
+	}
+	if maxSize, minSize := m.poolSizes(); maxSize > 0 && minSize > maxSize {
+		return errors.Errorf("min_conns (%d) cannot be greater than max_conns (%d)", minSize, maxSize)
+	}
+
+	if err := m.Transactions.Validate(); err != nil {
+		return errors.Wrap(err, "invalid transactions")
 	}
 
 	// Validate TLS configuration if provided
@@ -141,16 +160,23 @@ func (m MongoDBConfig) CreateClient() (*mongo.Client, error) {
 	}
 	clientOpts.SetConnectTimeout(connectTimeout)
 
-	// Set pool size limits
-	if m.MaxPoolSize > 0 {
-		clientOpts.SetMaxPoolSize(m.MaxPoolSize)
-	} else {
-		clientOpts.SetMaxPoolSize(100)
+	// Set pool settings
+	maxPoolSize, minPoolSize := m.poolSizes()
+	if maxPoolSize == 0 {
+		maxPoolSize = 100
 	}
-
-	if m.MinPoolSize > 0 {
-		clientOpts.SetMinPoolSize(m.MinPoolSize)
+	clientOpts.SetMaxPoolSize(maxPoolSize)
+	if minPoolSize > 0 {
+		clientOpts.SetMinPoolSize(minPoolSize)
 	}
+	if m.MaxConnIdleTime > 0 {
+		clientOpts.SetMaxConnIdleTime(m.MaxConnIdleTime)
+	}
+	if m.HealthCheckPeriod > 0 {
+		clientOpts.SetHeartbeatInterval(m.HealthCheckPeriod)
+	}
+	monitor := &mongoPoolMonitor{}
+	clientOpts.SetPoolMonitor(&event.PoolMonitor{Event: monitor.event})
 
 	// Configure TLS if TLS config is provided
 	if m.TLS != nil {
@@ -185,9 +211,65 @@ func (m MongoDBConfig) CreateClient() (*mongo.Client, error) {
 		return nil, errors.Wrap(err, "failed to ping MongoDB")
 	}
 
+	trackClient(client, RegisterPoolMetrics("mongodb", m.Database, func() PoolStats {
+		stats := monitor.stats()
+		stats.Max = int64(maxPoolSize) // #nosec G115 -- pool sizes are far below the int64 range
+		return stats
+	}))
+
 	return client, nil
 }
 
+// DisconnectMongoDB disconnects a client created by MongoDBConfig and stops exporting its metrics
+func DisconnectMongoDB(ctx context.Context, client *mongo.Client) error {
+	untrackClient(client)
+	return client.Disconnect(ctx)
+}
+
+// poolSizes returns the maximum and minimum pool sizes, from the pool settings or the deprecated keys
+func (m MongoDBConfig) poolSizes() (maxSize, minSize uint64) {
+	maxSize, minSize = m.MaxPoolSize, m.MinPoolSize
+	if m.MaxConns > 0 {
+		maxSize = uint64(m.MaxConns) // #nosec G115 -- positive int32
+	}
+	if m.MinConns > 0 {
+		minSize = uint64(m.MinConns) // #nosec G115 -- positive int32
+	}
+	return maxSize, minSize
+}
+
+// mongoPoolMonitor counts the connections of a client from the events of its pools, as the driver
+// has no pool statistics
+type mongoPoolMonitor struct {
+	created, closed, checkedOut, checkedIn, waits atomic.Int64
+}
+
+func (m *mongoPoolMonitor) event(e *event.PoolEvent) {
+	switch e.Type {
+	case event.ConnectionCreated:
+		m.created.Add(1)
+	case event.ConnectionClosed:
+		m.closed.Add(1)
+	case event.GetStarted:
+		if m.stats().Idle == 0 {
+			m.waits.Add(1)
+		}
+	case event.GetSucceeded:
+		m.checkedOut.Add(1)
+	case event.ConnectionReturned:
+		m.checkedIn.Add(1)
+	}
+}
+
+func (m *mongoPoolMonitor) stats() PoolStats {
+	inUse := m.checkedOut.Load() - m.checkedIn.Load()
+	return PoolStats{
+		InUse: inUse,
+		Idle:  max(m.created.Load()-m.closed.Load()-inUse, 0),
+		Waits: m.waits.Load(),
+	}
+}
+
 // buildTLSConfig creates a TLS configuration from the MongoDB TLS settings.
 // Returns nil if no TLS config is provided.
 func (m MongoDBConfig) buildTLSConfig() (*tls.Config, error) {
@@ -5,6 +5,7 @@ package database
 import (
 	"os"
 	"path/filepath"
+	"time"
 
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
@@ -53,6 +54,32 @@ var _ = Describe("MongoDBConfig", func() {
 			Expect(cfg.Validate()).To(HaveOccurred())
 		})
 
+		It("should prefer the pool settings to the deprecated pool sizes", func() {
+			cfg := MongoDBConfig{
+				URI:         "mongodb://localhost:27017",
+				Database:    "testdb",
+				MaxPoolSize: 10,
+				MinPoolSize: 20,
+				PoolConfig:  PoolConfig{MaxConns: 30},
+			}
+			Expect(cfg.Validate()).To(Succeed())
+			maxSize, minSize := cfg.poolSizes()
+			Expect(maxSize).To(Equal(uint64(30)))
+			Expect(minSize).To(Equal(uint64(20)))
+
+			cfg.MinConns = 40
+			Expect(cfg.Validate()).To(MatchError(ContainSubstring("min_conns (40) cannot be greater than max_conns (30)")))
+		})
+
+		It("should fail with unsupported pool settings", func() {
+			cfg := MongoDBConfig{
+				URI:        "mongodb://localhost:27017",
+				Database:   "testdb",
+				PoolConfig: PoolConfig{MaxConnLifetime: time.Hour},
+			}
+			Expect(cfg.Validate()).To(MatchError(ContainSubstring("max_conn_lifetime is not supported")))
+		})
+
 		Context("TLS Validation", func() {
 			var tempDir string
 
@@ -0,0 +1,145 @@
+package database
+
+import (
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// PoolConfig holds the connection pool settings shared by the PostgreSQL, MongoDB and Redis configs.
+// MongoDB and Redis inline it in their sections; PostgresConfig keeps its own fields of the same
+// names, so its existing literals still compile. Settings a client does not support fail its validation.
+type PoolConfig struct {
+	MaxConns          int32         `yaml:"max_conns,omitempty"`           // Maximum number of connections in the pool
+	MinConns          int32         `yaml:"min_conns,omitempty"`           // Minimum number of connections in the pool
+	MaxConnLifetime   time.Duration `yaml:"max_conn_lifetime,omitempty"`   // Maximum lifetime of a connection
+	MaxConnIdleTime   time.Duration `yaml:"max_conn_idle_time,omitempty"`  // Maximum idle time of a connection
+	HealthCheckPeriod time.Duration `yaml:"health_check_period,omitempty"` // Period between health checks
+}
+
+// Validate checks the pool settings
+func (p PoolConfig) Validate() error {
+	if p.MaxConns < 0 {
+		return errors.New("max_conns must be non-negative")
+	}
+	if p.MinConns < 0 {
+		return errors.New("min_conns must be non-negative")
+	}
+	if p.MaxConns > 0 && p.MinConns > p.MaxConns {
+		return errors.Errorf("min_conns (%d) cannot be greater than max_conns (%d)", p.MinConns, p.MaxConns)
+	}
+	if p.MaxConnLifetime < 0 {
+		return errors.New("max_conn_lifetime must be non-negative")
+	}
+	if p.MaxConnIdleTime < 0 {
+		return errors.New("max_conn_idle_time must be non-negative")
+	}
+	if p.HealthCheckPeriod < 0 {
+		return errors.New("health_check_period must be non-negative")
+	}
+	return nil
+}
+
+// PoolStats are the statistics of a connection pool
+type PoolStats struct {
+	InUse int64 // Connections in use
+	Idle  int64 // Idle connections
+	Max   int64 // Maximum number of connections, 0 if unlimited
+	Waits int64 // Acquisitions that found no idle connection, since the pool was created
+}
+
+var (
+	poolConnectionsDesc = prometheus.NewDesc("sargantana_db_pool_connections",
+		"Connections of database pools, in use or idle", []string{"database", "pool", "state"}, nil)
+	poolMaxConnectionsDesc = prometheus.NewDesc("sargantana_db_pool_max_connections",
+		"Maximum number of connections of database pools, 0 if unlimited", []string{"database", "pool"}, nil)
+	poolWaitsDesc = prometheus.NewDesc("sargantana_db_pool_waits_total",
+		"Connection acquisitions of database pools that found no idle connection", []string{"database", "pool"}, nil)
+)
+
+type poolKey struct {
+	database, pool string
+}
+
+// poolEntry is a registration of a pool, compared by identity so that unregistering a pool leaves
+// the others registered under the same labels
+type poolEntry struct {
+	stats func() PoolStats
+}
+
+// poolCollector exports the statistics of the registered pools, read when the metrics are scraped
+type poolCollector struct {
+	mu    sync.RWMutex
+	pools map[poolKey][]*poolEntry
+}
+
+var poolMetrics = &poolCollector{pools: make(map[poolKey][]*poolEntry)}
+
+func init() {
+	prometheus.MustRegister(poolMetrics)
+}
+
+// RegisterPoolMetrics exports the statistics of a pool as metrics labeled with the database type and
+// the pool name, until the returned func is called. The statistics of the pools registered under
+// the same labels, e.g. several pools of one database, are added up. The clients created from the
+// configs of this package are registered on creation and unregistered by ClosePostgres, CloseRedis
+// and DisconnectMongoDB.
+func RegisterPoolMetrics(database, pool string, stats func() PoolStats) (unregister func()) {
+	key, entry := poolKey{database, pool}, &poolEntry{stats: stats}
+	poolMetrics.mu.Lock()
+	defer poolMetrics.mu.Unlock()
+	poolMetrics.pools[key] = append(poolMetrics.pools[key], entry)
+	return func() {
+		poolMetrics.mu.Lock()
+		defer poolMetrics.mu.Unlock()
+		entries := slices.DeleteFunc(poolMetrics.pools[key], func(e *poolEntry) bool { return e == entry })
+		if len(entries) == 0 {
+			delete(poolMetrics.pools, key)
+		} else {
+			poolMetrics.pools[key] = entries
+		}
+	}
+}
+
+// clientMetrics holds the unregister funcs of the pool metrics of the created clients, by client
+var clientMetrics sync.Map
+
+// trackClient records the unregister func of the pool metrics of a client
+func trackClient(client any, unregister func()) {
+	clientMetrics.Store(client, unregister)
+}
+
+// untrackClient stops exporting the pool metrics of a client, if it was created by this package
+func untrackClient(client any) {
+	if unregister, ok := clientMetrics.LoadAndDelete(client); ok {
+		unregister.(func())()
+	}
+}
+
+func (c *poolCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- poolConnectionsDesc
+	ch <- poolMaxConnectionsDesc
+	ch <- poolWaitsDesc
+}
+
+func (c *poolCollector) Collect(ch chan<- prometheus.Metric) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	for key, entries := range c.pools {
+		var s PoolStats
+		for _, entry := range entries {
+			stats := entry.stats()
+			s.InUse += stats.InUse
+			s.Idle += stats.Idle
+			s.Max += stats.Max
+			s.Waits += stats.Waits
+		}
+		ch <- prometheus.MustNewConstMetric(poolConnectionsDesc, prometheus.GaugeValue, float64(s.InUse), key.database, key.pool, "in_use")
+		ch <- prometheus.MustNewConstMetric(poolConnectionsDesc, prometheus.GaugeValue, float64(s.Idle), key.database, key.pool, "idle")
+		ch <- prometheus.MustNewConstMetric(poolMaxConnectionsDesc, prometheus.GaugeValue, float64(s.Max), key.database, key.pool)
+		ch <- prometheus.MustNewConstMetric(poolWaitsDesc, prometheus.CounterValue, float64(s.Waits), key.database, key.pool)
+	}
+}
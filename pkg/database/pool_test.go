@@ -0,0 +1,97 @@
+//go:build unit
+
+package database
+
+import (
+	"strings"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"go.mongodb.org/mongo-driver/event"
+)
+
+var _ = Describe("Pools", func() {
+	Context("Validation", func() {
+		It("should accept valid settings", func() {
+			Expect(PoolConfig{}.Validate()).To(Succeed())
+			Expect(PoolConfig{MaxConns: 10, MinConns: 2, MaxConnLifetime: time.Hour, MaxConnIdleTime: time.Minute, HealthCheckPeriod: time.Minute}.Validate()).To(Succeed())
+		})
+
+		It("should reject invalid settings", func() {
+			Expect(PoolConfig{MaxConns: -1}.Validate()).To(MatchError(ContainSubstring("max_conns must be non-negative")))
+			Expect(PoolConfig{MaxConns: 1, MinConns: 2}.Validate()).To(MatchError(ContainSubstring("cannot be greater than max_conns")))
+			Expect(PoolConfig{MaxConnIdleTime: -1}.Validate()).To(HaveOccurred())
+		})
+	})
+
+	Context("Metrics", func() {
+		It("should export the statistics of the registered pools", func() {
+			unregister := RegisterPoolMetrics("test", "metrics", func() PoolStats {
+				return PoolStats{InUse: 2, Idle: 3, Max: 10, Waits: 7}
+			})
+			DeferCleanup(unregister)
+			expected := `
+# HELP sargantana_db_pool_connections Connections of database pools, in use or idle
+# TYPE sargantana_db_pool_connections gauge
+sargantana_db_pool_connections{database="test",pool="metrics",state="idle"} 3
+sargantana_db_pool_connections{database="test",pool="metrics",state="in_use"} 2
+# HELP sargantana_db_pool_max_connections Maximum number of connections of database pools, 0 if unlimited
+# TYPE sargantana_db_pool_max_connections gauge
+sargantana_db_pool_max_connections{database="test",pool="metrics"} 10
+# HELP sargantana_db_pool_waits_total Connection acquisitions of database pools that found no idle connection
+# TYPE sargantana_db_pool_waits_total counter
+sargantana_db_pool_waits_total{database="test",pool="metrics"} 7
+`
+			Expect(testutil.CollectAndCompare(poolMetrics, strings.NewReader(expected))).To(Succeed())
+
+			unregister()
+			Expect(testutil.CollectAndCount(poolMetrics)).To(Equal(0))
+		})
+
+		It("should add up the pools registered under the same labels", func() {
+			unregisterFirst := RegisterPoolMetrics("test", "metrics", func() PoolStats { return PoolStats{InUse: 1, Max: 5} })
+			unregisterSecond := RegisterPoolMetrics("test", "metrics", func() PoolStats { return PoolStats{InUse: 2, Max: 10} })
+			DeferCleanup(unregisterSecond)
+			maxConnections := func(value string) string {
+				return `
+# HELP sargantana_db_pool_max_connections Maximum number of connections of database pools, 0 if unlimited
+# TYPE sargantana_db_pool_max_connections gauge
+sargantana_db_pool_max_connections{database="test",pool="metrics"} ` + value + "\n"
+			}
+			Expect(testutil.CollectAndCompare(poolMetrics, strings.NewReader(maxConnections("15")), "sargantana_db_pool_max_connections")).To(Succeed())
+
+			unregisterFirst()
+			Expect(testutil.CollectAndCompare(poolMetrics, strings.NewReader(maxConnections("10")), "sargantana_db_pool_max_connections")).To(Succeed())
+			unregisterSecond()
+			Expect(testutil.CollectAndCount(poolMetrics)).To(Equal(0))
+		})
+
+		It("should export the pools of the created clients until they are closed", func() {
+			pool, err := RedisConfig{Address: "localhost:6379", Database: 3, PoolConfig: PoolConfig{MaxConns: 5}}.CreateClient()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(testutil.CollectAndCompare(poolMetrics, strings.NewReader(`
+# HELP sargantana_db_pool_max_connections Maximum number of connections of database pools, 0 if unlimited
+# TYPE sargantana_db_pool_max_connections gauge
+sargantana_db_pool_max_connections{database="redis",pool="localhost:6379/3"} 5
+`), "sargantana_db_pool_max_connections")).To(Succeed())
+
+			Expect(CloseRedis(pool)).To(Succeed())
+			Expect(testutil.CollectAndCount(poolMetrics)).To(Equal(0))
+		})
+	})
+
+	It("should count MongoDB connections from the pool events", func() {
+		monitor := &mongoPoolMonitor{}
+		for _, eventType := range []string{
+			event.GetStarted, event.ConnectionCreated, event.GetSucceeded, // waits for a new connection
+			event.ConnectionReturned,
+			event.GetStarted, event.GetSucceeded, // reuses the idle connection
+			event.ConnectionCreated, event.ConnectionCreated, event.ConnectionClosed,
+		} {
+			monitor.event(&event.PoolEvent{Type: eventType})
+		}
+		Expect(monitor.stats()).To(Equal(PoolStats{InUse: 1, Idle: 1, Waits: 1}))
+	})
+})
@@ -16,15 +16,33 @@ type PostgresConfig struct {
 	User              string        `yaml:"user"`
 	Password          string        `yaml:"password"`
 	SSLMode           string        `yaml:"ssl_mode,omitempty"`            // disable, allow, prefer, require, verify-ca, verify-full
+	DSN               string        `yaml:"dsn,omitempty"`                 // Connection string of the primary, instead of the fields above
+	ReplicaDSNs       []string      `yaml:"replica_dsns,omitempty"`        // Connection strings of the read replicas, see CreateCluster
 	MaxConns          int32         `yaml:"max_conns,omitempty"`           // Maximum number of connections in the pool
 	MinConns          int32         `yaml:"min_conns,omitempty"`           // Minimum number of connections in the pool
 	MaxConnLifetime   time.Duration `yaml:"max_conn_lifetime,omitempty"`   // Maximum lifetime of a connection
 	MaxConnIdleTime   time.Duration `yaml:"max_conn_idle_time,omitempty"`  // Maximum idle time of a connection
 	HealthCheckPeriod time.Duration `yaml:"health_check_period,omitempty"` // Period between health checks
+	Transactions      TxConfig      `yaml:"transactions,omitempty"`        // Settings of WithTx, see TxConfig
 }
 
 // Validate checks if the PostgresConfig has all required fields set
 func (p PostgresConfig) Validate() error {
+	for _, dsn := range p.ReplicaDSNs {
+		if _, err := pgxpool.ParseConfig(dsn); err != nil {
+			return fmt.Errorf("invalid postgres replica_dsns: %w", err)
+		}
+	}
+	if p.DSN != "" {
+		if _, err := pgxpool.ParseConfig(p.DSN); err != nil {
+			return fmt.Errorf("invalid postgres dsn: %w", err)
+		}
+		if p.Host != "" || p.Port != 0 || p.Database != "" || p.User != "" || p.Password != "" || p.SSLMode != "" {
+			return fmt.Errorf("postgres dsn cannot be combined with host, port, database, user, password or ssl_mode")
+		}
+		return p.validatePool()
+	}
+
 	if p.Host == "" {
 		return fmt.Errorf("postgres host must be set and non-empty")
 	}
@@ -56,37 +74,60 @@ func (p PostgresConfig) Validate() error {
 		}
 	}
 
-	// Validate pool settings
-	if p.MaxConns < 0 {
-		return fmt.Errorf("max_conns must be non-negative")
-	}
-	if p.MinConns < 0 {
-		return fmt.Errorf("min_conns must be non-negative")
-	}
-	if p.MaxConns > 0 && p.MinConns > p.MaxConns {
-		return fmt.Errorf("min_conns (%d) cannot be greater than max_conns (%d)", p.MinConns, p.MaxConns)
-	}
-	if p.MaxConnLifetime < 0 {
-		return fmt.Errorf("max_conn_lifetime must be non-negative")
-	}
-	if p.MaxConnIdleTime < 0 {
-		return fmt.Errorf("max_conn_idle_time must be non-negative")
+	return p.validatePool()
+}
+
+// validatePool checks the pool and transaction settings
+func (p PostgresConfig) validatePool() error {
+	if err := p.pool().Validate(); err != nil {
+		return err
 	}
-	if p.HealthCheckPeriod < 0 {
-		return fmt.Errorf("health_check_period must be non-negative")
+	if err := p.Transactions.Validate(); err != nil {
+		return fmt.Errorf("invalid transactions: %w", err)
 	}
-
 	return nil
 }
 
+// pool returns the pool settings of the primary and the replicas
+func (p PostgresConfig) pool() PoolConfig {
+	return PoolConfig{
+		MaxConns:          p.MaxConns,
+		MinConns:          p.MinConns,
+		MaxConnLifetime:   p.MaxConnLifetime,
+		MaxConnIdleTime:   p.MaxConnIdleTime,
+		HealthCheckPeriod: p.HealthCheckPeriod,
+	}
+}
+
 // CreateClient creates and configures a PostgreSQL connection pool from this config.
 // Implements the config.ClientFactory[*pgxpool.Pool] interface.
 // Returns *pgxpool.Pool on success, or an error if client creation fails.
+// The pool connects to the primary, the replicas are only used by CreateCluster.
 
 func (p PostgresConfig) CreateClient() (*pgxpool.Pool, error) {
-	// Build connection string
-	connString := p.buildConnectionString()
+	return p.newPool(p.buildConnectionString())
+}
 
+// CreateCluster creates the pools of the primary and of the replicas, see PostgresCluster
+func (p PostgresConfig) CreateCluster() (*PostgresCluster, error) {
+	primary, err := p.CreateClient()
+	if err != nil {
+		return nil, err
+	}
+	cluster := &PostgresCluster{primary: primary}
+	for i, dsn := range p.ReplicaDSNs {
+		replica, err := p.newPool(dsn)
+		if err != nil {
+			cluster.Close()
+			return nil, fmt.Errorf("failed to connect to PostgreSQL replica %d: %w", i, err)
+		}
+		cluster.replicas = append(cluster.replicas, replica)
+	}
+	return cluster, nil
+}
+
+// newPool creates a pool with the pool settings of the config and exports its metrics
+func (p PostgresConfig) newPool(connString string) (*pgxpool.Pool, error) {
 	// Parse and create pool config
 	poolConfig, err := pgxpool.ParseConfig(connString)
 	if err != nil {
@@ -123,11 +164,31 @@ func (p PostgresConfig) CreateClient() (*pgxpool.Pool, error) {
 		return nil, fmt.Errorf("failed to ping PostgreSQL database: %w", err)
 	}
 
+	conn := poolConfig.ConnConfig
+	trackClient(pool, RegisterPoolMetrics("postgres", fmt.Sprintf("%s:%d/%s", conn.Host, conn.Port, conn.Database), func() PoolStats {
+		stat := pool.Stat()
+		return PoolStats{
+			InUse: int64(stat.AcquiredConns()),
+			Idle:  int64(stat.IdleConns()),
+			Max:   int64(stat.MaxConns()),
+			Waits: stat.EmptyAcquireCount(),
+		}
+	}))
+
 	return pool, nil
 }
 
+// ClosePostgres closes a pool created by PostgresConfig and stops exporting its metrics
+func ClosePostgres(pool *pgxpool.Pool) {
+	untrackClient(pool)
+	pool.Close()
+}
+
 // buildConnectionString creates a PostgreSQL connection string from the config
 func (p PostgresConfig) buildConnectionString() string {
+	if p.DSN != "" {
+		return p.DSN
+	}
 	sslMode := p.SSLMode
 	if sslMode == "" {
 		sslMode = "prefer" // Default to prefer if not specified
@@ -16,9 +16,12 @@ type RedisConfig struct {
 	Username    string        `yaml:"username,omitempty"`
 	Password    string        `yaml:"password,omitempty"`
 	Database    int           `yaml:"database,omitempty"`
-	MaxIdle     int           `yaml:"max_idle"`
-	IdleTimeout time.Duration `yaml:"idle_timeout"`
+	MaxIdle     int           `yaml:"max_idle"`               // Maximum number of idle connections
+	IdleTimeout time.Duration `yaml:"idle_timeout,omitempty"` // Deprecated: use max_conn_idle_time, which takes precedence
 	TLS         *TLSConfig    `yaml:"tls,omitempty"`
+	// PoolConfig holds the pool settings. Borrowing waits for a connection when max_conns are in use,
+	// idle connections are checked after health_check_period (default 1m) and min_conns is not supported.
+	PoolConfig `yaml:",inline"`
 }
 
 func (r RedisConfig) Validate() error {
@@ -34,6 +37,12 @@ func (r RedisConfig) Validate() error {
 	if r.Database < 0 {
 		return fmt.Errorf("redis database must be non-negative")
 	}
+	if err := r.PoolConfig.Validate(); err != nil {
+		return err
+	}
+	if r.MinConns > 0 {
+		return fmt.Errorf("min_conns is not supported by redis")
+	}
 	if r.TLS != nil {
 		if (r.TLS.CertFile != "" && r.TLS.KeyFile == "") || (r.TLS.CertFile == "" && r.TLS.KeyFile != "") {
 			return fmt.Errorf("both cert_file and key_file must be set together in TLS configuration")
@@ -47,7 +56,23 @@ func (r RedisConfig) Validate() error {
 // Returns *redis.Pool on success, or an error if client creation fails.
 
 func (r RedisConfig) CreateClient() (*redis.Pool, error) {
-	return newRedisPoolWithConfig(&r), nil
+	pool := newRedisPoolWithConfig(&r)
+	trackClient(pool, RegisterPoolMetrics("redis", fmt.Sprintf("%s/%d", r.Address, r.Database), func() PoolStats {
+		stats := pool.Stats()
+		return PoolStats{
+			InUse: int64(stats.ActiveCount - stats.IdleCount),
+			Idle:  int64(stats.IdleCount),
+			Max:   int64(pool.MaxActive),
+			Waits: stats.WaitCount,
+		}
+	}))
+	return pool, nil
+}
+
+// CloseRedis closes a pool created by RedisConfig and stops exporting its metrics
+func CloseRedis(pool *redis.Pool) error {
+	untrackClient(pool)
+	return pool.Close()
 }
 
 // TLSConfig holds TLS configuration for Redis connections
@@ -66,16 +91,27 @@ type TLSConfig struct {
 //
 // Returns a configured Redis connection pool ready for use.
 func newRedisPoolWithConfig(config *RedisConfig) *redis.Pool {
+	healthCheckPeriod := config.HealthCheckPeriod
+	if healthCheckPeriod == 0 {
+		healthCheckPeriod = time.Minute
+	}
+	idleTimeout := config.MaxConnIdleTime
+	if idleTimeout == 0 {
+		idleTimeout = config.IdleTimeout
+	}
 	return &redis.Pool{
 		TestOnBorrow: func(c redis.Conn, t time.Time) error {
-			if time.Since(t) < time.Minute {
+			if time.Since(t) < healthCheckPeriod {
 				return nil
 			}
 			_, err := c.Do("PING")
 			return err
 		},
-		MaxIdle:     config.MaxIdle,
-		IdleTimeout: config.IdleTimeout,
+		MaxIdle:         config.MaxIdle,
+		MaxActive:       int(config.MaxConns),
+		Wait:            config.MaxConns > 0,
+		IdleTimeout:     idleTimeout,
+		MaxConnLifetime: config.MaxConnLifetime,
 		Dial: func() (redis.Conn, error) {
 			return dialRedis(config)
 		},
@@ -43,6 +43,35 @@ var _ = Describe("RedisConfig", func() {
 			Expect(cfg.Validate()).To(HaveOccurred())
 		})
 
+		It("should fail with unsupported or invalid pool settings", func() {
+			cfg := RedisConfig{
+				Address:    "localhost:6379",
+				PoolConfig: PoolConfig{MinConns: 1},
+			}
+			Expect(cfg.Validate()).To(MatchError(ContainSubstring("min_conns is not supported")))
+
+			cfg.PoolConfig = PoolConfig{MaxConnLifetime: -1}
+			Expect(cfg.Validate()).To(HaveOccurred())
+		})
+
+		It("should apply the pool settings", func() {
+			pool := newRedisPoolWithConfig(&RedisConfig{
+				Address:     "localhost:6379",
+				MaxIdle:     2,
+				IdleTimeout: time.Minute,
+				PoolConfig:  PoolConfig{MaxConns: 8, MaxConnLifetime: time.Hour, MaxConnIdleTime: time.Second},
+			})
+			Expect(pool.MaxIdle).To(Equal(2))
+			Expect(pool.MaxActive).To(Equal(8))
+			Expect(pool.Wait).To(BeTrue())
+			Expect(pool.MaxConnLifetime).To(Equal(time.Hour))
+			Expect(pool.IdleTimeout).To(Equal(time.Second))
+
+			pool = newRedisPoolWithConfig(&RedisConfig{Address: "localhost:6379", IdleTimeout: time.Minute})
+			Expect(pool.Wait).To(BeFalse())
+			Expect(pool.IdleTimeout).To(Equal(time.Minute))
+		})
+
 		It("should fail if database is negative", func() {
 			cfg := RedisConfig{
 				Address:  "localhost:6379",
@@ -0,0 +1,154 @@
+package database
+
+import (
+	"context"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const (
+	defaultTxMaxRetries   = 3
+	defaultTxRetryBackoff = 10 * time.Millisecond
+)
+
+// PostgreSQL error codes of the transactions worth retrying
+const (
+	serializationFailure = "40001"
+	deadlockDetected     = "40P01"
+)
+
+var isolationLevels = map[string]pgx.TxIsoLevel{
+	"":                "",
+	"read_committed":  pgx.ReadCommitted,
+	"repeatable_read": pgx.RepeatableRead,
+	"serializable":    pgx.Serializable,
+}
+
+// TxConfig holds the transaction settings of a database client, under the transactions key of its config
+type TxConfig struct {
+	Timeout        time.Duration `yaml:"timeout,omitempty"`         // Maximum duration of a transaction, unlimited if zero
+	MaxRetries     int           `yaml:"max_retries,omitempty"`     // PostgreSQL retries after serialization failures and deadlocks. Defaults to 3, negative disables them.
+	RetryBackoff   time.Duration `yaml:"retry_backoff,omitempty"`   // Wait before the first retry, doubled on each retry. Defaults to 10ms.
+	IsolationLevel string        `yaml:"isolation_level,omitempty"` // PostgreSQL only: read_committed, repeatable_read or serializable. Defaults to the server's.
+}
+
+// Validate checks the transaction settings
+func (t TxConfig) Validate() error {
+	if t.Timeout < 0 {
+		return errors.New("timeout must be non-negative")
+	}
+	if t.RetryBackoff < 0 {
+		return errors.New("retry_backoff must be non-negative")
+	}
+	if _, ok := isolationLevels[t.IsolationLevel]; !ok {
+		return errors.Errorf("invalid isolation_level %q, must be one of: read_committed, repeatable_read, serializable", t.IsolationLevel)
+	}
+	return nil
+}
+
+// TxBeginner starts pgx transactions, e.g. *pgxpool.Pool, *pgx.Conn or *PostgresCluster
+type TxBeginner interface {
+	BeginTx(ctx context.Context, txOptions pgx.TxOptions) (pgx.Tx, error)
+}
+
+// WithTx runs fn in a PostgreSQL transaction with the default settings, see TxConfig.WithTx
+func WithTx(ctx context.Context, db TxBeginner, fn func(tx pgx.Tx) error) error {
+	return TxConfig{}.WithTx(ctx, db, fn)
+}
+
+// WithTx runs fn in a transaction, committed if fn returns nil and rolled back otherwise, also when fn
+// panics. Transactions failing with a serialization failure or a deadlock are retried as a whole, so fn
+// must not have side effects outside the transaction. The timeout applies to each attempt. Contexts
+// with the ReadOnly hint run read-only transactions, on a replica when db is a PostgresCluster.
+func (t TxConfig) WithTx(ctx context.Context, db TxBeginner, fn func(tx pgx.Tx) error) error {
+	options := pgx.TxOptions{IsoLevel: isolationLevels[t.IsolationLevel]}
+	if IsReadOnly(ctx) {
+		options.AccessMode = pgx.ReadOnly
+	}
+	maxRetries, backoff := t.MaxRetries, t.RetryBackoff
+	if maxRetries == 0 {
+		maxRetries = defaultTxMaxRetries
+	}
+	if backoff == 0 {
+		backoff = defaultTxRetryBackoff
+	}
+
+	for retry := 0; ; retry++ {
+		err := t.runTx(ctx, db, options, fn)
+		if err == nil || retry >= maxRetries || !IsRetryable(err) {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(backoff << retry):
+		}
+	}
+}
+
+// runTx runs a single attempt of WithTx
+func (t TxConfig) runTx(ctx context.Context, db TxBeginner, options pgx.TxOptions, fn func(tx pgx.Tx) error) error {
+	ctx, cancel := t.withTimeout(ctx)
+	defer cancel()
+
+	tx, err := db.BeginTx(ctx, options)
+	if err != nil {
+		return errors.Wrap(err, "failed to begin transaction")
+	}
+	// Rolling back with a done context would only close the connection
+	rollbackCtx := context.WithoutCancel(ctx)
+	defer func() {
+		if p := recover(); p != nil {
+			_ = tx.Rollback(rollbackCtx)
+			panic(p)
+		}
+	}()
+
+	if err := fn(tx); err != nil {
+		_ = tx.Rollback(rollbackCtx)
+		return err
+	}
+	return errors.Wrap(tx.Commit(ctx), "failed to commit transaction")
+}
+
+// IsRetryable tells whether the error is a PostgreSQL serialization failure or deadlock, after which
+// the whole transaction can be retried
+func IsRetryable(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && (pgErr.Code == serializationFailure || pgErr.Code == deadlockDetected)
+}
+
+// WithMongoTx runs fn in a MongoDB transaction with the default settings, see TxConfig.WithMongoTx
+func WithMongoTx(ctx context.Context, client *mongo.Client, fn func(sc mongo.SessionContext) error) error {
+	return TxConfig{}.WithMongoTx(ctx, client, fn)
+}
+
+// WithMongoTx runs fn in a transaction of a new session of the client. The operations of fn must use
+// the session context. The driver retries transient transaction errors by itself, so the timeout
+// applies to all the attempts and the retry settings are ignored.
+func (t TxConfig) WithMongoTx(ctx context.Context, client *mongo.Client, fn func(sc mongo.SessionContext) error) error {
+	ctx, cancel := t.withTimeout(ctx)
+	defer cancel()
+
+	session, err := client.StartSession()
+	if err != nil {
+		return errors.Wrap(err, "failed to start MongoDB session")
+	}
+	defer session.EndSession(context.WithoutCancel(ctx))
+
+	_, err = session.WithTransaction(ctx, func(sc mongo.SessionContext) (any, error) {
+		return nil, fn(sc)
+	})
+	return err
+}
+
+func (t TxConfig) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if t.Timeout > 0 {
+		return context.WithTimeout(ctx, t.Timeout)
+	}
+	return context.WithCancel(ctx)
+}
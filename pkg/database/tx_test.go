@@ -0,0 +1,178 @@
+//go:build unit
+
+package database
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+// fakeTx records the outcome of a transaction, the other pgx.Tx methods are not used
+type fakeTx struct {
+	pgx.Tx
+	commitErr  error
+	committed  bool
+	rolledBack bool
+}
+
+func (t *fakeTx) Commit(context.Context) error {
+	t.committed = true
+	return t.commitErr
+}
+
+func (t *fakeTx) Rollback(context.Context) error {
+	t.rolledBack = true
+	return nil
+}
+
+type fakeBeginner struct {
+	txs      []*fakeTx
+	options  pgx.TxOptions
+	deadline bool
+	err      error
+}
+
+func (b *fakeBeginner) BeginTx(ctx context.Context, options pgx.TxOptions) (pgx.Tx, error) {
+	if b.err != nil {
+		return nil, b.err
+	}
+	_, b.deadline = ctx.Deadline()
+	b.options = options
+	tx := &fakeTx{}
+	b.txs = append(b.txs, tx)
+	return tx, nil
+}
+
+var _ = Describe("Transactions", func() {
+	var db *fakeBeginner
+
+	BeforeEach(func() {
+		db = &fakeBeginner{}
+	})
+
+	It("should commit when fn succeeds", func() {
+		Expect(WithTx(context.Background(), db, func(tx pgx.Tx) error { return nil })).To(Succeed())
+		Expect(db.txs).To(HaveLen(1))
+		Expect(db.txs[0].committed).To(BeTrue())
+		Expect(db.txs[0].rolledBack).To(BeFalse())
+		Expect(db.deadline).To(BeFalse())
+	})
+
+	It("should roll back and return the error of fn", func() {
+		failure := errors.New("failure")
+		Expect(WithTx(context.Background(), db, func(tx pgx.Tx) error { return failure })).To(MatchError(failure))
+		Expect(db.txs).To(HaveLen(1))
+		Expect(db.txs[0].committed).To(BeFalse())
+		Expect(db.txs[0].rolledBack).To(BeTrue())
+	})
+
+	It("should roll back when fn panics", func() {
+		Expect(func() {
+			_ = WithTx(context.Background(), db, func(tx pgx.Tx) error { panic("boom") })
+		}).To(PanicWith("boom"))
+		Expect(db.txs[0].rolledBack).To(BeTrue())
+	})
+
+	It("should fail when the transaction cannot begin", func() {
+		db.err = errors.New("no connection")
+		Expect(WithTx(context.Background(), db, func(tx pgx.Tx) error { return nil })).To(MatchError(ContainSubstring("failed to begin transaction")))
+	})
+
+	It("should retry serialization failures and deadlocks", func() {
+		attempts := 0
+		cfg := TxConfig{RetryBackoff: time.Millisecond}
+		err := cfg.WithTx(context.Background(), db, func(tx pgx.Tx) error {
+			attempts++
+			switch attempts {
+			case 1:
+				return &pgconn.PgError{Code: serializationFailure}
+			case 2:
+				return &pgconn.PgError{Code: deadlockDetected}
+			}
+			return nil
+		})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(db.txs).To(HaveLen(3))
+		Expect(db.txs[2].committed).To(BeTrue())
+	})
+
+	It("should retry failed commits", func() {
+		cfg := TxConfig{RetryBackoff: time.Millisecond}
+		err := cfg.WithTx(context.Background(), db, func(tx pgx.Tx) error {
+			if len(db.txs) == 1 {
+				tx.(*fakeTx).commitErr = &pgconn.PgError{Code: serializationFailure}
+			}
+			return nil
+		})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(db.txs).To(HaveLen(2))
+	})
+
+	It("should give up after the maximum retries", func() {
+		cfg := TxConfig{MaxRetries: 2, RetryBackoff: time.Millisecond}
+		err := cfg.WithTx(context.Background(), db, func(tx pgx.Tx) error {
+			return &pgconn.PgError{Code: serializationFailure}
+		})
+		Expect(IsRetryable(err)).To(BeTrue())
+		Expect(db.txs).To(HaveLen(3))
+	})
+
+	It("should not retry when disabled or for other errors", func() {
+		cfg := TxConfig{MaxRetries: -1}
+		Expect(cfg.WithTx(context.Background(), db, func(tx pgx.Tx) error {
+			return &pgconn.PgError{Code: serializationFailure}
+		})).To(HaveOccurred())
+		Expect(db.txs).To(HaveLen(1))
+
+		Expect(WithTx(context.Background(), db, func(tx pgx.Tx) error {
+			return &pgconn.PgError{Code: "23505"}
+		})).To(HaveOccurred())
+		Expect(db.txs).To(HaveLen(2))
+	})
+
+	It("should stop retrying when the context is done", func() {
+		ctx, cancel := context.WithCancel(context.Background())
+		err := TxConfig{RetryBackoff: time.Hour}.WithTx(ctx, db, func(tx pgx.Tx) error {
+			cancel()
+			return &pgconn.PgError{Code: serializationFailure}
+		})
+		Expect(IsRetryable(err)).To(BeTrue())
+		Expect(db.txs).To(HaveLen(1))
+	})
+
+	It("should apply the timeout and the isolation level", func() {
+		cfg := TxConfig{Timeout: time.Second, IsolationLevel: "serializable"}
+		Expect(cfg.WithTx(context.Background(), db, func(tx pgx.Tx) error { return nil })).To(Succeed())
+		Expect(db.deadline).To(BeTrue())
+		Expect(db.options.IsoLevel).To(Equal(pgx.Serializable))
+	})
+
+	Context("Validation", func() {
+		It("should accept empty and complete settings", func() {
+			Expect(TxConfig{}.Validate()).To(Succeed())
+			Expect(TxConfig{Timeout: time.Second, MaxRetries: 5, RetryBackoff: time.Millisecond, IsolationLevel: "repeatable_read"}.Validate()).To(Succeed())
+		})
+
+		It("should reject invalid settings", func() {
+			Expect(TxConfig{Timeout: -1}.Validate()).To(HaveOccurred())
+			Expect(TxConfig{RetryBackoff: -1}.Validate()).To(HaveOccurred())
+			Expect(TxConfig{IsolationLevel: "snapshot"}.Validate()).To(MatchError(ContainSubstring("invalid isolation_level")))
+		})
+
+		It("should be validated with the client configs", func() {
+			pg := PostgresConfig{Host: "localhost", Port: 5432, Database: "db", User: "user", Password: "password"}
+			pg.Transactions.IsolationLevel = "snapshot"
+			Expect(pg.Validate()).To(MatchError(ContainSubstring("invalid transactions")))
+
+			mongo := MongoDBConfig{URI: "mongodb://localhost:27017", Database: "db"}
+			mongo.Transactions.Timeout = -1
+			Expect(mongo.Validate()).To(MatchError(ContainSubstring("invalid transactions")))
+		})
+	})
+})
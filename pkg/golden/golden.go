@@ -0,0 +1,174 @@
+// Package golden renders the surface of a gateway, its effective configuration and its route
+// table, deterministically, and compares it with golden files, so that downstream gateways detect
+// unintended changes when upgrading sargantana-go:
+//
+//	func TestGatewaySurface(t *testing.T) {
+//		cfg := golden.Fixture(t, "testdata/config.yaml", map[string]string{"SESSION_SECRET": "secret"})
+//		golden.Assert(t, "effective-config", golden.EffectiveConfig(t, cfg))
+//		golden.Assert(t, "routes", golden.RouteTable(t, cfg))
+//	}
+//
+// The golden files are written to testdata/<name>.golden when the test runs with UpdateEnv set,
+// e.g. SARGANTANA_UPDATE_GOLDEN=1 go test ./..., and then reviewed and committed.
+package golden
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/animalet/sargantana-go/pkg/config"
+	"github.com/animalet/sargantana-go/pkg/logging"
+	"github.com/animalet/sargantana-go/pkg/server"
+)
+
+// T is the part of testing.TB used by the helpers, also implemented by GinkgoT().
+type T interface {
+	Helper()
+	Errorf(format string, args ...any)
+	Fatalf(format string, args ...any)
+	Setenv(key, value string)
+}
+
+// UpdateEnv is the environment variable that makes Assert write the golden files instead of
+// comparing them.
+const UpdateEnv = "SARGANTANA_UPDATE_GOLDEN"
+
+// Dir is the directory of the golden files, relative to the package under test.
+var Dir = "testdata"
+
+// Fixture loads the configuration file with the environment variables set for the duration of
+// the test, so that the placeholders resolved from the environment do not depend on the machine
+// running it. Secret providers other than env, such as Vault, are not registered.
+func Fixture(t T, path string, env map[string]string) *config.Config {
+	t.Helper()
+	for name, value := range env {
+		t.Setenv(name, value)
+	}
+	cfg, err := config.NewConfig(path)
+	if err != nil {
+		t.Fatalf("failed to load configuration fixture: %v", err)
+	}
+	return cfg
+}
+
+// EffectiveConfig renders the effective configuration, as printed by "sargantana
+// -print-config": the logging and server sections with their defaults, the secrets redacted
+// and the source of every value. Sections loaded with config.Get before are rendered the same way.
+func EffectiveConfig(t T, cfg *config.Config) []byte {
+	t.Helper()
+	if _, err := config.Get[logging.Config](cfg, "logging"); err != nil {
+		t.Fatalf("failed to load logging configuration: %v", err)
+	}
+	if _, err := config.Get[server.SargantanaConfig](cfg, "sargantana"); err != nil {
+		t.Fatalf("failed to load server configuration: %v", err)
+	}
+	var buf bytes.Buffer
+	if err := cfg.WriteEffective(&buf); err != nil {
+		t.Fatalf("failed to render effective configuration: %v", err)
+	}
+	return buf.Bytes()
+}
+
+// RouteTable renders the routes of the gateway configured in the server section, one per line
+// with their method, path, controller binding and priority, sorted by path and method. See
+// server.DescribeRoutes for how the server is bootstrapped. The controller types of the bindings
+// must be registered, e.g. with server.RegisterController.
+func RouteTable(t T, cfg *config.Config) []byte {
+	t.Helper()
+	serverCfg, err := config.Get[server.SargantanaConfig](cfg, "sargantana")
+	if err != nil {
+		t.Fatalf("failed to load server configuration: %v", err)
+	}
+	if serverCfg == nil {
+		t.Fatalf("no sargantana configuration section")
+	}
+	routes, err := server.DescribeRoutes(*serverCfg)
+	if err != nil {
+		t.Fatalf("failed to describe routes: %v", err)
+	}
+	return FormatRoutes(routes)
+}
+
+// FormatRoutes renders the routes as a table, see RouteTable.
+func FormatRoutes(routes []server.Route) []byte {
+	var buf bytes.Buffer
+	tw := tabwriter.NewWriter(&buf, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintln(tw, "METHOD\tPATH\tCONTROLLER\tTYPE\tPRIORITY")
+	for _, route := range routes {
+		_, _ = fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", route.Method, route.Path, orDash(route.Controller),
+			orDash(route.ControllerType), orDash(string(route.Priority)))
+	}
+	_ = tw.Flush()
+	// Trailing spaces of the last column are not kept by editors
+	lines := strings.Split(buf.String(), "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimRight(line, " ")
+	}
+	return []byte(strings.Join(lines, "\n"))
+}
+
+func orDash(value string) string {
+	if value == "" {
+		return "-"
+	}
+	return value
+}
+
+// Assert compares got with the golden file Dir/<name>.golden, failing the test with the first
+// differing line. With UpdateEnv set to a non-empty value, the golden file is written instead.
+func Assert(t T, name string, got []byte) {
+	t.Helper()
+	path := filepath.Join(Dir, name+".golden")
+	if os.Getenv(UpdateEnv) != "" {
+		if err := os.MkdirAll(filepath.Dir(path), 0o750); err != nil {
+			t.Fatalf("failed to create golden file directory: %v", err)
+		}
+		if err := os.WriteFile(path, got, 0o600); err != nil {
+			t.Fatalf("failed to write golden file: %v", err)
+		}
+		return
+	}
+	// #nosec G304 -- the golden files are chosen by the test
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read golden file, run the test with %s=1 to create it: %v", UpdateEnv, err)
+	}
+	if diff := Diff(want, got); diff != "" {
+		t.Errorf("%s differs from the golden file, run the test with %s=1 to update it if the change is intended:\n%s",
+			path, UpdateEnv, diff)
+	}
+}
+
+// Diff describes the first line where got differs from want, or returns "" if they are equal.
+func Diff(want, got []byte) string {
+	if bytes.Equal(want, got) {
+		return ""
+	}
+	wantLines := strings.Split(string(want), "\n")
+	gotLines := strings.Split(string(got), "\n")
+	for i := 0; ; i++ {
+		var w, g string
+		wantOK, gotOK := i < len(wantLines), i < len(gotLines)
+		if wantOK {
+			w = wantLines[i]
+		}
+		if gotOK {
+			g = gotLines[i]
+		}
+		if wantOK && gotOK && w == g {
+			continue
+		}
+		switch {
+		case !gotOK:
+			return fmt.Sprintf("line %d: missing %q", i+1, w)
+		case !wantOK:
+			return fmt.Sprintf("line %d: unexpected %q", i+1, g)
+		default:
+			return fmt.Sprintf("line %d:\n- %s\n+ %s", i+1, w, g)
+		}
+	}
+}
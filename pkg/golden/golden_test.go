@@ -0,0 +1,116 @@
+//go:build unit
+
+package golden
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"github.com/animalet/sargantana-go/pkg/server"
+	"github.com/gin-gonic/gin"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+type itemsConfig struct {
+	Resource string `yaml:"resource"`
+}
+
+func (c itemsConfig) Validate() error {
+	if c.Resource == "" {
+		return fmt.Errorf("resource must be set")
+	}
+	return nil
+}
+
+type itemsController struct {
+	resource string
+}
+
+func (c *itemsController) Bind(engine *gin.Engine, _ gin.HandlerFunc) error {
+	engine.GET("/"+c.resource, func(c *gin.Context) { c.Status(http.StatusOK) })
+	engine.POST("/"+c.resource+"/:id", func(c *gin.Context) { c.Status(http.StatusCreated) })
+	return nil
+}
+
+func (c *itemsController) Close() error { return nil }
+
+// recordingT records the failures of the helpers
+type recordingT struct {
+	FullGinkgoTInterface
+	errors []string
+}
+
+func (t *recordingT) Errorf(format string, args ...any) {
+	t.errors = append(t.errors, fmt.Sprintf(format, args...))
+}
+
+const fixture = `sargantana:
+  server:
+    address: ${GOLDEN_ADDRESS}
+    session_name: session
+    session_secret: a_very_long_secret_key_for_testing_purposes
+    preflight:
+      readiness_path: /readyz
+  controllers:
+    - type: golden_items
+      name: items
+      priority: high
+      config:
+        resource: items
+`
+
+var _ = Describe("Golden", func() {
+	var configPath string
+
+	BeforeEach(func() {
+		server.RegisterController("golden_items", func(cfg *itemsConfig, _ server.ControllerContext) (server.IController, error) {
+			return &itemsController{resource: cfg.Resource}, nil
+		})
+		configPath = filepath.Join(GinkgoT().TempDir(), "config.yaml")
+		Expect(os.WriteFile(configPath, []byte(fixture), 0o600)).To(Succeed())
+		previous := Dir
+		Dir = GinkgoT().TempDir()
+		DeferCleanup(func() { Dir = previous })
+	})
+
+	It("should render the effective configuration with the environment of the fixture", func() {
+		cfg := Fixture(GinkgoT(), configPath, map[string]string{"GOLDEN_ADDRESS": "localhost:8080"})
+		rendered := string(EffectiveConfig(GinkgoT(), cfg))
+		Expect(rendered).To(ContainSubstring("address: localhost:8080 # env"))
+		Expect(rendered).To(ContainSubstring("session_secret: <redacted> # config file"))
+		Expect(rendered).NotTo(ContainSubstring("a_very_long_secret_key_for_testing_purposes"))
+		Expect(EffectiveConfig(GinkgoT(), Fixture(GinkgoT(), configPath, map[string]string{"GOLDEN_ADDRESS": "localhost:8080"}))).
+			To(Equal([]byte(rendered)))
+	})
+
+	It("should render the route table with the controller bindings", func() {
+		cfg := Fixture(GinkgoT(), configPath, map[string]string{"GOLDEN_ADDRESS": "localhost:0"})
+		Expect(string(RouteTable(GinkgoT(), cfg))).To(Equal(
+			"METHOD  PATH        CONTROLLER  TYPE          PRIORITY\n" +
+				"GET     /items      items       golden_items  high\n" +
+				"POST    /items/:id  items       golden_items  high\n" +
+				"GET     /readyz     -           -             -\n"))
+	})
+
+	It("should write the golden files when updating and compare them otherwise", func() {
+		GinkgoT().Setenv(UpdateEnv, "1")
+		Assert(GinkgoT(), "routes", []byte("GET /items\n"))
+		Expect(filepath.Join(Dir, "routes.golden")).To(BeAnExistingFile())
+
+		GinkgoT().Setenv(UpdateEnv, "")
+		t := &recordingT{FullGinkgoTInterface: GinkgoT()}
+		Assert(t, "routes", []byte("GET /items\n"))
+		Expect(t.errors).To(BeEmpty())
+		Assert(t, "routes", []byte("GET /items\nPOST /items/:id\n"))
+		Expect(t.errors).To(ConsistOf(And(ContainSubstring("routes.golden differs"), ContainSubstring("line 2:\n- \n+ POST /items/:id"))))
+	})
+
+	It("should describe the first differing line", func() {
+		Expect(Diff([]byte("a\nb\n"), []byte("a\nb\n"))).To(BeEmpty())
+		Expect(Diff([]byte("a\nb\n"), []byte("a\nc\n"))).To(Equal("line 2:\n- b\n+ c"))
+		Expect(Diff([]byte("a\nb\nc"), []byte("a\nb"))).To(Equal(`line 3: missing "c"`))
+	})
+})
@@ -0,0 +1,16 @@
+package logging
+
+// DefaultJournaldSocket is the systemd journal native protocol socket.
+const DefaultJournaldSocket = "/run/systemd/journal/socket"
+
+// JournaldConfig configures the journald sink.
+type JournaldConfig struct {
+	// Socket is the journal socket path, defaults to DefaultJournaldSocket.
+	Socket string `yaml:"socket,omitempty"`
+	// Identifier is the SYSLOG_IDENTIFIER of the records, defaults to the program name.
+	Identifier string `yaml:"identifier,omitempty"`
+}
+
+func (c JournaldConfig) Validate() error {
+	return nil
+}
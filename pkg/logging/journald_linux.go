@@ -0,0 +1,61 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+)
+
+// journaldWriter sends records to journald using its native protocol, so that the priority and
+// identifier are kept as structured journal fields.
+type journaldWriter struct {
+	conn       *net.UnixConn
+	identifier string
+}
+
+func newJournaldWriter(cfg JournaldConfig) (*journaldWriter, error) {
+	socket := cfg.Socket
+	if socket == "" {
+		socket = DefaultJournaldSocket
+	}
+	identifier := cfg.Identifier
+	if identifier == "" {
+		identifier = filepath.Base(os.Args[0])
+	}
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to connect to journald")
+	}
+	return &journaldWriter{conn: conn, identifier: identifier}, nil
+}
+
+func (w *journaldWriter) Write(p []byte) (int, error) {
+	return w.WriteLevel(zerolog.NoLevel, p)
+}
+
+func (w *journaldWriter) WriteLevel(level zerolog.Level, p []byte) (int, error) {
+	var datagram bytes.Buffer
+	datagram.WriteString("PRIORITY=" + strconv.Itoa(syslogSeverity(level)) + "\n")
+	datagram.WriteString("SYSLOG_IDENTIFIER=" + w.identifier + "\n")
+	// MESSAGE uses the binary-safe encoding, as records may contain newlines
+	message := bytes.TrimSuffix(p, []byte("\n"))
+	datagram.WriteString("MESSAGE\n")
+	_ = binary.Write(&datagram, binary.LittleEndian, uint64(len(message)))
+	datagram.Write(message)
+	datagram.WriteByte('\n')
+
+	if _, err := w.conn.Write(datagram.Bytes()); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (w *journaldWriter) Close() error {
+	return w.conn.Close()
+}
@@ -0,0 +1,45 @@
+//go:build unit
+
+package logging
+
+import (
+	"encoding/binary"
+	"net"
+	"path/filepath"
+	"strings"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/rs/zerolog"
+)
+
+var _ = Describe("Journald sink", func() {
+	It("should send records with their priority using the native protocol", func() {
+		socket := filepath.Join(GinkgoT().TempDir(), "journal.socket")
+		listener, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: socket, Net: "unixgram"})
+		Expect(err).NotTo(HaveOccurred())
+		defer listener.Close()
+
+		writer, err := newJournaldWriter(JournaldConfig{Socket: socket, Identifier: "gateway"})
+		Expect(err).NotTo(HaveOccurred())
+		defer writer.Close()
+
+		_, err = writer.WriteLevel(zerolog.ErrorLevel, []byte("line one\nline two\n"))
+		Expect(err).NotTo(HaveOccurred())
+
+		buffer := make([]byte, 4096)
+		n, err := listener.Read(buffer)
+		Expect(err).NotTo(HaveOccurred())
+		datagram := string(buffer[:n])
+		Expect(datagram).To(HavePrefix("PRIORITY=3\nSYSLOG_IDENTIFIER=gateway\nMESSAGE\n"))
+
+		encoded := datagram[strings.Index(datagram, "MESSAGE\n")+len("MESSAGE\n"):]
+		length := binary.LittleEndian.Uint64([]byte(encoded[:8]))
+		Expect(encoded[8 : 8+length]).To(Equal("line one\nline two"))
+	})
+
+	It("should fail without journald", func() {
+		_, err := newJournaldWriter(JournaldConfig{Socket: filepath.Join(GinkgoT().TempDir(), "missing.socket")})
+		Expect(err).To(MatchError(ContainSubstring("failed to connect to journald")))
+	})
+})
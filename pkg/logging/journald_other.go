@@ -0,0 +1,22 @@
+//go:build !linux
+
+package logging
+
+import (
+	"io"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+)
+
+type journaldWriter struct {
+	io.WriteCloser
+}
+
+func newJournaldWriter(JournaldConfig) (*journaldWriter, error) {
+	return nil, errors.New("journald output is only supported on Linux")
+}
+
+func (w *journaldWriter) WriteLevel(_ zerolog.Level, p []byte) (int, error) {
+	return w.Write(p)
+}
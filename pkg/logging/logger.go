@@ -0,0 +1,77 @@
+package logging
+
+import (
+	"context"
+	"log/slog"
+
+	"github.com/rs/zerolog"
+)
+
+// Logger is a minimal structured logger for code that should not depend on a specific logging
+// library. Fields are passed as alternating keys and values, as in log/slog.
+type Logger interface {
+	Debug(msg string, keyvals ...any)
+	Info(msg string, keyvals ...any)
+	Warn(msg string, keyvals ...any)
+	Error(msg string, keyvals ...any)
+	// With returns a Logger that includes the given fields in every record.
+	With(keyvals ...any) Logger
+}
+
+// FromZerolog adapts a zerolog logger to the Logger interface.
+func FromZerolog(logger zerolog.Logger) Logger {
+	return zerologLogger{logger: logger}
+}
+
+// FromSlog adapts a log/slog logger to the Logger interface.
+func FromSlog(logger *slog.Logger) Logger {
+	return slogLogger{logger: logger}
+}
+
+type zerologLogger struct {
+	logger zerolog.Logger
+}
+
+func (l zerologLogger) Debug(msg string, keyvals ...any) {
+	l.logger.Debug().Fields(keyvals).Msg(msg)
+}
+
+func (l zerologLogger) Info(msg string, keyvals ...any) {
+	l.logger.Info().Fields(keyvals).Msg(msg)
+}
+
+func (l zerologLogger) Warn(msg string, keyvals ...any) {
+	l.logger.Warn().Fields(keyvals).Msg(msg)
+}
+
+func (l zerologLogger) Error(msg string, keyvals ...any) {
+	l.logger.Error().Fields(keyvals).Msg(msg)
+}
+
+func (l zerologLogger) With(keyvals ...any) Logger {
+	return zerologLogger{logger: l.logger.With().Fields(keyvals).Logger()}
+}
+
+type slogLogger struct {
+	logger *slog.Logger
+}
+
+func (l slogLogger) Debug(msg string, keyvals ...any) {
+	l.logger.Log(context.Background(), slog.LevelDebug, msg, keyvals...)
+}
+
+func (l slogLogger) Info(msg string, keyvals ...any) {
+	l.logger.Log(context.Background(), slog.LevelInfo, msg, keyvals...)
+}
+
+func (l slogLogger) Warn(msg string, keyvals ...any) {
+	l.logger.Log(context.Background(), slog.LevelWarn, msg, keyvals...)
+}
+
+func (l slogLogger) Error(msg string, keyvals ...any) {
+	l.logger.Log(context.Background(), slog.LevelError, msg, keyvals...)
+}
+
+func (l slogLogger) With(keyvals ...any) Logger {
+	return slogLogger{logger: l.logger.With(keyvals...)}
+}
@@ -0,0 +1,353 @@
+// Package logging configures the framework logging. zerolog remains the logging backend, while
+// this package adds configurable output formats, per-module levels that can be changed at
+// runtime, a Logger interface for code that should not depend on zerolog directly and adapters
+// between zerolog and log/slog.
+package logging
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// FormatConsole writes human-readable, colored records. It is the default format.
+	FormatConsole = "console"
+	// FormatJSON writes one JSON object per record, suitable for log pipelines.
+	FormatJSON = "json"
+
+	// ModuleField is the field holding the module name in records logged through Module.
+	ModuleField = "module"
+
+	consoleTimeFormat = "2006-01-02 15:04:05"
+)
+
+// Config holds the logging configuration, read from the "logging" section.
+type Config struct {
+	// Format is the output format, either "console" (default) or "json".
+	Format string `yaml:"format,omitempty"`
+	// Level is the default level (trace, debug, info, warn, error or disabled). Defaults to info.
+	Level string `yaml:"level,omitempty"`
+	// Modules overrides the level for specific modules, keyed by module name. Controllers
+	// use their instance name as module name for request-scoped loggers.
+	Modules map[string]string `yaml:"modules,omitempty"`
+	// Sampling optionally drops part of the records to reduce the log volume.
+	Sampling *SamplingConfig `yaml:"sampling,omitempty"`
+	// NoColor disables colors in console output, even on a terminal.
+	NoColor bool `yaml:"no_color,omitempty"`
+	// Output selects where records are written: stdout (default), stderr, file, syslog or journald.
+	Output string `yaml:"output,omitempty"`
+	// File configures the file output, required when output is "file".
+	File *FileConfig `yaml:"file,omitempty"`
+	// Syslog configures the syslog output.
+	Syslog *SyslogConfig `yaml:"syslog,omitempty"`
+	// Journald configures the journald output.
+	Journald *JournaldConfig `yaml:"journald,omitempty"`
+	// Shipping optionally pushes access and audit records to OpenSearch or Loki.
+	Shipping *ShippingConfig `yaml:"shipping,omitempty"`
+}
+
+func (c Config) Validate() error {
+	switch c.Format {
+	case "", FormatConsole, FormatJSON:
+	default:
+		return errors.Errorf("unsupported log format %q, must be %q or %q", c.Format, FormatConsole, FormatJSON)
+	}
+	if _, err := parseLevel(c.Level); c.Level != "" && err != nil {
+		return err
+	}
+	for module, level := range c.Modules {
+		if module == "" {
+			return errors.New("module names must be non-empty")
+		}
+		if _, err := parseLevel(level); err != nil {
+			return errors.Wrapf(err, "invalid level for module %q", module)
+		}
+	}
+	if c.Sampling != nil {
+		if err := c.Sampling.Validate(); err != nil {
+			return errors.Wrap(err, "sampling configuration is invalid")
+		}
+	}
+	if c.Shipping != nil {
+		if err := c.Shipping.Validate(); err != nil {
+			return errors.Wrap(err, "shipping configuration is invalid")
+		}
+	}
+	return c.validateOutput()
+}
+
+func (c Config) validateOutput() error {
+	switch c.Output {
+	case "", OutputStdout, OutputStderr, OutputSyslog, OutputJournald:
+	case OutputFile:
+		if c.File == nil {
+			return errors.New("file output requires the file configuration")
+		}
+	default:
+		return errors.Errorf("unsupported log output %q", c.Output)
+	}
+	if c.File != nil {
+		if err := c.File.Validate(); err != nil {
+			return errors.Wrap(err, "file output configuration is invalid")
+		}
+	}
+	if c.Syslog != nil {
+		if err := c.Syslog.Validate(); err != nil {
+			return errors.Wrap(err, "syslog output configuration is invalid")
+		}
+	}
+	if c.Journald != nil {
+		if err := c.Journald.Validate(); err != nil {
+			return errors.Wrap(err, "journald output configuration is invalid")
+		}
+	}
+	return nil
+}
+
+// moduleKey is the context key holding the module name of a logger, see ForModule.
+type moduleKey struct{}
+
+var levels = struct {
+	sync.RWMutex
+	configured zerolog.Level // default level set by configuration, restored when leaving debug mode
+	current    zerolog.Level
+	modules    map[string]zerolog.Level
+}{
+	configured: zerolog.InfoLevel,
+	current:    zerolog.InfoLevel,
+	modules:    make(map[string]zerolog.Level),
+}
+
+// activeSampler is the sampler applied to every record, nil if sampling is disabled.
+var activeSampler atomic.Pointer[sampler]
+
+// activeSink is the sink of the configured logger, closed when it is replaced.
+var activeSink io.Closer
+
+// activeShipper ships access and audit records, nil if shipping is disabled.
+var activeShipper atomic.Pointer[Shipper]
+
+func init() {
+	// The filter hook is carried over by log.Output, so it survives later output changes
+	log.Logger = log.Logger.Hook(filterHook{})
+}
+
+// Configure replaces the global logger according to cfg. Records are written to out if not nil,
+// regardless of the configured output. The log/slog default logger is redirected to it. It must
+// be called at startup, before the global logger is used concurrently.
+func Configure(cfg Config, out io.Writer) error {
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+
+	var (
+		output   sink
+		terminal = true
+	)
+	if out != nil {
+		output = nopCloser{zerolog.LevelWriterAdapter{Writer: out}}
+	} else {
+		var err error
+		if output, terminal, err = newSink(cfg); err != nil {
+			return errors.Wrapf(err, "failed to open %s log output", cfg.Output)
+		}
+	}
+
+	var writer io.Writer = output
+	if cfg.Format != FormatJSON {
+		writer = consoleWriter{
+			console: zerolog.ConsoleWriter{TimeFormat: consoleTimeFormat, NoColor: !terminal || cfg.NoColor},
+			sink:    output,
+		}
+	}
+	log.Logger = zerolog.New(writer).With().Timestamp().Logger().Hook(filterHook{})
+
+	var shipper *Shipper
+	if cfg.Shipping != nil {
+		var err error
+		if shipper, err = NewShipper(*cfg.Shipping); err != nil {
+			_ = output.Close()
+			return errors.Wrap(err, "failed to configure log shipping")
+		}
+	}
+
+	if err := Close(); err != nil {
+		log.Warn().Err(err).Msg("Failed to close the previous log output")
+	}
+	activeSink = output
+	activeShipper.Store(shipper)
+
+	modules := make(map[string]zerolog.Level, len(cfg.Modules))
+	for module, level := range cfg.Modules {
+		modules[module], _ = parseLevel(level)
+	}
+	defaultLevel := zerolog.InfoLevel
+	if cfg.Level != "" {
+		defaultLevel, _ = parseLevel(cfg.Level)
+	}
+
+	slog.SetDefault(slog.New(NewSlogHandler(log.Logger)))
+
+	if cfg.Sampling != nil {
+		activeSampler.Store(newSampler(*cfg.Sampling))
+	} else {
+		activeSampler.Store(nil)
+	}
+
+	levels.Lock()
+	defer levels.Unlock()
+	levels.configured = defaultLevel
+	levels.current = defaultLevel
+	levels.modules = modules
+	updateGlobalLevel()
+	return nil
+}
+
+// Close flushes the shipped records and closes the configured log output, such as the log file or
+// the syslog connection. Records logged afterwards through the global logger are lost.
+func Close() error {
+	if shipper := activeShipper.Swap(nil); shipper != nil {
+		_ = shipper.Close()
+	}
+	if activeSink == nil {
+		return nil
+	}
+	err := activeSink.Close()
+	activeSink = nil
+	return err
+}
+
+// Ship queues a record for the configured log shipping backends. It does nothing if shipping is
+// disabled. See StreamAccess and StreamAudit.
+func Ship(stream string, fields map[string]any) {
+	if shipper := activeShipper.Load(); shipper != nil {
+		shipper.Ship(stream, fields)
+	}
+}
+
+// ShippingEnabled tells whether log shipping is configured.
+func ShippingEnabled() bool {
+	return activeShipper.Load() != nil
+}
+
+// SetDebug lowers the default level to debug, or restores the configured default level.
+func SetDebug(enabled bool) {
+	levels.Lock()
+	defer levels.Unlock()
+	if enabled {
+		levels.current = zerolog.DebugLevel
+	} else {
+		levels.current = levels.configured
+	}
+	updateGlobalLevel()
+}
+
+// SetLevel changes the level of a module at runtime. An empty module changes the default level,
+// and an empty level removes the module override so it follows the default level again.
+func SetLevel(module, level string) error {
+	levels.Lock()
+	defer levels.Unlock()
+	if level == "" {
+		if module == "" {
+			return errors.New("the default level cannot be removed")
+		}
+		delete(levels.modules, module)
+		updateGlobalLevel()
+		return nil
+	}
+
+	parsed, err := parseLevel(level)
+	if err != nil {
+		return err
+	}
+	if module == "" {
+		levels.current = parsed
+	} else {
+		levels.modules[module] = parsed
+	}
+	updateGlobalLevel()
+	return nil
+}
+
+// Levels returns the current levels. The default level is reported under the empty module name.
+func Levels() map[string]string {
+	levels.RLock()
+	defer levels.RUnlock()
+	result := make(map[string]string, len(levels.modules)+1)
+	result[""] = levels.current.String()
+	for module, level := range levels.modules {
+		result[module] = level.String()
+	}
+	return result
+}
+
+// Module returns a child of the global logger that includes the module name and is filtered by
+// the module level.
+func Module(name string) zerolog.Logger {
+	return ForModule(log.With().Str(ModuleField, name).Logger(), name)
+}
+
+// ForModule returns a copy of logger filtered by the level of the given module. The logger must
+// be derived from the global logger, which carries the filter. It replaces the context attached
+// to the logger with zerolog.Context.Ctx.
+func ForModule(logger zerolog.Logger, name string) zerolog.Logger {
+	return logger.With().Ctx(context.WithValue(context.Background(), moduleKey{}, name)).Logger()
+}
+
+// filterHook discards records below the level of the module of the logger (or below the default
+// level for loggers without module), then applies sampling.
+type filterHook struct{}
+
+func (filterHook) Run(e *zerolog.Event, level zerolog.Level, msg string) {
+	if level == zerolog.NoLevel || level == zerolog.Disabled {
+		return
+	}
+	module, _ := e.GetCtx().Value(moduleKey{}).(string)
+	if level < levelOf(module) {
+		e.Discard()
+		return
+	}
+	if s := activeSampler.Load(); s != nil {
+		keep, suppressed := s.sample(level, msg)
+		if !keep {
+			e.Discard()
+		} else if suppressed > 0 {
+			e.Int(SuppressedField, suppressed)
+		}
+	}
+}
+
+func levelOf(module string) zerolog.Level {
+	levels.RLock()
+	defer levels.RUnlock()
+	if level, ok := levels.modules[module]; ok && module != "" {
+		return level
+	}
+	return levels.current
+}
+
+// updateGlobalLevel lowers the zerolog global level to the most verbose configured level, so
+// that records reach the level hook. Must be called with the lock held.
+func updateGlobalLevel() {
+	lowest := levels.current
+	for _, level := range levels.modules {
+		lowest = min(lowest, level)
+	}
+	zerolog.SetGlobalLevel(lowest)
+}
+
+func parseLevel(level string) (zerolog.Level, error) {
+	parsed, err := zerolog.ParseLevel(strings.ToLower(level))
+	if err != nil || parsed == zerolog.NoLevel {
+		return zerolog.NoLevel, errors.Errorf("invalid log level %q", level)
+	}
+	return parsed, nil
+}
@@ -0,0 +1,177 @@
+//go:build unit
+
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"strings"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+var _ = Describe("Logging", func() {
+	var (
+		output         *bytes.Buffer
+		originalLogger zerolog.Logger
+		originalSlog   *slog.Logger
+	)
+
+	BeforeEach(func() {
+		originalLogger = log.Logger
+		originalSlog = slog.Default()
+		output = &bytes.Buffer{}
+		Expect(Configure(Config{Format: FormatJSON}, output)).To(Succeed())
+		DeferCleanup(func() {
+			// Reset the levels, then restore the original loggers
+			Expect(Configure(Config{}, nil)).To(Succeed())
+			log.Logger = originalLogger
+			slog.SetDefault(originalSlog)
+		})
+	})
+
+	records := func() []map[string]interface{} {
+		var result []map[string]interface{}
+		for _, line := range strings.Split(strings.TrimSpace(output.String()), "\n") {
+			if line == "" {
+				continue
+			}
+			var record map[string]interface{}
+			Expect(json.Unmarshal([]byte(line), &record)).To(Succeed())
+			result = append(result, record)
+		}
+		return result
+	}
+
+	Context("Config", func() {
+		It("should accept valid configurations", func() {
+			Expect(Config{}.Validate()).To(Succeed())
+			Expect(Config{Format: FormatConsole, Level: "WARN", Modules: map[string]string{"api": "trace"}}.Validate()).To(Succeed())
+		})
+
+		It("should reject invalid formats and levels", func() {
+			Expect(Config{Format: "xml"}.Validate()).To(MatchError(ContainSubstring("unsupported log format")))
+			Expect(Config{Level: "verbose"}.Validate()).To(MatchError(ContainSubstring("invalid log level")))
+			Expect(Config{Modules: map[string]string{"api": ""}}.Validate()).To(HaveOccurred())
+			Expect(Config{Modules: map[string]string{"": "debug"}}.Validate()).To(HaveOccurred())
+		})
+
+		It("should write console output by default", func() {
+			Expect(Configure(Config{}, output)).To(Succeed())
+			log.Info().Msg("console record")
+			Expect(output.String()).To(ContainSubstring("console record"))
+			Expect(json.Valid(output.Bytes())).To(BeFalse())
+		})
+	})
+
+	Context("Levels", func() {
+		It("should filter the global logger by the default level", func() {
+			log.Debug().Msg("hidden")
+			log.Info().Msg("shown")
+			Expect(records()).To(HaveLen(1))
+			Expect(records()[0]["message"]).To(Equal("shown"))
+		})
+
+		It("should apply module levels", func() {
+			Expect(Configure(Config{Format: FormatJSON, Level: "warn", Modules: map[string]string{"api": "debug"}}, output)).To(Succeed())
+
+			api := Module("api")
+			api.Debug().Msg("api debug")
+			other := Module("other")
+			other.Info().Msg("other info")
+			log.Info().Msg("default info")
+
+			Expect(records()).To(HaveLen(1))
+			Expect(records()[0]).To(HaveKeyWithValue("message", "api debug"))
+			Expect(records()[0]).To(HaveKeyWithValue(ModuleField, "api"))
+		})
+
+		It("should change levels at runtime", func() {
+			api := Module("api")
+			api.Debug().Msg("before")
+
+			Expect(SetLevel("api", "debug")).To(Succeed())
+			api.Debug().Msg("after")
+			Expect(Levels()).To(Equal(map[string]string{"": "info", "api": "debug"}))
+
+			Expect(SetLevel("api", "")).To(Succeed())
+			api.Debug().Msg("removed")
+			Expect(Levels()).To(Equal(map[string]string{"": "info"}))
+
+			Expect(SetLevel("", "error")).To(Succeed())
+			log.Warn().Msg("default warn")
+
+			Expect(records()).To(HaveLen(1))
+			Expect(records()[0]["message"]).To(Equal("after"))
+		})
+
+		It("should reject invalid runtime changes", func() {
+			Expect(SetLevel("api", "loud")).To(HaveOccurred())
+			Expect(SetLevel("", "")).To(HaveOccurred())
+		})
+
+		It("should toggle debug and restore the configured level", func() {
+			Expect(Configure(Config{Format: FormatJSON, Level: "warn"}, output)).To(Succeed())
+			SetDebug(true)
+			Expect(zerolog.GlobalLevel()).To(Equal(zerolog.DebugLevel))
+			SetDebug(false)
+			Expect(Levels()[""]).To(Equal("warn"))
+		})
+	})
+
+	Context("Logger adapters", func() {
+		It("should log through zerolog", func() {
+			logger := FromZerolog(log.Logger).With("component", "test")
+			logger.Info("zerolog record", "count", 3)
+			logger.Debug("hidden")
+
+			Expect(records()).To(HaveLen(1))
+			Expect(records()[0]).To(HaveKeyWithValue("component", "test"))
+			Expect(records()[0]).To(HaveKeyWithValue("count", BeNumerically("==", 3)))
+		})
+
+		It("should log through slog", func() {
+			var buffer bytes.Buffer
+			logger := FromSlog(slog.New(slog.NewJSONHandler(&buffer, nil))).With("component", "test")
+			logger.Warn("slog record", "count", 3)
+			Expect(buffer.String()).To(ContainSubstring(`"msg":"slog record"`))
+			Expect(buffer.String()).To(ContainSubstring(`"component":"test"`))
+		})
+	})
+
+	Context("slog bridge", func() {
+		It("should redirect the slog default logger", func() {
+			slog.With("component", "library").WithGroup("request").Info("from slog",
+				"status", 200,
+				"elapsed", time.Second,
+				"err", errors.New("boom"),
+				slog.Group("user", "id", "u1"),
+			)
+			slog.Debug("hidden")
+
+			Expect(records()).To(HaveLen(1))
+			record := records()[0]
+			Expect(record).To(HaveKeyWithValue("message", "from slog"))
+			Expect(record).To(HaveKeyWithValue("level", "info"))
+			Expect(record).To(HaveKeyWithValue("component", "library"))
+			Expect(record).To(HaveKeyWithValue("request.status", BeNumerically("==", 200)))
+			Expect(record).To(HaveKeyWithValue("request.err", "boom"))
+			Expect(record).To(HaveKeyWithValue("request.user.id", "u1"))
+			Expect(record).To(HaveKey("request.elapsed"))
+		})
+
+		It("should map slog levels", func() {
+			Expect(zerologLevel(slog.LevelError + 4)).To(Equal(zerolog.ErrorLevel))
+			Expect(zerologLevel(slog.LevelWarn)).To(Equal(zerolog.WarnLevel))
+			Expect(zerologLevel(slog.LevelInfo)).To(Equal(zerolog.InfoLevel))
+			Expect(zerologLevel(slog.LevelDebug)).To(Equal(zerolog.DebugLevel))
+			Expect(zerologLevel(slog.LevelDebug - 4)).To(Equal(zerolog.TraceLevel))
+		})
+	})
+})
@@ -0,0 +1,241 @@
+package logging
+
+import (
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+)
+
+// backupTimeFormat is the timestamp appended to rotated file names. It sorts lexically.
+const backupTimeFormat = "20060102T150405.000"
+
+// FileConfig configures the file sink and its rotation.
+type FileConfig struct {
+	// Path is the log file path. Rotated files are stored next to it as
+	// <name>-<timestamp><ext>, optionally gzip-compressed.
+	Path string `yaml:"path"`
+	// MaxSizeMB rotates the file when it reaches the given size in megabytes. Zero disables
+	// size-based rotation.
+	MaxSizeMB int `yaml:"max_size_mb,omitempty"`
+	// RotateEvery rotates the file periodically, e.g. 24h. Zero disables time-based rotation.
+	RotateEvery time.Duration `yaml:"rotate_every,omitempty"`
+	// MaxBackups is the maximum number of rotated files kept. Zero keeps all of them.
+	MaxBackups int `yaml:"max_backups,omitempty"`
+	// MaxAge removes rotated files older than the given duration. Zero keeps them regardless of age.
+	MaxAge time.Duration `yaml:"max_age,omitempty"`
+	// Compress gzips rotated files.
+	Compress bool `yaml:"compress,omitempty"`
+}
+
+func (c FileConfig) Validate() error {
+	if c.Path == "" {
+		return errors.New("path must be set and non-empty")
+	}
+	if c.MaxSizeMB < 0 || c.MaxBackups < 0 || c.RotateEvery < 0 || c.MaxAge < 0 {
+		return errors.New("max_size_mb, rotate_every, max_backups and max_age must not be negative")
+	}
+	return nil
+}
+
+// rotatingFile is an io.WriteCloser writing to a file rotated by size and/or age. Compression and
+// removal of old files run in the background.
+type rotatingFile struct {
+	config FileConfig
+	now    func() time.Time
+
+	mu       sync.Mutex
+	file     *os.File
+	size     int64
+	openedAt time.Time
+	cleanup  sync.WaitGroup
+}
+
+func newRotatingFile(cfg FileConfig) (*rotatingFile, error) {
+	r := &rotatingFile{config: cfg, now: time.Now}
+	if err := os.MkdirAll(filepath.Dir(cfg.Path), 0o750); err != nil {
+		return nil, errors.Wrap(err, "failed to create log directory")
+	}
+	if err := r.open(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *rotatingFile) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.file == nil {
+		return 0, os.ErrClosed
+	}
+	if r.shouldRotate(len(p)) {
+		if err := r.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := r.file.Write(p)
+	r.size += int64(n)
+	return n, err
+}
+
+// Close closes the file and waits for pending compression and cleanup.
+func (r *rotatingFile) Close() error {
+	r.mu.Lock()
+	var err error
+	if r.file != nil {
+		err = r.file.Close()
+		r.file = nil
+	}
+	r.mu.Unlock()
+	r.cleanup.Wait()
+	return err
+}
+
+func (r *rotatingFile) shouldRotate(pending int) bool {
+	if r.size == 0 {
+		return false
+	}
+	if r.config.MaxSizeMB > 0 && r.size+int64(pending) > int64(r.config.MaxSizeMB)*1024*1024 {
+		return true
+	}
+	return r.config.RotateEvery > 0 && r.now().Sub(r.openedAt) >= r.config.RotateEvery
+}
+
+// open opens the log file for appending. Must be called with the lock held.
+func (r *rotatingFile) open() error {
+	// #nosec G304 -- Log file path is provided by operator configuration
+	file, err := os.OpenFile(r.config.Path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o640)
+	if err != nil {
+		return errors.Wrap(err, "failed to open log file")
+	}
+	info, err := file.Stat()
+	if err != nil {
+		_ = file.Close()
+		return errors.Wrap(err, "failed to stat log file")
+	}
+	r.file = file
+	r.size = info.Size()
+	r.openedAt = r.now()
+	return nil
+}
+
+// rotate moves the current file aside and opens a new one. Must be called with the lock held.
+func (r *rotatingFile) rotate() error {
+	if err := r.file.Close(); err != nil {
+		return errors.Wrap(err, "failed to close log file")
+	}
+	backup := r.backupName(r.now())
+	if err := os.Rename(r.config.Path, backup); err != nil {
+		return errors.Wrap(err, "failed to rotate log file")
+	}
+	if err := r.open(); err != nil {
+		return err
+	}
+
+	r.cleanup.Add(1)
+	go func() {
+		defer r.cleanup.Done()
+		r.postRotate(backup)
+	}()
+	return nil
+}
+
+func (r *rotatingFile) backupName(t time.Time) string {
+	ext := filepath.Ext(r.config.Path)
+	return strings.TrimSuffix(r.config.Path, ext) + "-" + t.Format(backupTimeFormat) + ext
+}
+
+// postRotate compresses the rotated file and removes old backups. Errors are reported through the
+// global logger, as they must not stop logging.
+func (r *rotatingFile) postRotate(backup string) {
+	if r.config.Compress {
+		if err := compressFile(backup); err != nil {
+			log.Error().Err(err).Str("file", backup).Msg("Failed to compress rotated log file")
+		}
+	}
+	if err := r.prune(); err != nil {
+		log.Error().Err(err).Msg("Failed to remove old log files")
+	}
+}
+
+// prune removes the backups beyond MaxBackups or older than MaxAge.
+func (r *rotatingFile) prune() error {
+	if r.config.MaxBackups == 0 && r.config.MaxAge == 0 {
+		return nil
+	}
+	ext := filepath.Ext(r.config.Path)
+	prefix := filepath.Base(strings.TrimSuffix(r.config.Path, ext)) + "-"
+	entries, err := os.ReadDir(filepath.Dir(r.config.Path))
+	if err != nil {
+		return err
+	}
+
+	var backups []string
+	for _, entry := range entries {
+		name := entry.Name()
+		stamp, ok := strings.CutPrefix(name, prefix)
+		if !ok || entry.IsDir() {
+			continue
+		}
+		stamp = strings.TrimSuffix(strings.TrimSuffix(stamp, ".gz"), ext)
+		if _, err := time.Parse(backupTimeFormat, stamp); err == nil {
+			backups = append(backups, name)
+		}
+	}
+	// Newest first
+	sort.Sort(sort.Reverse(sort.StringSlice(backups)))
+
+	var errs []string
+	for i, name := range backups {
+		path := filepath.Join(filepath.Dir(r.config.Path), name)
+		remove := r.config.MaxBackups > 0 && i >= r.config.MaxBackups
+		if !remove && r.config.MaxAge > 0 {
+			if info, err := os.Stat(path); err == nil && r.now().Sub(info.ModTime()) > r.config.MaxAge {
+				remove = true
+			}
+		}
+		if remove {
+			if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+				errs = append(errs, err.Error())
+			}
+		}
+	}
+	if len(errs) > 0 {
+		return errors.New(strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+func compressFile(path string) (err error) {
+	// #nosec G304 -- Rotated log file path derived from operator configuration
+	source, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = source.Close() }()
+
+	// #nosec G304 -- Rotated log file path derived from operator configuration
+	target, err := os.OpenFile(path+".gz", os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o640)
+	if err != nil {
+		return err
+	}
+	writer := gzip.NewWriter(target)
+	if _, err = io.Copy(writer, source); err == nil {
+		err = writer.Close()
+	}
+	if closeErr := target.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.Remove(path + ".gz")
+		return err
+	}
+	return os.Remove(path)
+}
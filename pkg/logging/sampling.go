@@ -0,0 +1,134 @@
+package logging
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/rs/zerolog"
+)
+
+const (
+	// DefaultErrorPeriod is the default window for error rate limiting.
+	DefaultErrorPeriod = time.Minute
+
+	// SuppressedField holds the number of identical records suppressed in the previous window.
+	// It is added to the first record logged after a window in which records were suppressed.
+	SuppressedField = "suppressed"
+
+	// maxTrackedMessages bounds the number of distinct messages tracked per window. Messages
+	// beyond the limit are not rate limited.
+	maxTrackedMessages = 10000
+)
+
+var suppressedRecords = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "sargantana_log_records_suppressed_total",
+	Help: "Number of log records dropped by sampling or rate limiting.",
+}, []string{"reason", "level"})
+
+// SamplingConfig reduces the log volume of high-traffic servers.
+type SamplingConfig struct {
+	// Every logs only 1 of every N records for the given levels, e.g. {debug: 100}.
+	Every map[string]uint32 `yaml:"every,omitempty"`
+	// ErrorBurst is the maximum number of records with the same message logged per ErrorPeriod
+	// at error level or above. Zero disables rate limiting.
+	ErrorBurst int `yaml:"error_burst,omitempty"`
+	// ErrorPeriod is the rate limiting window. Defaults to DefaultErrorPeriod.
+	ErrorPeriod time.Duration `yaml:"error_period,omitempty"`
+}
+
+func (c SamplingConfig) Validate() error {
+	for level, n := range c.Every {
+		if _, err := parseLevel(level); err != nil {
+			return errors.Wrap(err, "invalid sampling level")
+		}
+		if n == 0 {
+			return errors.Errorf("sampling rate for level %q must be greater than zero", level)
+		}
+	}
+	if c.ErrorBurst < 0 {
+		return errors.New("error_burst must not be negative")
+	}
+	if c.ErrorPeriod < 0 {
+		return errors.New("error_period must not be negative")
+	}
+	return nil
+}
+
+// sampler decides which records are dropped by sampling or rate limiting.
+type sampler struct {
+	every    map[zerolog.Level]uint32
+	counters map[zerolog.Level]*atomic.Uint32
+	burst    int
+	period   time.Duration
+	now      func() time.Time
+
+	mu          sync.Mutex
+	windowStart time.Time
+	seen        map[string]int // records per message in the current window
+	carried     map[string]int // suppressed records per message in the previous window
+}
+
+func newSampler(cfg SamplingConfig) *sampler {
+	s := &sampler{
+		every:    make(map[zerolog.Level]uint32, len(cfg.Every)),
+		counters: make(map[zerolog.Level]*atomic.Uint32, len(cfg.Every)),
+		burst:    cfg.ErrorBurst,
+		period:   cfg.ErrorPeriod,
+		now:      time.Now,
+		seen:     make(map[string]int),
+	}
+	if s.period == 0 {
+		s.period = DefaultErrorPeriod
+	}
+	for level, n := range cfg.Every {
+		parsed, _ := parseLevel(level)
+		s.every[parsed] = n
+		s.counters[parsed] = new(atomic.Uint32)
+	}
+	return s
+}
+
+// sample returns whether a record should be logged and, for rate limited messages, the number
+// of records suppressed in the previous window.
+func (s *sampler) sample(level zerolog.Level, msg string) (bool, int) {
+	if n, ok := s.every[level]; ok && n > 1 {
+		if s.counters[level].Add(1)%n != 1 {
+			suppressedRecords.WithLabelValues("sampled", level.String()).Inc()
+			return false, 0
+		}
+	}
+
+	if s.burst == 0 || level < zerolog.ErrorLevel {
+		return true, 0
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if now := s.now(); now.Sub(s.windowStart) >= s.period {
+		s.carried = make(map[string]int)
+		for message, count := range s.seen {
+			if count > s.burst {
+				s.carried[message] = count - s.burst
+			}
+		}
+		s.seen = make(map[string]int)
+		s.windowStart = now
+	}
+
+	count, tracked := s.seen[msg]
+	if !tracked && len(s.seen) >= maxTrackedMessages {
+		return true, 0
+	}
+	s.seen[msg] = count + 1
+	if count >= s.burst {
+		suppressedRecords.WithLabelValues("rate_limited", level.String()).Inc()
+		return false, 0
+	}
+	suppressed := s.carried[msg]
+	delete(s.carried, msg)
+	return true, suppressed
+}
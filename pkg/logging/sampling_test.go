@@ -0,0 +1,126 @@
+//go:build unit
+
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"strings"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+var _ = Describe("Sampling", func() {
+	var (
+		output         *bytes.Buffer
+		originalLogger zerolog.Logger
+		originalSlog   *slog.Logger
+	)
+
+	BeforeEach(func() {
+		originalLogger = log.Logger
+		originalSlog = slog.Default()
+		output = &bytes.Buffer{}
+		DeferCleanup(func() {
+			Expect(Configure(Config{}, nil)).To(Succeed())
+			log.Logger = originalLogger
+			slog.SetDefault(originalSlog)
+		})
+	})
+
+	records := func() []map[string]interface{} {
+		var result []map[string]interface{}
+		for _, line := range strings.Split(strings.TrimSpace(output.String()), "\n") {
+			if line == "" {
+				continue
+			}
+			var record map[string]interface{}
+			Expect(json.Unmarshal([]byte(line), &record)).To(Succeed())
+			result = append(result, record)
+		}
+		return result
+	}
+
+	It("should validate the configuration", func() {
+		Expect(SamplingConfig{Every: map[string]uint32{"debug": 10}, ErrorBurst: 5}.Validate()).To(Succeed())
+		Expect(SamplingConfig{Every: map[string]uint32{"debug": 0}}.Validate()).To(HaveOccurred())
+		Expect(SamplingConfig{Every: map[string]uint32{"chatty": 2}}.Validate()).To(HaveOccurred())
+		Expect(SamplingConfig{ErrorBurst: -1}.Validate()).To(HaveOccurred())
+		Expect(SamplingConfig{ErrorPeriod: -time.Second}.Validate()).To(HaveOccurred())
+		Expect(Config{Sampling: &SamplingConfig{ErrorBurst: -1}}.Validate()).To(MatchError(ContainSubstring("sampling")))
+	})
+
+	It("should log 1 of every N records", func() {
+		sampled := suppressedRecords.WithLabelValues("sampled", "info")
+		before := testutil.ToFloat64(sampled)
+		Expect(Configure(Config{Format: FormatJSON, Sampling: &SamplingConfig{Every: map[string]uint32{"info": 3}}}, output)).To(Succeed())
+
+		for i := 0; i < 7; i++ {
+			log.Info().Int("i", i).Msg("sampled")
+		}
+		log.Warn().Msg("not sampled")
+
+		Expect(records()).To(HaveLen(4))
+		Expect(records()[0]["i"]).To(BeNumerically("==", 0))
+		Expect(records()[1]["i"]).To(BeNumerically("==", 3))
+		Expect(records()[2]["i"]).To(BeNumerically("==", 6))
+		Expect(testutil.ToFloat64(sampled) - before).To(Equal(4.0))
+	})
+
+	It("should not count records filtered by level", func() {
+		sampled := suppressedRecords.WithLabelValues("sampled", "debug")
+		before := testutil.ToFloat64(sampled)
+		Expect(Configure(Config{Format: FormatJSON, Sampling: &SamplingConfig{Every: map[string]uint32{"debug": 2}}}, output)).To(Succeed())
+
+		log.Debug().Msg("below the default level")
+		log.Debug().Msg("below the default level")
+		Expect(output.String()).To(BeEmpty())
+		Expect(testutil.ToFloat64(sampled)).To(Equal(before))
+	})
+
+	It("should rate limit identical error messages", func() {
+		limited := suppressedRecords.WithLabelValues("rate_limited", "error")
+		before := testutil.ToFloat64(limited)
+		Expect(Configure(Config{Format: FormatJSON, Sampling: &SamplingConfig{ErrorBurst: 2, ErrorPeriod: time.Minute}}, output)).To(Succeed())
+		now := time.Now()
+		activeSampler.Load().now = func() time.Time { return now }
+
+		for i := 0; i < 5; i++ {
+			log.Error().Msg("database unavailable")
+		}
+		log.Error().Msg("other error")
+		for i := 0; i < 5; i++ {
+			log.Warn().Msg("warnings are not limited")
+		}
+		Expect(records()).To(HaveLen(8))
+		Expect(testutil.ToFloat64(limited) - before).To(Equal(3.0))
+
+		output.Reset()
+		now = now.Add(time.Minute)
+		log.Error().Msg("database unavailable")
+		Expect(records()).To(HaveLen(1))
+		Expect(records()[0]).To(HaveKeyWithValue(SuppressedField, BeNumerically("==", 3)))
+
+		output.Reset()
+		log.Error().Msg("database unavailable")
+		Expect(records()[0]).NotTo(HaveKey(SuppressedField))
+	})
+
+	It("should stop tracking messages beyond the limit", func() {
+		s := newSampler(SamplingConfig{ErrorBurst: 1})
+		s.windowStart = time.Now()
+		for i := 0; i < maxTrackedMessages; i++ {
+			s.seen[string(rune(i))] = 1
+		}
+		keep, _ := s.sample(zerolog.ErrorLevel, "untracked")
+		Expect(keep).To(BeTrue())
+		keep, _ = s.sample(zerolog.ErrorLevel, "untracked")
+		Expect(keep).To(BeTrue())
+	})
+})
@@ -0,0 +1,406 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/rs/zerolog/log"
+)
+
+// Streams of shipped records.
+const (
+	// StreamAccess holds one record per HTTP request handled by the server.
+	StreamAccess = "access"
+	// StreamAudit holds security relevant events, such as logins or configuration changes.
+	StreamAudit = "audit"
+)
+
+const (
+	defaultShippingBufferSize    = 10000
+	defaultShippingBatchSize     = 500
+	defaultShippingFlushInterval = 5 * time.Second
+	defaultShippingMaxRetries    = 3
+	defaultShippingRetryBackoff  = time.Second
+	defaultShippingTimeout       = 10 * time.Second
+
+	// DefaultOpenSearchIndex is the default OpenSearch index name pattern.
+	DefaultOpenSearchIndex = "sargantana-{stream}-{date}"
+)
+
+var (
+	shippedRecords = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "sargantana_log_shipping_records_total",
+		Help: "Number of records sent to log shipping backends, by result.",
+	}, []string{"backend", "result"})
+	droppedShippingRecords = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "sargantana_log_shipping_dropped_total",
+		Help: "Number of records dropped because the shipping buffer was full.",
+	})
+)
+
+// ShippingConfig configures the bulk shipping of access and audit records.
+type ShippingConfig struct {
+	OpenSearch *OpenSearchConfig `yaml:"opensearch,omitempty"`
+	Loki       *LokiConfig       `yaml:"loki,omitempty"`
+	// BufferSize is the number of records buffered while backends are slow or unavailable.
+	// Records are dropped, never blocking requests, when the buffer is full. Defaults to 10000.
+	BufferSize int `yaml:"buffer_size,omitempty"`
+	// BatchSize is the maximum number of records per bulk request. Defaults to 500.
+	BatchSize int `yaml:"batch_size,omitempty"`
+	// FlushInterval is the maximum time a record waits in the buffer. Defaults to 5s.
+	FlushInterval time.Duration `yaml:"flush_interval,omitempty"`
+	// MaxRetries is the number of retries of a failed bulk request. Defaults to 3.
+	MaxRetries int `yaml:"max_retries,omitempty"`
+	// RetryBackoff is the delay before the first retry, doubled on each retry. Defaults to 1s.
+	RetryBackoff time.Duration `yaml:"retry_backoff,omitempty"`
+	// Timeout of each bulk request. Defaults to 10s.
+	Timeout time.Duration `yaml:"timeout,omitempty"`
+}
+
+// OpenSearchConfig configures shipping to the OpenSearch (or Elasticsearch) bulk API.
+type OpenSearchConfig struct {
+	URL string `yaml:"url"`
+	// Index is the index name. The {stream} and {date} (UTC, yyyy.mm.dd) placeholders are
+	// replaced for each record. Defaults to DefaultOpenSearchIndex.
+	Index    string `yaml:"index,omitempty"`
+	Username string `yaml:"username,omitempty"`
+	Password string `yaml:"password,omitempty"`
+}
+
+// LokiConfig configures shipping to the Grafana Loki push API.
+type LokiConfig struct {
+	URL string `yaml:"url"`
+	// TenantID is sent as X-Scope-OrgID for multi-tenant Loki deployments.
+	TenantID string `yaml:"tenant_id,omitempty"`
+	// Labels are added to every stream, along with the "stream" label.
+	Labels   map[string]string `yaml:"labels,omitempty"`
+	Username string            `yaml:"username,omitempty"`
+	Password string            `yaml:"password,omitempty"`
+}
+
+func (c ShippingConfig) Validate() error {
+	if c.OpenSearch == nil && c.Loki == nil {
+		return errors.New("at least one of opensearch or loki must be configured")
+	}
+	if c.OpenSearch != nil {
+		if _, err := url.ParseRequestURI(c.OpenSearch.URL); err != nil {
+			return errors.Wrap(err, "invalid opensearch url")
+		}
+	}
+	if c.Loki != nil {
+		if _, err := url.ParseRequestURI(c.Loki.URL); err != nil {
+			return errors.Wrap(err, "invalid loki url")
+		}
+		if _, ok := c.Loki.Labels["stream"]; ok {
+			return errors.New("the loki stream label is reserved")
+		}
+	}
+	if c.BufferSize < 0 || c.BatchSize < 0 || c.MaxRetries < 0 || c.FlushInterval < 0 || c.RetryBackoff < 0 || c.Timeout < 0 {
+		return errors.New("buffer_size, batch_size, max_retries, flush_interval, retry_backoff and timeout must not be negative")
+	}
+	return nil
+}
+
+// shippedRecord is a record waiting to be shipped.
+type shippedRecord struct {
+	stream string
+	time   time.Time
+	body   []byte // JSON object
+}
+
+// shippingBackend sends a batch of records in a single bulk request.
+type shippingBackend interface {
+	name() string
+	send(client *http.Client, batch []shippedRecord) error
+}
+
+// retryableError marks bulk request failures that may succeed when retried.
+type retryableError struct {
+	error
+}
+
+// Shipper buffers access and audit records and ships them in batches to the configured backends.
+// Shipping never blocks the caller: records are dropped when the buffer is full.
+type Shipper struct {
+	config   ShippingConfig
+	backends []shippingBackend
+	client   *http.Client
+	records  chan shippedRecord
+
+	closeOnce sync.Once
+	done      chan struct{}
+	stopped   chan struct{}
+}
+
+// NewShipper creates a Shipper and starts its background flushing.
+func NewShipper(cfg ShippingConfig) (*Shipper, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	if cfg.BufferSize == 0 {
+		cfg.BufferSize = defaultShippingBufferSize
+	}
+	if cfg.BatchSize == 0 {
+		cfg.BatchSize = defaultShippingBatchSize
+	}
+	if cfg.FlushInterval == 0 {
+		cfg.FlushInterval = defaultShippingFlushInterval
+	}
+	if cfg.MaxRetries == 0 {
+		cfg.MaxRetries = defaultShippingMaxRetries
+	}
+	if cfg.RetryBackoff == 0 {
+		cfg.RetryBackoff = defaultShippingRetryBackoff
+	}
+	if cfg.Timeout == 0 {
+		cfg.Timeout = defaultShippingTimeout
+	}
+
+	s := &Shipper{
+		config:  cfg,
+		client:  &http.Client{Timeout: cfg.Timeout},
+		records: make(chan shippedRecord, cfg.BufferSize),
+		done:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+	if cfg.OpenSearch != nil {
+		s.backends = append(s.backends, openSearchBackend{config: *cfg.OpenSearch})
+	}
+	if cfg.Loki != nil {
+		s.backends = append(s.backends, lokiBackend{config: *cfg.Loki})
+	}
+	go s.run()
+	return s, nil
+}
+
+// Ship queues a record of the given stream. The record time is added as "@timestamp".
+func (s *Shipper) Ship(stream string, fields map[string]any) {
+	now := time.Now()
+	record := make(map[string]any, len(fields)+2)
+	for key, value := range fields {
+		record[key] = value
+	}
+	record["@timestamp"] = now.UTC().Format(time.RFC3339Nano)
+	record["stream"] = stream
+	body, err := json.Marshal(record)
+	if err != nil {
+		log.Error().Err(err).Str("stream", stream).Msg("Failed to encode shipped log record")
+		return
+	}
+
+	select {
+	case <-s.done:
+		droppedShippingRecords.Inc()
+	case s.records <- shippedRecord{stream: stream, time: now, body: body}:
+	default:
+		droppedShippingRecords.Inc()
+	}
+}
+
+// Close flushes the buffered records and stops the shipper.
+func (s *Shipper) Close() error {
+	s.closeOnce.Do(func() {
+		close(s.done)
+	})
+	<-s.stopped
+	return nil
+}
+
+func (s *Shipper) closing() bool {
+	select {
+	case <-s.done:
+		return true
+	default:
+		return false
+	}
+}
+
+func (s *Shipper) run() {
+	defer close(s.stopped)
+	ticker := time.NewTicker(s.config.FlushInterval)
+	defer ticker.Stop()
+
+	batch := make([]shippedRecord, 0, s.config.BatchSize)
+	flush := func() {
+		if len(batch) > 0 {
+			s.flush(batch)
+			batch = make([]shippedRecord, 0, s.config.BatchSize)
+		}
+	}
+
+	for {
+		select {
+		case record := <-s.records:
+			batch = append(batch, record)
+			if len(batch) >= s.config.BatchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		case <-s.done:
+			for {
+				select {
+				case record := <-s.records:
+					batch = append(batch, record)
+					if len(batch) >= s.config.BatchSize {
+						flush()
+					}
+				default:
+					flush()
+					return
+				}
+			}
+		}
+	}
+}
+
+// flush sends the batch to every backend, retrying retryable failures with exponential backoff.
+func (s *Shipper) flush(batch []shippedRecord) {
+	for _, backend := range s.backends {
+		backoff := s.config.RetryBackoff
+		var err error
+		for attempt := 0; attempt <= s.config.MaxRetries; attempt++ {
+			if attempt > 0 {
+				// Pending records are flushed with a single attempt on shutdown
+				select {
+				case <-time.After(backoff):
+				case <-s.done:
+				}
+				backoff *= 2
+			}
+			if err = backend.send(s.client, batch); err == nil {
+				break
+			}
+			var retryable retryableError
+			if !errors.As(err, &retryable) || s.closing() {
+				break
+			}
+		}
+
+		if err != nil {
+			shippedRecords.WithLabelValues(backend.name(), "failed").Add(float64(len(batch)))
+			log.Error().Err(err).Str("backend", backend.name()).Int("records", len(batch)).Msg("Failed to ship log records")
+		} else {
+			shippedRecords.WithLabelValues(backend.name(), "shipped").Add(float64(len(batch)))
+		}
+	}
+}
+
+// post sends a bulk request and classifies its failures.
+func post(client *http.Client, request *http.Request) error {
+	response, err := client.Do(request)
+	if err != nil {
+		return retryableError{err}
+	}
+	defer func() { _ = response.Body.Close() }()
+	body, _ := io.ReadAll(io.LimitReader(response.Body, 4096))
+
+	switch {
+	case response.StatusCode == http.StatusTooManyRequests || response.StatusCode >= 500:
+		return retryableError{errors.Errorf("bulk request failed with status %d: %s", response.StatusCode, body)}
+	case response.StatusCode >= 300:
+		return errors.Errorf("bulk request rejected with status %d: %s", response.StatusCode, body)
+	}
+	return nil
+}
+
+type openSearchBackend struct {
+	config OpenSearchConfig
+}
+
+func (b openSearchBackend) name() string {
+	return "opensearch"
+}
+
+func (b openSearchBackend) send(client *http.Client, batch []shippedRecord) error {
+	pattern := b.config.Index
+	if pattern == "" {
+		pattern = DefaultOpenSearchIndex
+	}
+
+	var payload bytes.Buffer
+	for _, record := range batch {
+		index := strings.NewReplacer("{stream}", record.stream, "{date}", record.time.UTC().Format("2006.01.02")).Replace(pattern)
+		action, _ := json.Marshal(map[string]any{"index": map[string]string{"_index": index}})
+		payload.Write(action)
+		payload.WriteByte('\n')
+		payload.Write(record.body)
+		payload.WriteByte('\n')
+	}
+
+	request, err := http.NewRequest(http.MethodPost, strings.TrimSuffix(b.config.URL, "/")+"/_bulk", &payload)
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/x-ndjson")
+	if b.config.Username != "" {
+		request.SetBasicAuth(b.config.Username, b.config.Password)
+	}
+	return post(client, request)
+}
+
+type lokiBackend struct {
+	config LokiConfig
+}
+
+func (b lokiBackend) name() string {
+	return "loki"
+}
+
+type lokiStream struct {
+	Stream map[string]string `json:"stream"`
+	Values [][2]string       `json:"values"`
+}
+
+func (b lokiBackend) send(client *http.Client, batch []shippedRecord) error {
+	streams := make(map[string]*lokiStream)
+	var order []string
+	for _, record := range batch {
+		stream, ok := streams[record.stream]
+		if !ok {
+			labels := make(map[string]string, len(b.config.Labels)+1)
+			for key, value := range b.config.Labels {
+				labels[key] = value
+			}
+			labels["stream"] = record.stream
+			stream = &lokiStream{Stream: labels}
+			streams[record.stream] = stream
+			order = append(order, record.stream)
+		}
+		stream.Values = append(stream.Values, [2]string{strconv.FormatInt(record.time.UnixNano(), 10), string(record.body)})
+	}
+
+	push := struct {
+		Streams []*lokiStream `json:"streams"`
+	}{}
+	for _, name := range order {
+		push.Streams = append(push.Streams, streams[name])
+	}
+	payload, err := json.Marshal(push)
+	if err != nil {
+		return err
+	}
+
+	request, err := http.NewRequest(http.MethodPost, strings.TrimSuffix(b.config.URL, "/")+"/loki/api/v1/push", bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	if b.config.TenantID != "" {
+		request.Header.Set("X-Scope-OrgID", b.config.TenantID)
+	}
+	if b.config.Username != "" {
+		request.SetBasicAuth(b.config.Username, b.config.Password)
+	}
+	return post(client, request)
+}
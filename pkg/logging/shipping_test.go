@@ -0,0 +1,216 @@
+//go:build unit
+
+package logging
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/rs/zerolog/log"
+)
+
+var _ = Describe("Shipping", func() {
+	var (
+		mu       sync.Mutex
+		requests []*http.Request
+		bodies   [][]byte
+		statuses []int
+		backend  *httptest.Server
+	)
+
+	BeforeEach(func() {
+		requests, bodies, statuses = nil, nil, nil
+		backend = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body, _ := io.ReadAll(r.Body)
+			mu.Lock()
+			requests = append(requests, r)
+			bodies = append(bodies, body)
+			status := http.StatusOK
+			if len(statuses) > 0 {
+				status, statuses = statuses[0], statuses[1:]
+			}
+			mu.Unlock()
+			w.WriteHeader(status)
+		}))
+		DeferCleanup(backend.Close)
+	})
+
+	received := func() int {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(requests)
+	}
+
+	It("should validate the configuration", func() {
+		Expect(ShippingConfig{}.Validate()).To(MatchError(ContainSubstring("at least one")))
+		Expect(ShippingConfig{OpenSearch: &OpenSearchConfig{URL: "not a url"}}.Validate()).To(HaveOccurred())
+		Expect(ShippingConfig{Loki: &LokiConfig{URL: "http://loki:3100", Labels: map[string]string{"stream": "x"}}}.Validate()).To(MatchError(ContainSubstring("reserved")))
+		Expect(ShippingConfig{Loki: &LokiConfig{URL: "http://loki:3100"}, BatchSize: -1}.Validate()).To(HaveOccurred())
+		Expect(ShippingConfig{Loki: &LokiConfig{URL: "http://loki:3100"}}.Validate()).To(Succeed())
+		Expect(Config{Shipping: &ShippingConfig{}}.Validate()).To(MatchError(ContainSubstring("shipping")))
+	})
+
+	It("should ship records to the OpenSearch bulk API", func() {
+		shipper, err := NewShipper(ShippingConfig{
+			OpenSearch: &OpenSearchConfig{URL: backend.URL, Index: "logs-{stream}", Username: "user", Password: "secret"},
+		})
+		Expect(err).NotTo(HaveOccurred())
+		shipper.Ship(StreamAccess, map[string]any{"status": 200})
+		shipper.Ship(StreamAudit, map[string]any{"event": "login"})
+		Expect(shipper.Close()).To(Succeed())
+
+		Expect(requests).To(HaveLen(1))
+		Expect(requests[0].URL.Path).To(Equal("/_bulk"))
+		Expect(requests[0].Header.Get("Content-Type")).To(Equal("application/x-ndjson"))
+		username, password, ok := requests[0].BasicAuth()
+		Expect(ok).To(BeTrue())
+		Expect(username).To(Equal("user"))
+		Expect(password).To(Equal("secret"))
+
+		var lines []map[string]any
+		scanner := bufio.NewScanner(bytes.NewReader(bodies[0]))
+		for scanner.Scan() {
+			var line map[string]any
+			Expect(json.Unmarshal(scanner.Bytes(), &line)).To(Succeed())
+			lines = append(lines, line)
+		}
+		Expect(lines).To(HaveLen(4))
+		Expect(lines[0]).To(HaveKeyWithValue("index", HaveKeyWithValue("_index", "logs-access")))
+		Expect(lines[1]).To(HaveKeyWithValue("status", BeNumerically("==", 200)))
+		Expect(lines[1]).To(HaveKey("@timestamp"))
+		Expect(lines[2]).To(HaveKeyWithValue("index", HaveKeyWithValue("_index", "logs-audit")))
+		Expect(lines[3]).To(HaveKeyWithValue("event", "login"))
+	})
+
+	It("should ship records to the Loki push API grouped by stream", func() {
+		shipper, err := NewShipper(ShippingConfig{
+			Loki: &LokiConfig{URL: backend.URL, TenantID: "team-a", Labels: map[string]string{"app": "gateway"}},
+		})
+		Expect(err).NotTo(HaveOccurred())
+		shipper.Ship(StreamAccess, map[string]any{"path": "/a"})
+		shipper.Ship(StreamAccess, map[string]any{"path": "/b"})
+		shipper.Ship(StreamAudit, map[string]any{"event": "logout"})
+		Expect(shipper.Close()).To(Succeed())
+
+		Expect(requests).To(HaveLen(1))
+		Expect(requests[0].URL.Path).To(Equal("/loki/api/v1/push"))
+		Expect(requests[0].Header.Get("X-Scope-OrgID")).To(Equal("team-a"))
+		var push struct {
+			Streams []struct {
+				Stream map[string]string `json:"stream"`
+				Values [][2]string       `json:"values"`
+			} `json:"streams"`
+		}
+		Expect(json.Unmarshal(bodies[0], &push)).To(Succeed())
+		Expect(push.Streams).To(HaveLen(2))
+		Expect(push.Streams[0].Stream).To(Equal(map[string]string{"app": "gateway", "stream": StreamAccess}))
+		Expect(push.Streams[0].Values).To(HaveLen(2))
+		Expect(push.Streams[0].Values[1][1]).To(ContainSubstring(`"path":"/b"`))
+		Expect(push.Streams[1].Stream).To(HaveKeyWithValue("stream", StreamAudit))
+	})
+
+	It("should flush batches when they are full or on the flush interval", func() {
+		shipper, err := NewShipper(ShippingConfig{
+			OpenSearch:    &OpenSearchConfig{URL: backend.URL},
+			BatchSize:     2,
+			FlushInterval: 50 * time.Millisecond,
+		})
+		Expect(err).NotTo(HaveOccurred())
+		defer shipper.Close()
+
+		shipper.Ship(StreamAccess, nil)
+		shipper.Ship(StreamAccess, nil)
+		Eventually(received).Should(Equal(1))
+		shipper.Ship(StreamAccess, nil)
+		Eventually(received).Should(Equal(2))
+	})
+
+	It("should retry server errors with backoff", func() {
+		statuses = []int{http.StatusServiceUnavailable, http.StatusTooManyRequests}
+		shipped := shippedRecords.WithLabelValues("opensearch", "shipped")
+		before := testutil.ToFloat64(shipped)
+		shipper, err := NewShipper(ShippingConfig{
+			OpenSearch:    &OpenSearchConfig{URL: backend.URL},
+			FlushInterval: 10 * time.Millisecond,
+			RetryBackoff:  time.Millisecond,
+		})
+		Expect(err).NotTo(HaveOccurred())
+		defer shipper.Close()
+
+		shipper.Ship(StreamAccess, nil)
+		Eventually(received).Should(Equal(3))
+		Eventually(func() float64 { return testutil.ToFloat64(shipped) - before }).Should(Equal(1.0))
+	})
+
+	It("should not retry rejected requests", func() {
+		statuses = []int{http.StatusBadRequest}
+		failed := shippedRecords.WithLabelValues("loki", "failed")
+		before := testutil.ToFloat64(failed)
+		shipper, err := NewShipper(ShippingConfig{
+			Loki:         &LokiConfig{URL: backend.URL},
+			RetryBackoff: time.Millisecond,
+		})
+		Expect(err).NotTo(HaveOccurred())
+		shipper.Ship(StreamAccess, nil)
+		Expect(shipper.Close()).To(Succeed())
+
+		Expect(requests).To(HaveLen(1))
+		Expect(testutil.ToFloat64(failed) - before).To(Equal(1.0))
+	})
+
+	It("should drop records instead of blocking when the buffer is full", func() {
+		var release atomic.Bool
+		slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			for !release.Load() {
+				time.Sleep(time.Millisecond)
+			}
+		}))
+		defer slow.Close()
+		before := testutil.ToFloat64(droppedShippingRecords)
+		shipper, err := NewShipper(ShippingConfig{
+			OpenSearch:    &OpenSearchConfig{URL: slow.URL},
+			BufferSize:    2,
+			BatchSize:     1,
+			FlushInterval: time.Hour,
+		})
+		Expect(err).NotTo(HaveOccurred())
+
+		// The first record is being sent, the next two fill the buffer
+		for i := 0; i < 10; i++ {
+			shipper.Ship(StreamAccess, map[string]any{"i": i})
+		}
+		Expect(testutil.ToFloat64(droppedShippingRecords) - before).To(BeNumerically(">=", 7))
+		release.Store(true)
+		Expect(shipper.Close()).To(Succeed())
+	})
+
+	It("should ship through the configured shipper", func() {
+		originalLogger, originalSlog := log.Logger, slog.Default()
+		DeferCleanup(func() {
+			log.Logger = originalLogger
+			slog.SetDefault(originalSlog)
+		})
+		Expect(ShippingEnabled()).To(BeFalse())
+		Ship(StreamAccess, map[string]any{"ignored": true})
+
+		Expect(Configure(Config{Shipping: &ShippingConfig{OpenSearch: &OpenSearchConfig{URL: backend.URL}}}, io.Discard)).To(Succeed())
+		Expect(ShippingEnabled()).To(BeTrue())
+		Ship(StreamAudit, map[string]any{"event": "login"})
+		Expect(Close()).To(Succeed())
+
+		Expect(ShippingEnabled()).To(BeFalse())
+		Expect(requests).To(HaveLen(1))
+		Expect(string(bodies[0])).To(ContainSubstring(`"event":"login"`))
+	})
+})
@@ -0,0 +1,99 @@
+package logging
+
+import (
+	"bytes"
+	"io"
+	"os"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+)
+
+// Outputs accepted in Config.Output.
+const (
+	OutputStdout   = "stdout"
+	OutputStderr   = "stderr"
+	OutputFile     = "file"
+	OutputSyslog   = "syslog"
+	OutputJournald = "journald"
+)
+
+// sink is the destination of the records, aware of their level.
+type sink interface {
+	zerolog.LevelWriter
+	io.Closer
+}
+
+// newSink creates the sink selected in cfg. The second result tells whether the sink is a
+// terminal stream, which gets colored console output.
+func newSink(cfg Config) (sink, bool, error) {
+	switch cfg.Output {
+	case "", OutputStdout:
+		return nopCloser{zerolog.LevelWriterAdapter{Writer: os.Stdout}}, true, nil
+	case OutputStderr:
+		return nopCloser{zerolog.LevelWriterAdapter{Writer: os.Stderr}}, true, nil
+	case OutputFile:
+		file, err := newRotatingFile(*cfg.File)
+		if err != nil {
+			return nil, false, err
+		}
+		return fileSink{LevelWriterAdapter: zerolog.LevelWriterAdapter{Writer: file}, file: file}, false, nil
+	case OutputSyslog:
+		syslogCfg := SyslogConfig{}
+		if cfg.Syslog != nil {
+			syslogCfg = *cfg.Syslog
+		}
+		writer, err := newSyslogWriter(syslogCfg)
+		return writer, false, err
+	case OutputJournald:
+		journaldCfg := JournaldConfig{}
+		if cfg.Journald != nil {
+			journaldCfg = *cfg.Journald
+		}
+		writer, err := newJournaldWriter(journaldCfg)
+		return writer, false, err
+	default:
+		return nil, false, errors.Errorf("unsupported log output %q", cfg.Output)
+	}
+}
+
+type nopCloser struct {
+	zerolog.LevelWriterAdapter
+}
+
+func (nopCloser) Close() error {
+	return nil
+}
+
+type fileSink struct {
+	zerolog.LevelWriterAdapter
+	file *rotatingFile
+}
+
+func (s fileSink) Close() error {
+	return s.file.Close()
+}
+
+// consoleWriter formats records for humans before handing them to a level-aware sink, which
+// zerolog.ConsoleWriter alone does not preserve.
+type consoleWriter struct {
+	console zerolog.ConsoleWriter
+	sink    zerolog.LevelWriter
+}
+
+func (w consoleWriter) Write(p []byte) (int, error) {
+	return w.WriteLevel(zerolog.NoLevel, p)
+}
+
+func (w consoleWriter) WriteLevel(level zerolog.Level, p []byte) (int, error) {
+	var formatted bytes.Buffer
+	console := w.console
+	console.Out = &formatted
+	if _, err := console.Write(p); err != nil {
+		return 0, err
+	}
+	if _, err := w.sink.WriteLevel(level, formatted.Bytes()); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
@@ -0,0 +1,178 @@
+//go:build unit
+
+package logging
+
+import (
+	"compress/gzip"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+var _ = Describe("Sinks", func() {
+	var dir string
+
+	BeforeEach(func() {
+		dir = GinkgoT().TempDir()
+	})
+
+	backups := func() []string {
+		entries, err := os.ReadDir(dir)
+		Expect(err).NotTo(HaveOccurred())
+		var names []string
+		for _, entry := range entries {
+			if entry.Name() != "app.log" {
+				names = append(names, entry.Name())
+			}
+		}
+		return names
+	}
+
+	Context("Validation", func() {
+		It("should validate outputs", func() {
+			Expect(Config{Output: OutputFile}.Validate()).To(MatchError(ContainSubstring("requires the file configuration")))
+			Expect(Config{Output: "kafka"}.Validate()).To(MatchError(ContainSubstring("unsupported log output")))
+			Expect(Config{Output: OutputFile, File: &FileConfig{}}.Validate()).To(HaveOccurred())
+			Expect(Config{Output: OutputFile, File: &FileConfig{Path: "app.log", MaxSizeMB: -1}}.Validate()).To(HaveOccurred())
+			Expect(Config{Output: OutputSyslog, Syslog: &SyslogConfig{Facility: "nope"}}.Validate()).To(HaveOccurred())
+			Expect(Config{Output: OutputSyslog, Syslog: &SyslogConfig{Network: "udp"}}.Validate()).To(HaveOccurred())
+			Expect(Config{Output: OutputSyslog, Syslog: &SyslogConfig{Network: "udp", Address: "localhost:514", Facility: "local3"}}.Validate()).To(Succeed())
+			Expect(Config{Output: OutputJournald}.Validate()).To(Succeed())
+		})
+	})
+
+	Context("Rotating file", func() {
+		It("should rotate by size", func() {
+			file, err := newRotatingFile(FileConfig{Path: filepath.Join(dir, "app.log"), MaxSizeMB: 1})
+			Expect(err).NotTo(HaveOccurred())
+			chunk := []byte(strings.Repeat("x", 600*1024))
+			_, err = file.Write(chunk)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(backups()).To(BeEmpty())
+
+			_, err = file.Write(chunk)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(file.Close()).To(Succeed())
+
+			Expect(backups()).To(HaveLen(1))
+			Expect(backups()[0]).To(MatchRegexp(`^app-\d{8}T\d{6}\.\d{3}\.log$`))
+			info, err := os.Stat(filepath.Join(dir, "app.log"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(info.Size()).To(Equal(int64(len(chunk))))
+		})
+
+		It("should rotate by age and compress the rotated file", func() {
+			file, err := newRotatingFile(FileConfig{Path: filepath.Join(dir, "app.log"), RotateEvery: time.Hour, Compress: true})
+			Expect(err).NotTo(HaveOccurred())
+			now := time.Now()
+			file.now = func() time.Time { return now }
+			file.openedAt = now
+
+			_, err = file.Write([]byte("first\n"))
+			Expect(err).NotTo(HaveOccurred())
+			now = now.Add(time.Hour)
+			_, err = file.Write([]byte("second\n"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(file.Close()).To(Succeed())
+
+			Expect(backups()).To(HaveLen(1))
+			Expect(backups()[0]).To(HaveSuffix(".log.gz"))
+			compressed, err := os.Open(filepath.Join(dir, backups()[0]))
+			Expect(err).NotTo(HaveOccurred())
+			defer compressed.Close()
+			reader, err := gzip.NewReader(compressed)
+			Expect(err).NotTo(HaveOccurred())
+			content, err := io.ReadAll(reader)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(content)).To(Equal("first\n"))
+		})
+
+		It("should keep at most max_backups rotated files", func() {
+			file, err := newRotatingFile(FileConfig{Path: filepath.Join(dir, "app.log"), RotateEvery: time.Minute, MaxBackups: 2})
+			Expect(err).NotTo(HaveOccurred())
+			now := time.Now()
+			file.now = func() time.Time { return now }
+			for i := 0; i < 5; i++ {
+				_, err = file.Write([]byte("record\n"))
+				Expect(err).NotTo(HaveOccurred())
+				now = now.Add(time.Minute)
+			}
+			Expect(file.Close()).To(Succeed())
+			Expect(backups()).To(HaveLen(2))
+		})
+
+		It("should remove rotated files older than max_age", func() {
+			old := filepath.Join(dir, "app-20200101T000000.000.log")
+			Expect(os.WriteFile(old, []byte("old\n"), 0o600)).To(Succeed())
+			Expect(os.Chtimes(old, time.Now().Add(-48*time.Hour), time.Now().Add(-48*time.Hour))).To(Succeed())
+			unrelated := filepath.Join(dir, "app-notes.log")
+			Expect(os.WriteFile(unrelated, []byte("keep\n"), 0o600)).To(Succeed())
+
+			file, err := newRotatingFile(FileConfig{Path: filepath.Join(dir, "app.log"), MaxSizeMB: 1, MaxAge: 24 * time.Hour})
+			Expect(err).NotTo(HaveOccurred())
+			_, err = file.Write([]byte(strings.Repeat("x", 1024*1024)))
+			Expect(err).NotTo(HaveOccurred())
+			_, err = file.Write([]byte("rotate\n"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(file.Close()).To(Succeed())
+
+			Expect(old).NotTo(BeAnExistingFile())
+			Expect(unrelated).To(BeAnExistingFile())
+			Expect(backups()).To(HaveLen(2))
+		})
+
+		It("should fail writes after close", func() {
+			file, err := newRotatingFile(FileConfig{Path: filepath.Join(dir, "app.log")})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(file.Close()).To(Succeed())
+			_, err = file.Write([]byte("late\n"))
+			Expect(err).To(MatchError(os.ErrClosed))
+		})
+	})
+
+	Context("Configure", func() {
+		var (
+			originalLogger zerolog.Logger
+			originalSlog   *slog.Logger
+		)
+
+		BeforeEach(func() {
+			originalLogger = log.Logger
+			originalSlog = slog.Default()
+			DeferCleanup(func() {
+				Expect(Configure(Config{}, io.Discard)).To(Succeed())
+				Expect(Close()).To(Succeed())
+				log.Logger = originalLogger
+				slog.SetDefault(originalSlog)
+			})
+		})
+
+		It("should write uncolored console records to the log file", func() {
+			path := filepath.Join(dir, "app.log")
+			Expect(Configure(Config{Output: OutputFile, File: &FileConfig{Path: path}}, nil)).To(Succeed())
+			log.Info().Str("key", "value").Msg("to file")
+			Expect(Close()).To(Succeed())
+
+			content, err := os.ReadFile(path)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(content)).To(ContainSubstring("to file"))
+			Expect(string(content)).To(ContainSubstring("key=value"))
+			Expect(string(content)).NotTo(ContainSubstring("\x1b["))
+		})
+
+		It("should fail when the output cannot be opened", func() {
+			blocker := filepath.Join(dir, "blocker")
+			Expect(os.WriteFile(blocker, nil, 0o600)).To(Succeed())
+			err := Configure(Config{Output: OutputFile, File: &FileConfig{Path: filepath.Join(blocker, "app.log")}}, nil)
+			Expect(err).To(MatchError(ContainSubstring("failed to open file log output")))
+		})
+	})
+})
@@ -0,0 +1,142 @@
+package logging
+
+import (
+	"context"
+	"log/slog"
+
+	"github.com/rs/zerolog"
+)
+
+// NewSlogHandler returns a log/slog handler that writes to the given zerolog logger, so that
+// libraries logging through slog share the output, format and levels of the framework logs.
+// Configure installs it as the slog default handler.
+func NewSlogHandler(logger zerolog.Logger) slog.Handler {
+	return &slogHandler{logger: logger}
+}
+
+type slogHandler struct {
+	logger zerolog.Logger
+	prefix string // group prefix for attribute keys
+}
+
+func (h *slogHandler) Enabled(_ context.Context, level slog.Level) bool {
+	zlevel := zerologLevel(level)
+	return zlevel >= h.logger.GetLevel() && zlevel >= zerolog.GlobalLevel()
+}
+
+func (h *slogHandler) Handle(_ context.Context, record slog.Record) error {
+	event := h.logger.WithLevel(zerologLevel(record.Level))
+	record.Attrs(func(attr slog.Attr) bool {
+		appendAttr(event, h.prefix, attr)
+		return true
+	})
+	event.Msg(record.Message)
+	return nil
+}
+
+func (h *slogHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	// Attributes are rendered once into the logger context
+	ctx := h.logger.With()
+	for _, attr := range attrs {
+		ctx = appendContextAttr(ctx, h.prefix, attr)
+	}
+	return &slogHandler{logger: ctx.Logger(), prefix: h.prefix}
+}
+
+func (h *slogHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	return &slogHandler{logger: h.logger, prefix: h.prefix + name + "."}
+}
+
+func zerologLevel(level slog.Level) zerolog.Level {
+	switch {
+	case level >= slog.LevelError:
+		return zerolog.ErrorLevel
+	case level >= slog.LevelWarn:
+		return zerolog.WarnLevel
+	case level >= slog.LevelInfo:
+		return zerolog.InfoLevel
+	case level >= slog.LevelDebug:
+		return zerolog.DebugLevel
+	default:
+		return zerolog.TraceLevel
+	}
+}
+
+func appendAttr(event *zerolog.Event, prefix string, attr slog.Attr) {
+	value := attr.Value.Resolve()
+	if attr.Key == "" && value.Kind() != slog.KindGroup {
+		return
+	}
+	key := prefix + attr.Key
+	switch value.Kind() {
+	case slog.KindGroup:
+		groupPrefix := prefix
+		if attr.Key != "" {
+			groupPrefix = key + "."
+		}
+		for _, child := range value.Group() {
+			appendAttr(event, groupPrefix, child)
+		}
+	case slog.KindString:
+		event.Str(key, value.String())
+	case slog.KindInt64:
+		event.Int64(key, value.Int64())
+	case slog.KindUint64:
+		event.Uint64(key, value.Uint64())
+	case slog.KindFloat64:
+		event.Float64(key, value.Float64())
+	case slog.KindBool:
+		event.Bool(key, value.Bool())
+	case slog.KindDuration:
+		event.Dur(key, value.Duration())
+	case slog.KindTime:
+		event.Time(key, value.Time())
+	default:
+		if err, ok := value.Any().(error); ok {
+			event.AnErr(key, err)
+		} else {
+			event.Interface(key, value.Any())
+		}
+	}
+}
+
+func appendContextAttr(ctx zerolog.Context, prefix string, attr slog.Attr) zerolog.Context {
+	value := attr.Value.Resolve()
+	if attr.Key == "" && value.Kind() != slog.KindGroup {
+		return ctx
+	}
+	key := prefix + attr.Key
+	switch value.Kind() {
+	case slog.KindGroup:
+		groupPrefix := prefix
+		if attr.Key != "" {
+			groupPrefix = key + "."
+		}
+		for _, child := range value.Group() {
+			ctx = appendContextAttr(ctx, groupPrefix, child)
+		}
+		return ctx
+	case slog.KindString:
+		return ctx.Str(key, value.String())
+	case slog.KindInt64:
+		return ctx.Int64(key, value.Int64())
+	case slog.KindUint64:
+		return ctx.Uint64(key, value.Uint64())
+	case slog.KindFloat64:
+		return ctx.Float64(key, value.Float64())
+	case slog.KindBool:
+		return ctx.Bool(key, value.Bool())
+	case slog.KindDuration:
+		return ctx.Dur(key, value.Duration())
+	case slog.KindTime:
+		return ctx.Time(key, value.Time())
+	default:
+		if err, ok := value.Any().(error); ok {
+			return ctx.AnErr(key, err)
+		}
+		return ctx.Interface(key, value.Any())
+	}
+}
@@ -0,0 +1,61 @@
+package logging
+
+import (
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+)
+
+// SyslogConfig configures the syslog sink.
+type SyslogConfig struct {
+	// Network and Address of the syslog server, e.g. "udp" and "logs.internal:514". Both empty
+	// use the local syslog daemon.
+	Network string `yaml:"network,omitempty"`
+	Address string `yaml:"address,omitempty"`
+	// Tag identifies the records, defaults to the program name.
+	Tag string `yaml:"tag,omitempty"`
+	// Facility is the syslog facility name (kern, user, daemon, local0...local7...), defaults to daemon.
+	Facility string `yaml:"facility,omitempty"`
+}
+
+// syslogFacilities are the facility names accepted in the configuration, with their codes.
+var syslogFacilities = map[string]int{
+	"kern": 0, "user": 1, "mail": 2, "daemon": 3, "auth": 4, "syslog": 5, "lpr": 6, "news": 7,
+	"uucp": 8, "cron": 9, "authpriv": 10, "ftp": 11,
+	"local0": 16, "local1": 17, "local2": 18, "local3": 19,
+	"local4": 20, "local5": 21, "local6": 22, "local7": 23,
+}
+
+func (c SyslogConfig) Validate() error {
+	if (c.Network == "") != (c.Address == "") {
+		return errors.New("network and address must be set together")
+	}
+	if _, ok := syslogFacilities[c.facility()]; !ok {
+		return errors.Errorf("unknown syslog facility %q", c.Facility)
+	}
+	return nil
+}
+
+func (c SyslogConfig) facility() string {
+	if c.Facility == "" {
+		return "daemon"
+	}
+	return c.Facility
+}
+
+// syslogSeverity maps zerolog levels to syslog severities.
+func syslogSeverity(level zerolog.Level) int {
+	switch level {
+	case zerolog.TraceLevel, zerolog.DebugLevel:
+		return 7
+	case zerolog.WarnLevel:
+		return 4
+	case zerolog.ErrorLevel:
+		return 3
+	case zerolog.FatalLevel:
+		return 2
+	case zerolog.PanicLevel:
+		return 0
+	default:
+		return 6
+	}
+}
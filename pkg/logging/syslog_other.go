@@ -0,0 +1,22 @@
+//go:build windows || plan9
+
+package logging
+
+import (
+	"io"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+)
+
+type syslogWriter struct {
+	io.WriteCloser
+}
+
+func newSyslogWriter(SyslogConfig) (*syslogWriter, error) {
+	return nil, errors.New("syslog output is not supported on this platform")
+}
+
+func (w *syslogWriter) WriteLevel(_ zerolog.Level, p []byte) (int, error) {
+	return w.Write(p)
+}
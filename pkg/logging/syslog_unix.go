@@ -0,0 +1,56 @@
+//go:build !windows && !plan9
+
+package logging
+
+import (
+	"log/syslog"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+)
+
+// syslogWriter forwards records to syslog with the severity of their level.
+type syslogWriter struct {
+	writer *syslog.Writer
+}
+
+func newSyslogWriter(cfg SyslogConfig) (*syslogWriter, error) {
+	priority := syslog.Priority(syslogFacilities[cfg.facility()]<<3) | syslog.LOG_INFO
+	writer, err := syslog.Dial(cfg.Network, cfg.Address, priority, cfg.Tag)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to connect to syslog")
+	}
+	return &syslogWriter{writer: writer}, nil
+}
+
+func (w *syslogWriter) Write(p []byte) (int, error) {
+	return w.WriteLevel(zerolog.NoLevel, p)
+}
+
+func (w *syslogWriter) WriteLevel(level zerolog.Level, p []byte) (int, error) {
+	msg := strings.TrimSuffix(string(p), "\n")
+	var err error
+	switch syslogSeverity(level) {
+	case 7:
+		err = w.writer.Debug(msg)
+	case 4:
+		err = w.writer.Warning(msg)
+	case 3:
+		err = w.writer.Err(msg)
+	case 2:
+		err = w.writer.Crit(msg)
+	case 0:
+		err = w.writer.Emerg(msg)
+	default:
+		err = w.writer.Info(msg)
+	}
+	if err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (w *syslogWriter) Close() error {
+	return w.writer.Close()
+}
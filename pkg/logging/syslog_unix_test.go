@@ -0,0 +1,49 @@
+//go:build unit && !windows && !plan9
+
+package logging
+
+import (
+	"net"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/rs/zerolog"
+)
+
+var _ = Describe("Syslog sink", func() {
+	It("should send records with the facility and severity of their level", func() {
+		listener, err := net.ListenPacket("udp", "127.0.0.1:0")
+		Expect(err).NotTo(HaveOccurred())
+		defer listener.Close()
+
+		writer, err := newSyslogWriter(SyslogConfig{Network: "udp", Address: listener.LocalAddr().String(), Tag: "gateway", Facility: "local0"})
+		Expect(err).NotTo(HaveOccurred())
+		defer writer.Close()
+
+		read := func() string {
+			buffer := make([]byte, 4096)
+			n, _, err := listener.ReadFrom(buffer)
+			Expect(err).NotTo(HaveOccurred())
+			return string(buffer[:n])
+		}
+
+		_, err = writer.WriteLevel(zerolog.WarnLevel, []byte("disk almost full\n"))
+		Expect(err).NotTo(HaveOccurred())
+		// local0 (16) * 8 + warning (4)
+		Expect(read()).To(And(HavePrefix("<132>"), ContainSubstring("gateway"), HaveSuffix("disk almost full\n")))
+
+		_, err = writer.Write([]byte("no level\n"))
+		Expect(err).NotTo(HaveOccurred())
+		// local0 (16) * 8 + info (6)
+		Expect(read()).To(HavePrefix("<134>"))
+	})
+
+	It("should map levels to syslog severities", func() {
+		Expect(syslogSeverity(zerolog.TraceLevel)).To(Equal(7))
+		Expect(syslogSeverity(zerolog.DebugLevel)).To(Equal(7))
+		Expect(syslogSeverity(zerolog.InfoLevel)).To(Equal(6))
+		Expect(syslogSeverity(zerolog.ErrorLevel)).To(Equal(3))
+		Expect(syslogSeverity(zerolog.FatalLevel)).To(Equal(2))
+		Expect(syslogSeverity(zerolog.PanicLevel)).To(Equal(0))
+	})
+})
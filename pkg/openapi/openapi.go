@@ -0,0 +1,186 @@
+// Package openapi describes the endpoints of the gateway as an OpenAPI 3.0 document, and generates
+// a TypeScript client from it, so that frontends integrate against typed contracts. The
+// configurations of the controllers implement Describer to add their routes:
+//
+//	doc := openapi.New("Sargantana gateway", version)
+//	authConfig.Describe(doc, "/")
+//	data, err := json.MarshalIndent(doc, "", "  ")
+//	client := doc.TypeScript()
+package openapi
+
+import (
+	"fmt"
+	"net/http"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// Version is the OpenAPI version of the documents.
+const Version = "3.0.3"
+
+// Describer adds the routes served under basePath to the document.
+type Describer interface {
+	Describe(doc *Document, basePath string)
+}
+
+// Document is an OpenAPI document, limited to the objects describing the gateway.
+type Document struct {
+	OpenAPI    string               `json:"openapi"`
+	Info       Info                 `json:"info"`
+	Paths      map[string]*PathItem `json:"paths"`
+	Components Components           `json:"components"`
+
+	operationIDs map[string]int
+}
+
+type Info struct {
+	Title   string `json:"title"`
+	Version string `json:"version"`
+}
+
+type Components struct {
+	Schemas map[string]*Schema `json:"schemas"`
+}
+
+// PathItem holds the operations of a path by lowercase method.
+type PathItem map[string]*Operation
+
+type Operation struct {
+	OperationID string              `json:"operationId"`
+	Summary     string              `json:"summary,omitempty"`
+	Tags        []string            `json:"tags,omitempty"`
+	Parameters  []Parameter         `json:"parameters,omitempty"`
+	RequestBody *RequestBody        `json:"requestBody,omitempty"`
+	Responses   map[string]Response `json:"responses"`
+}
+
+type Parameter struct {
+	Name        string  `json:"name"`
+	In          string  `json:"in"`
+	Description string  `json:"description,omitempty"`
+	Required    bool    `json:"required,omitempty"`
+	Schema      *Schema `json:"schema"`
+}
+
+type RequestBody struct {
+	Required bool                 `json:"required,omitempty"`
+	Content  map[string]MediaType `json:"content"`
+}
+
+type Response struct {
+	Description string               `json:"description"`
+	Content     map[string]MediaType `json:"content,omitempty"`
+}
+
+type MediaType struct {
+	Schema *Schema `json:"schema"`
+}
+
+// Schema is a JSON schema of the OpenAPI dialect.
+type Schema struct {
+	Ref                  string             `json:"$ref,omitempty"`
+	Type                 string             `json:"type,omitempty"`
+	Format               string             `json:"format,omitempty"`
+	Description          string             `json:"description,omitempty"`
+	Enum                 []string           `json:"enum,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+	AdditionalProperties *Schema            `json:"additionalProperties,omitempty"`
+}
+
+// String is the schema of the string parameters.
+func String() *Schema {
+	return &Schema{Type: "string"}
+}
+
+// JSON is the content of a JSON body with the given schema.
+func JSON(schema *Schema) map[string]MediaType {
+	return map[string]MediaType{"application/json": {Schema: schema}}
+}
+
+// Redirect is the response of the routes redirecting the browser.
+func Redirect(description string) map[string]Response {
+	return map[string]Response{"302": {Description: description}}
+}
+
+// New creates an empty document.
+func New(title, version string) *Document {
+	return &Document{
+		OpenAPI:      Version,
+		Info:         Info{Title: title, Version: version},
+		Paths:        make(map[string]*PathItem),
+		Components:   Components{Schemas: make(map[string]*Schema)},
+		operationIDs: make(map[string]int),
+	}
+}
+
+// pathParam matches the parameters of the Gin (:name, *name) and OpenAPI ({name}) paths
+var pathParam = regexp.MustCompile(`[:*]([A-Za-z0-9_]+)|\{([A-Za-z0-9_]+)}`)
+
+// Add adds the operation of the route, with a path in the Gin or OpenAPI syntax below basePath.
+// The parameters of the path not described by the operation are added as strings, and the
+// operation ID is suffixed with a number if already taken, e.g. by another instance of a
+// controller.
+func (d *Document) Add(method, basePath, route string, op Operation) {
+	route = Join(basePath, route)
+	described := make(map[string]bool)
+	for _, p := range op.Parameters {
+		if p.In == "path" {
+			described[p.Name] = true
+		}
+	}
+	route = pathParam.ReplaceAllStringFunc(route, func(param string) string {
+		match := pathParam.FindStringSubmatch(param)
+		name := match[1] + match[2]
+		if !described[name] {
+			described[name] = true
+			op.Parameters = append(op.Parameters, Parameter{Name: name, In: "path", Required: true, Schema: String()})
+		}
+		return "{" + name + "}"
+	})
+
+	d.operationIDs[op.OperationID]++
+	if n := d.operationIDs[op.OperationID]; n > 1 {
+		op.OperationID = fmt.Sprintf("%s%d", op.OperationID, n)
+	}
+	item, ok := d.Paths[route]
+	if !ok {
+		item = &PathItem{}
+		d.Paths[route] = item
+	}
+	(*item)[strings.ToLower(method)] = &op
+}
+
+// Join joins the base path of a controller binding and the path of a route as Gin does.
+func Join(basePath, route string) string {
+	if route == "" {
+		return basePath
+	}
+	joined := path.Join("/", basePath, route)
+	if strings.HasSuffix(route, "/") && !strings.HasSuffix(joined, "/") {
+		joined += "/"
+	}
+	return joined
+}
+
+// Errors returns the error responses of an operation, with the descriptions of their status.
+func Errors(statuses ...int) map[string]Response {
+	responses := make(map[string]Response, len(statuses))
+	for _, status := range statuses {
+		responses[fmt.Sprint(status)] = Response{Description: http.StatusText(status)}
+	}
+	return responses
+}
+
+// With returns the responses with the given additional responses.
+func With(responses map[string]Response, others map[string]Response) map[string]Response {
+	merged := make(map[string]Response, len(responses)+len(others))
+	for _, r := range []map[string]Response{responses, others} {
+		for status, response := range r {
+			merged[status] = response
+		}
+	}
+	return merged
+}
@@ -0,0 +1,109 @@
+//go:build unit
+
+package openapi
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+type item struct {
+	Name     string            `json:"name"`
+	Count    int64             `json:"count,omitempty"`
+	Tags     []string          `json:"tags"`
+	Labels   map[string]string `json:"labels,omitempty"`
+	Created  time.Time         `json:"created_at"`
+	Parent   *item             `json:"parent,omitempty"`
+	Ignored  string            `json:"-"`
+	internal string
+	metadata
+}
+
+type metadata struct {
+	Owner string `json:"owner"`
+}
+
+var _ = Describe("Document", func() {
+	var doc *Document
+
+	BeforeEach(func() {
+		doc = New("Gateway", "1.0.0")
+	})
+
+	It("should add the routes with their path parameters", func() {
+		doc.Add(http.MethodGet, "/admin", "/quotas/:quota/{subject}", Operation{
+			OperationID: "getUsage",
+			Parameters:  []Parameter{{Name: "subject", In: "path", Required: true, Schema: &Schema{Type: "string", Enum: []string{"a"}}}},
+			Responses:   Errors(http.StatusNotFound),
+		})
+		doc.Add(http.MethodDelete, "/admin", "/quotas/:quota/:subject", Operation{OperationID: "getUsage"})
+
+		item := *doc.Paths["/admin/quotas/{quota}/{subject}"]
+		Expect(item).To(HaveKey("get"))
+		Expect(item["get"].Parameters).To(HaveLen(2))
+		Expect(item["get"].Parameters[1]).To(Equal(Parameter{Name: "quota", In: "path", Required: true, Schema: String()}))
+		Expect(item["get"].Responses).To(HaveKeyWithValue("404", Response{Description: "Not Found"}))
+		Expect(item["delete"].OperationID).To(Equal("getUsage2"))
+	})
+
+	It("should join the paths as Gin does", func() {
+		Expect(Join("/", "/auth/user")).To(Equal("/auth/user"))
+		Expect(Join("", "/auth/user")).To(Equal("/auth/user"))
+		Expect(Join("/app/", "/files/")).To(Equal("/app/files/"))
+		Expect(Join("/admin/quotas", "")).To(Equal("/admin/quotas"))
+	})
+
+	It("should describe the JSON encoding of the Go types", func() {
+		Expect(doc.Schema("Item", item{})).To(Equal(Ref("Item")))
+		schema := doc.Components.Schemas["Item"]
+		Expect(schema.Properties).To(HaveKey("owner"))
+		Expect(schema.Properties).NotTo(HaveKey("Ignored"))
+		Expect(schema.Properties).NotTo(HaveKey("internal"))
+		Expect(schema.Properties["count"]).To(Equal(&Schema{Type: "integer", Format: "int64"}))
+		Expect(schema.Properties["created_at"]).To(Equal(&Schema{Type: "string", Format: "date-time"}))
+		Expect(schema.Properties["labels"].AdditionalProperties).To(Equal(String()))
+		Expect(schema.Properties["parent"]).To(Equal(Ref("Item")))
+		Expect(schema.Required).To(ConsistOf("name", "tags", "created_at", "owner"))
+
+		data, err := json.Marshal(doc)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(data)).To(ContainSubstring(`"openapi":"3.0.3"`))
+		Expect(string(data)).To(ContainSubstring(`"$ref":"#/components/schemas/Item"`))
+	})
+
+	It("should generate a TypeScript client", func() {
+		provider := Parameter{Name: "provider", In: "path", Required: true, Schema: &Schema{Type: "string", Enum: []string{"github", "google"}}}
+		doc.Add(http.MethodGet, "/", "/auth/{provider}", Operation{OperationID: "login", Parameters: []Parameter{provider}, Responses: Redirect("To the provider")})
+		doc.Add(http.MethodPost, "/", "/auth/{provider}/callback", Operation{OperationID: "formPostCallback", Parameters: []Parameter{provider}, Responses: Redirect("Home")})
+		doc.Add(http.MethodGet, "/", "/items/:name", Operation{
+			OperationID: "getItem",
+			Summary:     "Returns the item",
+			Parameters:  []Parameter{{Name: "period", In: "query", Schema: String()}},
+			Responses: With(map[string]Response{
+				"200": {Description: "The item", Content: JSON(doc.Schema("Item", item{}))},
+			}, Errors(http.StatusNotFound)),
+		})
+		doc.Add(http.MethodPut, "/", "/items/:name", Operation{
+			OperationID: "putItem",
+			RequestBody: &RequestBody{Required: true, Content: JSON(Ref("Item"))},
+			Responses:   map[string]Response{"204": {Description: "Saved"}},
+		})
+
+		ts := doc.TypeScript()
+		Expect(ts).To(HavePrefix("// Code generated by sargantana openapi. DO NOT EDIT."))
+		Expect(ts).To(ContainSubstring("export interface Item {\n  count?: number;\n  created_at: string;\n"))
+		Expect(ts).To(ContainSubstring("  labels?: Record<string, string>;\n"))
+		Expect(ts).To(ContainSubstring("  parent?: Item;\n"))
+		Expect(ts).To(ContainSubstring("  tags: string[];\n"))
+		Expect(ts).To(ContainSubstring("  loginUrl(provider: \"github\" | \"google\"): string {\n    return this.url(`/auth/${encodeURIComponent(provider)}`);\n  }"))
+		Expect(ts).NotTo(ContainSubstring("formPostCallback"))
+		Expect(ts).To(ContainSubstring("  /** Returns the item */\n  getItem(name: string, query?: { period?: string }): Promise<Item> {\n" +
+			"    return this.request(\"GET\", this.url(`/items/${encodeURIComponent(name)}`, query));\n  }"))
+		Expect(ts).To(ContainSubstring("  putItem(name: string, body: Item): Promise<void> {\n" +
+			"    return this.request(\"PUT\", this.url(`/items/${encodeURIComponent(name)}`), body);\n  }"))
+	})
+})
@@ -0,0 +1,124 @@
+package openapi
+
+import (
+	"iter"
+	"reflect"
+	"strings"
+	"time"
+	"unicode"
+)
+
+var timeType = reflect.TypeFor[time.Time]()
+
+// Schema returns a reference to the schema of the JSON encoding of the Go value, added to the
+// components under name along with the named structs it contains, e.g. d.Schema("User", User{}).
+func (d *Document) Schema(name string, value any) *Schema {
+	return d.schemaOf(reflect.TypeOf(value), name)
+}
+
+// Ref returns a reference to a schema of the components.
+func Ref(name string) *Schema {
+	return &Schema{Ref: "#/components/schemas/" + name}
+}
+
+func (d *Document) schemaOf(t reflect.Type, name string) *Schema {
+	if t == timeType {
+		return &Schema{Type: "string", Format: "date-time"}
+	}
+	switch t.Kind() {
+	case reflect.Pointer:
+		return d.schemaOf(t.Elem(), name)
+	case reflect.String:
+		return String()
+	case reflect.Bool:
+		return &Schema{Type: "boolean"}
+	case reflect.Int64, reflect.Uint64:
+		return &Schema{Type: "integer", Format: "int64"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return &Schema{Type: "integer"}
+	case reflect.Float32, reflect.Float64:
+		return &Schema{Type: "number"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return &Schema{Type: "string", Format: "byte"}
+		}
+		return &Schema{Type: "array", Items: d.schemaOf(t.Elem(), "")}
+	case reflect.Map:
+		return &Schema{Type: "object", AdditionalProperties: d.schemaOf(t.Elem(), "")}
+	case reflect.Struct:
+		if name == "" {
+			name = exported(t.Name())
+		}
+		if name == "" {
+			return d.object(t)
+		}
+		if _, ok := d.Components.Schemas[name]; !ok {
+			// Added before its properties, for the recursive types
+			schema := &Schema{}
+			d.Components.Schemas[name] = schema
+			*schema = *d.object(t)
+		}
+		return Ref(name)
+	default:
+		return &Schema{} // Any value
+	}
+}
+
+// object returns the schema of a struct, whose fields without omitempty or omitzero are required
+func (d *Document) object(t reflect.Type) *Schema {
+	schema := &Schema{Type: "object", Properties: make(map[string]*Schema)}
+	for field := range fields(t) {
+		name, options, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "" {
+			name = field.Name
+		}
+		schema.Properties[name] = d.schemaOf(field.Type, "")
+		if !strings.Contains(options, "omitempty") && !strings.Contains(options, "omitzero") {
+			schema.Required = append(schema.Required, name)
+		}
+	}
+	return schema
+}
+
+// fields yields the JSON encoded fields of a struct, including those of its embedded structs
+func fields(t reflect.Type) iter.Seq[reflect.StructField] {
+	return func(yield func(reflect.StructField) bool) {
+		for i := range t.NumField() {
+			field := t.Field(i)
+			tag := field.Tag.Get("json")
+			if tag == "-" {
+				continue
+			}
+			if name, _, _ := strings.Cut(tag, ","); field.Anonymous && name == "" {
+				embedded := field.Type
+				if embedded.Kind() == reflect.Pointer {
+					embedded = embedded.Elem()
+				}
+				if embedded.Kind() == reflect.Struct {
+					for f := range fields(embedded) {
+						if !yield(f) {
+							return
+						}
+					}
+					continue
+				}
+			}
+			if !field.IsExported() {
+				continue
+			}
+			if !yield(field) {
+				return
+			}
+		}
+	}
+}
+
+// exported capitalizes the name of a Go type, e.g. logLevelsResponse to LogLevelsResponse
+func exported(name string) string {
+	if name == "" {
+		return ""
+	}
+	runes := []rune(name)
+	runes[0] = unicode.ToUpper(runes[0])
+	return string(runes)
+}
@@ -0,0 +1,201 @@
+package openapi
+
+import (
+	"fmt"
+	"maps"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// typescriptRuntime is the part of the client independent of the document
+const typescriptRuntime = `export class GatewayError extends Error {
+  constructor(readonly status: number, readonly body: string) {
+    super("the gateway answered " + status);
+  }
+}
+
+export class GatewayClient {
+  constructor(readonly baseUrl = "", readonly init: RequestInit = { credentials: "include" }) {}
+
+  url(path: string, query?: Record<string, string | undefined>): string {
+    const params = new URLSearchParams();
+    for (const [name, value] of Object.entries(query ?? {})) {
+      if (value !== undefined) params.set(name, value);
+    }
+    const search = params.toString();
+    return this.baseUrl + path + (search ? "?" + search : "");
+  }
+
+  async request<T>(method: string, url: string, body?: unknown): Promise<T> {
+    const headers = new Headers(this.init.headers);
+    headers.set("Accept", "application/json");
+    if (body !== undefined) headers.set("Content-Type", "application/json");
+    const response = await fetch(url, {
+      ...this.init,
+      method,
+      headers,
+      body: body === undefined ? undefined : JSON.stringify(body),
+    });
+    if (!response.ok) throw new GatewayError(response.status, await response.text());
+    if (response.status === 204) return undefined as T;
+    return (await response.json()) as T;
+  }
+`
+
+var identifier = regexp.MustCompile(`^[A-Za-z_$][A-Za-z0-9_$]*$`)
+
+// TypeScript returns a TypeScript module with the types of the schemas of the document and a
+// GatewayClient class calling its operations with fetch. The GET routes redirecting the browser,
+// such as the login, get methods returning their URL instead, suffixed with Url.
+func (d *Document) TypeScript() string {
+	var b strings.Builder
+	b.WriteString("// Code generated by sargantana openapi. DO NOT EDIT.\n\n")
+	for _, name := range slices.Sorted(maps.Keys(d.Components.Schemas)) {
+		schema := d.Components.Schemas[name]
+		if schema.Type == "object" && schema.Properties != nil {
+			fmt.Fprintf(&b, "export interface %s %s\n\n", name, typescriptObject(schema, ""))
+		} else {
+			fmt.Fprintf(&b, "export type %s = %s;\n\n", name, typescriptType(schema, ""))
+		}
+	}
+
+	b.WriteString(typescriptRuntime)
+	for _, route := range slices.Sorted(maps.Keys(d.Paths)) {
+		item := *d.Paths[route]
+		for _, method := range slices.Sorted(maps.Keys(item)) {
+			typescriptMethod(&b, strings.ToUpper(method), route, item[method])
+		}
+	}
+	b.WriteString("}\n")
+	return b.String()
+}
+
+// typescriptMethod writes the client method of the operation
+func typescriptMethod(b *strings.Builder, method, route string, op *Operation) {
+	redirect := isRedirect(op)
+	if redirect && method != "GET" {
+		return // Only reached by the browser, e.g. the callbacks posted by the providers
+	}
+
+	var params, query []string
+	for _, p := range op.Parameters {
+		switch p.In {
+		case "path":
+			params = append(params, p.Name+": "+typescriptType(p.Schema, ""))
+		case "query":
+			query = append(query, property(p.Name)+"?: "+typescriptType(p.Schema, ""))
+		}
+	}
+	if len(query) > 0 {
+		params = append(params, "query?: { "+strings.Join(query, "; ")+" }")
+	}
+	var body string
+	if op.RequestBody != nil {
+		if media, ok := op.RequestBody.Content["application/json"]; ok {
+			params = append(params, "body: "+typescriptType(media.Schema, "  "))
+			body = ", body"
+		}
+	}
+
+	url := "this.url(`" + pathParam.ReplaceAllStringFunc(route, func(param string) string {
+		match := pathParam.FindStringSubmatch(param)
+		return "${encodeURIComponent(" + match[1] + match[2] + ")}"
+	}) + "`"
+	if len(query) > 0 {
+		url += ", query"
+	}
+	url += ")"
+
+	b.WriteString("\n")
+	if op.Summary != "" {
+		fmt.Fprintf(b, "  /** %s */\n", op.Summary)
+	}
+	if redirect {
+		fmt.Fprintf(b, "  %sUrl(%s): string {\n    return %s;\n  }\n", op.OperationID, strings.Join(params, ", "), url)
+		return
+	}
+	fmt.Fprintf(b, "  %s(%s): Promise<%s> {\n    return this.request(%q, %s%s);\n  }\n",
+		op.OperationID, strings.Join(params, ", "), responseType(op), method, url, body)
+}
+
+// isRedirect tells whether the operation only redirects
+func isRedirect(op *Operation) bool {
+	_, ok := op.Responses["302"]
+	return ok && !slices.ContainsFunc(slices.Collect(maps.Keys(op.Responses)), func(status string) bool {
+		return strings.HasPrefix(status, "2")
+	})
+}
+
+// responseType returns the type of the first successful JSON response, void if none
+func responseType(op *Operation) string {
+	for _, status := range slices.Sorted(maps.Keys(op.Responses)) {
+		if !strings.HasPrefix(status, "2") {
+			continue
+		}
+		if media, ok := op.Responses[status].Content["application/json"]; ok {
+			return typescriptType(media.Schema, "  ")
+		}
+	}
+	return "void"
+}
+
+func typescriptType(s *Schema, indent string) string {
+	switch {
+	case s == nil:
+		return "unknown"
+	case s.Ref != "":
+		return strings.TrimPrefix(s.Ref, "#/components/schemas/")
+	case len(s.Enum) > 0:
+		values := make([]string, len(s.Enum))
+		for i, value := range s.Enum {
+			values[i] = strconv.Quote(value)
+		}
+		return strings.Join(values, " | ")
+	}
+	switch s.Type {
+	case "string":
+		return "string"
+	case "integer", "number":
+		return "number"
+	case "boolean":
+		return "boolean"
+	case "array":
+		item := typescriptType(s.Items, indent)
+		if strings.Contains(item, " | ") {
+			item = "(" + item + ")"
+		}
+		return item + "[]"
+	case "object":
+		if s.Properties != nil {
+			return typescriptObject(s, indent)
+		}
+		return "Record<string, " + typescriptType(s.AdditionalProperties, indent) + ">"
+	default:
+		return "unknown"
+	}
+}
+
+// typescriptObject returns an object type with a property per line
+func typescriptObject(s *Schema, indent string) string {
+	var b strings.Builder
+	b.WriteString("{\n")
+	for _, name := range slices.Sorted(maps.Keys(s.Properties)) {
+		optional := "?"
+		if slices.Contains(s.Required, name) {
+			optional = ""
+		}
+		fmt.Fprintf(&b, "%s  %s%s: %s;\n", indent, property(name), optional, typescriptType(s.Properties[name], indent+"  "))
+	}
+	b.WriteString(indent + "}")
+	return b.String()
+}
+
+// property quotes the names of properties that are not identifiers
+func property(name string) string {
+	if identifier.MatchString(name) {
+		return name
+	}
+	return strconv.Quote(name)
+}
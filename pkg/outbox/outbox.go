@@ -0,0 +1,168 @@
+// Package outbox publishes events transactionally with database writes. Controllers insert the
+// events into an outbox table in the PostgreSQL transaction of their writes, and a Relay publishes
+// them in the background to a message broker or a webhook, at least once and in insertion order
+// unless they fail:
+//
+//	err := database.WithTx(ctx, pool, func(tx pgx.Tx) error {
+//		if _, err := tx.Exec(ctx, "INSERT INTO orders ...", ...); err != nil {
+//			return err
+//		}
+//		return outbox.Publish(ctx, tx, outbox.Event{Topic: "orders", Key: id, Payload: payload})
+//	})
+package outbox
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/pkg/errors"
+)
+
+// DefaultTable is the name of the outbox table when not configured
+const DefaultTable = "outbox"
+
+const (
+	defaultPollInterval = time.Second
+	defaultBatchSize    = 100
+	defaultMaxAttempts  = 10
+	defaultRetryBackoff = time.Second
+	maxRetryBackoff     = 10 * time.Minute
+)
+
+var tableName = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*(\.[a-zA-Z_][a-zA-Z0-9_]*)?$`)
+
+// Event is a message of the outbox
+type Event struct {
+	ID        int64             `json:"id"` // Set by the outbox, increasing in insertion order
+	Topic     string            `json:"topic"`
+	Key       string            `json:"key,omitempty"` // Partition or ordering key for the brokers supporting it
+	Payload   json.RawMessage   `json:"payload"`
+	Headers   map[string]string `json:"headers,omitempty"`
+	CreatedAt time.Time         `json:"created_at"`
+	Attempts  int               `json:"attempts"` // Failed publications so far
+}
+
+// Config holds the settings of the outbox table and of its relay
+type Config struct {
+	// Table is the outbox table, optionally qualified with its schema. Defaults to DefaultTable.
+	Table string `yaml:"table,omitempty"`
+	// PollInterval is the wait between two relay passes finding no events. Defaults to 1s.
+	PollInterval time.Duration `yaml:"poll_interval,omitempty"`
+	// BatchSize is the maximum number of events published by a relay pass. Defaults to 100.
+	BatchSize int `yaml:"batch_size,omitempty"`
+	// MaxAttempts is the number of failed publications after which events are left in the table,
+	// with their last error, for an operator to handle. Defaults to 10.
+	MaxAttempts int `yaml:"max_attempts,omitempty"`
+	// RetryBackoff is the wait before retrying a failed event, doubled on each failure up to 10
+	// minutes. Defaults to 1s.
+	RetryBackoff time.Duration `yaml:"retry_backoff,omitempty"`
+	// Webhook publishes the events to a webhook, see NewWebhookPublisher
+	Webhook *WebhookConfig `yaml:"webhook,omitempty"`
+}
+
+// Validate checks the outbox settings
+func (c Config) Validate() error {
+	if c.Table != "" && !tableName.MatchString(c.Table) {
+		return errors.Errorf("invalid table name %q", c.Table)
+	}
+	if c.PollInterval < 0 {
+		return errors.New("poll_interval must be non-negative")
+	}
+	if c.BatchSize < 0 {
+		return errors.New("batch_size must be non-negative")
+	}
+	if c.MaxAttempts < 0 {
+		return errors.New("max_attempts must be non-negative")
+	}
+	if c.RetryBackoff < 0 {
+		return errors.New("retry_backoff must be non-negative")
+	}
+	if c.Webhook != nil {
+		if err := c.Webhook.Validate(); err != nil {
+			return errors.Wrap(err, "invalid webhook configuration")
+		}
+	}
+	return nil
+}
+
+func (c Config) table() string {
+	if c.Table == "" {
+		return DefaultTable
+	}
+	return c.Table
+}
+
+// Execer runs statements, e.g. pgx.Tx or *pgxpool.Pool
+type Execer interface {
+	Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error)
+}
+
+// Migrate creates the outbox table and its index if they do not exist
+func (c Config) Migrate(ctx context.Context, db Execer) error {
+	table := c.table()
+	_, err := db.Exec(ctx, fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %[1]s (
+		id BIGSERIAL PRIMARY KEY,
+		topic TEXT NOT NULL,
+		key TEXT NOT NULL DEFAULT '',
+		payload JSONB NOT NULL,
+		headers JSONB NOT NULL DEFAULT '{}',
+		created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+		attempts INT NOT NULL DEFAULT 0,
+		next_attempt_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+		last_error TEXT NOT NULL DEFAULT ''
+	);
+	CREATE INDEX IF NOT EXISTS %[2]s_pending_idx ON %[1]s (next_attempt_at, id)`, table, indexPrefix(table)))
+	return errors.Wrapf(err, "failed to migrate outbox table %s", table)
+}
+
+// Migrate creates the default outbox table, see Config.Migrate
+func Migrate(ctx context.Context, db Execer) error {
+	return Config{}.Migrate(ctx, db)
+}
+
+// Publish inserts an event into the outbox table, in the transaction of the database writes it
+// announces. The event is published by the relay once the transaction commits, and discarded if it
+// rolls back.
+func (c Config) Publish(ctx context.Context, tx Execer, event Event) error {
+	if event.Topic == "" {
+		return errors.New("event topic is required")
+	}
+	if !json.Valid(event.Payload) {
+		return errors.New("event payload must be valid JSON")
+	}
+	headers, err := json.Marshal(event.Headers)
+	if err != nil {
+		return errors.Wrap(err, "failed to encode event headers")
+	}
+	if event.Headers == nil {
+		headers = []byte("{}")
+	}
+	_, err = tx.Exec(ctx, "INSERT INTO "+c.table()+" (topic, key, payload, headers) VALUES ($1, $2, $3, $4)",
+		event.Topic, event.Key, []byte(event.Payload), headers)
+	return errors.Wrap(err, "failed to insert event into the outbox")
+}
+
+// Publish inserts an event into the default outbox table, see Config.Publish
+func Publish(ctx context.Context, tx pgx.Tx, event Event) error {
+	return Config{}.Publish(ctx, tx, event)
+}
+
+// idempotencyKey identifies the event across deliveries
+func (e Event) idempotencyKey() string {
+	return fmt.Sprintf("outbox-%d-%d", e.ID, e.CreatedAt.UnixNano())
+}
+
+// indexPrefix returns the unqualified table name, as index names cannot be qualified
+func indexPrefix(table string) string {
+	for i := len(table) - 1; i >= 0; i-- {
+		if table[i] == '.' {
+			return table[i+1:]
+		}
+	}
+	return table
+}
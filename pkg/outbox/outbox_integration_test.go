@@ -0,0 +1,112 @@
+//go:build integration
+
+package outbox
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+
+	"github.com/animalet/sargantana-go/pkg/database"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Outbox", func() {
+	var (
+		pool *pgxpool.Pool
+		cfg  Config
+	)
+
+	BeforeEach(func() {
+		var err error
+		pool, err = database.PostgresConfig{
+			Host:     "localhost",
+			Port:     5432,
+			User:     "user",
+			Password: "password",
+			Database: "my_blog_db",
+			SSLMode:  "disable",
+		}.CreateClient()
+		Expect(err).NotTo(HaveOccurred())
+		DeferCleanup(pool.Close)
+
+		cfg = Config{Table: "outbox_integration"}
+		Expect(cfg.Migrate(context.Background(), pool)).To(Succeed())
+		Expect(cfg.Migrate(context.Background(), pool)).To(Succeed())
+		DeferCleanup(func() {
+			_, _ = pool.Exec(context.Background(), "DROP TABLE IF EXISTS outbox_integration")
+		})
+	})
+
+	publish := func(events ...Event) error {
+		return database.WithTx(context.Background(), pool, func(tx pgx.Tx) error {
+			for _, event := range events {
+				if err := cfg.Publish(context.Background(), tx, event); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	}
+
+	It("should relay committed events in order and delete them", func() {
+		Expect(publish(
+			Event{Topic: "orders", Key: "1", Payload: json.RawMessage(`{"id":1}`), Headers: map[string]string{"trace": "abc"}},
+			Event{Topic: "orders", Key: "2", Payload: json.RawMessage(`{"id":2}`)},
+		)).To(Succeed())
+
+		var received []Event
+		relay, err := NewRelay(cfg, pool, PublisherFunc(func(_ context.Context, event Event) error {
+			received = append(received, event)
+			return nil
+		}))
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(relay.RelayBatch(context.Background())).To(Equal(2))
+		Expect(received).To(HaveLen(2))
+		Expect(received[0].Key).To(Equal("1"))
+		Expect(received[0].Headers).To(Equal(map[string]string{"trace": "abc"}))
+		Expect(received[0].Payload).To(MatchJSON(`{"id":1}`))
+		Expect(received[1].Key).To(Equal("2"))
+		Expect(received[1].ID).To(BeNumerically(">", received[0].ID))
+
+		Expect(relay.RelayBatch(context.Background())).To(Equal(0))
+	})
+
+	It("should discard the events of rolled back transactions", func() {
+		err := database.WithTx(context.Background(), pool, func(tx pgx.Tx) error {
+			Expect(cfg.Publish(context.Background(), tx, Event{Topic: "orders", Payload: json.RawMessage(`{}`)})).To(Succeed())
+			return errors.New("rollback")
+		})
+		Expect(err).To(MatchError("rollback"))
+
+		relay, err := NewRelay(cfg, pool, PublisherFunc(func(context.Context, Event) error {
+			Fail("no event should be published")
+			return nil
+		}))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(relay.RelayBatch(context.Background())).To(Equal(0))
+	})
+
+	It("should record failures and retry after the backoff", func() {
+		Expect(publish(Event{Topic: "orders", Payload: json.RawMessage(`{}`)})).To(Succeed())
+
+		relay, err := NewRelay(cfg, pool, PublisherFunc(func(context.Context, Event) error {
+			return errors.New("broker unavailable")
+		}))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(relay.RelayBatch(context.Background())).To(Equal(1))
+		// The event is not due again until its backoff elapses
+		Expect(relay.RelayBatch(context.Background())).To(Equal(0))
+
+		var attempts int
+		var lastError string
+		Expect(pool.QueryRow(context.Background(), "SELECT attempts, last_error FROM outbox_integration").
+			Scan(&attempts, &lastError)).To(Succeed())
+		Expect(attempts).To(Equal(1))
+		Expect(lastError).To(Equal("broker unavailable"))
+	})
+})
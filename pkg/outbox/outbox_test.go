@@ -0,0 +1,84 @@
+//go:build unit
+
+package outbox
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+type recordingExecer struct {
+	sql  []string
+	args [][]any
+	err  error
+}
+
+func (e *recordingExecer) Exec(_ context.Context, sql string, args ...any) (pgconn.CommandTag, error) {
+	e.sql = append(e.sql, sql)
+	e.args = append(e.args, args)
+	return pgconn.CommandTag{}, e.err
+}
+
+var _ = Describe("Config", func() {
+	DescribeTable("Validate",
+		func(cfg Config, expected string) {
+			err := cfg.Validate()
+			if expected == "" {
+				Expect(err).NotTo(HaveOccurred())
+			} else {
+				Expect(err).To(MatchError(ContainSubstring(expected)))
+			}
+		},
+		Entry("empty config", Config{}, ""),
+		Entry("qualified table", Config{Table: "events.outbox"}, ""),
+		Entry("invalid table", Config{Table: "outbox; DROP TABLE users"}, "invalid table name"),
+		Entry("negative poll interval", Config{PollInterval: -1}, "poll_interval"),
+		Entry("negative batch size", Config{BatchSize: -1}, "batch_size"),
+		Entry("negative max attempts", Config{MaxAttempts: -1}, "max_attempts"),
+		Entry("negative retry backoff", Config{RetryBackoff: -1}, "retry_backoff"),
+		Entry("invalid webhook", Config{Webhook: &WebhookConfig{URL: "ftp://example.com"}}, "invalid webhook configuration"),
+	)
+
+	It("should migrate the table and its index", func() {
+		db := &recordingExecer{}
+		Expect(Config{Table: "events.outbox"}.Migrate(context.Background(), db)).To(Succeed())
+		Expect(db.sql).To(HaveLen(1))
+		Expect(db.sql[0]).To(ContainSubstring("CREATE TABLE IF NOT EXISTS events.outbox ("))
+		Expect(db.sql[0]).To(ContainSubstring("CREATE INDEX IF NOT EXISTS outbox_pending_idx ON events.outbox"))
+	})
+
+	It("should wrap migration errors", func() {
+		db := &recordingExecer{err: errors.New("boom")}
+		Expect(Migrate(context.Background(), db)).To(MatchError(ContainSubstring("failed to migrate outbox table outbox: boom")))
+	})
+})
+
+var _ = Describe("Publish", func() {
+	It("should insert the event in the configured table", func() {
+		db := &recordingExecer{}
+		event := Event{Topic: "orders", Key: "42", Payload: json.RawMessage(`{"id":42}`), Headers: map[string]string{"trace": "abc"}}
+		Expect(Config{Table: "events"}.Publish(context.Background(), db, event)).To(Succeed())
+		Expect(db.sql).To(ConsistOf(HavePrefix("INSERT INTO events ")))
+		Expect(db.args[0]).To(Equal([]any{"orders", "42", []byte(`{"id":42}`), []byte(`{"trace":"abc"}`)}))
+	})
+
+	It("should store empty headers as an empty object", func() {
+		db := &recordingExecer{}
+		Expect(Config{}.Publish(context.Background(), db, Event{Topic: "orders", Payload: json.RawMessage(`1`)})).To(Succeed())
+		Expect(db.args[0][3]).To(Equal([]byte("{}")))
+	})
+
+	It("should reject events without topic or with invalid payloads", func() {
+		db := &recordingExecer{}
+		Expect(Config{}.Publish(context.Background(), db, Event{Payload: json.RawMessage(`{}`)})).
+			To(MatchError("event topic is required"))
+		Expect(Config{}.Publish(context.Background(), db, Event{Topic: "orders", Payload: json.RawMessage(`{`)})).
+			To(MatchError("event payload must be valid JSON"))
+		Expect(db.sql).To(BeEmpty())
+	})
+})
@@ -0,0 +1,147 @@
+package outbox
+
+import (
+	"context"
+	"time"
+
+	"github.com/animalet/sargantana-go/pkg/database"
+	"github.com/jackc/pgx/v5"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/rs/zerolog/log"
+)
+
+var relayedEvents = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "sargantana_outbox_events_total",
+	Help: "Number of outbox events relayed, by table and result (published or failed).",
+}, []string{"table", "result"})
+
+// Publisher sends the events of the outbox to a message broker or a webhook. Publish must return
+// once the event is durably accepted, as the event is then removed from the outbox.
+type Publisher interface {
+	Publish(ctx context.Context, event Event) error
+}
+
+// PublisherFunc adapts a function to a Publisher, e.g. for a Kafka or NATS client:
+//
+//	outbox.PublisherFunc(func(ctx context.Context, e outbox.Event) error {
+//		return js.Publish(ctx, e.Topic, e.Payload) // NATS JetStream
+//	})
+type PublisherFunc func(ctx context.Context, event Event) error
+
+func (f PublisherFunc) Publish(ctx context.Context, event Event) error {
+	return f(ctx, event)
+}
+
+// Relay publishes the events of an outbox table. Events are locked while they are published, so
+// several relays, e.g. one per replica of the server, can share a table.
+type Relay struct {
+	config    Config
+	db        database.TxBeginner
+	publisher Publisher
+}
+
+// NewRelay creates a relay of the outbox table of the config. Without publisher, the events are
+// published to the webhook of the config.
+func NewRelay(cfg Config, db database.TxBeginner, publisher Publisher) (*Relay, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	if publisher == nil {
+		if cfg.Webhook == nil {
+			return nil, errors.New("outbox relay requires a publisher or a webhook")
+		}
+		publisher = NewWebhookPublisher(*cfg.Webhook)
+	}
+	if cfg.PollInterval == 0 {
+		cfg.PollInterval = defaultPollInterval
+	}
+	if cfg.BatchSize == 0 {
+		cfg.BatchSize = defaultBatchSize
+	}
+	if cfg.MaxAttempts == 0 {
+		cfg.MaxAttempts = defaultMaxAttempts
+	}
+	if cfg.RetryBackoff == 0 {
+		cfg.RetryBackoff = defaultRetryBackoff
+	}
+	return &Relay{config: cfg, db: db, publisher: publisher}, nil
+}
+
+// Run relays the events until the context is done, waiting for the poll interval when the outbox
+// has no more events to publish
+func (r *Relay) Run(ctx context.Context) {
+	for {
+		relayed, err := r.RelayBatch(ctx)
+		if err != nil && ctx.Err() == nil {
+			log.Error().Err(err).Str("table", r.config.table()).Msg("Failed to relay outbox events")
+		}
+		if relayed < r.config.BatchSize {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(r.config.PollInterval):
+			}
+		} else if ctx.Err() != nil {
+			return
+		}
+	}
+}
+
+// RelayBatch publishes the next batch of due events, in insertion order, and returns the number of
+// events tried. Published events are deleted; failed ones are retried after a backoff.
+func (r *Relay) RelayBatch(ctx context.Context) (int, error) {
+	table := r.config.table()
+	var relayed int
+	err := database.WithTx(ctx, r.db, func(tx pgx.Tx) error {
+		rows, err := tx.Query(ctx, "SELECT id, topic, key, payload, headers, created_at, attempts FROM "+table+
+			" WHERE next_attempt_at <= now() AND attempts < $1 ORDER BY id LIMIT $2 FOR UPDATE SKIP LOCKED",
+			r.config.MaxAttempts, r.config.BatchSize)
+		if err != nil {
+			return errors.Wrap(err, "failed to query outbox events")
+		}
+		events, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (Event, error) {
+			var e Event
+			err := row.Scan(&e.ID, &e.Topic, &e.Key, &e.Payload, &e.Headers, &e.CreatedAt, &e.Attempts)
+			return e, err
+		})
+		if err != nil {
+			return errors.Wrap(err, "failed to read outbox events")
+		}
+
+		relayed = len(events)
+		published := make([]int64, 0, len(events))
+		for _, event := range events {
+			if publishErr := r.publisher.Publish(ctx, event); publishErr != nil {
+				relayedEvents.WithLabelValues(table, "failed").Inc()
+				log.Warn().Err(publishErr).Str("table", table).Int64("event", event.ID).Int("attempts", event.Attempts+1).
+					Msg("Failed to publish outbox event")
+				if _, err := tx.Exec(ctx, "UPDATE "+table+" SET attempts = attempts + 1, last_error = $2, "+
+					"next_attempt_at = now() + make_interval(secs => $3) WHERE id = $1",
+					event.ID, publishErr.Error(), r.backoff(event.Attempts).Seconds()); err != nil {
+					return errors.Wrap(err, "failed to record outbox event failure")
+				}
+				continue
+			}
+			relayedEvents.WithLabelValues(table, "published").Inc()
+			published = append(published, event.ID)
+		}
+		if len(published) > 0 {
+			if _, err := tx.Exec(ctx, "DELETE FROM "+table+" WHERE id = ANY($1)", published); err != nil {
+				return errors.Wrap(err, "failed to delete published outbox events")
+			}
+		}
+		return nil
+	})
+	return relayed, err
+}
+
+// backoff returns the wait before retrying an event after its attempts failed publications
+func (r *Relay) backoff(attempts int) time.Duration {
+	backoff := r.config.RetryBackoff
+	for i := 0; i < attempts && backoff < maxRetryBackoff; i++ {
+		backoff *= 2
+	}
+	return min(backoff, maxRetryBackoff)
+}
@@ -0,0 +1,50 @@
+//go:build unit
+
+package outbox
+
+import (
+	"context"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Relay", func() {
+	noop := PublisherFunc(func(context.Context, Event) error { return nil })
+
+	It("should apply the defaults", func() {
+		relay, err := NewRelay(Config{}, nil, noop)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(relay.config.PollInterval).To(Equal(time.Second))
+		Expect(relay.config.BatchSize).To(Equal(100))
+		Expect(relay.config.MaxAttempts).To(Equal(10))
+		Expect(relay.config.RetryBackoff).To(Equal(time.Second))
+	})
+
+	It("should publish to the webhook without publisher", func() {
+		relay, err := NewRelay(Config{Webhook: &WebhookConfig{URL: "https://example.com/events"}}, nil, nil)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(relay.publisher).To(BeAssignableToTypeOf(&webhookPublisher{}))
+	})
+
+	It("should require a publisher or a webhook", func() {
+		_, err := NewRelay(Config{}, nil, nil)
+		Expect(err).To(MatchError("outbox relay requires a publisher or a webhook"))
+	})
+
+	It("should reject invalid configs", func() {
+		_, err := NewRelay(Config{BatchSize: -1}, nil, noop)
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("should double the backoff up to its maximum", func() {
+		relay, err := NewRelay(Config{RetryBackoff: time.Minute}, nil, noop)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(relay.backoff(0)).To(Equal(time.Minute))
+		Expect(relay.backoff(1)).To(Equal(2 * time.Minute))
+		Expect(relay.backoff(3)).To(Equal(8 * time.Minute))
+		Expect(relay.backoff(4)).To(Equal(10 * time.Minute))
+		Expect(relay.backoff(100)).To(Equal(10 * time.Minute))
+	})
+})
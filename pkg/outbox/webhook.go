@@ -0,0 +1,77 @@
+package outbox
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const defaultWebhookTimeout = 10 * time.Second
+
+// WebhookConfig configures the webhook events are POSTed to, as JSON Event documents
+type WebhookConfig struct {
+	URL     string            `yaml:"url"`
+	Headers map[string]string `yaml:"headers,omitempty"`
+	// Timeout of each request. Defaults to 10s.
+	Timeout time.Duration `yaml:"timeout,omitempty"`
+}
+
+func (c WebhookConfig) Validate() error {
+	parsed, err := url.ParseRequestURI(c.URL)
+	if err != nil {
+		return errors.Wrap(err, "invalid url")
+	}
+	if parsed.Scheme != "http" && parsed.Scheme != "https" {
+		return errors.New("url must use http or https")
+	}
+	if c.Timeout < 0 {
+		return errors.New("timeout must be non-negative")
+	}
+	return nil
+}
+
+type webhookPublisher struct {
+	config WebhookConfig
+	client *http.Client
+}
+
+// NewWebhookPublisher returns a publisher POSTing the events to a webhook. Events are published
+// once the webhook responds with a 2xx status. The Idempotency-Key header holds the event ID, for
+// the webhook to discard the events delivered more than once.
+func NewWebhookPublisher(cfg WebhookConfig) Publisher {
+	timeout := cfg.Timeout
+	if timeout == 0 {
+		timeout = defaultWebhookTimeout
+	}
+	return &webhookPublisher{config: cfg, client: &http.Client{Timeout: timeout}}
+}
+
+func (w *webhookPublisher) Publish(ctx context.Context, event Event) error {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return errors.Wrap(err, "failed to encode event")
+	}
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, w.config.URL, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("Idempotency-Key", event.idempotencyKey())
+	for key, value := range w.config.Headers {
+		request.Header.Set(key, value)
+	}
+	response, err := w.client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = response.Body.Close() }()
+	if response.StatusCode < 200 || response.StatusCode >= 300 {
+		return errors.Errorf("webhook responded with status %d", response.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,76 @@
+//go:build unit
+
+package outbox
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Webhook publisher", func() {
+	var (
+		status   int
+		requests []*http.Request
+		bodies   [][]byte
+		server   *httptest.Server
+	)
+
+	BeforeEach(func() {
+		status = http.StatusAccepted
+		requests, bodies = nil, nil
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body, _ := io.ReadAll(r.Body)
+			requests = append(requests, r)
+			bodies = append(bodies, body)
+			w.WriteHeader(status)
+		}))
+		DeferCleanup(server.Close)
+	})
+
+	DescribeTable("Validate",
+		func(cfg WebhookConfig, expected string) {
+			err := cfg.Validate()
+			if expected == "" {
+				Expect(err).NotTo(HaveOccurred())
+			} else {
+				Expect(err).To(MatchError(ContainSubstring(expected)))
+			}
+		},
+		Entry("https url", WebhookConfig{URL: "https://example.com/events"}, ""),
+		Entry("missing url", WebhookConfig{}, "invalid url"),
+		Entry("unsupported scheme", WebhookConfig{URL: "ftp://example.com"}, "url must use http or https"),
+		Entry("negative timeout", WebhookConfig{URL: "https://example.com", Timeout: -time.Second}, "timeout"),
+	)
+
+	It("should POST the event as JSON", func() {
+		publisher := NewWebhookPublisher(WebhookConfig{URL: server.URL, Headers: map[string]string{"Authorization": "Bearer token"}})
+		event := Event{ID: 7, Topic: "orders", Payload: json.RawMessage(`{"id":42}`), CreatedAt: time.Unix(10, 0)}
+		Expect(publisher.Publish(context.Background(), event)).To(Succeed())
+
+		Expect(requests).To(HaveLen(1))
+		Expect(requests[0].Method).To(Equal(http.MethodPost))
+		Expect(requests[0].Header.Get("Content-Type")).To(Equal("application/json"))
+		Expect(requests[0].Header.Get("Authorization")).To(Equal("Bearer token"))
+		Expect(requests[0].Header.Get("Idempotency-Key")).To(Equal(event.idempotencyKey()))
+
+		var received Event
+		Expect(json.Unmarshal(bodies[0], &received)).To(Succeed())
+		Expect(received.ID).To(Equal(int64(7)))
+		Expect(received.Topic).To(Equal("orders"))
+		Expect(received.Payload).To(MatchJSON(`{"id":42}`))
+	})
+
+	It("should fail on non 2xx responses", func() {
+		status = http.StatusServiceUnavailable
+		publisher := NewWebhookPublisher(WebhookConfig{URL: server.URL})
+		Expect(publisher.Publish(context.Background(), Event{Topic: "orders", Payload: json.RawMessage(`{}`)})).
+			To(MatchError("webhook responded with status 503"))
+	})
+})
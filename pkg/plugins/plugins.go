@@ -0,0 +1,111 @@
+// Package plugins loads controller types shipped outside the gateway binary, so that proprietary
+// controllers are deployed without forking it. Two kinds of plugins are supported:
+//
+//   - Go plugins, shared libraries built with go build -buildmode=plugin exporting a Register
+//     function that registers their controller types with server.RegisterController. They run in
+//     the gateway process, and must be built with the same Go toolchain and module versions as
+//     the gateway. Only supported on Linux, FreeBSD and macOS, with cgo.
+//   - Process plugins, executables started for each controller binding of their type and serving
+//     HTTP, to which the gateway forwards the requests of the binding. They may be written in any
+//     language, see ProcessConfig for the protocol.
+//
+// The plugins are loaded from the plugins configuration section, before the server starts:
+//
+//	pluginsCfg, err := config.Get[plugins.Config](cfg, "plugins")
+//	if err := plugins.Load(*pluginsCfg); err != nil { ... }
+package plugins
+
+import (
+	"plugin"
+	"slices"
+
+	"github.com/animalet/sargantana-go/pkg/server"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+)
+
+// RegisterSymbol is the function exported by Go plugins, of type func() error, registering their
+// controller types.
+const RegisterSymbol = "Register"
+
+// Config is the plugins configuration section.
+type Config struct {
+	// Libraries are the Go plugins to load, see LibraryConfig.
+	Libraries []LibraryConfig `yaml:"libraries,omitempty"`
+	// Processes are the controller types served by process plugins, see ProcessConfig.
+	Processes []ProcessConfig `yaml:"processes,omitempty"`
+}
+
+func (c Config) Validate() error {
+	for i, library := range c.Libraries {
+		if err := library.Validate(); err != nil {
+			return errors.Wrapf(err, "invalid library %d", i)
+		}
+	}
+	types := make([]string, 0, len(c.Processes))
+	for i, process := range c.Processes {
+		if err := process.Validate(); err != nil {
+			return errors.Wrapf(err, "invalid process %d", i)
+		}
+		if slices.Contains(types, process.Type) {
+			return errors.Errorf("controller type %q is served by several processes", process.Type)
+		}
+		types = append(types, process.Type)
+	}
+	return nil
+}
+
+// LibraryConfig is a Go plugin.
+type LibraryConfig struct {
+	// Path is the path of the shared library, e.g. /usr/lib/sargantana/billing.so.
+	Path string `yaml:"path"`
+}
+
+func (c LibraryConfig) Validate() error {
+	if c.Path == "" {
+		return errors.New("path must be set and non-empty")
+	}
+	return nil
+}
+
+// Load loads the Go plugins, and registers the controller types of the process plugins. It must be
+// called before the server starts, after the built-in controller types are registered, which the
+// plugins may override.
+func Load(cfg Config) error {
+	for _, library := range cfg.Libraries {
+		if err := loadLibrary(library.Path); err != nil {
+			return err
+		}
+	}
+	for _, process := range cfg.Processes {
+		registerProcess(process)
+	}
+	return nil
+}
+
+func loadLibrary(path string) error {
+	before := server.ControllerTypes()
+	p, err := plugin.Open(path)
+	if err != nil {
+		return errors.Wrapf(err, "failed to open plugin %s", path)
+	}
+	symbol, err := p.Lookup(RegisterSymbol)
+	if err != nil {
+		return errors.Wrapf(err, "plugin %s does not export %s", path, RegisterSymbol)
+	}
+	register, ok := symbol.(func() error)
+	if !ok {
+		return errors.Errorf("%s of plugin %s is a %T, not a func() error", RegisterSymbol, path, symbol)
+	}
+	if err := register(); err != nil {
+		return errors.Wrapf(err, "failed to register the controllers of plugin %s", path)
+	}
+	var added []string
+	for _, typeName := range server.ControllerTypes() {
+		if !slices.Contains(before, typeName) {
+			added = append(added, typeName)
+		}
+	}
+	log.Info().Str("plugin", path).Strs("types", added).Msgf("Loaded %d controller types from plugin", len(added))
+	return nil
+}
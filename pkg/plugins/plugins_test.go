@@ -0,0 +1,148 @@
+//go:build unit
+
+package plugins
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"time"
+
+	"github.com/animalet/sargantana-go/pkg/server"
+	"github.com/gin-gonic/gin"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Plugins", func() {
+	testPlugin := func(mode string) ProcessConfig {
+		return ProcessConfig{
+			Type:    "billing",
+			Command: os.Args[0],
+			Args:    []string{"-test.run=TestPlugins"},
+			Env:     map[string]string{testPluginEnv: mode, "CUSTOM": "value"},
+		}
+	}
+
+	// get requests the path through a listening server, since the proxy needs a ResponseWriter
+	// implementing http.CloseNotifier
+	get := func(engine *gin.Engine, path string) map[string]string {
+		gateway := httptest.NewServer(engine)
+		defer gateway.Close()
+		request, err := http.NewRequest(http.MethodGet, gateway.URL+path, nil)
+		Expect(err).NotTo(HaveOccurred())
+		request.Header.Set(RemoteUserHeader, "mallory")
+		response, err := http.DefaultClient.Do(request)
+		Expect(err).NotTo(HaveOccurred())
+		defer func() { _ = response.Body.Close() }()
+		Expect(response.StatusCode).To(Equal(http.StatusOK))
+		var body map[string]string
+		Expect(json.NewDecoder(response.Body).Decode(&body)).To(Succeed())
+		return body
+	}
+
+	Context("Configuration", func() {
+		It("should validate the plugins configuration", func() {
+			Expect(Config{}.Validate()).To(Succeed())
+			Expect(Config{Libraries: []LibraryConfig{{}}}.Validate()).To(MatchError(ContainSubstring("path must be set")))
+			Expect(Config{Processes: []ProcessConfig{{Command: "/bin/billing"}}}.Validate()).To(MatchError(ContainSubstring("type must be set")))
+			Expect(Config{Processes: []ProcessConfig{{Type: "billing"}}}.Validate()).To(MatchError(ContainSubstring("command must be set")))
+			Expect(Config{Processes: []ProcessConfig{{Type: "billing", Command: "/bin/billing", StartTimeout: -1}}}.Validate()).
+				To(MatchError(ContainSubstring("start_timeout")))
+			Expect(Config{Processes: []ProcessConfig{{Type: "billing", Command: "/bin/billing", StopTimeout: -1}}}.Validate()).
+				To(MatchError(ContainSubstring("stop_timeout")))
+			Expect(Config{Processes: []ProcessConfig{
+				{Type: "billing", Command: "/bin/billing"},
+				{Type: "billing", Command: "/bin/invoices"},
+			}}.Validate()).To(MatchError(ContainSubstring("served by several processes")))
+			Expect(ProcessControllerConfig{}.Validate()).To(MatchError(ContainSubstring("path must be set")))
+		})
+
+		It("should parse the handshake lines", func() {
+			network, address, err := parseHandshake("1|tcp|127.0.0.1:4000\n")
+			Expect(err).NotTo(HaveOccurred())
+			Expect([]string{network, address}).To(Equal([]string{"tcp", "127.0.0.1:4000"}))
+			_, _, err = parseHandshake("1|tcp")
+			Expect(err).To(MatchError(ContainSubstring("expected version|network|address")))
+			_, _, err = parseHandshake("1|udp|127.0.0.1:4000")
+			Expect(err).To(MatchError(ContainSubstring("unsupported network")))
+			_, _, err = parseHandshake("1|unix|")
+			Expect(err).To(MatchError(ContainSubstring("empty address")))
+		})
+	})
+
+	It("should fail to load a missing Go plugin", func() {
+		err := Load(Config{Libraries: []LibraryConfig{{Path: "testdata/missing.so"}}})
+		Expect(err).To(MatchError(ContainSubstring("failed to open plugin testdata/missing.so")))
+	})
+
+	It("should register the controller types of the process plugins", func() {
+		Expect(Load(Config{Processes: []ProcessConfig{testPlugin("tcp")}})).To(Succeed())
+		Expect(server.ControllerTypes()).To(ContainElement("billing"))
+	})
+
+	It("should forward the requests of the binding to its process", func() {
+		ctrl, err := newProcessController(testPlugin("tcp"), ProcessControllerConfig{
+			Path:     "/billing",
+			Auth:     true,
+			Settings: map[string]any{"currency": "EUR"},
+		}, "invoices")
+		Expect(err).NotTo(HaveOccurred())
+		DeferCleanup(ctrl.Close)
+		engine := gin.New()
+		Expect(ctrl.Bind(engine, func(c *gin.Context) {
+			c.Set(server.RemoteUserKey, "alice")
+			c.Set(server.RolesKey, []string{"admin", "billing"})
+		})).To(Succeed())
+
+		Expect(get(engine, "/billing/invoices/42")).To(Equal(map[string]string{
+			"path":     "/billing/invoices/42",
+			"user":     "alice",
+			"roles":    "admin,billing",
+			"name":     "invoices",
+			"protocol": "1",
+			"settings": `{"currency":"EUR"}`,
+			"custom":   "value",
+		}))
+		Expect(ctrl.HealthCheck(context.Background())).To(Succeed())
+
+		By("stopping the process on close")
+		Expect(ctrl.Close()).To(Succeed())
+		Expect(ctrl.HealthCheck(context.Background())).To(MatchError(ContainSubstring("plugin process exited")))
+	})
+
+	It("should forward the requests to processes listening on unix sockets", func() {
+		dir, err := os.MkdirTemp("", "plugin")
+		Expect(err).NotTo(HaveOccurred())
+		DeferCleanup(os.RemoveAll, dir)
+		cfg := testPlugin("unix")
+		cfg.Env["SOCKET_DIR"] = dir
+		ctrl, err := newProcessController(cfg, ProcessControllerConfig{Path: "/billing"}, "invoices")
+		Expect(err).NotTo(HaveOccurred())
+		DeferCleanup(ctrl.Close)
+		engine := gin.New()
+		Expect(ctrl.Bind(engine, nil)).To(Succeed())
+
+		body := get(engine, "/billing/")
+		Expect(body["path"]).To(Equal("/billing/"))
+		Expect(body["user"]).To(BeEmpty())
+		Expect(ctrl.HealthCheck(context.Background())).To(Succeed())
+	})
+
+	It("should fail when the process does not complete its handshake", func() {
+		cfg := testPlugin("silent")
+		cfg.StartTimeout = 200 * time.Millisecond
+		_, err := newProcessController(cfg, ProcessControllerConfig{Path: "/billing"}, "invoices")
+		Expect(err).To(MatchError(ContainSubstring("did not write its handshake within 200ms")))
+
+		_, err = newProcessController(testPlugin("invalid"), ProcessControllerConfig{Path: "/billing"}, "invoices")
+		Expect(err).To(MatchError(ContainSubstring("unsupported protocol version")))
+
+		cfg = testPlugin("tcp")
+		cfg.Command = "/nonexistent/plugin"
+		_, err = newProcessController(cfg, ProcessControllerConfig{Path: "/billing"}, "invoices")
+		Expect(err).To(MatchError(ContainSubstring("failed to start plugin invoices")))
+	})
+})
@@ -0,0 +1,366 @@
+package plugins
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"maps"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"os"
+	"os/exec"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/animalet/sargantana-go/pkg/server"
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+)
+
+// ProtocolVersion is the version of the process plugin protocol, see ProcessConfig.
+const ProtocolVersion = 1
+
+// Environment variables of the process plugins, see ProcessConfig.
+const (
+	ProtocolEnv = "SARGANTANA_PLUGIN_PROTOCOL"
+	NameEnv     = "SARGANTANA_PLUGIN_NAME"
+	SettingsEnv = "SARGANTANA_PLUGIN_SETTINGS"
+)
+
+// Headers of the requests forwarded to the process plugins, see ProcessConfig. The values sent by
+// the clients are removed.
+const (
+	RemoteUserHeader  = "X-Remote-User"
+	RemoteRolesHeader = "X-Remote-Roles"
+)
+
+const (
+	defaultStartTimeout = 10 * time.Second
+	defaultStopTimeout  = 5 * time.Second
+)
+
+// ProcessConfig is a controller type served by a process plugin. A process is started for each
+// controller binding of the type, with the environment of the gateway, Env, and:
+//
+//   - SARGANTANA_PLUGIN_PROTOCOL, the protocol version, 1
+//   - SARGANTANA_PLUGIN_NAME, the name of the controller binding
+//   - SARGANTANA_PLUGIN_SETTINGS, the settings of the binding as a JSON object, see
+//     ProcessControllerConfig
+//
+// Once it listens, the process writes a handshake line to its standard output with the protocol
+// version, the network and the address it serves HTTP on, e.g. "1|tcp|127.0.0.1:4000" or
+// "1|unix|/run/billing.sock". The requests of the binding are then forwarded to it unchanged, with
+// the user name and roles set by the authenticators in the X-Remote-User and X-Remote-Roles
+// headers. The other lines of its outputs are logged. On shutdown, the process is sent SIGTERM,
+// and killed after StopTimeout. Processes exiting while the server runs are not restarted, and
+// fail the health check of their controller.
+type ProcessConfig struct {
+	// Type is the controller type served by the process, used in the controller bindings.
+	Type string `yaml:"type"`
+	// Command is the path of the executable.
+	Command string `yaml:"command"`
+	// Args are the arguments of the command.
+	Args []string `yaml:"args,omitempty"`
+	// Env adds environment variables to the process.
+	Env map[string]string `yaml:"env,omitempty"`
+	// StartTimeout bounds the wait for the handshake line. Defaults to 10s.
+	StartTimeout time.Duration `yaml:"start_timeout,omitempty"`
+	// StopTimeout is the time given to the process to exit after SIGTERM. Defaults to 5s.
+	StopTimeout time.Duration `yaml:"stop_timeout,omitempty"`
+}
+
+func (c ProcessConfig) Validate() error {
+	if c.Type == "" {
+		return errors.New("type must be set and non-empty")
+	}
+	if c.Command == "" {
+		return errors.New("command must be set and non-empty")
+	}
+	if c.StartTimeout < 0 {
+		return errors.New("start_timeout must not be negative")
+	}
+	if c.StopTimeout < 0 {
+		return errors.New("stop_timeout must not be negative")
+	}
+	return nil
+}
+
+// ProcessControllerConfig is the configuration of the controller bindings of the types served by
+// process plugins.
+type ProcessControllerConfig struct {
+	// Path is the path prefix of the requests forwarded to the process.
+	Path string `yaml:"path"`
+	// Auth requires the users to be authenticated.
+	Auth bool `yaml:"auth"`
+	// Settings are passed to the process in SARGANTANA_PLUGIN_SETTINGS.
+	Settings map[string]any `yaml:"settings,omitempty"`
+}
+
+func (c ProcessControllerConfig) Validate() error {
+	if c.Path == "" {
+		return errors.New("path must be set and non-empty")
+	}
+	return nil
+}
+
+func registerProcess(cfg ProcessConfig) {
+	server.RegisterController(cfg.Type, func(c *ProcessControllerConfig, ctx server.ControllerContext) (server.IController, error) {
+		return newProcessController(cfg, *c, ctx.Name)
+	})
+}
+
+// process is a running process plugin
+type process struct {
+	name        string
+	cmd         *exec.Cmd
+	network     string
+	address     string
+	stopTimeout time.Duration
+	stopping    atomic.Bool
+	exited      chan struct{}
+	err         error // Set before exited is closed
+}
+
+func startProcess(cfg ProcessConfig, name string, settings map[string]any) (*process, error) {
+	if settings == nil {
+		settings = map[string]any{}
+	}
+	encodedSettings, err := json.Marshal(settings)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to encode the settings of plugin %s", name)
+	}
+
+	// #nosec G204 -- the command is set in the configuration
+	cmd := exec.Command(cfg.Command, cfg.Args...)
+	cmd.Env = os.Environ()
+	for _, key := range slices.Sorted(maps.Keys(cfg.Env)) {
+		cmd.Env = append(cmd.Env, key+"="+cfg.Env[key])
+	}
+	cmd.Env = append(cmd.Env,
+		ProtocolEnv+"="+strconv.Itoa(ProtocolVersion),
+		NameEnv+"="+name,
+		SettingsEnv+"="+string(encodedSettings),
+	)
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to start plugin %s", name)
+	}
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to start plugin %s", name)
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, errors.Wrapf(err, "failed to start plugin %s", name)
+	}
+
+	stopTimeout := cfg.StopTimeout
+	if stopTimeout == 0 {
+		stopTimeout = defaultStopTimeout
+	}
+	p := &process{name: name, cmd: cmd, stopTimeout: stopTimeout, exited: make(chan struct{})}
+	handshake := make(chan string, 1)
+	var outputs sync.WaitGroup
+	outputs.Add(2)
+	go func() {
+		defer outputs.Done()
+		p.readOutput(stdout, handshake)
+	}()
+	go func() {
+		defer outputs.Done()
+		p.readOutput(stderr, nil)
+	}()
+	go func() {
+		// The outputs must be read before waiting for the process
+		outputs.Wait()
+		p.err = cmd.Wait()
+		if !p.stopping.Load() {
+			log.Error().Err(p.err).Str("plugin", name).Msg("Plugin process exited")
+		}
+		close(p.exited)
+	}()
+
+	startTimeout := cfg.StartTimeout
+	if startTimeout == 0 {
+		startTimeout = defaultStartTimeout
+	}
+	timer := time.NewTimer(startTimeout)
+	defer timer.Stop()
+	select {
+	case line := <-handshake:
+		if p.network, p.address, err = parseHandshake(line); err != nil {
+			_ = p.stop()
+			return nil, errors.Wrapf(err, "invalid handshake of plugin %s", name)
+		}
+	case <-p.exited:
+		return nil, errors.Errorf("plugin %s exited before its handshake: %v", name, p.err)
+	case <-timer.C:
+		_ = p.stop()
+		return nil, errors.Errorf("plugin %s did not write its handshake within %s", name, startTimeout)
+	}
+	log.Info().Str("plugin", name).Str("network", p.network).Str("address", p.address).Int("pid", cmd.Process.Pid).
+		Msg("Plugin process started")
+	return p, nil
+}
+
+// readOutput logs the lines of an output of the process, sending the first one to handshake if
+// not nil
+func (p *process) readOutput(output io.Reader, handshake chan<- string) {
+	scanner := bufio.NewScanner(output)
+	for scanner.Scan() {
+		if handshake != nil {
+			handshake <- scanner.Text()
+			handshake = nil
+			continue
+		}
+		log.Info().Str("plugin", p.name).Msg(scanner.Text())
+	}
+}
+
+// parseHandshake returns the network and the address of a handshake line
+func parseHandshake(line string) (string, string, error) {
+	parts := strings.Split(strings.TrimSpace(line), "|")
+	if len(parts) != 3 {
+		return "", "", errors.Errorf("expected version|network|address, got %q", line)
+	}
+	if parts[0] != strconv.Itoa(ProtocolVersion) {
+		return "", "", errors.Errorf("unsupported protocol version %q, expected %d", parts[0], ProtocolVersion)
+	}
+	if parts[1] != "tcp" && parts[1] != "unix" {
+		return "", "", errors.Errorf("unsupported network %q, expected tcp or unix", parts[1])
+	}
+	if parts[2] == "" {
+		return "", "", errors.New("empty address")
+	}
+	return parts[1], parts[2], nil
+}
+
+// stop sends SIGTERM to the process, killing it if it does not exit within the stop timeout
+func (p *process) stop() error {
+	p.stopping.Store(true)
+	select {
+	case <-p.exited:
+		return nil
+	default:
+	}
+	if err := p.cmd.Process.Signal(syscall.SIGTERM); err != nil {
+		// Windows does not support SIGTERM
+		return p.kill()
+	}
+	timer := time.NewTimer(p.stopTimeout)
+	defer timer.Stop()
+	select {
+	case <-p.exited:
+		return nil
+	case <-timer.C:
+		log.Warn().Str("plugin", p.name).Dur("timeout", p.stopTimeout).Msg("Plugin process did not exit in time, killing it")
+		return p.kill()
+	}
+}
+
+func (p *process) kill() error {
+	if err := p.cmd.Process.Kill(); err != nil && !errors.Is(err, os.ErrProcessDone) {
+		return errors.Wrapf(err, "failed to kill plugin %s", p.name)
+	}
+	<-p.exited
+	return nil
+}
+
+// processController forwards the requests of a controller binding to its process plugin
+type processController struct {
+	process *process
+	path    string
+	auth    bool
+	proxy   *httputil.ReverseProxy
+}
+
+func newProcessController(cfg ProcessConfig, c ProcessControllerConfig, name string) (*processController, error) {
+	p, err := startProcess(cfg, name, c.Settings)
+	if err != nil {
+		return nil, err
+	}
+	target := &url.URL{Scheme: "http", Host: p.address}
+	transport := &http.Transport{MaxIdleConnsPerHost: 10}
+	if p.network == "unix" {
+		target.Host = "plugin"
+		transport.DialContext = func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var dialer net.Dialer
+			return dialer.DialContext(ctx, "unix", p.address)
+		}
+	}
+	proxy := &httputil.ReverseProxy{
+		Rewrite: func(r *httputil.ProxyRequest) {
+			r.SetURL(target)
+			r.SetXForwarded()
+		},
+		Transport: transport,
+		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+			if r.Context().Err() != nil {
+				log.Debug().Err(err).Str("plugin", name).Msg("Client disconnected while forwarding to plugin")
+			} else {
+				log.Error().Err(err).Str("plugin", name).Msg("Error forwarding to plugin")
+			}
+			w.WriteHeader(http.StatusBadGateway)
+		},
+	}
+	log.Info().Str("plugin", name).Str("type", cfg.Type).Str("path", c.Path).Bool("auth", c.Auth).Msg("Plugin controller configured")
+	return &processController{
+		process: p,
+		path:    strings.TrimSuffix(c.Path, "/") + "/*proxyPath",
+		auth:    c.Auth,
+		proxy:   proxy,
+	}, nil
+}
+
+func (p *processController) Bind(engine *gin.Engine, loginMiddleware gin.HandlerFunc) error {
+	return p.BindGroup(&engine.RouterGroup, loginMiddleware)
+}
+
+func (p *processController) BindGroup(group *gin.RouterGroup, loginMiddleware gin.HandlerFunc) error {
+	if p.auth {
+		group.Any(p.path, loginMiddleware, p.forward)
+	} else {
+		group.Any(p.path, p.forward)
+	}
+	return nil
+}
+
+func (p *processController) forward(c *gin.Context) {
+	c.Request.Header.Del(RemoteUserHeader)
+	c.Request.Header.Del(RemoteRolesHeader)
+	if user, ok := server.GetRemoteUser(c); ok {
+		c.Request.Header.Set(RemoteUserHeader, user)
+	}
+	if roles := server.GetRoles(c); len(roles) > 0 {
+		c.Request.Header.Set(RemoteRolesHeader, strings.Join(roles, ","))
+	}
+	p.proxy.ServeHTTP(c.Writer, c.Request)
+}
+
+// HealthCheck fails when the process has exited or does not accept connections, see
+// server.HealthReporter.
+func (p *processController) HealthCheck(ctx context.Context) error {
+	select {
+	case <-p.process.exited:
+		return errors.Errorf("plugin process exited: %v", p.process.err)
+	default:
+	}
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, p.process.network, p.process.address)
+	if err != nil {
+		return errors.Wrapf(err, "plugin %s is unreachable", p.process.address)
+	}
+	return conn.Close()
+}
+
+func (p *processController) Close() error {
+	return p.process.stop()
+}
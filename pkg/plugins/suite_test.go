@@ -0,0 +1,63 @@
+package plugins
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+// testPluginEnv makes the test binary run as a process plugin, see serveTestPlugin
+const testPluginEnv = "SARGANTANA_TEST_PLUGIN"
+
+func TestPlugins(t *testing.T) {
+	if mode := os.Getenv(testPluginEnv); mode != "" {
+		serveTestPlugin(mode)
+		return
+	}
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Plugins Suite")
+}
+
+// serveTestPlugin serves the requests with their path, identity headers and the plugin
+// environment, listening on tcp or unix, or writes a silent or invalid handshake
+func serveTestPlugin(mode string) {
+	var listener net.Listener
+	var err error
+	switch mode {
+	case "silent":
+		time.Sleep(time.Minute)
+		return
+	case "invalid":
+		fmt.Println("2|tcp|127.0.0.1:1")
+		time.Sleep(time.Minute)
+		return
+	case "unix":
+		listener, err = net.Listen("unix", filepath.Join(os.Getenv("SOCKET_DIR"), "plugin.sock"))
+	default:
+		listener, err = net.Listen("tcp", "127.0.0.1:0")
+	}
+	if err != nil {
+		panic(err)
+	}
+	fmt.Printf("1|%s|%s\n", listener.Addr().Network(), listener.Addr().String())
+	fmt.Println("plugin listening")
+	_ = http.Serve(listener, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]string{
+			"path":     r.URL.Path,
+			"user":     r.Header.Get(RemoteUserHeader),
+			"roles":    r.Header.Get(RemoteRolesHeader),
+			"name":     os.Getenv(NameEnv),
+			"protocol": os.Getenv(ProtocolEnv),
+			"settings": os.Getenv(SettingsEnv),
+			"custom":   os.Getenv("CUSTOM"),
+		})
+	}))
+}
@@ -0,0 +1,54 @@
+package query
+
+import (
+	"regexp"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var mongoOperators = map[Operator]string{Eq: "$eq", Ne: "$ne", Lt: "$lt", Lte: "$lte", Gt: "$gt", Gte: "$gte", In: "$in"}
+
+// Mongo builds the filter document and the find options (sort, skip and limit) of the options, e.g.
+//
+//	filter, opts := q.Mongo()
+//	cursor, err := collection.Find(ctx, filter, opts)
+func (o Options) Mongo() (bson.D, *options.FindOptions) {
+	conditions := make(bson.A, 0, len(o.Filters))
+	for _, f := range o.Filters {
+		var condition bson.D
+		switch f.Op {
+		case Contains:
+			condition = bson.D{{Key: "$regex", Value: regexp.QuoteMeta(f.Value.(string))}, {Key: "$options", Value: "i"}}
+		case In:
+			condition = bson.D{{Key: "$in", Value: bson.A(f.Value.([]any))}}
+		default:
+			condition = bson.D{{Key: mongoOperators[f.Op], Value: f.Value}}
+		}
+		conditions = append(conditions, bson.D{{Key: f.Column, Value: condition}})
+	}
+
+	filter := bson.D{}
+	switch len(conditions) {
+	case 0:
+	case 1:
+		filter = conditions[0].(bson.D)
+	default:
+		// Several conditions on the same field cannot share a document
+		filter = bson.D{{Key: "$and", Value: conditions}}
+	}
+
+	opts := options.Find().SetSkip(int64(o.Offset())).SetLimit(int64(o.PerPage))
+	if len(o.Sort) > 0 {
+		sort := make(bson.D, 0, len(o.Sort))
+		for _, s := range o.Sort {
+			direction := 1
+			if s.Desc {
+				direction = -1
+			}
+			sort = append(sort, bson.E{Key: s.Column, Value: direction})
+		}
+		opts.SetSort(sort)
+	}
+	return filter, opts
+}
@@ -0,0 +1,41 @@
+//go:build unit
+
+package query
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+var _ = Describe("Mongo", func() {
+	It("should build an empty filter without conditions", func() {
+		filter, opts := Options{Page: 3, PerPage: 20}.Mongo()
+		Expect(filter).To(BeEmpty())
+		Expect(*opts.Skip).To(Equal(int64(40)))
+		Expect(*opts.Limit).To(Equal(int64(20)))
+		Expect(opts.Sort).To(BeNil())
+	})
+
+	It("should build a single condition", func() {
+		filter, _ := Options{Page: 1, PerPage: 20, Filters: []Filter{{Column: "views", Op: Gte, Value: int64(3)}}}.Mongo()
+		Expect(filter).To(Equal(bson.D{{Key: "views", Value: bson.D{{Key: "$gte", Value: int64(3)}}}}))
+	})
+
+	It("should combine conditions and sort", func() {
+		filter, opts := Options{
+			Page:    1,
+			PerPage: 5,
+			Sort:    []Sort{{Column: "published", Desc: true}, {Column: "title"}},
+			Filters: []Filter{
+				{Column: "title", Op: Contains, Value: "a.b"},
+				{Column: "owner", Op: In, Value: []any{"alice", "bob"}},
+			},
+		}.Mongo()
+		Expect(filter).To(Equal(bson.D{{Key: "$and", Value: bson.A{
+			bson.D{{Key: "title", Value: bson.D{{Key: "$regex", Value: `a\.b`}, {Key: "$options", Value: "i"}}}},
+			bson.D{{Key: "owner", Value: bson.D{{Key: "$in", Value: bson.A{"alice", "bob"}}}}},
+		}}}))
+		Expect(opts.Sort).To(Equal(bson.D{{Key: "published", Value: -1}, {Key: "title", Value: 1}}))
+	})
+})
@@ -0,0 +1,220 @@
+// Package query parses the pagination, sorting and filtering parameters of list endpoints into
+// typed Options, and builds the SQL and MongoDB queries of the options. Only the fields declared in
+// the Schema of the endpoint can be sorted and filtered, so that requests never name columns
+// directly:
+//
+//	GET /posts?page=2&per_page=20&sort=-published,title&filter=owner:eq:alice&filter=published:gte:2024-01-01
+package query
+
+import (
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+)
+
+const (
+	defaultPerPage = 20
+	defaultMaxPage = 100
+)
+
+// ErrInvalid is wrapped by the errors of Parse, for requests to be answered with 400 Bad Request
+var ErrInvalid = errors.New("invalid query parameters")
+
+// Type is the type filter values are converted to
+type Type int
+
+const (
+	String Type = iota
+	Int
+	Float
+	Bool
+	// Time values are RFC 3339 timestamps or dates, e.g. 2024-01-01
+	Time
+)
+
+// Field is a sortable and filterable field of a Schema
+type Field struct {
+	// Column is the column, or the document key for MongoDB, of the field
+	Column string
+	Type   Type
+}
+
+// Schema declares the fields of a list endpoint and its page sizes
+type Schema struct {
+	// Fields are the public names of the fields, as used in the sort and filter parameters
+	Fields map[string]Field
+	// DefaultSort is the sort of the requests without one, in the syntax of the sort parameter
+	DefaultSort string
+	// DefaultPerPage is the page size of the requests without one. Defaults to 20.
+	DefaultPerPage int
+	// MaxPerPage is the largest page size accepted. Defaults to 100.
+	MaxPerPage int
+}
+
+// Operator is a comparison of a filter
+type Operator string
+
+const (
+	Eq       Operator = "eq"
+	Ne       Operator = "ne"
+	Lt       Operator = "lt"
+	Lte      Operator = "lte"
+	Gt       Operator = "gt"
+	Gte      Operator = "gte"
+	Contains Operator = "contains" // Case-insensitive substring, for strings only
+	In       Operator = "in"       // Any of a comma-separated list of values
+)
+
+// Sort orders the results by a column
+type Sort struct {
+	Column string
+	Desc   bool
+}
+
+// Filter compares a column with a value, or with a list of values for In
+type Filter struct {
+	Column string
+	Op     Operator
+	Value  any
+}
+
+// Options are the page, the sort and the filters of a list request
+type Options struct {
+	Page    int // From 1
+	PerPage int
+	Sort    []Sort
+	Filters []Filter
+}
+
+// Offset returns the number of results before the page
+func (o Options) Offset() int {
+	return (o.Page - 1) * o.PerPage
+}
+
+// ParseRequest parses the query parameters of a request, see Parse
+func (s Schema) ParseRequest(c *gin.Context) (Options, error) {
+	return s.Parse(c.Request.URL.Query())
+}
+
+// Parse parses the page, per_page, sort and filter parameters. Missing parameters take the
+// defaults of the schema. Errors wrap ErrInvalid.
+func (s Schema) Parse(values url.Values) (Options, error) {
+	perPage, maxPerPage := s.DefaultPerPage, s.MaxPerPage
+	if maxPerPage <= 0 {
+		maxPerPage = defaultMaxPage
+	}
+	if perPage <= 0 {
+		perPage = min(defaultPerPage, maxPerPage)
+	}
+	o := Options{Page: 1, PerPage: perPage}
+
+	var err error
+	if page := values.Get("page"); page != "" {
+		if o.Page, err = strconv.Atoi(page); err != nil || o.Page < 1 {
+			return Options{}, errors.Wrapf(ErrInvalid, "page must be a positive integer, got %q", page)
+		}
+	}
+	if size := values.Get("per_page"); size != "" {
+		if o.PerPage, err = strconv.Atoi(size); err != nil || o.PerPage < 1 || o.PerPage > maxPerPage {
+			return Options{}, errors.Wrapf(ErrInvalid, "per_page must be an integer between 1 and %d, got %q", maxPerPage, size)
+		}
+	}
+
+	sort := values.Get("sort")
+	if sort == "" {
+		sort = s.DefaultSort
+	}
+	if o.Sort, err = s.parseSort(sort); err != nil {
+		return Options{}, err
+	}
+
+	for _, filter := range values["filter"] {
+		f, err := s.parseFilter(filter)
+		if err != nil {
+			return Options{}, err
+		}
+		o.Filters = append(o.Filters, f)
+	}
+	return o, nil
+}
+
+// parseSort parses comma-separated field names, descending if prefixed with -
+func (s Schema) parseSort(sort string) ([]Sort, error) {
+	var sorts []Sort
+	for _, name := range strings.Split(sort, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		desc := strings.HasPrefix(name, "-")
+		field, ok := s.Fields[strings.TrimPrefix(name, "-")]
+		if !ok {
+			return nil, errors.Wrapf(ErrInvalid, "unknown sort field %q", strings.TrimPrefix(name, "-"))
+		}
+		sorts = append(sorts, Sort{Column: field.Column, Desc: desc})
+	}
+	return sorts, nil
+}
+
+// parseFilter parses a field:operator:value filter
+func (s Schema) parseFilter(filter string) (Filter, error) {
+	parts := strings.SplitN(filter, ":", 3)
+	if len(parts) != 3 {
+		return Filter{}, errors.Wrapf(ErrInvalid, "filter must be field:operator:value, got %q", filter)
+	}
+	field, ok := s.Fields[parts[0]]
+	if !ok {
+		return Filter{}, errors.Wrapf(ErrInvalid, "unknown filter field %q", parts[0])
+	}
+	f := Filter{Column: field.Column, Op: Operator(parts[1])}
+	switch f.Op {
+	case Eq, Ne, Lt, Lte, Gt, Gte:
+		value, err := field.Type.convert(parts[2])
+		if err != nil {
+			return Filter{}, errors.Wrapf(ErrInvalid, "invalid value of filter %q: %v", parts[0], err)
+		}
+		f.Value = value
+	case Contains:
+		if field.Type != String {
+			return Filter{}, errors.Wrapf(ErrInvalid, "contains filters only apply to text fields, not %q", parts[0])
+		}
+		f.Value = parts[2]
+	case In:
+		items := strings.Split(parts[2], ",")
+		values := make([]any, 0, len(items))
+		for _, item := range items {
+			value, err := field.Type.convert(item)
+			if err != nil {
+				return Filter{}, errors.Wrapf(ErrInvalid, "invalid value of filter %q: %v", parts[0], err)
+			}
+			values = append(values, value)
+		}
+		f.Value = values
+	default:
+		return Filter{}, errors.Wrapf(ErrInvalid, "unknown filter operator %q", parts[1])
+	}
+	return f, nil
+}
+
+// convert converts a filter value to the type
+func (t Type) convert(value string) (any, error) {
+	switch t {
+	case Int:
+		return strconv.ParseInt(value, 10, 64)
+	case Float:
+		return strconv.ParseFloat(value, 64)
+	case Bool:
+		return strconv.ParseBool(value)
+	case Time:
+		if date, err := time.Parse(time.DateOnly, value); err == nil {
+			return date, nil
+		}
+		return time.Parse(time.RFC3339, value)
+	default:
+		return value, nil
+	}
+}
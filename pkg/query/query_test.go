@@ -0,0 +1,106 @@
+//go:build unit
+
+package query
+
+import (
+	"net/http/httptest"
+	"net/url"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var testSchema = Schema{
+	Fields: map[string]Field{
+		"title":     {Column: "title", Type: String},
+		"views":     {Column: "view_count", Type: Int},
+		"score":     {Column: "score", Type: Float},
+		"draft":     {Column: "draft", Type: Bool},
+		"published": {Column: "publication_date", Type: Time},
+	},
+	DefaultSort:    "-published",
+	DefaultPerPage: 10,
+	MaxPerPage:     50,
+}
+
+var _ = Describe("Schema", func() {
+	Context("Parse", func() {
+		It("should apply the defaults", func() {
+			o, err := testSchema.Parse(nil)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(o).To(Equal(Options{Page: 1, PerPage: 10, Sort: []Sort{{Column: "publication_date", Desc: true}}}))
+			Expect(o.Offset()).To(Equal(0))
+		})
+
+		It("should default the page sizes of empty schemas", func() {
+			o, err := Schema{}.Parse(url.Values{"per_page": {"100"}})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(o.PerPage).To(Equal(100))
+
+			o, err = Schema{}.Parse(nil)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(o.PerPage).To(Equal(20))
+			Expect(o.Sort).To(BeEmpty())
+		})
+
+		It("should parse the page and the sort", func() {
+			o, err := testSchema.Parse(url.Values{"page": {"3"}, "per_page": {"5"}, "sort": {"views, -title"}})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(o.Page).To(Equal(3))
+			Expect(o.PerPage).To(Equal(5))
+			Expect(o.Offset()).To(Equal(10))
+			Expect(o.Sort).To(Equal([]Sort{{Column: "view_count"}, {Column: "title", Desc: true}}))
+		})
+
+		It("should convert the filter values to the field types", func() {
+			o, err := testSchema.Parse(url.Values{"filter": {
+				"title:contains:a:b",
+				"views:gte:10",
+				"score:lt:2.5",
+				"draft:eq:false",
+				"published:gt:2024-01-02",
+				"published:lte:2024-03-01T10:00:00Z",
+				"views:in:1,2,3",
+			}})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(o.Filters).To(Equal([]Filter{
+				{Column: "title", Op: Contains, Value: "a:b"},
+				{Column: "view_count", Op: Gte, Value: int64(10)},
+				{Column: "score", Op: Lt, Value: 2.5},
+				{Column: "draft", Op: Eq, Value: false},
+				{Column: "publication_date", Op: Gt, Value: time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)},
+				{Column: "publication_date", Op: Lte, Value: time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC)},
+				{Column: "view_count", Op: In, Value: []any{int64(1), int64(2), int64(3)}},
+			}))
+		})
+
+		DescribeTable("should reject invalid parameters",
+			func(values url.Values) {
+				_, err := testSchema.Parse(values)
+				Expect(err).To(MatchError(ErrInvalid))
+			},
+			Entry("page zero", url.Values{"page": {"0"}}),
+			Entry("non-numeric page", url.Values{"page": {"first"}}),
+			Entry("page size above the maximum", url.Values{"per_page": {"51"}}),
+			Entry("unknown sort field", url.Values{"sort": {"-view_count"}}),
+			Entry("incomplete filter", url.Values{"filter": {"title:eq"}}),
+			Entry("unknown filter field", url.Values{"filter": {"body:eq:x"}}),
+			Entry("unknown operator", url.Values{"filter": {"title:like:x"}}),
+			Entry("contains on a number", url.Values{"filter": {"views:contains:1"}}),
+			Entry("invalid number", url.Values{"filter": {"views:eq:many"}}),
+			Entry("invalid list item", url.Values{"filter": {"views:in:1,x"}}),
+			Entry("invalid time", url.Values{"filter": {"published:gt:yesterday"}}),
+		)
+	})
+
+	It("should parse the query of a request", func() {
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request = httptest.NewRequest("GET", "/posts?page=2&filter=draft:eq:true", nil)
+		o, err := testSchema.ParseRequest(c)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(o.Page).To(Equal(2))
+		Expect(o.Filters).To(Equal([]Filter{{Column: "draft", Op: Eq, Value: true}}))
+	})
+})
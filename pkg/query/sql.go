@@ -0,0 +1,95 @@
+package query
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Dialect holds the SQL differences between databases
+type Dialect struct {
+	// Placeholder returns the placeholder of the nth argument, from 1
+	Placeholder func(n int) string
+	// ContainsOperator is the case-insensitive LIKE operator
+	ContainsOperator string
+}
+
+var (
+	// Postgres numbers the placeholders, as expected by pgx
+	Postgres = Dialect{
+		Placeholder:      func(n int) string { return "$" + strconv.Itoa(n) },
+		ContainsOperator: "ILIKE",
+	}
+	// SQLite uses ? placeholders, and LIKE ignores the case of ASCII letters
+	SQLite = Dialect{
+		Placeholder:      func(int) string { return "?" },
+		ContainsOperator: "LIKE",
+	}
+)
+
+var sqlOperators = map[Operator]string{Eq: "=", Ne: "<>", Lt: "<", Lte: "<=", Gt: ">", Gte: ">="}
+
+// likeEscaper escapes the wildcards of LIKE patterns, see the ESCAPE clause of contains filters
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// SQL holds the clauses of the options, to be appended to a SELECT statement. The columns come from
+// the schema and the values are arguments, so the clauses are safe to concatenate.
+type SQL struct {
+	Where   string // Conditions joined with AND, without the WHERE keyword. Empty without filters.
+	OrderBy string // Without the ORDER BY keywords. Empty without sort.
+	Args    []any  // Arguments of the placeholders of Where
+	Limit   int
+	Offset  int
+}
+
+// SQL builds the clauses of the options for the dialect. The placeholders are numbered after
+// argOffset, the number of arguments of the statement before the clauses.
+func (o Options) SQL(dialect Dialect, argOffset int) SQL {
+	s := SQL{Limit: o.PerPage, Offset: o.Offset()}
+
+	placeholder := func(value any) string {
+		s.Args = append(s.Args, value)
+		return dialect.Placeholder(argOffset + len(s.Args))
+	}
+	conditions := make([]string, 0, len(o.Filters))
+	for _, f := range o.Filters {
+		switch f.Op {
+		case Contains:
+			pattern := "%" + likeEscaper.Replace(f.Value.(string)) + "%"
+			conditions = append(conditions, f.Column+" "+dialect.ContainsOperator+" "+placeholder(pattern)+` ESCAPE '\'`)
+		case In:
+			values := f.Value.([]any)
+			placeholders := make([]string, len(values))
+			for i, value := range values {
+				placeholders[i] = placeholder(value)
+			}
+			conditions = append(conditions, f.Column+" IN ("+strings.Join(placeholders, ", ")+")")
+		default:
+			conditions = append(conditions, f.Column+" "+sqlOperators[f.Op]+" "+placeholder(f.Value))
+		}
+	}
+	s.Where = strings.Join(conditions, " AND ")
+
+	order := make([]string, 0, len(o.Sort))
+	for _, sort := range o.Sort {
+		if sort.Desc {
+			order = append(order, sort.Column+" DESC")
+		} else {
+			order = append(order, sort.Column+" ASC")
+		}
+	}
+	s.OrderBy = strings.Join(order, ", ")
+	return s
+}
+
+// String returns the WHERE, ORDER BY, LIMIT and OFFSET clauses, with a leading space
+func (s SQL) String() string {
+	var b strings.Builder
+	if s.Where != "" {
+		b.WriteString(" WHERE " + s.Where)
+	}
+	if s.OrderBy != "" {
+		b.WriteString(" ORDER BY " + s.OrderBy)
+	}
+	b.WriteString(" LIMIT " + strconv.Itoa(s.Limit) + " OFFSET " + strconv.Itoa(s.Offset))
+	return b.String()
+}
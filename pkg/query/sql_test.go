@@ -0,0 +1,41 @@
+//go:build unit
+
+package query
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("SQL", func() {
+	options := Options{
+		Page:    2,
+		PerPage: 10,
+		Sort:    []Sort{{Column: "publication_date", Desc: true}, {Column: "title"}},
+		Filters: []Filter{
+			{Column: "title", Op: Contains, Value: `50%_off\`},
+			{Column: "view_count", Op: In, Value: []any{int64(1), int64(2)}},
+			{Column: "owner", Op: Ne, Value: "bob"},
+		},
+	}
+
+	It("should number the Postgres placeholders after the offset", func() {
+		s := options.SQL(Postgres, 1)
+		Expect(s.Where).To(Equal(`title ILIKE $2 ESCAPE '\' AND view_count IN ($3, $4) AND owner <> $5`))
+		Expect(s.Args).To(Equal([]any{`%50\%\_off\\%`, int64(1), int64(2), "bob"}))
+		Expect(s.OrderBy).To(Equal("publication_date DESC, title ASC"))
+		Expect(s.String()).To(Equal(" WHERE " + s.Where + " ORDER BY " + s.OrderBy + " LIMIT 10 OFFSET 10"))
+	})
+
+	It("should use positional placeholders for SQLite", func() {
+		s := options.SQL(SQLite, 0)
+		Expect(s.Where).To(Equal(`title LIKE ? ESCAPE '\' AND view_count IN (?, ?) AND owner <> ?`))
+		Expect(s.Args).To(HaveLen(4))
+	})
+
+	It("should omit the empty clauses", func() {
+		s := Options{Page: 1, PerPage: 20}.SQL(Postgres, 0)
+		Expect(s.Args).To(BeEmpty())
+		Expect(s.String()).To(Equal(" LIMIT 20 OFFSET 0"))
+	})
+})
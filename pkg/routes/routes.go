@@ -0,0 +1,136 @@
+// Package routes provides the well-known routes of the gateway, so that templates and downstream
+// Go services build their URLs without hardcoded strings:
+//
+//	r := routes.Defaults()
+//	r.LoginURL("github")  // /auth/github
+//	r.ReauthURL("")       // /auth/reauth
+//
+// The defaults are the paths used throughout the documentation. Gateways configured with other
+// paths set them in Routes, e.g. with controller.AuthControllerConfig.Routes.
+package routes
+
+import (
+	"html/template"
+	"net/url"
+	"path"
+	"strings"
+)
+
+// ProviderPlaceholder is replaced with the provider name in the login and callback paths.
+const ProviderPlaceholder = "{provider}"
+
+// Default paths of the well-known routes
+const (
+	DefaultLoginPath     = "/auth/" + ProviderPlaceholder
+	DefaultCallbackPath  = "/auth/" + ProviderPlaceholder + "/callback"
+	DefaultLogoutPath    = "/auth/logout"
+	DefaultUserInfoPath  = "/auth/user"
+	DefaultReauthPath    = "/auth/reauth"
+	DefaultReadinessPath = "/readyz"
+	DefaultLivenessPath  = "/healthz"
+)
+
+// Routes are the paths of the well-known routes of a gateway. Empty paths are not served.
+type Routes struct {
+	// Origin is prepended to the URLs, e.g. https://gateway.example.org. Empty for relative URLs.
+	Origin string
+	// BasePath is the base path of the controller bindings serving the routes, e.g. /app.
+	BasePath  string
+	Login     string
+	Callback  string
+	Logout    string
+	UserInfo  string
+	Reauth    string
+	Readiness string
+	Liveness  string
+}
+
+// Defaults returns the routes with the default paths.
+func Defaults() Routes {
+	return Routes{
+		Login:     DefaultLoginPath,
+		Callback:  DefaultCallbackPath,
+		Logout:    DefaultLogoutPath,
+		UserInfo:  DefaultUserInfoPath,
+		Reauth:    DefaultReauthPath,
+		Readiness: DefaultReadinessPath,
+		Liveness:  DefaultLivenessPath,
+	}
+}
+
+// LoginURL returns the URL starting the login with the provider.
+func (r Routes) LoginURL(provider string) string {
+	return r.url(withProvider(r.Login, provider))
+}
+
+// CallbackURL returns the URL the provider redirects to, to be registered at the provider.
+func (r Routes) CallbackURL(provider string) string {
+	return r.url(withProvider(r.Callback, provider))
+}
+
+// LogoutURL returns the URL signing the user out.
+func (r Routes) LogoutURL() string {
+	return r.url(r.Logout)
+}
+
+// UserInfoURL returns the URL of the authenticated user.
+func (r Routes) UserInfoURL() string {
+	return r.url(r.UserInfo)
+}
+
+// ReauthURL returns the URL renewing the session silently, with the provider of the last login if
+// provider is empty.
+func (r Routes) ReauthURL(provider string) string {
+	u := r.url(r.Reauth)
+	if u == "" || provider == "" {
+		return u
+	}
+	return u + "?" + url.Values{"provider": {provider}}.Encode()
+}
+
+// ReadinessURL returns the URL of the readiness probe. It is not below the base path.
+func (r Routes) ReadinessURL() string {
+	if r.Readiness == "" {
+		return ""
+	}
+	return strings.TrimSuffix(r.Origin, "/") + r.Readiness
+}
+
+// LivenessURL returns the URL of the liveness probe. It is not below the base path.
+func (r Routes) LivenessURL() string {
+	if r.Liveness == "" {
+		return ""
+	}
+	return strings.TrimSuffix(r.Origin, "/") + r.Liveness
+}
+
+// FuncMap returns the template functions building the URLs of the routes: loginURL, callbackURL,
+// logoutURL, userInfoURL, reauthURL, readinessURL and livenessURL, e.g. {{ loginURL "github" }}. Set them
+// with gin.Engine.SetFuncMap before loading the templates.
+func (r Routes) FuncMap() template.FuncMap {
+	return template.FuncMap{
+		"loginURL":     r.LoginURL,
+		"callbackURL":  r.CallbackURL,
+		"logoutURL":    r.LogoutURL,
+		"userInfoURL":  r.UserInfoURL,
+		"reauthURL":    r.ReauthURL,
+		"readinessURL": r.ReadinessURL,
+		"livenessURL":  r.LivenessURL,
+	}
+}
+
+// url returns the URL of the route path below the base path, or an empty string if not served
+func (r Routes) url(routePath string) string {
+	if routePath == "" {
+		return ""
+	}
+	joined := path.Join("/", r.BasePath, routePath)
+	if strings.HasSuffix(routePath, "/") && joined != "/" {
+		joined += "/"
+	}
+	return strings.TrimSuffix(r.Origin, "/") + joined
+}
+
+func withProvider(routePath, provider string) string {
+	return strings.ReplaceAll(routePath, ProviderPlaceholder, url.PathEscape(provider))
+}
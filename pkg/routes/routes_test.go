@@ -0,0 +1,49 @@
+//go:build unit
+
+package routes
+
+import (
+	"bytes"
+	"html/template"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Routes", func() {
+	It("should build the URLs of the default routes", func() {
+		r := Defaults()
+		Expect(r.LoginURL("github")).To(Equal("/auth/github"))
+		Expect(r.CallbackURL("openid-connect")).To(Equal("/auth/openid-connect/callback"))
+		Expect(r.LogoutURL()).To(Equal("/auth/logout"))
+		Expect(r.UserInfoURL()).To(Equal("/auth/user"))
+		Expect(r.ReauthURL("")).To(Equal("/auth/reauth"))
+		Expect(r.ReauthURL("google")).To(Equal("/auth/reauth?provider=google"))
+		Expect(r.ReadinessURL()).To(Equal("/readyz"))
+		Expect(r.LivenessURL()).To(Equal("/healthz"))
+	})
+
+	It("should prefix the origin and the base path", func() {
+		r := Defaults()
+		r.Origin, r.BasePath = "https://gateway.example.org/", "/app"
+		Expect(r.LoginURL("a b")).To(Equal("https://gateway.example.org/app/auth/a%20b"))
+		Expect(r.LogoutURL()).To(Equal("https://gateway.example.org/app/auth/logout"))
+		Expect(r.ReadinessURL()).To(Equal("https://gateway.example.org/readyz"))
+		Expect(r.LivenessURL()).To(Equal("https://gateway.example.org/healthz"))
+	})
+
+	It("should return empty URLs for the routes not served", func() {
+		r := Routes{Login: "/signin/{provider}"}
+		Expect(r.LoginURL("github")).To(Equal("/signin/github"))
+		Expect(r.ReauthURL("github")).To(BeEmpty())
+		Expect(r.ReadinessURL()).To(BeEmpty())
+		Expect(r.LivenessURL()).To(BeEmpty())
+	})
+
+	It("should build the URLs in templates", func() {
+		t := template.Must(template.New("page").Funcs(Defaults().FuncMap()).Parse(`<a href="{{ loginURL "github" }}">Sign in</a> <a href="{{ logoutURL }}">Sign out</a>`))
+		var out bytes.Buffer
+		Expect(t.Execute(&out, nil)).To(Succeed())
+		Expect(out.String()).To(Equal(`<a href="/auth/github">Sign in</a> <a href="/auth/logout">Sign out</a>`))
+	})
+})
@@ -0,0 +1,207 @@
+package scripting
+
+import (
+	"slices"
+
+	"github.com/animalet/sargantana-go/pkg/server"
+	"github.com/animalet/sargantana-go/pkg/sessionsx"
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+	lua "github.com/yuin/gopher-lua"
+)
+
+// Lua type names of the arguments of the hooks
+const (
+	requestType  = "sargantana.request"
+	responseType = "sargantana.response"
+)
+
+// request is the argument of on_request
+type request struct {
+	c         *gin.Context
+	script    string
+	responded bool
+	status    int
+	body      string
+}
+
+// response is the argument of on_response
+type response struct {
+	c      *gin.Context
+	script string
+	writer gin.ResponseWriter
+}
+
+var requestMethods = map[string]lua.LGFunction{
+	"method": func(L *lua.LState) int {
+		L.Push(lua.LString(checkRequest(L).c.Request.Method))
+		return 1
+	},
+	"path": func(L *lua.LState) int {
+		L.Push(lua.LString(checkRequest(L).c.Request.URL.Path))
+		return 1
+	},
+	"query": func(L *lua.LState) int {
+		r := checkRequest(L)
+		return pushFirst(L, r.c.Request.URL.Query()[L.CheckString(2)])
+	},
+	"header": func(L *lua.LState) int {
+		r := checkRequest(L)
+		return pushFirst(L, r.c.Request.Header.Values(L.CheckString(2)))
+	},
+	"set_header": func(L *lua.LState) int {
+		checkRequest(L).c.Request.Header.Set(L.CheckString(2), L.CheckString(3))
+		return 0
+	},
+	"remove_header": func(L *lua.LState) int {
+		checkRequest(L).c.Request.Header.Del(L.CheckString(2))
+		return 0
+	},
+	"client_ip": func(L *lua.LState) int {
+		L.Push(lua.LString(checkRequest(L).c.ClientIP()))
+		return 1
+	},
+	"user": func(L *lua.LState) int {
+		L.Push(userTable(L, checkRequest(L).c))
+		return 1
+	},
+	"roles": func(L *lua.LState) int {
+		roles := L.NewTable()
+		for _, role := range server.GetRoles(checkRequest(L).c) {
+			roles.Append(lua.LString(role))
+		}
+		L.Push(roles)
+		return 1
+	},
+	"has_role": func(L *lua.LState) int {
+		r := checkRequest(L)
+		L.Push(lua.LBool(slices.Contains(server.GetRoles(r.c), L.CheckString(2))))
+		return 1
+	},
+	"set_response_header": func(L *lua.LState) int {
+		checkRequest(L).c.Header(L.CheckString(2), L.CheckString(3))
+		return 0
+	},
+	"respond": func(L *lua.LState) int {
+		r := checkRequest(L)
+		r.status = checkStatus(L, 2)
+		r.body = L.OptString(3, "")
+		r.responded = true
+		return 0
+	},
+	"log": func(L *lua.LState) int {
+		r := checkRequest(L)
+		logger := server.RequestLogger(r.c)
+		logger.Info().Str("script", r.script).Msg(L.CheckString(2))
+		return 0
+	},
+}
+
+var responseMethods = map[string]lua.LGFunction{
+	"method": func(L *lua.LState) int {
+		L.Push(lua.LString(checkResponse(L).c.Request.Method))
+		return 1
+	},
+	"path": func(L *lua.LState) int {
+		L.Push(lua.LString(checkResponse(L).c.Request.URL.Path))
+		return 1
+	},
+	"user": func(L *lua.LState) int {
+		L.Push(userTable(L, checkResponse(L).c))
+		return 1
+	},
+	"status": func(L *lua.LState) int {
+		L.Push(lua.LNumber(checkResponse(L).writer.Status()))
+		return 1
+	},
+	"set_status": func(L *lua.LState) int {
+		r := checkResponse(L)
+		r.writer.WriteHeader(checkStatus(L, 2))
+		return 0
+	},
+	"header": func(L *lua.LState) int {
+		r := checkResponse(L)
+		return pushFirst(L, r.writer.Header().Values(L.CheckString(2)))
+	},
+	"set_header": func(L *lua.LState) int {
+		checkResponse(L).writer.Header().Set(L.CheckString(2), L.CheckString(3))
+		return 0
+	},
+	"remove_header": func(L *lua.LState) int {
+		checkResponse(L).writer.Header().Del(L.CheckString(2))
+		return 0
+	},
+	"log": func(L *lua.LState) int {
+		r := checkResponse(L)
+		logger := server.RequestLogger(r.c)
+		logger.Info().Str("script", r.script).Msg(L.CheckString(2))
+		return 0
+	},
+}
+
+// registerTypes registers the metatables of the arguments of the hooks in the interpreter
+func registerTypes(L *lua.LState) {
+	for typeName, methods := range map[string]map[string]lua.LGFunction{
+		requestType:  requestMethods,
+		responseType: responseMethods,
+	} {
+		metatable := L.NewTypeMetatable(typeName)
+		L.SetField(metatable, "__index", L.SetFuncs(L.NewTable(), methods))
+		// Hides the metatable from getmetatable
+		L.SetField(metatable, "__metatable", lua.LFalse)
+	}
+}
+
+func checkRequest(L *lua.LState) *request {
+	if r, ok := L.CheckUserData(1).Value.(*request); ok {
+		return r
+	}
+	L.ArgError(1, "request expected")
+	return nil
+}
+
+func checkResponse(L *lua.LState) *response {
+	if r, ok := L.CheckUserData(1).Value.(*response); ok {
+		return r
+	}
+	L.ArgError(1, "response expected")
+	return nil
+}
+
+func checkStatus(L *lua.LState, n int) int {
+	status := L.CheckInt(n)
+	if status < 100 || status > 599 {
+		L.ArgError(n, "status must be between 100 and 599")
+	}
+	return status
+}
+
+// pushFirst pushes the first value, or nil if there is none
+func pushFirst(L *lua.LState, values []string) int {
+	if len(values) == 0 {
+		L.Push(lua.LNil)
+	} else {
+		L.Push(lua.LString(values[0]))
+	}
+	return 1
+}
+
+// userTable returns the signed-in user as a table with its id, and the name, email and provider of
+// session users, or nil for anonymous requests
+func userTable(L *lua.LState, c *gin.Context) lua.LValue {
+	user := L.NewTable()
+	if name, ok := server.GetRemoteUser(c); ok {
+		user.RawSetString("id", lua.LString(name))
+		return user
+	}
+	if _, ok := c.Get(sessions.DefaultKey); ok {
+		if sessionUser, ok := sessionsx.User(c); ok && sessionUser.Id != "" {
+			user.RawSetString("id", lua.LString(sessionUser.Id))
+			user.RawSetString("name", lua.LString(sessionUser.User.Name))
+			user.RawSetString("email", lua.LString(sessionUser.User.Email))
+			user.RawSetString("provider", lua.LString(sessionUser.User.Provider))
+			return user
+		}
+	}
+	return lua.LNil
+}
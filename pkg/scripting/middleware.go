@@ -0,0 +1,95 @@
+package scripting
+
+import (
+	"net/http"
+
+	"github.com/animalet/sargantana-go/pkg/server"
+	"github.com/gin-gonic/gin"
+)
+
+// NewMiddleware creates the middleware of the lua middleware type, compiling and running the script.
+func NewMiddleware(c Config) (gin.HandlerFunc, error) {
+	s, err := newScript(c)
+	if err != nil {
+		return nil, err
+	}
+	m := &middleware{script: s, failOpen: c.FailOpen}
+	return m.handle, nil
+}
+
+type middleware struct {
+	script   *script
+	failOpen bool
+}
+
+func (m *middleware) handle(c *gin.Context) {
+	if m.script.onRequest {
+		r := &request{c: c, script: m.script.name}
+		if err := m.script.call(c.Request.Context(), OnRequestHook, r, requestType); err != nil {
+			logger := server.RequestLogger(c)
+			if !m.failOpen {
+				logger.Error().Err(err).Str("script", m.script.name).Msg("Lua request hook failed")
+				c.AbortWithStatus(http.StatusInternalServerError)
+				return
+			}
+			logger.Warn().Err(err).Str("script", m.script.name).Msg("Lua request hook failed, continuing")
+		} else if r.responded {
+			c.Abort()
+			c.String(r.status, r.body)
+			return
+		}
+	}
+
+	if !m.script.onResponse {
+		c.Next()
+		return
+	}
+	writer := &hookWriter{ResponseWriter: c.Writer, script: m.script, c: c}
+	c.Writer = writer
+	defer func() {
+		c.Writer = writer.ResponseWriter
+	}()
+	c.Next()
+	// Responses without body are written after the middlewares
+	writer.hook()
+}
+
+// hookWriter calls on_response before the headers of the response are written
+type hookWriter struct {
+	gin.ResponseWriter
+	script *script
+	c      *gin.Context
+	hooked bool
+}
+
+func (w *hookWriter) hook() {
+	if w.hooked || w.ResponseWriter.Written() {
+		return
+	}
+	w.hooked = true
+	r := &response{c: w.c, script: w.script.name, writer: w.ResponseWriter}
+	if err := w.script.call(w.c.Request.Context(), OnResponseHook, r, responseType); err != nil {
+		logger := server.RequestLogger(w.c)
+		logger.Warn().Err(err).Str("script", w.script.name).Msg("Lua response hook failed")
+	}
+}
+
+func (w *hookWriter) WriteHeaderNow() {
+	w.hook()
+	w.ResponseWriter.WriteHeaderNow()
+}
+
+func (w *hookWriter) Write(b []byte) (int, error) {
+	w.hook()
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *hookWriter) WriteString(s string) (int, error) {
+	w.hook()
+	return w.ResponseWriter.WriteString(s)
+}
+
+func (w *hookWriter) Flush() {
+	w.hook()
+	w.ResponseWriter.Flush()
+}
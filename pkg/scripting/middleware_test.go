@@ -0,0 +1,173 @@
+//go:build unit
+
+package scripting
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/animalet/sargantana-go/pkg/server"
+	"github.com/gin-gonic/gin"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Lua middleware", func() {
+	// serve runs the middleware before a handler echoing the request headers
+	serve := func(c Config, path string, header http.Header) *httptest.ResponseRecorder {
+		middleware, err := NewMiddleware(c)
+		Expect(err).NotTo(HaveOccurred())
+		engine := gin.New()
+		engine.Use(func(c *gin.Context) {
+			c.Set(server.RemoteUserKey, "alice")
+			c.Set(server.RolesKey, []string{"admin", "billing"})
+		}, middleware)
+		engine.GET("/*path", func(c *gin.Context) {
+			c.Header("X-Internal", "secret")
+			c.JSON(http.StatusOK, c.Request.Header)
+		})
+		engine.HEAD("/*path", func(c *gin.Context) {
+			c.Status(http.StatusNoContent)
+		})
+		method := http.MethodGet
+		if path == "/empty" {
+			method = http.MethodHead
+		}
+		request := httptest.NewRequest(method, path, nil)
+		for name, values := range header {
+			request.Header[name] = values
+		}
+		recorder := httptest.NewRecorder()
+		engine.ServeHTTP(recorder, request)
+		return recorder
+	}
+
+	Context("Configuration", func() {
+		It("should validate the configuration", func() {
+			Expect(Config{Script: "function on_request(r) end"}.Validate()).To(Succeed())
+			Expect(Config{File: "hooks.lua"}.Validate()).To(Succeed())
+			Expect(Config{}.Validate()).To(MatchError(ContainSubstring("exactly one of script or file")))
+			Expect(Config{Script: "x = 1", File: "hooks.lua"}.Validate()).To(MatchError(ContainSubstring("exactly one of script or file")))
+			Expect(Config{Script: "x = 1", Timeout: -1}.Validate()).To(MatchError(ContainSubstring("timeout")))
+		})
+
+		It("should fail on missing and invalid scripts", func() {
+			_, err := NewMiddleware(Config{File: "testdata/missing.lua"})
+			Expect(err).To(MatchError(ContainSubstring("failed to read lua script testdata/missing.lua")))
+			_, err = NewMiddleware(Config{Script: "function on_request(r)"})
+			Expect(err).To(MatchError(ContainSubstring("failed to parse lua script")))
+			_, err = NewMiddleware(Config{Script: "error('boom')"})
+			Expect(err).To(MatchError(ContainSubstring("failed to run lua script")))
+			_, err = NewMiddleware(Config{Script: "x = 1"})
+			Expect(err).To(MatchError(ContainSubstring("defines neither on_request nor on_response")))
+		})
+
+		It("should load the scripts from files", func() {
+			file := filepath.Join(GinkgoT().TempDir(), "hooks.lua")
+			Expect(os.WriteFile(file, []byte(`function on_request(r) r:set_header("X-Script", "file") end`), 0o600)).To(Succeed())
+			Expect(serve(Config{File: file}, "/orders", nil).Body.String()).To(ContainSubstring(`"X-Script":["file"]`))
+		})
+	})
+
+	It("should rewrite the headers of the request and the response", func() {
+		recorder := serve(Config{Script: `
+			function on_request(r)
+				local user = r:user()
+				r:set_header("X-User", user.id .. ":" .. table.concat(r:roles(), ","))
+				r:set_header("X-Route", r:method() .. " " .. r:path() .. "?" .. (r:query("page") or ""))
+				r:set_header("X-Tenant", string.lower(r:header("X-Tenant") or "default"))
+				r:remove_header("X-Secret")
+				r:set_response_header("X-Request-Hook", "done")
+				r:log("rewritten")
+			end
+
+			function on_response(r)
+				r:set_header("X-Response-Hook", tostring(r:status()))
+				r:remove_header("X-Internal")
+			end`}, "/orders?page=2", http.Header{"X-Secret": {"s3cr3t"}, "X-Tenant": {"ACME"}})
+		Expect(recorder.Code).To(Equal(http.StatusOK))
+		Expect(recorder.Body.String()).To(ContainSubstring(`"X-User":["alice:admin,billing"]`))
+		Expect(recorder.Body.String()).To(ContainSubstring(`"X-Route":["GET /orders?2"]`))
+		Expect(recorder.Body.String()).To(ContainSubstring(`"X-Tenant":["acme"]`))
+		Expect(recorder.Body.String()).NotTo(ContainSubstring("s3cr3t"))
+		Expect(recorder.Header().Get("X-Request-Hook")).To(Equal("done"))
+		Expect(recorder.Header().Get("X-Response-Hook")).To(Equal("200"))
+		Expect(recorder.Header().Get("X-Internal")).To(BeEmpty())
+	})
+
+	It("should change the status of the responses, including those without body", func() {
+		recorder := serve(Config{Script: `
+			function on_response(r)
+				if r:status() == 204 then r:set_status(202) end
+			end`}, "/empty", nil)
+		Expect(recorder.Code).To(Equal(http.StatusAccepted))
+	})
+
+	It("should answer the requests the script responds to", func() {
+		script := Config{Script: `
+			function on_request(r)
+				if not r:has_role("operator") then
+					r:set_response_header("Retry-After", "60")
+					r:respond(503, "down for maintenance")
+				end
+			end
+			function on_response(r)
+				r:set_header("X-Response-Hook", "called")
+			end`}
+		recorder := serve(script, "/orders", nil)
+		Expect(recorder.Code).To(Equal(http.StatusServiceUnavailable))
+		Expect(recorder.Body.String()).To(Equal("down for maintenance"))
+		Expect(recorder.Header().Get("Retry-After")).To(Equal("60"))
+		Expect(recorder.Header().Get("X-Response-Hook")).To(BeEmpty())
+	})
+
+	It("should answer 500 when the request hook fails, unless failing open", func() {
+		for _, source := range []string{
+			`function on_request(r) error("boom") end`,
+			`function on_request(r) r:respond(42) end`,
+			`function on_request(r) dofile("/etc/passwd") end`,
+			`function on_request(r) os.exit(1) end`,
+		} {
+			Expect(serve(Config{Script: source}, "/orders", nil).Code).To(Equal(http.StatusInternalServerError), source)
+			Expect(serve(Config{Script: source, FailOpen: true}, "/orders", nil).Code).To(Equal(http.StatusOK), source)
+		}
+	})
+
+	It("should stop the hooks exceeding their timeout", func() {
+		start := time.Now()
+		Expect(serve(Config{Script: `function on_request(r) while true do end end`, Timeout: 20 * time.Millisecond}, "/orders", nil).Code).
+			To(Equal(http.StatusInternalServerError))
+		Expect(time.Since(start)).To(BeNumerically("<", 5*time.Second))
+	})
+
+	It("should serve concurrent requests with several interpreters", func() {
+		middleware, err := NewMiddleware(Config{Script: `
+			function on_request(r)
+				counter = (counter or 0) + 1
+				r:set_header("X-Path", r:path())
+			end`})
+		Expect(err).NotTo(HaveOccurred())
+		engine := gin.New()
+		engine.Use(middleware)
+		engine.GET("/*path", func(c *gin.Context) {
+			c.String(http.StatusOK, c.GetHeader("X-Path"))
+		})
+		var wg sync.WaitGroup
+		for range 16 {
+			wg.Add(1)
+			go func() {
+				defer GinkgoRecover()
+				defer wg.Done()
+				recorder := httptest.NewRecorder()
+				engine.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/orders", nil))
+				Expect(recorder.Code).To(Equal(http.StatusOK))
+				Expect(recorder.Body.String()).To(Equal("/orders"))
+			}()
+		}
+		wg.Wait()
+	})
+})
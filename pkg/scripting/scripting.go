@@ -0,0 +1,184 @@
+// Package scripting runs Lua scripts as middlewares of controller bindings, for quick operational
+// tweaks such as header rewrites, maintenance responses or custom access checks, without
+// rebuilding the gateway. The scripts run in an embedded Lua 5.1 interpreter with a restricted
+// API: they cannot access the filesystem, the network nor load other code, and each call is
+// bounded in time. For sandboxed logic written in other languages, see the wasm package.
+package scripting
+
+import (
+	"context"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	lua "github.com/yuin/gopher-lua"
+	"github.com/yuin/gopher-lua/parse"
+)
+
+// Hooks defined by the scripts, see Config.
+const (
+	OnRequestHook  = "on_request"
+	OnResponseHook = "on_response"
+)
+
+const defaultTimeout = 50 * time.Millisecond
+
+// Globals removed from the base library, loading code or reaching outside the interpreter
+var removedGlobals = []string{
+	"collectgarbage", "dofile", "getfenv", "load", "loadfile", "loadstring", "module", "newproxy",
+	"print", "_printregs", "require", "setfenv",
+}
+
+// Config configures the lua middleware type, which calls the hooks of a Lua script for the
+// requests of a controller binding. The script defines on_request(r), called before the handlers
+// of the binding, on_response(r), called with the status and the headers of the response before
+// they are written, or both:
+//
+//	function on_request(r)
+//	  if r:header("X-Maintenance") == nil and not r:has_role("admin") then
+//	    r:respond(503, "down for maintenance")
+//	  end
+//	  r:set_header("X-Tenant", string.lower(r:header("X-Tenant") or "default"))
+//	end
+//
+// See the README for the methods of r. Several interpreters run the script for concurrent
+// requests, so the hooks must not rely on the globals set by previous calls.
+type Config struct {
+	// Script is the source of the script. Either Script or File must be set.
+	Script string `yaml:"script,omitempty"`
+	// File is the path of the script.
+	File string `yaml:"file,omitempty"`
+	// Timeout bounds each call of a hook. Defaults to 50ms.
+	Timeout time.Duration `yaml:"timeout,omitempty"`
+	// FailOpen lets the requests continue when on_request fails or times out, instead of answering
+	// them with 500. Failures of on_response are logged, and the response is sent anyway.
+	FailOpen bool `yaml:"fail_open,omitempty"`
+}
+
+func (c Config) Validate() error {
+	if (c.Script == "") == (c.File == "") {
+		return errors.New("exactly one of script or file must be set")
+	}
+	if c.Timeout < 0 {
+		return errors.New("timeout must not be negative")
+	}
+	return nil
+}
+
+// script is a compiled script with its idle interpreters
+type script struct {
+	name       string
+	proto      *lua.FunctionProto
+	timeout    time.Duration
+	onRequest  bool // Whether on_request is defined
+	onResponse bool // Whether on_response is defined
+	mu         sync.Mutex
+	idle       []*lua.LState
+}
+
+func newScript(c Config) (*script, error) {
+	source, name := c.Script, "script"
+	if c.File != "" {
+		read, err := os.ReadFile(c.File)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to read lua script %s", c.File)
+		}
+		source, name = string(read), c.File
+	}
+	chunk, err := parse.Parse(strings.NewReader(source), name)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to parse lua script %s", name)
+	}
+	proto, err := lua.Compile(chunk, name)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to compile lua script %s", name)
+	}
+	timeout := c.Timeout
+	if timeout == 0 {
+		timeout = defaultTimeout
+	}
+
+	s := &script{name: name, proto: proto, timeout: timeout}
+	// Run the script once to fail on start instead of on the first request
+	state, err := s.newState()
+	if err != nil {
+		return nil, err
+	}
+	s.onRequest = state.GetGlobal(OnRequestHook).Type() == lua.LTFunction
+	s.onResponse = state.GetGlobal(OnResponseHook).Type() == lua.LTFunction
+	if !s.onRequest && !s.onResponse {
+		state.Close()
+		return nil, errors.Errorf("lua script %s defines neither %s nor %s", name, OnRequestHook, OnResponseHook)
+	}
+	s.idle = append(s.idle, state)
+	return s, nil
+}
+
+// newState creates an interpreter with the restricted libraries, and runs the script in it
+func (s *script) newState() (*lua.LState, error) {
+	state := lua.NewState(lua.Options{SkipOpenLibs: true, MinimizeStackMemory: true})
+	for _, lib := range []struct {
+		name string
+		open lua.LGFunction
+	}{
+		{lua.BaseLibName, lua.OpenBase},
+		{lua.TabLibName, lua.OpenTable},
+		{lua.StringLibName, lua.OpenString},
+		{lua.MathLibName, lua.OpenMath},
+	} {
+		if err := state.CallByParam(lua.P{Fn: state.NewFunction(lib.open), Protect: true}, lua.LString(lib.name)); err != nil {
+			state.Close()
+			return nil, errors.Wrapf(err, "failed to open lua library %s", lib.name)
+		}
+	}
+	for _, name := range removedGlobals {
+		state.SetGlobal(name, lua.LNil)
+	}
+	registerTypes(state)
+
+	ctx, cancel := context.WithTimeout(context.Background(), max(s.timeout, time.Second))
+	defer cancel()
+	state.SetContext(ctx)
+	defer state.RemoveContext()
+	if err := state.CallByParam(lua.P{Fn: state.NewFunctionFromProto(s.proto), Protect: true}); err != nil {
+		state.Close()
+		return nil, errors.Wrapf(err, "failed to run lua script %s", s.name)
+	}
+	return state, nil
+}
+
+// call calls a hook with its argument in an idle interpreter
+func (s *script) call(ctx context.Context, hook string, argument any, typeName string) error {
+	s.mu.Lock()
+	var state *lua.LState
+	if n := len(s.idle); n > 0 {
+		state, s.idle = s.idle[n-1], s.idle[:n-1]
+	}
+	s.mu.Unlock()
+	if state == nil {
+		var err error
+		if state, err = s.newState(); err != nil {
+			return err
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	state.SetContext(ctx)
+	userData := state.NewUserData()
+	userData.Value = argument
+	state.SetMetatable(userData, state.GetTypeMetatable(typeName))
+	err := state.CallByParam(lua.P{Fn: state.GetGlobal(hook), Protect: true}, userData)
+	state.RemoveContext()
+	if err != nil {
+		// The interpreter may be left in any state by the failed call
+		state.Close()
+		return errors.Wrapf(err, "%s failed", hook)
+	}
+	s.mu.Lock()
+	s.idle = append(s.idle, state)
+	s.mu.Unlock()
+	return nil
+}
@@ -0,0 +1,13 @@
+package scripting
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+func TestScripting(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Scripting Suite")
+}
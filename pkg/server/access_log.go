@@ -0,0 +1,90 @@
+package server
+
+import (
+	"time"
+
+	"github.com/animalet/sargantana-go/pkg/logging"
+	"github.com/animalet/sargantana-go/pkg/sessionsx"
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+)
+
+// ResponseBytesKey is the context key where handlers writing the response body past gin's
+// ResponseWriter, e.g. with sendfile, record the number of bytes sent as an int64.
+const ResponseBytesKey = "sargantana.response_bytes"
+
+// accessLogMiddleware ships one access record per request when log shipping is enabled. It must be
+// installed after requestContextMiddleware to include the request ID.
+func accessLogMiddleware(c *gin.Context) {
+	start := time.Now()
+	c.Next()
+
+	size := c.Writer.Size()
+	if sent, ok := c.Get(ResponseBytesKey); ok {
+		size = int(sent.(int64))
+	}
+	if size < 0 {
+		size = 0
+	}
+	fields := map[string]any{
+		"method":      c.Request.Method,
+		"path":        c.Request.URL.Path,
+		"route":       c.FullPath(),
+		"status":      ResponseStatus(c),
+		"duration_ms": float64(time.Since(start).Microseconds()) / 1000,
+		"bytes":       size,
+		"client_ip":   c.ClientIP(),
+		"user_agent":  c.Request.UserAgent(),
+		"request_id":  RequestID(c),
+	}
+	if _, ok := c.Get(sessions.DefaultKey); ok {
+		if user, ok := sessionsx.User(c); ok {
+			fields["user_id"] = user.Id
+		}
+	}
+	if username, ok := GetRemoteUser(c); ok {
+		fields["remote_user"] = username
+	}
+	addControllerFields(c, fields)
+	logging.Ship(logging.StreamAccess, fields)
+}
+
+// Audit ships an audit record of a security relevant action performed in the request, such as a
+// login or a configuration change, when log shipping is enabled. The record includes the request
+// ID, the client IP, the user and the controller binding handling the request, besides the
+// given fields.
+//
+// Example usage:
+//
+//	server.Audit(c, "order_refunded", map[string]any{"order": id})
+func Audit(c *gin.Context, action string, fields map[string]any) {
+	if !logging.ShippingEnabled() {
+		return
+	}
+	record := map[string]any{
+		"action":     action,
+		"request_id": RequestID(c),
+		"client_ip":  c.ClientIP(),
+	}
+	if _, ok := c.Get(sessions.DefaultKey); ok {
+		if user, ok := sessionsx.User(c); ok {
+			record["user_id"] = user.Id
+		}
+	}
+	if username, ok := GetRemoteUser(c); ok {
+		record["remote_user"] = username
+	}
+	addControllerFields(c, record)
+	for key, value := range fields {
+		record[key] = value
+	}
+	logging.Ship(logging.StreamAudit, record)
+}
+
+// addControllerFields adds the controller binding handling the request, if any, to a record.
+func addControllerFields(c *gin.Context, fields map[string]any) {
+	if name := ControllerName(c); name != "" {
+		fields["controller"] = name
+		fields["controller_type"] = ControllerType(c)
+	}
+}
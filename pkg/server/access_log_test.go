@@ -0,0 +1,129 @@
+//go:build unit
+
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+
+	"github.com/animalet/sargantana-go/pkg/logging"
+	"github.com/gin-gonic/gin"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/rs/zerolog/log"
+)
+
+var _ = Describe("Access log", func() {
+	It("should ship one access record per request", func() {
+		var (
+			mu     sync.Mutex
+			bodies [][]byte
+		)
+		backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body, _ := io.ReadAll(r.Body)
+			mu.Lock()
+			bodies = append(bodies, body)
+			mu.Unlock()
+		}))
+		defer backend.Close()
+
+		originalLogger, originalSlog := log.Logger, slog.Default()
+		DeferCleanup(func() {
+			log.Logger = originalLogger
+			slog.SetDefault(originalSlog)
+		})
+		Expect(logging.Configure(logging.Config{Shipping: &logging.ShippingConfig{
+			OpenSearch: &logging.OpenSearchConfig{URL: backend.URL},
+		}}, io.Discard)).To(Succeed())
+
+		gin.SetMode(gin.TestMode)
+		engine := gin.New()
+		engine.Use(requestContextMiddleware, accessLogMiddleware)
+		engine.GET("/items/:id", func(c *gin.Context) { c.String(http.StatusCreated, "created") })
+
+		request := httptest.NewRequest(http.MethodGet, "/items/42", nil)
+		request.Header.Set(RequestIDHeader, "req-1")
+		request.Header.Set("User-Agent", "tests")
+		engine.ServeHTTP(httptest.NewRecorder(), request)
+		Expect(logging.Close()).To(Succeed())
+
+		Expect(bodies).To(HaveLen(1))
+		scanner := bufio.NewScanner(bytes.NewReader(bodies[0]))
+		Expect(scanner.Scan()).To(BeTrue())
+		Expect(scanner.Scan()).To(BeTrue())
+		var record map[string]interface{}
+		Expect(json.Unmarshal(scanner.Bytes(), &record)).To(Succeed())
+		Expect(record).To(HaveKeyWithValue("stream", logging.StreamAccess))
+		Expect(record).To(HaveKeyWithValue("method", http.MethodGet))
+		Expect(record).To(HaveKeyWithValue("path", "/items/42"))
+		Expect(record).To(HaveKeyWithValue("route", "/items/:id"))
+		Expect(record).To(HaveKeyWithValue("status", BeNumerically("==", http.StatusCreated)))
+		Expect(record).To(HaveKeyWithValue("bytes", BeNumerically("==", len("created"))))
+		Expect(record).To(HaveKeyWithValue("request_id", "req-1"))
+		Expect(record).To(HaveKeyWithValue("user_agent", "tests"))
+		Expect(record).To(HaveKey("duration_ms"))
+		Expect(record).NotTo(HaveKey("user_id"))
+	})
+
+	It("should ship audit records and label both streams with the controller binding", func() {
+		var (
+			mu      sync.Mutex
+			records []map[string]interface{}
+		)
+		backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			scanner := bufio.NewScanner(r.Body)
+			for scanner.Scan() {
+				var record map[string]interface{}
+				if json.Unmarshal(scanner.Bytes(), &record) == nil && record["stream"] != nil {
+					mu.Lock()
+					records = append(records, record)
+					mu.Unlock()
+				}
+			}
+		}))
+		defer backend.Close()
+
+		originalLogger, originalSlog := log.Logger, slog.Default()
+		DeferCleanup(func() {
+			log.Logger = originalLogger
+			slog.SetDefault(originalSlog)
+		})
+		Expect(logging.Configure(logging.Config{Shipping: &logging.ShippingConfig{
+			OpenSearch: &logging.OpenSearchConfig{URL: backend.URL},
+		}}, io.Discard)).To(Succeed())
+
+		gin.SetMode(gin.TestMode)
+		engine := gin.New()
+		routes := controllerRoutes{"POST /refunds": {name: "billing", typeName: "payments"}}
+		engine.Use(requestContextMiddleware, routes.middleware, accessLogMiddleware)
+		engine.POST("/refunds", func(c *gin.Context) {
+			c.Set(RemoteUserKey, "alice")
+			Audit(c, "refund", map[string]any{"order": "42"})
+			c.Status(http.StatusNoContent)
+		})
+
+		request := httptest.NewRequest(http.MethodPost, "/refunds", nil)
+		request.Header.Set(RequestIDHeader, "req-2")
+		engine.ServeHTTP(httptest.NewRecorder(), request)
+		Expect(logging.Close()).To(Succeed())
+
+		Expect(records).To(HaveLen(2))
+		audit, access := records[0], records[1]
+		Expect(audit).To(HaveKeyWithValue("stream", logging.StreamAudit))
+		Expect(audit).To(HaveKeyWithValue("action", "refund"))
+		Expect(audit).To(HaveKeyWithValue("order", "42"))
+		Expect(audit).To(HaveKeyWithValue("request_id", "req-2"))
+		Expect(audit).To(HaveKeyWithValue("remote_user", "alice"))
+		Expect(audit).To(HaveKeyWithValue("controller", "billing"))
+		Expect(audit).To(HaveKeyWithValue("controller_type", "payments"))
+		Expect(access).To(HaveKeyWithValue("stream", logging.StreamAccess))
+		Expect(access).To(HaveKeyWithValue("controller", "billing"))
+		Expect(access).To(HaveKeyWithValue("controller_type", "payments"))
+	})
+})
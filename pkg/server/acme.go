@@ -0,0 +1,227 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+	"regexp"
+	"time"
+
+	"github.com/animalet/sargantana-go/pkg/database"
+	"github.com/gomodule/redigo/redis"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+const (
+	defaultACMETable = "sargantana_acme_cache"
+	redisACMEPrefix  = "sargantana:acme:"
+)
+
+var acmeTableName = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*(\.[a-zA-Z_][a-zA-Z0-9_]*)?$`)
+
+// ACMEConfig enables HTTPS on the server listener with certificates obtained and renewed from an
+// ACME certificate authority, Let's Encrypt by default. Renewed certificates are served without a
+// restart. The certificates and the account key are kept in a cache shared by the replicas of the
+// server, in a directory, Redis or PostgreSQL.
+type ACMEConfig struct {
+	// Domains are the host names certificates are requested for. TLS handshakes for other names
+	// are refused.
+	Domains []string `yaml:"domains"`
+	// Email is the contact of the ACME account, notified about expiring certificates. Optional.
+	Email string `yaml:"email,omitempty"`
+	// DirectoryURL is the ACME directory of the certificate authority, e.g. the Let's Encrypt
+	// staging one. Defaults to Let's Encrypt.
+	DirectoryURL string `yaml:"directory_url,omitempty"`
+	// RenewBefore is how long before their expiry certificates are renewed. Defaults to 30 days.
+	RenewBefore time.Duration `yaml:"renew_before,omitempty"`
+	// HTTPAddress is the address answering the HTTP-01 challenges, e.g. ":80", which redirects
+	// the other requests to HTTPS. Without it, only the TLS-ALPN-01 challenges are answered, on
+	// the server address, which must then be reachable on port 443.
+	HTTPAddress string `yaml:"http_address,omitempty"`
+	// CacheDir stores the certificates in a directory.
+	CacheDir string `yaml:"cache_dir,omitempty"`
+	// Redis stores the certificates in Redis.
+	Redis *database.RedisConfig `yaml:"redis,omitempty"`
+	// Postgres stores the certificates in a PostgreSQL table, created if it does not exist.
+	Postgres *database.PostgresConfig `yaml:"postgres,omitempty"`
+	// Table is the PostgreSQL table. Defaults to sargantana_acme_cache.
+	Table string `yaml:"table,omitempty"`
+}
+
+func (c ACMEConfig) Validate() error {
+	if len(c.Domains) == 0 {
+		return errors.New("at least one domain must be set")
+	}
+	for i, domain := range c.Domains {
+		if domain == "" {
+			return errors.Errorf("domain %d must not be empty", i)
+		}
+	}
+	if c.DirectoryURL != "" {
+		if u, err := url.Parse(c.DirectoryURL); err != nil || u.Scheme == "" || u.Host == "" {
+			return errors.Errorf("invalid directory_url %q", c.DirectoryURL)
+		}
+	}
+	if c.RenewBefore < 0 {
+		return errors.New("renew_before must not be negative")
+	}
+	if c.HTTPAddress != "" {
+		if _, err := net.ResolveTCPAddr("tcp", c.HTTPAddress); err != nil {
+			return errors.Wrap(err, "invalid http_address")
+		}
+	}
+	caches := 0
+	for _, set := range []bool{c.CacheDir != "", c.Redis != nil, c.Postgres != nil} {
+		if set {
+			caches++
+		}
+	}
+	if caches != 1 {
+		// Without a cache, every start would request new certificates and hit the rate limits
+		return errors.New("exactly one of cache_dir, redis or postgres must be set")
+	}
+	if c.Redis != nil {
+		if err := c.Redis.Validate(); err != nil {
+			return errors.Wrap(err, "invalid redis configuration")
+		}
+	}
+	if c.Postgres != nil {
+		if err := c.Postgres.Validate(); err != nil {
+			return errors.Wrap(err, "invalid postgres configuration")
+		}
+	}
+	if c.Table != "" && !acmeTableName.MatchString(c.Table) {
+		return errors.Errorf("invalid table name %q", c.Table)
+	}
+	return nil
+}
+
+// newACMEManager creates the certificate manager of the config, and the closer of its cache.
+func newACMEManager(c ACMEConfig) (*autocert.Manager, func() error, error) {
+	var (
+		cache  autocert.Cache
+		closer = func() error { return nil }
+	)
+	switch {
+	case c.Redis != nil:
+		pool, err := c.Redis.CreateClient()
+		if err != nil {
+			return nil, nil, errors.Wrap(err, "failed to create redis client")
+		}
+		cache, closer = &redisACMECache{pool: pool}, func() error { return database.CloseRedis(pool) }
+	case c.Postgres != nil:
+		pool, err := c.Postgres.CreateClient()
+		if err != nil {
+			return nil, nil, errors.Wrap(err, "failed to create postgres client")
+		}
+		table := c.Table
+		if table == "" {
+			table = defaultACMETable
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if cache, err = newPostgresACMECache(ctx, pool, table); err != nil {
+			database.ClosePostgres(pool)
+			return nil, nil, err
+		}
+		closer = func() error {
+			database.ClosePostgres(pool)
+			return nil
+		}
+	default:
+		cache = autocert.DirCache(c.CacheDir)
+	}
+
+	manager := &autocert.Manager{
+		Prompt:      autocert.AcceptTOS,
+		Cache:       cache,
+		HostPolicy:  autocert.HostWhitelist(c.Domains...),
+		Email:       c.Email,
+		RenewBefore: c.RenewBefore,
+	}
+	if c.DirectoryURL != "" {
+		manager.Client = &acme.Client{DirectoryURL: c.DirectoryURL}
+	}
+	return manager, closer, nil
+}
+
+// redisACMECache is an autocert.Cache storing the entries as Redis strings
+type redisACMECache struct {
+	pool *redis.Pool
+}
+
+func (r *redisACMECache) Get(ctx context.Context, key string) ([]byte, error) {
+	conn, err := r.pool.GetContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = conn.Close() }()
+	data, err := redis.Bytes(conn.Do("GET", redisACMEPrefix+key))
+	if errors.Is(err, redis.ErrNil) {
+		return nil, autocert.ErrCacheMiss
+	}
+	return data, errors.Wrap(err, "failed to get acme cache entry")
+}
+
+func (r *redisACMECache) Put(ctx context.Context, key string, data []byte) error {
+	conn, err := r.pool.GetContext(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = conn.Close() }()
+	_, err = conn.Do("SET", redisACMEPrefix+key, data)
+	return errors.Wrap(err, "failed to put acme cache entry")
+}
+
+func (r *redisACMECache) Delete(ctx context.Context, key string) error {
+	conn, err := r.pool.GetContext(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = conn.Close() }()
+	_, err = conn.Do("DEL", redisACMEPrefix+key)
+	return errors.Wrap(err, "failed to delete acme cache entry")
+}
+
+// postgresACMECache is an autocert.Cache storing the entries in a table
+type postgresACMECache struct {
+	pool  *pgxpool.Pool
+	table string
+}
+
+func newPostgresACMECache(ctx context.Context, pool *pgxpool.Pool, table string) (*postgresACMECache, error) {
+	_, err := pool.Exec(ctx, fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+		key TEXT PRIMARY KEY,
+		data BYTEA NOT NULL,
+		updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
+	)`, table))
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to create acme cache table %s", table)
+	}
+	return &postgresACMECache{pool: pool, table: table}, nil
+}
+
+func (p *postgresACMECache) Get(ctx context.Context, key string) ([]byte, error) {
+	var data []byte
+	err := p.pool.QueryRow(ctx, fmt.Sprintf("SELECT data FROM %s WHERE key = $1", p.table), key).Scan(&data)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, autocert.ErrCacheMiss
+	}
+	return data, errors.Wrap(err, "failed to get acme cache entry")
+}
+
+func (p *postgresACMECache) Put(ctx context.Context, key string, data []byte) error {
+	_, err := p.pool.Exec(ctx, fmt.Sprintf(`INSERT INTO %s (key, data) VALUES ($1, $2)
+		ON CONFLICT (key) DO UPDATE SET data = EXCLUDED.data, updated_at = now()`, p.table), key, data)
+	return errors.Wrap(err, "failed to put acme cache entry")
+}
+
+func (p *postgresACMECache) Delete(ctx context.Context, key string) error {
+	_, err := p.pool.Exec(ctx, fmt.Sprintf("DELETE FROM %s WHERE key = $1", p.table), key)
+	return errors.Wrap(err, "failed to delete acme cache entry")
+}
@@ -0,0 +1,69 @@
+//go:build integration
+
+package server
+
+import (
+	"context"
+	"time"
+
+	"github.com/animalet/sargantana-go/pkg/database"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+var _ = Describe("ACME caches", func() {
+	ctx := context.Background()
+
+	behaveLikeACache := func(newCache func() autocert.Cache) {
+		It("should put, get and delete entries", func() {
+			cache := newCache()
+			key := "integration-" + time.Now().Format(time.RFC3339Nano)
+			DeferCleanup(func() { _ = cache.Delete(ctx, key) })
+
+			_, err := cache.Get(ctx, key)
+			Expect(err).To(MatchError(autocert.ErrCacheMiss))
+			Expect(cache.Put(ctx, key, []byte("certificate"))).To(Succeed())
+			Expect(cache.Put(ctx, key, []byte("renewed certificate"))).To(Succeed())
+			Expect(cache.Get(ctx, key)).To(Equal([]byte("renewed certificate")))
+			Expect(cache.Delete(ctx, key)).To(Succeed())
+			_, err = cache.Get(ctx, key)
+			Expect(err).To(MatchError(autocert.ErrCacheMiss))
+		})
+	}
+
+	Context("Redis", func() {
+		behaveLikeACache(func() autocert.Cache {
+			pool, err := database.RedisConfig{
+				Address:  "localhost:6379",
+				Username: "redisuser",
+				Password: "redispass",
+				MaxIdle:  3,
+			}.CreateClient()
+			Expect(err).NotTo(HaveOccurred())
+			DeferCleanup(pool.Close)
+			return &redisACMECache{pool: pool}
+		})
+	})
+
+	Context("PostgreSQL", func() {
+		behaveLikeACache(func() autocert.Cache {
+			pool, err := database.PostgresConfig{
+				Host:     "localhost",
+				Port:     5432,
+				User:     "user",
+				Password: "password",
+				Database: "my_blog_db",
+				SSLMode:  "disable",
+			}.CreateClient()
+			Expect(err).NotTo(HaveOccurred())
+			DeferCleanup(pool.Close)
+			cache, err := newPostgresACMECache(ctx, pool, "acme_cache_integration")
+			Expect(err).NotTo(HaveOccurred())
+			DeferCleanup(func() {
+				_, _ = pool.Exec(context.Background(), "DROP TABLE IF EXISTS acme_cache_integration")
+			})
+			return cache
+		})
+	})
+})
@@ -0,0 +1,127 @@
+//go:build unit
+
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	"github.com/animalet/sargantana-go/pkg/database"
+	"github.com/gin-contrib/sessions/cookie"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+var _ = Describe("ACME", func() {
+	Context("ACMEConfig Validate", func() {
+		valid := func() ACMEConfig {
+			return ACMEConfig{Domains: []string{"example.org"}, CacheDir: "/var/lib/sargantana/acme"}
+		}
+
+		It("should accept a domain with a cache", func() {
+			Expect(valid().Validate()).To(Succeed())
+			config := valid()
+			config.CacheDir = ""
+			config.Postgres = &database.PostgresConfig{Host: "localhost", Port: 5432, User: "user", Password: "password", Database: "db"}
+			config.Table = "tls.certificates"
+			config.DirectoryURL = "https://acme-staging-v02.api.letsencrypt.org/directory"
+			config.HTTPAddress = ":80"
+			Expect(config.Validate()).To(Succeed())
+		})
+
+		It("should reject invalid settings", func() {
+			config := valid()
+			config.Domains = nil
+			Expect(config.Validate()).To(MatchError(ContainSubstring("at least one domain")))
+			config = valid()
+			config.Domains = []string{""}
+			Expect(config.Validate()).To(MatchError(ContainSubstring("must not be empty")))
+			config = valid()
+			config.DirectoryURL = "letsencrypt"
+			Expect(config.Validate()).To(MatchError(ContainSubstring("invalid directory_url")))
+			config = valid()
+			config.RenewBefore = -time.Hour
+			Expect(config.Validate()).To(MatchError(ContainSubstring("renew_before")))
+			config = valid()
+			config.HTTPAddress = "localhost:http-port"
+			Expect(config.Validate()).To(MatchError(ContainSubstring("invalid http_address")))
+			config = valid()
+			config.Table = "drop table;"
+			Expect(config.Validate()).To(MatchError(ContainSubstring("invalid table name")))
+		})
+
+		It("should require exactly one cache", func() {
+			config := valid()
+			config.CacheDir = ""
+			Expect(config.Validate()).To(MatchError(ContainSubstring("exactly one of")))
+			config = valid()
+			config.Redis = &database.RedisConfig{Address: "localhost:6379"}
+			Expect(config.Validate()).To(MatchError(ContainSubstring("exactly one of")))
+		})
+
+		It("should not allow tls and acme together", func() {
+			config := valid()
+			cfg := WebServerConfig{
+				Address:       "localhost:8443",
+				SessionName:   "session",
+				SessionSecret: "secret",
+				TLS:           &TLSConfig{CertFile: "cert.pem", KeyFile: "key.pem"},
+				ACME:          &config,
+			}
+			Expect(cfg.Validate()).To(MatchError(ContainSubstring("tls and acme")))
+			cfg.TLS = nil
+			Expect(cfg.Validate()).To(Succeed())
+		})
+	})
+
+	It("should create a manager for the configured domains", func() {
+		dir := GinkgoT().TempDir()
+		manager, closer, err := newACMEManager(ACMEConfig{
+			Domains:      []string{"example.org", "www.example.org"},
+			Email:        "admin@example.org",
+			DirectoryURL: "https://acme.example.org/directory",
+			RenewBefore:  10 * 24 * time.Hour,
+			CacheDir:     dir,
+		})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(closer()).To(Succeed())
+		Expect(manager.Cache).To(Equal(autocert.DirCache(dir)))
+		Expect(manager.Email).To(Equal("admin@example.org"))
+		Expect(manager.RenewBefore).To(Equal(10 * 24 * time.Hour))
+		Expect(manager.Client.DirectoryURL).To(Equal("https://acme.example.org/directory"))
+
+		ctx := context.Background()
+		Expect(manager.HostPolicy(ctx, "www.example.org")).To(Succeed())
+		Expect(manager.HostPolicy(ctx, "attacker.example.com")).NotTo(Succeed())
+	})
+
+	It("should serve TLS with the certificates of the manager and answer the HTTP challenges", func() {
+		s := NewServer(SargantanaConfig{WebServerConfig: WebServerConfig{
+			Address:       "localhost:0",
+			SessionName:   "session",
+			SessionSecret: "secret",
+			ACME: &ACMEConfig{
+				Domains:     []string{"example.org"},
+				CacheDir:    GinkgoT().TempDir(),
+				HTTPAddress: "localhost:0",
+			},
+		}})
+		s.SetSessionStore(cookie.NewStore([]byte("secret")))
+		Expect(s.bootstrap()).To(Succeed())
+
+		tlsConfig := s.httpServer.TLSConfig
+		Expect(tlsConfig.GetCertificate).NotTo(BeNil())
+		Expect(tlsConfig.NextProtos).To(ContainElement(acme.ALPNProto))
+		Expect(s.challengeServer).NotTo(BeNil())
+
+		// Requests other than challenges are redirected to HTTPS
+		recorder := httptest.NewRecorder()
+		s.challengeServer.Handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "http://example.org/page?q=1", nil))
+		Expect(recorder.Code).To(Equal(http.StatusFound))
+		Expect(recorder.Header().Get("Location")).To(Equal("https://example.org/page?q=1"))
+	})
+})
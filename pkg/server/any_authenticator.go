@@ -0,0 +1,144 @@
+package server
+
+import (
+	"bytes"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// AnyAuthenticator composes authenticators, accepting the requests accepted by any of them.
+// The authenticators are tried in order, e.g. goth sessions for browsers first and bearer tokens
+// for machine clients next, and the first one accepting the request wins: the context values it
+// set, such as the user, are kept and the request proceeds.
+//
+// If every authenticator rejects the request, the response of the first one is sent, carrying
+// the WWW-Authenticate challenges of all of them.
+type AnyAuthenticator struct {
+	probe       authProbe
+	middlewares []gin.HandlerFunc
+}
+
+// NewAnyAuthenticator creates a new authenticator accepting the requests accepted by any of the
+// given authenticators.
+//
+// Example usage:
+//
+//	jwtAuthenticator, err := server.NewJWTAuthenticator(jwtCfg)
+//	if err != nil {
+//	    return err
+//	}
+//	srv.SetAuthenticator(server.NewAnyAuthenticator(controller.NewGothAuthenticator(), jwtAuthenticator))
+func NewAnyAuthenticator(authenticators ...Authenticator) Authenticator {
+	composite := &AnyAuthenticator{probe: newAuthProbe()}
+	for _, authenticator := range authenticators {
+		composite.middlewares = append(composite.middlewares, authenticator.Middleware())
+	}
+	return composite
+}
+
+// Middleware returns a Gin middleware that requires any of the authenticators to accept the request.
+func (a *AnyAuthenticator) Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var rejections []*authResponse
+		for _, middleware := range a.middlewares {
+			accepted, response := a.probe.run(c, middleware)
+			if accepted {
+				c.Next()
+				return
+			}
+			rejections = append(rejections, response)
+		}
+		rejectAll(c, rejections)
+	}
+}
+
+// authProbe runs authentication middlewares on a copy of the request context, so that a
+// rejection can be ignored or replaced instead of being sent.
+type authProbe struct {
+	engine *gin.Engine
+}
+
+func newAuthProbe() authProbe {
+	engine := gin.New()
+	// The probes must not trust forwarding headers, like the server engine in release mode
+	_ = engine.SetTrustedProxies(nil)
+	return authProbe{engine: engine}
+}
+
+// run runs the middleware on a copy of the context with the values of the original one, recording
+// its response. If the middleware accepts the request, what it set, e.g. the user or a refreshed
+// cookie, is copied to the original context.
+func (p authProbe) run(c *gin.Context, middleware gin.HandlerFunc) (accepted bool, response *authResponse) {
+	response = &authResponse{header: make(http.Header)}
+	probe := gin.CreateTestContextOnly(response, p.engine)
+	probe.Request = c.Request
+	probe.Params = c.Params
+	for key, value := range c.Keys {
+		probe.Set(key, value)
+	}
+
+	middleware(probe)
+	if probe.IsAborted() {
+		return false, response
+	}
+	for key, value := range probe.Keys {
+		c.Set(key, value)
+	}
+	for name, values := range response.header {
+		c.Writer.Header()[name] = values
+	}
+	c.Request = probe.Request
+	return true, response
+}
+
+// rejectAll sends the first rejection, with the challenges of all of them.
+func rejectAll(c *gin.Context, rejections []*authResponse) {
+	if len(rejections) == 0 {
+		c.AbortWithStatus(http.StatusUnauthorized)
+		return
+	}
+	first := rejections[0]
+	for name, values := range first.header {
+		if name != "Www-Authenticate" {
+			c.Writer.Header()[name] = values
+		}
+	}
+	for _, rejection := range rejections {
+		for _, challenge := range rejection.header.Values("WWW-Authenticate") {
+			c.Writer.Header().Add("WWW-Authenticate", challenge)
+		}
+	}
+	status := first.status
+	if status == 0 {
+		status = http.StatusUnauthorized
+	}
+	c.AbortWithStatus(status)
+	if first.body.Len() > 0 {
+		_, _ = c.Writer.Write(first.body.Bytes())
+	}
+}
+
+// authResponse records the response of an authenticator.
+type authResponse struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (r *authResponse) Header() http.Header {
+	return r.header
+}
+
+func (r *authResponse) Write(data []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	return r.body.Write(data)
+}
+
+func (r *authResponse) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+}
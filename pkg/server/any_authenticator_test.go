@@ -0,0 +1,92 @@
+//go:build unit
+
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/gin-gonic/gin"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+// headerAuthenticator accepts requests carrying a header, rejecting others with a challenge.
+type headerAuthenticator struct {
+	header    string
+	challenge string
+}
+
+func (h *headerAuthenticator) Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		value := c.GetHeader(h.header)
+		if value == "" {
+			c.Header("WWW-Authenticate", h.challenge)
+			c.Header("X-Rejected-By", h.header)
+			c.AbortWithStatus(http.StatusUnauthorized)
+			return
+		}
+		c.Set(RemoteUserKey, value)
+		c.Header("X-Accepted-By", h.header)
+		c.Next()
+	}
+}
+
+var _ = Describe("AnyAuthenticator", func() {
+	var handled int
+
+	serve := func(authenticator Authenticator, header http.Header) *httptest.ResponseRecorder {
+		gin.SetMode(gin.TestMode)
+		handled = 0
+		engine := gin.New()
+		engine.Use(func(c *gin.Context) {
+			c.Set("outer", "value")
+		})
+		engine.GET("/items/:id", authenticator.Middleware(), func(c *gin.Context) {
+			handled++
+			user, _ := GetRemoteUser(c)
+			c.String(http.StatusOK, "%s %s %s", user, c.GetString("outer"), c.Param("id"))
+		})
+
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/items/42", nil)
+		req.Header = header
+		engine.ServeHTTP(w, req)
+		return w
+	}
+
+	session := &headerAuthenticator{header: "X-Session", challenge: `Session realm="app"`}
+	token := &headerAuthenticator{header: "X-Token", challenge: "Bearer"}
+
+	It("should accept requests accepted by any authenticator", func() {
+		authenticator := NewAnyAuthenticator(session, token)
+
+		w := serve(authenticator, http.Header{"X-Session": {"alice"}})
+		Expect(w.Code).To(Equal(http.StatusOK))
+		Expect(w.Body.String()).To(Equal("alice value 42"))
+		Expect(w.Header().Get("X-Accepted-By")).To(Equal("X-Session"))
+
+		w = serve(authenticator, http.Header{"X-Token": {"machine"}})
+		Expect(w.Code).To(Equal(http.StatusOK))
+		Expect(w.Body.String()).To(Equal("machine value 42"))
+		Expect(w.Header().Get("X-Rejected-By")).To(BeEmpty())
+		Expect(handled).To(Equal(1))
+	})
+
+	It("should prefer the first accepting authenticator", func() {
+		w := serve(NewAnyAuthenticator(session, token), http.Header{"X-Session": {"alice"}, "X-Token": {"machine"}})
+		Expect(w.Body.String()).To(HavePrefix("alice "))
+	})
+
+	It("should reject with the first response and every challenge", func() {
+		w := serve(NewAnyAuthenticator(session, token), http.Header{})
+		Expect(w.Code).To(Equal(http.StatusUnauthorized))
+		Expect(w.Header().Values("WWW-Authenticate")).To(Equal([]string{`Session realm="app"`, "Bearer"}))
+		Expect(w.Header().Get("X-Rejected-By")).To(Equal("X-Session"))
+		Expect(handled).To(BeZero())
+
+		w = serve(NewAnyAuthenticator(NewUnauthorizedAuthenticator()), http.Header{"X-Token": {"machine"}})
+		Expect(w.Code).To(Equal(http.StatusUnauthorized))
+		Expect(serve(NewAnyAuthenticator(), http.Header{}).Code).To(Equal(http.StatusUnauthorized))
+	})
+})
@@ -1,9 +1,18 @@
 package server
 
 import (
+	"maps"
 	"net/http"
+	"slices"
+	"sort"
+	"strings"
 
+	"github.com/animalet/sargantana-go/pkg/config"
+	"github.com/animalet/sargantana-go/pkg/sessionsx"
+	"github.com/gin-contrib/sessions"
 	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
 )
 
 // Authenticator defines the interface for authentication middleware providers.
@@ -46,3 +55,260 @@ func (u *UnauthorizedAuthenticator) Middleware() gin.HandlerFunc {
 func NewUnauthorizedAuthenticator() Authenticator {
 	return &UnauthorizedAuthenticator{}
 }
+
+// RemoteUserKey is the Gin context key under which BasicAuthenticator, TrustedHeaderAuthenticator,
+// JWTAuthenticator and ClientCertAuthenticator store the name of the authenticated user.
+const RemoteUserKey = "remote_user"
+
+// GetRemoteUser returns the user name stored by BasicAuthenticator, TrustedHeaderAuthenticator or
+// JWTAuthenticator.
+func GetRemoteUser(c *gin.Context) (string, bool) {
+	username := c.GetString(RemoteUserKey)
+	return username, username != ""
+}
+
+// UserId returns the ID of the signed-in user: the session user of the auth controller, or the
+// user of the basic, header, JWT or client certificate authenticators. Empty for anonymous requests.
+func UserId(c *gin.Context) string {
+	if _, ok := c.Get(sessions.DefaultKey); ok {
+		if user, ok := sessionsx.User(c); ok && user.Id != "" {
+			return user.Id
+		}
+	}
+	user, _ := GetRemoteUser(c)
+	return strings.TrimSpace(user)
+}
+
+// RolesKey and ScopesKey are the Gin context keys under which authenticators store the roles and
+// the OAuth2 scopes granted to the authenticated user, as a []string. They are checked by the
+// middlewares of ControllerContext.RequireRole and ControllerContext.RequireScope.
+const (
+	RolesKey  = "roles"
+	ScopesKey = "scopes"
+)
+
+// GetRoles returns the roles of the authenticated user.
+func GetRoles(c *gin.Context) []string {
+	return c.GetStringSlice(RolesKey)
+}
+
+// GetScopes returns the OAuth2 scopes granted to the authenticated user.
+func GetScopes(c *gin.Context) []string {
+	return c.GetStringSlice(ScopesKey)
+}
+
+// AnonymousAuthenticator lets every request through without authentication. It disables the
+// protection of all routes, so it is only meant for development or for deployments where
+// access is controlled elsewhere.
+type AnonymousAuthenticator struct{}
+
+// Middleware returns a Gin middleware that accepts every request.
+func (a *AnonymousAuthenticator) Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Next()
+	}
+}
+
+// NewAnonymousAuthenticator creates a new authenticator accepting every request.
+func NewAnonymousAuthenticator() Authenticator {
+	log.Warn().Msg("Authentication is disabled, protected routes are accessible to anyone")
+	return &AnonymousAuthenticator{}
+}
+
+// AnonymousAuthenticatorConfig is the empty configuration of AnonymousAuthenticator.
+type AnonymousAuthenticatorConfig struct{}
+
+func (c AnonymousAuthenticatorConfig) Validate() error {
+	return nil
+}
+
+// AuthenticatorConfig selects an authenticator by the type it was registered with, see
+// RegisterAuthenticator. Config holds the options of the type and may be omitted if the type
+// has none. Alternatively, AnyOf composes several authenticators, see AnyAuthenticator.
+type AuthenticatorConfig struct {
+	TypeName string                 `yaml:"type,omitempty"`
+	Config   config.ModuleRawConfig `yaml:"config,omitempty"`
+	// AnyOf accepts the requests accepted by any of the authenticators, tried in order.
+	AnyOf []AuthenticatorConfig `yaml:"any_of,omitempty"`
+}
+
+func (c AuthenticatorConfig) Validate() error {
+	if (c.TypeName == "") == (len(c.AnyOf) == 0) {
+		return errors.New("exactly one of authenticator type or any_of must be set")
+	}
+	if c.Config != nil && c.TypeName == "" {
+		return errors.New("config requires an authenticator type")
+	}
+	for i, authenticator := range c.AnyOf {
+		if err := authenticator.Validate(); err != nil {
+			return errors.Wrapf(err, "invalid any_of authenticator at index %d", i)
+		}
+	}
+	return nil
+}
+
+// AuthenticatorRoute selects the authenticator of the requests whose path starts with a prefix.
+type AuthenticatorRoute struct {
+	PathPrefix string `yaml:"path_prefix"`
+	// Authenticator is the name of the authenticator in the authenticators section, or "default".
+	Authenticator string `yaml:"authenticator"`
+}
+
+func (r AuthenticatorRoute) Validate() error {
+	if !strings.HasPrefix(r.PathPrefix, "/") {
+		return errors.New("path_prefix must be set and start with /")
+	}
+	if r.Authenticator == "" {
+		return errors.New("authenticator must be set and non-empty")
+	}
+	return nil
+}
+
+// DefaultAuthenticatorName refers to the default authenticator of the server, the one set with
+// Server.SetAuthenticator or in the authenticator section, in controller bindings and
+// authenticator routes.
+const DefaultAuthenticatorName = "default"
+
+// AuthenticatorFactory is a factory function that creates an authenticator from its
+// configuration.
+type AuthenticatorFactory func(authenticatorConfig config.ModuleRawConfig) (Authenticator, error)
+
+// authenticatorRegistry holds the mapping of authenticator type names to their factory functions.
+var authenticatorRegistry = make(map[string]AuthenticatorFactory)
+
+// RegisterAuthenticator registers an authenticator factory that takes a typed configuration,
+// making the type selectable in the authenticator section of the server configuration.
+// T must implement config.Validatable.
+func RegisterAuthenticator[T config.Validatable](typeName string, factory func(cfg *T) (Authenticator, error)) {
+	log.Info().Msgf("Registering authenticator type %q", typeName)
+	if _, exists := authenticatorRegistry[typeName]; exists {
+		log.Warn().Msgf("Authenticator type %q is already registered, overriding", typeName)
+	}
+	authenticatorRegistry[typeName] = func(raw config.ModuleRawConfig) (Authenticator, error) {
+		if len(raw) == 0 {
+			cfg := new(T)
+			if err := (*cfg).Validate(); err != nil {
+				return nil, errors.Wrapf(err, "configuration for authenticator type %s is required", typeName)
+			}
+			return factory(cfg)
+		}
+		cfg, err := config.Unmarshal[T](raw)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to unmarshal configuration for authenticator type %s", typeName)
+		}
+		return factory(cfg)
+	}
+}
+
+// AuthenticatorTypes returns the sorted names of the registered authenticator types.
+func AuthenticatorTypes() []string {
+	return slices.Sorted(maps.Keys(authenticatorRegistry))
+}
+
+// newAuthenticator creates the authenticator selected by the configuration.
+func newAuthenticator(c AuthenticatorConfig) (Authenticator, error) {
+	if len(c.AnyOf) > 0 {
+		authenticators := make([]Authenticator, 0, len(c.AnyOf))
+		for i, authCfg := range c.AnyOf {
+			authenticator, err := newAuthenticator(authCfg)
+			if err != nil {
+				return nil, errors.Wrapf(err, "failed to configure any_of authenticator at index %d", i)
+			}
+			authenticators = append(authenticators, authenticator)
+		}
+		return NewAnyAuthenticator(authenticators...), nil
+	}
+
+	factory, exists := authenticatorRegistry[c.TypeName]
+	if !exists {
+		return nil, errors.Errorf("no factory found for authenticator type %q", c.TypeName)
+	}
+	log.Info().Str("type", c.TypeName).Msg("Configuring authenticator")
+	return factory(c.Config)
+}
+
+// loginMiddlewares resolves the login middleware handed to each controller binding.
+type loginMiddlewares struct {
+	// middlewares holds the middleware of each authenticator by name, including the default one
+	middlewares map[string]gin.HandlerFunc
+	// routes are sorted by descending prefix length, so that the longest matching prefix wins
+	routes []authenticatorRoute
+}
+
+type authenticatorRoute struct {
+	prefix     string
+	middleware gin.HandlerFunc
+}
+
+// configureAuthenticators creates the default and named authenticators missing from the server
+// and resolves the authenticator routes.
+func (s *Server) configureAuthenticators() (*loginMiddlewares, error) {
+	if s.authenticator == nil {
+		if authCfg := s.config.WebServerConfig.Authenticator; authCfg != nil {
+			authenticator, err := newAuthenticator(*authCfg)
+			if err != nil {
+				return nil, errors.Wrap(err, "failed to configure authenticator")
+			}
+			s.authenticator = authenticator
+		} else {
+			s.authenticator = NewUnauthorizedAuthenticator()
+		}
+	}
+	for name, authCfg := range s.config.WebServerConfig.Authenticators {
+		if _, exists := s.authenticators[name]; exists {
+			continue
+		}
+		authenticator, err := newAuthenticator(authCfg)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to configure authenticator %q", name)
+		}
+		s.authenticators[name] = authenticator
+	}
+
+	login := &loginMiddlewares{middlewares: map[string]gin.HandlerFunc{DefaultAuthenticatorName: s.authenticator.Middleware()}}
+	for name, authenticator := range s.authenticators {
+		login.middlewares[name] = authenticator.Middleware()
+	}
+	for _, route := range s.config.WebServerConfig.AuthenticatorRoutes {
+		middleware, exists := login.middlewares[route.Authenticator]
+		if !exists {
+			return nil, errors.Errorf("authenticator route %q refers to unknown authenticator %q", route.PathPrefix, route.Authenticator)
+		}
+		log.Info().Str("path_prefix", route.PathPrefix).Str("authenticator", route.Authenticator).Msg("Authenticator route configured")
+		login.routes = append(login.routes, authenticatorRoute{prefix: strings.TrimSuffix(route.PathPrefix, "/"), middleware: middleware})
+	}
+	sort.SliceStable(login.routes, func(i, j int) bool {
+		return len(login.routes[i].prefix) > len(login.routes[j].prefix)
+	})
+	return login, nil
+}
+
+// forBinding returns the login middleware of a controller binding selecting the named
+// authenticator, or the default one if the name is empty.
+func (l *loginMiddlewares) forBinding(name string) (gin.HandlerFunc, error) {
+	if name == "" {
+		name = DefaultAuthenticatorName
+	}
+	middleware, exists := l.middlewares[name]
+	if !exists {
+		return nil, errors.Errorf("unknown authenticator %q", name)
+	}
+	if len(l.routes) == 0 {
+		return middleware, nil
+	}
+	return func(c *gin.Context) {
+		for _, route := range l.routes {
+			if hasPathPrefix(c.Request.URL.Path, route.prefix) {
+				route.middleware(c)
+				return
+			}
+		}
+		middleware(c)
+	}, nil
+}
+
+// hasPathPrefix tells whether the path is the prefix or one of its subpaths. The prefix has no
+// trailing slash, so the empty prefix matches every path.
+func hasPathPrefix(path, prefix string) bool {
+	return prefix == "" || path == prefix || strings.HasPrefix(path, prefix+"/")
+}
@@ -0,0 +1,232 @@
+//go:build unit
+
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/animalet/sargantana-go/pkg/config"
+	"github.com/gin-contrib/sessions/cookie"
+	"github.com/gin-gonic/gin"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Configured authenticator", func() {
+	BeforeEach(func() {
+		RegisterAuthenticator("test-header", func(c *TrustedHeaderAuthenticatorConfig) (Authenticator, error) {
+			return NewTrustedHeaderAuthenticator(*c)
+		})
+		RegisterAuthenticator("test-none", func(*AnonymousAuthenticatorConfig) (Authenticator, error) {
+			return NewAnonymousAuthenticator(), nil
+		})
+		addControllerType("protected-controller", func(config.ModuleRawConfig, ControllerContext) (IController, error) {
+			return &MockController{BindFunc: func(engine *gin.Engine, loginMiddleware gin.HandlerFunc) {
+				engine.GET("/protected", loginMiddleware, func(c *gin.Context) {
+					user, _ := GetRemoteUser(c)
+					c.String(http.StatusOK, user)
+				})
+			}}, nil
+		})
+	})
+
+	// bootstrap starts a server with the authenticator section and returns its handler
+	bootstrap := func(authCfg *AuthenticatorConfig, authenticator Authenticator) (http.Handler, error) {
+		s := NewServer(SargantanaConfig{
+			WebServerConfig: WebServerConfig{
+				Address:       "localhost:0",
+				SessionName:   "test-session",
+				SessionSecret: "secret",
+				Authenticator: authCfg,
+			},
+			ControllerBindings: []ControllerBinding{{TypeName: "protected-controller", Config: config.ModuleRawConfig{}}},
+		})
+		s.SetSessionStore(cookie.NewStore([]byte("secret")))
+		if authenticator != nil {
+			s.SetAuthenticator(authenticator)
+		}
+		if err := s.bootstrap(); err != nil {
+			return nil, err
+		}
+		DeferCleanup(s.Shutdown)
+		return s.httpServer.Handler, nil
+	}
+
+	get := func(handler http.Handler, header http.Header) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/protected", nil)
+		req.RemoteAddr = "10.0.0.1:1234"
+		req.Header = header
+		handler.ServeHTTP(w, req)
+		return w
+	}
+
+	It("should validate the authenticator section", func() {
+		Expect(AuthenticatorConfig{TypeName: "none"}.Validate()).To(Succeed())
+		Expect(AuthenticatorConfig{}.Validate()).To(MatchError(ContainSubstring("exactly one of authenticator type or any_of")))
+		cfg := WebServerConfig{Address: ":8080", SessionName: "s", SessionSecret: "secret", Authenticator: &AuthenticatorConfig{}}
+		Expect(cfg.Validate()).To(MatchError(ContainSubstring("invalid authenticator configuration")))
+	})
+
+	It("should reject protected routes without an authenticator", func() {
+		handler, err := bootstrap(nil, nil)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(get(handler, http.Header{}).Code).To(Equal(http.StatusUnauthorized))
+	})
+
+	It("should create the authenticator of the configured type", func() {
+		handler, err := bootstrap(&AuthenticatorConfig{
+			TypeName: "test-header",
+			Config:   config.ModuleRawConfig("trusted_proxies: [\"10.0.0.0/8\"]\n"),
+		}, nil)
+		Expect(err).NotTo(HaveOccurred())
+
+		w := get(handler, http.Header{"X-Remote-User": {"alice"}})
+		Expect(w.Code).To(Equal(http.StatusOK))
+		Expect(w.Body.String()).To(Equal("alice"))
+		Expect(get(handler, http.Header{}).Code).To(Equal(http.StatusUnauthorized))
+	})
+
+	It("should allow types without configuration", func() {
+		handler, err := bootstrap(&AuthenticatorConfig{TypeName: "test-none"}, nil)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(get(handler, http.Header{}).Code).To(Equal(http.StatusOK))
+	})
+
+	It("should prefer the authenticator set programmatically", func() {
+		handler, err := bootstrap(&AuthenticatorConfig{TypeName: "test-none"}, NewUnauthorizedAuthenticator())
+		Expect(err).NotTo(HaveOccurred())
+		Expect(get(handler, http.Header{}).Code).To(Equal(http.StatusUnauthorized))
+	})
+
+	It("should compose authenticators with any_of", func() {
+		handler, err := bootstrap(&AuthenticatorConfig{AnyOf: []AuthenticatorConfig{
+			{TypeName: "test-header", Config: config.ModuleRawConfig("trusted_proxies: [\"10.0.0.0/8\"]\n")},
+			{TypeName: "test-header", Config: config.ModuleRawConfig("header: X-Machine\ntrusted_proxies: [\"10.0.0.0/8\"]\n")},
+		}}, nil)
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(get(handler, http.Header{"X-Remote-User": {"alice"}}).Body.String()).To(Equal("alice"))
+		Expect(get(handler, http.Header{"X-Machine": {"job"}}).Body.String()).To(Equal("job"))
+		Expect(get(handler, http.Header{}).Code).To(Equal(http.StatusUnauthorized))
+
+		Expect(AuthenticatorConfig{TypeName: "none", AnyOf: []AuthenticatorConfig{{TypeName: "none"}}}.Validate()).To(HaveOccurred())
+		Expect(AuthenticatorConfig{AnyOf: []AuthenticatorConfig{{}}}.Validate()).To(MatchError(ContainSubstring("index 0")))
+		Expect(AuthenticatorConfig{AnyOf: []AuthenticatorConfig{{TypeName: "none"}}, Config: config.ModuleRawConfig("{}")}.Validate()).To(HaveOccurred())
+	})
+
+	It("should fail with unknown types or invalid configurations", func() {
+		_, err := bootstrap(&AuthenticatorConfig{TypeName: "unknown"}, nil)
+		Expect(err).To(MatchError(ContainSubstring(`no factory found for authenticator type "unknown"`)))
+
+		_, err = bootstrap(&AuthenticatorConfig{TypeName: "test-header"}, nil)
+		Expect(err).To(MatchError(ContainSubstring("configuration for authenticator type test-header is required")))
+
+		_, err = bootstrap(&AuthenticatorConfig{TypeName: "test-header", Config: config.ModuleRawConfig("trusted_proxies: [\"proxy\"]\n")}, nil)
+		Expect(err).To(MatchError(ContainSubstring("failed to unmarshal configuration for authenticator type test-header")))
+	})
+
+	Context("Per route selection", func() {
+		// bootstrapRoutes starts a server with a controller bound with each named authenticator
+		bootstrapRoutes := func(cfg WebServerConfig, named map[string]Authenticator) (http.Handler, error) {
+			addControllerType("named-controller", func(raw config.ModuleRawConfig, _ ControllerContext) (IController, error) {
+				prefix := string(raw)
+				return &MockController{BindFunc: func(engine *gin.Engine, loginMiddleware gin.HandlerFunc) {
+					engine.GET(prefix+"/*path", loginMiddleware, func(c *gin.Context) {
+						user, _ := GetRemoteUser(c)
+						c.String(http.StatusOK, user)
+					})
+				}}, nil
+			})
+			cfg.Address, cfg.SessionName, cfg.SessionSecret = "localhost:0", "test-session", "secret"
+			s := NewServer(SargantanaConfig{
+				WebServerConfig: cfg,
+				ControllerBindings: []ControllerBinding{
+					{TypeName: "named-controller", Config: config.ModuleRawConfig("/web")},
+					{TypeName: "named-controller", Config: config.ModuleRawConfig("/api"), Authenticator: "machine"},
+				},
+			})
+			s.SetSessionStore(cookie.NewStore([]byte("secret")))
+			s.SetAuthenticator(&headerAuthenticator{header: "X-Session"})
+			for name, authenticator := range named {
+				s.AddAuthenticator(name, authenticator)
+			}
+			if err := s.bootstrap(); err != nil {
+				return nil, err
+			}
+			DeferCleanup(s.Shutdown)
+			return s.httpServer.Handler, nil
+		}
+
+		request := func(handler http.Handler, path string, header http.Header) string {
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, path, nil)
+			req.RemoteAddr = "10.0.0.1:1234"
+			req.Header = header
+			handler.ServeHTTP(w, req)
+			if w.Code != http.StatusOK {
+				return http.StatusText(w.Code)
+			}
+			return w.Body.String()
+		}
+
+		It("should select the authenticator of each controller binding", func() {
+			handler, err := bootstrapRoutes(WebServerConfig{Authenticators: map[string]AuthenticatorConfig{
+				"machine": {TypeName: "test-header", Config: config.ModuleRawConfig("header: X-Machine\ntrusted_proxies: [\"10.0.0.0/8\"]\n")},
+			}}, nil)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(request(handler, "/web/page", http.Header{"X-Session": {"alice"}})).To(Equal("alice"))
+			Expect(request(handler, "/web/page", http.Header{"X-Machine": {"job"}})).To(Equal("Unauthorized"))
+			Expect(request(handler, "/api/items", http.Header{"X-Machine": {"job"}})).To(Equal("job"))
+			Expect(request(handler, "/api/items", http.Header{"X-Session": {"alice"}})).To(Equal("Unauthorized"))
+		})
+
+		It("should prefer authenticators added programmatically", func() {
+			handler, err := bootstrapRoutes(WebServerConfig{Authenticators: map[string]AuthenticatorConfig{
+				"machine": {TypeName: "unknown"},
+			}}, map[string]Authenticator{"machine": &headerAuthenticator{header: "X-Robot"}})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(request(handler, "/api/items", http.Header{"X-Robot": {"robot"}})).To(Equal("robot"))
+		})
+
+		It("should select the authenticator by the longest path prefix", func() {
+			handler, err := bootstrapRoutes(WebServerConfig{AuthenticatorRoutes: []AuthenticatorRoute{
+				{PathPrefix: "/", Authenticator: "machine"},
+				{PathPrefix: "/api/public/", Authenticator: "open"},
+				{PathPrefix: "/api/users", Authenticator: "default"},
+			}}, map[string]Authenticator{"machine": &headerAuthenticator{header: "X-Machine"}, "open": NewAnonymousAuthenticator()})
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(request(handler, "/web/page", http.Header{"X-Machine": {"job"}})).To(Equal("job"))
+			Expect(request(handler, "/web/page", http.Header{"X-Session": {"alice"}})).To(Equal("Unauthorized"))
+			Expect(request(handler, "/api/public/docs", http.Header{})).To(Equal(""))
+			Expect(request(handler, "/api/users/1", http.Header{"X-Session": {"alice"}})).To(Equal("alice"))
+			Expect(request(handler, "/api/usersettings", http.Header{"X-Session": {"alice"}})).To(Equal("Unauthorized"))
+		})
+
+		It("should validate names and routes", func() {
+			cfg := WebServerConfig{Address: ":8080", SessionName: "s", SessionSecret: "secret"}
+			cfg.Authenticators = map[string]AuthenticatorConfig{"default": {TypeName: "none"}}
+			Expect(cfg.Validate()).To(MatchError(ContainSubstring(`invalid authenticator name "default"`)))
+			cfg.Authenticators = map[string]AuthenticatorConfig{"machine": {}}
+			Expect(cfg.Validate()).To(MatchError(ContainSubstring(`invalid configuration of authenticator "machine"`)))
+			cfg.Authenticators = nil
+			cfg.AuthenticatorRoutes = []AuthenticatorRoute{{PathPrefix: "api", Authenticator: "machine"}}
+			Expect(cfg.Validate()).To(MatchError(ContainSubstring("start with /")))
+			cfg.AuthenticatorRoutes = []AuthenticatorRoute{{PathPrefix: "/api"}}
+			Expect(cfg.Validate()).To(MatchError(ContainSubstring("authenticator must be set")))
+
+			_, err := bootstrapRoutes(WebServerConfig{AuthenticatorRoutes: []AuthenticatorRoute{{PathPrefix: "/api", Authenticator: "missing"}}},
+				map[string]Authenticator{"machine": NewAnonymousAuthenticator()})
+			Expect(err).To(MatchError(ContainSubstring(`refers to unknown authenticator "missing"`)))
+
+			// Like other configuration errors, an unknown authenticator excludes the controller
+			handler, err := bootstrapRoutes(WebServerConfig{}, nil)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(request(handler, "/web/page", http.Header{"X-Session": {"alice"}})).To(Equal("alice"))
+			Expect(request(handler, "/api/items", http.Header{"X-Session": {"alice"}})).To(Equal("Not Found"))
+		})
+	})
+})
@@ -0,0 +1,315 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const defaultBasicAuthRealm = "Restricted"
+
+// BasicAuthenticatorConfig configures HTTP Basic authentication. Credentials are read from an
+// htpasswd file or from a PostgreSQL table; exactly one of both must be set. Passwords must be
+// hashed with bcrypt ($2y$), Apache MD5 ($apr1$) or SHA-1 ({SHA}) as written by htpasswd.
+type BasicAuthenticatorConfig struct {
+	// Realm is sent in the WWW-Authenticate challenge. Defaults to "Restricted".
+	Realm string `yaml:"realm,omitempty"`
+	// HtpasswdFile is a file of "user:hash" lines. It is reloaded when modified.
+	HtpasswdFile string `yaml:"htpasswd_file,omitempty"`
+	// Table looks the password hashes up in a table of the database of the postgres section.
+	Table *BasicAuthTableConfig `yaml:"table,omitempty"`
+}
+
+func (c BasicAuthenticatorConfig) Validate() error {
+	if (c.HtpasswdFile == "") == (c.Table == nil) {
+		return errors.New("exactly one of htpasswd_file or table must be set")
+	}
+	if strings.ContainsRune(c.Realm, '"') {
+		return errors.New("realm must not contain quotes")
+	}
+	if c.Table != nil {
+		return errors.Wrap(c.Table.Validate(), "invalid table configuration")
+	}
+	if _, err := readHtpasswd(c.HtpasswdFile); err != nil {
+		return err
+	}
+	return nil
+}
+
+// BasicAuthTableConfig describes the table holding the credentials.
+type BasicAuthTableConfig struct {
+	// Name of the table, optionally qualified with its schema.
+	Name string `yaml:"name"`
+	// UsernameColumn defaults to "username".
+	UsernameColumn string `yaml:"username_column,omitempty"`
+	// PasswordColumn holds the password hash and defaults to "password_hash".
+	PasswordColumn string `yaml:"password_column,omitempty"`
+}
+
+func (c BasicAuthTableConfig) Validate() error {
+	if c.Name == "" {
+		return errors.New("name must be set and non-empty")
+	}
+	return nil
+}
+
+// BasicAuthenticator implements Authenticator with HTTP Basic authentication. Requests without
+// valid credentials are answered with 401 Unauthorized and a challenge, so browsers prompt for
+// them. On success, the user name is stored in the context under RemoteUserKey.
+//
+// Credentials are sent on every request, so Basic authentication should only be used over TLS.
+type BasicAuthenticator struct {
+	challenge string
+	lookup    func(ctx context.Context, username string) (hash string, found bool, err error)
+}
+
+// NewBasicAuthenticator creates a new authenticator backed by the configured credentials. The
+// pool is only required if the credentials are stored in a table.
+//
+// Example usage:
+//
+//	authenticator, err := server.NewBasicAuthenticator(server.BasicAuthenticatorConfig{
+//	    HtpasswdFile: "/etc/sargantana/htpasswd",
+//	}, nil)
+//	if err != nil {
+//	    return err
+//	}
+//	server.SetAuthenticator(authenticator)
+func NewBasicAuthenticator(c BasicAuthenticatorConfig, pool *pgxpool.Pool) (Authenticator, error) {
+	realm := c.Realm
+	if realm == "" {
+		realm = defaultBasicAuthRealm
+	}
+	authenticator := &BasicAuthenticator{challenge: fmt.Sprintf("Basic realm=%q, charset=\"UTF-8\"", realm)}
+
+	if c.Table != nil {
+		if pool == nil {
+			return nil, errors.New("basic authentication with a table requires a PostgreSQL pool")
+		}
+		authenticator.lookup = newTableLookup(*c.Table, pool)
+	} else {
+		file := &htpasswdFile{path: c.HtpasswdFile}
+		if err := file.reload(); err != nil {
+			return nil, err
+		}
+		authenticator.lookup = file.lookup
+	}
+	return authenticator, nil
+}
+
+// Middleware returns a Gin middleware that requires valid Basic credentials.
+func (a *BasicAuthenticator) Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		username, password, ok := c.Request.BasicAuth()
+		if !ok {
+			a.reject(c)
+			return
+		}
+
+		hash, found, err := a.lookup(c.Request.Context(), username)
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to look up Basic authentication credentials")
+			c.AbortWithStatus(http.StatusInternalServerError)
+			return
+		}
+		if !found {
+			// Spend the same time as for existing users, so that user names cannot be guessed
+			hash = dummyPasswordHash
+		}
+		if !verifyPassword(hash, password) || !found {
+			log.Debug().Str("username", username).Msg("Basic authentication failed")
+			ReportAbuse(c, failedAuthPenalty)
+			a.reject(c)
+			return
+		}
+
+		c.Set(RemoteUserKey, username)
+		c.Next()
+	}
+}
+
+func (a *BasicAuthenticator) reject(c *gin.Context) {
+	c.Header("WWW-Authenticate", a.challenge)
+	c.AbortWithStatus(http.StatusUnauthorized)
+}
+
+// dummyPasswordHash is verified for unknown users. It is the bcrypt hash of a random password.
+const dummyPasswordHash = "$2a$10$fJGsu2g2LS3VnMGDYyEaJ.vh23GGUhXB/ocjq45CQ5oIOdqnozcT6"
+
+// verifyPassword checks the password against a hash in one of the formats written by htpasswd.
+func verifyPassword(hash, password string) bool {
+	switch {
+	case strings.HasPrefix(hash, "$2a$"), strings.HasPrefix(hash, "$2b$"), strings.HasPrefix(hash, "$2y$"):
+		return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+	case strings.HasPrefix(hash, "$apr1$"):
+		salt, _, _ := strings.Cut(hash[len("$apr1$"):], "$")
+		return subtle.ConstantTimeCompare([]byte(apr1(password, salt)), []byte(hash)) == 1
+	case strings.HasPrefix(hash, "{SHA}"):
+		sum := sha1.Sum([]byte(password))
+		expected := "{SHA}" + base64.StdEncoding.EncodeToString(sum[:])
+		return subtle.ConstantTimeCompare([]byte(expected), []byte(hash)) == 1
+	}
+	return false
+}
+
+// apr1 computes the Apache variant of the MD5-based crypt(3) hash.
+func apr1(password, salt string) string {
+	const magic = "$apr1$"
+	if len(salt) > 8 {
+		salt = salt[:8]
+	}
+
+	alternate := md5.Sum([]byte(password + salt + password))
+	digest := md5.New()
+	digest.Write([]byte(password + magic + salt))
+	for i := len(password); i > 0; i -= 16 {
+		digest.Write(alternate[:min(i, 16)])
+	}
+	for i := len(password); i > 0; i >>= 1 {
+		if i&1 == 1 {
+			digest.Write([]byte{0})
+		} else {
+			digest.Write([]byte(password[:1]))
+		}
+	}
+	final := digest.Sum(nil)
+
+	for i := 0; i < 1000; i++ {
+		round := md5.New()
+		if i&1 == 1 {
+			round.Write([]byte(password))
+		} else {
+			round.Write(final)
+		}
+		if i%3 != 0 {
+			round.Write([]byte(salt))
+		}
+		if i%7 != 0 {
+			round.Write([]byte(password))
+		}
+		if i&1 == 1 {
+			round.Write(final)
+		} else {
+			round.Write([]byte(password))
+		}
+		final = round.Sum(nil)
+	}
+
+	const itoa64 = "./0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+	var encoded strings.Builder
+	encode := func(value uint, n int) {
+		for ; n > 0; n-- {
+			encoded.WriteByte(itoa64[value&0x3f])
+			value >>= 6
+		}
+	}
+	for _, group := range [][3]int{{0, 6, 12}, {1, 7, 13}, {2, 8, 14}, {3, 9, 15}, {4, 10, 5}} {
+		encode(uint(final[group[0]])<<16|uint(final[group[1]])<<8|uint(final[group[2]]), 4)
+	}
+	encode(uint(final[11]), 2)
+	return magic + salt + "$" + encoded.String()
+}
+
+// htpasswdFile holds the credentials of an htpasswd file, reloading them when the file changes.
+type htpasswdFile struct {
+	path     string
+	mu       sync.Mutex
+	modified time.Time
+	hashes   map[string]string
+}
+
+func (h *htpasswdFile) lookup(_ context.Context, username string) (string, bool, error) {
+	if err := h.reload(); err != nil {
+		// Keep serving the last valid credentials while the file is being replaced
+		log.Warn().Err(err).Str("file", h.path).Msg("Failed to reload htpasswd file")
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	hash, found := h.hashes[username]
+	return hash, found, nil
+}
+
+func (h *htpasswdFile) reload() error {
+	stat, err := os.Stat(h.path)
+	if err != nil {
+		return errors.Wrap(err, "failed to read htpasswd file")
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if stat.ModTime().Equal(h.modified) && h.hashes != nil {
+		return nil
+	}
+	hashes, err := readHtpasswd(h.path)
+	if err != nil {
+		return err
+	}
+	h.hashes, h.modified = hashes, stat.ModTime()
+	return nil
+}
+
+// readHtpasswd parses an htpasswd file, skipping blank lines and comments.
+func readHtpasswd(path string) (map[string]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read htpasswd file")
+	}
+	defer func() { _ = file.Close() }()
+
+	hashes := make(map[string]string)
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		entry := strings.TrimSpace(scanner.Text())
+		if entry == "" || strings.HasPrefix(entry, "#") {
+			continue
+		}
+		username, hash, ok := strings.Cut(entry, ":")
+		if !ok || username == "" {
+			return nil, errors.Errorf("invalid htpasswd entry at line %d", line)
+		}
+		hashes[username] = hash
+	}
+	return hashes, errors.Wrap(scanner.Err(), "failed to read htpasswd file")
+}
+
+// newTableLookup returns a lookup of the password hashes stored in a PostgreSQL table.
+func newTableLookup(c BasicAuthTableConfig, pool *pgxpool.Pool) func(context.Context, string) (string, bool, error) {
+	usernameColumn, passwordColumn := c.UsernameColumn, c.PasswordColumn
+	if usernameColumn == "" {
+		usernameColumn = "username"
+	}
+	if passwordColumn == "" {
+		passwordColumn = "password_hash"
+	}
+	query := fmt.Sprintf("SELECT %s FROM %s WHERE %s = $1",
+		pgx.Identifier{passwordColumn}.Sanitize(),
+		pgx.Identifier(strings.Split(c.Name, ".")).Sanitize(),
+		pgx.Identifier{usernameColumn}.Sanitize())
+
+	return func(ctx context.Context, username string) (string, bool, error) {
+		var hash string
+		err := pool.QueryRow(ctx, query, username).Scan(&hash)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return "", false, nil
+		}
+		if err != nil {
+			return "", false, errors.Wrap(err, "failed to query credentials")
+		}
+		return hash, true, nil
+	}
+}
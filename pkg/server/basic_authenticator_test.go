@@ -0,0 +1,109 @@
+//go:build unit
+
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var _ = Describe("BasicAuthenticator", func() {
+	var (
+		htpasswd string
+		user     string
+	)
+
+	BeforeEach(func() {
+		hash, err := bcrypt.GenerateFromPassword([]byte("bcrypt-secret"), bcrypt.MinCost)
+		Expect(err).NotTo(HaveOccurred())
+		htpasswd = filepath.Join(GinkgoT().TempDir(), "htpasswd")
+		Expect(os.WriteFile(htpasswd, []byte("# users\nalice:"+string(hash)+"\n\n"+
+			"bob:$apr1$r31..G..$3UUppPV2F0WcPRalJVo3i.\n"+
+			"carol:{SHA}5en6G6MezRroT3XKqkdPOmY/BfQ=\n"), 0o600)).To(Succeed())
+	})
+
+	serve := func(authenticator Authenticator, username, password string) *httptest.ResponseRecorder {
+		gin.SetMode(gin.TestMode)
+		user = ""
+		engine := gin.New()
+		engine.GET("/", authenticator.Middleware(), func(c *gin.Context) {
+			user, _ = GetRemoteUser(c)
+			c.Status(http.StatusOK)
+		})
+
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		if username != "" {
+			req.SetBasicAuth(username, password)
+		}
+		engine.ServeHTTP(w, req)
+		return w
+	}
+
+	It("should validate the configuration", func() {
+		Expect(BasicAuthenticatorConfig{HtpasswdFile: htpasswd}.Validate()).To(Succeed())
+		Expect(BasicAuthenticatorConfig{Table: &BasicAuthTableConfig{Name: "auth.users"}}.Validate()).To(Succeed())
+		Expect(BasicAuthenticatorConfig{}.Validate()).To(MatchError(ContainSubstring("exactly one of")))
+		Expect(BasicAuthenticatorConfig{HtpasswdFile: htpasswd, Table: &BasicAuthTableConfig{Name: "users"}}.Validate()).To(HaveOccurred())
+		Expect(BasicAuthenticatorConfig{Table: &BasicAuthTableConfig{}}.Validate()).To(MatchError(ContainSubstring("name must be set")))
+		Expect(BasicAuthenticatorConfig{HtpasswdFile: htpasswd, Realm: `a"b`}.Validate()).To(HaveOccurred())
+		Expect(BasicAuthenticatorConfig{HtpasswdFile: htpasswd + ".missing"}.Validate()).To(HaveOccurred())
+
+		Expect(os.WriteFile(htpasswd, []byte("no separator\n"), 0o600)).To(Succeed())
+		Expect(BasicAuthenticatorConfig{HtpasswdFile: htpasswd}.Validate()).To(MatchError(ContainSubstring("line 1")))
+	})
+
+	It("should require a pool for table credentials", func() {
+		_, err := NewBasicAuthenticator(BasicAuthenticatorConfig{Table: &BasicAuthTableConfig{Name: "users"}}, nil)
+		Expect(err).To(MatchError(ContainSubstring("requires a PostgreSQL pool")))
+	})
+
+	It("should accept the hash formats of htpasswd", func() {
+		authenticator, err := NewBasicAuthenticator(BasicAuthenticatorConfig{HtpasswdFile: htpasswd}, nil)
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(serve(authenticator, "alice", "bcrypt-secret").Code).To(Equal(http.StatusOK))
+		Expect(user).To(Equal("alice"))
+		Expect(serve(authenticator, "bob", "secret").Code).To(Equal(http.StatusOK))
+		Expect(user).To(Equal("bob"))
+		Expect(serve(authenticator, "carol", "secret").Code).To(Equal(http.StatusOK))
+	})
+
+	It("should challenge requests without valid credentials", func() {
+		authenticator, err := NewBasicAuthenticator(BasicAuthenticatorConfig{HtpasswdFile: htpasswd, Realm: "Intranet"}, nil)
+		Expect(err).NotTo(HaveOccurred())
+
+		for _, credentials := range [][2]string{{"", ""}, {"alice", "wrong"}, {"bob", "Secret"}, {"mallory", "secret"}} {
+			w := serve(authenticator, credentials[0], credentials[1])
+			Expect(w.Code).To(Equal(http.StatusUnauthorized), credentials[0])
+			Expect(w.Header().Get("WWW-Authenticate")).To(Equal(`Basic realm="Intranet", charset="UTF-8"`))
+			Expect(user).To(BeEmpty())
+		}
+	})
+
+	It("should reload the htpasswd file when it changes", func() {
+		authenticator, err := NewBasicAuthenticator(BasicAuthenticatorConfig{HtpasswdFile: htpasswd}, nil)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(serve(authenticator, "dave", "secret").Code).To(Equal(http.StatusUnauthorized))
+
+		Expect(os.WriteFile(htpasswd, []byte("dave:{SHA}5en6G6MezRroT3XKqkdPOmY/BfQ=\n"), 0o600)).To(Succeed())
+		later := time.Now().Add(time.Minute)
+		Expect(os.Chtimes(htpasswd, later, later)).To(Succeed())
+		Expect(serve(authenticator, "dave", "secret").Code).To(Equal(http.StatusOK))
+		Expect(serve(authenticator, "alice", "bcrypt-secret").Code).To(Equal(http.StatusUnauthorized))
+	})
+
+	It("should compute Apache MD5 hashes", func() {
+		Expect(apr1("secret", "r31..G..")).To(Equal("$apr1$r31..G..$3UUppPV2F0WcPRalJVo3i."))
+		Expect(verifyPassword("$apr1$r31..G..$3UUppPV2F0WcPRalJVo3i.", "secret")).To(BeTrue())
+		Expect(verifyPassword("plain", "plain")).To(BeFalse())
+	})
+})
@@ -0,0 +1,154 @@
+package server
+
+import (
+	"crypto/x509"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+)
+
+// ClientCertIdentityKey is the Gin context key under which ClientCertAuthenticator stores
+// the identity extracted from the verified client certificate.
+const ClientCertIdentityKey = "client_cert_identity"
+
+// ClientCertIdentity holds the identity attributes of a verified client certificate.
+type ClientCertIdentity struct {
+	Subject        string   `json:"subject"`
+	CommonName     string   `json:"common_name"`
+	DNSNames       []string `json:"dns_names,omitempty"`
+	EmailAddresses []string `json:"email_addresses,omitempty"`
+	URIs           []string `json:"uris,omitempty"`
+	SerialNumber   string   `json:"serial_number"`
+}
+
+// ClientCertAuthenticatorConfig restricts which verified client certificates are accepted.
+// Entries may end with '*' to match by prefix (e.g. "spiffe://example.org/*").
+// If both lists are empty, any certificate verified by the TLS listener is accepted.
+type ClientCertAuthenticatorConfig struct {
+	AllowedCommonNames []string `yaml:"allowed_common_names,omitempty"`
+	AllowedSANs        []string `yaml:"allowed_sans,omitempty"` // DNS names, email addresses or URIs
+}
+
+func (c ClientCertAuthenticatorConfig) Validate() error {
+	return nil
+}
+
+// ClientCertAuthenticator implements Authenticator for mutual TLS. It accepts requests whose
+// client certificate was verified by the TLS listener (see TLSConfig.ClientAuth) and matches
+// the configured common names or subject alternative names.
+//
+// The listener must be configured with client_auth "verify_if_given" or "require_and_verify",
+// otherwise certificates are not verified and every request is rejected.
+type ClientCertAuthenticator struct {
+	allowedCommonNames []string
+	allowedSANs        []string
+}
+
+// NewClientCertAuthenticator creates a new authenticator backed by verified client certificates.
+//
+// Example usage:
+//
+//	server := server.NewServer(cfg)
+//	server.SetAuthenticator(server.NewClientCertAuthenticator(server.ClientCertAuthenticatorConfig{
+//	    AllowedSANs: []string{"spiffe://example.org/*"},
+//	}))
+func NewClientCertAuthenticator(c ClientCertAuthenticatorConfig) Authenticator {
+	return &ClientCertAuthenticator{
+		allowedCommonNames: append([]string(nil), c.AllowedCommonNames...),
+		allowedSANs:        append([]string(nil), c.AllowedSANs...),
+	}
+}
+
+// Middleware returns a Gin middleware that requires a verified and allowed client certificate.
+// On success, the certificate identity is stored in the context under ClientCertIdentityKey, and
+// its user (see ClientCertIdentity.User) under RemoteUserKey.
+func (a *ClientCertAuthenticator) Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.Request.TLS == nil || len(c.Request.TLS.VerifiedChains) == 0 || len(c.Request.TLS.VerifiedChains[0]) == 0 {
+			c.AbortWithStatus(http.StatusUnauthorized)
+			return
+		}
+
+		cert := c.Request.TLS.VerifiedChains[0][0]
+		if !a.allowed(cert) {
+			log.Debug().Str("subject", cert.Subject.String()).Msg("Client certificate not allowed")
+			c.AbortWithStatus(http.StatusForbidden)
+			return
+		}
+
+		identity := newClientCertIdentity(cert)
+		c.Set(ClientCertIdentityKey, identity)
+		c.Set(RemoteUserKey, identity.User())
+		c.Next()
+	}
+}
+
+func (a *ClientCertAuthenticator) allowed(cert *x509.Certificate) bool {
+	if len(a.allowedCommonNames) == 0 && len(a.allowedSANs) == 0 {
+		return true
+	}
+	if matchesAny(a.allowedCommonNames, cert.Subject.CommonName) {
+		return true
+	}
+	sans := append(append([]string(nil), cert.DNSNames...), cert.EmailAddresses...)
+	for _, uri := range cert.URIs {
+		sans = append(sans, uri.String())
+	}
+	for _, san := range sans {
+		if matchesAny(a.allowedSANs, san) {
+			return true
+		}
+	}
+	return false
+}
+
+// User returns the user identified by the certificate: its first URI SAN, e.g. a SPIFFE ID, or
+// otherwise its common name.
+func (i *ClientCertIdentity) User() string {
+	if len(i.URIs) > 0 {
+		return i.URIs[0]
+	}
+	return i.CommonName
+}
+
+// GetClientCertIdentity returns the client certificate identity stored by ClientCertAuthenticator.
+func GetClientCertIdentity(c *gin.Context) (*ClientCertIdentity, bool) {
+	value, exists := c.Get(ClientCertIdentityKey)
+	if !exists {
+		return nil, false
+	}
+	identity, ok := value.(*ClientCertIdentity)
+	return identity, ok
+}
+
+func newClientCertIdentity(cert *x509.Certificate) *ClientCertIdentity {
+	identity := &ClientCertIdentity{
+		Subject:        cert.Subject.String(),
+		CommonName:     cert.Subject.CommonName,
+		DNSNames:       cert.DNSNames,
+		EmailAddresses: cert.EmailAddresses,
+		SerialNumber:   cert.SerialNumber.String(),
+	}
+	for _, uri := range cert.URIs {
+		identity.URIs = append(identity.URIs, uri.String())
+	}
+	return identity
+}
+
+func matchesAny(patterns []string, value string) bool {
+	if value == "" {
+		return false
+	}
+	for _, pattern := range patterns {
+		if prefix, ok := strings.CutSuffix(pattern, "*"); ok {
+			if strings.HasPrefix(value, prefix) {
+				return true
+			}
+		} else if pattern == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,93 @@
+//go:build unit
+
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/gin-gonic/gin"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("ClientCertAuthenticator", func() {
+	var (
+		identity *ClientCertIdentity
+		userId   string
+	)
+
+	serve := func(authenticator Authenticator, cert *x509.Certificate) int {
+		gin.SetMode(gin.TestMode)
+		identity, userId = nil, ""
+		engine := gin.New()
+		engine.GET("/", authenticator.Middleware(), func(c *gin.Context) {
+			identity, _ = GetClientCertIdentity(c)
+			userId = UserId(c)
+			c.Status(http.StatusOK)
+		})
+
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		if cert != nil {
+			req.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{cert}}}
+		}
+		engine.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	It("should reject requests without a verified certificate", func() {
+		Expect(serve(NewClientCertAuthenticator(ClientCertAuthenticatorConfig{}), nil)).To(Equal(http.StatusUnauthorized))
+	})
+
+	It("should accept any verified certificate when no restrictions are configured", func() {
+		cert, _, _ := testCertificate("machine-1", []string{"machine-1.internal"})
+		Expect(serve(NewClientCertAuthenticator(ClientCertAuthenticatorConfig{}), cert)).To(Equal(http.StatusOK))
+		Expect(identity).NotTo(BeNil())
+		Expect(identity.CommonName).To(Equal("machine-1"))
+		Expect(identity.DNSNames).To(ConsistOf("machine-1.internal"))
+		Expect(identity.SerialNumber).NotTo(BeEmpty())
+	})
+
+	It("should match allowed common names", func() {
+		authenticator := NewClientCertAuthenticator(ClientCertAuthenticatorConfig{AllowedCommonNames: []string{"billing"}})
+		allowed, _, _ := testCertificate("billing", nil)
+		denied, _, _ := testCertificate("reporting", nil)
+		Expect(serve(authenticator, allowed)).To(Equal(http.StatusOK))
+		Expect(serve(authenticator, denied)).To(Equal(http.StatusForbidden))
+		Expect(identity).To(BeNil())
+	})
+
+	It("should match allowed SANs with prefix wildcards", func() {
+		authenticator := NewClientCertAuthenticator(ClientCertAuthenticatorConfig{
+			AllowedSANs: []string{"spiffe://example.org/*", "worker.internal"},
+		})
+		uriCert, _, _ := testCertificate("svc", nil, "spiffe://example.org/ns/default/sa/api")
+		dnsCert, _, _ := testCertificate("svc", []string{"worker.internal"})
+		otherCert, _, _ := testCertificate("svc", []string{"other.internal"}, "spiffe://other.org/api")
+
+		Expect(serve(authenticator, uriCert)).To(Equal(http.StatusOK))
+		Expect(identity.URIs).To(ConsistOf("spiffe://example.org/ns/default/sa/api"))
+		Expect(serve(authenticator, dnsCert)).To(Equal(http.StatusOK))
+		Expect(serve(authenticator, otherCert)).To(Equal(http.StatusForbidden))
+	})
+
+	It("should identify the user by the first URI SAN or else the common name", func() {
+		authenticator := NewClientCertAuthenticator(ClientCertAuthenticatorConfig{})
+		uriCert, _, _ := testCertificate("svc", []string{"svc.internal"}, "spiffe://example.org/ns/default/sa/api")
+		cnCert, _, _ := testCertificate("billing", []string{"billing.internal"})
+
+		Expect(serve(authenticator, uriCert)).To(Equal(http.StatusOK))
+		Expect(userId).To(Equal("spiffe://example.org/ns/default/sa/api"))
+		Expect(serve(authenticator, cnCert)).To(Equal(http.StatusOK))
+		Expect(userId).To(Equal("billing"))
+	})
+
+	It("should return no identity when the authenticator did not run", func() {
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		_, ok := GetClientCertIdentity(c)
+		Expect(ok).To(BeFalse())
+	})
+})
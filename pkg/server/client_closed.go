@@ -0,0 +1,46 @@
+package server
+
+import (
+	"context"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// StatusClientClosedRequest is the status recorded in the logs and metrics of the requests whose
+// client disconnected before a response was written, as nginx does. It is never sent to clients.
+const StatusClientClosedRequest = 499
+
+var clientClosedRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "sargantana_client_closed_requests_total",
+	Help: "Number of requests whose client disconnected before the response, by controller binding.",
+}, []string{"controller", "type"})
+
+// ResponseStatus returns the status of the response of the request, or StatusClientClosedRequest
+// if its client disconnected before a response was written. Middlewares logging or measuring the
+// responses read the status with it, so that cancellations by the users are not reported as
+// successes nor upstream errors.
+func ResponseStatus(c *gin.Context) int {
+	if !c.Writer.Written() && ClientClosed(c) {
+		// Only records the status, the connection of the gone client is closed
+		c.Status(StatusClientClosedRequest)
+	}
+	return c.Writer.Status()
+}
+
+// ClientClosed tells whether the client of the request disconnected. Handlers failing because their
+// client is gone should abort without logging errors nor writing a response.
+func ClientClosed(c *gin.Context) bool {
+	return errors.Is(c.Request.Context().Err(), context.Canceled)
+}
+
+// clientClosedMiddleware records the status of the requests of gone clients before the request log
+// and counts them. It must be installed right after the request logger.
+func clientClosedMiddleware(c *gin.Context) {
+	c.Next()
+	if ResponseStatus(c) == StatusClientClosedRequest {
+		clientClosedRequests.WithLabelValues(ControllerName(c), ControllerType(c)).Inc()
+	}
+}
@@ -0,0 +1,67 @@
+//go:build unit
+
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/gin-gonic/gin"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+var _ = Describe("Client closed requests", func() {
+	var (
+		engine   *gin.Engine
+		statuses chan int
+	)
+
+	BeforeEach(func() {
+		statuses = make(chan int, 1)
+		engine = gin.New()
+		engine.Use(func(c *gin.Context) {
+			c.Next()
+			statuses <- c.Writer.Status()
+		}, clientClosedMiddleware)
+		engine.GET("/aborted", func(c *gin.Context) {
+			if ClientClosed(c) {
+				c.Abort()
+				return
+			}
+			c.String(http.StatusOK, "ok")
+		})
+		engine.GET("/answered", func(c *gin.Context) {
+			c.String(http.StatusBadGateway, "upstream failed")
+		})
+	})
+
+	// serve sends a request whose client already disconnected if closed
+	serve := func(path string, closed bool) {
+		ctx, cancel := context.WithCancel(context.Background())
+		if closed {
+			cancel()
+		} else {
+			defer cancel()
+		}
+		engine.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil).WithContext(ctx))
+	}
+
+	It("should record the requests of gone clients with the 499 status", func() {
+		before := testutil.ToFloat64(clientClosedRequests.WithLabelValues("", ""))
+		serve("/aborted", true)
+		Expect(statuses).To(Receive(Equal(StatusClientClosedRequest)))
+		Expect(testutil.ToFloat64(clientClosedRequests.WithLabelValues("", ""))).To(Equal(before + 1))
+	})
+
+	It("should keep the status of the responses written", func() {
+		before := testutil.ToFloat64(clientClosedRequests.WithLabelValues("", ""))
+		serve("/aborted", false)
+		Expect(statuses).To(Receive(Equal(http.StatusOK)))
+		serve("/answered", true)
+		Expect(statuses).To(Receive(Equal(http.StatusBadGateway)))
+		Expect(testutil.ToFloat64(clientClosedRequests.WithLabelValues("", ""))).To(Equal(before))
+	})
+})
@@ -0,0 +1,412 @@
+package server
+
+import (
+	"compress/gzip"
+	"io"
+	"mime"
+	"net/http"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/klauspost/compress/zstd"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	defaultCompressionMinSize = 1024
+	compressionCPUInterval    = time.Second
+	// zstdWindowSize is the largest window HTTP clients must support, see RFC 9659
+	zstdWindowSize = 8 << 20
+)
+
+var (
+	defaultCompressionEncodings = []string{"zstd", "gzip"}
+	defaultCompressibleTypes    = []string{
+		"text/html", "text/css", "text/plain", "text/javascript", "text/xml", "text/csv", "text/markdown",
+		"text/event-stream", "application/javascript", "application/json", "application/problem+json",
+		"application/ld+json", "application/xml", "application/xhtml+xml", "application/rss+xml",
+		"application/atom+xml", "application/wasm", "image/svg+xml",
+	}
+	compressionLevels = map[string]struct {
+		gzip int
+		zstd zstd.EncoderLevel
+	}{
+		"fastest": {gzip.BestSpeed, zstd.SpeedFastest},
+		"default": {gzip.DefaultCompression, zstd.SpeedDefault},
+		"best":    {gzip.BestCompression, zstd.SpeedBestCompression},
+	}
+)
+
+// CompressionConfig configures the compression middleware type, which compresses the responses of a
+// controller binding with the zstd or gzip content coding accepted by the client. Responses that
+// already have a Content-Encoding, e.g. compressed by an upstream, are sent unchanged.
+type CompressionConfig struct {
+	// Encodings are the offered content codings, zstd and gzip, in order of preference among those
+	// accepted with the same quality. Defaults to zstd, then gzip.
+	Encodings []string `yaml:"encodings,omitempty"`
+	// Level is fastest, default or best. Defaults to default.
+	Level string `yaml:"level,omitempty"`
+	// MinSize is the size in bytes of the smallest response compressed. Defaults to 1 KiB.
+	MinSize int64 `yaml:"min_size,omitempty"`
+	// ContentTypes are the media types compressed. Defaults to the common text types.
+	ContentTypes []string `yaml:"content_types,omitempty"`
+	// RecompressUpstream drops the Accept-Encoding header of the requests, so that load balancers
+	// receive the responses of the upstreams uncompressed, and compresses them with the coding
+	// negotiated with the client, e.g. zstd for upstreams only supporting gzip.
+	RecompressUpstream bool `yaml:"recompress_upstream,omitempty"`
+	// MaxConcurrent is the number of responses compressed at once; the others are sent
+	// uncompressed. Unlimited by default.
+	MaxConcurrent int `yaml:"max_concurrent,omitempty"`
+	// MaxCPU is the CPU usage of the process, as a fraction of GOMAXPROCS, e.g. 0.8, above which
+	// responses are sent uncompressed. Unlimited by default.
+	MaxCPU float64 `yaml:"max_cpu,omitempty"`
+}
+
+func (c CompressionConfig) Validate() error {
+	for _, encoding := range c.Encodings {
+		if encoding != "zstd" && encoding != "gzip" {
+			return errors.Errorf("unsupported encoding %q, expected zstd or gzip", encoding)
+		}
+	}
+	if _, ok := compressionLevels[c.Level]; c.Level != "" && !ok {
+		return errors.Errorf("invalid level %q, expected fastest, default or best", c.Level)
+	}
+	if c.MinSize < 0 {
+		return errors.New("min_size must not be negative")
+	}
+	if c.MaxConcurrent < 0 {
+		return errors.New("max_concurrent must not be negative")
+	}
+	if c.MaxCPU < 0 || c.MaxCPU > 1 {
+		return errors.New("max_cpu must be between 0 and 1")
+	}
+	return nil
+}
+
+// NewCompressionMiddleware creates the middleware of the compression middleware type.
+func NewCompressionMiddleware(c CompressionConfig) (gin.HandlerFunc, error) {
+	compressor := &compression{
+		encodings:    c.Encodings,
+		contentTypes: c.ContentTypes,
+		minSize:      c.MinSize,
+		recompress:   c.RecompressUpstream,
+		encoders:     make(map[string]*sync.Pool),
+	}
+	if len(compressor.encodings) == 0 {
+		compressor.encodings = defaultCompressionEncodings
+	}
+	if len(compressor.contentTypes) == 0 {
+		compressor.contentTypes = defaultCompressibleTypes
+	}
+	if compressor.minSize == 0 {
+		compressor.minSize = defaultCompressionMinSize
+	}
+	if c.MaxConcurrent > 0 {
+		compressor.slots = make(chan struct{}, c.MaxConcurrent)
+	}
+	if c.MaxCPU > 0 {
+		compressor.cpu = &cpuBudget{max: c.MaxCPU, cpuTime: processCPUTime, lastSample: time.Now()}
+		compressor.cpu.lastCPU = compressor.cpu.cpuTime()
+	}
+
+	level := c.Level
+	if level == "" {
+		level = "default"
+	}
+	levels := compressionLevels[level]
+	zstdOptions := []zstd.EOption{
+		zstd.WithEncoderLevel(levels.zstd),
+		zstd.WithWindowSize(zstdWindowSize),
+		// One goroutine per response, to keep the CPU of a response bounded
+		zstd.WithEncoderConcurrency(1),
+	}
+	first, err := zstd.NewWriter(nil, zstdOptions...)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create zstd encoder")
+	}
+	compressor.encoders["zstd"] = &sync.Pool{New: func() any {
+		encoder, _ := zstd.NewWriter(nil, zstdOptions...) // The options are those of the first
+		return encoder
+	}}
+	compressor.encoders["zstd"].Put(first)
+	compressor.encoders["gzip"] = &sync.Pool{New: func() any {
+		encoder, _ := gzip.NewWriterLevel(io.Discard, levels.gzip) // Levels are valid
+		return encoder
+	}}
+	return compressor.middleware, nil
+}
+
+// encoder is a pooled writer of a content coding
+type encoder interface {
+	io.WriteCloser
+	Flush() error
+	Reset(w io.Writer)
+}
+
+type compression struct {
+	encodings    []string
+	contentTypes []string
+	minSize      int64
+	recompress   bool
+	encoders     map[string]*sync.Pool
+	slots        chan struct{} // Nil without max_concurrent
+	cpu          *cpuBudget    // Nil without max_cpu
+}
+
+func (z *compression) middleware(c *gin.Context) {
+	if c.Request.Method == http.MethodHead {
+		c.Next()
+		return
+	}
+	encoding := negotiateEncoding(c.Request.Header.Values("Accept-Encoding"), z.encodings)
+	if z.recompress {
+		c.Request.Header.Del("Accept-Encoding")
+	}
+	if encoding == "" {
+		c.Next()
+		return
+	}
+	writer := &compressionWriter{ResponseWriter: c.Writer, compression: z, encoding: encoding}
+	c.Writer = writer
+	defer func() {
+		c.Writer = writer.ResponseWriter
+	}()
+	c.Next()
+	writer.finish()
+}
+
+// compressible tells whether responses with a Content-Type are compressed
+func (z *compression) compressible(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	return err == nil && slices.Contains(z.contentTypes, mediaType)
+}
+
+// acquire reserves the CPU to compress a response, returning false when the budget is exhausted
+func (z *compression) acquire() bool {
+	if z.cpu.exceeded() {
+		return false
+	}
+	if z.slots == nil {
+		return true
+	}
+	select {
+	case z.slots <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+func (z *compression) release() {
+	if z.slots != nil {
+		<-z.slots
+	}
+}
+
+// negotiateEncoding returns the offered content coding with the highest quality in Accept-Encoding
+// header values, preferring the first offered ones on ties, or an empty string if none is accepted
+func negotiateEncoding(values []string, offered []string) string {
+	accepted := make(map[string]float64)
+	for _, value := range values {
+		for _, item := range strings.Split(value, ",") {
+			name, params, _ := strings.Cut(item, ";")
+			quality := 1.0
+			for _, param := range strings.Split(params, ";") {
+				key, v, ok := strings.Cut(strings.TrimSpace(param), "=")
+				if ok && strings.EqualFold(key, "q") {
+					if q, err := strconv.ParseFloat(v, 64); err == nil {
+						quality = q
+					}
+				}
+			}
+			if name = strings.ToLower(strings.TrimSpace(name)); name != "" {
+				accepted[name] = quality
+			}
+		}
+	}
+	best, bestQuality := "", 0.0
+	for _, encoding := range offered {
+		quality, ok := accepted[encoding]
+		if !ok {
+			quality = accepted["*"]
+		}
+		if quality > bestQuality {
+			best, bestQuality = encoding, quality
+		}
+	}
+	return best
+}
+
+// cpuBudget samples the CPU usage of the process at most once per interval
+type cpuBudget struct {
+	max        float64
+	cpuTime    func() time.Duration
+	mu         sync.Mutex
+	lastCPU    time.Duration
+	lastSample time.Time
+	usage      float64
+}
+
+func (b *cpuBudget) exceeded() bool {
+	if b == nil {
+		return false
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if elapsed := time.Since(b.lastSample); elapsed >= compressionCPUInterval {
+		cpuTime := b.cpuTime()
+		b.usage = float64(cpuTime-b.lastCPU) / float64(elapsed) / float64(runtime.GOMAXPROCS(0))
+		b.lastCPU, b.lastSample = cpuTime, time.Now()
+	}
+	return b.usage > b.max
+}
+
+const (
+	compressionUndecided = iota
+	compressionPending   // Buffering until the response reaches min_size
+	compressionOff
+	compressionOn
+)
+
+// compressionWriter compresses the compressible responses, and passes the others through
+type compressionWriter struct {
+	gin.ResponseWriter
+	compression *compression
+	encoding    string
+	state       int
+	buffer      []byte
+	encoder     encoder
+}
+
+// decide compresses the responses of compressible types with a body and without a content coding,
+// waiting for min_size bytes when their length is unknown
+func (w *compressionWriter) decide() {
+	if w.state != compressionUndecided {
+		return
+	}
+	w.state = compressionOff
+	header := w.Header()
+	status := w.Status()
+	if header.Get("Content-Encoding") != "" || !w.compression.compressible(header.Get("Content-Type")) ||
+		status < http.StatusOK || status == http.StatusNoContent || status == http.StatusPartialContent ||
+		status == http.StatusNotModified {
+		return
+	}
+	header.Add("Vary", "Accept-Encoding")
+	if length := header.Get("Content-Length"); length != "" {
+		if size, err := strconv.ParseInt(length, 10, 64); err == nil && size < w.compression.minSize {
+			return
+		}
+		w.start()
+		return
+	}
+	w.state = compressionPending
+}
+
+// start compresses the response if the CPU budget allows it, writing the buffered bytes
+func (w *compressionWriter) start() {
+	buffered := w.buffer
+	w.buffer = nil
+	if !w.compression.acquire() {
+		w.state = compressionOff
+		w.writeRaw(buffered)
+		return
+	}
+	w.state = compressionOn
+	header := w.Header()
+	header.Set("Content-Encoding", w.encoding)
+	header.Del("Content-Length")
+	header.Del("Accept-Ranges")
+	if etag := header.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+		// The compressed representation is no longer byte for byte the one of the strong ETag
+		header.Set("ETag", "W/"+etag)
+	}
+	w.encoder = w.compression.encoders[w.encoding].Get().(encoder)
+	w.encoder.Reset(w.ResponseWriter)
+	if len(buffered) > 0 {
+		if _, err := w.encoder.Write(buffered); err != nil {
+			log.Debug().Err(err).Msg("Failed to write compressed response")
+		}
+	}
+}
+
+// writeRaw sends the header and bytes unchanged
+func (w *compressionWriter) writeRaw(b []byte) {
+	if len(b) == 0 {
+		w.ResponseWriter.WriteHeaderNow()
+		return
+	}
+	if _, err := w.ResponseWriter.Write(b); err != nil {
+		log.Debug().Err(err).Msg("Failed to write response")
+	}
+}
+
+func (w *compressionWriter) WriteHeaderNow() {
+	w.decide()
+	if w.state != compressionPending {
+		w.ResponseWriter.WriteHeaderNow()
+	}
+}
+
+func (w *compressionWriter) Write(b []byte) (int, error) {
+	w.decide()
+	switch w.state {
+	case compressionOn:
+		return w.encoder.Write(b)
+	case compressionPending:
+		w.buffer = append(w.buffer, b...)
+		if int64(len(w.buffer)) >= w.compression.minSize {
+			w.start()
+		}
+		return len(b), nil
+	default:
+		return w.ResponseWriter.Write(b)
+	}
+}
+
+func (w *compressionWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+// Flush compresses the buffered response, as streamed responses cannot wait for min_size bytes
+func (w *compressionWriter) Flush() {
+	w.decide()
+	if w.state == compressionPending {
+		w.start()
+	}
+	if w.state == compressionOn {
+		if err := w.encoder.Flush(); err != nil {
+			log.Debug().Err(err).Msg("Failed to flush compressed response")
+		}
+	}
+	w.ResponseWriter.Flush()
+}
+
+func (w *compressionWriter) Written() bool {
+	return len(w.buffer) > 0 || w.ResponseWriter.Written()
+}
+
+// finish sends the responses smaller than min_size unchanged, and ends the compressed ones
+func (w *compressionWriter) finish() {
+	switch w.state {
+	case compressionPending:
+		w.state = compressionOff
+		w.writeRaw(w.buffer)
+		w.buffer = nil
+	case compressionOn:
+		w.state = compressionOff
+		if err := w.encoder.Close(); err != nil {
+			log.Debug().Err(err).Msg("Failed to end compressed response")
+		}
+		w.encoder.Reset(io.Discard) // Do not keep the response writer alive in the pool
+		w.compression.encoders[w.encoding].Put(w.encoder)
+		w.encoder = nil
+		w.compression.release()
+	}
+}
@@ -0,0 +1,208 @@
+//go:build unit
+
+package server
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/klauspost/compress/zstd"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Compression", func() {
+	Context("CompressionConfig Validate", func() {
+		It("should accept the defaults and valid settings", func() {
+			Expect(CompressionConfig{}.Validate()).To(Succeed())
+			Expect(CompressionConfig{Encodings: []string{"gzip"}, Level: "best", MaxConcurrent: 4, MaxCPU: 0.8}.Validate()).To(Succeed())
+		})
+
+		It("should reject invalid settings", func() {
+			Expect(CompressionConfig{Encodings: []string{"br"}}.Validate()).To(MatchError(ContainSubstring("unsupported encoding")))
+			Expect(CompressionConfig{Level: "max"}.Validate()).To(MatchError(ContainSubstring("invalid level")))
+			Expect(CompressionConfig{MinSize: -1}.Validate()).To(MatchError(ContainSubstring("min_size")))
+			Expect(CompressionConfig{MaxConcurrent: -1}.Validate()).To(MatchError(ContainSubstring("max_concurrent")))
+			Expect(CompressionConfig{MaxCPU: 1.5}.Validate()).To(MatchError(ContainSubstring("max_cpu")))
+		})
+	})
+
+	It("should negotiate the accepted encoding of highest quality", func() {
+		offered := []string{"zstd", "gzip"}
+		Expect(negotiateEncoding([]string{"gzip, deflate, br, zstd"}, offered)).To(Equal("zstd"))
+		Expect(negotiateEncoding([]string{"gzip;q=1.0, zstd;q=0.5"}, offered)).To(Equal("gzip"))
+		Expect(negotiateEncoding([]string{"deflate", "GZIP"}, offered)).To(Equal("gzip"))
+		Expect(negotiateEncoding([]string{"*"}, offered)).To(Equal("zstd"))
+		Expect(negotiateEncoding([]string{"*;q=0.5, zstd;q=0"}, offered)).To(Equal("gzip"))
+		Expect(negotiateEncoding([]string{"br"}, offered)).To(BeEmpty())
+		Expect(negotiateEncoding(nil, offered)).To(BeEmpty())
+	})
+
+	Context("Middleware", func() {
+		var (
+			config         CompressionConfig
+			acceptEncoding string
+			body           string
+			header         http.Header
+			upstreamAccept string
+		)
+
+		BeforeEach(func() {
+			config = CompressionConfig{}
+			acceptEncoding = "gzip, zstd"
+			body = strings.Repeat(`{"name":"sargantana"}`, 100)
+			header = http.Header{"Content-Type": {"application/json"}}
+		})
+
+		serve := func() *httptest.ResponseRecorder {
+			middleware, err := NewCompressionMiddleware(config)
+			Expect(err).NotTo(HaveOccurred())
+			engine := gin.New()
+			engine.Use(middleware)
+			engine.GET("/data", func(c *gin.Context) {
+				upstreamAccept = c.GetHeader("Accept-Encoding")
+				for name, values := range header {
+					c.Writer.Header()[name] = values
+				}
+				// Written in parts as proxied responses are
+				c.Status(http.StatusOK)
+				half := len(body) / 2
+				_, _ = c.Writer.WriteString(body[:half])
+				_, _ = c.Writer.Write([]byte(body[half:]))
+			})
+			recorder := httptest.NewRecorder()
+			request := httptest.NewRequest(http.MethodGet, "/data", nil)
+			if acceptEncoding != "" {
+				request.Header.Set("Accept-Encoding", acceptEncoding)
+			}
+			engine.ServeHTTP(recorder, request)
+			return recorder
+		}
+
+		decode := func(recorder *httptest.ResponseRecorder) string {
+			var reader io.Reader
+			switch recorder.Header().Get("Content-Encoding") {
+			case "zstd":
+				decoder, err := zstd.NewReader(recorder.Body)
+				Expect(err).NotTo(HaveOccurred())
+				defer decoder.Close()
+				reader = decoder
+			case "gzip":
+				decoder, err := gzip.NewReader(recorder.Body)
+				Expect(err).NotTo(HaveOccurred())
+				reader = decoder
+			default:
+				reader = recorder.Body
+			}
+			data, err := io.ReadAll(reader)
+			Expect(err).NotTo(HaveOccurred())
+			return string(data)
+		}
+
+		It("should compress with the preferred encoding", func() {
+			recorder := serve()
+			Expect(recorder.Code).To(Equal(http.StatusOK))
+			Expect(recorder.Header().Get("Content-Encoding")).To(Equal("zstd"))
+			Expect(recorder.Header().Get("Vary")).To(Equal("Accept-Encoding"))
+			Expect(decode(recorder)).To(Equal(body))
+			Expect(upstreamAccept).To(Equal("gzip, zstd"))
+		})
+
+		It("should compress with gzip", func() {
+			acceptEncoding = "gzip"
+			header.Set("Content-Length", "2100")
+			header.Set("ETag", `"v1"`)
+			recorder := serve()
+			Expect(recorder.Header().Get("Content-Encoding")).To(Equal("gzip"))
+			Expect(recorder.Header().Get("Content-Length")).To(BeEmpty())
+			Expect(recorder.Header().Get("ETag")).To(Equal(`W/"v1"`))
+			Expect(decode(recorder)).To(Equal(body))
+		})
+
+		It("should send small responses unchanged", func() {
+			body = `{"name":"sargantana"}`
+			recorder := serve()
+			Expect(recorder.Header().Get("Content-Encoding")).To(BeEmpty())
+			Expect(recorder.Header().Get("Vary")).To(Equal("Accept-Encoding"))
+			Expect(recorder.Body.String()).To(Equal(body))
+		})
+
+		It("should send responses of other types unchanged", func() {
+			header.Set("Content-Type", "image/png")
+			recorder := serve()
+			Expect(recorder.Header().Get("Content-Encoding")).To(BeEmpty())
+			Expect(recorder.Header().Get("Vary")).To(BeEmpty())
+			Expect(recorder.Body.String()).To(Equal(body))
+		})
+
+		It("should send responses already compressed unchanged", func() {
+			header.Set("Content-Encoding", "br")
+			recorder := serve()
+			Expect(recorder.Header().Get("Content-Encoding")).To(Equal("br"))
+			Expect(recorder.Body.String()).To(Equal(body))
+		})
+
+		It("should not compress for clients without an offered encoding", func() {
+			acceptEncoding = "br"
+			recorder := serve()
+			Expect(recorder.Header().Get("Content-Encoding")).To(BeEmpty())
+			Expect(recorder.Body.String()).To(Equal(body))
+			Expect(upstreamAccept).To(Equal("br"))
+		})
+
+		It("should request uncompressed upstream responses to recompress them", func() {
+			config.RecompressUpstream = true
+			config.Encodings = []string{"gzip"}
+			recorder := serve()
+			Expect(upstreamAccept).To(BeEmpty())
+			Expect(recorder.Header().Get("Content-Encoding")).To(Equal("gzip"))
+			Expect(decode(recorder)).To(Equal(body))
+		})
+
+		It("should compress flushed responses before min_size", func() {
+			middleware, err := NewCompressionMiddleware(config)
+			Expect(err).NotTo(HaveOccurred())
+			engine := gin.New()
+			engine.Use(middleware)
+			engine.GET("/events", func(c *gin.Context) {
+				c.Header("Content-Type", "text/event-stream")
+				_, _ = c.Writer.WriteString("data: 1\n\n")
+				c.Writer.Flush()
+				_, _ = c.Writer.WriteString("data: 2\n\n")
+			})
+			recorder := httptest.NewRecorder()
+			request := httptest.NewRequest(http.MethodGet, "/events", nil)
+			request.Header.Set("Accept-Encoding", "zstd")
+			engine.ServeHTTP(recorder, request)
+			Expect(recorder.Flushed).To(BeTrue())
+			Expect(decode(recorder)).To(Equal("data: 1\n\ndata: 2\n\n"))
+		})
+	})
+
+	Context("CPU budget", func() {
+		It("should limit the responses compressed at once", func() {
+			z := &compression{slots: make(chan struct{}, 1)}
+			Expect(z.acquire()).To(BeTrue())
+			Expect(z.acquire()).To(BeFalse())
+			z.release()
+			Expect(z.acquire()).To(BeTrue())
+		})
+
+		It("should stop compressing while the CPU usage is exceeded", func() {
+			cpuTime := time.Duration(0)
+			budget := &cpuBudget{max: 0.5, cpuTime: func() time.Duration { return cpuTime }, lastSample: time.Now().Add(-2 * time.Second)}
+			z := &compression{cpu: budget}
+			cpuTime = 2 * time.Second * time.Duration(runtime.GOMAXPROCS(0))
+			Expect(z.acquire()).To(BeFalse())
+
+			budget.lastSample = time.Now().Add(-2 * time.Second)
+			Expect(z.acquire()).To(BeTrue())
+		})
+	})
+})
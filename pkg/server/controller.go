@@ -1,10 +1,15 @@
 package server
 
 import (
+	"fmt"
+	"slices"
+	"strings"
+
 	"github.com/animalet/sargantana-go/pkg/config"
 	"github.com/gin-contrib/sessions"
 	"github.com/gin-gonic/gin"
 	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
 )
 
 // IController defines the interface that all controllers must implement.
@@ -29,6 +34,18 @@ type IController interface {
 	Close() error
 }
 
+// GroupController is implemented by controllers that can register their routes on a router group
+// instead of the engine. The server binds them with BindGroup, on a group with the base path, the
+// host restriction and the middlewares of their binding already applied, so that the same
+// controller type can be mounted under different prefixes. Bind is still used by code binding
+// controllers on an engine directly, and usually delegates to BindGroup with &engine.RouterGroup.
+type GroupController interface {
+	IController
+
+	// BindGroup registers the controller's routes relative to the router group, see Bind.
+	BindGroup(group *gin.RouterGroup, loginMiddleware gin.HandlerFunc) error
+}
+
 // ControllerContext provides runtime dependencies and configuration to controllers
 // during instantiation. This separates pure YAML configuration (WebServerConfig) from
 // runtime dependencies like session stores, databases, or loggers.
@@ -43,8 +60,28 @@ type ControllerContext struct {
 	// Used by authentication controllers to configure gothic.Store
 	SessionStore sessions.Store
 
+	// Reputation is the IP reputation store consulted by the IP filtering middleware.
+	// Controllers detecting abusive clients can report penalties to it. Nil if disabled.
+	Reputation ReputationStore
+
+	// Identity is the SPIFFE workload identity of the server, used for upstream mTLS.
+	// Nil if SPIFFE is not configured.
+	Identity *WorkloadIdentity
+
+	// Name is the instance name of the controller being created, as configured in the
+	// controller binding or generated from its type. Used to scope request loggers, see Handler.
+	Name string
+
+	// BasePath is the base path of the controller binding, below which the routes of controllers
+	// implementing GroupController are served. Used by controllers building absolute URLs of
+	// their routes, e.g. OAuth2 callbacks.
+	BasePath string
+
+	// login is the login middleware of the controller binding, see OptionalAuth, RequireRole and
+	// RequireScope.
+	login gin.HandlerFunc
+
 	// Future additions can include:
-	// Logger       *zerolog.Logger
 	// Metrics      MetricsCollector
 	// Database     *sql.DB
 }
@@ -58,10 +95,104 @@ type ControllerBinding struct {
 	Config   config.ModuleRawConfig `yaml:"config"`
 	TypeName string                 `yaml:"type"`
 	Name     string                 `yaml:"name,omitempty"`
+	// Authenticator is the name of the authenticator whose middleware is handed to the
+	// controller, see WebServerConfig.Authenticators. Defaults to the server authenticator.
+	Authenticator string `yaml:"authenticator,omitempty"`
+	// BasePath, Hosts and Middlewares configure the router group of controllers implementing
+	// GroupController, which all built-in controllers registering routes do. BasePath prefixes
+	// the routes of the controller, so that several instances of a controller type can be mounted
+	// under different paths, Hosts restricts them to requests for the given hosts, optionally
+	// with a leading "*." wildcard, and Middlewares are applied to them in order, see
+	// RegisterMiddleware.
+	BasePath    string             `yaml:"base_path,omitempty"`
+	Hosts       []string           `yaml:"hosts,omitempty"`
+	Middlewares []MiddlewareConfig `yaml:"middlewares,omitempty"`
+	// Priority is the priority of the requests to the routes of the controller, unless a rule of
+	// the server priorities matches them, see PrioritiesConfig.
+	Priority Priority `yaml:"priority,omitempty"`
 }
 
 type ControllerBindings []ControllerBinding
 
+// ControllerEnvPrefix prefixes the environment variables overriding controller configuration
+// values, see ControllerBindings.WithEnvOverrides.
+const ControllerEnvPrefix = "SARGANTANA_CONTROLLERS_"
+
+// instanceNames returns the names of the controller instances: the binding name if set, else the
+// type name, suffixed with "-<n>" from the second instance of a type on.
+func (c ControllerBindings) instanceNames() []string {
+	names := make([]string, len(c))
+	instanceCounts := make(map[string]int) // Track instances per type for auto-naming
+	for i, binding := range c {
+		names[i] = binding.Name
+		if names[i] != "" {
+			continue
+		}
+		instanceCounts[binding.TypeName]++
+		if count := instanceCounts[binding.TypeName]; count == 1 {
+			names[i] = binding.TypeName
+		} else {
+			names[i] = fmt.Sprintf("%s-%d", binding.TypeName, count)
+		}
+	}
+	return names
+}
+
+// WithEnvOverrides returns a copy of the bindings with the controller configuration values
+// overridden by the environment variables named SARGANTANA_CONTROLLERS_<controller>_<path>, where
+// <controller> is the instance name, with "-" written as "_", and <path> the words of the path of
+// the value in the controller configuration, e.g. SARGANTANA_CONTROLLERS_auth_PROVIDERS_google_KEY
+// overrides providers.google.key of the "auth" controller. The path is resolved against the
+// configuration type of the controller type; see config.ModuleRawConfig.Override for the matching
+// rules. Variables naming no controller are reported as errors.
+func (c ControllerBindings) WithEnvOverrides(environ []string) (ControllerBindings, error) {
+	var variables []string
+	for _, variable := range environ {
+		if strings.HasPrefix(variable, ControllerEnvPrefix) {
+			variables = append(variables, variable)
+		}
+	}
+	if len(variables) == 0 {
+		return c, nil
+	}
+	slices.Sort(variables)
+
+	overridden := slices.Clone(c)
+	names := c.instanceNames()
+	for _, variable := range variables {
+		key, value, _ := strings.Cut(variable, "=")
+		words := strings.Split(strings.TrimPrefix(key, ControllerEnvPrefix), "_")
+		index, path := matchInstance(names, words)
+		if index < 0 {
+			return nil, errors.Errorf("environment variable %s does not name a controller, expected one of %v", key, names)
+		}
+		if len(path) == 0 {
+			return nil, errors.Errorf("environment variable %s does not name a value of controller %q", key, names[index])
+		}
+		raw, err := overridden[index].Config.Override(path, value, controllerConfigTypes[overridden[index].TypeName])
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to apply environment variable %s", key)
+		}
+		overridden[index].Config = raw
+		log.Info().Str("controller", names[index]).Str("variable", key).Msg("Controller configuration value overridden from the environment")
+	}
+	return overridden, nil
+}
+
+// matchInstance returns the index of the instance named by the longest prefix of words, and the
+// remaining words, or -1 if no instance matches.
+func matchInstance(names []string, words []string) (int, []string) {
+	for n := len(words); n > 0; n-- {
+		wanted := strings.Join(words[:n], "_")
+		for i, name := range names {
+			if strings.EqualFold(strings.ReplaceAll(name, "-", "_"), wanted) {
+				return i, words[n:]
+			}
+		}
+	}
+	return -1, nil
+}
+
 func (c ControllerBindings) Validate() error {
 	var validationErrors []error
 	for i, binding := range c {
@@ -86,5 +217,28 @@ func (c ControllerBinding) Validate() error {
 	if c.Config == nil {
 		return errors.New("controller config must be provided")
 	}
+	if c.BasePath != "" && !strings.HasPrefix(c.BasePath, "/") {
+		return errors.New("base_path must start with /")
+	}
+	for _, host := range c.Hosts {
+		if host == "" || strings.ContainsAny(host, "/:") {
+			return errors.Errorf("invalid host %q, expected a host name without scheme or port", host)
+		}
+	}
+	for i, middleware := range c.Middlewares {
+		if err := middleware.Validate(); err != nil {
+			return errors.Wrapf(err, "invalid middleware at index %d", i)
+		}
+	}
+	if c.Priority != "" {
+		if err := c.Priority.Validate(); err != nil {
+			return errors.Wrap(err, "invalid priority")
+		}
+	}
 	return nil
 }
+
+// groupOptions tells whether the binding configures the router group of the controller.
+func (c ControllerBinding) groupOptions() bool {
+	return c.BasePath != "" || len(c.Hosts) > 0 || len(c.Middlewares) > 0
+}
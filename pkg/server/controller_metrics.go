@@ -0,0 +1,91 @@
+package server
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const (
+	controllerNameKey = "sargantana.controller"
+	controllerTypeKey = "sargantana.controller_type"
+)
+
+var (
+	controllerRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "sargantana_controller_requests_total",
+		Help: "Number of requests handled by each controller binding, by method and status code.",
+	}, []string{"controller", "type", "method", "code"})
+	controllerRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "sargantana_controller_request_duration_seconds",
+		Help:    "Duration of the requests handled by each controller binding, by method.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"controller", "type", "method"})
+)
+
+// ControllerName returns the name of the controller binding handling the current request, or an
+// empty string if the route was not registered by a controller.
+func ControllerName(c *gin.Context) string {
+	return c.GetString(controllerNameKey)
+}
+
+// ControllerType returns the type of the controller binding handling the current request, or an
+// empty string if the route was not registered by a controller.
+func ControllerType(c *gin.Context) string {
+	return c.GetString(controllerTypeKey)
+}
+
+// controllerLabels identifies a controller binding in logs and metrics.
+type controllerLabels struct {
+	name     string
+	typeName string
+	priority Priority // Empty if the binding sets no priority
+}
+
+// controllerRoutes maps the routes of the engine, as "METHOD /full/path", to the controller
+// binding that registered them.
+type controllerRoutes map[string]controllerLabels
+
+// bind binds the controller, attributing the routes it registers to its binding.
+func (r controllerRoutes) bind(engine *gin.Engine, c configuredController) error {
+	known := make(map[string]bool)
+	for _, route := range engine.Routes() {
+		known[route.Method+" "+route.Path] = true
+	}
+	if err := c.bind(engine); err != nil {
+		return err
+	}
+	for _, route := range engine.Routes() {
+		if key := route.Method + " " + route.Path; !known[key] {
+			r[key] = c.labels
+		}
+	}
+	return nil
+}
+
+// middleware labels the requests to controller routes with their binding, in the context, the
+// request logger and the controller metrics, and recovers the panics of their handlers. It must be
+// installed after requestContextMiddleware.
+func (r controllerRoutes) middleware(c *gin.Context) {
+	labels, ok := r[c.Request.Method+" "+c.FullPath()]
+	if !ok {
+		c.Next()
+		return
+	}
+	c.Set(controllerNameKey, labels.name)
+	c.Set(controllerTypeKey, labels.typeName)
+	logger := RequestLogger(c).With().Str("controller", labels.name).Str("controller_type", labels.typeName).Logger()
+	c.Set(requestLoggerKey, logger)
+	c.Request = c.Request.WithContext(logger.WithContext(c.Request.Context()))
+
+	start := time.Now()
+	func() {
+		defer recoverControllerPanic(c, labels)
+		c.Next()
+	}()
+	controllerRequests.WithLabelValues(labels.name, labels.typeName, c.Request.Method, strconv.Itoa(ResponseStatus(c))).Inc()
+	controllerRequestDuration.WithLabelValues(labels.name, labels.typeName, c.Request.Method).Observe(time.Since(start).Seconds())
+}
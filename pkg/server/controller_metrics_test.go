@@ -0,0 +1,65 @@
+//go:build unit
+
+package server
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/animalet/sargantana-go/pkg/config"
+	"github.com/gin-contrib/sessions/cookie"
+	"github.com/gin-gonic/gin"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+var _ = Describe("Controller labels", func() {
+	It("should label requests, logs and metrics with the controller binding", func() {
+		var logs bytes.Buffer
+		originalLogger := log.Logger
+		log.Logger = zerolog.New(&logs)
+		DeferCleanup(func() { log.Logger = originalLogger })
+
+		addControllerType("labeled-controller", func(raw config.ModuleRawConfig, ctx ControllerContext) (IController, error) {
+			prefix := string(raw)
+			return &MockController{BindFunc: func(engine *gin.Engine, _ gin.HandlerFunc) {
+				engine.GET(prefix+"/:id", ctx.Handler(func(_ context.Context, logger zerolog.Logger, c *gin.Context) {
+					logger.Info().Msg("Handled")
+					c.String(http.StatusOK, ControllerName(c)+" "+ControllerType(c))
+				}))
+			}}, nil
+		})
+		s := NewServer(SargantanaConfig{
+			WebServerConfig: WebServerConfig{Address: "localhost:0", SessionName: "test-session", SessionSecret: "secret"},
+			ControllerBindings: ControllerBindings{
+				{TypeName: "labeled-controller", Config: config.ModuleRawConfig("/docs")},
+				{TypeName: "labeled-controller", Name: "app", Config: config.ModuleRawConfig("/app")},
+			},
+		})
+		s.SetSessionStore(cookie.NewStore([]byte("secret")))
+		Expect(s.bootstrap()).To(Succeed())
+		DeferCleanup(s.Shutdown)
+
+		get := func(path string) *httptest.ResponseRecorder {
+			w := httptest.NewRecorder()
+			s.httpServer.Handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+			return w
+		}
+		before := testutil.ToFloat64(controllerRequests.WithLabelValues("app", "labeled-controller", http.MethodGet, "200"))
+
+		Expect(get("/docs/1").Body.String()).To(Equal("labeled-controller labeled-controller"))
+		Expect(get("/app/1").Body.String()).To(Equal("app labeled-controller"))
+		Expect(get("/app/2").Code).To(Equal(http.StatusOK))
+		Expect(get("/missing").Code).To(Equal(http.StatusNotFound))
+
+		Expect(testutil.ToFloat64(controllerRequests.WithLabelValues("app", "labeled-controller", http.MethodGet, "200")) - before).To(Equal(2.0))
+		Expect(testutil.CollectAndCount(controllerRequestDuration, "sargantana_controller_request_duration_seconds")).To(BeNumerically(">=", 2))
+		Expect(logs.String()).To(ContainSubstring(`"controller":"app","controller_type":"labeled-controller"`))
+		Expect(bytes.Count(logs.Bytes(), []byte(`"controller":"app"`))).To(Equal(2))
+	})
+})
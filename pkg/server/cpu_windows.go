@@ -0,0 +1,22 @@
+//go:build windows
+
+package server
+
+import (
+	"syscall"
+	"time"
+)
+
+// processCPUTime returns the user and kernel CPU time consumed by the process
+func processCPUTime() time.Duration {
+	var creation, exit, kernel, user syscall.Filetime
+	if err := syscall.GetProcessTimes(syscall.CurrentProcess(), &creation, &exit, &kernel, &user); err != nil {
+		return 0
+	}
+	// Filetimes count 100ns intervals
+	return time.Duration((filetimeTicks(kernel) + filetimeTicks(user)) * 100)
+}
+
+func filetimeTicks(ft syscall.Filetime) int64 {
+	return int64(ft.HighDateTime)<<32 | int64(ft.LowDateTime)
+}
@@ -0,0 +1,144 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// startupCheckTimeout bounds the startup checks of each controller, unless configured in the
+// preflight section
+const startupCheckTimeout = 10 * time.Second
+
+// CheckResult is the outcome of a startup check of a dependency, e.g. an OAuth provider.
+type CheckResult struct {
+	// Name identifies the checked dependency, e.g. "provider google".
+	Name string
+	// Err is nil when the check passed.
+	Err error
+	// Duration is the time the check took.
+	Duration time.Duration
+}
+
+// StartupChecker is implemented by controllers verifying their dependencies at startup, e.g. that
+// the endpoints of their upstreams are reachable. Failed checks are reported in the startup
+// diagnostics instead of failing the startup, so that problems show up before the first request,
+// unless the preflight is strict and the checks required, see PreflightConfig.
+type StartupChecker interface {
+	// StartupCheck runs the checks of the controller, returning one result per dependency. It
+	// must return when ctx is done.
+	StartupCheck(ctx context.Context) []CheckResult
+}
+
+// Diagnostic is the result of a startup check of a controller, or of a preflight check of the
+// server when Controller is empty.
+type Diagnostic struct {
+	CheckResult
+	// Controller is the name of the controller instance.
+	Controller string
+	// Required tells whether the failure of the check makes the server unready, see
+	// PreflightConfig.Optional.
+	Required bool
+}
+
+// ID identifies the check in the preflight configuration: its name, prefixed with the name of
+// its controller and "/" for controller checks, e.g. "api/endpoint http://backend:8080".
+func (d Diagnostic) ID() string {
+	if d.Controller == "" {
+		return d.Name
+	}
+	return d.Controller + "/" + d.Name
+}
+
+// Diagnostics returns the results of the preflight checks of the server and the startup checks
+// of the controllers, available once the server is started.
+func (s *Server) Diagnostics() []Diagnostic {
+	return s.diagnostics
+}
+
+// AddPreflightCheck adds a check run before the listener opens, such as a database ping. Its
+// result is part of the startup diagnostics.
+func (s *Server) AddPreflightCheck(name string, check func(ctx context.Context) error) {
+	s.preflightChecks = append(s.preflightChecks, preflightCheck{name: name, run: check})
+}
+
+// preflightCheck is a check added with AddPreflightCheck
+type preflightCheck struct {
+	name string
+	run  func(ctx context.Context) error
+}
+
+// runStartupChecks runs the preflight checks of the server and the checks of the controllers
+// implementing StartupChecker, and logs their results.
+func (s *Server) runStartupChecks(controllers []configuredController) []Diagnostic {
+	timeout := startupCheckTimeout
+	var optional []string
+	if preflight := s.config.WebServerConfig.Preflight; preflight != nil {
+		if preflight.Timeout > 0 {
+			timeout = preflight.Timeout
+		}
+		optional = preflight.Optional
+	}
+
+	var diagnostics []Diagnostic
+	for _, check := range s.preflightChecks {
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		start := time.Now()
+		err := check.run(ctx)
+		cancel()
+		diagnostics = append(diagnostics, Diagnostic{CheckResult: CheckResult{Name: check.name, Err: err, Duration: time.Since(start)}})
+	}
+	for _, c := range controllers {
+		checker, ok := c.controller.(StartupChecker)
+		if !ok {
+			continue
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		results := checker.StartupCheck(ctx)
+		cancel()
+		for _, result := range results {
+			diagnostics = append(diagnostics, Diagnostic{CheckResult: result, Controller: c.labels.name})
+		}
+	}
+
+	for i := range diagnostics {
+		d := &diagnostics[i]
+		d.Required = !slices.Contains(optional, d.ID()) && (d.Controller == "" || !slices.Contains(optional, d.Controller))
+		if d.Err != nil {
+			log.Warn().
+				Err(d.Err).
+				Str("controller", d.Controller).
+				Str("check", d.Name).
+				Bool("required", d.Required).
+				Dur("duration", d.Duration).
+				Msg("Startup check failed")
+			continue
+		}
+		log.Info().
+			Str("controller", d.Controller).
+			Str("check", d.Name).
+			Dur("duration", d.Duration).
+			Msg("Startup check passed")
+	}
+	return diagnostics
+}
+
+// failedRequiredChecks returns the IDs of the required checks that failed
+func failedRequiredChecks(diagnostics []Diagnostic) []string {
+	var failed []string
+	for _, d := range diagnostics {
+		if d.Required && d.Err != nil {
+			failed = append(failed, d.ID())
+		}
+	}
+	return failed
+}
+
+// preflightError reports the required checks that failed in strict preflight mode
+func preflightError(failed []string) error {
+	return fmt.Errorf("%w: %s", ErrPreflight, strings.Join(failed, ", "))
+}
@@ -0,0 +1,151 @@
+//go:build unit
+
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+
+	"github.com/animalet/sargantana-go/pkg/config"
+	"github.com/gin-contrib/sessions/cookie"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/pkg/errors"
+)
+
+// checkingController reports fixed startup check results
+type checkingController struct {
+	MockController
+	results []CheckResult
+}
+
+func (c *checkingController) StartupCheck(ctx context.Context) []CheckResult {
+	Expect(ctx.Done()).NotTo(BeNil())
+	return c.results
+}
+
+var _ = Describe("Startup diagnostics", func() {
+	It("should collect the startup checks of the controllers", func() {
+		addControllerType("checking-controller", func(config.ModuleRawConfig, ControllerContext) (IController, error) {
+			return &checkingController{results: []CheckResult{
+				{Name: "upstream a"},
+				{Name: "upstream b", Err: errors.New("unreachable")},
+			}}, nil
+		})
+		s := NewServer(SargantanaConfig{
+			WebServerConfig: WebServerConfig{
+				Address:       "localhost:0",
+				SessionName:   "test-session",
+				SessionSecret: "secret",
+			},
+			ControllerBindings: []ControllerBinding{
+				{TypeName: "checking-controller", Name: "checked", Config: config.ModuleRawConfig{}},
+				{TypeName: "checking-controller", Name: "other", Config: config.ModuleRawConfig{}},
+			},
+		})
+		s.SetSessionStore(cookie.NewStore([]byte("secret")))
+		Expect(s.Diagnostics()).To(BeEmpty())
+
+		Expect(s.Start()).To(Succeed())
+		DeferCleanup(s.Shutdown)
+
+		diagnostics := s.Diagnostics()
+		Expect(diagnostics).To(HaveLen(4))
+		Expect(diagnostics[0].Controller).To(Equal("checked"))
+		Expect(diagnostics[0].Name).To(Equal("upstream a"))
+		Expect(diagnostics[0].Err).NotTo(HaveOccurred())
+		Expect(diagnostics[1].Err).To(MatchError("unreachable"))
+		Expect(diagnostics[2].Controller).To(Equal("other"))
+	})
+
+	Context("Preflight", func() {
+		newServer := func(preflight *PreflightConfig) *Server {
+			addControllerType("checking-controller", func(config.ModuleRawConfig, ControllerContext) (IController, error) {
+				return &checkingController{results: []CheckResult{{Name: "upstream", Err: errors.New("unreachable")}}}, nil
+			})
+			s := NewServer(SargantanaConfig{
+				WebServerConfig: WebServerConfig{
+					Address:       "localhost:0",
+					SessionName:   "test-session",
+					SessionSecret: "secret",
+					Preflight:     preflight,
+				},
+				ControllerBindings: []ControllerBinding{
+					{TypeName: "checking-controller", Name: "api", Config: config.ModuleRawConfig{}},
+				},
+			})
+			s.SetSessionStore(cookie.NewStore([]byte("secret")))
+			return s
+		}
+
+		It("should run the preflight checks of the server before the controller checks", func() {
+			s := newServer(nil)
+			s.AddPreflightCheck("session_store", func(ctx context.Context) error {
+				return nil
+			})
+			Expect(s.Start()).To(Succeed())
+			DeferCleanup(s.Shutdown)
+
+			diagnostics := s.Diagnostics()
+			Expect(diagnostics).To(HaveLen(2))
+			Expect(diagnostics[0].ID()).To(Equal("session_store"))
+			Expect(diagnostics[0].Required).To(BeTrue())
+			Expect(diagnostics[1].ID()).To(Equal("api/upstream"))
+			Expect(diagnostics[1].Required).To(BeTrue())
+		})
+
+		It("should fail the startup when a required check fails in strict mode", func() {
+			s := newServer(&PreflightConfig{Strict: true})
+			s.AddPreflightCheck("session_store", func(ctx context.Context) error {
+				return errors.New("connection refused")
+			})
+			err := s.Start()
+			Expect(err).To(MatchError(ErrPreflight))
+			Expect(err.Error()).To(ContainSubstring("session_store, api/upstream"))
+			Expect(s.Addresses()).To(BeEmpty())
+		})
+
+		It("should ignore optional checks in strict mode", func() {
+			s := newServer(&PreflightConfig{Strict: true, Optional: []string{"api", "session_store"}})
+			s.AddPreflightCheck("session_store", func(ctx context.Context) error {
+				return errors.New("connection refused")
+			})
+			Expect(s.Start()).To(Succeed())
+			DeferCleanup(s.Shutdown)
+			Expect(s.Diagnostics()[0].Required).To(BeFalse())
+			Expect(s.Diagnostics()[1].Required).To(BeFalse())
+		})
+
+		It("should serve the readiness of the server", func() {
+			s := newServer(&PreflightConfig{ReadinessPath: "/readyz"})
+			Expect(s.Start()).To(Succeed())
+			DeferCleanup(s.Shutdown)
+
+			res, err := http.Get("http://" + s.Addresses()[0] + "/readyz")
+			Expect(err).NotTo(HaveOccurred())
+			defer res.Body.Close()
+			Expect(res.StatusCode).To(Equal(http.StatusServiceUnavailable))
+			var body struct {
+				Ready  bool `json:"ready"`
+				Checks []struct {
+					Name       string `json:"name"`
+					Controller string `json:"controller"`
+					Required   bool   `json:"required"`
+					Error      string `json:"error"`
+				} `json:"checks"`
+			}
+			Expect(json.NewDecoder(res.Body).Decode(&body)).To(Succeed())
+			Expect(body.Ready).To(BeFalse())
+			Expect(body.Checks).To(HaveLen(1))
+			Expect(body.Checks[0].Controller).To(Equal("api"))
+			Expect(body.Checks[0].Error).To(Equal("unreachable"))
+		})
+
+		It("should validate the preflight configuration", func() {
+			Expect(PreflightConfig{Timeout: -1}.Validate()).To(MatchError(ContainSubstring("timeout")))
+			Expect(PreflightConfig{ReadinessPath: "readyz"}.Validate()).To(MatchError(ContainSubstring("readiness_path")))
+			Expect(PreflightConfig{ReadinessPath: "/readyz"}.Validate()).To(Succeed())
+		})
+	})
+})
@@ -0,0 +1,232 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/animalet/sargantana-go/pkg/openapi"
+	"github.com/animalet/sargantana-go/pkg/routes"
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+)
+
+// healthCheckTimeout bounds the health checks of a readiness request, unless configured in the
+// health section
+const healthCheckTimeout = 2 * time.Second
+
+// controllerHealthCheck names the health check of the controllers implementing HealthReporter
+const controllerHealthCheck = "health"
+
+// Lifecycle states of the server, reported by the readiness endpoint
+const (
+	stateStarting int32 = iota
+	stateServing
+	stateDraining
+)
+
+// HealthReporter is implemented by the dependencies reporting their health while the server runs,
+// such as session stores, database pools and the upstreams of load balancers. The server is not
+// ready while a required reporter fails, see HealthConfig. Controllers implementing it are
+// registered when the server starts; other reporters are registered with Server.AddHealthReporter.
+type HealthReporter interface {
+	// HealthCheck returns nil when the dependency is healthy. It must return when ctx is done.
+	HealthCheck(ctx context.Context) error
+}
+
+// HealthCheckFunc adapts a function to HealthReporter.
+type HealthCheckFunc func(ctx context.Context) error
+
+// HealthCheck calls f.
+func (f HealthCheckFunc) HealthCheck(ctx context.Context) error {
+	return f(ctx)
+}
+
+// HealthConfig serves the liveness and readiness endpoints of the server. Both are served before
+// the session middleware, so probes create no sessions.
+type HealthConfig struct {
+	// LivenessPath answers with status 200 as long as the server handles requests, including
+	// while it drains connections on shutdown. Defaults to /healthz.
+	LivenessPath string `yaml:"liveness_path,omitempty"`
+	// ReadinessPath answers with status 200 when the server is ready, and 503 while it starts,
+	// while it drains connections on shutdown, or when a required startup or health check fails.
+	// Defaults to /readyz, or to the readiness path of the preflight section.
+	ReadinessPath string `yaml:"readiness_path,omitempty"`
+	// Timeout bounds the health checks run by each readiness request. Defaults to 2s.
+	Timeout time.Duration `yaml:"timeout,omitempty"`
+	// Optional lists the health checks whose failures do not make the server unready, by ID, as
+	// PreflightConfig.Optional does for the startup checks.
+	Optional []string `yaml:"optional,omitempty"`
+	// DrainDelay keeps the server handling requests for this duration after its readiness starts
+	// failing on shutdown, before the connections are drained, so that load balancers stop
+	// routing to it first. Optional.
+	DrainDelay time.Duration `yaml:"drain_delay,omitempty"`
+}
+
+func (c HealthConfig) Validate() error {
+	if c.LivenessPath != "" && !strings.HasPrefix(c.LivenessPath, "/") {
+		return errors.New("liveness_path must start with /")
+	}
+	if c.ReadinessPath != "" && !strings.HasPrefix(c.ReadinessPath, "/") {
+		return errors.New("readiness_path must start with /")
+	}
+	if c.Timeout < 0 {
+		return errors.New("timeout must not be negative")
+	}
+	if c.DrainDelay < 0 {
+		return errors.New("drain_delay must not be negative")
+	}
+	return nil
+}
+
+func (c HealthConfig) livenessPath() string {
+	if c.LivenessPath == "" {
+		return routes.DefaultLivenessPath
+	}
+	return c.LivenessPath
+}
+
+func (c HealthConfig) timeout() time.Duration {
+	if c.Timeout == 0 {
+		return healthCheckTimeout
+	}
+	return c.Timeout
+}
+
+// ProbePaths returns the liveness and readiness paths served by the server, empty when not
+// served. Without health section, only the readiness path of the preflight section is served.
+func (c WebServerConfig) ProbePaths() (liveness, readiness string) {
+	if c.Preflight != nil {
+		readiness = c.Preflight.ReadinessPath
+	}
+	if c.Health == nil {
+		return "", readiness
+	}
+	if c.Health.ReadinessPath != "" {
+		readiness = c.Health.ReadinessPath
+	} else if readiness == "" {
+		readiness = routes.DefaultReadinessPath
+	}
+	return c.Health.livenessPath(), readiness
+}
+
+// Describe adds the liveness and readiness endpoints served by the server to the OpenAPI
+// document, see openapi.Describer.
+func (c WebServerConfig) Describe(doc *openapi.Document, basePath string) {
+	liveness, readiness := c.ProbePaths()
+	if liveness != "" {
+		doc.Add(http.MethodGet, basePath, liveness, openapi.Operation{
+			OperationID: "getLiveness",
+			Summary:     "Answers while the server handles requests",
+			Tags:        []string{"health"},
+			Responses: map[string]openapi.Response{
+				"200": {Description: "The server is alive", Content: openapi.JSON(doc.Schema("Liveness", livenessResponse{}))},
+			},
+		})
+	}
+	PreflightConfig{ReadinessPath: readiness}.Describe(doc, basePath)
+}
+
+// AddHealthReporter adds a dependency checked by the readiness endpoint, such as a database pool,
+// under the check name, e.g. "orders_db". See HealthReporter.
+func (s *Server) AddHealthReporter(name string, reporter HealthReporter) {
+	s.healthReporters = append(s.healthReporters, healthReporter{name: name, reporter: reporter})
+}
+
+// healthReporter is a reporter added with AddHealthReporter, or a controller
+type healthReporter struct {
+	name       string
+	controller string // Empty for the reporters of the server
+	reporter   HealthReporter
+}
+
+// livenessResponse is the liveness endpoint response
+type livenessResponse struct {
+	Status string `json:"status"`
+}
+
+func livenessHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, livenessResponse{Status: "ok"})
+}
+
+// readinessHandler serves the readiness of the server: its lifecycle state, the startup
+// diagnostics and the results of the health checks, with status 503 unless it is ready
+func (s *Server) readinessHandler(c *gin.Context) {
+	response := s.readiness(c.Request.Context())
+	status := http.StatusOK
+	if !response.Ready {
+		status = http.StatusServiceUnavailable
+	}
+	c.JSON(status, response)
+}
+
+// readiness runs the health checks unless the server is starting or draining, and replaces the
+// startup diagnostics of the same checks with their results
+func (s *Server) readiness(ctx context.Context) readinessResponse {
+	diagnostics := slices.Clone(s.diagnostics)
+	var status string
+	switch s.state.Load() {
+	case stateStarting:
+		status = "starting"
+	case stateDraining:
+		status = "draining"
+	default:
+		for _, d := range s.runHealthChecks(ctx) {
+			i := slices.IndexFunc(diagnostics, func(startup Diagnostic) bool { return startup.ID() == d.ID() })
+			if i < 0 {
+				diagnostics = append(diagnostics, d)
+			} else {
+				diagnostics[i] = d
+			}
+		}
+		status = "ready"
+		if len(failedRequiredChecks(diagnostics)) > 0 {
+			status = "unready"
+		}
+	}
+
+	checks := make([]readinessCheck, 0, len(diagnostics))
+	for _, d := range diagnostics {
+		check := readinessCheck{
+			Name:       d.Name,
+			Controller: d.Controller,
+			Required:   d.Required,
+			DurationMs: d.Duration.Milliseconds(),
+		}
+		if d.Err != nil {
+			check.Error = d.Err.Error()
+		}
+		checks = append(checks, check)
+	}
+	return readinessResponse{Ready: status == "ready", Status: status, Checks: checks}
+}
+
+// runHealthChecks runs the health reporters concurrently, bounded by the health timeout
+func (s *Server) runHealthChecks(ctx context.Context) []Diagnostic {
+	healthConfig := HealthConfig{}
+	if s.config.WebServerConfig.Health != nil {
+		healthConfig = *s.config.WebServerConfig.Health
+	}
+	ctx, cancel := context.WithTimeout(ctx, healthConfig.timeout())
+	defer cancel()
+
+	diagnostics := make([]Diagnostic, len(s.healthReporters))
+	var wg sync.WaitGroup
+	for i, r := range s.healthReporters {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			start := time.Now()
+			err := r.reporter.HealthCheck(ctx)
+			d := Diagnostic{CheckResult: CheckResult{Name: r.name, Err: err, Duration: time.Since(start)}, Controller: r.controller}
+			d.Required = !slices.Contains(healthConfig.Optional, d.ID()) &&
+				(d.Controller == "" || !slices.Contains(healthConfig.Optional, d.Controller))
+			diagnostics[i] = d
+		}()
+	}
+	wg.Wait()
+	return diagnostics
+}
@@ -0,0 +1,181 @@
+//go:build unit
+
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"time"
+
+	"github.com/animalet/sargantana-go/pkg/config"
+	"github.com/gin-contrib/sessions/cookie"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/pkg/errors"
+)
+
+// reportingController reports its health
+type reportingController struct {
+	MockController
+	err error
+}
+
+func (c *reportingController) HealthCheck(context.Context) error {
+	return c.err
+}
+
+var _ = Describe("Health", func() {
+	newServer := func(health *HealthConfig) *Server {
+		addControllerType("reporting-controller", func(config.ModuleRawConfig, ControllerContext) (IController, error) {
+			return &reportingController{err: errors.New("no endpoint is reachable")}, nil
+		})
+		s := NewServer(SargantanaConfig{
+			WebServerConfig: WebServerConfig{
+				Address:       "localhost:0",
+				SessionName:   "test-session",
+				SessionSecret: "secret",
+				Health:        health,
+			},
+			ControllerBindings: []ControllerBinding{
+				{TypeName: "reporting-controller", Name: "api", Config: config.ModuleRawConfig{}},
+			},
+		})
+		s.SetSessionStore(cookie.NewStore([]byte("secret")))
+		return s
+	}
+
+	probe := func(s *Server, path string) (int, readinessResponse) {
+		w := httptest.NewRecorder()
+		s.httpServer.Handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		var response readinessResponse
+		Expect(json.Unmarshal(w.Body.Bytes(), &response)).To(Succeed())
+		return w.Code, response
+	}
+
+	Context("Configuration", func() {
+		It("should validate the health configuration", func() {
+			Expect(HealthConfig{}.Validate()).To(Succeed())
+			Expect(HealthConfig{LivenessPath: "healthz"}.Validate()).To(MatchError(ContainSubstring("liveness_path")))
+			Expect(HealthConfig{ReadinessPath: "readyz"}.Validate()).To(MatchError(ContainSubstring("readiness_path")))
+			Expect(HealthConfig{Timeout: -1}.Validate()).To(MatchError(ContainSubstring("timeout")))
+			Expect(HealthConfig{DrainDelay: -1}.Validate()).To(MatchError(ContainSubstring("drain_delay")))
+		})
+
+		It("should resolve the probe paths", func() {
+			liveness, readiness := WebServerConfig{}.ProbePaths()
+			Expect([]string{liveness, readiness}).To(Equal([]string{"", ""}))
+			liveness, readiness = WebServerConfig{Preflight: &PreflightConfig{ReadinessPath: "/ready"}}.ProbePaths()
+			Expect([]string{liveness, readiness}).To(Equal([]string{"", "/ready"}))
+			liveness, readiness = WebServerConfig{Health: &HealthConfig{}}.ProbePaths()
+			Expect([]string{liveness, readiness}).To(Equal([]string{"/healthz", "/readyz"}))
+			liveness, readiness = WebServerConfig{Health: &HealthConfig{LivenessPath: "/live"}, Preflight: &PreflightConfig{ReadinessPath: "/ready"}}.ProbePaths()
+			Expect([]string{liveness, readiness}).To(Equal([]string{"/live", "/ready"}))
+		})
+
+		It("should reject conflicting probe paths", func() {
+			cfg := WebServerConfig{
+				Address:       "localhost:8080",
+				SessionName:   "session",
+				SessionSecret: "secret",
+				Preflight:     &PreflightConfig{ReadinessPath: "/ready"},
+				Health:        &HealthConfig{ReadinessPath: "/readyz"},
+			}
+			Expect(cfg.Validate()).To(MatchError(ContainSubstring("must be the same")))
+			cfg.Health = &HealthConfig{LivenessPath: "/ready"}
+			Expect(cfg.Validate()).To(MatchError(ContainSubstring("must differ")))
+			cfg.Health = &HealthConfig{}
+			Expect(cfg.Validate()).To(Succeed())
+		})
+	})
+
+	It("should report the readiness of the lifecycle and health checks", func() {
+		s := newServer(&HealthConfig{Optional: []string{"api"}})
+		var sessionStoreDown atomic.Bool
+		s.AddHealthReporter("session_store", HealthCheckFunc(func(ctx context.Context) error {
+			Expect(ctx.Done()).NotTo(BeNil())
+			if sessionStoreDown.Load() {
+				return errors.New("connection refused")
+			}
+			return nil
+		}))
+
+		By("failing while the server starts")
+		Expect(s.bootstrap()).To(Succeed())
+		code, response := probe(s, "/readyz")
+		Expect(code).To(Equal(http.StatusServiceUnavailable))
+		Expect(response.Status).To(Equal("starting"))
+
+		By("running the health checks once it listens")
+		Expect(s.listenAndServe()).To(Succeed())
+		code, response = probe(s, "/readyz")
+		Expect(code).To(Equal(http.StatusOK))
+		Expect(response.Ready).To(BeTrue())
+		Expect(response.Checks).To(ConsistOf(
+			readinessCheck{Name: "session_store", Required: true},
+			readinessCheck{Name: "health", Controller: "api", Error: "no endpoint is reachable"},
+		))
+
+		By("failing while a required check fails")
+		sessionStoreDown.Store(true)
+		code, response = probe(s, "/readyz")
+		Expect(code).To(Equal(http.StatusServiceUnavailable))
+		Expect(response.Status).To(Equal("unready"))
+
+		By("answering the liveness probe")
+		w := httptest.NewRecorder()
+		s.httpServer.Handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+		Expect(w.Code).To(Equal(http.StatusOK))
+		Expect(w.Body.String()).To(MatchJSON(`{"status": "ok"}`))
+
+		By("failing while the server drains")
+		Expect(s.Shutdown()).To(Succeed())
+		code, response = probe(s, "/readyz")
+		Expect(code).To(Equal(http.StatusServiceUnavailable))
+		Expect(response.Status).To(Equal("draining"))
+	})
+
+	It("should replace the startup results of the health checks", func() {
+		s := newServer(&HealthConfig{})
+		s.AddPreflightCheck("session_store", func(context.Context) error {
+			return errors.New("connection refused")
+		})
+		s.AddHealthReporter("session_store", HealthCheckFunc(func(context.Context) error { return nil }))
+		s.config.WebServerConfig.Preflight = &PreflightConfig{Optional: []string{"api"}}
+		Expect(s.Start()).To(Succeed())
+		DeferCleanup(s.Shutdown)
+
+		Expect(s.Diagnostics()[0].Err).To(HaveOccurred())
+		response := s.readiness(context.Background())
+		Expect(response.Checks).To(HaveLen(2))
+		Expect(response.Checks[0]).To(Equal(readinessCheck{Name: "session_store", Required: true}))
+		Expect(response.Checks[1].Controller).To(Equal("api"))
+		Expect(response.Ready).To(BeFalse())
+	})
+
+	It("should keep serving with a failing readiness for the drain delay", func() {
+		s := newServer(&HealthConfig{DrainDelay: 300 * time.Millisecond, Optional: []string{"api"}})
+		Expect(s.Start()).To(Succeed())
+		url := "http://" + s.Addresses()[0] + "/readyz"
+		res, err := http.Get(url)
+		Expect(err).NotTo(HaveOccurred())
+		_ = res.Body.Close()
+		Expect(res.StatusCode).To(Equal(http.StatusOK))
+
+		done := make(chan error, 1)
+		go func() {
+			done <- s.Shutdown()
+		}()
+		Eventually(func() int {
+			res, err := http.Get(url)
+			if err != nil {
+				return 0
+			}
+			_ = res.Body.Close()
+			return res.StatusCode
+		}).Should(Equal(http.StatusServiceUnavailable))
+		Eventually(done).Should(Receive(BeNil()))
+	})
+})
@@ -0,0 +1,273 @@
+package server
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"mime"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// NoncePlaceholder is replaced in HTML snippets with the CSP nonce of the response, e.g.
+	// <script nonce="{{nonce}}">. The nonce is added to the script-src and style-src directives of
+	// the Content-Security-Policy header of the response.
+	NoncePlaceholder = "{{nonce}}"
+
+	defaultInjectionMaxBodySize = 1024 * 1024
+)
+
+var (
+	headEnd = regexp.MustCompile(`(?i)</head\s*>`)
+	bodyEnd = regexp.MustCompile(`(?i)</body\s*>`)
+)
+
+// HTMLInjectionConfig configures the html_injection middleware type, which injects HTML snippets
+// into the HTML responses of a controller binding, e.g. a cookie banner, an analytics script or an
+// environment ribbon, without changing the upstream applications or the templates.
+type HTMLInjectionConfig struct {
+	Snippets []HTMLSnippetConfig `yaml:"snippets"`
+	// MaxBodySize is the size in bytes of the largest response snippets are injected into; larger
+	// responses are sent unchanged. Defaults to 1 MiB.
+	MaxBodySize int64 `yaml:"max_body_size,omitempty"`
+}
+
+// HTMLSnippetConfig is an HTML snippet, given inline or read from a file, injected before the end of
+// the head or of the body of the pages.
+type HTMLSnippetConfig struct {
+	HTML string `yaml:"html,omitempty"`
+	File string `yaml:"file,omitempty"`
+	// Position is "head" to inject the snippet before </head>, or "body" before </body>. Defaults to
+	// body; pages without </body> get the snippet at their end.
+	Position string `yaml:"position,omitempty"`
+}
+
+func (c HTMLInjectionConfig) Validate() error {
+	if len(c.Snippets) == 0 {
+		return errors.New("at least one snippet must be set")
+	}
+	if c.MaxBodySize < 0 {
+		return errors.New("max_body_size must not be negative")
+	}
+	for i, snippet := range c.Snippets {
+		if (snippet.HTML == "") == (snippet.File == "") {
+			return errors.Errorf("snippet %d must set exactly one of html or file", i)
+		}
+		if snippet.Position != "" && snippet.Position != "head" && snippet.Position != "body" {
+			return errors.Errorf("snippet %d has invalid position %q, expected head or body", i, snippet.Position)
+		}
+	}
+	return nil
+}
+
+// NewHTMLInjectionMiddleware creates the middleware of the html_injection middleware type, reading
+// the snippet files.
+func NewHTMLInjectionMiddleware(c HTMLInjectionConfig) (gin.HandlerFunc, error) {
+	injection := &htmlInjection{maxBodySize: c.MaxBodySize}
+	if injection.maxBodySize == 0 {
+		injection.maxBodySize = defaultInjectionMaxBodySize
+	}
+	for _, snippet := range c.Snippets {
+		content := snippet.HTML
+		if snippet.File != "" {
+			data, err := os.ReadFile(snippet.File)
+			if err != nil {
+				return nil, errors.Wrapf(err, "failed to read snippet file %s", snippet.File)
+			}
+			content = string(data)
+		}
+		if snippet.Position == "head" {
+			injection.head += content
+		} else {
+			injection.body += content
+		}
+	}
+	injection.nonce = strings.Contains(injection.head+injection.body, NoncePlaceholder)
+	return injection.middleware, nil
+}
+
+type htmlInjection struct {
+	head, body  string
+	nonce       bool // The snippets use the nonce placeholder
+	maxBodySize int64
+}
+
+func (h *htmlInjection) middleware(c *gin.Context) {
+	if c.Request.Method == http.MethodHead {
+		c.Next()
+		return
+	}
+	// Proxied pages are requested uncompressed from the upstreams to be injected into
+	c.Request.Header.Del("Accept-Encoding")
+	writer := &injectionWriter{ResponseWriter: c.Writer, injection: h}
+	c.Writer = writer
+	defer func() {
+		c.Writer = writer.ResponseWriter
+	}()
+	c.Next()
+	writer.finish()
+}
+
+// inject inserts the snippets into a page, setting the nonce of the response
+func (h *htmlInjection) inject(page []byte, header http.Header) []byte {
+	head, body := h.head, h.body
+	if h.nonce {
+		nonce := make([]byte, 16)
+		_, _ = rand.Read(nonce)
+		value := base64.StdEncoding.EncodeToString(nonce)
+		head = strings.ReplaceAll(head, NoncePlaceholder, value)
+		body = strings.ReplaceAll(body, NoncePlaceholder, value)
+		if policy := header.Get("Content-Security-Policy"); policy != "" {
+			header.Set("Content-Security-Policy", addNonce(policy, value))
+		}
+	}
+
+	var injected bytes.Buffer
+	injected.Grow(len(page) + len(head) + len(body))
+	rest := page
+	if head != "" {
+		if loc := headEnd.FindIndex(rest); loc != nil {
+			injected.Write(rest[:loc[0]])
+			injected.WriteString(head)
+			rest = rest[loc[0]:]
+		} else {
+			log.Debug().Msg("HTML response has no </head>, head snippets not injected")
+		}
+	}
+	if body != "" {
+		// The last </body>, as scripts may contain the string
+		if locs := bodyEnd.FindAllIndex(rest, -1); locs != nil {
+			end := locs[len(locs)-1][0]
+			injected.Write(rest[:end])
+			injected.WriteString(body)
+			rest = rest[end:]
+		} else {
+			injected.Write(rest)
+			injected.WriteString(body)
+			rest = nil
+		}
+	}
+	injected.Write(rest)
+	return injected.Bytes()
+}
+
+// addNonce allows the nonce in the script-src and style-src directives of a policy, or in its
+// default-src directive for the missing ones. Directives allowing 'unsafe-inline' are left as they
+// are, as a nonce would disable it.
+func addNonce(policy, nonce string) string {
+	directives := strings.Split(policy, ";")
+	index := make(map[string]int, len(directives))
+	for i, directive := range directives {
+		if fields := strings.Fields(directive); len(fields) > 0 {
+			index[strings.ToLower(fields[0])] = i
+		}
+	}
+	source := " 'nonce-" + nonce + "'"
+	allow := func(i int) {
+		if !strings.Contains(strings.ToLower(directives[i]), "'unsafe-inline'") {
+			directives[i] = strings.TrimRight(directives[i], " ") + source
+		}
+	}
+	fallback := false
+	for _, name := range []string{"script-src", "style-src"} {
+		if i, ok := index[name]; ok {
+			allow(i)
+		} else {
+			fallback = true
+		}
+	}
+	if i, ok := index["default-src"]; ok && fallback {
+		allow(i)
+	}
+	return strings.Join(directives, ";")
+}
+
+// injectionWriter buffers the HTML responses to inject the snippets into them, and passes the others
+// through
+type injectionWriter struct {
+	gin.ResponseWriter
+	injection *htmlInjection
+	decided   bool // Whether the response is buffered has been decided
+	buffering bool
+	buffer    bytes.Buffer
+}
+
+// decide buffers uncompressed HTML responses with a body
+func (w *injectionWriter) decide() {
+	if w.decided {
+		return
+	}
+	w.decided = true
+	header := w.Header()
+	status := w.Status()
+	if header.Get("Content-Encoding") != "" || status == http.StatusNoContent || status == http.StatusNotModified {
+		return
+	}
+	mediaType, _, err := mime.ParseMediaType(header.Get("Content-Type"))
+	w.buffering = err == nil && (mediaType == "text/html" || mediaType == "application/xhtml+xml")
+}
+
+func (w *injectionWriter) WriteHeaderNow() {
+	w.decide()
+	if !w.buffering {
+		w.ResponseWriter.WriteHeaderNow()
+	}
+}
+
+func (w *injectionWriter) Write(b []byte) (int, error) {
+	w.decide()
+	if !w.buffering {
+		return w.ResponseWriter.Write(b)
+	}
+	if int64(w.buffer.Len()+len(b)) > w.injection.maxBodySize {
+		// Too large, the page is sent unchanged
+		if err := w.stopBuffering(); err != nil {
+			return 0, err
+		}
+		return w.ResponseWriter.Write(b)
+	}
+	return w.buffer.Write(b)
+}
+
+func (w *injectionWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+// Flush sends the buffered page unchanged, as streamed pages cannot wait for their end
+func (w *injectionWriter) Flush() {
+	if w.buffering {
+		_ = w.stopBuffering()
+	}
+	w.ResponseWriter.Flush()
+}
+
+func (w *injectionWriter) Written() bool {
+	return w.buffer.Len() > 0 || w.ResponseWriter.Written()
+}
+
+func (w *injectionWriter) stopBuffering() error {
+	w.buffering = false
+	_, err := w.ResponseWriter.Write(w.buffer.Bytes())
+	w.buffer.Reset()
+	return err
+}
+
+// finish injects the snippets into the buffered page and sends it
+func (w *injectionWriter) finish() {
+	if !w.buffering {
+		return
+	}
+	w.buffering = false
+	page := w.injection.inject(w.buffer.Bytes(), w.Header())
+	w.Header().Del("Content-Length")
+	if _, err := w.ResponseWriter.Write(page); err != nil {
+		log.Debug().Err(err).Msg("Failed to write injected HTML response")
+	}
+}
@@ -0,0 +1,153 @@
+//go:build unit
+
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("HTMLInjection", func() {
+	Context("HTMLInjectionConfig Validate", func() {
+		It("should accept inline and file snippets", func() {
+			Expect(HTMLInjectionConfig{Snippets: []HTMLSnippetConfig{
+				{HTML: "<div>banner</div>"},
+				{File: "analytics.html", Position: "head"},
+			}}.Validate()).To(Succeed())
+		})
+
+		It("should reject invalid settings", func() {
+			Expect(HTMLInjectionConfig{}.Validate()).To(MatchError(ContainSubstring("at least one snippet")))
+			Expect(HTMLInjectionConfig{Snippets: []HTMLSnippetConfig{{}}}.Validate()).To(MatchError(ContainSubstring("exactly one of")))
+			Expect(HTMLInjectionConfig{Snippets: []HTMLSnippetConfig{{HTML: "a", File: "b"}}}.Validate()).To(MatchError(ContainSubstring("exactly one of")))
+			Expect(HTMLInjectionConfig{Snippets: []HTMLSnippetConfig{{HTML: "a", Position: "footer"}}}.Validate()).To(MatchError(ContainSubstring("invalid position")))
+			Expect(HTMLInjectionConfig{Snippets: []HTMLSnippetConfig{{HTML: "a"}}, MaxBodySize: -1}.Validate()).NotTo(Succeed())
+		})
+	})
+
+	Context("Middleware", func() {
+		var (
+			config      HTMLInjectionConfig
+			contentType string
+			body        string
+			header      http.Header
+		)
+
+		BeforeEach(func() {
+			config = HTMLInjectionConfig{Snippets: []HTMLSnippetConfig{{HTML: "<div id=\"ribbon\">staging</div>"}}}
+			contentType, body, header = "text/html; charset=utf-8", "<html><head><title>t</title></head><body><p>page</p></body></html>", http.Header{}
+		})
+
+		serve := func(method string) *httptest.ResponseRecorder {
+			middleware, err := NewHTMLInjectionMiddleware(config)
+			Expect(err).NotTo(HaveOccurred())
+			engine := gin.New()
+			engine.Use(middleware)
+			engine.Handle(method, "/page", func(c *gin.Context) {
+				Expect(c.GetHeader("Accept-Encoding")).To(BeEmpty())
+				for name, values := range header {
+					c.Writer.Header()[name] = values
+				}
+				c.Header("Content-Length", "999")
+				// Written in parts as proxied pages are
+				c.Status(http.StatusOK)
+				c.Header("Content-Type", contentType)
+				half := len(body) / 2
+				_, _ = c.Writer.WriteString(body[:half])
+				_, _ = c.Writer.Write([]byte(body[half:]))
+			})
+			recorder := httptest.NewRecorder()
+			request := httptest.NewRequest(method, "/page", nil)
+			request.Header.Set("Accept-Encoding", "gzip")
+			engine.ServeHTTP(recorder, request)
+			return recorder
+		}
+
+		It("should inject body snippets before the end of the body", func() {
+			recorder := serve(http.MethodGet)
+			Expect(recorder.Code).To(Equal(http.StatusOK))
+			Expect(recorder.Body.String()).To(Equal("<html><head><title>t</title></head><body><p>page</p><div id=\"ribbon\">staging</div></body></html>"))
+			Expect(recorder.Header().Get("Content-Length")).To(BeEmpty())
+		})
+
+		It("should inject head snippets, and body snippets before the last end of the body", func() {
+			config.Snippets = append(config.Snippets, HTMLSnippetConfig{HTML: "<meta name=\"env\">", Position: "head"})
+			body = "<HTML><HEAD></HEAD><BODY><script>let s = '</body>';</script></BODY></HTML>"
+			Expect(serve(http.MethodGet).Body.String()).To(Equal(
+				"<HTML><HEAD><meta name=\"env\"></HEAD><BODY><script>let s = '</body>';</script><div id=\"ribbon\">staging</div></BODY></HTML>"))
+		})
+
+		It("should append body snippets to pages without body end", func() {
+			body = "<p>fragment</p>"
+			Expect(serve(http.MethodGet).Body.String()).To(Equal("<p>fragment</p><div id=\"ribbon\">staging</div>"))
+		})
+
+		It("should read snippets from files", func() {
+			file := filepath.Join(GinkgoT().TempDir(), "banner.html")
+			Expect(os.WriteFile(file, []byte("<div>cookies</div>"), 0o600)).To(Succeed())
+			config.Snippets = []HTMLSnippetConfig{{File: file}}
+			Expect(serve(http.MethodGet).Body.String()).To(ContainSubstring("<p>page</p><div>cookies</div></body>"))
+
+			config.Snippets = []HTMLSnippetConfig{{File: file + ".missing"}}
+			_, err := NewHTMLInjectionMiddleware(config)
+			Expect(err).To(MatchError(ContainSubstring("failed to read snippet file")))
+		})
+
+		It("should leave other responses unchanged", func() {
+			contentType, body = "application/json", `{"html":"</body>"}`
+			Expect(serve(http.MethodGet).Body.String()).To(Equal(body))
+
+			contentType = "text/html"
+			header.Set("Content-Encoding", "gzip")
+			Expect(serve(http.MethodGet).Body.String()).To(Equal(body))
+		})
+
+		It("should send pages larger than the maximum unchanged", func() {
+			config.MaxBodySize = 20
+			recorder := serve(http.MethodGet)
+			Expect(recorder.Body.String()).To(Equal(body))
+			Expect(recorder.Header().Get("Content-Length")).To(Equal("999"))
+		})
+
+		It("should set a fresh nonce in snippets and the content security policy", func() {
+			config.Snippets = []HTMLSnippetConfig{{HTML: "<script nonce=\"{{nonce}}\">track()</script>"}}
+			header.Set("Content-Security-Policy", "default-src 'self'; script-src 'self'")
+			nonces := make([]string, 2)
+			for i := range nonces {
+				recorder := serve(http.MethodGet)
+				nonce := regexp.MustCompile(`nonce="([^"]+)"`).FindStringSubmatch(recorder.Body.String())
+				Expect(nonce).To(HaveLen(2))
+				nonces[i] = nonce[1]
+				Expect(recorder.Header().Get("Content-Security-Policy")).To(Equal(
+					"default-src 'self' 'nonce-" + nonce[1] + "'; script-src 'self' 'nonce-" + nonce[1] + "'"))
+			}
+			Expect(nonces[0]).NotTo(Equal(nonces[1]))
+		})
+	})
+
+	Context("addNonce", func() {
+		It("should add the nonce to script and style sources", func() {
+			Expect(addNonce("script-src 'self'; style-src 'self'; img-src *", "n")).To(
+				Equal("script-src 'self' 'nonce-n'; style-src 'self' 'nonce-n'; img-src *"))
+		})
+
+		It("should fall back to the default sources", func() {
+			Expect(addNonce("default-src 'self'", "n")).To(Equal("default-src 'self' 'nonce-n'"))
+			Expect(addNonce("img-src *", "n")).To(Equal("img-src *"))
+		})
+
+		It("should keep directives allowing inline code", func() {
+			policy := "script-src 'self' 'unsafe-inline'; style-src 'unsafe-inline'"
+			Expect(addNonce(policy, "n")).To(Equal(policy))
+			Expect(strings.Count(addNonce("default-src 'self'; style-src 'unsafe-inline'", "n"), "'nonce-n'")).To(Equal(1))
+		})
+	})
+})
@@ -0,0 +1,333 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"regexp"
+	"slices"
+	"time"
+
+	"github.com/animalet/sargantana-go/pkg/database"
+	"github.com/animalet/sargantana-go/pkg/sessionsx"
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// IdempotencyKeyHeader is the default header carrying the idempotency key of a request.
+	IdempotencyKeyHeader = "Idempotency-Key"
+	// IdempotentReplayedHeader is set to "true" on the responses replayed by the idempotency middleware.
+	IdempotentReplayedHeader = "Idempotent-Replayed"
+
+	defaultIdempotencyTTL         = 24 * time.Hour
+	defaultIdempotencyLockTimeout = time.Minute
+	defaultIdempotencyMaxBodySize = 1024 * 1024
+	defaultIdempotencyTable       = "idempotency_keys"
+	maxIdempotencyKeyLength       = 255
+)
+
+var (
+	defaultIdempotencyMethods = []string{http.MethodPost, http.MethodPatch}
+	idempotencyTableName      = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*(\.[a-zA-Z_][a-zA-Z0-9_]*)?$`)
+)
+
+// IdempotencyConfig configures the idempotency middleware type. Requests with an unsafe method
+// carrying an idempotency key are run once: the response is stored for TTL and replayed to the
+// retries of the request with the same key, method, path, query and body. Keys are scoped to the
+// authenticated user, so the middleware should follow the login middleware of the binding.
+// Responses are stored in Redis or PostgreSQL, to be shared by the replicas of the server, or in
+// memory without either.
+type IdempotencyConfig struct {
+	// TTL is the time responses are replayed for. Defaults to 24h.
+	TTL time.Duration `yaml:"ttl,omitempty"`
+	// Methods are the methods honoring idempotency keys. Defaults to POST and PATCH.
+	Methods []string `yaml:"methods,omitempty"`
+	// Header carries the idempotency keys. Defaults to Idempotency-Key.
+	Header string `yaml:"header,omitempty"`
+	// Required answers 400 Bad Request to requests without idempotency key.
+	Required bool `yaml:"required,omitempty"`
+	// LockTimeout bounds the time a key is held by a request in progress, after which it can be
+	// retried, e.g. when the server handling it crashed. Defaults to 1m.
+	LockTimeout time.Duration `yaml:"lock_timeout,omitempty"`
+	// MaxBodySize is the size in bytes of the largest stored response body; larger responses are
+	// not stored and their retries run again. Defaults to 1 MiB.
+	MaxBodySize int64 `yaml:"max_body_size,omitempty"`
+	// Redis stores the responses in Redis.
+	Redis *database.RedisConfig `yaml:"redis,omitempty"`
+	// Postgres stores the responses in a PostgreSQL table, created if it does not exist.
+	Postgres *database.PostgresConfig `yaml:"postgres,omitempty"`
+	// Table is the PostgreSQL table of the responses. Defaults to idempotency_keys.
+	Table string `yaml:"table,omitempty"`
+}
+
+func (c IdempotencyConfig) Validate() error {
+	if c.TTL < 0 || c.LockTimeout < 0 || c.MaxBodySize < 0 {
+		return errors.New("ttl, lock_timeout and max_body_size must not be negative")
+	}
+	for _, method := range c.Methods {
+		switch method {
+		case http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete:
+		default:
+			return errors.Errorf("unsupported method %q, expected POST, PUT, PATCH or DELETE", method)
+		}
+	}
+	if c.Redis != nil && c.Postgres != nil {
+		return errors.New("only one of redis and postgres can be set")
+	}
+	if c.Redis != nil {
+		if err := c.Redis.Validate(); err != nil {
+			return errors.Wrap(err, "invalid redis configuration")
+		}
+	}
+	if c.Postgres != nil {
+		if err := c.Postgres.Validate(); err != nil {
+			return errors.Wrap(err, "invalid postgres configuration")
+		}
+	}
+	if c.Table != "" && !idempotencyTableName.MatchString(c.Table) {
+		return errors.Errorf("invalid table name %q", c.Table)
+	}
+	return nil
+}
+
+// NewIdempotencyMiddleware creates the middleware of the idempotency middleware type, with the
+// store of the config.
+func NewIdempotencyMiddleware(c IdempotencyConfig) (gin.HandlerFunc, error) {
+	var store IdempotencyStore
+	switch {
+	case c.Redis != nil:
+		pool, err := c.Redis.CreateClient()
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to create redis client")
+		}
+		store = NewRedisIdempotencyStore(pool)
+	case c.Postgres != nil:
+		pool, err := c.Postgres.CreateClient()
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to create postgres client")
+		}
+		table := c.Table
+		if table == "" {
+			table = defaultIdempotencyTable
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if store, err = NewPostgresIdempotencyStore(ctx, pool, table); err != nil {
+			database.ClosePostgres(pool)
+			return nil, err
+		}
+	default:
+		store = NewMemoryIdempotencyStore()
+	}
+	return newIdempotency(c, store).middleware(), nil
+}
+
+type idempotency struct {
+	config IdempotencyConfig
+	store  IdempotencyStore
+}
+
+func newIdempotency(c IdempotencyConfig, store IdempotencyStore) *idempotency {
+	if c.TTL == 0 {
+		c.TTL = defaultIdempotencyTTL
+	}
+	if len(c.Methods) == 0 {
+		c.Methods = defaultIdempotencyMethods
+	}
+	if c.Header == "" {
+		c.Header = IdempotencyKeyHeader
+	}
+	if c.LockTimeout == 0 {
+		c.LockTimeout = defaultIdempotencyLockTimeout
+	}
+	if c.MaxBodySize == 0 {
+		c.MaxBodySize = defaultIdempotencyMaxBodySize
+	}
+	return &idempotency{config: c, store: store}
+}
+
+func (i *idempotency) middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !slices.Contains(i.config.Methods, c.Request.Method) {
+			c.Next()
+			return
+		}
+		key := c.GetHeader(i.config.Header)
+		if key == "" {
+			if i.config.Required {
+				RespondError(c, http.StatusBadRequest)
+				return
+			}
+			c.Next()
+			return
+		}
+		if len(key) > maxIdempotencyKeyLength {
+			RespondError(c, http.StatusBadRequest)
+			return
+		}
+		body, err := io.ReadAll(c.Request.Body)
+		if err != nil {
+			RespondError(c, http.StatusBadRequest)
+			return
+		}
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+
+		ctx := c.Request.Context()
+		storeKey, fingerprint := i.storeKey(c, key), i.fingerprint(c, body)
+		stored, err := i.store.Reserve(ctx, storeKey, fingerprint, i.config.LockTimeout)
+		if err != nil {
+			logger := RequestLogger(c)
+			logger.Error().Err(err).Msg("Failed to reserve idempotency key")
+			RespondError(c, http.StatusServiceUnavailable)
+			return
+		}
+		if stored != nil {
+			i.replay(c, stored, fingerprint)
+			return
+		}
+
+		writer := &idempotentWriter{ResponseWriter: c.Writer, maxBodySize: i.config.MaxBodySize}
+		before := c.Writer.Header().Clone()
+		c.Writer = writer
+		completed := false
+		defer func() {
+			c.Writer = writer.ResponseWriter
+			if completed {
+				return
+			}
+			// The handler panicked or its response cannot be replayed, let the retries run
+			if err := i.store.Release(context.WithoutCancel(ctx), storeKey); err != nil {
+				log.Error().Err(err).Msg("Failed to release idempotency key")
+			}
+		}()
+		c.Next()
+
+		status := writer.Status()
+		if writer.overflow || !replayable(status) {
+			return
+		}
+		response := IdempotentResponse{
+			Fingerprint: fingerprint,
+			Status:      status,
+			Header:      changedHeaders(before, writer.Header()),
+			Body:        writer.body.Bytes(),
+		}
+		if err := i.store.Complete(context.WithoutCancel(ctx), storeKey, response, i.config.TTL); err != nil {
+			logger := RequestLogger(c)
+			logger.Error().Err(err).Msg("Failed to store idempotent response")
+			return
+		}
+		completed = true
+	}
+}
+
+// replay answers a retry with the stored response, or with 409 Conflict while the first request is
+// in progress and 422 Unprocessable Entity if the key was used for a different request.
+func (i *idempotency) replay(c *gin.Context, stored *IdempotentResponse, fingerprint string) {
+	switch {
+	case stored.Fingerprint != fingerprint:
+		RespondError(c, http.StatusUnprocessableEntity)
+	case stored.Pending:
+		c.Header("Retry-After", "1")
+		RespondError(c, http.StatusConflict)
+	default:
+		for name, values := range stored.Header {
+			c.Writer.Header()[name] = values
+		}
+		c.Header(IdempotentReplayedHeader, "true")
+		c.Data(stored.Status, stored.Header.Get("Content-Type"), stored.Body)
+		c.Abort()
+	}
+}
+
+// storeKey scopes the idempotency key to the user and the route, hashed to bound its length
+func (i *idempotency) storeKey(c *gin.Context, key string) string {
+	return hashParts(requestUser(c), c.Request.Method, c.Request.URL.Path, key)
+}
+
+// requestUser returns the authenticated user of a request, from the authenticators or the
+// session, or an empty string for anonymous requests
+func requestUser(c *gin.Context) string {
+	if user, ok := GetRemoteUser(c); ok {
+		return user
+	}
+	if _, ok := c.Get(sessions.DefaultKey); ok {
+		if sessionUser, ok := sessionsx.User(c); ok {
+			return sessionUser.Id
+		}
+	}
+	return ""
+}
+
+// fingerprint identifies the request a key was used for
+func (i *idempotency) fingerprint(c *gin.Context, body []byte) string {
+	return hashParts(c.Request.Method, c.Request.URL.Path, c.Request.URL.RawQuery, string(body))
+}
+
+func hashParts(parts ...string) string {
+	hash := sha256.New()
+	var length [8]byte
+	for _, part := range parts {
+		// Length prefixes keep the parts apart
+		binary.BigEndian.PutUint64(length[:], uint64(len(part)))
+		hash.Write(length[:])
+		_, _ = io.WriteString(hash, part)
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// replayable tells whether a response is stored, rather than letting the retries run again.
+// Server errors, timeouts and rate limiting are transient.
+func replayable(status int) bool {
+	return status < http.StatusInternalServerError &&
+		status != http.StatusRequestTimeout && status != http.StatusTooManyRequests
+}
+
+// changedHeaders returns the headers set by the handlers, not those set before by the outer
+// middlewares such as the request ID
+func changedHeaders(before, after http.Header) http.Header {
+	changed := make(http.Header)
+	for name, values := range after {
+		if !slices.Equal(before[name], values) {
+			changed[name] = slices.Clone(values)
+		}
+	}
+	return changed
+}
+
+// idempotentWriter keeps the response body to be stored, up to the maximum size
+type idempotentWriter struct {
+	gin.ResponseWriter
+	body        bytes.Buffer
+	maxBodySize int64
+	overflow    bool
+}
+
+func (w *idempotentWriter) Write(b []byte) (int, error) {
+	w.capture(b)
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *idempotentWriter) WriteString(s string) (int, error) {
+	w.capture([]byte(s))
+	return w.ResponseWriter.WriteString(s)
+}
+
+func (w *idempotentWriter) capture(b []byte) {
+	if w.overflow {
+		return
+	}
+	if int64(w.body.Len()+len(b)) > w.maxBodySize {
+		w.overflow = true
+		w.body.Reset()
+		return
+	}
+	w.body.Write(b)
+}
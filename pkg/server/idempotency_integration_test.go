@@ -0,0 +1,83 @@
+//go:build integration
+
+package server
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/animalet/sargantana-go/pkg/database"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Idempotency stores", func() {
+	ctx := context.Background()
+
+	behaveLikeAStore := func(newStore func() IdempotencyStore) {
+		It("should reserve, complete and release keys", func() {
+			store := newStore()
+			key := "integration-" + time.Now().Format(time.RFC3339Nano)
+			DeferCleanup(func() { _ = store.Release(ctx, key) })
+
+			Expect(store.Reserve(ctx, key, "fp", time.Minute)).To(BeNil())
+			Expect(store.Reserve(ctx, key, "fp", time.Minute)).To(Equal(&IdempotentResponse{Fingerprint: "fp", Pending: true}))
+			Expect(store.Release(ctx, key)).To(Succeed())
+			Expect(store.Reserve(ctx, key, "fp", time.Minute)).To(BeNil())
+
+			response := IdempotentResponse{
+				Fingerprint: "fp",
+				Status:      http.StatusCreated,
+				Header:      http.Header{"Location": {"/orders/42"}},
+				Body:        []byte("created"),
+			}
+			Expect(store.Complete(ctx, key, response, time.Minute)).To(Succeed())
+			Expect(store.Reserve(ctx, key, "fp", time.Minute)).To(Equal(&response))
+		})
+
+		It("should take over expired keys", func() {
+			store := newStore()
+			key := "integration-expired-" + time.Now().Format(time.RFC3339Nano)
+			Expect(store.Reserve(ctx, key, "fp", time.Millisecond)).To(BeNil())
+			time.Sleep(1100 * time.Millisecond)
+			Expect(store.Reserve(ctx, key, "fp", time.Minute)).To(BeNil())
+			Expect(store.Release(ctx, key)).To(Succeed())
+		})
+	}
+
+	Context("Redis", func() {
+		behaveLikeAStore(func() IdempotencyStore {
+			pool, err := database.RedisConfig{
+				Address:  "localhost:6379",
+				Username: "redisuser",
+				Password: "redispass",
+				MaxIdle:  3,
+			}.CreateClient()
+			Expect(err).NotTo(HaveOccurred())
+			DeferCleanup(pool.Close)
+			return NewRedisIdempotencyStore(pool)
+		})
+	})
+
+	Context("PostgreSQL", func() {
+		behaveLikeAStore(func() IdempotencyStore {
+			pool, err := database.PostgresConfig{
+				Host:     "localhost",
+				Port:     5432,
+				User:     "user",
+				Password: "password",
+				Database: "my_blog_db",
+				SSLMode:  "disable",
+			}.CreateClient()
+			Expect(err).NotTo(HaveOccurred())
+			DeferCleanup(pool.Close)
+			store, err := NewPostgresIdempotencyStore(ctx, pool, "idempotency_keys_integration")
+			Expect(err).NotTo(HaveOccurred())
+			DeferCleanup(func() {
+				_, _ = pool.Exec(context.Background(), "DROP TABLE IF EXISTS idempotency_keys_integration")
+			})
+			return store
+		})
+	})
+})
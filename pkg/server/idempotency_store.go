@@ -0,0 +1,263 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+)
+
+// IdempotentResponse is the record of an idempotency key: the fingerprint of the request it was
+// used for and, once the request completed, its response.
+type IdempotentResponse struct {
+	Fingerprint string      `json:"fingerprint"`
+	Pending     bool        `json:"pending,omitempty"` // The request is in progress
+	Status      int         `json:"status,omitempty"`
+	Header      http.Header `json:"header,omitempty"`
+	Body        []byte      `json:"body,omitempty"`
+}
+
+// IdempotencyStore keeps the records of the idempotency keys for the idempotency middleware. Keys
+// are opaque strings scoped by the middleware.
+type IdempotencyStore interface {
+	// Reserve records a pending request with the fingerprint under the key, for lockTimeout, unless
+	// the key already has a record. It returns the existing record, or nil if the key was reserved.
+	Reserve(ctx context.Context, key, fingerprint string, lockTimeout time.Duration) (*IdempotentResponse, error)
+	// Complete replaces the pending record of the key with the response, kept for ttl.
+	Complete(ctx context.Context, key string, response IdempotentResponse, ttl time.Duration) error
+	// Release removes the pending record of the key, so that the request can be retried.
+	Release(ctx context.Context, key string) error
+}
+
+type idempotencyEntry struct {
+	response IdempotentResponse
+	expires  time.Time
+}
+
+// memoryIdempotencyStore is an in-process IdempotencyStore, for servers without replicas.
+type memoryIdempotencyStore struct {
+	mu      sync.Mutex
+	entries map[string]idempotencyEntry
+	now     func() time.Time
+	swept   time.Time
+}
+
+// NewMemoryIdempotencyStore creates an in-memory idempotency store.
+func NewMemoryIdempotencyStore() IdempotencyStore {
+	return &memoryIdempotencyStore{entries: make(map[string]idempotencyEntry), now: time.Now}
+}
+
+func (m *memoryIdempotencyStore) Reserve(_ context.Context, key, fingerprint string, lockTimeout time.Duration) (*IdempotentResponse, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := m.now()
+	m.sweep(now)
+	if entry, ok := m.entries[key]; ok && now.Before(entry.expires) {
+		response := entry.response
+		return &response, nil
+	}
+	m.entries[key] = idempotencyEntry{
+		response: IdempotentResponse{Fingerprint: fingerprint, Pending: true},
+		expires:  now.Add(lockTimeout),
+	}
+	return nil, nil
+}
+
+func (m *memoryIdempotencyStore) Complete(_ context.Context, key string, response IdempotentResponse, ttl time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.entries[key] = idempotencyEntry{response: response, expires: m.now().Add(ttl)}
+	return nil
+}
+
+func (m *memoryIdempotencyStore) Release(_ context.Context, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if entry, ok := m.entries[key]; ok && entry.response.Pending {
+		delete(m.entries, key)
+	}
+	return nil
+}
+
+// sweep drops the expired entries, at most once a minute. Must be called with the lock held.
+func (m *memoryIdempotencyStore) sweep(now time.Time) {
+	if now.Sub(m.swept) < time.Minute {
+		return
+	}
+	m.swept = now
+	for key, entry := range m.entries {
+		if !now.Before(entry.expires) {
+			delete(m.entries, key)
+		}
+	}
+}
+
+const redisIdempotencyPrefix = "sargantana:idempotency:"
+
+// redisIdempotencyStore keeps the records as JSON strings expiring with the Redis TTL.
+type redisIdempotencyStore struct {
+	pool *redis.Pool
+}
+
+// NewRedisIdempotencyStore creates an idempotency store shared through Redis.
+func NewRedisIdempotencyStore(pool *redis.Pool) IdempotencyStore {
+	return &redisIdempotencyStore{pool: pool}
+}
+
+func (r *redisIdempotencyStore) Reserve(ctx context.Context, key, fingerprint string, lockTimeout time.Duration) (*IdempotentResponse, error) {
+	conn, err := r.pool.GetContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = conn.Close() }()
+	pending, err := json.Marshal(IdempotentResponse{Fingerprint: fingerprint, Pending: true})
+	if err != nil {
+		return nil, err
+	}
+	// The record may expire between SET NX and GET, in which case the key is reserved again
+	for range 3 {
+		reply, err := redis.String(conn.Do("SET", redisIdempotencyPrefix+key, pending, "NX", "PX", lockTimeout.Milliseconds()))
+		if err == nil && reply == "OK" {
+			return nil, nil
+		}
+		if err != nil && !errors.Is(err, redis.ErrNil) {
+			return nil, errors.Wrap(err, "failed to reserve idempotency key")
+		}
+		stored, err := redis.Bytes(conn.Do("GET", redisIdempotencyPrefix+key))
+		if errors.Is(err, redis.ErrNil) {
+			continue
+		}
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to read idempotency key")
+		}
+		var response IdempotentResponse
+		if err := json.Unmarshal(stored, &response); err != nil {
+			return nil, errors.Wrap(err, "failed to decode idempotency key")
+		}
+		return &response, nil
+	}
+	return nil, errors.New("idempotency key expired repeatedly while being reserved")
+}
+
+func (r *redisIdempotencyStore) Complete(ctx context.Context, key string, response IdempotentResponse, ttl time.Duration) error {
+	conn, err := r.pool.GetContext(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = conn.Close() }()
+	encoded, err := json.Marshal(response)
+	if err != nil {
+		return err
+	}
+	_, err = conn.Do("SET", redisIdempotencyPrefix+key, encoded, "PX", ttl.Milliseconds())
+	return errors.Wrap(err, "failed to store idempotent response")
+}
+
+func (r *redisIdempotencyStore) Release(ctx context.Context, key string) error {
+	conn, err := r.pool.GetContext(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = conn.Close() }()
+	_, err = conn.Do("DEL", redisIdempotencyPrefix+key)
+	return errors.Wrap(err, "failed to release idempotency key")
+}
+
+// postgresIdempotencyStore keeps the records in a table, whose expired rows are deleted at most
+// once a minute.
+type postgresIdempotencyStore struct {
+	pool  *pgxpool.Pool
+	table string
+
+	mu     sync.Mutex
+	purged time.Time
+}
+
+// NewPostgresIdempotencyStore creates an idempotency store shared through a PostgreSQL table,
+// created if it does not exist.
+func NewPostgresIdempotencyStore(ctx context.Context, pool *pgxpool.Pool, table string) (IdempotencyStore, error) {
+	_, err := pool.Exec(ctx, fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+		key TEXT PRIMARY KEY,
+		fingerprint TEXT NOT NULL,
+		pending BOOLEAN NOT NULL,
+		status INT NOT NULL DEFAULT 0,
+		header JSONB NOT NULL DEFAULT '{}',
+		body BYTEA NOT NULL DEFAULT '',
+		expires_at TIMESTAMPTZ NOT NULL
+	)`, table))
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to create idempotency table %s", table)
+	}
+	return &postgresIdempotencyStore{pool: pool, table: table}, nil
+}
+
+func (p *postgresIdempotencyStore) Reserve(ctx context.Context, key, fingerprint string, lockTimeout time.Duration) (*IdempotentResponse, error) {
+	for range 3 {
+		// Expired records are taken over as if they did not exist
+		tag, err := p.pool.Exec(ctx, "INSERT INTO "+p.table+" AS t (key, fingerprint, pending, expires_at) "+
+			"VALUES ($1, $2, true, now() + make_interval(secs => $3)) ON CONFLICT (key) DO UPDATE SET "+
+			"fingerprint = EXCLUDED.fingerprint, pending = true, status = 0, header = '{}', body = '', "+
+			"expires_at = EXCLUDED.expires_at WHERE t.expires_at <= now()",
+			key, fingerprint, lockTimeout.Seconds())
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to reserve idempotency key")
+		}
+		if tag.RowsAffected() == 1 {
+			return nil, nil
+		}
+		var response IdempotentResponse
+		err = p.pool.QueryRow(ctx, "SELECT fingerprint, pending, status, header, body FROM "+p.table+
+			" WHERE key = $1 AND expires_at > now()", key).
+			Scan(&response.Fingerprint, &response.Pending, &response.Status, &response.Header, &response.Body)
+		if errors.Is(err, pgx.ErrNoRows) {
+			continue
+		}
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to read idempotency key")
+		}
+		return &response, nil
+	}
+	return nil, errors.New("idempotency key expired repeatedly while being reserved")
+}
+
+func (p *postgresIdempotencyStore) Complete(ctx context.Context, key string, response IdempotentResponse, ttl time.Duration) error {
+	header := response.Header
+	if header == nil {
+		header = http.Header{}
+	}
+	_, err := p.pool.Exec(ctx, "UPDATE "+p.table+" SET pending = false, status = $2, header = $3, body = $4, "+
+		"expires_at = now() + make_interval(secs => $5) WHERE key = $1",
+		key, response.Status, header, response.Body, ttl.Seconds())
+	if err != nil {
+		return errors.Wrap(err, "failed to store idempotent response")
+	}
+	p.purge(ctx)
+	return nil
+}
+
+func (p *postgresIdempotencyStore) Release(ctx context.Context, key string) error {
+	_, err := p.pool.Exec(ctx, "DELETE FROM "+p.table+" WHERE key = $1 AND pending", key)
+	return errors.Wrap(err, "failed to release idempotency key")
+}
+
+// purge deletes the expired records, at most once a minute
+func (p *postgresIdempotencyStore) purge(ctx context.Context) {
+	p.mu.Lock()
+	if time.Since(p.purged) < time.Minute {
+		p.mu.Unlock()
+		return
+	}
+	p.purged = time.Now()
+	p.mu.Unlock()
+	if _, err := p.pool.Exec(ctx, "DELETE FROM "+p.table+" WHERE expires_at <= now()"); err != nil {
+		log.Warn().Err(err).Str("table", p.table).Msg("Failed to purge expired idempotency keys")
+	}
+}
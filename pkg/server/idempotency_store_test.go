@@ -0,0 +1,66 @@
+//go:build unit
+
+package server
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Memory idempotency store", func() {
+	var (
+		store *memoryIdempotencyStore
+		now   time.Time
+		ctx   = context.Background()
+	)
+
+	BeforeEach(func() {
+		store = NewMemoryIdempotencyStore().(*memoryIdempotencyStore)
+		now = time.Now()
+		store.now = func() time.Time { return now }
+	})
+
+	It("should reserve keys once", func() {
+		Expect(store.Reserve(ctx, "key", "fp", time.Minute)).To(BeNil())
+		Expect(store.Reserve(ctx, "key", "other", time.Minute)).To(Equal(&IdempotentResponse{Fingerprint: "fp", Pending: true}))
+	})
+
+	It("should keep completed responses for the ttl", func() {
+		Expect(store.Reserve(ctx, "key", "fp", time.Minute)).To(BeNil())
+		response := IdempotentResponse{Fingerprint: "fp", Status: http.StatusCreated, Body: []byte("created")}
+		Expect(store.Complete(ctx, "key", response, time.Hour)).To(Succeed())
+
+		now = now.Add(30 * time.Minute)
+		Expect(store.Reserve(ctx, "key", "fp", time.Minute)).To(Equal(&response))
+		now = now.Add(time.Hour)
+		Expect(store.Reserve(ctx, "key", "fp", time.Minute)).To(BeNil())
+	})
+
+	It("should expire pending keys after the lock timeout", func() {
+		Expect(store.Reserve(ctx, "key", "fp", time.Minute)).To(BeNil())
+		now = now.Add(2 * time.Minute)
+		Expect(store.Reserve(ctx, "key", "fp", time.Minute)).To(BeNil())
+	})
+
+	It("should only release pending keys", func() {
+		Expect(store.Reserve(ctx, "pending", "fp", time.Minute)).To(BeNil())
+		Expect(store.Release(ctx, "pending")).To(Succeed())
+		Expect(store.Reserve(ctx, "pending", "fp", time.Minute)).To(BeNil())
+
+		Expect(store.Complete(ctx, "done", IdempotentResponse{Fingerprint: "fp", Status: http.StatusOK}, time.Hour)).To(Succeed())
+		Expect(store.Release(ctx, "done")).To(Succeed())
+		Expect(store.Reserve(ctx, "done", "fp", time.Minute)).NotTo(BeNil())
+	})
+
+	It("should sweep expired entries", func() {
+		Expect(store.Reserve(ctx, "old", "fp", time.Minute)).To(BeNil())
+		now = now.Add(2 * time.Minute)
+		Expect(store.Reserve(ctx, "new", "fp", time.Minute)).To(BeNil())
+		Expect(store.entries).To(HaveLen(1))
+		Expect(store.entries).To(HaveKey("new"))
+	})
+})
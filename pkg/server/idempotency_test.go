@@ -0,0 +1,196 @@
+//go:build unit
+
+package server
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+type failingIdempotencyStore struct {
+	IdempotencyStore
+}
+
+func (failingIdempotencyStore) Reserve(context.Context, string, string, time.Duration) (*IdempotentResponse, error) {
+	return nil, errors.New("store unavailable")
+}
+
+var _ = Describe("Idempotency", func() {
+	Context("IdempotencyConfig Validate", func() {
+		It("should accept the defaults and unsafe methods", func() {
+			Expect(IdempotencyConfig{}.Validate()).To(Succeed())
+			Expect(IdempotencyConfig{Methods: []string{"PUT", "DELETE"}, Table: "api.idempotency"}.Validate()).To(Succeed())
+		})
+
+		It("should reject invalid settings", func() {
+			Expect(IdempotencyConfig{TTL: -time.Second}.Validate()).NotTo(Succeed())
+			Expect(IdempotencyConfig{Methods: []string{"GET"}}.Validate()).To(MatchError(ContainSubstring("unsupported method")))
+			Expect(IdempotencyConfig{Table: "keys; DROP TABLE users"}.Validate()).To(MatchError(ContainSubstring("invalid table name")))
+		})
+	})
+
+	Context("Middleware", func() {
+		var (
+			engine *gin.Engine
+			calls  int
+			status int
+			store  IdempotencyStore
+			config IdempotencyConfig
+		)
+
+		BeforeEach(func() {
+			calls, status = 0, http.StatusCreated
+			store, config = NewMemoryIdempotencyStore(), IdempotencyConfig{}
+		})
+
+		JustBeforeEach(func() {
+			engine = gin.New()
+			engine.Use(func(c *gin.Context) {
+				c.Header("X-Outer", "set-before")
+				if user := c.GetHeader("X-User"); user != "" {
+					c.Set(RemoteUserKey, user)
+				}
+			})
+			engine.Use(newIdempotency(config, store).middleware())
+			handler := func(c *gin.Context) {
+				calls++
+				c.Header("Location", "/orders/42")
+				c.String(status, "order %d", calls)
+			}
+			engine.POST("/orders", handler)
+			engine.PUT("/orders", handler)
+			engine.POST("/panic", func(c *gin.Context) {
+				calls++
+				panic("boom")
+			})
+		})
+
+		request := func(method, path, key, body string, headers ...string) *httptest.ResponseRecorder {
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest(method, path, strings.NewReader(body))
+			if key != "" {
+				req.Header.Set(IdempotencyKeyHeader, key)
+			}
+			for i := 0; i < len(headers); i += 2 {
+				req.Header.Set(headers[i], headers[i+1])
+			}
+			engine.ServeHTTP(w, req)
+			return w
+		}
+
+		It("should replay the response to retries", func() {
+			first := request(http.MethodPost, "/orders", "key-1", `{"item":1}`)
+			Expect(first.Code).To(Equal(http.StatusCreated))
+			Expect(first.Body.String()).To(Equal("order 1"))
+			Expect(first.Header().Get(IdempotentReplayedHeader)).To(BeEmpty())
+
+			retry := request(http.MethodPost, "/orders", "key-1", `{"item":1}`)
+			Expect(retry.Code).To(Equal(http.StatusCreated))
+			Expect(retry.Body.String()).To(Equal("order 1"))
+			Expect(retry.Header().Get("Location")).To(Equal("/orders/42"))
+			Expect(retry.Header().Get("Content-Type")).To(Equal("text/plain; charset=utf-8"))
+			Expect(retry.Header().Get(IdempotentReplayedHeader)).To(Equal("true"))
+			Expect(calls).To(Equal(1))
+		})
+
+		It("should run requests without key or with other methods", func() {
+			request(http.MethodPost, "/orders", "", "")
+			request(http.MethodPost, "/orders", "", "")
+			request(http.MethodPut, "/orders", "key-1", "")
+			request(http.MethodPut, "/orders", "key-1", "")
+			Expect(calls).To(Equal(4))
+		})
+
+		It("should answer 422 to keys reused for different requests", func() {
+			request(http.MethodPost, "/orders", "key-1", `{"item":1}`)
+			Expect(request(http.MethodPost, "/orders", "key-1", `{"item":2}`).Code).To(Equal(http.StatusUnprocessableEntity))
+			Expect(request(http.MethodPost, "/orders?dry_run=true", "key-1", `{"item":1}`).Code).To(Equal(http.StatusUnprocessableEntity))
+			Expect(calls).To(Equal(1))
+		})
+
+		It("should answer 409 while the first request is in progress", func() {
+			fingerprint := hashParts(http.MethodPost, "/orders", "", "")
+			Expect(store.Reserve(context.Background(), hashParts("", http.MethodPost, "/orders", "key-1"), fingerprint, time.Minute)).To(BeNil())
+			response := request(http.MethodPost, "/orders", "key-1", "")
+			Expect(response.Code).To(Equal(http.StatusConflict))
+			Expect(response.Header().Get("Retry-After")).To(Equal("1"))
+			Expect(calls).To(BeZero())
+		})
+
+		It("should scope keys to the authenticated user", func() {
+			request(http.MethodPost, "/orders", "key-1", "", "X-User", "alice")
+			response := request(http.MethodPost, "/orders", "key-1", "", "X-User", "bob")
+			Expect(response.Header().Get(IdempotentReplayedHeader)).To(BeEmpty())
+			Expect(calls).To(Equal(2))
+		})
+
+		It("should let the retries of server errors run again", func() {
+			status = http.StatusBadGateway
+			Expect(request(http.MethodPost, "/orders", "key-1", "").Code).To(Equal(http.StatusBadGateway))
+			status = http.StatusCreated
+			Expect(request(http.MethodPost, "/orders", "key-1", "").Code).To(Equal(http.StatusCreated))
+			Expect(calls).To(Equal(2))
+		})
+
+		It("should release the key when the handler panics", func() {
+			Expect(func() { request(http.MethodPost, "/panic", "key-1", "") }).To(Panic())
+			Expect(func() { request(http.MethodPost, "/panic", "key-1", "") }).To(Panic())
+			Expect(calls).To(Equal(2))
+		})
+
+		It("should not replay the headers set by outer middlewares", func() {
+			request(http.MethodPost, "/orders", "key-1", "")
+			stored, err := store.Reserve(context.Background(), hashParts("", http.MethodPost, "/orders", "key-1"), "", time.Minute)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(stored.Header).To(HaveKey("Location"))
+			Expect(stored.Header).NotTo(HaveKey("X-Outer"))
+		})
+
+		It("should reject overlong keys", func() {
+			Expect(request(http.MethodPost, "/orders", strings.Repeat("k", 256), "").Code).To(Equal(http.StatusBadRequest))
+			Expect(calls).To(BeZero())
+		})
+
+		Context("with large responses", func() {
+			BeforeEach(func() {
+				config.MaxBodySize = 4
+			})
+
+			It("should not store them", func() {
+				request(http.MethodPost, "/orders", "key-1", "")
+				request(http.MethodPost, "/orders", "key-1", "")
+				Expect(calls).To(Equal(2))
+			})
+		})
+
+		Context("with required keys", func() {
+			BeforeEach(func() {
+				config.Required = true
+			})
+
+			It("should reject requests without key", func() {
+				Expect(request(http.MethodPost, "/orders", "", "").Code).To(Equal(http.StatusBadRequest))
+				Expect(request(http.MethodPut, "/orders", "", "").Code).To(Equal(http.StatusCreated))
+			})
+		})
+
+		Context("with a failing store", func() {
+			BeforeEach(func() {
+				store = failingIdempotencyStore{}
+			})
+
+			It("should answer 503 without running the request", func() {
+				Expect(request(http.MethodPost, "/orders", "key-1", "").Code).To(Equal(http.StatusServiceUnavailable))
+				Expect(calls).To(BeZero())
+			})
+		})
+	})
+})
@@ -0,0 +1,400 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+)
+
+// IPFilterConfig configures the server-wide IP filtering middleware.
+// Requests are checked against static allow/deny lists, periodically refreshed
+// denylist feeds and, optionally, a local reputation store.
+type IPFilterConfig struct {
+	Allow      []string             `yaml:"allow,omitempty"` // If set, only these IPs/CIDRs are accepted
+	Deny       []string             `yaml:"deny,omitempty"`
+	Feeds      []DenylistFeedConfig `yaml:"feeds,omitempty"`
+	Reputation *ReputationConfig    `yaml:"reputation,omitempty"`
+}
+
+func (c IPFilterConfig) Validate() error {
+	if _, err := parseIPNets(c.Allow); err != nil {
+		return errors.Wrap(err, "invalid allow entry")
+	}
+	if _, err := parseIPNets(c.Deny); err != nil {
+		return errors.Wrap(err, "invalid deny entry")
+	}
+	for i, feed := range c.Feeds {
+		if err := feed.Validate(); err != nil {
+			return errors.Wrapf(err, "invalid feed at index %d", i)
+		}
+	}
+	if c.Reputation != nil {
+		if err := c.Reputation.Validate(); err != nil {
+			return errors.Wrap(err, "invalid reputation configuration")
+		}
+	}
+	return nil
+}
+
+// DenylistFeedConfig describes an external denylist. Source is either a local file path
+// or an http(s) URL returning one IP or CIDR per line. Lines starting with '#' or ';'
+// are treated as comments and only the first field of each line is considered.
+type DenylistFeedConfig struct {
+	Source          string        `yaml:"source"`
+	RefreshInterval time.Duration `yaml:"refresh_interval,omitempty"` // Zero loads the feed only once
+}
+
+func (c DenylistFeedConfig) Validate() error {
+	if c.Source == "" {
+		return errors.New("source must be set and non-empty")
+	}
+	if c.RefreshInterval < 0 {
+		return errors.New("refresh_interval must be non-negative")
+	}
+	return nil
+}
+
+// ReputationConfig enables the local reputation store. Addresses whose accumulated
+// penalty reaches Threshold are rejected until their record expires after TTL
+// without new reports. The basic and JWT authenticators report each failed
+// authentication with a penalty of one, see ReportAbuse.
+type ReputationConfig struct {
+	Threshold int           `yaml:"threshold"`
+	TTL       time.Duration `yaml:"ttl"`
+}
+
+func (c ReputationConfig) Validate() error {
+	if c.Threshold <= 0 {
+		return errors.New("threshold must be greater than zero")
+	}
+	if c.TTL <= 0 {
+		return errors.New("ttl must be greater than zero")
+	}
+	return nil
+}
+
+// ReputationStore keeps track of misbehaving client addresses. Subsystems that detect
+// abuse (failed logins, honeypot hits, lockouts...) report penalties against an IP and
+// the IP filtering middleware consults the accumulated score on every request.
+type ReputationStore interface {
+	// Report adds penalty points to the given IP address.
+	Report(ip string, penalty int)
+	// Score returns the current penalty score for the given IP address.
+	Score(ip string) int
+}
+
+// reputationKey is the context key of the reputation store of the IP filter
+const reputationKey = "sargantana.reputation"
+
+// failedAuthPenalty is the penalty reported for each failed authentication
+const failedAuthPenalty = 1
+
+// ReportAbuse adds penalty points to the client address of the request in the reputation
+// store of the server. It does nothing unless reputation tracking is enabled.
+func ReportAbuse(c *gin.Context, penalty int) {
+	store, ok := c.Value(reputationKey).(ReputationStore)
+	if !ok {
+		return
+	}
+	if ip := net.ParseIP(c.ClientIP()); ip != nil {
+		store.Report(ip.String(), penalty)
+	}
+}
+
+type reputationEntry struct {
+	score   int
+	expires time.Time
+}
+
+// memoryReputationStore is an in-process ReputationStore whose records expire after
+// a fixed TTL since the last report. Expired records are swept on reports at most
+// once per TTL.
+type memoryReputationStore struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	entries map[string]*reputationEntry
+	now     func() time.Time
+	sweptAt time.Time
+}
+
+// NewMemoryReputationStore creates an in-memory reputation store whose records
+// are forgotten ttl after the last penalty was reported.
+func NewMemoryReputationStore(ttl time.Duration) ReputationStore {
+	return &memoryReputationStore{
+		ttl:     ttl,
+		entries: make(map[string]*reputationEntry),
+		now:     time.Now,
+	}
+}
+
+func (m *memoryReputationStore) Report(ip string, penalty int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := m.now()
+	if m.sweptAt.IsZero() {
+		m.sweptAt = now
+	} else if now.Sub(m.sweptAt) > m.ttl {
+		for reportedIP, entry := range m.entries {
+			if now.After(entry.expires) {
+				delete(m.entries, reportedIP)
+			}
+		}
+		m.sweptAt = now
+	}
+	entry, ok := m.entries[ip]
+	if !ok || now.After(entry.expires) {
+		entry = &reputationEntry{}
+		m.entries[ip] = entry
+	}
+	entry.score += penalty
+	entry.expires = now.Add(m.ttl)
+}
+
+func (m *memoryReputationStore) Score(ip string) int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	entry, ok := m.entries[ip]
+	if !ok {
+		return 0
+	}
+	if m.now().After(entry.expires) {
+		delete(m.entries, ip)
+		return 0
+	}
+	return entry.score
+}
+
+// denylistFeed holds the last successfully loaded contents of a denylist source.
+type denylistFeed struct {
+	source   string
+	interval time.Duration
+	client   *http.Client
+	mu       sync.RWMutex
+	nets     []*net.IPNet
+}
+
+func (f *denylistFeed) contains(ip net.IP) bool {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return containsIP(f.nets, ip)
+}
+
+// load fetches and parses the feed. On failure the previously loaded entries are kept.
+func (f *denylistFeed) load() error {
+	var reader io.ReadCloser
+	if strings.HasPrefix(f.source, "http://") || strings.HasPrefix(f.source, "https://") {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		request, err := http.NewRequestWithContext(ctx, http.MethodGet, f.source, nil)
+		if err != nil {
+			return err
+		}
+		response, err := f.client.Do(request)
+		if err != nil {
+			return err
+		}
+		if response.StatusCode != http.StatusOK {
+			_ = response.Body.Close()
+			return errors.Errorf("unexpected status code %d", response.StatusCode)
+		}
+		reader = response.Body
+	} else {
+		// #nosec G304 -- Feed path is provided by the operator in the configuration
+		file, err := os.Open(f.source)
+		if err != nil {
+			return err
+		}
+		reader = file
+	}
+	defer func() {
+		if err := reader.Close(); err != nil {
+			log.Error().Err(err).Str("source", f.source).Msg("Error closing denylist feed")
+		}
+	}()
+
+	nets, err := parseDenylist(reader)
+	if err != nil {
+		return err
+	}
+
+	f.mu.Lock()
+	f.nets = nets
+	f.mu.Unlock()
+	log.Info().Str("source", f.source).Int("entries", len(nets)).Msg("Denylist feed loaded")
+	return nil
+}
+
+func parseDenylist(r io.Reader) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";") {
+			continue
+		}
+		ipNet, err := parseIPNet(strings.Fields(line)[0])
+		if err != nil {
+			log.Debug().Str("entry", line).Msg("Skipping invalid denylist entry")
+			continue
+		}
+		nets = append(nets, ipNet)
+	}
+	return nets, scanner.Err()
+}
+
+// ipFilter implements the IP filtering middleware.
+type ipFilter struct {
+	allow      []*net.IPNet
+	deny       []*net.IPNet
+	feeds      []*denylistFeed
+	reputation ReputationStore
+	threshold  int
+	stop       chan struct{}
+	wg         sync.WaitGroup
+}
+
+func newIPFilter(c IPFilterConfig, reputation ReputationStore) (*ipFilter, error) {
+	allow, err := parseIPNets(c.Allow)
+	if err != nil {
+		return nil, err
+	}
+	deny, err := parseIPNets(c.Deny)
+	if err != nil {
+		return nil, err
+	}
+
+	filter := &ipFilter{
+		allow:      allow,
+		deny:       deny,
+		reputation: reputation,
+		stop:       make(chan struct{}),
+	}
+	if c.Reputation != nil {
+		filter.threshold = c.Reputation.Threshold
+	}
+
+	client := &http.Client{Timeout: 30 * time.Second}
+	for _, feedCfg := range c.Feeds {
+		feed := &denylistFeed{source: feedCfg.Source, interval: feedCfg.RefreshInterval, client: client}
+		if err := feed.load(); err != nil {
+			log.Error().Err(err).Str("source", feed.source).Msg("Failed to load denylist feed")
+		}
+		filter.feeds = append(filter.feeds, feed)
+		if feed.interval > 0 {
+			filter.wg.Add(1)
+			go filter.refresh(feed)
+		}
+	}
+	return filter, nil
+}
+
+func (f *ipFilter) refresh(feed *denylistFeed) {
+	defer f.wg.Done()
+	ticker := time.NewTicker(feed.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-f.stop:
+			return
+		case <-ticker.C:
+			if err := feed.load(); err != nil {
+				log.Error().Err(err).Str("source", feed.source).Msg("Failed to refresh denylist feed, keeping previous entries")
+			}
+		}
+	}
+}
+
+// blocked reports whether the given client address must be rejected and why.
+func (f *ipFilter) blocked(clientIP string) (bool, string) {
+	ip := net.ParseIP(clientIP)
+	if ip == nil {
+		return true, "unparseable client address"
+	}
+	if len(f.allow) > 0 && !containsIP(f.allow, ip) {
+		return true, "not in allow list"
+	}
+	if containsIP(f.deny, ip) {
+		return true, "in deny list"
+	}
+	for _, feed := range f.feeds {
+		if feed.contains(ip) {
+			return true, fmt.Sprintf("in denylist feed %s", feed.source)
+		}
+	}
+	if f.reputation != nil && f.threshold > 0 && f.reputation.Score(ip.String()) >= f.threshold {
+		return true, "reputation threshold exceeded"
+	}
+	return false, ""
+}
+
+func (f *ipFilter) middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if f.reputation != nil {
+			c.Set(reputationKey, f.reputation)
+		}
+		if blocked, reason := f.blocked(c.ClientIP()); blocked {
+			log.Debug().Str("client_ip", c.ClientIP()).Str("reason", reason).Msg("Request rejected by IP filter")
+			c.AbortWithStatus(http.StatusForbidden)
+			return
+		}
+		c.Next()
+	}
+}
+
+// Close stops background feed refreshes.
+func (f *ipFilter) Close() error {
+	close(f.stop)
+	f.wg.Wait()
+	return nil
+}
+
+func parseIPNets(entries []string) ([]*net.IPNet, error) {
+	nets := make([]*net.IPNet, 0, len(entries))
+	for _, entry := range entries {
+		ipNet, err := parseIPNet(entry)
+		if err != nil {
+			return nil, err
+		}
+		nets = append(nets, ipNet)
+	}
+	return nets, nil
+}
+
+// parseIPNet accepts either a CIDR or a single IP address, which is turned into a host network.
+func parseIPNet(entry string) (*net.IPNet, error) {
+	if strings.Contains(entry, "/") {
+		_, ipNet, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid CIDR %q", entry)
+		}
+		return ipNet, nil
+	}
+	ip := net.ParseIP(entry)
+	if ip == nil {
+		return nil, errors.Errorf("invalid IP address %q", entry)
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		return &net.IPNet{IP: ip4, Mask: net.CIDRMask(32, 32)}, nil
+	}
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(128, 128)}, nil
+}
+
+func containsIP(nets []*net.IPNet, ip net.IP) bool {
+	for _, ipNet := range nets {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,196 @@
+//go:build unit
+
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("IP Filter", func() {
+	Context("IPFilterConfig Validate", func() {
+		It("should accept IPs and CIDRs", func() {
+			cfg := IPFilterConfig{Allow: []string{"10.0.0.0/8", "127.0.0.1"}, Deny: []string{"::1"}}
+			Expect(cfg.Validate()).To(Succeed())
+		})
+
+		It("should reject invalid entries", func() {
+			Expect(IPFilterConfig{Deny: []string{"not-an-ip"}}.Validate()).NotTo(Succeed())
+			Expect(IPFilterConfig{Allow: []string{"10.0.0.0/99"}}.Validate()).NotTo(Succeed())
+		})
+
+		It("should reject feeds without source and invalid reputation settings", func() {
+			Expect(IPFilterConfig{Feeds: []DenylistFeedConfig{{}}}.Validate()).NotTo(Succeed())
+			Expect(IPFilterConfig{Reputation: &ReputationConfig{Threshold: 0, TTL: time.Minute}}.Validate()).NotTo(Succeed())
+			Expect(IPFilterConfig{Reputation: &ReputationConfig{Threshold: 1}}.Validate()).NotTo(Succeed())
+		})
+	})
+
+	Context("Memory reputation store", func() {
+		It("should accumulate penalties and expire them", func() {
+			store := NewMemoryReputationStore(time.Minute).(*memoryReputationStore)
+			now := time.Now()
+			store.now = func() time.Time { return now }
+
+			store.Report("1.2.3.4", 2)
+			store.Report("1.2.3.4", 3)
+			Expect(store.Score("1.2.3.4")).To(Equal(5))
+			Expect(store.Score("4.3.2.1")).To(Equal(0))
+
+			now = now.Add(2 * time.Minute)
+			Expect(store.Score("1.2.3.4")).To(Equal(0))
+		})
+
+		It("should sweep the expired records of addresses never looked up", func() {
+			store := NewMemoryReputationStore(time.Minute).(*memoryReputationStore)
+			now := time.Now()
+			store.now = func() time.Time { return now }
+
+			store.Report("1.2.3.4", 1)
+			now = now.Add(2 * time.Minute)
+			store.Report("4.3.2.1", 1)
+			Expect(store.entries).To(HaveLen(1))
+			Expect(store.entries).To(HaveKey("4.3.2.1"))
+		})
+	})
+
+	Context("Middleware", func() {
+		var engine *gin.Engine
+
+		request := func(ip string) int {
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.RemoteAddr = ip + ":12345"
+			engine.ServeHTTP(w, req)
+			return w.Code
+		}
+
+		newEngine := func(filter *ipFilter) {
+			gin.SetMode(gin.TestMode)
+			engine = gin.New()
+			engine.Use(filter.middleware())
+			engine.GET("/", func(c *gin.Context) { c.Status(http.StatusOK) })
+		}
+
+		It("should apply allow and deny lists", func() {
+			filter, err := newIPFilter(IPFilterConfig{Allow: []string{"10.0.0.0/8"}, Deny: []string{"10.0.0.66"}}, nil)
+			Expect(err).NotTo(HaveOccurred())
+			defer filter.Close()
+			newEngine(filter)
+
+			Expect(request("10.1.2.3")).To(Equal(http.StatusOK))
+			Expect(request("10.0.0.66")).To(Equal(http.StatusForbidden))
+			Expect(request("192.168.1.1")).To(Equal(http.StatusForbidden))
+		})
+
+		It("should load denylist feeds from files", func() {
+			dir := GinkgoT().TempDir()
+			feedPath := filepath.Join(dir, "denylist.txt")
+			Expect(os.WriteFile(feedPath, []byte("# comment\n192.0.2.0/24 spam\n\n198.51.100.7\ngarbage\n"), 0600)).To(Succeed())
+
+			filter, err := newIPFilter(IPFilterConfig{Feeds: []DenylistFeedConfig{{Source: feedPath}}}, nil)
+			Expect(err).NotTo(HaveOccurred())
+			defer filter.Close()
+			newEngine(filter)
+
+			Expect(request("192.0.2.10")).To(Equal(http.StatusForbidden))
+			Expect(request("198.51.100.7")).To(Equal(http.StatusForbidden))
+			Expect(request("198.51.100.8")).To(Equal(http.StatusOK))
+		})
+
+		It("should refresh denylist feeds from URLs", func() {
+			var entries atomic.Value
+			entries.Store("203.0.113.1\n")
+			feedServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				_, _ = fmt.Fprint(w, entries.Load())
+			}))
+			defer feedServer.Close()
+
+			filter, err := newIPFilter(IPFilterConfig{
+				Feeds: []DenylistFeedConfig{{Source: feedServer.URL, RefreshInterval: 20 * time.Millisecond}},
+			}, nil)
+			Expect(err).NotTo(HaveOccurred())
+			defer filter.Close()
+			newEngine(filter)
+
+			Expect(request("203.0.113.1")).To(Equal(http.StatusForbidden))
+			Expect(request("203.0.113.2")).To(Equal(http.StatusOK))
+
+			entries.Store("203.0.113.2\n")
+			Eventually(func() int { return request("203.0.113.2") }).Should(Equal(http.StatusForbidden))
+		})
+
+		It("should keep previous entries when a feed fails to load", func() {
+			feed := &denylistFeed{source: filepath.Join(GinkgoT().TempDir(), "missing.txt")}
+			Expect(feed.load()).NotTo(Succeed())
+			Expect(feed.contains([]byte{127, 0, 0, 1})).To(BeFalse())
+		})
+
+		It("should reject addresses above the reputation threshold", func() {
+			store := NewMemoryReputationStore(time.Minute)
+			filter, err := newIPFilter(IPFilterConfig{Reputation: &ReputationConfig{Threshold: 3, TTL: time.Minute}}, store)
+			Expect(err).NotTo(HaveOccurred())
+			defer filter.Close()
+			newEngine(filter)
+
+			store.Report("192.0.2.1", 2)
+			Expect(request("192.0.2.1")).To(Equal(http.StatusOK))
+			store.Report("192.0.2.1", 1)
+			Expect(request("192.0.2.1")).To(Equal(http.StatusForbidden))
+		})
+	})
+
+	Context("Failed authentications", func() {
+		It("should reject addresses failing to authenticate too often", func() {
+			htpasswd := filepath.Join(GinkgoT().TempDir(), "htpasswd")
+			Expect(os.WriteFile(htpasswd, []byte("carol:{SHA}5en6G6MezRroT3XKqkdPOmY/BfQ=\n"), 0o600)).To(Succeed())
+			authenticator, err := NewBasicAuthenticator(BasicAuthenticatorConfig{HtpasswdFile: htpasswd}, nil)
+			Expect(err).NotTo(HaveOccurred())
+			store := NewMemoryReputationStore(time.Minute)
+			filter, err := newIPFilter(IPFilterConfig{Reputation: &ReputationConfig{Threshold: 2, TTL: time.Minute}}, store)
+			Expect(err).NotTo(HaveOccurred())
+			defer filter.Close()
+
+			gin.SetMode(gin.TestMode)
+			engine := gin.New()
+			engine.Use(filter.middleware())
+			engine.GET("/", authenticator.Middleware(), func(c *gin.Context) { c.Status(http.StatusOK) })
+			request := func(password string) int {
+				w := httptest.NewRecorder()
+				req := httptest.NewRequest(http.MethodGet, "/", nil)
+				req.RemoteAddr = "192.0.2.1:12345"
+				if password != "" {
+					req.SetBasicAuth("carol", password)
+				}
+				engine.ServeHTTP(w, req)
+				return w.Code
+			}
+
+			// Requests without credentials are not penalized
+			Expect(request("")).To(Equal(http.StatusUnauthorized))
+			Expect(store.Score("192.0.2.1")).To(Equal(0))
+			Expect(request("wrong")).To(Equal(http.StatusUnauthorized))
+			Expect(request("wrong")).To(Equal(http.StatusUnauthorized))
+			Expect(request("wrong")).To(Equal(http.StatusForbidden))
+		})
+	})
+
+	Context("Server integration", func() {
+		It("should expose the reputation store when enabled", func() {
+			srv := NewServer(SargantanaConfig{WebServerConfig: WebServerConfig{
+				IPFilter: &IPFilterConfig{Reputation: &ReputationConfig{Threshold: 1, TTL: time.Minute}},
+			}})
+			Expect(srv.ReputationStore()).NotTo(BeNil())
+			Expect(NewServer(SargantanaConfig{}).ReputationStore()).To(BeNil())
+		})
+	})
+})
@@ -0,0 +1,244 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/go-jose/go-jose/v4"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// jwksMinRefreshInterval limits how often unknown key IDs trigger a download of the key set,
+	// and how often failed background downloads are retried.
+	jwksMinRefreshInterval     = time.Minute
+	defaultJWKSRefreshInterval = 15 * time.Minute
+	defaultJWKSMaxStale        = 24 * time.Hour
+	// jwksMaxSize bounds the size of the downloaded key sets
+	jwksMaxSize = 1 << 20
+)
+
+// jwks resolves the verification keys of tokens from a JSON Web Key Set, downloaded in the
+// background and again when a token refers to an unknown key. The last downloaded set is used
+// while the downloads fail, until it is older than maxStale.
+type jwks struct {
+	url    string
+	client *http.Client
+	// algorithms are the accepted algorithms: keys declaring another algorithm are ignored
+	algorithms []string
+	// refreshInterval is the interval of the background downloads
+	refreshInterval time.Duration
+	// maxStale is the age after which the set is no longer used, unlimited if zero
+	maxStale time.Duration
+	// cacheFile is where the downloaded set is saved, none if empty
+	cacheFile string
+
+	fetchMu   sync.Mutex // Serializes the downloads
+	mu        sync.Mutex
+	keys      jose.JSONWebKeySet
+	fetched   time.Time // Last download attempt
+	refreshed time.Time // Last successful download
+	stop      chan struct{}
+	wg        sync.WaitGroup
+}
+
+// newJWKS creates the key set of the configuration, loading the cache file if any, and starts its
+// background downloads. The first download does not block, so that the server starts while the
+// issuer is unreachable.
+func newJWKS(c JWTAuthenticatorConfig, algorithms []string) *jwks {
+	j := &jwks{
+		url:             c.JWKSURL,
+		client:          &http.Client{Timeout: 10 * time.Second},
+		algorithms:      algorithms,
+		refreshInterval: c.JWKSRefreshInterval,
+		maxStale:        c.JWKSMaxStale,
+		cacheFile:       c.JWKSCacheFile,
+		stop:            make(chan struct{}),
+	}
+	if j.refreshInterval == 0 {
+		j.refreshInterval = defaultJWKSRefreshInterval
+	}
+	if j.maxStale == 0 {
+		j.maxStale = defaultJWKSMaxStale
+	}
+	if j.cacheFile != "" {
+		if err := j.load(); err != nil && !errors.Is(err, os.ErrNotExist) {
+			log.Warn().Err(err).Str("file", j.cacheFile).Msg("Ignoring JWKS cache file")
+		}
+	}
+	j.wg.Add(1)
+	go j.refreshLoop()
+	return j
+}
+
+func (j *jwks) key(token *jwt.Token) (any, error) {
+	kid, _ := token.Header["kid"].(string)
+	key, err := j.lookup(kid)
+	if err != nil {
+		if fetchErr := j.refresh(jwksMinRefreshInterval); fetchErr != nil {
+			log.Warn().Err(fetchErr).Str("url", j.url).Msg("Failed to download JWKS")
+		}
+		if key, err = j.lookup(kid); err != nil {
+			return nil, err
+		}
+	}
+	if key.Algorithm != "" && key.Algorithm != token.Method.Alg() {
+		return nil, errors.Errorf("token algorithm %s does not match the algorithm %s of key %q", token.Method.Alg(), key.Algorithm, kid)
+	}
+	return key.Key, nil
+}
+
+// lookup finds the signing key with the given ID. Tokens without an ID are accepted if the set
+// has a single signing key.
+func (j *jwks) lookup(kid string) (jose.JSONWebKey, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.maxStale > 0 && !j.refreshed.IsZero() && time.Since(j.refreshed) > j.maxStale {
+		return jose.JSONWebKey{}, errors.Errorf("JWKS expired, last downloaded at %s", j.refreshed.Format(time.RFC3339))
+	}
+	var candidates []jose.JSONWebKey
+	for _, key := range j.keys.Keys {
+		if key.Use == "enc" || (key.Algorithm != "" && len(j.algorithms) > 0 && !slices.Contains(j.algorithms, key.Algorithm)) {
+			continue
+		}
+		if kid == "" || key.KeyID == kid {
+			candidates = append(candidates, key)
+		}
+	}
+	if len(candidates) != 1 {
+		return jose.JSONWebKey{}, errors.Errorf("unknown key %q", kid)
+	}
+	return candidates[0], nil
+}
+
+// refresh downloads the set unless it was attempted less than minInterval ago, e.g. by a
+// concurrent refresh
+func (j *jwks) refresh(minInterval time.Duration) error {
+	j.fetchMu.Lock()
+	defer j.fetchMu.Unlock()
+	j.mu.Lock()
+	if time.Since(j.fetched) < minInterval {
+		j.mu.Unlock()
+		return nil
+	}
+	j.fetched = time.Now()
+	j.mu.Unlock()
+
+	raw, keys, err := j.download()
+	if err != nil {
+		return err
+	}
+	j.mu.Lock()
+	j.keys, j.refreshed = keys, time.Now()
+	j.mu.Unlock()
+	log.Debug().Str("url", j.url).Int("keys", len(keys.Keys)).Msg("JWKS downloaded")
+	if j.cacheFile != "" {
+		if err := writeFileAtomically(j.cacheFile, raw); err != nil {
+			log.Warn().Err(err).Str("file", j.cacheFile).Msg("Failed to save JWKS cache file")
+		}
+	}
+	return nil
+}
+
+func (j *jwks) download() ([]byte, jose.JSONWebKeySet, error) {
+	var keys jose.JSONWebKeySet
+	response, err := j.client.Get(j.url)
+	if err != nil {
+		return nil, keys, errors.Wrap(err, "failed to download JWKS")
+	}
+	defer func() { _ = response.Body.Close() }()
+	if response.StatusCode != http.StatusOK {
+		return nil, keys, fmt.Errorf("failed to download JWKS: unexpected status %d", response.StatusCode)
+	}
+	raw, err := io.ReadAll(io.LimitReader(response.Body, jwksMaxSize))
+	if err != nil {
+		return nil, keys, errors.Wrap(err, "failed to download JWKS")
+	}
+	if err := json.Unmarshal(raw, &keys); err != nil {
+		return nil, keys, errors.Wrap(err, "invalid JWKS")
+	}
+	return raw, keys, nil
+}
+
+// load reads the set saved in the cache file, considered downloaded when the file was written
+func (j *jwks) load() error {
+	info, err := os.Stat(j.cacheFile)
+	if err != nil {
+		return err
+	}
+	// #nosec G304 -- Cache file path is provided by operator configuration
+	raw, err := os.ReadFile(j.cacheFile)
+	if err != nil {
+		return err
+	}
+	var keys jose.JSONWebKeySet
+	if err := json.Unmarshal(raw, &keys); err != nil {
+		return errors.Wrap(err, "invalid JWKS")
+	}
+	j.keys, j.refreshed = keys, info.ModTime()
+	log.Info().Str("file", j.cacheFile).Time("downloaded_at", j.refreshed).Int("keys", len(keys.Keys)).Msg("JWKS cache file loaded")
+	return nil
+}
+
+// refreshLoop downloads the set at start and then on each refresh interval, retrying the failed
+// downloads sooner
+func (j *jwks) refreshLoop() {
+	defer j.wg.Done()
+	for {
+		wait := j.refreshInterval
+		// Skips the download if a token with an unknown key just triggered one
+		if err := j.refresh(min(wait, jwksMinRefreshInterval)); err != nil {
+			j.mu.Lock()
+			refreshed := j.refreshed
+			j.mu.Unlock()
+			event := log.Warn().Err(err).Str("url", j.url)
+			if !refreshed.IsZero() {
+				event = event.Dur("stale", time.Since(refreshed)).Dur("max_stale", j.maxStale)
+			}
+			event.Msg("Failed to download JWKS, keeping the previous keys")
+			wait = min(wait, jwksMinRefreshInterval)
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-j.stop:
+			timer.Stop()
+			return
+		}
+	}
+}
+
+// Close stops the background downloads.
+func (j *jwks) Close() error {
+	if j.stop != nil {
+		close(j.stop)
+		j.wg.Wait()
+	}
+	return nil
+}
+
+// writeFileAtomically replaces the file with the data, so that readers never see a partial file
+func writeFileAtomically(path string, data []byte) error {
+	file, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer func() { _ = os.Remove(file.Name()) }()
+	if _, err := file.Write(data); err != nil {
+		_ = file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(file.Name(), path)
+}
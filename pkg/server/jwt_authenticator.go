@@ -0,0 +1,309 @@
+package server
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+)
+
+// JWTClaimsKey is the Gin context key under which JWTAuthenticator stores the claims of the
+// validated token.
+const JWTClaimsKey = "jwt_claims"
+
+const (
+	defaultJWTUsernameClaim = "sub"
+	defaultJWTRolesClaim    = "roles"
+)
+
+var (
+	jwtHMACAlgorithms       = []string{"HS256", "HS384", "HS512"}
+	jwtAsymmetricAlgorithms = []string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512", "EdDSA"}
+)
+
+// JWTAuthenticatorConfig configures the validation of bearer JSON Web Tokens. Exactly one of
+// secret, public_key_file and jwks_url must be set.
+type JWTAuthenticatorConfig struct {
+	// Secret verifies tokens signed with HMAC (HS256, HS384 or HS512).
+	Secret string `yaml:"secret,omitempty"`
+	// PublicKeyFile is a PEM file with the RSA, ECDSA or Ed25519 public key, or a certificate,
+	// verifying the tokens.
+	PublicKeyFile string `yaml:"public_key_file,omitempty"`
+	// JWKSURL is the JSON Web Key Set of the token issuer, e.g. its OIDC jwks_uri. The set is
+	// downloaded in the background, and again when a token refers to an unknown key.
+	JWKSURL string `yaml:"jwks_url,omitempty"`
+	// JWKSRefreshInterval is the interval of the background downloads of the key set. Defaults to
+	// 15m; failed downloads are retried every minute.
+	JWKSRefreshInterval time.Duration `yaml:"jwks_refresh_interval,omitempty"`
+	// JWKSMaxStale is how long the last downloaded key set is used while the downloads fail, after
+	// which the tokens are rejected. Defaults to 24h.
+	JWKSMaxStale time.Duration `yaml:"jwks_max_stale,omitempty"`
+	// JWKSCacheFile is where the downloaded key set is saved, to validate the tokens after a
+	// restart while the issuer is unreachable. Optional.
+	JWKSCacheFile string `yaml:"jwks_cache_file,omitempty"`
+	// Algorithms restricts the accepted signing algorithms. Defaults to the HMAC algorithms with
+	// a secret and to the asymmetric algorithms otherwise. The keys of the key set declaring
+	// another algorithm are ignored, and tokens must use the algorithm declared by their key.
+	Algorithms []string `yaml:"algorithms,omitempty"`
+	// Issuer and Audience, if set, must match the iss and aud claims.
+	Issuer   string `yaml:"issuer,omitempty"`
+	Audience string `yaml:"audience,omitempty"`
+	// Leeway tolerates clock skew when checking the exp, nbf and iat claims.
+	Leeway time.Duration `yaml:"leeway,omitempty"`
+	// UsernameClaim is the claim holding the user name. Defaults to sub.
+	UsernameClaim string `yaml:"username_claim,omitempty"`
+	// RolesClaim is the claim holding the roles of the user, as an array or a space separated
+	// string. Defaults to roles. The scopes are read from the standard scope and scp claims.
+	RolesClaim string `yaml:"roles_claim,omitempty"`
+}
+
+func (c JWTAuthenticatorConfig) Validate() error {
+	sources := 0
+	for _, source := range []string{c.Secret, c.PublicKeyFile, c.JWKSURL} {
+		if source != "" {
+			sources++
+		}
+	}
+	if sources != 1 {
+		return errors.New("exactly one of secret, public_key_file or jwks_url must be set")
+	}
+	if c.JWKSURL != "" {
+		if u, err := url.Parse(c.JWKSURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return errors.New("jwks_url must be an http or https URL")
+		}
+	} else if c.JWKSRefreshInterval != 0 || c.JWKSMaxStale != 0 || c.JWKSCacheFile != "" {
+		return errors.New("jwks_refresh_interval, jwks_max_stale and jwks_cache_file require jwks_url")
+	}
+	if c.JWKSRefreshInterval < 0 || c.JWKSMaxStale < 0 {
+		return errors.New("jwks_refresh_interval and jwks_max_stale must be non-negative")
+	}
+	refreshInterval, maxStale := c.JWKSRefreshInterval, c.JWKSMaxStale
+	if refreshInterval == 0 {
+		refreshInterval = defaultJWKSRefreshInterval
+	}
+	if maxStale == 0 {
+		maxStale = defaultJWKSMaxStale
+	}
+	if c.JWKSURL != "" && maxStale < refreshInterval {
+		return errors.New("jwks_max_stale must not be shorter than jwks_refresh_interval")
+	}
+	if c.PublicKeyFile != "" {
+		if _, err := readPublicKey(c.PublicKeyFile); err != nil {
+			return err
+		}
+	}
+	supported := jwtAsymmetricAlgorithms
+	if c.Secret != "" {
+		supported = jwtHMACAlgorithms
+	}
+	for _, algorithm := range c.Algorithms {
+		if !slices.Contains(supported, algorithm) {
+			return errors.Errorf("unsupported algorithm %q for the configured key", algorithm)
+		}
+	}
+	if c.Leeway < 0 {
+		return errors.New("leeway must be non-negative")
+	}
+	return nil
+}
+
+// JWTAuthenticator implements Authenticator for bearer JSON Web Tokens, e.g. the access tokens
+// of an OAuth2 authorization server. Requests must carry a valid token in the Authorization
+// header, otherwise they are answered with 401 Unauthorized. On success, the claims are stored
+// in the context under JWTClaimsKey, the user name under RemoteUserKey, and the roles and scopes
+// under RolesKey and ScopesKey.
+type JWTAuthenticator struct {
+	parser        *jwt.Parser
+	keyFunc       jwt.Keyfunc
+	keys          *jwks // Nil without JWKS
+	usernameClaim string
+	rolesClaim    string
+}
+
+// NewJWTAuthenticator creates a new authenticator validating tokens with the configured key. With
+// a JWKS, Close stops its background downloads.
+//
+// Example usage:
+//
+//	authenticator, err := server.NewJWTAuthenticator(server.JWTAuthenticatorConfig{
+//	    JWKSURL:  "https://idp.example.org/.well-known/jwks.json",
+//	    Issuer:   "https://idp.example.org",
+//	    Audience: "api",
+//	})
+//	if err != nil {
+//	    return err
+//	}
+//	server.SetAuthenticator(authenticator)
+func NewJWTAuthenticator(c JWTAuthenticatorConfig) (Authenticator, error) {
+	algorithms := c.Algorithms
+	var keyFunc jwt.Keyfunc
+	var keys *jwks
+	switch {
+	case c.Secret != "":
+		if len(algorithms) == 0 {
+			algorithms = jwtHMACAlgorithms
+		}
+		secret := []byte(c.Secret)
+		keyFunc = func(*jwt.Token) (any, error) { return secret, nil }
+	case c.PublicKeyFile != "":
+		key, err := readPublicKey(c.PublicKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		keyFunc = func(*jwt.Token) (any, error) { return key, nil }
+	}
+	if len(algorithms) == 0 {
+		algorithms = jwtAsymmetricAlgorithms
+	}
+	if keyFunc == nil {
+		keys = newJWKS(c, algorithms)
+		keyFunc = keys.key
+	}
+
+	options := []jwt.ParserOption{jwt.WithValidMethods(algorithms), jwt.WithLeeway(c.Leeway), jwt.WithExpirationRequired()}
+	if c.Issuer != "" {
+		options = append(options, jwt.WithIssuer(c.Issuer))
+	}
+	if c.Audience != "" {
+		options = append(options, jwt.WithAudience(c.Audience))
+	}
+	usernameClaim := c.UsernameClaim
+	if usernameClaim == "" {
+		usernameClaim = defaultJWTUsernameClaim
+	}
+	rolesClaim := c.RolesClaim
+	if rolesClaim == "" {
+		rolesClaim = defaultJWTRolesClaim
+	}
+	return &JWTAuthenticator{
+		parser:        jwt.NewParser(options...),
+		keyFunc:       keyFunc,
+		keys:          keys,
+		usernameClaim: usernameClaim,
+		rolesClaim:    rolesClaim,
+	}, nil
+}
+
+// Close stops the background downloads of the JWKS, if any.
+func (a *JWTAuthenticator) Close() error {
+	if a.keys == nil {
+		return nil
+	}
+	return a.keys.Close()
+}
+
+// Middleware returns a Gin middleware that requires a valid bearer token.
+func (a *JWTAuthenticator) Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		scheme, raw, _ := strings.Cut(c.GetHeader("Authorization"), " ")
+		if !strings.EqualFold(scheme, "Bearer") || raw == "" {
+			c.Header("WWW-Authenticate", "Bearer")
+			c.AbortWithStatus(http.StatusUnauthorized)
+			return
+		}
+
+		claims := jwt.MapClaims{}
+		if _, err := a.parser.ParseWithClaims(strings.TrimSpace(raw), claims, a.keyFunc); err != nil {
+			log.Debug().Err(err).Msg("Invalid bearer token")
+			ReportAbuse(c, failedAuthPenalty)
+			c.Header("WWW-Authenticate", `Bearer error="invalid_token"`)
+			c.AbortWithStatus(http.StatusUnauthorized)
+			return
+		}
+
+		username, _ := claims[a.usernameClaim].(string)
+		if username != "" && CredentialsRevoked(c, username, issuedAfter(claims)) {
+			log.Debug().Str("user", username).Msg("Revoked bearer token")
+			c.Header("WWW-Authenticate", `Bearer error="invalid_token"`)
+			c.AbortWithStatus(http.StatusUnauthorized)
+			return
+		}
+
+		c.Set(JWTClaimsKey, claims)
+		if username != "" {
+			c.Set(RemoteUserKey, username)
+		}
+		if roles := claimStrings(claims[a.rolesClaim]); len(roles) > 0 {
+			c.Set(RolesKey, roles)
+		}
+		// RFC 8693 defines scope as a space separated string, some issuers use an scp array instead
+		if scopes := append(claimStrings(claims["scope"]), claimStrings(claims["scp"])...); len(scopes) > 0 {
+			c.Set(ScopesKey, scopes)
+		}
+		c.Next()
+	}
+}
+
+// issuedAfter tells whether a token was issued after the last revocation of its user. Tokens
+// without iat claim are revoked, and so are the tokens issued in the second of the revocation, as
+// iat has a precision of seconds.
+func issuedAfter(claims jwt.MapClaims) func(Revocation) bool {
+	return func(revocation Revocation) bool {
+		issuedAt, err := claims.GetIssuedAt()
+		return err == nil && issuedAt != nil && !issuedAt.Before(revocation.RevokedAt.Truncate(time.Second).Add(time.Second))
+	}
+}
+
+// claimStrings reads a claim holding either an array of strings or a space separated string.
+func claimStrings(claim any) []string {
+	switch value := claim.(type) {
+	case string:
+		return strings.Fields(value)
+	case []any:
+		var values []string
+		for _, item := range value {
+			if s, ok := item.(string); ok && s != "" {
+				values = append(values, s)
+			}
+		}
+		return values
+	}
+	return nil
+}
+
+// GetJWTClaims returns the token claims stored by JWTAuthenticator.
+func GetJWTClaims(c *gin.Context) (jwt.MapClaims, bool) {
+	value, exists := c.Get(JWTClaimsKey)
+	if !exists {
+		return nil, false
+	}
+	claims, ok := value.(jwt.MapClaims)
+	return claims, ok
+}
+
+// readPublicKey reads a PEM encoded public key or certificate.
+func readPublicKey(path string) (any, error) {
+	// #nosec G304 -- Key file path is provided by operator configuration
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read public key file")
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("public key file is not PEM encoded")
+	}
+	switch block.Type {
+	case "PUBLIC KEY":
+		key, err := x509.ParsePKIXPublicKey(block.Bytes)
+		return key, errors.Wrap(err, "invalid public key")
+	case "RSA PUBLIC KEY":
+		key, err := x509.ParsePKCS1PublicKey(block.Bytes)
+		return key, errors.Wrap(err, "invalid public key")
+	case "CERTIFICATE":
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, errors.Wrap(err, "invalid certificate")
+		}
+		return cert.PublicKey, nil
+	}
+	return nil, errors.Errorf("unsupported PEM block %q in public key file", block.Type)
+}
@@ -0,0 +1,277 @@
+//go:build unit
+
+package server
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-jose/go-jose/v4"
+	"github.com/golang-jwt/jwt/v5"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("JWTAuthenticator", func() {
+	var (
+		user          string
+		claims        jwt.MapClaims
+		roles, scopes []string
+	)
+
+	serve := func(authenticator Authenticator, authorization string) *httptest.ResponseRecorder {
+		gin.SetMode(gin.TestMode)
+		user, claims, roles, scopes = "", nil, nil, nil
+		engine := gin.New()
+		engine.GET("/", authenticator.Middleware(), func(c *gin.Context) {
+			user, _ = GetRemoteUser(c)
+			claims, _ = GetJWTClaims(c)
+			roles, scopes = GetRoles(c), GetScopes(c)
+			c.Status(http.StatusOK)
+		})
+
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		if authorization != "" {
+			req.Header.Set("Authorization", authorization)
+		}
+		engine.ServeHTTP(w, req)
+		return w
+	}
+
+	sign := func(method jwt.SigningMethod, key any, kid string, claims jwt.MapClaims) string {
+		token := jwt.NewWithClaims(method, claims)
+		if kid != "" {
+			token.Header["kid"] = kid
+		}
+		signed, err := token.SignedString(key)
+		Expect(err).NotTo(HaveOccurred())
+		return "Bearer " + signed
+	}
+
+	validClaims := func() jwt.MapClaims {
+		return jwt.MapClaims{"sub": "alice", "iss": "https://idp", "aud": "api", "exp": time.Now().Add(time.Hour).Unix()}
+	}
+
+	It("should validate the configuration", func() {
+		Expect(JWTAuthenticatorConfig{Secret: "s"}.Validate()).To(Succeed())
+		Expect(JWTAuthenticatorConfig{JWKSURL: "https://idp/jwks"}.Validate()).To(Succeed())
+		Expect(JWTAuthenticatorConfig{}.Validate()).To(MatchError(ContainSubstring("exactly one of")))
+		Expect(JWTAuthenticatorConfig{Secret: "s", JWKSURL: "https://idp/jwks"}.Validate()).To(HaveOccurred())
+		Expect(JWTAuthenticatorConfig{JWKSURL: "ftp://idp/jwks"}.Validate()).To(MatchError(ContainSubstring("http or https")))
+		Expect(JWTAuthenticatorConfig{PublicKeyFile: "/nonexistent.pem"}.Validate()).To(HaveOccurred())
+		Expect(JWTAuthenticatorConfig{Secret: "s", Algorithms: []string{"HS512"}}.Validate()).To(Succeed())
+		Expect(JWTAuthenticatorConfig{Secret: "s", Algorithms: []string{"none"}}.Validate()).To(MatchError(ContainSubstring("unsupported algorithm")))
+		Expect(JWTAuthenticatorConfig{Secret: "s", Algorithms: []string{"RS256"}}.Validate()).To(HaveOccurred())
+		Expect(JWTAuthenticatorConfig{JWKSURL: "https://idp/jwks", Algorithms: []string{"HS256"}}.Validate()).To(HaveOccurred())
+		Expect(JWTAuthenticatorConfig{Secret: "s", Leeway: -time.Second}.Validate()).To(HaveOccurred())
+		Expect(JWTAuthenticatorConfig{Secret: "s", JWKSCacheFile: "/tmp/jwks.json"}.Validate()).To(MatchError(ContainSubstring("require jwks_url")))
+		Expect(JWTAuthenticatorConfig{JWKSURL: "https://idp/jwks", JWKSRefreshInterval: -time.Second}.Validate()).To(MatchError(ContainSubstring("non-negative")))
+		Expect(JWTAuthenticatorConfig{JWKSURL: "https://idp/jwks", JWKSRefreshInterval: time.Hour, JWKSMaxStale: time.Minute}.Validate()).
+			To(MatchError(ContainSubstring("jwks_max_stale must not be shorter")))
+		Expect(JWTAuthenticatorConfig{JWKSURL: "https://idp/jwks", JWKSRefreshInterval: time.Minute, JWKSMaxStale: time.Hour}.Validate()).To(Succeed())
+	})
+
+	It("should validate HMAC tokens and their claims", func() {
+		authenticator, err := NewJWTAuthenticator(JWTAuthenticatorConfig{Secret: "secret", Issuer: "https://idp", Audience: "api"})
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(serve(authenticator, sign(jwt.SigningMethodHS256, []byte("secret"), "", validClaims())).Code).To(Equal(http.StatusOK))
+		Expect(user).To(Equal("alice"))
+		Expect(claims).To(HaveKeyWithValue("aud", "api"))
+
+		w := serve(authenticator, "")
+		Expect(w.Code).To(Equal(http.StatusUnauthorized))
+		Expect(w.Header().Get("WWW-Authenticate")).To(Equal("Bearer"))
+
+		invalid := []string{
+			sign(jwt.SigningMethodHS256, []byte("other"), "", validClaims()),
+			sign(jwt.SigningMethodHS256, []byte("secret"), "", jwt.MapClaims{"sub": "alice", "iss": "https://idp", "aud": "api"}),
+			sign(jwt.SigningMethodHS256, []byte("secret"), "", jwt.MapClaims{"sub": "alice", "iss": "https://other", "aud": "api", "exp": time.Now().Add(time.Hour).Unix()}),
+			sign(jwt.SigningMethodHS256, []byte("secret"), "", jwt.MapClaims{"sub": "alice", "iss": "https://idp", "aud": "web", "exp": time.Now().Add(time.Hour).Unix()}),
+			sign(jwt.SigningMethodHS256, []byte("secret"), "", jwt.MapClaims{"sub": "alice", "iss": "https://idp", "aud": "api", "exp": time.Now().Add(-time.Minute).Unix()}),
+			"Basic YWxpY2U6c2VjcmV0",
+		}
+		for _, authorization := range invalid {
+			w := serve(authenticator, authorization)
+			Expect(w.Code).To(Equal(http.StatusUnauthorized), authorization)
+			Expect(user).To(BeEmpty())
+		}
+	})
+
+	It("should read the roles and scopes", func() {
+		authenticator, err := NewJWTAuthenticator(JWTAuthenticatorConfig{Secret: "secret"})
+		Expect(err).NotTo(HaveOccurred())
+
+		tokenClaims := validClaims()
+		tokenClaims["roles"] = []string{"admin", "editor"}
+		tokenClaims["scope"] = "orders:read orders:write"
+		Expect(serve(authenticator, sign(jwt.SigningMethodHS256, []byte("secret"), "", tokenClaims)).Code).To(Equal(http.StatusOK))
+		Expect(roles).To(Equal([]string{"admin", "editor"}))
+		Expect(scopes).To(Equal([]string{"orders:read", "orders:write"}))
+
+		authenticator, err = NewJWTAuthenticator(JWTAuthenticatorConfig{Secret: "secret", RolesClaim: "groups"})
+		Expect(err).NotTo(HaveOccurred())
+		tokenClaims = validClaims()
+		tokenClaims["roles"] = "admin"
+		tokenClaims["groups"] = "staff"
+		tokenClaims["scp"] = []string{"orders:read"}
+		Expect(serve(authenticator, sign(jwt.SigningMethodHS256, []byte("secret"), "", tokenClaims)).Code).To(Equal(http.StatusOK))
+		Expect(roles).To(Equal([]string{"staff"}))
+		Expect(scopes).To(Equal([]string{"orders:read"}))
+
+		Expect(serve(authenticator, sign(jwt.SigningMethodHS256, []byte("secret"), "", validClaims())).Code).To(Equal(http.StatusOK))
+		Expect(roles).To(BeEmpty())
+		Expect(scopes).To(BeEmpty())
+	})
+
+	It("should validate tokens with a public key file and reject other algorithms", func() {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		Expect(err).NotTo(HaveOccurred())
+		der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+		Expect(err).NotTo(HaveOccurred())
+		keyFile := filepath.Join(GinkgoT().TempDir(), "key.pem")
+		Expect(os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), 0o600)).To(Succeed())
+
+		authenticator, err := NewJWTAuthenticator(JWTAuthenticatorConfig{PublicKeyFile: keyFile, UsernameClaim: "email"})
+		Expect(err).NotTo(HaveOccurred())
+
+		tokenClaims := validClaims()
+		tokenClaims["email"] = "alice@example.org"
+		Expect(serve(authenticator, sign(jwt.SigningMethodES256, key, "", tokenClaims)).Code).To(Equal(http.StatusOK))
+		Expect(user).To(Equal("alice@example.org"))
+
+		// An HMAC token signed with the public key must not be accepted
+		Expect(serve(authenticator, sign(jwt.SigningMethodHS256, der, "", tokenClaims)).Code).To(Equal(http.StatusUnauthorized))
+	})
+
+	It("should resolve keys from a JWKS and download it again for unknown keys", func() {
+		oldKey, err := rsa.GenerateKey(rand.Reader, 2048)
+		Expect(err).NotTo(HaveOccurred())
+		newKey, err := rsa.GenerateKey(rand.Reader, 2048)
+		Expect(err).NotTo(HaveOccurred())
+
+		var downloads atomic.Int32
+		keys := []jose.JSONWebKey{{Key: &oldKey.PublicKey, KeyID: "old", Algorithm: "RS256", Use: "sig"}}
+		idp := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			downloads.Add(1)
+			_ = json.NewEncoder(w).Encode(jose.JSONWebKeySet{Keys: keys})
+		}))
+		DeferCleanup(idp.Close)
+
+		authenticator, err := NewJWTAuthenticator(JWTAuthenticatorConfig{JWKSURL: idp.URL})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(serve(authenticator, sign(jwt.SigningMethodRS256, oldKey, "old", validClaims())).Code).To(Equal(http.StatusOK))
+		Expect(serve(authenticator, sign(jwt.SigningMethodRS256, oldKey, "old", validClaims())).Code).To(Equal(http.StatusOK))
+		Expect(downloads.Load()).To(Equal(int32(1)))
+
+		// Unknown keys are only looked up again after the minimum refresh interval
+		keys = append(keys, jose.JSONWebKey{Key: &newKey.PublicKey, KeyID: "new", Algorithm: "RS256", Use: "sig"})
+		Expect(serve(authenticator, sign(jwt.SigningMethodRS256, newKey, "new", validClaims())).Code).To(Equal(http.StatusUnauthorized))
+		Expect(downloads.Load()).To(Equal(int32(1)))
+
+		authenticator.(*JWTAuthenticator).keyFunc = (&jwks{url: idp.URL, client: http.DefaultClient}).key
+		Expect(serve(authenticator, sign(jwt.SigningMethodRS256, newKey, "new", validClaims())).Code).To(Equal(http.StatusOK))
+		Expect(serve(authenticator, sign(jwt.SigningMethodRS256, newKey, "unknown", validClaims())).Code).To(Equal(http.StatusUnauthorized))
+		Expect(downloads.Load()).To(Equal(int32(2)))
+	})
+
+	Context("JWKS resilience", func() {
+		var (
+			key       *rsa.PrivateKey
+			keys      []jose.JSONWebKey
+			available atomic.Bool
+			downloads atomic.Int32
+			idp       *httptest.Server
+		)
+
+		BeforeEach(func() {
+			var err error
+			key, err = rsa.GenerateKey(rand.Reader, 2048)
+			Expect(err).NotTo(HaveOccurred())
+			keys = []jose.JSONWebKey{{Key: &key.PublicKey, KeyID: "current", Algorithm: "RS256", Use: "sig"}}
+			available.Store(true)
+			downloads.Store(0)
+			idp = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				downloads.Add(1)
+				if !available.Load() {
+					w.WriteHeader(http.StatusServiceUnavailable)
+					return
+				}
+				_ = json.NewEncoder(w).Encode(jose.JSONWebKeySet{Keys: keys})
+			}))
+			DeferCleanup(idp.Close)
+		})
+
+		newAuthenticator := func(c JWTAuthenticatorConfig) Authenticator {
+			c.JWKSURL = idp.URL
+			Expect(c.Validate()).To(Succeed())
+			authenticator, err := NewJWTAuthenticator(c)
+			Expect(err).NotTo(HaveOccurred())
+			DeferCleanup(authenticator.(*JWTAuthenticator).Close)
+			return authenticator
+		}
+
+		It("should download the key set in the background and keep it while the downloads fail", func() {
+			authenticator := newAuthenticator(JWTAuthenticatorConfig{JWKSRefreshInterval: 20 * time.Millisecond, JWKSMaxStale: 300 * time.Millisecond})
+			Eventually(downloads.Load).Should(BeNumerically(">=", 2))
+			Expect(serve(authenticator, sign(jwt.SigningMethodRS256, key, "current", validClaims())).Code).To(Equal(http.StatusOK))
+
+			By("accepting the tokens until the key set is older than jwks_max_stale")
+			available.Store(false)
+			failedFrom := downloads.Load()
+			Eventually(downloads.Load).Should(BeNumerically(">", failedFrom+1))
+			Expect(serve(authenticator, sign(jwt.SigningMethodRS256, key, "current", validClaims())).Code).To(Equal(http.StatusOK))
+			Eventually(func() int {
+				return serve(authenticator, sign(jwt.SigningMethodRS256, key, "current", validClaims())).Code
+			}).Should(Equal(http.StatusUnauthorized))
+
+			By("accepting them again once the issuer recovers")
+			available.Store(true)
+			Eventually(func() int {
+				return serve(authenticator, sign(jwt.SigningMethodRS256, key, "current", validClaims())).Code
+			}).Should(Equal(http.StatusOK))
+		})
+
+		It("should validate the tokens with the cache file while the issuer is unreachable", func() {
+			cacheFile := filepath.Join(GinkgoT().TempDir(), "jwks.json")
+			newAuthenticator(JWTAuthenticatorConfig{JWKSCacheFile: cacheFile})
+			Eventually(func() error { _, err := os.Stat(cacheFile); return err }).Should(Succeed())
+
+			available.Store(false)
+			restarted := newAuthenticator(JWTAuthenticatorConfig{JWKSCacheFile: cacheFile})
+			Expect(serve(restarted, sign(jwt.SigningMethodRS256, key, "current", validClaims())).Code).To(Equal(http.StatusOK))
+
+			// Cache files older than jwks_max_stale are not used
+			old := time.Now().Add(-2 * time.Hour)
+			Expect(os.Chtimes(cacheFile, old, old)).To(Succeed())
+			expired := newAuthenticator(JWTAuthenticatorConfig{JWKSCacheFile: cacheFile, JWKSRefreshInterval: time.Minute, JWKSMaxStale: time.Hour})
+			Expect(serve(expired, sign(jwt.SigningMethodRS256, key, "current", validClaims())).Code).To(Equal(http.StatusUnauthorized))
+		})
+
+		It("should require the algorithms declared by the keys", func() {
+			keys = append(keys, jose.JSONWebKey{Key: &key.PublicKey, KeyID: "legacy", Algorithm: "RS512", Use: "sig"})
+			authenticator := newAuthenticator(JWTAuthenticatorConfig{})
+			Expect(serve(authenticator, sign(jwt.SigningMethodRS256, key, "current", validClaims())).Code).To(Equal(http.StatusOK))
+			Expect(serve(authenticator, sign(jwt.SigningMethodRS384, key, "current", validClaims())).Code).To(Equal(http.StatusUnauthorized))
+			Expect(serve(authenticator, sign(jwt.SigningMethodRS512, key, "legacy", validClaims())).Code).To(Equal(http.StatusOK))
+
+			// Keys declaring algorithms that are not accepted are ignored
+			restricted := newAuthenticator(JWTAuthenticatorConfig{Algorithms: []string{"RS256"}})
+			Expect(serve(restricted, sign(jwt.SigningMethodRS256, key, "current", validClaims())).Code).To(Equal(http.StatusOK))
+			Expect(serve(restricted, sign(jwt.SigningMethodRS512, key, "legacy", validClaims())).Code).To(Equal(http.StatusUnauthorized))
+		})
+	})
+})
@@ -0,0 +1,193 @@
+package server
+
+import (
+	"net/http"
+	"runtime"
+	"runtime/metrics"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	defaultSheddingSampleInterval = time.Second
+
+	// memoryMetric is the memory mapped by the Go runtime, see runtime/metrics
+	memoryMetric = "/memory/classes/total:bytes"
+)
+
+var (
+	sheddingLevel = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "sargantana_load_shedding_level",
+		Help: "Current load shedding level: 0 sheds nothing, 1 sheds low priority requests, 2 normal ones and 3 high ones.",
+	})
+	shedRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "sargantana_shed_requests_total",
+		Help: "Number of requests rejected by load shedding, by priority.",
+	}, []string{"priority"})
+)
+
+// LoadSheddingConfig configures the rejection of requests with 503 while the gateway is saturated,
+// by priority, see PrioritiesConfig. The signals are sampled periodically, and while any of them
+// exceeds its limit the shedding level rises by one priority per sample, up to high priority
+// requests. It falls back by one per sample once every signal is under its limit. Signals without
+// limit are not monitored.
+type LoadSheddingConfig struct {
+	// MaxSchedulingLag is the maximum delay of the sampling timer, which grows when goroutines
+	// wait for a processor, e.g. 100ms.
+	MaxSchedulingLag time.Duration `yaml:"max_scheduling_lag,omitempty"`
+	// MaxCPU is the maximum CPU usage of the process, as a fraction of GOMAXPROCS, e.g. 0.9.
+	MaxCPU float64 `yaml:"max_cpu,omitempty"`
+	// MaxMemory is the maximum memory in bytes mapped by the Go runtime.
+	MaxMemory int64 `yaml:"max_memory,omitempty"`
+	// SampleInterval is how often the signals are sampled. Defaults to 1s.
+	SampleInterval time.Duration `yaml:"sample_interval,omitempty"`
+	// RetryAfter is sent in the Retry-After header of the shed requests, rounded up to seconds.
+	// Defaults to the sample interval.
+	RetryAfter time.Duration `yaml:"retry_after,omitempty"`
+}
+
+func (c LoadSheddingConfig) Validate() error {
+	if c.MaxSchedulingLag == 0 && c.MaxCPU == 0 && c.MaxMemory == 0 {
+		return errors.New("at least one of max_scheduling_lag, max_cpu or max_memory must be set")
+	}
+	if c.MaxSchedulingLag < 0 || c.MaxMemory < 0 || c.SampleInterval < 0 || c.RetryAfter < 0 {
+		return errors.New("max_scheduling_lag, max_memory, sample_interval and retry_after must not be negative")
+	}
+	if c.MaxCPU < 0 || c.MaxCPU > 1 {
+		return errors.New("max_cpu must be between 0 and 1")
+	}
+	return nil
+}
+
+// loadShedder samples the resource signals and sheds the requests whose priority is not above
+// the current level
+type loadShedder struct {
+	config     LoadSheddingConfig
+	interval   time.Duration
+	retryAfter string
+	level      atomic.Int32
+	cpuTime    func() time.Duration
+	stop       chan struct{}
+	done       sync.WaitGroup
+}
+
+func newLoadShedder(config LoadSheddingConfig) *loadShedder {
+	interval := config.SampleInterval
+	if interval == 0 {
+		interval = defaultSheddingSampleInterval
+	}
+	retryAfter := config.RetryAfter
+	if retryAfter == 0 {
+		retryAfter = interval
+	}
+	return &loadShedder{
+		config:     config,
+		interval:   interval,
+		retryAfter: strconv.Itoa(int((retryAfter + time.Second - 1) / time.Second)),
+		cpuTime:    processCPUTime,
+		stop:       make(chan struct{}),
+	}
+}
+
+// start samples the signals in the background until Close
+func (l *loadShedder) start() {
+	l.done.Add(1)
+	go func() {
+		defer l.done.Done()
+		lastCPU, lastSample := l.cpuTime(), time.Now()
+		for {
+			deadline := time.Now().Add(l.interval)
+			timer := time.NewTimer(l.interval)
+			select {
+			case <-l.stop:
+				timer.Stop()
+				return
+			case <-timer.C:
+			}
+			now := time.Now()
+			lag := now.Sub(deadline)
+			cpuTime := l.cpuTime()
+			cpu := float64(cpuTime-lastCPU) / float64(now.Sub(lastSample)) / float64(runtime.GOMAXPROCS(0))
+			lastCPU, lastSample = cpuTime, now
+			l.adjust(l.overloaded(lag, cpu, runtimeMemory()))
+		}
+	}()
+}
+
+func (l *loadShedder) Close() error {
+	close(l.stop)
+	l.done.Wait()
+	return nil
+}
+
+// overloaded tells whether any monitored signal exceeds its limit, logging the signals if so
+func (l *loadShedder) overloaded(lag time.Duration, cpu float64, memory uint64) bool {
+	lagExceeded := l.config.MaxSchedulingLag > 0 && lag > l.config.MaxSchedulingLag
+	cpuExceeded := l.config.MaxCPU > 0 && cpu > l.config.MaxCPU
+	memoryExceeded := l.config.MaxMemory > 0 && memory > uint64(l.config.MaxMemory)
+	if !lagExceeded && !cpuExceeded && !memoryExceeded {
+		return false
+	}
+	log.Warn().
+		Dur("scheduling_lag", lag).
+		Float64("cpu", cpu).
+		Uint64("memory", memory).
+		Int32("level", l.level.Load()).
+		Msg("Gateway saturated")
+	return true
+}
+
+// adjust raises the shedding level by one while overloaded, up to high priority requests, and
+// lowers it by one otherwise
+func (l *loadShedder) adjust(overloaded bool) {
+	level := l.level.Load()
+	switch {
+	case overloaded && int(level) < PriorityHigh.Rank():
+		level++
+	case !overloaded && level > 0:
+		level--
+	default:
+		return
+	}
+	l.level.Store(level)
+	sheddingLevel.Set(float64(level))
+	log.Info().Int32("level", level).Msg("Load shedding level changed")
+}
+
+// middleware sheds the requests whose priority is not above the level. It must be installed after
+// the priority middleware.
+func (l *loadShedder) middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		level := l.level.Load()
+		if level == 0 {
+			c.Next()
+			return
+		}
+		priority := RequestPriority(c)
+		if priority.Rank() > int(level) {
+			c.Next()
+			return
+		}
+		shedRequests.WithLabelValues(string(priority)).Inc()
+		c.Header("Retry-After", l.retryAfter)
+		c.AbortWithStatus(http.StatusServiceUnavailable)
+	}
+}
+
+// runtimeMemory returns the memory mapped by the Go runtime
+func runtimeMemory() uint64 {
+	sample := []metrics.Sample{{Name: memoryMetric}}
+	metrics.Read(sample)
+	if sample[0].Value.Kind() != metrics.KindUint64 {
+		return 0
+	}
+	return sample[0].Value.Uint64()
+}
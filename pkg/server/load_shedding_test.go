@@ -0,0 +1,94 @@
+//go:build unit
+
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Load shedding", func() {
+	var (
+		shedder *loadShedder
+		engine  *gin.Engine
+	)
+
+	BeforeEach(func() {
+		shedder = newLoadShedder(LoadSheddingConfig{MaxCPU: 0.9, RetryAfter: 2500 * time.Millisecond})
+		resolver := newPriorityResolver(&PrioritiesConfig{
+			Rules: []PriorityRuleConfig{
+				{Routes: []string{"/reports/*"}, Priority: PriorityLow},
+				{Routes: []string{"/checkout"}, Methods: []string{"POST"}, Priority: PriorityHigh},
+			},
+		}, []string{"/readyz"}, controllerRoutes{"GET /auth/login": {name: "auth", typeName: "auth"}})
+		engine = gin.New()
+		engine.Use(resolver.middleware, shedder.middleware())
+		ok := func(c *gin.Context) {
+			c.Status(http.StatusOK)
+		}
+		engine.GET("/auth/login", ok)
+		engine.NoRoute(ok)
+	})
+
+	serve := func(method, path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		engine.ServeHTTP(w, httptest.NewRequest(method, path, nil))
+		return w
+	}
+
+	It("should validate the configuration", func() {
+		Expect(LoadSheddingConfig{MaxCPU: 0.8}.Validate()).To(Succeed())
+		Expect(LoadSheddingConfig{}.Validate()).To(MatchError(ContainSubstring("at least one")))
+		Expect(LoadSheddingConfig{MaxCPU: 1.5}.Validate()).To(MatchError(ContainSubstring("max_cpu")))
+		Expect(LoadSheddingConfig{MaxMemory: -1}.Validate()).To(MatchError(ContainSubstring("must not be negative")))
+	})
+
+	It("should serve every request when not saturated", func() {
+		Expect(serve(http.MethodGet, "/reports/daily").Code).To(Equal(http.StatusOK))
+	})
+
+	It("should shed the requests by priority as the level rises", func() {
+		shedder.adjust(true)
+		w := serve(http.MethodGet, "/reports/daily")
+		Expect(w.Code).To(Equal(http.StatusServiceUnavailable))
+		Expect(w.Header().Get("Retry-After")).To(Equal("3"))
+		Expect(serve(http.MethodGet, "/home").Code).To(Equal(http.StatusOK))
+
+		shedder.adjust(true)
+		Expect(serve(http.MethodGet, "/home").Code).To(Equal(http.StatusServiceUnavailable))
+		Expect(serve(http.MethodPost, "/checkout").Code).To(Equal(http.StatusOK))
+
+		shedder.adjust(true)
+		shedder.adjust(true)
+		Expect(shedder.level.Load()).To(BeEquivalentTo(3))
+		Expect(serve(http.MethodPost, "/checkout").Code).To(Equal(http.StatusServiceUnavailable))
+		Expect(serve(http.MethodGet, "/checkout").Code).To(Equal(http.StatusServiceUnavailable))
+
+		By("never shedding the auth and readiness endpoints")
+		Expect(serve(http.MethodGet, "/auth/login").Code).To(Equal(http.StatusOK))
+		Expect(serve(http.MethodGet, "/readyz").Code).To(Equal(http.StatusOK))
+
+		By("recovering one level per sample")
+		shedder.adjust(false)
+		shedder.adjust(false)
+		Expect(serve(http.MethodGet, "/home").Code).To(Equal(http.StatusOK))
+		Expect(serve(http.MethodGet, "/reports/daily").Code).To(Equal(http.StatusServiceUnavailable))
+		shedder.adjust(false)
+		Expect(serve(http.MethodGet, "/reports/daily").Code).To(Equal(http.StatusOK))
+	})
+
+	It("should detect saturation from the sampled signals", func() {
+		Expect(shedder.overloaded(0, 0.95, 0)).To(BeTrue())
+		Expect(shedder.overloaded(time.Second, 0.5, 1<<40)).To(BeFalse())
+
+		sampled := newLoadShedder(LoadSheddingConfig{MaxMemory: 1, SampleInterval: 10 * time.Millisecond})
+		sampled.start()
+		DeferCleanup(sampled.Close)
+		Eventually(sampled.level.Load).Should(BeEquivalentTo(3))
+	})
+})
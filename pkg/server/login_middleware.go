@@ -0,0 +1,118 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+)
+
+// OptionalAuth returns a middleware authenticating requests with the authenticator of the
+// controller, like the login middleware passed to Bind, but letting anonymous requests through
+// instead of rejecting them. Handlers can tell both apart with GetRemoteUser, GetJWTClaims or
+// the session user.
+//
+// Example usage:
+//
+//	engine.GET("/articles", ctx.OptionalAuth(), listArticles)
+func (ctx ControllerContext) OptionalAuth() gin.HandlerFunc {
+	probe, login := newAuthProbe(), ctx.LoginMiddleware()
+	return func(c *gin.Context) {
+		probe.run(c, login)
+		c.Next()
+	}
+}
+
+// RequireRole returns a login middleware that also requires the authenticated user to have the
+// role, see RolesKey. Authenticated users without the role are answered with 403 Forbidden.
+//
+// Example usage:
+//
+//	engine.DELETE("/articles/:id", ctx.RequireRole("editor"), deleteArticle)
+func (ctx ControllerContext) RequireRole(role string) gin.HandlerFunc {
+	return ctx.requireGrant(func(c *gin.Context) bool {
+		return slices.Contains(GetRoles(c), role)
+	}, func(c *gin.Context) {
+		log.Debug().Str("role", role).Msg("Authenticated user lacks the required role")
+		c.AbortWithStatus(http.StatusForbidden)
+	})
+}
+
+// RequireScope returns a login middleware that also requires the OAuth2 scope to be granted to
+// the authenticated user, see ScopesKey. Authenticated users without the scope are answered with
+// 403 Forbidden and an insufficient_scope bearer challenge.
+//
+// Example usage:
+//
+//	engine.POST("/orders", ctx.RequireScope("orders:write"), createOrder)
+func (ctx ControllerContext) RequireScope(scope string) gin.HandlerFunc {
+	return ctx.requireGrant(func(c *gin.Context) bool {
+		return slices.Contains(GetScopes(c), scope)
+	}, func(c *gin.Context) {
+		log.Debug().Str("scope", scope).Msg("Authenticated user lacks the required scope")
+		c.Header("WWW-Authenticate", fmt.Sprintf("Bearer error=\"insufficient_scope\", scope=%q", scope))
+		c.AbortWithStatus(http.StatusForbidden)
+	})
+}
+
+// requireGrant returns a login middleware that calls deny for authenticated requests not
+// satisfying granted. The authenticator runs in a probe, so that the check happens before it
+// would hand the request over to the next handlers.
+func (ctx ControllerContext) requireGrant(granted func(c *gin.Context) bool, deny func(c *gin.Context)) gin.HandlerFunc {
+	probe, login := newAuthProbe(), ctx.LoginMiddleware()
+	return func(c *gin.Context) {
+		if accepted, response := probe.run(c, login); !accepted {
+			rejectAll(c, []*authResponse{response})
+			return
+		}
+		if !granted(c) {
+			deny(c)
+			return
+		}
+		c.Next()
+	}
+}
+
+// LoginMiddleware returns the login middleware of the controller, the one passed to Bind. It
+// rejects every request if the context was not created by the server.
+func (ctx ControllerContext) LoginMiddleware() gin.HandlerFunc {
+	if ctx.login == nil {
+		return NewUnauthorizedAuthenticator().Middleware()
+	}
+	return ctx.login
+}
+
+// LoginMiddlewareConfig is the empty configuration of the login and optional_auth middleware
+// types, see RegisterMiddleware.
+type LoginMiddlewareConfig struct{}
+
+func (c LoginMiddlewareConfig) Validate() error {
+	return nil
+}
+
+// RoleMiddlewareConfig configures the role required by the require_role middleware type.
+type RoleMiddlewareConfig struct {
+	Role string `yaml:"role"`
+}
+
+func (c RoleMiddlewareConfig) Validate() error {
+	if c.Role == "" {
+		return errors.New("role must be set and non-empty")
+	}
+	return nil
+}
+
+// ScopeMiddlewareConfig configures the scope required by the require_scope middleware type.
+type ScopeMiddlewareConfig struct {
+	Scope string `yaml:"scope"`
+}
+
+func (c ScopeMiddlewareConfig) Validate() error {
+	if c.Scope == "" {
+		return errors.New("scope must be set and non-empty")
+	}
+	return nil
+}
@@ -0,0 +1,110 @@
+//go:build unit
+
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	"github.com/animalet/sargantana-go/pkg/config"
+	"github.com/gin-gonic/gin"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+// rolesAuthenticator accepts requests with an X-User header, granting the roles and scopes of
+// the X-Roles and X-Scopes headers.
+type rolesAuthenticator struct{}
+
+func (rolesAuthenticator) Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user := c.GetHeader("X-User")
+		if user == "" {
+			c.Header("WWW-Authenticate", "Bearer")
+			c.AbortWithStatus(http.StatusUnauthorized)
+			return
+		}
+		c.Set(RemoteUserKey, user)
+		c.Set(RolesKey, strings.Fields(c.GetHeader("X-Roles")))
+		c.Set(ScopesKey, strings.Fields(c.GetHeader("X-Scopes")))
+		c.Next()
+	}
+}
+
+var _ = Describe("Login middleware variants", func() {
+	ctx := ControllerContext{login: rolesAuthenticator{}.Middleware()}
+
+	serve := func(middleware gin.HandlerFunc, header http.Header) *httptest.ResponseRecorder {
+		gin.SetMode(gin.TestMode)
+		engine := gin.New()
+		engine.GET("/", middleware, func(c *gin.Context) {
+			user, ok := GetRemoteUser(c)
+			if !ok {
+				user = "anonymous"
+			}
+			c.String(http.StatusOK, user)
+		})
+
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header = header
+		engine.ServeHTTP(w, req)
+		return w
+	}
+
+	It("should populate the user if present and never reject with OptionalAuth", func() {
+		w := serve(ctx.OptionalAuth(), http.Header{"X-User": {"alice"}})
+		Expect(w.Code).To(Equal(http.StatusOK))
+		Expect(w.Body.String()).To(Equal("alice"))
+
+		w = serve(ctx.OptionalAuth(), http.Header{})
+		Expect(w.Code).To(Equal(http.StatusOK))
+		Expect(w.Body.String()).To(Equal("anonymous"))
+		Expect(w.Header().Get("WWW-Authenticate")).To(BeEmpty())
+	})
+
+	It("should require a role", func() {
+		w := serve(ctx.RequireRole("admin"), http.Header{"X-User": {"alice"}, "X-Roles": {"staff admin"}})
+		Expect(w.Code).To(Equal(http.StatusOK))
+		Expect(w.Body.String()).To(Equal("alice"))
+
+		Expect(serve(ctx.RequireRole("admin"), http.Header{"X-User": {"bob"}, "X-Roles": {"staff"}}).Code).To(Equal(http.StatusForbidden))
+
+		w = serve(ctx.RequireRole("admin"), http.Header{"X-Roles": {"admin"}})
+		Expect(w.Code).To(Equal(http.StatusUnauthorized))
+		Expect(w.Header().Get("WWW-Authenticate")).To(Equal("Bearer"))
+	})
+
+	It("should require a scope", func() {
+		Expect(serve(ctx.RequireScope("orders:write"), http.Header{"X-User": {"job"}, "X-Scopes": {"orders:read orders:write"}}).Code).To(Equal(http.StatusOK))
+
+		w := serve(ctx.RequireScope("orders:write"), http.Header{"X-User": {"job"}, "X-Scopes": {"orders:read"}})
+		Expect(w.Code).To(Equal(http.StatusForbidden))
+		Expect(w.Header().Get("WWW-Authenticate")).To(Equal(`Bearer error="insufficient_scope", scope="orders:write"`))
+
+		Expect(serve(ctx.RequireScope("orders:write"), http.Header{}).Code).To(Equal(http.StatusUnauthorized))
+	})
+
+	It("should reject every request without the login middleware of the server", func() {
+		Expect(serve(ControllerContext{}.RequireRole("admin"), http.Header{"X-User": {"alice"}, "X-Roles": {"admin"}}).Code).To(Equal(http.StatusUnauthorized))
+		Expect(serve(ControllerContext{}.OptionalAuth(), http.Header{"X-User": {"alice"}}).Body.String()).To(Equal("anonymous"))
+	})
+
+	It("should hand the login middleware of the binding to the controller", func() {
+		var received ControllerContext
+		addControllerType("variants-controller", func(_ config.ModuleRawConfig, ctx ControllerContext) (IController, error) {
+			received = ctx
+			return &MockController{}, nil
+		})
+		login := &loginMiddlewares{middlewares: map[string]gin.HandlerFunc{
+			DefaultAuthenticatorName: NewUnauthorizedAuthenticator().Middleware(),
+			"roles":                  rolesAuthenticator{}.Middleware(),
+		}}
+		_, errs := configureControllers(SargantanaConfig{ControllerBindings: ControllerBindings{
+			{TypeName: "variants-controller", Authenticator: "roles"},
+		}}, ControllerContext{}, login)
+		Expect(errs).To(BeEmpty())
+		Expect(serve(received.RequireRole("admin"), http.Header{"X-User": {"alice"}, "X-Roles": {"admin"}}).Code).To(Equal(http.StatusOK))
+	})
+})
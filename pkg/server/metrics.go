@@ -0,0 +1,98 @@
+package server
+
+import (
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const defaultMetricsPath = "/metrics"
+
+// unmatchedRoute labels the requests matching no route, to bound the cardinality of the metrics
+const unmatchedRoute = "unmatched"
+
+var (
+	httpRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "sargantana_http_request_duration_seconds",
+		Help:    "Duration of the requests, by route, method, status code and controller binding.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"route", "method", "code", "controller"})
+	httpRequestSize = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "sargantana_http_request_size_bytes",
+		Help:    "Size of the request bodies, by route, method and controller binding.",
+		Buckets: prometheus.ExponentialBuckets(128, 4, 8),
+	}, []string{"route", "method", "controller"})
+	httpResponseSize = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "sargantana_http_response_size_bytes",
+		Help:    "Size of the response bodies, by route, method, status code and controller binding.",
+		Buckets: prometheus.ExponentialBuckets(128, 4, 8),
+	}, []string{"route", "method", "code", "controller"})
+)
+
+// MetricsConfig exposes the Prometheus metrics of the server: the requests by route and controller
+// binding, the session store, the load balancer backends and the other subsystems.
+type MetricsConfig struct {
+	// Path serves the metrics. Defaults to /metrics.
+	Path string `yaml:"path,omitempty"`
+	// Address serves the metrics on a separate listener, e.g. "127.0.0.1:9090", instead of the
+	// server address, so that they are not exposed to the clients of the server.
+	Address string `yaml:"address,omitempty"`
+}
+
+func (c MetricsConfig) Validate() error {
+	if c.Path != "" && !strings.HasPrefix(c.Path, "/") {
+		return errors.New("path must start with /")
+	}
+	if c.Address != "" {
+		if _, err := net.ResolveTCPAddr("tcp", c.Address); err != nil {
+			return errors.Wrap(err, "invalid address")
+		}
+	}
+	return nil
+}
+
+func (c MetricsConfig) path() string {
+	if c.Path == "" {
+		return defaultMetricsPath
+	}
+	return c.Path
+}
+
+// metricsServer serves the metrics of the default Prometheus registry on the separate address
+func (c MetricsConfig) metricsServer() *http.Server {
+	mux := http.NewServeMux()
+	mux.Handle(c.path(), promhttp.Handler())
+	return &http.Server{
+		Addr:              c.Address,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+}
+
+// requestMetricsMiddleware observes the duration and sizes of the requests. The controller binding
+// is known once the request went through controllerRoutes.middleware.
+func requestMetricsMiddleware(c *gin.Context) {
+	start := time.Now()
+	c.Next()
+
+	route := c.FullPath()
+	if route == "" {
+		route = unmatchedRoute
+	}
+	method := c.Request.Method
+	code := strconv.Itoa(ResponseStatus(c))
+	controller := ControllerName(c)
+	httpRequestDuration.WithLabelValues(route, method, code, controller).Observe(time.Since(start).Seconds())
+	if size := c.Request.ContentLength; size >= 0 {
+		httpRequestSize.WithLabelValues(route, method, controller).Observe(float64(size))
+	}
+	httpResponseSize.WithLabelValues(route, method, code, controller).Observe(float64(max(c.Writer.Size(), 0)))
+}
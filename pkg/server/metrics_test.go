@@ -0,0 +1,111 @@
+//go:build unit
+
+package server
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	"github.com/animalet/sargantana-go/pkg/config"
+	"github.com/animalet/sargantana-go/pkg/server/session"
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Metrics", func() {
+	newServer := func(metrics *MetricsConfig) *Server {
+		addControllerType("metrics-controller", func(config.ModuleRawConfig, ControllerContext) (IController, error) {
+			return &MockController{BindFunc: func(engine *gin.Engine, _ gin.HandlerFunc) {
+				engine.POST("/items/:id", func(c *gin.Context) {
+					_ = sessions.Default(c).Get("user")
+					c.String(http.StatusCreated, "created")
+				})
+			}}, nil
+		})
+		s := NewServer(SargantanaConfig{
+			WebServerConfig: WebServerConfig{
+				Address:       "localhost:0",
+				SessionName:   "session",
+				SessionSecret: "secret",
+				Metrics:       metrics,
+			},
+			ControllerBindings: ControllerBindings{
+				{TypeName: "metrics-controller", Name: "items", Config: config.ModuleRawConfig("")},
+			},
+		})
+		s.SetSessionStore(session.NewMemorySessionStore(false, []byte("secret")))
+		return s
+	}
+
+	serve := func(handler http.Handler, method, path, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(method, path, strings.NewReader(body)))
+		return w
+	}
+
+	Context("MetricsConfig Validate", func() {
+		It("should validate the path and address", func() {
+			Expect(MetricsConfig{}.Validate()).To(Succeed())
+			Expect(MetricsConfig{Path: "/internal/metrics", Address: "127.0.0.1:9090"}.Validate()).To(Succeed())
+			Expect(MetricsConfig{Path: "metrics"}.Validate()).To(MatchError(ContainSubstring("must start with /")))
+			Expect(MetricsConfig{Address: "localhost:metrics"}.Validate()).To(MatchError(ContainSubstring("invalid address")))
+		})
+
+		It("should require a separate address", func() {
+			cfg := WebServerConfig{
+				Address:       "localhost:8080",
+				SessionName:   "session",
+				SessionSecret: "secret",
+				Metrics:       &MetricsConfig{Address: "localhost:8080"},
+			}
+			Expect(cfg.Validate()).To(MatchError(ContainSubstring("must differ")))
+			cfg.Metrics.Address = "localhost:9090"
+			Expect(cfg.Validate()).To(Succeed())
+		})
+	})
+
+	It("should observe the requests by route and controller binding, and serve the metrics", func() {
+		s := newServer(&MetricsConfig{})
+		Expect(s.bootstrap()).To(Succeed())
+		DeferCleanup(s.Shutdown)
+
+		Expect(serve(s.httpServer.Handler, http.MethodPost, "/items/1", "payload").Code).To(Equal(http.StatusCreated))
+		Expect(serve(s.httpServer.Handler, http.MethodGet, "/missing/1", "").Code).To(Equal(http.StatusNotFound))
+
+		response := serve(s.httpServer.Handler, http.MethodGet, "/metrics", "")
+		Expect(response.Code).To(Equal(http.StatusOK))
+		metrics := response.Body.String()
+		Expect(metrics).To(ContainSubstring(`sargantana_http_request_duration_seconds_count{code="201",controller="items",method="POST",route="/items/:id"}`))
+		Expect(metrics).To(ContainSubstring(`sargantana_http_request_size_bytes_sum{controller="items",method="POST",route="/items/:id"}`))
+		Expect(metrics).To(ContainSubstring(`sargantana_http_response_size_bytes_count{code="201",controller="items",method="POST",route="/items/:id"}`))
+		Expect(metrics).To(ContainSubstring(`sargantana_http_request_duration_seconds_count{code="404",controller="",method="GET",route="unmatched"}`))
+		Expect(metrics).To(ContainSubstring(`sargantana_session_store_operations_total{operation="load",result="ok",store="memory"}`))
+		Expect(metrics).To(ContainSubstring(`sargantana_sessions_active{store="memory"}`))
+	})
+
+	It("should keep the session count of the instrumented store for the shutdown report", func() {
+		s := newServer(&MetricsConfig{})
+		Expect(s.bootstrap()).To(Succeed())
+		_, counts := s.sessionStore.(sessionCounter)
+		Expect(counts).To(BeTrue())
+		Expect(s.Shutdown()).To(Succeed())
+	})
+
+	It("should serve the metrics on a separate address", func() {
+		s := newServer(&MetricsConfig{Path: "/internal/metrics", Address: "localhost:0"})
+		Expect(s.Start()).To(Succeed())
+		DeferCleanup(s.Shutdown)
+
+		Expect(serve(s.httpServer.Handler, http.MethodGet, "/internal/metrics", "").Code).To(Equal(http.StatusNotFound))
+		Expect(s.metricsServer).NotTo(BeNil())
+		response := serve(s.metricsServer.Handler, http.MethodGet, "/internal/metrics", "")
+		Expect(response.Code).To(Equal(http.StatusOK))
+		body, err := io.ReadAll(response.Body)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(body)).To(ContainSubstring("sargantana_http_request_duration_seconds"))
+	})
+})
@@ -0,0 +1,114 @@
+package server
+
+import (
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/animalet/sargantana-go/pkg/config"
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+)
+
+// MiddlewareConfig selects a middleware applied to the routes of a controller binding by the type
+// it was registered with, see RegisterMiddleware. Config holds the options of the type and may be
+// omitted if the type has none.
+type MiddlewareConfig struct {
+	TypeName string                 `yaml:"type"`
+	Config   config.ModuleRawConfig `yaml:"config,omitempty"`
+}
+
+func (c MiddlewareConfig) Validate() error {
+	if c.TypeName == "" {
+		return errors.New("middleware type must be set and non-empty")
+	}
+	return nil
+}
+
+// MiddlewareFactory is a factory function that creates a middleware from its configuration and
+// the context of the controller it is applied to.
+type MiddlewareFactory func(middlewareConfig config.ModuleRawConfig, ctx ControllerContext) (gin.HandlerFunc, error)
+
+// middlewareRegistry holds the mapping of middleware type names to their factory functions.
+var middlewareRegistry = make(map[string]MiddlewareFactory)
+
+// RegisterMiddleware registers a middleware factory that takes a typed configuration, making the
+// type selectable in the middlewares of controller bindings. T must implement config.Validatable.
+//
+// Example usage:
+//
+//	server.RegisterMiddleware("require_role", func(c *server.RoleMiddlewareConfig, ctx server.ControllerContext) (gin.HandlerFunc, error) {
+//	    return ctx.RequireRole(c.Role), nil
+//	})
+func RegisterMiddleware[T config.Validatable](typeName string, factory func(cfg *T, ctx ControllerContext) (gin.HandlerFunc, error)) {
+	log.Info().Msgf("Registering middleware type %q", typeName)
+	if _, exists := middlewareRegistry[typeName]; exists {
+		log.Warn().Msgf("Middleware type %q is already registered, overriding", typeName)
+	}
+	middlewareRegistry[typeName] = func(raw config.ModuleRawConfig, ctx ControllerContext) (gin.HandlerFunc, error) {
+		if len(raw) == 0 {
+			cfg := new(T)
+			if err := (*cfg).Validate(); err != nil {
+				return nil, errors.Wrapf(err, "configuration for middleware type %s is required", typeName)
+			}
+			return factory(cfg, ctx)
+		}
+		cfg, err := config.Unmarshal[T](raw)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to unmarshal configuration for middleware type %s", typeName)
+		}
+		return factory(cfg, ctx)
+	}
+}
+
+// groupMiddlewares creates the middlewares of the router group of a controller binding: the host
+// restriction, if any, followed by the configured middlewares, traced if trace is set, see
+// MiddlewareTracer.
+func groupMiddlewares(binding ControllerBinding, ctx ControllerContext, trace bool) ([]gin.HandlerFunc, error) {
+	var middlewares []gin.HandlerFunc
+	if len(binding.Hosts) > 0 {
+		middlewares = append(middlewares, hostMatcher(binding.Hosts))
+	}
+	var configured []gin.HandlerFunc
+	names := make([]string, 0, len(binding.Middlewares))
+	for _, middlewareCfg := range binding.Middlewares {
+		factory, exists := middlewareRegistry[middlewareCfg.TypeName]
+		if !exists {
+			return nil, errors.Errorf("no factory found for middleware type %q", middlewareCfg.TypeName)
+		}
+		middleware, err := factory(middlewareCfg.Config, ctx)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to configure middleware of type %q", middlewareCfg.TypeName)
+		}
+		configured = append(configured, middleware)
+		names = append(names, middlewareCfg.TypeName)
+	}
+	if trace && len(configured) > 0 {
+		configured = traceMiddlewares(names, configured)
+	}
+	return append(middlewares, configured...), nil
+}
+
+// hostMatcher returns a middleware answering 404 Not Found to requests for other hosts. Patterns
+// starting with "*." match any subdomain.
+func hostMatcher(hosts []string) gin.HandlerFunc {
+	patterns := make([]string, len(hosts))
+	for i, host := range hosts {
+		patterns[i] = strings.ToLower(host)
+	}
+	return func(c *gin.Context) {
+		host := c.Request.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		host = strings.ToLower(host)
+		for _, pattern := range patterns {
+			if host == pattern || (strings.HasPrefix(pattern, "*.") && strings.HasSuffix(host, pattern[1:])) {
+				c.Next()
+				return
+			}
+		}
+		c.AbortWithStatus(http.StatusNotFound)
+	}
+}
@@ -0,0 +1,175 @@
+//go:build unit
+
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/animalet/sargantana-go/pkg/config"
+	"github.com/gin-contrib/sessions/cookie"
+	"github.com/gin-gonic/gin"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+// groupController serves its name on /name below its router group.
+type groupController struct {
+	MockController
+	name string
+}
+
+func (g *groupController) Bind(engine *gin.Engine, loginMiddleware gin.HandlerFunc) error {
+	return g.BindGroup(&engine.RouterGroup, loginMiddleware)
+}
+
+func (g *groupController) BindGroup(group *gin.RouterGroup, _ gin.HandlerFunc) error {
+	group.GET("/name", func(c *gin.Context) {
+		c.String(http.StatusOK, g.name+c.GetHeader("X-Tag")+c.Writer.Header().Get("X-Tag"))
+	})
+	return nil
+}
+
+// tracingController echoes the request modifications of its binding middlewares on /modifications
+type tracingController struct {
+	groupController
+}
+
+func (t *tracingController) TraceMiddlewares() bool {
+	return true
+}
+
+func (t *tracingController) BindGroup(group *gin.RouterGroup, _ gin.HandlerFunc) error {
+	group.GET("/modifications", func(c *gin.Context) {
+		c.JSON(http.StatusOK, RequestModifications(c))
+	})
+	return nil
+}
+
+// TagMiddlewareConfig configures the tag set by the test middleware.
+type TagMiddlewareConfig struct {
+	Tag string `yaml:"tag"`
+}
+
+func (c TagMiddlewareConfig) Validate() error {
+	return nil
+}
+
+var _ = Describe("Controller router groups", func() {
+	BeforeEach(func() {
+		addControllerType("group-controller", func(raw config.ModuleRawConfig, ctx ControllerContext) (IController, error) {
+			return &groupController{name: ctx.Name}, nil
+		})
+		addControllerType("engine-controller", func(config.ModuleRawConfig, ControllerContext) (IController, error) {
+			return &MockController{}, nil
+		})
+		RegisterMiddleware("test-tag", func(c *TagMiddlewareConfig, ctx ControllerContext) (gin.HandlerFunc, error) {
+			return func(g *gin.Context) {
+				g.Header("X-Tag", " "+c.Tag+"@"+ctx.Name)
+			}, nil
+		})
+		addControllerType("tracing-controller", func(config.ModuleRawConfig, ControllerContext) (IController, error) {
+			return &tracingController{}, nil
+		})
+		RegisterMiddleware("test-rewrite", func(c *TagMiddlewareConfig, _ ControllerContext) (gin.HandlerFunc, error) {
+			return func(g *gin.Context) {
+				g.Request.Header.Set("X-Tag", c.Tag)
+				g.Request.Header.Del("X-Remove")
+				g.Request.Host = "rewritten.example.org"
+			}, nil
+		})
+	})
+
+	bootstrap := func(bindings ...ControllerBinding) http.Handler {
+		s := NewServer(SargantanaConfig{
+			WebServerConfig:    WebServerConfig{Address: "localhost:0", SessionName: "test-session", SessionSecret: "secret"},
+			ControllerBindings: bindings,
+		})
+		s.SetSessionStore(cookie.NewStore([]byte("secret")))
+		Expect(s.bootstrap()).To(Succeed())
+		DeferCleanup(s.Shutdown)
+		return s.httpServer.Handler
+	}
+
+	get := func(handler http.Handler, host, path string) string {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Host = host
+		handler.ServeHTTP(w, req)
+		if w.Code != http.StatusOK {
+			return http.StatusText(w.Code)
+		}
+		return w.Body.String()
+	}
+
+	It("should mount controllers below their base path, host and middlewares", func() {
+		handler := bootstrap(
+			ControllerBinding{TypeName: "group-controller", Name: "root", Config: config.ModuleRawConfig{}},
+			ControllerBinding{TypeName: "group-controller", Name: "a", BasePath: "/a", Config: config.ModuleRawConfig{}},
+			ControllerBinding{TypeName: "group-controller", Name: "b", BasePath: "/b", Hosts: []string{"Example.org", "*.example.net"}, Config: config.ModuleRawConfig{},
+				Middlewares: []MiddlewareConfig{{TypeName: "test-tag", Config: config.ModuleRawConfig("tag: blue")}}},
+		)
+
+		Expect(get(handler, "any", "/name")).To(Equal("root"))
+		Expect(get(handler, "any", "/a/name")).To(Equal("a"))
+		Expect(get(handler, "example.org:8080", "/b/name")).To(Equal("b blue@b"))
+		Expect(get(handler, "api.example.net", "/b/name")).To(Equal("b blue@b"))
+		Expect(get(handler, "example.net", "/b/name")).To(Equal("Not Found"))
+		Expect(get(handler, "other.org", "/b/name")).To(Equal("Not Found"))
+	})
+
+	It("should trace the changes of the middlewares of tracing controllers", func() {
+		handler := bootstrap(
+			ControllerBinding{TypeName: "tracing-controller", BasePath: "/traced", Config: config.ModuleRawConfig{},
+				Middlewares: []MiddlewareConfig{
+					{TypeName: "test-rewrite", Config: config.ModuleRawConfig("tag: blue")},
+					{TypeName: "test-tag", Config: config.ModuleRawConfig("tag: none")},
+					{TypeName: "test-rewrite", Config: config.ModuleRawConfig("tag: green")},
+				}},
+		)
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/traced/modifications", nil)
+		req.Header.Set("X-Remove", "1")
+		handler.ServeHTTP(w, req)
+		Expect(w.Code).To(Equal(http.StatusOK))
+		Expect(w.Body.String()).To(MatchJSON(`[
+			{"middleware": "test-rewrite", "added": ["X-Tag"], "removed": ["X-Remove"], "host": "rewritten.example.org"},
+			{"middleware": "test-rewrite", "changed": ["X-Tag"]}
+		]`))
+	})
+
+	It("should exclude controllers with unsupported or invalid group options", func() {
+		handler := bootstrap(
+			ControllerBinding{TypeName: "group-controller", Name: "ok", BasePath: "/ok", Config: config.ModuleRawConfig{}},
+			ControllerBinding{TypeName: "group-controller", Name: "unknown", BasePath: "/unknown", Config: config.ModuleRawConfig{},
+				Middlewares: []MiddlewareConfig{{TypeName: "missing"}}},
+		)
+		Expect(get(handler, "any", "/ok/name")).To(Equal("ok"))
+		Expect(get(handler, "any", "/unknown/name")).To(Equal("Not Found"))
+
+		closed := false
+		addControllerType("engine-controller", func(config.ModuleRawConfig, ControllerContext) (IController, error) {
+			return &MockController{CloseFunc: func() error { closed = true; return nil }}, nil
+		})
+		_, errs := configureControllers(SargantanaConfig{ControllerBindings: ControllerBindings{
+			{TypeName: "engine-controller", BasePath: "/engine", Config: config.ModuleRawConfig{}},
+		}}, ControllerContext{}, &loginMiddlewares{middlewares: map[string]gin.HandlerFunc{DefaultAuthenticatorName: nil}})
+		Expect(errs).To(ConsistOf(MatchError(ContainSubstring("not supported by this controller type"))))
+		Expect(closed).To(BeTrue())
+	})
+
+	It("should validate the group options of bindings", func() {
+		binding := ControllerBinding{TypeName: "group-controller", Config: config.ModuleRawConfig{}}
+		Expect(binding.Validate()).To(Succeed())
+
+		invalid := binding
+		invalid.BasePath = "api"
+		Expect(invalid.Validate()).To(MatchError(ContainSubstring("base_path must start with /")))
+		invalid = binding
+		invalid.Hosts = []string{"https://example.org"}
+		Expect(invalid.Validate()).To(MatchError(ContainSubstring("invalid host")))
+		invalid = binding
+		invalid.Middlewares = []MiddlewareConfig{{}}
+		Expect(invalid.Validate()).To(MatchError(ContainSubstring("invalid middleware at index 0")))
+	})
+})
@@ -0,0 +1,106 @@
+package server
+
+import (
+	"net/http"
+	"slices"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	requestSnapshotKey      = "sargantana.request_snapshot"
+	requestModificationsKey = "sargantana.request_modifications"
+)
+
+// MiddlewareTracer is implemented by the controllers whose binding middlewares are traced, so that
+// their handlers can report the changes each middleware made to the request, see
+// RequestModifications. Tracing copies the request headers after every middleware, so it is meant
+// for debugging controllers only.
+type MiddlewareTracer interface {
+	TraceMiddlewares() bool
+}
+
+// RequestModification describes the changes a binding middleware made to the request.
+type RequestModification struct {
+	// Middleware is the type of the middleware, e.g. "headers".
+	Middleware string `json:"middleware"`
+	// Added, Changed and Removed are the names of the request headers set, changed and deleted.
+	Added   []string `json:"added,omitempty"`
+	Changed []string `json:"changed,omitempty"`
+	Removed []string `json:"removed,omitempty"`
+	// URL and Host are the rewritten URL and host, if changed.
+	URL  string `json:"url,omitempty"`
+	Host string `json:"host,omitempty"`
+}
+
+func (m RequestModification) empty() bool {
+	return len(m.Added) == 0 && len(m.Changed) == 0 && len(m.Removed) == 0 && m.URL == "" && m.Host == ""
+}
+
+// RequestModifications returns the changes made to the request by the middlewares of the binding
+// of a MiddlewareTracer, in order. Middlewares that changed nothing are omitted.
+func RequestModifications(c *gin.Context) []RequestModification {
+	modifications, _ := c.Get(requestModificationsKey)
+	list, _ := modifications.([]RequestModification)
+	return list
+}
+
+// requestSnapshot holds the parts of a request compared after each traced middleware
+type requestSnapshot struct {
+	header http.Header
+	url    string
+	host   string
+}
+
+func snapshotRequest(c *gin.Context) {
+	c.Set(requestSnapshotKey, requestSnapshot{header: c.Request.Header.Clone(), url: c.Request.URL.String(), host: c.Request.Host})
+}
+
+// traceMiddlewares interleaves the middlewares with handlers recording the changes each of them
+// made to the request before calling the next handler
+func traceMiddlewares(names []string, middlewares []gin.HandlerFunc) []gin.HandlerFunc {
+	traced := make([]gin.HandlerFunc, 0, 2*len(middlewares)+1)
+	traced = append(traced, snapshotRequest)
+	for i, middleware := range middlewares {
+		traced = append(traced, middleware, recordModification(names[i]))
+	}
+	return traced
+}
+
+func recordModification(name string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		value, _ := c.Get(requestSnapshotKey)
+		before, ok := value.(requestSnapshot)
+		if !ok {
+			return
+		}
+		modification := RequestModification{Middleware: name}
+		for key, values := range c.Request.Header {
+			previous, found := before.header[key]
+			switch {
+			case !found:
+				modification.Added = append(modification.Added, key)
+			case !slices.Equal(previous, values):
+				modification.Changed = append(modification.Changed, key)
+			}
+		}
+		for key := range before.header {
+			if _, found := c.Request.Header[key]; !found {
+				modification.Removed = append(modification.Removed, key)
+			}
+		}
+		slices.Sort(modification.Added)
+		slices.Sort(modification.Changed)
+		slices.Sort(modification.Removed)
+		if url := c.Request.URL.String(); url != before.url {
+			modification.URL = url
+		}
+		if c.Request.Host != before.host {
+			modification.Host = c.Request.Host
+		}
+		if !modification.empty() {
+			c.Set(requestModificationsKey, append(RequestModifications(c), modification))
+			snapshotRequest(c)
+		}
+	}
+}
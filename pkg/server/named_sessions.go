@@ -0,0 +1,82 @@
+package server
+
+import (
+	"time"
+
+	"github.com/animalet/sargantana-go/pkg/server/session"
+	"github.com/animalet/sargantana-go/pkg/sessionsx"
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+)
+
+// NamedSessionConfig is an additional session stored in its own cookie next to the default session,
+// e.g. a long-lived preferences session. Handlers read it with sessionsx.GetNamed or
+// sessionsx.Named.
+type NamedSessionConfig struct {
+	// Name is the name of the session cookie.
+	Name string `yaml:"name"`
+	// MaxAge is the lifetime of the session. Defaults to the lifetime of the default session, 24h
+	// with the stores of the server.
+	MaxAge time.Duration `yaml:"max_age,omitempty"`
+}
+
+func (c NamedSessionConfig) Validate() error {
+	if c.Name == "" {
+		return errors.New("name must be set and non-empty")
+	}
+	if c.MaxAge < 0 {
+		return errors.New("max_age must not be negative")
+	}
+	if c.MaxAge > 0 && c.MaxAge < time.Second {
+		return errors.New("max_age must be at least one second")
+	}
+	return nil
+}
+
+func validateNamedSessions(defaultName string, configs []NamedSessionConfig) error {
+	names := map[string]bool{defaultName: true}
+	for _, c := range configs {
+		if err := c.Validate(); err != nil {
+			return err
+		}
+		if names[c.Name] {
+			return errors.Errorf("duplicate session name %q", c.Name)
+		}
+		names[c.Name] = true
+	}
+	return nil
+}
+
+// sessionMiddlewares serves the default session and the named sessions, with their lifetimes. The
+// named sessions are kept under sessionsx.NamedKey, so that sessions.Default still returns the
+// default session. The session key of the authenticated user is set per request, see
+// sessionsx.SetUserKey.
+func sessionMiddlewares(defaultName, userKey string, configs []NamedSessionConfig, store sessions.Store) []gin.HandlerFunc {
+	if len(configs) == 0 {
+		return []gin.HandlerFunc{sessions.Sessions(defaultName, store), func(c *gin.Context) {
+			sessionsx.SetUserKey(c, userKey)
+			c.Next()
+		}}
+	}
+	names := make([]string, 0, len(configs)+1)
+	names = append(names, defaultName)
+	maxAges := make(map[string]int)
+	for _, c := range configs {
+		names = append(names, c.Name)
+		if c.MaxAge > 0 {
+			maxAges[c.Name] = int(c.MaxAge / time.Second)
+		}
+	}
+	if len(maxAges) > 0 {
+		store = session.WithMaxAges(store, maxAges)
+	}
+	return []gin.HandlerFunc{sessions.SessionsMany(names, store), func(c *gin.Context) {
+		// SessionsMany stores all the sessions under sessions.DefaultKey
+		all := c.MustGet(sessions.DefaultKey).(map[string]sessions.Session)
+		c.Set(sessionsx.NamedKey, all)
+		c.Set(sessions.DefaultKey, all[defaultName])
+		sessionsx.SetUserKey(c, userKey)
+		c.Next()
+	}}
+}
@@ -0,0 +1,81 @@
+//go:build unit
+
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	"github.com/animalet/sargantana-go/pkg/server/session"
+	"github.com/animalet/sargantana-go/pkg/sessionsx"
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Named sessions", func() {
+	It("should validate the sessions", func() {
+		Expect(validateNamedSessions("session", []NamedSessionConfig{{Name: "prefs", MaxAge: 720 * time.Hour}})).To(Succeed())
+		Expect(validateNamedSessions("session", []NamedSessionConfig{{Name: "session"}})).To(MatchError(ContainSubstring("duplicate")))
+		Expect(validateNamedSessions("session", []NamedSessionConfig{{Name: "prefs"}, {Name: "prefs"}})).To(MatchError(ContainSubstring("duplicate")))
+		Expect(validateNamedSessions("session", []NamedSessionConfig{{}})).To(MatchError(ContainSubstring("name must be set")))
+		Expect(validateNamedSessions("session", []NamedSessionConfig{{Name: "prefs", MaxAge: -time.Hour}})).To(HaveOccurred())
+		Expect(WebServerConfig{
+			Address: "localhost:8080", SessionName: "s", SessionSecret: "secret",
+			Sessions: []NamedSessionConfig{{Name: "s"}},
+		}.Validate()).To(MatchError(ContainSubstring("invalid sessions configuration")))
+	})
+
+	It("should store the named sessions in their own cookies with their lifetime", func() {
+		engine := gin.New()
+		engine.Use(sessionMiddlewares("session", "", []NamedSessionConfig{
+			{Name: "prefs", MaxAge: 720 * time.Hour},
+			{Name: "cart"},
+		}, session.NewCookieStore(false, []byte("secret")))...)
+		engine.GET("/", func(c *gin.Context) {
+			Expect(sessionsx.Set(c, "theme", "light")).To(Succeed())
+			Expect(sessionsx.SetNamed(c, "prefs", "theme", "dark")).To(Succeed())
+			Expect(sessionsx.SetNamed(c, "cart", "items", []string{"apple"})).To(Succeed())
+		})
+		engine.GET("/theme", func(c *gin.Context) {
+			theme, _ := sessionsx.GetNamed[string](c, "prefs", "theme")
+			Expect(sessions.Default(c).Get("theme")).To(Equal("light"))
+			_, ok := sessionsx.GetNamed[string](c, "unknown", "theme")
+			Expect(ok).To(BeFalse())
+			Expect(sessionsx.SetNamed(c, "unknown", "theme", "dark")).To(MatchError(ContainSubstring("unknown session")))
+			c.String(http.StatusOK, theme)
+		})
+
+		w := httptest.NewRecorder()
+		engine.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+		maxAges := map[string]int{}
+		req := httptest.NewRequest(http.MethodGet, "/theme", nil)
+		for _, c := range w.Result().Cookies() {
+			maxAges[c.Name] = c.MaxAge
+			req.AddCookie(c)
+		}
+		Expect(maxAges).To(Equal(map[string]int{"session": 86400, "prefs": 2592000, "cart": 86400}))
+
+		w = httptest.NewRecorder()
+		engine.ServeHTTP(w, req)
+		Expect(w.Body.String()).To(Equal("dark"))
+	})
+
+	It("should store the user under the key of each server", func() {
+		store := session.NewCookieStore(false, []byte("secret"))
+		for _, sessionConfigs := range [][]NamedSessionConfig{nil, {{Name: "prefs"}}} {
+			keys := map[string]string{}
+			for _, userKey := range []string{"", "account"} {
+				engine := gin.New()
+				engine.Use(sessionMiddlewares("session", userKey, sessionConfigs, store)...)
+				engine.GET("/", func(c *gin.Context) {
+					keys[userKey] = sessionsx.CurrentUserKey(c)
+				})
+				engine.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+			}
+			Expect(keys).To(Equal(map[string]string{"": sessionsx.UserKey, "account": "account"}))
+		}
+	})
+})
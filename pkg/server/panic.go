@@ -0,0 +1,68 @@
+package server
+
+import (
+	"fmt"
+	"html"
+	"net/http"
+	runtimedebug "runtime/debug"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var controllerPanics = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "sargantana_controller_panics_total",
+	Help: "Number of panics recovered in the handlers of each controller binding.",
+}, []string{"controller", "type"})
+
+// errorPage is the HTML error response for browsers, with the status code, the status text and
+// the request ID
+const errorPage = `<!DOCTYPE html>
+<html>
+<head><title>%[1]d %[2]s</title></head>
+<body>
+<h1>%[1]d %[2]s</h1>
+<p>Request ID: <code>%[3]s</code></p>
+</body>
+</html>
+`
+
+// RespondError aborts the request with the standard error response of the server: an HTML page
+// for clients accepting HTML, such as browsers, or a JSON envelope with the status text and the
+// request ID, e.g. {"error": "Internal Server Error", "request_id": "..."}.
+func RespondError(c *gin.Context, status int) {
+	if c.NegotiateFormat(gin.MIMEJSON, gin.MIMEHTML) == gin.MIMEHTML {
+		page := fmt.Sprintf(errorPage, status, http.StatusText(status), html.EscapeString(RequestID(c)))
+		c.Data(status, "text/html; charset=utf-8", []byte(page))
+		c.Abort()
+		return
+	}
+	c.AbortWithStatusJSON(status, gin.H{"error": http.StatusText(status), "request_id": RequestID(c)})
+}
+
+// recoverControllerPanic recovers the panics of the handlers of a controller binding, so that they
+// are logged and counted per binding and answered with the standard error response. Aborted
+// handlers, see http.ErrAbortHandler, keep panicking to close the connection.
+func recoverControllerPanic(c *gin.Context, labels controllerLabels) {
+	r := recover()
+	if r == nil {
+		return
+	}
+	if err, ok := r.(error); ok && errors.Is(err, http.ErrAbortHandler) {
+		panic(r)
+	}
+	controllerPanics.WithLabelValues(labels.name, labels.typeName).Inc()
+	logger := RequestLogger(c)
+	logger.Error().
+		Str("panic", fmt.Sprint(r)).
+		Bytes("stack", runtimedebug.Stack()).
+		Msg("Controller handler panicked")
+	if c.Writer.Written() {
+		// The response has started, it can only be cut short
+		c.Abort()
+		return
+	}
+	RespondError(c, http.StatusInternalServerError)
+}
@@ -0,0 +1,103 @@
+//go:build unit
+
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/animalet/sargantana-go/pkg/config"
+	"github.com/gin-contrib/sessions/cookie"
+	"github.com/gin-gonic/gin"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+var _ = Describe("Controller panics", func() {
+	var (
+		s    *Server
+		logs bytes.Buffer
+	)
+
+	BeforeEach(func() {
+		logs.Reset()
+		originalLogger := log.Logger
+		log.Logger = zerolog.New(&logs)
+		DeferCleanup(func() { log.Logger = originalLogger })
+
+		addControllerType("panicking-controller", func(raw config.ModuleRawConfig, ctx ControllerContext) (IController, error) {
+			prefix := string(raw)
+			return &MockController{BindFunc: func(engine *gin.Engine, _ gin.HandlerFunc) {
+				engine.GET(prefix+"/panic", func(c *gin.Context) {
+					panic("boom")
+				})
+				engine.GET(prefix+"/partial", func(c *gin.Context) {
+					c.String(http.StatusOK, "partial")
+					panic("boom")
+				})
+				engine.GET(prefix+"/ok", func(c *gin.Context) {
+					c.String(http.StatusOK, "ok")
+				})
+			}}, nil
+		})
+		s = NewServer(SargantanaConfig{
+			WebServerConfig: WebServerConfig{Address: "localhost:0", SessionName: "test-session", SessionSecret: "secret"},
+			ControllerBindings: ControllerBindings{
+				{TypeName: "panicking-controller", Name: "faulty", Config: config.ModuleRawConfig("/faulty")},
+				{TypeName: "panicking-controller", Name: "healthy", Config: config.ModuleRawConfig("/healthy")},
+			},
+		})
+		s.SetSessionStore(cookie.NewStore([]byte("secret")))
+		Expect(s.bootstrap()).To(Succeed())
+		DeferCleanup(s.Shutdown)
+	})
+
+	get := func(path, accept string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, path, nil)
+		r.Header.Set("Accept", accept)
+		r.Header.Set(RequestIDHeader, "<req-1>")
+		s.httpServer.Handler.ServeHTTP(w, r)
+		return w
+	}
+
+	It("should answer with the JSON error envelope and count the panic of the binding", func() {
+		before := testutil.ToFloat64(controllerPanics.WithLabelValues("faulty", "panicking-controller"))
+
+		w := get("/faulty/panic", "application/json")
+		Expect(w.Code).To(Equal(http.StatusInternalServerError))
+		var envelope map[string]string
+		Expect(json.Unmarshal(w.Body.Bytes(), &envelope)).To(Succeed())
+		Expect(envelope).To(Equal(map[string]string{"error": "Internal Server Error", "request_id": "<req-1>"}))
+
+		Expect(testutil.ToFloat64(controllerPanics.WithLabelValues("faulty", "panicking-controller")) - before).To(Equal(1.0))
+		Expect(testutil.ToFloat64(controllerRequests.WithLabelValues("faulty", "panicking-controller", http.MethodGet, "500"))).To(BeNumerically(">=", 1))
+		Expect(logs.String()).To(ContainSubstring(`"controller":"faulty"`))
+		Expect(logs.String()).To(ContainSubstring(`"panic":"boom"`))
+	})
+
+	It("should answer browsers with the error page", func() {
+		w := get("/faulty/panic", "text/html,application/xhtml+xml")
+		Expect(w.Code).To(Equal(http.StatusInternalServerError))
+		Expect(w.Header().Get("Content-Type")).To(Equal("text/html; charset=utf-8"))
+		Expect(w.Body.String()).To(ContainSubstring("<h1>500 Internal Server Error</h1>"))
+		Expect(w.Body.String()).To(ContainSubstring("&lt;req-1&gt;"))
+	})
+
+	It("should keep the started responses", func() {
+		w := get("/faulty/partial", "application/json")
+		Expect(w.Code).To(Equal(http.StatusOK))
+		Expect(w.Body.String()).To(Equal("partial"))
+	})
+
+	It("should keep serving the other routes and bindings", func() {
+		Expect(get("/faulty/panic", "application/json").Code).To(Equal(http.StatusInternalServerError))
+		Expect(get("/faulty/ok", "application/json").Body.String()).To(Equal("ok"))
+		Expect(get("/healthy/ok", "application/json").Body.String()).To(Equal("ok"))
+	})
+})
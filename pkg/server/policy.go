@@ -0,0 +1,299 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+)
+
+const defaultPolicyTimeout = time.Second
+
+// credentialHeaders are not sent to the policies unless listed explicitly
+var credentialHeaders = []string{"Authorization", "Cookie", "Proxy-Authorization"}
+
+// PolicyInput is the input of the authorization policies: the authenticated user, empty for
+// anonymous requests, and the request. Header names are lower case and repeated headers are joined
+// with commas.
+type PolicyInput struct {
+	User     string            `json:"user"`
+	Roles    []string          `json:"roles"`
+	Scopes   []string          `json:"scopes"`
+	Method   string            `json:"method"`
+	Path     string            `json:"path"`
+	Query    map[string]string `json:"query"`
+	Headers  map[string]string `json:"headers"`
+	Host     string            `json:"host"`
+	ClientIP string            `json:"client_ip"`
+}
+
+// PolicyDecision is the decision of an authorization policy. Denied requests are answered with
+// Status, 403 Forbidden by default, and Headers, e.g. a WWW-Authenticate challenge.
+type PolicyDecision struct {
+	Allow   bool              `json:"allow"`
+	Status  int               `json:"status,omitempty"`
+	Headers map[string]string `json:"headers,omitempty"`
+	Reason  string            `json:"reason,omitempty"`
+}
+
+// PolicyEvaluator decides whether requests are authorized, e.g. by evaluating Rego policies
+// embedded with the OPA Go SDK.
+type PolicyEvaluator interface {
+	Evaluate(ctx context.Context, input PolicyInput) (PolicyDecision, error)
+}
+
+// PolicyEvaluatorFunc adapts a function to PolicyEvaluator.
+type PolicyEvaluatorFunc func(ctx context.Context, input PolicyInput) (PolicyDecision, error)
+
+func (f PolicyEvaluatorFunc) Evaluate(ctx context.Context, input PolicyInput) (PolicyDecision, error) {
+	return f(ctx, input)
+}
+
+var (
+	policyEvaluatorsMu sync.RWMutex
+	policyEvaluators   = make(map[string]PolicyEvaluator)
+)
+
+// RegisterPolicyEvaluator makes an evaluator selectable by name in the evaluator field of the
+// policy middleware type.
+//
+// Example usage:
+//
+//	server.RegisterPolicyEvaluator("rego", server.PolicyEvaluatorFunc(func(ctx context.Context, input server.PolicyInput) (server.PolicyDecision, error) {
+//	    results, err := query.Eval(ctx, rego.EvalInput(input))
+//	    return server.PolicyDecision{Allow: err == nil && results.Allowed()}, err
+//	}))
+func RegisterPolicyEvaluator(name string, evaluator PolicyEvaluator) {
+	log.Info().Msgf("Registering policy evaluator %q", name)
+	policyEvaluatorsMu.Lock()
+	defer policyEvaluatorsMu.Unlock()
+	if _, exists := policyEvaluators[name]; exists {
+		log.Warn().Msgf("Policy evaluator %q is already registered, overriding", name)
+	}
+	policyEvaluators[name] = evaluator
+}
+
+// PolicyConfig configures the policy middleware type, which authorizes the requests of a binding
+// with a policy evaluated by an Open Policy Agent server, usually a sidecar, or by an evaluator
+// registered with RegisterPolicyEvaluator. The user is part of the input, so the middleware should
+// follow the login or optional_auth middleware of the binding.
+type PolicyConfig struct {
+	OPA *OPAConfig `yaml:"opa,omitempty"`
+	// Evaluator is the name of a registered evaluator, used instead of an OPA server.
+	Evaluator string `yaml:"evaluator,omitempty"`
+	// Headers are the request headers in the input. Defaults to all of them but Authorization,
+	// Cookie and Proxy-Authorization.
+	Headers []string `yaml:"headers,omitempty"`
+	// FailOpen lets the requests through when the policy cannot be evaluated, instead of answering
+	// 503 Service Unavailable.
+	FailOpen bool `yaml:"fail_open,omitempty"`
+}
+
+// OPAConfig configures the Open Policy Agent server evaluating the policy with its Data API.
+type OPAConfig struct {
+	// URL is the address of the server, e.g. http://localhost:8181.
+	URL string `yaml:"url"`
+	// Policy is the path of the decision in the data tree, e.g. httpapi/authz/allow. The decision
+	// is a boolean or an object with the fields of PolicyDecision; an undefined decision denies.
+	Policy string `yaml:"policy"`
+	// Timeout bounds the evaluation. Defaults to 1s.
+	Timeout time.Duration `yaml:"timeout,omitempty"`
+	// Headers are added to the requests to the server, e.g. an Authorization bearer token.
+	Headers map[string]string `yaml:"headers,omitempty"`
+}
+
+func (c PolicyConfig) Validate() error {
+	if (c.OPA == nil) == (c.Evaluator == "") {
+		return errors.New("exactly one of opa and evaluator must be set")
+	}
+	if c.OPA != nil {
+		if err := c.OPA.Validate(); err != nil {
+			return errors.Wrap(err, "invalid opa configuration")
+		}
+	}
+	return nil
+}
+
+func (c OPAConfig) Validate() error {
+	parsed, err := url.ParseRequestURI(c.URL)
+	if err != nil {
+		return errors.Wrap(err, "invalid url")
+	}
+	if parsed.Scheme != "http" && parsed.Scheme != "https" {
+		return errors.New("url must use http or https")
+	}
+	if strings.Trim(c.Policy, "/") == "" {
+		return errors.New("policy must be set and non-empty")
+	}
+	if c.Timeout < 0 {
+		return errors.New("timeout must not be negative")
+	}
+	return nil
+}
+
+// NewPolicyMiddleware creates the middleware of the policy middleware type.
+func NewPolicyMiddleware(c PolicyConfig) (gin.HandlerFunc, error) {
+	var evaluator PolicyEvaluator
+	if c.OPA != nil {
+		evaluator = NewOPAEvaluator(*c.OPA)
+	} else {
+		policyEvaluatorsMu.RLock()
+		registered, ok := policyEvaluators[c.Evaluator]
+		policyEvaluatorsMu.RUnlock()
+		if !ok {
+			return nil, errors.Errorf("no policy evaluator registered as %q", c.Evaluator)
+		}
+		evaluator = registered
+	}
+	return (&policy{config: c, evaluator: evaluator}).middleware, nil
+}
+
+type policy struct {
+	config    PolicyConfig
+	evaluator PolicyEvaluator
+}
+
+func (p *policy) middleware(c *gin.Context) {
+	decision, err := p.evaluator.Evaluate(c.Request.Context(), p.input(c))
+	if err != nil {
+		logger := RequestLogger(c)
+		logger.Error().Err(err).Msg("Failed to evaluate authorization policy")
+		if p.config.FailOpen {
+			c.Next()
+			return
+		}
+		RespondError(c, http.StatusServiceUnavailable)
+		return
+	}
+	if !decision.Allow {
+		log.Debug().Str("path", c.Request.URL.Path).Str("reason", decision.Reason).Msg("Request denied by authorization policy")
+		for name, value := range decision.Headers {
+			c.Header(name, value)
+		}
+		status := decision.Status
+		if status < http.StatusBadRequest || status > 599 {
+			status = http.StatusForbidden
+		}
+		RespondError(c, status)
+		return
+	}
+	c.Next()
+}
+
+// input describes the request to the policy
+func (p *policy) input(c *gin.Context) PolicyInput {
+	input := PolicyInput{
+		User:     requestUser(c),
+		Roles:    GetRoles(c),
+		Scopes:   GetScopes(c),
+		Method:   c.Request.Method,
+		Path:     c.Request.URL.Path,
+		Query:    make(map[string]string),
+		Headers:  make(map[string]string),
+		Host:     c.Request.Host,
+		ClientIP: c.ClientIP(),
+	}
+	for name, values := range c.Request.URL.Query() {
+		input.Query[name] = strings.Join(values, ",")
+	}
+	if len(p.config.Headers) > 0 {
+		for _, name := range p.config.Headers {
+			if values := c.Request.Header.Values(name); len(values) > 0 {
+				input.Headers[strings.ToLower(name)] = strings.Join(values, ", ")
+			}
+		}
+		return input
+	}
+	for name, values := range c.Request.Header {
+		if !isCredentialHeader(name) {
+			input.Headers[strings.ToLower(name)] = strings.Join(values, ", ")
+		}
+	}
+	return input
+}
+
+func isCredentialHeader(name string) bool {
+	for _, credential := range credentialHeaders {
+		if strings.EqualFold(name, credential) {
+			return true
+		}
+	}
+	return false
+}
+
+// opaEvaluator queries the Data API of an Open Policy Agent server
+type opaEvaluator struct {
+	config   OPAConfig
+	endpoint string
+	client   *http.Client
+}
+
+// NewOPAEvaluator creates an evaluator querying the decision of the policy from an Open Policy
+// Agent server.
+func NewOPAEvaluator(c OPAConfig) PolicyEvaluator {
+	if c.Timeout == 0 {
+		c.Timeout = defaultPolicyTimeout
+	}
+	return &opaEvaluator{
+		config:   c,
+		endpoint: strings.TrimRight(c.URL, "/") + "/v1/data/" + strings.Trim(c.Policy, "/"),
+		client:   &http.Client{Timeout: c.Timeout},
+	}
+}
+
+func (o *opaEvaluator) Evaluate(ctx context.Context, input PolicyInput) (PolicyDecision, error) {
+	payload, err := json.Marshal(map[string]any{"input": input})
+	if err != nil {
+		return PolicyDecision{}, err
+	}
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, o.endpoint, bytes.NewReader(payload))
+	if err != nil {
+		return PolicyDecision{}, err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	for name, value := range o.config.Headers {
+		request.Header.Set(name, value)
+	}
+	response, err := o.client.Do(request)
+	if err != nil {
+		return PolicyDecision{}, errors.Wrap(err, "opa request failed")
+	}
+	defer func() { _ = response.Body.Close() }()
+	if response.StatusCode != http.StatusOK {
+		return PolicyDecision{}, errors.Errorf("opa responded with status %d", response.StatusCode)
+	}
+	var body struct {
+		Result json.RawMessage `json:"result"`
+	}
+	if err := json.NewDecoder(response.Body).Decode(&body); err != nil {
+		return PolicyDecision{}, errors.Wrap(err, "invalid opa response")
+	}
+	return parseOPAResult(body.Result)
+}
+
+// parseOPAResult reads a boolean or object decision. Undefined decisions deny.
+func parseOPAResult(result json.RawMessage) (PolicyDecision, error) {
+	var decision PolicyDecision
+	trimmed := bytes.TrimSpace(result)
+	switch {
+	case len(trimmed) == 0 || bytes.Equal(trimmed, []byte("null")):
+		decision.Reason = "policy decision is undefined"
+	case trimmed[0] == '{':
+		if err := json.Unmarshal(trimmed, &decision); err != nil {
+			return PolicyDecision{}, errors.Wrap(err, "invalid opa decision")
+		}
+	default:
+		if err := json.Unmarshal(trimmed, &decision.Allow); err != nil {
+			return PolicyDecision{}, errors.Wrap(err, "opa decision is neither a boolean nor an object")
+		}
+	}
+	return decision, nil
+}
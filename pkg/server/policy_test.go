@@ -0,0 +1,194 @@
+//go:build unit
+
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Policy", func() {
+	Context("PolicyConfig Validate", func() {
+		It("should accept an OPA server or an evaluator", func() {
+			Expect(PolicyConfig{OPA: &OPAConfig{URL: "http://localhost:8181", Policy: "httpapi/authz/allow"}}.Validate()).To(Succeed())
+			Expect(PolicyConfig{Evaluator: "rego"}.Validate()).To(Succeed())
+		})
+
+		It("should reject invalid settings", func() {
+			Expect(PolicyConfig{}.Validate()).To(MatchError(ContainSubstring("exactly one of")))
+			Expect(PolicyConfig{Evaluator: "rego", OPA: &OPAConfig{URL: "http://localhost:8181", Policy: "p"}}.Validate()).To(MatchError(ContainSubstring("exactly one of")))
+			Expect(PolicyConfig{OPA: &OPAConfig{URL: "localhost:8181", Policy: "p"}}.Validate()).NotTo(Succeed())
+			Expect(PolicyConfig{OPA: &OPAConfig{URL: "http://localhost:8181", Policy: "/"}}.Validate()).To(MatchError(ContainSubstring("policy must be set")))
+			Expect(PolicyConfig{OPA: &OPAConfig{URL: "http://localhost:8181", Policy: "p", Timeout: -time.Second}}.Validate()).NotTo(Succeed())
+		})
+
+		It("should require registered evaluators", func() {
+			_, err := NewPolicyMiddleware(PolicyConfig{Evaluator: "unregistered"})
+			Expect(err).To(MatchError(ContainSubstring("no policy evaluator registered")))
+		})
+	})
+
+	Context("Middleware", func() {
+		var (
+			engine   *gin.Engine
+			config   PolicyConfig
+			inputs   []PolicyInput
+			decision PolicyDecision
+			evalErr  error
+		)
+
+		BeforeEach(func() {
+			inputs, decision, evalErr = nil, PolicyDecision{Allow: true}, nil
+			RegisterPolicyEvaluator("test", PolicyEvaluatorFunc(func(_ context.Context, input PolicyInput) (PolicyDecision, error) {
+				inputs = append(inputs, input)
+				return decision, evalErr
+			}))
+			config = PolicyConfig{Evaluator: "test"}
+		})
+
+		JustBeforeEach(func() {
+			middleware, err := NewPolicyMiddleware(config)
+			Expect(err).NotTo(HaveOccurred())
+			engine = gin.New()
+			engine.Use(func(c *gin.Context) {
+				if user := c.GetHeader("X-User"); user != "" {
+					c.Set(RemoteUserKey, user)
+					c.Set(RolesKey, []string{"editor"})
+				}
+			}, middleware)
+			engine.DELETE("/articles/:id", func(c *gin.Context) {
+				c.Status(http.StatusNoContent)
+			})
+		})
+
+		request := func() *httptest.ResponseRecorder {
+			recorder := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodDelete, "/articles/42?force=true", nil)
+			req.Header.Set("X-User", "alice")
+			req.Header.Set("Authorization", "Bearer secret")
+			req.Header.Add("Accept", "text/html")
+			req.Header.Add("Accept", "application/json")
+			engine.ServeHTTP(recorder, req)
+			return recorder
+		}
+
+		It("should pass the user and the request to the policy", func() {
+			Expect(request().Code).To(Equal(http.StatusNoContent))
+			Expect(inputs).To(HaveLen(1))
+			input := inputs[0]
+			Expect(input.User).To(Equal("alice"))
+			Expect(input.Roles).To(Equal([]string{"editor"}))
+			Expect(input.Method).To(Equal(http.MethodDelete))
+			Expect(input.Path).To(Equal("/articles/42"))
+			Expect(input.Query).To(Equal(map[string]string{"force": "true"}))
+			Expect(input.Headers).To(HaveKeyWithValue("accept", "text/html, application/json"))
+			Expect(input.Headers).To(HaveKeyWithValue("x-user", "alice"))
+			Expect(input.Headers).NotTo(HaveKey("authorization"))
+		})
+
+		Context("with listed headers", func() {
+			BeforeEach(func() {
+				config.Headers = []string{"Authorization", "X-Missing"}
+			})
+
+			It("should only pass them", func() {
+				request()
+				Expect(inputs[0].Headers).To(Equal(map[string]string{"authorization": "Bearer secret"}))
+			})
+		})
+
+		It("should answer denied requests with the status and headers of the decision", func() {
+			decision = PolicyDecision{Reason: "not the author"}
+			Expect(request().Code).To(Equal(http.StatusForbidden))
+
+			decision = PolicyDecision{Status: http.StatusUnauthorized, Headers: map[string]string{"WWW-Authenticate": `Bearer realm="api"`}}
+			recorder := request()
+			Expect(recorder.Code).To(Equal(http.StatusUnauthorized))
+			Expect(recorder.Header().Get("WWW-Authenticate")).To(Equal(`Bearer realm="api"`))
+
+			decision = PolicyDecision{Status: http.StatusOK}
+			Expect(request().Code).To(Equal(http.StatusForbidden))
+		})
+
+		It("should fail closed when the policy cannot be evaluated", func() {
+			evalErr = errors.New("opa unreachable")
+			Expect(request().Code).To(Equal(http.StatusServiceUnavailable))
+		})
+
+		Context("failing open", func() {
+			BeforeEach(func() {
+				config.FailOpen = true
+			})
+
+			It("should let the requests through when the policy cannot be evaluated", func() {
+				evalErr = errors.New("opa unreachable")
+				Expect(request().Code).To(Equal(http.StatusNoContent))
+			})
+		})
+	})
+
+	Context("OPA evaluator", func() {
+		var (
+			result  string
+			status  int
+			request *http.Request
+			body    map[string]PolicyInput
+		)
+
+		BeforeEach(func() {
+			result, status = `{"result": true}`, http.StatusOK
+		})
+
+		evaluate := func() (PolicyDecision, error) {
+			opa := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				request = r
+				body = nil
+				_ = json.NewDecoder(r.Body).Decode(&body)
+				w.WriteHeader(status)
+				_, _ = w.Write([]byte(result))
+			}))
+			DeferCleanup(opa.Close)
+			evaluator := NewOPAEvaluator(OPAConfig{URL: opa.URL + "/", Policy: "/httpapi/authz/allow",
+				Headers: map[string]string{"Authorization": "Bearer opa-token"}})
+			return evaluator.Evaluate(context.Background(), PolicyInput{User: "alice", Method: http.MethodGet, Path: "/"})
+		}
+
+		It("should query the data API with the input", func() {
+			Expect(evaluate()).To(Equal(PolicyDecision{Allow: true}))
+			Expect(request.Method).To(Equal(http.MethodPost))
+			Expect(request.URL.Path).To(Equal("/v1/data/httpapi/authz/allow"))
+			Expect(request.Header.Get("Authorization")).To(Equal("Bearer opa-token"))
+			Expect(body["input"].User).To(Equal("alice"))
+		})
+
+		It("should read object decisions", func() {
+			result = `{"result": {"allow": false, "status": 401, "reason": "expired"}}`
+			Expect(evaluate()).To(Equal(PolicyDecision{Status: http.StatusUnauthorized, Reason: "expired"}))
+		})
+
+		It("should deny undefined decisions", func() {
+			result = `{}`
+			decision, err := evaluate()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(decision.Allow).To(BeFalse())
+		})
+
+		It("should fail on errors and unexpected decisions", func() {
+			status = http.StatusInternalServerError
+			_, err := evaluate()
+			Expect(err).To(MatchError(ContainSubstring("status 500")))
+
+			status, result = http.StatusOK, `{"result": "yes"}`
+			_, err = evaluate()
+			Expect(err).To(MatchError(ContainSubstring("neither a boolean nor an object")))
+		})
+	})
+})
@@ -0,0 +1,76 @@
+package server
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/animalet/sargantana-go/pkg/openapi"
+	"github.com/pkg/errors"
+)
+
+// ErrPreflight is the cause of the errors returned by Start when a required check fails in
+// strict preflight mode, see PreflightConfig.Strict.
+var ErrPreflight = errors.New("required preflight check failed")
+
+// PreflightConfig configures the checks run before the listener opens: the preflight checks of
+// the server, such as the session store connectivity, and the startup checks of the controllers,
+// such as the reachability of load balancer endpoints.
+type PreflightConfig struct {
+	// Strict fails the startup when a required check fails. Otherwise, failures are only reported
+	// in the startup diagnostics and by the readiness endpoint.
+	Strict bool `yaml:"strict,omitempty"`
+	// Optional lists the checks whose failures neither fail the startup nor make the server
+	// unready, by ID (e.g. "session_store" or "api/endpoint http://backend:8080") or by
+	// controller name for all the checks of a controller. Other checks are required.
+	Optional []string `yaml:"optional,omitempty"`
+	// Timeout bounds the checks of the server and of each controller. Defaults to 10s.
+	Timeout time.Duration `yaml:"timeout,omitempty"`
+	// ReadinessPath serves the results of the checks as JSON, with status 503 when a required
+	// check failed, e.g. "/readyz". See HealthConfig.ReadinessPath.
+	ReadinessPath string `yaml:"readiness_path,omitempty"`
+}
+
+func (c PreflightConfig) Validate() error {
+	if c.Timeout < 0 {
+		return errors.New("timeout must not be negative")
+	}
+	if c.ReadinessPath != "" && !strings.HasPrefix(c.ReadinessPath, "/") {
+		return errors.New("readiness_path must start with /")
+	}
+	return nil
+}
+
+// Describe adds the readiness endpoint to the OpenAPI document, see openapi.Describer.
+func (c PreflightConfig) Describe(doc *openapi.Document, basePath string) {
+	if c.ReadinessPath == "" {
+		return
+	}
+	readiness := openapi.JSON(doc.Schema("Readiness", readinessResponse{}))
+	doc.Add(http.MethodGet, basePath, c.ReadinessPath, openapi.Operation{
+		OperationID: "getReadiness",
+		Summary:     "Returns the readiness of the server and the results of its checks",
+		Tags:        []string{"health"},
+		Responses: map[string]openapi.Response{
+			"200": {Description: "The server is ready", Content: readiness},
+			"503": {Description: "The server is starting or draining, or a required check failed", Content: readiness},
+		},
+	})
+}
+
+// readinessResponse is the readiness endpoint response
+type readinessResponse struct {
+	Ready bool `json:"ready"`
+	// Status is starting, ready, unready or draining
+	Status string           `json:"status"`
+	Checks []readinessCheck `json:"checks"`
+}
+
+// readinessCheck is a check in the readiness endpoint response
+type readinessCheck struct {
+	Name       string `json:"name"`
+	Controller string `json:"controller,omitempty"`
+	Required   bool   `json:"required"`
+	Error      string `json:"error,omitempty"`
+	DurationMs int64  `json:"duration_ms"`
+}
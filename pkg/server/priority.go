@@ -0,0 +1,165 @@
+package server
+
+import (
+	"slices"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+)
+
+const (
+	requestPriorityKey = "sargantana.priority"
+
+	// authControllerType is the type of the controllers whose routes are critical by default, so
+	// that users can still log in while the gateway is saturated
+	authControllerType = "auth"
+)
+
+// Priority classifies requests so that load shedding, queueing and rate limiting treat the
+// important ones preferentially, see RequestPriority.
+type Priority string
+
+const (
+	PriorityLow      Priority = "low"
+	PriorityNormal   Priority = "normal"
+	PriorityHigh     Priority = "high"
+	PriorityCritical Priority = "critical"
+)
+
+// Rank orders the priorities from low (1) to critical (4), 0 for unknown priorities.
+func (p Priority) Rank() int {
+	switch p {
+	case PriorityLow:
+		return 1
+	case PriorityNormal:
+		return 2
+	case PriorityHigh:
+		return 3
+	case PriorityCritical:
+		return 4
+	default:
+		return 0
+	}
+}
+
+func (p Priority) Validate() error {
+	if p.Rank() == 0 {
+		return errors.Errorf("invalid priority %q, must be one of low, normal, high or critical", p)
+	}
+	return nil
+}
+
+// PrioritiesConfig assigns the priorities of requests. The first matching rule wins, then the
+// priority of the controller binding handling the request, see ControllerBinding.Priority. The
+// routes of auth controllers and the liveness and readiness endpoints are otherwise critical, and other
+// requests get the default priority.
+type PrioritiesConfig struct {
+	// Default is the priority of the requests matched by nothing else. Defaults to normal.
+	Default Priority             `yaml:"default,omitempty"`
+	Rules   []PriorityRuleConfig `yaml:"rules,omitempty"`
+}
+
+func (c PrioritiesConfig) Validate() error {
+	if c.Default != "" {
+		if err := c.Default.Validate(); err != nil {
+			return errors.Wrap(err, "invalid default priority")
+		}
+	}
+	for i, rule := range c.Rules {
+		if err := rule.Validate(); err != nil {
+			return errors.Wrapf(err, "invalid rule at index %d", i)
+		}
+	}
+	return nil
+}
+
+// PriorityRuleConfig sets the priority of the requests to some routes. Routes are request paths,
+// matched exactly or, when ending with '*', by prefix.
+type PriorityRuleConfig struct {
+	Routes   []string `yaml:"routes"`
+	Methods  []string `yaml:"methods,omitempty"` // Empty matches every method
+	Priority Priority `yaml:"priority"`
+}
+
+func (c PriorityRuleConfig) Validate() error {
+	if len(c.Routes) == 0 {
+		return errors.New("at least one route must be configured")
+	}
+	for _, route := range c.Routes {
+		if !strings.HasPrefix(route, "/") {
+			return errors.Errorf("route %q must start with /", route)
+		}
+	}
+	return c.Priority.Validate()
+}
+
+func (c PriorityRuleConfig) matches(method, path string) bool {
+	if len(c.Methods) > 0 && !slices.ContainsFunc(c.Methods, func(m string) bool { return strings.EqualFold(m, method) }) {
+		return false
+	}
+	for _, route := range c.Routes {
+		if prefix, ok := strings.CutSuffix(route, "*"); ok {
+			if strings.HasPrefix(path, prefix) {
+				return true
+			}
+		} else if path == route {
+			return true
+		}
+	}
+	return false
+}
+
+// RequestPriority returns the priority of the current request, see PrioritiesConfig. Middlewares
+// of controller bindings, such as rate limiters, and controllers use it to favor important requests.
+func RequestPriority(c *gin.Context) Priority {
+	if priority, ok := c.Get(requestPriorityKey); ok {
+		return priority.(Priority)
+	}
+	return PriorityNormal
+}
+
+// priorityResolver assigns the priority of the requests
+type priorityResolver struct {
+	config     PrioritiesConfig
+	probePaths []string // Liveness and readiness paths
+	routes     controllerRoutes
+}
+
+func newPriorityResolver(config *PrioritiesConfig, probePaths []string, routes controllerRoutes) *priorityResolver {
+	resolver := &priorityResolver{probePaths: probePaths, routes: routes}
+	if config != nil {
+		resolver.config = *config
+	}
+	if resolver.config.Default == "" {
+		resolver.config.Default = PriorityNormal
+	}
+	return resolver
+}
+
+func (p *priorityResolver) resolve(c *gin.Context) Priority {
+	path := c.Request.URL.Path
+	for _, rule := range p.config.Rules {
+		if rule.matches(c.Request.Method, path) {
+			return rule.Priority
+		}
+	}
+	if labels, ok := p.routes[c.Request.Method+" "+c.FullPath()]; ok {
+		if labels.priority != "" {
+			return labels.priority
+		}
+		if labels.typeName == authControllerType {
+			return PriorityCritical
+		}
+	}
+	if slices.Contains(p.probePaths, path) {
+		return PriorityCritical
+	}
+	return p.config.Default
+}
+
+// middleware stores the priority of the requests in the context, see RequestPriority
+func (p *priorityResolver) middleware(c *gin.Context) {
+	c.Set(requestPriorityKey, p.resolve(c))
+	c.Next()
+}
@@ -0,0 +1,81 @@
+//go:build unit
+
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/animalet/sargantana-go/pkg/config"
+	"github.com/gin-gonic/gin"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Request priorities", func() {
+	// priorityOf serves a request and returns the priority seen by the handler
+	priorityOf := func(config *PrioritiesConfig, method, path string) Priority {
+		routes := controllerRoutes{
+			"GET /auth/callback":  {name: "auth", typeName: "auth"},
+			"GET /bulk/*path":     {name: "bulk", typeName: "load_balancer", priority: PriorityLow},
+			"POST /bulk/*path":    {name: "bulk", typeName: "load_balancer", priority: PriorityLow},
+			"GET /internal/*path": {name: "internal-auth", typeName: "auth", priority: PriorityHigh},
+			"GET /api/*path":      {name: "api", typeName: "load_balancer"},
+		}
+		engine := gin.New()
+		engine.Use(newPriorityResolver(config, []string{"/healthz", "/readyz"}, routes).middleware)
+		var priority Priority
+		handler := func(c *gin.Context) {
+			priority = RequestPriority(c)
+		}
+		engine.GET("/auth/callback", handler)
+		engine.GET("/bulk/*path", handler)
+		engine.POST("/bulk/*path", handler)
+		engine.GET("/internal/*path", handler)
+		engine.GET("/api/*path", handler)
+		engine.NoRoute(handler)
+		engine.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(method, path, nil))
+		return priority
+	}
+
+	It("should validate the configuration", func() {
+		Expect(PrioritiesConfig{Default: PriorityLow}.Validate()).To(Succeed())
+		Expect(PrioritiesConfig{Default: "urgent"}.Validate()).To(MatchError(ContainSubstring("invalid priority")))
+		Expect(PrioritiesConfig{Rules: []PriorityRuleConfig{{Routes: []string{"bulk"}, Priority: PriorityLow}}}.Validate()).To(HaveOccurred())
+		Expect(PrioritiesConfig{Rules: []PriorityRuleConfig{{Routes: []string{"/bulk"}}}}.Validate()).To(HaveOccurred())
+		Expect(ControllerBinding{TypeName: "static", Config: config.ModuleRawConfig("{}"), Priority: "top"}.Validate()).To(MatchError(ContainSubstring("invalid priority")))
+	})
+
+	It("should default to normal priority", func() {
+		Expect(priorityOf(nil, http.MethodGet, "/api/users")).To(Equal(PriorityNormal))
+		Expect(priorityOf(nil, http.MethodGet, "/unbound")).To(Equal(PriorityNormal))
+		Expect(priorityOf(&PrioritiesConfig{Default: PriorityLow}, http.MethodGet, "/api/users")).To(Equal(PriorityLow))
+	})
+
+	It("should make auth controllers and the probe endpoints critical", func() {
+		Expect(priorityOf(nil, http.MethodGet, "/auth/callback")).To(Equal(PriorityCritical))
+		Expect(priorityOf(nil, http.MethodGet, "/readyz")).To(Equal(PriorityCritical))
+		Expect(priorityOf(nil, http.MethodGet, "/healthz")).To(Equal(PriorityCritical))
+	})
+
+	It("should use the priority of the controller binding", func() {
+		Expect(priorityOf(nil, http.MethodPost, "/bulk/import")).To(Equal(PriorityLow))
+		Expect(priorityOf(nil, http.MethodGet, "/internal/login")).To(Equal(PriorityHigh))
+	})
+
+	It("should prefer the first matching rule", func() {
+		config := &PrioritiesConfig{Rules: []PriorityRuleConfig{
+			{Routes: []string{"/bulk/status"}, Priority: PriorityHigh},
+			{Routes: []string{"/bulk/*", "/api/export"}, Methods: []string{"get"}, Priority: PriorityCritical},
+		}}
+		Expect(priorityOf(config, http.MethodGet, "/bulk/status")).To(Equal(PriorityHigh))
+		Expect(priorityOf(config, http.MethodGet, "/bulk/import")).To(Equal(PriorityCritical))
+		Expect(priorityOf(config, http.MethodPost, "/bulk/import")).To(Equal(PriorityLow))
+		Expect(priorityOf(config, http.MethodGet, "/api/export")).To(Equal(PriorityCritical))
+	})
+
+	It("should return normal priority outside of the server", func() {
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		Expect(RequestPriority(c)).To(Equal(PriorityNormal))
+	})
+})
@@ -0,0 +1,312 @@
+package server
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"regexp"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/animalet/sargantana-go/pkg/database"
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// QuotaLimitHeader, QuotaRemainingHeader and QuotaResetHeader report the quota of the subject of
+	// a request: its limit, the requests or bytes left in the period, and the seconds until the
+	// period ends.
+	QuotaLimitHeader     = "X-Quota-Limit"
+	QuotaRemainingHeader = "X-Quota-Remaining"
+	QuotaResetHeader     = "X-Quota-Reset"
+
+	defaultQuotaAPIKeyHeader = "X-API-Key"
+	defaultQuotaTable        = "quota_usage"
+)
+
+var quotaName = regexp.MustCompile(`^[a-zA-Z0-9_-]+$`)
+
+// QuotaConfig configures the quota middleware type, which counts the requests or the bytes of each
+// subject, a user, a tenant or an API key, per day or month, and answers 429 Too Many Requests to
+// the subjects over their limit until the period ends. Usage is stored in Redis or PostgreSQL, to
+// be shared by the replicas of the server, or in memory without either.
+type QuotaConfig struct {
+	// Name identifies the quota in the storage and the admin API.
+	Name string `yaml:"name"`
+	// Limit is the number of requests or bytes allowed per period.
+	Limit int64 `yaml:"limit"`
+	// Unit is "requests", the default, or "bytes" to count the request and response bodies.
+	Unit string `yaml:"unit,omitempty"`
+	// Period is "day", the default, or "month". Periods start at midnight UTC.
+	Period string `yaml:"period,omitempty"`
+	// Subject is "user", the default, for the authenticated user, "header" for the value of Header,
+	// e.g. a tenant header set by a trusted proxy, or "api_key" for the API key in Header, which
+	// defaults to X-API-Key. API keys are identified by a hash, so that they are not stored.
+	// Requests without subject are not counted.
+	Subject string `yaml:"subject,omitempty"`
+	Header  string `yaml:"header,omitempty"`
+	// Redis stores the usage in Redis.
+	Redis *database.RedisConfig `yaml:"redis,omitempty"`
+	// Postgres stores the usage in a PostgreSQL table, created if it does not exist.
+	Postgres *database.PostgresConfig `yaml:"postgres,omitempty"`
+	// Table is the PostgreSQL table of the usage. Defaults to quota_usage.
+	Table string `yaml:"table,omitempty"`
+}
+
+func (c QuotaConfig) Validate() error {
+	if !quotaName.MatchString(c.Name) {
+		return errors.Errorf("invalid quota name %q, expected letters, digits, '_' and '-'", c.Name)
+	}
+	if c.Limit <= 0 {
+		return errors.New("limit must be positive")
+	}
+	if c.Unit != "" && c.Unit != "requests" && c.Unit != "bytes" {
+		return errors.Errorf("invalid unit %q, expected requests or bytes", c.Unit)
+	}
+	if c.Period != "" && c.Period != "day" && c.Period != "month" {
+		return errors.Errorf("invalid period %q, expected day or month", c.Period)
+	}
+	switch c.Subject {
+	case "", "user", "api_key":
+	case "header":
+		if c.Header == "" {
+			return errors.New("header must be set for header subjects")
+		}
+	default:
+		return errors.Errorf("invalid subject %q, expected user, header or api_key", c.Subject)
+	}
+	if c.Redis != nil && c.Postgres != nil {
+		return errors.New("only one of redis and postgres can be set")
+	}
+	if c.Redis != nil {
+		if err := c.Redis.Validate(); err != nil {
+			return errors.Wrap(err, "invalid redis configuration")
+		}
+	}
+	if c.Postgres != nil {
+		if err := c.Postgres.Validate(); err != nil {
+			return errors.Wrap(err, "invalid postgres configuration")
+		}
+	}
+	if c.Table != "" && !idempotencyTableName.MatchString(c.Table) {
+		return errors.Errorf("invalid table name %q", c.Table)
+	}
+	return nil
+}
+
+var (
+	quotasMu sync.RWMutex
+	quotas   = make(map[string]*Quota)
+)
+
+// LookupQuota returns the quota with the name, created by the middlewares of the bindings.
+func LookupQuota(name string) (*Quota, bool) {
+	quotasMu.RLock()
+	defer quotasMu.RUnlock()
+	quota, ok := quotas[name]
+	return quota, ok
+}
+
+// QuotaNames returns the sorted names of the quotas created by the middlewares of the bindings.
+func QuotaNames() []string {
+	quotasMu.RLock()
+	defer quotasMu.RUnlock()
+	names := make([]string, 0, len(quotas))
+	for name := range quotas {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// NewQuotaMiddleware creates the middleware of the quota middleware type, with the store of the
+// config. The quota is registered under its name for LookupQuota, replacing any previous quota with
+// the same name.
+func NewQuotaMiddleware(c QuotaConfig) (gin.HandlerFunc, error) {
+	var store QuotaStore
+	switch {
+	case c.Redis != nil:
+		pool, err := c.Redis.CreateClient()
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to create redis client")
+		}
+		store = NewRedisQuotaStore(pool)
+	case c.Postgres != nil:
+		pool, err := c.Postgres.CreateClient()
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to create postgres client")
+		}
+		table := c.Table
+		if table == "" {
+			table = defaultQuotaTable
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if store, err = NewPostgresQuotaStore(ctx, pool, table); err != nil {
+			database.ClosePostgres(pool)
+			return nil, err
+		}
+	default:
+		store = NewMemoryQuotaStore()
+	}
+	quota := NewQuota(c, store)
+	quotasMu.Lock()
+	quotas[c.Name] = quota
+	quotasMu.Unlock()
+	return quota.Middleware(), nil
+}
+
+// Quota accounts the usage of the subjects of a quota middleware.
+type Quota struct {
+	config QuotaConfig
+	store  QuotaStore
+	now    func() time.Time
+}
+
+// NewQuota creates a quota storing its usage in the store.
+func NewQuota(c QuotaConfig, store QuotaStore) *Quota {
+	if c.Unit == "" {
+		c.Unit = "requests"
+	}
+	if c.Period == "" {
+		c.Period = "day"
+	}
+	if c.Subject == "" {
+		c.Subject = "user"
+	}
+	if c.Subject == "api_key" && c.Header == "" {
+		c.Header = defaultQuotaAPIKeyHeader
+	}
+	return &Quota{config: c, store: store, now: time.Now}
+}
+
+// Limit returns the number of requests or bytes allowed per period, and the unit.
+func (q *Quota) Limit() (int64, string) {
+	return q.config.Limit, q.config.Unit
+}
+
+// Period returns the period containing the time, e.g. 2024-05-17 for daily quotas and 2024-05 for
+// monthly ones, and the time it ends.
+func (q *Quota) Period(t time.Time) (string, time.Time) {
+	t = t.UTC()
+	if q.config.Period == "month" {
+		start := time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+		return start.Format("2006-01"), start.AddDate(0, 1, 0)
+	}
+	start := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	return start.Format("2006-01-02"), start.AddDate(0, 0, 1)
+}
+
+// previousPeriod returns the period before the one containing the time
+func (q *Quota) previousPeriod(t time.Time) string {
+	t = t.UTC()
+	if q.config.Period == "month" {
+		// Day 0 is the last day of the previous month
+		t = time.Date(t.Year(), t.Month(), 0, 0, 0, 0, 0, time.UTC)
+	} else {
+		t = t.AddDate(0, 0, -1)
+	}
+	period, _ := q.Period(t)
+	return period
+}
+
+// CurrentPeriod returns the current period.
+func (q *Quota) CurrentPeriod() string {
+	period, _ := q.Period(q.now())
+	return period
+}
+
+// Usage returns the usage of the subjects in a period.
+func (q *Quota) Usage(ctx context.Context, period string) (map[string]int64, error) {
+	return q.store.Usage(ctx, q.config.Name, period)
+}
+
+// Reset clears the usage of a subject in a period.
+func (q *Quota) Reset(ctx context.Context, subject, period string) error {
+	return q.store.Reset(ctx, q.config.Name, subject, period)
+}
+
+// subject identifies who the request is accounted to, empty if nobody
+func (q *Quota) subject(c *gin.Context) string {
+	switch q.config.Subject {
+	case "header":
+		return c.GetHeader(q.config.Header)
+	case "api_key":
+		key := c.GetHeader(q.config.Header)
+		if key == "" {
+			return ""
+		}
+		hash := sha256.Sum256([]byte(key))
+		return hex.EncodeToString(hash[:8])
+	default:
+		return requestUser(c)
+	}
+}
+
+// Middleware counts the requests or bytes of the subjects, rejecting those over their limit. Usage
+// is not enforced if the store fails, so that an outage of the store does not stop the traffic.
+func (q *Quota) Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		subject := q.subject(c)
+		if subject == "" {
+			c.Next()
+			return
+		}
+		now := q.now()
+		period, end := q.Period(now)
+		// Usage is kept for the next period too, to be reviewed once the period ended
+		_, expires := q.Period(end)
+		ctx := c.Request.Context()
+		bytes := q.config.Unit == "bytes"
+
+		increment := int64(1)
+		if bytes {
+			// Bytes are counted once the response is sent, the request is admitted with the usage so far
+			increment = 0
+		}
+		usage, err := q.store.Add(ctx, q.config.Name, subject, period, increment, expires)
+		if err != nil {
+			logger := RequestLogger(c)
+			logger.Error().Err(err).Str("quota", q.config.Name).Msg("Failed to account quota usage")
+			c.Next()
+			return
+		}
+		reset := strconv.FormatInt(int64(end.Sub(now).Round(time.Second)/time.Second), 10)
+		c.Header(QuotaLimitHeader, strconv.FormatInt(q.config.Limit, 10))
+		c.Header(QuotaResetHeader, reset)
+		exceeded := usage > q.config.Limit
+		if bytes {
+			exceeded = usage >= q.config.Limit
+		}
+		if exceeded {
+			if !bytes {
+				// Rejected requests do not consume the quota
+				if _, err := q.store.Add(context.WithoutCancel(ctx), q.config.Name, subject, period, -1, expires); err != nil {
+					log.Error().Err(err).Str("quota", q.config.Name).Msg("Failed to refund quota usage")
+				}
+			}
+			c.Header(QuotaRemainingHeader, "0")
+			c.Header("Retry-After", reset)
+			RespondError(c, http.StatusTooManyRequests)
+			return
+		}
+		c.Header(QuotaRemainingHeader, strconv.FormatInt(q.config.Limit-usage, 10))
+		c.Next()
+
+		if bytes {
+			size := int64(max(c.Writer.Size(), 0))
+			if c.Request.ContentLength > 0 {
+				size += c.Request.ContentLength
+			}
+			if _, err := q.store.Add(context.WithoutCancel(ctx), q.config.Name, subject, period, size, expires); err != nil {
+				logger := RequestLogger(c)
+				logger.Error().Err(err).Str("quota", q.config.Name).Msg("Failed to account quota usage")
+			}
+		}
+	}
+}
@@ -0,0 +1,78 @@
+//go:build integration
+
+package server
+
+import (
+	"context"
+	"time"
+
+	"github.com/animalet/sargantana-go/pkg/database"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Quota stores", func() {
+	ctx := context.Background()
+
+	behaveLikeAStore := func(newStore func() QuotaStore) {
+		It("should add, report and reset usage", func() {
+			store := newStore()
+			period := "integration-" + time.Now().Format(time.RFC3339Nano)
+			expires := time.Now().Add(time.Minute)
+
+			Expect(store.Add(ctx, "api", "alice", period, 1, expires)).To(BeEquivalentTo(1))
+			Expect(store.Add(ctx, "api", "alice", period, 2, expires)).To(BeEquivalentTo(3))
+			Expect(store.Add(ctx, "api", "bob", period, 0, expires)).To(BeEquivalentTo(0))
+			Expect(store.Usage(ctx, "api", period)).To(Equal(map[string]int64{"alice": 3, "bob": 0}))
+
+			Expect(store.Reset(ctx, "api", "alice", period)).To(Succeed())
+			Expect(store.Usage(ctx, "api", period)).To(Equal(map[string]int64{"bob": 0}))
+			Expect(store.Reset(ctx, "api", "bob", period)).To(Succeed())
+		})
+
+		It("should expire usage", func() {
+			store := newStore()
+			period := "integration-expired-" + time.Now().Format(time.RFC3339Nano)
+			Expect(store.Add(ctx, "api", "alice", period, 5, time.Now().Add(time.Second))).To(BeEquivalentTo(5))
+			time.Sleep(1100 * time.Millisecond)
+			Expect(store.Usage(ctx, "api", period)).To(BeEmpty())
+			Expect(store.Add(ctx, "api", "alice", period, 1, time.Now().Add(time.Minute))).To(BeEquivalentTo(1))
+			Expect(store.Reset(ctx, "api", "alice", period)).To(Succeed())
+		})
+	}
+
+	Context("Redis", func() {
+		behaveLikeAStore(func() QuotaStore {
+			pool, err := database.RedisConfig{
+				Address:  "localhost:6379",
+				Username: "redisuser",
+				Password: "redispass",
+				MaxIdle:  3,
+			}.CreateClient()
+			Expect(err).NotTo(HaveOccurred())
+			DeferCleanup(pool.Close)
+			return NewRedisQuotaStore(pool)
+		})
+	})
+
+	Context("PostgreSQL", func() {
+		behaveLikeAStore(func() QuotaStore {
+			pool, err := database.PostgresConfig{
+				Host:     "localhost",
+				Port:     5432,
+				User:     "user",
+				Password: "password",
+				Database: "my_blog_db",
+				SSLMode:  "disable",
+			}.CreateClient()
+			Expect(err).NotTo(HaveOccurred())
+			DeferCleanup(pool.Close)
+			store, err := NewPostgresQuotaStore(ctx, pool, "quota_usage_integration")
+			Expect(err).NotTo(HaveOccurred())
+			DeferCleanup(func() {
+				_, _ = pool.Exec(context.Background(), "DROP TABLE IF EXISTS quota_usage_integration")
+			})
+			return store
+		})
+	})
+})
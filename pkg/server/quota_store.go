@@ -0,0 +1,223 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+)
+
+// QuotaStore keeps the usage of the subjects of the quotas, per period.
+type QuotaStore interface {
+	// Add adds n, which may be zero or negative, to the usage of the subject in the period, kept
+	// until expires, and returns the new usage.
+	Add(ctx context.Context, quota, subject, period string, n int64, expires time.Time) (int64, error)
+	// Usage returns the usage of the subjects of the quota in the period.
+	Usage(ctx context.Context, quota, period string) (map[string]int64, error)
+	// Reset clears the usage of the subject in the period.
+	Reset(ctx context.Context, quota, subject, period string) error
+}
+
+type quotaEntry struct {
+	usage   int64
+	expires time.Time
+}
+
+// memoryQuotaStore is an in-process QuotaStore, for servers without replicas.
+type memoryQuotaStore struct {
+	mu      sync.Mutex
+	entries map[[3]string]quotaEntry // By quota, period and subject
+	now     func() time.Time
+	swept   time.Time
+}
+
+// NewMemoryQuotaStore creates an in-memory quota store.
+func NewMemoryQuotaStore() QuotaStore {
+	return &memoryQuotaStore{entries: make(map[[3]string]quotaEntry), now: time.Now}
+}
+
+func (m *memoryQuotaStore) Add(_ context.Context, quota, subject, period string, n int64, expires time.Time) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := m.now()
+	m.sweep(now)
+	key := [3]string{quota, period, subject}
+	entry := m.entries[key]
+	if !now.Before(entry.expires) {
+		entry.usage = 0
+	}
+	entry.usage += n
+	entry.expires = expires
+	m.entries[key] = entry
+	return entry.usage, nil
+}
+
+func (m *memoryQuotaStore) Usage(_ context.Context, quota, period string) (map[string]int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := m.now()
+	usage := make(map[string]int64)
+	for key, entry := range m.entries {
+		if key[0] == quota && key[1] == period && now.Before(entry.expires) {
+			usage[key[2]] = entry.usage
+		}
+	}
+	return usage, nil
+}
+
+func (m *memoryQuotaStore) Reset(_ context.Context, quota, subject, period string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.entries, [3]string{quota, period, subject})
+	return nil
+}
+
+// sweep drops the expired entries, at most once a minute. Must be called with the lock held.
+func (m *memoryQuotaStore) sweep(now time.Time) {
+	if now.Sub(m.swept) < time.Minute {
+		return
+	}
+	m.swept = now
+	for key, entry := range m.entries {
+		if !now.Before(entry.expires) {
+			delete(m.entries, key)
+		}
+	}
+}
+
+const redisQuotaPrefix = "sargantana:quota:"
+
+// redisQuotaStore keeps the usage of each quota and period in a hash by subject, expiring with the
+// Redis TTL.
+type redisQuotaStore struct {
+	pool *redis.Pool
+}
+
+// NewRedisQuotaStore creates a quota store shared through Redis.
+func NewRedisQuotaStore(pool *redis.Pool) QuotaStore {
+	return &redisQuotaStore{pool: pool}
+}
+
+func (r *redisQuotaStore) Add(ctx context.Context, quota, subject, period string, n int64, expires time.Time) (int64, error) {
+	conn, err := r.pool.GetContext(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer func() { _ = conn.Close() }()
+	key := redisQuotaPrefix + quota + ":" + period
+	_ = conn.Send("MULTI")
+	_ = conn.Send("HINCRBY", key, subject, n)
+	_ = conn.Send("PEXPIREAT", key, expires.UnixMilli())
+	replies, err := redis.Values(conn.Do("EXEC"))
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to add quota usage")
+	}
+	usage, err := redis.Int64(replies[0], nil)
+	return usage, errors.Wrap(err, "failed to add quota usage")
+}
+
+func (r *redisQuotaStore) Usage(ctx context.Context, quota, period string) (map[string]int64, error) {
+	conn, err := r.pool.GetContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = conn.Close() }()
+	usage, err := redis.Int64Map(conn.Do("HGETALL", redisQuotaPrefix+quota+":"+period))
+	return usage, errors.Wrap(err, "failed to read quota usage")
+}
+
+func (r *redisQuotaStore) Reset(ctx context.Context, quota, subject, period string) error {
+	conn, err := r.pool.GetContext(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = conn.Close() }()
+	_, err = conn.Do("HDEL", redisQuotaPrefix+quota+":"+period, subject)
+	return errors.Wrap(err, "failed to reset quota usage")
+}
+
+// postgresQuotaStore keeps the usage in a table, whose expired rows are deleted at most once a
+// minute.
+type postgresQuotaStore struct {
+	pool  *pgxpool.Pool
+	table string
+
+	mu     sync.Mutex
+	purged time.Time
+}
+
+// NewPostgresQuotaStore creates a quota store shared through a PostgreSQL table, created if it
+// does not exist.
+func NewPostgresQuotaStore(ctx context.Context, pool *pgxpool.Pool, table string) (QuotaStore, error) {
+	_, err := pool.Exec(ctx, fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+		quota TEXT NOT NULL,
+		period TEXT NOT NULL,
+		subject TEXT NOT NULL,
+		usage BIGINT NOT NULL,
+		expires_at TIMESTAMPTZ NOT NULL,
+		PRIMARY KEY (quota, period, subject)
+	)`, table))
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to create quota table %s", table)
+	}
+	return &postgresQuotaStore{pool: pool, table: table}, nil
+}
+
+func (p *postgresQuotaStore) Add(ctx context.Context, quota, subject, period string, n int64, expires time.Time) (int64, error) {
+	// Expired rows start over, as if they did not exist
+	var usage int64
+	err := p.pool.QueryRow(ctx, "INSERT INTO "+p.table+" AS t (quota, period, subject, usage, expires_at) "+
+		"VALUES ($1, $2, $3, $4, $5) ON CONFLICT (quota, period, subject) DO UPDATE SET "+
+		"usage = CASE WHEN t.expires_at <= now() THEN 0 ELSE t.usage END + EXCLUDED.usage, "+
+		"expires_at = EXCLUDED.expires_at RETURNING usage",
+		quota, period, subject, n, expires).Scan(&usage)
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to add quota usage")
+	}
+	p.purge(ctx)
+	return usage, nil
+}
+
+func (p *postgresQuotaStore) Usage(ctx context.Context, quota, period string) (map[string]int64, error) {
+	rows, err := p.pool.Query(ctx, "SELECT subject, usage FROM "+p.table+
+		" WHERE quota = $1 AND period = $2 AND expires_at > now()", quota, period)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read quota usage")
+	}
+	defer rows.Close()
+	usage := make(map[string]int64)
+	for rows.Next() {
+		var subject string
+		var n int64
+		if err := rows.Scan(&subject, &n); err != nil {
+			return nil, errors.Wrap(err, "failed to read quota usage")
+		}
+		usage[subject] = n
+	}
+	return usage, errors.Wrap(rows.Err(), "failed to read quota usage")
+}
+
+func (p *postgresQuotaStore) Reset(ctx context.Context, quota, subject, period string) error {
+	_, err := p.pool.Exec(ctx, "DELETE FROM "+p.table+" WHERE quota = $1 AND period = $2 AND subject = $3",
+		quota, period, subject)
+	return errors.Wrap(err, "failed to reset quota usage")
+}
+
+// purge deletes the expired rows, at most once a minute
+func (p *postgresQuotaStore) purge(ctx context.Context) {
+	p.mu.Lock()
+	if time.Since(p.purged) < time.Minute {
+		p.mu.Unlock()
+		return
+	}
+	p.purged = time.Now()
+	p.mu.Unlock()
+	if _, err := p.pool.Exec(ctx, "DELETE FROM "+p.table+" WHERE expires_at <= now()"); err != nil {
+		log.Warn().Err(err).Str("table", p.table).Msg("Failed to purge expired quota usage")
+	}
+}
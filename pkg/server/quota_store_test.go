@@ -0,0 +1,51 @@
+//go:build unit
+
+package server
+
+import (
+	"context"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Memory quota store", func() {
+	var (
+		store *memoryQuotaStore
+		now   time.Time
+		ctx   = context.Background()
+	)
+
+	BeforeEach(func() {
+		store = NewMemoryQuotaStore().(*memoryQuotaStore)
+		now = time.Now()
+		store.now = func() time.Time { return now }
+	})
+
+	It("should add usage by quota, period and subject", func() {
+		expires := now.Add(time.Hour)
+		Expect(store.Add(ctx, "api", "alice", "2024-05-17", 1, expires)).To(BeEquivalentTo(1))
+		Expect(store.Add(ctx, "api", "alice", "2024-05-17", 2, expires)).To(BeEquivalentTo(3))
+		Expect(store.Add(ctx, "api", "alice", "2024-05-17", -1, expires)).To(BeEquivalentTo(2))
+		Expect(store.Add(ctx, "api", "bob", "2024-05-17", 0, expires)).To(BeEquivalentTo(0))
+		Expect(store.Add(ctx, "api", "alice", "2024-05-18", 1, expires)).To(BeEquivalentTo(1))
+		Expect(store.Add(ctx, "uploads", "alice", "2024-05-17", 5, expires)).To(BeEquivalentTo(5))
+
+		Expect(store.Usage(ctx, "api", "2024-05-17")).To(Equal(map[string]int64{"alice": 2, "bob": 0}))
+	})
+
+	It("should reset the usage of a subject", func() {
+		Expect(store.Add(ctx, "api", "alice", "2024-05-17", 4, now.Add(time.Hour))).To(BeEquivalentTo(4))
+		Expect(store.Reset(ctx, "api", "alice", "2024-05-17")).To(Succeed())
+		Expect(store.Usage(ctx, "api", "2024-05-17")).To(BeEmpty())
+		Expect(store.Add(ctx, "api", "alice", "2024-05-17", 1, now.Add(time.Hour))).To(BeEquivalentTo(1))
+	})
+
+	It("should start expired usage over", func() {
+		Expect(store.Add(ctx, "api", "alice", "2024-05-17", 4, now.Add(time.Hour))).To(BeEquivalentTo(4))
+		now = now.Add(2 * time.Hour)
+		Expect(store.Usage(ctx, "api", "2024-05-17")).To(BeEmpty())
+		Expect(store.Add(ctx, "api", "alice", "2024-05-17", 1, now.Add(time.Hour))).To(BeEquivalentTo(1))
+	})
+})
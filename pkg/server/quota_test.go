@@ -0,0 +1,198 @@
+//go:build unit
+
+package server
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+type failingQuotaStore struct {
+	QuotaStore
+}
+
+func (failingQuotaStore) Add(context.Context, string, string, string, int64, time.Time) (int64, error) {
+	return 0, errors.New("store unavailable")
+}
+
+var _ = Describe("Quota", func() {
+	Context("QuotaConfig Validate", func() {
+		It("should accept valid quotas", func() {
+			Expect(QuotaConfig{Name: "api", Limit: 1000}.Validate()).To(Succeed())
+			Expect(QuotaConfig{Name: "tenant-bytes", Limit: 1 << 30, Unit: "bytes", Period: "month",
+				Subject: "header", Header: "X-Tenant-ID", Table: "quotas.usage"}.Validate()).To(Succeed())
+		})
+
+		It("should reject invalid settings", func() {
+			Expect(QuotaConfig{Limit: 1}.Validate()).To(MatchError(ContainSubstring("invalid quota name")))
+			Expect(QuotaConfig{Name: "a:b", Limit: 1}.Validate()).To(MatchError(ContainSubstring("invalid quota name")))
+			Expect(QuotaConfig{Name: "api"}.Validate()).To(MatchError(ContainSubstring("limit must be positive")))
+			Expect(QuotaConfig{Name: "api", Limit: 1, Unit: "calls"}.Validate()).To(MatchError(ContainSubstring("invalid unit")))
+			Expect(QuotaConfig{Name: "api", Limit: 1, Period: "week"}.Validate()).To(MatchError(ContainSubstring("invalid period")))
+			Expect(QuotaConfig{Name: "api", Limit: 1, Subject: "header"}.Validate()).To(MatchError(ContainSubstring("header must be set")))
+			Expect(QuotaConfig{Name: "api", Limit: 1, Subject: "ip"}.Validate()).To(MatchError(ContainSubstring("invalid subject")))
+			Expect(QuotaConfig{Name: "api", Limit: 1, Table: "usage; DROP TABLE users"}.Validate()).To(MatchError(ContainSubstring("invalid table name")))
+		})
+	})
+
+	Context("Period", func() {
+		It("should start periods at midnight UTC", func() {
+			t := time.Date(2024, 12, 31, 23, 30, 0, 0, time.FixedZone("CET", 3600))
+			period, end := NewQuota(QuotaConfig{}, nil).Period(t)
+			Expect(period).To(Equal("2024-12-31"))
+			Expect(end).To(Equal(time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)))
+
+			period, end = NewQuota(QuotaConfig{Period: "month"}, nil).Period(t)
+			Expect(period).To(Equal("2024-12"))
+			Expect(end).To(Equal(time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)))
+		})
+	})
+
+	Context("Middleware", func() {
+		var (
+			engine *gin.Engine
+			quota  *Quota
+			config QuotaConfig
+			store  QuotaStore
+			now    time.Time
+		)
+
+		BeforeEach(func() {
+			config = QuotaConfig{Name: "api", Limit: 2}
+			store = NewMemoryQuotaStore()
+			now = time.Date(2024, 5, 17, 23, 0, 0, 0, time.UTC)
+		})
+
+		JustBeforeEach(func() {
+			if memory, ok := store.(*memoryQuotaStore); ok {
+				memory.now = func() time.Time { return now }
+			}
+			quota = NewQuota(config, store)
+			quota.now = func() time.Time { return now }
+			engine = gin.New()
+			engine.Use(func(c *gin.Context) {
+				if user := c.GetHeader("X-User"); user != "" {
+					c.Set(RemoteUserKey, user)
+				}
+			}, quota.Middleware())
+			engine.POST("/upload", func(c *gin.Context) {
+				c.String(http.StatusOK, "stored")
+			})
+		})
+
+		request := func(headers map[string]string, body string) *httptest.ResponseRecorder {
+			recorder := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodPost, "/upload", strings.NewReader(body))
+			for name, value := range headers {
+				req.Header.Set(name, value)
+			}
+			engine.ServeHTTP(recorder, req)
+			return recorder
+		}
+
+		It("should count the requests of each user and reject those over the limit", func() {
+			alice := map[string]string{"X-User": "alice"}
+			recorder := request(alice, "")
+			Expect(recorder.Code).To(Equal(http.StatusOK))
+			Expect(recorder.Header().Get(QuotaLimitHeader)).To(Equal("2"))
+			Expect(recorder.Header().Get(QuotaRemainingHeader)).To(Equal("1"))
+			Expect(recorder.Header().Get(QuotaResetHeader)).To(Equal("3600"))
+			Expect(request(alice, "").Header().Get(QuotaRemainingHeader)).To(Equal("0"))
+
+			recorder = request(alice, "")
+			Expect(recorder.Code).To(Equal(http.StatusTooManyRequests))
+			Expect(recorder.Header().Get(QuotaRemainingHeader)).To(Equal("0"))
+			Expect(recorder.Header().Get("Retry-After")).To(Equal("3600"))
+			Expect(request(map[string]string{"X-User": "bob"}, "").Code).To(Equal(http.StatusOK))
+
+			// Rejected requests are not counted
+			Expect(quota.Usage(context.Background(), "2024-05-17")).To(Equal(map[string]int64{"alice": 2, "bob": 1}))
+
+			now = now.Add(time.Hour)
+			Expect(request(alice, "").Code).To(Equal(http.StatusOK))
+		})
+
+		It("should not count anonymous requests", func() {
+			for range 3 {
+				recorder := request(nil, "")
+				Expect(recorder.Code).To(Equal(http.StatusOK))
+				Expect(recorder.Header().Get(QuotaLimitHeader)).To(BeEmpty())
+			}
+		})
+
+		It("should allow again reset subjects", func() {
+			alice := map[string]string{"X-User": "alice"}
+			request(alice, "")
+			request(alice, "")
+			Expect(request(alice, "").Code).To(Equal(http.StatusTooManyRequests))
+			Expect(quota.Reset(context.Background(), "alice", quota.CurrentPeriod())).To(Succeed())
+			Expect(request(alice, "").Code).To(Equal(http.StatusOK))
+		})
+
+		Context("with bytes", func() {
+			BeforeEach(func() {
+				config = QuotaConfig{Name: "bytes", Limit: 20, Unit: "bytes", Subject: "header", Header: "X-Tenant-ID"}
+			})
+
+			It("should count the request and response bodies", func() {
+				tenant := map[string]string{"X-Tenant-ID": "acme"}
+				Expect(request(tenant, "0123456789").Header().Get(QuotaRemainingHeader)).To(Equal("20"))
+				Expect(request(tenant, "").Header().Get(QuotaRemainingHeader)).To(Equal("4"))
+				Expect(quota.Usage(context.Background(), "2024-05-17")).To(Equal(map[string]int64{"acme": 22}))
+				Expect(request(tenant, "").Code).To(Equal(http.StatusTooManyRequests))
+			})
+		})
+
+		Context("with API keys", func() {
+			BeforeEach(func() {
+				config = QuotaConfig{Name: "keys", Limit: 5, Subject: "api_key"}
+			})
+
+			It("should identify the keys by their hash", func() {
+				Expect(request(map[string]string{"X-API-Key": "secret-key"}, "").Code).To(Equal(http.StatusOK))
+				usage, err := quota.Usage(context.Background(), "2024-05-17")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(usage).To(HaveLen(1))
+				for subject := range usage {
+					Expect(subject).To(HaveLen(16))
+					Expect(subject).NotTo(ContainSubstring("secret"))
+				}
+			})
+		})
+
+		Context("when the store fails", func() {
+			BeforeEach(func() {
+				store = failingQuotaStore{}
+			})
+
+			It("should let the requests through", func() {
+				recorder := request(map[string]string{"X-User": "alice"}, "")
+				Expect(recorder.Code).To(Equal(http.StatusOK))
+				Expect(recorder.Header().Get(QuotaLimitHeader)).To(BeEmpty())
+			})
+		})
+	})
+
+	Context("Registry", func() {
+		It("should register the quotas of the middlewares by name", func() {
+			_, err := NewQuotaMiddleware(QuotaConfig{Name: "registry-test", Limit: 10})
+			Expect(err).NotTo(HaveOccurred())
+			quota, ok := LookupQuota("registry-test")
+			Expect(ok).To(BeTrue())
+			limit, unit := quota.Limit()
+			Expect(limit).To(BeEquivalentTo(10))
+			Expect(unit).To(Equal("requests"))
+			Expect(QuotaNames()).To(ContainElement("registry-test"))
+			_, ok = LookupQuota("missing")
+			Expect(ok).To(BeFalse())
+		})
+	})
+})
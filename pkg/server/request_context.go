@@ -0,0 +1,108 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+
+	"github.com/animalet/sargantana-go/pkg/logging"
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// RequestIDHeader is the header carrying the request ID. Incoming values are propagated,
+	// otherwise a new ID is generated. The ID is always echoed back in the response.
+	RequestIDHeader = "X-Request-ID"
+
+	// maxRequestIDLength bounds the length of request IDs accepted from clients.
+	maxRequestIDLength = 128
+
+	requestIDKey     = "sargantana.request_id"
+	requestLoggerKey = "sargantana.request_logger"
+)
+
+// ContextHandlerFunc is a request handler that receives the request context and a logger scoped to
+// the request, pre-populated with the request ID, the authenticated user ID (if any) and the
+// controller name, and filtered by the level configured for the controller name (see
+// logging.Config.Modules). The logger is also attached to ctx, so it can be retrieved with
+// zerolog.Ctx from code that only receives the context.
+type ContextHandlerFunc func(ctx context.Context, logger zerolog.Logger, c *gin.Context)
+
+// Handler adapts a ContextHandlerFunc to a gin.HandlerFunc for the controller described by this
+// context. It is an optional alternative to plain gin handlers that log through the global logger.
+//
+// Example usage:
+//
+//	engine.GET("/orders", ctx.Handler(func(ctx context.Context, logger zerolog.Logger, c *gin.Context) {
+//		logger.Info().Msg("Listing orders")
+//	}))
+func (ctx ControllerContext) Handler(handler ContextHandlerFunc) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		builder := RequestLogger(c).With()
+		// The request logger of controller routes already carries the controller name
+		if ctx.Name != "" && ControllerName(c) == "" {
+			builder = builder.Str("controller", ctx.Name)
+		}
+		if userId := UserId(c); userId != "" {
+			builder = builder.Str("user_id", userId)
+		}
+		logger := builder.Logger()
+		if ctx.Name != "" {
+			logger = logging.ForModule(logger, ctx.Name)
+		}
+		handler(logger.WithContext(c.Request.Context()), logger, c)
+	}
+}
+
+// RequestID returns the ID of the current request, or an empty string if the request context
+// middleware is not installed.
+func RequestID(c *gin.Context) string {
+	return c.GetString(requestIDKey)
+}
+
+// RequestLogger returns the logger scoped to the current request. It falls back to the global
+// logger if the request context middleware is not installed.
+func RequestLogger(c *gin.Context) zerolog.Logger {
+	if logger, ok := c.Get(requestLoggerKey); ok {
+		return logger.(zerolog.Logger)
+	}
+	return log.Logger
+}
+
+// requestContextMiddleware assigns a request ID to every request and stores a logger including it
+// in both the gin context and the request context.
+func requestContextMiddleware(c *gin.Context) {
+	id := c.GetHeader(RequestIDHeader)
+	if !validRequestID(id) {
+		id = newRequestID()
+	}
+	c.Set(requestIDKey, id)
+	c.Header(RequestIDHeader, id)
+
+	logger := log.With().Str("request_id", id).Logger()
+	c.Set(requestLoggerKey, logger)
+	c.Request = c.Request.WithContext(logger.WithContext(c.Request.Context()))
+	c.Next()
+}
+
+// validRequestID accepts non-empty printable ASCII IDs of bounded length, so clients cannot inject
+// arbitrary content in logs.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] < 0x21 || id[i] > 0x7e {
+			return false
+		}
+	}
+	return true
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,137 @@
+//go:build unit
+
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	"github.com/animalet/sargantana-go/pkg/sessionsx"
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-contrib/sessions/cookie"
+	"github.com/gin-gonic/gin"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+var _ = Describe("Request context", func() {
+	var (
+		engine   *gin.Engine
+		output   *bytes.Buffer
+		original zerolog.Logger
+	)
+
+	BeforeEach(func() {
+		original = log.Logger
+		output = &bytes.Buffer{}
+		log.Logger = zerolog.New(output)
+		DeferCleanup(func() { log.Logger = original })
+
+		gin.SetMode(gin.TestMode)
+		engine = gin.New()
+		engine.Use(requestContextMiddleware)
+	})
+
+	lastRecord := func() map[string]interface{} {
+		lines := strings.Split(strings.TrimSpace(output.String()), "\n")
+		var record map[string]interface{}
+		Expect(json.Unmarshal([]byte(lines[len(lines)-1]), &record)).To(Succeed())
+		return record
+	}
+
+	It("should generate a request ID and echo it in the response", func() {
+		var id string
+		engine.GET("/", func(c *gin.Context) { id = RequestID(c) })
+
+		w := httptest.NewRecorder()
+		engine.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+		Expect(id).To(HaveLen(32))
+		Expect(w.Header().Get(RequestIDHeader)).To(Equal(id))
+	})
+
+	It("should propagate valid incoming request IDs only", func() {
+		var id string
+		engine.GET("/", func(c *gin.Context) { id = RequestID(c) })
+
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set(RequestIDHeader, "upstream-123")
+		engine.ServeHTTP(httptest.NewRecorder(), req)
+		Expect(id).To(Equal("upstream-123"))
+
+		req = httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set(RequestIDHeader, "forged\nline")
+		engine.ServeHTTP(httptest.NewRecorder(), req)
+		Expect(id).NotTo(ContainSubstring("forged"))
+
+		req = httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set(RequestIDHeader, strings.Repeat("a", maxRequestIDLength+1))
+		engine.ServeHTTP(httptest.NewRecorder(), req)
+		Expect(id).To(HaveLen(32))
+	})
+
+	It("should fall back to the global logger without the middleware", func() {
+		bare := gin.New()
+		bare.GET("/", func(c *gin.Context) {
+			logger := RequestLogger(c)
+			logger.Info().Msg("bare")
+		})
+		bare.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+		Expect(lastRecord()).NotTo(HaveKey("request_id"))
+		Expect(lastRecord()["message"]).To(Equal("bare"))
+	})
+
+	It("should provide a request scoped logger to context handlers", func() {
+		engine.Use(sessions.Sessions("session", cookie.NewStore([]byte("secret"))))
+		ctx := ControllerContext{Name: "orders"}
+		engine.GET("/", func(c *gin.Context) {
+			sessions.Default(c).Set(sessionsx.UserKey, sessionsx.UserObject{Id: "user@example.com"})
+			c.Next()
+		}, ctx.Handler(func(ctx context.Context, logger zerolog.Logger, c *gin.Context) {
+			logger.Info().Msg("from handler")
+			zerolog.Ctx(ctx).Info().Msg("from context")
+		}))
+
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set(RequestIDHeader, "req-1")
+		engine.ServeHTTP(httptest.NewRecorder(), req)
+
+		lines := strings.Split(strings.TrimSpace(output.String()), "\n")
+		Expect(lines).To(HaveLen(2))
+		for _, line := range lines {
+			var record map[string]interface{}
+			Expect(json.Unmarshal([]byte(line), &record)).To(Succeed())
+			Expect(record).To(HaveKeyWithValue("request_id", "req-1"))
+			Expect(record).To(HaveKeyWithValue("controller", "orders"))
+			Expect(record).To(HaveKeyWithValue("user_id", "user@example.com"))
+		}
+	})
+
+	It("should include the user ID of the authenticators without session", func() {
+		engine.GET("/", func(c *gin.Context) {
+			c.Set(RemoteUserKey, "alice")
+		}, ControllerContext{}.Handler(func(_ context.Context, logger zerolog.Logger, _ *gin.Context) {
+			logger.Info().Msg("authenticated")
+		}))
+		engine.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+
+		Expect(lastRecord()).To(HaveKeyWithValue("user_id", "alice"))
+	})
+
+	It("should omit the user ID when there is no session", func() {
+		engine.GET("/", ControllerContext{}.Handler(func(_ context.Context, logger zerolog.Logger, _ *gin.Context) {
+			logger.Info().Msg("anonymous")
+		}))
+		engine.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+
+		record := lastRecord()
+		Expect(record).To(HaveKey("request_id"))
+		Expect(record).NotTo(HaveKey("user_id"))
+		Expect(record).NotTo(HaveKey("controller"))
+	})
+})
@@ -0,0 +1,59 @@
+package server
+
+import (
+	"context"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+)
+
+var (
+	revocationMu         sync.RWMutex
+	revocationStore      RevocationStore
+	revocationFailClosed bool
+)
+
+// SetRevocationStore enables the revocation of the credentials of the users: the sessions of the
+// auth controller and the tokens of JWTAuthenticator are checked against the generation of their
+// user in the store, see CredentialsRevoked. A nil store disables the checks. If failClosed is
+// set, credentials are rejected while the store fails, otherwise they are accepted.
+func SetRevocationStore(store RevocationStore, failClosed bool) {
+	revocationMu.Lock()
+	defer revocationMu.Unlock()
+	revocationStore, revocationFailClosed = store, failClosed
+}
+
+// CurrentRevocation returns the current credential generation of the user, zero without a
+// revocation store.
+func CurrentRevocation(ctx context.Context, user string) (Revocation, error) {
+	revocationMu.RLock()
+	store := revocationStore
+	revocationMu.RUnlock()
+	if store == nil || user == "" {
+		return Revocation{}, nil
+	}
+	return store.Get(ctx, user)
+}
+
+// CredentialsRevoked tells whether credentials of the user were revoked after being issued.
+// current reports whether the credentials belong to the current generation of the user. Store
+// failures are logged, and revoke the credentials only if the store fails closed.
+//
+// Example usage:
+//
+//	if server.CredentialsRevoked(c, user.Id, func(r server.Revocation) bool { return user.Generation >= r.Generation }) {
+//	    c.AbortWithStatus(http.StatusUnauthorized)
+//	    return
+//	}
+func CredentialsRevoked(c *gin.Context, user string, current func(Revocation) bool) bool {
+	revocation, err := CurrentRevocation(c.Request.Context(), user)
+	if err != nil {
+		revocationMu.RLock()
+		failClosed := revocationFailClosed
+		revocationMu.RUnlock()
+		logger := RequestLogger(c)
+		logger.Error().Err(err).Bool("fail_closed", failClosed).Msg("Failed to check the credential generation")
+		return failClosed
+	}
+	return revocation.Generation > 0 && !current(revocation)
+}
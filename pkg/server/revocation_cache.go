@@ -0,0 +1,211 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+)
+
+// cachedRevocation is a generation read from the store, kept until it expires
+type cachedRevocation struct {
+	revocation Revocation
+	expiresAt  time.Time
+}
+
+// revocationCache keeps the generations read from a store in memory for a TTL. Generations only
+// grow, so a cached generation is never replaced by an older one.
+type revocationCache struct {
+	store   RevocationStore
+	ttl     time.Duration
+	mu      sync.Mutex
+	entries map[string]cachedRevocation
+	sweptAt time.Time
+}
+
+// NewCachedRevocationStore wraps a revocation store with a cache of the generations of the users,
+// read from the store at most once per TTL. The revocations made by other replicas apply once
+// their cached generations expire, or as soon as they are broadcast, see NewRevocationBroadcast.
+func NewCachedRevocationStore(store RevocationStore, ttl time.Duration) RevocationStore {
+	return newRevocationCache(store, ttl)
+}
+
+func newRevocationCache(store RevocationStore, ttl time.Duration) *revocationCache {
+	return &revocationCache{store: store, ttl: ttl, entries: make(map[string]cachedRevocation), sweptAt: time.Now()}
+}
+
+func (r *revocationCache) Revoke(ctx context.Context, user string, at time.Time) (Revocation, error) {
+	revocation, err := r.store.Revoke(ctx, user, at)
+	if err != nil {
+		return revocation, err
+	}
+	return r.apply(user, revocation), nil
+}
+
+func (r *revocationCache) Get(ctx context.Context, user string) (Revocation, error) {
+	r.mu.Lock()
+	entry, ok := r.entries[user]
+	r.mu.Unlock()
+	if ok && time.Now().Before(entry.expiresAt) {
+		return entry.revocation, nil
+	}
+	revocation, err := r.store.Get(ctx, user)
+	if err != nil {
+		return revocation, err
+	}
+	return r.apply(user, revocation), nil
+}
+
+// apply caches the generation of the user unless a newer one is cached, and returns the cached one
+func (r *revocationCache) apply(user string, revocation Revocation) Revocation {
+	now := time.Now()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if now.Sub(r.sweptAt) > r.ttl {
+		for cachedUser, entry := range r.entries {
+			if now.After(entry.expiresAt) {
+				delete(r.entries, cachedUser)
+			}
+		}
+		r.sweptAt = now
+	}
+	if entry, ok := r.entries[user]; ok && entry.revocation.Generation > revocation.Generation {
+		revocation = entry.revocation
+	}
+	r.entries[user] = cachedRevocation{revocation: revocation, expiresAt: now.Add(r.ttl)}
+	return revocation
+}
+
+// clear forgets all the cached generations
+func (r *revocationCache) clear() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	clear(r.entries)
+}
+
+// revocationMessage is a revocation broadcast to the replicas
+type revocationMessage struct {
+	User string `json:"user"`
+	Revocation
+}
+
+// RevocationBroadcast is a cached revocation store sharing its revocations with the other
+// replicas through Redis pub/sub, so that each replica rejects the revoked credentials at once
+// instead of once its cached generation expires.
+type RevocationBroadcast struct {
+	*revocationCache
+	pool    *redis.Pool
+	channel string
+	stop    chan struct{}
+	wg      sync.WaitGroup
+	subMu   sync.Mutex
+	subConn redis.Conn
+}
+
+// NewRevocationBroadcast wraps a revocation store with a cache of the generations of the users,
+// see NewCachedRevocationStore, publishing the revocations on the Redis channel and applying those
+// published by any replica. The pool is not closed by Close.
+func NewRevocationBroadcast(store RevocationStore, ttl time.Duration, pool *redis.Pool, channel string) *RevocationBroadcast {
+	b := &RevocationBroadcast{
+		revocationCache: newRevocationCache(store, ttl),
+		pool:            pool,
+		channel:         channel,
+		stop:            make(chan struct{}),
+	}
+	b.wg.Add(1)
+	go b.subscribe()
+	return b
+}
+
+// Revoke revokes the credentials of the user in the store, then publishes the revocation. Failed
+// publications are logged: the other replicas apply the revocation once their cached generation
+// expires.
+func (b *RevocationBroadcast) Revoke(ctx context.Context, user string, at time.Time) (Revocation, error) {
+	revocation, err := b.revocationCache.Revoke(ctx, user, at)
+	if err != nil {
+		return revocation, err
+	}
+	if err := b.publish(ctx, revocationMessage{User: user, Revocation: revocation}); err != nil {
+		log.Error().Err(err).Str("user", user).Str("channel", b.channel).Dur("ttl", b.ttl).
+			Msg("Failed to broadcast the revocation, the other replicas apply it once their cache expires")
+	}
+	return revocation, nil
+}
+
+func (b *RevocationBroadcast) publish(ctx context.Context, message revocationMessage) error {
+	payload, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+	conn, err := b.pool.GetContext(ctx)
+	if err != nil {
+		return errors.Wrap(err, "failed to publish revocation")
+	}
+	defer func() { _ = conn.Close() }()
+	_, err = conn.Do("PUBLISH", b.channel, payload)
+	return errors.Wrap(err, "failed to publish revocation")
+}
+
+// subscribe applies the revocations published by any replica, reconnecting until the broadcast is
+// closed. The cache is cleared on each subscription, as revocations may have been missed while
+// disconnected.
+func (b *RevocationBroadcast) subscribe() {
+	defer b.wg.Done()
+	for {
+		conn := b.pool.Get()
+		b.subMu.Lock()
+		select {
+		case <-b.stop:
+			b.subMu.Unlock()
+			_ = conn.Close()
+			return
+		default:
+			b.subConn = conn
+		}
+		b.subMu.Unlock()
+
+		psc := redis.PubSubConn{Conn: conn}
+		err := psc.Subscribe(b.channel)
+		for err == nil {
+			switch message := psc.Receive().(type) {
+			case redis.Subscription:
+				if message.Kind == "subscribe" {
+					b.clear()
+				}
+			case redis.Message:
+				var revocation revocationMessage
+				if jsonErr := json.Unmarshal(message.Data, &revocation); jsonErr != nil || revocation.User == "" {
+					log.Warn().Err(jsonErr).Msg("Ignoring malformed revocation message")
+					continue
+				}
+				b.apply(revocation.User, revocation.Revocation)
+			case error:
+				err = message
+			}
+		}
+		_ = conn.Close()
+
+		select {
+		case <-b.stop:
+			return
+		case <-time.After(time.Second):
+			log.Warn().Err(err).Str("channel", b.channel).Msg("Revocation broadcast subscription lost, reconnecting")
+		}
+	}
+}
+
+// Close stops applying the revocations of the other replicas.
+func (b *RevocationBroadcast) Close() error {
+	close(b.stop)
+	b.subMu.Lock()
+	if b.subConn != nil {
+		_ = b.subConn.Close()
+	}
+	b.subMu.Unlock()
+	b.wg.Wait()
+	return nil
+}
@@ -0,0 +1,110 @@
+//go:build integration
+
+package server
+
+import (
+	"context"
+	"time"
+
+	"github.com/animalet/sargantana-go/pkg/database"
+	"github.com/gomodule/redigo/redis"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Revocation stores", func() {
+	ctx := context.Background()
+
+	behaveLikeAStore := func(newStore func() RevocationStore) {
+		It("should increment the generation of the users", func() {
+			store := newStore()
+			user := "integration-" + time.Now().Format(time.RFC3339Nano)
+			Expect(store.Get(ctx, user)).To(Equal(Revocation{}))
+
+			at := time.Now().Truncate(time.Millisecond)
+			revocation, err := store.Revoke(ctx, user, at)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(revocation.Generation).To(BeEquivalentTo(1))
+			Expect(revocation.RevokedAt).To(BeTemporally("~", at, time.Millisecond))
+
+			revocation, err = store.Revoke(ctx, user, at.Add(time.Minute))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(revocation.Generation).To(BeEquivalentTo(2))
+
+			revocation, err = store.Get(ctx, user)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(revocation.Generation).To(BeEquivalentTo(2))
+			Expect(revocation.RevokedAt).To(BeTemporally("~", at.Add(time.Minute), time.Millisecond))
+		})
+	}
+
+	Context("Redis", func() {
+		behaveLikeAStore(func() RevocationStore {
+			pool, err := database.RedisConfig{
+				Address:  "localhost:6379",
+				Username: "redisuser",
+				Password: "redispass",
+				MaxIdle:  3,
+			}.CreateClient()
+			Expect(err).NotTo(HaveOccurred())
+			DeferCleanup(pool.Close)
+			return NewRedisRevocationStore(pool)
+		})
+	})
+
+	It("should broadcast the revocations to the other replicas through Redis", func() {
+		pool, err := database.RedisConfig{
+			Address:  "localhost:6379",
+			Username: "redisuser",
+			Password: "redispass",
+			MaxIdle:  3,
+		}.CreateClient()
+		Expect(err).NotTo(HaveOccurred())
+		DeferCleanup(pool.Close)
+		store := NewRedisRevocationStore(pool)
+		channel := "sargantana:revocations:integration"
+		first := NewRevocationBroadcast(store, time.Hour, pool, channel)
+		DeferCleanup(first.Close)
+		second := NewRevocationBroadcast(store, time.Hour, pool, channel)
+		DeferCleanup(second.Close)
+
+		// The subscriptions clear the cache once established
+		Eventually(func() (int64, error) {
+			conn := pool.Get()
+			defer func() { _ = conn.Close() }()
+			values, err := redis.Values(conn.Do("PUBSUB", "NUMSUB", channel))
+			if err != nil {
+				return 0, err
+			}
+			return redis.Int64(values[1], nil)
+		}).Should(BeNumerically(">=", 2))
+
+		user := "broadcast-" + time.Now().Format(time.RFC3339Nano)
+		Expect(second.Get(ctx, user)).To(Equal(Revocation{}))
+		revocation, err := first.Revoke(ctx, user, time.Now())
+		Expect(err).NotTo(HaveOccurred())
+		Eventually(func() (Revocation, error) { return second.Get(ctx, user) }).
+			Should(HaveField("Generation", revocation.Generation))
+	})
+
+	Context("PostgreSQL", func() {
+		behaveLikeAStore(func() RevocationStore {
+			pool, err := database.PostgresConfig{
+				Host:     "localhost",
+				Port:     5432,
+				User:     "user",
+				Password: "password",
+				Database: "my_blog_db",
+				SSLMode:  "disable",
+			}.CreateClient()
+			Expect(err).NotTo(HaveOccurred())
+			DeferCleanup(pool.Close)
+			store, err := NewPostgresRevocationStore(ctx, pool, "credential_revocations_integration")
+			Expect(err).NotTo(HaveOccurred())
+			DeferCleanup(func() {
+				_, _ = pool.Exec(context.Background(), "DROP TABLE IF EXISTS credential_revocations_integration")
+			})
+			return store
+		})
+	})
+})
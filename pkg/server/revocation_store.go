@@ -0,0 +1,143 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/pkg/errors"
+)
+
+// Revocation is the credential generation of a user. Each revocation increments the generation,
+// invalidating the sessions opened and the tokens issued before it.
+type Revocation struct {
+	Generation int64     `json:"generation"`
+	RevokedAt  time.Time `json:"revoked_at,omitzero"`
+}
+
+// RevocationStore keeps the credential generation of the users. Users never revoked are at
+// generation zero.
+type RevocationStore interface {
+	// Revoke increments the generation of the user, revoked at the given time, and returns it.
+	Revoke(ctx context.Context, user string, at time.Time) (Revocation, error)
+	// Get returns the current generation of the user.
+	Get(ctx context.Context, user string) (Revocation, error)
+}
+
+// memoryRevocationStore is an in-process RevocationStore, for servers without replicas. The
+// revocations are lost on restart.
+type memoryRevocationStore struct {
+	mu          sync.Mutex
+	revocations map[string]Revocation
+}
+
+// NewMemoryRevocationStore creates an in-memory revocation store.
+func NewMemoryRevocationStore() RevocationStore {
+	return &memoryRevocationStore{revocations: make(map[string]Revocation)}
+}
+
+func (m *memoryRevocationStore) Revoke(_ context.Context, user string, at time.Time) (Revocation, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	revocation := Revocation{Generation: m.revocations[user].Generation + 1, RevokedAt: at}
+	m.revocations[user] = revocation
+	return revocation, nil
+}
+
+func (m *memoryRevocationStore) Get(_ context.Context, user string) (Revocation, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.revocations[user], nil
+}
+
+const redisRevocationPrefix = "sargantana:revocation:"
+
+// redisRevocationStore keeps the generation and the revocation time of each user in a hash, which
+// never expires.
+type redisRevocationStore struct {
+	pool *redis.Pool
+}
+
+// NewRedisRevocationStore creates a revocation store shared through Redis.
+func NewRedisRevocationStore(pool *redis.Pool) RevocationStore {
+	return &redisRevocationStore{pool: pool}
+}
+
+func (r *redisRevocationStore) Revoke(ctx context.Context, user string, at time.Time) (Revocation, error) {
+	conn, err := r.pool.GetContext(ctx)
+	if err != nil {
+		return Revocation{}, err
+	}
+	defer func() { _ = conn.Close() }()
+	key := redisRevocationPrefix + user
+	_ = conn.Send("MULTI")
+	_ = conn.Send("HINCRBY", key, "generation", 1)
+	_ = conn.Send("HSET", key, "revoked_at", at.UnixMilli())
+	replies, err := redis.Values(conn.Do("EXEC"))
+	if err != nil {
+		return Revocation{}, errors.Wrap(err, "failed to revoke credentials")
+	}
+	generation, err := redis.Int64(replies[0], nil)
+	if err != nil {
+		return Revocation{}, errors.Wrap(err, "failed to revoke credentials")
+	}
+	return Revocation{Generation: generation, RevokedAt: time.UnixMilli(at.UnixMilli())}, nil
+}
+
+func (r *redisRevocationStore) Get(ctx context.Context, user string) (Revocation, error) {
+	conn, err := r.pool.GetContext(ctx)
+	if err != nil {
+		return Revocation{}, err
+	}
+	defer func() { _ = conn.Close() }()
+	values, err := redis.Int64s(conn.Do("HMGET", redisRevocationPrefix+user, "generation", "revoked_at"))
+	if err != nil {
+		return Revocation{}, errors.Wrap(err, "failed to read credential generation")
+	}
+	if values[0] == 0 {
+		return Revocation{}, nil
+	}
+	return Revocation{Generation: values[0], RevokedAt: time.UnixMilli(values[1])}, nil
+}
+
+// postgresRevocationStore keeps the generation of each user in a row.
+type postgresRevocationStore struct {
+	pool  *pgxpool.Pool
+	table string
+}
+
+// NewPostgresRevocationStore creates a revocation store shared through a PostgreSQL table,
+// created if it does not exist.
+func NewPostgresRevocationStore(ctx context.Context, pool *pgxpool.Pool, table string) (RevocationStore, error) {
+	_, err := pool.Exec(ctx, fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+		user_id TEXT PRIMARY KEY,
+		generation BIGINT NOT NULL,
+		revoked_at TIMESTAMPTZ NOT NULL
+	)`, table))
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to create revocation table %s", table)
+	}
+	return &postgresRevocationStore{pool: pool, table: table}, nil
+}
+
+func (p *postgresRevocationStore) Revoke(ctx context.Context, user string, at time.Time) (Revocation, error) {
+	var revocation Revocation
+	err := p.pool.QueryRow(ctx, "INSERT INTO "+p.table+" AS t (user_id, generation, revoked_at) VALUES ($1, 1, $2) "+
+		"ON CONFLICT (user_id) DO UPDATE SET generation = t.generation + 1, revoked_at = EXCLUDED.revoked_at "+
+		"RETURNING generation, revoked_at", user, at).Scan(&revocation.Generation, &revocation.RevokedAt)
+	return revocation, errors.Wrap(err, "failed to revoke credentials")
+}
+
+func (p *postgresRevocationStore) Get(ctx context.Context, user string) (Revocation, error) {
+	var revocation Revocation
+	err := p.pool.QueryRow(ctx, "SELECT generation, revoked_at FROM "+p.table+" WHERE user_id = $1", user).
+		Scan(&revocation.Generation, &revocation.RevokedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return Revocation{}, nil
+	}
+	return revocation, errors.Wrap(err, "failed to read credential generation")
+}
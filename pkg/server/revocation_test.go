@@ -0,0 +1,150 @@
+//go:build unit
+
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/pkg/errors"
+)
+
+// failingRevocationStore is a RevocationStore whose reads fail
+type failingRevocationStore struct {
+	RevocationStore
+}
+
+func (failingRevocationStore) Get(context.Context, string) (Revocation, error) {
+	return Revocation{}, errors.New("store unavailable")
+}
+
+var _ = Describe("Credential revocation", func() {
+	ctx := context.Background()
+
+	BeforeEach(func() {
+		gin.SetMode(gin.TestMode)
+		DeferCleanup(func() { SetRevocationStore(nil, false) })
+	})
+
+	It("should increment the generation of the users in memory", func() {
+		store := NewMemoryRevocationStore()
+		Expect(store.Get(ctx, "alice")).To(Equal(Revocation{}))
+
+		at := time.Now()
+		Expect(store.Revoke(ctx, "alice", at)).To(Equal(Revocation{Generation: 1, RevokedAt: at}))
+		Expect(store.Revoke(ctx, "alice", at.Add(time.Minute))).To(Equal(Revocation{Generation: 2, RevokedAt: at.Add(time.Minute)}))
+		Expect(store.Get(ctx, "alice")).To(Equal(Revocation{Generation: 2, RevokedAt: at.Add(time.Minute)}))
+		Expect(store.Get(ctx, "bob")).To(Equal(Revocation{}))
+	})
+
+	It("should cache the generations for the TTL", func() {
+		backend := NewMemoryRevocationStore()
+		store := NewCachedRevocationStore(backend, 50*time.Millisecond)
+		Expect(store.Get(ctx, "alice")).To(Equal(Revocation{}))
+
+		// Revocations of other replicas apply once the cached generation expires
+		at := time.Now()
+		_, err := backend.Revoke(ctx, "alice", at)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(store.Get(ctx, "alice")).To(Equal(Revocation{}))
+		Eventually(func() (Revocation, error) { return store.Get(ctx, "alice") }).Should(Equal(Revocation{Generation: 1, RevokedAt: at}))
+
+		// Own revocations apply at once, and the cache keeps working while the store fails
+		Expect(store.Revoke(ctx, "alice", at)).To(HaveField("Generation", BeEquivalentTo(2)))
+		failing := NewCachedRevocationStore(failingRevocationStore{RevocationStore: backend}, time.Minute)
+		Expect(failing.Revoke(ctx, "bob", at)).To(HaveField("Generation", BeEquivalentTo(1)))
+		Expect(failing.Get(ctx, "bob")).To(HaveField("Generation", BeEquivalentTo(1)))
+		_, err = failing.Get(ctx, "carol")
+		Expect(err).To(MatchError("store unavailable"))
+	})
+
+	It("should never replace a cached generation by an older one", func() {
+		cache := newRevocationCache(NewMemoryRevocationStore(), time.Minute)
+		at := time.Now()
+		Expect(cache.apply("alice", Revocation{Generation: 2, RevokedAt: at})).To(Equal(Revocation{Generation: 2, RevokedAt: at}))
+		Expect(cache.apply("alice", Revocation{Generation: 1})).To(Equal(Revocation{Generation: 2, RevokedAt: at}))
+		Expect(cache.Get(ctx, "alice")).To(HaveField("Generation", BeEquivalentTo(2)))
+		cache.clear()
+		Expect(cache.Get(ctx, "alice")).To(Equal(Revocation{}))
+	})
+
+	Context("CredentialsRevoked", func() {
+		revoked := func(user string, current func(Revocation) bool) bool {
+			c, _ := gin.CreateTestContext(httptest.NewRecorder())
+			c.Request = httptest.NewRequest(http.MethodGet, "/", nil)
+			return CredentialsRevoked(c, user, current)
+		}
+		never := func(Revocation) bool { return false }
+
+		It("should revoke nothing without store", func() {
+			Expect(revoked("alice", never)).To(BeFalse())
+			Expect(CurrentRevocation(ctx, "alice")).To(Equal(Revocation{}))
+		})
+
+		It("should revoke the credentials of previous generations", func() {
+			store := NewMemoryRevocationStore()
+			SetRevocationStore(store, false)
+			Expect(revoked("alice", never)).To(BeFalse())
+
+			_, err := store.Revoke(ctx, "alice", time.Now())
+			Expect(err).NotTo(HaveOccurred())
+			Expect(revoked("alice", never)).To(BeTrue())
+			Expect(revoked("alice", func(r Revocation) bool { return r.Generation == 1 })).To(BeFalse())
+			Expect(revoked("bob", never)).To(BeFalse())
+		})
+
+		It("should fail open unless configured to fail closed", func() {
+			SetRevocationStore(failingRevocationStore{}, false)
+			Expect(revoked("alice", never)).To(BeFalse())
+			SetRevocationStore(failingRevocationStore{}, true)
+			Expect(revoked("alice", never)).To(BeTrue())
+		})
+	})
+
+	Context("JWTAuthenticator", func() {
+		var (
+			store         RevocationStore
+			authenticator Authenticator
+		)
+
+		BeforeEach(func() {
+			store = NewMemoryRevocationStore()
+			SetRevocationStore(store, false)
+			var err error
+			authenticator, err = NewJWTAuthenticator(JWTAuthenticatorConfig{Secret: "secret"})
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		serve := func(claims jwt.MapClaims) int {
+			signed, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte("secret"))
+			Expect(err).NotTo(HaveOccurred())
+			engine := gin.New()
+			engine.GET("/", authenticator.Middleware(), func(c *gin.Context) { c.Status(http.StatusOK) })
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.Header.Set("Authorization", "Bearer "+signed)
+			engine.ServeHTTP(w, req)
+			return w.Code
+		}
+
+		It("should reject the tokens issued before the revocation of their user", func() {
+			revokedAt := time.Now().Add(-time.Hour)
+			exp := time.Now().Add(time.Hour).Unix()
+			Expect(serve(jwt.MapClaims{"sub": "alice", "exp": exp})).To(Equal(http.StatusOK))
+
+			_, err := store.Revoke(ctx, "alice", revokedAt)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(serve(jwt.MapClaims{"sub": "alice", "exp": exp})).To(Equal(http.StatusUnauthorized))
+			Expect(serve(jwt.MapClaims{"sub": "alice", "exp": exp, "iat": revokedAt.Add(-time.Minute).Unix()})).To(Equal(http.StatusUnauthorized))
+			Expect(serve(jwt.MapClaims{"sub": "alice", "exp": exp, "iat": revokedAt.Unix()})).To(Equal(http.StatusUnauthorized))
+			Expect(serve(jwt.MapClaims{"sub": "alice", "exp": exp, "iat": revokedAt.Add(time.Minute).Unix()})).To(Equal(http.StatusOK))
+			Expect(serve(jwt.MapClaims{"sub": "bob", "exp": exp})).To(Equal(http.StatusOK))
+		})
+	})
+})
@@ -0,0 +1,68 @@
+package server
+
+import (
+	"cmp"
+	"slices"
+
+	"github.com/animalet/sargantana-go/pkg/server/session"
+	"github.com/gin-gonic/gin"
+)
+
+// Route is a route served by the gateway.
+type Route struct {
+	Method string
+	Path   string
+	// Controller and ControllerType identify the controller binding that registered the route,
+	// empty for the routes of the server, such as the readiness and metrics endpoints.
+	Controller     string
+	ControllerType string
+	Priority       Priority // Empty if the binding sets no priority
+}
+
+// Routes returns the routes of the bootstrapped server, sorted by path and method, or nil if the
+// server has not been started.
+func (s *Server) Routes() []Route {
+	if s.httpServer == nil {
+		return nil
+	}
+	engine, ok := s.httpServer.Handler.(*gin.Engine)
+	if !ok {
+		return nil
+	}
+	var routes []Route
+	for _, info := range engine.Routes() {
+		labels := s.routes[info.Method+" "+info.Path]
+		routes = append(routes, Route{
+			Method:         info.Method,
+			Path:           info.Path,
+			Controller:     labels.name,
+			ControllerType: labels.typeName,
+			Priority:       labels.priority,
+		})
+	}
+	slices.SortFunc(routes, func(a, b Route) int {
+		return cmp.Or(cmp.Compare(a.Path, b.Path), cmp.Compare(a.Method, b.Method))
+	})
+	return routes
+}
+
+// DescribeRoutes bootstraps a server with the configuration, without listening, and returns its
+// routes, e.g. to compare the surface of a gateway across versions. The sessions are kept in
+// memory and the listener certificates are not loaded, so that neither session store nor
+// certificates are needed; the controllers are configured as in Start. Controllers that fail to
+// configure are excluded, as in Start, and so are their routes.
+func DescribeRoutes(cfg SargantanaConfig) ([]Route, error) {
+	cfg.WebServerConfig.TLS = nil
+	cfg.WebServerConfig.ACME = nil
+	cfg.WebServerConfig.ShutdownReportFile = ""
+	s := NewServer(cfg)
+	s.SetSessionStore(session.NewMemorySessionStore(false, []byte(cfg.WebServerConfig.SessionSecret)))
+	if err := s.bootstrap(); err != nil {
+		return nil, err
+	}
+	routes := s.Routes()
+	if err := s.Shutdown(); err != nil {
+		return nil, err
+	}
+	return routes, nil
+}
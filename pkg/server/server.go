@@ -1,32 +1,80 @@
 package server
 
 import (
-	"bytes"
 	"context"
+	"crypto/tls"
 	"fmt"
+	"io"
+	"maps"
 	"net"
 	"net/http"
 	"os"
 	"os/signal"
-	"syscall"
+	"reflect"
+	"slices"
+	"sync/atomic"
 	"time"
 
 	"github.com/animalet/sargantana-go/internal/snapshot"
 	"github.com/animalet/sargantana-go/pkg/config"
+	"github.com/animalet/sargantana-go/pkg/logging"
 	"github.com/animalet/sargantana-go/pkg/server/session"
 	"github.com/gin-contrib/secure"
 	"github.com/gin-contrib/sessions"
 	"github.com/gin-gonic/gin"
+	"github.com/hashicorp/vault/api"
 	"github.com/pkg/errors"
-	"github.com/rs/zerolog"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/rs/zerolog/log"
 )
 
 type WebServerConfig struct {
-	Address       string          `yaml:"address"`
-	SessionName   string          `yaml:"session_name"`
-	SessionSecret string          `yaml:"session_secret"`
-	Security      *SecurityConfig `yaml:"security,omitempty"`
+	Address       string `yaml:"address"`
+	SessionName   string `yaml:"session_name"`
+	SessionSecret string `yaml:"session_secret"`
+	// ServerSideSessions keeps session values (OAuth tokens, raw user data) on the server and only
+	// sends a session reference to the client. Without an external session store, an embedded
+	// in-memory store is used instead of the cookie store.
+	ServerSideSessions bool            `yaml:"server_side_sessions,omitempty"`
+	Security           *SecurityConfig `yaml:"security,omitempty"`
+	IPFilter           *IPFilterConfig `yaml:"ip_filter,omitempty"`
+	TLS                *TLSConfig      `yaml:"tls,omitempty"`
+	SPIFFE             *SPIFFEConfig   `yaml:"spiffe,omitempty"`
+	SLO                *SLOConfig      `yaml:"slo,omitempty"`
+	// UsageExport exports the usage of the quota middlewares on a schedule, see UsageExportConfig.
+	UsageExport *UsageExportConfig `yaml:"usage_export,omitempty"`
+	// ACME obtains and renews the certificate of the listener from an ACME certificate authority,
+	// such as Let's Encrypt, instead of the files of the tls section, see ACMEConfig.
+	ACME *ACMEConfig `yaml:"acme,omitempty"`
+	// Authenticator selects the authenticator protecting the routes of the controllers, unless
+	// one is set with Server.SetAuthenticator. Without both, protected routes reject every request.
+	Authenticator *AuthenticatorConfig `yaml:"authenticator,omitempty"`
+	// Authenticators are additional named authenticators, selected with the authenticator field
+	// of controller bindings or with authenticator routes.
+	Authenticators map[string]AuthenticatorConfig `yaml:"authenticators,omitempty"`
+	// AuthenticatorRoutes select the authenticator by request path prefix, overriding the one of
+	// the controller binding. The longest matching prefix wins.
+	AuthenticatorRoutes []AuthenticatorRoute `yaml:"authenticator_routes,omitempty"`
+	// Preflight configures the checks run before the listener opens, see PreflightConfig.
+	Preflight *PreflightConfig `yaml:"preflight,omitempty"`
+	// Health serves the liveness and readiness endpoints, see HealthConfig.
+	Health *HealthConfig `yaml:"health,omitempty"`
+	// Priorities assigns the priorities of requests used by load shedding and queueing, see
+	// PrioritiesConfig.
+	Priorities *PrioritiesConfig `yaml:"priorities,omitempty"`
+	// LoadShedding rejects low priority requests while the gateway is saturated, see
+	// LoadSheddingConfig.
+	LoadShedding *LoadSheddingConfig `yaml:"load_shedding,omitempty"`
+	// Metrics exposes the Prometheus metrics of the server, see MetricsConfig.
+	Metrics *MetricsConfig `yaml:"metrics,omitempty"`
+	// UserSessionKey is the session key under which the auth controller stores the authenticated
+	// user. Defaults to "user".
+	UserSessionKey string `yaml:"user_session_key,omitempty"`
+	// Sessions are additional named sessions, see NamedSessionConfig.
+	Sessions []NamedSessionConfig `yaml:"sessions,omitempty"`
+	// ShutdownReportFile is written with the JSON ShutdownReport when the server shuts down.
+	// Optional.
+	ShutdownReportFile string `yaml:"shutdown_report_file,omitempty"`
 }
 
 func (c WebServerConfig) Validate() error {
@@ -47,12 +95,122 @@ func (c WebServerConfig) Validate() error {
 		return fmt.Errorf("invalid address: %w", err)
 	}
 
+	if err := validateNamedSessions(c.SessionName, c.Sessions); err != nil {
+		return fmt.Errorf("invalid sessions configuration: %w", err)
+	}
+
 	if c.Security != nil {
 		if err := c.Security.Validate(); err != nil {
 			return fmt.Errorf("invalid security configuration: %w", err)
 		}
 	}
 
+	if c.IPFilter != nil {
+		if err := c.IPFilter.Validate(); err != nil {
+			return fmt.Errorf("invalid ip_filter configuration: %w", err)
+		}
+	}
+
+	if c.SLO != nil {
+		if err := c.SLO.Validate(); err != nil {
+			return fmt.Errorf("invalid slo configuration: %w", err)
+		}
+	}
+
+	if c.UsageExport != nil {
+		if err := c.UsageExport.Validate(); err != nil {
+			return fmt.Errorf("invalid usage_export configuration: %w", err)
+		}
+	}
+
+	if c.TLS != nil {
+		if err := c.TLS.Validate(); err != nil {
+			return fmt.Errorf("invalid tls configuration: %w", err)
+		}
+	}
+
+	if c.ACME != nil {
+		if c.TLS != nil {
+			return errors.New("tls and acme cannot be configured at the same time")
+		}
+		if err := c.ACME.Validate(); err != nil {
+			return fmt.Errorf("invalid acme configuration: %w", err)
+		}
+	}
+
+	if c.Authenticator != nil {
+		if err := c.Authenticator.Validate(); err != nil {
+			return fmt.Errorf("invalid authenticator configuration: %w", err)
+		}
+	}
+
+	for name, authenticator := range c.Authenticators {
+		if name == "" || name == DefaultAuthenticatorName {
+			return fmt.Errorf("invalid authenticator name %q", name)
+		}
+		if err := authenticator.Validate(); err != nil {
+			return fmt.Errorf("invalid configuration of authenticator %q: %w", name, err)
+		}
+	}
+
+	for i, route := range c.AuthenticatorRoutes {
+		if err := route.Validate(); err != nil {
+			return fmt.Errorf("invalid authenticator route at index %d: %w", i, err)
+		}
+	}
+
+	if c.Preflight != nil {
+		if err := c.Preflight.Validate(); err != nil {
+			return fmt.Errorf("invalid preflight configuration: %w", err)
+		}
+	}
+
+	if c.Health != nil {
+		if err := c.Health.Validate(); err != nil {
+			return fmt.Errorf("invalid health configuration: %w", err)
+		}
+		if c.Preflight != nil && c.Preflight.ReadinessPath != "" && c.Health.ReadinessPath != "" &&
+			c.Preflight.ReadinessPath != c.Health.ReadinessPath {
+			return errors.New("preflight and health readiness paths must be the same")
+		}
+		if liveness, readiness := c.ProbePaths(); liveness == readiness {
+			return errors.New("health liveness and readiness paths must differ")
+		}
+	}
+
+	if c.Priorities != nil {
+		if err := c.Priorities.Validate(); err != nil {
+			return fmt.Errorf("invalid priorities configuration: %w", err)
+		}
+	}
+
+	if c.LoadShedding != nil {
+		if err := c.LoadShedding.Validate(); err != nil {
+			return fmt.Errorf("invalid load_shedding configuration: %w", err)
+		}
+	}
+
+	if c.Metrics != nil {
+		if err := c.Metrics.Validate(); err != nil {
+			return fmt.Errorf("invalid metrics configuration: %w", err)
+		}
+		if c.Metrics.Address != "" && c.Metrics.Address == c.Address {
+			return errors.New("metrics address must differ from the server address")
+		}
+	}
+
+	if c.SPIFFE != nil {
+		if c.TLS != nil {
+			return errors.New("tls and spiffe cannot be configured at the same time")
+		}
+		if c.ACME != nil {
+			return errors.New("acme and spiffe cannot be configured at the same time")
+		}
+		if err := c.SPIFFE.Validate(); err != nil {
+			return fmt.Errorf("invalid spiffe configuration: %w", err)
+		}
+	}
+
 	return nil
 }
 
@@ -99,26 +257,69 @@ func (c SargantanaConfig) Validate() error {
 
 // Server represents the main HTTP server instance for the Sargantana Go framework.
 type Server struct {
-	config          SargantanaConfig
-	httpServer      *http.Server
-	shutdownHooks   []func() error
-	shutdownChannel chan os.Signal
-	sessionStore    sessions.Store
-	authenticator   Authenticator
+	config           SargantanaConfig
+	httpServer       *http.Server
+	listener         net.Listener
+	challengeServer  *http.Server // Answers the ACME HTTP-01 challenges, if configured
+	metricsServer    *http.Server // Serves the metrics on their own address, if configured
+	routes           controllerRoutes
+	healthReporters  []healthReporter
+	state            atomic.Int32 // stateStarting, stateServing or stateDraining
+	shutdownHooks    []func() error
+	stoppingHooks    []func()
+	reloadHooks      []func() error
+	shutdownRequests chan struct{}
+	reloadRequests   chan struct{}
+	sessionStore     sessions.Store
+	authenticator    Authenticator
+	authenticators   map[string]Authenticator
+	reputation       ReputationStore
+	identity         *WorkloadIdentity
+	vaultClient      *api.Client
+	preflightChecks  []preflightCheck
+	diagnostics      []Diagnostic
+	startedAt        time.Time
+	requests         atomic.Int64
+	fatalErrors      chan error
+	shutdownReason   ShutdownReason
+	shutdownSignal   string
+	fatalErr         error
+	report           *ShutdownReport
 }
 
+// ErrListen is the cause of the errors returned by Start when the server cannot listen on the
+// configured address, telling bind errors apart from configuration errors.
+var ErrListen = errors.New("listen error")
+
 // controllerRegistry holds the mapping of controller type names to their factory functions.
 var controllerRegistry = make(map[string]ControllerFactory)
 
+// controllerConfigTypes holds the configuration types of the controller types registered with
+// RegisterController, against which the environment overrides are resolved.
+var controllerConfigTypes = make(map[string]reflect.Type)
+
 var debug = false
 
+var quiet = false
+
+// SetQuiet suppresses the colored request log and the debug output of Gin, leaving the records of
+// the configured logger as the only console output.
+func SetQuiet(quietEnabled bool) {
+	quiet = quietEnabled
+	if quietEnabled {
+		gin.DisableConsoleColor()
+		gin.DefaultWriter = io.Discard
+	} else {
+		gin.DefaultWriter = os.Stdout
+	}
+}
+
 func SetDebug(debugEnabled bool) {
 	debug = debugEnabled
+	logging.SetDebug(debugEnabled)
 	if debugEnabled {
-		zerolog.SetGlobalLevel(zerolog.DebugLevel)
 		gin.SetMode(gin.DebugMode)
 	} else {
-		zerolog.SetGlobalLevel(zerolog.InfoLevel)
 		gin.SetMode(gin.ReleaseMode)
 	}
 }
@@ -127,10 +328,17 @@ func SetDebug(debugEnabled bool) {
 // The configuration is deep-copied internally to ensure that subsequent modifications
 // to the original config don't affect the running server.
 func NewServer(cfg SargantanaConfig) *Server {
-	return &Server{
-		config:        *snapshot.MustCopy(&cfg),
-		authenticator: NewUnauthorizedAuthenticator(),
+	s := &Server{
+		config:           *snapshot.MustCopy(&cfg),
+		authenticators:   make(map[string]Authenticator),
+		shutdownRequests: make(chan struct{}, 1),
+		reloadRequests:   make(chan struct{}, 1),
+		fatalErrors:      make(chan error, 1),
+	}
+	if cfg.WebServerConfig.IPFilter != nil && cfg.WebServerConfig.IPFilter.Reputation != nil {
+		s.reputation = NewMemoryReputationStore(cfg.WebServerConfig.IPFilter.Reputation.TTL)
 	}
+	return s
 }
 
 func (s *Server) SetSessionStore(sessionStore sessions.Store) {
@@ -142,7 +350,8 @@ func (s *Server) SetSessionStore(sessionStore sessions.Store) {
 // the application. The provided authenticator will be passed to all controllers
 // that need authentication middleware.
 //
-// By default, the server uses UnauthorizedAuthenticator which rejects all
+// The authenticator set here takes precedence over the authenticator section of the
+// configuration. Without both, the server uses UnauthorizedAuthenticator which rejects all
 // authenticated requests. To enable authentication, set a proper authenticator:
 //
 //	server.SetAuthenticator(controller.NewGothAuthenticator())
@@ -152,6 +361,33 @@ func (s *Server) SetAuthenticator(authenticator Authenticator) {
 	s.authenticator = authenticator
 }
 
+// AddAuthenticator adds a named authenticator, which controller bindings and authenticator
+// routes select by name. It takes precedence over the authenticator of the same name in the
+// authenticators section of the configuration.
+func (s *Server) AddAuthenticator(name string, authenticator Authenticator) {
+	s.authenticators[name] = authenticator
+}
+
+// SetVaultClient sets the Vault client used to issue the server certificate when
+// tls.vault_pki is configured. It is usually the client created from the vault configuration
+// section that also backs the ${vault:...} secret provider.
+func (s *Server) SetVaultClient(client *api.Client) {
+	s.vaultClient = client
+}
+
+// ReputationStore returns the IP reputation store consulted by the IP filtering middleware,
+// or nil if reputation tracking is not enabled in the configuration. Abuse detection
+// subsystems can report penalties through it.
+func (s *Server) ReputationStore() ReputationStore {
+	return s.reputation
+}
+
+// WorkloadIdentity returns the SPIFFE workload identity of the server, or nil if SPIFFE is not
+// configured or the server has not been started yet.
+func (s *Server) WorkloadIdentity() *WorkloadIdentity {
+	return s.identity
+}
+
 func addControllerType(typeName string, factory ControllerFactory) {
 	log.Info().Msgf("Registering controller type %q", typeName)
 	_, exists := controllerRegistry[typeName]
@@ -159,6 +395,7 @@ func addControllerType(typeName string, factory ControllerFactory) {
 		log.Warn().Msgf("Controller type %q is already registered, overriding", typeName)
 	}
 	controllerRegistry[typeName] = factory
+	delete(controllerConfigTypes, typeName)
 }
 
 // RegisterController registers a controller factory that takes a typed configuration.
@@ -171,29 +408,42 @@ func RegisterController[T config.Validatable](typeName string, factory func(cfg
 		}
 		return factory(cfg, ctx)
 	})
+	controllerConfigTypes[typeName] = reflect.TypeFor[T]()
+}
+
+// ControllerTypes returns the sorted names of the registered controller types.
+func ControllerTypes() []string {
+	return slices.Sorted(maps.Keys(controllerRegistry))
 }
 
-func configureControllers(c SargantanaConfig, sessionStore sessions.Store) (controllers []IController, configErrors []error) {
-	instanceCounts := make(map[string]int) // Track instances per type for auto-naming
+// configuredController is a controller created from a binding, with the login middleware of the
+// authenticator selected by the binding and the router group options of the binding.
+type configuredController struct {
+	controller       IController
+	labels           controllerLabels
+	loginMiddleware  gin.HandlerFunc
+	basePath         string
+	groupMiddlewares []gin.HandlerFunc
+}
 
-	// Build the controller context with runtime dependencies
-	ctx := ControllerContext{
-		ServerConfig: c.WebServerConfig,
-		SessionStore: sessionStore,
+// bind registers the routes of the controller, on its router group if it is a GroupController.
+func (c configuredController) bind(engine *gin.Engine) error {
+	groupController, ok := c.controller.(GroupController)
+	if !ok {
+		return c.controller.Bind(engine, c.loginMiddleware)
+	}
+	basePath := c.basePath
+	if basePath == "" {
+		basePath = "/"
 	}
+	return groupController.BindGroup(engine.Group(basePath, c.groupMiddlewares...), c.loginMiddleware)
+}
 
-	for _, binding := range c.ControllerBindings {
-		// Generate instance name
-		instanceName := binding.Name
-		if instanceName == "" {
-			instanceCounts[binding.TypeName]++
-			count := instanceCounts[binding.TypeName]
-			if count == 1 {
-				instanceName = binding.TypeName
-			} else {
-				instanceName = fmt.Sprintf("%s-%d", binding.TypeName, count)
-			}
-		}
+func configureControllers(c SargantanaConfig, ctx ControllerContext, login *loginMiddlewares) (controllers []configuredController, configErrors []error) {
+	instanceNames := c.ControllerBindings.instanceNames()
+
+	for i, binding := range c.ControllerBindings {
+		instanceName := instanceNames[i]
 
 		factory, exists := controllerRegistry[binding.TypeName]
 		if !exists {
@@ -201,12 +451,38 @@ func configureControllers(c SargantanaConfig, sessionStore sessions.Store) (cont
 			continue
 		}
 
+		loginMiddleware, err := login.forBinding(binding.Authenticator)
+		if err != nil {
+			configErrors = append(configErrors, fmt.Errorf("error configuring controller %q of type %q: %v", instanceName, binding.TypeName, err))
+			continue
+		}
+		ctx.login = loginMiddleware
+		ctx.BasePath = binding.BasePath
+
 		newController, err := newController(ctx, instanceName, binding, factory)
-		if err == nil {
-			controllers = append(controllers, newController)
+		if err != nil {
+			configErrors = append(configErrors, fmt.Errorf("error configuring controller %q of type %q: %v", instanceName, binding.TypeName, err))
+			continue
+		}
+		configured := configuredController{
+			controller:      newController,
+			labels:          controllerLabels{name: instanceName, typeName: binding.TypeName, priority: binding.Priority},
+			loginMiddleware: loginMiddleware,
+			basePath:        binding.BasePath,
+		}
+		if _, ok := newController.(GroupController); !ok && binding.groupOptions() {
+			err = errors.New("base_path, hosts and middlewares are not supported by this controller type")
 		} else {
+			ctx.Name = instanceName
+			tracer, ok := newController.(MiddlewareTracer)
+			configured.groupMiddlewares, err = groupMiddlewares(binding, ctx, ok && tracer.TraceMiddlewares())
+		}
+		if err != nil {
+			_ = newController.Close()
 			configErrors = append(configErrors, fmt.Errorf("error configuring controller %q of type %q: %v", instanceName, binding.TypeName, err))
+			continue
 		}
+		controllers = append(controllers, configured)
 	}
 	return controllers, configErrors
 }
@@ -219,6 +495,7 @@ func newController(ctx ControllerContext, name string, binding ControllerBinding
 			newController = nil
 		}
 	}()
+	ctx.Name = name
 	if newController, err = factory(binding.Config, ctx); err != nil {
 		return nil, errors.Wrap(err, fmt.Sprintf("failed to configure %s controller of type: %s", name, binding.TypeName))
 	}
@@ -230,7 +507,16 @@ func (s *Server) StartAndWaitForSignal() error {
 	if err != nil {
 		return err
 	}
-	return s.waitForSignal()
+	return s.WaitForSignal()
+}
+
+// Addresses returns the addresses the server listens on, with the actual port when the
+// configured address uses port 0. It is empty until the server is started.
+func (s *Server) Addresses() []string {
+	if s.listener == nil {
+		return nil
+	}
+	return []string{s.listener.Addr().String()}
 }
 
 func (s *Server) Start() (err error) {
@@ -253,25 +539,62 @@ func (s *Server) Start() (err error) {
 		return err
 	}
 
-	s.listenAndServe()
-
-	return nil
+	return s.listenAndServe()
 }
 
 func (s *Server) bootstrap() error {
 	log.Info().Msg("Bootstrapping server...")
+	metricsConfig := s.config.WebServerConfig.Metrics
+	if metricsConfig != nil {
+		s.sessionStore = session.WithMetrics(s.sessionStore)
+	}
+
+	if s.config.WebServerConfig.SPIFFE != nil && s.identity == nil {
+		identity, err := NewWorkloadIdentity(*s.config.WebServerConfig.SPIFFE)
+		if err != nil {
+			return err
+		}
+		s.identity = identity
+		s.addShutdownHook(identity.Close)
+	}
+
+	loginMiddlewares, err := s.configureAuthenticators()
+	if err != nil {
+		return err
+	}
 
 	// Configure controllers with session store now that it's available
-	controllers, configurationErrors := configureControllers(s.config, s.sessionStore)
+	controllers, configurationErrors := configureControllers(s.config, ControllerContext{
+		ServerConfig: s.config.WebServerConfig,
+		SessionStore: s.sessionStore,
+		Reputation:   s.reputation,
+		Identity:     s.identity,
+	}, loginMiddlewares)
 	if len(configurationErrors) > 0 {
 		log.Error().Msg("Configuration errors encountered, affected controllers have been excluded from bootstrap:")
 		for _, configErr := range configurationErrors {
 			log.Error().Msgf(" - %v", configErr)
 		}
 	}
+	s.diagnostics = s.runStartupChecks(controllers)
+	for _, c := range controllers {
+		if reporter, ok := c.controller.(HealthReporter); ok {
+			s.healthReporters = append(s.healthReporters, healthReporter{name: controllerHealthCheck, controller: c.labels.name, reporter: reporter})
+		}
+	}
+	if preflight := s.config.WebServerConfig.Preflight; preflight != nil && preflight.Strict {
+		if failed := failedRequiredChecks(s.diagnostics); len(failed) > 0 {
+			for _, c := range controllers {
+				_ = c.controller.Close()
+			}
+			return preflightError(failed)
+		}
+	}
 
 	// Initialize Gin engine
-	gin.ForceConsoleColor()
+	if !quiet {
+		gin.ForceConsoleColor()
+	}
 	engine := gin.New()
 	if gin.IsDebugging() {
 		log.Info().Msg("Running in debug mode")
@@ -284,11 +607,83 @@ func (s *Server) bootstrap() error {
 		}
 		engine.Use(gin.ErrorLoggerT(gin.ErrorTypePrivate))
 	}
+	routes := make(controllerRoutes)
+	s.routes = routes
+	livenessPath, readinessPath := s.config.WebServerConfig.ProbePaths()
+	var probePaths []string
+	for _, path := range []string{livenessPath, readinessPath} {
+		if path != "" {
+			probePaths = append(probePaths, path)
+		}
+	}
+	engine.Use(s.countRequests)
+	if metricsConfig != nil {
+		engine.Use(requestMetricsMiddleware)
+	}
 	engine.Use(
 		gin.Logger(),
+		clientClosedMiddleware,
 		gin.Recovery(),
-		sessions.Sessions(s.config.WebServerConfig.SessionName, s.sessionStore),
+		requestContextMiddleware,
+		routes.middleware,
+		newPriorityResolver(s.config.WebServerConfig.Priorities, probePaths, routes).middleware,
 	)
+	if s.config.WebServerConfig.SLO != nil {
+		log.Info().Msg("Tracking service level objectives")
+		tracker := newSLOTracker(*s.config.WebServerConfig.SLO)
+		tracker.start()
+		engine.Use(tracker.middleware())
+		s.addShutdownHook(tracker.Close)
+	}
+	if exportConfig := s.config.WebServerConfig.UsageExport; exportConfig != nil {
+		log.Info().Str("schedule", exportConfig.Schedule).Msg("Scheduling usage export")
+		exporter, err := newUsageExporter(*exportConfig)
+		if err != nil {
+			return errors.Wrap(err, "failed to configure usage export")
+		}
+		exporter.start()
+		s.addShutdownHook(exporter.Close)
+	}
+	if logging.ShippingEnabled() {
+		log.Info().Msg("Shipping access logs")
+		engine.Use(accessLogMiddleware)
+	}
+
+	if sheddingConfig := s.config.WebServerConfig.LoadShedding; sheddingConfig != nil {
+		log.Info().Msg("Applying load shedding middleware")
+		shedder := newLoadShedder(*sheddingConfig)
+		shedder.start()
+		engine.Use(shedder.middleware())
+		s.addShutdownHook(shedder.Close)
+	}
+
+	if s.config.WebServerConfig.IPFilter != nil {
+		log.Info().Msg("Applying IP filtering middleware")
+		filter, err := newIPFilter(*s.config.WebServerConfig.IPFilter, s.reputation)
+		if err != nil {
+			return errors.Wrap(err, "failed to configure IP filter")
+		}
+		engine.Use(filter.middleware())
+		s.addShutdownHook(filter.Close)
+	}
+
+	// Probes need no session
+	if livenessPath != "" {
+		engine.GET(livenessPath, livenessHandler)
+	}
+	if readinessPath != "" {
+		engine.GET(readinessPath, s.readinessHandler)
+	}
+	if metricsConfig != nil {
+		if metricsConfig.Address != "" {
+			s.metricsServer = metricsConfig.metricsServer()
+		} else {
+			engine.GET(metricsConfig.path(), gin.WrapH(promhttp.Handler()))
+		}
+		log.Info().Str("path", metricsConfig.path()).Str("address", metricsConfig.Address).Msg("Exposing metrics")
+	}
+
+	engine.Use(sessionMiddlewares(s.config.WebServerConfig.SessionName, s.config.WebServerConfig.UserSessionKey, s.config.WebServerConfig.Sessions, s.sessionStore)...)
 
 	if s.config.WebServerConfig.Security != nil {
 		log.Info().Msg("Applying security middleware")
@@ -315,11 +710,11 @@ func (s *Server) bootstrap() error {
 	}
 
 	for _, c := range controllers {
-		log.Debug().Msgf("Binding controller: %T", c)
-		if err := c.Bind(engine, s.authenticator.Middleware()); err != nil {
+		log.Debug().Msgf("Binding controller: %T", c.controller)
+		if err := routes.bind(engine, c); err != nil {
 			return errors.Wrap(err, "failed to bind controller")
 		}
-		s.addShutdownHook(c.Close)
+		s.addShutdownHook(c.controller.Close)
 	}
 
 	s.httpServer = &http.Server{
@@ -331,6 +726,47 @@ func (s *Server) bootstrap() error {
 		IdleTimeout:       120 * time.Second,
 	}
 
+	if s.config.WebServerConfig.TLS != nil {
+		tlsConfig, err := s.config.WebServerConfig.TLS.buildTLSConfig()
+		if err != nil {
+			return errors.Wrap(err, "failed to configure TLS")
+		}
+		if vaultPKI := s.config.WebServerConfig.TLS.VaultPKI; vaultPKI != nil {
+			if s.vaultClient == nil {
+				return errors.New("tls.vault_pki is configured but no Vault client was provided")
+			}
+			issuer, err := newVaultCertificateIssuer(s.vaultClient, *vaultPKI)
+			if err != nil {
+				return errors.Wrap(err, "failed to configure TLS")
+			}
+			tlsConfig.GetCertificate = issuer.GetCertificate
+			s.addShutdownHook(issuer.Close)
+		}
+		s.httpServer.TLSConfig = tlsConfig
+		log.Info().Str("client_auth", s.config.WebServerConfig.TLS.ClientAuth).Msg("TLS enabled")
+	} else if acmeConfig := s.config.WebServerConfig.ACME; acmeConfig != nil {
+		manager, closer, err := newACMEManager(*acmeConfig)
+		if err != nil {
+			return errors.Wrap(err, "failed to configure ACME")
+		}
+		s.addShutdownHook(closer)
+		// Certificates are obtained on the first handshake of each domain, and renewed in the
+		// background
+		s.httpServer.TLSConfig = manager.TLSConfig()
+		s.httpServer.TLSConfig.MinVersion = tls.VersionTLS12
+		if acmeConfig.HTTPAddress != "" {
+			s.challengeServer = &http.Server{
+				Addr:              acmeConfig.HTTPAddress,
+				Handler:           manager.HTTPHandler(nil),
+				ReadHeaderTimeout: 10 * time.Second,
+			}
+		}
+		log.Info().Strs("domains", acmeConfig.Domains).Msg("TLS enabled with ACME certificates")
+	} else if s.identity != nil {
+		s.httpServer.TLSConfig = s.identity.serverTLSConfig(s.config.WebServerConfig.SPIFFE.clientAuthorizer())
+		log.Info().Msg("TLS enabled with SPIFFE workload identity")
+	}
+
 	log.Info().Msgf("Starting server on %s", s.config.WebServerConfig.Address)
 	// listenAndServe is now called by Start()
 
@@ -344,29 +780,140 @@ func (s *Server) createSessionStore(isReleaseMode bool) (sessions.Store, error)
 	}
 	sessionSecret := []byte(secret)
 
-	// Default to cookie-based session storage
 	// For Redis or other session stores, use SetSessionStore() before calling Start()
+	if s.config.WebServerConfig.ServerSideSessions {
+		log.Info().Msg("Using embedded in-memory session storage")
+		return session.NewMemorySessionStore(isReleaseMode, sessionSecret), nil
+	}
+
+	// Default to cookie-based session storage
 	log.Info().Msg("Using default cookie-based session storage")
 	sessionStore := session.NewCookieStore(isReleaseMode, sessionSecret)
 	return sessionStore, nil
 }
 
-func (s *Server) waitForSignal() error {
-	s.shutdownChannel = make(chan os.Signal, 1)
-	// kill (no params) by default sends syscall.SIGTERM
-	// kill -2 is syscall.SIGINT
-	// kill -9 is syscall.SIGKILL but can't be caught, so don't need to add it
-	signal.Notify(s.shutdownChannel, syscall.SIGINT, syscall.SIGTERM)
-	log.Info().Msgf("Shutdown signal received (%s)", <-s.shutdownChannel)
-	return s.Shutdown()
+// OnReload registers a hook executed on reload signals and requests, such as reopening log files.
+func (s *Server) OnReload(hook func() error) {
+	s.reloadHooks = append(s.reloadHooks, hook)
+}
+
+// OnStopping registers a hook executed when the shutdown begins, before connections are drained.
+func (s *Server) OnStopping(hook func()) {
+	s.stoppingHooks = append(s.stoppingHooks, hook)
+}
+
+// WaitForSignal blocks until a shutdown signal is received or a shutdown is requested, then shuts
+// the server down. Reload signals and requests execute the reload hooks and keep waiting. The
+// signals depend on the platform: SIGINT and SIGTERM shut down and SIGHUP reloads on Unix, while
+// Windows only has shutdown signals (Ctrl+C, Ctrl+Break and console close events).
+func (s *Server) WaitForSignal() error {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, append(shutdownSignals, reloadSignals...)...)
+	defer signal.Stop(signals)
+	for {
+		select {
+		case received := <-signals:
+			if slices.Contains(reloadSignals, received) {
+				s.reload()
+				continue
+			}
+			log.Info().Msgf("Shutdown signal received (%s)", received)
+			s.shutdownReason, s.shutdownSignal = ShutdownSignal, received.String()
+		case <-s.shutdownRequests:
+			log.Info().Msg("Shutdown requested")
+			s.shutdownReason = ShutdownRequested
+		case err := <-s.fatalErrors:
+			s.shutdownReason, s.fatalErr = ShutdownFatalError, err
+			if shutdownErr := s.Shutdown(); shutdownErr != nil {
+				log.Error().Err(shutdownErr).Msg("Failed to shut down")
+			}
+			return fmt.Errorf("%w: %w", ErrFatal, err)
+		case <-s.reloadRequests:
+			s.reload()
+			continue
+		}
+		return s.Shutdown()
+	}
+}
+
+// RequestShutdown makes WaitForSignal shut the server down as if a shutdown signal was received.
+// Service managers without signals, such as the Windows service control manager, use it.
+func (s *Server) RequestShutdown() {
+	select {
+	case s.shutdownRequests <- struct{}{}:
+	default:
+	}
+}
+
+// RequestReload makes WaitForSignal execute the reload hooks as if a reload signal was received.
+func (s *Server) RequestReload() {
+	select {
+	case s.reloadRequests <- struct{}{}:
+	default:
+	}
+}
+
+func (s *Server) reload() {
+	log.Info().Msg("Reload signal received, executing reload hooks...")
+	for _, hook := range s.reloadHooks {
+		if err := hook(); err != nil {
+			log.Error().Msgf("Error during reload hook: %s", err)
+		}
+	}
 }
 
-func (s *Server) listenAndServe() {
+// listenAndServe opens the listener before serving in the background, so that listen errors are
+// returned by Start and the server accepts connections once it returns.
+func (s *Server) listenAndServe() error {
+	listener, err := net.Listen("tcp", s.httpServer.Addr)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrListen, err)
+	}
+	s.listener = listener
+	s.startedAt = time.Now()
+	var auxiliaryListeners []net.Listener
+	for name, auxiliary := range s.auxiliaryServers() {
+		auxiliaryListener, err := net.Listen("tcp", auxiliary.Addr)
+		if err != nil {
+			_ = listener.Close()
+			for _, l := range auxiliaryListeners {
+				_ = l.Close()
+			}
+			return fmt.Errorf("%w: %s: %w", ErrListen, name, err)
+		}
+		auxiliaryListeners = append(auxiliaryListeners, auxiliaryListener)
+		go func() {
+			if err := auxiliary.Serve(auxiliaryListener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				log.Error().Err(err).Msgf("%s listener failed", name)
+			}
+		}()
+	}
 	go func() {
-		if err := s.httpServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
-			log.Fatal().Msgf("Listen error: %s", err)
+		var err error
+		if s.httpServer.TLSConfig != nil {
+			// Certificates are already loaded into TLSConfig
+			err = s.httpServer.ServeTLS(listener, "", "")
+		} else {
+			err = s.httpServer.Serve(listener)
+		}
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			s.fail(fmt.Errorf("listen error: %w", err))
 		}
 	}()
+	s.state.Store(stateServing)
+	return nil
+}
+
+// auxiliaryServers returns the configured listeners served next to the server, by name
+func (s *Server) auxiliaryServers() map[string]*http.Server {
+	servers := make(map[string]*http.Server)
+	if s.challengeServer != nil {
+		servers["ACME challenge"] = s.challengeServer
+	}
+	if s.metricsServer != nil {
+		servers["metrics"] = s.metricsServer
+	}
+	return servers
 }
 
 func (s *Server) addShutdownHook(f func() error) {
@@ -375,43 +922,84 @@ func (s *Server) addShutdownHook(f func() error) {
 
 func (s *Server) Shutdown() error {
 	log.Info().Msg("Shutting down server...")
+	serving := s.state.Swap(stateDraining) == stateServing
+	for _, hook := range s.stoppingHooks {
+		hook()
+	}
+	if health := s.config.WebServerConfig.Health; serving && health != nil && health.DrainDelay > 0 {
+		log.Info().Dur("delay", health.DrainDelay).Msg("Reporting unreadiness before draining connections")
+		time.Sleep(health.DrainDelay)
+	}
 
+	report := s.newShutdownReport()
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer cancel()
 
+	for name, auxiliary := range s.auxiliaryServers() {
+		if err := auxiliary.Shutdown(ctx); err != nil {
+			log.Error().Err(err).Msgf("Failed to shut the %s listener down", name)
+		}
+	}
 	if err := s.httpServer.Shutdown(ctx); err != nil {
-		return fmt.Errorf("forced shutdown: %s", err)
+		report.Forced = true
+		s.emitShutdownReport(report)
+		return fmt.Errorf("%w: forced shutdown: %s", ErrUncleanShutdown, err)
 	}
 
 	log.Info().Msg("Executing shutdown hooks...")
 	for _, hook := range s.shutdownHooks {
 		if err := hook(); err != nil {
 			log.Error().Msgf("Error during shutdown hook: %s", err)
+			report.HooksFailed++
+		} else {
+			report.HooksSucceeded++
 		}
 	}
 
+	s.emitShutdownReport(report)
 	log.Info().Msg("Server exited gracefully")
 	return nil
 }
 
+// debugBodyLogSize is the number of bytes of the response bodies logged in debug mode, so that
+// large and streamed responses are not buffered.
+const debugBodyLogSize = 2048
+
+// bodyLogWriter keeps the first bytes of the response body and counts the others
 type bodyLogWriter struct {
 	gin.ResponseWriter
-	body *bytes.Buffer
+	head    []byte
+	written int64
 }
 
-func (w bodyLogWriter) Write(b []byte) (int, error) {
-	w.body.Write(b)
+func (w *bodyLogWriter) Write(b []byte) (int, error) {
+	w.capture(b)
 	return w.ResponseWriter.Write(b)
 }
 
+func (w *bodyLogWriter) WriteString(s string) (int, error) {
+	w.capture([]byte(s))
+	return w.ResponseWriter.WriteString(s)
+}
+
+func (w *bodyLogWriter) capture(b []byte) {
+	if room := debugBodyLogSize - len(w.head); room > 0 {
+		w.head = append(w.head, b[:min(room, len(b))]...)
+	}
+	w.written += int64(len(b))
+}
+
 func bodyLogMiddleware(c *gin.Context) {
-	blw := &bodyLogWriter{body: bytes.NewBufferString(""), ResponseWriter: c.Writer}
+	blw := &bodyLogWriter{ResponseWriter: c.Writer}
 	c.Writer = blw
 	c.Next()
 	log.Debug().
 		Str("method", c.Request.Method).
 		Str("path", c.Request.URL.Path).
 		Int("status", c.Writer.Status()).
-		Str("body", blw.body.String()).
+		Str("content_type", c.Writer.Header().Get("Content-Type")).
+		Int64("content_length", blw.written).
+		Bool("truncated", blw.written > int64(len(blw.head))).
+		Str("body", string(blw.head)).
 		Msg("Request processed")
 }
@@ -3,6 +3,12 @@
 package server
 
 import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
 	"time"
 
 	"github.com/animalet/sargantana-go/pkg/config"
@@ -13,6 +19,7 @@ import (
 	. "github.com/onsi/gomega"
 	"github.com/pkg/errors"
 	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
 	"gopkg.in/yaml.v3"
 )
 
@@ -48,6 +55,18 @@ func (c TestConfig) Validate() error {
 	return nil
 }
 
+// OverriddenTestConfig for testing the environment overrides of typed controllers
+type OverriddenTestConfig struct {
+	TLS struct {
+		CAFile string `yaml:"ca_file"`
+	} `yaml:"tls"`
+	ClientSecret string `yaml:"client_secret"`
+}
+
+func (c OverriddenTestConfig) Validate() error {
+	return nil
+}
+
 var _ = Describe("Server", func() {
 	Context("NewServer", func() {
 		It("should create a new server instance", func() {
@@ -71,6 +90,24 @@ var _ = Describe("Server", func() {
 			// We can't directly access the registry to verify, but we can verify via behavior if we had a way to trigger it
 			// For now, just ensuring it doesn't panic is a start.
 		})
+
+		It("should pass the instance name to each controller", func() {
+			var names []string
+			addControllerType("named", func(c config.ModuleRawConfig, ctx ControllerContext) (IController, error) {
+				names = append(names, ctx.Name)
+				return &MockController{}, nil
+			})
+
+			_, errs := configureControllers(SargantanaConfig{ControllerBindings: ControllerBindings{
+				{TypeName: "named", Config: config.ModuleRawConfig{}},
+				{TypeName: "named", Config: config.ModuleRawConfig{}},
+				{TypeName: "named", Name: "custom", Config: config.ModuleRawConfig{}},
+			}}, ControllerContext{}, &loginMiddlewares{middlewares: map[string]gin.HandlerFunc{
+				DefaultAuthenticatorName: NewUnauthorizedAuthenticator().Middleware(),
+			}})
+			Expect(errs).To(BeEmpty())
+			Expect(names).To(Equal([]string{"named", "named-2", "custom"}))
+		})
 	})
 
 	Context("Server Configuration", func() {
@@ -133,8 +170,11 @@ var _ = Describe("Server", func() {
 			})
 
 			// Start server
+			Expect(s.Addresses()).To(BeEmpty())
 			err := s.Start()
 			Expect(err).NotTo(HaveOccurred())
+			Expect(s.Addresses()).To(HaveLen(1))
+			Expect(s.Addresses()[0]).NotTo(HaveSuffix(":0"))
 
 			// Give it a moment to start listening
 			time.Sleep(100 * time.Millisecond)
@@ -165,6 +205,52 @@ var _ = Describe("Server", func() {
 			Expect(err).NotTo(HaveOccurred())
 			Expect(hookCalled).To(BeTrue())
 		})
+
+		It("should execute reload hooks and shut down on request", func() {
+			s := NewServer(cfg)
+			s.SetSessionStore(sessionStore)
+			addControllerType("mock-controller", func(cfg config.ModuleRawConfig, ctx ControllerContext) (IController, error) {
+				return &MockController{}, nil
+			})
+
+			reloaded := make(chan struct{}, 1)
+			stopping := false
+			s.OnReload(func() error {
+				reloaded <- struct{}{}
+				return nil
+			})
+			s.OnStopping(func() {
+				stopping = true
+			})
+			Expect(s.Start()).To(Succeed())
+
+			done := make(chan error, 1)
+			go func() {
+				done <- s.WaitForSignal()
+			}()
+			s.RequestReload()
+			Eventually(reloaded).Should(Receive())
+
+			s.RequestShutdown()
+			Eventually(done).Should(Receive(BeNil()))
+			Expect(stopping).To(BeTrue())
+		})
+
+		It("should return a listen error when the address is in use", func() {
+			first := NewServer(cfg)
+			first.SetSessionStore(sessionStore)
+			Expect(first.Start()).To(Succeed())
+			defer func() {
+				_ = first.Shutdown()
+			}()
+
+			busy := cfg
+			busy.WebServerConfig.Address = first.Addresses()[0]
+			second := NewServer(busy)
+			second.SetSessionStore(sessionStore)
+			err := second.Start()
+			Expect(err).To(MatchError(ErrListen))
+		})
 	})
 
 	Context("SetDebug", func() {
@@ -214,6 +300,34 @@ var _ = Describe("Server", func() {
 			// Clean up
 			s.Shutdown()
 		})
+
+		It("should log the head and the length of the response bodies", func() {
+			var logs bytes.Buffer
+			originalLogger := log.Logger
+			originalLevel := zerolog.GlobalLevel()
+			log.Logger = zerolog.New(&logs)
+			zerolog.SetGlobalLevel(zerolog.DebugLevel)
+			DeferCleanup(func() {
+				log.Logger = originalLogger
+				zerolog.SetGlobalLevel(originalLevel)
+			})
+
+			engine := gin.New()
+			engine.Use(bodyLogMiddleware)
+			engine.GET("/large", func(c *gin.Context) {
+				_, _ = c.Writer.WriteString(strings.Repeat("a", debugBodyLogSize))
+				_, _ = c.Writer.Write([]byte("tail"))
+			})
+			w := httptest.NewRecorder()
+			engine.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/large", nil))
+			Expect(w.Body.Len()).To(Equal(debugBodyLogSize + 4))
+
+			var entry map[string]any
+			Expect(json.Unmarshal(logs.Bytes(), &entry)).To(Succeed())
+			Expect(entry["content_length"]).To(BeEquivalentTo(debugBodyLogSize + 4))
+			Expect(entry["truncated"]).To(BeTrue())
+			Expect(entry["body"]).To(Equal(strings.Repeat("a", debugBodyLogSize)))
+		})
 	})
 
 	Context("Controller Configuration", func() {
@@ -355,6 +469,22 @@ var _ = Describe("Server", func() {
 		})
 	})
 
+	Context("createSessionStore", func() {
+		It("should use the cookie store by default", func() {
+			s := NewServer(SargantanaConfig{WebServerConfig: WebServerConfig{SessionSecret: "secret"}})
+			store, err := s.createSessionStore(false)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(fmt.Sprintf("%T", store)).To(ContainSubstring("chunkedCookieStore"))
+		})
+
+		It("should use the embedded store with server side sessions", func() {
+			s := NewServer(SargantanaConfig{WebServerConfig: WebServerConfig{SessionSecret: "secret", ServerSideSessions: true}})
+			store, err := s.createSessionStore(false)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(fmt.Sprintf("%T", store)).To(ContainSubstring("memoryStore"))
+		})
+	})
+
 	Context("AddControllerType", func() {
 		It("should add a controller type", func() {
 			factory := func(configData config.ModuleRawConfig, context ControllerContext) (IController, error) {
@@ -450,6 +580,67 @@ var _ = Describe("Server", func() {
 			})
 		})
 
+		Context("WithEnvOverrides", func() {
+			var bindings ControllerBindings
+
+			BeforeEach(func() {
+				bindings = ControllerBindings{
+					{Name: "auth", TypeName: "auth", Config: config.ModuleRawConfig("providers:\n  google:\n    key: from-file\n    client_secret: file-secret\n")},
+					{TypeName: "load_balancer", Config: config.ModuleRawConfig("endpoints: [a]\n")},
+					{TypeName: "load_balancer", Config: config.ModuleRawConfig("endpoints: [b]\n")},
+				}
+			})
+
+			It("should return the bindings unchanged without variables", func() {
+				overridden, err := bindings.WithEnvOverrides([]string{"PATH=/usr/bin"})
+				Expect(err).NotTo(HaveOccurred())
+				Expect(overridden).To(Equal(bindings))
+			})
+
+			It("should override values by instance name and path", func() {
+				overridden, err := bindings.WithEnvOverrides([]string{
+					"SARGANTANA_CONTROLLERS_auth_PROVIDERS_google_KEY=from-env",
+					"SARGANTANA_CONTROLLERS_auth_PROVIDERS_google_CLIENT_SECRET=env-secret",
+					"SARGANTANA_CONTROLLERS_load_balancer_2_ENDPOINTS_0=c",
+					"SARGANTANA_CONTROLLERS_load_balancer_TIMEOUT=30",
+				})
+				Expect(err).NotTo(HaveOccurred())
+				Expect(string(overridden[0].Config)).To(ContainSubstring("key: from-env"))
+				Expect(string(overridden[0].Config)).To(ContainSubstring("client_secret: env-secret"))
+				Expect(string(overridden[1].Config)).To(ContainSubstring("timeout: 30"))
+				Expect(string(overridden[2].Config)).To(ContainSubstring("- c"))
+				Expect(string(bindings[0].Config)).To(ContainSubstring("key: from-file"))
+			})
+
+			It("should resolve unset values against the configuration type of the controller", func() {
+				RegisterController("overridden-controller", func(*OverriddenTestConfig, ControllerContext) (IController, error) {
+					return &MockController{}, nil
+				})
+				defer delete(controllerRegistry, "overridden-controller")
+				defer delete(controllerConfigTypes, "overridden-controller")
+
+				overridden, err := ControllerBindings{{TypeName: "overridden-controller"}}.WithEnvOverrides([]string{
+					"SARGANTANA_CONTROLLERS_overridden_controller_TLS_CA_FILE=/ca.pem",
+					"SARGANTANA_CONTROLLERS_overridden_controller_CLIENT_SECRET=env-secret",
+				})
+				Expect(err).NotTo(HaveOccurred())
+				cfg, err := config.Unmarshal[OverriddenTestConfig](overridden[0].Config)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(cfg.TLS.CAFile).To(Equal("/ca.pem"))
+				Expect(cfg.ClientSecret).To(Equal("env-secret"))
+			})
+
+			It("should fail for variables naming no controller", func() {
+				_, err := bindings.WithEnvOverrides([]string{"SARGANTANA_CONTROLLERS_static_STATUS=200"})
+				Expect(err).To(MatchError(ContainSubstring("does not name a controller")))
+			})
+
+			It("should fail for variables naming no value", func() {
+				_, err := bindings.WithEnvOverrides([]string{"SARGANTANA_CONTROLLERS_auth=x"})
+				Expect(err).To(MatchError(ContainSubstring("does not name a value")))
+			})
+		})
+
 		Context("RegisterController", func() {
 			var (
 				sessionStore sessions.Store
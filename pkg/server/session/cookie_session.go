@@ -4,14 +4,48 @@
 package session
 
 import (
+	"fmt"
 	"net/http"
+	"strconv"
+	"strings"
 
 	"github.com/gin-contrib/sessions"
-	"github.com/gin-contrib/sessions/cookie"
+	"github.com/gorilla/securecookie"
+	gsessions "github.com/gorilla/sessions"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// cookieChunkSize is the maximum length of the encoded value stored in a single cookie.
+	// It leaves room for the cookie name and attributes within the 4096 bytes browsers accept.
+	cookieChunkSize = 3800
+
+	// DefaultCookieMaxChunks is the default maximum number of cookies a session can be split into.
+	DefaultCookieMaxChunks = 4
+)
+
+// ErrSessionTooLarge is returned when saving a session whose encoded size exceeds the cookie limit.
+// Store large payloads server-side (Redis, Postgres, ...) or trim the session values.
+var ErrSessionTooLarge = errors.New("session is too large to be stored in cookies")
+
+var (
+	cookieChunkedSaves = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "sargantana_session_cookie_chunked_total",
+		Help: "Number of cookie sessions saved across more than one cookie.",
+	})
+	cookieOversizedSaves = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "sargantana_session_cookie_oversized_total",
+		Help: "Number of cookie sessions that could not be saved because they exceeded the size limit.",
+	})
 )
 
 // NewCookieStore creates a new cookie-based session store with secure default settings.
 // The store is configured with appropriate security settings based on the deployment mode.
+// Sessions larger than a single cookie are transparently split across up to
+// DefaultCookieMaxChunks cookies; larger sessions fail to save with ErrSessionTooLarge.
 //
 // Parameters:
 //   - isReleaseMode: Whether the application is running in production mode (affects cookie security)
@@ -24,7 +58,19 @@ import (
 //   - HttpOnly: true (prevents JavaScript access to cookies)
 //   - SameSite: Lax mode (balanced security and functionality)
 func NewCookieStore(secure bool, secret []byte) sessions.Store {
-	store := cookie.NewStore(secret)
+	return NewChunkedCookieStore(secure, secret, DefaultCookieMaxChunks)
+}
+
+// NewChunkedCookieStore creates a cookie session store like NewCookieStore with a custom
+// maximum number of cookies per session.
+func NewChunkedCookieStore(secure bool, secret []byte, maxChunks int) sessions.Store {
+	if maxChunks < 1 {
+		maxChunks = 1
+	}
+	store := &chunkedCookieStore{
+		codecs:    securecookie.CodecsFromPairs(secret),
+		maxChunks: maxChunks,
+	}
 
 	store.Options(sessions.Options{
 		Path:     "/",
@@ -36,3 +82,144 @@ func NewCookieStore(secure bool, secret []byte) sessions.Store {
 
 	return store
 }
+
+// chunkedCookieStore is a signed cookie store that splits encoded sessions across several cookies
+// named <name>, <name>_1, <name>_2, ... When split, the first cookie is prefixed with the
+// number of chunks ("<n>.").
+type chunkedCookieStore struct {
+	codecs    []securecookie.Codec
+	options   *gsessions.Options
+	maxChunks int
+}
+
+func (s *chunkedCookieStore) Options(options sessions.Options) {
+	s.options = options.ToGorillaOptions()
+	for _, codec := range s.codecs {
+		if sc, ok := codec.(*securecookie.SecureCookie); ok {
+			// The size limit is enforced per chunk by the store
+			sc.MaxLength(0)
+			if options.MaxAge > 0 {
+				sc.MaxAge(options.MaxAge)
+			}
+		}
+	}
+}
+
+// Get returns a cached session for the request, see gorilla/sessions.Store.
+func (s *chunkedCookieStore) Get(r *http.Request, name string) (*gsessions.Session, error) {
+	return gsessions.GetRegistry(r).Get(s, name)
+}
+
+// New returns a session decoded from the request cookies, or an empty one.
+func (s *chunkedCookieStore) New(r *http.Request, name string) (*gsessions.Session, error) {
+	session := gsessions.NewSession(s, name)
+	options := *s.options
+	session.Options = &options
+	session.IsNew = true
+
+	encoded, _, ok := readChunks(r, name, s.maxChunks)
+	if !ok {
+		return session, nil
+	}
+	if err := securecookie.DecodeMulti(name, encoded, &session.Values, s.codecs...); err != nil {
+		return session, err
+	}
+	session.IsNew = false
+	return session, nil
+}
+
+// Save writes the session to as many cookies as needed and removes stale chunks.
+func (s *chunkedCookieStore) Save(r *http.Request, w http.ResponseWriter, session *gsessions.Session) error {
+	name := session.Name()
+	_, previousChunks, _ := readChunks(r, name, s.maxChunks)
+	// The count comes from the client, only the chunks the store can write are expired
+	previousChunks = min(previousChunks, s.maxChunks)
+
+	if session.Options.MaxAge < 0 {
+		deleteChunks(w, name, session.Options, 0, max(previousChunks, 1))
+		return nil
+	}
+
+	encoded, err := securecookie.EncodeMulti(name, session.Values, s.codecs...)
+	if err != nil {
+		return err
+	}
+
+	chunks := splitChunks(encoded)
+	if len(chunks) > s.maxChunks {
+		cookieOversizedSaves.Inc()
+		log.Warn().
+			Str("session", name).
+			Int("size", len(encoded)).
+			Int("max_size", s.maxChunks*cookieChunkSize).
+			Msg("Session exceeds the cookie size limit and was not saved")
+		return errors.Wrapf(ErrSessionTooLarge, "session %q is %d bytes encoded, limit is %d", name, len(encoded), s.maxChunks*cookieChunkSize)
+	}
+	if len(chunks) > 1 {
+		cookieChunkedSaves.Inc()
+		chunks[0] = strconv.Itoa(len(chunks)) + "." + chunks[0]
+	}
+
+	for i, chunk := range chunks {
+		http.SetCookie(w, gsessions.NewCookie(chunkName(name, i), chunk, session.Options))
+	}
+	deleteChunks(w, name, session.Options, len(chunks), previousChunks)
+	return nil
+}
+
+// readChunks reassembles the encoded session from the request cookies and returns the
+// number of chunks it was stored in. Sessions claiming more than maxChunks chunks are rejected.
+func readChunks(r *http.Request, name string, maxChunks int) (string, int, bool) {
+	first, err := r.Cookie(name)
+	if err != nil {
+		return "", 0, false
+	}
+
+	countPrefix, rest, chunked := strings.Cut(first.Value, ".")
+	if !chunked {
+		return first.Value, 1, true
+	}
+	count, err := strconv.Atoi(countPrefix)
+	if err != nil || count < 2 {
+		return "", 1, false
+	}
+	if count > maxChunks {
+		return "", maxChunks, false
+	}
+
+	var builder strings.Builder
+	builder.WriteString(rest)
+	for i := 1; i < count; i++ {
+		chunk, err := r.Cookie(chunkName(name, i))
+		if err != nil {
+			return "", count, false
+		}
+		builder.WriteString(chunk.Value)
+	}
+	return builder.String(), count, true
+}
+
+func splitChunks(encoded string) []string {
+	chunks := make([]string, 0, len(encoded)/cookieChunkSize+1)
+	for len(encoded) > cookieChunkSize {
+		chunks = append(chunks, encoded[:cookieChunkSize])
+		encoded = encoded[cookieChunkSize:]
+	}
+	return append(chunks, encoded)
+}
+
+// deleteChunks expires the cookies for chunks in [from, to).
+func deleteChunks(w http.ResponseWriter, name string, options *gsessions.Options, from, to int) {
+	expired := *options
+	expired.MaxAge = -1
+	for i := from; i < to; i++ {
+		http.SetCookie(w, gsessions.NewCookie(chunkName(name, i), "", &expired))
+	}
+}
+
+func chunkName(name string, index int) string {
+	if index == 0 {
+		return name
+	}
+	return fmt.Sprintf("%s_%d", name, index)
+}
@@ -3,6 +3,10 @@
 package session
 
 import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
 	"github.com/gin-contrib/sessions/cookie"
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
@@ -27,4 +31,133 @@ var _ = Describe("Cookie Session Store", func() {
 			Expect(store).NotTo(BeNil())
 		})
 	})
+
+	Context("chunking", func() {
+		var store *chunkedCookieStore
+
+		BeforeEach(func() {
+			store = NewChunkedCookieStore(false, []byte("secret-key"), 3).(*chunkedCookieStore)
+		})
+
+		// save stores the value in a new session and returns the cookies set in the response
+		save := func(request *http.Request, value string) ([]*http.Cookie, error) {
+			session, err := store.New(request, "session")
+			Expect(err).NotTo(HaveOccurred())
+			session.Values["data"] = value
+			recorder := httptest.NewRecorder()
+			err = store.Save(request, recorder, session)
+			return recorder.Result().Cookies(), err
+		}
+
+		// load builds a request carrying the live cookies and decodes the session from it
+		load := func(cookies []*http.Cookie) (interface{}, error) {
+			request := httptest.NewRequest(http.MethodGet, "/", nil)
+			for _, c := range cookies {
+				if c.MaxAge >= 0 {
+					request.AddCookie(c)
+				}
+			}
+			session, err := store.New(request, "session")
+			return session.Values["data"], err
+		}
+
+		It("should store small sessions in a single cookie", func() {
+			cookies, err := save(httptest.NewRequest(http.MethodGet, "/", nil), "small")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(cookies).To(HaveLen(1))
+			Expect(cookies[0].Name).To(Equal("session"))
+			Expect(cookies[0].HttpOnly).To(BeTrue())
+
+			value, err := load(cookies)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(value).To(Equal("small"))
+		})
+
+		It("should split large sessions across several cookies", func() {
+			large := strings.Repeat("x", cookieChunkSize)
+			cookies, err := save(httptest.NewRequest(http.MethodGet, "/", nil), large)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(len(cookies)).To(BeNumerically(">", 1))
+			for _, c := range cookies {
+				Expect(len(c.String())).To(BeNumerically("<", 4096))
+			}
+
+			value, err := load(cookies)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(value).To(Equal(large))
+		})
+
+		It("should expire stale chunks when the session shrinks", func() {
+			cookies, err := save(httptest.NewRequest(http.MethodGet, "/", nil), strings.Repeat("x", cookieChunkSize))
+			Expect(err).NotTo(HaveOccurred())
+			request := httptest.NewRequest(http.MethodGet, "/", nil)
+			for _, c := range cookies {
+				request.AddCookie(c)
+			}
+
+			cookies, err = save(request, "small")
+			Expect(err).NotTo(HaveOccurred())
+			var expired []string
+			for _, c := range cookies {
+				if c.MaxAge < 0 {
+					expired = append(expired, c.Name)
+				}
+			}
+			Expect(expired).NotTo(BeEmpty())
+			Expect(expired).NotTo(ContainElement("session"))
+
+			value, err := load(cookies)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(value).To(Equal("small"))
+		})
+
+		It("should fail explicitly when the session exceeds the limit", func() {
+			cookies, err := save(httptest.NewRequest(http.MethodGet, "/", nil), strings.Repeat("x", 4*cookieChunkSize))
+			Expect(err).To(MatchError(ErrSessionTooLarge))
+			Expect(cookies).To(BeEmpty())
+		})
+
+		It("should reject sessions with missing or tampered chunks", func() {
+			cookies, err := save(httptest.NewRequest(http.MethodGet, "/", nil), strings.Repeat("x", cookieChunkSize))
+			Expect(err).NotTo(HaveOccurred())
+
+			_, err = load(cookies[:1])
+			Expect(err).NotTo(HaveOccurred()) // missing chunks result in a new session
+
+			cookies[1].Value = strings.Repeat("A", len(cookies[1].Value))
+			_, err = load(cookies)
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("should ignore chunk counts above the limit", func() {
+			request := httptest.NewRequest(http.MethodGet, "/", nil)
+			request.AddCookie(&http.Cookie{Name: "session", Value: "1000000.x"})
+			value, err := load([]*http.Cookie{{Name: "session", Value: "1000000.x"}})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(value).To(BeNil())
+
+			cookies, err := save(request, "small")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(len(cookies)).To(BeNumerically("<=", 3))
+		})
+
+		It("should expire every chunk when the session is deleted", func() {
+			cookies, err := save(httptest.NewRequest(http.MethodGet, "/", nil), strings.Repeat("x", cookieChunkSize))
+			Expect(err).NotTo(HaveOccurred())
+			request := httptest.NewRequest(http.MethodGet, "/", nil)
+			for _, c := range cookies {
+				request.AddCookie(c)
+			}
+
+			session, err := store.New(request, "session")
+			Expect(err).NotTo(HaveOccurred())
+			session.Options.MaxAge = -1
+			recorder := httptest.NewRecorder()
+			Expect(store.Save(request, recorder, session)).To(Succeed())
+			Expect(recorder.Result().Cookies()).To(HaveLen(len(cookies)))
+			for _, c := range recorder.Result().Cookies() {
+				Expect(c.MaxAge).To(BeNumerically("<", 0))
+			}
+		})
+	})
 })
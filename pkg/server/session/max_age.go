@@ -0,0 +1,65 @@
+package session
+
+import (
+	"net/http"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/gorilla/securecookie"
+	gsessions "github.com/gorilla/sessions"
+)
+
+// WithMaxAges returns the store with the max age of some sessions, in seconds by session name,
+// overriding the max age of the store, e.g. for a long-lived preferences session next to a
+// short-lived authentication session. The cookie and memory stores accept the signatures of the
+// sessions up to the longest max age; other stores may expire their sessions earlier.
+func WithMaxAges(store sessions.Store, maxAges map[string]int) sessions.Store {
+	longest := 0
+	for _, maxAge := range maxAges {
+		longest = max(longest, maxAge)
+	}
+	if extendable, ok := store.(interface{ extendMaxAge(int) }); ok {
+		extendable.extendMaxAge(longest)
+	}
+	return &maxAgeStore{Store: store, maxAges: maxAges}
+}
+
+type maxAgeStore struct {
+	sessions.Store
+	maxAges map[string]int
+}
+
+// Get returns a cached session for the request, see gorilla/sessions.Store.
+func (s *maxAgeStore) Get(r *http.Request, name string) (*gsessions.Session, error) {
+	return gsessions.GetRegistry(r).Get(s, name)
+}
+
+// New returns the session of the store, with the max age of its name if any.
+func (s *maxAgeStore) New(r *http.Request, name string) (*gsessions.Session, error) {
+	session, err := s.Store.New(r, name)
+	if maxAge, ok := s.maxAges[name]; ok && session != nil && session.Options != nil {
+		options := *session.Options
+		options.MaxAge = maxAge
+		session.Options = &options
+	}
+	return session, err
+}
+
+// extendCodecs accepts signatures up to maxAge seconds old, if longer than the max age of the store
+func extendCodecs(codecs []securecookie.Codec, options *gsessions.Options, maxAge int) {
+	if maxAge <= options.MaxAge {
+		return
+	}
+	for _, codec := range codecs {
+		if sc, ok := codec.(*securecookie.SecureCookie); ok {
+			sc.MaxAge(maxAge)
+		}
+	}
+}
+
+func (s *chunkedCookieStore) extendMaxAge(maxAge int) {
+	extendCodecs(s.codecs, s.options, maxAge)
+}
+
+func (s *memoryStore) extendMaxAge(maxAge int) {
+	extendCodecs(s.codecs, s.options, maxAge)
+}
@@ -0,0 +1,173 @@
+package session
+
+import (
+	"bytes"
+	"encoding/base32"
+	"encoding/gob"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/gorilla/securecookie"
+	gsessions "github.com/gorilla/sessions"
+	"github.com/pkg/errors"
+)
+
+// memorySweepInterval is the minimum time between sweeps of expired sessions.
+const memorySweepInterval = time.Minute
+
+// NewMemorySessionStore creates an embedded, in-process session store. Only a signed session
+// reference is sent to the client, while the session values (e.g. OAuth tokens and raw user data)
+// never leave the server. It is intended for single-instance deployments without an external store:
+// sessions are lost on restart and are not shared between instances.
+//
+// Parameters:
+//   - secure: Whether cookies should only be sent over HTTPS
+//   - secret: Secret key used to sign the session reference cookie
+//
+// The store uses the same cookie settings as NewCookieStore.
+func NewMemorySessionStore(secure bool, secret []byte) sessions.Store {
+	store := &memoryStore{
+		codecs:  securecookie.CodecsFromPairs(secret),
+		entries: make(map[string]memoryEntry),
+		now:     time.Now,
+	}
+
+	store.Options(sessions.Options{
+		Path:     "/",
+		MaxAge:   86400, // 24 hours
+		Secure:   secure,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	return store
+}
+
+type memoryEntry struct {
+	data    []byte
+	expires time.Time
+}
+
+type memoryStore struct {
+	codecs  []securecookie.Codec
+	options *gsessions.Options
+
+	mu        sync.Mutex
+	entries   map[string]memoryEntry
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+func (s *memoryStore) Options(options sessions.Options) {
+	s.options = options.ToGorillaOptions()
+	for _, codec := range s.codecs {
+		if sc, ok := codec.(*securecookie.SecureCookie); ok && options.MaxAge > 0 {
+			sc.MaxAge(options.MaxAge)
+		}
+	}
+}
+
+// Get returns a cached session for the request, see gorilla/sessions.Store.
+func (s *memoryStore) Get(r *http.Request, name string) (*gsessions.Session, error) {
+	return gsessions.GetRegistry(r).Get(s, name)
+}
+
+// New returns the session referenced by the request cookie, or an empty one.
+func (s *memoryStore) New(r *http.Request, name string) (*gsessions.Session, error) {
+	session := gsessions.NewSession(s, name)
+	options := *s.options
+	session.Options = &options
+	session.IsNew = true
+
+	cookie, err := r.Cookie(name)
+	if err != nil {
+		return session, nil
+	}
+	var id string
+	if err := securecookie.DecodeMulti(name, cookie.Value, &id, s.codecs...); err != nil {
+		return session, err
+	}
+
+	s.mu.Lock()
+	entry, ok := s.entries[id]
+	s.mu.Unlock()
+	if !ok || s.now().After(entry.expires) {
+		return session, nil
+	}
+
+	if err := gob.NewDecoder(bytes.NewReader(entry.data)).Decode(&session.Values); err != nil {
+		return session, errors.Wrap(err, "failed to decode session values")
+	}
+	session.ID = id
+	session.IsNew = false
+	return session, nil
+}
+
+// Save stores the session values in memory and sends the signed session reference to the client.
+func (s *memoryStore) Save(_ *http.Request, w http.ResponseWriter, session *gsessions.Session) error {
+	if session.Options.MaxAge < 0 {
+		s.mu.Lock()
+		delete(s.entries, session.ID)
+		s.mu.Unlock()
+		http.SetCookie(w, gsessions.NewCookie(session.Name(), "", session.Options))
+		return nil
+	}
+
+	if session.ID == "" {
+		session.ID = strings.TrimRight(base32.StdEncoding.EncodeToString(securecookie.GenerateRandomKey(32)), "=")
+	}
+
+	var data bytes.Buffer
+	if err := gob.NewEncoder(&data).Encode(session.Values); err != nil {
+		return errors.Wrap(err, "failed to encode session values")
+	}
+
+	encodedID, err := securecookie.EncodeMulti(session.Name(), session.ID, s.codecs...)
+	if err != nil {
+		return err
+	}
+
+	maxAge := session.Options.MaxAge
+	if maxAge == 0 {
+		// Browser session cookies are kept for the default duration
+		maxAge = s.options.MaxAge
+	}
+
+	s.mu.Lock()
+	now := s.now()
+	s.entries[session.ID] = memoryEntry{data: data.Bytes(), expires: now.Add(time.Duration(maxAge) * time.Second)}
+	if now.Sub(s.lastSweep) > memorySweepInterval {
+		s.sweep(now)
+	}
+	s.mu.Unlock()
+
+	http.SetCookie(w, gsessions.NewCookie(session.Name(), encodedID, session.Options))
+	return nil
+}
+
+// ActiveSessions counts the sessions that have not expired.
+func (s *memoryStore) ActiveSessions() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.now()
+	active := 0
+	for _, entry := range s.entries {
+		if !now.After(entry.expires) {
+			active++
+		}
+	}
+	return active
+}
+
+// sweep removes expired sessions. Must be called with the lock held.
+func (s *memoryStore) sweep(now time.Time) {
+	for id, entry := range s.entries {
+		if now.After(entry.expires) {
+			delete(s.entries, id)
+		}
+	}
+	s.lastSweep = now
+}
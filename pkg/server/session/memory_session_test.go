@@ -0,0 +1,112 @@
+//go:build unit
+
+package session
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Memory Session Store", func() {
+	var (
+		store *memoryStore
+		now   time.Time
+	)
+
+	BeforeEach(func() {
+		store = NewMemorySessionStore(false, []byte("secret-key")).(*memoryStore)
+		now = time.Now()
+		store.now = func() time.Time { return now }
+	})
+
+	save := func(request *http.Request, value string) *http.Cookie {
+		session, err := store.New(request, "session")
+		Expect(err).NotTo(HaveOccurred())
+		session.Values["token"] = value
+		recorder := httptest.NewRecorder()
+		Expect(store.Save(request, recorder, session)).To(Succeed())
+		cookies := recorder.Result().Cookies()
+		Expect(cookies).To(HaveLen(1))
+		return cookies[0]
+	}
+
+	load := func(cookie *http.Cookie) (interface{}, bool) {
+		request := httptest.NewRequest(http.MethodGet, "/", nil)
+		request.AddCookie(cookie)
+		session, err := store.New(request, "session")
+		Expect(err).NotTo(HaveOccurred())
+		return session.Values["token"], session.IsNew
+	}
+
+	It("should only send a session reference to the client", func() {
+		secret := strings.Repeat("provider-token-", 1000)
+		cookie := save(httptest.NewRequest(http.MethodGet, "/", nil), secret)
+		Expect(len(cookie.Value)).To(BeNumerically("<", 200))
+		Expect(cookie.HttpOnly).To(BeTrue())
+
+		value, isNew := load(cookie)
+		Expect(isNew).To(BeFalse())
+		Expect(value).To(Equal(secret))
+	})
+
+	It("should keep the session ID when the session is updated", func() {
+		cookie := save(httptest.NewRequest(http.MethodGet, "/", nil), "first")
+		request := httptest.NewRequest(http.MethodGet, "/", nil)
+		request.AddCookie(cookie)
+		updated := save(request, "second")
+
+		value, _ := load(updated)
+		Expect(value).To(Equal("second"))
+		Expect(store.entries).To(HaveLen(1))
+	})
+
+	It("should expire sessions after max age", func() {
+		cookie := save(httptest.NewRequest(http.MethodGet, "/", nil), "token")
+		now = now.Add(25 * time.Hour)
+
+		value, isNew := load(cookie)
+		Expect(isNew).To(BeTrue())
+		Expect(value).To(BeNil())
+
+		save(httptest.NewRequest(http.MethodGet, "/", nil), "other")
+		Expect(store.entries).To(HaveLen(1))
+	})
+
+	It("should count the active sessions", func() {
+		Expect(store.ActiveSessions()).To(BeZero())
+		save(httptest.NewRequest(http.MethodGet, "/", nil), "first")
+		save(httptest.NewRequest(http.MethodGet, "/", nil), "second")
+		Expect(store.ActiveSessions()).To(Equal(2))
+
+		now = now.Add(25 * time.Hour)
+		Expect(store.ActiveSessions()).To(BeZero())
+	})
+
+	It("should delete sessions with negative max age", func() {
+		cookie := save(httptest.NewRequest(http.MethodGet, "/", nil), "token")
+		request := httptest.NewRequest(http.MethodGet, "/", nil)
+		request.AddCookie(cookie)
+		session, err := store.New(request, "session")
+		Expect(err).NotTo(HaveOccurred())
+		session.Options.MaxAge = -1
+		Expect(store.Save(request, httptest.NewRecorder(), session)).To(Succeed())
+
+		Expect(store.entries).To(BeEmpty())
+		_, isNew := load(cookie)
+		Expect(isNew).To(BeTrue())
+	})
+
+	It("should reject tampered session references", func() {
+		cookie := save(httptest.NewRequest(http.MethodGet, "/", nil), "token")
+		cookie.Value = "tampered" + cookie.Value
+		request := httptest.NewRequest(http.MethodGet, "/", nil)
+		request.AddCookie(cookie)
+		_, err := store.New(request, "session")
+		Expect(err).To(HaveOccurred())
+	})
+})
@@ -0,0 +1,145 @@
+package session
+
+import (
+	"net/http"
+	"path"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/gin-contrib/sessions"
+	gsessions "github.com/gorilla/sessions"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	storeOperations = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "sargantana_session_store_operations_total",
+		Help: "Number of session loads and saves, by store, operation and result.",
+	}, []string{"store", "operation", "result"})
+	storeOperationDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "sargantana_session_store_operation_duration_seconds",
+		Help:    "Duration of the session loads and saves, by store and operation.",
+		Buckets: prometheus.ExponentialBuckets(0.0005, 4, 8),
+	}, []string{"store", "operation"})
+	activeSessionsDesc = prometheus.NewDesc("sargantana_sessions_active",
+		"Number of sessions that have not expired, for the stores counting them.", []string{"store"}, nil)
+)
+
+// activeSessions exports the number of sessions of the counting stores
+var activeSessions = &activeSessionsCollector{stores: make(map[string]func() int)}
+
+func init() {
+	prometheus.MustRegister(activeSessions)
+}
+
+type activeSessionsCollector struct {
+	mu     sync.Mutex
+	stores map[string]func() int
+}
+
+func (c *activeSessionsCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- activeSessionsDesc
+}
+
+func (c *activeSessionsCollector) Collect(ch chan<- prometheus.Metric) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for store, count := range c.stores {
+		ch <- prometheus.MustNewConstMetric(activeSessionsDesc, prometheus.GaugeValue, float64(count()), store)
+	}
+}
+
+// WithMetrics returns the store with its loads and saves exported as Prometheus metrics, labeled
+// with the type of the store, e.g. "cookie", "memory" or "redis". The stores counting their
+// sessions, such as the memory store, also export the number of active sessions.
+func WithMetrics(store sessions.Store) sessions.Store {
+	instrumented := &metricsStore{Store: store, name: StoreType(store)}
+	if counter, ok := store.(interface{ ActiveSessions() int }); ok {
+		activeSessions.mu.Lock()
+		activeSessions.stores[instrumented.name] = counter.ActiveSessions
+		activeSessions.mu.Unlock()
+		return &countingMetricsStore{metricsStore: instrumented, counter: counter.ActiveSessions}
+	}
+	return instrumented
+}
+
+// StoreType names the type of the store: cookie, memory, or the name of the package of the other
+// stores, e.g. redis, postgres or memcached.
+func StoreType(store sessions.Store) string {
+	switch s := store.(type) {
+	case *chunkedCookieStore:
+		return "cookie"
+	case *memoryStore:
+		return "memory"
+	case *maxAgeStore:
+		return StoreType(s.Store)
+	case *metricsStore:
+		return s.name
+	case *countingMetricsStore:
+		return s.name
+	}
+	t := reflect.TypeOf(store)
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if name := path.Base(t.PkgPath()); name != "mongodriver" {
+		return name
+	}
+	return "mongodb"
+}
+
+type metricsStore struct {
+	sessions.Store
+	name string
+}
+
+// Get returns a cached session for the request, loaded with New, see gorilla/sessions.Store.
+func (s *metricsStore) Get(r *http.Request, name string) (*gsessions.Session, error) {
+	return gsessions.GetRegistry(r).Get(s, name)
+}
+
+// New loads the session of the request.
+func (s *metricsStore) New(r *http.Request, name string) (*gsessions.Session, error) {
+	start := time.Now()
+	session, err := s.Store.New(r, name)
+	s.observe("load", start, err)
+	return session, err
+}
+
+// Save saves the session in the response.
+func (s *metricsStore) Save(r *http.Request, w http.ResponseWriter, session *gsessions.Session) error {
+	start := time.Now()
+	err := s.Store.Save(r, w, session)
+	s.observe("save", start, err)
+	return err
+}
+
+// extendMaxAge forwards the longest max age of the sessions to the instrumented store, see
+// WithMaxAges.
+func (s *metricsStore) extendMaxAge(maxAge int) {
+	if extendable, ok := s.Store.(interface{ extendMaxAge(int) }); ok {
+		extendable.extendMaxAge(maxAge)
+	}
+}
+
+func (s *metricsStore) observe(operation string, start time.Time, err error) {
+	result := "ok"
+	if err != nil {
+		result = "error"
+	}
+	storeOperations.WithLabelValues(s.name, operation, result).Inc()
+	storeOperationDuration.WithLabelValues(s.name, operation).Observe(time.Since(start).Seconds())
+}
+
+// countingMetricsStore keeps the session count of the instrumented store
+type countingMetricsStore struct {
+	*metricsStore
+	counter func() int
+}
+
+// ActiveSessions counts the sessions of the instrumented store.
+func (s *countingMetricsStore) ActiveSessions() int {
+	return s.counter()
+}
@@ -0,0 +1,59 @@
+//go:build unit
+
+package session
+
+import (
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/gin-contrib/sessions/cookie"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+var _ = Describe("Session store metrics", func() {
+	It("should name the store types", func() {
+		Expect(StoreType(NewCookieStore(false, []byte("secret")))).To(Equal("cookie"))
+		Expect(StoreType(NewMemorySessionStore(false, []byte("secret")))).To(Equal("memory"))
+		Expect(StoreType(cookie.NewStore([]byte("secret")))).To(Equal("cookie"))
+		Expect(StoreType(WithMetrics(NewMemorySessionStore(false, []byte("secret"))))).To(Equal("memory"))
+	})
+
+	It("should count the loads and saves of the store", func() {
+		store := WithMetrics(NewCookieStore(false, []byte("secret")))
+		_, counts := store.(interface{ ActiveSessions() int })
+		Expect(counts).To(BeFalse())
+		loads := storeOperations.WithLabelValues("cookie", "load", "ok")
+		saves := storeOperations.WithLabelValues("cookie", "save", "ok")
+		loadsBefore, savesBefore := testutil.ToFloat64(loads), testutil.ToFloat64(saves)
+
+		request := httptest.NewRequest(http.MethodGet, "/", nil)
+		session, err := store.Get(request, "session")
+		Expect(err).NotTo(HaveOccurred())
+		// Sessions are loaded once per request
+		_, err = store.Get(request, "session")
+		Expect(err).NotTo(HaveOccurred())
+		session.Values["user"] = "alice"
+		Expect(store.Save(request, httptest.NewRecorder(), session)).To(Succeed())
+
+		Expect(testutil.ToFloat64(loads) - loadsBefore).To(Equal(1.0))
+		Expect(testutil.ToFloat64(saves) - savesBefore).To(Equal(1.0))
+	})
+
+	It("should export the active sessions of the counting stores", func() {
+		memory := NewMemorySessionStore(false, []byte("secret"))
+		store := WithMetrics(memory)
+		counter, counts := store.(interface{ ActiveSessions() int })
+		Expect(counts).To(BeTrue())
+
+		request := httptest.NewRequest(http.MethodGet, "/", nil)
+		session, err := store.New(request, "session")
+		Expect(err).NotTo(HaveOccurred())
+		session.Values["user"] = "alice"
+		Expect(store.Save(request, httptest.NewRecorder(), session)).To(Succeed())
+
+		Expect(counter.ActiveSessions()).To(Equal(1))
+		Expect(testutil.CollectAndCount(activeSessions, "sargantana_sessions_active")).To(BeNumerically(">=", 1))
+	})
+})
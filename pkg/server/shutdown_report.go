@@ -0,0 +1,156 @@
+package server
+
+import (
+	"encoding/json"
+	"os"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+// ShutdownReason tells why the server shut down.
+type ShutdownReason string
+
+const (
+	// ShutdownSignal is a shutdown signal, such as SIGTERM.
+	ShutdownSignal ShutdownReason = "signal"
+	// ShutdownRequested is a call to Shutdown or RequestShutdown, e.g. by a service manager.
+	ShutdownRequested ShutdownReason = "requested"
+	// ShutdownFatalError is a fatal error, such as a failure of the listener.
+	ShutdownFatalError ShutdownReason = "fatal_error"
+)
+
+var (
+	// ErrFatal is the cause of the error returned by WaitForSignal when the server shut down on a
+	// fatal error.
+	ErrFatal = errors.New("fatal error")
+	// ErrUncleanShutdown is the cause of the errors telling that the connections were not drained
+	// in time, or that shutdown hooks failed.
+	ErrUncleanShutdown = errors.New("unclean shutdown")
+)
+
+// ShutdownReport summarizes the run of the server. It is logged when the server shuts down, and
+// written to the shutdown_report_file of the configuration for post-mortem tooling.
+type ShutdownReport struct {
+	Reason ShutdownReason `json:"reason"`
+	// Signal is the received signal, for the signal reason.
+	Signal string `json:"signal,omitempty"`
+	// Error is the fatal error, for the fatal_error reason.
+	Error     string    `json:"error,omitempty"`
+	StartedAt time.Time `json:"started_at,omitzero"`
+	StoppedAt time.Time `json:"stopped_at"`
+	// RequestsServed counts the requests answered since the start.
+	RequestsServed int64 `json:"requests_served"`
+	// ActiveSessions counts the sessions alive at exit. It is only known with the embedded
+	// server-side session store.
+	ActiveSessions *int `json:"active_sessions,omitempty"`
+	HooksSucceeded int  `json:"hooks_succeeded"`
+	HooksFailed    int  `json:"hooks_failed"`
+	// Forced tells that connections were closed before their requests completed.
+	Forced bool `json:"forced,omitempty"`
+}
+
+// Uptime returns how long the server accepted connections.
+func (r ShutdownReport) Uptime() time.Duration {
+	if r.StartedAt.IsZero() {
+		return 0
+	}
+	return r.StoppedAt.Sub(r.StartedAt)
+}
+
+// Clean tells whether the server shut down on a signal or request, with its connections drained
+// and all its shutdown hooks succeeded.
+func (r ShutdownReport) Clean() bool {
+	return r.Reason != ShutdownFatalError && !r.Forced && r.HooksFailed == 0
+}
+
+// ShutdownReport returns the report of the last shutdown of the server, or nil if it has not
+// shut down.
+func (s *Server) ShutdownReport() *ShutdownReport {
+	return s.report
+}
+
+// sessionCounter is implemented by the session stores counting their live sessions
+type sessionCounter interface {
+	ActiveSessions() int
+}
+
+// countRequests counts the requests served, for the shutdown report
+func (s *Server) countRequests(c *gin.Context) {
+	c.Next()
+	s.requests.Add(1)
+}
+
+// fail shuts the server down on a fatal error, see WaitForSignal
+func (s *Server) fail(err error) {
+	log.Error().Err(err).Msg("Fatal server error, shutting down")
+	select {
+	case s.fatalErrors <- err:
+	default:
+	}
+}
+
+// newShutdownReport starts the report of a shutdown, for the reason set by WaitForSignal
+func (s *Server) newShutdownReport() *ShutdownReport {
+	report := &ShutdownReport{
+		Reason:         s.shutdownReason,
+		Signal:         s.shutdownSignal,
+		StartedAt:      s.startedAt,
+		RequestsServed: s.requests.Load(),
+	}
+	if report.Reason == "" {
+		report.Reason = ShutdownRequested
+	}
+	if s.fatalErr != nil {
+		report.Error = s.fatalErr.Error()
+	}
+	if counter, ok := s.sessionStore.(sessionCounter); ok {
+		active := counter.ActiveSessions()
+		report.ActiveSessions = &active
+	}
+	return report
+}
+
+// emitShutdownReport logs the report and writes it to the configured file
+func (s *Server) emitShutdownReport(report *ShutdownReport) {
+	report.StoppedAt = time.Now()
+	s.report = report
+
+	event := log.Info()
+	if !report.Clean() {
+		event = log.Warn()
+	}
+	event = event.
+		Str("reason", string(report.Reason)).
+		Dur("uptime", report.Uptime()).
+		Int64("requests_served", report.RequestsServed).
+		Int("hooks_succeeded", report.HooksSucceeded).
+		Int("hooks_failed", report.HooksFailed).
+		Bool("forced", report.Forced)
+	if report.Signal != "" {
+		event = event.Str("signal", report.Signal)
+	}
+	if report.Error != "" {
+		event = event.Str(zerolog.ErrorFieldName, report.Error)
+	}
+	if report.ActiveSessions != nil {
+		event = event.Int("active_sessions", *report.ActiveSessions)
+	}
+	event.Msg("Shutdown report")
+
+	path := s.config.WebServerConfig.ShutdownReportFile
+	if path == "" {
+		return
+	}
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err == nil {
+		// #nosec G306 -- the report holds no secrets and is read by external tooling
+		err = os.WriteFile(path, append(data, '\n'), 0o644)
+	}
+	if err != nil {
+		log.Error().Err(err).Str("path", path).Msg("Failed to write the shutdown report")
+	}
+}
@@ -0,0 +1,104 @@
+//go:build unit
+
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/animalet/sargantana-go/pkg/server/session"
+	"github.com/gin-contrib/sessions/cookie"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/pkg/errors"
+)
+
+var _ = Describe("Shutdown report", func() {
+	newServer := func(reportFile string) *Server {
+		s := NewServer(SargantanaConfig{WebServerConfig: WebServerConfig{
+			Address:            "localhost:0",
+			SessionName:        "session",
+			SessionSecret:      "secret",
+			ShutdownReportFile: reportFile,
+		}})
+		s.SetSessionStore(cookie.NewStore([]byte("secret")))
+		return s
+	}
+
+	It("should tell clean shutdowns apart", func() {
+		Expect(ShutdownReport{Reason: ShutdownSignal}.Clean()).To(BeTrue())
+		Expect(ShutdownReport{Reason: ShutdownRequested}.Clean()).To(BeTrue())
+		Expect(ShutdownReport{Reason: ShutdownRequested, HooksFailed: 1}.Clean()).To(BeFalse())
+		Expect(ShutdownReport{Reason: ShutdownSignal, Forced: true}.Clean()).To(BeFalse())
+		Expect(ShutdownReport{Reason: ShutdownFatalError}.Clean()).To(BeFalse())
+
+		started := time.Now()
+		Expect(ShutdownReport{StartedAt: started, StoppedAt: started.Add(time.Minute)}.Uptime()).To(Equal(time.Minute))
+		Expect(ShutdownReport{StoppedAt: started}.Uptime()).To(BeZero())
+	})
+
+	It("should report the requests served and the shutdown hooks, and write the report file", func() {
+		reportFile := filepath.Join(GinkgoT().TempDir(), "shutdown-report.json")
+		s := newServer(reportFile)
+		s.addShutdownHook(func() error { return nil })
+		s.addShutdownHook(func() error { return errors.New("hook error") })
+		Expect(s.Start()).To(Succeed())
+		Expect(s.ShutdownReport()).To(BeNil())
+
+		for range 3 {
+			response, err := http.Get("http://" + s.listener.Addr().String() + "/missing")
+			Expect(err).NotTo(HaveOccurred())
+			_ = response.Body.Close()
+		}
+		s.RequestShutdown()
+		Expect(s.WaitForSignal()).To(Succeed())
+
+		report := s.ShutdownReport()
+		Expect(report).NotTo(BeNil())
+		Expect(report.Reason).To(Equal(ShutdownRequested))
+		Expect(report.RequestsServed).To(BeEquivalentTo(3))
+		Expect(report.HooksSucceeded).To(BeNumerically(">=", 1))
+		Expect(report.HooksFailed).To(Equal(1))
+		Expect(report.ActiveSessions).To(BeNil())
+		Expect(report.Uptime()).To(BeNumerically(">", 0))
+		Expect(report.Clean()).To(BeFalse())
+
+		data, err := os.ReadFile(reportFile)
+		Expect(err).NotTo(HaveOccurred())
+		var written map[string]any
+		Expect(json.Unmarshal(data, &written)).To(Succeed())
+		Expect(written).To(HaveKeyWithValue("reason", "requested"))
+		Expect(written).To(HaveKeyWithValue("requests_served", BeEquivalentTo(3)))
+		Expect(written).To(HaveKeyWithValue("hooks_failed", BeEquivalentTo(1)))
+	})
+
+	It("should count the active sessions of the in-memory store", func() {
+		s := newServer("")
+		s.SetSessionStore(session.NewMemorySessionStore(false, []byte("secret")))
+		Expect(s.Start()).To(Succeed())
+		Expect(s.Shutdown()).To(Succeed())
+
+		report := s.ShutdownReport()
+		Expect(report.ActiveSessions).NotTo(BeNil())
+		Expect(*report.ActiveSessions).To(BeZero())
+		Expect(report.Clean()).To(BeTrue())
+	})
+
+	It("should shut down on a fatal error", func() {
+		s := newServer("")
+		Expect(s.Start()).To(Succeed())
+
+		s.fail(errors.New("listener closed"))
+		err := s.WaitForSignal()
+		Expect(err).To(MatchError(ErrFatal))
+		Expect(err).To(MatchError(ContainSubstring("listener closed")))
+
+		report := s.ShutdownReport()
+		Expect(report.Reason).To(Equal(ShutdownFatalError))
+		Expect(report.Error).To(Equal("listener closed"))
+		Expect(report.Clean()).To(BeFalse())
+	})
+})
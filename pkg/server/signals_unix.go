@@ -0,0 +1,15 @@
+//go:build !windows
+
+package server
+
+import (
+	"os"
+	"syscall"
+)
+
+// shutdownSignals stop the server. kill (no params) by default sends SIGTERM, kill -2 is SIGINT
+// and kill -9 is SIGKILL, which can't be caught.
+var shutdownSignals = []os.Signal{syscall.SIGINT, syscall.SIGTERM}
+
+// reloadSignals execute the reload hooks, see Server.OnReload.
+var reloadSignals = []os.Signal{syscall.SIGHUP}
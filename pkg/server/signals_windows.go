@@ -0,0 +1,16 @@
+//go:build windows
+
+package server
+
+import (
+	"os"
+	"syscall"
+)
+
+// shutdownSignals stop the server. Ctrl+C and Ctrl+Break are delivered as os.Interrupt, while
+// console close, logoff and system shutdown events are delivered as SIGTERM.
+var shutdownSignals = []os.Signal{os.Interrupt, syscall.SIGTERM}
+
+// reloadSignals is empty as Windows has no reload signal. Services reload through
+// Server.RequestReload when the service control manager sends a parameter change.
+var reloadSignals []os.Signal
@@ -0,0 +1,458 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	defaultSLOWindow             = time.Hour
+	defaultSLOEvaluationInterval = 30 * time.Second
+	defaultSLOAlertBurnRate      = 1
+	defaultSLOMinRequests        = 10
+
+	// sloBuckets is the number of buckets the SLO window is divided into.
+	sloBuckets = 60
+
+	sloIndicatorLatency = "latency"
+	sloIndicatorErrors  = "errors"
+)
+
+var (
+	sloRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "sargantana_slo_requests_total",
+		Help: "Number of requests matched by each SLO, by result (good, slow or error).",
+	}, []string{"slo", "result"})
+	sloBurnRate = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "sargantana_slo_burn_rate",
+		Help: "Rate at which the error budget is consumed over the SLO window. 1 consumes exactly the budget.",
+	}, []string{"slo", "indicator"})
+	sloBudgetRemaining = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "sargantana_slo_error_budget_remaining",
+		Help: "Fraction of the error budget left over the SLO window. Negative when the budget is exhausted.",
+	}, []string{"slo", "indicator"})
+	sloObjectiveTarget = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "sargantana_slo_objective",
+		Help: "Target ratio of good requests of each SLO indicator.",
+	}, []string{"slo", "indicator"})
+	sloAlerts = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "sargantana_slo_alerts_total",
+		Help: "Number of SLO alerts raised, by state (exhausted or recovered).",
+	}, []string{"slo", "indicator", "state"})
+)
+
+// SLOConfig configures service level objectives tracked per route group. Burn rates are computed
+// over a sliding window and exposed as Prometheus metrics, optionally alerting through a webhook.
+type SLOConfig struct {
+	Objectives []SLOObjectiveConfig `yaml:"objectives"`
+	// Window is the sliding window burn rates are computed over. Defaults to 1h.
+	Window time.Duration `yaml:"window,omitempty"`
+	// EvaluationInterval is how often burn rates are recomputed. Defaults to 30s.
+	EvaluationInterval time.Duration `yaml:"evaluation_interval,omitempty"`
+	// AlertBurnRate is the burn rate from which the budget is considered exhausted. Defaults to 1.
+	AlertBurnRate float64 `yaml:"alert_burn_rate,omitempty"`
+	// MinRequests is the number of requests in the window below which no alert is raised.
+	// Defaults to 10.
+	MinRequests int               `yaml:"min_requests,omitempty"`
+	Webhook     *SLOWebhookConfig `yaml:"webhook,omitempty"`
+}
+
+func (c SLOConfig) Validate() error {
+	if len(c.Objectives) == 0 {
+		return errors.New("at least one objective must be configured")
+	}
+	names := make(map[string]bool, len(c.Objectives))
+	for i, objective := range c.Objectives {
+		if err := objective.Validate(); err != nil {
+			return errors.Wrapf(err, "invalid objective at index %d", i)
+		}
+		if names[objective.Name] {
+			return errors.Errorf("duplicate objective name %q", objective.Name)
+		}
+		names[objective.Name] = true
+	}
+	if c.Window < 0 || c.EvaluationInterval < 0 || c.AlertBurnRate < 0 || c.MinRequests < 0 {
+		return errors.New("window, evaluation_interval, alert_burn_rate and min_requests must not be negative")
+	}
+	if c.Window != 0 && c.Window < time.Minute {
+		return errors.New("window must be at least 1m")
+	}
+	if c.Webhook != nil {
+		if err := c.Webhook.Validate(); err != nil {
+			return errors.Wrap(err, "invalid webhook configuration")
+		}
+	}
+	return nil
+}
+
+// SLOObjectiveConfig defines the objectives of a group of routes. Routes are request paths, matched
+// exactly or, when ending with '*', by prefix. At least one of Latency and MaxErrorRate must be set.
+type SLOObjectiveConfig struct {
+	Name    string   `yaml:"name"`
+	Routes  []string `yaml:"routes"`
+	Methods []string `yaml:"methods,omitempty"` // Empty matches every method
+	// Latency requires the given percentile of requests to complete within the threshold,
+	// e.g. p99 < 300ms.
+	Latency *SLOLatencyConfig `yaml:"latency,omitempty"`
+	// MaxErrorRate is the maximum ratio of server errors (5xx responses), e.g. 0.01 for 1%.
+	MaxErrorRate float64 `yaml:"max_error_rate,omitempty"`
+}
+
+func (c SLOObjectiveConfig) Validate() error {
+	if c.Name == "" {
+		return errors.New("name must be set and non-empty")
+	}
+	if len(c.Routes) == 0 {
+		return errors.New("at least one route must be configured")
+	}
+	for _, route := range c.Routes {
+		if !strings.HasPrefix(route, "/") {
+			return errors.Errorf("route %q must start with /", route)
+		}
+	}
+	if c.Latency == nil && c.MaxErrorRate == 0 {
+		return errors.New("latency or max_error_rate must be set")
+	}
+	if c.Latency != nil {
+		if err := c.Latency.Validate(); err != nil {
+			return errors.Wrap(err, "invalid latency objective")
+		}
+	}
+	if c.MaxErrorRate < 0 || c.MaxErrorRate >= 1 {
+		return errors.New("max_error_rate must be between 0 and 1")
+	}
+	return nil
+}
+
+// SLOLatencyConfig is a latency objective.
+type SLOLatencyConfig struct {
+	Threshold  time.Duration `yaml:"threshold"`
+	Percentile float64       `yaml:"percentile"` // e.g. 99 or 99.9
+}
+
+func (c SLOLatencyConfig) Validate() error {
+	if c.Threshold <= 0 {
+		return errors.New("threshold must be greater than zero")
+	}
+	if c.Percentile <= 0 || c.Percentile >= 100 {
+		return errors.New("percentile must be between 0 and 100")
+	}
+	return nil
+}
+
+// SLOWebhookConfig configures the webhook notified, with a JSON POST request, when the error
+// budget of an objective is exhausted and when it recovers.
+type SLOWebhookConfig struct {
+	URL     string            `yaml:"url"`
+	Headers map[string]string `yaml:"headers,omitempty"`
+}
+
+func (c SLOWebhookConfig) Validate() error {
+	parsed, err := url.ParseRequestURI(c.URL)
+	if err != nil {
+		return errors.Wrap(err, "invalid url")
+	}
+	if parsed.Scheme != "http" && parsed.Scheme != "https" {
+		return errors.New("url must use http or https")
+	}
+	return nil
+}
+
+// SLOAlert is the payload sent to the SLO webhook. Text makes it usable as a Slack-compatible
+// incoming webhook message.
+type SLOAlert struct {
+	SLO       string    `json:"slo"`
+	Indicator string    `json:"indicator"`
+	State     string    `json:"state"` // "exhausted" or "recovered"
+	BurnRate  float64   `json:"burn_rate"`
+	Objective float64   `json:"objective"`
+	Window    string    `json:"window"`
+	Requests  int64     `json:"requests"`
+	Time      time.Time `json:"time"`
+	Text      string    `json:"text"`
+}
+
+type sloBucket struct {
+	start  time.Time
+	total  int64
+	slow   int64
+	errors int64
+}
+
+// sloObjective accumulates the requests of an objective in a ring of time buckets.
+type sloObjective struct {
+	config SLOObjectiveConfig
+
+	mu        sync.Mutex
+	buckets   [sloBuckets]sloBucket
+	exhausted map[string]bool
+}
+
+func (o *sloObjective) matches(method, path string) bool {
+	if len(o.config.Methods) > 0 {
+		found := false
+		for _, m := range o.config.Methods {
+			if strings.EqualFold(m, method) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	for _, route := range o.config.Routes {
+		if prefix, ok := strings.CutSuffix(route, "*"); ok {
+			if strings.HasPrefix(path, prefix) {
+				return true
+			}
+		} else if path == route {
+			return true
+		}
+	}
+	return false
+}
+
+// sloTracker records matched requests and periodically evaluates the burn rate of each objective.
+type sloTracker struct {
+	config     SLOConfig
+	objectives []*sloObjective
+	bucket     time.Duration
+	client     *http.Client
+	now        func() time.Time
+
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+func newSLOTracker(c SLOConfig) *sloTracker {
+	if c.Window == 0 {
+		c.Window = defaultSLOWindow
+	}
+	if c.EvaluationInterval == 0 {
+		c.EvaluationInterval = defaultSLOEvaluationInterval
+	}
+	if c.AlertBurnRate == 0 {
+		c.AlertBurnRate = defaultSLOAlertBurnRate
+	}
+	if c.MinRequests == 0 {
+		c.MinRequests = defaultSLOMinRequests
+	}
+
+	t := &sloTracker{
+		config: c,
+		bucket: c.Window / sloBuckets,
+		client: &http.Client{Timeout: 10 * time.Second},
+		now:    time.Now,
+		stop:   make(chan struct{}),
+	}
+	for _, objective := range c.Objectives {
+		t.objectives = append(t.objectives, &sloObjective{config: objective, exhausted: make(map[string]bool)})
+		if objective.Latency != nil {
+			sloObjectiveTarget.WithLabelValues(objective.Name, sloIndicatorLatency).Set(objective.Latency.Percentile / 100)
+		}
+		if objective.MaxErrorRate > 0 {
+			sloObjectiveTarget.WithLabelValues(objective.Name, sloIndicatorErrors).Set(1 - objective.MaxErrorRate)
+		}
+	}
+	return t
+}
+
+// start runs the periodic evaluation until Close is called.
+func (t *sloTracker) start() {
+	t.wg.Add(1)
+	go func() {
+		defer t.wg.Done()
+		ticker := time.NewTicker(t.config.EvaluationInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				t.evaluate()
+			case <-t.stop:
+				return
+			}
+		}
+	}()
+}
+
+func (t *sloTracker) Close() error {
+	close(t.stop)
+	t.wg.Wait()
+	return nil
+}
+
+func (t *sloTracker) middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := t.now()
+		c.Next()
+		status := ResponseStatus(c)
+		if status == StatusClientClosedRequest {
+			// Cancelled by the user, neither good nor bad
+			return
+		}
+		t.record(c.Request.Method, c.Request.URL.Path, status, t.now().Sub(start))
+	}
+}
+
+func (t *sloTracker) record(method, path string, status int, duration time.Duration) {
+	now := t.now()
+	for _, objective := range t.objectives {
+		if !objective.matches(method, path) {
+			continue
+		}
+		slow := objective.config.Latency != nil && duration > objective.config.Latency.Threshold
+		failed := status >= http.StatusInternalServerError
+
+		objective.mu.Lock()
+		bucket := t.currentBucket(objective, now)
+		bucket.total++
+		if slow {
+			bucket.slow++
+		}
+		if failed {
+			bucket.errors++
+		}
+		objective.mu.Unlock()
+
+		switch {
+		case failed:
+			sloRequests.WithLabelValues(objective.config.Name, "error").Inc()
+		case slow:
+			sloRequests.WithLabelValues(objective.config.Name, "slow").Inc()
+		default:
+			sloRequests.WithLabelValues(objective.config.Name, "good").Inc()
+		}
+	}
+}
+
+// currentBucket returns the bucket for the given time, resetting it if it belongs to a previous
+// turn of the ring. Must be called with the objective lock held.
+func (t *sloTracker) currentBucket(o *sloObjective, now time.Time) *sloBucket {
+	start := now.Truncate(t.bucket)
+	bucket := &o.buckets[(start.UnixNano()/int64(t.bucket))%sloBuckets]
+	if !bucket.start.Equal(start) {
+		*bucket = sloBucket{start: start}
+	}
+	return bucket
+}
+
+// evaluate updates the burn rate metrics and sends the alerts of state changes.
+func (t *sloTracker) evaluate() {
+	now := t.now()
+	var alerts []SLOAlert
+	for _, o := range t.objectives {
+		o.mu.Lock()
+		var total, slow, failed int64
+		for _, bucket := range o.buckets {
+			if now.Sub(bucket.start) < t.config.Window {
+				total += bucket.total
+				slow += bucket.slow
+				failed += bucket.errors
+			}
+		}
+
+		if o.config.Latency != nil {
+			allowed := 1 - o.config.Latency.Percentile/100
+			if alert, ok := t.update(o, sloIndicatorLatency, slow, total, allowed, now); ok {
+				alerts = append(alerts, alert)
+			}
+		}
+		if o.config.MaxErrorRate > 0 {
+			if alert, ok := t.update(o, sloIndicatorErrors, failed, total, o.config.MaxErrorRate, now); ok {
+				alerts = append(alerts, alert)
+			}
+		}
+		o.mu.Unlock()
+	}
+
+	for _, alert := range alerts {
+		sloAlerts.WithLabelValues(alert.SLO, alert.Indicator, alert.State).Inc()
+		event := log.Warn()
+		if alert.State == "recovered" {
+			event = log.Info()
+		}
+		event.Str("slo", alert.SLO).Str("indicator", alert.Indicator).Float64("burn_rate", alert.BurnRate).Msg(alert.Text)
+		if t.config.Webhook != nil {
+			if err := t.notify(alert); err != nil {
+				log.Error().Err(err).Str("slo", alert.SLO).Msg("Failed to send SLO alert")
+			}
+		}
+	}
+}
+
+// update sets the metrics of an indicator and returns an alert if its budget became exhausted or
+// recovered. Must be called with the objective lock held.
+func (t *sloTracker) update(o *sloObjective, indicator string, bad, total int64, allowed float64, now time.Time) (SLOAlert, bool) {
+	var burnRate float64
+	if total > 0 {
+		burnRate = float64(bad) / float64(total) / allowed
+	}
+	sloBurnRate.WithLabelValues(o.config.Name, indicator).Set(burnRate)
+	sloBudgetRemaining.WithLabelValues(o.config.Name, indicator).Set(1 - burnRate)
+
+	exhausted := o.exhausted[indicator]
+	if total >= int64(t.config.MinRequests) && burnRate >= t.config.AlertBurnRate {
+		exhausted = true
+	} else if burnRate < t.config.AlertBurnRate {
+		exhausted = false
+	}
+	if exhausted == o.exhausted[indicator] {
+		return SLOAlert{}, false
+	}
+	o.exhausted[indicator] = exhausted
+
+	alert := SLOAlert{
+		SLO:       o.config.Name,
+		Indicator: indicator,
+		State:     "recovered",
+		BurnRate:  burnRate,
+		Objective: 1 - allowed,
+		Window:    t.config.Window.String(),
+		Requests:  total,
+		Time:      now.UTC(),
+	}
+	if exhausted {
+		alert.State = "exhausted"
+		alert.Text = fmt.Sprintf("SLO %s (%s) error budget exhausted: burn rate %.2f over %s", alert.SLO, indicator, burnRate, alert.Window)
+	} else {
+		alert.Text = fmt.Sprintf("SLO %s (%s) recovered: burn rate %.2f over %s", alert.SLO, indicator, burnRate, alert.Window)
+	}
+	return alert, true
+}
+
+func (t *sloTracker) notify(alert SLOAlert) error {
+	payload, err := json.Marshal(alert)
+	if err != nil {
+		return err
+	}
+	request, err := http.NewRequest(http.MethodPost, t.config.Webhook.URL, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	for key, value := range t.config.Webhook.Headers {
+		request.Header.Set(key, value)
+	}
+	response, err := t.client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = response.Body.Close() }()
+	if response.StatusCode >= 300 {
+		return errors.Errorf("webhook responded with status %d", response.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,148 @@
+//go:build unit
+
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+var _ = Describe("SLO tracking", func() {
+	var (
+		now    time.Time
+		mu     sync.Mutex
+		alerts []SLOAlert
+		hook   *httptest.Server
+	)
+
+	BeforeEach(func() {
+		now = time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+		alerts = nil
+		hook = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			defer GinkgoRecover()
+			Expect(r.Header.Get("Authorization")).To(Equal("Bearer token"))
+			var alert SLOAlert
+			Expect(json.NewDecoder(r.Body).Decode(&alert)).To(Succeed())
+			mu.Lock()
+			alerts = append(alerts, alert)
+			mu.Unlock()
+		}))
+		DeferCleanup(hook.Close)
+	})
+
+	newTracker := func(objective SLOObjectiveConfig) *sloTracker {
+		tracker := newSLOTracker(SLOConfig{
+			Objectives:  []SLOObjectiveConfig{objective},
+			Window:      time.Hour,
+			MinRequests: 5,
+			Webhook:     &SLOWebhookConfig{URL: hook.URL, Headers: map[string]string{"Authorization": "Bearer token"}},
+		})
+		tracker.now = func() time.Time { return now }
+		return tracker
+	}
+
+	It("should validate the configuration", func() {
+		valid := SLOObjectiveConfig{Name: "api", Routes: []string{"/api/*"}, MaxErrorRate: 0.01}
+		Expect(SLOConfig{Objectives: []SLOObjectiveConfig{valid}}.Validate()).To(Succeed())
+		Expect(SLOConfig{}.Validate()).To(HaveOccurred())
+		Expect(SLOConfig{Objectives: []SLOObjectiveConfig{valid, valid}}.Validate()).To(MatchError(ContainSubstring("duplicate")))
+		Expect(SLOConfig{Objectives: []SLOObjectiveConfig{valid}, Window: time.Second}.Validate()).To(HaveOccurred())
+		Expect(SLOConfig{Objectives: []SLOObjectiveConfig{valid}, Webhook: &SLOWebhookConfig{URL: "ftp://hooks"}}.Validate()).To(HaveOccurred())
+		Expect(SLOObjectiveConfig{Name: "api", Routes: []string{"/api"}}.Validate()).To(MatchError(ContainSubstring("latency or max_error_rate")))
+		Expect(SLOObjectiveConfig{Name: "api", Routes: []string{"api"}, MaxErrorRate: 0.01}.Validate()).To(HaveOccurred())
+		Expect(SLOObjectiveConfig{Name: "api", Routes: []string{"/api"}, MaxErrorRate: 1}.Validate()).To(HaveOccurred())
+		Expect(SLOObjectiveConfig{Name: "api", Routes: []string{"/api"}, Latency: &SLOLatencyConfig{Threshold: time.Second, Percentile: 100}}.Validate()).To(HaveOccurred())
+		Expect(WebServerConfig{
+			Address: "localhost:8080", SessionName: "s", SessionSecret: "secret",
+			SLO: &SLOConfig{},
+		}.Validate()).To(MatchError(ContainSubstring("invalid slo configuration")))
+	})
+
+	It("should match routes by method and path", func() {
+		objective := &sloObjective{config: SLOObjectiveConfig{Routes: []string{"/api/*", "/health"}, Methods: []string{"get"}}}
+		Expect(objective.matches(http.MethodGet, "/api/orders")).To(BeTrue())
+		Expect(objective.matches(http.MethodGet, "/health")).To(BeTrue())
+		Expect(objective.matches(http.MethodGet, "/healthz")).To(BeFalse())
+		Expect(objective.matches(http.MethodPost, "/api/orders")).To(BeFalse())
+	})
+
+	It("should count requests through the middleware", func() {
+		tracker := newTracker(SLOObjectiveConfig{Name: "mw", Routes: []string{"/api/*"}, MaxErrorRate: 0.5})
+		good := sloRequests.WithLabelValues("mw", "good")
+		failed := sloRequests.WithLabelValues("mw", "error")
+		goodBefore, failedBefore := testutil.ToFloat64(good), testutil.ToFloat64(failed)
+
+		gin.SetMode(gin.TestMode)
+		engine := gin.New()
+		engine.Use(tracker.middleware())
+		engine.GET("/api/ok", func(c *gin.Context) { c.Status(http.StatusOK) })
+		engine.GET("/api/fail", func(c *gin.Context) { c.Status(http.StatusBadGateway) })
+		engine.GET("/other", func(c *gin.Context) { c.Status(http.StatusInternalServerError) })
+		for _, path := range []string{"/api/ok", "/api/fail", "/other"} {
+			engine.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+		}
+
+		Expect(testutil.ToFloat64(good) - goodBefore).To(Equal(1.0))
+		Expect(testutil.ToFloat64(failed) - failedBefore).To(Equal(1.0))
+	})
+
+	It("should compute burn rates and alert when the budget is exhausted and recovers", func() {
+		tracker := newTracker(SLOObjectiveConfig{
+			Name:         "checkout",
+			Routes:       []string{"/checkout"},
+			Latency:      &SLOLatencyConfig{Threshold: 300 * time.Millisecond, Percentile: 90},
+			MaxErrorRate: 0.1,
+		})
+		for i := 0; i < 18; i++ {
+			tracker.record(http.MethodPost, "/checkout", http.StatusOK, 100*time.Millisecond)
+		}
+		tracker.record(http.MethodPost, "/checkout", http.StatusOK, time.Second)
+		tracker.record(http.MethodPost, "/checkout", http.StatusServiceUnavailable, 100*time.Millisecond)
+		tracker.evaluate()
+
+		Expect(testutil.ToFloat64(sloBurnRate.WithLabelValues("checkout", sloIndicatorLatency))).To(BeNumerically("~", 0.5, 1e-9))
+		Expect(testutil.ToFloat64(sloBudgetRemaining.WithLabelValues("checkout", sloIndicatorErrors))).To(BeNumerically("~", 0.5, 1e-9))
+		Expect(testutil.ToFloat64(sloObjectiveTarget.WithLabelValues("checkout", sloIndicatorLatency))).To(BeNumerically("~", 0.9, 1e-9))
+		Expect(alerts).To(BeEmpty())
+
+		for i := 0; i < 5; i++ {
+			tracker.record(http.MethodPost, "/checkout", http.StatusInternalServerError, 100*time.Millisecond)
+		}
+		tracker.evaluate()
+		Expect(alerts).To(HaveLen(1))
+		Expect(alerts[0].SLO).To(Equal("checkout"))
+		Expect(alerts[0].Indicator).To(Equal(sloIndicatorErrors))
+		Expect(alerts[0].State).To(Equal("exhausted"))
+		Expect(alerts[0].BurnRate).To(BeNumerically("~", 2.4, 1e-9))
+		Expect(alerts[0].Requests).To(BeEquivalentTo(25))
+		Expect(alerts[0].Text).To(ContainSubstring("error budget exhausted"))
+
+		// Alerts are only sent on state changes
+		tracker.evaluate()
+		Expect(alerts).To(HaveLen(1))
+
+		// Requests older than the window are forgotten
+		now = now.Add(time.Hour)
+		tracker.record(http.MethodPost, "/checkout", http.StatusOK, 100*time.Millisecond)
+		tracker.evaluate()
+		Expect(alerts).To(HaveLen(2))
+		Expect(alerts[1].State).To(Equal("recovered"))
+		Expect(testutil.ToFloat64(sloBurnRate.WithLabelValues("checkout", sloIndicatorErrors))).To(BeZero())
+	})
+
+	It("should not alert below the minimum number of requests", func() {
+		tracker := newTracker(SLOObjectiveConfig{Name: "quiet", Routes: []string{"/quiet"}, MaxErrorRate: 0.01})
+		tracker.record(http.MethodGet, "/quiet", http.StatusInternalServerError, time.Millisecond)
+		tracker.evaluate()
+		Expect(testutil.ToFloat64(sloBurnRate.WithLabelValues("quiet", sloIndicatorErrors))).To(Equal(100.0))
+		Expect(alerts).To(BeEmpty())
+	})
+})
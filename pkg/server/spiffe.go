@@ -0,0 +1,145 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"io"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	"github.com/spiffe/go-spiffe/v2/bundle/x509bundle"
+	"github.com/spiffe/go-spiffe/v2/spiffeid"
+	"github.com/spiffe/go-spiffe/v2/spiffetls/tlsconfig"
+	"github.com/spiffe/go-spiffe/v2/svid/x509svid"
+	"github.com/spiffe/go-spiffe/v2/workloadapi"
+)
+
+const defaultSPIFFETimeout = 30 * time.Second
+
+// SPIFFEConfig obtains the server identity from a SPIFFE Workload API (e.g. a SPIRE agent).
+// The X.509 SVID served by the listener and used for upstream mTLS is rotated automatically.
+//
+// If AuthorizedIDs or AuthorizedTrustDomain are set, clients must present an SVID matching them,
+// otherwise the listener only serves the SVID without requesting client certificates.
+type SPIFFEConfig struct {
+	SocketPath            string        `yaml:"socket_path,omitempty"` // defaults to SPIFFE_ENDPOINT_SOCKET, e.g. unix:///run/spire/sockets/agent.sock
+	AuthorizedIDs         []string      `yaml:"authorized_ids,omitempty"`
+	AuthorizedTrustDomain string        `yaml:"authorized_trust_domain,omitempty"`
+	Timeout               time.Duration `yaml:"timeout,omitempty"` // maximum wait for the first SVID, defaults to 30s
+}
+
+func (c SPIFFEConfig) Validate() error {
+	if _, err := parseSPIFFEIDs(c.AuthorizedIDs); err != nil {
+		return err
+	}
+	if c.AuthorizedTrustDomain != "" {
+		if _, err := spiffeid.TrustDomainFromString(c.AuthorizedTrustDomain); err != nil {
+			return errors.Wrapf(err, "invalid authorized_trust_domain %q", c.AuthorizedTrustDomain)
+		}
+	}
+	if c.Timeout < 0 {
+		return errors.New("timeout must not be negative")
+	}
+	return nil
+}
+
+// clientAuthorizer returns the authorizer for incoming connections, or nil if clients are not verified.
+func (c SPIFFEConfig) clientAuthorizer() tlsconfig.Authorizer {
+	ids, _ := parseSPIFFEIDs(c.AuthorizedIDs)
+	switch {
+	case len(ids) > 0:
+		return tlsconfig.AuthorizeOneOf(ids...)
+	case c.AuthorizedTrustDomain != "":
+		return tlsconfig.AuthorizeMemberOf(spiffeid.RequireTrustDomainFromString(c.AuthorizedTrustDomain))
+	default:
+		return nil
+	}
+}
+
+// WorkloadIdentity provides the server's SPIFFE X.509 SVID and trust bundles.
+// Controllers can use it to establish mTLS connections to upstream services.
+type WorkloadIdentity struct {
+	svids   x509svid.Source
+	bundles x509bundle.Source
+	closer  io.Closer
+}
+
+// NewWorkloadIdentity connects to the Workload API and waits for the first SVID to be issued.
+// The returned identity keeps watching the Workload API for rotations until Close is called.
+func NewWorkloadIdentity(c SPIFFEConfig) (*WorkloadIdentity, error) {
+	timeout := c.Timeout
+	if timeout == 0 {
+		timeout = defaultSPIFFETimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	var clientOptions []workloadapi.ClientOption
+	if c.SocketPath != "" {
+		clientOptions = append(clientOptions, workloadapi.WithAddr(c.SocketPath))
+	}
+	source, err := workloadapi.NewX509Source(ctx, workloadapi.WithClientOptions(clientOptions...))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to obtain X.509 SVID from the SPIFFE Workload API")
+	}
+
+	identity := &WorkloadIdentity{svids: source, bundles: source, closer: source}
+	if id, err := identity.ID(); err == nil {
+		log.Info().Str("spiffe_id", id.String()).Msg("SPIFFE workload identity obtained")
+	}
+	return identity, nil
+}
+
+// ID returns the SPIFFE ID of the current SVID.
+func (w *WorkloadIdentity) ID() (spiffeid.ID, error) {
+	svid, err := w.svids.GetX509SVID()
+	if err != nil {
+		return spiffeid.ID{}, err
+	}
+	return svid.ID, nil
+}
+
+// ClientTLSConfig returns a TLS configuration for mTLS connections to upstream services.
+// The server presents its own SVID and only accepts upstream SVIDs matching one of the given
+// SPIFFE IDs; if none are given, any SVID trusted by the bundles is accepted.
+func (w *WorkloadIdentity) ClientTLSConfig(authorizedIDs ...string) (*tls.Config, error) {
+	ids, err := parseSPIFFEIDs(authorizedIDs)
+	if err != nil {
+		return nil, err
+	}
+	authorizer := tlsconfig.AuthorizeAny()
+	if len(ids) > 0 {
+		authorizer = tlsconfig.AuthorizeOneOf(ids...)
+	}
+	return tlsconfig.MTLSClientConfig(w.svids, w.bundles, authorizer), nil
+}
+
+// serverTLSConfig returns the listener TLS configuration. If authorizer is nil,
+// client certificates are not requested.
+func (w *WorkloadIdentity) serverTLSConfig(authorizer tlsconfig.Authorizer) *tls.Config {
+	if authorizer == nil {
+		return tlsconfig.TLSServerConfig(w.svids)
+	}
+	return tlsconfig.MTLSServerConfig(w.svids, w.bundles, authorizer)
+}
+
+// Close stops watching the Workload API for SVID rotations.
+func (w *WorkloadIdentity) Close() error {
+	if w.closer == nil {
+		return nil
+	}
+	return w.closer.Close()
+}
+
+func parseSPIFFEIDs(rawIDs []string) ([]spiffeid.ID, error) {
+	ids := make([]spiffeid.ID, 0, len(rawIDs))
+	for _, raw := range rawIDs {
+		id, err := spiffeid.FromString(raw)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid SPIFFE ID %q", raw)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
@@ -0,0 +1,171 @@
+//go:build unit
+
+package server
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net/http"
+	"net/url"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/spiffe/go-spiffe/v2/bundle/x509bundle"
+	"github.com/spiffe/go-spiffe/v2/spiffeid"
+	"github.com/spiffe/go-spiffe/v2/spiffetls/tlsconfig"
+	"github.com/spiffe/go-spiffe/v2/svid/x509svid"
+)
+
+// testSPIFFECA generates a CA for the example.org trust domain.
+func testSPIFFECA() (*x509.Certificate, crypto.Signer) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	Expect(err).NotTo(HaveOccurred())
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "example.org CA"},
+		URIs:                  []*url.URL{{Scheme: "spiffe", Host: "example.org"}},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	Expect(err).NotTo(HaveOccurred())
+	ca, err := x509.ParseCertificate(der)
+	Expect(err).NotTo(HaveOccurred())
+	return ca, key
+}
+
+// testSVID issues an X.509 SVID for the given SPIFFE ID signed by the CA.
+func testSVID(ca *x509.Certificate, caKey crypto.Signer, id string) *x509svid.SVID {
+	spiffeID := spiffeid.RequireFromString(id)
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	Expect(err).NotTo(HaveOccurred())
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		URIs:         []*url.URL{spiffeID.URL()},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca, &key.PublicKey, caKey)
+	Expect(err).NotTo(HaveOccurred())
+	cert, err := x509.ParseCertificate(der)
+	Expect(err).NotTo(HaveOccurred())
+	return &x509svid.SVID{ID: spiffeID, Certificates: []*x509.Certificate{cert}, PrivateKey: key}
+}
+
+var _ = Describe("SPIFFE", func() {
+	Context("SPIFFEConfig Validate", func() {
+		It("should accept an empty configuration", func() {
+			Expect(SPIFFEConfig{}.Validate()).To(Succeed())
+		})
+
+		It("should reject invalid SPIFFE IDs and trust domains", func() {
+			Expect(SPIFFEConfig{AuthorizedIDs: []string{"https://example.org/api"}}.Validate()).NotTo(Succeed())
+			Expect(SPIFFEConfig{AuthorizedTrustDomain: "Not A Domain"}.Validate()).NotTo(Succeed())
+			Expect(SPIFFEConfig{Timeout: -time.Second}.Validate()).NotTo(Succeed())
+			Expect(SPIFFEConfig{
+				AuthorizedIDs:         []string{"spiffe://example.org/api"},
+				AuthorizedTrustDomain: "example.org",
+			}.Validate()).To(Succeed())
+		})
+
+		It("should not allow tls and spiffe together", func() {
+			cfg := WebServerConfig{
+				Address:       "localhost:8080",
+				SessionName:   "session",
+				SessionSecret: "secret",
+				TLS:           &TLSConfig{CertFile: "cert.pem", KeyFile: "key.pem"},
+				SPIFFE:        &SPIFFEConfig{},
+			}
+			Expect(cfg.Validate()).To(MatchError(ContainSubstring("cannot be configured at the same time")))
+		})
+
+		It("should only build a client authorizer when restrictions are configured", func() {
+			Expect(SPIFFEConfig{}.clientAuthorizer()).To(BeNil())
+			Expect(SPIFFEConfig{AuthorizedTrustDomain: "example.org"}.clientAuthorizer()).NotTo(BeNil())
+		})
+	})
+
+	Context("WorkloadIdentity", func() {
+		var (
+			bundle        *x509bundle.Bundle
+			serverSVID    *x509svid.SVID
+			gatewaySVID   *x509svid.SVID
+			serverAllowed tlsconfig.Authorizer
+		)
+
+		BeforeEach(func() {
+			ca, caKey := testSPIFFECA()
+			bundle = x509bundle.FromX509Authorities(spiffeid.RequireTrustDomainFromString("example.org"), []*x509.Certificate{ca})
+			serverSVID = testSVID(ca, caKey, "spiffe://example.org/backend")
+			gatewaySVID = testSVID(ca, caKey, "spiffe://example.org/gateway")
+			serverAllowed = tlsconfig.AuthorizeID(spiffeid.RequireFromString("spiffe://example.org/gateway"))
+		})
+
+		// startUpstream serves the upstream SVID and returns its URL
+		startUpstream := func() string {
+			tlsConfig := (&WorkloadIdentity{svids: serverSVID, bundles: bundle}).serverTLSConfig(serverAllowed)
+			listener, err := tls.Listen("tcp", "127.0.0.1:0", tlsConfig)
+			Expect(err).NotTo(HaveOccurred())
+			upstream := &http.Server{
+				Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					w.WriteHeader(http.StatusNoContent)
+				}),
+				ReadHeaderTimeout: time.Second,
+			}
+			go func() { _ = upstream.Serve(listener) }()
+			DeferCleanup(upstream.Close)
+			return "https://" + listener.Addr().String()
+		}
+
+		It("should expose the SVID SPIFFE ID", func() {
+			id, err := (&WorkloadIdentity{svids: gatewaySVID, bundles: bundle}).ID()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(id.String()).To(Equal("spiffe://example.org/gateway"))
+		})
+
+		It("should establish mTLS with an authorized upstream", func() {
+			upstream := startUpstream()
+
+			identity := &WorkloadIdentity{svids: gatewaySVID, bundles: bundle}
+			tlsConfig, err := identity.ClientTLSConfig("spiffe://example.org/backend")
+			Expect(err).NotTo(HaveOccurred())
+
+			client := &http.Client{Transport: &http.Transport{TLSClientConfig: tlsConfig}}
+			response, err := client.Get(upstream)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(response.Body.Close()).To(Succeed())
+			Expect(response.StatusCode).To(Equal(http.StatusNoContent))
+		})
+
+		It("should reject upstreams with unexpected SPIFFE IDs", func() {
+			upstream := startUpstream()
+
+			tlsConfig, err := (&WorkloadIdentity{svids: gatewaySVID, bundles: bundle}).ClientTLSConfig("spiffe://example.org/other")
+			Expect(err).NotTo(HaveOccurred())
+
+			client := &http.Client{Transport: &http.Transport{TLSClientConfig: tlsConfig}}
+			_, err = client.Get(upstream)
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("should reject invalid upstream SPIFFE IDs", func() {
+			_, err := (&WorkloadIdentity{svids: gatewaySVID, bundles: bundle}).ClientTLSConfig("not-an-id")
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("should close without an underlying source", func() {
+			Expect((&WorkloadIdentity{}).Close()).To(Succeed())
+		})
+	})
+})
@@ -0,0 +1,130 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"os"
+
+	"github.com/pkg/errors"
+)
+
+// TLSConfig enables HTTPS on the server listener.
+// The server certificate is read from CertFile and KeyFile, or issued by Vault if VaultPKI is set.
+// ClientAuth controls whether client certificates are requested and verified against ClientCA,
+// which is how machine-to-machine (mTLS) access is configured.
+type TLSConfig struct {
+	CertFile   string          `yaml:"cert_file,omitempty"`
+	KeyFile    string          `yaml:"key_file,omitempty"`
+	VaultPKI   *VaultPKIConfig `yaml:"vault_pki,omitempty"`
+	ClientCA   string          `yaml:"client_ca,omitempty"`
+	ClientAuth string          `yaml:"client_auth,omitempty"` // none, request, require, verify_if_given, require_and_verify
+	// MinVersion is the oldest accepted protocol version, "1.2" or "1.3". Defaults to 1.2.
+	MinVersion string `yaml:"min_version,omitempty"`
+	// CipherSuites restricts the TLS 1.2 cipher suites, by their IANA names, e.g.
+	// TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256. Defaults to the secure suites of Go. TLS 1.3 suites
+	// are not configurable.
+	CipherSuites []string `yaml:"cipher_suites,omitempty"`
+}
+
+var tlsVersions = map[string]uint16{
+	"":    tls.VersionTLS12,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+var clientAuthTypes = map[string]tls.ClientAuthType{
+	"":                   tls.NoClientCert,
+	"none":               tls.NoClientCert,
+	"request":            tls.RequestClientCert,
+	"require":            tls.RequireAnyClientCert,
+	"verify_if_given":    tls.VerifyClientCertIfGiven,
+	"require_and_verify": tls.RequireAndVerifyClientCert,
+}
+
+func (c TLSConfig) Validate() error {
+	if c.VaultPKI != nil {
+		if c.CertFile != "" || c.KeyFile != "" {
+			return errors.New("cert_file and key_file cannot be used together with vault_pki")
+		}
+		if err := c.VaultPKI.Validate(); err != nil {
+			return errors.Wrap(err, "invalid vault_pki configuration")
+		}
+	} else if c.CertFile == "" || c.KeyFile == "" {
+		return errors.New("cert_file and key_file must be set and non-empty")
+	}
+	clientAuth, ok := clientAuthTypes[c.ClientAuth]
+	if !ok {
+		return errors.Errorf("invalid client_auth %q, must be one of: none, request, require, verify_if_given, require_and_verify", c.ClientAuth)
+	}
+	if clientAuth >= tls.VerifyClientCertIfGiven && c.ClientCA == "" {
+		return errors.Errorf("client_ca must be set when client_auth is %q", c.ClientAuth)
+	}
+	version, ok := tlsVersions[c.MinVersion]
+	if !ok {
+		return errors.Errorf("invalid min_version %q, must be 1.2 or 1.3", c.MinVersion)
+	}
+	if len(c.CipherSuites) > 0 && version == tls.VersionTLS13 {
+		return errors.New("cipher_suites cannot be set with min_version 1.3, whose suites are not configurable")
+	}
+	if _, err := cipherSuiteIDs(c.CipherSuites); err != nil {
+		return err
+	}
+	return nil
+}
+
+// cipherSuiteIDs returns the IDs of the named cipher suites, rejecting the insecure ones
+func cipherSuiteIDs(names []string) ([]uint16, error) {
+	if len(names) == 0 {
+		return nil, nil
+	}
+	ids := make(map[string]uint16)
+	for _, suite := range tls.CipherSuites() {
+		ids[suite.Name] = suite.ID
+	}
+	suites := make([]uint16, 0, len(names))
+	for _, name := range names {
+		id, ok := ids[name]
+		if !ok {
+			return nil, errors.Errorf("unknown or insecure cipher suite %q", name)
+		}
+		suites = append(suites, id)
+	}
+	return suites, nil
+}
+
+// buildTLSConfig loads the server certificate files and the client CA pool, if any.
+// Vault-issued certificates are not loaded here, the server sets GetCertificate instead.
+func (c TLSConfig) buildTLSConfig() (*tls.Config, error) {
+	suites, err := cipherSuiteIDs(c.CipherSuites)
+	if err != nil {
+		return nil, err
+	}
+	tlsConfig := &tls.Config{
+		MinVersion:   tlsVersions[c.MinVersion],
+		CipherSuites: suites,
+		ClientAuth:   clientAuthTypes[c.ClientAuth],
+	}
+
+	if c.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to load server certificate")
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	if c.ClientCA != "" {
+		// #nosec G304 -- CA file path is provided by the operator in the configuration
+		caCert, err := os.ReadFile(c.ClientCA)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to read client CA certificate")
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caCert) {
+			return nil, errors.Errorf("failed to parse client CA certificate %q", c.ClientCA)
+		}
+		tlsConfig.ClientCAs = pool
+	}
+
+	return tlsConfig, nil
+}
@@ -0,0 +1,144 @@
+//go:build unit
+
+package server
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/url"
+	"os"
+	"path/filepath"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+// testCertificate generates a self-signed certificate usable both as server certificate and CA.
+func testCertificate(commonName string, dnsNames []string, uris ...string) (*x509.Certificate, []byte, []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	Expect(err).NotTo(HaveOccurred())
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: commonName},
+		DNSNames:              dnsNames,
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	for _, raw := range uris {
+		u, err := url.Parse(raw)
+		Expect(err).NotTo(HaveOccurred())
+		template.URIs = append(template.URIs, u)
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	Expect(err).NotTo(HaveOccurred())
+	cert, err := x509.ParseCertificate(der)
+	Expect(err).NotTo(HaveOccurred())
+
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	Expect(err).NotTo(HaveOccurred())
+
+	return cert,
+		pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer})
+}
+
+// writeTestCertificate writes a generated certificate and key to dir and returns their paths.
+func writeTestCertificate(dir, commonName string) (certFile, keyFile string) {
+	_, certPEM, keyPEM := testCertificate(commonName, []string{"localhost"})
+	certFile = filepath.Join(dir, commonName+".crt")
+	keyFile = filepath.Join(dir, commonName+".key")
+	Expect(os.WriteFile(certFile, certPEM, 0600)).To(Succeed())
+	Expect(os.WriteFile(keyFile, keyPEM, 0600)).To(Succeed())
+	return certFile, keyFile
+}
+
+var _ = Describe("TLS", func() {
+	Context("TLSConfig Validate", func() {
+		It("should require certificate and key", func() {
+			Expect(TLSConfig{CertFile: "cert.pem"}.Validate()).NotTo(Succeed())
+			Expect(TLSConfig{CertFile: "cert.pem", KeyFile: "key.pem"}.Validate()).To(Succeed())
+		})
+
+		It("should reject unknown client_auth modes", func() {
+			err := TLSConfig{CertFile: "cert.pem", KeyFile: "key.pem", ClientAuth: "maybe"}.Validate()
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("invalid client_auth"))
+		})
+
+		It("should require client_ca when verifying client certificates", func() {
+			Expect(TLSConfig{CertFile: "c", KeyFile: "k", ClientAuth: "require_and_verify"}.Validate()).NotTo(Succeed())
+			Expect(TLSConfig{CertFile: "c", KeyFile: "k", ClientAuth: "verify_if_given", ClientCA: "ca.pem"}.Validate()).To(Succeed())
+			Expect(TLSConfig{CertFile: "c", KeyFile: "k", ClientAuth: "request"}.Validate()).To(Succeed())
+		})
+
+		It("should validate the protocol version and cipher suites", func() {
+			Expect(TLSConfig{CertFile: "c", KeyFile: "k", MinVersion: "1.3"}.Validate()).To(Succeed())
+			Expect(TLSConfig{CertFile: "c", KeyFile: "k", CipherSuites: []string{"TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256"}}.Validate()).To(Succeed())
+			Expect(TLSConfig{CertFile: "c", KeyFile: "k", MinVersion: "1.1"}.Validate()).To(MatchError(ContainSubstring("invalid min_version")))
+			Expect(TLSConfig{CertFile: "c", KeyFile: "k", CipherSuites: []string{"TLS_RSA_WITH_RC4_128_SHA"}}.Validate()).To(MatchError(ContainSubstring("insecure cipher suite")))
+			Expect(TLSConfig{CertFile: "c", KeyFile: "k", MinVersion: "1.3", CipherSuites: []string{"TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256"}}.Validate()).To(MatchError(ContainSubstring("min_version 1.3")))
+		})
+	})
+
+	Context("buildTLSConfig", func() {
+		It("should load certificates and client CA", func() {
+			dir := GinkgoT().TempDir()
+			certFile, keyFile := writeTestCertificate(dir, "server")
+			caFile, _ := writeTestCertificate(dir, "client-ca")
+
+			tlsConfig, err := TLSConfig{
+				CertFile:   certFile,
+				KeyFile:    keyFile,
+				ClientCA:   caFile,
+				ClientAuth: "require_and_verify",
+			}.buildTLSConfig()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(tlsConfig.Certificates).To(HaveLen(1))
+			Expect(tlsConfig.ClientCAs).NotTo(BeNil())
+			Expect(tlsConfig.ClientAuth).To(Equal(tls.RequireAndVerifyClientCert))
+			Expect(tlsConfig.MinVersion).To(Equal(uint16(tls.VersionTLS12)))
+			Expect(tlsConfig.CipherSuites).To(BeEmpty())
+		})
+
+		It("should set the protocol version and cipher suites", func() {
+			certFile, keyFile := writeTestCertificate(GinkgoT().TempDir(), "server")
+			tlsConfig, err := TLSConfig{
+				CertFile:     certFile,
+				KeyFile:      keyFile,
+				CipherSuites: []string{"TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256", "TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384"},
+			}.buildTLSConfig()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(tlsConfig.CipherSuites).To(Equal([]uint16{tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256, tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384}))
+
+			tlsConfig, err = TLSConfig{CertFile: certFile, KeyFile: keyFile, MinVersion: "1.3"}.buildTLSConfig()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(tlsConfig.MinVersion).To(Equal(uint16(tls.VersionTLS13)))
+		})
+
+		It("should fail with missing or invalid files", func() {
+			dir := GinkgoT().TempDir()
+			_, err := TLSConfig{CertFile: filepath.Join(dir, "missing.crt"), KeyFile: filepath.Join(dir, "missing.key")}.buildTLSConfig()
+			Expect(err).To(HaveOccurred())
+
+			certFile, keyFile := writeTestCertificate(dir, "server")
+			invalidCA := filepath.Join(dir, "invalid-ca.pem")
+			Expect(os.WriteFile(invalidCA, []byte("not a certificate"), 0600)).To(Succeed())
+			_, err = TLSConfig{CertFile: certFile, KeyFile: keyFile, ClientCA: invalidCA}.buildTLSConfig()
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("failed to parse client CA"))
+		})
+	})
+})
@@ -0,0 +1,121 @@
+package server
+
+import (
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+)
+
+const defaultTrustedUserHeader = "X-Remote-User"
+
+// TrustedHeaderAuthenticatorConfig configures authentication delegated to an upstream proxy, e.g.
+// an SSO gateway, that puts the name of the authenticated user in a request header.
+type TrustedHeaderAuthenticatorConfig struct {
+	// Header holds the user name. Defaults to X-Remote-User.
+	Header string `yaml:"header,omitempty"`
+	// TrustedProxies are the IPs or CIDRs of the proxies allowed to set the header. Requests
+	// from any other peer are rejected, whether they carry the header or not.
+	TrustedProxies []string `yaml:"trusted_proxies"`
+	// RolesHeader optionally holds the comma separated roles, or groups, of the user.
+	RolesHeader string `yaml:"roles_header,omitempty"`
+}
+
+func (c TrustedHeaderAuthenticatorConfig) Validate() error {
+	if len(c.TrustedProxies) == 0 {
+		return errors.New("trusted_proxies must be set and non-empty")
+	}
+	if _, err := parseIPNets(c.TrustedProxies); err != nil {
+		return errors.Wrap(err, "invalid trusted_proxies entry")
+	}
+	return nil
+}
+
+// TrustedHeaderAuthenticator implements Authenticator for deployments behind an authenticating
+// proxy. It accepts requests whose direct peer is a trusted proxy and that carry the user header,
+// and stores the user name in the context under RemoteUserKey, and the roles, if configured, under
+// RolesKey.
+//
+// The proxy must always overwrite the headers, otherwise clients may impersonate any user.
+type TrustedHeaderAuthenticator struct {
+	header      string
+	rolesHeader string
+	trusted     []*net.IPNet
+}
+
+// NewTrustedHeaderAuthenticator creates a new authenticator trusting the user header set by the
+// configured proxies.
+//
+// Example usage:
+//
+//	authenticator, err := server.NewTrustedHeaderAuthenticator(server.TrustedHeaderAuthenticatorConfig{
+//	    TrustedProxies: []string{"10.0.0.0/8"},
+//	})
+//	if err != nil {
+//	    return err
+//	}
+//	server.SetAuthenticator(authenticator)
+func NewTrustedHeaderAuthenticator(c TrustedHeaderAuthenticatorConfig) (Authenticator, error) {
+	trusted, err := parseIPNets(c.TrustedProxies)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid trusted_proxies entry")
+	}
+	header := c.Header
+	if header == "" {
+		header = defaultTrustedUserHeader
+	}
+	authenticator := &TrustedHeaderAuthenticator{header: http.CanonicalHeaderKey(header), trusted: trusted}
+	if c.RolesHeader != "" {
+		authenticator.rolesHeader = http.CanonicalHeaderKey(c.RolesHeader)
+	}
+	return authenticator, nil
+}
+
+// Middleware returns a Gin middleware that requires the user header from a trusted proxy.
+func (a *TrustedHeaderAuthenticator) Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		// The direct peer is checked instead of c.ClientIP, which may come from forwarding headers
+		host, _, err := net.SplitHostPort(c.Request.RemoteAddr)
+		if err != nil {
+			host = c.Request.RemoteAddr
+		}
+		if !a.isTrusted(net.ParseIP(host)) {
+			log.Debug().Str("peer", host).Msg("Request not sent by a trusted proxy")
+			c.AbortWithStatus(http.StatusUnauthorized)
+			return
+		}
+
+		username := strings.TrimSpace(c.GetHeader(a.header))
+		if username == "" {
+			c.AbortWithStatus(http.StatusUnauthorized)
+			return
+		}
+
+		c.Set(RemoteUserKey, username)
+		if a.rolesHeader != "" {
+			var roles []string
+			for _, role := range strings.Split(c.GetHeader(a.rolesHeader), ",") {
+				if role = strings.TrimSpace(role); role != "" {
+					roles = append(roles, role)
+				}
+			}
+			c.Set(RolesKey, roles)
+		}
+		c.Next()
+	}
+}
+
+func (a *TrustedHeaderAuthenticator) isTrusted(ip net.IP) bool {
+	if ip == nil {
+		return false
+	}
+	for _, ipNet := range a.trusted {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,77 @@
+//go:build unit
+
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/gin-gonic/gin"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("TrustedHeaderAuthenticator", func() {
+	var (
+		user  string
+		roles []string
+	)
+
+	serve := func(authenticator Authenticator, remoteAddr string, header http.Header) int {
+		gin.SetMode(gin.TestMode)
+		user, roles = "", nil
+		engine := gin.New()
+		engine.GET("/", authenticator.Middleware(), func(c *gin.Context) {
+			user, _ = GetRemoteUser(c)
+			roles = GetRoles(c)
+			c.Status(http.StatusOK)
+		})
+
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.RemoteAddr = remoteAddr
+		req.Header = header
+		engine.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	It("should validate the configuration", func() {
+		Expect(TrustedHeaderAuthenticatorConfig{TrustedProxies: []string{"10.0.0.0/8", "::1"}}.Validate()).To(Succeed())
+		Expect(TrustedHeaderAuthenticatorConfig{}.Validate()).To(MatchError(ContainSubstring("trusted_proxies must be set")))
+		Expect(TrustedHeaderAuthenticatorConfig{TrustedProxies: []string{"proxy"}}.Validate()).To(HaveOccurred())
+	})
+
+	It("should accept the user header from trusted proxies only", func() {
+		authenticator, err := NewTrustedHeaderAuthenticator(TrustedHeaderAuthenticatorConfig{TrustedProxies: []string{"10.0.0.0/8", "::1"}})
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(serve(authenticator, "10.1.2.3:4567", http.Header{"X-Remote-User": {"alice"}})).To(Equal(http.StatusOK))
+		Expect(user).To(Equal("alice"))
+		Expect(serve(authenticator, "[::1]:4567", http.Header{"X-Remote-User": {"bob"}})).To(Equal(http.StatusOK))
+		Expect(user).To(Equal("bob"))
+
+		Expect(serve(authenticator, "192.168.1.1:4567", http.Header{"X-Remote-User": {"alice"}, "X-Forwarded-For": {"10.1.2.3"}})).To(Equal(http.StatusUnauthorized))
+		Expect(serve(authenticator, "10.1.2.3:4567", http.Header{})).To(Equal(http.StatusUnauthorized))
+		Expect(serve(authenticator, "10.1.2.3:4567", http.Header{"X-Remote-User": {" "}})).To(Equal(http.StatusUnauthorized))
+		Expect(user).To(BeEmpty())
+	})
+
+	It("should read the configured header", func() {
+		authenticator, err := NewTrustedHeaderAuthenticator(TrustedHeaderAuthenticatorConfig{Header: "x-auth-request-user", TrustedProxies: []string{"10.0.0.1"}})
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(serve(authenticator, "10.0.0.1:4567", http.Header{"X-Auth-Request-User": {"carol"}})).To(Equal(http.StatusOK))
+		Expect(user).To(Equal("carol"))
+		Expect(serve(authenticator, "10.0.0.2:4567", http.Header{"X-Auth-Request-User": {"carol"}})).To(Equal(http.StatusUnauthorized))
+	})
+
+	It("should read the roles header if configured", func() {
+		authenticator, err := NewTrustedHeaderAuthenticator(TrustedHeaderAuthenticatorConfig{TrustedProxies: []string{"10.0.0.1"}, RolesHeader: "x-remote-groups"})
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(serve(authenticator, "10.0.0.1:4567", http.Header{"X-Remote-User": {"alice"}, "X-Remote-Groups": {"admin, staff,"}})).To(Equal(http.StatusOK))
+		Expect(roles).To(Equal([]string{"admin", "staff"}))
+		Expect(serve(authenticator, "10.0.0.1:4567", http.Header{"X-Remote-User": {"alice"}})).To(Equal(http.StatusOK))
+		Expect(roles).To(BeEmpty())
+	})
+})
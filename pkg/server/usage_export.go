@@ -0,0 +1,354 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/animalet/sargantana-go/internal/cron"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+)
+
+// UsageExportConfig configures the scheduled export of the usage of the quotas of the quota
+// middlewares, e.g. to bill internal customers. Each run aggregates the usage of the subjects of
+// every quota into a CSV or JSON report, written to a directory, posted to a webhook or uploaded to
+// S3.
+type UsageExportConfig struct {
+	// Schedule is a cron expression evaluated in UTC, e.g. "15 0 * * *" or "@daily".
+	Schedule string `yaml:"schedule"`
+	// Quotas are the names of the exported quotas. Defaults to all of them.
+	Quotas []string `yaml:"quotas,omitempty"`
+	// Period is "previous", the default, to export the last ended period of each quota, or "current".
+	Period string `yaml:"period,omitempty"`
+	// Format is "csv", the default, or "json".
+	Format    string                    `yaml:"format,omitempty"`
+	Directory string                    `yaml:"directory,omitempty"`
+	Webhook   *UsageExportWebhookConfig `yaml:"webhook,omitempty"`
+	S3        *UsageExportS3Config      `yaml:"s3,omitempty"`
+}
+
+// UsageExportWebhookConfig configures the webhook the reports are posted to.
+type UsageExportWebhookConfig struct {
+	URL     string            `yaml:"url"`
+	Headers map[string]string `yaml:"headers,omitempty"`
+}
+
+// UsageExportS3Config configures the bucket the reports are uploaded to.
+type UsageExportS3Config struct {
+	Bucket string `yaml:"bucket"`
+	// Prefix is prepended to the keys of the reports, e.g. billing/gateway.
+	Prefix string `yaml:"prefix,omitempty"`
+	Region string `yaml:"region"`
+	// Endpoint overrides the AWS endpoint, e.g. for MinIO. Defaults to https://s3.<region>.amazonaws.com.
+	Endpoint  string `yaml:"endpoint,omitempty"`
+	PathStyle bool   `yaml:"path_style,omitempty"`
+	// AccessKeyID and SecretAccessKey are optional, the default AWS credential chain is used otherwise.
+	AccessKeyID     string `yaml:"access_key_id,omitempty"`
+	SecretAccessKey string `yaml:"secret_access_key,omitempty"`
+}
+
+func (c UsageExportConfig) Validate() error {
+	if _, err := cron.Parse(c.Schedule); err != nil {
+		return errors.Wrap(err, "invalid schedule")
+	}
+	if c.Period != "" && c.Period != "previous" && c.Period != "current" {
+		return errors.Errorf("invalid period %q, expected previous or current", c.Period)
+	}
+	if c.Format != "" && c.Format != "csv" && c.Format != "json" {
+		return errors.Errorf("invalid format %q, expected csv or json", c.Format)
+	}
+	if c.Directory == "" && c.Webhook == nil && c.S3 == nil {
+		return errors.New("at least one of directory, webhook and s3 must be set")
+	}
+	if c.Webhook != nil {
+		if err := c.Webhook.Validate(); err != nil {
+			return errors.Wrap(err, "invalid webhook configuration")
+		}
+	}
+	if c.S3 != nil {
+		if err := c.S3.Validate(); err != nil {
+			return errors.Wrap(err, "invalid s3 configuration")
+		}
+	}
+	return nil
+}
+
+func (c UsageExportWebhookConfig) Validate() error {
+	parsed, err := url.ParseRequestURI(c.URL)
+	if err != nil {
+		return errors.Wrap(err, "invalid url")
+	}
+	if parsed.Scheme != "http" && parsed.Scheme != "https" {
+		return errors.New("url must use http or https")
+	}
+	return nil
+}
+
+func (c UsageExportS3Config) Validate() error {
+	if c.Bucket == "" || c.Region == "" {
+		return errors.New("bucket and region are required")
+	}
+	if c.Endpoint != "" {
+		if _, err := url.ParseRequestURI(c.Endpoint); err != nil {
+			return errors.Wrap(err, "invalid endpoint")
+		}
+	}
+	if (c.AccessKeyID == "") != (c.SecretAccessKey == "") {
+		return errors.New("access_key_id and secret_access_key must be set together")
+	}
+	return nil
+}
+
+// UsageRecord is the usage of a subject of a quota in a period, a line of the usage reports.
+type UsageRecord struct {
+	Quota   string `json:"quota"`
+	Period  string `json:"period"`
+	Subject string `json:"subject"`
+	Unit    string `json:"unit"`
+	Usage   int64  `json:"usage"`
+	Limit   int64  `json:"limit"`
+}
+
+// usageExporter runs the usage export on its schedule until Close is called.
+type usageExporter struct {
+	config      UsageExportConfig
+	schedule    *cron.Schedule
+	client      *http.Client
+	credentials aws.CredentialsProvider // Nil without s3
+	now         func() time.Time
+
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+func newUsageExporter(c UsageExportConfig) (*usageExporter, error) {
+	schedule, err := cron.Parse(c.Schedule)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid schedule")
+	}
+	if c.Period == "" {
+		c.Period = "previous"
+	}
+	if c.Format == "" {
+		c.Format = "csv"
+	}
+	e := &usageExporter{
+		config:   c,
+		schedule: schedule,
+		client:   &http.Client{Timeout: time.Minute},
+		now:      time.Now,
+		stop:     make(chan struct{}),
+	}
+	if c.S3 != nil {
+		if c.S3.AccessKeyID != "" {
+			e.credentials = credentials.NewStaticCredentialsProvider(c.S3.AccessKeyID, c.S3.SecretAccessKey, "")
+		} else {
+			cfg, err := awsconfig.LoadDefaultConfig(context.Background(), awsconfig.WithRegion(c.S3.Region))
+			if err != nil {
+				return nil, errors.Wrap(err, "failed to load AWS configuration")
+			}
+			e.credentials = cfg.Credentials
+		}
+	}
+	return e, nil
+}
+
+// start runs the exports at the scheduled times until Close is called.
+func (e *usageExporter) start() {
+	e.wg.Add(1)
+	go func() {
+		defer e.wg.Done()
+		for {
+			next := e.schedule.Next(e.now())
+			if next.IsZero() {
+				log.Warn().Str("schedule", e.config.Schedule).Msg("Usage export schedule has no next run")
+				return
+			}
+			timer := time.NewTimer(next.Sub(e.now()))
+			select {
+			case <-timer.C:
+				if err := e.export(context.Background()); err != nil {
+					log.Error().Err(err).Msg("Usage export failed")
+				}
+			case <-e.stop:
+				timer.Stop()
+				return
+			}
+		}
+	}()
+}
+
+func (e *usageExporter) Close() error {
+	close(e.stop)
+	e.wg.Wait()
+	return nil
+}
+
+// records aggregates the usage of the exported quotas
+func (e *usageExporter) records(ctx context.Context) ([]UsageRecord, error) {
+	names := e.config.Quotas
+	if len(names) == 0 {
+		names = QuotaNames()
+	}
+	now := e.now()
+	records := make([]UsageRecord, 0)
+	for _, name := range names {
+		quota, ok := LookupQuota(name)
+		if !ok {
+			log.Warn().Str("quota", name).Msg("Exported quota is not used by any middleware")
+			continue
+		}
+		period, _ := quota.Period(now)
+		if e.config.Period == "previous" {
+			period = quota.previousPeriod(now)
+		}
+		usage, err := quota.Usage(ctx, period)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to read the usage of quota %s", name)
+		}
+		limit, unit := quota.Limit()
+		subjects := make([]string, 0, len(usage))
+		for subject := range usage {
+			subjects = append(subjects, subject)
+		}
+		slices.Sort(subjects)
+		for _, subject := range subjects {
+			records = append(records, UsageRecord{Quota: name, Period: period, Subject: subject, Unit: unit, Usage: usage[subject], Limit: limit})
+		}
+	}
+	return records, nil
+}
+
+// export writes the usage report of the current run to every destination
+func (e *usageExporter) export(ctx context.Context) error {
+	records, err := e.records(ctx)
+	if err != nil {
+		return err
+	}
+	report, contentType, err := e.encode(records)
+	if err != nil {
+		return err
+	}
+	name := "usage-" + e.now().UTC().Format("20060102T150405Z") + "." + e.config.Format
+
+	// Every destination is tried, a failing one does not prevent the others from getting the report
+	failed := 0
+	fail := func(destination string, err error) {
+		failed++
+		log.Error().Err(err).Str("destination", destination).Str("report", name).Msg("Failed to export usage report")
+	}
+	if e.config.Directory != "" {
+		if err := os.MkdirAll(e.config.Directory, 0o750); err != nil {
+			fail("directory", err)
+		} else if err := os.WriteFile(filepath.Join(e.config.Directory, name), report, 0o640); err != nil {
+			fail("directory", err)
+		}
+	}
+	if e.config.Webhook != nil {
+		if err := e.post(ctx, report, contentType, name); err != nil {
+			fail("webhook", err)
+		}
+	}
+	if e.config.S3 != nil {
+		if err := e.upload(ctx, report, contentType, name); err != nil {
+			fail("s3", err)
+		}
+	}
+	if failed > 0 {
+		return errors.Errorf("%d usage report destinations failed", failed)
+	}
+	log.Info().Int("records", len(records)).Str("report", name).Msg("Usage exported")
+	return nil
+}
+
+func (e *usageExporter) encode(records []UsageRecord) ([]byte, string, error) {
+	var buffer bytes.Buffer
+	if e.config.Format == "json" {
+		err := json.NewEncoder(&buffer).Encode(records)
+		return buffer.Bytes(), "application/json", err
+	}
+	writer := csv.NewWriter(&buffer)
+	_ = writer.Write([]string{"quota", "period", "subject", "unit", "usage", "limit"})
+	for _, record := range records {
+		_ = writer.Write([]string{record.Quota, record.Period, record.Subject, record.Unit,
+			strconv.FormatInt(record.Usage, 10), strconv.FormatInt(record.Limit, 10)})
+	}
+	writer.Flush()
+	return buffer.Bytes(), "text/csv", writer.Error()
+}
+
+func (e *usageExporter) post(ctx context.Context, report []byte, contentType, name string) error {
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, e.config.Webhook.URL, bytes.NewReader(report))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", contentType)
+	request.Header.Set("Content-Disposition", `attachment; filename="`+name+`"`)
+	for key, value := range e.config.Webhook.Headers {
+		request.Header.Set(key, value)
+	}
+	return e.send(request)
+}
+
+// upload puts the report in the bucket with a signed request
+func (e *usageExporter) upload(ctx context.Context, report []byte, contentType, name string) error {
+	s3 := e.config.S3
+	endpoint := s3.Endpoint
+	if endpoint == "" {
+		endpoint = "https://s3." + s3.Region + ".amazonaws.com"
+	}
+	target, err := url.Parse(endpoint)
+	if err != nil {
+		return errors.Wrap(err, "invalid s3 endpoint")
+	}
+	key := strings.Trim(path.Join(s3.Prefix, name), "/")
+	if s3.PathStyle {
+		target.Path = path.Join("/", target.Path, s3.Bucket, key)
+	} else {
+		target.Host = s3.Bucket + "." + target.Host
+		target.Path = "/" + key
+	}
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodPut, target.String(), bytes.NewReader(report))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", contentType)
+	request.Header.Set("X-Amz-Content-Sha256", "UNSIGNED-PAYLOAD")
+	creds, err := e.credentials.Retrieve(ctx)
+	if err != nil {
+		return errors.Wrap(err, "failed to retrieve AWS credentials")
+	}
+	if err := v4.NewSigner().SignHTTP(ctx, creds, request, "UNSIGNED-PAYLOAD", "s3", s3.Region, e.now()); err != nil {
+		return errors.Wrap(err, "failed to sign s3 request")
+	}
+	return e.send(request)
+}
+
+func (e *usageExporter) send(request *http.Request) error {
+	response, err := e.client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = response.Body.Close() }()
+	if response.StatusCode >= 300 {
+		return errors.Errorf("%s responded with status %d", request.URL.Host, response.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,200 @@
+//go:build unit
+
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Usage export", func() {
+	Context("UsageExportConfig Validate", func() {
+		It("should accept valid exports", func() {
+			Expect(UsageExportConfig{Schedule: "@daily", Directory: "/var/lib/usage"}.Validate()).To(Succeed())
+			Expect(UsageExportConfig{Schedule: "15 0 1 * *", Period: "current", Format: "json",
+				Webhook: &UsageExportWebhookConfig{URL: "https://billing.example.com/usage"},
+				S3:      &UsageExportS3Config{Bucket: "billing", Region: "eu-west-1"}}.Validate()).To(Succeed())
+		})
+
+		It("should reject invalid settings", func() {
+			Expect(UsageExportConfig{Schedule: "daily", Directory: "d"}.Validate()).To(MatchError(ContainSubstring("invalid schedule")))
+			Expect(UsageExportConfig{Schedule: "@daily"}.Validate()).To(MatchError(ContainSubstring("at least one of")))
+			Expect(UsageExportConfig{Schedule: "@daily", Directory: "d", Period: "last"}.Validate()).To(MatchError(ContainSubstring("invalid period")))
+			Expect(UsageExportConfig{Schedule: "@daily", Directory: "d", Format: "xml"}.Validate()).To(MatchError(ContainSubstring("invalid format")))
+			Expect(UsageExportConfig{Schedule: "@daily", Webhook: &UsageExportWebhookConfig{URL: "ftp://host"}}.Validate()).NotTo(Succeed())
+			Expect(UsageExportConfig{Schedule: "@daily", S3: &UsageExportS3Config{Bucket: "billing"}}.Validate()).NotTo(Succeed())
+			Expect(UsageExportConfig{Schedule: "@daily", S3: &UsageExportS3Config{Bucket: "b", Region: "r", AccessKeyID: "id"}}.Validate()).NotTo(Succeed())
+		})
+	})
+
+	Context("Export", func() {
+		var (
+			now      time.Time
+			config   UsageExportConfig
+			exporter *usageExporter
+		)
+
+		register := func(c QuotaConfig, usage map[string]map[string]int64) {
+			store := NewMemoryQuotaStore()
+			for period, subjects := range usage {
+				for subject, n := range subjects {
+					_, err := store.Add(context.Background(), c.Name, subject, period, n, time.Now().Add(time.Hour))
+					Expect(err).NotTo(HaveOccurred())
+				}
+			}
+			quotasMu.Lock()
+			quotas[c.Name] = NewQuota(c, store)
+			quotasMu.Unlock()
+			DeferCleanup(func() {
+				quotasMu.Lock()
+				delete(quotas, c.Name)
+				quotasMu.Unlock()
+			})
+		}
+
+		BeforeEach(func() {
+			now = time.Date(2024, 6, 1, 0, 15, 0, 0, time.UTC)
+			config = UsageExportConfig{Schedule: "15 0 * * *", Quotas: []string{"export-daily", "export-monthly"}}
+			register(QuotaConfig{Name: "export-daily", Limit: 100}, map[string]map[string]int64{
+				"2024-05-31": {"bob": 7, "alice": 42},
+				"2024-06-01": {"alice": 1},
+			})
+			register(QuotaConfig{Name: "export-monthly", Limit: 1 << 30, Unit: "bytes", Period: "month"}, map[string]map[string]int64{
+				"2024-05": {"acme": 123456},
+			})
+		})
+
+		JustBeforeEach(func() {
+			var err error
+			exporter, err = newUsageExporter(config)
+			Expect(err).NotTo(HaveOccurred())
+			exporter.now = func() time.Time { return now }
+		})
+
+		It("should aggregate the usage of the previous periods", func() {
+			records, err := exporter.records(context.Background())
+			Expect(err).NotTo(HaveOccurred())
+			Expect(records).To(Equal([]UsageRecord{
+				{Quota: "export-daily", Period: "2024-05-31", Subject: "alice", Unit: "requests", Usage: 42, Limit: 100},
+				{Quota: "export-daily", Period: "2024-05-31", Subject: "bob", Unit: "requests", Usage: 7, Limit: 100},
+				{Quota: "export-monthly", Period: "2024-05", Subject: "acme", Unit: "bytes", Usage: 123456, Limit: 1 << 30},
+			}))
+		})
+
+		Context("with the current period", func() {
+			BeforeEach(func() {
+				config.Period = "current"
+				config.Quotas = []string{"export-daily", "export-missing"}
+			})
+
+			It("should aggregate the usage of the current periods", func() {
+				Expect(exporter.records(context.Background())).To(Equal([]UsageRecord{
+					{Quota: "export-daily", Period: "2024-06-01", Subject: "alice", Unit: "requests", Usage: 1, Limit: 100},
+				}))
+			})
+		})
+
+		Context("to a directory", func() {
+			BeforeEach(func() {
+				config.Directory = filepath.Join(GinkgoT().TempDir(), "usage")
+			})
+
+			It("should write a CSV report", func() {
+				Expect(exporter.export(context.Background())).To(Succeed())
+				report, err := os.ReadFile(filepath.Join(config.Directory, "usage-20240601T001500Z.csv"))
+				Expect(err).NotTo(HaveOccurred())
+				Expect(string(report)).To(Equal("quota,period,subject,unit,usage,limit\n" +
+					"export-daily,2024-05-31,alice,requests,42,100\n" +
+					"export-daily,2024-05-31,bob,requests,7,100\n" +
+					"export-monthly,2024-05,acme,bytes,123456,1073741824\n"))
+			})
+		})
+
+		Context("to a webhook and S3", func() {
+			var (
+				requests []*http.Request
+				bodies   []string
+				status   int
+			)
+
+			BeforeEach(func() {
+				requests, bodies, status = nil, nil, http.StatusOK
+				server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					body, _ := io.ReadAll(r.Body)
+					requests = append(requests, r)
+					bodies = append(bodies, string(body))
+					w.WriteHeader(status)
+				}))
+				DeferCleanup(server.Close)
+				config.Format = "json"
+				config.Webhook = &UsageExportWebhookConfig{URL: server.URL + "/usage", Headers: map[string]string{"Authorization": "Bearer token"}}
+				config.S3 = &UsageExportS3Config{Bucket: "billing", Prefix: "gateway/", Region: "eu-west-1", Endpoint: server.URL,
+					PathStyle: true, AccessKeyID: "id", SecretAccessKey: "secret"}
+			})
+
+			It("should post and upload a JSON report", func() {
+				Expect(exporter.export(context.Background())).To(Succeed())
+				Expect(requests).To(HaveLen(2))
+
+				Expect(requests[0].Method).To(Equal(http.MethodPost))
+				Expect(requests[0].URL.Path).To(Equal("/usage"))
+				Expect(requests[0].Header.Get("Authorization")).To(Equal("Bearer token"))
+				Expect(requests[0].Header.Get("Content-Type")).To(Equal("application/json"))
+				Expect(requests[0].Header.Get("Content-Disposition")).To(ContainSubstring("usage-20240601T001500Z.json"))
+				var records []UsageRecord
+				Expect(json.Unmarshal([]byte(bodies[0]), &records)).To(Succeed())
+				Expect(records).To(HaveLen(3))
+
+				Expect(requests[1].Method).To(Equal(http.MethodPut))
+				Expect(requests[1].URL.Path).To(Equal("/billing/gateway/usage-20240601T001500Z.json"))
+				Expect(requests[1].Header.Get("Authorization")).To(HavePrefix("AWS4-HMAC-SHA256 Credential=id/20240601/eu-west-1/s3/aws4_request"))
+				Expect(bodies[1]).To(Equal(bodies[0]))
+			})
+
+			It("should report failed destinations", func() {
+				status = http.StatusInternalServerError
+				Expect(exporter.export(context.Background())).To(MatchError(ContainSubstring("2 usage report destinations failed")))
+			})
+		})
+	})
+
+	Context("Schedule", func() {
+		It("should run the exports on schedule until closed", func() {
+			directory := GinkgoT().TempDir()
+			exporter, err := newUsageExporter(UsageExportConfig{Schedule: "* * * * *", Directory: directory})
+			Expect(err).NotTo(HaveOccurred())
+			// The next minute starts a second from now
+			start := time.Now()
+			exporter.now = func() time.Time {
+				return time.Now().Add(time.Minute - time.Second - start.Sub(start.Truncate(time.Minute)))
+			}
+			exporter.start()
+			DeferCleanup(exporter.Close)
+			Eventually(func() []os.DirEntry {
+				entries, _ := os.ReadDir(directory)
+				return entries
+			}, 3*time.Second).ShouldNot(BeEmpty())
+			entries, _ := os.ReadDir(directory)
+			Expect(strings.HasSuffix(entries[0].Name(), ".csv")).To(BeTrue())
+		})
+	})
+})
+
+var _ = Describe("Quota previous period", func() {
+	It("should return the period before", func() {
+		t := time.Date(2024, 3, 1, 0, 30, 0, 0, time.UTC)
+		Expect(NewQuota(QuotaConfig{}, nil).previousPeriod(t)).To(Equal("2024-02-29"))
+		Expect(NewQuota(QuotaConfig{Period: "month"}, nil).previousPeriod(t)).To(Equal("2024-02"))
+		Expect(NewQuota(QuotaConfig{Period: "month"}, nil).previousPeriod(time.Date(2024, 1, 31, 0, 0, 0, 0, time.UTC))).To(Equal("2023-12"))
+	})
+})
@@ -0,0 +1,207 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/vault/api"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+)
+
+// vaultPKIRetryInterval is the delay before retrying a failed certificate renewal.
+var vaultPKIRetryInterval = 30 * time.Second
+
+// vaultPKIMinRenewWait is the shortest delay between two certificate renewals, in case Vault issues
+// certificates already due for renewal.
+var vaultPKIMinRenewWait = time.Minute
+
+// VaultPKIConfig issues the server certificate from a HashiCorp Vault PKI secrets engine
+// instead of reading it from files. The certificate is renewed automatically before it expires.
+// The Vault client is the one configured in the top-level vault section (see Server.SetVaultClient).
+type VaultPKIConfig struct {
+	Mount       string        `yaml:"mount,omitempty"` // PKI secrets engine mount path, defaults to "pki"
+	Role        string        `yaml:"role"`
+	CommonName  string        `yaml:"common_name"`
+	AltNames    []string      `yaml:"alt_names,omitempty"`
+	IPSANs      []string      `yaml:"ip_sans,omitempty"`
+	TTL         time.Duration `yaml:"ttl,omitempty"`          // defaults to the role TTL
+	RenewBefore time.Duration `yaml:"renew_before,omitempty"` // defaults to a third of the certificate lifetime
+}
+
+func (c VaultPKIConfig) Validate() error {
+	if c.Role == "" {
+		return errors.New("role must be set and non-empty")
+	}
+	if c.CommonName == "" {
+		return errors.New("common_name must be set and non-empty")
+	}
+	if c.TTL < 0 || c.RenewBefore < 0 {
+		return errors.New("ttl and renew_before must not be negative")
+	}
+	if c.TTL > 0 && c.RenewBefore >= c.TTL {
+		return errors.New("renew_before must be lower than ttl")
+	}
+	return nil
+}
+
+func (c VaultPKIConfig) issuePath() string {
+	mount := strings.Trim(c.Mount, "/")
+	if mount == "" {
+		mount = "pki"
+	}
+	return mount + "/issue/" + c.Role
+}
+
+// vaultCertificateIssuer keeps a Vault-issued certificate up to date and serves it to the TLS listener.
+type vaultCertificateIssuer struct {
+	logical *api.Logical
+	config  VaultPKIConfig
+
+	mu          sync.RWMutex
+	certificate *tls.Certificate
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// newVaultCertificateIssuer issues the initial certificate and starts the renewal loop.
+func newVaultCertificateIssuer(client *api.Client, c VaultPKIConfig) (*vaultCertificateIssuer, error) {
+	issuer := &vaultCertificateIssuer{
+		logical: client.Logical(),
+		config:  c,
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	if err := issuer.issue(); err != nil {
+		return nil, err
+	}
+	go issuer.renewLoop()
+	return issuer, nil
+}
+
+func (v *vaultCertificateIssuer) issue() error {
+	data := map[string]interface{}{
+		"common_name": v.config.CommonName,
+	}
+	if len(v.config.AltNames) > 0 {
+		data["alt_names"] = strings.Join(v.config.AltNames, ",")
+	}
+	if len(v.config.IPSANs) > 0 {
+		data["ip_sans"] = strings.Join(v.config.IPSANs, ",")
+	}
+	if v.config.TTL > 0 {
+		data["ttl"] = fmt.Sprintf("%ds", int64(v.config.TTL.Seconds()))
+	}
+
+	secret, err := v.logical.Write(v.config.issuePath(), data)
+	if err != nil {
+		return errors.Wrapf(err, "failed to issue certificate from Vault path %q", v.config.issuePath())
+	}
+	if secret == nil || secret.Data == nil {
+		return errors.Errorf("no certificate returned from Vault path %q", v.config.issuePath())
+	}
+
+	certPEM, _ := secret.Data["certificate"].(string)
+	keyPEM, _ := secret.Data["private_key"].(string)
+	if certPEM == "" || keyPEM == "" {
+		return errors.Errorf("Vault response from %q does not contain a certificate and private key", v.config.issuePath())
+	}
+	// Append the chain so clients can build the path to the root
+	chain := certPEM
+	if caChain, ok := secret.Data["ca_chain"].([]interface{}); ok {
+		for _, ca := range caChain {
+			if caPEM, ok := ca.(string); ok {
+				chain += "\n" + caPEM
+			}
+		}
+	} else if issuingCA, ok := secret.Data["issuing_ca"].(string); ok {
+		chain += "\n" + issuingCA
+	}
+
+	certificate, err := tls.X509KeyPair([]byte(chain), []byte(keyPEM))
+	if err != nil {
+		return errors.Wrap(err, "failed to parse certificate issued by Vault")
+	}
+	if certificate.Leaf == nil {
+		if certificate.Leaf, err = x509.ParseCertificate(certificate.Certificate[0]); err != nil {
+			return errors.Wrap(err, "failed to parse certificate issued by Vault")
+		}
+	}
+
+	v.mu.Lock()
+	v.certificate = &certificate
+	v.mu.Unlock()
+
+	log.Info().
+		Str("common_name", v.config.CommonName).
+		Str("serial", certificate.Leaf.SerialNumber.String()).
+		Time("not_after", certificate.Leaf.NotAfter).
+		Msg("Server certificate issued by Vault PKI")
+	return nil
+}
+
+// renewAt returns when the current certificate should be renewed. renew_before falls back to a
+// third of the lifetime when it is not shorter than the lifetime issued, e.g. capped by the role.
+func (v *vaultCertificateIssuer) renewAt() time.Time {
+	v.mu.RLock()
+	leaf := v.certificate.Leaf
+	v.mu.RUnlock()
+
+	lifetime := leaf.NotAfter.Sub(leaf.NotBefore)
+	renewBefore := v.config.RenewBefore
+	if renewBefore >= lifetime {
+		log.Warn().Dur("renew_before", renewBefore).Dur("lifetime", lifetime).
+			Msg("Vault PKI renew_before is not shorter than the certificate lifetime, renewing after two thirds of it")
+		renewBefore = 0
+	}
+	if renewBefore == 0 {
+		renewBefore = lifetime / 3
+	}
+	return leaf.NotAfter.Add(-renewBefore)
+}
+
+// renewWait returns the delay until the current certificate should be renewed, at least
+// vaultPKIMinRenewWait.
+func (v *vaultCertificateIssuer) renewWait() time.Duration {
+	return max(time.Until(v.renewAt()), vaultPKIMinRenewWait)
+}
+
+func (v *vaultCertificateIssuer) renewLoop() {
+	defer close(v.done)
+	wait := v.renewWait()
+	for {
+		timer := time.NewTimer(wait)
+		select {
+		case <-v.stop:
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		if err := v.issue(); err != nil {
+			log.Error().Err(err).Msg("Failed to renew server certificate from Vault PKI, keeping the current one")
+			wait = vaultPKIRetryInterval
+			continue
+		}
+		wait = v.renewWait()
+	}
+}
+
+// GetCertificate implements tls.Config.GetCertificate.
+func (v *vaultCertificateIssuer) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+	return v.certificate, nil
+}
+
+// Close stops the renewal loop.
+func (v *vaultCertificateIssuer) Close() error {
+	close(v.stop)
+	<-v.done
+	return nil
+}
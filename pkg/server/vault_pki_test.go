@@ -0,0 +1,193 @@
+//go:build unit
+
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"time"
+
+	"github.com/hashicorp/vault/api"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Vault PKI", func() {
+	Context("VaultPKIConfig Validate", func() {
+		It("should require role and common name", func() {
+			Expect(VaultPKIConfig{CommonName: "gateway.internal"}.Validate()).NotTo(Succeed())
+			Expect(VaultPKIConfig{Role: "web"}.Validate()).NotTo(Succeed())
+			Expect(VaultPKIConfig{Role: "web", CommonName: "gateway.internal"}.Validate()).To(Succeed())
+		})
+
+		It("should require renew_before to be lower than ttl", func() {
+			Expect(VaultPKIConfig{Role: "web", CommonName: "gw", TTL: time.Hour, RenewBefore: 2 * time.Hour}.Validate()).NotTo(Succeed())
+			Expect(VaultPKIConfig{Role: "web", CommonName: "gw", TTL: time.Hour, RenewBefore: time.Minute}.Validate()).To(Succeed())
+		})
+
+		It("should not allow certificate files together with vault_pki", func() {
+			vaultPKI := &VaultPKIConfig{Role: "web", CommonName: "gw"}
+			Expect(TLSConfig{VaultPKI: vaultPKI}.Validate()).To(Succeed())
+			Expect(TLSConfig{VaultPKI: vaultPKI, CertFile: "cert.pem", KeyFile: "key.pem"}.Validate()).NotTo(Succeed())
+		})
+
+		It("should default the mount path", func() {
+			Expect(VaultPKIConfig{Role: "web"}.issuePath()).To(Equal("pki/issue/web"))
+			Expect(VaultPKIConfig{Mount: "/pki_int/", Role: "web"}.issuePath()).To(Equal("pki_int/issue/web"))
+		})
+	})
+
+	Context("vaultCertificateIssuer", func() {
+		var (
+			issued   atomic.Int32
+			requests chan map[string]interface{}
+			fail     atomic.Bool
+			client   *api.Client
+		)
+
+		BeforeEach(func() {
+			issued.Store(0)
+			fail.Store(false)
+			requests = make(chan map[string]interface{}, 10)
+			vault := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path != "/v1/pki/issue/web" || fail.Load() {
+					w.WriteHeader(http.StatusBadRequest)
+					return
+				}
+				var body map[string]interface{}
+				Expect(json.NewDecoder(r.Body).Decode(&body)).To(Succeed())
+				select {
+				case requests <- body:
+				default:
+				}
+
+				issued.Add(1)
+				_, certPEM, keyPEM := testCertificate("gateway.internal", []string{"gateway.internal"})
+				_, caPEM, _ := testCertificate("intermediate", nil)
+				_ = json.NewEncoder(w).Encode(map[string]interface{}{
+					"data": map[string]interface{}{
+						"certificate": string(certPEM),
+						"private_key": string(keyPEM),
+						"ca_chain":    []string{string(caPEM)},
+					},
+				})
+			}))
+			DeferCleanup(vault.Close)
+
+			cfg := api.DefaultConfig()
+			cfg.Address = vault.URL
+			var err error
+			client, err = api.NewClient(cfg)
+			Expect(err).NotTo(HaveOccurred())
+			client.SetToken("test-token")
+		})
+
+		It("should issue a certificate with the configured parameters", func() {
+			issuer, err := newVaultCertificateIssuer(client, VaultPKIConfig{
+				Role:       "web",
+				CommonName: "gateway.internal",
+				AltNames:   []string{"a.internal", "b.internal"},
+				IPSANs:     []string{"10.0.0.1"},
+				TTL:        24 * time.Hour,
+			})
+			Expect(err).NotTo(HaveOccurred())
+			defer func() { Expect(issuer.Close()).To(Succeed()) }()
+
+			Expect(<-requests).To(Equal(map[string]interface{}{
+				"common_name": "gateway.internal",
+				"alt_names":   "a.internal,b.internal",
+				"ip_sans":     "10.0.0.1",
+				"ttl":         "86400s",
+			}))
+
+			cert, err := issuer.GetCertificate(nil)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(cert.Certificate).To(HaveLen(2))
+			Expect(cert.Leaf.Subject.CommonName).To(Equal("gateway.internal"))
+		})
+
+		It("should renew the certificate before it expires", func() {
+			previousWait := vaultPKIMinRenewWait
+			vaultPKIMinRenewWait = 0
+			DeferCleanup(func() { vaultPKIMinRenewWait = previousWait })
+
+			// Test certificates are valid for one more hour
+			issuer, err := newVaultCertificateIssuer(client, VaultPKIConfig{
+				Role:        "web",
+				CommonName:  "gateway.internal",
+				RenewBefore: time.Hour - 100*time.Millisecond,
+			})
+			Expect(err).NotTo(HaveOccurred())
+			defer func() { Expect(issuer.Close()).To(Succeed()) }()
+
+			first := currentSerial(issuer)
+			Eventually(issued.Load).Should(BeNumerically(">=", 2))
+			Eventually(func() string { return currentSerial(issuer) }).ShouldNot(Equal(first))
+		})
+
+		It("should keep the current certificate when renewal fails", func() {
+			previousRetry, previousWait := vaultPKIRetryInterval, vaultPKIMinRenewWait
+			vaultPKIRetryInterval, vaultPKIMinRenewWait = 10*time.Millisecond, 0
+			DeferCleanup(func() { vaultPKIRetryInterval, vaultPKIMinRenewWait = previousRetry, previousWait })
+
+			issuer, err := newVaultCertificateIssuer(client, VaultPKIConfig{
+				Role:        "web",
+				CommonName:  "gateway.internal",
+				RenewBefore: time.Hour - 50*time.Millisecond,
+			})
+			Expect(err).NotTo(HaveOccurred())
+			defer func() { Expect(issuer.Close()).To(Succeed()) }()
+
+			fail.Store(true)
+			first := currentSerial(issuer)
+			Consistently(func() string { return currentSerial(issuer) }, 200*time.Millisecond).Should(Equal(first))
+
+			fail.Store(false)
+			Eventually(issued.Load).Should(BeNumerically(">=", 2))
+		})
+
+		It("should renew after two thirds of the lifetime when it is shorter than renew_before", func() {
+			// Test certificates are issued for two hours
+			issuer, err := newVaultCertificateIssuer(client, VaultPKIConfig{
+				Role:        "web",
+				CommonName:  "gateway.internal",
+				RenewBefore: 3 * time.Hour,
+			})
+			Expect(err).NotTo(HaveOccurred())
+			defer func() { Expect(issuer.Close()).To(Succeed()) }()
+
+			cert, err := issuer.GetCertificate(nil)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(issuer.renewAt()).To(Equal(cert.Leaf.NotAfter.Add(-40 * time.Minute)))
+			Consistently(issued.Load, 200*time.Millisecond).Should(Equal(int32(1)))
+		})
+
+		It("should wait between renewals of certificates already due", func() {
+			// The test certificates have one hour left, less than renew_before
+			issuer, err := newVaultCertificateIssuer(client, VaultPKIConfig{
+				Role:        "web",
+				CommonName:  "gateway.internal",
+				RenewBefore: 90 * time.Minute,
+			})
+			Expect(err).NotTo(HaveOccurred())
+			defer func() { Expect(issuer.Close()).To(Succeed()) }()
+
+			Expect(issuer.renewWait()).To(Equal(vaultPKIMinRenewWait))
+			Consistently(issued.Load, 200*time.Millisecond).Should(Equal(int32(1)))
+		})
+
+		It("should fail when Vault cannot issue the certificate", func() {
+			_, err := newVaultCertificateIssuer(client, VaultPKIConfig{Role: "unknown", CommonName: "gw"})
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("failed to issue certificate"))
+		})
+	})
+})
+
+func currentSerial(issuer *vaultCertificateIssuer) string {
+	cert, err := issuer.GetCertificate(nil)
+	Expect(err).NotTo(HaveOccurred())
+	return cert.Leaf.SerialNumber.String()
+}
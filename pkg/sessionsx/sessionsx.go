@@ -0,0 +1,174 @@
+// Package sessionsx provides typed accessors over gin-contrib/sessions so that controllers don't
+// need to repeat unchecked type assertions on session values. Values stored through Set are
+// registered with encoding/gob automatically, which session stores use to serialize them.
+package sessionsx
+
+import (
+	"encoding/gob"
+	"reflect"
+	"sync"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+	"github.com/markbates/goth"
+	"github.com/pkg/errors"
+)
+
+// UserKey is the default session key under which the auth controller stores the authenticated
+// user, see SetUserKey.
+const UserKey = "user"
+
+// UserKeyKey is the context key of the session key of the authenticated user, see SetUserKey.
+const UserKeyKey = "github.com/animalet/sargantana-go/sessionsx/user_key"
+
+// SetUserKey renames the session key of the authenticated user for the request, UserKey if empty.
+// The session middleware of the server sets it from its configuration.
+func SetUserKey(c *gin.Context, key string) {
+	if key == "" {
+		key = UserKey
+	}
+	c.Set(UserKeyKey, key)
+}
+
+// CurrentUserKey returns the session key of the authenticated user for the request, UserKey
+// unless renamed with SetUserKey.
+func CurrentUserKey(c *gin.Context) string {
+	if key := c.GetString(UserKeyKey); key != "" {
+		return key
+	}
+	return UserKey
+}
+
+// NamedKey is the context key of the named sessions, see Named.
+const NamedKey = "github.com/animalet/sargantana-go/sessionsx/named"
+
+// ErrNoUser is returned when the session holds no authenticated user.
+var ErrNoUser = errors.New("no authenticated user in session")
+
+// UserObject is the authenticated user stored in the session by the auth controller.
+type UserObject struct {
+	Id   string    `json:"id"`   // Unique identifier for the user session
+	User goth.User `json:"user"` // Complete user information from OAuth2 provider
+	// Generation is the credential generation of the user at login, see server.Revocation
+	Generation int64 `json:"generation,omitempty"`
+}
+
+func init() {
+	// UserObject was originally declared in the controller package. Keep its gob name so that
+	// sessions persisted by previous versions can still be decoded.
+	gob.RegisterName("github.com/animalet/sargantana-go/pkg/controller.UserObject", UserObject{})
+	registered.Store(reflect.TypeOf(UserObject{}), struct{}{})
+}
+
+// registered tracks the types already registered with gob.
+var registered sync.Map
+
+// Register registers the concrete type of value with encoding/gob, so that it can be stored in
+// sessions. Registering the same type several times is safe. Pointers register their element type.
+func Register(value any) {
+	t := reflect.TypeOf(value)
+	if t == nil {
+		return
+	}
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if _, loaded := registered.LoadOrStore(t, struct{}{}); loaded {
+		return
+	}
+	if t.Kind() == reflect.Struct || t.Kind() == reflect.Map || t.Kind() == reflect.Slice {
+		gob.Register(reflect.Zero(t).Interface())
+	}
+}
+
+// Get returns the session value for key as T. The second result is false if the key is missing or
+// holds a value of another type. Values stored as *T are dereferenced.
+func Get[T any](c *gin.Context, key string) (T, bool) {
+	return get[T](sessions.Default(c), key)
+}
+
+// Named returns the named session, or nil if no session has the name. Named sessions are
+// configured in the sessions of the server configuration.
+func Named(c *gin.Context, name string) sessions.Session {
+	named, _ := c.Value(NamedKey).(map[string]sessions.Session)
+	return named[name]
+}
+
+// GetNamed returns the value for key of the named session as T, see Get. The second result is
+// also false if no session has the name.
+func GetNamed[T any](c *gin.Context, name, key string) (T, bool) {
+	session := Named(c, name)
+	if session == nil {
+		var zero T
+		return zero, false
+	}
+	return get[T](session, key)
+}
+
+func get[T any](session sessions.Session, key string) (T, bool) {
+	var zero T
+	switch value := session.Get(key).(type) {
+	case T:
+		return value, true
+	case *T:
+		if value != nil {
+			return *value, true
+		}
+	}
+	return zero, false
+}
+
+// Set stores value under key and saves the session.
+func Set(c *gin.Context, key string, value any) error {
+	return set(sessions.Default(c), key, value)
+}
+
+// SetNamed stores value under key in the named session and saves it.
+func SetNamed(c *gin.Context, name, key string, value any) error {
+	session := Named(c, name)
+	if session == nil {
+		return errors.Errorf("unknown session %q", name)
+	}
+	return set(session, key, value)
+}
+
+func set(session sessions.Session, key string, value any) error {
+	Register(value)
+	session.Set(key, value)
+	return errors.Wrapf(session.Save(), "failed to save session value %q", key)
+}
+
+// Delete removes key from the session and saves it.
+func Delete(c *gin.Context, key string) error {
+	session := sessions.Default(c)
+	session.Delete(key)
+	return errors.Wrapf(session.Save(), "failed to delete session value %q", key)
+}
+
+// Clear removes every value from the session and saves it.
+func Clear(c *gin.Context) error {
+	session := sessions.Default(c)
+	session.Clear()
+	return errors.Wrap(session.Save(), "failed to clear session")
+}
+
+// User returns the authenticated user stored in the session, if any.
+func User(c *gin.Context) (UserObject, bool) {
+	return Get[UserObject](c, CurrentUserKey(c))
+}
+
+// SetUser stores the authenticated user in the session and saves it.
+func SetUser(c *gin.Context, user *UserObject) error {
+	return Set(c, CurrentUserKey(c), user)
+}
+
+// MustUser returns the authenticated user stored in the session. It is meant for handlers behind
+// the login middleware, which guarantees the user is present; it panics otherwise, which
+// gin.Recovery turns into a 500 response.
+func MustUser(c *gin.Context) UserObject {
+	user, ok := User(c)
+	if !ok {
+		panic(ErrNoUser)
+	}
+	return user
+}
@@ -0,0 +1,143 @@
+//go:build unit
+
+package sessionsx
+
+import (
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-contrib/sessions/cookie"
+	"github.com/gin-gonic/gin"
+	"github.com/markbates/goth"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+type cart struct {
+	Items []string
+}
+
+var _ = Describe("Typed session accessors", func() {
+	var engine *gin.Engine
+
+	BeforeEach(func() {
+		gin.SetMode(gin.TestMode)
+		engine = gin.New()
+		engine.Use(sessions.Sessions("session", cookie.NewStore([]byte("secret"))))
+	})
+
+	// serve runs a request carrying the cookies of the previous response, if any.
+	serve := func(path string, previous *httptest.ResponseRecorder) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(http.MethodGet, path, nil)
+		if previous != nil {
+			for _, c := range previous.Result().Cookies() {
+				req.AddCookie(c)
+			}
+		}
+		engine.ServeHTTP(w, req)
+		return w
+	}
+
+	It("should store and read typed values across requests", func() {
+		engine.GET("/set", func(c *gin.Context) {
+			Expect(Set(c, "cart", cart{Items: []string{"apple"}})).To(Succeed())
+		})
+		engine.GET("/get", func(c *gin.Context) {
+			value, ok := Get[cart](c, "cart")
+			Expect(ok).To(BeTrue())
+			c.String(http.StatusOK, value.Items[0])
+		})
+
+		w := serve("/get", serve("/set", nil))
+		Expect(w.Code).To(Equal(http.StatusOK))
+		Expect(w.Body.String()).To(Equal("apple"))
+	})
+
+	It("should dereference pointer values", func() {
+		engine.GET("/", func(c *gin.Context) {
+			sessions.Default(c).Set("cart", &cart{Items: []string{"pear"}})
+			value, ok := Get[cart](c, "cart")
+			Expect(ok).To(BeTrue())
+			Expect(value.Items).To(ConsistOf("pear"))
+		})
+		Expect(serve("/", nil).Code).To(Equal(http.StatusOK))
+	})
+
+	It("should report missing and mistyped values", func() {
+		engine.GET("/", func(c *gin.Context) {
+			sessions.Default(c).Set("count", "not-a-number")
+			_, ok := Get[int](c, "count")
+			Expect(ok).To(BeFalse())
+			_, ok = Get[int](c, "missing")
+			Expect(ok).To(BeFalse())
+		})
+		Expect(serve("/", nil).Code).To(Equal(http.StatusOK))
+	})
+
+	It("should delete values", func() {
+		engine.GET("/set", func(c *gin.Context) {
+			Expect(Set(c, "count", 1)).To(Succeed())
+		})
+		engine.GET("/delete", func(c *gin.Context) {
+			Expect(Delete(c, "count")).To(Succeed())
+		})
+		engine.GET("/get", func(c *gin.Context) {
+			_, ok := Get[int](c, "count")
+			Expect(ok).To(BeFalse())
+		})
+		Expect(serve("/get", serve("/delete", serve("/set", nil))).Code).To(Equal(http.StatusOK))
+	})
+
+	It("should register types only once", func() {
+		Expect(func() {
+			Register(cart{})
+			Register(&cart{})
+			Register(nil)
+		}).NotTo(Panic())
+	})
+
+	Context("User", func() {
+		It("should return the stored user", func() {
+			engine.GET("/set", func(c *gin.Context) {
+				Expect(Set(c, UserKey, &UserObject{Id: "user@example.com", User: goth.User{Email: "user@example.com"}})).To(Succeed())
+			})
+			engine.GET("/get", func(c *gin.Context) {
+				c.String(http.StatusOK, MustUser(c).Id)
+			})
+
+			w := serve("/get", serve("/set", nil))
+			Expect(w.Body.String()).To(Equal("user@example.com"))
+		})
+
+		It("should panic in MustUser when there is no user", func() {
+			engine.GET("/", func(c *gin.Context) {
+				sessions.Default(c).Set(UserKey, "malformed")
+				_, ok := User(c)
+				Expect(ok).To(BeFalse())
+				Expect(func() { MustUser(c) }).To(PanicWith(ErrNoUser))
+			})
+			Expect(serve("/", nil).Code).To(Equal(http.StatusOK))
+		})
+
+		It("should store the user under the key of the request", func() {
+			engine.GET("/", func(c *gin.Context) {
+				Expect(CurrentUserKey(c)).To(Equal(UserKey))
+				SetUserKey(c, "account")
+				Expect(SetUser(c, &UserObject{Id: "user@example.com"})).To(Succeed())
+				Expect(sessions.Default(c).Get(UserKey)).To(BeNil())
+				Expect(sessions.Default(c).Get("account")).NotTo(BeNil())
+				Expect(MustUser(c).Id).To(Equal("user@example.com"))
+				Expect(CurrentUserKey(c)).To(Equal("account"))
+			})
+			engine.GET("/other", func(c *gin.Context) {
+				Expect(CurrentUserKey(c)).To(Equal(UserKey))
+				SetUserKey(c, "")
+				Expect(CurrentUserKey(c)).To(Equal(UserKey))
+			})
+			Expect(serve("/", nil).Code).To(Equal(http.StatusOK))
+			Expect(serve("/other", nil).Code).To(Equal(http.StatusOK))
+		})
+	})
+})
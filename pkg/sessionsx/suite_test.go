@@ -0,0 +1,13 @@
+package sessionsx
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+func TestSessionsx(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Sessionsx Suite")
+}
@@ -0,0 +1,282 @@
+package signing
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"encoding/base64"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/animalet/sargantana-go/pkg/config/secrets"
+	"github.com/go-jose/go-jose/v4"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/pkg/errors"
+)
+
+// minSecretLength is the minimum length of the secrets of HMAC keys, in bytes
+const minSecretLength = 32
+
+var (
+	keyID = regexp.MustCompile(`^[a-zA-Z0-9_-]+$`)
+
+	// ErrNoSigningKey is returned when no key of a ring is valid for signing at the time
+	ErrNoSigningKey = errors.New("no signing key is valid")
+	// ErrInvalidSignature is returned by Verify for signatures not made by a valid key of the ring
+	ErrInvalidSignature = errors.New("invalid signature")
+)
+
+// KeyConfig configures a key of a ring.
+type KeyConfig struct {
+	// ID identifies the key in the kid header of JWTs and in the signatures, e.g. 2024-06.
+	ID string `yaml:"id"`
+	// Algorithm is HS256, HS384, HS512, RS256, RS384, RS512, PS256, PS384, PS512, ES256, ES384,
+	// ES512 or EdDSA.
+	Algorithm string `yaml:"algorithm"`
+	// Secret locates the key material in the secrets subsystem, e.g. vault:jwt-2024-06 or
+	// file:jwt-2024-06.pem: the secret of HMAC keys, of at least 32 bytes, or the PEM private key
+	// of the other algorithms. It is resolved again on each refresh.
+	Secret string `yaml:"secret"`
+	// NotBefore is the time from which the key signs, replacing the previous keys. Optional.
+	NotBefore time.Time `yaml:"not_before,omitempty"`
+	// NotAfter is the time from which the key neither signs nor verifies. Optional.
+	NotAfter time.Time `yaml:"not_after,omitempty"`
+}
+
+func (c KeyConfig) Validate() error {
+	if !keyID.MatchString(c.ID) {
+		return errors.Errorf("invalid key id %q, expected letters, digits, '_' and '-'", c.ID)
+	}
+	if jwt.GetSigningMethod(c.Algorithm) == nil || c.Algorithm == jwt.SigningMethodNone.Alg() {
+		return errors.Errorf("unsupported algorithm %q", c.Algorithm)
+	}
+	if c.Secret == "" {
+		return errors.New("secret must be set and non-empty")
+	}
+	if !c.NotAfter.IsZero() && !c.NotAfter.After(c.NotBefore) {
+		return errors.New("not_after must be after not_before")
+	}
+	return nil
+}
+
+// key is a key of a ring with its parsed material
+type key struct {
+	config    KeyConfig
+	method    jwt.SigningMethod
+	signing   any // []byte for HMAC, a crypto.Signer otherwise
+	verifying any // []byte for HMAC, the public key otherwise
+}
+
+// signs tells whether the key signs at the time
+func (k *key) signs(now time.Time) bool {
+	return !now.Before(k.config.NotBefore) && k.verifies(now)
+}
+
+// verifies tells whether the key verifies at the time
+func (k *key) verifies(now time.Time) bool {
+	return k.config.NotAfter.IsZero() || now.Before(k.config.NotAfter)
+}
+
+// KeyRing holds the keys of a ring. The signing key is the valid key with the latest not_before,
+// while every valid key verifies, so that the signatures of the previous keys remain valid during
+// a rotation. Safe for concurrent use.
+type KeyRing struct {
+	configs []KeyConfig
+	now     func() time.Time
+
+	mu   sync.RWMutex
+	keys []*key
+}
+
+// NewKeyRing creates a ring of keys, resolving their material from the secrets subsystem.
+func NewKeyRing(configs []KeyConfig) (*KeyRing, error) {
+	if len(configs) == 0 {
+		return nil, errors.New("at least one key must be configured")
+	}
+	seen := make(map[string]bool, len(configs))
+	for i, c := range configs {
+		if err := c.Validate(); err != nil {
+			return nil, errors.Wrapf(err, "invalid key at index %d", i)
+		}
+		if seen[c.ID] {
+			return nil, errors.Errorf("key id %q is used more than once", c.ID)
+		}
+		seen[c.ID] = true
+	}
+	r := &KeyRing{configs: slices.Clone(configs), now: time.Now}
+	return r, r.Refresh()
+}
+
+// Refresh resolves the material of the keys again, picking up the secrets rotated in place. The
+// previous keys are kept if any key fails to load.
+func (r *KeyRing) Refresh() error {
+	keys := make([]*key, 0, len(r.configs))
+	for _, c := range r.configs {
+		k, err := loadKey(c)
+		if err != nil {
+			return errors.Wrapf(err, "failed to load key %q", c.ID)
+		}
+		keys = append(keys, k)
+	}
+	r.mu.Lock()
+	r.keys = keys
+	r.mu.Unlock()
+	return nil
+}
+
+func loadKey(c KeyConfig) (*key, error) {
+	material, err := secrets.Resolve(c.Secret)
+	if err != nil {
+		return nil, err
+	}
+	k := &key{config: c, method: jwt.GetSigningMethod(c.Algorithm)}
+	var private crypto.Signer
+	switch k.method.(type) {
+	case *jwt.SigningMethodHMAC:
+		if len(material) < minSecretLength {
+			return nil, errors.Errorf("HMAC secrets must have at least %d bytes", minSecretLength)
+		}
+		k.signing, k.verifying = []byte(material), []byte(material)
+		return k, nil
+	case *jwt.SigningMethodRSA, *jwt.SigningMethodRSAPSS:
+		private, err = jwt.ParseRSAPrivateKeyFromPEM([]byte(material))
+	case *jwt.SigningMethodECDSA:
+		private, err = jwt.ParseECPrivateKeyFromPEM([]byte(material))
+	case *jwt.SigningMethodEd25519:
+		var parsed crypto.PrivateKey
+		if parsed, err = jwt.ParseEdPrivateKeyFromPEM([]byte(material)); err == nil {
+			private = parsed.(ed25519.PrivateKey)
+		}
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid private key for %s", c.Algorithm)
+	}
+	if ecKey, ok := private.(*ecdsa.PrivateKey); ok && ecKey.Curve.Params().BitSize != k.method.(*jwt.SigningMethodECDSA).CurveBits {
+		return nil, errors.Errorf("the curve of the key does not match %s", c.Algorithm)
+	}
+	k.signing, k.verifying = private, private.Public()
+	return k, nil
+}
+
+// signingKey returns the valid key with the latest not_before
+func (r *KeyRing) signingKey() (*key, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	now := r.now()
+	var latest *key
+	for _, k := range r.keys {
+		if k.signs(now) && (latest == nil || k.config.NotBefore.After(latest.config.NotBefore)) {
+			latest = k
+		}
+	}
+	if latest == nil {
+		return nil, ErrNoSigningKey
+	}
+	return latest, nil
+}
+
+// verifyingKey returns the valid key with the ID
+func (r *KeyRing) verifyingKey(id string) (*key, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	now := r.now()
+	for _, k := range r.keys {
+		if k.config.ID == id && k.verifies(now) {
+			return k, true
+		}
+	}
+	return nil, false
+}
+
+// SigningKeyID returns the ID of the key signing at the moment.
+func (r *KeyRing) SigningKeyID() (string, error) {
+	k, err := r.signingKey()
+	if err != nil {
+		return "", err
+	}
+	return k.config.ID, nil
+}
+
+// SignJWT signs a token with the signing key, identified in its kid header.
+func (r *KeyRing) SignJWT(claims jwt.Claims) (string, error) {
+	k, err := r.signingKey()
+	if err != nil {
+		return "", err
+	}
+	token := jwt.NewWithClaims(k.method, claims)
+	token.Header["kid"] = k.config.ID
+	signed, err := token.SignedString(k.signing)
+	return signed, errors.Wrap(err, "failed to sign token")
+}
+
+// ParseJWT parses and validates a token signed by a valid key of the ring, selected by its kid
+// header, into claims.
+func (r *KeyRing) ParseJWT(token string, claims jwt.Claims, options ...jwt.ParserOption) (*jwt.Token, error) {
+	return jwt.ParseWithClaims(token, claims, func(token *jwt.Token) (any, error) {
+		id, _ := token.Header["kid"].(string)
+		k, ok := r.verifyingKey(id)
+		if !ok {
+			return nil, errors.Errorf("unknown key %q", id)
+		}
+		if token.Method.Alg() != k.method.Alg() {
+			return nil, errors.Errorf("unexpected algorithm %q for key %q", token.Method.Alg(), id)
+		}
+		return k.verifying, nil
+	}, options...)
+}
+
+// Sign signs data with the signing key, e.g. the query of a signed URL or a CSRF token. The
+// signature is URL safe and carries the ID of the key: {kid}.{base64url signature}.
+func (r *KeyRing) Sign(data []byte) (string, error) {
+	k, err := r.signingKey()
+	if err != nil {
+		return "", err
+	}
+	signature, err := k.method.Sign(string(data), k.signing)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to sign")
+	}
+	return k.config.ID + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+// Verify checks a signature made by Sign with a valid key of the ring, or returns
+// ErrInvalidSignature.
+func (r *KeyRing) Verify(data []byte, signature string) error {
+	id, encoded, found := strings.Cut(signature, ".")
+	if !found {
+		return ErrInvalidSignature
+	}
+	k, ok := r.verifyingKey(id)
+	if !ok {
+		return ErrInvalidSignature
+	}
+	decoded, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil || k.method.Verify(string(data), decoded, k.verifying) != nil {
+		return ErrInvalidSignature
+	}
+	return nil
+}
+
+// JWKS returns the public keys of the asymmetric keys of the ring that are not expired, including
+// the keys whose not_before is in the future, so that verifiers know them before their first use.
+// The secrets of HMAC keys are never published.
+func (r *KeyRing) JWKS() jose.JSONWebKeySet {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	now := r.now()
+	set := jose.JSONWebKeySet{Keys: make([]jose.JSONWebKey, 0, len(r.keys))}
+	for _, k := range r.keys {
+		if !k.verifies(now) {
+			continue
+		}
+		switch public := k.verifying.(type) {
+		case *rsa.PublicKey, *ecdsa.PublicKey, ed25519.PublicKey:
+			set.Keys = append(set.Keys, jose.JSONWebKey{Key: public, KeyID: k.config.ID, Algorithm: k.config.Algorithm, Use: "sig"})
+		}
+	}
+	return set
+}
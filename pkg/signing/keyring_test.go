@@ -0,0 +1,186 @@
+//go:build unit
+
+package signing
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"strings"
+	"time"
+
+	"github.com/animalet/sargantana-go/pkg/config/secrets"
+	"github.com/golang-jwt/jwt/v5"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/pkg/errors"
+)
+
+// mapLoader resolves the secrets of the tests from a map
+type mapLoader map[string]string
+
+func (m mapLoader) Resolve(key string) (string, error) {
+	if value, ok := m[key]; ok {
+		return value, nil
+	}
+	return "", errors.Errorf("secret %q not found", key)
+}
+
+// privateKeyPEM encodes a private key in PKCS #8 PEM
+func privateKeyPEM(key any) string {
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	Expect(err).NotTo(HaveOccurred())
+	return string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}))
+}
+
+var _ = Describe("KeyRing", func() {
+	var (
+		loader mapLoader
+		now    time.Time
+	)
+
+	BeforeEach(func() {
+		ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		Expect(err).NotTo(HaveOccurred())
+		_, edKey, err := ed25519.GenerateKey(rand.Reader)
+		Expect(err).NotTo(HaveOccurred())
+		loader = mapLoader{
+			"hmac":  strings.Repeat("s", minSecretLength),
+			"short": "secret",
+			"ec":    privateKeyPEM(ecKey),
+			"ed":    privateKeyPEM(edKey),
+		}
+		secrets.Register("signingtest", loader)
+		now = time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	})
+
+	newRing := func(configs ...KeyConfig) *KeyRing {
+		ring, err := NewKeyRing(configs)
+		Expect(err).NotTo(HaveOccurred())
+		ring.now = func() time.Time { return now }
+		return ring
+	}
+
+	It("should validate the keys", func() {
+		Expect(KeyConfig{ID: "k1", Algorithm: "HS256", Secret: "signingtest:hmac"}.Validate()).To(Succeed())
+		Expect(KeyConfig{ID: "k 1", Algorithm: "HS256", Secret: "signingtest:hmac"}.Validate()).To(MatchError(ContainSubstring("invalid key id")))
+		Expect(KeyConfig{ID: "k1", Algorithm: "none", Secret: "signingtest:hmac"}.Validate()).To(MatchError(ContainSubstring("unsupported algorithm")))
+		Expect(KeyConfig{ID: "k1", Algorithm: "HS256"}.Validate()).To(MatchError(ContainSubstring("secret")))
+		Expect(KeyConfig{ID: "k1", Algorithm: "HS256", Secret: "signingtest:hmac", NotBefore: now, NotAfter: now}.Validate()).
+			To(MatchError(ContainSubstring("not_after")))
+
+		_, err := NewKeyRing([]KeyConfig{{ID: "k1", Algorithm: "HS256", Secret: "signingtest:hmac"}, {ID: "k1", Algorithm: "ES256", Secret: "signingtest:ec"}})
+		Expect(err).To(MatchError(ContainSubstring("more than once")))
+		_, err = NewKeyRing([]KeyConfig{{ID: "k1", Algorithm: "HS256", Secret: "signingtest:short"}})
+		Expect(err).To(MatchError(ContainSubstring("at least 32 bytes")))
+		_, err = NewKeyRing([]KeyConfig{{ID: "k1", Algorithm: "ES384", Secret: "signingtest:ec"}})
+		Expect(err).To(MatchError(ContainSubstring("curve")))
+		_, err = NewKeyRing([]KeyConfig{{ID: "k1", Algorithm: "RS256", Secret: "signingtest:ec"}})
+		Expect(err).To(MatchError(ContainSubstring("invalid private key")))
+	})
+
+	It("should rotate the signing key on the not_before of the next key", func() {
+		ring := newRing(
+			KeyConfig{ID: "old", Algorithm: "ES256", Secret: "signingtest:ec", NotAfter: now.Add(48 * time.Hour)},
+			KeyConfig{ID: "new", Algorithm: "EdDSA", Secret: "signingtest:ed", NotBefore: now.Add(24 * time.Hour)},
+		)
+		Expect(ring.SigningKeyID()).To(Equal("old"))
+		oldToken, err := ring.SignJWT(jwt.MapClaims{"sub": "alice"})
+		Expect(err).NotTo(HaveOccurred())
+
+		now = now.Add(25 * time.Hour)
+		Expect(ring.SigningKeyID()).To(Equal("new"))
+		newToken, err := ring.SignJWT(jwt.MapClaims{"sub": "alice"})
+		Expect(err).NotTo(HaveOccurred())
+
+		// The tokens of the previous key remain valid until its not_after
+		for _, token := range []string{oldToken, newToken} {
+			parsed, err := ring.ParseJWT(token, jwt.MapClaims{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(parsed.Claims.GetSubject()).To(Equal("alice"))
+		}
+		now = now.Add(24 * time.Hour)
+		_, err = ring.ParseJWT(oldToken, jwt.MapClaims{})
+		Expect(err).To(MatchError(ContainSubstring(`unknown key "old"`)))
+		_, err = ring.ParseJWT(newToken, jwt.MapClaims{})
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	It("should fail without valid signing key", func() {
+		ring := newRing(KeyConfig{ID: "k1", Algorithm: "HS256", Secret: "signingtest:hmac", NotBefore: now.Add(time.Hour)})
+		_, err := ring.SignJWT(jwt.MapClaims{})
+		Expect(err).To(MatchError(ErrNoSigningKey))
+		_, err = ring.Sign([]byte("data"))
+		Expect(err).To(MatchError(ErrNoSigningKey))
+	})
+
+	It("should reject the tokens of unknown keys or algorithms", func() {
+		ring := newRing(KeyConfig{ID: "k1", Algorithm: "HS256", Secret: "signingtest:hmac"})
+		other := newRing(KeyConfig{ID: "k2", Algorithm: "HS256", Secret: "signingtest:hmac"})
+		token, err := other.SignJWT(jwt.MapClaims{})
+		Expect(err).NotTo(HaveOccurred())
+		_, err = ring.ParseJWT(token, jwt.MapClaims{})
+		Expect(err).To(MatchError(ContainSubstring(`unknown key "k2"`)))
+
+		forged := jwt.NewWithClaims(jwt.SigningMethodHS384, jwt.MapClaims{})
+		forged.Header["kid"] = "k1"
+		token, err = forged.SignedString([]byte(loader["hmac"]))
+		Expect(err).NotTo(HaveOccurred())
+		_, err = ring.ParseJWT(token, jwt.MapClaims{})
+		Expect(err).To(MatchError(ContainSubstring("unexpected algorithm")))
+	})
+
+	It("should sign and verify data", func() {
+		ring := newRing(
+			KeyConfig{ID: "k1", Algorithm: "HS256", Secret: "signingtest:hmac"},
+			KeyConfig{ID: "k2", Algorithm: "ES256", Secret: "signingtest:ec", NotBefore: now.Add(time.Hour)},
+		)
+		signature, err := ring.Sign([]byte("/files/report.pdf?expires=1717200000"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(signature).To(HavePrefix("k1."))
+		Expect(ring.Verify([]byte("/files/report.pdf?expires=1717200000"), signature)).To(Succeed())
+		Expect(ring.Verify([]byte("/files/other.pdf?expires=1717200000"), signature)).To(MatchError(ErrInvalidSignature))
+		Expect(ring.Verify([]byte("/files/report.pdf?expires=1717200000"), "k2"+strings.TrimPrefix(signature, "k1"))).To(MatchError(ErrInvalidSignature))
+		Expect(ring.Verify([]byte("data"), "garbage")).To(MatchError(ErrInvalidSignature))
+
+		now = now.Add(time.Hour)
+		signature, err = ring.Sign([]byte("data"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(signature).To(HavePrefix("k2."))
+		Expect(ring.Verify([]byte("data"), signature)).To(Succeed())
+	})
+
+	It("should publish the public keys that are not expired", func() {
+		ring := newRing(
+			KeyConfig{ID: "hmac", Algorithm: "HS256", Secret: "signingtest:hmac"},
+			KeyConfig{ID: "expired", Algorithm: "ES256", Secret: "signingtest:ec", NotAfter: now},
+			KeyConfig{ID: "current", Algorithm: "ES256", Secret: "signingtest:ec"},
+			KeyConfig{ID: "next", Algorithm: "EdDSA", Secret: "signingtest:ed", NotBefore: now.Add(time.Hour)},
+		)
+		set := ring.JWKS()
+		Expect(set.Keys).To(HaveLen(2))
+		Expect(set.Keys[0].KeyID).To(Equal("current"))
+		Expect(set.Keys[0].Algorithm).To(Equal("ES256"))
+		Expect(set.Keys[0].Use).To(Equal("sig"))
+		Expect(set.Keys[0].IsPublic()).To(BeTrue())
+		Expect(set.Keys[1].KeyID).To(Equal("next"))
+		Expect(set.Keys[1].Key).To(BeAssignableToTypeOf(ed25519.PublicKey{}))
+	})
+
+	It("should pick up the rotated secrets and keep the keys on refresh failures", func() {
+		ring := newRing(KeyConfig{ID: "k1", Algorithm: "HS256", Secret: "signingtest:hmac"})
+		signature, err := ring.Sign([]byte("data"))
+		Expect(err).NotTo(HaveOccurred())
+
+		delete(loader, "hmac")
+		Expect(ring.Refresh()).To(MatchError(ContainSubstring(`failed to load key "k1"`)))
+		Expect(ring.Verify([]byte("data"), signature)).To(Succeed())
+
+		loader["hmac"] = strings.Repeat("t", minSecretLength)
+		Expect(ring.Refresh()).To(Succeed())
+		Expect(ring.Verify([]byte("data"), signature)).To(MatchError(ErrInvalidSignature))
+	})
+})
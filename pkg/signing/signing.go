@@ -0,0 +1,156 @@
+// Package signing manages the keys signing the tokens issued by the server, such as JWTs, signed
+// URLs or CSRF tokens. Keys are grouped in named rings, one per use, and their material is loaded
+// from the secrets subsystem, e.g. Vault, and refreshed periodically. Each ring may hold several
+// keys identified by their kid, whose validity periods schedule their rotation:
+//
+//	manager, err := signing.NewManager(cfg)
+//	defer manager.Close()
+//	ring, _ := signing.Lookup("jwt")
+//	token, err := ring.SignJWT(jwt.MapClaims{"sub": "alice", "exp": time.Now().Add(time.Hour).Unix()})
+//
+// The public keys of the asymmetric keys are published by the jwks controller for downstream
+// verifiers.
+package signing
+
+import (
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+)
+
+const defaultRefreshInterval = 5 * time.Minute
+
+// Config is the signing_keys configuration section.
+type Config struct {
+	// Rings holds the keys of each ring by name, e.g. jwt, urls or csrf, see Lookup.
+	Rings map[string][]KeyConfig `yaml:"rings"`
+	// RefreshInterval is the period of the reload of the key material from the secrets. Defaults to
+	// 5m.
+	RefreshInterval time.Duration `yaml:"refresh_interval,omitempty"`
+}
+
+func (c Config) Validate() error {
+	if len(c.Rings) == 0 {
+		return errors.New("at least one ring must be configured")
+	}
+	for name, keys := range c.Rings {
+		if name == "" {
+			return errors.New("ring names must be non-empty")
+		}
+		if len(keys) == 0 {
+			return errors.Errorf("ring %q has no keys", name)
+		}
+		for i, key := range keys {
+			if err := key.Validate(); err != nil {
+				return errors.Wrapf(err, "invalid key at index %d of ring %q", i, name)
+			}
+		}
+	}
+	if c.RefreshInterval < 0 {
+		return errors.New("refresh_interval must be non-negative")
+	}
+	return nil
+}
+
+var (
+	ringsMu sync.RWMutex
+	rings   = make(map[string]*KeyRing)
+)
+
+// Register makes a ring available to Lookup under the name, replacing any previous ring with the
+// same name. Rings created by NewManager are registered automatically.
+func Register(name string, ring *KeyRing) {
+	ringsMu.Lock()
+	defer ringsMu.Unlock()
+	rings[name] = ring
+}
+
+// Lookup returns the ring registered under the name.
+func Lookup(name string) (*KeyRing, bool) {
+	ringsMu.RLock()
+	defer ringsMu.RUnlock()
+	ring, ok := rings[name]
+	return ring, ok
+}
+
+// Names returns the sorted names of the registered rings.
+func Names() []string {
+	ringsMu.RLock()
+	defer ringsMu.RUnlock()
+	names := make([]string, 0, len(rings))
+	for name := range rings {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// Manager owns the rings of a configuration and refreshes their keys.
+type Manager struct {
+	rings    map[string]*KeyRing
+	interval time.Duration
+
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+// NewManager creates and registers the rings of the configuration, failing if any key cannot be
+// loaded, and refreshes their keys every refresh interval until Close.
+func NewManager(c Config) (*Manager, error) {
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	m := &Manager{rings: make(map[string]*KeyRing, len(c.Rings)), interval: c.RefreshInterval, stop: make(chan struct{})}
+	if m.interval == 0 {
+		m.interval = defaultRefreshInterval
+	}
+	for name, keys := range c.Rings {
+		ring, err := NewKeyRing(keys)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid ring %q", name)
+		}
+		m.rings[name] = ring
+	}
+	for name, ring := range m.rings {
+		Register(name, ring)
+		log.Info().Str("ring", name).Int("keys", len(ring.configs)).Msg("Signing keys loaded")
+	}
+	m.start()
+	return m, nil
+}
+
+func (m *Manager) start() {
+	m.wg.Add(1)
+	go func() {
+		defer m.wg.Done()
+		ticker := time.NewTicker(m.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				m.refresh()
+			case <-m.stop:
+				return
+			}
+		}
+	}()
+}
+
+// refresh reloads the keys of every ring, keeping the previous keys of the rings failing to load
+func (m *Manager) refresh() {
+	for name, ring := range m.rings {
+		if err := ring.Refresh(); err != nil {
+			log.Error().Err(err).Str("ring", name).Msg("Failed to refresh signing keys, keeping the previous ones")
+		}
+	}
+}
+
+// Close stops the refresh of the keys. The rings remain registered and usable.
+func (m *Manager) Close() error {
+	close(m.stop)
+	m.wg.Wait()
+	return nil
+}
@@ -0,0 +1,72 @@
+//go:build unit
+
+package signing
+
+import (
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/animalet/sargantana-go/pkg/config/secrets"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+// rotatingLoader resolves every key to its current secret, rotated concurrently by the tests
+type rotatingLoader struct {
+	secret atomic.Pointer[string]
+}
+
+func (l *rotatingLoader) Resolve(string) (string, error) {
+	return *l.secret.Load(), nil
+}
+
+func (l *rotatingLoader) rotate(secret string) {
+	l.secret.Store(&secret)
+}
+
+var _ = Describe("Manager", func() {
+	BeforeEach(func() {
+		secrets.Register("signingtest", mapLoader{"hmac": strings.Repeat("s", minSecretLength)})
+	})
+
+	It("should validate the configuration", func() {
+		key := KeyConfig{ID: "k1", Algorithm: "HS256", Secret: "signingtest:hmac"}
+		Expect(Config{}.Validate()).To(MatchError(ContainSubstring("at least one ring")))
+		Expect(Config{Rings: map[string][]KeyConfig{"jwt": nil}}.Validate()).To(MatchError(ContainSubstring("has no keys")))
+		Expect(Config{Rings: map[string][]KeyConfig{"jwt": {{ID: "k1"}}}}.Validate()).To(MatchError(ContainSubstring(`ring "jwt"`)))
+		Expect(Config{Rings: map[string][]KeyConfig{"jwt": {key}}, RefreshInterval: -time.Second}.Validate()).To(HaveOccurred())
+		Expect(Config{Rings: map[string][]KeyConfig{"jwt": {key}}}.Validate()).To(Succeed())
+	})
+
+	It("should register the rings and refresh their keys", func() {
+		loader := &rotatingLoader{}
+		loader.rotate(strings.Repeat("s", minSecretLength))
+		secrets.Register("rotatingtest", loader)
+		manager, err := NewManager(Config{
+			Rings: map[string][]KeyConfig{
+				"jwt":  {{ID: "k1", Algorithm: "HS256", Secret: "rotatingtest:jwt"}},
+				"csrf": {{ID: "k2", Algorithm: "HS256", Secret: "rotatingtest:csrf"}},
+			},
+			RefreshInterval: 10 * time.Millisecond,
+		})
+		Expect(err).NotTo(HaveOccurred())
+		DeferCleanup(manager.Close)
+
+		Expect(Names()).To(ContainElements("csrf", "jwt"))
+		ring, ok := Lookup("jwt")
+		Expect(ok).To(BeTrue())
+		signature, err := ring.Sign([]byte("data"))
+		Expect(err).NotTo(HaveOccurred())
+
+		loader.rotate(strings.Repeat("t", minSecretLength))
+		Eventually(func() error { return ring.Verify([]byte("data"), signature) }).Should(MatchError(ErrInvalidSignature))
+		_, ok = Lookup("unknown")
+		Expect(ok).To(BeFalse())
+	})
+
+	It("should fail when a key cannot be loaded", func() {
+		_, err := NewManager(Config{Rings: map[string][]KeyConfig{"jwt": {{ID: "k1", Algorithm: "HS256", Secret: "signingtest:missing"}}}})
+		Expect(err).To(MatchError(ContainSubstring(`invalid ring "jwt"`)))
+	})
+})
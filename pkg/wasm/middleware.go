@@ -0,0 +1,207 @@
+package wasm
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/animalet/sargantana-go/pkg/server"
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+)
+
+// Actions of the on_request hook.
+const (
+	ActionContinue = "continue"
+	ActionRespond  = "respond"
+)
+
+// Request is the input of the on_request hook.
+type Request struct {
+	Method     string      `json:"method"`
+	Path       string      `json:"path"`
+	Query      string      `json:"query,omitempty"`
+	Headers    http.Header `json:"headers"`
+	RemoteAddr string      `json:"remote_addr"`
+	// User and Roles are set by the authenticators of the binding, see server.GetRemoteUser.
+	User  string   `json:"user,omitempty"`
+	Roles []string `json:"roles,omitempty"`
+}
+
+// Action is the output of the on_request hook.
+type Action struct {
+	// Action is "continue" to let the request continue, the default, or "respond" to answer it
+	// with Status and Body without calling the handlers of the binding.
+	Action string `json:"action,omitempty"`
+	// Status is the status of the response, with the respond action. Defaults to 403.
+	Status int    `json:"status,omitempty"`
+	Body   string `json:"body,omitempty"`
+	// SetHeaders and RemoveHeaders change the headers of the request, with the continue action.
+	SetHeaders    map[string]string `json:"set_headers,omitempty"`
+	RemoveHeaders []string          `json:"remove_headers,omitempty"`
+	// ResponseHeaders are set on the response, with both actions.
+	ResponseHeaders map[string]string `json:"response_headers,omitempty"`
+}
+
+// Response is the input of the on_response hook.
+type Response struct {
+	Status  int         `json:"status"`
+	Headers http.Header `json:"headers"`
+}
+
+// HeaderChanges is the output of the on_response hook, changing the headers of the response.
+type HeaderChanges struct {
+	SetHeaders    map[string]string `json:"set_headers,omitempty"`
+	RemoveHeaders []string          `json:"remove_headers,omitempty"`
+}
+
+// NewMiddleware creates the middleware of the wasm middleware type, compiling the module.
+func NewMiddleware(c Config) (gin.HandlerFunc, error) {
+	module, err := newHookModule(c)
+	if err != nil {
+		return nil, err
+	}
+	m := &middleware{module: module, failOpen: c.FailOpen}
+	return m.handle, nil
+}
+
+type middleware struct {
+	module   *hookModule
+	failOpen bool
+}
+
+func (m *middleware) handle(c *gin.Context) {
+	action, err := m.onRequest(c)
+	if err != nil {
+		logger := server.RequestLogger(c)
+		if m.failOpen {
+			logger.Warn().Err(err).Str("module", m.module.path).Msg("WASM request hook failed, continuing")
+			c.Next()
+			return
+		}
+		logger.Error().Err(err).Str("module", m.module.path).Msg("WASM request hook failed")
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	for name, value := range action.ResponseHeaders {
+		c.Header(name, value)
+	}
+	if action.Action == ActionRespond {
+		status := action.Status
+		if status == 0 {
+			status = http.StatusForbidden
+		}
+		c.Abort()
+		c.String(status, action.Body)
+		return
+	}
+	for _, name := range action.RemoveHeaders {
+		c.Request.Header.Del(name)
+	}
+	for name, value := range action.SetHeaders {
+		c.Request.Header.Set(name, value)
+	}
+
+	if !m.module.onResponse {
+		c.Next()
+		return
+	}
+	writer := &hookWriter{ResponseWriter: c.Writer, middleware: m, c: c}
+	c.Writer = writer
+	defer func() {
+		c.Writer = writer.ResponseWriter
+	}()
+	c.Next()
+	// Responses without body are written after the middlewares
+	writer.hook()
+}
+
+// onRequest calls the on_request hook with the request
+func (m *middleware) onRequest(c *gin.Context) (Action, error) {
+	user, _ := server.GetRemoteUser(c)
+	input, err := json.Marshal(Request{
+		Method:     c.Request.Method,
+		Path:       c.Request.URL.Path,
+		Query:      c.Request.URL.RawQuery,
+		Headers:    c.Request.Header,
+		RemoteAddr: c.ClientIP(),
+		User:       user,
+		Roles:      server.GetRoles(c),
+	})
+	if err != nil {
+		return Action{}, err
+	}
+	output, err := m.module.hook(c.Request.Context(), OnRequestExport, input)
+	if err != nil {
+		return Action{}, err
+	}
+	var action Action
+	if len(output) > 0 {
+		if err := json.Unmarshal(output, &action); err != nil {
+			return Action{}, errors.Wrap(err, "on_request returned an invalid action")
+		}
+	}
+	switch action.Action {
+	case "", ActionContinue, ActionRespond:
+	default:
+		return Action{}, errors.Errorf("on_request returned the unknown action %q", action.Action)
+	}
+	if action.Status != 0 && (action.Status < 100 || action.Status > 599) {
+		return Action{}, errors.Errorf("on_request returned the invalid status %d", action.Status)
+	}
+	return action, nil
+}
+
+// hookWriter calls the on_response hook before the headers of the response are written
+type hookWriter struct {
+	gin.ResponseWriter
+	middleware *middleware
+	c          *gin.Context
+	hooked     bool
+}
+
+func (w *hookWriter) hook() {
+	if w.hooked || w.ResponseWriter.Written() {
+		return
+	}
+	w.hooked = true
+	input, err := json.Marshal(Response{Status: w.Status(), Headers: w.Header()})
+	if err == nil {
+		var output []byte
+		if output, err = w.middleware.module.hook(w.c.Request.Context(), OnResponseExport, input); err == nil && len(output) > 0 {
+			var changes HeaderChanges
+			if err = json.Unmarshal(output, &changes); err == nil {
+				for _, name := range changes.RemoveHeaders {
+					w.Header().Del(name)
+				}
+				for name, value := range changes.SetHeaders {
+					w.Header().Set(name, value)
+				}
+			}
+		}
+	}
+	if err != nil {
+		logger := server.RequestLogger(w.c)
+		logger.Warn().Err(err).Str("module", w.middleware.module.path).Msg("WASM response hook failed, response unchanged")
+	}
+}
+
+func (w *hookWriter) WriteHeaderNow() {
+	w.hook()
+	w.ResponseWriter.WriteHeaderNow()
+}
+
+func (w *hookWriter) Write(b []byte) (int, error) {
+	w.hook()
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *hookWriter) WriteString(s string) (int, error) {
+	w.hook()
+	return w.ResponseWriter.WriteString(s)
+}
+
+func (w *hookWriter) Flush() {
+	w.hook()
+	w.ResponseWriter.Flush()
+}
@@ -0,0 +1,138 @@
+//go:build unit
+
+package wasm
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/animalet/sargantana-go/pkg/server"
+	"github.com/gin-gonic/gin"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("WASM middleware", func() {
+	// serve runs the middleware before a handler echoing the request headers
+	serve := func(c Config, path string, header http.Header) *httptest.ResponseRecorder {
+		middleware, err := NewMiddleware(c)
+		Expect(err).NotTo(HaveOccurred())
+		engine := gin.New()
+		engine.Use(func(c *gin.Context) {
+			c.Set(server.RemoteUserKey, "alice")
+			c.Set(server.RolesKey, []string{"admin", "billing"})
+		}, middleware)
+		engine.GET("/*path", func(c *gin.Context) {
+			c.Header("X-Internal", "secret")
+			c.JSON(http.StatusOK, c.Request.Header)
+		})
+		engine.HEAD("/empty", func(c *gin.Context) {
+			c.Status(http.StatusNoContent)
+		})
+		method := http.MethodGet
+		if path == "/empty" {
+			method = http.MethodHead
+		}
+		request := httptest.NewRequest(method, path, nil)
+		for name, values := range header {
+			request.Header[name] = values
+		}
+		recorder := httptest.NewRecorder()
+		engine.ServeHTTP(recorder, request)
+		return recorder
+	}
+
+	Context("Configuration", func() {
+		It("should validate the configuration", func() {
+			Expect(Config{Module: "hooks.wasm"}.Validate()).To(Succeed())
+			Expect(Config{}.Validate()).To(MatchError(ContainSubstring("module must be set")))
+			Expect(Config{Module: "hooks.wasm", Timeout: -1}.Validate()).To(MatchError(ContainSubstring("timeout")))
+			Expect(Config{Module: "hooks.wasm", MaxInstances: -1}.Validate()).To(MatchError(ContainSubstring("max_instances")))
+			Expect(Config{Module: "hooks.wasm", MaxMemory: -1}.Validate()).To(MatchError(ContainSubstring("max_memory must not be negative")))
+			Expect(Config{Module: "hooks.wasm", MaxMemory: 5 << 30}.Validate()).To(MatchError(ContainSubstring("max_memory must not exceed")))
+		})
+
+		It("should fail on missing and invalid modules", func() {
+			_, err := NewMiddleware(Config{Module: "testdata/missing.wasm"})
+			Expect(err).To(MatchError(ContainSubstring("failed to read wasm module testdata/missing.wasm")))
+
+			invalid := filepath.Join(GinkgoT().TempDir(), "invalid.wasm")
+			Expect(os.WriteFile(invalid, []byte("not wasm"), 0o600)).To(Succeed())
+			_, err = NewMiddleware(Config{Module: invalid})
+			Expect(err).To(MatchError(ContainSubstring("failed to compile wasm module")))
+		})
+	})
+
+	Context("Hooks", func() {
+		It("should fail when the module rejects its settings", func() {
+			_, err := NewMiddleware(Config{Module: hooksModule, Settings: map[string]any{"fail": "yes"}})
+			Expect(err).To(MatchError(ContainSubstring("configure returned 2")))
+		})
+
+		It("should rewrite the headers of the request and the response", func() {
+			recorder := serve(Config{Module: hooksModule, Settings: map[string]any{"greeting": "hola"}}, "/orders",
+				http.Header{"X-Secret": {"s3cr3t"}})
+			Expect(recorder.Code).To(Equal(http.StatusOK))
+			Expect(recorder.Body.String()).To(ContainSubstring(`"X-Greeting":["hola"]`))
+			Expect(recorder.Body.String()).To(ContainSubstring(`"X-User":["alice:admin,billing"]`))
+			Expect(recorder.Body.String()).NotTo(ContainSubstring("s3cr3t"))
+			Expect(recorder.Header().Get("X-Request-Hook")).To(Equal("done"))
+			Expect(recorder.Header().Get("X-Response-Hook")).To(Equal("OK"))
+			Expect(recorder.Header().Get("X-Internal")).To(BeEmpty())
+		})
+
+		It("should call the response hook for responses without body", func() {
+			recorder := serve(Config{Module: hooksModule}, "/empty", nil)
+			Expect(recorder.Code).To(Equal(http.StatusNoContent))
+			Expect(recorder.Header().Get("X-Response-Hook")).To(Equal("No Content"))
+		})
+
+		It("should answer the requests the module responds to", func() {
+			recorder := serve(Config{Module: hooksModule}, "/orders", http.Header{"X-Block": {"1"}})
+			Expect(recorder.Code).To(Equal(http.StatusUnauthorized))
+			Expect(recorder.Body.String()).To(Equal("blocked"))
+			Expect(recorder.Header().Get("X-Blocked-By")).To(Equal("hooks"))
+			Expect(recorder.Header().Get("X-Response-Hook")).To(BeEmpty())
+		})
+
+		It("should answer 500 when the request hook fails, unless failing open", func() {
+			for _, path := range []string{"/trap", "/invalid"} {
+				Expect(serve(Config{Module: hooksModule}, path, nil).Code).To(Equal(http.StatusInternalServerError))
+				Expect(serve(Config{Module: hooksModule, FailOpen: true}, path, nil).Code).To(Equal(http.StatusOK))
+			}
+		})
+
+		It("should stop the hooks exceeding their timeout", func() {
+			start := time.Now()
+			Expect(serve(Config{Module: hooksModule, Timeout: 50 * time.Millisecond}, "/loop", nil).Code).
+				To(Equal(http.StatusInternalServerError))
+			Expect(time.Since(start)).To(BeNumerically("<", 5*time.Second))
+		})
+
+		It("should serve concurrent requests with several instances", func() {
+			middleware, err := NewMiddleware(Config{Module: hooksModule, MaxInstances: 2})
+			Expect(err).NotTo(HaveOccurred())
+			engine := gin.New()
+			engine.Use(middleware)
+			engine.GET("/*path", func(c *gin.Context) {
+				c.String(http.StatusOK, c.GetHeader("X-User"))
+			})
+			var wg sync.WaitGroup
+			for range 8 {
+				wg.Add(1)
+				go func() {
+					defer GinkgoRecover()
+					defer wg.Done()
+					recorder := httptest.NewRecorder()
+					engine.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/orders", nil))
+					Expect(recorder.Code).To(Equal(http.StatusOK))
+				}()
+			}
+			wg.Wait()
+		})
+	})
+})
@@ -0,0 +1,31 @@
+package wasm
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+// hooksModule is the path of testdata/hooks, built in a temporary directory
+var hooksModule string
+
+func TestWasm(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "WASM Suite")
+}
+
+var _ = BeforeSuite(func() {
+	dir, err := os.MkdirTemp("", "wasm")
+	Expect(err).NotTo(HaveOccurred())
+	DeferCleanup(os.RemoveAll, dir)
+	hooksModule = filepath.Join(dir, "hooks.wasm")
+	build := exec.Command("go", "build", "-buildmode=c-shared", "-o", hooksModule, ".")
+	build.Dir = filepath.Join("testdata", "hooks")
+	build.Env = append(os.Environ(), "GOOS=wasip1", "GOARCH=wasm")
+	output, err := build.CombinedOutput()
+	Expect(err).NotTo(HaveOccurred(), string(output))
+})
@@ -0,0 +1,110 @@
+// Command hooks is the module of the wasm tests, built with:
+//
+//	GOOS=wasip1 GOARCH=wasm go build -buildmode=c-shared -o hooks.wasm .
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"unsafe"
+)
+
+var (
+	input    []byte
+	output   []byte
+	settings map[string]string
+)
+
+//go:wasmimport sargantana log
+func log(level, ptr, size uint32)
+
+//go:wasmexport alloc
+func alloc(size uint32) unsafe.Pointer {
+	input = make([]byte, size+1)
+	return unsafe.Pointer(&input[0])
+}
+
+//go:wasmexport configure
+func configure(ptr unsafe.Pointer, size uint32) uint32 {
+	if err := json.Unmarshal(unsafe.Slice((*byte)(ptr), size), &settings); err != nil {
+		return 1
+	}
+	if settings["fail"] != "" {
+		return 2
+	}
+	return 0
+}
+
+//go:wasmexport on_request
+func onRequest(ptr unsafe.Pointer, size uint32) uint64 {
+	var request struct {
+		Path    string      `json:"path"`
+		Headers http.Header `json:"headers"`
+		User    string      `json:"user"`
+		Roles   []string    `json:"roles"`
+	}
+	if err := json.Unmarshal(unsafe.Slice((*byte)(ptr), size), &request); err != nil {
+		panic(err)
+	}
+	switch request.Path {
+	case "/loop":
+		for {
+		}
+	case "/trap":
+		panic("trap")
+	case "/invalid":
+		return reply([]byte("{"))
+	case "/empty":
+		return 0
+	}
+	message := "request to " + request.Path
+	log(1, uint32(uintptr(unsafe.Pointer(unsafe.StringData(message)))), uint32(len(message)))
+	if request.Headers.Get("X-Block") != "" {
+		return encode(map[string]any{
+			"action":           "respond",
+			"status":           401,
+			"body":             "blocked",
+			"response_headers": map[string]string{"X-Blocked-By": "hooks"},
+		})
+	}
+	return encode(map[string]any{
+		"set_headers": map[string]string{
+			"X-Greeting": settings["greeting"],
+			"X-User":     request.User + ":" + strings.Join(request.Roles, ","),
+		},
+		"remove_headers":   []string{"X-Secret"},
+		"response_headers": map[string]string{"X-Request-Hook": "done"},
+	})
+}
+
+//go:wasmexport on_response
+func onResponse(ptr unsafe.Pointer, size uint32) uint64 {
+	var response struct {
+		Status  int         `json:"status"`
+		Headers http.Header `json:"headers"`
+	}
+	if err := json.Unmarshal(unsafe.Slice((*byte)(ptr), size), &response); err != nil {
+		panic(err)
+	}
+	return encode(map[string]any{
+		"set_headers":    map[string]string{"X-Response-Hook": http.StatusText(response.Status)},
+		"remove_headers": []string{"X-Internal"},
+	})
+}
+
+func encode(v any) uint64 {
+	encoded, err := json.Marshal(v)
+	if err != nil {
+		panic(err)
+	}
+	return reply(encoded)
+}
+
+// reply keeps the output until the next call and packs its address and length
+func reply(b []byte) uint64 {
+	output = b
+	return uint64(uintptr(unsafe.Pointer(&output[0])))<<32 | uint64(len(output))
+}
+
+func main() {}
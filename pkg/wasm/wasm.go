@@ -0,0 +1,309 @@
+// Package wasm runs user-provided WebAssembly modules as middlewares of controller bindings, to
+// add sandboxed custom logic, such as header rewrites or custom authorization checks, without
+// rebuilding the gateway. The modules run in the wazero runtime, without access to the network nor
+// the filesystem, bounded in memory and time.
+//
+// The support is experimental: the ABI between the gateway and the modules may change in minor
+// versions, see Config for its current version.
+package wasm
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"os"
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/api"
+	"github.com/tetratelabs/wazero/imports/wasi_snapshot_preview1"
+)
+
+// Exports and imports of the modules, see Config.
+const (
+	AllocExport      = "alloc"
+	ConfigureExport  = "configure"
+	OnRequestExport  = "on_request"
+	OnResponseExport = "on_response"
+	HostModule       = "sargantana"
+)
+
+const (
+	defaultTimeout   = 100 * time.Millisecond
+	defaultMaxMemory = 64 * 1024 * 1024
+	pageSize         = 64 * 1024
+)
+
+// Config configures the wasm middleware type, which calls the hooks of a WebAssembly module for the
+// requests of a controller binding. The module implements ABI version 1, exchanging JSON documents
+// through its linear memory:
+//
+//   - alloc(size i32) i32 returns the address of a buffer of size bytes, where the gateway writes
+//     the input of the next call. Required.
+//   - on_request(ptr i32, len i32) i64 is called with the request before the handlers of the
+//     binding, and returns the address and the length of its action, packed as address<<32|length,
+//     or 0 to let the request continue unchanged. Required.
+//   - on_response(ptr i32, len i32) i64 is called with the status and the headers of the response
+//     before they are written, and returns the header changes the same way. Optional.
+//   - configure(ptr i32, len i32) i32 is called with the settings once per instance, and returns 0
+//     on success. Optional.
+//
+// The buffers returned by the module must stay valid until its next call. The module may import
+// the WASI preview 1 functions, with the standard outputs discarded, and sargantana.log(level i32,
+// ptr i32, len i32), logging a message at debug (0), info (1), warn (2) or error (3) level. Several
+// instances of the module serve concurrent requests, so the hooks must not rely on the state of
+// previous calls.
+type Config struct {
+	// Module is the path of the WebAssembly module, e.g. a Go program built with GOOS=wasip1
+	// GOARCH=wasm -buildmode=c-shared, or a TinyGo or Rust module.
+	Module string `yaml:"module"`
+	// Settings are passed to the configure export of the module as a JSON object.
+	Settings map[string]any `yaml:"settings,omitempty"`
+	// Timeout bounds each call of a hook. Defaults to 100ms.
+	Timeout time.Duration `yaml:"timeout,omitempty"`
+	// MaxInstances is the number of instances of the module running hooks at the same time; the
+	// other requests wait for one. Defaults to GOMAXPROCS.
+	MaxInstances int `yaml:"max_instances,omitempty"`
+	// MaxMemory is the size in bytes of the largest memory of an instance, rounded up to 64 KiB
+	// pages. Defaults to 64 MiB.
+	MaxMemory int64 `yaml:"max_memory,omitempty"`
+	// FailOpen lets the requests continue when on_request fails or times out, instead of answering
+	// them with 500. Failures of on_response always leave the response unchanged.
+	FailOpen bool `yaml:"fail_open,omitempty"`
+}
+
+func (c Config) Validate() error {
+	if c.Module == "" {
+		return errors.New("module must be set and non-empty")
+	}
+	if c.Timeout < 0 {
+		return errors.New("timeout must not be negative")
+	}
+	if c.MaxInstances < 0 {
+		return errors.New("max_instances must not be negative")
+	}
+	if c.MaxMemory < 0 {
+		return errors.New("max_memory must not be negative")
+	}
+	if c.MaxMemory > 4*1024*1024*1024 {
+		return errors.New("max_memory must not exceed 4 GiB")
+	}
+	return nil
+}
+
+// hookModule is a compiled module with its idle instances
+type hookModule struct {
+	path        string
+	runtime     wazero.Runtime
+	compiled    wazero.CompiledModule
+	settings    []byte
+	onResponse  bool // Whether on_response is exported
+	configure   bool // Whether configure is exported
+	slots       chan struct{}
+	mu          sync.Mutex
+	idle        []*instance
+	timeout     time.Duration
+	logger      zerolog.Logger
+	initTimeout time.Duration
+}
+
+// instance is an instance of the module, running one hook at a time
+type instance struct {
+	module api.Module
+	alloc  api.Function
+}
+
+func newHookModule(c Config) (*hookModule, error) {
+	binary, err := os.ReadFile(c.Module)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read wasm module %s", c.Module)
+	}
+	settings := c.Settings
+	if settings == nil {
+		settings = map[string]any{}
+	}
+	encodedSettings, err := json.Marshal(settings)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to encode the settings of wasm module %s", c.Module)
+	}
+	maxMemory := c.MaxMemory
+	if maxMemory == 0 {
+		maxMemory = defaultMaxMemory
+	}
+	maxInstances := c.MaxInstances
+	if maxInstances == 0 {
+		maxInstances = runtime.GOMAXPROCS(0)
+	}
+	timeout := c.Timeout
+	if timeout == 0 {
+		timeout = defaultTimeout
+	}
+
+	ctx := context.Background()
+	m := &hookModule{
+		path:     c.Module,
+		settings: encodedSettings,
+		slots:    make(chan struct{}, maxInstances),
+		timeout:  timeout,
+		logger:   log.With().Str("module", c.Module).Logger(),
+		// Instantiations also initialize the runtime of the module, e.g. Go's, slower than the hooks
+		initTimeout: max(timeout, time.Second),
+	}
+	// #nosec G115 -- the memory is validated not to exceed 4 GiB
+	pages := uint32((maxMemory + pageSize - 1) / pageSize)
+	m.runtime = wazero.NewRuntimeWithConfig(ctx, wazero.NewRuntimeConfig().
+		WithCloseOnContextDone(true).
+		WithMemoryLimitPages(pages))
+	if _, err := wasi_snapshot_preview1.Instantiate(ctx, m.runtime); err != nil {
+		_ = m.runtime.Close(ctx)
+		return nil, errors.Wrap(err, "failed to instantiate WASI")
+	}
+	if _, err := m.runtime.NewHostModuleBuilder(HostModule).
+		NewFunctionBuilder().WithFunc(m.log).Export("log").
+		Instantiate(ctx); err != nil {
+		_ = m.runtime.Close(ctx)
+		return nil, errors.Wrap(err, "failed to instantiate the host functions")
+	}
+	if m.compiled, err = m.runtime.CompileModule(ctx, binary); err != nil {
+		_ = m.runtime.Close(ctx)
+		return nil, errors.Wrapf(err, "failed to compile wasm module %s", c.Module)
+	}
+	exports := m.compiled.ExportedFunctions()
+	for _, name := range []string{AllocExport, OnRequestExport} {
+		if _, ok := exports[name]; !ok {
+			_ = m.runtime.Close(ctx)
+			return nil, errors.Errorf("wasm module %s does not export %s", c.Module, name)
+		}
+	}
+	_, m.onResponse = exports[OnResponseExport]
+	_, m.configure = exports[ConfigureExport]
+
+	// Instantiate once to fail on start instead of on the first request
+	first, err := m.instantiate(ctx)
+	if err != nil {
+		_ = m.runtime.Close(ctx)
+		return nil, err
+	}
+	m.idle = append(m.idle, first)
+	return m, nil
+}
+
+// log is the sargantana.log host function
+func (m *hookModule) log(_ context.Context, module api.Module, level, ptr, size uint32) {
+	message, ok := module.Memory().Read(ptr, size)
+	if !ok {
+		m.logger.Warn().Uint32("ptr", ptr).Uint32("len", size).Msg("WASM module logged a message out of its memory")
+		return
+	}
+	var event *zerolog.Event
+	switch level {
+	case 0:
+		event = m.logger.Debug()
+	case 1:
+		event = m.logger.Info()
+	case 2:
+		event = m.logger.Warn()
+	default:
+		event = m.logger.Error()
+	}
+	event.Msg(string(message))
+}
+
+func (m *hookModule) instantiate(ctx context.Context) (*instance, error) {
+	ctx, cancel := context.WithTimeout(ctx, m.initTimeout)
+	defer cancel()
+	module, err := m.runtime.InstantiateModule(ctx, m.compiled, wazero.NewModuleConfig().
+		// Anonymous, so that the module can be instantiated several times
+		WithName("").
+		// Reactors, such as Go's c-shared modules, initialize their runtime in _initialize
+		WithStartFunctions("_initialize").
+		WithSysWalltime().
+		WithSysNanotime().
+		WithRandSource(rand.Reader))
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to instantiate wasm module %s", m.path)
+	}
+	i := &instance{module: module, alloc: module.ExportedFunction(AllocExport)}
+	if m.configure {
+		result, err := i.call(ctx, module.ExportedFunction(ConfigureExport), m.settings)
+		if err == nil && result != 0 {
+			err = errors.Errorf("configure returned %d", result)
+		}
+		if err != nil {
+			_ = module.Close(ctx)
+			return nil, errors.Wrapf(err, "failed to configure wasm module %s", m.path)
+		}
+	}
+	return i, nil
+}
+
+// hook calls a hook of an instance with the input, returning its output, or nil if empty
+func (m *hookModule) hook(ctx context.Context, name string, input []byte) ([]byte, error) {
+	select {
+	case m.slots <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	defer func() { <-m.slots }()
+
+	m.mu.Lock()
+	var i *instance
+	if n := len(m.idle); n > 0 {
+		i, m.idle = m.idle[n-1], m.idle[:n-1]
+	}
+	m.mu.Unlock()
+	if i == nil {
+		var err error
+		if i, err = m.instantiate(context.Background()); err != nil {
+			return nil, err
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, m.timeout)
+	defer cancel()
+	packed, err := i.call(ctx, i.module.ExportedFunction(name), input)
+	if err != nil {
+		// The instance is closed when the context is done, and may be corrupted otherwise
+		_ = i.module.Close(context.Background())
+		return nil, errors.Wrapf(err, "%s failed", name)
+	}
+	var output []byte
+	// #nosec G115 -- the packed result holds the address and the length of the output
+	if address, size := uint32(packed>>32), uint32(packed); size > 0 {
+		view, ok := i.module.Memory().Read(address, size)
+		if !ok {
+			_ = i.module.Close(context.Background())
+			return nil, errors.Errorf("%s returned a buffer out of memory", name)
+		}
+		output = append([]byte(nil), view...)
+	}
+	m.mu.Lock()
+	m.idle = append(m.idle, i)
+	m.mu.Unlock()
+	return output, nil
+}
+
+// call writes the input in a buffer of the instance and calls the function with it
+func (i *instance) call(ctx context.Context, function api.Function, input []byte) (uint64, error) {
+	results, err := i.alloc.Call(ctx, uint64(len(input)))
+	if err != nil {
+		return 0, errors.Wrap(err, "alloc failed")
+	}
+	// #nosec G115 -- alloc returns an i32 address
+	ptr := uint32(results[0])
+	if !i.module.Memory().Write(ptr, input) {
+		return 0, errors.New("alloc returned a buffer out of memory")
+	}
+	if results, err = function.Call(ctx, uint64(ptr), uint64(len(input))); err != nil {
+		return 0, err
+	}
+	if len(results) == 0 {
+		return 0, nil
+	}
+	return results[0], nil
+}